@@ -103,6 +103,16 @@ push-image: image ## (Build) Push the manager/agent container image to $(TELEPRE
 	docker push $(TELEPRESENCE_REGISTRY)/tel2-base:$(TELEPRESENCE_BASE_VERSION) && \
 	docker push $(TELEPRESENCE_REGISTRY)/tel2:$(patsubst v%,%,$(TELEPRESENCE_VERSION))
 
+.PHONY: client-image push-client-image
+client-image: pkg/install/helm/telepresence-chart.tgz ## (Build) Build/tag the client container image that runs the daemons of 'connect --docker'
+	mkdir -p $(BUILDDIR)/client-image
+	CGO_ENABLED=0 GOOS=linux go build -trimpath -ldflags=-X=$(PKG_VERSION).Version=$(TELEPRESENCE_VERSION) -o $(BUILDDIR)/client-image/telepresence ./cmd/telepresence
+	cp build-aux/client-image/Dockerfile $(BUILDDIR)/client-image/
+	docker build --pull -t $(TELEPRESENCE_REGISTRY)/telepresence:$(patsubst v%,%,$(TELEPRESENCE_VERSION)) $(BUILDDIR)/client-image
+
+push-client-image: client-image ## (Build) Push the client container image to $(TELEPRESENCE_REGISTRY)
+	docker push $(TELEPRESENCE_REGISTRY)/telepresence:$(patsubst v%,%,$(TELEPRESENCE_VERSION))
+
 .PHONY: clean
 clean: ## (Build) Remove all build artifacts
 	rm -rf $(BUILDDIR) pkg/install/helm/telepresence-chart.tgz
@@ -238,7 +248,7 @@ install: build ## (Install) Installs the telepresence binary to $(bindir)
 # =======
 
 .PHONY: all test images push-images
-all:         build image ## (ZAlias) Alias for 'build image'
-test:        check       ## (ZAlias) Alias for 'check'
-images:      image       ## (ZAlias) Alias for 'image'
-push-images: push-image  ## (ZAlias) Alias for 'push-image'
+all:         build image                  ## (ZAlias) Alias for 'build image'
+test:        check                        ## (ZAlias) Alias for 'check'
+images:      image client-image           ## (ZAlias) Alias for 'image client-image'
+push-images: push-image push-client-image ## (ZAlias) Alias for 'push-image push-client-image'
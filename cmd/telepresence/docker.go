@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+
+	"github.com/datawire/dlib/dgroup"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// dockerCommand runs the root daemon and the user daemon in one process. It is the command of the
+// container that runs the daemons when connecting with --docker. The user daemon serves its gRPC API
+// on the given TCP address so that the CLI on the host can reach it through a published port. When
+// one of the daemons quits, so does the other, and the container stops.
+func dockerCommand() *cobra.Command {
+	var address string
+	cmd := &cobra.Command{
+		Use:    "docker-foreground",
+		Short:  "Launch the Telepresence Daemons in the foreground of a container",
+		Args:   cobra.NoArgs,
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := cmd.Context()
+			logDir, err := filelocation.AppUserLogDir(ctx)
+			if err != nil {
+				return err
+			}
+			configDir, err := filelocation.AppUserConfigDir(ctx)
+			if err != nil {
+				return err
+			}
+			g := dgroup.NewGroup(ctx, dgroup.GroupConfig{
+				ShutdownOnNonError: true,
+			})
+			g.Go(daemon.ProcessName, func(ctx context.Context) error {
+				dc := daemon.Command()
+				dc.SetArgs([]string{logDir, configDir, ""})
+				return dc.ExecuteContext(ctx)
+			})
+			g.Go(connector.ProcessName, func(ctx context.Context) error {
+				cc := connector.Command()
+				cc.SetArgs([]string{"--address", address})
+				return cc.ExecuteContext(ctx)
+			})
+			return g.Wait()
+		},
+	}
+	cmd.Flags().StringVar(&address, "address", "", "The TCP address that the user daemon listens to")
+	return cmd
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -10,11 +11,16 @@ import (
 
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/daemon"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/helper"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/logging"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/scout"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 )
 
 func main() {
@@ -35,7 +41,7 @@ func main() {
 
 	var cmd *cobra.Command
 	if isDaemon() {
-		// Avoid the initialization of all subcommands except for [connector|daemon]-foreground and
+		// Avoid the initialization of all subcommands except for [connector|daemon|daemon-service|daemon-cleanup|helper|docker|wsl]-foreground and
 		// avoids checks for legacy commands.
 		cmd = &cobra.Command{
 			Use:  "telepresence",
@@ -49,6 +55,11 @@ func main() {
 		}
 		cmd.AddCommand(connector.Command())
 		cmd.AddCommand(daemon.Command())
+		cmd.AddCommand(daemon.ServiceCommand())
+		cmd.AddCommand(daemon.CleanupCommand())
+		cmd.AddCommand(helper.Command())
+		cmd.AddCommand(dockerCommand())
+		cmd.AddCommand(wslCommand())
 		if err := cmd.ExecuteContext(ctx); err != nil {
 			fmt.Fprintf(cmd.ErrOrStderr(), "%s: error: %v\n", cmd.CommandPath(), err)
 			os.Exit(1)
@@ -56,12 +67,40 @@ func main() {
 	} else {
 		cfg, err := client.LoadConfig(ctx)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to load config: %v", err)
-			os.Exit(1)
+			if !isConfigValidate() {
+				fmt.Fprintf(os.Stderr, "Failed to load config: %v", err)
+				os.Exit(1)
+			}
+			// The config validate command reports the problems of the config files itself
+			dflt := client.GetDefaultConfig(ctx)
+			cfg = &dflt
 		}
 		ctx = client.WithConfig(ctx, cfg)
+		if cfg.Tracing.Enabled {
+			// The CLI is too short-lived to keep its spans, but it must start the traces that the
+			// daemons continue.
+			defer tracing.Start(ctx, "cli", 0)(ctx)
+		}
+		// Telemetry is sent by the user daemon when it's running, so that the CLI doesn't wait for it.
+		ctx = scout.WithForwarder(ctx, cliutil.ForwardTelemetry)
 		cmd = cli.Command(ctx)
-		if err := cmd.ExecuteContext(ctx); err != nil {
+		err = cmd.ExecuteContext(ctx)
+		scout.WaitForAsyncReports()
+		if err != nil {
+			// A command that the CLI ran, such as the handler of an intercept, has already reported why
+			// it failed, so its exit status is propagated quietly unless something else failed too.
+			var exitErr *proc.ExitError
+			if errors.As(err, &exitErr) {
+				if err.Error() != exitErr.Error() {
+					fmt.Fprintf(cmd.ErrOrStderr(), "%s: error: %v\n", cmd.CommandPath(), err)
+				}
+				os.Exit(exitErr.ExitCode())
+			}
+			var codeErr *cli.ExitCodeError
+			if errors.As(err, &codeErr) && codeErr.Err == nil {
+				// The exit code is all that the command reports
+				os.Exit(codeErr.Code)
+			}
 			fmt.Fprintf(cmd.ErrOrStderr(), "%s: error: %v\n", cmd.CommandPath(), err)
 			if errcat.GetCategory(err) > errcat.NoLogs {
 				summarizeLogs(ctx, cmd)
@@ -73,7 +112,10 @@ func main() {
 					"telepresence_logs.zip to your github issue or create a new one: "+
 					"https://github.com/telepresenceio/telepresence/issues/new?template=Bug_report.md .")
 			}
-			os.Exit(1)
+			if codeErr != nil {
+				os.Exit(codeErr.Code)
+			}
+			os.Exit(cli.ExitFailure)
 		}
 	}
 }
@@ -84,6 +126,11 @@ func isDaemon() bool {
 	return len(a) > 1 && strings.HasSuffix(a[1], fg) || len(a) > 2 && strings.HasSuffix(a[2], fg) && a[1] == "help"
 }
 
+func isConfigValidate() bool {
+	a := os.Args
+	return len(a) > 2 && a[1] == "config" && a[2] == "validate"
+}
+
 func summarizeLogs(ctx context.Context, cmd *cobra.Command) {
 	w := cmd.ErrOrStderr()
 	first := true
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector"
+)
+
+// wslCommand runs the user daemon on Windows so that it also serves the CLI in a WSL2 distribution. It is
+// started by that CLI, using WSL interop, when connecting with --windows-daemons. The CLI in WSL can't
+// launch the root daemon on Windows, so it's launched first, the same way that the CLI on Windows
+// launches it.
+func wslCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:    "wsl-foreground",
+		Short:  "Launch the Telepresence Daemons on Windows for the CLI in WSL2",
+		Args:   cobra.NoArgs,
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := cmd.Context()
+			cfg, err := client.LoadConfig(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			ctx = client.WithConfig(ctx, cfg)
+			err = cliutil.WithDaemon(ctx, "", func(context.Context, daemon.DaemonClient) error {
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			cc := connector.Command()
+			cc.SetArgs([]string{"--wsl"})
+			return cc.ExecuteContext(ctx)
+		},
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -30,19 +31,34 @@ type Config struct {
 	AppPort     int32  `env:"_TEL_AGENT_APP_PORT,required"`
 	ManagerHost string `env:"_TEL_AGENT_MANAGER_HOST,default=traffic-manager"`
 	ManagerPort int32  `env:"_TEL_AGENT_MANAGER_PORT,default=8081"`
+
+	// AppVolumeMounts are the volume mounts of the app container in the form <name>=<mount path>,
+	// separated by colons.
+	AppVolumeMounts string `env:"_TEL_AGENT_APP_VOLUME_MOUNTS,default="`
+
+	// ExtraPorts are the other ports that the agent intercepts in the form <agent port>=<app port>,
+	// separated by commas.
+	ExtraPorts string `env:"_TEL_AGENT_EXTRA_PORTS,default="`
+
+	// TCPKeepAlive is the keep-alive period of TCP connections that the agent dials or accepts for the
+	// tunnel. A negative value disables keep-alives.
+	TCPKeepAlive time.Duration `env:"_TEL_AGENT_TCP_KEEPALIVE,default=15s"`
 }
 
 var skipKeys = map[string]bool{
 	// Keys found in the Config
-	"_TEL_AGENT_NAME":         true,
-	"_TEL_AGENT_NAMESPACE":    true,
-	"_TEL_AGENT_POD_IP":       true,
-	"_TEL_AGENT_PORT":         true,
-	"_TEL_AGENT_APP_MOUNTS":   true,
-	"_TEL_AGENT_APP_PORT":     true,
-	"_TEL_AGENT_MANAGER_HOST": true,
-	"_TEL_AGENT_MANAGER_PORT": true,
-	"_TEL_AGENT_LOG_LEVEL":    true,
+	"_TEL_AGENT_NAME":              true,
+	"_TEL_AGENT_NAMESPACE":         true,
+	"_TEL_AGENT_POD_IP":            true,
+	"_TEL_AGENT_PORT":              true,
+	"_TEL_AGENT_APP_MOUNTS":        true,
+	"_TEL_AGENT_APP_VOLUME_MOUNTS": true,
+	"_TEL_AGENT_APP_PORT":          true,
+	"_TEL_AGENT_EXTRA_PORTS":       true,
+	"_TEL_AGENT_MANAGER_HOST":      true,
+	"_TEL_AGENT_MANAGER_PORT":      true,
+	"_TEL_AGENT_LOG_LEVEL":         true,
+	"_TEL_AGENT_TCP_KEEPALIVE":     true,
 
 	// Keys that aren't useful when running on the local machine
 	"HOME":     true,
@@ -76,6 +92,49 @@ func AppEnvironment() map[string]string {
 
 const tpMountsEnv = "TELEPRESENCE_MOUNTS"
 
+// VolumeMounts returns the volume mounts of the app container
+func (cfg *Config) VolumeMounts() []*rpc.AgentInfo_VolumeMount {
+	if cfg.AppVolumeMounts == "" {
+		return nil
+	}
+	entries := strings.Split(cfg.AppVolumeMounts, ":")
+	vms := make([]*rpc.AgentInfo_VolumeMount, 0, len(entries))
+	for _, entry := range entries {
+		if eq := strings.IndexByte(entry, '='); eq > 0 {
+			vms = append(vms, &rpc.AgentInfo_VolumeMount{Name: entry[:eq], MountPath: entry[eq+1:]})
+		}
+	}
+	return vms
+}
+
+// ExtraPort is a port that the agent intercepts in addition to the AgentPort. What isn't intercepted is
+// forwarded to the AppPort.
+type ExtraPort struct {
+	AgentPort int32
+	AppPort   int32
+}
+
+// ParseExtraPorts returns the ExtraPorts of the config.
+func (cfg *Config) ParseExtraPorts() ([]ExtraPort, error) {
+	if cfg.ExtraPorts == "" {
+		return nil, nil
+	}
+	entries := strings.Split(cfg.ExtraPorts, ",")
+	eps := make([]ExtraPort, len(entries))
+	for i, entry := range entries {
+		if eq := strings.IndexByte(entry, '='); eq > 0 {
+			agentPort, err1 := strconv.ParseUint(entry[:eq], 10, 16)
+			appPort, err2 := strconv.ParseUint(entry[eq+1:], 10, 16)
+			if err1 == nil && err2 == nil {
+				eps[i] = ExtraPort{AgentPort: int32(agentPort), AppPort: int32(appPort)}
+				continue
+			}
+		}
+		return nil, fmt.Errorf("invalid extra port %q, must be <agent port>=<app port>", entry)
+	}
+	return eps, nil
+}
+
 func (cfg *Config) HasMounts(ctx context.Context, env map[string]string) bool {
 	tpMounts := env[tpMountsEnv]
 	if tpMounts != "" {
@@ -163,14 +222,20 @@ func Main(ctx context.Context, args ...string) error {
 		return err
 	}
 	dlog.Infof(ctx, "%+v", config)
+	extraPorts, err := config.ParseExtraPorts()
+	if err != nil {
+		return err
+	}
+	ctx = tunnel.WithTCPKeepAlive(ctx, config.TCPKeepAlive)
 
 	info := &rpc.AgentInfo{
-		Name:        config.Name,
-		PodIp:       config.PodIP,
-		Product:     "telepresence",
-		Version:     version.Version,
-		Environment: AppEnvironment(),
-		Namespace:   config.Namespace,
+		Name:         config.Name,
+		PodIp:        config.PodIP,
+		Product:      "telepresence",
+		Version:      version.Version,
+		Environment:  AppEnvironment(),
+		Namespace:    config.Namespace,
+		VolumeMounts: config.VolumeMounts(),
 	}
 
 	// Select initial mechanism
@@ -238,21 +303,33 @@ func Main(ctx context.Context, args ...string) error {
 		dlog.Info(ctx, "Not starting sftp-server ($APP_MOUNTS is empty or $USER is set)")
 	}
 
-	forwarderChan := make(chan *forwarder.Forwarder)
+	forwardersChan := make(chan []*forwarder.Forwarder)
 
-	// Manage the forwarder
+	// Manage the forwarders. The first one forwards the agent port, and the ones that follow the extra ports.
 	g.Go("forward", func(ctx context.Context) error {
 		ctx = tunnel.WithPool(ctx, tunnel.NewPool())
-		lisAddr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf(":%d", config.AgentPort))
-		if err != nil {
-			close(forwarderChan)
-			return err
+		ports := append([]ExtraPort{{AgentPort: config.AgentPort, AppPort: config.AppPort}}, extraPorts...)
+		forwarders := make([]*forwarder.Forwarder, len(ports))
+		for i, port := range ports {
+			lisAddr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf(":%d", port.AgentPort))
+			if err != nil {
+				close(forwardersChan)
+				return err
+			}
+			forwarders[i] = forwarder.NewForwarder(lisAddr, "", port.AppPort)
 		}
+		forwardersChan <- forwarders
 
-		forwarder := forwarder.NewForwarder(lisAddr, "", config.AppPort)
-		forwarderChan <- forwarder
+		for i, extra := range forwarders[1:] {
+			extra := extra
+			name := fmt.Sprintf("forward-%d", ports[i+1].AgentPort)
+			g.Go(name+"-udp", extra.ServeUDP)
+			g.Go(name, func(context.Context) error { return extra.Serve(ctx) })
+		}
 
-		return forwarder.Serve(ctx)
+		// The agent port may be either a TCP or a UDP port, so listen for both
+		g.Go("forward-udp", forwarders[0].ServeUDP)
+		return forwarders[0].Serve(ctx)
 	})
 
 	// Talk to the Traffic Manager
@@ -263,13 +340,13 @@ func Main(ctx context.Context, args ...string) error {
 		ticker := time.NewTicker(5 * time.Second)
 		defer ticker.Stop()
 
-		forwarder := <-forwarderChan
-		if forwarder == nil {
+		forwarders := <-forwardersChan
+		if forwarders == nil {
 			return nil
 		}
 
 		sftpPort := <-sftpPortCh
-		state := NewState(forwarder, config.ManagerHost, config.Namespace, config.PodIP, sftpPort)
+		state := NewState(forwarders[0], config.ManagerHost, config.Namespace, config.PodIP, sftpPort, forwarders[1:]...)
 
 		for {
 			if err := TalkToManager(ctx, gRPCAddress, info, state); err != nil {
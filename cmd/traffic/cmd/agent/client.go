@@ -12,6 +12,8 @@ import (
 
 	"github.com/blang/semver"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/datawire/dlib/dcontext"
@@ -116,14 +118,23 @@ func TalkToManager(ctx context.Context, address string, info *rpc.AgentInfo, sta
 	if err != nil {
 		return err
 	}
-	go tunnel.DialWaitLoop(ctx, manager, dialerStream, session.SessionId)
+	go func() {
+		_ = tunnel.DialWaitLoop(ctx, manager, dialerStream, session.SessionId)
+	}()
 
-	// Deal with log-level changes
+	// Deal with log-level changes, both those for all agents and those for this agent only. The
+	// last log-level that is received is the one in effect.
+	timedLevel := log.NewTimedLevel(GetLogLevel(), log.SetLevel)
 	logLevelStream, err := manager.WatchLogLevel(ctx, &empty.Empty{})
 	if err != nil {
 		return err
 	}
-	go logLevelWaitLoop(ctx, logLevelStream)
+	go logLevelWaitLoop(ctx, logLevelStream, timedLevel)
+	agentLogLevelStream, err := manager.WatchAgentLogLevel(ctx, session)
+	if err != nil {
+		return err
+	}
+	go logLevelWaitLoop(ctx, agentLogLevelStream, timedLevel)
 
 	// Loop calling Remain
 	ticker := time.NewTicker(5 * time.Second)
@@ -228,14 +239,22 @@ func GetLogLevel() string {
 	return level
 }
 
-func logLevelWaitLoop(ctx context.Context, logLevelStream rpc.Manager_WatchLogLevelClient) {
-	level := GetLogLevel()
-	timedLevel := log.NewTimedLevel(level, log.SetLevel)
+// logLevelStream is a stream of log-levels, from either WatchLogLevel or WatchAgentLogLevel.
+type logLevelStream interface {
+	Recv() (*rpc.LogLevelRequest, error)
+}
+
+func logLevelWaitLoop(ctx context.Context, stream logLevelStream, timedLevel log.TimedLevel) {
 	for ctx.Err() == nil {
-		ll, err := logLevelStream.Recv()
+		ll, err := stream.Recv()
 		if err != nil {
 			if ctx.Err() == nil && !errors.Is(err, io.EOF) {
-				dlog.Debugf(ctx, "log-level stream recv: %+v", err)
+				if status.Code(err) == codes.Unimplemented {
+					// A traffic-manager that is older than this agent can't set log-levels for it only
+					dlog.Debugf(ctx, "log-level stream not supported by the traffic-manager: %v", err)
+				} else {
+					dlog.Debugf(ctx, "log-level stream recv: %+v", err)
+				}
 			}
 			return
 		}
@@ -3,8 +3,10 @@ package agent
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/blang/semver"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
@@ -20,78 +22,80 @@ type State interface {
 type state struct {
 	forwarder   *forwarder.Forwarder
 	managerHost string
-	appHost     string
-	appPort     int32
-	chosenID    string
-	namespace   string
-	podIP       string
-	sftpPort    int32
+
+	// extraForwarders forward the extra ports of the agent, and intercept the ExtraPortMappings of the
+	// intercepts that target their app ports.
+	extraForwarders []*forwarder.Forwarder
+
+	appHost   string
+	appPort   int32
+	chosenIDs []string
+	namespace string
+	podIP     string
+	sftpPort  int32
 }
 
-func NewState(forwarder *forwarder.Forwarder, managerHost, namespace, podIP string, sftpPort int32) State {
+func NewState(forwarder *forwarder.Forwarder, managerHost, namespace, podIP string, sftpPort int32, extraForwarders ...*forwarder.Forwarder) State {
 	host, port := forwarder.Target()
 	return &state{
-		forwarder:   forwarder,
-		managerHost: managerHost,
-		appHost:     host,
-		appPort:     port,
-		namespace:   namespace,
-		podIP:       podIP,
-		sftpPort:    sftpPort,
+		forwarder:       forwarder,
+		managerHost:     managerHost,
+		extraForwarders: extraForwarders,
+		appHost:         host,
+		appPort:         port,
+		namespace:       namespace,
+		podIP:           podIP,
+		sftpPort:        sftpPort,
 	}
 }
 
 func (s *state) SetManager(sessionInfo *manager.SessionInfo, manager manager.ManagerClient, version semver.Version) {
 	s.forwarder.SetManager(sessionInfo, manager, version)
+	for _, f := range s.extraForwarders {
+		f.SetManager(sessionInfo, manager, version)
+	}
 }
 
 func (s *state) HandleIntercepts(ctx context.Context, cepts []*manager.InterceptInfo) []*manager.ReviewInterceptRequest {
-	var chosenIntercept, activeIntercept *manager.InterceptInfo
-
 	dlog.Debug(ctx, "HandleIntercepts called")
 
-	// Find the chosen intercept if it still exists
-	if s.chosenID != "" {
-		for _, cept := range cepts {
-			if cept.Id == s.chosenID {
-				chosenIntercept = cept
-				break
-			}
-		}
-
-		if chosenIntercept != nil {
-			// The chosen intercept still exists
-			if chosenIntercept.Disposition == manager.InterceptDispositionType_ACTIVE {
-				// and is active
-				activeIntercept = chosenIntercept
-			}
+	// Find the chosen intercepts that still exist
+	var chosen []*manager.InterceptInfo
+	for _, id := range s.chosenIDs {
+		if cept := findIntercept(cepts, id); cept != nil {
+			chosen = append(chosen, cept)
 		} else {
 			// The chosen intercept was deleted by the user
-			dlog.Info(ctx, "The previously-active intercept has been deleted")
-			s.chosenID = ""
+			dlog.Infof(ctx, "The previously-active intercept %q has been deleted", id)
 		}
-	} else {
-		// Attach to already ACTIVE intercept if there is one.
-		for _, cept := range cepts {
-			if cept.Disposition == manager.InterceptDispositionType_ACTIVE {
-				chosenIntercept = cept
-				s.chosenID = cept.Id
-				activeIntercept = cept
-				break
-			}
+	}
+
+	// Attach to already ACTIVE intercepts that don't conflict with the chosen ones.
+	for _, cept := range cepts {
+		if cept.Disposition == manager.InterceptDispositionType_ACTIVE && findIntercept(chosen, cept.Id) == nil && conflicting(chosen, cept) == nil {
+			chosen = append(chosen, cept)
 		}
 	}
 
 	// Update forwarding
-	s.forwarder.SetIntercepting(activeIntercept)
+	var active []*manager.InterceptInfo
+	for _, cept := range chosen {
+		if cept.Disposition == manager.InterceptDispositionType_ACTIVE {
+			active = append(active, cept)
+		}
+	}
+	s.forwarder.SetIntercepts(active)
+	for _, f := range s.extraForwarders {
+		_, appPort := f.Target()
+		f.SetIntercepts(extraIntercepts(active, appPort))
+	}
 
 	// Review waiting intercepts
 	reviews := []*manager.ReviewInterceptRequest{}
 	for _, cept := range cepts {
 		if cept.Disposition == manager.InterceptDispositionType_WAITING {
 			// This intercept is ready to be active
-			switch {
-			case chosenIntercept != nil && cept.Id == chosenIntercept.Id:
+			if findIntercept(chosen, cept.Id) != nil {
 				// We've already chosen this one and marked it active, but it's not
 				// active yet in this snapshot.  We could probably just do nothing
 				// and it would probably change to ACTIVE in the very next snapshot
@@ -99,51 +103,144 @@ func (s *state) HandleIntercepts(ctx context.Context, cepts []*manager.Intercept
 				// that just hasn't propagated yet.  But let's go ahead and tell the
 				// manager to mark it ACTIVE again anyway, just to be safe.
 				dlog.Infof(ctx, "Setting intercept %q as ACTIVE (again?)", cept.Id)
+				reviews = append(reviews, s.activeReview(cept))
+			} else if pm := s.unservedPortMapping(cept); pm != nil {
+				// The intercept must intercept all its ports or none of them.
+				dlog.Infof(ctx, "Setting intercept %q as AGENT_ERROR; as container port %d isn't intercepted by this agent", cept.Id, pm.ContainerPort)
 				reviews = append(reviews, &manager.ReviewInterceptRequest{
-					Id:                cept.Id,
-					Disposition:       manager.InterceptDispositionType_ACTIVE,
-					PodIp:             s.podIP,
-					SftpPort:          s.sftpPort,
-					MechanismArgsDesc: "all TCP connections",
+					Id:          cept.Id,
+					Disposition: manager.InterceptDispositionType_AGENT_ERROR,
+					Message: fmt.Sprintf("The traffic-agent doesn't intercept container port %d of service port %q",
+						pm.ContainerPort, pm.ServicePortIdentifier),
+					MechanismArgsDesc: mechanismArgsDesc(cept),
 				})
-			case chosenIntercept == nil:
-				// We don't have an intercept in play, so choose this one. All
-				// agents will get intercepts in the same order every time, so
-				// this will yield a consistent result. Note that the intercept
-				// will not become active at this time. That will happen later,
-				// once the manager assigns a port.
+			} else if other := conflicting(chosen, cept); other == nil {
+				// No intercept in play intercepts the same traffic, so choose this one. All
+				// agents will get intercepts in the same order every time, so this will
+				// yield a consistent result. Note that the intercept will not become active
+				// at this time. That will happen later, once the manager assigns a port.
 				dlog.Infof(ctx, "Setting intercept %q as ACTIVE", cept.Id)
-				s.chosenID = cept.Id
-				chosenIntercept = cept
-				reviews = append(reviews, &manager.ReviewInterceptRequest{
-					Id:                cept.Id,
-					Disposition:       manager.InterceptDispositionType_ACTIVE,
-					PodIp:             s.podIP,
-					SftpPort:          s.sftpPort,
-					MechanismArgsDesc: "all TCP connections",
-				})
-			default:
-				// We already have an intercept in play, so reject this one.
-				dlog.Infof(ctx, "Setting intercept %q as AGENT_ERROR; as it conflicts with %q as the current chosen-to-be-ACTIVE intercept", cept.Id, s.chosenID)
+				chosen = append(chosen, cept)
+				reviews = append(reviews, s.activeReview(cept))
+			} else {
+				// We already have an intercept of the same traffic in play, so reject this one.
+				dlog.Infof(ctx, "Setting intercept %q as AGENT_ERROR; as it conflicts with %q as the current chosen-to-be-ACTIVE intercept", cept.Id, other.Id)
 				var msg string
-				if chosenIntercept.Disposition == manager.InterceptDispositionType_ACTIVE {
-					msg = fmt.Sprintf("Conflicts with the currently-served intercept %q", s.chosenID)
+				if other.Disposition == manager.InterceptDispositionType_ACTIVE {
+					msg = fmt.Sprintf("Conflicts with the currently-served intercept %q", other.Id)
 				} else {
-					msg = fmt.Sprintf("Conflicts with the currently-waiting-to-be-served intercept %q", s.chosenID)
+					msg = fmt.Sprintf("Conflicts with the currently-waiting-to-be-served intercept %q", other.Id)
 				}
 				reviews = append(reviews, &manager.ReviewInterceptRequest{
 					Id:                cept.Id,
 					Disposition:       manager.InterceptDispositionType_AGENT_ERROR,
 					Message:           msg,
-					MechanismArgsDesc: "all TCP connections",
+					MechanismArgsDesc: mechanismArgsDesc(cept),
 				})
 			}
 		}
 	}
 
+	s.chosenIDs = make([]string, len(chosen))
+	for i, cept := range chosen {
+		s.chosenIDs[i] = cept.Id
+	}
 	return reviews
 }
 
+func (s *state) activeReview(cept *manager.InterceptInfo) *manager.ReviewInterceptRequest {
+	return &manager.ReviewInterceptRequest{
+		Id:                cept.Id,
+		Disposition:       manager.InterceptDispositionType_ACTIVE,
+		PodIp:             s.podIP,
+		SftpPort:          s.sftpPort,
+		MechanismArgsDesc: mechanismArgsDesc(cept),
+	}
+}
+
+// unservedPortMapping returns the first of the ExtraPortMappings of the given intercept that targets a container
+// port that none of the extra forwarders forward, or nil if there's no such mapping.
+func (s *state) unservedPortMapping(cept *manager.InterceptInfo) *manager.InterceptPortMapping {
+	for _, pm := range cept.Spec.ExtraPortMappings {
+		served := false
+		for _, f := range s.extraForwarders {
+			if _, appPort := f.Target(); appPort == pm.ContainerPort {
+				served = true
+				break
+			}
+		}
+		if !served {
+			return pm
+		}
+	}
+	return nil
+}
+
+// extraIntercepts returns a copy of each of the given intercepts that has a port mapping of the given container
+// port. The target port of the copy is the one of that mapping.
+func extraIntercepts(cepts []*manager.InterceptInfo, containerPort int32) []*manager.InterceptInfo {
+	var extras []*manager.InterceptInfo
+	for _, cept := range cepts {
+		for _, pm := range cept.Spec.ExtraPortMappings {
+			if pm.ContainerPort == containerPort {
+				extra := proto.Clone(cept).(*manager.InterceptInfo)
+				extra.Spec.TargetPort = pm.TargetPort
+				extras = append(extras, extra)
+				break
+			}
+		}
+	}
+	return extras
+}
+
+func findIntercept(cepts []*manager.InterceptInfo, id string) *manager.InterceptInfo {
+	for _, cept := range cepts {
+		if cept.Id == id {
+			return cept
+		}
+	}
+	return nil
+}
+
+// conflicting returns the first of the given intercepts that intercepts the same traffic as the given
+// intercept, or nil if there's no such intercept. Intercepts that only intercept HTTP requests with
+// conditions that exclude each other can be served at the same time.
+func conflicting(cepts []*manager.InterceptInfo, cept *manager.InterceptInfo) *manager.InterceptInfo {
+	for _, other := range cepts {
+		if forwarder.InterceptsOverlap(other.Spec, cept.Spec) {
+			return other
+		}
+	}
+	return nil
+}
+
+// mechanismArgsDesc returns a human-friendly description of what the given intercept intercepts
+func mechanismArgsDesc(cept *manager.InterceptInfo) string {
+	spec := cept.Spec
+	if spec.Protocol == "UDP" {
+		return "all UDP datagrams"
+	}
+	if !forwarder.HasHTTPConditions(spec) {
+		return "all TCP connections"
+	}
+	var conds []string
+	for _, hm := range spec.HttpHeaders {
+		conds = append(conds, fmt.Sprintf("header %q matching %q", hm.Name, hm.ValueRegex))
+	}
+	if spec.HttpPathPrefix != "" {
+		conds = append(conds, fmt.Sprintf("path prefix %q", spec.HttpPathPrefix))
+	}
+	return "HTTP requests with " + strings.Join(conds, " and ")
+}
+
 func (s *state) Intercepting() bool {
-	return s.forwarder.Intercepting()
+	if s.forwarder.Intercepting() {
+		return true
+	}
+	for _, f := range s.extraForwarders {
+		if f.Intercepting() {
+			return true
+		}
+	}
+	return false
 }
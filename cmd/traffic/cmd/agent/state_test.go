@@ -2,16 +2,22 @@ package agent_test
 
 import (
 	"context"
+	"io"
 	"net"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/blang/semver"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
 
 	"github.com/datawire/dlib/dlog"
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/agent"
 	"github.com/telepresenceio/telepresence/v2/pkg/forwarder"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
 )
 
 const (
@@ -21,7 +27,7 @@ const (
 )
 
 func makeFS(t *testing.T) (*forwarder.Forwarder, agent.State) {
-	lAddr, err := net.ResolveTCPAddr("tcp", ":1111")
+	lAddr, err := net.ResolveTCPAddr("tcp", ":0")
 	assert.NoError(t, err)
 
 	f := forwarder.NewForwarder(lAddr, appHost, appPort)
@@ -137,3 +143,214 @@ func TestState_HandleIntercepts(t *testing.T) {
 	a.Len(reviews, 0)
 	a.False(f.Intercepting())
 }
+
+func TestState_HandleIntercepts_headerMatched(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	a := assert.New(t)
+	f, s := makeFS(t)
+
+	cept := func(id, client, dev string) *rpc.InterceptInfo {
+		spec := &rpc.InterceptSpec{
+			Name:      id + "Name",
+			Client:    client,
+			Agent:     "agentName",
+			Mechanism: "tcp",
+			Namespace: "default",
+		}
+		if dev != "" {
+			spec.HttpHeaders = []*rpc.HTTPHeaderMatch{{Name: "x-dev", ValueRegex: dev}}
+		}
+		return &rpc.InterceptInfo{Spec: spec, Id: id, Disposition: rpc.InterceptDispositionType_WAITING}
+	}
+	cepts := []*rpc.InterceptInfo{
+		cept("intercept-01", "alice@host1", "alice"),
+		cept("intercept-02", "bob@host2", "bob"),
+		cept("intercept-03", "carol@host3", ""),
+		cept("intercept-04", "dave@host4", "alice"),
+	}
+
+	// Intercepts with HTTP conditions that exclude each other are served at the same time
+	reviews := s.HandleIntercepts(ctx, cepts)
+	a.Len(reviews, 4)
+	a.Equal(rpc.InterceptDispositionType_ACTIVE, reviews[0].Disposition)
+	a.Equal(rpc.InterceptDispositionType_ACTIVE, reviews[1].Disposition)
+	a.Equal(rpc.InterceptDispositionType_AGENT_ERROR, reviews[2].Disposition)
+	a.Equal("Conflicts with the currently-waiting-to-be-served intercept \"intercept-01\"", reviews[2].Message)
+	a.Equal(rpc.InterceptDispositionType_AGENT_ERROR, reviews[3].Disposition)
+	a.False(f.Intercepting())
+
+	cepts[0].Disposition = rpc.InterceptDispositionType_ACTIVE
+	cepts[1].Disposition = rpc.InterceptDispositionType_ACTIVE
+	cepts = cepts[:2]
+	reviews = s.HandleIntercepts(ctx, cepts)
+	a.Len(reviews, 0)
+	a.True(f.Intercepting())
+
+	// The remaining intercept is still served when the other one ends
+	reviews = s.HandleIntercepts(ctx, cepts[1:])
+	a.Len(reviews, 0)
+	a.True(f.Intercepting())
+
+	// An intercept of all traffic can be served once the header-matched intercepts have ended
+	cepts = []*rpc.InterceptInfo{cept("intercept-03", "carol@host3", "")}
+	reviews = s.HandleIntercepts(ctx, cepts)
+	a.Len(reviews, 1)
+	a.Equal(rpc.InterceptDispositionType_ACTIVE, reviews[0].Disposition)
+	a.False(f.Intercepting())
+}
+
+// tunnelStream is one side of a Manager_TunnelClient whose other side is served by a fakeManager.
+type tunnelStream struct {
+	grpc.ClientStream
+	done      <-chan struct{}
+	in        <-chan *rpc.TunnelMessage
+	out       chan<- *rpc.TunnelMessage
+	closeOnce sync.Once
+}
+
+func (s *tunnelStream) Recv() (*rpc.TunnelMessage, error) {
+	select {
+	case <-s.done:
+		return nil, context.Canceled
+	case m, ok := <-s.in:
+		if !ok {
+			return nil, io.EOF
+		}
+		return m, nil
+	}
+}
+
+func (s *tunnelStream) Send(m *rpc.TunnelMessage) error {
+	select {
+	case <-s.done:
+		return context.Canceled
+	case s.out <- m:
+		return nil
+	}
+}
+
+func (s *tunnelStream) CloseSend() error {
+	s.closeOnce.Do(func() { close(s.out) })
+	return nil
+}
+
+// fakeManager is a traffic-manager that dials the destination of each tunnel that the agent opens, the same
+// way as the intercepting client does.
+type fakeManager struct {
+	rpc.ManagerClient
+	ctx context.Context
+}
+
+func (m *fakeManager) Tunnel(context.Context, ...grpc.CallOption) (rpc.Manager_TunnelClient, error) {
+	cToS := make(chan *rpc.TunnelMessage, 10)
+	sToC := make(chan *rpc.TunnelMessage, 10)
+	go func() {
+		s, err := tunnel.NewServerStream(m.ctx, &tunnelStream{done: m.ctx.Done(), in: cToS, out: sToC})
+		if err != nil {
+			dlog.Error(m.ctx, err)
+			return
+		}
+		// The agent sends the session of the intercepting client first
+		if _, err = s.Receive(m.ctx); err != nil {
+			dlog.Error(m.ctx, err)
+			return
+		}
+		tunnel.NewDialer(s).Start(m.ctx)
+	}()
+	return &tunnelStream{done: m.ctx.Done(), in: sToC, out: cToS}, nil
+}
+
+// greeter starts a server that writes the given greeting to each connection and returns its port.
+func greeter(t *testing.T, greeting string) int32 {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte(greeting))
+			_ = conn.Close()
+		}
+	}()
+	return int32(l.Addr().(*net.TCPAddr).Port)
+}
+
+func TestState_HandleIntercepts_twoPorts(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	a := assert.New(t)
+
+	serve := func(appPort int32) (*forwarder.Forwarder, string) {
+		lAddr, err := net.ResolveTCPAddr("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		f := forwarder.NewForwarder(lAddr, appHost, appPort)
+		l, err := f.Listen(ctx)
+		require.NoError(t, err)
+		go func() { _ = f.ServeListener(ctx, l) }()
+		return f, l.Addr().String()
+	}
+	f, addr := serve(appPort)
+	extra, extraAddr := serve(appPort + 1)
+	s := agent.NewState(f, mgrHost, "default", "xyz", 0, extra)
+
+	// The local process listens to one port for each of the intercepted ports
+	cept := func(id string, containerPort int32) *rpc.InterceptInfo {
+		return &rpc.InterceptInfo{
+			Spec: &rpc.InterceptSpec{
+				Name:        id + "Name",
+				Client:      "user@host1",
+				Agent:       "agentName",
+				Mechanism:   "tcp",
+				Namespace:   "default",
+				TargetHost:  "127.0.0.1",
+				TargetPort:  greeter(t, "http"),
+				DialTimeout: int64(5 * time.Second),
+				ExtraPortMappings: []*rpc.InterceptPortMapping{{
+					ServicePortIdentifier: "grpc",
+					TargetPort:            greeter(t, "grpc"),
+					ContainerPort:         containerPort,
+				}},
+			},
+			Id:            id,
+			Disposition:   rpc.InterceptDispositionType_WAITING,
+			ClientSession: &rpc.SessionInfo{SessionId: "client-session"},
+		}
+	}
+
+	// An intercept fails as a whole when one of its ports isn't intercepted by the agent
+	cepts := []*rpc.InterceptInfo{cept("intercept-01", appPort+2)}
+	reviews := s.HandleIntercepts(ctx, cepts)
+	require.Len(t, reviews, 1)
+	a.Equal(rpc.InterceptDispositionType_AGENT_ERROR, reviews[0].Disposition)
+	a.Equal("The traffic-agent doesn't intercept container port 5002 of service port \"grpc\"", reviews[0].Message)
+
+	cepts = []*rpc.InterceptInfo{cept("intercept-02", appPort+1)}
+	reviews = s.HandleIntercepts(ctx, cepts)
+	require.Len(t, reviews, 1)
+	a.Equal(rpc.InterceptDispositionType_ACTIVE, reviews[0].Disposition)
+
+	cepts[0].Disposition = rpc.InterceptDispositionType_ACTIVE
+	a.Len(s.HandleIntercepts(ctx, cepts), 0)
+	a.True(f.Intercepting())
+	a.True(extra.Intercepting())
+	s.SetManager(&rpc.SessionInfo{SessionId: "agent-session"}, &fakeManager{ctx: ctx}, semver.MustParse("2.4.5"))
+
+	// Each port is routed to its own port of the local process
+	for addr, greeting := range map[string]string{addr: "http", extraAddr: "grpc"} {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+		data, err := io.ReadAll(conn)
+		_ = conn.Close()
+		a.NoError(err)
+		a.Equal(greeting, string(data))
+	}
+
+	// Both ports are released when the intercept ends
+	a.Len(s.HandleIntercepts(ctx, nil), 0)
+	a.False(f.Intercepting())
+	a.False(extra.Intercepting())
+}
@@ -14,6 +14,9 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/informers"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	licorev1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 
 	"github.com/datawire/dlib/dlog"
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
@@ -56,8 +59,10 @@ type info struct {
 }
 
 func NewInfo(ctx context.Context) Info {
+	env := managerutil.GetEnv(ctx)
 	oi := info{}
 	oi.waiter.L = &oi.accLock
+	oi.ManagedNamespaces = env.ManagedNamespaceList()
 	clientset := managerutil.GetK8sClientset(ctx)
 
 	// Validate that the kubernetes server version is supported
@@ -130,37 +135,16 @@ func NewInfo(ctx context.Context) Info {
 	// check the error message for the correct range as suggested tin the second answer here:
 	//   https://stackoverflow.com/questions/44190607/how-do-you-find-the-cluster-service-cidr-of-a-kubernetes-cluster
 	// This requires an additional permission to create a service, which the traffic-manager
-	// should have.
-	env := managerutil.GetEnv(ctx)
-	svc := corev1.Service{
-		TypeMeta: metav1.TypeMeta{
-			Kind: "Service",
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Namespace: env.ManagerNamespace,
-			Name:      "t2-tst-dummy",
-		},
-		Spec: corev1.ServiceSpec{
-			Ports:     []corev1.ServicePort{{Port: 443}},
-			ClusterIP: "1.1.1.1",
-		},
-	}
-	if _, err = client.Services(env.ManagerNamespace).Create(ctx, &svc, metav1.CreateOptions{}); err != nil {
-		svcCIDRrx := regexp.MustCompile(`range of valid IPs is (.*)$`)
-		if match := svcCIDRrx.FindStringSubmatch(err.Error()); match != nil {
-			var cidr *net.IPNet
-			if _, cidr, err = net.ParseCIDR(match[1]); err != nil {
-				dlog.Errorf(ctx, "unable to parse service CIDR %q", match[1])
-			} else {
-				dlog.Infof(ctx, "Extracting service subnet %v from create service error message", cidr)
-				oi.ServiceSubnet = iputil.IPNetToRPC(cidr)
-			}
-		} else {
-			dlog.Errorf(ctx, "unable to extract service subnet from error message %q", err.Error())
+	// should have. A dual-stack cluster has one range per IP family, so one attempt is made
+	// for each family.
+	for _, probeIP := range serviceSubnetProbeIPs {
+		if cidr := probeServiceSubnet(ctx, client, env.ManagerNamespace, probeIP); cidr != nil {
+			dlog.Infof(ctx, "Extracting service subnet %v from create service error message", cidr)
+			oi.ServiceSubnets = append(oi.ServiceSubnets, iputil.IPNetToRPC(cidr))
 		}
 	}
 
-	if oi.ServiceSubnet == nil && oi.KubeDnsIp != nil {
+	if len(oi.ServiceSubnets) == 0 && oi.KubeDnsIp != nil {
 		// Using a "kubectl cluster-info dump" or scanning all services generates a lot of unwanted traffic
 		// and would quite possibly also require elevated permissions, so instead, we derive the service subnet
 		// from the kubeDNS IP. This is cheating but a cluster may only have one service subnet and the mask is
@@ -169,7 +153,10 @@ func NewInfo(ctx context.Context) Info {
 		bits := len(oi.KubeDnsIp) * 8
 		ones := bits / 2
 		mask := net.CIDRMask(ones, bits) // will yield a 16 bit mask on IPv4 and 64 bit mask on IPv6.
-		oi.ServiceSubnet = &rpc.IPNet{Ip: net.IP(oi.KubeDnsIp).Mask(mask), Mask: int32(ones)}
+		oi.ServiceSubnets = []*rpc.IPNet{{Ip: net.IP(oi.KubeDnsIp).Mask(mask), Mask: int32(ones)}}
+	}
+	if len(oi.ServiceSubnets) > 0 {
+		oi.ServiceSubnet = oi.ServiceSubnets[0]
 	}
 
 	podCIDRStrategy := env.PodCIDRStrategy
@@ -194,6 +181,57 @@ func NewInfo(ctx context.Context) Info {
 	return &oi
 }
 
+// serviceSubnetProbeIPs are the IPs that probeServiceSubnet uses, one per IP family. Neither is
+// likely to be within the service range of a cluster.
+var serviceSubnetProbeIPs = []string{"1.1.1.1", "2001:db8::1"}
+
+var svcCIDRrx = regexp.MustCompile(`range of valid IPs is (.*)$`)
+
+// probeServiceSubnet attempts to create a service with the given ClusterIP and returns the
+// service subnet of the IP's family that the API server reports in the resulting error, or
+// nil if no such subnet could be found, e.g. because the cluster doesn't support the family.
+func probeServiceSubnet(ctx context.Context, client typedcorev1.CoreV1Interface, namespace, probeIP string) *net.IPNet {
+	svc := corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			Kind: "Service",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      "t2-tst-dummy",
+		},
+		Spec: corev1.ServiceSpec{
+			Ports:     []corev1.ServicePort{{Port: 443}},
+			ClusterIP: probeIP,
+		},
+	}
+	services := client.Services(namespace)
+	_, err := services.Create(ctx, &svc, metav1.CreateOptions{})
+	if err == nil {
+		// The IP is in the service range after all, so it doesn't tell us anything.
+		dlog.Infof(ctx, "unable to extract service subnet, %s is a valid cluster IP", probeIP)
+		if err = services.Delete(ctx, svc.Name, metav1.DeleteOptions{}); err != nil {
+			dlog.Errorf(ctx, "unable to delete service %s.%s: %v", svc.Name, namespace, err)
+		}
+		return nil
+	}
+	match := svcCIDRrx.FindStringSubmatch(err.Error())
+	if match == nil {
+		if net.ParseIP(probeIP).To4() == nil {
+			// Clusters that aren't dual-stack are expected to reject IPv6
+			dlog.Debugf(ctx, "unable to extract IPv6 service subnet from error message %q", err.Error())
+		} else {
+			dlog.Errorf(ctx, "unable to extract service subnet from error message %q", err.Error())
+		}
+		return nil
+	}
+	_, cidr, err := net.ParseCIDR(match[1])
+	if err != nil {
+		dlog.Errorf(ctx, "unable to parse service CIDR %q", match[1])
+		return nil
+	}
+	return cidr
+}
+
 func (oi *info) watchNodeSubnets(ctx context.Context) bool {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -218,15 +256,25 @@ func (oi *info) watchNodeSubnets(ctx context.Context) bool {
 func (oi *info) watchPodSubnets(ctx context.Context) bool {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	informerFactory := informers.NewSharedInformerFactory(managerutil.GetK8sClientset(ctx), 0)
-	podController := informerFactory.Core().V1().Pods()
-	podLister := podController.Lister()
-	podInformer := podController.Informer()
 
-	informerFactory.Start(ctx.Done())
-	informerFactory.WaitForCacheSync(ctx.Done())
+	// A traffic-manager with namespace-scoped RBAC can only watch the pods of the namespaces that it manages
+	namespaces := oi.ManagedNamespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+	podListers := make([]licorev1.PodLister, len(namespaces))
+	podInformers := make([]cache.SharedIndexInformer, len(namespaces))
+	for i, ns := range namespaces {
+		informerFactory := informers.NewSharedInformerFactoryWithOptions(managerutil.GetK8sClientset(ctx), 0, informers.WithNamespace(ns))
+		podController := informerFactory.Core().V1().Pods()
+		podListers[i] = podController.Lister()
+		podInformers[i] = podController.Informer()
+
+		informerFactory.Start(ctx.Done())
+		informerFactory.WaitForCacheSync(ctx.Done())
+	}
 
-	retriever := newPodWatcher(ctx, podLister, podInformer)
+	retriever := newPodWatcher(ctx, podListers, podInformers)
 	if !retriever.viable(ctx) {
 		dlog.Errorf(ctx, "Unable to derive subnets from IPs of pods")
 		return false
@@ -293,11 +341,16 @@ func (oi *info) GetClusterID() string {
 // clusterInfo must be called with accLock locked
 func (oi *info) clusterInfo() *rpc.ClusterInfo {
 	ci := &rpc.ClusterInfo{
-		KubeDnsIp:     oi.KubeDnsIp,
-		ServiceSubnet: oi.ServiceSubnet,
-		PodSubnets:    make([]*rpc.IPNet, len(oi.PodSubnets)),
-		ClusterDomain: oi.ClusterDomain,
+		KubeDnsIp:      oi.KubeDnsIp,
+		ServiceSubnet:  oi.ServiceSubnet,
+		ServiceSubnets: make([]*rpc.IPNet, len(oi.ServiceSubnets)),
+		PodSubnets:     make([]*rpc.IPNet, len(oi.PodSubnets)),
+		ClusterDomain:  oi.ClusterDomain,
+
+		// ManagedNamespaces never changes
+		ManagedNamespaces: oi.ManagedNamespaces,
 	}
+	copy(ci.ServiceSubnets, oi.ServiceSubnets)
 	copy(ci.PodSubnets, oi.PodSubnets)
 	return ci
 }
@@ -327,17 +380,25 @@ func (oi *info) GetTrafficAgentPods(ctx context.Context, agents string) ([]*core
 	}
 	clientset := managerutil.GetK8sClientset(ctx)
 	client := clientset.CoreV1()
-	podList, err := client.Pods("").List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return nil, err
+	namespaces := oi.ManagedNamespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+	var pods []corev1.Pod
+	for _, ns := range namespaces {
+		podList, err := client.Pods(ns).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		pods = append(pods, podList.Items...)
 	}
 
 	// This is useful to determine how many pods we *should* be
 	// getting logs for
-	dlog.Debugf(ctx, "Found %d pod that contain a traffic-agent", len(podList.Items))
+	dlog.Debugf(ctx, "Found %d pod that contain a traffic-agent", len(pods))
 
 	var agentPods []*corev1.Pod
-	for _, pod := range podList.Items {
+	for _, pod := range pods {
 		pod := pod
 		if agents != "all" && !strings.Contains(pod.Name, agents) {
 			continue
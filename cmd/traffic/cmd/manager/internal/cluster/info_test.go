@@ -0,0 +1,88 @@
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// rejectClusterIPs makes the given clientset reject the creation of services like an API server
+// with the given service subnets does.
+func rejectClusterIPs(cs *fake.Clientset, serviceCIDRs ...string) {
+	cs.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		svc := action.(k8stesting.CreateAction).GetObject().(*corev1.Service)
+		ip := net.ParseIP(svc.Spec.ClusterIP)
+		for _, s := range serviceCIDRs {
+			_, cidr, _ := net.ParseCIDR(s)
+			if (ip.To4() == nil) != (cidr.IP.To4() == nil) {
+				continue
+			}
+			if cidr.Contains(ip) {
+				return false, nil, nil
+			}
+			return true, nil, fmt.Errorf(`Service "%s" is invalid: spec.clusterIPs: Invalid value: []string{"%s"}: `+
+				`failed to allocated ip:%s with error:provided IP is not in the valid range. The range of valid IPs is %s`,
+				svc.Name, ip, ip, s)
+		}
+		return true, nil, fmt.Errorf(`Service "%s" is invalid: spec.clusterIPs[0]: Invalid value: "%s": `+
+			`not configured for this cluster`, svc.Name, ip)
+	})
+}
+
+func Test_probeServiceSubnet(t *testing.T) {
+	tests := []struct {
+		name         string
+		serviceCIDRs []string
+		want         []string
+	}{
+		{
+			name:         "IPv4",
+			serviceCIDRs: []string{"10.96.0.0/12"},
+			want:         []string{"10.96.0.0/12"},
+		},
+		{
+			name:         "IPv6",
+			serviceCIDRs: []string{"fd00:10:96::/112"},
+			want:         []string{"fd00:10:96::/112"},
+		},
+		{
+			name:         "dual-stack",
+			serviceCIDRs: []string{"10.96.0.0/12", "fd00:10:96::/112"},
+			want:         []string{"10.96.0.0/12", "fd00:10:96::/112"},
+		},
+		{
+			name:         "probe IP in range",
+			serviceCIDRs: []string{"1.0.0.0/8"},
+			want:         nil,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := dlog.NewTestContext(t, false)
+			cs := fake.NewSimpleClientset()
+			rejectClusterIPs(cs, tt.serviceCIDRs...)
+			var got []string
+			for _, probeIP := range serviceSubnetProbeIPs {
+				if cidr := probeServiceSubnet(ctx, cs.CoreV1(), "ambassador", probeIP); cidr != nil {
+					got = append(got, cidr.String())
+				}
+			}
+			assert.Equal(t, tt.want, got)
+
+			// No service is left behind
+			svcs, err := cs.CoreV1().Services("ambassador").List(ctx, metav1.ListOptions{})
+			assert.NoError(t, err)
+			assert.Empty(t, svcs.Items)
+		})
+	}
+}
@@ -16,32 +16,34 @@ import (
 )
 
 type podWatcher struct {
-	lister   licorev1.PodLister
-	informer cache.SharedIndexInformer
-	ipsMap   map[iputil.IPKey]struct{}
-	subnets  subnet.Set
-	changed  time.Time
-	lock     sync.Mutex // Protects all access to ipsMap
+	listers []licorev1.PodLister
+	ipsMap  map[iputil.IPKey]struct{}
+	subnets subnet.Set
+	changed time.Time
+	lock    sync.Mutex // Protects all access to ipsMap
 }
 
-func newPodWatcher(ctx context.Context, lister licorev1.PodLister, informer cache.SharedIndexInformer) *podWatcher {
+// newPodWatcher returns a watcher of the pods of the given listers and informers. There's one lister and
+// informer per namespace when the traffic-manager's RBAC is namespace-scoped.
+func newPodWatcher(ctx context.Context, listers []licorev1.PodLister, informers []cache.SharedIndexInformer) *podWatcher {
 	w := &podWatcher{
-		lister:   lister,
-		informer: informer,
-		ipsMap:   make(map[iputil.IPKey]struct{}),
-		subnets:  make(subnet.Set),
-	}
-	w.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			w.onPodAdded(ctx, obj.(*corev1.Pod))
-		},
-		DeleteFunc: func(obj interface{}) {
-			w.onPodDeleted(ctx, obj.(*corev1.Pod))
-		},
-		UpdateFunc: func(oldObj, newObj interface{}) {
-			w.onPodUpdated(ctx, oldObj.(*corev1.Pod), newObj.(*corev1.Pod))
-		},
-	})
+		listers: listers,
+		ipsMap:  make(map[iputil.IPKey]struct{}),
+		subnets: make(subnet.Set),
+	}
+	for _, informer := range informers {
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				w.onPodAdded(ctx, obj.(*corev1.Pod))
+			},
+			DeleteFunc: func(obj interface{}) {
+				w.onPodDeleted(ctx, obj.(*corev1.Pod))
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				w.onPodUpdated(ctx, oldObj.(*corev1.Pod), newObj.(*corev1.Pod))
+			},
+		})
+	}
 	return w
 }
 
@@ -95,10 +97,14 @@ func (w *podWatcher) viable(ctx context.Context) bool {
 	w.lock.Lock()
 	defer w.lock.Unlock()
 
-	pods, err := w.lister.List(labels.Everything())
-	if err != nil {
-		dlog.Errorf(ctx, "unable to list pods: %v", err)
-		return false
+	var pods []*corev1.Pod
+	for _, lister := range w.listers {
+		lp, err := lister.List(labels.Everything())
+		if err != nil {
+			dlog.Errorf(ctx, "unable to list pods: %v", err)
+			return false
+		}
+		pods = append(pods, lp...)
 	}
 
 	// Create the initial snapshot
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	admission "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -18,6 +19,10 @@ import (
 )
 
 var podResource = metav1.GroupVersionResource{Version: "v1", Group: "", Resource: "pods"}
+
+// rolloutsHashLabel is the pod template hash label of the replicasets of an Argo rollout
+const rolloutsHashLabel = "rollouts-pod-template-hash"
+
 var findMatchingService = install.FindMatchingService
 
 func agentInjector(ctx context.Context, req *admission.AdmissionRequest) ([]patchOperation, error) {
@@ -67,6 +72,13 @@ func agentInjector(ctx context.Context, req *admission.AdmissionRequest) ([]patc
 		return nil, nil
 	}
 
+	env := managerutil.GetEnv(ctx)
+	if !env.IsManaged(podNamespace) {
+		dlog.Debugf(ctx, "The %s pod is in namespace %s, which is not managed by this traffic-manager; skipping",
+			refPodName, podNamespace)
+		return nil, nil
+	}
+
 	// Make the kates client available in the context
 	// TODO: Use the kubernetes SharedInformerFactory instead
 	client, err := kates.NewClient(kates.ClientConfig{})
@@ -82,7 +94,7 @@ func agentInjector(ctx context.Context, req *admission.AdmissionRequest) ([]patc
 
 	// The ServicePortAnnotation is expected to contain a string that identifies the service port.
 	portNameOrNumber := pod.Annotations[install.ServicePortAnnotation]
-	servicePort, appContainer, containerPortIndex, err := install.FindMatchingPort(pod.Spec.Containers, portNameOrNumber, svc)
+	servicePort, appContainer, containerPortIndex, err := install.FindMatchingPort(pod.Spec.Containers, portNameOrNumber, "", svc)
 	if err != nil {
 		dlog.Error(ctx, err)
 		return nil, nil
@@ -92,7 +104,6 @@ func agentInjector(ctx context.Context, req *admission.AdmissionRequest) ([]patc
 		return nil, nil
 	}
 
-	env := managerutil.GetEnv(ctx)
 	ports := appContainer.Ports
 	for i := range ports {
 		if ports[i].ContainerPort == env.AgentPort {
@@ -118,11 +129,26 @@ func agentInjector(ctx context.Context, req *admission.AdmissionRequest) ([]patc
 		return nil, fmt.Errorf("container port unexpectedly not found in %s", refPodName)
 	}
 
+	// The configuration of the traffic-agent is validated here, so that a pod with invalid annotations
+	// is rejected with an error that names the offending field.
+	agentConfig, err := env.AgentConfig()
+	if err != nil {
+		return nil, err
+	}
+	if agentConfig, err = agentConfig.WithAnnotations(pod.Annotations); err != nil {
+		return nil, fmt.Errorf("unable to inject %s into pod %s: %w", install.AgentContainerName, refPodName, err)
+	}
+	if agentConfig.IgnoresPort(appPort.ContainerPort) {
+		dlog.Infof(ctx, "the %s pod container %s port %d is one of the ignored ports %v of the %s; skipping",
+			refPodName, appContainer.Name, appPort.ContainerPort, agentConfig.IgnorePorts, install.AgentContainerName)
+		return nil, nil
+	}
+
 	// Create patch operations to add the traffic-agent sidecar
 	dlog.Infof(ctx, "Injecting %s into pod %s", install.AgentContainerName, refPodName)
 
 	var patches []patchOperation
-	patches, err = addAgentContainer(ctx, svc, &pod, servicePort, appContainer, &appPort, podName, podNamespace, patches)
+	patches, err = addAgentContainer(ctx, svc, &pod, servicePort, appContainer, &appPort, podName, podNamespace, agentConfig, patches)
 	if err != nil {
 		return nil, err
 	}
@@ -132,9 +158,41 @@ func agentInjector(ctx context.Context, req *admission.AdmissionRequest) ([]patc
 		patches = hidePorts(&pod, appContainer, servicePort.TargetPort.StrVal, patches)
 	}
 	patches = addAgentVolume(&pod, patches)
+	if agentConfig.ReadOnlyRootFilesystem() {
+		patches = addAgentTmpVolume(&pod, patches)
+	}
+	patches = addPullSecrets(&pod, strings.Fields(env.AgentPullSecrets), patches)
 	return patches, nil
 }
 
+// agentNameForPod returns the name of the agent for the given pod, which is the name of the pod's workload.
+func agentNameForPod(pod *corev1.Pod, podName string) string {
+	for _, owner := range pod.OwnerReferences {
+		switch owner.Kind {
+		case "StatefulSet":
+			// If the pod is owned by a statefulset, the workload's name is the same as the statefulset's
+			return owner.Name
+		case "ReplicaSet":
+			// If it's owned by a replicaset that belongs to a deployment or an Argo rollout, then it's the same
+			// as the deployment or rollout e.g. "my-echo-697464c6c5" -> "my-echo". Those replicasets are named
+			// after a pod template hash label, so a replicaset without one has no such owner.
+			for _, hashLabel := range []string{appsv1.DefaultDeploymentUniqueLabelKey, rolloutsHashLabel} {
+				if hash := pod.Labels[hashLabel]; hash != "" && strings.HasSuffix(owner.Name, "-"+hash) {
+					return strings.TrimSuffix(owner.Name, "-"+hash)
+				}
+			}
+			return owner.Name
+		}
+	}
+	// If we weren't able to find a good name for the agent from the owners, take it from the pod name
+	if strings.HasSuffix(podName, "-") {
+		// Transform a generated name "my-echo-697464c6c5-" into an agent service name "my-echo"
+		tokens := strings.Split(podName, "-")
+		return strings.Join(tokens[:len(tokens)-2], "-")
+	}
+	return podName
+}
+
 func addInitContainer(ctx context.Context, pod *corev1.Pod, svcPort *corev1.ServicePort, appPort *corev1.ContainerPort, patches []patchOperation) []patchOperation {
 	env := managerutil.GetEnv(ctx)
 	proto := svcPort.Protocol
@@ -192,6 +250,49 @@ func addAgentVolume(pod *corev1.Pod, patches []patchOperation) []patchOperation
 	})
 }
 
+// addAgentTmpVolume creates a patch operation that adds the volume that the traffic-agent writes to when its
+// root filesystem is read-only.
+func addAgentTmpVolume(pod *corev1.Pod, patches []patchOperation) []patchOperation {
+	for _, vol := range pod.Spec.Volumes {
+		if vol.Name == install.AgentTmpVolumeName {
+			return patches
+		}
+	}
+	return append(patches, patchOperation{
+		Op:    "add",
+		Path:  "/spec/volumes/-",
+		Value: install.AgentTmpVolume(),
+	})
+}
+
+// addPullSecrets creates patch operations that add the given image pull secrets of the traffic-agent that the
+// pod doesn't already declare.
+func addPullSecrets(pod *corev1.Pod, names []string, patches []patchOperation) []patchOperation {
+	missing := install.MissingPullSecrets(pod.Spec.ImagePullSecrets, names)
+	if len(missing) == 0 {
+		return patches
+	}
+	refs := make([]corev1.LocalObjectReference, len(missing))
+	for i, name := range missing {
+		refs[i] = corev1.LocalObjectReference{Name: name}
+	}
+	if len(pod.Spec.ImagePullSecrets) == 0 {
+		return append(patches, patchOperation{
+			Op:    "add",
+			Path:  "/spec/imagePullSecrets",
+			Value: refs,
+		})
+	}
+	for _, ref := range refs {
+		patches = append(patches, patchOperation{
+			Op:    "add",
+			Path:  "/spec/imagePullSecrets/-",
+			Value: ref,
+		})
+	}
+	return patches
+}
+
 // addAgentContainer creates a patch operation to add the traffic-agent container
 func addAgentContainer(
 	ctx context.Context,
@@ -201,6 +302,7 @@ func addAgentContainer(
 	appContainer *corev1.Container,
 	appPort *corev1.ContainerPort,
 	podName, namespace string,
+	agentConfig *install.AgentConfig,
 	patches []patchOperation,
 ) ([]patchOperation, error) {
 	env := managerutil.GetEnv(ctx)
@@ -222,32 +324,7 @@ func addAgentContainer(
 			return strconv.Itoa(int(svcPort.Port))
 		}(), refPodName)
 
-	agentName := ""
-	if pod.OwnerReferences != nil {
-	owners:
-		for _, owner := range pod.OwnerReferences {
-			switch owner.Kind {
-			case "StatefulSet":
-				// If the pod is owned by a statefulset, the workload's name is the same as the statefulset's
-				agentName = owner.Name
-				break owners
-			case "ReplicaSet":
-				// If it's owned by a replicaset, then it's the same as the deployment e.g. "my-echo-697464c6c5" -> "my-echo"
-				tokens := strings.Split(owner.Name, "-")
-				agentName = strings.Join(tokens[:len(tokens)-1], "-")
-				break owners
-			}
-		}
-	}
-	if agentName == "" {
-		// If we weren't able to find a good name for the agent from the owners, take it from the pod name
-		agentName = podName
-		if strings.HasSuffix(agentName, "-") {
-			// Transform a generated name "my-echo-697464c6c5-" into an agent service name "my-echo"
-			tokens := strings.Split(podName, "-")
-			agentName = strings.Join(tokens[:len(tokens)-2], "-")
-		}
-	}
+	agentName := agentNameForPod(pod, podName)
 
 	proto := svcPort.Protocol
 	if proto == "" {
@@ -269,7 +346,8 @@ func addAgentContainer(
 			appContainer,
 			containerPort,
 			int(appPort.ContainerPort),
-			env.ManagerNamespace)})
+			env.ManagerNamespace,
+			agentConfig)})
 
 	return patches, nil
 }
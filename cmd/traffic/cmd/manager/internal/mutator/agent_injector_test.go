@@ -3,6 +3,7 @@ package mutator
 import (
 	"context"
 	"encoding/json"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -470,6 +471,7 @@ func TestTrafficAgentInjector(t *testing.T) {
 				`{"name":"_TEL_AGENT_APP_PORT","value":"8888"},` +
 				`{"name":"_TEL_AGENT_APP_MOUNTS","value":"/tel_app_mounts"},` +
 				`{"name":"TELEPRESENCE_MOUNTS","value":"/var/run/secrets/kubernetes.io/serviceaccount"},` +
+				`{"name":"_TEL_AGENT_APP_VOLUME_MOUNTS","value":"some-token=/var/run/secrets/kubernetes.io/serviceaccount"},` +
 				`{"name":"_TEL_AGENT_MANAGER_HOST","value":"traffic-manager.default"}` +
 				`],` +
 				`"resources":{},` +
@@ -514,6 +516,346 @@ func TestTrafficAgentInjector(t *testing.T) {
 	}
 }
 
+func TestTrafficAgentInjector_agentConfig(t *testing.T) {
+	svc := &kates.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "some-name", Namespace: "some-ns"},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{
+				Protocol:   "TCP",
+				Port:       80,
+				TargetPort: intstr.FromString("http"),
+			}},
+			Selector: map[string]string{"service": "some-name"},
+		},
+	}
+	fms := findMatchingService
+	defer func() {
+		findMatchingService = fms
+	}()
+	findMatchingService = func(c context.Context, client *kates.Client, portNameOrNumber, svcName, namespace string, labels map[string]string) (*kates.Service, error) {
+		return svc, nil
+	}
+
+	podRequest := func(annotations map[string]string) *admission.AdmissionRequest {
+		annotations[install.InjectAnnotation] = "enabled"
+		return toAdmissionRequest(podResource, corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: annotations,
+				Labels:      map[string]string{"service": "some-name"},
+				Namespace:   "some-ns",
+				Name:        "some-name",
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{
+					Name:  "some-app-name",
+					Image: "some-app-image",
+					Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8888}},
+				}},
+				Volumes: []corev1.Volume{{Name: "some-token"}},
+			},
+		})
+	}
+
+	// inject returns the traffic-agent container and the names of the volumes that the patches add
+	inject := func(t *testing.T, env *managerutil.Env, annotations map[string]string) (*corev1.Container, []string, error) {
+		t.Helper()
+		ctx := managerutil.WithEnv(dlog.NewTestContext(t, false), env)
+		patches, err := agentInjector(ctx, podRequest(annotations))
+		if err != nil {
+			return nil, nil, err
+		}
+		var agent *corev1.Container
+		var volumes []string
+		for _, p := range patches {
+			switch v := p.Value.(type) {
+			case corev1.Container:
+				if v.Name == install.AgentContainerName {
+					agent = &v
+				}
+			case corev1.Volume:
+				volumes = append(volumes, v.Name)
+			}
+		}
+		require.NotNil(t, agent)
+		return agent, volumes, nil
+	}
+
+	newEnv := func(resources, securityContext string) *managerutil.Env {
+		return &managerutil.Env{
+			ManagerNamespace:     "default",
+			AgentRegistry:        "docker.io/datawire",
+			AgentImage:           "tel2:2.4.5",
+			AgentPort:            9900,
+			AgentResources:       resources,
+			AgentSecurityContext: securityContext,
+		}
+	}
+
+	t.Run("defaults of the traffic-manager", func(t *testing.T) {
+		env := newEnv(`{"requests":{"cpu":"50m"},"limits":{"memory":"128Mi"}}`, `{"readOnlyRootFilesystem":true}`)
+		agent, volumes, err := inject(t, env, map[string]string{})
+		require.NoError(t, err)
+		assert.Equal(t, "50m", agent.Resources.Requests.Cpu().String())
+		assert.Equal(t, "128Mi", agent.Resources.Limits.Memory().String())
+		require.NotNil(t, agent.SecurityContext.ReadOnlyRootFilesystem)
+		assert.True(t, *agent.SecurityContext.ReadOnlyRootFilesystem)
+		assert.Contains(t, agent.VolumeMounts, corev1.VolumeMount{Name: install.AgentTmpVolumeName, MountPath: "/tmp"})
+		assert.Equal(t, []string{install.AgentAnnotationVolumeName, install.AgentTmpVolumeName}, volumes)
+	})
+
+	t.Run("annotations take precedence", func(t *testing.T) {
+		env := newEnv(`{"requests":{"cpu":"50m"}}`, `{"readOnlyRootFilesystem":true}`)
+		agent, volumes, err := inject(t, env, map[string]string{
+			install.AgentResourcesAnnotation:       `{"requests":{"cpu":"100m"},"limits":{"cpu":"200m"}}`,
+			install.AgentSecurityContextAnnotation: `{"readOnlyRootFilesystem":false}`,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "100m", agent.Resources.Requests.Cpu().String())
+		assert.Equal(t, "200m", agent.Resources.Limits.Cpu().String())
+		require.NotNil(t, agent.SecurityContext.ReadOnlyRootFilesystem)
+		assert.False(t, *agent.SecurityContext.ReadOnlyRootFilesystem)
+		assert.Equal(t, []string{install.AgentAnnotationVolumeName}, volumes)
+	})
+
+	t.Run("invalid annotation", func(t *testing.T) {
+		_, _, err := inject(t, newEnv("", ""), map[string]string{
+			install.AgentResourcesAnnotation: `{"limits":{"memory":"lots"}}`,
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "some-name.some-ns")
+		assert.Contains(t, err.Error(), `limits.memory: "lots" is not a valid quantity`)
+	})
+}
+
+func TestTrafficAgentInjector_ignorePorts(t *testing.T) {
+	var svc *kates.Service
+	fms := findMatchingService
+	defer func() {
+		findMatchingService = fms
+	}()
+	findMatchingService = func(c context.Context, client *kates.Client, portNameOrNumber, svcName, namespace string, labels map[string]string) (*kates.Service, error) {
+		return svc, nil
+	}
+	newSvc := func(targetPort intstr.IntOrString) *kates.Service {
+		return &kates.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "some-name", Namespace: "some-ns"},
+			Spec: corev1.ServiceSpec{
+				Ports:    []corev1.ServicePort{{Protocol: "TCP", Port: 80, TargetPort: targetPort}},
+				Selector: map[string]string{"service": "some-name"},
+			},
+		}
+	}
+
+	appContainer := corev1.Container{
+		Name:  "some-app-name",
+		Image: "some-app-image",
+		Ports: []corev1.ContainerPort{
+			{Name: "http", ContainerPort: 8080},
+			{Name: "metrics", ContainerPort: 9090},
+		},
+	}
+	proxyContainer := corev1.Container{
+		Name:  "some-proxy-name",
+		Image: "some-proxy-image",
+		Ports: []corev1.ContainerPort{
+			{Name: "status", ContainerPort: 15020},
+			{Name: "metrics", ContainerPort: 9090},
+		},
+	}
+
+	// inject returns the patches of a pod with the given containers and ignored ports, and a traffic-manager
+	// that ignores the given ports by default.
+	inject := func(t *testing.T, defaultPorts, ignorePorts string, containers ...corev1.Container) []patchOperation {
+		t.Helper()
+		ctx := managerutil.WithEnv(dlog.NewTestContext(t, false), &managerutil.Env{
+			ManagerNamespace: "default",
+			AgentRegistry:    "docker.io/datawire",
+			AgentImage:       "tel2:2.4.5",
+			AgentPort:        9900,
+			AgentIgnorePorts: defaultPorts,
+		})
+		annotations := map[string]string{install.InjectAnnotation: "enabled"}
+		if ignorePorts != "" {
+			annotations[install.IgnorePortsAnnotation] = ignorePorts
+		}
+		patches, err := agentInjector(ctx, toAdmissionRequest(podResource, corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: annotations,
+				Labels:      map[string]string{"service": "some-name"},
+				Namespace:   "some-ns",
+				Name:        "some-name",
+			},
+			Spec: corev1.PodSpec{Containers: containers},
+		}))
+		require.NoError(t, err)
+		return patches
+	}
+
+	// checkInjected asserts that the traffic-agent takes over the given port of the first container, and that
+	// no other port is modified.
+	checkInjected := func(t *testing.T, patches []patchOperation, portPath string, appPort string) {
+		t.Helper()
+		var agent *corev1.Container
+		for _, p := range patches {
+			switch v := p.Value.(type) {
+			case corev1.Container:
+				if v.Name == install.AgentContainerName {
+					agent = &v
+				}
+			}
+			if strings.Contains(p.Path, "/ports/") {
+				assert.Equal(t, portPath, p.Path)
+			}
+		}
+		require.NotNil(t, agent)
+		assert.Contains(t, agent.Env, corev1.EnvVar{Name: install.EnvPrefix + "APP_PORT", Value: appPort})
+	}
+
+	t.Run("single container", func(t *testing.T) {
+		svc = newSvc(intstr.FromString("http"))
+		checkInjected(t, inject(t, "", "9090,15020", appContainer), "/spec/containers/0/ports/0/name", "8080")
+
+		svc = newSvc(intstr.FromString("metrics"))
+		assert.Empty(t, inject(t, "", "9090,15020", appContainer))
+		assert.NotEmpty(t, inject(t, "", "", appContainer))
+	})
+
+	t.Run("multiple containers", func(t *testing.T) {
+		svc = newSvc(intstr.FromString("http"))
+		checkInjected(t, inject(t, "", "9090,15020", appContainer, proxyContainer), "/spec/containers/0/ports/0/name", "8080")
+
+		// The proxy container's ports are ignored, as is the 9090 that both containers declare
+		svc = newSvc(intstr.FromInt(15020))
+		assert.Empty(t, inject(t, "", "9090,15020", appContainer, proxyContainer))
+		svc = newSvc(intstr.FromInt(9090))
+		assert.Empty(t, inject(t, "", "9090,15020", proxyContainer))
+	})
+
+	t.Run("defaults of the traffic-manager", func(t *testing.T) {
+		svc = newSvc(intstr.FromString("metrics"))
+		assert.Empty(t, inject(t, "9090", "", appContainer))
+		assert.Empty(t, inject(t, "9090", "9090,15020", appContainer), "ports that are listed twice are ignored")
+
+		// The annotation adds to the defaults
+		svc = newSvc(intstr.FromString("http"))
+		assert.Empty(t, inject(t, "9090", "8080", appContainer))
+		checkInjected(t, inject(t, "15020", "9090", appContainer, proxyContainer), "/spec/containers/0/ports/0/name", "8080")
+	})
+
+	t.Run("deterministic", func(t *testing.T) {
+		svc = newSvc(intstr.FromInt(8080))
+		expected, err := json.Marshal(inject(t, "15020", "9090", appContainer, proxyContainer))
+		require.NoError(t, err)
+		for _, ports := range [][2]string{{"9090", "15020"}, {"", "15020, 9090"}, {"9090,15020", "15020,9090"}} {
+			actual, err := json.Marshal(inject(t, ports[0], ports[1], appContainer, proxyContainer))
+			require.NoError(t, err)
+			assert.Equal(t, string(expected), string(actual), ports)
+		}
+	})
+}
+
+func TestTrafficAgentInjector_managedNamespaces(t *testing.T) {
+	svc := &kates.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "some-name", Namespace: "some-ns"},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{
+				Protocol:   "TCP",
+				Port:       80,
+				TargetPort: intstr.FromString("http"),
+			}},
+			Selector: map[string]string{"service": "some-name"},
+		},
+	}
+	fms := findMatchingService
+	defer func() {
+		findMatchingService = fms
+	}()
+	findMatchingService = func(c context.Context, client *kates.Client, portNameOrNumber, svcName, namespace string, labels map[string]string) (*kates.Service, error) {
+		return svc, nil
+	}
+	request := toAdmissionRequest(podResource, corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{install.InjectAnnotation: "enabled"},
+			Labels:      map[string]string{"service": "some-name"},
+			Namespace:   "some-ns",
+			Name:        "some-name",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:  "some-app-name",
+				Image: "some-app-image",
+				Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8888}},
+			}},
+		},
+	})
+	inject := func(t *testing.T, managedNamespaces string) []patchOperation {
+		t.Helper()
+		ctx := managerutil.WithEnv(dlog.NewTestContext(t, false), &managerutil.Env{
+			ManagerNamespace:  "default",
+			AgentRegistry:     "docker.io/datawire",
+			AgentImage:        "tel2:2.4.5",
+			AgentPort:         9900,
+			ManagedNamespaces: managedNamespaces,
+		})
+		patches, err := agentInjector(ctx, request)
+		require.NoError(t, err)
+		return patches
+	}
+
+	assert.Empty(t, inject(t, "other"), "pods in unmanaged namespaces must not be mutated")
+	assert.NotEmpty(t, inject(t, "other some-ns"))
+	assert.NotEmpty(t, inject(t, ""))
+}
+
+func TestAddPullSecrets(t *testing.T) {
+	withSecrets := func(names ...string) *corev1.Pod {
+		pod := &corev1.Pod{}
+		for _, name := range names {
+			pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+		}
+		return pod
+	}
+	tests := []struct {
+		name          string
+		pod           *corev1.Pod
+		secrets       []string
+		expectedPatch string
+	}{
+		{
+			"No secrets configured",
+			withSecrets("app-creds"),
+			nil,
+			`null`,
+		},
+		{
+			"Pod without secrets",
+			withSecrets(),
+			[]string{"mirror-creds", "other-creds"},
+			`[{"op":"add","path":"/spec/imagePullSecrets","value":[{"name":"mirror-creds"},{"name":"other-creds"}]}]`,
+		},
+		{
+			"Existing secrets are preserved",
+			withSecrets("app-creds"),
+			[]string{"mirror-creds"},
+			`[{"op":"add","path":"/spec/imagePullSecrets/-","value":{"name":"mirror-creds"}}]`,
+		},
+		{
+			"Duplicates are avoided",
+			withSecrets("app-creds", "mirror-creds"),
+			[]string{"mirror-creds", "other-creds", "other-creds"},
+			`[{"op":"add","path":"/spec/imagePullSecrets/-","value":{"name":"other-creds"}}]`,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			patchBytes, err := json.Marshal(addPullSecrets(test.pod, test.secrets, nil))
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedPatch, string(patchBytes))
+		})
+	}
+}
+
 func assertContains(t *testing.T, err error, expected string) {
 	if expected == "" {
 		assert.NoError(t, err)
@@ -534,3 +876,28 @@ func toAdmissionRequest(resource metav1.GroupVersionResource, object interface{}
 		Namespace: "default",
 	}
 }
+
+func TestAgentNameForPod(t *testing.T) {
+	pod := func(labels map[string]string, owners ...metav1.OwnerReference) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: labels, OwnerReferences: owners}}
+	}
+	owner := func(kind, name string) metav1.OwnerReference {
+		return metav1.OwnerReference{Kind: kind, Name: name}
+	}
+	hash := map[string]string{"pod-template-hash": "697464c6c5"}
+
+	// The replicaset of a deployment
+	assert.Equal(t, "my-echo", agentNameForPod(pod(hash, owner("ReplicaSet", "my-echo-697464c6c5")), "my-echo-697464c6c5-"))
+
+	// A replicaset that isn't owned by a deployment
+	assert.Equal(t, "legacy-rs", agentNameForPod(pod(nil, owner("ReplicaSet", "legacy-rs")), "legacy-rs-"))
+	assert.Equal(t, "legacy-rs", agentNameForPod(pod(hash, owner("ReplicaSet", "legacy-rs")), "legacy-rs-"))
+
+	// The replicaset of an Argo rollout
+	rolloutHash := map[string]string{"rollouts-pod-template-hash": "5b4f8c7d9"}
+	assert.Equal(t, "web", agentNameForPod(pod(rolloutHash, owner("ReplicaSet", "web-5b4f8c7d9")), "web-5b4f8c7d9-"))
+
+	assert.Equal(t, "db", agentNameForPod(pod(nil, owner("StatefulSet", "db")), "db-0"))
+	assert.Equal(t, "my-echo", agentNameForPod(pod(nil), "my-echo-697464c6c5-"))
+	assert.Equal(t, "bare", agentNameForPod(pod(nil), "bare"))
+}
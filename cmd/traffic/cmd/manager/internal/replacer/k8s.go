@@ -0,0 +1,228 @@
+package replacer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// ReplacedContainerAnnotation is set on a replaced workload. It contains the JSON of the original
+// application container.
+const ReplacedContainerAnnotation = install.DomainPrefix + "replaced-container"
+
+type k8sWorkloads struct {
+	ki         kubernetes.Interface
+	idleImage  string
+	namespaces []string
+}
+
+// NewK8sWorkloads returns Workloads that replace the application container in the pod template of
+// Deployments, ReplicaSets, and StatefulSets with a container that runs the given image and does nothing.
+// The image must provide a "sleep" command. Replaced workloads are found in the given namespaces, or in
+// all namespaces when none are given.
+func NewK8sWorkloads(ki kubernetes.Interface, idleImage string, namespaces []string) Workloads {
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+	return &k8sWorkloads{ki: ki, idleImage: idleImage, namespaces: namespaces}
+}
+
+// workloadObject is a workload with a pod template, and a function that updates it in the cluster.
+type workloadObject struct {
+	metav1.Object
+	template *corev1.PodTemplateSpec
+	update   func(context.Context) error
+}
+
+func (k *k8sWorkloads) get(ctx context.Context, w Workload) (*workloadObject, error) {
+	apps := k.ki.AppsV1()
+	dep, err := apps.Deployments(w.Namespace).Get(ctx, w.Name, metav1.GetOptions{})
+	if err == nil {
+		return &workloadObject{Object: dep, template: &dep.Spec.Template, update: func(ctx context.Context) error {
+			_, err := apps.Deployments(w.Namespace).Update(ctx, dep, metav1.UpdateOptions{})
+			return err
+		}}, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+	rs, err := apps.ReplicaSets(w.Namespace).Get(ctx, w.Name, metav1.GetOptions{})
+	if err == nil {
+		return &workloadObject{Object: rs, template: &rs.Spec.Template, update: func(ctx context.Context) error {
+			_, err := apps.ReplicaSets(w.Namespace).Update(ctx, rs, metav1.UpdateOptions{})
+			return err
+		}}, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+	ss, err := apps.StatefulSets(w.Namespace).Get(ctx, w.Name, metav1.GetOptions{})
+	if err == nil {
+		return &workloadObject{Object: ss, template: &ss.Spec.Template, update: func(ctx context.Context) error {
+			_, err := apps.StatefulSets(w.Namespace).Update(ctx, ss, metav1.UpdateOptions{})
+			return err
+		}}, nil
+	}
+	return nil, err
+}
+
+func (k *k8sWorkloads) Replace(ctx context.Context, w Workload) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj, err := k.get(ctx, w)
+		if err != nil {
+			return err
+		}
+		if _, ok := obj.GetAnnotations()[ReplacedContainerAnnotation]; ok {
+			return nil
+		}
+		cns := obj.template.Spec.Containers
+		idx, err := appContainerIndex(cns)
+		if err != nil {
+			return fmt.Errorf("%s: %w", w, err)
+		}
+		orig, err := json.Marshal(&cns[idx])
+		if err != nil {
+			return err
+		}
+		anns := obj.GetAnnotations()
+		if anns == nil {
+			anns = make(map[string]string)
+		}
+		anns[ReplacedContainerAnnotation] = string(orig)
+		obj.SetAnnotations(anns)
+		cns[idx] = idleContainer(&cns[idx], k.idleImage)
+		return obj.update(ctx)
+	})
+}
+
+func (k *k8sWorkloads) Restore(ctx context.Context, w Workload) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj, err := k.get(ctx, w)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				// Nothing left to restore
+				return nil
+			}
+			return err
+		}
+		anns := obj.GetAnnotations()
+		orig, ok := anns[ReplacedContainerAnnotation]
+		if !ok {
+			return nil
+		}
+		var cn corev1.Container
+		if err = json.Unmarshal([]byte(orig), &cn); err != nil {
+			return fmt.Errorf("unable to parse annotation %s of %s: %w", ReplacedContainerAnnotation, w, err)
+		}
+		cns := obj.template.Spec.Containers
+		for i := range cns {
+			if cns[i].Name == cn.Name {
+				cns[i] = cn
+				break
+			}
+		}
+		delete(anns, ReplacedContainerAnnotation)
+		obj.SetAnnotations(anns)
+		return obj.update(ctx)
+	})
+}
+
+func (k *k8sWorkloads) Replaced(ctx context.Context) ([]Workload, error) {
+	var ws []Workload
+	add := func(obj metav1.Object) {
+		if _, ok := obj.GetAnnotations()[ReplacedContainerAnnotation]; ok {
+			ws = append(ws, Workload{Name: obj.GetName(), Namespace: obj.GetNamespace()})
+		}
+	}
+	apps := k.ki.AppsV1()
+	for _, ns := range k.namespaces {
+		deps, err := apps.Deployments(ns).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for i := range deps.Items {
+			add(&deps.Items[i])
+		}
+		rss, err := apps.ReplicaSets(ns).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for i := range rss.Items {
+			add(&rss.Items[i])
+		}
+		sss, err := apps.StatefulSets(ns).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for i := range sss.Items {
+			add(&sss.Items[i])
+		}
+	}
+	return ws, nil
+}
+
+// appContainerIndex returns the index of the container that the traffic-agent forwards to. That's the
+// container that declares the agent's application port, or the only container besides the agent.
+func appContainerIndex(cns []corev1.Container) (int, error) {
+	appPort := 0
+	for i := range cns {
+		if cns[i].Name != install.AgentContainerName {
+			continue
+		}
+		for _, env := range cns[i].Env {
+			if env.Name == install.EnvPrefix+"APP_PORT" {
+				appPort, _ = strconv.Atoi(env.Value)
+			}
+		}
+	}
+	if appPort != 0 {
+		for i := range cns {
+			if cns[i].Name == install.AgentContainerName {
+				continue
+			}
+			for _, p := range cns[i].Ports {
+				if int(p.ContainerPort) == appPort {
+					return i, nil
+				}
+			}
+		}
+	}
+	candidate := -1
+	for i := range cns {
+		if cns[i].Name == install.AgentContainerName {
+			continue
+		}
+		if candidate >= 0 {
+			return 0, fmt.Errorf("unable to determine which of the containers %s and %s to replace", cns[candidate].Name, cns[i].Name)
+		}
+		candidate = i
+	}
+	if candidate < 0 {
+		return 0, fmt.Errorf("found no container to replace")
+	}
+	return candidate, nil
+}
+
+// idleContainer returns a copy of the given container that keeps its ports, environment, and volumes, but
+// runs the given image and does nothing.
+func idleContainer(cn *corev1.Container, image string) corev1.Container {
+	idle := *cn.DeepCopy()
+	idle.Image = image
+	idle.Command = []string{"sleep", "infinity"}
+	idle.Args = nil
+	idle.WorkingDir = ""
+	idle.LivenessProbe = nil
+	idle.ReadinessProbe = nil
+	idle.StartupProbe = nil
+	idle.Lifecycle = nil
+	return idle
+}
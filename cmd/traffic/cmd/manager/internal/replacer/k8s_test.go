@@ -0,0 +1,95 @@
+package replacer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+func TestK8sWorkloads(t *testing.T) {
+	ctx := context.Background()
+	app := corev1.Container{
+		Name:           "echo",
+		Image:          "jmalloc/echo-server",
+		Args:           []string{"--verbose"},
+		Ports:          []corev1.ContainerPort{{ContainerPort: 8080}},
+		ReadinessProbe: &corev1.Probe{},
+	}
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{Name: "sidecar", Image: "envoy"},
+						app,
+						{Name: install.AgentContainerName, Env: []corev1.EnvVar{{Name: install.EnvPrefix + "APP_PORT", Value: "8080"}}},
+					},
+				},
+			},
+		},
+	}
+	ki := fake.NewSimpleClientset(dep)
+	ws := NewK8sWorkloads(ki, "docker.io/datawire/tel2:2.4.5", nil)
+	w := Workload{Name: "echo", Namespace: "default"}
+
+	require.NoError(t, ws.Replace(ctx, w))
+	dep, err := ki.AppsV1().Deployments("default").Get(ctx, "echo", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Contains(t, dep.Annotations, ReplacedContainerAnnotation)
+	idle := dep.Spec.Template.Spec.Containers[1]
+	assert.Equal(t, "echo", idle.Name)
+	assert.Equal(t, "docker.io/datawire/tel2:2.4.5", idle.Image)
+	assert.Equal(t, []string{"sleep", "infinity"}, idle.Command)
+	assert.Nil(t, idle.Args)
+	assert.Nil(t, idle.ReadinessProbe)
+	assert.Equal(t, app.Ports, idle.Ports)
+	assert.Equal(t, "envoy", dep.Spec.Template.Spec.Containers[0].Image)
+
+	replaced, err := ws.Replaced(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []Workload{w}, replaced)
+
+	require.NoError(t, ws.Restore(ctx, w))
+	dep, err = ki.AppsV1().Deployments("default").Get(ctx, "echo", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, dep.Annotations, ReplacedContainerAnnotation)
+	assert.Equal(t, app, dep.Spec.Template.Spec.Containers[1])
+
+	replaced, err = ws.Replaced(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, replaced)
+
+	// Workloads in namespaces that aren't managed are never listed
+	require.NoError(t, ws.Replace(ctx, w))
+	replaced, err = NewK8sWorkloads(ki, "docker.io/datawire/tel2:2.4.5", []string{"other"}).Replaced(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, replaced)
+	replaced, err = NewK8sWorkloads(ki, "docker.io/datawire/tel2:2.4.5", []string{"other", "default"}).Replaced(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []Workload{w}, replaced)
+	require.NoError(t, ws.Restore(ctx, w))
+
+	// Restoring a workload that no longer exists is a no-op
+	assert.NoError(t, ws.Restore(ctx, Workload{Name: "gone", Namespace: "default"}))
+}
+
+func TestAppContainerIndex(t *testing.T) {
+	_, err := appContainerIndex([]corev1.Container{{Name: "a"}, {Name: "b"}})
+	assert.Error(t, err)
+
+	_, err = appContainerIndex([]corev1.Container{{Name: install.AgentContainerName}})
+	assert.Error(t, err)
+
+	idx, err := appContainerIndex([]corev1.Container{{Name: install.AgentContainerName}, {Name: "a"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, idx)
+}
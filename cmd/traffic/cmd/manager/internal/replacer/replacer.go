@@ -0,0 +1,94 @@
+// Package replacer idles the application container of workloads that are intercepted using --replace, and
+// restores the container when the intercept ends, regardless of whether it ends because the client left
+// the intercept or because the client's session expired.
+package replacer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/state"
+)
+
+// Workload identifies a workload by name and namespace.
+type Workload struct {
+	Name      string
+	Namespace string
+}
+
+func (w Workload) String() string {
+	return w.Name + "." + w.Namespace
+}
+
+// Workloads replaces and restores the application containers of workloads.
+type Workloads interface {
+	// Replace idles the application container of the given workload.
+	Replace(ctx context.Context, w Workload) error
+
+	// Restore brings back the original application container of the given workload.
+	Restore(ctx context.Context, w Workload) error
+
+	// Replaced returns all workloads that currently have their application container replaced.
+	Replaced(ctx context.Context) ([]Workload, error)
+}
+
+// Run watches the intercepts of the given state and ensures that a workload has its application
+// container replaced for exactly as long as it is intercepted by an intercept with the replace flag
+// set. Workloads that were left replaced by a previous incarnation of the traffic-manager are restored
+// unless they are intercepted again. When a workload can't be replaced, its intercepts get the
+// REPLACE_FAILED disposition, so that their clients learn why.
+func Run(ctx context.Context, s *state.State, workloads Workloads) error {
+	replaced := make(map[Workload]struct{})
+	ws, err := workloads.Replaced(ctx)
+	if err != nil {
+		dlog.Errorf(ctx, "unable to find replaced workloads: %v", err)
+	}
+	for _, w := range ws {
+		replaced[w] = struct{}{}
+	}
+
+	for snapshot := range s.WatchIntercepts(ctx, nil) {
+		// wanted maps the workloads that should be replaced to the IDs of the intercepts that replace them
+		wanted := make(map[Workload][]string)
+		for id, ii := range snapshot.State {
+			if ii.Spec.Replace && ii.Disposition != rpc.InterceptDispositionType_REPLACE_FAILED {
+				w := Workload{Name: ii.Spec.Agent, Namespace: ii.Spec.Namespace}
+				wanted[w] = append(wanted[w], id)
+			}
+		}
+
+		// Failures to restore are logged and retried when the next snapshot arrives.
+		for w := range replaced {
+			if _, ok := wanted[w]; ok {
+				continue
+			}
+			dlog.Infof(ctx, "Restoring the application container of %s", w)
+			if err := workloads.Restore(ctx, w); err != nil {
+				dlog.Errorf(ctx, "unable to restore the application container of %s: %v", w, err)
+				continue
+			}
+			delete(replaced, w)
+		}
+		for w, ids := range wanted {
+			if _, ok := replaced[w]; ok {
+				continue
+			}
+			dlog.Infof(ctx, "Replacing the application container of %s", w)
+			if err := workloads.Replace(ctx, w); err != nil {
+				msg := fmt.Sprintf("unable to replace the application container of %s: %v", w, err)
+				dlog.Error(ctx, msg)
+				for _, id := range ids {
+					s.UpdateIntercept(id, func(ii *rpc.InterceptInfo) {
+						ii.Disposition = rpc.InterceptDispositionType_REPLACE_FAILED
+						ii.Message = msg
+					})
+				}
+				continue
+			}
+			replaced[w] = struct{}{}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package replacer_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/replacer"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/state"
+	testdata "github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/test"
+)
+
+type fakeWorkloads struct {
+	sync.Mutex
+	replaced   map[replacer.Workload]bool
+	replaceErr error
+}
+
+func newFakeWorkloads(replaced ...replacer.Workload) *fakeWorkloads {
+	fw := &fakeWorkloads{replaced: make(map[replacer.Workload]bool)}
+	for _, w := range replaced {
+		fw.replaced[w] = true
+	}
+	return fw
+}
+
+func (fw *fakeWorkloads) Replace(_ context.Context, w replacer.Workload) error {
+	fw.Lock()
+	defer fw.Unlock()
+	if fw.replaceErr != nil {
+		return fw.replaceErr
+	}
+	fw.replaced[w] = true
+	return nil
+}
+
+func (fw *fakeWorkloads) Restore(_ context.Context, w replacer.Workload) error {
+	fw.Lock()
+	delete(fw.replaced, w)
+	fw.Unlock()
+	return nil
+}
+
+func (fw *fakeWorkloads) Replaced(context.Context) ([]replacer.Workload, error) {
+	fw.Lock()
+	defer fw.Unlock()
+	ws := make([]replacer.Workload, 0, len(fw.replaced))
+	for w := range fw.replaced {
+		ws = append(ws, w)
+	}
+	return ws, nil
+}
+
+func (fw *fakeWorkloads) isReplaced(w replacer.Workload) bool {
+	fw.Lock()
+	defer fw.Unlock()
+	return fw.replaced[w]
+}
+
+var hello = replacer.Workload{Name: "hello", Namespace: "default"}
+
+func startReplacer(t *testing.T, fw *fakeWorkloads) (context.Context, *state.State) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	s := state.NewState(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = replacer.Run(ctx, s, fw)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return ctx, s
+}
+
+func replaceSpec(name string) *rpc.InterceptSpec {
+	return &rpc.InterceptSpec{
+		Name:       name,
+		Client:     "alice@squirtle.bigcorp.com",
+		Agent:      "hello",
+		Namespace:  "default",
+		Mechanism:  "tcp",
+		TargetHost: "127.0.0.1",
+		TargetPort: 8080,
+		Replace:    true,
+	}
+}
+
+func TestReplacer_restoreOnLeave(t *testing.T) {
+	fw := newFakeWorkloads()
+	_, s := startReplacer(t, fw)
+	now := time.Now()
+	s.AddAgent(testdata.GetTestAgents(t)["hello"], now)
+	sessionID := s.AddClient(testdata.GetTestClients(t)["alice"], now)
+
+	ii, err := s.AddIntercept(sessionID, "", replaceSpec("hello"), time.Now())
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return fw.isReplaced(hello) }, 5*time.Second, 10*time.Millisecond)
+
+	require.True(t, s.RemoveIntercept(ii.Id))
+	assert.Eventually(t, func() bool { return !fw.isReplaced(hello) }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestReplacer_restoreOnSessionDeath(t *testing.T) {
+	fw := newFakeWorkloads()
+	ctx, s := startReplacer(t, fw)
+	now := time.Now()
+	agentID := s.AddAgent(testdata.GetTestAgents(t)["hello"], now)
+	sessionID := s.AddClient(testdata.GetTestClients(t)["alice"], now)
+
+	_, err := s.AddIntercept(sessionID, "", replaceSpec("hello"), time.Now())
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return fw.isReplaced(hello) }, 5*time.Second, 10*time.Millisecond)
+
+	// The client stops sending heartbeats while the agent keeps going, so only the client's session
+	// expires, and its intercept along with it.
+	later := now.Add(time.Minute)
+	require.True(t, s.MarkSession(&rpc.RemainRequest{Session: &rpc.SessionInfo{SessionId: agentID}}, later))
+	s.ExpireSessions(ctx, now.Add(time.Second))
+	assert.Nil(t, s.GetClient(sessionID))
+	assert.Eventually(t, func() bool { return !fw.isReplaced(hello) }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestReplacer_replaceFailed(t *testing.T) {
+	fw := newFakeWorkloads()
+	fw.replaceErr = errors.New("deployments.apps \"hello\" is forbidden")
+	_, s := startReplacer(t, fw)
+	now := time.Now()
+	s.AddAgent(testdata.GetTestAgents(t)["hello"], now)
+	sessionID := s.AddClient(testdata.GetTestClients(t)["alice"], now)
+
+	ii, err := s.AddIntercept(sessionID, "", replaceSpec("hello"), time.Now())
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		ii, ok := s.GetIntercept(ii.Id)
+		return ok && ii.Disposition == rpc.InterceptDispositionType_REPLACE_FAILED
+	}, 5*time.Second, 10*time.Millisecond)
+	ii, _ = s.GetIntercept(ii.Id)
+	assert.Equal(t, `unable to replace the application container of hello.default: deployments.apps "hello" is forbidden`, ii.Message)
+	assert.False(t, fw.isReplaced(hello))
+}
+
+func TestReplacer_restoreLeftovers(t *testing.T) {
+	// A workload that was left replaced by a previous traffic-manager is restored
+	fw := newFakeWorkloads(hello)
+	_, _ = startReplacer(t, fw)
+	assert.Eventually(t, func() bool { return !fw.isReplaced(hello) }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestReplacer_conflict(t *testing.T) {
+	fw := newFakeWorkloads()
+	_, s := startReplacer(t, fw)
+	now := time.Now()
+	clients := testdata.GetTestClients(t)
+	s.AddAgent(testdata.GetTestAgents(t)["hello"], now)
+	alice := s.AddClient(clients["alice"], now)
+	bob := s.AddClient(clients["bob"], now)
+
+	_, err := s.AddIntercept(alice, "", replaceSpec("hello"), time.Now())
+	require.NoError(t, err)
+
+	spec := replaceSpec("hello")
+	spec.Replace = false
+	_, err = s.AddIntercept(bob, "", spec, time.Now())
+	require.Error(t, err)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Contains(t, err.Error(), `workload hello.default is replaced by intercept "hello" of alice@squirtle.bigcorp.com`)
+
+	// A replace is refused when someone else intercepts the workload
+	s2 := state.NewState(dlog.NewTestContext(t, false))
+	s2.AddAgent(testdata.GetTestAgents(t)["hello"], now)
+	alice = s2.AddClient(clients["alice"], now)
+	bob = s2.AddClient(clients["bob"], now)
+	_, err = s2.AddIntercept(alice, "", spec, time.Now())
+	require.NoError(t, err)
+	_, err = s2.AddIntercept(bob, "", replaceSpec("hello"), time.Now())
+	require.Error(t, err)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sort"
 	"sync"
 	"time"
 
@@ -12,12 +13,14 @@ import (
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/datawire/dlib/dlog"
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/watchable"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/connpool"
+	"github.com/telepresenceio/telepresence/v2/pkg/forwarder"
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
 	"github.com/telepresenceio/telepresence/v2/pkg/log"
 	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
@@ -188,6 +191,12 @@ type agentSessionState struct {
 	agent           *rpc.AgentInfo
 	lookups         chan *rpc.LookupHostRequest
 	lookupResponses map[string]chan *rpc.LookupHostResponse
+
+	// logLevel is the temporary log-level of this agent only. The logLevelChanged channel is
+	// nil until the agent watches it, because older agents can't receive it.
+	logLevel        *rpc.RemoteLogLevel
+	logLevelTimer   *time.Timer
+	logLevelChanged chan struct{}
 }
 
 func (ss *agentSessionState) Cancel() {
@@ -195,6 +204,12 @@ func (ss *agentSessionState) Cancel() {
 	for _, lr := range ss.lookupResponses {
 		close(lr)
 	}
+	if ss.logLevelTimer != nil {
+		ss.logLevelTimer.Stop()
+	}
+	if ss.logLevelChanged != nil {
+		close(ss.logLevelChanged)
+	}
 	ss.sessionState.Cancel()
 }
 
@@ -227,6 +242,10 @@ type State struct {
 	agentsByName     map[string]map[string]*rpc.AgentInfo // indexed copy of `agents`
 	timedLogLevel    log.TimedLevel
 	logLevelCond     sync.Cond
+
+	// allAgentsLogLevel is true when the temporary log-level of the traffic-manager was set by
+	// SetTempLogLevel, and hence is the log-level of all agents.
+	allAgentsLogLevel bool
 }
 
 func NewState(ctx context.Context) *State {
@@ -403,11 +422,23 @@ func (s *State) SessionDone(id string) (<-chan struct{}, error) {
 
 	sess, ok := s.sessions[id]
 	if !ok {
-		return nil, status.Errorf(codes.NotFound, "session %q not found", id)
+		return nil, UnknownSessionError(id)
 	}
 	return sess.Done(), nil
 }
 
+// UnknownSessionError returns the NotFound error for a session that the manager doesn't know. The error has
+// an UnknownSession detail, so that a client can tell it from other NotFound errors, and arrive again. The
+// session may have expired, or it may have arrived at another replica or at the manager before a restart.
+func UnknownSessionError(id string) error {
+	msg := fmt.Sprintf("Session %q not found", id)
+	st, err := status.New(codes.NotFound, msg).WithDetails(&rpc.UnknownSession{SessionId: id})
+	if err != nil {
+		return status.Error(codes.NotFound, msg)
+	}
+	return st.Err()
+}
+
 // Sessions: Clients ///////////////////////////////////////////////////////////////////////////////
 
 func (s *State) AddClient(client *rpc.ClientInfo, now time.Time) string {
@@ -544,7 +575,7 @@ func (s *State) WatchAgents(
 
 // Intercepts //////////////////////////////////////////////////////////////////////////////////////
 
-func (s *State) AddIntercept(sessionID, apiKey string, spec *rpc.InterceptSpec) (*rpc.InterceptInfo, error) {
+func (s *State) AddIntercept(sessionID, apiKey string, spec *rpc.InterceptSpec, now time.Time) (*rpc.InterceptInfo, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -558,7 +589,11 @@ func (s *State) AddIntercept(sessionID, apiKey string, spec *rpc.InterceptSpec)
 		ClientSession: &rpc.SessionInfo{
 			SessionId: sessionID,
 		},
-		ApiKey: apiKey,
+		ApiKey:  apiKey,
+		Created: timestamppb.New(now),
+	}
+	if spec.Ttl > 0 {
+		cept.Expires = timestamppb.New(now.Add(time.Duration(spec.Ttl)))
 	}
 
 	// Wrap each potential-state-change in a
@@ -573,6 +608,10 @@ func (s *State) AddIntercept(sessionID, apiKey string, spec *rpc.InterceptSpec)
 		}
 	}
 
+	if err := s.unlockedCheckConflict(cept); err != nil {
+		return nil, err
+	}
+
 	if _, hasConflict := s.intercepts.LoadOrStore(cept.Id, cept); hasConflict {
 		return nil, status.Errorf(codes.AlreadyExists, "Intercept named %q already exists", spec.Name)
 	}
@@ -580,6 +619,70 @@ func (s *State) AddIntercept(sessionID, apiKey string, spec *rpc.InterceptSpec)
 	return cept, nil
 }
 
+// interceptsConflict returns true if the given intercepts can't coexist, because they intercept the same
+// workload and either of them replaces it, or they may intercept the same traffic.
+func interceptsConflict(a, b *rpc.InterceptSpec) bool {
+	return a.Agent == b.Agent && a.Namespace == b.Namespace && (a.Replace || b.Replace || forwarder.InterceptsOverlap(a, b))
+}
+
+// unlockedCheckConflict returns a FailedPrecondition error if the given intercept conflicts with another
+// intercept. The error has an InterceptConflict detail that describes the other intercept.
+func (s *State) unlockedCheckConflict(cept *rpc.InterceptInfo) error {
+	spec := cept.Spec
+	for id, other := range s.intercepts.LoadAll() {
+		// An expired intercept is about to be removed and doesn't hold the workload
+		if id == cept.Id || other.Disposition == rpc.InterceptDispositionType_EXPIRED || !interceptsConflict(spec, other.Spec) {
+			continue
+		}
+		var msg string
+		switch {
+		case other.Spec.Replace:
+			msg = fmt.Sprintf("workload %s.%s is replaced by intercept %q of %s and can't be intercepted until that intercept ends",
+				spec.Agent, spec.Namespace, other.Spec.Name, s.unlockedClientName(other.ClientSession.SessionId))
+		case spec.Replace:
+			msg = fmt.Sprintf("workload %s.%s can't be replaced because it is intercepted by intercept %q of %s",
+				spec.Agent, spec.Namespace, other.Spec.Name, s.unlockedClientName(other.ClientSession.SessionId))
+		default:
+			msg = fmt.Sprintf("workload %s.%s is already intercepted by intercept %q of %s, which intercepts the same traffic",
+				spec.Agent, spec.Namespace, other.Spec.Name, s.unlockedClientName(other.ClientSession.SessionId))
+		}
+		st, err := status.New(codes.FailedPrecondition, msg).WithDetails(&rpc.InterceptConflict{
+			Name:           other.Spec.Name,
+			Client:         other.Spec.Client,
+			Created:        other.Created,
+			HttpHeaders:    other.Spec.HttpHeaders,
+			HttpPathPrefix: other.Spec.HttpPathPrefix,
+			Protocol:       other.Spec.Protocol,
+			Replace:        other.Spec.Replace || spec.Replace,
+		})
+		if err != nil {
+			return status.Error(codes.FailedPrecondition, msg)
+		}
+		return st.Err()
+	}
+	return nil
+}
+
+// RemoveConflictingIntercepts removes the intercepts that conflict with the intercept with the given spec of
+// the client with the given session ID, and returns them.
+func (s *State) RemoveConflictingIntercepts(sessionID string, spec *rpc.InterceptSpec) []*rpc.InterceptInfo {
+	interceptID := fmt.Sprintf("%s:%s", sessionID, spec.Name)
+	var removed []*rpc.InterceptInfo
+	for id, other := range s.intercepts.LoadAll() {
+		if id != interceptID && interceptsConflict(spec, other.Spec) && s.RemoveIntercept(id) {
+			removed = append(removed, other)
+		}
+	}
+	return removed
+}
+
+func (s *State) unlockedClientName(sessionID string) string {
+	if client, ok := s.clients.Load(sessionID); ok && client.Name != "" {
+		return client.Name
+	}
+	return "another client"
+}
+
 // getAgentsInterceptedByClient returns the session IDs for each agent that are currently
 // intercepted by the client with the given client session ID.
 func (s *State) getAgentsInterceptedByClient(clientSessionID string) []string {
@@ -636,6 +739,34 @@ func (s *State) UpdateIntercept(interceptID string, apply func(*rpc.InterceptInf
 	}
 }
 
+// ExtendIntercept makes the intercept with the given ID expire at the given time. It returns nil if the
+// intercept doesn't exist. An intercept that has expired already is returned as is.
+func (s *State) ExtendIntercept(interceptID string, expires time.Time) *rpc.InterceptInfo {
+	return s.UpdateIntercept(interceptID, func(cept *rpc.InterceptInfo) {
+		if cept.Disposition != rpc.InterceptDispositionType_EXPIRED {
+			cept.Expires = timestamppb.New(expires)
+		}
+	})
+}
+
+// ExpireIntercepts ends the intercepts that expire before the given moment. An intercept is first given
+// the EXPIRED disposition, so that its client learns why it ends, and is then removed by the next call.
+func (s *State) ExpireIntercepts(ctx context.Context, moment time.Time) {
+	for id, cept := range s.intercepts.LoadAll() {
+		switch {
+		case cept.Disposition == rpc.InterceptDispositionType_EXPIRED:
+			if s.RemoveIntercept(id) {
+				dlog.Infof(ctx, "Removed expired intercept %q of %s", cept.Spec.Name, cept.Spec.Client)
+			}
+		case cept.Expires != nil && !moment.Before(cept.Expires.AsTime()):
+			s.UpdateIntercept(id, func(cept *rpc.InterceptInfo) {
+				cept.Disposition = rpc.InterceptDispositionType_EXPIRED
+				cept.Message = fmt.Sprintf("intercept expired at %s", cept.Expires.AsTime().Format(time.RFC3339))
+			})
+		}
+	}
+}
+
 func (s *State) RemoveIntercept(interceptID string) bool {
 	_, didDelete := s.intercepts.LoadAndDelete(interceptID)
 	if didDelete {
@@ -702,7 +833,7 @@ func (s *State) ClientTunnel(ctx context.Context, muxTunnel connpool.MuxTunnel)
 	s.mu.Unlock()
 	cs, ok := ss.(*clientSessionState)
 	if !ok {
-		return status.Errorf(codes.NotFound, "Client session %q not found", sessionID)
+		return UnknownSessionError(sessionID)
 	}
 	dlog.Debug(ctx, "Established TCP tunnel")
 	pool := cs.pool // must have one pool per client
@@ -758,7 +889,7 @@ func (s *State) Tunnel(ctx context.Context, stream tunnel.Stream) error {
 	ss, ok := s.sessions[sessionID]
 	s.mu.Unlock()
 	if !ok {
-		return status.Errorf(codes.NotFound, "Session %q not found", sessionID)
+		return UnknownSessionError(sessionID)
 	}
 
 	bidiPipe, err := ss.OnConnect(ctx, stream)
@@ -1052,14 +1183,33 @@ func (s *State) SetTempLogLevel(ctx context.Context, logLevelRequest *rpc.LogLev
 	if gd := logLevelRequest.Duration; gd != nil {
 		duration = gd.AsDuration()
 	}
+	s.mu.Lock()
+	s.allAgentsLogLevel = true
+	s.mu.Unlock()
 	s.timedLogLevel.Set(ctx, logLevelRequest.LogLevel, duration)
 	s.logLevelCond.Broadcast()
 }
 
+// SetManagerLogLevel sets the temporary log-level for the traffic-manager only and, if a duration
+// is given, it also starts a timer that will reset the log-level once it fires. Agents that
+// already got a log-level from SetTempLogLevel keep it until it expires.
+func (s *State) SetManagerLogLevel(ctx context.Context, level string, duration time.Duration) {
+	s.mu.Lock()
+	s.allAgentsLogLevel = false
+	s.mu.Unlock()
+	s.timedLogLevel.Set(ctx, level, duration)
+}
+
 // InitialTempLogLevel returns the temporary log-level if it exists, along with the remaining
 // duration for it, which might be zero, in which case the log-level is valid until a new
 // level is requested.
 func (s *State) InitialTempLogLevel() *rpc.LogLevelRequest {
+	s.mu.Lock()
+	allAgents := s.allAgentsLogLevel
+	s.mu.Unlock()
+	if !allAgents {
+		return nil
+	}
 	level, duration := s.timedLogLevel.Get()
 	if level == "" {
 		return nil
@@ -1078,3 +1228,139 @@ func (s *State) WaitForTempLogLevel() *rpc.LogLevelRequest {
 	s.logLevelCond.Wait()
 	return s.InitialTempLogLevel()
 }
+
+// RemoteLogLevels returns the temporary log-levels of the traffic-manager and of the agents that
+// have a log-level of their own.
+func (s *State) RemoteLogLevels() *rpc.RemoteLogLevels {
+	rl := &rpc.RemoteLogLevels{}
+	if level, duration := s.timedLogLevel.Get(); level != "" {
+		rl.TrafficManager = &rpc.RemoteLogLevel{LogLevel: level}
+		if duration > 0 {
+			rl.TrafficManager.Expires = timestamppb.New(time.Now().Add(duration))
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rl.AllAgents = rl.TrafficManager != nil && s.allAgentsLogLevel
+	for _, sess := range s.sessions {
+		if as, ok := sess.(*agentSessionState); ok && as.logLevel != nil {
+			rl.Agents = append(rl.Agents, newAgentLogLevel(as))
+		}
+	}
+	sort.Slice(rl.Agents, func(i, j int) bool {
+		a, b := rl.Agents[i], rl.Agents[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.PodIp < b.PodIp
+	})
+	return rl
+}
+
+func newAgentLogLevel(as *agentSessionState) *rpc.AgentLogLevel {
+	return &rpc.AgentLogLevel{
+		Name:      as.agent.Name,
+		Namespace: as.agent.Namespace,
+		PodIp:     as.agent.PodIp,
+		Version:   as.agent.Version,
+		LogLevel:  as.logLevel,
+	}
+}
+
+// SetAgentLogLevel sets the temporary log-level of the agent with the given session and, if a
+// duration is given, it also starts a timer that will reset the log-level once it fires. The
+// traffic-manager owns the timer, so the log-level is reset also when the caller is gone. The
+// returned AgentLogLevel has an error when the agent is too old to receive its own log-level.
+func (s *State) SetAgentLogLevel(agentSessionID, level string, duration time.Duration) *rpc.AgentLogLevel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	as, ok := s.sessions[agentSessionID].(*agentSessionState)
+	if !ok {
+		return nil
+	}
+	if as.logLevelChanged == nil {
+		al := newAgentLogLevel(as)
+		al.Error = fmt.Sprintf("traffic-agent %s is too old to get a log-level of its own", as.agent.Version)
+		return al
+	}
+	if as.logLevelTimer != nil {
+		as.logLevelTimer.Stop()
+		as.logLevelTimer = nil
+	}
+	if level == "" {
+		as.logLevel = nil
+	} else {
+		as.logLevel = &rpc.RemoteLogLevel{LogLevel: level}
+		if duration > 0 {
+			as.logLevel.Expires = timestamppb.New(time.Now().Add(duration))
+			var timer *time.Timer
+			timer = time.AfterFunc(duration, func() {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				// The timer of a session that was removed, or of a replaced log-level, has been stopped,
+				// but might fire anyway.
+				if as.logLevelTimer == timer && s.sessions[agentSessionID] == as {
+					as.logLevel = nil
+					as.logLevelTimer = nil
+					as.notifyLogLevel()
+				}
+			})
+			as.logLevelTimer = timer
+		}
+	}
+	as.notifyLogLevel()
+	return newAgentLogLevel(as)
+}
+
+// notifyLogLevel tells the watcher of the agent's log-level that it changed. It must be called with
+// the state's mutex locked.
+func (as *agentSessionState) notifyLogLevel() {
+	select {
+	case as.logLevelChanged <- struct{}{}:
+	default:
+		// The watcher has yet to get the previous change, and it gets the current log-level
+	}
+}
+
+// WatchAgentLogLevel returns a channel that receives a value when the log-level of the given agent
+// changes, or nil when there's no such agent. The channel is closed when the agent's session ends.
+// Agents that have called this function can get log-levels of their own.
+func (s *State) WatchAgentLogLevel(agentSessionID string) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	as, ok := s.sessions[agentSessionID].(*agentSessionState)
+	if !ok {
+		return nil
+	}
+	if as.logLevelChanged == nil {
+		as.logLevelChanged = make(chan struct{}, 1)
+	}
+	if as.logLevel != nil {
+		as.notifyLogLevel()
+	}
+	return as.logLevelChanged
+}
+
+// AgentLogLevel returns the log-level that the agent with the given session should use. An empty
+// log-level means that the agent should use its configured log-level.
+func (s *State) AgentLogLevel(agentSessionID string) *rpc.LogLevelRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	as, ok := s.sessions[agentSessionID].(*agentSessionState)
+	if !ok || as.logLevel == nil {
+		return &rpc.LogLevelRequest{}
+	}
+	ll := &rpc.LogLevelRequest{LogLevel: as.logLevel.LogLevel}
+	if ex := as.logLevel.Expires; ex != nil {
+		remain := time.Until(ex.AsTime())
+		if remain <= 0 {
+			return &rpc.LogLevelRequest{}
+		}
+		// The agent resets the log-level itself should it lose the connection to the traffic-manager
+		ll.Duration = durationpb.New(remain)
+	}
+	return ll
+}
@@ -5,6 +5,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
 	manager "github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/state"
 	testdata "github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/test"
 )
@@ -123,3 +130,165 @@ func TestStateInternal(topT *testing.T) {
 		a.False(state.Mark(c3, clock.Now()))
 	})
 }
+
+func TestState_AddIntercept_conflict(t *testing.T) {
+	ctx := context.Background()
+	testAgents := testdata.GetTestAgents(t)
+	testClients := testdata.GetTestClients(t)
+
+	spec := func(name, dev string) *rpc.InterceptSpec {
+		spec := &rpc.InterceptSpec{
+			Name:       name,
+			Agent:      "hello",
+			Namespace:  "default",
+			Mechanism:  "tcp",
+			TargetHost: "127.0.0.1",
+			TargetPort: 8080,
+		}
+		if dev != "" {
+			spec.HttpHeaders = []*rpc.HTTPHeaderMatch{{Name: "x-dev", ValueRegex: dev}}
+		}
+		return spec
+	}
+	tests := []struct {
+		name     string
+		first    string // the x-dev header value of the first intercept, or empty for all traffic
+		second   string
+		conflict bool
+	}{
+		{"all traffic and all traffic", "", "", true},
+		{"all traffic and header-matched", "", "bob", true},
+		{"header-matched and all traffic", "alice", "", true},
+		{"same header values", "alice", "alice", true},
+		{"different header values", "alice", "bob", false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			state := manager.NewState(ctx)
+			state.AddAgent(testAgents["hello"], now)
+			alice := state.AddClient(testClients["alice"], now)
+			bob := state.AddClient(testClients["bob"], now)
+
+			aliceSpec := spec("alice-hello", tt.first)
+			aliceSpec.Client = testClients["alice"].Name
+			first, err := state.AddIntercept(alice, "", aliceSpec, now)
+			require.NoError(t, err)
+			require.NotNil(t, first.Created)
+
+			bobSpec := spec("bob-hello", tt.second)
+			bobSpec.Client = testClients["bob"].Name
+			_, err = state.AddIntercept(bob, "", bobSpec, now)
+			if !tt.conflict {
+				require.NoError(t, err)
+				assert.Empty(t, state.RemoveConflictingIntercepts(bob, bobSpec))
+				return
+			}
+			require.Error(t, err)
+			st := status.Convert(err)
+			assert.Equal(t, codes.FailedPrecondition, st.Code())
+			assert.Contains(t, st.Message(), `workload hello.default is already intercepted by intercept "alice-hello" of alice@squirtle.bigcorp.com`)
+			require.Len(t, st.Details(), 1)
+			conflict, ok := st.Details()[0].(*rpc.InterceptConflict)
+			require.True(t, ok)
+			assert.Equal(t, "alice-hello", conflict.Name)
+			assert.Equal(t, "alice@squirtle.bigcorp.com", conflict.Client)
+			assert.True(t, proto.Equal(first.Created, conflict.Created))
+			assert.Equal(t, len(aliceSpec.HttpHeaders), len(conflict.HttpHeaders))
+
+			// Stealing removes the conflicting intercept
+			removed := state.RemoveConflictingIntercepts(bob, bobSpec)
+			require.Len(t, removed, 1)
+			assert.Equal(t, first.Id, removed[0].Id)
+			_, err = state.AddIntercept(bob, "", bobSpec, now)
+			require.NoError(t, err)
+			_, ok = state.GetIntercept(first.Id)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestState_ExpireIntercepts(t *testing.T) {
+	ctx := context.Background()
+	clock := &FakeClock{}
+	state := manager.NewState(ctx)
+	state.AddAgent(testdata.GetTestAgents(t)["hello"], clock.Now())
+	alice := state.AddClient(testdata.GetTestClients(t)["alice"], clock.Now())
+
+	spec := func(name string, ttl time.Duration) *rpc.InterceptSpec {
+		return &rpc.InterceptSpec{
+			Name:        name,
+			Agent:       "hello",
+			Namespace:   "default",
+			Mechanism:   "tcp",
+			TargetHost:  "127.0.0.1",
+			TargetPort:  8080,
+			HttpHeaders: []*rpc.HTTPHeaderMatch{{Name: "x-dev", ValueRegex: name}},
+			Ttl:         int64(ttl),
+		}
+	}
+	forever, err := state.AddIntercept(alice, "", spec("forever", 0), clock.Now())
+	require.NoError(t, err)
+	assert.Nil(t, forever.Expires)
+	short, err := state.AddIntercept(alice, "", spec("short", 10*time.Second), clock.Now())
+	require.NoError(t, err)
+	require.NotNil(t, short.Expires)
+	assert.Equal(t, clock.Now().Add(10*time.Second), short.Expires.AsTime())
+	extended, err := state.AddIntercept(alice, "", spec("extended", 10*time.Second), clock.Now())
+	require.NoError(t, err)
+
+	disposition := func(id string) rpc.InterceptDispositionType {
+		cept, ok := state.GetIntercept(id)
+		require.True(t, ok, "intercept %s exists", id)
+		return cept.Disposition
+	}
+
+	clock.When = 5
+	state.ExpireIntercepts(ctx, clock.Now())
+	assert.Equal(t, rpc.InterceptDispositionType_WAITING, disposition(short.Id))
+	ii := state.ExtendIntercept(extended.Id, clock.Now().Add(time.Hour))
+	require.NotNil(t, ii)
+	assert.Equal(t, clock.Now().Add(time.Hour), ii.Expires.AsTime())
+
+	// An expired intercept is first marked as such, so that its client learns why it ends
+	clock.When = 10
+	state.ExpireIntercepts(ctx, clock.Now())
+	assert.Equal(t, rpc.InterceptDispositionType_EXPIRED, disposition(short.Id))
+	cept, _ := state.GetIntercept(short.Id)
+	assert.Equal(t, "intercept expired at 2000-01-01T00:00:10Z", cept.Message)
+	assert.Equal(t, rpc.InterceptDispositionType_WAITING, disposition(extended.Id))
+	assert.Equal(t, rpc.InterceptDispositionType_WAITING, disposition(forever.Id))
+
+	// An expired intercept cannot be extended
+	ii = state.ExtendIntercept(short.Id, clock.Now().Add(time.Hour))
+	require.NotNil(t, ii)
+	assert.Equal(t, rpc.InterceptDispositionType_EXPIRED, ii.Disposition)
+
+	clock.When = 15
+	state.ExpireIntercepts(ctx, clock.Now())
+	_, ok := state.GetIntercept(short.Id)
+	assert.False(t, ok)
+	assert.Equal(t, rpc.InterceptDispositionType_WAITING, disposition(extended.Id))
+	assert.Nil(t, state.ExtendIntercept(short.Id, clock.Now()))
+}
+
+func TestState_SessionDone_unknown(t *testing.T) {
+	ctx := context.Background()
+	clock := &FakeClock{}
+	state := manager.NewState(ctx)
+	alice := state.AddClient(testdata.GetTestClients(t)["alice"], clock.Now())
+	_, err := state.SessionDone(alice)
+	require.NoError(t, err)
+
+	// A session that another replica, or the manager before a restart, handed out
+	state.RemoveSession(ctx, alice)
+	_, err = state.SessionDone(alice)
+	require.Error(t, err)
+	st := status.Convert(err)
+	assert.Equal(t, codes.NotFound, st.Code())
+	require.Len(t, st.Details(), 1)
+	unknown, ok := st.Details()[0].(*rpc.UnknownSession)
+	require.True(t, ok)
+	assert.Equal(t, alice, unknown.SessionId)
+}
@@ -0,0 +1,165 @@
+package manager
+
+import (
+	"context"
+	"io"
+	"sort"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// logChunkSize is the maximum number of bytes of log data in a LogChunk.
+const logChunkSize = 32 * 1024
+
+// StreamLogs streams the logs of the traffic-manager, or of the traffic-agents of a workload. The
+// caller must have a client session, and the logs of the traffic-agents of a workload are only streamed
+// when the client could intercept it, i.e. when its namespace is managed and it has an agent.
+func (m *Manager) StreamLogs(request *rpc.StreamLogsRequest, stream rpc.Manager_StreamLogsServer) error {
+	ctx := managerutil.WithSessionInfo(stream.Context(), request.GetSession())
+	sessionID := request.GetSession().GetSessionId()
+	dlog.Debugf(ctx, "StreamLogs called %s.%s", request.AgentName, request.Namespace)
+
+	if m.state.GetClient(sessionID) == nil {
+		return status.Errorf(codes.NotFound, "Client session %q not found", sessionID)
+	}
+
+	var pods []*corev1.Pod
+	var container string
+	var err error
+	if request.AgentName == "" {
+		container = install.ManagerAppName
+		if pods, err = m.clusterInfo.GetTrafficManagerPods(ctx); err != nil {
+			return status.Errorf(codes.Internal, "unable to get the traffic-manager pods: %v", err)
+		}
+	} else {
+		if err = checkNamespace(ctx, request.Namespace); err != nil {
+			return err
+		}
+		container = install.AgentContainerName
+		if pods, err = m.agentPods(ctx, request.AgentName, request.Namespace); err != nil {
+			return err
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+
+	opts := corev1.PodLogOptions{Container: container}
+	if request.TailLines > 0 {
+		opts.TailLines = &request.TailLines
+	}
+	if since := request.Since.AsDuration(); since > 0 {
+		sinceSeconds := int64(since.Seconds())
+		opts.SinceSeconds = &sinceSeconds
+	}
+	for _, pod := range pods {
+		if err = streamPodLogs(ctx, stream, pod, opts, request.GetPodYaml); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// agentPods returns the pods of the agents of the given workload. An error with code NotFound is returned
+// when the workload has no agents.
+func (m *Manager) agentPods(ctx context.Context, name, namespace string) ([]*corev1.Pod, error) {
+	agents := m.state.GetAgentsByName(name, namespace)
+	if len(agents) == 0 {
+		return nil, status.Errorf(codes.NotFound, "no traffic-agent found for %s.%s", name, namespace)
+	}
+	podIPs := make(map[string]struct{}, len(agents))
+	for _, agent := range agents {
+		podIPs[agent.PodIp] = struct{}{}
+	}
+	podList, err := managerutil.GetK8sClientset(ctx).CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to get the pods of %s.%s: %v", name, namespace, err)
+	}
+	var pods []*corev1.Pod
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if _, ok := podIPs[pod.Status.PodIP]; !ok {
+			continue
+		}
+		for _, c := range pod.Spec.Containers {
+			if c.Name == install.AgentContainerName {
+				pods = append(pods, pod)
+				break
+			}
+		}
+	}
+	return pods, nil
+}
+
+// streamPodLogs sends the log of the container of the given options of the given pod, followed by the
+// log of its previous instance when it has restarted. A log that can't be read is reported in the error
+// of a chunk, so that it doesn't prevent the other logs from being streamed.
+func streamPodLogs(ctx context.Context, stream rpc.Manager_StreamLogsServer, pod *corev1.Pod, opts corev1.PodLogOptions, withYaml bool) error {
+	first := &rpc.LogChunk{PodName: pod.Name, Namespace: pod.Namespace, Container: opts.Container}
+	if withYaml {
+		podYaml, err := yaml.Marshal(pod)
+		if err != nil {
+			first.PodYaml = "Failed marshaling pod yaml: " + err.Error()
+		} else {
+			first.PodYaml = string(podYaml)
+		}
+	}
+	if err := streamContainerLog(ctx, stream, pod, opts, first); err != nil {
+		return err
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name == opts.Container && cs.RestartCount > 0 {
+			opts.Previous = true
+			return streamContainerLog(ctx, stream, pod, opts, nil)
+		}
+	}
+	return nil
+}
+
+// streamContainerLog sends the log of one container in chunks. The first chunk is based on the given
+// chunk, unless it's nil. An error is only returned when a chunk can't be sent.
+func streamContainerLog(ctx context.Context, stream rpc.Manager_StreamLogsServer, pod *corev1.Pod, opts corev1.PodLogOptions, first *rpc.LogChunk) error {
+	newChunk := func() *rpc.LogChunk {
+		if c := first; c != nil {
+			first = nil
+			return c
+		}
+		return &rpc.LogChunk{PodName: pod.Name, Namespace: pod.Namespace, Container: opts.Container}
+	}
+	rc, err := managerutil.GetK8sClientset(ctx).CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &opts).Stream(ctx)
+	if err != nil {
+		chunk := newChunk()
+		chunk.Previous = opts.Previous
+		chunk.Error = err.Error()
+		return stream.Send(chunk)
+	}
+	defer rc.Close()
+
+	// At least one chunk is sent, so that an empty log is still reported
+	buf := make([]byte, logChunkSize)
+	for sent := false; ; sent = true {
+		n, err := io.ReadFull(rc, buf)
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if n > 0 || !sent || err != nil && !eof {
+			chunk := newChunk()
+			chunk.Previous = opts.Previous
+			chunk.Data = buf[:n]
+			if err != nil && !eof {
+				chunk.Error = err.Error()
+			}
+			if err := stream.Send(chunk); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
@@ -19,8 +19,10 @@ import (
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/rpc/v2/systema"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/mutator"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/replacer"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/watchable"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 	"github.com/telepresenceio/telepresence/v2/pkg/version"
 )
 
@@ -32,6 +34,9 @@ func Main(ctx context.Context, args ...string) error {
 	if err != nil {
 		return fmt.Errorf("failed to LoadEnv: %w", err)
 	}
+	if managerutil.GetEnv(ctx).TracingEnabled {
+		defer tracing.Start(ctx, "traffic-manager", tracing.DefaultBufferSize)(ctx)
+	}
 
 	cfg, err := rest.InClusterConfig()
 	if err != nil {
@@ -61,6 +66,13 @@ func Main(ctx context.Context, args ...string) error {
 	// exist.
 	g.Go("systema-gc", mgr.runSystemAGCLoop)
 
+	// This goroutine idles the application containers of workloads intercepted with --replace and
+	// restores them when the intercepts end.
+	g.Go("replacer", func(ctx context.Context) error {
+		env := managerutil.GetEnv(ctx)
+		return replacer.Run(ctx, mgr.state, replacer.NewK8sWorkloads(clientset, env.AgentRegistry+"/"+env.AgentImage, env.ManagedNamespaceList()))
+	})
+
 	// Wait for exit
 	return g.Wait()
 }
@@ -69,7 +81,7 @@ func (m *Manager) serveHTTP(ctx context.Context) error {
 	env := managerutil.GetEnv(ctx)
 	host := env.ServerHost
 	port := env.ServerPort
-	opts := []grpc.ServerOption{}
+	opts := tracing.ServerOptions()
 	if mz, ok := env.MaxReceiveSize.AsInt64(); ok {
 		opts = append(opts, grpc.MaxRecvMsgSize(int(mz)))
 	}
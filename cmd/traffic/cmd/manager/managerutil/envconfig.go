@@ -2,11 +2,15 @@ package managerutil
 
 import (
 	"context"
+	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/sethvargo/go-envconfig"
 	"k8s.io/apimachinery/pkg/api/resource"
 
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
 	"github.com/telepresenceio/telepresence/v2/pkg/version"
 )
 
@@ -20,11 +24,35 @@ type Env struct {
 	ManagerNamespace string            `env:"MANAGER_NAMESPACE,default="`
 	AgentRegistry    string            `env:"TELEPRESENCE_REGISTRY,default=docker.io/datawire"`
 	AgentImage       string            `env:"TELEPRESENCE_AGENT_IMAGE,default="`
+	AgentPullSecrets string            `env:"TELEPRESENCE_AGENT_IMAGE_PULL_SECRETS,default="`
 	AgentPort        int32             `env:"TELEPRESENCE_AGENT_PORT,default=9900"`
 	MaxReceiveSize   resource.Quantity `env:"TELEPRESENCE_MAX_RECEIVE_SIZE,default=4Mi"`
 
+	// AgentResources and AgentSecurityContext are the JSON of the default resources and security context
+	// settings of the traffic-agents that the agent-injector injects. See install.AgentConfig.
+	AgentResources       string `env:"TELEPRESENCE_AGENT_RESOURCES,default="`
+	AgentSecurityContext string `env:"TELEPRESENCE_AGENT_SECURITY_CONTEXT,default="`
+
+	// AgentAppProtocol is the appProtocol that a service port declares when the client changes its target
+	// port to the port of the traffic-agent.
+	AgentAppProtocol string `env:"TELEPRESENCE_AGENT_APP_PROTOCOL,default="`
+
+	// AgentIgnorePorts is a comma separated list of the numbers of the container ports that the injected
+	// traffic-agents never take over.
+	AgentIgnorePorts string `env:"TELEPRESENCE_AGENT_IGNORE_PORTS,default="`
+
+	// ManagedNamespaces is a space separated list of the namespaces that the traffic-manager is limited to
+	// when its RBAC is namespace-scoped. An empty list means that all namespaces are managed.
+	ManagedNamespaces string `env:"MANAGED_NAMESPACES,default="`
+
 	PodCIDRStrategy string `env:"POD_CIDR_STRATEGY,default=auto"`
 	PodCIDRs        string `env:"POD_CIDRS,default="`
+
+	TracingEnabled bool `env:"TELEPRESENCE_TRACING_ENABLED,default=false"`
+
+	// TCPKeepAlive is the keep-alive period of the TCP connections that the traffic-manager dials for the
+	// tunnel. A negative value disables keep-alives.
+	TCPKeepAlive time.Duration `env:"TELEPRESENCE_TCP_KEEPALIVE,default=15s"`
 }
 
 type envKey struct{}
@@ -37,9 +65,70 @@ func LoadEnv(ctx context.Context) (context.Context, error) {
 	if env.AgentImage == "" {
 		env.AgentImage = "tel2:" + strings.TrimPrefix(version.Version, "v")
 	}
+	if _, err := env.AgentConfig(); err != nil {
+		return ctx, err
+	}
 	return WithEnv(ctx, &env), nil
 }
 
+// AgentConfig returns the default configuration of the traffic-agents that the agent-injector injects.
+func (e *Env) AgentConfig() (*install.AgentConfig, error) {
+	cfg := &install.AgentConfig{}
+	if e.AgentResources != "" {
+		rr, err := install.ParseAgentResources(e.AgentResources)
+		if err != nil {
+			return nil, fmt.Errorf("TELEPRESENCE_AGENT_RESOURCES: %w", err)
+		}
+		cfg.Resources = rr
+	}
+	if e.AgentSecurityContext != "" {
+		sc, err := install.ParseAgentSecurityContext(e.AgentSecurityContext)
+		if err != nil {
+			return nil, fmt.Errorf("TELEPRESENCE_AGENT_SECURITY_CONTEXT: %w", err)
+		}
+		cfg.SecurityContext = *sc
+	}
+	if e.AgentAppProtocol != "" {
+		if err := install.ValidateAppProtocol(e.AgentAppProtocol); err != nil {
+			return nil, fmt.Errorf("TELEPRESENCE_AGENT_APP_PROTOCOL: %w", err)
+		}
+		cfg.AppProtocol = e.AgentAppProtocol
+	}
+	if e.AgentIgnorePorts != "" {
+		ports, err := install.ParseIgnorePorts(e.AgentIgnorePorts)
+		if err != nil {
+			return nil, fmt.Errorf("TELEPRESENCE_AGENT_IGNORE_PORTS: %w", err)
+		}
+		cfg.IgnorePorts = ports
+	}
+	return cfg, nil
+}
+
+// ManagedNamespaceList returns the sorted list of namespaces that the traffic-manager is limited to, or nil
+// when it manages all namespaces.
+func (e *Env) ManagedNamespaceList() []string {
+	nss := strings.Fields(e.ManagedNamespaces)
+	if len(nss) == 0 {
+		return nil
+	}
+	sort.Strings(nss)
+	return nss
+}
+
+// IsManaged returns true if the traffic-manager manages the given namespace.
+func (e *Env) IsManaged(namespace string) bool {
+	nss := strings.Fields(e.ManagedNamespaces)
+	if len(nss) == 0 {
+		return true
+	}
+	for _, ns := range nss {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
 func WithEnv(ctx context.Context, env *Env) context.Context {
 	return context.WithValue(ctx, envKey{}, env)
 }
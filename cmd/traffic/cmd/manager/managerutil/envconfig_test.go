@@ -5,6 +5,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -39,6 +40,7 @@ func TestEnvconfig(t *testing.T) {
 		AgentPort:       9900,
 		MaxReceiveSize:  resource.MustParse("4Mi"),
 		PodCIDRStrategy: "auto",
+		TCPKeepAlive:    15 * time.Second,
 	}
 
 	testcases := map[string]struct {
@@ -57,6 +59,36 @@ func TestEnvconfig(t *testing.T) {
 				e.SystemAHost = "app.getambassador.io"
 			},
 		},
+		"agent config": {
+			Input: map[string]string{
+				"TELEPRESENCE_AGENT_RESOURCES":        `{"limits":{"memory":"128Mi"}}`,
+				"TELEPRESENCE_AGENT_SECURITY_CONTEXT": `{"readOnlyRootFilesystem":true}`,
+				"TELEPRESENCE_AGENT_APP_PROTOCOL":     "kubernetes.io/h2c",
+				"TELEPRESENCE_AGENT_IGNORE_PORTS":     "9090,15020",
+			},
+			Output: func(e *managerutil.Env) {
+				e.AgentResources = `{"limits":{"memory":"128Mi"}}`
+				e.AgentSecurityContext = `{"readOnlyRootFilesystem":true}`
+				e.AgentAppProtocol = "kubernetes.io/h2c"
+				e.AgentIgnorePorts = "9090,15020"
+			},
+		},
+		"managed namespaces": {
+			Input: map[string]string{
+				"MANAGED_NAMESPACES": "blue red",
+			},
+			Output: func(e *managerutil.Env) {
+				e.ManagedNamespaces = "blue red"
+			},
+		},
+		"tcp keepalive": {
+			Input: map[string]string{
+				"TELEPRESENCE_TCP_KEEPALIVE": "-1s",
+			},
+			Output: func(e *managerutil.Env) {
+				e.TCPKeepAlive = -time.Second
+			},
+		},
 	}
 
 	for tcName, tc := range testcases {
@@ -82,3 +114,36 @@ func TestEnvconfig(t *testing.T) {
 		})
 	}
 }
+
+func TestEnvconfig_invalidAgentConfig(t *testing.T) {
+	t.Setenv("TELEPRESENCE_AGENT_RESOURCES", `{"limits":{"memory":"128MB"}}`)
+	_, err := managerutil.LoadEnv(context.Background())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `TELEPRESENCE_AGENT_RESOURCES: limits.memory: "128MB" is not a valid quantity`)
+}
+
+func TestEnvconfig_invalidAgentAppProtocol(t *testing.T) {
+	t.Setenv("TELEPRESENCE_AGENT_APP_PROTOCOL", "not valid")
+	_, err := managerutil.LoadEnv(context.Background())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `TELEPRESENCE_AGENT_APP_PROTOCOL: "not valid" is not a valid appProtocol`)
+}
+
+func TestEnv_IsManaged(t *testing.T) {
+	all := managerutil.Env{}
+	assert.Nil(t, all.ManagedNamespaceList())
+	assert.True(t, all.IsManaged("default"))
+
+	some := managerutil.Env{ManagedNamespaces: " red  blue "}
+	assert.Equal(t, []string{"blue", "red"}, some.ManagedNamespaceList())
+	assert.True(t, some.IsManaged("red"))
+	assert.True(t, some.IsManaged("blue"))
+	assert.False(t, some.IsManaged("default"))
+}
+
+func TestEnvconfig_invalidAgentIgnorePorts(t *testing.T) {
+	t.Setenv("TELEPRESENCE_AGENT_IGNORE_PORTS", "9090 15020")
+	_, err := managerutil.LoadEnv(context.Background())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `TELEPRESENCE_AGENT_IGNORE_PORTS: "9090 15020" is not a valid port number`)
+}
@@ -8,11 +8,13 @@ import (
 	"net"
 	"os"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	empty "google.golang.org/protobuf/types/known/emptypb"
@@ -20,6 +22,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 
 	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/common"
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/rpc/v2/systema"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/cluster"
@@ -27,6 +30,7 @@ import (
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/connpool"
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
 	"github.com/telepresenceio/telepresence/v2/pkg/version"
 )
@@ -125,6 +129,30 @@ func (m *Manager) GetCloudConfig(ctx context.Context, _ *empty.Empty) (*rpc.Amba
 	return &rpc.AmbassadorCloudConfig{Host: env.SystemAHost, Port: env.SystemAPort}, nil
 }
 
+// GetAgentImage returns the image that the Manager uses for the traffic-agents that it injects.
+func (m *Manager) GetAgentImage(ctx context.Context, _ *empty.Empty) (*rpc.AgentImage, error) {
+	env := managerutil.GetEnv(ctx)
+	return &rpc.AgentImage{
+		Name:        env.AgentRegistry + "/" + env.AgentImage,
+		PullSecrets: strings.Fields(env.AgentPullSecrets),
+	}, nil
+}
+
+// GetAgentConfig returns the default configuration of the traffic-agents that the Manager injects.
+func (m *Manager) GetAgentConfig(ctx context.Context, _ *empty.Empty) (*rpc.AgentConfig, error) {
+	env := managerutil.GetEnv(ctx)
+	ac, err := env.AgentConfig()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &rpc.AgentConfig{
+		Resources:       env.AgentResources,
+		SecurityContext: env.AgentSecurityContext,
+		AppProtocol:     ac.AppProtocol,
+		IgnorePorts:     ac.IgnorePorts,
+	}, nil
+}
+
 // ArriveAsClient establishes a session between a client and the Manager.
 func (m *Manager) ArriveAsClient(ctx context.Context, client *rpc.ClientInfo) (*rpc.SessionInfo, error) {
 	dlog.Debug(ctx, "ArriveAsClient called")
@@ -147,6 +175,9 @@ func (m *Manager) ArriveAsAgent(ctx context.Context, agent *rpc.AgentInfo) (*rpc
 	if val := validateAgent(agent); val != "" {
 		return nil, status.Errorf(codes.InvalidArgument, val)
 	}
+	if err := checkNamespace(ctx, agent.Namespace); err != nil {
+		return nil, err
+	}
 
 	sessionID := m.state.AddAgent(agent, m.clock.Now())
 
@@ -159,7 +190,7 @@ func (m *Manager) Remain(_ context.Context, req *rpc.RemainRequest) (*empty.Empt
 	// dlog.Debug(ctx, "Remain called")
 
 	if ok := m.state.MarkSession(req, m.clock.Now()); !ok {
-		return nil, status.Errorf(codes.NotFound, "Session %q not found", req.GetSession().GetSessionId())
+		return nil, state.UnknownSessionError(req.GetSession().GetSessionId())
 	}
 
 	return &empty.Empty{}, nil
@@ -175,6 +206,13 @@ func (m *Manager) Depart(ctx context.Context, session *rpc.SessionInfo) (*empty.
 	return &empty.Empty{}, nil
 }
 
+// GetClients returns the clients that currently have a session with the manager.
+func (m *Manager) GetClients(ctx context.Context, _ *empty.Empty) (*rpc.ClientInfoSnapshot, error) {
+	dlog.Debug(ctx, "GetClients called")
+
+	return &rpc.ClientInfoSnapshot{Clients: m.state.GetAllClients()}, nil
+}
+
 // WatchAgents notifies a client of the set of known Agents.
 func (m *Manager) WatchAgents(session *rpc.SessionInfo, stream rpc.Manager_WatchAgentsServer) error {
 	ctx := managerutil.WithSessionInfo(stream.Context(), session)
@@ -306,14 +344,37 @@ func (m *Manager) CreateIntercept(ctx context.Context, ciReq *rpc.CreateIntercep
 	dlog.Debug(ctx, "CreateIntercept called")
 
 	if m.state.GetClient(sessionID) == nil {
-		return nil, status.Errorf(codes.NotFound, "Client session %q not found", sessionID)
+		return nil, state.UnknownSessionError(sessionID)
 	}
 
 	if val := validateIntercept(spec); val != "" {
 		return nil, status.Errorf(codes.InvalidArgument, val)
 	}
+	if err := checkNamespace(ctx, spec.Namespace); err != nil {
+		return nil, err
+	}
+	if spec.AgentTlsSecret != "" {
+		// Fail early, rather than leaving it to the agents to find that they can't terminate the TLS
+		if _, err := loadAgentTLSCertificate(ctx, spec.Namespace, spec.AgentTlsSecret); err != nil {
+			return nil, err
+		}
+	}
+	if ciReq.Steal {
+		for _, ii := range m.state.RemoveConflictingIntercepts(sessionID, spec) {
+			dlog.Infof(ctx, "Intercept %q of %s removed to make room for intercept %q of %s", ii.Spec.Name, ii.Spec.Client, spec.Name, spec.Client)
+		}
+	}
 
-	return m.state.AddIntercept(sessionID, apiKey, spec)
+	return m.state.AddIntercept(sessionID, apiKey, spec, m.clock.Now())
+}
+
+// checkNamespace returns a PermissionDenied error unless the given namespace is managed by this
+// traffic-manager.
+func checkNamespace(ctx context.Context, namespace string) error {
+	if env := managerutil.GetEnv(ctx); env != nil && !env.IsManaged(namespace) {
+		return status.Errorf(codes.PermissionDenied, "namespace %s is not managed by this traffic-manager", namespace)
+	}
+	return nil
 }
 
 func (m *Manager) makeinterceptID(ctx context.Context, sessionID string, name string) (string, error) {
@@ -328,7 +389,7 @@ func (m *Manager) makeinterceptID(ctx context.Context, sessionID string, name st
 		return name, nil
 	} else {
 		if m.state.GetClient(sessionID) == nil {
-			return "", status.Errorf(codes.NotFound, "Client session %q not found", sessionID)
+			return "", state.UnknownSessionError(sessionID)
 		}
 		return sessionID + ":" + name, nil
 	}
@@ -343,6 +404,18 @@ func (m *Manager) UpdateIntercept(ctx context.Context, req *rpc.UpdateInterceptR
 
 	dlog.Debugf(ctx, "UpdateIntercept called: %s", interceptID)
 
+	if req.Ttl != 0 {
+		intercept := m.state.ExtendIntercept(interceptID, m.clock.Now().Add(time.Duration(req.Ttl)))
+		switch {
+		case intercept == nil:
+			return nil, status.Errorf(codes.NotFound, "Intercept with ID %q not found for this session", interceptID)
+		case intercept.Disposition == rpc.InterceptDispositionType_EXPIRED:
+			return nil, status.Errorf(codes.FailedPrecondition, "intercept %q has expired", req.GetName())
+		case req.PreviewDomainAction == nil:
+			return intercept, nil
+		}
+	}
+
 	switch action := req.PreviewDomainAction.(type) {
 	case *rpc.UpdateInterceptRequest_AddPreviewDomain:
 		var domain string
@@ -448,7 +521,7 @@ func (m *Manager) RemoveIntercept(ctx context.Context, riReq *rpc.RemoveIntercep
 	dlog.Debugf(ctx, "RemoveIntercept called: %s", name)
 
 	if m.state.GetClient(sessionID) == nil {
-		return nil, status.Errorf(codes.NotFound, "Client session %q not found", sessionID)
+		return nil, state.UnknownSessionError(sessionID)
 	}
 
 	if !m.state.RemoveIntercept(sessionID + ":" + name) {
@@ -567,6 +640,9 @@ func (m *Manager) Tunnel(server rpc.Manager_TunnelServer) error {
 	if err != nil {
 		return status.Errorf(codes.FailedPrecondition, "failed to connect stream: %v", err)
 	}
+	if env := managerutil.GetEnv(m.ctx); env != nil {
+		ctx = tunnel.WithTCPKeepAlive(ctx, env.TCPKeepAlive)
+	}
 	return m.state.Tunnel(ctx, stream)
 }
 
@@ -574,6 +650,9 @@ func (m *Manager) WatchDial(session *rpc.SessionInfo, stream rpc.Manager_WatchDi
 	ctx := managerutil.WithSessionInfo(stream.Context(), session)
 	dlog.Debugf(ctx, "WatchDial called")
 	lrCh := m.state.WatchDial(session.SessionId)
+	if lrCh == nil {
+		return state.UnknownSessionError(session.SessionId)
+	}
 	for {
 		select {
 		case <-m.ctx.Done():
@@ -749,11 +828,108 @@ func (m *Manager) GetLogs(ctx context.Context, request *rpc.GetLogsRequest) (*rp
 	return resp, nil
 }
 
+// GatherTraces returns the spans that the traffic-manager has recorded.
+func (m *Manager) GatherTraces(_ context.Context, _ *empty.Empty) (*common.Traces, error) {
+	return tracing.Traces(), nil
+}
+
 func (m *Manager) SetLogLevel(ctx context.Context, request *rpc.LogLevelRequest) (*empty.Empty, error) {
 	m.state.SetTempLogLevel(ctx, request)
 	return &empty.Empty{}, nil
 }
 
+// SetRemoteLogLevel sets the log-level of the traffic-manager, and of the traffic-agents of the requested
+// workloads that the client could intercept. A workload that has no such traffic-agents, and traffic-agents
+// that are too old to get a log-level of their own, are reported with an error.
+func (m *Manager) SetRemoteLogLevel(ctx context.Context, request *rpc.RemoteLogLevelRequest) (*rpc.RemoteLogLevels, error) {
+	ctx = managerutil.WithSessionInfo(ctx, request.GetSession())
+	sessionID := request.GetSession().GetSessionId()
+	dlog.Debugf(ctx, "SetRemoteLogLevel called %s %v", request.LogLevel, request.Agents)
+
+	if m.state.GetClient(sessionID) == nil {
+		return nil, state.UnknownSessionError(sessionID)
+	}
+	if _, err := logrus.ParseLevel(request.LogLevel); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if request.Namespace != "" {
+		if err := checkNamespace(ctx, request.Namespace); err != nil {
+			return nil, err
+		}
+	}
+	duration := request.Duration.AsDuration()
+	m.state.SetManagerLogLevel(ctx, request.LogLevel, duration)
+	result := &rpc.RemoteLogLevels{TrafficManager: m.state.RemoteLogLevels().TrafficManager}
+
+	agents := m.state.GetAllAgents()
+	for _, name := range request.Agents {
+		found := false
+		for agentSessionID, agent := range agents {
+			if agent.Name != name || request.Namespace != "" && agent.Namespace != request.Namespace || checkNamespace(ctx, agent.Namespace) != nil {
+				continue
+			}
+			if al := m.state.SetAgentLogLevel(agentSessionID, request.LogLevel, duration); al != nil {
+				found = true
+				result.Agents = append(result.Agents, al)
+			}
+		}
+		if !found {
+			result.Agents = append(result.Agents, &rpc.AgentLogLevel{
+				Name:      name,
+				Namespace: request.Namespace,
+				Error:     "no traffic-agent found",
+			})
+		}
+	}
+	sort.SliceStable(result.Agents, func(i, j int) bool {
+		a, b := result.Agents[i], result.Agents[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.PodIp < b.PodIp
+	})
+	return result, nil
+}
+
+// GetRemoteLogLevels returns the temporary log-levels of the traffic-manager and the traffic-agents.
+func (m *Manager) GetRemoteLogLevels(ctx context.Context, session *rpc.SessionInfo) (*rpc.RemoteLogLevels, error) {
+	ctx = managerutil.WithSessionInfo(ctx, session)
+	dlog.Debug(ctx, "GetRemoteLogLevels called")
+	if m.state.GetClient(session.GetSessionId()) == nil {
+		return nil, state.UnknownSessionError(session.GetSessionId())
+	}
+	return m.state.RemoteLogLevels(), nil
+}
+
+// WatchAgentLogLevel sends the log-levels that are set for the calling agent using SetRemoteLogLevel.
+func (m *Manager) WatchAgentLogLevel(session *rpc.SessionInfo, stream rpc.Manager_WatchAgentLogLevelServer) error {
+	ctx := managerutil.WithSessionInfo(stream.Context(), session)
+	dlog.Debugf(ctx, "WatchAgentLogLevel called")
+	llCh := m.state.WatchAgentLogLevel(session.GetSessionId())
+	if llCh == nil {
+		return status.Errorf(codes.NotFound, "Agent session %q not found", session.GetSessionId())
+	}
+	for {
+		select {
+		case <-m.ctx.Done():
+			return nil
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-llCh:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(m.state.AgentLogLevel(session.SessionId)); err != nil {
+				dlog.Errorf(ctx, "WatchAgentLogLevel.Send() failed: %v", err)
+				return nil
+			}
+		}
+	}
+}
+
 func (m *Manager) WatchLogLevel(_ *empty.Empty, stream rpc.Manager_WatchLogLevelServer) error {
 	ctx := stream.Context()
 	dlog.Debugf(ctx, "WatchLogLevel called")
@@ -779,7 +955,9 @@ func (m *Manager) WatchClusterInfo(session *rpc.SessionInfo, stream rpc.Manager_
 	return m.clusterInfo.Watch(ctx, stream)
 }
 
-// expire removes stale sessions.
+// expire removes stale sessions and expired intercepts.
 func (m *Manager) expire(ctx context.Context) {
-	m.state.ExpireSessions(ctx, m.clock.Now().Add(-30*time.Second))
+	now := m.clock.Now()
+	m.state.ExpireSessions(ctx, now.Add(-30*time.Second))
+	m.state.ExpireIntercepts(ctx, now)
 }
@@ -3,17 +3,24 @@ package manager_test
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	k8sVersion "k8s.io/apimachinery/pkg/version"
 	fakeDiscovery "k8s.io/client-go/discovery/fake"
 	"k8s.io/client-go/kubernetes/fake"
@@ -66,6 +73,11 @@ func TestConnect(t *testing.T) {
 	a.NoError(err)
 	t.Logf("aliceSess2: %v", aliceSess2)
 
+	clients, err := client.GetClients(ctx, &empty.Empty{})
+	a.NoError(err)
+	a.Len(clients.Clients, 1)
+	a.True(proto.Equal(testClients["alice"], clients.Clients[aliceSess2.SessionId]))
+
 	t.Log("WatchAgents(aliceSess2)...")
 	aliceWA, err := client.WatchAgents(ctx, aliceSess2)
 	a.NoError(err)
@@ -286,7 +298,283 @@ func TestConnect(t *testing.T) {
 	a.NoError(err)
 }
 
+func TestManagedNamespaces(t *testing.T) {
+	ctx := dlog.NewTestContext(t, true)
+	testClients := testdata.GetTestClients(t)
+	testAgents := testdata.GetTestAgents(t)
+
+	conn := getTestClientConnWithEnv(t, &managerutil.Env{
+		MaxReceiveSize:    resource.Quantity{},
+		PodCIDRStrategy:   "environment",
+		PodCIDRs:          "192.168.0.0/16",
+		ManagedNamespaces: "other blue",
+	})
+	defer conn.Close()
+	client := rpc.NewManagerClient(conn)
+
+	assertNotManaged := func(err error) {
+		t.Helper()
+		require.Error(t, err)
+		st, ok := status.FromError(err)
+		require.True(t, ok)
+		assert.Equal(t, codes.PermissionDenied, st.Code())
+		assert.Equal(t, "namespace default is not managed by this traffic-manager", st.Message())
+	}
+
+	aliceSess, err := client.ArriveAsClient(ctx, testClients["alice"])
+	require.NoError(t, err)
+
+	// The cluster info tells the client which namespaces are managed
+	infoStream, err := client.WatchClusterInfo(ctx, aliceSess)
+	require.NoError(t, err)
+	ci, err := infoStream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"blue", "other"}, ci.ManagedNamespaces)
+
+	// Agents in unmanaged namespaces are refused
+	_, err = client.ArriveAsAgent(ctx, testAgents["hello"])
+	assertNotManaged(err)
+
+	// Intercepts in unmanaged namespaces are refused
+	_, err = client.CreateIntercept(ctx, &rpc.CreateInterceptRequest{
+		Session: aliceSess,
+		InterceptSpec: &rpc.InterceptSpec{
+			Name:       "first",
+			Namespace:  "default",
+			Client:     testClients["alice"].Name,
+			Agent:      testAgents["hello"].Name,
+			Mechanism:  "tcp",
+			TargetHost: "asdf",
+			TargetPort: 9876,
+		},
+	})
+	assertNotManaged(err)
+
+	// Agents in managed namespaces arrive as usual
+	otherAgent := proto.Clone(testAgents["hello"]).(*rpc.AgentInfo)
+	otherAgent.Namespace = "other"
+	otherSess, err := client.ArriveAsAgent(ctx, otherAgent)
+	require.NoError(t, err)
+	_, err = client.Depart(ctx, otherSess)
+	require.NoError(t, err)
+	_, err = client.Depart(ctx, aliceSess)
+	require.NoError(t, err)
+}
+
+func TestStreamLogs(t *testing.T) {
+	ctx := dlog.NewTestContext(t, true)
+	testClients := testdata.GetTestClients(t)
+	testAgents := testdata.GetTestAgents(t)
+
+	pod := func(name, namespace, ip, container string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: container}}},
+			Status:     corev1.PodStatus{PodIP: ip},
+		}
+	}
+	conn := getTestClientConnWithEnv(t, &managerutil.Env{
+		MaxReceiveSize:    resource.Quantity{},
+		PodCIDRStrategy:   "environment",
+		PodCIDRs:          "192.168.0.0/16",
+		ManagedNamespaces: "default other",
+		ManagerNamespace:  "ambassador",
+	},
+		pod("traffic-manager-5c69859f94-g4ntj", "ambassador", "192.168.0.2", "traffic-manager"),
+		pod("hello-abcdef-123", "default", "192.168.1.5", "traffic-agent"),
+		pod("hello-abcdef-456", "default", "192.168.1.6", "traffic-agent"),
+		pod("unrelated-abcdef-789", "default", "192.168.1.7", "traffic-agent"),
+	)
+	defer conn.Close()
+	client := rpc.NewManagerClient(conn)
+
+	streamLogs := func(request *rpc.StreamLogsRequest) ([]*rpc.LogChunk, error) {
+		t.Helper()
+		stream, err := client.StreamLogs(ctx, request)
+		require.NoError(t, err)
+		var chunks []*rpc.LogChunk
+		for {
+			chunk, err := stream.Recv()
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				return chunks, err
+			}
+			chunks = append(chunks, chunk)
+		}
+	}
+	assertCode := func(code codes.Code, err error) {
+		t.Helper()
+		require.Error(t, err)
+		assert.Equal(t, code, status.Code(err), err.Error())
+	}
+
+	hello := proto.Clone(testAgents["hello"]).(*rpc.AgentInfo)
+	hello.PodIp = "192.168.1.5"
+	agentSess, err := client.ArriveAsAgent(ctx, hello)
+	require.NoError(t, err)
+	hello = proto.Clone(hello).(*rpc.AgentInfo)
+	hello.PodIp = "192.168.1.6"
+	agentSess2, err := client.ArriveAsAgent(ctx, hello)
+	require.NoError(t, err)
+
+	// A caller without a client session gets nothing
+	_, err = streamLogs(&rpc.StreamLogsRequest{Session: agentSess, AgentName: "hello", Namespace: "default"})
+	assertCode(codes.NotFound, err)
+	_, err = streamLogs(&rpc.StreamLogsRequest{})
+	assertCode(codes.NotFound, err)
+
+	aliceSess, err := client.ArriveAsClient(ctx, testClients["alice"])
+	require.NoError(t, err)
+
+	// The traffic-manager's own log
+	chunks, err := streamLogs(&rpc.StreamLogsRequest{Session: aliceSess, TailLines: 100})
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	assert.Equal(t, "traffic-manager-5c69859f94-g4ntj", chunks[0].PodName)
+	assert.Equal(t, "traffic-manager", chunks[0].Container)
+	assert.Equal(t, "fake logs", string(chunks[0].Data))
+
+	// The logs of the agents of a workload, but not of other pods with agents
+	chunks, err = streamLogs(&rpc.StreamLogsRequest{Session: aliceSess, AgentName: "hello", Namespace: "default", GetPodYaml: true})
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, "hello-abcdef-123", chunks[0].PodName)
+	assert.Equal(t, "hello-abcdef-456", chunks[1].PodName)
+	assert.Equal(t, "traffic-agent", chunks[1].Container)
+	assert.Contains(t, chunks[1].PodYaml, "192.168.1.6")
+
+	// Workloads that can't be intercepted
+	_, err = streamLogs(&rpc.StreamLogsRequest{Session: aliceSess, AgentName: "unrelated", Namespace: "default"})
+	assertCode(codes.NotFound, err)
+	_, err = streamLogs(&rpc.StreamLogsRequest{Session: aliceSess, AgentName: "hello", Namespace: "private"})
+	assertCode(codes.PermissionDenied, err)
+
+	for _, sess := range []*rpc.SessionInfo{aliceSess, agentSess, agentSess2} {
+		_, err = client.Depart(ctx, sess)
+		require.NoError(t, err)
+	}
+}
+
+func TestRemoteLogLevel(t *testing.T) {
+	ctx := dlog.NewTestContext(t, true)
+	testClients := testdata.GetTestClients(t)
+	testAgents := testdata.GetTestAgents(t)
+	conn := getTestClientConn(t)
+	defer conn.Close()
+	client := rpc.NewManagerClient(conn)
+
+	aliceSess, err := client.ArriveAsClient(ctx, testClients["alice"])
+	require.NoError(t, err)
+
+	// A current agent that watches its log-level, and an older one that doesn't
+	newAgent := proto.Clone(testAgents["hello"]).(*rpc.AgentInfo)
+	newAgent.PodIp = "10.1.0.5"
+	newSess, err := client.ArriveAsAgent(ctx, newAgent)
+	require.NoError(t, err)
+	oldAgent := proto.Clone(testAgents["hello"]).(*rpc.AgentInfo)
+	oldAgent.PodIp = "10.1.0.6"
+	oldAgent.Version = "2.4.4"
+	_, err = client.ArriveAsAgent(ctx, oldAgent)
+	require.NoError(t, err)
+
+	wCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := client.WatchAgentLogLevel(wCtx, newSess)
+	require.NoError(t, err)
+	levels := make(chan string, 10)
+	go func() {
+		for {
+			ll, err := stream.Recv()
+			if err != nil {
+				close(levels)
+				return
+			}
+			levels <- ll.LogLevel
+		}
+	}()
+	nextLevel := func() string {
+		t.Helper()
+		select {
+		case level := <-levels:
+			return level
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for a log-level")
+			return ""
+		}
+	}
+
+	setRemoteLogLevel := func(duration time.Duration, agents ...string) *rpc.RemoteLogLevels {
+		t.Helper()
+		var result *rpc.RemoteLogLevels
+		// The watch of the agent starts asynchronously
+		require.Eventually(t, func() bool {
+			result, err = client.SetRemoteLogLevel(ctx, &rpc.RemoteLogLevelRequest{
+				Session:  aliceSess,
+				LogLevel: "debug",
+				Duration: durationpb.New(duration),
+				Agents:   agents,
+			})
+			require.NoError(t, err)
+			for _, al := range result.Agents {
+				if al.PodIp == "10.1.0.5" {
+					return al.Error == ""
+				}
+			}
+			return false
+		}, 5*time.Second, 10*time.Millisecond)
+		return result
+	}
+
+	result := setRemoteLogLevel(time.Hour, "hello", "nothing")
+	require.NotNil(t, result.TrafficManager)
+	assert.Equal(t, "debug", result.TrafficManager.LogLevel)
+	require.Len(t, result.Agents, 3)
+	assert.Equal(t, "nothing", result.Agents[0].Name)
+	assert.Equal(t, "no traffic-agent found", result.Agents[0].Error)
+	assert.Equal(t, "10.1.0.5", result.Agents[1].PodIp)
+	assert.Empty(t, result.Agents[1].Error)
+	assert.Equal(t, "debug", result.Agents[1].LogLevel.LogLevel)
+	assert.Equal(t, "10.1.0.6", result.Agents[2].PodIp)
+	assert.Contains(t, result.Agents[2].Error, "too old")
+	assert.Equal(t, "debug", nextLevel())
+
+	levels2, err := client.GetRemoteLogLevels(ctx, aliceSess)
+	require.NoError(t, err)
+	assert.False(t, levels2.AllAgents)
+	require.Len(t, levels2.Agents, 1)
+	assert.Equal(t, "10.1.0.5", levels2.Agents[0].PodIp)
+
+	// The traffic-manager resets the log-levels when they expire, also when the client is gone
+	setRemoteLogLevel(200*time.Millisecond, "hello")
+	assert.Equal(t, "debug", nextLevel())
+	_, err = client.Depart(ctx, aliceSess)
+	require.NoError(t, err)
+	assert.Equal(t, "", nextLevel())
+	bobSess, err := client.ArriveAsClient(ctx, testClients["bob"])
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		levels2, err = client.GetRemoteLogLevels(ctx, bobSess)
+		require.NoError(t, err)
+		return levels2.TrafficManager == nil && len(levels2.Agents) == 0
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// Only clients can set log-levels
+	_, err = client.SetRemoteLogLevel(ctx, &rpc.RemoteLogLevelRequest{Session: newSess, LogLevel: "debug"})
+	require.Error(t, err)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
 func getTestClientConn(t *testing.T) *grpc.ClientConn {
+	return getTestClientConnWithEnv(t, &managerutil.Env{
+		MaxReceiveSize:  resource.Quantity{},
+		PodCIDRStrategy: "environment",
+		PodCIDRs:        "192.168.0.0/16",
+	})
+}
+
+func getTestClientConnWithEnv(t *testing.T, env *managerutil.Env, objects ...runtime.Object) *grpc.ClientConn {
 	const bufsize = 64 * 1024
 	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, true))
 
@@ -295,20 +583,16 @@ func getTestClientConn(t *testing.T) *grpc.ClientConn {
 		return lis.Dial()
 	}
 
-	fakeClient := fake.NewSimpleClientset(&corev1.Namespace{
+	fakeClient := fake.NewSimpleClientset(append([]runtime.Object{&corev1.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: "default",
 		},
-	})
+	}}, objects...)...)
 	fakeClient.Discovery().(*fakeDiscovery.FakeDiscovery).FakedServerVersion = &k8sVersion.Info{
 		GitVersion: "v1.17.0",
 	}
 	ctx = managerutil.WithK8SClientset(ctx, fakeClient)
-	ctx = managerutil.WithEnv(ctx, &managerutil.Env{
-		MaxReceiveSize:  resource.Quantity{},
-		PodCIDRStrategy: "environment",
-		PodCIDRs:        "192.168.0.0/16",
-	})
+	ctx = managerutil.WithEnv(ctx, env)
 
 	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
 	if err != nil {
@@ -0,0 +1,60 @@
+package manager
+
+import (
+	"context"
+	"crypto/tls"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+)
+
+// GetAgentTLSCertificate returns the certificate of the agent_tls_secret of an intercept to an agent of the
+// intercepted workload. The secret is read when it's requested, so that an agent that arrives after the
+// secret was renewed gets the new certificate.
+func (m *Manager) GetAgentTLSCertificate(ctx context.Context, request *rpc.AgentTLSCertificateRequest) (*rpc.AgentTLSCertificate, error) {
+	ctx = managerutil.WithSessionInfo(ctx, request.GetSession())
+	sessionID := request.GetSession().GetSessionId()
+	dlog.Debugf(ctx, "GetAgentTLSCertificate called: %s", request.InterceptId)
+
+	agent := m.state.GetAgent(sessionID)
+	if agent == nil {
+		return nil, status.Errorf(codes.NotFound, "Agent session %q not found", sessionID)
+	}
+	intercept, ok := m.state.GetIntercept(request.InterceptId)
+	if !ok || intercept.Spec.Namespace != agent.Namespace || intercept.Spec.Agent != agent.Name {
+		return nil, status.Errorf(codes.NotFound, "Intercept with ID %q not found for this session", request.InterceptId)
+	}
+	if intercept.Spec.AgentTlsSecret == "" {
+		return nil, status.Errorf(codes.FailedPrecondition, "intercept %q has no TLS secret", intercept.Spec.Name)
+	}
+	return loadAgentTLSCertificate(ctx, intercept.Spec.Namespace, intercept.Spec.AgentTlsSecret)
+}
+
+// loadAgentTLSCertificate reads the certificate and key of the TLS secret with the given name. A
+// FailedPrecondition error that names the secret is returned when it doesn't exist, or when it doesn't
+// contain a valid certificate and key.
+func loadAgentTLSCertificate(ctx context.Context, namespace, name string) (*rpc.AgentTLSCertificate, error) {
+	secret, err := managerutil.GetK8sClientset(ctx).CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, status.Errorf(codes.FailedPrecondition, "the TLS secret %q was not found in namespace %s", name, namespace)
+		}
+		return nil, status.Errorf(codes.Unavailable, "unable to get the TLS secret %q in namespace %s: %v", name, namespace, err)
+	}
+	cert := &rpc.AgentTLSCertificate{
+		CertPem: secret.Data[corev1.TLSCertKey],
+		KeyPem:  secret.Data[corev1.TLSPrivateKeyKey],
+	}
+	if _, err = tls.X509KeyPair(cert.CertPem, cert.KeyPem); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "the TLS secret %q in namespace %s has no valid %s and %s: %v",
+			name, namespace, corev1.TLSCertKey, corev1.TLSPrivateKeyKey, err)
+	}
+	return cert, nil
+}
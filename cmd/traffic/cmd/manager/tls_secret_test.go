@@ -0,0 +1,112 @@
+package manager_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	testdata "github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/test"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+)
+
+// selfSignedCert returns the PEM encoded certificate and key of a self-signed certificate for the given host.
+func selfSignedCert(t *testing.T, host string) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	kb, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: kb})
+}
+
+func TestAgentTLSSecret(t *testing.T) {
+	ctx := dlog.NewTestContext(t, true)
+	testClients := testdata.GetTestClients(t)
+	testAgents := testdata.GetTestAgents(t)
+
+	certPEM, keyPEM := selfSignedCert(t, "hello.example.com")
+	tlsSecret := func(name string, data map[string][]byte) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Type:       corev1.SecretTypeTLS,
+			Data:       data,
+		}
+	}
+	conn := getTestClientConnWithEnv(t, &managerutil.Env{
+		MaxReceiveSize:  resource.Quantity{},
+		PodCIDRStrategy: "environment",
+		PodCIDRs:        "192.168.0.0/16",
+	},
+		tlsSecret("hello-tls", map[string][]byte{corev1.TLSCertKey: certPEM, corev1.TLSPrivateKeyKey: keyPEM}),
+		tlsSecret("bogus-tls", map[string][]byte{corev1.TLSCertKey: certPEM}),
+	)
+	defer conn.Close()
+	client := rpc.NewManagerClient(conn)
+
+	aliceSess, err := client.ArriveAsClient(ctx, testClients["alice"])
+	require.NoError(t, err)
+	helloSess, err := client.ArriveAsAgent(ctx, testAgents["hello"])
+	require.NoError(t, err)
+
+	createIntercept := func(secret string) (*rpc.InterceptInfo, error) {
+		return client.CreateIntercept(ctx, &rpc.CreateInterceptRequest{
+			Session: aliceSess,
+			InterceptSpec: &rpc.InterceptSpec{
+				Name:           "tls-" + secret,
+				Namespace:      "default",
+				Client:         testClients["alice"].Name,
+				Agent:          testAgents["hello"].Name,
+				Mechanism:      "tcp",
+				TargetHost:     "127.0.0.1",
+				TargetPort:     8443,
+				AgentTlsSecret: secret,
+			},
+		})
+	}
+
+	// Intercepts with a secret that the agent can't use are refused, and the secret is named
+	for _, secret := range []string{"missing-tls", "bogus-tls"} {
+		_, err = createIntercept(secret)
+		st := status.Convert(err)
+		assert.Equal(t, codes.FailedPrecondition, st.Code(), secret)
+		assert.Contains(t, st.Message(), `"`+secret+`"`)
+	}
+
+	ii, err := createIntercept("hello-tls")
+	require.NoError(t, err)
+
+	// The agent gets the certificate, but a client doesn't
+	cert, err := client.GetAgentTLSCertificate(ctx, &rpc.AgentTLSCertificateRequest{Session: helloSess, InterceptId: ii.Id})
+	require.NoError(t, err)
+	assert.Equal(t, certPEM, cert.CertPem)
+	assert.Equal(t, keyPEM, cert.KeyPem)
+
+	_, err = client.GetAgentTLSCertificate(ctx, &rpc.AgentTLSCertificateRequest{Session: aliceSess, InterceptId: ii.Id})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
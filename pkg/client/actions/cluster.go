@@ -2,6 +2,8 @@ package actions
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 
 	"github.com/datawire/ambassador/v2/pkg/kates"
 	"github.com/datawire/dlib/derror"
@@ -10,29 +12,47 @@ import (
 // GetClusterID returns the cluster ID for the given cluster.  If there is an error, it still
 // returns a usable ID along with the error.
 func GetClusterID(ctx context.Context, client *kates.Client) (clusterID string, err error) {
-	// Get the ID of the "default" Namespace.
-	namespaceID, err := func() (namespaceID string, err error) {
-		defer func() {
-			// Kates is panicy
-			if r := derror.PanicToError(recover()); r != nil {
-				err = r
-			}
-		}()
-
-		ns := &kates.Namespace{
-			TypeMeta:   kates.TypeMeta{Kind: "Namespace"},
-			ObjectMeta: kates.ObjectMeta{Name: "default"},
-		}
-		if err := client.Get(ctx, ns, ns); err != nil {
-			return "", err
-		}
-
-		return string(ns.GetUID()), nil
-	}()
-	// Just use the namespace ID as the cluster ID.
+	// Just use the ID of the "default" Namespace as the cluster ID.
+	namespaceID, err := getNamespaceUID(ctx, client, "default")
 	if err != nil {
 		// But still return a usable ID if there's an error.
 		return "00000000-0000-0000-0000-000000000000", err
 	}
 	return namespaceID, nil
 }
+
+// GetTelemetryClusterID returns the anonymized cluster ID that is used in telemetry reports. It is
+// derived from the UID of the "kube-system" namespace and nothing else, so that it stays the same
+// regardless of who connects to the cluster and how. If there is an error, it still returns a usable
+// ID along with the error.
+func GetTelemetryClusterID(ctx context.Context, client *kates.Client) (clusterID string, err error) {
+	namespaceID, err := getNamespaceUID(ctx, client, "kube-system")
+	if err != nil {
+		return "00000000-0000-0000-0000-000000000000", err
+	}
+	return AnonymizeClusterID(namespaceID), nil
+}
+
+// AnonymizeClusterID returns a one-way hash of the given namespace UID.
+func AnonymizeClusterID(namespaceID string) string {
+	sum := sha256.Sum256([]byte(namespaceID))
+	return hex.EncodeToString(sum[:])
+}
+
+func getNamespaceUID(ctx context.Context, client *kates.Client, name string) (namespaceID string, err error) {
+	defer func() {
+		// Kates is panicy
+		if r := derror.PanicToError(recover()); r != nil {
+			err = r
+		}
+	}()
+
+	ns := &kates.Namespace{
+		TypeMeta:   kates.TypeMeta{Kind: "Namespace"},
+		ObjectMeta: kates.ObjectMeta{Name: name},
+	}
+	if err := client.Get(ctx, ns, ns); err != nil {
+		return "", err
+	}
+	return string(ns.GetUID()), nil
+}
@@ -0,0 +1,19 @@
+package actions_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/actions"
+)
+
+func TestAnonymizeClusterID(t *testing.T) {
+	const uid = "d3c5d0c4-0d5a-4d4a-9f2b-2b8e6f1c1a10"
+
+	// The hash must never change between releases, or the telemetry of a cluster would be split in two.
+	assert.Equal(t, "65d87d5e596c6483fd4eb99c3e57244cb98052045b6d7932e52ac9bb0cfcd2df", actions.AnonymizeClusterID(uid))
+	assert.Equal(t, actions.AnonymizeClusterID(uid), actions.AnonymizeClusterID(uid))
+	assert.NotEqual(t, actions.AnonymizeClusterID(uid), actions.AnonymizeClusterID("0c4d5a3e-0d5a-4d4a-9f2b-2b8e6f1c1a10"))
+	assert.NotContains(t, actions.AnonymizeClusterID(uid), uid)
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+)
+
+const (
+	dockerDaemonFile     = "docker-daemon.json"
+	dockerKubeConfigFile = "docker-kubeconfig.yaml"
+)
+
+// DockerDaemon records that the daemons run in a container, i.e. that the session was created using
+// connect --docker.
+type DockerDaemon struct {
+	// ContainerName is the name of the container that runs the daemons
+	ContainerName string `json:"container_name"`
+
+	// ConnectorAddress is the host address that the gRPC API of the user daemon is published on
+	ConnectorAddress string `json:"connector_address"`
+}
+
+// SaveDockerDaemonToUserCache saves the provided record to user cache and returns an error if
+// something goes wrong while marshalling or persisting.
+func SaveDockerDaemonToUserCache(ctx context.Context, dd *DockerDaemon) error {
+	return SaveToUserCache(ctx, dd, dockerDaemonFile)
+}
+
+// LoadDockerDaemonFromUserCache gets the docker daemon record from cache. A nil record is returned if
+// the file does not exist. An error is returned if something goes wrong while loading or unmarshalling.
+func LoadDockerDaemonFromUserCache(ctx context.Context) (*DockerDaemon, error) {
+	var dd DockerDaemon
+	if err := LoadFromUserCache(ctx, &dd, dockerDaemonFile); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &dd, nil
+}
+
+// DeleteDockerDaemonFromUserCache removes the docker daemon record and the kubeconfig that was given
+// to its container if they exist, or returns an error. An attempt to remove a non existing record is
+// a no-op and the function returns nil.
+func DeleteDockerDaemonFromUserCache(ctx context.Context) error {
+	if err := DeleteFromUserCache(ctx, dockerKubeConfigFile); err != nil {
+		return err
+	}
+	return DeleteFromUserCache(ctx, dockerDaemonFile)
+}
+
+// SaveDockerKubeConfigToUserCache saves the kubeconfig that is mounted into the container of the
+// daemons, and returns the path of the file.
+func SaveDockerKubeConfigToUserCache(ctx context.Context, kubeConfig []byte) (string, error) {
+	dir, err := ensureCacheDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, dockerKubeConfigFile)
+	if err = os.WriteFile(path, kubeConfig, 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+const idleDisconnectFile = "idle-disconnect.json"
+
+// IdleDisconnect records that the connector ended a session because it was idle.
+type IdleDisconnect struct {
+	// Time is when the session was disconnected
+	Time time.Time `json:"time"`
+
+	// Timeout is the idle timeout that elapsed
+	Timeout time.Duration `json:"timeout"`
+}
+
+// SaveIdleDisconnectToUserCache saves the provided record to user cache and returns an error if
+// something goes wrong while marshalling or persisting.
+func SaveIdleDisconnectToUserCache(ctx context.Context, idle *IdleDisconnect) error {
+	return SaveToUserCache(ctx, idle, idleDisconnectFile)
+}
+
+// LoadIdleDisconnectFromUserCache gets the idle disconnect record from cache. A nil record is
+// returned if the file does not exist. An error is returned if something goes wrong while loading or
+// unmarshalling.
+func LoadIdleDisconnectFromUserCache(ctx context.Context) (*IdleDisconnect, error) {
+	var idle IdleDisconnect
+	if err := LoadFromUserCache(ctx, &idle, idleDisconnectFile); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &idle, nil
+}
+
+// DeleteIdleDisconnectFromUserCache removes the idle disconnect record if exists or returns an error.
+// An attempt to remove a non existing record is a no-op and the function returns nil.
+func DeleteIdleDisconnectFromUserCache(ctx context.Context) error {
+	return DeleteFromUserCache(ctx, idleDisconnectFile)
+}
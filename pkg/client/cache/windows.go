@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"context"
+	"os"
+)
+
+const windowsDaemonsFile = "windows-daemons.json"
+
+// WindowsDaemons records that the CLI runs in WSL2 and that the daemons run on Windows, i.e. that the
+// session was created using connect --windows-daemons.
+type WindowsDaemons struct {
+	// Distro is the name of the WSL distribution that the CLI runs in
+	Distro string `json:"distro"`
+
+	// Exe is the path, in WSL, of the telepresence executable on Windows
+	Exe string `json:"exe"`
+
+	// EndpointFile is the path, in WSL, of the file that describes the WSL endpoint of the user daemon
+	EndpointFile string `json:"endpoint_file"`
+}
+
+// SaveWindowsDaemonsToUserCache saves the provided record to user cache and returns an error if
+// something goes wrong while marshalling or persisting.
+func SaveWindowsDaemonsToUserCache(ctx context.Context, wd *WindowsDaemons) error {
+	return SaveToUserCache(ctx, wd, windowsDaemonsFile)
+}
+
+// LoadWindowsDaemonsFromUserCache gets the windows daemons record from cache. A nil record is returned
+// if the file does not exist. An error is returned if something goes wrong while loading or
+// unmarshalling.
+func LoadWindowsDaemonsFromUserCache(ctx context.Context) (*WindowsDaemons, error) {
+	var wd WindowsDaemons
+	if err := LoadFromUserCache(ctx, &wd, windowsDaemonsFile); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &wd, nil
+}
+
+// DeleteWindowsDaemonsFromUserCache removes the windows daemons record if it exists, or returns an
+// error. An attempt to remove a non existing record is a no-op and the function returns nil.
+func DeleteWindowsDaemonsFromUserCache(ctx context.Context) error {
+	return DeleteFromUserCache(ctx, windowsDaemonsFile)
+}
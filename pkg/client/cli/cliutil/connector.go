@@ -11,12 +11,14 @@ import (
 
 	"google.golang.org/grpc"
 	grpcCodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	grpcStatus "google.golang.org/grpc/status"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/datawire/dlib/dgroup"
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 	"github.com/telepresenceio/telepresence/v2/pkg/proc"
 )
 
@@ -29,6 +31,10 @@ var ErrNoConnector = errors.New("telepresence user daemon is not running")
 // WithConnector listens for via the UserNotifications gRPC call).  WithConnector does NOT make the
 // "Connect" gRPC call or any other gRPC call except for UserNotifications.
 //
+// When the daemons run in docker mode, WithConnector connects to the user daemon in their container
+// and never launches a user daemon on the host. Likewise, when the CLI in WSL uses the daemons on
+// Windows, WithConnector connects to the user daemon on Windows.
+//
 // Nested calls to WithConnector will reuse the outer connection.
 func WithConnector(ctx context.Context, fn func(context.Context, connector.ConnectorClient) error) error {
 	return withConnector(ctx, true, fn)
@@ -42,6 +48,22 @@ func WithStartedConnector(ctx context.Context, fn func(context.Context, connecto
 
 type connectorConnCtxKey struct{}
 type connectorStartedCtxKey struct{}
+type stdoutCtxKey struct{}
+
+// WithStdout returns a context that makes the functions of this package write what they report, such as
+// the launch of a daemon and the notifications from the user daemon, to the given writer instead of
+// os.Stdout. A command that writes a JSON document to stdout uses it to keep that document intact.
+func WithStdout(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, stdoutCtxKey{}, w)
+}
+
+// stdout returns the writer given to WithStdout, or os.Stdout.
+func stdout(ctx context.Context) io.Writer {
+	if w, ok := ctx.Value(stdoutCtxKey{}).(io.Writer); ok {
+		return w
+	}
+	return os.Stdout
+}
 
 func withConnector(ctx context.Context, maybeStart bool, fn func(context.Context, connector.ConnectorClient) error) error {
 	if untyped := ctx.Value(connectorConnCtxKey{}); untyped != nil {
@@ -50,37 +72,61 @@ func withConnector(ctx context.Context, maybeStart bool, fn func(context.Context
 		return fn(ctx, connectorClient)
 	}
 
+	dd, err := DockerDaemon(ctx)
+	if err != nil {
+		return err
+	}
+	wd, err := WindowsDaemons(ctx)
+	if err != nil {
+		return err
+	}
 	var conn *grpc.ClientConn
 	started := false
-	for {
-		var err error
-		conn, err = client.DialSocket(ctx, client.ConnectorSocketName)
-		if err == nil {
-			break
+	switch {
+	case dd != nil:
+		if conn, err = dialDockerConnector(ctx, dd, connectorDialOptions()...); err != nil {
+			return err
 		}
-		if errors.Is(err, os.ErrNotExist) {
-			err = ErrNoConnector
-			if maybeStart {
-				fmt.Println("Launching Telepresence User Daemon")
-				if err = proc.StartInBackground(client.GetExe(), "connector-foreground"); err != nil {
-					return fmt.Errorf("failed to launch the connector service: %w", err)
-				}
-
-				if err = client.WaitUntilSocketAppears("connector", client.ConnectorSocketName, 10*time.Second); err != nil {
-					return fmt.Errorf("connector service did not start: %w", err)
+		ctx = context.WithValue(ctx, dockerDaemonCtxKey{}, dd)
+	case wd != nil:
+		if conn, err = dialWindowsConnector(ctx, wd, connectorDialOptions()...); err != nil {
+			return err
+		}
+		ctx = context.WithValue(ctx, windowsDaemonsCtxKey{}, wd)
+	default:
+		address := client.UserDaemonAddress(ctx)
+		for {
+			conn, err = client.DialSocket(ctx, address, connectorDialOptions()...)
+			if err == nil {
+				break
+			}
+			if errors.Is(err, os.ErrNotExist) {
+				err = ErrNoConnector
+				if maybeStart {
+					fmt.Fprintln(stdout(ctx), "Launching Telepresence User Daemon")
+					if err = proc.StartInBackground(client.GetExe(), "connector-foreground"); err != nil {
+						return fmt.Errorf("failed to launch the connector service: %w", err)
+					}
+
+					if err = client.WaitUntilSocketAppears(ctx, "connector", address, 10*time.Second); err != nil {
+						return fmt.Errorf("connector service did not start: %w", err)
+					}
+
+					maybeStart = false
+					started = true
+					continue
 				}
-
-				maybeStart = false
-				started = true
-				continue
 			}
+			return err
 		}
-		return err
 	}
 	defer conn.Close()
 	ctx = context.WithValue(ctx, connectorConnCtxKey{}, conn)
 	ctx = context.WithValue(ctx, connectorStartedCtxKey{}, started)
 	connectorClient := connector.NewConnectorClient(conn)
+	if _, err = connectorVersion(ctx, connectorClient); err != nil {
+		return err
+	}
 
 	grp := dgroup.NewGroup(ctx, dgroup.GroupConfig{
 		ShutdownOnNonError: true,
@@ -103,7 +149,7 @@ func withConnector(ctx context.Context, maybeStart bool, fn func(context.Context
 				}
 				return err
 			}
-			fmt.Println(strings.TrimRight(msg.Message, "\n"))
+			fmt.Fprintln(stdout(ctx), strings.TrimRight(msg.Message, "\n"))
 		}
 	})
 	grp.Go("main", func(ctx context.Context) error {
@@ -121,22 +167,56 @@ func DidLaunchConnector(ctx context.Context) bool {
 	return launched
 }
 
+// QuitConnector shuts down the connector, which ends the session with the cluster.
 func QuitConnector(ctx context.Context) error {
-	err := WithStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
-		fmt.Print("Telepresence User Daemon quitting...")
-		_, err := connectorClient.Quit(ctx, &empty.Empty{})
-		return err
-	})
-	if err == nil {
-		err = client.WaitUntilSocketVanishes("connector", client.ConnectorSocketName, 5*time.Second)
+	return Quit(ctx, stdout(ctx), true)
+}
+
+// connectorDialOptions returns the dial options that make the calls to the user daemon announce the
+// version of this CLI, and make the errors of calls that the user daemon doesn't implement name the
+// versions of both.
+func connectorDialOptions() []grpc.DialOption {
+	return append(client.VersionDialOptions(), grpc.WithChainUnaryInterceptor(unimplementedUnaryInterceptor))
+}
+
+func unimplementedUnaryInterceptor(
+	ctx context.Context,
+	method string,
+	req, reply interface{},
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	if !strings.HasPrefix(method, connectorMethodPrefix) {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	var md metadata.MD
+	err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Header(&md))...)
+	if grpcStatus.Code(err) == grpcCodes.Unimplemented {
+		// The code is retained, so that callers still can fall back to what older user daemons implement.
+		err = grpcStatus.Errorf(grpcCodes.Unimplemented, "the user daemon is %s, which doesn't implement %s that this CLI, which is %s, uses",
+			client.PeerVersionFromMetadata(md), strings.TrimPrefix(method, connectorMethodPrefix), client.OwnVersion())
 	}
+	return err
+}
+
+// connectorMethodPrefix is the prefix of the full names of the methods of the Connector service.
+var connectorMethodPrefix = "/" + connector.Connector_ServiceDesc.ServiceName + "/"
+
+// connectorVersion exchanges versions with the user daemon and returns what it announced. An error is
+// returned when the CLI and the user daemon have no connector API version in common.
+func connectorVersion(ctx context.Context, connectorClient connector.ConnectorClient) (client.PeerVersion, error) {
+	var md metadata.MD
+	vi, err := connectorClient.Version(ctx, &empty.Empty{}, grpc.Header(&md))
 	if err != nil {
-		if errors.Is(err, ErrNoConnector) {
-			fmt.Println("Telepresence User Daemon is already stopped")
-			return nil
-		}
-		return err
+		return client.PeerVersion{}, fmt.Errorf("unable to get the version of the user daemon: %w", err)
+	}
+	// A user daemon that predates the versioning sends no header, but it does know its version.
+	peer := client.PeerVersionFromMetadata(md)
+	peer.Version = vi.Version
+	own := client.OwnVersion()
+	if !own.Compatible(peer) {
+		return peer, errcat.User.New(client.VersionMismatch(own, peer))
 	}
-	fmt.Println(" done")
-	return nil
+	return peer, nil
 }
@@ -0,0 +1,117 @@
+package cliutil
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/common"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// previousConnector is a user daemon of the given version that implements nothing but Version, and
+// sends the given header, which is empty for a user daemon that predates the versioning.
+type previousConnector struct {
+	connector.UnimplementedConnectorServer
+	version string
+	header  metadata.MD
+}
+
+func (p *previousConnector) Version(ctx context.Context, _ *empty.Empty) (*common.VersionInfo, error) {
+	if p.header != nil {
+		_ = grpc.SetHeader(ctx, p.header)
+	}
+	return &common.VersionInfo{ApiVersion: 3, Version: p.version}, nil
+}
+
+// dialPreviousConnector dials the given connector over a bufconn the way that withConnector does.
+func dialPreviousConnector(ctx context.Context, t *testing.T, pc *previousConnector) connector.ConnectorClient {
+	lis := bufconn.Listen(1024 * 1024)
+	svc := grpc.NewServer()
+	connector.RegisterConnectorServer(svc, pc)
+	go func() { _ = svc.Serve(lis) }()
+	t.Cleanup(svc.Stop)
+
+	conn, err := grpc.DialContext(ctx, "bufconn", append([]grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+	}, connectorDialOptions()...)...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return connector.NewConnectorClient(conn)
+}
+
+func TestConnectorVersion_previousAPI(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cc := dialPreviousConnector(ctx, t, &previousConnector{version: "v2.4.4"})
+
+	// The current CLI serves the API of a user daemon that predates the versioning
+	peer, err := connectorVersion(ctx, cc)
+	require.NoError(t, err)
+	assert.Equal(t, client.PeerVersion{API: 1, MinAPI: 1, Version: "v2.4.4"}, peer)
+
+	// The methods that it doesn't implement yield errors that name both versions
+	_, err = cc.Resolve(ctx, &connector.ResolveRequest{})
+	st := status.Convert(err)
+	assert.Equal(t, codes.Unimplemented, st.Code(), "callers can still fall back")
+	assert.Contains(t, st.Message(), "telepresence 2.4.4 or older")
+	assert.Contains(t, st.Message(), "Resolve")
+	assert.Contains(t, st.Message(), "telepresence "+client.Version())
+
+	// Streams keep the plain Unimplemented, so that the CLI falls back from ConnectStream to Connect
+	stream, err := cc.ConnectStream(ctx, &connector.ConnectRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+func TestConnectorVersion_futureAPI(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	api := client.ConnectorAPIVersion + 2
+	cc := dialPreviousConnector(ctx, t, &previousConnector{version: "v9.9.9", header: metadata.Pairs(
+		"x-telepresence-api-version", strconv.Itoa(api),
+		"x-telepresence-min-api-version", strconv.Itoa(api-1),
+		"x-telepresence-version", "v9.9.9",
+	)})
+
+	peer, err := connectorVersion(ctx, cc)
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+	assert.Equal(t, client.PeerVersion{API: api, MinAPI: api - 1, Version: "v9.9.9"}, peer)
+	assert.Contains(t, err.Error(), "telepresence v9.9.9")
+	assert.Contains(t, err.Error(), "telepresence "+client.Version())
+	assert.Contains(t, err.Error(), "upgrade the CLI")
+}
+
+func Test_olderVersion(t *testing.T) {
+	tests := []struct {
+		v, of string
+		older bool
+	}{
+		{"v2.4.4", "v2.4.5", true},
+		{"v2.4.5-rc.1", "v2.4.5", true},
+		{"v2.4.5", "v2.4.5", false},
+		{"v2.5.0", "v2.4.5", false},
+		{"(devel)", "v2.4.5", false},
+		{"v2.4.4", "(devel)", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.older, olderVersion(tt.v, tt.of), "%s older than %s", tt.v, tt.of)
+	}
+}
@@ -9,18 +9,30 @@ import (
 	"time"
 
 	"google.golang.org/grpc"
-	empty "google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/helper"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/helper"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/winservice"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
 	"github.com/telepresenceio/telepresence/v2/pkg/proc"
 )
 
 var ErrNoDaemon = errors.New("telepresence root daemon is not running")
 
+// queryService, startService, startWithHelper, and startAsRoot are variables so that tests can fake how
+// the root daemon is started.
+var (
+	queryService    = winservice.Query
+	startService    = winservice.Start
+	startWithHelper = helper.StartDaemon
+	startAsRoot     = proc.StartInBackgroundAsRoot
+)
+
 func launchDaemon(ctx context.Context, dnsIP string) error {
-	fmt.Println("Launching Telepresence Root Daemon")
+	fmt.Fprintln(stdout(ctx), "Launching Telepresence Root Daemon")
 
 	// Ensure that the logfile is present before the daemon starts so that it isn't created with
 	// root permissions.
@@ -48,7 +60,45 @@ func launchDaemon(ctx context.Context, dnsIP string) error {
 		return err
 	}
 
-	return proc.StartInBackgroundAsRoot(ctx, client.GetExe(), "daemon-foreground", logDir, configDir, dnsIP)
+	runtimeDir, err := filelocation.AppUserRuntimeDir(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The root daemon must find the user daemon that this CLI launches and honor the telemetry setting
+	// of the user, but neither the service, the helper, nor sudo propagate the environment, and the
+	// runtime directory of root differs from the one of the user.
+	address := client.UserDaemonAddress(ctx)
+	userArgs := []string{"--user-daemon-address", address, "--runtime-dir", runtimeDir}
+	_, telemetrySource := client.GetTelemetry(ctx)
+	disableTelemetry := telemetrySource == client.TelemetryEnvironment
+	if disableTelemetry {
+		userArgs = append(userArgs, "--disable-telemetry")
+	}
+
+	info, err := queryService()
+	if err != nil {
+		return err
+	}
+	if info.Installed {
+		if info.Version != client.Version() {
+			return errcat.User.Newf("the %s service was installed by telepresence %s but this is telepresence %s; "+
+				"please reinstall it by running \"telepresence daemon-service install\" from an elevated prompt",
+				winservice.Name, info.Version, client.Version())
+		}
+		// The service logs to the system log directory, so the logging directory isn't passed.
+		return startService(append([]string{configDir, dnsIP}, userArgs...)...)
+	}
+
+	started, err := startWithHelper(ctx, &rpc.StartDaemonRequest{
+		Dns:               dnsIP,
+		UserDaemonAddress: address,
+		DisableTelemetry:  disableTelemetry,
+	})
+	if started || err != nil {
+		return err
+	}
+	return startAsRoot(ctx, append([]string{client.GetExe(), "daemon-foreground", logDir, configDir, dnsIP}, userArgs...)...)
 }
 
 // WithDaemon (1) ensures that the daemon is running, (2) establishes a connection to it, and (3)
@@ -93,7 +143,7 @@ func withDaemon(ctx context.Context, maybeStart bool, dnsIP string, fn func(cont
 					return fmt.Errorf("failed to launch the daemon service: %w", err)
 				}
 
-				if err = client.WaitUntilSocketAppears("daemon", client.DaemonSocketName, 10*time.Second); err != nil {
+				if err = client.WaitUntilSocketAppears(ctx, "daemon", client.DaemonSocketName, 10*time.Second); err != nil {
 					return fmt.Errorf("daemon service did not start: %w", err)
 				}
 
@@ -120,34 +170,7 @@ func DidLaunchDaemon(ctx context.Context) bool {
 	return launched
 }
 
-// QuitDaemon shuts down the root daemon. When it shuts down, it will tell the connector to shut down.
-func QuitDaemon(ctx context.Context) (err error) {
-	defer func() {
-		// Ensure the connector is killed even if daemon isn't running.  If the daemon already
-		// shut down the connector, then this is a no-op.
-		if cerr := QuitConnector(ctx); !(cerr == nil || errors.Is(err, ErrNoConnector)) {
-			if err == nil {
-				err = cerr
-			} else {
-				fmt.Fprintf(os.Stderr, "Error when quitting connector: %v\n", cerr)
-			}
-		}
-	}()
-	err = WithStartedDaemon(ctx, func(ctx context.Context, daemonClient daemon.DaemonClient) error {
-		fmt.Print("Telepresence Root Daemon quitting...")
-		_, err := daemonClient.Quit(ctx, &empty.Empty{})
-		return err
-	})
-	if err == nil {
-		err = client.WaitUntilSocketVanishes("daemon", client.DaemonSocketName, 5*time.Second)
-	}
-	if err != nil {
-		if errors.Is(err, ErrNoDaemon) {
-			fmt.Println("Telepresence Root Daemon is already stopped")
-			return nil
-		}
-		return err
-	}
-	fmt.Println(" done")
-	return nil
+// QuitDaemon shuts down the connector and the root daemon.
+func QuitDaemon(ctx context.Context) error {
+	return Quit(ctx, stdout(ctx), false)
 }
@@ -0,0 +1,135 @@
+package cliutil
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/helper"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/winservice"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// launched records how launchDaemon started the root daemon.
+type launched struct {
+	serviceArgs []string
+	helperRq    *rpc.StartDaemonRequest
+	sudoArgs    []string
+}
+
+func fakeLaunch(t *testing.T, service *winservice.Info, helperStarted bool, helperErr error) *launched {
+	l := &launched{}
+	oldQuery, oldService, oldHelper, oldRoot := queryService, startService, startWithHelper, startAsRoot
+	queryService = func() (*winservice.Info, error) {
+		return service, nil
+	}
+	startService = func(args ...string) error {
+		l.serviceArgs = args
+		return nil
+	}
+	startWithHelper = func(_ context.Context, rq *rpc.StartDaemonRequest) (bool, error) {
+		l.helperRq = rq
+		return helperStarted, helperErr
+	}
+	startAsRoot = func(_ context.Context, args ...string) error {
+		l.sudoArgs = args
+		return nil
+	}
+	t.Cleanup(func() {
+		queryService, startService, startWithHelper, startAsRoot = oldQuery, oldService, oldHelper, oldRoot
+	})
+	return l
+}
+
+func launchTestContext(t *testing.T) context.Context {
+	ctx := dlog.NewTestContext(t, false)
+	ctx = filelocation.WithAppUserLogDir(ctx, t.TempDir())
+	ctx = filelocation.WithAppUserConfigDir(ctx, t.TempDir())
+	return filelocation.WithAppUserRuntimeDir(ctx, t.TempDir())
+}
+
+func TestLaunchDaemon(t *testing.T) {
+	tests := []struct {
+		name       string
+		started    bool
+		helperErr  error
+		expectSudo bool
+	}{
+		{"no helper", false, nil, true},
+		{"helper", true, nil, false},
+		{"helper refuses", true, errors.New("refused"), false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := launchTestContext(t)
+			l := fakeLaunch(t, &winservice.Info{}, tt.started, tt.helperErr)
+
+			err := launchDaemon(ctx, "10.0.0.10")
+			if tt.helperErr != nil {
+				assert.Equal(t, tt.helperErr, err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Nil(t, l.serviceArgs)
+			require.NotNil(t, l.helperRq)
+			assert.Equal(t, "10.0.0.10", l.helperRq.Dns)
+			assert.Equal(t, client.UserDaemonAddress(ctx), l.helperRq.UserDaemonAddress)
+			if tt.expectSudo {
+				require.NotEmpty(t, l.sudoArgs)
+				assert.Contains(t, l.sudoArgs, "--runtime-dir")
+			} else {
+				assert.Empty(t, l.sudoArgs)
+			}
+		})
+	}
+}
+
+func TestLaunchDaemon_windowsService(t *testing.T) {
+	ctx := launchTestContext(t)
+	l := fakeLaunch(t, &winservice.Info{Installed: true, Version: client.Version()}, false, nil)
+
+	require.NoError(t, launchDaemon(ctx, "10.0.0.10"))
+	configDir, _ := filelocation.AppUserConfigDir(ctx)
+	runtimeDir, _ := filelocation.AppUserRuntimeDir(ctx)
+	assert.Equal(t, []string{
+		configDir, "10.0.0.10",
+		"--user-daemon-address", client.UserDaemonAddress(ctx),
+		"--runtime-dir", runtimeDir,
+	}, l.serviceArgs)
+	assert.Nil(t, l.helperRq)
+	assert.Nil(t, l.sudoArgs)
+}
+
+func TestLaunchDaemon_windowsServiceVersionMismatch(t *testing.T) {
+	ctx := launchTestContext(t)
+	l := fakeLaunch(t, &winservice.Info{Installed: true, Version: "v2.0.0"}, false, nil)
+
+	err := launchDaemon(ctx, "")
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+	assert.Contains(t, err.Error(), "installed by telepresence v2.0.0")
+	assert.Contains(t, err.Error(), "telepresence daemon-service install")
+
+	// Nothing is started, so that the old version doesn't run with a newer CLI
+	assert.Nil(t, l.serviceArgs)
+	assert.Nil(t, l.helperRq)
+	assert.Nil(t, l.sudoArgs)
+}
+
+func TestLaunchDaemon_disableTelemetry(t *testing.T) {
+	// The root daemon doesn't inherit the environment, so SCOUT_DISABLE is passed explicitly
+	ctx := client.WithEnv(launchTestContext(t), &client.Env{ScoutDisable: "1"})
+	l := fakeLaunch(t, &winservice.Info{}, false, nil)
+
+	require.NoError(t, launchDaemon(ctx, ""))
+	require.NotNil(t, l.helperRq)
+	assert.True(t, l.helperRq.DisableTelemetry)
+	assert.Contains(t, l.sudoArgs, "--disable-telemetry")
+}
@@ -0,0 +1,271 @@
+package cliutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
+)
+
+const (
+	// DockerContainerName is the name of the container that runs the daemons in docker mode
+	DockerContainerName = "telepresence-daemons"
+
+	// DockerConnectorPort is the port that the user daemon listens to in the container
+	DockerConnectorPort = 8687
+
+	// dockerHome is the home directory of the root user that runs the daemons in the container
+	dockerHome = "/root"
+)
+
+// DockerClient is the part of the docker CLI that is used to manage the container of the daemons.
+type DockerClient interface {
+	// Run starts a detached container using the given arguments to docker run
+	Run(ctx context.Context, args ...string) error
+
+	// Port returns the host address that the given port of the named container is published on
+	Port(ctx context.Context, name string, port uint16) (string, error)
+
+	// Running returns true if the named container exists and is running
+	Running(ctx context.Context, name string) (bool, error)
+
+	// Stop stops the named container. Stopping a container that doesn't exist is not an error.
+	Stop(ctx context.Context, name string) error
+}
+
+// dockerClient is a variable so that tests can fake the docker CLI.
+var dockerClient DockerClient = dockerCLI{}
+
+// dockerStartTimeout is how long to wait for the user daemon in a new container to accept connections.
+var dockerStartTimeout = 10 * time.Second
+
+type dockerCLI struct{}
+
+func (dockerCLI) docker(ctx context.Context, args ...string) (string, error) {
+	out, err := dexec.CommandContext(ctx, "docker", args...).Output()
+	if err != nil {
+		var ee *dexec.ExitError
+		if errors.As(err, &ee) && len(ee.Stderr) > 0 {
+			err = fmt.Errorf("docker %s: %s", args[0], strings.TrimSpace(string(ee.Stderr)))
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (d dockerCLI) Run(ctx context.Context, args ...string) error {
+	_, err := d.docker(ctx, append([]string{"run"}, args...)...)
+	return err
+}
+
+func (d dockerCLI) Port(ctx context.Context, name string, port uint16) (string, error) {
+	out, err := d.docker(ctx, "port", name, fmt.Sprintf("%d/tcp", port))
+	if err != nil {
+		return "", err
+	}
+	// One line for each address that the port is published on. Only 127.0.0.1 is used.
+	return strings.Split(out, "\n")[0], nil
+}
+
+func (d dockerCLI) Running(ctx context.Context, name string) (bool, error) {
+	out, err := d.docker(ctx, "inspect", "--format", "{{.State.Running}}", name)
+	if err != nil {
+		if strings.Contains(err.Error(), "No such object") {
+			return false, nil
+		}
+		return false, err
+	}
+	return out == "true", nil
+}
+
+func (d dockerCLI) Stop(ctx context.Context, name string) error {
+	_, err := d.docker(ctx, "stop", name)
+	if err != nil && strings.Contains(err.Error(), "No such container") {
+		err = nil
+	}
+	return err
+}
+
+// DockerImage returns the image that runs the daemons in docker mode.
+func DockerImage(ctx context.Context) string {
+	images := client.GetConfig(ctx).Images
+	if images.ClientImage != "" {
+		return images.Registry + "/" + images.ClientImage
+	}
+	return fmt.Sprintf("%s/telepresence:%s", images.Registry, strings.TrimPrefix(client.Version(), "v"))
+}
+
+// dockerRunArgs returns the arguments to docker run that start the container of the daemons. The
+// container gets the capabilities that the root daemon needs to create a TUN device, and the host
+// directories for logs and config are mounted at the locations that the daemons use.
+func dockerRunArgs(name, image, kubeConfigFile, logDir, configDir string) []string {
+	return []string{
+		"--detach",
+		"--rm",
+		"--name", name,
+		"--cap-add", "NET_ADMIN",
+		"--device", "/dev/net/tun",
+		"--add-host", "host.docker.internal:host-gateway",
+		"--publish", fmt.Sprintf("127.0.0.1::%d", DockerConnectorPort),
+		"--volume", kubeConfigFile + ":" + dockerHome + "/.kube/config:ro",
+		"--volume", logDir + ":" + dockerHome + "/.cache/telepresence/logs",
+		"--volume", configDir + ":" + dockerHome + "/.config/telepresence:ro",
+		image,
+		"docker-foreground",
+		"--address", ":" + strconv.Itoa(DockerConnectorPort),
+	}
+}
+
+type dockerDaemonCtxKey struct{}
+
+// GetDockerDaemon returns the container of the daemons that WithConnector talks to, or nil when the
+// daemons don't run in docker mode.
+func GetDockerDaemon(ctx context.Context) *cache.DockerDaemon {
+	dd, _ := ctx.Value(dockerDaemonCtxKey{}).(*cache.DockerDaemon)
+	return dd
+}
+
+// DockerDaemon returns the container of the daemons when they run in docker mode, or nil when they
+// don't. A record of a container that is no longer running is removed.
+func DockerDaemon(ctx context.Context) (*cache.DockerDaemon, error) {
+	dd, err := cache.LoadDockerDaemonFromUserCache(ctx)
+	if err != nil || dd == nil {
+		return nil, err
+	}
+	running, err := dockerClient.Running(ctx, dd.ContainerName)
+	if err != nil {
+		return nil, err
+	}
+	if !running {
+		dlog.Debugf(ctx, "Docker container %s is no longer running", dd.ContainerName)
+		return nil, cache.DeleteDockerDaemonFromUserCache(ctx)
+	}
+	return dd, nil
+}
+
+// StartDockerDaemons starts a container that runs the daemons, unless one is already running, and
+// records it in the user cache so that subsequent commands talk to the daemons in that container.
+// The given kubeconfig is mounted into the container. The returned bool is true if the container was
+// started by this call.
+func StartDockerDaemons(ctx context.Context, out io.Writer, kubeConfig []byte) (*cache.DockerDaemon, bool, error) {
+	dd, err := DockerDaemon(ctx)
+	if err != nil || dd != nil {
+		return dd, false, err
+	}
+	if exists, err := client.SocketExists(ctx, client.UserDaemonAddress(ctx)); err != nil || exists {
+		if err == nil {
+			err = errcat.User.New("the Telepresence User Daemon is already running on this host. Quit it before connecting with --docker")
+		}
+		return nil, false, err
+	}
+
+	kubeConfigFile, err := cache.SaveDockerKubeConfigToUserCache(ctx, kubeConfig)
+	if err != nil {
+		return nil, false, err
+	}
+	// Docker would create missing directories owned by root
+	logDir, err := filelocation.AppUserLogDir(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	configDir, err := filelocation.AppUserConfigDir(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, dir := range []string{logDir, configDir} {
+		if err = os.MkdirAll(dir, 0700); err != nil {
+			return nil, false, err
+		}
+	}
+
+	fmt.Fprintf(out, "Launching Telepresence Daemons in Docker container %s\n", DockerContainerName)
+	if err = dockerClient.Run(ctx, dockerRunArgs(DockerContainerName, DockerImage(ctx), kubeConfigFile, logDir, configDir)...); err != nil {
+		return nil, false, fmt.Errorf("failed to launch the daemons container: %w", err)
+	}
+	dd = &cache.DockerDaemon{ContainerName: DockerContainerName}
+	if dd.ConnectorAddress, err = waitForDockerConnector(ctx, DockerContainerName, dockerStartTimeout); err != nil {
+		_ = dockerClient.Stop(ctx, DockerContainerName)
+		return nil, false, err
+	}
+	if err = cache.SaveDockerDaemonToUserCache(ctx, dd); err != nil {
+		_ = dockerClient.Stop(ctx, DockerContainerName)
+		return nil, false, err
+	}
+	return dd, true, nil
+}
+
+// waitForDockerConnector waits until the user daemon in the named container accepts connections on
+// its published port, and returns the host address of that port. The wait will be max ttw (time to
+// wait) long.
+func waitForDockerConnector(ctx context.Context, name string, ttw time.Duration) (string, error) {
+	giveUp := time.Now().Add(ttw)
+	for giveUp.After(time.Now()) {
+		running, err := dockerClient.Running(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		if !running {
+			return "", fmt.Errorf("the daemons container %s exited, see the connector.log for details", name)
+		}
+		if addr, err := dockerClient.Port(ctx, name, DockerConnectorPort); err == nil {
+			if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+				conn.Close()
+				return addr, nil
+			}
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	return "", fmt.Errorf("timeout while waiting for the daemons container %s to start", name)
+}
+
+// dialDockerConnector dials the user daemon in the container of the given record.
+func dialDockerConnector(ctx context.Context, dd *cache.DockerDaemon, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, dd.ConnectorAddress, append([]grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithNoProxy(),
+		grpc.WithBlock(),
+		grpc.FailOnNonTempDialError(true),
+	}, append(append(tracing.DialOptions(), client.GrpcDialOptions(ctx)...), opts...)...)...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to contact the Telepresence User Daemon in Docker container %s: %w", dd.ContainerName, err)
+	}
+	return conn, nil
+}
+
+// StopDockerContainer stops the named container. Stopping a container that doesn't exist is not an
+// error.
+func StopDockerContainer(ctx context.Context, name string) error {
+	return dockerClient.Stop(ctx, name)
+}
+
+// stopDockerDaemons stops the container of the daemons and removes its record.
+func stopDockerDaemons(ctx context.Context, out io.Writer, dd *cache.DockerDaemon) error {
+	fmt.Fprintf(out, "Telepresence Daemons in Docker container %s quitting...", dd.ContainerName)
+	if err := dockerClient.Stop(ctx, dd.ContainerName); err != nil {
+		fmt.Fprintln(out, " failed")
+		return fmt.Errorf("unable to stop the Docker container %s: %w", dd.ContainerName, err)
+	}
+	if err := cache.DeleteDockerDaemonFromUserCache(ctx); err != nil {
+		fmt.Fprintln(out, " failed")
+		return err
+	}
+	fmt.Fprintln(out, " done")
+	return nil
+}
@@ -0,0 +1,169 @@
+package cliutil
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// fakeDocker is a docker CLI that runs one container, whose published connector port is served by a
+// listener in this process.
+type fakeDocker struct {
+	sync.Mutex
+	runs    [][]string
+	running map[string]bool
+	addr    string
+	exits   bool // if true, containers exit immediately
+}
+
+func (d *fakeDocker) Run(_ context.Context, args ...string) error {
+	d.Lock()
+	defer d.Unlock()
+	d.runs = append(d.runs, args)
+	for i, arg := range args {
+		if arg == "--name" {
+			d.running[args[i+1]] = !d.exits
+		}
+	}
+	return nil
+}
+
+func (d *fakeDocker) Port(_ context.Context, name string, port uint16) (string, error) {
+	d.Lock()
+	defer d.Unlock()
+	if !d.running[name] || port != DockerConnectorPort {
+		return "", assert.AnError
+	}
+	return d.addr, nil
+}
+
+func (d *fakeDocker) Running(_ context.Context, name string) (bool, error) {
+	d.Lock()
+	defer d.Unlock()
+	return d.running[name], nil
+}
+
+func (d *fakeDocker) Stop(_ context.Context, name string) error {
+	d.Lock()
+	defer d.Unlock()
+	delete(d.running, name)
+	return nil
+}
+
+func setupFakeDocker(t *testing.T) (context.Context, *fakeDocker) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	fd := &fakeDocker{running: make(map[string]bool), addr: l.Addr().String()}
+	oldClient, oldTimeout := dockerClient, dockerStartTimeout
+	dockerClient, dockerStartTimeout = fd, time.Second
+	t.Cleanup(func() { dockerClient, dockerStartTimeout = oldClient, oldTimeout })
+
+	ctx := dlog.NewTestContext(t, false)
+	ctx = filelocation.WithUserHomeDir(ctx, t.TempDir())
+	ctx = client.WithEnv(ctx, &client.Env{Registry: "docker.io/datawire"})
+	cfg := client.GetDefaultConfig(ctx)
+	cfg.Images.ClientImage = "telepresence:2.4.5"
+	return client.WithConfig(ctx, &cfg), fd
+}
+
+func TestStartDockerDaemons(t *testing.T) {
+	ctx, fd := setupFakeDocker(t)
+	out := &bytes.Buffer{}
+
+	dd, started, err := StartDockerDaemons(ctx, out, []byte("apiVersion: v1\n"))
+	require.NoError(t, err)
+	assert.True(t, started)
+	assert.Equal(t, &cache.DockerDaemon{ContainerName: DockerContainerName, ConnectorAddress: fd.addr}, dd)
+	assert.Contains(t, out.String(), "Launching Telepresence Daemons in Docker container telepresence-daemons")
+
+	require.Len(t, fd.runs, 1)
+	cacheDir, err := filelocation.AppUserCacheDir(ctx)
+	require.NoError(t, err)
+	logDir, err := filelocation.AppUserLogDir(ctx)
+	require.NoError(t, err)
+	configDir, err := filelocation.AppUserConfigDir(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"--detach",
+		"--rm",
+		"--name", "telepresence-daemons",
+		"--cap-add", "NET_ADMIN",
+		"--device", "/dev/net/tun",
+		"--add-host", "host.docker.internal:host-gateway",
+		"--publish", "127.0.0.1::8687",
+		"--volume", filepath.Join(cacheDir, "docker-kubeconfig.yaml") + ":/root/.kube/config:ro",
+		"--volume", logDir + ":/root/.cache/telepresence/logs",
+		"--volume", configDir + ":/root/.config/telepresence:ro",
+		"docker.io/datawire/telepresence:2.4.5",
+		"docker-foreground",
+		"--address", ":8687",
+	}, fd.runs[0])
+
+	// Subsequent commands find the running container
+	dd2, err := DockerDaemon(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, dd, dd2)
+	_, started, err = StartDockerDaemons(ctx, out, []byte("apiVersion: v1\n"))
+	require.NoError(t, err)
+	assert.False(t, started)
+	assert.Len(t, fd.runs, 1)
+
+	// Quit stops the container and forgets about it
+	out.Reset()
+	require.NoError(t, Quit(ctx, out, true))
+	assert.Equal(t, "Telepresence Daemons in Docker container telepresence-daemons quitting... done\n", out.String())
+	running, _ := fd.Running(ctx, DockerContainerName)
+	assert.False(t, running)
+	dd, err = DockerDaemon(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, dd)
+}
+
+func TestDockerDaemon_stopped(t *testing.T) {
+	ctx, fd := setupFakeDocker(t)
+	_, _, err := StartDockerDaemons(ctx, &bytes.Buffer{}, []byte("apiVersion: v1\n"))
+	require.NoError(t, err)
+
+	// The container stops, e.g. because the user daemon quit
+	require.NoError(t, fd.Stop(ctx, DockerContainerName))
+	dd, err := DockerDaemon(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, dd)
+	dd, err = cache.LoadDockerDaemonFromUserCache(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, dd, "the record of a stopped container is removed")
+}
+
+func TestStartDockerDaemons_containerExits(t *testing.T) {
+	ctx, fd := setupFakeDocker(t)
+	fd.exits = true
+	_, _, err := StartDockerDaemons(ctx, &bytes.Buffer{}, []byte("apiVersion: v1\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exited")
+	dd, err := cache.LoadDockerDaemonFromUserCache(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, dd)
+}
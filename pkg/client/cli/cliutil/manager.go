@@ -16,3 +16,12 @@ func WithManager(ctx context.Context, fn func(context.Context, manager.ManagerCl
 		return fn(ctx, managerClient)
 	})
 }
+
+// WithStartedManager is like WithManager, but returns ErrNoConnector if the connector is not
+// already running, rather than starting it.
+func WithStartedManager(ctx context.Context, fn func(context.Context, manager.ManagerClient) error) error {
+	return WithStartedConnector(ctx, func(ctx context.Context, _ connector.ConnectorClient) error {
+		conn := ctx.Value(connectorConnCtxKey{}).(*grpc.ClientConn)
+		return fn(ctx, manager.NewManagerClient(conn))
+	})
+}
@@ -0,0 +1,83 @@
+package cliutil
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/blang/semver"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// QuitOutdatedDaemons quits the running daemons that are older than this CLI, so that they are relaunched
+// with this version. Only the user daemon is quit when the root daemon is up-to-date. What it does is
+// reported to the given writer.
+//
+// Daemons that run in docker mode, or on Windows for the CLI in WSL, are left alone, because their
+// binaries aren't the binary of this CLI.
+func QuitOutdatedDaemons(ctx context.Context, out io.Writer) error {
+	dd, err := DockerDaemon(ctx)
+	if err != nil {
+		return err
+	}
+	wd, err := WindowsDaemons(ctx)
+	if err != nil {
+		return err
+	}
+	if dd != nil || wd != nil {
+		return nil
+	}
+	ud := *userDaemon
+	ud.socket = client.UserDaemonAddress(ctx)
+	udVersion, udOutdated := ud.outdated(ctx)
+	rdVersion, rdOutdated := rootDaemon.outdated(ctx)
+	switch {
+	case rdOutdated:
+		fmt.Fprintf(out, "The Telepresence %s is %s, which is older than this CLI, which is %s. "+
+			"Restarting the daemons (use --no-upgrade-daemons to prevent this)\n", rootDaemon.name, rdVersion, client.Version())
+		return Quit(ctx, out, false)
+	case udOutdated:
+		fmt.Fprintf(out, "The Telepresence %s is %s, which is older than this CLI, which is %s. "+
+			"Restarting it (use --no-upgrade-daemons to prevent this)\n", ud.name, udVersion, client.Version())
+		return Quit(ctx, out, true)
+	}
+	return nil
+}
+
+// outdated returns the version of the daemon, and true if it's older than this CLI. A daemon that isn't
+// running, or that can't tell its version, isn't considered outdated.
+func (d *daemonProcess) outdated(ctx context.Context) (string, bool) {
+	if exists, err := client.SocketExists(ctx, d.socket); err != nil || !exists {
+		return "", false
+	}
+	conn, err := client.DialSocket(ctx, d.socket)
+	if err != nil {
+		dlog.Debugf(ctx, "unable to contact the Telepresence %s: %v", d.name, err)
+		return "", false
+	}
+	defer conn.Close()
+	tc, cancel := context.WithTimeout(ctx, quitTimeout)
+	defer cancel()
+	version, err := d.version(tc, conn)
+	if err != nil {
+		dlog.Debugf(ctx, "unable to get the version of the Telepresence %s: %v", d.name, err)
+		return "", false
+	}
+	return version, olderVersion(version, client.Version())
+}
+
+// olderVersion returns true if version v is older than version of. Versions that aren't semantic
+// versions, like those of development builds, are never older.
+func olderVersion(v, of string) bool {
+	sv, err := semver.ParseTolerant(v)
+	if err != nil {
+		return false
+	}
+	sof, err := semver.ParseTolerant(of)
+	if err != nil {
+		return false
+	}
+	return sv.LT(sof)
+}
@@ -0,0 +1,209 @@
+package cliutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"google.golang.org/grpc"
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+)
+
+// quitTimeout is how long to wait for a daemon to confirm that it has stopped.
+var quitTimeout = 5 * time.Second
+
+// isAlive is a variable so that tests can fake the lifecycle of a daemon process.
+var isAlive = proc.IsAlive
+
+// runAsRoot is a variable so that tests can verify the cleanup of the network without elevated privileges.
+var runAsRoot = proc.RunAsRoot
+
+// daemonProcess is a daemon that can be asked for its version, and be told to quit.
+type daemonProcess struct {
+	name    string
+	socket  string
+	version func(context.Context, *grpc.ClientConn) (string, error)
+	quit    func(context.Context, *grpc.ClientConn) error
+}
+
+// userDaemon has no socket because its address is configurable. Quit uses a copy with the configured address.
+var userDaemon = &daemonProcess{
+	name: "User Daemon",
+	version: func(ctx context.Context, conn *grpc.ClientConn) (string, error) {
+		vi, err := connector.NewConnectorClient(conn).Version(ctx, &empty.Empty{})
+		if err != nil {
+			return "", err
+		}
+		return vi.Version, nil
+	},
+	quit: func(ctx context.Context, conn *grpc.ClientConn) error {
+		_, err := connector.NewConnectorClient(conn).Quit(ctx, &empty.Empty{})
+		return err
+	},
+}
+
+var rootDaemon = &daemonProcess{
+	name:   "Root Daemon",
+	socket: client.DaemonSocketName,
+	version: func(ctx context.Context, conn *grpc.ClientConn) (string, error) {
+		vi, err := daemon.NewDaemonClient(conn).Version(ctx, &empty.Empty{})
+		if err != nil {
+			return "", err
+		}
+		return vi.Version, nil
+	},
+	quit: func(ctx context.Context, conn *grpc.ClientConn) error {
+		_, err := daemon.NewDaemonClient(conn).Quit(ctx, &empty.Empty{})
+		return err
+	},
+}
+
+// Quit stops the user daemon, which ends the session with the cluster, and then the root daemon
+// unless disconnectOnly is true. It waits for each daemon to confirm that it has stopped, and reports
+// what it did to the given writer. An error is returned if a daemon could not be stopped.
+//
+// When the root daemon is stopped, the routes and DNS changes left behind by a root daemon that
+// didn't terminate gracefully are reverted.
+//
+// Daemons that run in docker mode share a container, which is stopped regardless of disconnectOnly.
+// Daemons on Windows that the CLI in WSL uses are stopped by the telepresence executable on Windows.
+func Quit(ctx context.Context, out io.Writer, disconnectOnly bool) error {
+	dd, err := DockerDaemon(ctx)
+	if err != nil {
+		return err
+	}
+	if dd != nil {
+		return stopDockerDaemons(ctx, out, dd)
+	}
+	wd, err := WindowsDaemons(ctx)
+	if err != nil {
+		return err
+	}
+	if wd != nil {
+		return stopWindowsDaemons(ctx, out, wd, disconnectOnly)
+	}
+	ud := *userDaemon
+	ud.socket = client.UserDaemonAddress(ctx)
+	daemons := []*daemonProcess{&ud}
+	if !disconnectOnly {
+		daemons = append(daemons, rootDaemon)
+	}
+	var failed []error
+	for _, d := range daemons {
+		if err := d.stop(ctx, out); err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if !disconnectOnly && len(failed) == 0 {
+		if err := cleanupNetwork(ctx, out); err != nil {
+			failed = append(failed, err)
+		}
+	}
+	switch len(failed) {
+	case 0:
+		return nil
+	case 1:
+		return failed[0]
+	default:
+		return fmt.Errorf("%v; %v", failed[0], failed[1])
+	}
+}
+
+// cleanupNetwork reverts the network changes recorded by a root daemon that didn't terminate
+// gracefully. A root daemon removes its record when it stops, so nothing is done, and no
+// elevated privileges are requested, unless such a record exists.
+func cleanupNetwork(ctx context.Context, out io.Writer) error {
+	if _, err := os.Stat(client.DaemonNetworkStateFile(ctx)); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	fmt.Fprint(out, "Cleaning up the network changes left behind by the Telepresence Root Daemon...")
+	if err := runAsRoot(ctx, client.GetExe(), client.DaemonCleanupCommand); err != nil {
+		fmt.Fprintln(out, " failed")
+		return fmt.Errorf("unable to clean up the network changes left behind by the Telepresence Root Daemon: %w", err)
+	}
+	fmt.Fprintln(out, " done")
+	return nil
+}
+
+// stop tells the daemon to quit and waits until its socket is gone and its process has exited. A
+// socket that is left behind by a daemon that terminated ungracefully is removed.
+func (d *daemonProcess) stop(ctx context.Context, out io.Writer) (err error) {
+	exists, err := client.SocketExists(ctx, d.socket)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		fmt.Fprintf(out, "Telepresence %s is already stopped\n", d.name)
+		return nil
+	}
+
+	fmt.Fprintf(out, "Telepresence %s quitting...", d.name)
+	defer func() {
+		if err != nil {
+			fmt.Fprintln(out, " failed")
+		}
+	}()
+	pid, err := client.SocketOwnerPID(d.socket)
+	if err != nil {
+		if !errors.Is(err, client.ErrStaleSocket) {
+			return fmt.Errorf("unable to contact the Telepresence %s: %w", d.name, err)
+		}
+		if err = client.RemoveStaleSocket(ctx, d.socket); err != nil {
+			return fmt.Errorf("unable to remove the stale socket of the Telepresence %s: %w", d.name, err)
+		}
+		fmt.Fprintf(out, " it had already terminated, removed its stale socket %s\n", d.socket)
+		return nil
+	}
+
+	conn, err := client.DialSocket(ctx, d.socket)
+	if err != nil {
+		return fmt.Errorf("unable to contact the Telepresence %s: %w", d.name, err)
+	}
+	tc, cancel := context.WithTimeout(ctx, quitTimeout)
+	defer cancel()
+	err = d.quit(tc, conn)
+	conn.Close()
+	if err != nil && grpcStatus.Code(err) != grpcCodes.Unavailable {
+		// Unavailable means that the daemon went away before it responded, which is fine.
+		return fmt.Errorf("the Telepresence %s refused to quit: %w", d.name, err)
+	}
+	if err = d.waitUntilStopped(ctx, pid); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, " done")
+	return nil
+}
+
+func (d *daemonProcess) waitUntilStopped(ctx context.Context, pid int) error {
+	giveUp := time.Now().Add(quitTimeout)
+	for {
+		exists, err := client.SocketExists(ctx, d.socket)
+		if err != nil {
+			return err
+		}
+		if !exists && (pid == 0 || !isAlive(pid)) {
+			return nil
+		}
+		if time.Now().After(giveUp) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if pid != 0 {
+		return fmt.Errorf("the Telepresence %s (pid %d) did not stop within %s", d.name, pid, quitTimeout)
+	}
+	return fmt.Errorf("the Telepresence %s did not stop within %s", d.name, quitTimeout)
+}
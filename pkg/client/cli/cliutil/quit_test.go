@@ -0,0 +1,141 @@
+//go:build !windows
+// +build !windows
+
+package cliutil
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// fakeConnector is a user daemon that calls onQuit when it's told to quit.
+type fakeConnector struct {
+	connector.UnimplementedConnectorServer
+	onQuit func()
+}
+
+func (f *fakeConnector) Quit(context.Context, *empty.Empty) (*empty.Empty, error) {
+	f.onQuit()
+	return &empty.Empty{}, nil
+}
+
+// startFakeDaemon serves a fake user daemon on a socket in a temporary directory. The daemon runs in this
+// process, so its process is considered alive until it's told to quit. It then stops serving, removes its
+// socket, and exits, unless it's hung, in which case it does nothing.
+func startFakeDaemon(t *testing.T, hung bool) *daemonProcess {
+	socket := filepath.Join(t.TempDir(), "connector.socket")
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	svc := grpc.NewServer()
+
+	var exited int32
+	oldIsAlive := isAlive
+	isAlive = func(pid int) bool {
+		return pid != os.Getpid() || atomic.LoadInt32(&exited) == 0
+	}
+	t.Cleanup(func() { isAlive = oldIsAlive })
+
+	fc := &fakeConnector{onQuit: func() {}}
+	if !hung {
+		fc.onQuit = func() {
+			go func() {
+				svc.GracefulStop()
+				atomic.StoreInt32(&exited, 1)
+			}()
+		}
+	}
+	connector.RegisterConnectorServer(svc, fc)
+	go func() { _ = svc.Serve(l) }()
+	t.Cleanup(svc.Stop)
+	return &daemonProcess{name: "User Daemon", socket: socket, quit: userDaemon.quit}
+}
+
+func withQuitTimeout(t *testing.T, timeout time.Duration) {
+	old := quitTimeout
+	quitTimeout = timeout
+	t.Cleanup(func() { quitTimeout = old })
+}
+
+func TestDaemonProcess_stop(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	d := startFakeDaemon(t, false)
+	out := &bytes.Buffer{}
+	require.NoError(t, d.stop(ctx, out))
+	assert.Equal(t, "Telepresence User Daemon quitting... done\n", out.String())
+	assert.NoFileExists(t, d.socket)
+
+	out.Reset()
+	require.NoError(t, d.stop(ctx, out))
+	assert.Equal(t, "Telepresence User Daemon is already stopped\n", out.String())
+}
+
+func TestDaemonProcess_stop_hung(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	withQuitTimeout(t, 500*time.Millisecond)
+	d := startFakeDaemon(t, true)
+	out := &bytes.Buffer{}
+	err := d.stop(ctx, out)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "did not stop within 500ms")
+	assert.Contains(t, err.Error(), "pid")
+	assert.Equal(t, "Telepresence User Daemon quitting... failed\n", out.String())
+}
+
+func TestDaemonProcess_stop_staleSocket(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	socket := filepath.Join(t.TempDir(), "connector.socket")
+
+	// A daemon that died without removing its socket
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, l.Close())
+	_, err = os.Stat(socket)
+	require.NoError(t, err)
+
+	d := &daemonProcess{name: "User Daemon", socket: socket, quit: userDaemon.quit}
+	out := &bytes.Buffer{}
+	require.NoError(t, d.stop(ctx, out))
+	assert.Contains(t, out.String(), "removed its stale socket")
+	assert.NoFileExists(t, socket)
+}
+
+func TestCleanupNetwork(t *testing.T) {
+	runtimeDir := t.TempDir()
+	ctx := filelocation.WithAppSystemRuntimeDir(dlog.NewTestContext(t, false), runtimeDir)
+	var calls [][]string
+	oldRunAsRoot := runAsRoot
+	runAsRoot = func(_ context.Context, args ...string) error {
+		calls = append(calls, args)
+		return nil
+	}
+	t.Cleanup(func() { runAsRoot = oldRunAsRoot })
+
+	// Nothing is left behind by a daemon that terminated gracefully
+	out := &bytes.Buffer{}
+	require.NoError(t, cleanupNetwork(ctx, out))
+	assert.Empty(t, out.String())
+	assert.Empty(t, calls)
+
+	stateFile := client.DaemonNetworkStateFile(ctx)
+	require.NoError(t, os.WriteFile(stateFile, []byte(`{"interface":"tel0"}`), 0600))
+	require.NoError(t, cleanupNetwork(ctx, out))
+	assert.Equal(t, "Cleaning up the network changes left behind by the Telepresence Root Daemon... done\n", out.String())
+	assert.Equal(t, [][]string{{client.GetExe(), client.DaemonCleanupCommand}}, calls)
+}
@@ -0,0 +1,28 @@
+package cliutil
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// forwardTimeout limits the time that the CLI waits for the user daemon to accept a telemetry report.
+var forwardTimeout = time.Second
+
+// ForwardTelemetry is a scout.Forwarder that hands a report over to the user daemon, which sends it
+// in a batch with other reports. The user daemon is never started just to report, so ErrNoConnector
+// is returned when it isn't running.
+func ForwardTelemetry(ctx context.Context, action string, metadata map[string]interface{}) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	return WithStartedConnector(ctx, func(ctx context.Context, cc connector.ConnectorClient) error {
+		ctx, cancel := context.WithTimeout(ctx, forwardTimeout)
+		defer cancel()
+		_, err := cc.ReportTelemetry(ctx, &connector.TelemetryReport{Action: action, Metadata: data})
+		return err
+	})
+}
@@ -0,0 +1,232 @@
+package cliutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+)
+
+// WindowsInterop is the part of the WSL interop that is used to find, start, and stop the daemons on
+// Windows.
+type WindowsInterop interface {
+	// Distro returns the name of the WSL distribution that the CLI runs in, or an empty string when it
+	// doesn't run in WSL
+	Distro() string
+
+	// Executable returns the path, in WSL, of the telepresence executable on Windows
+	Executable(ctx context.Context) (string, error)
+
+	// LocalAppData returns the path, in WSL, of the %LOCALAPPDATA% directory of the Windows user
+	LocalAppData(ctx context.Context) (string, error)
+
+	// Start starts the given executable on Windows in the background
+	Start(ctx context.Context, exe string, args ...string) error
+
+	// Run runs the given executable on Windows and waits for it to exit
+	Run(ctx context.Context, exe string, args ...string) error
+}
+
+// windowsInterop is a variable so that tests can fake the WSL interop.
+var windowsInterop WindowsInterop = wslInterop{}
+
+// windowsStartTimeout is how long to wait for the user daemon on Windows to serve the CLI in WSL. It's
+// generous, because the user may have to confirm that the root daemon is started as administrator.
+var windowsStartTimeout = 30 * time.Second
+
+type wslInterop struct{}
+
+func (wslInterop) Distro() string {
+	if os.Getenv("WSL_INTEROP") == "" {
+		// WSL1 has no virtual switch, and no interop socket
+		return ""
+	}
+	return os.Getenv("WSL_DISTRO_NAME")
+}
+
+func (wslInterop) Executable(context.Context) (string, error) {
+	exe, err := dexec.LookPath("telepresence.exe")
+	if err != nil {
+		return "", errcat.User.Newf("telepresence.exe was not found in the PATH; install Telepresence on Windows, " +
+			"and make sure that WSL appends the PATH of Windows")
+	}
+	return exe, nil
+}
+
+func (w wslInterop) LocalAppData(ctx context.Context) (string, error) {
+	out, err := dexec.CommandContext(ctx, "cmd.exe", "/d", "/c", "echo %LOCALAPPDATA%").Output()
+	if err != nil {
+		return "", fmt.Errorf("unable to get %%LOCALAPPDATA%% from Windows: %w", err)
+	}
+	dir := strings.TrimSpace(string(out))
+	if dir == "" || dir == "%LOCALAPPDATA%" {
+		return "", errors.New("%LOCALAPPDATA% is not defined on Windows")
+	}
+	return NewWSLPaths(w.Distro()).ToLinux(dir)
+}
+
+func (wslInterop) Start(_ context.Context, exe string, args ...string) error {
+	return proc.StartInBackground(append([]string{exe}, args...)...)
+}
+
+func (wslInterop) Run(ctx context.Context, exe string, args ...string) error {
+	out, err := dexec.CommandContext(ctx, exe, args...).CombinedOutput()
+	if err != nil && len(out) > 0 {
+		err = fmt.Errorf("%s %s: %s", filepath.Base(exe), args[0], strings.TrimSpace(string(out)))
+	}
+	return err
+}
+
+type windowsDaemonsCtxKey struct{}
+
+// GetWindowsDaemons returns the record of the daemons on Windows that WithConnector talks to, or nil when
+// the CLI doesn't use daemons on Windows.
+func GetWindowsDaemons(ctx context.Context) *cache.WindowsDaemons {
+	wd, _ := ctx.Value(windowsDaemonsCtxKey{}).(*cache.WindowsDaemons)
+	return wd
+}
+
+// WindowsDaemons returns the record of the daemons on Windows when the CLI in WSL uses them, or nil when it
+// doesn't. A record of a user daemon whose endpoint file is gone is removed.
+func WindowsDaemons(ctx context.Context) (*cache.WindowsDaemons, error) {
+	wd, err := cache.LoadWindowsDaemonsFromUserCache(ctx)
+	if err != nil || wd == nil {
+		return nil, err
+	}
+	if _, err = os.Stat(wd.EndpointFile); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		dlog.Debug(ctx, "The user daemon on Windows is no longer running")
+		return nil, cache.DeleteWindowsDaemonsFromUserCache(ctx)
+	}
+	return wd, nil
+}
+
+// StartWindowsDaemons makes the CLI in WSL use the daemons on Windows. Daemons that already serve the CLI
+// in WSL, because another CLI started them, are discovered using their endpoint file. Otherwise, they're
+// started using the WSL interop. The record is stored in the user cache so that subsequent commands talk
+// to the same daemons. The returned bool is true if the daemons were started by this call.
+func StartWindowsDaemons(ctx context.Context, out io.Writer) (*cache.WindowsDaemons, bool, error) {
+	wd, err := WindowsDaemons(ctx)
+	if err != nil || wd != nil {
+		return wd, false, err
+	}
+	distro := windowsInterop.Distro()
+	if distro == "" {
+		return nil, false, errcat.User.New("--windows-daemons can only be used in a WSL2 distribution")
+	}
+	if exists, err := client.SocketExists(ctx, client.UserDaemonAddress(ctx)); err != nil || exists {
+		if err == nil {
+			err = errcat.User.New("the Telepresence User Daemon is already running in WSL. Quit it before connecting with --windows-daemons")
+		}
+		return nil, false, err
+	}
+	exe, err := windowsInterop.Executable(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	appData, err := windowsInterop.LocalAppData(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	wd = &cache.WindowsDaemons{
+		Distro:       distro,
+		Exe:          exe,
+		EndpointFile: filepath.Join(appData, "telepresence", client.WSLEndpointFileName),
+	}
+
+	started := false
+	conn, err := dialWindowsConnector(ctx, wd)
+	switch {
+	case err == nil:
+		conn.Close()
+	case errors.Is(err, os.ErrNotExist):
+		// An endpoint file that nobody serves was left behind by a user daemon that terminated ungracefully
+		_ = os.Remove(wd.EndpointFile)
+		fmt.Fprintln(out, "Launching Telepresence Daemons on Windows")
+		if err = windowsInterop.Start(ctx, exe, "wsl-foreground"); err != nil {
+			return nil, false, fmt.Errorf("failed to launch the daemons on Windows: %w", err)
+		}
+		if err = waitForWindowsConnector(ctx, wd, windowsStartTimeout); err != nil {
+			return nil, false, err
+		}
+		started = true
+	default:
+		return nil, false, err
+	}
+	if err = cache.SaveWindowsDaemonsToUserCache(ctx, wd); err != nil {
+		return nil, false, err
+	}
+	return wd, started, nil
+}
+
+// waitForWindowsConnector waits until the user daemon on Windows serves the CLI in WSL. The wait will be
+// max ttw (time to wait) long.
+func waitForWindowsConnector(ctx context.Context, wd *cache.WindowsDaemons, ttw time.Duration) error {
+	giveUp := time.Now().Add(ttw)
+	for giveUp.After(time.Now()) {
+		conn, err := dialWindowsConnector(ctx, wd)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	return errors.New("timeout while waiting for the daemons on Windows to start, see the connector.log on Windows for details")
+}
+
+// dialWindowsConnector dials the user daemon on Windows using its endpoint file. The returned error wraps
+// os.ErrNotExist when the endpoint file doesn't exist, or when nothing listens to its endpoint.
+func dialWindowsConnector(ctx context.Context, wd *cache.WindowsDaemons, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	ep, err := client.ReadWSLEndpoint(wd.EndpointFile)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.DialWSLSocket(ctx, ep, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to contact the Telepresence User Daemon on Windows: %w", err)
+	}
+	return conn, nil
+}
+
+// WindowsEndpoint returns the endpoint that the user daemon on Windows serves the CLI in WSL on.
+func WindowsEndpoint(wd *cache.WindowsDaemons) (*client.WSLEndpoint, error) {
+	return client.ReadWSLEndpoint(wd.EndpointFile)
+}
+
+// stopWindowsDaemons uses the telepresence executable on Windows to stop the user daemon on Windows, and
+// the root daemon unless disconnectOnly is true, and then removes the record of the daemons.
+func stopWindowsDaemons(ctx context.Context, out io.Writer, wd *cache.WindowsDaemons, disconnectOnly bool) error {
+	args := []string{"quit", "--stop-daemons"}
+	if disconnectOnly {
+		args[1] = "--disconnect-only"
+	}
+	fmt.Fprint(out, "Telepresence Daemons on Windows quitting...")
+	if err := windowsInterop.Run(ctx, wd.Exe, args...); err != nil {
+		fmt.Fprintln(out, " failed")
+		return fmt.Errorf("unable to stop the daemons on Windows: %w", err)
+	}
+	if err := cache.DeleteWindowsDaemonsFromUserCache(ctx); err != nil {
+		fmt.Fprintln(out, " failed")
+		return err
+	}
+	fmt.Fprintln(out, " done")
+	return nil
+}
@@ -0,0 +1,227 @@
+//go:build !windows
+// +build !windows
+
+package cliutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// fakeWindows is the Windows side of a WSL distribution. Its telepresence executable serves a fake
+// user daemon on the loopback interface, which stands in for the interface on the virtual switch of WSL.
+type fakeWindows struct {
+	sync.Mutex
+	t        *testing.T
+	distro   string
+	appData  string
+	iface    string
+	starts   [][]string
+	runs     [][]string
+	listener net.Listener
+	svc      *grpc.Server
+}
+
+func (w *fakeWindows) Distro() string {
+	return w.distro
+}
+
+func (w *fakeWindows) Executable(context.Context) (string, error) {
+	return "/mnt/c/Program Files/telepresence/telepresence.exe", nil
+}
+
+func (w *fakeWindows) LocalAppData(context.Context) (string, error) {
+	return w.appData, nil
+}
+
+func (w *fakeWindows) Start(ctx context.Context, _ string, args ...string) error {
+	w.Lock()
+	w.starts = append(w.starts, args)
+	w.Unlock()
+	go func() {
+		// The daemons take a while to start
+		time.Sleep(100 * time.Millisecond)
+		w.serve(ctx)
+	}()
+	return nil
+}
+
+func (w *fakeWindows) Run(_ context.Context, _ string, args ...string) error {
+	w.Lock()
+	defer w.Unlock()
+	w.runs = append(w.runs, args)
+	if w.svc != nil {
+		w.svc.Stop()
+		_ = client.RemoveSocket(w.listener)
+		w.svc = nil
+	}
+	return nil
+}
+
+func (w *fakeWindows) serve(ctx context.Context) {
+	w.Lock()
+	defer w.Unlock()
+	l, err := client.ListenWSLSocket(ctx, "connector", w.iface, filepath.Join(w.appData, "telepresence", client.WSLEndpointFileName))
+	if !assert.NoError(w.t, err) {
+		return
+	}
+	svc := grpc.NewServer()
+	connector.RegisterConnectorServer(svc, &fakeConnector{onQuit: func() {}})
+	go func() { _ = svc.Serve(l) }()
+	w.listener, w.svc = l, svc
+}
+
+func setupFakeWindows(t *testing.T) (context.Context, *fakeWindows) {
+	ifaces, err := net.Interfaces()
+	require.NoError(t, err)
+	fw := &fakeWindows{t: t, distro: "Ubuntu", appData: t.TempDir()}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			fw.iface = iface.Name
+			break
+		}
+	}
+	if fw.iface == "" {
+		t.Skip("no loopback interface")
+	}
+	t.Cleanup(func() { _ = fw.Run(context.Background(), "", "quit") })
+
+	oldInterop, oldTimeout := windowsInterop, windowsStartTimeout
+	windowsInterop, windowsStartTimeout = fw, 5*time.Second
+	t.Cleanup(func() { windowsInterop, windowsStartTimeout = oldInterop, oldTimeout })
+
+	ctx := dlog.NewTestContext(t, false)
+	ctx = filelocation.WithUserHomeDir(ctx, t.TempDir())
+	ctx = client.WithEnv(ctx, &client.Env{UserDaemonAddress: filepath.Join(t.TempDir(), "connector.socket")})
+	return ctx, fw
+}
+
+func TestStartWindowsDaemons(t *testing.T) {
+	ctx, fw := setupFakeWindows(t)
+	out := &bytes.Buffer{}
+
+	wd, started, err := StartWindowsDaemons(ctx, out)
+	require.NoError(t, err)
+	assert.True(t, started)
+	assert.Equal(t, &cache.WindowsDaemons{
+		Distro:       "Ubuntu",
+		Exe:          "/mnt/c/Program Files/telepresence/telepresence.exe",
+		EndpointFile: filepath.Join(fw.appData, "telepresence", "wsl-connector.json"),
+	}, wd)
+	assert.Equal(t, "Launching Telepresence Daemons on Windows\n", out.String())
+	assert.Equal(t, [][]string{{"wsl-foreground"}}, fw.starts)
+
+	ep, err := WindowsEndpoint(wd)
+	require.NoError(t, err)
+	assert.Equal(t, fw.iface, ep.Interface)
+
+	// Subsequent commands find the running daemons
+	wd2, err := WindowsDaemons(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, wd, wd2)
+	_, started, err = StartWindowsDaemons(ctx, out)
+	require.NoError(t, err)
+	assert.False(t, started)
+	assert.Len(t, fw.starts, 1)
+
+	// Quit stops the daemons using the executable on Windows and forgets about them
+	out.Reset()
+	require.NoError(t, Quit(ctx, out, true))
+	assert.Equal(t, "Telepresence Daemons on Windows quitting... done\n", out.String())
+	assert.Equal(t, [][]string{{"quit", "--disconnect-only"}}, fw.runs)
+	wd, err = cache.LoadWindowsDaemonsFromUserCache(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, wd)
+}
+
+func TestStartWindowsDaemons_discover(t *testing.T) {
+	ctx, fw := setupFakeWindows(t)
+
+	// Another CLI in WSL, e.g. in another distribution, started the daemons
+	fw.serve(ctx)
+	wd, started, err := StartWindowsDaemons(ctx, &bytes.Buffer{})
+	require.NoError(t, err)
+	assert.False(t, started)
+	assert.NotNil(t, wd)
+	assert.Empty(t, fw.starts)
+
+	require.NoError(t, Quit(ctx, &bytes.Buffer{}, false))
+	assert.Equal(t, [][]string{{"quit", "--stop-daemons"}}, fw.runs)
+}
+
+func TestStartWindowsDaemons_staleEndpoint(t *testing.T) {
+	ctx, fw := setupFakeWindows(t)
+
+	// A user daemon that terminated ungracefully left its endpoint file behind
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l.Close()
+	endpointFile := filepath.Join(fw.appData, "telepresence", client.WSLEndpointFileName)
+	require.NoError(t, os.MkdirAll(filepath.Dir(endpointFile), 0700))
+	data, err := json.Marshal(&client.WSLEndpoint{Address: l.Addr().String(), Token: strings.Repeat("0", 64)})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(endpointFile, data, 0600))
+
+	_, started, err := StartWindowsDaemons(ctx, &bytes.Buffer{})
+	require.NoError(t, err)
+	assert.True(t, started)
+	assert.Len(t, fw.starts, 1)
+	ep, err := client.ReadWSLEndpoint(endpointFile)
+	require.NoError(t, err)
+	assert.NotEqual(t, l.Addr().String(), ep.Address)
+}
+
+func TestWindowsDaemons_stopped(t *testing.T) {
+	ctx, fw := setupFakeWindows(t)
+	_, _, err := StartWindowsDaemons(ctx, &bytes.Buffer{})
+	require.NoError(t, err)
+
+	// The user daemon on Windows quits, e.g. because it was told to by the CLI on Windows
+	require.NoError(t, fw.Run(ctx, fw.distro, "quit"))
+	wd, err := WindowsDaemons(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, wd)
+	wd, err = cache.LoadWindowsDaemonsFromUserCache(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, wd, "the record of a stopped user daemon is removed")
+}
+
+func TestStartWindowsDaemons_notWSL(t *testing.T) {
+	ctx, fw := setupFakeWindows(t)
+	fw.distro = ""
+	_, _, err := StartWindowsDaemons(ctx, &bytes.Buffer{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can only be used in a WSL2 distribution")
+	assert.Empty(t, fw.starts)
+}
+
+func TestStartWindowsDaemons_localUserDaemon(t *testing.T) {
+	ctx, fw := setupFakeWindows(t)
+	l, err := net.Listen("unix", client.UserDaemonAddress(ctx))
+	require.NoError(t, err)
+	defer l.Close()
+	_, _, err = StartWindowsDaemons(ctx, &bytes.Buffer{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "already running in WSL")
+	assert.Empty(t, fw.starts)
+	_, err = os.Stat(filepath.Join(fw.appData, "telepresence", client.WSLEndpointFileName))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
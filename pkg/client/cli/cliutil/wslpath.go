@@ -0,0 +1,131 @@
+package cliutil
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// WSLPaths translates the paths of files between the forms that they have in a WSL2 distribution and on
+// Windows. The drives of Windows are mounted below the MountRoot in WSL, so that C:\Users is /mnt/c/Users,
+// and the file system of the distribution is shared with Windows as \\wsl$\<Distro>, so that /home is
+// \\wsl$\Ubuntu\home.
+type WSLPaths struct {
+	// Distro is the name of the WSL distribution
+	Distro string
+
+	// MountRoot is the directory that the drives of Windows are mounted in, e.g. "/mnt/"
+	MountRoot string
+}
+
+// wslShares are the names that Windows uses for the share of the file systems of the WSL distributions.
+var wslShares = []string{`\\wsl$\`, `\\wsl.localhost\`}
+
+// NewWSLPaths returns the path translation of the given distribution, using the automount root of the
+// /etc/wsl.conf of the distribution.
+func NewWSLPaths(distro string) *WSLPaths {
+	return &WSLPaths{Distro: distro, MountRoot: wslMountRoot("/etc/wsl.conf")}
+}
+
+// wslMountRoot returns the root setting of the automount section of the given wsl.conf, or "/mnt/", which
+// is its default.
+func wslMountRoot(wslConf string) string {
+	root := "/mnt/"
+	f, err := os.Open(wslConf)
+	if err != nil {
+		return root
+	}
+	defer f.Close()
+	section := ""
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+		case line[0] == '[':
+			section = strings.ToLower(strings.Trim(line, "[]"))
+		case section == "automount":
+			if eq := strings.IndexByte(line, '='); eq > 0 && strings.TrimSpace(line[:eq]) == "root" {
+				if v := strings.Trim(strings.TrimSpace(line[eq+1:]), `"`); path.IsAbs(v) {
+					root = path.Clean(v)
+					if !strings.HasSuffix(root, "/") {
+						root += "/"
+					}
+				}
+			}
+		}
+	}
+	return root
+}
+
+// IsWindowsPath returns true if the given path is in Windows form, i.e. starts with a drive letter, or is
+// a UNC path.
+func IsWindowsPath(p string) bool {
+	return windowsDrive(p) != 0 || strings.HasPrefix(p, `\\`)
+}
+
+// windowsDrive returns the drive letter of the given path in Windows form, or zero when it has none.
+func windowsDrive(p string) byte {
+	if len(p) >= 2 && p[1] == ':' && (len(p) == 2 || p[2] == '\\' || p[2] == '/') {
+		if c := p[0]; c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+			return c
+		}
+	}
+	return 0
+}
+
+// ToWindows returns the Windows form of the given absolute path in WSL. A path on a mounted drive, such
+// as /mnt/c/Users, becomes C:\Users, and any other path a path on the share of the distribution.
+func (w *WSLPaths) ToWindows(p string) (string, error) {
+	if !path.IsAbs(p) {
+		return "", fmt.Errorf("path %q is not absolute", p)
+	}
+	p = path.Clean(p)
+	if rest := strings.TrimPrefix(p, w.MountRoot); rest != p {
+		drive := rest
+		if slash := strings.IndexByte(rest, '/'); slash >= 0 {
+			drive, rest = rest[:slash], rest[slash+1:]
+		} else {
+			rest = ""
+		}
+		if len(drive) == 1 && windowsDrive(drive+":") != 0 {
+			return strings.ToUpper(drive) + `:\` + strings.ReplaceAll(rest, "/", `\`), nil
+		}
+	}
+	if w.Distro == "" {
+		return "", fmt.Errorf("path %q is not on a drive of Windows, and the WSL distribution is unknown", p)
+	}
+	return wslShares[0] + w.Distro + strings.ReplaceAll(p, "/", `\`), nil
+}
+
+// ToLinux returns the form in WSL of the given path in Windows form. A path on a drive, such as
+// C:\Users, becomes /mnt/c/Users, and a path on the share of the distribution, such as
+// \\wsl$\Ubuntu\home, becomes /home. A path that already is an absolute path in WSL is returned as is.
+func (w *WSLPaths) ToLinux(p string) (string, error) {
+	if path.IsAbs(p) {
+		return path.Clean(p), nil
+	}
+	if drive := windowsDrive(p); drive != 0 {
+		rest := strings.ReplaceAll(p[2:], `\`, "/")
+		return path.Join(w.MountRoot, strings.ToLower(string(drive)), rest), nil
+	}
+	for _, share := range wslShares {
+		if len(p) < len(share) || !strings.EqualFold(p[:len(share)], share) {
+			continue
+		}
+		rest := p[len(share):]
+		distro := rest
+		if bs := strings.IndexByte(rest, '\\'); bs >= 0 {
+			distro, rest = rest[:bs], rest[bs:]
+		} else {
+			rest = ""
+		}
+		if !strings.EqualFold(distro, w.Distro) {
+			return "", fmt.Errorf("path %q is in the WSL distribution %s, not in %s", p, distro, w.Distro)
+		}
+		return path.Clean("/" + strings.ReplaceAll(rest, `\`, "/")), nil
+	}
+	return "", fmt.Errorf("path %q can't be reached from WSL", p)
+}
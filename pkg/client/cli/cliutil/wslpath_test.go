@@ -0,0 +1,106 @@
+package cliutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWSLPaths_ToWindows(t *testing.T) {
+	w := &WSLPaths{Distro: "Ubuntu", MountRoot: "/mnt/"}
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "/mnt/c/Users/alice/env.json", want: `C:\Users\alice\env.json`},
+		{path: "/mnt/d", want: `D:\`},
+		{path: "/mnt/d/", want: `D:\`},
+		{path: "/mnt/c/tmp/../mount", want: `C:\mount`},
+		{path: "/home/alice/mount", want: `\\wsl$\Ubuntu\home\alice\mount`},
+		{path: "/mnt/wsl/shared", want: `\\wsl$\Ubuntu\mnt\wsl\shared`},
+		{path: "/tmp/telfs-123", want: `\\wsl$\Ubuntu\tmp\telfs-123`},
+		{path: "mount", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := w.ToWindows(tt.path)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := (&WSLPaths{MountRoot: "/mnt/"}).ToWindows("/home/alice")
+	assert.Error(t, err, "a path on the share can't be formed without a distribution")
+}
+
+func TestWSLPaths_ToLinux(t *testing.T) {
+	w := &WSLPaths{Distro: "Ubuntu", MountRoot: "/mnt/"}
+	tests := []struct {
+		path    string
+		want    string
+		wantErr string
+	}{
+		{path: `C:\Users\alice\env.json`, want: "/mnt/c/Users/alice/env.json"},
+		{path: `c:/Users/alice`, want: "/mnt/c/Users/alice"},
+		{path: `D:`, want: "/mnt/d"},
+		{path: `\\wsl$\Ubuntu\home\alice`, want: "/home/alice"},
+		{path: `\\wsl.localhost\ubuntu\home\alice`, want: "/home/alice"},
+		{path: `\\wsl$\Ubuntu`, want: "/"},
+		{path: "/home/alice/../bob", want: "/home/bob"},
+		{path: `\\wsl$\Debian\home\alice`, wantErr: "is in the WSL distribution Debian, not in Ubuntu"},
+		{path: `\\fileserver\share\env.json`, wantErr: "can't be reached from WSL"},
+		{path: `env.json`, wantErr: "can't be reached from WSL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := w.ToLinux(tt.path)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestWSLPaths_roundTrip(t *testing.T) {
+	w := &WSLPaths{Distro: "Ubuntu", MountRoot: "/windir/"}
+	for _, p := range []string{"/windir/c/Users/alice", "/home/alice/mount", "/"} {
+		wp, err := w.ToWindows(p)
+		require.NoError(t, err)
+		assert.True(t, IsWindowsPath(wp))
+		lp, err := w.ToLinux(wp)
+		require.NoError(t, err)
+		assert.Equal(t, p, lp)
+	}
+}
+
+func Test_wslMountRoot(t *testing.T) {
+	dir := t.TempDir()
+	assert.Equal(t, "/mnt/", wslMountRoot(filepath.Join(dir, "missing.conf")))
+
+	wslConf := filepath.Join(dir, "wsl.conf")
+	require.NoError(t, os.WriteFile(wslConf, []byte(`# Settings of the distribution
+[network]
+root = /ignored
+
+[automount]
+enabled = true
+root = "/windir"
+options = "metadata"
+`), 0644))
+	assert.Equal(t, "/windir/", wslMountRoot(wslConf))
+
+	require.NoError(t, os.WriteFile(wslConf, []byte("[automount]\nroot = relative/\n"), 0644))
+	assert.Equal(t, "/mnt/", wslMountRoot(wslConf))
+}
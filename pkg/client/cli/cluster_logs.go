@@ -0,0 +1,449 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_k8s"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// clusterLogs gathers the logs of the traffic-manager and the traffic-agents into the
+// cluster/<namespace>/<pod> directories of an export directory. The logs are streamed by the
+// traffic-manager when the connector has a session, and read using the pod log API when it doesn't
+// or when the traffic-manager is too old to stream them.
+type clusterLogs struct {
+	pods             typedcorev1.PodsGetter
+	managerNamespace string
+	trafficManager   bool
+
+	// agents are the workloads that traffic-agent logs are gathered for. Pods are selected
+	// when their name contains one of the entries. An empty slice means no agents, and a
+	// nil slice means all agents.
+	agents []string
+
+	// namespaces are the namespaces that the pods of traffic-agents are listed in when their
+	// logs are read using the pod log API. A nil slice means all namespaces.
+	namespaces []string
+
+	since      time.Duration
+	podYaml    bool
+	anonymize  bool
+	anonymizer *anonymizer
+
+	// session is the connector's session with the traffic-manager, or nil when it isn't connected.
+	session     *manager.SessionInfo
+	withManager func(context.Context, func(context.Context, manager.ManagerClient) error) error
+}
+
+// streamPodLogs is a variable so that tests can fake the pod log API.
+var streamPodLogs = func(ctx context.Context, pods typedcorev1.PodInterface, name string, opts *corev1.PodLogOptions) (io.ReadCloser, error) {
+	return pods.GetLogs(name, opts).Stream(ctx)
+}
+
+// parseTrafficAgents parses the value of the --traffic-agents flag.
+func parseTrafficAgents(agents string) []string {
+	switch agents {
+	case "all":
+		return nil
+	case "None", "":
+		return []string{}
+	}
+	var workloads []string
+	for _, w := range strings.Split(agents, ",") {
+		if w = strings.TrimSpace(w); w != "" {
+			workloads = append(workloads, w)
+		}
+	}
+	return workloads
+}
+
+// useLogsFallback tells if an attempt to get logs from the traffic-manager failed in a way that
+// calls for reading them using the pod log API instead.
+func useLogsFallback(err error) bool {
+	if errors.Is(err, cliutil.ErrNoConnector) {
+		return true
+	}
+	switch grpcStatus.Code(err) {
+	case codes.Unimplemented, codes.Unavailable:
+		return true
+	}
+	return false
+}
+
+// newClusterLogs creates a clusterLogs that uses the same kubernetes context as the connector, or
+// the context given by the kubernetes flags when the connector isn't running.
+func (gl *gatherLogsArgs) newClusterLogs(ctx context.Context, anonymizer *anonymizer) (*clusterLogs, error) {
+	flagMap := connectorKubeFlagMap(ctx)
+	var session *manager.SessionInfo
+	var namespaces []string
+	err := cliutil.WithStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+		ci, err := connectorClient.Status(ctx, &connector.ConnectRequest{KubeFlags: flagMap})
+		if err != nil {
+			return err
+		}
+		if _, ok := flagMap["context"]; ok && flagMap["context"] != ci.ClusterContext {
+			// The logs are gathered from another cluster than the one that the connector is connected to
+			return nil
+		}
+		if ci.ClusterContext != "" {
+			flagMap["context"] = ci.ClusterContext
+		}
+		if ci.Error == connector.ConnectInfo_ALREADY_CONNECTED {
+			session = ci.SessionInfo
+			if len(ci.MappedNamespaces) > 0 {
+				namespaces = ci.MappedNamespaces
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, cliutil.ErrNoConnector) {
+		return nil, err
+	}
+	cfg, err := userd_k8s.NewConfig(ctx, flagMap)
+	if err != nil {
+		return nil, err
+	}
+	restConfig, err := cfg.ConfigFlags.ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	ki, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &clusterLogs{
+		pods:             ki.CoreV1(),
+		managerNamespace: cfg.Manager.Namespace,
+		trafficManager:   gl.trafficManager,
+		agents:           parseTrafficAgents(gl.trafficAgents),
+		namespaces:       namespaces,
+		since:            gl.since,
+		podYaml:          gl.podYaml,
+		anonymize:        gl.anon,
+		anonymizer:       anonymizer,
+		session:          session,
+		withManager: func(ctx context.Context, fn func(context.Context, manager.ManagerClient) error) error {
+			return cliutil.WithStartedManager(client.WithSessionContext(ctx, sessionContext()), fn)
+		},
+	}, nil
+}
+
+// gather writes the logs of the selected pods into the export directory. Failures to get the
+// logs of an individual container are recorded in a .error file in place of its log. An error
+// is returned when the pods can't be listed or when files can't be written.
+func (cl *clusterLogs) gather(ctx context.Context, exportDir string) error {
+	var errs []string
+	if cl.agents == nil || len(cl.agents) > 0 {
+		if err := cl.gatherAgentLogs(ctx, exportDir); err != nil {
+			errs = append(errs, fmt.Sprintf("traffic-agents: %v", err))
+		}
+	}
+	if cl.trafficManager {
+		if err := cl.gatherManagerLogs(ctx, exportDir); err != nil {
+			errs = append(errs, fmt.Sprintf("traffic-manager: %v", err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (cl *clusterLogs) gatherAgentLogs(ctx context.Context, exportDir string) error {
+	if cl.session != nil {
+		err := cl.withManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
+			return cl.streamAgentLogs(ctx, managerClient, exportDir)
+		})
+		if !useLogsFallback(err) {
+			return err
+		}
+		dlog.Debugf(ctx, "unable to stream the traffic-agent logs from the traffic-manager, using the pod log API: %v", err)
+	}
+	namespaces := cl.namespaces
+	if namespaces == nil {
+		namespaces = []string{""}
+	}
+	for _, ns := range namespaces {
+		pods, err := cl.pods.Pods(ns).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return err
+		}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if !cl.agentSelected(pod.Name) {
+				continue
+			}
+			for _, c := range pod.Spec.Containers {
+				if c.Name == install.AgentContainerName {
+					if err = cl.writePodLogs(ctx, exportDir, pod, []string{c.Name}); err != nil {
+						return err
+					}
+					break
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (cl *clusterLogs) agentSelected(podName string) bool {
+	if cl.agents == nil {
+		return true
+	}
+	for _, w := range cl.agents {
+		if strings.Contains(podName, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// streamAgentLogs writes the logs of the selected traffic-agents that the traffic-manager streams.
+// The traffic-manager only streams the logs of agents that the session could intercept, and the
+// reason why it refuses to stream the logs of a workload is recorded in a .error file.
+func (cl *clusterLogs) streamAgentLogs(ctx context.Context, managerClient manager.ManagerClient, exportDir string) error {
+	wCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watcher, err := managerClient.WatchAgents(wCtx, cl.session)
+	if err != nil {
+		return err
+	}
+	snapshot, err := watcher.Recv()
+	if err != nil {
+		return err
+	}
+	cancel()
+
+	type workload struct{ name, namespace string }
+	var workloads []workload
+	seen := make(map[workload]struct{})
+	for _, agent := range snapshot.Agents {
+		w := workload{name: agent.Name, namespace: agent.Namespace}
+		if _, ok := seen[w]; ok || !cl.workloadSelected(w.name) {
+			continue
+		}
+		seen[w] = struct{}{}
+		workloads = append(workloads, w)
+	}
+	for _, w := range workloads {
+		err = cl.streamLogs(ctx, managerClient, exportDir, w.name, w.namespace)
+		if st, ok := grpcStatus.FromError(err); ok && err != nil && !useLogsFallback(err) {
+			// Not being allowed to stream the logs of one workload shouldn't prevent us from getting the others
+			name, namespace := cl.podDirNames(w.name, w.namespace)
+			err = writeLogError(filepath.Join(exportDir, "cluster", namespace), name, st.Message())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// workloadSelected tells if the traffic-agents of the given workload can have pods that are selected.
+// A pod is selected when its name contains an entry, and the name of the pod of a workload starts with
+// the name of the workload, so the entry either contains the workload name or is contained in it.
+func (cl *clusterLogs) workloadSelected(name string) bool {
+	if cl.agents == nil {
+		return true
+	}
+	for _, w := range cl.agents {
+		if strings.Contains(name, w) || strings.HasPrefix(w, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// streamLogs writes the logs that the traffic-manager streams for the traffic-agents of the given workload,
+// or for the traffic-manager itself when the name is empty.
+func (cl *clusterLogs) streamLogs(ctx context.Context, managerClient manager.ManagerClient, exportDir, name, namespace string) error {
+	request := &manager.StreamLogsRequest{
+		Session:    cl.session,
+		AgentName:  name,
+		Namespace:  namespace,
+		GetPodYaml: cl.podYaml,
+	}
+	if cl.since > 0 {
+		request.Since = durationpb.New(cl.since)
+	}
+	stream, err := managerClient.StreamLogs(ctx, request)
+	if err != nil {
+		return err
+	}
+	for {
+		chunk, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return err
+		}
+		if name != "" && !cl.agentSelected(chunk.PodName) {
+			continue
+		}
+		if err = cl.writeLogChunk(exportDir, chunk); err != nil {
+			return err
+		}
+	}
+}
+
+// writeLogChunk appends a chunk of a log to the log file of its container, and records an error
+// that the traffic-manager got when reading the log in a .error file.
+func (cl *clusterLogs) writeLogChunk(exportDir string, chunk *manager.LogChunk) error {
+	podName, namespace := cl.podDirNames(chunk.PodName, chunk.Namespace)
+	podDir := filepath.Join(exportDir, "cluster", namespace, podName)
+	if err := os.MkdirAll(podDir, 0o755); err != nil {
+		return err
+	}
+	name := chunk.Container
+	if chunk.Previous {
+		name += ".previous"
+	}
+	if chunk.PodYaml != "" {
+		if err := os.WriteFile(filepath.Join(podDir, "pod.yaml"), []byte(chunk.PodYaml), 0o644); err != nil {
+			return err
+		}
+	}
+	if chunk.Error != "" {
+		return writeLogError(podDir, name, chunk.Error)
+	}
+	f, err := os.OpenFile(filepath.Join(podDir, name+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(chunk.Data)
+	return err
+}
+
+func writeLogError(dir, name, msg string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name+".error"), []byte(msg+"\n"), 0o644)
+}
+
+func (cl *clusterLogs) gatherManagerLogs(ctx context.Context, exportDir string) error {
+	if cl.session != nil {
+		err := cl.withManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
+			return cl.streamLogs(ctx, managerClient, exportDir, "", "")
+		})
+		if !useLogsFallback(err) {
+			return err
+		}
+		dlog.Debugf(ctx, "unable to stream the traffic-manager logs from the traffic-manager, using the pod log API: %v", err)
+	}
+	pods, err := cl.pods.Pods(cl.managerNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !strings.Contains(pod.Name, install.ManagerAppName) {
+			continue
+		}
+		containers := make([]string, len(pod.Spec.Containers))
+		for i, c := range pod.Spec.Containers {
+			containers[i] = c.Name
+		}
+		if err = cl.writePodLogs(ctx, exportDir, pod, containers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePodLogs writes the logs of the given containers of the pod into the pod's directory. The
+// log of the previous instance of a container is included when the container has restarted.
+func (cl *clusterLogs) writePodLogs(ctx context.Context, exportDir string, pod *corev1.Pod, containers []string) error {
+	podName, namespace := cl.podDirNames(pod.Name, pod.Namespace)
+	podDir := filepath.Join(exportDir, "cluster", namespace, podName)
+	if err := os.MkdirAll(podDir, 0o755); err != nil {
+		return err
+	}
+
+	restarted := make(map[string]bool)
+	for _, cs := range pod.Status.ContainerStatuses {
+		restarted[cs.Name] = cs.RestartCount > 0
+	}
+	for _, c := range containers {
+		if err := cl.writeContainerLog(ctx, podDir, pod, c, false); err != nil {
+			return err
+		}
+		if restarted[c] {
+			if err := cl.writeContainerLog(ctx, podDir, pod, c, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	if cl.podYaml {
+		podYaml, err := yaml.Marshal(pod)
+		if err != nil {
+			return err
+		}
+		if err = os.WriteFile(filepath.Join(podDir, "pod.yaml"), podYaml, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// podDirNames returns the names of the directories of the given pod and namespace.
+func (cl *clusterLogs) podDirNames(podName, namespace string) (string, string) {
+	if cl.anonymize {
+		// Anonymized names are "<pod>.<namespace>" and contain no other dots
+		parts := strings.SplitN(getPodName(podName+"."+namespace, true, cl.anonymizer), ".", 2)
+		podName, namespace = parts[0], parts[1]
+	}
+	return podName, namespace
+}
+
+func (cl *clusterLogs) writeContainerLog(ctx context.Context, podDir string, pod *corev1.Pod, container string, previous bool) error {
+	name := container
+	if previous {
+		name += ".previous"
+	}
+	opts := &corev1.PodLogOptions{Container: container, Previous: previous}
+	if cl.since > 0 {
+		sinceSeconds := int64(cl.since.Seconds())
+		opts.SinceSeconds = &sinceSeconds
+	}
+	rc, err := streamPodLogs(ctx, cl.pods.Pods(pod.Namespace), pod.Name, opts)
+	if err != nil {
+		// Not being allowed to read the logs of one pod shouldn't prevent us from getting the others
+		return writeLogError(podDir, name, err.Error())
+	}
+	defer rc.Close()
+
+	f, err := os.Create(filepath.Join(podDir, name+".log"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = io.Copy(f, rc); err != nil {
+		return writeLogError(podDir, name, err.Error())
+	}
+	return nil
+}
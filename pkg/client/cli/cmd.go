@@ -2,6 +2,8 @@ package cli
 
 import (
 	"context"
+	"fmt"
+	"net"
 	"runtime"
 	"strings"
 
@@ -9,7 +11,9 @@ import (
 	"github.com/spf13/pflag"
 
 	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
 )
 
 var help = `Telepresence can connect to a cluster and route all outbound traffic from your
@@ -35,9 +39,56 @@ recommended) which in turn may result in a password prompt.`
 // global options
 var dnsIP string
 var mappedNamespaces []string
+var dnsIncludeSuffixes []string
+var dnsExcludeSuffixes []string
+var alsoProxy subnetSlice
+var neverProxy subnetSlice
+var managerNamespace string
+var managerValues []byte
+var proxyMode string
+var hostsFile string
+var socks5Address string
+var forceTunnel bool
+var noUpgradeDaemons bool
 var kubeFlags *pflag.FlagSet
 var kubeConfig *kates.ConfigFlags
 
+// subnetSlice is a pflag.Value for a comma separated list of CIDRs. An invalid CIDR is rejected
+// when the flag is parsed.
+type subnetSlice []*net.IPNet
+
+func (s *subnetSlice) String() string {
+	strs := make([]string, len(*s))
+	for i, sn := range *s {
+		strs[i] = sn.String()
+	}
+	return "[" + strings.Join(strs, ",") + "]"
+}
+
+func (s *subnetSlice) Set(v string) error {
+	for _, cidr := range strings.Split(v, ",") {
+		cidr = strings.TrimSpace(cidr)
+		_, sn, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %q", cidr)
+		}
+		*s = append(*s, sn)
+	}
+	return nil
+}
+
+func (s *subnetSlice) Type() string {
+	return "cidrSlice"
+}
+
+func (s subnetSlice) toRPC() []*manager.IPNet {
+	rs := make([]*manager.IPNet, len(s))
+	for i, sn := range s {
+		rs[i] = iputil.IPNetToRPC(sn)
+	}
+	return rs
+}
+
 // OnlySubcommands is a cobra.PositionalArgs that is similar to cobra.NoArgs, but prints a better
 // error message.
 func OnlySubcommands(cmd *cobra.Command, args []string) error {
@@ -162,6 +213,22 @@ func Command(ctx context.Context) *cobra.Command {
 				"mapped-namespaces", nil, ``+
 					`Comma separated list of namespaces considered by DNS resolver and NAT for outbound connections. `+
 					`Defaults to all namespaces`)
+			netflags.StringSliceVar(&dnsIncludeSuffixes,
+				"dns-include-suffixes", nil, ``+
+					`Comma separated list of name suffixes that the DNS resolver always resolves in the cluster. `+
+					`Added to the dns.includeSuffixes of the config`)
+			netflags.StringSliceVar(&dnsExcludeSuffixes,
+				"dns-exclude-suffixes", nil, ``+
+					`Comma separated list of name suffixes that the DNS resolver never resolves in the cluster. `+
+					`Added to the dns.excludeSuffixes of the config`)
+			netflags.Var(&alsoProxy,
+				"also-proxy", ``+
+					`Comma separated list of CIDRs, outside of the cluster's pod and service subnets, that are routed `+
+					`to the cluster. Added to the alsoProxy of the config`)
+			netflags.Var(&neverProxy,
+				"never-proxy", ``+
+					`Comma separated list of CIDRs that are never routed to the cluster, even when they overlap with `+
+					`the cluster's subnets. Added to the neverProxy of the config`)
 
 			return netflags
 		}(),
@@ -175,10 +242,19 @@ func Command(ctx context.Context) *cobra.Command {
 				"no-report", false,
 				"turn off anonymous crash reports and log submission on failure",
 			)
+			flags.BoolVar(&noUpgradeDaemons,
+				"no-upgrade-daemons", false, ``+
+					`Don't quit running daemons that are older than this CLI, so that they are relaunched with `+
+					`this version`)
 			return flags
 		}(),
 	})
 
+	otherCommands := []*cobra.Command{versionCommand(), upgradeCommand(), uninstallCommand(), dashboardCommand(), ClusterIdCommand(), completionCommand(), configCommand()}
+	if runtime.GOOS == "windows" {
+		otherCommands = append(otherCommands, daemonServiceCommand())
+	}
+
 	rootCmd.InitDefaultHelpCmd()
 	AddCommandGroups(rootCmd, []CommandGroup{
 		{
@@ -187,15 +263,15 @@ func Command(ctx context.Context) *cobra.Command {
 		},
 		{
 			Name:     "Traffic Commands",
-			Commands: []*cobra.Command{listCommand(), interceptCommand(ctx), leaveCommand(), previewCommand()},
+			Commands: []*cobra.Command{listCommand(), interceptCommand(ctx), leaveCommand(), previewCommand(), runSpecCommand(), portForwardCommand()},
 		},
 		{
 			Name:     "Debug Commands",
-			Commands: []*cobra.Command{loglevelCommand(), gatherLogsCommand()},
+			Commands: []*cobra.Command{loglevelCommand(), gatherLogsCommand(), bugReportCommand(), gatherTracesCommand(), resolveCommand(), logsCommand()},
 		},
 		{
 			Name:     "Other Commands",
-			Commands: []*cobra.Command{versionCommand(), uninstallCommand(), dashboardCommand(), ClusterIdCommand()},
+			Commands: otherCommands,
 		},
 	})
 	for _, group := range globalFlagGroups {
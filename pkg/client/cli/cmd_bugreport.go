@@ -0,0 +1,306 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/scout"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/log"
+)
+
+type bugReportArgs struct {
+	outputFile string
+	noCluster  bool
+	since      time.Duration
+}
+
+// bugReportManifest is the manifest.json of a bug report. It lists what the report was meant to contain,
+// and tells why the components that are missing weren't collected.
+type bugReportManifest struct {
+	ClientVersion string                `json:"client_version"`
+	Created       string                `json:"created"`
+	Cluster       bool                  `json:"cluster"`
+	Components    []*bugReportComponent `json:"components"`
+}
+
+type bugReportComponent struct {
+	Name      string `json:"name"`
+	Collected bool   `json:"collected"`
+
+	// Path is the file, or the directory, of the component in the archive.
+	Path     string   `json:"path,omitempty"`
+	Reason   string   `json:"reason,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// newBugReportClusterLogs is a variable so that tests can fake the cluster.
+var newBugReportClusterLogs = (*gatherLogsArgs).newClusterLogs
+
+func bugReportCommand() *cobra.Command {
+	br := &bugReportArgs{}
+	cmd := &cobra.Command{
+		Use:   "bug-report",
+		Args:  cobra.NoArgs,
+		Short: "Collect everything needed to report a problem with Telepresence into a zip file.",
+		Long: `Collect everything needed to report a problem with Telepresence into a zip file.
+
+The zip file contains the output of "telepresence version --output json" and "telepresence status --output
+json", the route and DNS diagnostics of the root daemon, the configuration as shown by "telepresence config
+view --source", the logs of the daemons, and the logs of the traffic-manager and traffic-agents. The names of
+pods and namespaces are anonymized, and values of the configuration that look like secrets are masked. A
+manifest.json lists the components of the report, and tells why a component couldn't be collected.
+
+Use --no-cluster to only collect what is available on this workstation.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return br.bugReport(cmd.Context(), cmd.OutOrStdout())
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&br.outputFile, "output-file", "o", "", "The file you want to output the report to.")
+	flags.BoolVar(&br.noCluster, "no-cluster", false, "Don't collect anything from the cluster")
+	flags.DurationVar(&br.since, "since", 0, "Only collect cluster logs newer than a relative duration like 30s, 5m, or 3h")
+	return cmd
+}
+
+// bugReport collects the components of the report into a zip file. A component that can't be collected is
+// recorded as skipped in the manifest, so an error is only returned when the zip file can't be created.
+func (br *bugReportArgs) bugReport(ctx context.Context, stdout io.Writer) error {
+	if br.outputFile == "" {
+		pwd, err := os.Getwd()
+		if err != nil {
+			return errcat.User.New(err)
+		}
+		br.outputFile = filepath.Join(pwd, "telepresence_bug_report.zip")
+	} else if !strings.HasSuffix(br.outputFile, ".zip") {
+		return errcat.User.New("output file must end in .zip")
+	}
+
+	sc := scout.NewScout(ctx, "cli")
+	sc.SetMetadatum("no_cluster", br.noCluster)
+	sc.Report(log.WithDiscardingLogger(ctx), "used_bug_report")
+
+	exportDir, err := os.MkdirTemp("", "bugreport-")
+	if err != nil {
+		return errcat.User.New(err)
+	}
+	defer os.RemoveAll(exportDir)
+
+	manifest := &bugReportManifest{
+		ClientVersion: client.Version(),
+		Created:       time.Now().UTC().Format(time.RFC3339),
+		Cluster:       !br.noCluster,
+	}
+	anonymizer := &anonymizer{
+		namespaces: make(map[string]string),
+		podNames:   make(map[string]string),
+	}
+	add := func(c *bugReportComponent) {
+		manifest.Components = append(manifest.Components, c)
+	}
+
+	// The error of a daemon that fails is included in the version and the status
+	vi, _ := collectVersion(ctx)
+	add(br.writeJSON(exportDir, "version", "version.json", vi))
+
+	si, err := collectStatus(ctx)
+	code := StatusError
+	if err == nil {
+		code = si.exitCode()
+	} else {
+		si.Error = err.Error()
+	}
+	si.State = statusStates[code]
+	add(br.writeJSON(exportDir, "status", "status.json", si))
+	if si.Network != nil && si.Network.Available {
+		add(br.writeJSON(exportDir, "network", "network.json", si.Network))
+	} else {
+		reason := "the root daemon is not running"
+		if si.Network != nil && si.Network.Reason != "" {
+			reason = si.Network.Reason
+		}
+		add(&bugReportComponent{Name: "network", Reason: reason})
+	}
+
+	add(br.writeConfig(ctx, exportDir))
+	add(br.copyDaemonLogs(ctx, exportDir))
+	for _, c := range br.gatherClusterLogs(ctx, exportDir, anonymizer) {
+		add(c)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(filepath.Join(exportDir, "manifest.json"), append(data, '\n'), 0o644); err != nil {
+		return errcat.User.New(err)
+	}
+
+	// Every file of the report is anonymized, not only the logs
+	var files []string
+	err = filepath.WalkDir(exportDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		if err := anonymizeLog(stdout, path, anonymizer); err != nil {
+			return err
+		}
+		files = append(files, path)
+		return nil
+	})
+	if err != nil {
+		return errcat.User.New(err)
+	}
+	if err := zipFiles(files, exportDir, br.outputFile); err != nil {
+		return errcat.User.New(err)
+	}
+	fmt.Fprintf(stdout, "Bug report has been written to %s\n", br.outputFile)
+	manifest.writeSummary(stdout)
+	return nil
+}
+
+// writeJSON writes the given value of the named component into a file of the export directory.
+func (br *bugReportArgs) writeJSON(exportDir, name, file string, v interface{}) *bugReportComponent {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err == nil {
+		err = os.WriteFile(filepath.Join(exportDir, file), append(data, '\n'), 0o644)
+	}
+	if err != nil {
+		return &bugReportComponent{Name: name, Reason: err.Error()}
+	}
+	return &bugReportComponent{Name: name, Collected: true, Path: file}
+}
+
+// writeConfig writes the configuration view, with the source of each value, into config.yml. The
+// warnings of the view, e.g. a kubeconfig that can't be read, are recorded in the manifest.
+func (br *bugReportArgs) writeConfig(ctx context.Context, exportDir string) *bugReportComponent {
+	const file = "config.yml"
+	c := &bugReportComponent{Name: "config"}
+	out := &bytes.Buffer{}
+	errOut := &bytes.Buffer{}
+	err := writeConfigView(ctx, out, errOut, connectorKubeFlagMap(ctx), "yaml", true)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(exportDir, file), out.Bytes(), 0o644)
+	}
+	if err != nil {
+		c.Reason = err.Error()
+		return c
+	}
+	for _, w := range strings.Split(strings.TrimSpace(errOut.String()), "\n") {
+		if w != "" {
+			c.Warnings = append(c.Warnings, w)
+		}
+	}
+	c.Collected = true
+	c.Path = file
+	return c
+}
+
+// copyDaemonLogs copies the log files of the daemons into the logs directory.
+func (br *bugReportArgs) copyDaemonLogs(ctx context.Context, exportDir string) *bugReportComponent {
+	const dir = "logs"
+	c := &bugReportComponent{Name: "daemon logs"}
+	logDir, err := filelocation.AppUserLogDir(ctx)
+	if err != nil {
+		c.Reason = err.Error()
+		return c
+	}
+	entries, err := os.ReadDir(logDir)
+	if err != nil {
+		c.Reason = err.Error()
+		return c
+	}
+	if err = os.MkdirAll(filepath.Join(exportDir, dir), 0o755); err != nil {
+		c.Reason = err.Error()
+		return c
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !(strings.Contains(name, "connector") || strings.Contains(name, "daemon")) {
+			continue
+		}
+		if err := copyFiles(filepath.Join(exportDir, dir, name), filepath.Join(logDir, name)); err != nil {
+			c.Warnings = append(c.Warnings, fmt.Sprintf("failed exporting %s: %v", name, err))
+			continue
+		}
+		c.Collected = true
+	}
+	if !c.Collected {
+		c.Reason = "no daemon logs found in " + logDir
+		return c
+	}
+	c.Path = dir
+	return c
+}
+
+// gatherClusterLogs gathers the logs of the traffic-manager and the traffic-agents into the cluster
+// directory, with the pod and namespace names anonymized.
+func (br *bugReportArgs) gatherClusterLogs(ctx context.Context, exportDir string, anonymizer *anonymizer) []*bugReportComponent {
+	const dir = "cluster"
+	mgr := &bugReportComponent{Name: "traffic-manager logs"}
+	agents := &bugReportComponent{Name: "traffic-agent logs"}
+	components := []*bugReportComponent{mgr, agents}
+	if br.noCluster {
+		mgr.Reason = "--no-cluster"
+		agents.Reason = "--no-cluster"
+		return components
+	}
+	gl := &gatherLogsArgs{trafficManager: true, trafficAgents: "all", since: br.since, anon: true}
+	cl, err := newBugReportClusterLogs(gl, ctx, anonymizer)
+	if err != nil {
+		mgr.Reason = skipReason(err)
+		agents.Reason = mgr.Reason
+		return components
+	}
+	gather := func(c *bugReportComponent, f func(context.Context, string) error) {
+		if err := f(ctx, exportDir); err != nil {
+			c.Reason = skipReason(err)
+			return
+		}
+		c.Collected = true
+		c.Path = dir
+	}
+	gather(mgr, cl.gatherManagerLogs)
+	gather(agents, cl.gatherAgentLogs)
+	return components
+}
+
+// skipReason returns the reason that a component is skipped because of the given error. Access that the
+// cluster denies is reported as "RBAC", since it's resolved by granting the missing permissions.
+func skipReason(err error) string {
+	if k8serrors.IsForbidden(err) {
+		return "RBAC"
+	}
+	return err.Error()
+}
+
+// writeSummary prints what was collected, and why the other components were skipped.
+func (m *bugReportManifest) writeSummary(out io.Writer) {
+	var collected []string
+	for _, c := range m.Components {
+		if c.Collected {
+			collected = append(collected, c.Name)
+		}
+	}
+	if len(collected) > 0 {
+		fmt.Fprintf(out, "Collected: %s\n", strings.Join(collected, ", "))
+	}
+	for _, c := range m.Components {
+		if !c.Collected {
+			fmt.Fprintf(out, "%s skipped: %s\n", c.Name, c.Reason)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package cli
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+func Test_bugReport(t *testing.T) {
+	ctx := client.WithEnv(dlog.NewTestContext(t, false), &client.Env{ScoutDisable: "1"})
+	cfg := client.GetDefaultConfig(ctx)
+	ctx = client.WithConfig(ctx, &cfg)
+	ctx = filelocation.WithAppUserConfigDir(ctx, t.TempDir())
+	ctx = filelocation.WithUserHomeDir(ctx, t.TempDir())
+	ctx = filelocation.WithAppUserLogDir(ctx, "testdata/testLogDir")
+	t.Setenv("KUBECONFIG", filepath.Join(t.TempDir(), "missing"))
+
+	// A cluster where the pods of the traffic-manager's namespace can't be listed
+	ki := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "echo-easy-867b648b88-zjsp2", Namespace: "echo-ns"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "echo"}, {Name: install.AgentContainerName}}},
+	})
+	ki.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() == "ambassador" {
+			return true, nil, k8serrors.NewForbidden(corev1.Resource("pods"), "", errors.New("RBAC denied"))
+		}
+		return false, nil, nil
+	})
+	oldStreamPodLogs := streamPodLogs
+	streamPodLogs = func(_ context.Context, _ typedcorev1.PodInterface, name string, opts *corev1.PodLogOptions) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(fmt.Sprintf("log of %s/%s", name, opts.Container))), nil
+	}
+	defer func() { streamPodLogs = oldStreamPodLogs }()
+
+	withFakeCluster := func(t *testing.T, err error) {
+		old := newBugReportClusterLogs
+		newBugReportClusterLogs = func(gl *gatherLogsArgs, _ context.Context, anonymizer *anonymizer) (*clusterLogs, error) {
+			if err != nil {
+				return nil, err
+			}
+			return &clusterLogs{
+				pods:             ki.CoreV1(),
+				managerNamespace: "ambassador",
+				trafficManager:   gl.trafficManager,
+				agents:           parseTrafficAgents(gl.trafficAgents),
+				anonymize:        gl.anon,
+				anonymizer:       anonymizer,
+			}, nil
+		}
+		t.Cleanup(func() { newBugReportClusterLogs = old })
+	}
+
+	// bugReport creates a report and returns its manifest, the contents of its files, and the summary.
+	bugReport := func(t *testing.T, ctx context.Context, noCluster bool) (*bugReportManifest, map[string]string, string) {
+		br := &bugReportArgs{outputFile: filepath.Join(t.TempDir(), "report.zip"), noCluster: noCluster}
+		out := &bytes.Buffer{}
+		require.NoError(t, br.bugReport(ctx, out))
+
+		zipReader, err := zip.OpenReader(br.outputFile)
+		require.NoError(t, err)
+		defer zipReader.Close()
+		contents := make(map[string]string)
+		for _, f := range zipReader.File {
+			content, err := ReadZip(f)
+			require.NoError(t, err)
+			contents[f.Name] = string(content)
+		}
+		var manifest bugReportManifest
+		require.NoError(t, json.Unmarshal([]byte(contents["manifest.json"]), &manifest))
+		assert.NotEmpty(t, manifest.ClientVersion)
+		assert.Equal(t, !noCluster, manifest.Cluster)
+		return &manifest, contents, out.String()
+	}
+
+	// checkManifest asserts that the manifest has the expected components, and that the path of each
+	// collected component exists in the archive.
+	checkManifest := func(t *testing.T, manifest *bugReportManifest, contents map[string]string, expected map[string]string) {
+		names := make([]string, len(manifest.Components))
+		for i, c := range manifest.Components {
+			names[i] = c.Name
+			reason, ok := expected[c.Name]
+			require.True(t, ok, "unexpected component %s", c.Name)
+			if reason == "" {
+				assert.True(t, c.Collected, c.Name)
+				found := false
+				for name := range contents {
+					if name == c.Path || strings.HasPrefix(name, c.Path+"/") {
+						found = true
+						break
+					}
+				}
+				assert.True(t, found, "the archive has no %s for %s", c.Path, c.Name)
+			} else {
+				assert.False(t, c.Collected, c.Name)
+				assert.Equal(t, reason, c.Reason, c.Name)
+			}
+		}
+		assert.Equal(t, []string{
+			"version", "status", "network", "config", "daemon logs", "traffic-manager logs", "traffic-agent logs",
+		}, names)
+	}
+
+	t.Run("local only", func(t *testing.T) {
+		withFakeDaemon(t, nil)
+		withFakeConnector(t, nil)
+		withFakeCluster(t, nil)
+
+		manifest, contents, summary := bugReport(t, ctx, true)
+		checkManifest(t, manifest, contents, map[string]string{
+			"version":              "",
+			"status":               "",
+			"network":              "the root daemon is not running",
+			"config":               "",
+			"daemon logs":          "",
+			"traffic-manager logs": "--no-cluster",
+			"traffic-agent logs":   "--no-cluster",
+		})
+		assert.Contains(t, contents, "logs/connector.log")
+		assert.Contains(t, contents, "logs/daemon.log")
+		for name := range contents {
+			assert.False(t, strings.HasPrefix(name, "cluster/"), name)
+		}
+
+		var vi versionInfo
+		require.NoError(t, json.Unmarshal([]byte(contents["version.json"]), &vi))
+		assert.False(t, vi.RootDaemon.Running)
+		assert.False(t, vi.UserDaemon.Running)
+		var si statusInfo
+		require.NoError(t, json.Unmarshal([]byte(contents["status.json"]), &si))
+		assert.Equal(t, "not_running", si.State)
+
+		assert.Contains(t, summary, "Collected: version, status, config, daemon logs\n")
+		assert.Contains(t, summary, "traffic-manager logs skipped: --no-cluster\n")
+	})
+
+	t.Run("traffic-manager logs denied", func(t *testing.T) {
+		withFakeDaemon(t, &statusRootDaemon{})
+		withFakeConnector(t, &statusUserDaemon{status: &connector.ConnectInfo{Error: connector.ConnectInfo_ALREADY_CONNECTED, ClusterContext: "default"}})
+		withFakeCluster(t, nil)
+
+		manifest, contents, summary := bugReport(t, ctx, false)
+		checkManifest(t, manifest, contents, map[string]string{
+			"version":              "",
+			"status":               "",
+			"network":              "",
+			"config":               "",
+			"daemon logs":          "",
+			"traffic-manager logs": "RBAC",
+			"traffic-agent logs":   "",
+		})
+		assert.Contains(t, contents, "network.json")
+		assert.Contains(t, summary, "traffic-manager logs skipped: RBAC\n")
+
+		// The names of pods and namespaces are anonymized throughout
+		assert.Equal(t, "log of pod-1/traffic-agent", contents["cluster/namespace-1/pod-1/traffic-agent.log"])
+		for name, content := range contents {
+			assert.NotContains(t, name, "echo-ns")
+			assert.NotContains(t, content, "echo-easy", name)
+			assert.NotContains(t, content, "echo-ns", name)
+		}
+	})
+
+	t.Run("cluster unavailable", func(t *testing.T) {
+		withFakeDaemon(t, &statusRootDaemon{})
+		withFakeConnector(t, nil)
+		withFakeCluster(t, errors.New("no kubeconfig"))
+
+		manifest, contents, summary := bugReport(t, ctx, false)
+		checkManifest(t, manifest, contents, map[string]string{
+			"version":              "",
+			"status":               "",
+			"network":              "",
+			"config":               "",
+			"daemon logs":          "",
+			"traffic-manager logs": "no kubeconfig",
+			"traffic-agent logs":   "no kubeconfig",
+		})
+		assert.Contains(t, summary, "traffic-agent logs skipped: no kubeconfig\n")
+	})
+
+	t.Run("daemon logs missing", func(t *testing.T) {
+		withFakeDaemon(t, nil)
+		withFakeConnector(t, nil)
+		logDir := t.TempDir()
+
+		manifest, contents, _ := bugReport(t, filelocation.WithAppUserLogDir(ctx, logDir), true)
+		checkManifest(t, manifest, contents, map[string]string{
+			"version":              "",
+			"status":               "",
+			"network":              "the root daemon is not running",
+			"config":               "",
+			"daemon logs":          "no daemon logs found in " + logDir,
+			"traffic-manager logs": "--no-cluster",
+			"traffic-agent logs":   "--no-cluster",
+		})
+	})
+
+	t.Run("output file", func(t *testing.T) {
+		br := &bugReportArgs{outputFile: "report.tar", noCluster: true}
+		assert.Error(t, br.bugReport(ctx, io.Discard))
+	})
+
+}
@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/actions"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/scout"
+)
+
+// unknownClusterID is the ID that the connector reports when it was unable to get the cluster ID.
+const unknownClusterID = "00000000-0000-0000-0000-000000000000"
+
+type clusterIDInfo struct {
+	ClusterID          string `json:"cluster_id"`
+	Context            string `json:"context"`
+	Namespace          string `json:"namespace"`
+	TelemetryClusterID string `json:"telemetry_cluster_id"`
+	InstallID          string `json:"install_id"`
+}
+
+// withStartedConnector and newKubeInterface are variables so that tests can fake the connector and
+// the cluster.
+var (
+	withStartedConnector = cliutil.WithStartedConnector
+	newKubeInterface     = func(configFlags *kates.ConfigFlags) (kubernetes.Interface, error) {
+		restConfig, err := configFlags.ToRESTConfig()
+		if err != nil {
+			return nil, err
+		}
+		return kubernetes.NewForConfig(restConfig)
+	}
+)
+
+// ClusterIdCommand is a simple command that makes it easier for users to figure out what their
+// cluster ID is. It's used when people are making licenses for air-gapped environments, and in
+// support conversations, where the anonymized IDs that telemetry reports use are of interest.
+func ClusterIdCommand() *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:  "current-cluster-id",
+		Args: cobra.NoArgs,
+
+		Short: "Get cluster ID for your kubernetes cluster",
+		Long: "Get cluster ID for your kubernetes cluster, mostly used for licenses in air-gapped environments. " +
+			"The ID is the one that the traffic-manager uses. It's obtained from the user daemon when it's " +
+			"connected to the cluster, and from the cluster given by the kubernetes flags otherwise. " +
+			"The JSON output also contains the anonymized cluster ID and the install ID that are used in telemetry reports.",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if output != "" && output != "json" {
+				return errcat.User.Newf("unsupported output format %q", output)
+			}
+			ctx := cmd.Context()
+			info, err := connectedClusterID(ctx)
+			if err != nil {
+				return err
+			}
+			if info == nil {
+				if info, err = clusterIDFromKubeconfig(ctx, kubeConfig, output == "json"); err != nil {
+					return err
+				}
+			}
+			if output == "json" {
+				info.InstallID = scout.NewScout(ctx, "cli").InstallID(ctx)
+			}
+			return info.write(cmd.OutOrStdout(), output)
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", `Set the output format. The only supported format is "json"`)
+	return cmd
+}
+
+// connectedClusterID returns the cluster ID of the session of the user daemon, or nil when the user
+// daemon isn't connected to the cluster given by the kubernetes flags.
+func connectedClusterID(ctx context.Context) (*clusterIDInfo, error) {
+	var info *clusterIDInfo
+	err := withStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+		ci, err := connectorClient.Status(ctx, &connector.ConnectRequest{KubeFlags: connectorKubeFlagMap(ctx)})
+		if err != nil {
+			return err
+		}
+		if ci.Error != connector.ConnectInfo_ALREADY_CONNECTED {
+			return nil
+		}
+		if ci.ClusterId == "" || ci.ClusterId == unknownClusterID {
+			return errors.New("the user daemon was unable to get the cluster ID, see the connector.log for details")
+		}
+		info = &clusterIDInfo{
+			ClusterID:          ci.ClusterId,
+			Context:            ci.ClusterContext,
+			Namespace:          ci.ClusterNamespace,
+			TelemetryClusterID: ci.TelemetryClusterId,
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, cliutil.ErrNoConnector) {
+		return nil, err
+	}
+	return info, nil
+}
+
+// clusterIDFromKubeconfig returns the cluster ID of the cluster of the context given by the kubernetes
+// flags, using a client of its own. The telemetry cluster ID, which requires access to the
+// "kube-system" namespace, is only included when withTelemetry is true.
+func clusterIDFromKubeconfig(ctx context.Context, configFlags *kates.ConfigFlags, withTelemetry bool) (*clusterIDInfo, error) {
+	loader := configFlags.ToRawKubeConfigLoader()
+	config, err := loader.RawConfig()
+	if err != nil {
+		return nil, err
+	}
+	info := &clusterIDInfo{Context: config.CurrentContext}
+	if configFlags.Context != nil && *configFlags.Context != "" {
+		info.Context = *configFlags.Context
+	}
+	if info.Namespace, _, err = loader.Namespace(); err != nil {
+		return nil, err
+	}
+	ki, err := newKubeInterface(configFlags)
+	if err != nil {
+		return nil, err
+	}
+	if info.ClusterID, err = namespaceUID(ctx, ki, "default"); err != nil {
+		return nil, err
+	}
+	if withTelemetry {
+		uid, err := namespaceUID(ctx, ki, "kube-system")
+		if err != nil {
+			return nil, err
+		}
+		info.TelemetryClusterID = actions.AnonymizeClusterID(uid)
+	}
+	return info, nil
+}
+
+// namespaceUID returns the UID of the given namespace. The cluster ID is the UID of the "default"
+// namespace, just like in the traffic-manager.
+func namespaceUID(ctx context.Context, ki kubernetes.Interface, name string) (string, error) {
+	ns, err := ki.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("unable to get the %q namespace: %w", name, err)
+	}
+	return string(ns.UID), nil
+}
+
+// write prints the cluster ID, or when the output is "json", the cluster ID, the context and namespace
+// that it was obtained from, and the IDs used in telemetry reports.
+func (info *clusterIDInfo) write(out io.Writer, output string) error {
+	if output != "json" {
+		_, err := fmt.Fprintln(out, info.ClusterID)
+		return err
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(info)
+}
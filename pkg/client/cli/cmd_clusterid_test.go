@@ -0,0 +1,211 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/actions"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+)
+
+const (
+	defaultNsUID    = "6a3ab2de-4c5f-4b8e-9c1d-2e0f6b7a8c90"
+	kubeSystemNsUID = "0d7c7a1e-5b2f-4c3d-8e9f-a1b2c3d4e5f6"
+)
+
+// fakeCluster returns a clientset with the given namespaces, each with a UID.
+func fakeCluster(uids map[string]string) *fake.Clientset {
+	cs := fake.NewSimpleClientset()
+	for name, uid := range uids {
+		_, _ = cs.CoreV1().Namespaces().Create(context.Background(), &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: name, UID: types.UID(uid)},
+		}, metav1.CreateOptions{})
+	}
+	return cs
+}
+
+// clusterIDConnector is a user daemon that reports a session with the given cluster.
+type clusterIDConnector struct {
+	connector.ConnectorClient
+	cluster kubernetes.Interface
+	status  connector.ConnectInfo_ErrType
+}
+
+func (c *clusterIDConnector) Status(ctx context.Context, _ *connector.ConnectRequest, _ ...grpc.CallOption) (*connector.ConnectInfo, error) {
+	if c.status != connector.ConnectInfo_ALREADY_CONNECTED {
+		return &connector.ConnectInfo{Error: c.status}, nil
+	}
+	// Like the connector, which reports a zero ID when it can't get the namespace
+	id, err := namespaceUID(ctx, c.cluster, "default")
+	if err != nil {
+		id = unknownClusterID
+	}
+	return &connector.ConnectInfo{
+		Error:              c.status,
+		ClusterContext:     "connected-ctx",
+		ClusterNamespace:   "connected-ns",
+		ClusterId:          id,
+		TelemetryClusterId: actions.AnonymizeClusterID(kubeSystemNsUID),
+	}, nil
+}
+
+func withFakeConnector(t *testing.T, cc connector.ConnectorClient) {
+	oldWith, oldFlags := withStartedConnector, kubeFlags
+	kubeFlags = pflag.NewFlagSet("", 0)
+	withStartedConnector = func(ctx context.Context, fn func(context.Context, connector.ConnectorClient) error) error {
+		if cc == nil {
+			return cliutil.ErrNoConnector
+		}
+		return fn(ctx, cc)
+	}
+	t.Cleanup(func() { withStartedConnector, kubeFlags = oldWith, oldFlags })
+}
+
+func Test_connectedClusterID(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	t.Run("connected", func(t *testing.T) {
+		withFakeConnector(t, &clusterIDConnector{
+			cluster: fakeCluster(map[string]string{"default": defaultNsUID}),
+			status:  connector.ConnectInfo_ALREADY_CONNECTED,
+		})
+		info, err := connectedClusterID(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, &clusterIDInfo{
+			ClusterID:          defaultNsUID,
+			Context:            "connected-ctx",
+			Namespace:          "connected-ns",
+			TelemetryClusterID: actions.AnonymizeClusterID(kubeSystemNsUID),
+		}, info)
+	})
+	t.Run("connected without access", func(t *testing.T) {
+		withFakeConnector(t, &clusterIDConnector{
+			cluster: fakeCluster(nil),
+			status:  connector.ConnectInfo_ALREADY_CONNECTED,
+		})
+		_, err := connectedClusterID(ctx)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unable to get the cluster ID")
+	})
+	t.Run("not connected", func(t *testing.T) {
+		withFakeConnector(t, &clusterIDConnector{status: connector.ConnectInfo_DISCONNECTED})
+		info, err := connectedClusterID(ctx)
+		require.NoError(t, err)
+		assert.Nil(t, info)
+	})
+	t.Run("connected to another context", func(t *testing.T) {
+		withFakeConnector(t, &clusterIDConnector{status: connector.ConnectInfo_MUST_RESTART})
+		info, err := connectedClusterID(ctx)
+		require.NoError(t, err)
+		assert.Nil(t, info)
+	})
+	t.Run("no user daemon", func(t *testing.T) {
+		withFakeConnector(t, nil)
+		info, err := connectedClusterID(ctx)
+		require.NoError(t, err)
+		assert.Nil(t, info)
+	})
+}
+
+func Test_clusterIDFromKubeconfig(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: c1
+  cluster:
+    server: https://c1.example.com
+contexts:
+- name: ctx1
+  context:
+    cluster: c1
+    namespace: ns1
+- name: ctx2
+  context:
+    cluster: c1
+current-context: ctx1
+`), 0600))
+
+	withCluster := func(t *testing.T, uids map[string]string) {
+		old := newKubeInterface
+		newKubeInterface = func(*kates.ConfigFlags) (kubernetes.Interface, error) {
+			return fakeCluster(uids), nil
+		}
+		t.Cleanup(func() { newKubeInterface = old })
+	}
+	configFlags := func(context string) *kates.ConfigFlags {
+		cf := kates.NewConfigFlags(false)
+		cf.KubeConfig = &kubeconfig
+		cf.Context = &context
+		return cf
+	}
+
+	t.Run("current context", func(t *testing.T) {
+		withCluster(t, map[string]string{"default": defaultNsUID, "kube-system": kubeSystemNsUID})
+		info, err := clusterIDFromKubeconfig(ctx, configFlags(""), true)
+		require.NoError(t, err)
+		assert.Equal(t, &clusterIDInfo{
+			ClusterID:          defaultNsUID,
+			Context:            "ctx1",
+			Namespace:          "ns1",
+			TelemetryClusterID: actions.AnonymizeClusterID(kubeSystemNsUID),
+		}, info)
+	})
+	t.Run("context flag", func(t *testing.T) {
+		withCluster(t, map[string]string{"default": defaultNsUID})
+		info, err := clusterIDFromKubeconfig(ctx, configFlags("ctx2"), false)
+		require.NoError(t, err)
+		assert.Equal(t, &clusterIDInfo{ClusterID: defaultNsUID, Context: "ctx2", Namespace: "default"}, info)
+	})
+	t.Run("no access to kube-system", func(t *testing.T) {
+		withCluster(t, map[string]string{"default": defaultNsUID})
+		_, err := clusterIDFromKubeconfig(ctx, configFlags(""), true)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `unable to get the "kube-system" namespace`)
+	})
+	t.Run("no access to default", func(t *testing.T) {
+		withCluster(t, nil)
+		_, err := clusterIDFromKubeconfig(ctx, configFlags(""), false)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `unable to get the "default" namespace`)
+	})
+}
+
+func TestClusterIDInfo_write(t *testing.T) {
+	info := &clusterIDInfo{
+		ClusterID:          defaultNsUID,
+		Context:            "ctx1",
+		Namespace:          "ns1",
+		TelemetryClusterID: "abc",
+		InstallID:          "def",
+	}
+	out := &bytes.Buffer{}
+	require.NoError(t, info.write(out, ""))
+	assert.Equal(t, defaultNsUID+"\n", out.String())
+
+	out.Reset()
+	require.NoError(t, info.write(out, "json"))
+	assert.JSONEq(t, `{
+  "cluster_id": "`+defaultNsUID+`",
+  "context": "ctx1",
+  "namespace": "ns1",
+  "telemetry_cluster_id": "abc",
+  "install_id": "def"
+}`, out.String())
+}
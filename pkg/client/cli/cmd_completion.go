@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// completionTimeout limits the time that a completion may spend querying the user daemon, so that a
+// daemon that is slow to respond never hangs the shell. It's a variable so that tests can shorten it.
+var completionTimeout = 2 * time.Second
+
+func completionCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:       "completion {bash|zsh|fish|powershell}",
+		Args:      cobra.ExactValidArgs(1),
+		ValidArgs: []string{"bash", "zsh", "fish", "powershell"},
+
+		Short: "Generate a shell completion script",
+		Long: `Generate a shell completion script
+
+The script completes the commands and flags of telepresence, and also the names of workloads, active
+intercepts, installed agents, and mapped namespaces when the user daemon is connected to a cluster.
+
+To load the completions of the current shell session:
+
+  bash:       source <(telepresence completion bash)
+  zsh:        source <(telepresence completion zsh)
+  fish:       telepresence completion fish | source
+  powershell: telepresence completion powershell | Out-String | Invoke-Expression
+
+To load them in every session, write the script to the completion directory of the shell, e.g.
+/etc/bash_completion.d/telepresence, a directory in the $fpath as _telepresence, or
+~/.config/fish/completions/telepresence.fish.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root := cmd.Root()
+			out := cmd.OutOrStdout()
+			switch args[0] {
+			case "bash":
+				return root.GenBashCompletion(out)
+			case "zsh":
+				return root.GenZshCompletion(out)
+			case "fish":
+				return root.GenFishCompletion(out, true)
+			case "powershell":
+				return root.GenPowerShellCompletionWithDesc(out)
+			}
+			return errcat.User.Newf("unsupported shell %q", args[0])
+		},
+	}
+}
+
+// completeFromConnector returns the candidates that the given function obtains from a running user daemon
+// and that start with toComplete. No candidates are returned when the user daemon isn't running, when it
+// fails, or when it doesn't respond within the completionTimeout. Completion never starts a daemon.
+func completeFromConnector(
+	cmd *cobra.Command,
+	toComplete string,
+	exclude []string,
+	f func(context.Context, connector.ConnectorClient) ([]string, error),
+) ([]string, cobra.ShellCompDirective) {
+	// Cobra doesn't pass the context on to the command that is completed
+	ctx := cmd.Context()
+	if ctx == nil {
+		ctx = cmd.Root().Context()
+	}
+	ctx, cancel := context.WithTimeout(ctx, completionTimeout)
+	defer cancel()
+	var names []string
+	err := withStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) (err error) {
+		names, err = f(ctx, connectorClient)
+		return err
+	})
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	skip := make(map[string]bool, len(exclude))
+	for _, name := range exclude {
+		skip[name] = true
+	}
+	var candidates []string
+	for _, name := range names {
+		if !skip[name] && strings.HasPrefix(name, toComplete) {
+			skip[name] = true
+			candidates = append(candidates, name)
+		}
+	}
+	return candidates, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeWorkloads returns a cobra.ValidArgsFunction that completes the names of the workloads that the
+// given filter lists in the namespace of the given flag. Names that are already given are not repeated.
+// The first maxArgs arguments are completed, or all of them when maxArgs is zero. Arguments that follow
+// are completed by the shell.
+func completeWorkloads(filter connector.ListRequest_Filter, namespace *string, maxArgs int) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if maxArgs > 0 && len(args) >= maxArgs {
+			return nil, cobra.ShellCompDirectiveDefault
+		}
+		return completeFromConnector(cmd, toComplete, args, func(ctx context.Context, connectorClient connector.ConnectorClient) ([]string, error) {
+			r, err := connectorClient.List(ctx, &connector.ListRequest{Filter: filter, Namespace: *namespace, Context: sessionContext()})
+			if err != nil {
+				return nil, err
+			}
+			names := make([]string, len(r.Workloads))
+			for i, wl := range r.Workloads {
+				names[i] = wl.Name
+			}
+			return names, nil
+		})
+	}
+}
+
+// completeIntercepts completes the names of the active intercepts of this client.
+func completeIntercepts(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completeFromConnector(cmd, toComplete, args, func(ctx context.Context, connectorClient connector.ConnectorClient) ([]string, error) {
+		ci, err := connectorClient.Status(ctx, &connector.ConnectRequest{KubeFlags: connectorKubeFlagMap(ctx)})
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, ii := range ci.GetIntercepts().GetIntercepts() {
+			names = append(names, ii.Spec.Name)
+		}
+		return names, nil
+	})
+}
+
+// completeNamespaces completes a namespace flag with the namespaces that the user daemon maps. Nothing is
+// completed when all namespaces are mapped, because the user daemon doesn't enumerate them.
+func completeNamespaces(cmd *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completeFromConnector(cmd, toComplete, nil, func(ctx context.Context, connectorClient connector.ConnectorClient) ([]string, error) {
+		ci, err := connectorClient.Status(ctx, &connector.ConnectRequest{KubeFlags: connectorKubeFlagMap(ctx)})
+		if err != nil {
+			return nil, err
+		}
+		return ci.MappedNamespaces, nil
+	})
+}
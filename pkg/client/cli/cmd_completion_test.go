@@ -0,0 +1,211 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// completionConnector is a user daemon that lists the workloads of each namespace, an empty namespace
+// being the connected one, and that has the given intercepts and mapped namespaces. A hung user daemon
+// doesn't respond until the call is cancelled.
+type completionConnector struct {
+	connector.ConnectorClient
+	interceptable    map[string][]string
+	agents           map[string][]string
+	intercepts       []string
+	mappedNamespaces []string
+	hung             bool
+}
+
+func (c *completionConnector) List(ctx context.Context, lr *connector.ListRequest, _ ...grpc.CallOption) (*connector.WorkloadInfoSnapshot, error) {
+	if c.hung {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	names := c.interceptable[lr.Namespace]
+	if lr.Filter == connector.ListRequest_INSTALLED_AGENTS {
+		names = c.agents[lr.Namespace]
+	}
+	wls := make([]*connector.WorkloadInfo, len(names))
+	for i, name := range names {
+		wls[i] = &connector.WorkloadInfo{Name: name}
+	}
+	return &connector.WorkloadInfoSnapshot{Workloads: wls}, nil
+}
+
+func (c *completionConnector) Status(ctx context.Context, _ *connector.ConnectRequest, _ ...grpc.CallOption) (*connector.ConnectInfo, error) {
+	if c.hung {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	iis := make([]*manager.InterceptInfo, len(c.intercepts))
+	for i, name := range c.intercepts {
+		iis[i] = &manager.InterceptInfo{Spec: &manager.InterceptSpec{Name: name}}
+	}
+	return &connector.ConnectInfo{
+		Error:            connector.ConnectInfo_ALREADY_CONNECTED,
+		Intercepts:       &manager.InterceptInfoSnapshot{Intercepts: iis},
+		MappedNamespaces: c.mappedNamespaces,
+	}, nil
+}
+
+// complete returns the candidates and the directive that the shell gets when it completes the given
+// command line, the last argument being the word to complete.
+func complete(ctx context.Context, t *testing.T, args ...string) ([]string, string) {
+	t.Helper()
+	root := &cobra.Command{Use: "telepresence"}
+	root.AddCommand(interceptCommand(ctx), leaveCommand(), uninstallCommand(), listCommand())
+	out := &bytes.Buffer{}
+	root.SetOut(out)
+	root.SetErr(&bytes.Buffer{})
+	root.SetArgs(append([]string{cobra.ShellCompRequestCmd}, args...))
+	require.NoError(t, root.ExecuteContext(ctx))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	last := len(lines) - 1
+	require.True(t, strings.HasPrefix(lines[last], ":"), "no directive in %q", out.String())
+	var candidates []string
+	if last > 0 {
+		candidates = lines[:last]
+	}
+	return candidates, lines[last]
+}
+
+func Test_completion(t *testing.T) {
+	ctx := client.WithEnv(dlog.NewTestContext(t, false), &client.Env{ScoutDisable: "1"})
+	cfg := client.GetDefaultConfig(ctx)
+	ctx = client.WithConfig(ctx, &cfg)
+	ctx = filelocation.WithAppUserConfigDir(ctx, t.TempDir())
+
+	cc := &completionConnector{
+		interceptable: map[string][]string{
+			"":     {"echo-easy", "echo-server", "web"},
+			"blue": {"blue-echo", "blue-web"},
+		},
+		agents: map[string][]string{
+			"":     {"echo-server", "web"},
+			"blue": {"blue-web"},
+		},
+		intercepts:       []string{"echo-server", "web-blue", "web-green"},
+		mappedNamespaces: []string{"blue", "default", "green"},
+	}
+	const (
+		noFiles = ":4"
+		files   = ":0"
+	)
+	tests := []struct {
+		name               string
+		args               []string
+		expectedCandidates []string
+		expectedDirective  string
+	}{
+		{
+			name:               "intercept",
+			args:               []string{"intercept", ""},
+			expectedCandidates: []string{"echo-easy", "echo-server", "web"},
+			expectedDirective:  noFiles,
+		},
+		{
+			name:               "intercept prefix",
+			args:               []string{"intercept", "ech"},
+			expectedCandidates: []string{"echo-easy", "echo-server"},
+			expectedDirective:  noFiles,
+		},
+		{
+			name:               "intercept in namespace",
+			args:               []string{"intercept", "--namespace", "blue", ""},
+			expectedCandidates: []string{"blue-echo", "blue-web"},
+			expectedDirective:  noFiles,
+		},
+		{
+			name:              "intercept command",
+			args:              []string{"intercept", "web", "--", ""},
+			expectedDirective: files,
+		},
+		{
+			name:               "intercept namespace flag",
+			args:               []string{"intercept", "-n", "g"},
+			expectedCandidates: []string{"green"},
+			expectedDirective:  noFiles,
+		},
+		{
+			name:               "list namespace flag",
+			args:               []string{"list", "--namespace", ""},
+			expectedCandidates: []string{"blue", "default", "green"},
+			expectedDirective:  noFiles,
+		},
+		{
+			name:               "leave",
+			args:               []string{"leave", "web-blue", "web"},
+			expectedCandidates: []string{"web-green"},
+			expectedDirective:  noFiles,
+		},
+		{
+			name:              "leave all",
+			args:              []string{"leave", "--all", ""},
+			expectedDirective: noFiles,
+		},
+		{
+			name:               "uninstall agents",
+			args:               []string{"uninstall", "--agent", "web", ""},
+			expectedCandidates: []string{"echo-server"},
+			expectedDirective:  noFiles,
+		},
+		{
+			name:               "uninstall agents in namespace",
+			args:               []string{"uninstall", "--agent", "-n", "blue", ""},
+			expectedCandidates: []string{"blue-web"},
+			expectedDirective:  noFiles,
+		},
+		{
+			name:              "uninstall everything",
+			args:              []string{"uninstall", "--everything", ""},
+			expectedDirective: noFiles,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			withFakeConnector(t, cc)
+			candidates, directive := complete(ctx, t, tt.args...)
+			assert.Equal(t, tt.expectedCandidates, candidates)
+			assert.Equal(t, tt.expectedDirective, directive)
+		})
+	}
+
+	t.Run("no daemon", func(t *testing.T) {
+		withFakeConnector(t, nil)
+		for _, args := range [][]string{{"intercept", ""}, {"leave", ""}, {"uninstall", "--agent", ""}, {"list", "-n", ""}} {
+			candidates, directive := complete(ctx, t, args...)
+			assert.Empty(t, candidates, args)
+			assert.Equal(t, noFiles, directive, args)
+		}
+	})
+
+	t.Run("hung daemon", func(t *testing.T) {
+		withFakeConnector(t, &completionConnector{hung: true})
+		oldTimeout := completionTimeout
+		completionTimeout = 50 * time.Millisecond
+		t.Cleanup(func() { completionTimeout = oldTimeout })
+
+		start := time.Now()
+		candidates, directive := complete(ctx, t, "leave", "")
+		assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+		assert.Empty(t, candidates)
+		assert.Equal(t, noFiles, directive)
+	})
+}
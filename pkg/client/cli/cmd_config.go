@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_k8s"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+func configCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:  "config",
+		Args: OnlySubcommands,
+
+		Short: "Show or validate the configuration of the client",
+		RunE:  RunSubcommands,
+	}
+	cmd.AddCommand(configViewCommand(), configValidateCommand())
+	return cmd
+}
+
+func configViewCommand() *cobra.Command {
+	var output string
+	var withSources bool
+	cmd := &cobra.Command{
+		Use:  "view",
+		Args: cobra.NoArgs,
+
+		Short: "Show the effective configuration of the client",
+		Long: `Show the effective configuration of the client
+
+The configuration is the result of merging the defaults, the environment variables that change them, the
+config.yml files of the system and of the user, the environment variables that override the config files,
+the overrides of the config files that match the kube context, and the telepresence.io extension of the
+cluster in the kubeconfig. Only keys that have a value are shown.
+Values that look like secrets are masked. No daemon is needed.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if output != "" && output != "yaml" && output != "json" {
+				return errcat.User.Newf("unsupported output format %q", output)
+			}
+			return writeConfigView(cmd.Context(), cmd.OutOrStdout(), cmd.ErrOrStderr(), kubeFlagMap(), output, withSources)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&output, "output", "o", "yaml", `Set the output format, "yaml" or "json"`)
+	flags.BoolVar(&withSources, "source", false, "Show where each value comes from")
+	return cmd
+}
+
+// writeConfigView writes the effective configuration, including the overrides for the kube context that
+// the given kubernetes flags select and the kubeconfig extension of its cluster. The overrides that match
+// are listed on errOut, so that the output remains valid YAML or JSON. A kubeconfig that can't be read
+// only results in a warning, so that the configuration can be viewed on a workstation without a cluster.
+func writeConfigView(ctx context.Context, out, errOut io.Writer, flagMap map[string]string, output string, withSources bool) error {
+	layers, err := client.LoadConfigViewLayers(ctx)
+	if err != nil {
+		return err
+	}
+	kubeContext, ext, err := userd_k8s.ExtensionConfigLayer(flagMap)
+	if err != nil {
+		fmt.Fprintf(errOut, "Warning: the kubeconfig extension of the cluster is not included: %v\n", err)
+	}
+	if kubeContext != "" {
+		overrides := client.OverrideConfigLayers(layers, kubeContext)
+		for _, o := range overrides {
+			fmt.Fprintf(errOut, "Using %s for context %s\n", o.Source, kubeContext)
+		}
+		layers = append(layers, overrides...)
+	}
+	if ext != nil {
+		layers = append(layers, ext)
+	}
+
+	view := client.NewConfigView(layers)
+	var data []byte
+	if output == "json" {
+		if data, err = view.JSON(withSources); err == nil {
+			data = append(data, '\n')
+		}
+	} else {
+		data, err = view.YAML(withSources)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	return err
+}
+
+func configValidateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:  "validate [file]",
+		Args: cobra.MaximumNArgs(1),
+
+		Short: "Validate the config files of the client",
+		Long: `Validate the config files of the client
+
+The given file, or else each config.yml file of the system and of the user, is checked against the schema
+of the configuration. Unknown keys and values of the wrong type are errors. All problems are reported
+with the file, line, and column where they occur. Keys with the "x-" prefix are ignored, so they can be
+used for comments, YAML anchors, or the settings of other tools. No daemon is needed.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return validateConfigFiles(cmd.Context(), cmd.OutOrStdout(), args)
+		},
+	}
+}
+
+// validateConfigFiles validates the given config files, or the config files that the client reads when
+// none are given, and reports each file that is valid. The returned error describes the problems of all
+// files that are invalid.
+func validateConfigFiles(ctx context.Context, out io.Writer, files []string) error {
+	if len(files) == 0 {
+		var err error
+		if files, err = client.ConfigFiles(ctx); err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			fmt.Fprintln(out, "No config files found")
+			return nil
+		}
+	}
+	var problems []string
+	for _, file := range files {
+		if _, err := client.ParseConfigFile(ctx, file); err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		fmt.Fprintf(out, "%s: valid\n", file)
+	}
+	if len(problems) > 0 {
+		return errcat.Config.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/winservice"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+)
+
+func daemonServiceCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:  "daemon-service",
+		Args: OnlySubcommands,
+
+		Short: "Install or uninstall the root daemon as a Windows service",
+		Long: `Install or uninstall the root daemon as a Windows service.
+
+When the service is installed, the root daemon runs as a service rather than as a child of the terminal
+that started it, so it survives when that terminal is closed and no UAC prompt is shown when it starts.
+The service must be reinstalled when telepresence is upgraded.`,
+		RunE: RunSubcommands,
+	}
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "install",
+			Args:  cobra.NoArgs,
+			Short: "Install the root daemon as a Windows service, replacing a service that is already installed",
+			RunE: func(cmd *cobra.Command, _ []string) error {
+				if !proc.IsAdmin() {
+					return errcat.User.New("the service must be installed from an elevated prompt")
+				}
+				if err := winservice.Install(client.GetExe(), client.Version()); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Installed service %s\n", winservice.Name)
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "uninstall",
+			Args:  cobra.NoArgs,
+			Short: "Stop and uninstall the root daemon Windows service",
+			RunE: func(cmd *cobra.Command, _ []string) error {
+				if !proc.IsAdmin() {
+					return errcat.User.New("the service must be uninstalled from an elevated prompt")
+				}
+				if err := winservice.Uninstall(); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Uninstalled service %s\n", winservice.Name)
+				return nil
+			},
+		},
+	)
+	return cmd
+}
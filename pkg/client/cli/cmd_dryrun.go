@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/routing"
+	"github.com/telepresenceio/telepresence/v2/pkg/subnet"
+)
+
+// connectDryRun prints the local routes, the subnets that a connect would route to the cluster, and
+// the conflicts between them, without connecting. The subnets of a connected session are taken from
+// the root daemon. Without a session, only the also-proxy and never-proxy subnets of the config and
+// the command line are known.
+func connectDryRun(ctx context.Context, out io.Writer) error {
+	table, err := routing.GetRoutingTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	var subnets []*net.IPNet
+	var ownInterfaces []string
+	err = cliutil.WithStartedDaemon(ctx, func(ctx context.Context, daemonClient daemon.DaemonClient) error {
+		status, err := daemonClient.Status(ctx, &empty.Empty{})
+		if err != nil {
+			return err
+		}
+		for _, rs := range status.RoutedSubnets {
+			subnets = append(subnets, iputil.IPNetFromRPC(rs.Subnet))
+		}
+		for _, c := range status.SubnetConflicts {
+			if c.Severity == daemon.SubnetConflict_ERROR {
+				// Not routed because of the conflict
+				subnets = append(subnets, iputil.IPNetFromRPC(c.Subnet))
+			}
+		}
+		ownInterfaces = routing.InterfaceIdentifiers(status.TunName)
+		return nil
+	})
+
+	cfg := client.GetConfig(ctx)
+	switch {
+	case err == nil && len(subnets) > 0:
+		subnets = subnet.Unique(subnets)
+	case err == nil || errors.Is(err, cliutil.ErrNoDaemon):
+		fmt.Fprintln(out, "Not connected. The cluster's service and pod subnets are unknown until a connection is made.")
+		desired := append(configSubnets(cfg.AlsoProxy), alsoProxy...)
+		excluded := append(configSubnets(cfg.NeverProxy), neverProxy...)
+		subnets = subnet.SubtractAll(subnet.Unique(desired), excluded)
+	default:
+		return err
+	}
+
+	fmt.Fprintf(out, "Local routes (%d):\n", len(table))
+	for _, r := range table {
+		fmt.Fprintf(out, "  - %s\n", r)
+	}
+	fmt.Fprintf(out, "Subnets to route (%d):\n", len(subnets))
+	for _, sn := range subnets {
+		fmt.Fprintf(out, "  - %s\n", sn)
+	}
+	conflicts := routing.FindConflicts(table, subnets, configSubnets(cfg.AllowConflictingSubnets), ownInterfaces...)
+	if len(conflicts) == 0 {
+		fmt.Fprintln(out, "No conflicts found")
+		return nil
+	}
+	fmt.Fprintf(out, "Conflicts (%d):\n", len(conflicts))
+	for _, c := range conflicts {
+		fmt.Fprintf(out, "  - %s\n", c)
+	}
+	return nil
+}
+
+func configSubnets(sns []*iputil.Subnet) []*net.IPNet {
+	ipNets := make([]*net.IPNet, len(sns))
+	for i, sn := range sns {
+		ipNets[i] = (*net.IPNet)(sn)
+	}
+	return ipNets
+}
@@ -6,17 +6,16 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
-	"github.com/telepresenceio/telepresence/rpc/v2/connector"
-	"github.com/telepresenceio/telepresence/rpc/v2/manager"
-	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/scout"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
@@ -28,6 +27,7 @@ type gatherLogsArgs struct {
 	daemons        string
 	trafficAgents  string
 	trafficManager bool
+	since          time.Duration
 	anon           bool
 	podYaml        bool
 }
@@ -40,7 +40,11 @@ func gatherLogsCommand() *cobra.Command {
 		Short: "Gather logs from traffic-manager, traffic-agent, user and root daemons, and export them into a zip file.",
 		Long: `Gather logs from traffic-manager, traffic-agent, user and root daemons,
 and export them into a zip file. Useful if you are opening a Github issue or asking
-someone to help you debug Telepresence.`,
+someone to help you debug Telepresence.
+
+When connected, the logs of the traffic-manager and traffic-agents are streamed by the
+traffic-manager, which only includes the traffic-agents of workloads that can be intercepted.
+Otherwise, they are read using the pod log API of the cluster.`,
 		Example: `Here are a few examples of how you can use this command:
 # Get all logs and export to a given file
 telepresence gather-logs -o /tmp/telepresence_logs.zip
@@ -54,6 +58,9 @@ telepresence gather-logs --traffic-agents=None --traffic-manager=False
 # Get all logs for pods that have "echo-easy" in the name, useful if you have multiple replicas
 telepresence gather-logs --traffic-manager=False --traffic-agents=echo-easy
 
+# Get the last hour of logs for the traffic-agents of two workloads
+telepresence gather-logs --traffic-manager=False --traffic-agents=echo-easy,echo-auto-inject --since=1h
+
 # Get all logs for a specific pod
 telepresence gather-logs --traffic-manager=False --traffic-agents=echo-easy-6848967857-tw4jw     
 
@@ -62,14 +69,15 @@ telepresence gather-logs --daemons=None
 `,
 
 		RunE: func(cmd *cobra.Command, _ []string) error {
-			return gl.gatherLogs(cmd.Context(), cmd, cmd.OutOrStdout(), cmd.ErrOrStderr())
+			return gl.gatherLogs(cmd.Context(), cmd.OutOrStdout(), cmd.ErrOrStderr())
 		},
 	}
 	flags := cmd.Flags()
 	flags.StringVarP(&gl.outputFile, "output-file", "o", "", "The file you want to output the logs to.")
 	flags.StringVar(&gl.daemons, "daemons", "all", "The daemons you want logs from: all, root, user, None")
 	flags.BoolVar(&gl.trafficManager, "traffic-manager", true, "If you want to collect logs from the traffic-manager")
-	flags.StringVar(&gl.trafficAgents, "traffic-agents", "all", "Traffic-agents to collect logs from: all, name substring, None")
+	flags.StringVar(&gl.trafficAgents, "traffic-agents", "all", "Traffic-agents to collect logs from: all, comma separated list of workload names or name substrings, None")
+	flags.DurationVar(&gl.since, "since", 0, "Only collect cluster logs newer than a relative duration like 30s, 5m, or 3h")
 	flags.BoolVarP(&gl.anon, "anonymize", "a", false, "To anonymize pod names + namespaces from the logs")
 	flags.BoolVarP(&gl.podYaml, "get-pod-yaml", "y", false, "Get the yaml of any pods you are getting logs for")
 	return cmd
@@ -85,7 +93,7 @@ type anonymizer struct {
 }
 
 // gatherLogs gets the logs from the daemons (daemon + connector) and creates a zip
-func (gl *gatherLogsArgs) gatherLogs(ctx context.Context, cmd *cobra.Command, stdout, stderr io.Writer) error {
+func (gl *gatherLogsArgs) gatherLogs(ctx context.Context, stdout, stderr io.Writer) error {
 	scout := scout.NewScout(ctx, "cli")
 	// Get the log directory and return the error if we can't get it
 	logDir, err := filelocation.AppUserLogDir(ctx)
@@ -167,32 +175,13 @@ func (gl *gatherLogsArgs) gatherLogs(ctx context.Context, cmd *cobra.Command, st
 		}
 	}
 
-	// Since getting the logs from k8s requires the connector, let's only do this
-	// work if we know the user wants to get logs from k8s.
+	// Getting the logs from k8s requires a connection to the cluster, so let's only do
+	// this work if we know the user wants to get logs from k8s.
 	if gl.trafficManager || gl.trafficAgents != "None" {
-		// To get logs from the components in the kubernetes cluster, we ask the
-		// traffic-manager.
-		rq := &manager.GetLogsRequest{
-			TrafficManager: gl.trafficManager,
-			Agents:         gl.trafficAgents,
-			GetPodYaml:     gl.podYaml,
+		cl, err := gl.newClusterLogs(ctx, anonymizer)
+		if err == nil {
+			err = cl.gather(ctx, exportDir)
 		}
-		err = withConnector(cmd, false, func(ctx context.Context, connectorClient connector.ConnectorClient, connInfo *connector.ConnectInfo) error {
-			err = cliutil.WithManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
-				lr, err := managerClient.GetLogs(ctx, rq)
-				if err != nil {
-					return err
-				}
-				if err := writeResponseToFiles(lr, anonymizer, exportDir, gl.anon); err != nil {
-					return err
-				}
-				return nil
-			})
-			if err != nil {
-				return err
-			}
-			return nil
-		})
 		// We let the user know we were unable to get logs from the kubernetes components,
 		// and why, but this shouldn't block the command returning successful with the logs
 		// it was able to get.
@@ -202,28 +191,25 @@ func (gl *gatherLogsArgs) gatherLogs(ctx context.Context, cmd *cobra.Command, st
 	}
 
 	// Zip up all the files we've created in the zip directory and return that to the user
-	dirEntries, err := os.ReadDir(exportDir)
-	files := make([]string, len(dirEntries))
-	if err != nil {
-		return errcat.User.New(err)
-	}
-	for i, entry := range dirEntries {
-		if entry.IsDir() {
-			files = files[:len(files)-1]
-			continue
+	var files []string
+	err = filepath.WalkDir(exportDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
 		}
-
-		fullFileName := fmt.Sprintf("%s/%s", exportDir, entry.Name())
 		// anonymize the log if necessary
 		if gl.anon {
-			if err := anonymizeLog(stdout, fullFileName, anonymizer); err != nil {
-				fmt.Fprintf(stdout, "error anonymizing %s: %s\n", fullFileName, err)
+			if err := anonymizeLog(stdout, path, anonymizer); err != nil {
+				fmt.Fprintf(stdout, "error anonymizing %s: %s\n", path, err)
 			}
 		}
-		files[i] = fullFileName
+		files = append(files, path)
+		return nil
+	})
+	if err != nil {
+		return errcat.User.New(err)
 	}
 
-	if err := zipFiles(files, gl.outputFile); err != nil {
+	if err := zipFiles(files, exportDir, gl.outputFile); err != nil {
 		return errcat.User.New(err)
 	}
 
@@ -231,43 +217,6 @@ func (gl *gatherLogsArgs) gatherLogs(ctx context.Context, cmd *cobra.Command, st
 	return nil
 }
 
-// writeResponseToFiles contains the logic for parsing the response from the
-// manager and writing its components into logical files.
-func writeResponseToFiles(lr *manager.LogsResponse, anonymizer *anonymizer, exportDir string, anonymize bool) error {
-	createFileWithContent := func(fileName, content string) error {
-		fd, err := os.Create(fileName)
-		if err != nil {
-			return err
-		}
-		defer fd.Close()
-		fdWriter := bufio.NewWriter(fd)
-		_, err = fdWriter.WriteString(content)
-		if err != nil {
-			return err
-		}
-		fdWriter.Flush()
-		return nil
-	}
-	// Write the logs for each pod to files
-	for podName, log := range lr.PodLogs {
-		podName = getPodName(podName, anonymize, anonymizer)
-		agentLogFile := fmt.Sprintf("%s/%s.log", exportDir, podName)
-		if err := createFileWithContent(agentLogFile, log); err != nil {
-			return err
-		}
-	}
-
-	// Write the pod yaml to files
-	for podName, yaml := range lr.PodYaml {
-		podName = getPodName(podName, anonymize, anonymizer)
-		podYamlFile := fmt.Sprintf("%s/%s.yaml", exportDir, podName)
-		if err := createFileWithContent(podYamlFile, yaml); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
 // copyFiles copies files from one location into another.
 func copyFiles(dstFile, srcFile string) error {
 	srcWriter, err := os.Open(srcFile)
@@ -288,10 +237,11 @@ func copyFiles(dstFile, srcFile string) error {
 	return nil
 }
 
-// zipFiles creates a zip file with the contents of all the files passed in.
+// zipFiles creates a zip file with the contents of all the files passed in,
+// named by their path relative to rootDir.
 // If some of the files do not exist, it will include that in the error message
 // but it will still create a zip file with as many files as it can.
-func zipFiles(files []string, zipFileName string) error {
+func zipFiles(files []string, rootDir, zipFileName string) error {
 	zipFile, err := os.Create(zipFileName)
 	if err != nil {
 		return err
@@ -322,11 +272,13 @@ func zipFiles(files []string, zipFileName string) error {
 			return err
 		}
 
-		// Get the basename of the file since that's all we want
-		// to include in the zip
-		baseName := filepath.Base(file)
-
-		fileHeader.Name = baseName
+		// Get the name of the file relative to the root since that's
+		// all we want to include in the zip
+		relName, err := filepath.Rel(rootDir, file)
+		if err != nil {
+			return err
+		}
+		fileHeader.Name = filepath.ToSlash(relName)
 		zfd, err := zipWriter.CreateHeader(fileHeader)
 		if err != nil {
 			return err
@@ -2,19 +2,33 @@ package cli
 
 import (
 	"archive/zip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 
 	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
 )
 
 func Test_gatherLogsZipFiles(t *testing.T) {
@@ -73,7 +87,7 @@ func Test_gatherLogsZipFiles(t *testing.T) {
 				}
 			}
 			outputDir := t.TempDir()
-			err := zipFiles(fileNames, fmt.Sprintf("%s/logs.zip", outputDir))
+			err := zipFiles(fileNames, tc.fileDir, fmt.Sprintf("%s/logs.zip", outputDir))
 			// If we put in fakeFileNames, then we verify we get the errors we expect
 			if len(tc.fakeFileNames) > 0 {
 				for _, name := range tc.fakeFileNames {
@@ -215,10 +229,6 @@ func Test_gatherLogsNoK8s(t *testing.T) {
 			testLogDir := "testdata/testLogDir"
 			ctx = filelocation.WithAppUserLogDir(ctx, testLogDir)
 
-			// this isn't actually used for our unit tests, but is needed for the function
-			// when it is getting logs from k8s components
-			cmd := &cobra.Command{}
-
 			// override the outputFile
 			outputDir := t.TempDir()
 			if tc.outputFile == "" {
@@ -236,7 +246,7 @@ func Test_gatherLogsNoK8s(t *testing.T) {
 			}
 
 			// Ensure we can create a zip of the logs
-			err := gl.gatherLogs(ctx, cmd, stdout, stderr)
+			err := gl.gatherLogs(ctx, stdout, stderr)
 			if tc.errMsg != "" {
 				require.Error(t, err)
 				assert.Contains(t, err.Error(), tc.errMsg)
@@ -483,3 +493,239 @@ func checkZipEqual(zippedFile *zip.File, srcLogDir string) (bool, error) {
 
 	return string(dstContent) == string(srcContent), nil
 }
+
+func Test_gatherLogsCluster(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	agentPod := func(name, namespace string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "echo"},
+				{Name: install.AgentContainerName},
+			}},
+		}
+	}
+	managerPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "traffic-manager-5c69859f94-g4ntj", Namespace: "ambassador"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: install.ManagerAppName}}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{Name: install.ManagerAppName, RestartCount: 1},
+		}},
+	}
+	ki := fake.NewSimpleClientset(
+		managerPod,
+		agentPod("echo-easy-867b648b88-zjsp2", "default"),
+		agentPod("echo-auto-inject-6496f77cbd-n86nc", "default"),
+		agentPod("secret-7c5b9d8f6-abcde", "private"),
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "no-agent-7c5b9d8f6-fghij", Namespace: "default"}},
+	)
+
+	// Fake the pod log API. The logs in the "private" namespace can't be read.
+	var since []int64
+	oldStreamPodLogs := streamPodLogs
+	streamPodLogs = func(_ context.Context, _ typedcorev1.PodInterface, name string, opts *corev1.PodLogOptions) (io.ReadCloser, error) {
+		if strings.HasPrefix(name, "secret-") {
+			return nil, k8serrors.NewForbidden(corev1.Resource("pods/log"), name, errors.New("RBAC denied"))
+		}
+		if opts.SinceSeconds != nil {
+			since = append(since, *opts.SinceSeconds)
+		}
+		log := fmt.Sprintf("log of %s/%s previous=%t", name, opts.Container, opts.Previous)
+		return io.NopCloser(strings.NewReader(log)), nil
+	}
+	defer func() { streamPodLogs = oldStreamPodLogs }()
+
+	gatherZip := func(t *testing.T, cl *clusterLogs) map[string]string {
+		exportDir := t.TempDir()
+		require.NoError(t, cl.gather(ctx, exportDir))
+		var files []string
+		require.NoError(t, filepath.WalkDir(exportDir, func(path string, entry fs.DirEntry, err error) error {
+			if err == nil && !entry.IsDir() {
+				files = append(files, path)
+			}
+			return err
+		}))
+		zipFile := filepath.Join(t.TempDir(), "logs.zip")
+		require.NoError(t, zipFiles(files, exportDir, zipFile))
+
+		zipReader, err := zip.OpenReader(zipFile)
+		require.NoError(t, err)
+		defer zipReader.Close()
+		contents := make(map[string]string)
+		for _, f := range zipReader.File {
+			content, err := ReadZip(f)
+			require.NoError(t, err)
+			contents[f.Name] = string(content)
+		}
+		return contents
+	}
+
+	t.Run("all", func(t *testing.T) {
+		since = nil
+		contents := gatherZip(t, &clusterLogs{
+			pods:             ki.CoreV1(),
+			managerNamespace: "ambassador",
+			trafficManager:   true,
+			agents:           parseTrafficAgents("all"),
+			since:            time.Hour,
+		})
+		names := make([]string, 0, len(contents))
+		for name := range contents {
+			names = append(names, name)
+		}
+		assert.ElementsMatch(t, []string{
+			"cluster/ambassador/traffic-manager-5c69859f94-g4ntj/traffic-manager.log",
+			"cluster/ambassador/traffic-manager-5c69859f94-g4ntj/traffic-manager.previous.log",
+			"cluster/default/echo-easy-867b648b88-zjsp2/traffic-agent.log",
+			"cluster/default/echo-auto-inject-6496f77cbd-n86nc/traffic-agent.log",
+			"cluster/private/secret-7c5b9d8f6-abcde/traffic-agent.error",
+		}, names)
+		assert.Equal(t, "log of traffic-manager-5c69859f94-g4ntj/traffic-manager previous=true",
+			contents["cluster/ambassador/traffic-manager-5c69859f94-g4ntj/traffic-manager.previous.log"])
+		assert.Contains(t, contents["cluster/private/secret-7c5b9d8f6-abcde/traffic-agent.error"], "RBAC denied")
+		assert.Equal(t, []int64{3600, 3600, 3600, 3600}, since)
+	})
+
+	t.Run("mapped namespaces", func(t *testing.T) {
+		contents := gatherZip(t, &clusterLogs{
+			pods:       ki.CoreV1(),
+			agents:     parseTrafficAgents("all"),
+			namespaces: []string{"default"},
+		})
+		names := make([]string, 0, len(contents))
+		for name := range contents {
+			names = append(names, name)
+		}
+		assert.ElementsMatch(t, []string{
+			"cluster/default/echo-auto-inject-6496f77cbd-n86nc/traffic-agent.log",
+			"cluster/default/echo-easy-867b648b88-zjsp2/traffic-agent.log",
+		}, names)
+	})
+
+	t.Run("selected agents", func(t *testing.T) {
+		contents := gatherZip(t, &clusterLogs{
+			pods:    ki.CoreV1(),
+			agents:  parseTrafficAgents("echo-easy,secret"),
+			podYaml: true,
+		})
+		names := make([]string, 0, len(contents))
+		for name := range contents {
+			names = append(names, name)
+		}
+		assert.ElementsMatch(t, []string{
+			"cluster/default/echo-easy-867b648b88-zjsp2/traffic-agent.log",
+			"cluster/default/echo-easy-867b648b88-zjsp2/pod.yaml",
+			"cluster/private/secret-7c5b9d8f6-abcde/traffic-agent.error",
+			"cluster/private/secret-7c5b9d8f6-abcde/pod.yaml",
+		}, names)
+	})
+
+	t.Run("anonymized", func(t *testing.T) {
+		anonymizer := &anonymizer{
+			namespaces: make(map[string]string),
+			podNames:   make(map[string]string),
+		}
+		contents := gatherZip(t, &clusterLogs{
+			pods:             ki.CoreV1(),
+			managerNamespace: "ambassador",
+			trafficManager:   true,
+			agents:           parseTrafficAgents("None"),
+			anonymize:        true,
+			anonymizer:       anonymizer,
+		})
+		assert.Contains(t, contents, "cluster/namespace-1/traffic-manager/traffic-manager.log")
+		assert.Len(t, contents, 2)
+	})
+
+	newManager := func() *logsManager {
+		return &logsManager{
+			agents: []*manager.AgentInfo{
+				{Name: "echo-easy", Namespace: "default"},
+				{Name: "echo-easy", Namespace: "default"},
+				{Name: "echo-auto-inject", Namespace: "default"},
+				{Name: "secret", Namespace: "private"},
+			},
+			chunks: map[string][]*manager.LogChunk{
+				"": {{PodName: "traffic-manager-5c69859f94-g4ntj", Namespace: "ambassador", Container: "traffic-manager", Data: []byte("streamed")}},
+				"echo-easy.default": {
+					{PodName: "echo-easy-867b648b88-zjsp2", Namespace: "default", Container: "traffic-agent", Data: []byte("streamed "), PodYaml: "kind: Pod\n"},
+					{PodName: "echo-easy-867b648b88-zjsp2", Namespace: "default", Container: "traffic-agent", Data: []byte("log")},
+					{PodName: "echo-easy-867b648b88-zjsp2", Namespace: "default", Container: "traffic-agent", Previous: true, Error: "previous terminated container not found"},
+				},
+			},
+			errs: map[string]error{
+				"secret.private": grpcStatus.Error(codes.PermissionDenied, "namespace private is not managed"),
+			},
+		}
+	}
+	withManager := func(mc manager.ManagerClient) func(context.Context, func(context.Context, manager.ManagerClient) error) error {
+		return func(ctx context.Context, fn func(context.Context, manager.ManagerClient) error) error {
+			return fn(ctx, mc)
+		}
+	}
+	session := &manager.SessionInfo{SessionId: "session-1"}
+
+	t.Run("traffic-manager", func(t *testing.T) {
+		lm := newManager()
+		contents := gatherZip(t, &clusterLogs{
+			pods:             ki.CoreV1(),
+			managerNamespace: "ambassador",
+			trafficManager:   true,
+			agents:           parseTrafficAgents("echo-easy,secret"),
+			since:            time.Minute,
+			podYaml:          true,
+			session:          session,
+			withManager:      withManager(lm),
+		})
+		assert.Equal(t, map[string]string{
+			"cluster/ambassador/traffic-manager-5c69859f94-g4ntj/traffic-manager.log": "streamed",
+			"cluster/default/echo-easy-867b648b88-zjsp2/traffic-agent.log":            "streamed log",
+			"cluster/default/echo-easy-867b648b88-zjsp2/traffic-agent.previous.error": "previous terminated container not found\n",
+			"cluster/default/echo-easy-867b648b88-zjsp2/pod.yaml":                     "kind: Pod\n",
+			"cluster/private/secret.error":                                            "namespace private is not managed\n",
+		}, contents)
+
+		// The logs of a workload are only requested once, and only for selected workloads
+		require.Len(t, lm.requests, 3)
+		for _, request := range lm.requests {
+			assert.Equal(t, session, request.Session)
+			assert.Equal(t, time.Minute, request.Since.AsDuration())
+		}
+	})
+
+	t.Run("traffic-manager unimplemented", func(t *testing.T) {
+		lm := newManager()
+		lm.chunks = nil
+		lm.errs = map[string]error{
+			"":                  grpcStatus.Error(codes.Unimplemented, "unknown method StreamLogs"),
+			"echo-easy.default": grpcStatus.Error(codes.Unimplemented, "unknown method StreamLogs"),
+		}
+		contents := gatherZip(t, &clusterLogs{
+			pods:             ki.CoreV1(),
+			managerNamespace: "ambassador",
+			trafficManager:   true,
+			agents:           parseTrafficAgents("echo-easy"),
+			session:          session,
+			withManager:      withManager(lm),
+		})
+		assert.Equal(t, map[string]string{
+			"cluster/ambassador/traffic-manager-5c69859f94-g4ntj/traffic-manager.log":          "log of traffic-manager-5c69859f94-g4ntj/traffic-manager previous=false",
+			"cluster/ambassador/traffic-manager-5c69859f94-g4ntj/traffic-manager.previous.log": "log of traffic-manager-5c69859f94-g4ntj/traffic-manager previous=true",
+			"cluster/default/echo-easy-867b648b88-zjsp2/traffic-agent.log":                     "log of echo-easy-867b648b88-zjsp2/traffic-agent previous=false",
+		}, contents)
+	})
+
+	t.Run("no connector", func(t *testing.T) {
+		contents := gatherZip(t, &clusterLogs{
+			pods:    ki.CoreV1(),
+			agents:  parseTrafficAgents("echo-easy"),
+			session: session,
+			withManager: func(context.Context, func(context.Context, manager.ManagerClient) error) error {
+				return cliutil.ErrNoConnector
+			},
+		})
+		assert.Equal(t, map[string]string{
+			"cluster/default/echo-easy-867b648b88-zjsp2/traffic-agent.log": "log of echo-easy-867b648b88-zjsp2/traffic-agent previous=false",
+		}, contents)
+	})
+}
@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/common"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
+)
+
+type gatherTracesArgs struct {
+	outputFile string
+}
+
+func gatherTracesCommand() *cobra.Command {
+	gt := &gatherTracesArgs{}
+	cmd := &cobra.Command{
+		Use:   "gather-traces",
+		Args:  cobra.NoArgs,
+		Short: "Gather the traces recorded by the traffic-manager and the user and root daemons, and export them into a file.",
+		Long: `Gather the traces recorded by the traffic-manager and the user and root daemons,
+and export them into a JSON file that can be loaded into the Jaeger UI. Traces are only
+recorded when tracing is enabled in the config.yml of the client, and by the tracing.enabled
+value of the traffic-manager's Helm chart.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return gt.gatherTraces(cmd.Context(), cmd.OutOrStdout(), cmd.ErrOrStderr())
+		},
+	}
+	cmd.Flags().StringVarP(&gt.outputFile, "output-file", "o", "./traces.json", "The file that the traces are written to.")
+	return cmd
+}
+
+// gatherTraces collects the span buffers of the components that are running and writes them to
+// the output file. A component that can't be reached is reported but doesn't fail the command.
+func (gt *gatherTracesArgs) gatherTraces(ctx context.Context, stdout, stderr io.Writer) error {
+	var traces []*common.Traces
+	gathered := func(component string, t *common.Traces, err error) {
+		if err != nil {
+			fmt.Fprintf(stderr, "unable to gather traces from the %s: %v\n", component, err)
+			return
+		}
+		traces = append(traces, t)
+	}
+
+	err := cliutil.WithStartedDaemon(ctx, func(ctx context.Context, daemonClient daemon.DaemonClient) error {
+		t, err := daemonClient.GatherTraces(ctx, &empty.Empty{})
+		gathered("root daemon", t, err)
+		return nil
+	})
+	if err != nil && !errors.Is(err, cliutil.ErrNoDaemon) {
+		return err
+	}
+
+	err = cliutil.WithStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+		t, err := connectorClient.GatherTraces(ctx, &empty.Empty{})
+		gathered("user daemon", t, err)
+
+		// The traffic-manager is reached through the connector's proxy, which is only
+		// present when the connector is connected.
+		return withManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
+			t, err := managerClient.GatherTraces(ctx, &empty.Empty{})
+			gathered("traffic-manager", t, err)
+			return nil
+		})
+	})
+	if err != nil && !errors.Is(err, cliutil.ErrNoConnector) {
+		return err
+	}
+
+	f, err := os.Create(gt.outputFile)
+	if err != nil {
+		return errcat.User.New(err)
+	}
+	defer f.Close()
+	if err = tracing.WriteJaegerJSON(f, traces...); err != nil {
+		return err
+	}
+	spanCount := 0
+	for _, t := range traces {
+		spanCount += len(t.Spans)
+	}
+	fmt.Fprintf(stdout, "Wrote %d spans to %s\n", spanCount, gt.outputFile)
+	return nil
+}
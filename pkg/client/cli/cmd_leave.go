@@ -0,0 +1,339 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+)
+
+func leaveCommand() *cobra.Command {
+	var all, orphaned bool
+	var output string
+	cmd := &cobra.Command{
+		Use: "leave [flags] <intercept_name or pattern>...",
+		Args: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case all && orphaned:
+				return errcat.User.New("--all cannot be combined with --orphaned")
+			case all && len(args) > 0:
+				return errcat.User.New("--all cannot be combined with intercept names")
+			case orphaned && len(args) > 0:
+				return errcat.User.New("--orphaned cannot be combined with intercept names")
+			case !all && !orphaned && len(args) == 0:
+				return errcat.User.New("at least one intercept name or pattern, --all, or --orphaned, must be given")
+			}
+			return nil
+		},
+
+		Short: "Remove existing intercepts",
+		Long: `Remove existing intercepts
+
+An intercept is given by its name, or by a pattern such as 'myteam-*' that matches the names of the intercepts
+of this client, using the same syntax as shell file name patterns. Local-only intercepts are only left when
+given by name. An intercept that no longer exists is considered to be left, so the command only fails when
+an intercept couldn't be removed.
+
+An intercept that runs a handler is owned by the process of the intercept command. The user daemon leaves
+such an intercept shortly after its owner has disappeared without leaving it, e.g. because its terminal
+was closed. The --orphaned flag leaves those intercepts right away.
+
+With --output json, a single JSON document that tells what happened to each intercept, or the error that
+prevented the leave, is written to stdout.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch output {
+			case "":
+			case "json":
+				return leaveJSON(cmd, args, all, orphaned)
+			default:
+				return errcat.User.Newf("unsupported output format %q", output)
+			}
+			if orphaned {
+				return leaveOrphaned(cmd.Context(), cmd.OutOrStdout(), cmd.ErrOrStderr())
+			}
+			return leave(cmd.Context(), cmd.OutOrStdout(), cmd.ErrOrStderr(), args, all)
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if all || orphaned {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return completeIntercepts(cmd, args, toComplete)
+		},
+	}
+	cmd.Flags().BoolVarP(&all, "all", "a", false, "Leave all intercepts of this client")
+	cmd.Flags().BoolVar(&orphaned, "orphaned", false, "Leave the intercepts of this client whose handler's process no longer exists")
+	cmd.Flags().StringVar(&output, "output", "", `Set the output format. The only supported format is "json"`)
+	return cmd
+}
+
+// leaveJSON is leave or leaveOrphaned with --output json. Only the JSON document is written to stdout.
+func leaveJSON(cmd *cobra.Command, args []string, all, orphaned bool) error {
+	ctx := cliutil.WithStdout(cmd.Context(), cmd.ErrOrStderr())
+	doc := &jsonDocument{out: cmd.OutOrStdout()}
+	var lr *leaveReport
+	var err error
+	if orphaned {
+		lr, err = orphanedLeaveReport(ctx)
+	} else {
+		lr, err = leaveReportOf(ctx, args, all)
+	}
+	if err != nil {
+		return doc.writeError(err)
+	}
+	if err = doc.write(lr); err != nil {
+		return err
+	}
+	if lr.failures() > 0 {
+		// The document tells which intercepts couldn't be left
+		return &ExitCodeError{Code: ExitFailure}
+	}
+	return nil
+}
+
+// isInterceptPattern returns true if the given argument to leave is a pattern rather than a name.
+// Intercept names never contain the special characters of a pattern.
+func isInterceptPattern(arg string) bool {
+	return strings.ContainsAny(arg, `*?[\`)
+}
+
+// The states of the intercepts in a leaveReport.
+const (
+	leaveStateLeft      = "left"
+	leaveStateNotActive = "not_active"
+	leaveStateFailed    = "failed"
+)
+
+// leaveReport tells what a leave did with each intercept, in the order that they were left, and what patterns
+// didn't match any intercept.
+type leaveReport struct {
+	Unmatched  []string      `json:"unmatched,omitempty"`
+	Intercepts []leaveResult `json:"intercepts"`
+
+	bulk bool // each result is reported, and the remaining intercepts are left also when one of them fails
+}
+
+type leaveResult struct {
+	Name  string       `json:"name"`
+	State string       `json:"state"`
+	Error *errorOutput `json:"error,omitempty"`
+
+	err error
+}
+
+func (lr *leaveReport) failures() int {
+	failures := 0
+	for i := range lr.Intercepts {
+		if lr.Intercepts[i].err != nil {
+			failures++
+		}
+	}
+	return failures
+}
+
+// writeText writes the report as text. In bulk, each result is reported, and an error is returned if any of
+// the intercepts couldn't be left. Otherwise, the error of the intercept is returned.
+func (lr *leaveReport) writeText(stdout, stderr io.Writer) error {
+	for _, pattern := range lr.Unmatched {
+		fmt.Fprintf(stdout, "No intercepts match %q\n", pattern)
+	}
+	for _, r := range lr.Intercepts {
+		switch {
+		case r.State == leaveStateNotActive:
+			// Already gone, perhaps because another leave got there first
+			fmt.Fprintf(stdout, "Intercept %s is not active\n", r.Name)
+		case r.err != nil:
+			if !lr.bulk {
+				return r.err
+			}
+			fmt.Fprintf(stderr, "Failed to leave intercept %s: %v\n", r.Name, r.err)
+		case lr.bulk:
+			fmt.Fprintf(stdout, "Left intercept %s\n", r.Name)
+		}
+	}
+	if failures := lr.failures(); failures > 0 {
+		return fmt.Errorf("failed to leave %d of %d intercepts", failures, len(lr.Intercepts))
+	}
+	return nil
+}
+
+// leave removes the intercepts with the given names, the intercepts of this client that match the given
+// patterns, or all intercepts of this client. Each removal is reported, and the remaining intercepts are
+// removed also when one of them fails. A single intercept that is given by name is left silently.
+func leave(ctx context.Context, stdout, stderr io.Writer, args []string, all bool) error {
+	lr, err := leaveReportOf(ctx, args, all)
+	if err != nil {
+		return err
+	}
+	if all && len(lr.Intercepts) == 0 {
+		fmt.Fprintln(stdout, "No intercepts to leave")
+		return nil
+	}
+	return lr.writeText(stdout, stderr)
+}
+
+// leaveReportOf removes the intercepts that leave removes, and returns what happened to them.
+func leaveReportOf(ctx context.Context, args []string, all bool) (*leaveReport, error) {
+	hasPattern := false
+	for i, arg := range args {
+		arg = strings.TrimSpace(arg)
+		if isInterceptPattern(arg) {
+			if _, err := path.Match(arg, ""); err != nil {
+				return nil, errcat.User.Newf("invalid pattern %q: %w", arg, err)
+			}
+			hasPattern = true
+		}
+		args[i] = arg
+	}
+	lr := &leaveReport{bulk: all || hasPattern || len(args) > 1}
+
+	var active []string
+	if all || hasPattern {
+		var err error
+		if active, err = activeInterceptNames(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var targets []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			targets = append(targets, name)
+		}
+	}
+	if all {
+		for _, name := range active {
+			add(name)
+		}
+	}
+	for _, arg := range args {
+		if !isInterceptPattern(arg) {
+			add(arg)
+			continue
+		}
+		matched := false
+		for _, name := range active {
+			if ok, _ := path.Match(arg, name); ok {
+				add(name)
+				matched = true
+			}
+		}
+		if !matched {
+			lr.Unmatched = append(lr.Unmatched, arg)
+		}
+	}
+	lr.leaveTargets(ctx, targets)
+	return lr, nil
+}
+
+// leaveOrphaned removes the intercepts of this client whose owner no longer exists.
+func leaveOrphaned(ctx context.Context, stdout, stderr io.Writer) error {
+	lr, err := orphanedLeaveReport(ctx)
+	if err != nil {
+		return err
+	}
+	if len(lr.Intercepts) == 0 {
+		fmt.Fprintln(stdout, "No orphaned intercepts to leave")
+		return nil
+	}
+	return lr.writeText(stdout, stderr)
+}
+
+// orphanedLeaveReport removes the intercepts that leaveOrphaned removes, and returns what happened to them.
+func orphanedLeaveReport(ctx context.Context) (*leaveReport, error) {
+	targets, err := orphanedInterceptNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lr := &leaveReport{bulk: true}
+	lr.leaveTargets(ctx, targets)
+	return lr, nil
+}
+
+// leaveTargets removes the intercepts with the given names and adds their results to the report. Unless in
+// bulk, nothing more is removed when a removal fails.
+func (lr *leaveReport) leaveTargets(ctx context.Context, targets []string) {
+	lr.Intercepts = make([]leaveResult, 0, len(targets))
+	for _, name := range targets {
+		r, err := leaveIntercept(ctx, name)
+		if err == nil {
+			err = interceptMessage(r)
+		}
+		result := leaveResult{Name: name, State: leaveStateLeft}
+		switch {
+		case r != nil && r.Error == connector.InterceptError_NOT_FOUND:
+			result.State = leaveStateNotActive
+		case err != nil:
+			result.State = leaveStateFailed
+			result.Error = newErrorOutput(err)
+			result.err = err
+		}
+		lr.Intercepts = append(lr.Intercepts, result)
+		if result.err != nil && !lr.bulk {
+			return
+		}
+	}
+}
+
+// activeInterceptNames returns the names of the intercepts of this client, or nothing when the user
+// daemon isn't running or isn't connected.
+func activeInterceptNames(ctx context.Context) ([]string, error) {
+	var names []string
+	err := withStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+		ci, err := connectorClient.Status(ctx, &connector.ConnectRequest{KubeFlags: connectorKubeFlagMap(ctx)})
+		if err != nil {
+			return err
+		}
+		if ci.Error == connector.ConnectInfo_SESSION_AMBIGUOUS {
+			return errcat.Category(ci.ErrorCategory).New(ci.ErrorText)
+		}
+		for _, ii := range ci.GetIntercepts().GetIntercepts() {
+			names = append(names, ii.Spec.Name)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, cliutil.ErrNoConnector) {
+		return nil, err
+	}
+	return names, nil
+}
+
+// processAlive returns true if a process with the given id exists.
+var processAlive = proc.IsAlive
+
+// orphanedInterceptNames returns the names of the intercepts of this client whose owner no longer
+// exists, or nothing when the user daemon isn't running or isn't connected.
+func orphanedInterceptNames(ctx context.Context) ([]string, error) {
+	var names []string
+	err := withStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+		ci, err := connectorClient.Status(ctx, &connector.ConnectRequest{KubeFlags: connectorKubeFlagMap(ctx)})
+		if err != nil {
+			return err
+		}
+		if ci.Error == connector.ConnectInfo_SESSION_AMBIGUOUS {
+			return errcat.Category(ci.ErrorCategory).New(ci.ErrorText)
+		}
+		for name, pid := range ci.InterceptOwners {
+			if !processAlive(int(pid)) {
+				names = append(names, name)
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, cliutil.ErrNoConnector) {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
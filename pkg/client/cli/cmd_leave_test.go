@@ -0,0 +1,249 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+// leaveConnector is a user daemon with the given intercepts. Removing an intercept in gone reports
+// that it doesn't exist, and removing an intercept in broken fails.
+type leaveConnector struct {
+	connector.ConnectorClient
+	intercepts []string
+	owners     map[string]int32
+	gone       map[string]bool
+	broken     map[string]bool
+	removed    []string
+}
+
+func (c *leaveConnector) Status(context.Context, *connector.ConnectRequest, ...grpc.CallOption) (*connector.ConnectInfo, error) {
+	iis := make([]*manager.InterceptInfo, len(c.intercepts))
+	for i, name := range c.intercepts {
+		iis[i] = &manager.InterceptInfo{Spec: &manager.InterceptSpec{Name: name}}
+	}
+	return &connector.ConnectInfo{
+		Error:           connector.ConnectInfo_ALREADY_CONNECTED,
+		Intercepts:      &manager.InterceptInfoSnapshot{Intercepts: iis},
+		InterceptOwners: c.owners,
+	}, nil
+}
+
+func (c *leaveConnector) RemoveIntercept(_ context.Context, rr *connector.RemoveInterceptRequest, _ ...grpc.CallOption) (*connector.InterceptResult, error) {
+	c.removed = append(c.removed, rr.Name)
+	switch {
+	case c.gone[rr.Name]:
+		return &connector.InterceptResult{Error: connector.InterceptError_NOT_FOUND, ErrorText: rr.Name}, nil
+	case c.broken[rr.Name]:
+		return &connector.InterceptResult{Error: connector.InterceptError_TRAFFIC_MANAGER_ERROR, ErrorText: "connection refused"}, nil
+	}
+	return &connector.InterceptResult{}, nil
+}
+
+func Test_leave(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	intercepts := []string{"myteam-echo", "myteam-db", "other-echo", "myteam-api"}
+	tests := []struct {
+		name            string
+		args            []string
+		all             bool
+		gone            map[string]bool
+		broken          map[string]bool
+		expectedRemoved []string
+		expectedStdout  string
+		expectedStderr  string
+		expectedError   string
+	}{
+		{
+			name:            "single name",
+			args:            []string{"other-echo"},
+			expectedRemoved: []string{"other-echo"},
+		},
+		{
+			name:            "single name already gone",
+			args:            []string{"mylocal"},
+			gone:            map[string]bool{"mylocal": true},
+			expectedRemoved: []string{"mylocal"},
+			expectedStdout:  "Intercept mylocal is not active\n",
+		},
+		{
+			name:            "single name failure",
+			args:            []string{"other-echo"},
+			broken:          map[string]bool{"other-echo": true},
+			expectedRemoved: []string{"other-echo"},
+			expectedError:   "connection refused",
+		},
+		{
+			name:            "pattern",
+			args:            []string{"myteam-*"},
+			expectedRemoved: []string{"myteam-echo", "myteam-db", "myteam-api"},
+			expectedStdout:  "Left intercept myteam-echo\nLeft intercept myteam-db\nLeft intercept myteam-api\n",
+		},
+		{
+			name:            "patterns and names",
+			args:            []string{"*-echo", "myteam-d?", "myteam-echo", "extra"},
+			expectedRemoved: []string{"myteam-echo", "other-echo", "myteam-db", "extra"},
+			expectedStdout:  "Left intercept myteam-echo\nLeft intercept other-echo\nLeft intercept myteam-db\nLeft intercept extra\n",
+		},
+		{
+			name:           "no match",
+			args:           []string{"[xy]*"},
+			expectedStdout: "No intercepts match \"[xy]*\"\n",
+		},
+		{
+			name:          "invalid pattern",
+			args:          []string{"myteam-[a"},
+			expectedError: `invalid pattern "myteam-[a"`,
+		},
+		{
+			name:            "all with partial failure",
+			all:             true,
+			gone:            map[string]bool{"myteam-db": true},
+			broken:          map[string]bool{"other-echo": true},
+			expectedRemoved: intercepts,
+			expectedStdout: "Left intercept myteam-echo\n" +
+				"Intercept myteam-db is not active\n" +
+				"Left intercept myteam-api\n",
+			expectedStderr: "Failed to leave intercept other-echo: connection refused\n",
+			expectedError:  "failed to leave 1 of 4 intercepts",
+		},
+		{
+			name:            "all already gone",
+			all:             true,
+			gone:            map[string]bool{"myteam-echo": true, "myteam-db": true, "other-echo": true, "myteam-api": true},
+			expectedRemoved: intercepts,
+			expectedStdout: "Intercept myteam-echo is not active\n" +
+				"Intercept myteam-db is not active\n" +
+				"Intercept other-echo is not active\n" +
+				"Intercept myteam-api is not active\n",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			cc := &leaveConnector{intercepts: intercepts, gone: tt.gone, broken: tt.broken}
+			withFakeConnector(t, cc)
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			err := leave(ctx, stdout, stderr, tt.args, tt.all)
+			if tt.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedRemoved, cc.removed)
+			assert.Equal(t, tt.expectedStdout, stdout.String())
+			assert.Equal(t, tt.expectedStderr, stderr.String())
+		})
+	}
+
+	t.Run("all without intercepts", func(t *testing.T) {
+		withFakeConnector(t, &leaveConnector{})
+		stdout := &bytes.Buffer{}
+		require.NoError(t, leave(ctx, stdout, &bytes.Buffer{}, nil, true))
+		assert.Equal(t, "No intercepts to leave\n", stdout.String())
+	})
+}
+
+func Test_leaveOrphaned(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	oldAlive := processAlive
+	t.Cleanup(func() { processAlive = oldAlive })
+	alive := map[int]bool{100: true}
+	processAlive = func(pid int) bool { return alive[pid] }
+
+	cc := &leaveConnector{
+		intercepts: []string{"echo", "api", "db", "local"},
+		owners:     map[string]int32{"echo": 100, "api": 200, "db": 300},
+		gone:       map[string]bool{"db": true},
+	}
+	withFakeConnector(t, cc)
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	require.NoError(t, leaveOrphaned(ctx, stdout, stderr))
+	assert.Equal(t, []string{"api", "db"}, cc.removed)
+	assert.Equal(t, "Left intercept api\nIntercept db is not active\n", stdout.String())
+	assert.Empty(t, stderr.String())
+
+	t.Run("no orphans", func(t *testing.T) {
+		cc := &leaveConnector{intercepts: []string{"echo"}, owners: map[string]int32{"echo": 100}}
+		withFakeConnector(t, cc)
+		stdout := &bytes.Buffer{}
+		require.NoError(t, leaveOrphaned(ctx, stdout, &bytes.Buffer{}))
+		assert.Empty(t, cc.removed)
+		assert.Equal(t, "No orphaned intercepts to leave\n", stdout.String())
+	})
+}
+
+func Test_leaveJSON(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	run := func(t *testing.T, cc *leaveConnector, args ...string) (string, error) {
+		t.Helper()
+		withFakeConnector(t, cc)
+		cmd := leaveCommand()
+		cmd.SilenceErrors = true
+		cmd.SilenceUsage = true
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		cmd.SetOut(stdout)
+		cmd.SetErr(stderr)
+		cmd.SetArgs(append(args, "--output", "json"))
+		err := cmd.ExecuteContext(ctx)
+		assert.Empty(t, stderr.String())
+		return stdout.String(), err
+	}
+
+	t.Run("partial failure", func(t *testing.T) {
+		cc := &leaveConnector{
+			intercepts: []string{"myteam-echo", "myteam-db", "other-echo"},
+			gone:       map[string]bool{"myteam-db": true},
+			broken:     map[string]bool{"other-echo": true},
+		}
+		out, err := run(t, cc, "--all")
+		var codeErr *ExitCodeError
+		require.True(t, errors.As(err, &codeErr))
+		assert.Equal(t, ExitFailure, codeErr.Code)
+		assert.NoError(t, codeErr.Err, "the JSON document tells what went wrong")
+		assert.JSONEq(t, `{
+  "intercepts": [
+    {"name": "myteam-echo", "state": "left"},
+    {"name": "myteam-db", "state": "not_active"},
+    {
+      "name": "other-echo",
+      "state": "failed",
+      "error": {"category": "unknown", "reason": "traffic_manager_error", "message": "connection refused"}
+    }
+  ]
+}`, out)
+	})
+
+	t.Run("unmatched", func(t *testing.T) {
+		out, err := run(t, &leaveConnector{intercepts: []string{"echo"}}, "echo", "x*")
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"unmatched": ["x*"], "intercepts": [{"name": "echo", "state": "left"}]}`, out)
+	})
+
+	t.Run("nothing to leave", func(t *testing.T) {
+		out, err := run(t, &leaveConnector{}, "--orphaned")
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"intercepts": []}`, out)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		out, err := run(t, &leaveConnector{}, "myteam-[a")
+		var codeErr *ExitCodeError
+		require.True(t, errors.As(err, &codeErr))
+		assert.Equal(t, ExitFailure, codeErr.Code)
+		assert.JSONEq(t, `{
+  "error": {"category": "user", "message": "invalid pattern \"myteam-[a\": syntax error in pattern"},
+  "exit_code": 1
+}`, out)
+	})
+}
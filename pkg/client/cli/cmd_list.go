@@ -2,14 +2,18 @@ package cli
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 )
 
 type listInfo struct {
@@ -17,7 +21,9 @@ type listInfo struct {
 	onlyAgents        bool
 	onlyInterceptable bool
 	debug             bool
+	detailed          bool
 	namespace         string
+	output            string
 }
 
 func listCommand() *cobra.Command {
@@ -35,11 +41,17 @@ func listCommand() *cobra.Command {
 	flags.BoolVarP(&s.onlyInterceptable, "only-interceptable", "o", true, "interceptable workloads only")
 	flags.BoolVar(&s.debug, "debug", false, "include debugging information")
 	flags.StringVarP(&s.namespace, "namespace", "n", "", "If present, the namespace scope for this CLI request")
+	_ = cmd.RegisterFlagCompletionFunc("namespace", completeNamespaces)
+	flags.BoolVar(&s.detailed, "detailed", false, "include agent versions and the clients that intercept each workload")
+	flags.StringVar(&s.output, "output", "", `Set the output format. The only supported format is "json"`)
 	return cmd
 }
 
 // list requests a list current intercepts from the daemon
 func (s *listInfo) list(cmd *cobra.Command, _ []string) error {
+	if s.output != "" && s.output != "json" {
+		return errcat.User.Newf("unsupported output format %q", s.output)
+	}
 	var r *connector.WorkloadInfoSnapshot
 	var err error
 	err = withConnector(cmd, true, func(ctx context.Context, connectorClient connector.ConnectorClient, _ *connector.ConnectInfo) error {
@@ -54,20 +66,106 @@ func (s *listInfo) list(cmd *cobra.Command, _ []string) error {
 		default:
 			filter = connector.ListRequest_EVERYTHING
 		}
-		r, err = connectorClient.List(cmd.Context(), &connector.ListRequest{Filter: filter, Namespace: s.namespace})
+		r, err = connectorClient.List(cmd.Context(), &connector.ListRequest{Filter: filter, Namespace: s.namespace, Context: sessionContext()})
 		return err
 	})
 	if err != nil {
 		return err
 	}
-	stdout := cmd.OutOrStdout()
-	if len(r.Workloads) == 0 {
-		fmt.Fprintln(stdout, "No Workloads (Deployments, StatefulSets, or ReplicaSets)")
-		return nil
+	wl := &workloadList{workloads: r.Workloads, debug: s.debug}
+	if s.output == "json" {
+		return wl.writeJSON(cmd.OutOrStdout())
+	}
+	wl.writeText(cmd.OutOrStdout(), s.detailed)
+	return nil
+}
+
+// workloadList is the result of a list request, rendered as text or JSON.
+type workloadList struct {
+	workloads []*connector.WorkloadInfo
+	debug     bool
+}
+
+type workloadListing struct {
+	Name           string              `json:"name"`
+	Kind           string              `json:"kind,omitempty"`
+	State          string              `json:"state"`
+	Reason         string              `json:"reason,omitempty"`
+	AgentVersion   string              `json:"agent_version,omitempty"`
+	Intercepts     []workloadIntercept `json:"intercepts,omitempty"`
+	InterceptCount int                 `json:"intercept_count"`
+}
+
+type workloadIntercept struct {
+	Name      string `json:"name"`
+	Client    string `json:"client"`
+	Mechanism string `json:"mechanism"`
+}
+
+// intercepts returns the intercepts of the workload, or nil when the identity of an intercepting
+// client is unknown, which is the case when the traffic-manager doesn't report it. The count is
+// always returned.
+func intercepts(workload *connector.WorkloadInfo) ([]*connector.WorkloadInfo_Intercept, int) {
+	wis := workload.Intercepts
+	if len(wis) == 0 {
+		if workload.InterceptInfo != nil {
+			// A user daemon that doesn't report the intercepts of other clients
+			return nil, 1
+		}
+		return nil, 0
+	}
+	for _, wi := range wis {
+		if wi.Client == "" {
+			return nil, len(wis)
+		}
+	}
+	return wis, len(wis)
+}
+
+func (wl *workloadList) writeJSON(out io.Writer) error {
+	ls := make([]workloadListing, len(wl.workloads))
+	for i, workload := range wl.workloads {
+		l := &ls[i]
+		l.Name = workload.Name
+		l.Kind = workload.WorkloadResourceType
+		switch {
+		case workload.Name == "":
+			// Local-only, so use name of intercept
+			l.Name = workload.InterceptInfo.Spec.Name
+			l.State = "local-only intercept"
+		case workload.InterceptInfo != nil && interceptPending(workload.InterceptInfo):
+			l.State = "intercept pending"
+		case workload.InterceptInfo != nil:
+			l.State = "intercepted"
+		case workload.NotInterceptableReason != "":
+			l.State = "not interceptable"
+			l.Reason = workload.NotInterceptableReason
+		default:
+			l.State = "ready to intercept"
+		}
+		if ai := workload.AgentInfo; ai != nil {
+			l.AgentVersion = ai.Version
+		}
+		var wis []*connector.WorkloadInfo_Intercept
+		wis, l.InterceptCount = intercepts(workload)
+		for _, wi := range wis {
+			l.Intercepts = append(l.Intercepts, workloadIntercept{Name: wi.Name, Client: wi.Client, Mechanism: wi.Mechanism})
+		}
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ls)
+}
+
+func (wl *workloadList) writeText(out io.Writer, detailed bool) {
+	if len(wl.workloads) == 0 {
+		fmt.Fprintln(out, "No Workloads (Deployments, StatefulSets, ReplicaSets, or Rollouts)")
+		return
 	}
 
 	nameLen := 0
-	for _, dep := range r.Workloads {
+	kindLen := 0
+	for _, dep := range wl.workloads {
 		n := dep.Name
 		if n == "" {
 			// Local-only, so use name of intercept
@@ -76,11 +174,14 @@ func (s *listInfo) list(cmd *cobra.Command, _ []string) error {
 		if nl := len(n); nl > nameLen {
 			nameLen = nl
 		}
+		if kl := len(dep.WorkloadResourceType); kl > kindLen {
+			kindLen = kl
+		}
 	}
 
 	state := func(workload *connector.WorkloadInfo) string {
 		if ii := workload.InterceptInfo; ii != nil {
-			return DescribeIntercept(ii, nil, s.debug)
+			return DescribeIntercept(ii, nil, wl.debug)
 		}
 		ai := workload.AgentInfo
 		if ai != nil {
@@ -93,19 +194,54 @@ func (s *listInfo) list(cmd *cobra.Command, _ []string) error {
 		}
 	}
 
-	for _, workload := range r.Workloads {
+	// The kind of the workload precedes its name, e.g. "StatefulSet echo-db: ready to intercept"
+	kind := func(workload *connector.WorkloadInfo) string {
+		if kindLen == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%-*s ", kindLen, workload.WorkloadResourceType)
+	}
+
+	for _, workload := range wl.workloads {
 		if workload.Name == "" {
 			// Local-only, so use name of intercept
-			fmt.Fprintf(stdout, "%-*s: local-only intercept\n", nameLen, workload.InterceptInfo.Spec.Name)
-		} else {
-			fmt.Fprintf(stdout, "%-*s: %s\n", nameLen, workload.Name, state(workload))
+			fmt.Fprintf(out, "%s%-*s: local-only intercept\n", kind(workload), nameLen, workload.InterceptInfo.Spec.Name)
+			continue
+		}
+		fmt.Fprintf(out, "%s%-*s: %s\n", kind(workload), nameLen, workload.Name, state(workload))
+		if detailed {
+			writeWorkloadDetails(out, workload)
 		}
 	}
-	return nil
+}
+
+// writeWorkloadDetails writes the version of the workload's traffic-agent and the clients that
+// intercept it.
+func writeWorkloadDetails(out io.Writer, workload *connector.WorkloadInfo) {
+	if ai := workload.AgentInfo; ai != nil {
+		fmt.Fprintf(out, "    traffic-agent: %s\n", ai.Version)
+	} else {
+		fmt.Fprintln(out, "    no agent")
+	}
+	wis, count := intercepts(workload)
+	switch {
+	case len(wis) > 0:
+		for _, wi := range wis {
+			fmt.Fprintf(out, "    intercepted by %s: intercept %q, %s\n", wi.Client, wi.Name, wi.Mechanism)
+		}
+	case count > 0:
+		fmt.Fprintf(out, "    intercepts: %d\n", count)
+	}
 }
 
 func DescribeIntercept(ii *manager.InterceptInfo, volumeMountsPrevented error, debug bool) string {
 	msg := "intercepted"
+	switch {
+	case interceptPending(ii):
+		msg = "intercept pending (waiting for a traffic-agent to pick it up)"
+	case ii.Spec.Replace && ii.Disposition == manager.InterceptDispositionType_ACTIVE:
+		msg = "intercepted and replaced (the workload's container is idle)"
+	}
 
 	type kv struct {
 		Key   string
@@ -117,7 +253,10 @@ func DescribeIntercept(ii *manager.InterceptInfo, volumeMountsPrevented error, d
 	fields = append(fields, kv{"Intercept name", ii.Spec.Name})
 	fields = append(fields, kv{"State", func() string {
 		msg := ""
-		if ii.Disposition > manager.InterceptDispositionType_WAITING {
+		switch {
+		case interceptPending(ii):
+			msg += "pending: "
+		case ii.Disposition > manager.InterceptDispositionType_WAITING:
 			msg += "error: "
 		}
 		msg += ii.Disposition.String()
@@ -138,11 +277,28 @@ func DescribeIntercept(ii *manager.InterceptInfo, volumeMountsPrevented error, d
 	if ii.Spec.ServicePortIdentifier != "" {
 		fields = append(fields, kv{"Service Port Identifier", ii.Spec.ServicePortIdentifier})
 	}
+	if ii.Spec.ContainerName != "" {
+		fields = append(fields, kv{"Container", fmt.Sprintf("%s, port %d", ii.Spec.ContainerName, ii.Spec.ContainerPort)})
+	}
+	if ii.Expires != nil {
+		fields = append(fields, kv{"Expires", ii.Expires.AsTime().Local().Format(time.RFC1123)})
+	}
 	if debug {
 		fields = append(fields, kv{"Mechanism", ii.Spec.Mechanism})
 		fields = append(fields, kv{"Mechanism Args", fmt.Sprintf("%q", ii.Spec.MechanismArgs)})
 	}
 
+	if len(ii.Spec.HttpHeaders) > 0 {
+		hms := make([]string, len(ii.Spec.HttpHeaders))
+		for i, hm := range ii.Spec.HttpHeaders {
+			hms[i] = hm.Name + "=" + hm.ValueRegex
+		}
+		fields = append(fields, kv{"HTTP Header Match", strings.Join(hms, "\n")})
+	}
+	if ii.Spec.HttpPathPrefix != "" {
+		fields = append(fields, kv{"HTTP Path Prefix", ii.Spec.HttpPathPrefix})
+	}
+
 	if ii.Spec.MountPoint != "" {
 		fields = append(fields, kv{"Volume Mount Point", ii.Spec.MountPoint})
 	} else if volumeMountsPrevented != nil {
@@ -156,14 +312,8 @@ func DescribeIntercept(ii *manager.InterceptInfo, volumeMountsPrevented error, d
 		return ii.MechanismArgsDesc
 	}()})
 
-	if ii.PreviewDomain != "" {
-		previewURL := ii.PreviewDomain
-		// Right now SystemA gives back domains with the leading "https://", but
-		// let's not rely on that.
-		if !strings.HasPrefix(previewURL, "https://") && !strings.HasPrefix(previewURL, "http://") {
-			previewURL = "https://" + previewURL
-		}
-		fields = append(fields, kv{"Preview URL", previewURL})
+	if url := previewURL(ii); url != "" {
+		fields = append(fields, kv{"Preview URL", url})
 	}
 	if l5Hostname := ii.GetPreviewSpec().GetIngress().GetL5Host(); l5Hostname != "" {
 		fields = append(fields, kv{"Layer 5 Hostname", l5Hostname})
@@ -184,3 +334,14 @@ func DescribeIntercept(ii *manager.InterceptInfo, volumeMountsPrevented error, d
 	}
 	return msg
 }
+
+// interceptPending returns true if the given intercept has been accepted by the traffic-manager, but is
+// yet to be picked up by a traffic-agent. It becomes active when that happens.
+func interceptPending(ii *manager.InterceptInfo) bool {
+	switch ii.Disposition {
+	case manager.InterceptDispositionType_WAITING, manager.InterceptDispositionType_NO_AGENT:
+		return true
+	default:
+		return false
+	}
+}
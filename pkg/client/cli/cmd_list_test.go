@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+func fakeInterceptInfo(name, client string) *manager.InterceptInfo {
+	return &manager.InterceptInfo{
+		Spec: &manager.InterceptSpec{
+			Name:         name,
+			Client:       client,
+			Agent:        "echo",
+			WorkloadKind: "Deployment",
+			Mechanism:    "tcp",
+			TargetHost:   "127.0.0.1",
+			TargetPort:   8080,
+		},
+		Disposition:       manager.InterceptDispositionType_ACTIVE,
+		MechanismArgsDesc: "all TCP connections",
+	}
+}
+
+func TestList_render(t *testing.T) {
+	agent := &manager.AgentInfo{Name: "echo", Namespace: "default", Version: "2.4.5"}
+	tests := []struct {
+		name      string
+		workloads []*connector.WorkloadInfo
+	}{
+		{"no-agent", []*connector.WorkloadInfo{
+			{Name: "echo", WorkloadResourceType: "Deployment"},
+			{Name: "echo-db", WorkloadResourceType: "StatefulSet", NotInterceptableReason: "no ports"},
+		}},
+		{"intercepted", []*connector.WorkloadInfo{
+			{
+				Name:                 "echo",
+				WorkloadResourceType: "Deployment",
+				AgentInfo:            agent,
+				InterceptInfo:        fakeInterceptInfo("echo", "alice@laptop"),
+				Intercepts: []*connector.WorkloadInfo_Intercept{
+					{Name: "echo", Client: "alice@laptop", Mechanism: "all-traffic"},
+					{Name: "echo-bob", Client: "bob@desktop", Mechanism: "header-matched"},
+				},
+			},
+			{Name: "web", WorkloadResourceType: "Deployment", AgentInfo: &manager.AgentInfo{Name: "web", Namespace: "default", Version: "2.4.4"}},
+		}},
+		{"pending", []*connector.WorkloadInfo{
+			{
+				Name:                 "echo",
+				WorkloadResourceType: "Deployment",
+				InterceptInfo: func() *manager.InterceptInfo {
+					// Created with --no-wait while the pods of the workload are replaced
+					ii := fakeInterceptInfo("echo", "alice@laptop")
+					ii.Disposition = manager.InterceptDispositionType_NO_AGENT
+					ii.Message = `No agent found for "echo"`
+					return ii
+				}(),
+				Intercepts: []*connector.WorkloadInfo_Intercept{
+					{Name: "echo", Client: "alice@laptop", Mechanism: "all-traffic"},
+				},
+			},
+		}},
+		{"no-client-identity", []*connector.WorkloadInfo{
+			{
+				Name:                 "echo",
+				WorkloadResourceType: "Deployment",
+				AgentInfo:            agent,
+				Intercepts: []*connector.WorkloadInfo_Intercept{
+					{Name: "echo", Mechanism: "all-traffic"},
+					{Name: "echo-2", Mechanism: "header-matched"},
+				},
+			},
+		}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			wl := &workloadList{workloads: tt.workloads}
+			out := &bytes.Buffer{}
+			wl.writeText(out, true)
+			expected, err := os.ReadFile(filepath.Join("testdata", "list", tt.name+".txt"))
+			require.NoError(t, err)
+			assert.Equal(t, string(expected), out.String())
+
+			out.Reset()
+			require.NoError(t, wl.writeJSON(out))
+			expected, err = os.ReadFile(filepath.Join("testdata", "list", tt.name+".json"))
+			require.NoError(t, err)
+			assert.JSONEq(t, string(expected), out.String())
+		})
+	}
+}
+
+func TestList_notDetailed(t *testing.T) {
+	wl := &workloadList{workloads: []*connector.WorkloadInfo{
+		{Name: "echo", WorkloadResourceType: "Deployment", AgentInfo: &manager.AgentInfo{Version: "2.4.5"}},
+	}}
+	out := &bytes.Buffer{}
+	wl.writeText(out, false)
+	assert.Equal(t, "Deployment echo: ready to intercept (traffic-agent already installed)\n", out.String())
+}
@@ -3,11 +3,14 @@ package cli
 import (
 	"context"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/durationpb"
 
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
@@ -23,6 +26,9 @@ type logLevelSetter struct {
 	duration   time.Duration
 	localOnly  bool
 	remoteOnly bool
+	remote     bool
+	agents     []string
+	namespace  string
 }
 
 func logLevelArg(cmd *cobra.Command, args []string) error {
@@ -58,12 +64,31 @@ func loglevelCommand() *cobra.Command {
 	flags.DurationVarP(&lls.duration, "duration", "d", defaultDuration, "The time that the log-level will be in effect (0s means indefinitely)")
 	flags.BoolVarP(&lls.localOnly, "local-only", "l", false, "Only affect the user and root daemons")
 	flags.BoolVarP(&lls.remoteOnly, "remote-only", "r", false, "Only affect the traffic-manager and traffic-agents")
+	flags.BoolVar(&lls.remote, "remote", false,
+		"Only affect the traffic-manager and the traffic-agents of the workloads given by --agents. The traffic-manager resets them when the duration expires")
+	flags.StringSliceVar(&lls.agents, "agents", nil, "Comma separated list of workloads whose traffic-agents are affected by --remote")
+	flags.StringVarP(&lls.namespace, "namespace", "n", "", "The namespace of the workloads given by --agents. All managed namespaces when omitted")
+	_ = cmd.RegisterFlagCompletionFunc("namespace", completeNamespaces)
+	_ = cmd.RegisterFlagCompletionFunc("agents", completeWorkloads(connector.ListRequest_INSTALLED_AGENTS, &lls.namespace, 0))
 	return cmd
 }
 
 func (lls *logLevelSetter) setTempLogLevel(cmd *cobra.Command, args []string) error {
-	if lls.localOnly && lls.remoteOnly {
-		return errcat.User.New("the local-only and remote-only options are mutually exclusive")
+	if lls.localOnly && lls.remoteOnly || lls.remote && (lls.localOnly || lls.remoteOnly) {
+		return errcat.User.New("the local-only, remote-only, and remote options are mutually exclusive")
+	}
+	if !lls.remote && (len(lls.agents) > 0 || lls.namespace != "") {
+		return errcat.User.New("--agents and --namespace can only be used with --remote")
+	}
+	if lls.remote {
+		if lls.duration <= 0 {
+			return errcat.User.New("--remote requires a duration")
+		}
+		return withConnector(cmd, true, func(ctx context.Context, _ connector.ConnectorClient, connInfo *connector.ConnectInfo) error {
+			return withManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
+				return lls.setRemoteLogLevel(ctx, cmd.OutOrStdout(), managerClient, connInfo.SessionInfo, args[0])
+			})
+		})
 	}
 
 	return withConnector(cmd, true, func(ctx context.Context, connectorClient connector.ConnectorClient, connInfo *connector.ConnectInfo) error {
@@ -84,7 +109,7 @@ func (lls *logLevelSetter) setTempLogLevel(cmd *cobra.Command, args []string) er
 		}
 
 		if !lls.localOnly {
-			err := cliutil.WithManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
+			err := withManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
 				_, err := managerClient.SetLogLevel(ctx, rq)
 				return err
 			})
@@ -93,3 +118,60 @@ func (lls *logLevelSetter) setTempLogLevel(cmd *cobra.Command, args []string) er
 		return nil
 	})
 }
+
+// setRemoteLogLevel sets the log-level of the traffic-manager and of the traffic-agents of the selected
+// workloads, and prints the outcome. Workloads whose traffic-agents didn't get the log-level, e.g. because
+// they are too old, are reported without failing the command.
+func (lls *logLevelSetter) setRemoteLogLevel(ctx context.Context, out io.Writer, managerClient manager.ManagerClient, session *manager.SessionInfo, level string) error {
+	rl, err := managerClient.SetRemoteLogLevel(ctx, &manager.RemoteLogLevelRequest{
+		Session:   session,
+		LogLevel:  level,
+		Duration:  durationpb.New(lls.duration),
+		Agents:    lls.agents,
+		Namespace: lls.namespace,
+	})
+	if err != nil {
+		if st, ok := grpcStatus.FromError(err); ok {
+			switch st.Code() {
+			case codes.Unimplemented:
+				return errcat.User.New("the traffic-manager is too old to set the log-level of individual traffic-agents, please use --remote-only")
+			case codes.NotFound, codes.PermissionDenied, codes.InvalidArgument:
+				return errcat.User.New(st.Message())
+			}
+		}
+		return err
+	}
+	fmt.Fprintf(out, "traffic-manager: %s\n", describeRemoteLogLevel(rl.TrafficManager))
+	for _, al := range rl.Agents {
+		fmt.Fprintf(out, "%s: ", describeAgent(al))
+		if al.Error != "" {
+			fmt.Fprintln(out, al.Error)
+		} else {
+			fmt.Fprintln(out, describeRemoteLogLevel(al.LogLevel))
+		}
+	}
+	return nil
+}
+
+// describeRemoteLogLevel returns the log-level and the time when it expires.
+func describeRemoteLogLevel(rl *manager.RemoteLogLevel) string {
+	if rl == nil {
+		return "default"
+	}
+	if ex := rl.Expires; ex != nil {
+		return fmt.Sprintf("%s until %s", rl.LogLevel, ex.AsTime().Local().Format("15:04:05"))
+	}
+	return rl.LogLevel
+}
+
+// describeAgent returns "<workload>.<namespace>", followed by the pod IP of the traffic-agent if known.
+func describeAgent(al *manager.AgentLogLevel) string {
+	name := al.Name
+	if al.Namespace != "" {
+		name += "." + al.Namespace
+	}
+	if al.PodIp != "" {
+		name += " (" + al.PodIp + ")"
+	}
+	return name
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// remoteLogLevelManager is a traffic-manager that answers SetRemoteLogLevel with the given result, or
+// with the given error.
+type remoteLogLevelManager struct {
+	manager.ManagerClient
+	result  *manager.RemoteLogLevels
+	err     error
+	request *manager.RemoteLogLevelRequest
+}
+
+func (m *remoteLogLevelManager) SetRemoteLogLevel(_ context.Context, request *manager.RemoteLogLevelRequest, _ ...grpc.CallOption) (*manager.RemoteLogLevels, error) {
+	m.request = request
+	return m.result, m.err
+}
+
+func Test_setRemoteLogLevel(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	session := &manager.SessionInfo{SessionId: "session-1"}
+	expires := time.Date(2021, 11, 4, 10, 0, 0, 0, time.UTC)
+	until := expires.Local().Format("15:04:05")
+
+	t.Run("mixed versions", func(t *testing.T) {
+		level := &manager.RemoteLogLevel{LogLevel: "debug", Expires: timestamppb.New(expires)}
+		mc := &remoteLogLevelManager{result: &manager.RemoteLogLevels{
+			TrafficManager: level,
+			Agents: []*manager.AgentLogLevel{
+				{Name: "echo", Namespace: "blue", PodIp: "10.1.0.5", Version: "2.4.5", LogLevel: level},
+				{Name: "echo", Namespace: "blue", PodIp: "10.1.0.6", Version: "2.4.4", Error: "traffic-agent 2.4.4 is too old to get a log-level of its own"},
+				{Name: "nothing", Error: "no traffic-agent found"},
+			},
+		}}
+		lls := &logLevelSetter{duration: 5 * time.Minute, remote: true, agents: []string{"echo", "nothing"}}
+		out := &bytes.Buffer{}
+		require.NoError(t, lls.setRemoteLogLevel(ctx, out, mc, session, "debug"))
+		assert.Equal(t, fmt.Sprintf(`traffic-manager: debug until %[1]s
+echo.blue (10.1.0.5): debug until %[1]s
+echo.blue (10.1.0.6): traffic-agent 2.4.4 is too old to get a log-level of its own
+nothing: no traffic-agent found
+`, until), out.String())
+		assert.Equal(t, session, mc.request.Session)
+		assert.Equal(t, 5*time.Minute, mc.request.Duration.AsDuration())
+		assert.Equal(t, []string{"echo", "nothing"}, mc.request.Agents)
+	})
+
+	t.Run("old traffic-manager", func(t *testing.T) {
+		mc := &remoteLogLevelManager{err: grpcStatus.Error(codes.Unimplemented, "unknown method SetRemoteLogLevel")}
+		lls := &logLevelSetter{duration: 5 * time.Minute, remote: true}
+		err := lls.setRemoteLogLevel(ctx, &bytes.Buffer{}, mc, session, "debug")
+		require.Error(t, err)
+		assert.Equal(t, errcat.User, errcat.GetCategory(err))
+		assert.Contains(t, err.Error(), "--remote-only")
+	})
+
+	t.Run("flags", func(t *testing.T) {
+		run := func(args ...string) error {
+			cmd := loglevelCommand()
+			cmd.SetArgs(args)
+			cmd.SetOut(&bytes.Buffer{})
+			cmd.SetErr(&bytes.Buffer{})
+			cmd.SilenceErrors = true
+			cmd.SilenceUsage = true
+			return cmd.ExecuteContext(ctx)
+		}
+		err := run("debug", "--remote", "--remote-only")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "mutually exclusive")
+		err = run("debug", "--agents", "echo")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "can only be used with --remote")
+		err = run("debug", "--remote", "--duration", "0s")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "requires a duration")
+	})
+}
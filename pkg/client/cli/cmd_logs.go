@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+type logsArgs struct {
+	agent     string
+	namespace string
+	tail      int64
+	since     time.Duration
+}
+
+func logsCommand() *cobra.Command {
+	la := &logsArgs{}
+	cmd := &cobra.Command{
+		Use:  "logs [flags]",
+		Args: cobra.NoArgs,
+
+		Short: "Show the logs of the traffic-manager, or of the traffic-agents of a workload",
+		Long: `Show the logs of the traffic-manager, or of the traffic-agents of a workload
+
+The logs are streamed by the traffic-manager, so no permission to read pod logs is needed. The logs of the
+traffic-agents of a workload are only shown when the workload could be intercepted. The log of each container
+is preceded by a "==> <pod>.<namespace>/<container> <==" header on stderr.`,
+		RunE: la.run,
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&la.agent, "agent", "a", "", "Show the logs of the traffic-agents of this workload instead of the traffic-manager")
+	flags.StringVarP(&la.namespace, "namespace", "n", "", "The namespace of the workload. Can be omitted when the workload name is unique")
+	flags.Int64Var(&la.tail, "tail", 0, "The number of lines of the most recent log to show, 0 shows all lines")
+	flags.DurationVar(&la.since, "since", 0, "Only show the log that is newer than this duration, e.g. 5m")
+	_ = cmd.RegisterFlagCompletionFunc("namespace", completeNamespaces)
+	_ = cmd.RegisterFlagCompletionFunc("agent", completeWorkloads(connector.ListRequest_INSTALLED_AGENTS, &la.namespace, 0))
+	return cmd
+}
+
+func (la *logsArgs) run(cmd *cobra.Command, _ []string) error {
+	if la.tail < 0 {
+		return errcat.User.New("--tail cannot be negative")
+	}
+	if la.namespace != "" && la.agent == "" {
+		return errcat.User.New("--namespace can only be used with --agent")
+	}
+	return withConnector(cmd, true, func(ctx context.Context, _ connector.ConnectorClient, connInfo *connector.ConnectInfo) error {
+		return withManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
+			return la.printLogs(ctx, cmd.OutOrStdout(), cmd.ErrOrStderr(), managerClient, connInfo.SessionInfo)
+		})
+	})
+}
+
+// printLogs prints the logs that the traffic-manager streams to stdout, and a header for the log of each
+// container to stderr. The logs that the traffic-manager was unable to read are reported on stderr, and
+// result in an error once all logs have been printed.
+func (la *logsArgs) printLogs(ctx context.Context, stdout, stderr io.Writer, managerClient manager.ManagerClient, session *manager.SessionInfo) error {
+	request := &manager.StreamLogsRequest{
+		Session:   session,
+		AgentName: la.agent,
+		Namespace: la.namespace,
+		TailLines: la.tail,
+	}
+	if la.since > 0 {
+		request.Since = durationpb.New(la.since)
+	}
+	if request.AgentName != "" && request.Namespace == "" {
+		var err error
+		if request.Namespace, err = agentNamespace(ctx, managerClient, session, request.AgentName); err != nil {
+			return err
+		}
+	}
+	stream, err := managerClient.StreamLogs(ctx, request)
+	if err != nil {
+		return logsError(err)
+	}
+
+	var current string
+	failures := 0
+	for {
+		chunk, err := stream.Recv()
+		if err != nil {
+			if err != io.EOF {
+				return logsError(err)
+			}
+			break
+		}
+		header := fmt.Sprintf("%s.%s/%s", chunk.PodName, chunk.Namespace, chunk.Container)
+		if chunk.Previous {
+			header += " (previous)"
+		}
+		if header != current {
+			current = header
+			fmt.Fprintf(stderr, "==> %s <==\n", header)
+		}
+		if _, err = stdout.Write(chunk.Data); err != nil {
+			return err
+		}
+		if chunk.Error != "" {
+			failures++
+			fmt.Fprintf(stderr, "unable to read the log: %s\n", chunk.Error)
+		}
+	}
+	if failures > 0 {
+		return errcat.Unknown.Newf("unable to read %d of the logs", failures)
+	}
+	return nil
+}
+
+// agentNamespace returns the namespace of the workload with the given name that has traffic-agents.
+func agentNamespace(ctx context.Context, managerClient manager.ManagerClient, session *manager.SessionInfo, name string) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watcher, err := managerClient.WatchAgents(ctx, session)
+	if err != nil {
+		return "", err
+	}
+	snapshot, err := watcher.Recv()
+	if err != nil {
+		return "", err
+	}
+	nsSet := make(map[string]struct{})
+	for _, agent := range snapshot.Agents {
+		if agent.Name == name {
+			nsSet[agent.Namespace] = struct{}{}
+		}
+	}
+	namespaces := make([]string, 0, len(nsSet))
+	for ns := range nsSet {
+		namespaces = append(namespaces, ns)
+	}
+	switch len(namespaces) {
+	case 0:
+		return "", errcat.User.Newf("no traffic-agent found for %s", name)
+	case 1:
+		return namespaces[0], nil
+	default:
+		sort.Strings(namespaces)
+		return "", errcat.User.Newf("%s has traffic-agents in namespaces %s, please use --namespace to select one", name, strings.Join(namespaces, ", "))
+	}
+}
+
+// logsError makes the errors that tell that the caller isn't allowed to stream a log user errors.
+func logsError(err error) error {
+	if st, ok := grpcStatus.FromError(err); ok {
+		switch st.Code() {
+		case codes.NotFound, codes.PermissionDenied:
+			return errcat.User.New(st.Message())
+		case codes.Unimplemented:
+			return errcat.User.New("the traffic-manager is too old to stream logs, please use \"telepresence gather-logs\"")
+		}
+	}
+	return err
+}
@@ -0,0 +1,137 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// logsManager is a traffic-manager that streams the given chunks, keyed by "<agent>.<namespace>", or by
+// "" for the traffic-manager's own log. The error of a key is returned once its chunks have been streamed.
+type logsManager struct {
+	manager.ManagerClient
+	agents   []*manager.AgentInfo
+	chunks   map[string][]*manager.LogChunk
+	errs     map[string]error
+	requests []*manager.StreamLogsRequest
+}
+
+type agentSnapshotStream struct {
+	grpc.ClientStream
+	snapshot *manager.AgentInfoSnapshot
+}
+
+func (s *agentSnapshotStream) Recv() (*manager.AgentInfoSnapshot, error) {
+	return s.snapshot, nil
+}
+
+type logChunkStream struct {
+	grpc.ClientStream
+	chunks []*manager.LogChunk
+	err    error
+}
+
+func (s *logChunkStream) Recv() (*manager.LogChunk, error) {
+	if len(s.chunks) == 0 {
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, io.EOF
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return chunk, nil
+}
+
+func (m *logsManager) WatchAgents(context.Context, *manager.SessionInfo, ...grpc.CallOption) (manager.Manager_WatchAgentsClient, error) {
+	return &agentSnapshotStream{snapshot: &manager.AgentInfoSnapshot{Agents: m.agents}}, nil
+}
+
+func (m *logsManager) StreamLogs(_ context.Context, request *manager.StreamLogsRequest, _ ...grpc.CallOption) (manager.Manager_StreamLogsClient, error) {
+	m.requests = append(m.requests, request)
+	key := ""
+	if request.AgentName != "" {
+		key = request.AgentName + "." + request.Namespace
+	}
+	return &logChunkStream{chunks: m.chunks[key], err: m.errs[key]}, nil
+}
+
+func Test_printLogs(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	session := &manager.SessionInfo{SessionId: "session-1"}
+	lm := &logsManager{
+		agents: []*manager.AgentInfo{
+			{Name: "echo", Namespace: "blue", PodIp: "10.1.0.5"},
+			{Name: "echo", Namespace: "blue", PodIp: "10.1.0.6"},
+			{Name: "web", Namespace: "blue"},
+			{Name: "web", Namespace: "red"},
+		},
+		chunks: map[string][]*manager.LogChunk{
+			"": {{PodName: "traffic-manager-abc", Namespace: "ambassador", Container: "traffic-manager", Data: []byte("manager\n")}},
+			"echo.blue": {
+				{PodName: "echo-1", Namespace: "blue", Container: "traffic-agent", Data: []byte("one\n")},
+				{PodName: "echo-1", Namespace: "blue", Container: "traffic-agent", Data: []byte("two\n")},
+				{PodName: "echo-1", Namespace: "blue", Container: "traffic-agent", Previous: true, Error: "gone"},
+				{PodName: "echo-2", Namespace: "blue", Container: "traffic-agent", Data: []byte("three\n")},
+			},
+		},
+		errs: map[string]error{
+			"web.red": grpcStatus.Error(codes.PermissionDenied, "namespace red is not managed"),
+		},
+	}
+	run := func(la *logsArgs) (string, string, error) {
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		err := la.printLogs(ctx, stdout, stderr, lm, session)
+		return stdout.String(), stderr.String(), err
+	}
+
+	t.Run("traffic-manager", func(t *testing.T) {
+		stdout, stderr, err := run(&logsArgs{tail: 10})
+		require.NoError(t, err)
+		assert.Equal(t, "manager\n", stdout)
+		assert.Equal(t, "==> traffic-manager-abc.ambassador/traffic-manager <==\n", stderr)
+		request := lm.requests[len(lm.requests)-1]
+		assert.Equal(t, int64(10), request.TailLines)
+		assert.Equal(t, session, request.Session)
+	})
+
+	t.Run("agent", func(t *testing.T) {
+		// The namespace is found using the agents, and a log that can't be read results in an error
+		stdout, stderr, err := run(&logsArgs{agent: "echo"})
+		require.Error(t, err)
+		assert.Equal(t, "unable to read 1 of the logs", err.Error())
+		assert.Equal(t, "blue", lm.requests[len(lm.requests)-1].Namespace)
+		assert.Equal(t, "one\ntwo\nthree\n", stdout)
+		assert.Equal(t, `==> echo-1.blue/traffic-agent <==
+==> echo-1.blue/traffic-agent (previous) <==
+unable to read the log: gone
+==> echo-2.blue/traffic-agent <==
+`, stderr)
+	})
+
+	t.Run("not allowed", func(t *testing.T) {
+		_, _, err := run(&logsArgs{agent: "web"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "please use --namespace")
+
+		_, _, err = run(&logsArgs{agent: "web", namespace: "red"})
+		require.Error(t, err)
+		assert.Equal(t, errcat.User, errcat.GetCategory(err))
+		assert.Equal(t, "namespace red is not managed", err.Error())
+
+		_, _, err = run(&logsArgs{agent: "nothing"})
+		require.Error(t, err)
+		assert.Equal(t, "no traffic-agent found for nothing", err.Error())
+	})
+}
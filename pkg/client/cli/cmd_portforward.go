@@ -0,0 +1,169 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+func portForwardCommand() *cobra.Command {
+	var namespace string
+	cmd := &cobra.Command{
+		Use:  "port-forward [flags] <svc|pod>/<name> [<local port>:]<remote port>... [<svc|pod>/<name> [<local port>:]<remote port>...]...",
+		Args: cobra.MinimumNArgs(2),
+
+		Short: "Forward local ports to a service or pod in the cluster",
+		Long: `Forward local ports to a service or pod in the cluster
+
+Each target is given as svc/<name> or pod/<name> and is followed by the ports to forward to it. A port is
+given as <local port>:<remote port>, or as just <remote port> when the local port is the same. The remote
+port of a service is its port number or name, and the remote port of a pod is a container port number or
+name. Connections are forwarded using the tunnel of the current session, to a running pod that backs the
+service. The forwards move to another pod when that pod is replaced.
+
+The command runs until it is interrupted with Ctrl-C or the session ends.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			specs, err := parsePortForwardArgs(args, namespace)
+			if err != nil {
+				return err
+			}
+			return withConnector(cmd, true, func(ctx context.Context, connectorClient connector.ConnectorClient, _ *connector.ConnectInfo) error {
+				ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+				defer cancel()
+				return portForward(ctx, cmd.OutOrStdout(), connectorClient, specs)
+			})
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&namespace, "namespace", "n", "", "If present, the namespace scope for this CLI request")
+	_ = cmd.RegisterFlagCompletionFunc("namespace", completeNamespaces)
+	return cmd
+}
+
+// parsePortForwardArgs parses the targets and ports of the port-forward command.
+func parsePortForwardArgs(args []string, namespace string) ([]*connector.PortForwardSpec, error) {
+	var specs []*connector.PortForwardSpec
+	for _, arg := range args {
+		if kindAndName := strings.SplitN(arg, "/", 2); len(kindAndName) == 2 {
+			kind := kindAndName[0]
+			switch kind {
+			case "svc", "service", "services":
+				kind = "svc"
+			case "pod", "pods":
+				kind = "pod"
+			default:
+				return nil, errcat.User.Newf("invalid target %q, must be svc/<name> or pod/<name>", arg)
+			}
+			if kindAndName[1] == "" {
+				return nil, errcat.User.Newf("invalid target %q, the name is missing", arg)
+			}
+			specs = append(specs, &connector.PortForwardSpec{Kind: kind, Name: kindAndName[1], Namespace: namespace})
+			continue
+		}
+		if len(specs) == 0 {
+			return nil, errcat.User.Newf("port %q must be preceded by a target svc/<name> or pod/<name>", arg)
+		}
+		pfp, err := parsePortForwardPort(arg)
+		if err != nil {
+			return nil, err
+		}
+		spec := specs[len(specs)-1]
+		spec.Ports = append(spec.Ports, pfp)
+	}
+
+	seen := make(map[int32]bool)
+	for _, spec := range specs {
+		if len(spec.Ports) == 0 {
+			return nil, errcat.User.Newf("no ports given for %s/%s", spec.Kind, spec.Name)
+		}
+		for _, p := range spec.Ports {
+			if seen[p.LocalPort] {
+				return nil, errcat.User.Newf("local port %d is used more than once", p.LocalPort)
+			}
+			seen[p.LocalPort] = true
+		}
+	}
+	return specs, nil
+}
+
+// parsePortForwardPort parses a port on the form [<local port>:]<remote port>.
+func parsePortForwardPort(arg string) (*connector.PortForwardPort, error) {
+	local, remote := "", arg
+	if i := strings.IndexByte(arg, ':'); i >= 0 {
+		local, remote = arg[:i], arg[i+1:]
+	}
+	if remote == "" {
+		return nil, errcat.User.Newf("invalid port %q, the remote port is missing", arg)
+	}
+	if local == "" {
+		if _, err := strconv.ParseUint(remote, 10, 16); err != nil {
+			return nil, errcat.User.Newf("invalid port %q, a local port is required when the remote port is a name", arg)
+		}
+		local = remote
+	} else if n, err := strconv.ParseUint(remote, 10, 16); err == nil && n == 0 {
+		return nil, errcat.User.Newf("invalid port %q, the remote port cannot be zero", arg)
+	}
+	lp, err := strconv.ParseUint(local, 10, 16)
+	if err != nil || lp == 0 {
+		return nil, errcat.User.Newf("invalid port %q, the local port must be a number between 1 and 65535", arg)
+	}
+	return &connector.PortForwardPort{LocalPort: int32(lp), RemotePort: remote}, nil
+}
+
+// describePortForward returns a one line description of each port of the given port forward.
+func describePortForward(pf *connector.PortForwardInfo) []string {
+	spec := pf.Spec
+	descs := make([]string, len(spec.Ports))
+	for i, p := range spec.Ports {
+		to := "waiting for a pod"
+		if pf.PodName != "" {
+			to = fmt.Sprintf("pod %s:%d", pf.PodName, p.PodPort)
+		}
+		descs[i] = fmt.Sprintf("127.0.0.1:%d -> %s/%s.%s:%s (%s)", p.LocalPort, spec.Kind, spec.Name, spec.Namespace, p.RemotePort, to)
+	}
+	return descs
+}
+
+// portForward establishes the given port forwards and prints them, and how they change, until the context
+// is cancelled or the user daemon ends the session.
+func portForward(ctx context.Context, out io.Writer, connectorClient connector.ConnectorClient, specs []*connector.PortForwardSpec) error {
+	stream, err := connectorClient.PortForward(ctx, &connector.PortForwardRequest{Forwards: specs, Context: sessionContext()})
+	if err != nil {
+		return err
+	}
+	var printed []string
+	for {
+		snapshot, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if snapshot.ErrorText != "" {
+			return errcat.Category(snapshot.ErrorCategory).New(snapshot.ErrorText)
+		}
+		// Only print the ports whose forward has changed since the previous snapshot
+		var descs []string
+		for _, pf := range snapshot.Forwards {
+			descs = append(descs, describePortForward(pf)...)
+		}
+		for i, desc := range descs {
+			if i >= len(printed) || printed[i] != desc {
+				fmt.Fprintf(out, "Forwarding from %s\n", desc)
+			}
+		}
+		printed = descs
+	}
+}
@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+func Test_parsePortForwardArgs(t *testing.T) {
+	tests := []struct {
+		name          string
+		args          []string
+		expected      []*connector.PortForwardSpec
+		expectedError string
+	}{
+		{
+			name: "service and pod",
+			args: []string{"svc/echo", "8080:80", "9000", "pods/db-0", "5432:postgres"},
+			expected: []*connector.PortForwardSpec{
+				{Kind: "svc", Name: "echo", Namespace: "ns", Ports: []*connector.PortForwardPort{
+					{LocalPort: 8080, RemotePort: "80"},
+					{LocalPort: 9000, RemotePort: "9000"},
+				}},
+				{Kind: "pod", Name: "db-0", Namespace: "ns", Ports: []*connector.PortForwardPort{
+					{LocalPort: 5432, RemotePort: "postgres"},
+				}},
+			},
+		},
+		{
+			name:          "port before target",
+			args:          []string{"8080", "svc/echo"},
+			expectedError: "must be preceded by a target",
+		},
+		{
+			name:          "deployment target",
+			args:          []string{"deploy/echo", "8080"},
+			expectedError: `invalid target "deploy/echo"`,
+		},
+		{
+			name:          "target without ports",
+			args:          []string{"svc/echo", "pod/db-0", "5432"},
+			expectedError: "no ports given for svc/echo",
+		},
+		{
+			name:          "named port without local port",
+			args:          []string{"svc/echo", "http"},
+			expectedError: "a local port is required",
+		},
+		{
+			name:          "invalid local port",
+			args:          []string{"svc/echo", "70000:80"},
+			expectedError: "the local port must be a number",
+		},
+		{
+			name:          "duplicate local port",
+			args:          []string{"svc/echo", "8080:80", "pod/db-0", "8080:5432"},
+			expectedError: "local port 8080 is used more than once",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			specs, err := parsePortForwardArgs(tt.args, "ns")
+			if tt.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, specs)
+		})
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 )
 
 // addPreviewFlags mutates 'flags', adding flags to it such that the flags set the appropriate
@@ -18,6 +19,86 @@ func addPreviewFlags(prefix string, flags *pflag.FlagSet, spec *manager.PreviewS
 	flags.BoolVarP(&spec.DisplayBanner, prefix+"banner", "b", true, "Display banner on preview page")
 }
 
+// ingressArgs are the values of the flags that declare the ingress of a preview URL, so that it doesn't have
+// to be asked for.
+type ingressArgs struct {
+	host   string // --ingress-host
+	port   int32  // --ingress-port
+	useTLS bool   // --ingress-tls
+	l5Host string // --ingress-l5host
+	tlsSet bool   // whether --ingress-tls was passed
+}
+
+// addIngressFlags mutates 'flags', adding the flags that set the fields of the given 'args'.
+func addIngressFlags(flags *pflag.FlagSet, args *ingressArgs) {
+	flags.StringVar(&args.host, "ingress-host", "", ``+
+		`The IP address or DNS name of the ingress that requests to the preview URL enter the cluster through, `+
+		`e.g. "ambassador.ambassador". Defaults to the ingress that was last used with the cluster.`)
+	flags.Int32Var(&args.port, "ingress-port", 0, `The TCP port number of the ingress`)
+	flags.BoolVar(&args.useTLS, "ingress-tls", false, `Whether the TCP port of the ingress uses TLS`)
+	flags.StringVar(&args.l5Host, "ingress-l5host", "", ``+
+		`The hostname (TLS-SNI, HTTP "Host" header) to use in requests to the ingress, if different from --ingress-host`)
+}
+
+// resolve records which of the ingress flags were given, and validates their values.
+func (a *ingressArgs) resolve(flags *pflag.FlagSet) error {
+	a.tlsSet = flags.Changed("ingress-tls")
+	for _, h := range []struct{ flag, host string }{{"--ingress-host", a.host}, {"--ingress-l5host", a.l5Host}} {
+		if h.host != "" && !hostRx.MatchString(h.host) {
+			return errcat.User.Newf("%s %q is not a valid IP address or DNS name", h.flag, h.host)
+		}
+	}
+	if a.port < 0 || flags.Changed("ingress-port") && a.port == 0 {
+		return errcat.User.New("--ingress-port must be a positive integer")
+	}
+	return nil
+}
+
+// given returns true if any of the ingress flags was given.
+func (a *ingressArgs) given() bool {
+	return a.host != "" || a.port != 0 || a.tlsSet || a.l5Host != ""
+}
+
+// apply returns a copy of the given ingress, with the values that were given using flags. The L5 host
+// follows the host unless it differs from it, or is given.
+func (a *ingressArgs) apply(ii *manager.IngressInfo) *manager.IngressInfo {
+	r := &manager.IngressInfo{}
+	if ii != nil {
+		r = &manager.IngressInfo{Host: ii.Host, Port: ii.Port, UseTls: ii.UseTls, L5Host: ii.L5Host}
+	}
+	if a.host != "" {
+		if r.L5Host == "" || r.L5Host == r.Host {
+			r.L5Host = a.host
+		}
+		r.Host = a.host
+	}
+	if a.port != 0 {
+		r.Port = a.port
+	}
+	if a.tlsSet {
+		r.UseTls = a.useTLS
+	}
+	if a.l5Host != "" {
+		r.L5Host = a.l5Host
+	}
+	if r.L5Host == "" {
+		r.L5Host = r.Host
+	}
+	return r
+}
+
+// missingFlags returns the flags that must be given for the ingress to be complete.
+func (a *ingressArgs) missingFlags() []string {
+	var missing []string
+	if a.host == "" {
+		missing = append(missing, "--ingress-host")
+	}
+	if a.port == 0 {
+		missing = append(missing, "--ingress-port")
+	}
+	return missing
+}
+
 func previewCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:  "preview",
@@ -28,17 +109,21 @@ func previewCommand() *cobra.Command {
 	}
 
 	var createSpec manager.PreviewSpec
+	var ingress ingressArgs
 	createCmd := &cobra.Command{
 		Use:  "create [flags] <intercept_name>",
 		Args: cobra.ExactArgs(1),
 
 		Short: "Create a preview domain for an existing intercept",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := ingress.resolve(cmd.Flags()); err != nil {
+				return err
+			}
 			if _, err := cliutil.EnsureLoggedIn(cmd.Context(), ""); err != nil {
 				return err
 			}
 			return withConnector(cmd, true, func(ctx context.Context, _ connector.ConnectorClient, connInfo *connector.ConnectInfo) error {
-				return cliutil.WithManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
+				return withManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
 					if createSpec.Ingress == nil {
 						request := manager.GetInterceptRequest{Session: connInfo.SessionInfo, Name: args[0]}
 						// Throws rpc "not found" error if intercept has not yet been created
@@ -46,11 +131,11 @@ func previewCommand() *cobra.Command {
 						if err != nil {
 							return err
 						}
-						ingress, err := selectIngress(ctx, cmd.InOrStdin(), cmd.OutOrStdout(), connInfo, interceptInfo.Spec.Agent, interceptInfo.Spec.Namespace)
+						ii, err := selectIngress(ctx, cmd.InOrStdin(), cmd.OutOrStdout(), connInfo, interceptInfo.Spec.Agent, interceptInfo.Spec.Namespace, &ingress)
 						if err != nil {
 							return err
 						}
-						createSpec.Ingress = ingress
+						createSpec.Ingress = ii
 					}
 					intercept, err := managerClient.UpdateIntercept(ctx, &manager.UpdateInterceptRequest{
 						Session: connInfo.SessionInfo,
@@ -69,6 +154,7 @@ func previewCommand() *cobra.Command {
 		},
 	}
 	addPreviewFlags("", createCmd.Flags(), &createSpec)
+	addIngressFlags(createCmd.Flags(), &ingress)
 
 	removeCmd := &cobra.Command{
 		Use:  "remove <intercept_name>",
@@ -77,7 +163,7 @@ func previewCommand() *cobra.Command {
 		Short: "Remove a preview domain from an intercept",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return withConnector(cmd, true, func(ctx context.Context, _ connector.ConnectorClient, connInfo *connector.ConnectInfo) error {
-				return cliutil.WithManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
+				return withManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
 					intercept, err := managerClient.UpdateIntercept(ctx, &manager.UpdateInterceptRequest{
 						Session: connInfo.SessionInfo,
 						Name:    args[0],
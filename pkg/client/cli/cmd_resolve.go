@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+type resolveAnswer struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	TTL      uint32 `json:"ttl"`
+	IP       string `json:"ip,omitempty"`
+	Target   string `json:"target,omitempty"`
+	Port     uint32 `json:"port,omitempty"`
+	Priority uint32 `json:"priority"`
+	Weight   uint32 `json:"weight,omitempty"`
+}
+
+type resolveInfo struct {
+	Name    string           `json:"name"`
+	Status  string           `json:"status"`
+	Answers []*resolveAnswer `json:"answers"`
+	Error   string           `json:"error,omitempty"`
+}
+
+func resolveCommand() *cobra.Command {
+	var qType, output string
+	cmd := &cobra.Command{
+		Use:  "resolve [flags] <name>",
+		Args: cobra.ExactArgs(1),
+
+		Short: "Look up a name in the cluster",
+		Long: `Look up a name in the cluster
+
+The name is looked up using the same cluster side lookup as the DNS server of the root daemon, so the
+answers are those that a pod in the cluster gets. A name that doesn't end with a dot, or with the cluster
+domain, is looked up using the search path of a pod in the namespace of the connection, e.g. "echo" is
+looked up as "echo.<namespace>.svc.<cluster domain>". The SRV records of the named ports of a service are
+looked up as "_<port name>._<protocol>.<service>", e.g. "_http._tcp.echo".
+
+The command exits with code 5 when the name doesn't exist, and with code 6 when the lookup failed.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != "" && output != "json" {
+				return errcat.User.Newf("unsupported output format %q", output)
+			}
+			switch strings.ToUpper(qType) {
+			case "A", "AAAA", "SRV":
+			default:
+				return errcat.User.Newf("unsupported record type %q, must be A, AAAA, or SRV", qType)
+			}
+			return withConnector(cmd, true, func(ctx context.Context, connectorClient connector.ConnectorClient, _ *connector.ConnectInfo) error {
+				return resolve(ctx, cmd.OutOrStdout(), connectorClient, &connector.ResolveRequest{
+					Name:    args[0],
+					Type:    strings.ToUpper(qType),
+					Context: sessionContext(),
+				}, output)
+			})
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&qType, "type", "t", "A", "The type of the records, A, AAAA, or SRV")
+	flags.StringVarP(&output, "output", "o", "", `Set the output format. The only supported format is "json"`)
+	return cmd
+}
+
+// resolve looks up the name of the given request in the cluster and prints the answers. A name that
+// doesn't exist, and a lookup that fails, result in an ExitCodeError.
+func resolve(ctx context.Context, out io.Writer, connectorClient connector.ConnectorClient, rr *connector.ResolveRequest, output string) error {
+	r, err := connectorClient.Resolve(ctx, rr)
+	if err != nil {
+		return err
+	}
+	info := newResolveInfo(r)
+	if output == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(info); err != nil {
+			return err
+		}
+	} else {
+		info.writeText(out, rr.Type)
+	}
+
+	var codeErr *ExitCodeError
+	switch r.Rcode {
+	case connector.ResolveResult_NXDOMAIN:
+		codeErr = &ExitCodeError{Code: ExitNotFound, Err: errcat.User.Newf("%s doesn't exist in the cluster", r.Name)}
+	case connector.ResolveResult_SERVFAIL:
+		codeErr = &ExitCodeError{Code: ExitLookupFailed, Err: errcat.Category(r.ErrorCategory).Newf("lookup of %s failed: %s", r.Name, r.ErrorText)}
+	default:
+		return nil
+	}
+	if output == "json" {
+		// The JSON output tells what went wrong
+		codeErr.Err = nil
+	}
+	return codeErr
+}
+
+func newResolveInfo(r *connector.ResolveResult) *resolveInfo {
+	info := &resolveInfo{
+		Name:    r.Name,
+		Status:  r.Rcode.String(),
+		Answers: make([]*resolveAnswer, len(r.Answers)),
+		Error:   r.ErrorText,
+	}
+	for i, a := range r.Answers {
+		ra := &resolveAnswer{
+			Name:     a.Name,
+			Type:     a.Type,
+			TTL:      a.Ttl,
+			Target:   a.Target,
+			Port:     a.Port,
+			Priority: a.Priority,
+			Weight:   a.Weight,
+		}
+		if len(a.Ip) > 0 {
+			ra.IP = net.IP(a.Ip).String()
+		}
+		info.Answers[i] = ra
+	}
+	return info
+}
+
+// writeText prints one line per answer, in the format of a zone file. Nothing is printed when the lookup
+// failed, because the error is printed instead.
+func (info *resolveInfo) writeText(out io.Writer, qType string) {
+	if info.Status != connector.ResolveResult_NOERROR.String() {
+		return
+	}
+	if len(info.Answers) == 0 {
+		fmt.Fprintf(out, "%s has no %s records\n", info.Name, qType)
+		return
+	}
+	for _, a := range info.Answers {
+		if a.Type == "SRV" {
+			fmt.Fprintf(out, "%s.\t%d\tIN\tSRV\t%d %d %d %s\n", a.Name, a.TTL, a.Priority, a.Weight, a.Port, a.Target)
+		} else {
+			fmt.Fprintf(out, "%s.\t%d\tIN\t%s\t%s\n", a.Name, a.TTL, a.Type, a.IP)
+		}
+	}
+}
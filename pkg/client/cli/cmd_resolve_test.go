@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// resolveConnector is a user daemon that answers resolve requests with the given results, keyed by
+// name and type.
+type resolveConnector struct {
+	connector.ConnectorClient
+	results map[string]*connector.ResolveResult
+}
+
+func (c *resolveConnector) Resolve(_ context.Context, rr *connector.ResolveRequest, _ ...grpc.CallOption) (*connector.ResolveResult, error) {
+	if r, ok := c.results[rr.Name+" "+rr.Type]; ok {
+		return r, nil
+	}
+	return nil, errors.New("not connected")
+}
+
+func Test_resolve(t *testing.T) {
+	const fqdn = "echo.blue.svc.cluster.local"
+	cc := &resolveConnector{results: map[string]*connector.ResolveResult{
+		"echo A": {Name: fqdn, Answers: []*connector.ResolveAnswer{
+			{Name: fqdn, Type: "A", Ttl: 60, Ip: net.IP{10, 96, 0, 10}},
+		}},
+		"echo AAAA": {Name: fqdn, Answers: []*connector.ResolveAnswer{
+			{Name: fqdn, Type: "AAAA", Ttl: 60, Ip: net.ParseIP("fd00:96::10")},
+		}},
+		"echo.red AAAA": {Name: "echo.red.svc.cluster.local"},
+		"_http._tcp.echo SRV": {Name: "_http._tcp." + fqdn, Answers: []*connector.ResolveAnswer{
+			{Name: "_http._tcp." + fqdn, Type: "SRV", Ttl: 60, Target: fqdn + ".", Port: 80, Weight: 100},
+		}},
+		"nothing A": {Rcode: connector.ResolveResult_NXDOMAIN, Name: "nothing"},
+		"echo.down A": {
+			Rcode:         connector.ResolveResult_SERVFAIL,
+			Name:          "echo.down.blue.svc.cluster.local",
+			ErrorText:     "connection refused",
+			ErrorCategory: int32(errcat.Unknown),
+		},
+	}}
+	ctx := dlog.NewTestContext(t, false)
+	run := func(name, qType, output string) (string, error) {
+		out := &bytes.Buffer{}
+		err := resolve(ctx, out, cc, &connector.ResolveRequest{Name: name, Type: qType}, output)
+		return out.String(), err
+	}
+	exitCode := func(t *testing.T, err error) int {
+		t.Helper()
+		var codeErr *ExitCodeError
+		require.True(t, errors.As(err, &codeErr), "expected an ExitCodeError, got %v", err)
+		return codeErr.Code
+	}
+
+	t.Run("A", func(t *testing.T) {
+		out, err := run("echo", "A", "")
+		require.NoError(t, err)
+		assert.Equal(t, "echo.blue.svc.cluster.local.\t60\tIN\tA\t10.96.0.10\n", out)
+	})
+
+	t.Run("AAAA", func(t *testing.T) {
+		out, err := run("echo", "AAAA", "")
+		require.NoError(t, err)
+		assert.Equal(t, "echo.blue.svc.cluster.local.\t60\tIN\tAAAA\tfd00:96::10\n", out)
+
+		out, err = run("echo.red", "AAAA", "")
+		require.NoError(t, err)
+		assert.Equal(t, "echo.red.svc.cluster.local has no AAAA records\n", out)
+	})
+
+	t.Run("SRV", func(t *testing.T) {
+		out, err := run("_http._tcp.echo", "SRV", "")
+		require.NoError(t, err)
+		assert.Equal(t, "_http._tcp.echo.blue.svc.cluster.local.\t60\tIN\tSRV\t0 100 80 echo.blue.svc.cluster.local.\n", out)
+
+		out, err = run("_http._tcp.echo", "SRV", "json")
+		require.NoError(t, err)
+		assert.JSONEq(t, `{
+  "name": "_http._tcp.echo.blue.svc.cluster.local",
+  "status": "NOERROR",
+  "answers": [{
+    "name": "_http._tcp.echo.blue.svc.cluster.local",
+    "type": "SRV",
+    "ttl": 60,
+    "target": "echo.blue.svc.cluster.local.",
+    "port": 80,
+    "priority": 0,
+    "weight": 100
+  }]
+}`, out)
+	})
+
+	t.Run("NXDOMAIN", func(t *testing.T) {
+		out, err := run("nothing", "A", "")
+		assert.Equal(t, ExitNotFound, exitCode(t, err))
+		assert.Equal(t, "nothing doesn't exist in the cluster", err.Error())
+		assert.Empty(t, out)
+
+		// The JSON output has the status, so the error has no message
+		out, err = run("nothing", "A", "json")
+		assert.Equal(t, ExitNotFound, exitCode(t, err))
+		assert.Equal(t, "exit status 5", err.Error())
+		assert.JSONEq(t, `{"name": "nothing", "status": "NXDOMAIN", "answers": []}`, out)
+	})
+
+	t.Run("SERVFAIL", func(t *testing.T) {
+		out, err := run("echo.down", "A", "")
+		assert.Equal(t, ExitLookupFailed, exitCode(t, err))
+		assert.Equal(t, "lookup of echo.down.blue.svc.cluster.local failed: connection refused", err.Error())
+		assert.Empty(t, out)
+
+		out, err = run("echo.down", "A", "json")
+		assert.Equal(t, ExitLookupFailed, exitCode(t, err))
+		assert.JSONEq(t, `{
+  "name": "echo.down.blue.svc.cluster.local",
+  "status": "SERVFAIL",
+  "answers": [],
+  "error": "connection refused"
+}`, out)
+	})
+
+	t.Run("connector error", func(t *testing.T) {
+		_, err := run("echo", "MX", "")
+		require.Error(t, err)
+		var codeErr *ExitCodeError
+		assert.False(t, errors.As(err, &codeErr))
+	})
+}
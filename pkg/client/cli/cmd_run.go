@@ -0,0 +1,232 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/extensions"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// runHandler and waitForInterrupt are variables so that tests can run a spec without starting
+// processes or waiting for signals.
+var (
+	runHandler = func(ctx context.Context, is *interceptState) error {
+		return is.runCommand(ctx)
+	}
+	waitForInterrupt = func(ctx context.Context) {
+		ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+		<-ctx.Done()
+	}
+)
+
+func runSpecCommand() *cobra.Command {
+	var file string
+	cmd := &cobra.Command{
+		Use:  "run --file <spec file>",
+		Args: cobra.NoArgs,
+
+		Short: "Run the intercepts declared in a spec file",
+		Long: `Run the intercepts declared in a spec file
+
+Connects to the cluster of the spec and establishes its intercepts in the order that they are declared.
+The handler of each intercept is then started with the environment of the intercepted container merged
+over the local environment. All intercepts are left when the handlers have exited, when one of them
+fails, or, if there are no handlers, when the command is interrupted. The intercepts that were
+established are also left when establishing another one fails.
+
+The format of the spec file is:
+
+  connection:
+    context: my-cluster        # the kubeconfig context, defaults to the current context
+    namespace: blue            # the default namespace of the intercepts
+  intercepts:
+    - name: echo               # required, the name of the intercept
+      workload: echo-server    # the workload to intercept, defaults to the name
+      namespace: green         # defaults to the namespace of the connection
+      service: echo            # the service to intercept, auto-detected when omitted
+      ports: [8080, 9090:grpc] # intercepted ports like --port, defaults to 8080
+      mount: false             # like --mount, defaults to true
+      mountType: sftp          # like --mount-type, defaults to auto
+      envFile: echo.env        # like --env-file
+      envJSON: echo.json       # like --env-json
+      toPod: [8081]            # like --to-pod
+      httpHeaders: [x-user=me] # like --http-header
+      httpPathPrefix: /api     # like --http-path-prefix
+      replace: false           # like --replace
+      mechanism: tcp           # like --mechanism
+      handler: [go, run, .]    # the command to run while the intercepts are active
+
+Relative paths are relative to the directory of the spec file.`,
+		PreRunE:  updateCheckIfDue,
+		PostRunE: raiseCloudMessage,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			spec, err := loadRunSpec(file)
+			if err != nil {
+				return err
+			}
+			if err = applyConnectionSpec(&spec.Connection); err != nil {
+				return err
+			}
+			return withConnector(cmd, false, func(ctx context.Context, connectorClient connector.ConnectorClient, connInfo *connector.ConnectInfo) error {
+				return withManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
+					return runIntercepts(ctx, safeCobraCommandImpl{cmd}, spec, connectorClient, managerClient, connInfo)
+				})
+			})
+		},
+	}
+	cmd.Flags().StringVarP(&file, "file", "f", "", "The spec file that declares the connection and the intercepts")
+	_ = cmd.MarkFlagRequired("file")
+	return cmd
+}
+
+// applyConnectionSpec sets the kubernetes flags that the connection of a spec declares. A flag that is
+// also given on the command line must have the same value.
+func applyConnectionSpec(cs *connectionSpec) error {
+	if cs.Context == "" {
+		return nil
+	}
+	if flag := kubeFlags.Lookup("context"); flag.Changed && flag.Value.String() != cs.Context {
+		return errcat.User.Newf("the --context %q conflicts with the context %q of the spec", flag.Value.String(), cs.Context)
+	}
+	return kubeFlags.Set("context", cs.Context)
+}
+
+// runIntercepts establishes the intercepts of the spec in order and runs their handlers. The intercepts that
+// were established are left in reverse order when the handlers are done, or when establishing an
+// intercept fails, so that nothing of the spec remains active when it returns.
+func runIntercepts(
+	ctx context.Context,
+	cmd safeCobraCommand,
+	spec *runSpec,
+	connectorClient connector.ConnectorClient,
+	managerClient manager.ManagerClient,
+	connInfo *connector.ConnectInfo,
+) (err error) {
+	var active []*interceptState
+	defer func() {
+		for i := len(active) - 1; i >= 0; i-- {
+			is := active[i]
+			if leaveErr := is.DeactivateState(ctx); leaveErr != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Failed to leave intercept %s: %v\n", is.args.name, leaveErr)
+				if err == nil {
+					err = leaveErr
+				}
+			}
+		}
+	}()
+
+	for _, ic := range spec.Intercepts {
+		args, argsErr := ic.interceptArgs(ctx)
+		if argsErr != nil {
+			return argsErr
+		}
+		if loginErr := loginIfNeeded(ctx, args); loginErr != nil {
+			return loginErr
+		}
+		is := newInterceptState(ctx, cmd, args, connectorClient, managerClient, connInfo)
+		acquired, ensureErr := is.EnsureState(ctx)
+		if acquired {
+			active = append(active, is)
+		}
+		if ensureErr != nil {
+			return fmt.Errorf("intercept %s: %w", ic.Name, ensureErr)
+		}
+	}
+	return runHandlers(ctx, cmd, active)
+}
+
+// runHandlers runs the handlers of the given intercepts concurrently and returns when all of them have
+// exited. The remaining handlers are stopped when one of them fails. When none of the intercepts has a
+// handler, it waits for an interrupt instead.
+func runHandlers(ctx context.Context, cmd safeCobraCommand, active []*interceptState) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for _, is := range active {
+		if len(is.args.cmdline) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(is *interceptState) {
+			defer wg.Done()
+			if err := runHandler(ctx, is); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("handler of intercept %s: %w", is.args.name, err)
+				}
+				mu.Unlock()
+				cancel()
+			}
+		}(is)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	for _, is := range active {
+		if len(is.args.cmdline) > 0 {
+			return nil
+		}
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), "All intercepts are active. Press Ctrl-C to leave them.")
+	waitForInterrupt(ctx)
+	return nil
+}
+
+// interceptArgs returns the arguments that the intercept command would have for this intercept.
+func (ic *interceptSpec) interceptArgs(ctx context.Context) (interceptArgs, error) {
+	flags := pflag.NewFlagSet("", 0)
+	extState, err := extensions.LoadExtensions(ctx, flags)
+	if err != nil {
+		return interceptArgs{}, err
+	}
+	if ic.Mechanism != "" {
+		if err = flags.Set("mechanism", ic.Mechanism); err != nil {
+			return interceptArgs{}, errcat.User.Newf("intercept %s: %w", ic.Name, err)
+		}
+	}
+	requiresLogin, err := extState.RequiresAPIKeyOrLicense()
+	if err != nil {
+		return interceptArgs{}, err
+	}
+	mountType := ic.MountType
+	if mountType == "" {
+		mountType = mountTypeAuto
+	}
+	return interceptArgs{
+		name:             ic.Name,
+		agentName:        ic.Workload,
+		namespace:        ic.Namespace,
+		ports:            ic.Ports,
+		serviceName:      ic.Service,
+		previewSpec:      &manager.PreviewSpec{},
+		envFile:          ic.EnvFile,
+		envJSON:          ic.EnvJSON,
+		mount:            ic.Mount,
+		mountSet:         ic.mountSet,
+		mountType:        mountType,
+		toPod:            ic.ToPod,
+		httpHeaders:      ic.HTTPHeaders,
+		httpPathPrefix:   ic.HTTPPathPrefix,
+		replace:          ic.Replace,
+		extState:         extState,
+		extRequiresLogin: requiresLogin,
+		cmdline:          ic.Handler,
+	}, nil
+}
@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// specConnector is a user daemon that records the intercepts that are created and removed. Creating
+// an intercept that is listed in failing fails.
+type specConnector struct {
+	connector.ConnectorClient
+	sync.Mutex
+	calls   []string
+	failing map[string]bool
+}
+
+func (c *specConnector) record(call string) {
+	c.Lock()
+	c.calls = append(c.calls, call)
+	c.Unlock()
+}
+
+func (c *specConnector) CreateIntercept(_ context.Context, ir *connector.CreateInterceptRequest, _ ...grpc.CallOption) (*connector.InterceptResult, error) {
+	name := ir.Spec.Name
+	c.record("create " + name)
+	if c.failing[name] {
+		return &connector.InterceptResult{Error: connector.InterceptError_NO_ACCEPTABLE_WORKLOAD, ErrorText: ir.Spec.Agent}, nil
+	}
+	return &connector.InterceptResult{
+		InterceptInfo: &manager.InterceptInfo{
+			Id:          "id-" + name,
+			Spec:        ir.Spec,
+			Disposition: manager.InterceptDispositionType_ACTIVE,
+		},
+		WorkloadKind: "Deployment",
+		Environment:  map[string]string{"INTERCEPTED": name},
+	}, nil
+}
+
+func (c *specConnector) RemoveIntercept(_ context.Context, rr *connector.RemoveInterceptRequest, _ ...grpc.CallOption) (*connector.InterceptResult, error) {
+	c.record("remove " + rr.Name)
+	return &connector.InterceptResult{}, nil
+}
+
+// withFakeHandlers makes the handlers record their intercept and environment instead of running,
+// and fail when their intercept is in failing.
+func withFakeHandlers(t *testing.T, cc *specConnector, failing map[string]bool) {
+	oldRun, oldWait := runHandler, waitForInterrupt
+	runHandler = func(ctx context.Context, is *interceptState) error {
+		cc.record("run " + is.args.name + " " + is.env["INTERCEPTED"])
+		if failing[is.args.name] {
+			return errors.New("exited with 1")
+		}
+		return nil
+	}
+	waitForInterrupt = func(context.Context) { cc.record("interrupt") }
+	t.Cleanup(func() { runHandler, waitForInterrupt = oldRun, oldWait })
+}
+
+func Test_runIntercepts(t *testing.T) {
+	ctx := client.WithEnv(dlog.NewTestContext(t, false), &client.Env{ScoutDisable: "1"})
+	cfg := client.GetDefaultConfig(ctx)
+	cfg.Images.AgentImage = "tel2:2.4.5"
+	ctx = client.WithConfig(ctx, &cfg)
+	ctx = filelocation.WithAppUserConfigDir(ctx, t.TempDir())
+
+	const withHandlers = `
+intercepts:
+  - name: echo
+    mount: false
+    handler: [echo-server]
+  - name: db
+    ports: 5432
+    mount: false
+    handler: [db-proxy]
+`
+	const withoutHandlers = `
+intercepts:
+  - name: echo
+    mount: false
+  - name: db
+    mount: false
+`
+	tests := []struct {
+		name            string
+		spec            string
+		failingCreate   map[string]bool
+		failingHandlers map[string]bool
+		expectedError   string
+		expectedCalls   []string
+	}{
+		{
+			name: "handlers",
+			spec: withHandlers,
+			expectedCalls: []string{
+				"create echo", "create db", "run db db", "run echo echo", "remove db", "remove echo",
+			},
+		},
+		{
+			name:          "no handlers",
+			spec:          withoutHandlers,
+			expectedCalls: []string{"create echo", "create db", "interrupt", "remove db", "remove echo"},
+		},
+		{
+			name:          "create fails",
+			spec:          withHandlers,
+			failingCreate: map[string]bool{"db": true},
+			expectedError: "intercept db: No interceptable deployment or replicaset matching db found",
+			expectedCalls: []string{"create echo", "create db", "remove echo"},
+		},
+		{
+			name:            "handler fails",
+			spec:            withHandlers,
+			failingHandlers: map[string]bool{"echo": true},
+			expectedError:   "handler of intercept echo: exited with 1",
+			expectedCalls: []string{
+				"create echo", "create db", "run db db", "run echo echo", "remove db", "remove echo",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := parseRunSpec("spec.yaml", []byte(tt.spec))
+			require.NoError(t, err)
+
+			cc := &specConnector{failing: tt.failingCreate}
+			withFakeConnector(t, cc)
+			withFakeHandlers(t, cc, tt.failingHandlers)
+
+			cmd := &cobra.Command{}
+			cmd.SetOut(&bytes.Buffer{})
+			cmd.SetErr(&bytes.Buffer{})
+			err = runIntercepts(ctx, safeCobraCommandImpl{cmd}, spec, cc, nil, &connector.ConnectInfo{})
+			if tt.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+			} else {
+				require.NoError(t, err)
+			}
+
+			// The handlers run concurrently, so the order in which they are started is arbitrary
+			calls := cc.calls
+			if len(calls) > 3 && strings.HasPrefix(calls[2], "run ") {
+				sort.Strings(calls[2:4])
+			}
+			assert.Equal(t, tt.expectedCalls, calls)
+		})
+	}
+}
@@ -2,171 +2,1078 @@ package cli
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/common"
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
 	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/routing"
 )
 
+type statusInfo struct {
+	// State is the state of the daemons that the exit code of the status command reflects.
+	State      string            `json:"state"`
+	Error      string            `json:"error,omitempty"`
+	RootDaemon *rootDaemonStatus `json:"root_daemon"`
+	UserDaemon *userDaemonStatus `json:"user_daemon"`
+	Network    *networkStatus    `json:"network"`
+	WSL        *wslStatus        `json:"wsl,omitempty"`
+	Telemetry  *telemetryStatus  `json:"telemetry,omitempty"`
+}
+
+// wslStatus describes how the CLI in a WSL2 distribution reaches the daemons on Windows.
+type wslStatus struct {
+	Distro       string `json:"distro"`
+	WindowsExe   string `json:"windows_exe"`
+	EndpointFile string `json:"endpoint_file"`
+	Endpoint     string `json:"endpoint,omitempty"`
+	Interface    string `json:"interface,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+type telemetryStatus struct {
+	Enabled bool   `json:"enabled"`
+	Source  string `json:"source"`
+}
+
+type rootDaemonStatus struct {
+	Running          bool     `json:"running"`
+	Container        string   `json:"container,omitempty"`
+	Windows          bool     `json:"windows,omitempty"`
+	Version          string   `json:"version,omitempty"`
+	APIVersion       int32    `json:"api_version,omitempty"`
+	StartedBy        string   `json:"started_by,omitempty"`
+	AlsoProxy        []string `json:"also_proxy,omitempty"`
+	NeverProxy       []string `json:"never_proxy,omitempty"`
+	Conflicts        []string `json:"subnet_conflicts,omitempty"`
+	SessionConflicts []string `json:"session_conflicts,omitempty"`
+	Metrics          string   `json:"metrics,omitempty"`
+}
+
+// networkStatus describes the TUN device, the subnets routed to it, and the DNS servers of the root
+// daemon. It is unavailable when the root daemon isn't running or runs in a container.
+type networkStatus struct {
+	Available       bool           `json:"available"`
+	Reason          string         `json:"reason,omitempty"`
+	TunName         string         `json:"tun_name,omitempty"`
+	TunMTU          int32          `json:"tun_mtu,omitempty"`
+	TunMTULimitedBy string         `json:"tun_mtu_limited_by,omitempty"`
+	RoutedSubnets   []routedSubnet `json:"routed_subnets,omitempty"`
+
+	// OutboundTrafficPolicy is set when only the IPs in the mapped namespaces are routed, using
+	// ManagedRoutes host routes.
+	OutboundTrafficPolicy string `json:"outbound_traffic_policy,omitempty"`
+	ManagedRoutes         int32  `json:"managed_routes,omitempty"`
+
+	// GatewayRoutes route the cluster subnets of local clusters via their docker network.
+	GatewayRoutes []gatewayRoute `json:"gateway_routes,omitempty"`
+
+	// Connections is the connection table of the root daemon.
+	Connections *connectionsStatus `json:"connections,omitempty"`
+
+	DNS *dnsStatus `json:"dns,omitempty"`
+}
+
+type connectionsStatus struct {
+	Tracked        int32  `json:"tracked"`
+	MaxTracked     int32  `json:"max_tracked,omitempty"`
+	IdleEvictions  uint64 `json:"idle_evictions"`
+	LimitEvictions uint64 `json:"limit_evictions"`
+	Refused        uint64 `json:"refused"`
+}
+
+type routedSubnet struct {
+	Subnet string `json:"subnet"`
+	Source string `json:"source"`
+}
+
+type gatewayRoute struct {
+	Subnet  string `json:"subnet"`
+	Gateway string `json:"gateway"`
+}
+
+type dnsStatus struct {
+	Mode            string   `json:"mode,omitempty"`
+	Listener        string   `json:"listener,omitempty"`
+	Forwarder       string   `json:"forwarder,omitempty"`
+	LocalIP         string   `json:"local_ip,omitempty"`
+	RemoteIP        string   `json:"remote_ip,omitempty"`
+	ExcludeSuffixes []string `json:"exclude_suffixes"`
+	IncludeSuffixes []string `json:"include_suffixes"`
+	SearchPaths     []string `json:"search_paths"`
+	LookupTimeout   string   `json:"lookup_timeout"`
+}
+
+type userDaemonStatus struct {
+	Running         bool   `json:"running"`
+	Container       string `json:"container,omitempty"`
+	Windows         bool   `json:"windows,omitempty"`
+	Version         string `json:"version,omitempty"`
+	APIVersion      int32  `json:"api_version,omitempty"`
+	AmbassadorCloud string `json:"ambassador_cloud,omitempty"`
+	Metrics         string `json:"metrics,omitempty"`
+	sessionStatus
+
+	// Sessions are the statuses of the sessions when the user daemon has more than one.
+	Sessions []*sessionStatus `json:"sessions,omitempty"`
+}
+
+// sessionStatus describes a session of the user daemon.
+type sessionStatus struct {
+	Status              string            `json:"status,omitempty"`
+	ReconnectingSince   string            `json:"reconnecting_since,omitempty"`
+	Error               string            `json:"error,omitempty"`
+	KubernetesServer    string            `json:"kubernetes_server,omitempty"`
+	KubernetesContext   string            `json:"kubernetes_context,omitempty"`
+	KubernetesNamespace string            `json:"kubernetes_namespace,omitempty"`
+	ManagerNamespace    string            `json:"manager_namespace,omitempty"`
+	AgentImage          string            `json:"agent_image,omitempty"`
+	AgentImageSource    string            `json:"agent_image_source,omitempty"`
+	ManagedNamespaces   []string          `json:"managed_namespaces,omitempty"`
+	MappedNamespaces    []string          `json:"mapped_namespaces,omitempty"`
+	ProxyOK             bool              `json:"proxy_ok"`
+	ProxyMode           string            `json:"proxy_mode,omitempty"`
+	ProxyPorts          *proxyPortsStatus `json:"proxy_ports,omitempty"`
+	ProxySOCKS5         *proxySOCKS5      `json:"proxy_socks5,omitempty"`
+	RoutingStrategy     *routingStrategy  `json:"routing_strategy,omitempty"`
+	Intercepts          []interceptStatus `json:"intercepts,omitempty"`
+	PortForwards        []string          `json:"port_forwards,omitempty"`
+	RemoteLogLevels     *remoteLogLevels  `json:"remote_log_levels,omitempty"`
+	connected           bool
+}
+
+// routingStrategy tells how the cluster subnets of a session are routed when the proxy mode is "tun".
+type routingStrategy struct {
+	Strategy string `json:"strategy"`
+	Provider string `json:"provider,omitempty"`
+	Gateway  string `json:"gateway,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// remoteLogLevels are the temporary log-levels of the traffic-manager and traffic-agents.
+type remoteLogLevels struct {
+	TrafficManager *remoteLogLevel `json:"traffic_manager,omitempty"`
+	AllAgents      bool            `json:"all_agents,omitempty"`
+	Agents         []agentLogLevel `json:"agents,omitempty"`
+}
+
+type remoteLogLevel struct {
+	LogLevel string `json:"log_level"`
+	Expires  string `json:"expires,omitempty"`
+}
+
+type agentLogLevel struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	PodIP     string `json:"pod_ip"`
+	remoteLogLevel
+}
+
+// proxyPortsStatus describes how the services are reached when the proxy mode is "ports".
+type proxyPortsStatus struct {
+	Limitations string            `json:"limitations"`
+	DNSListener string            `json:"dns_listener,omitempty"`
+	HostsFile   string            `json:"hosts_file,omitempty"`
+	Ports       []proxyPortStatus `json:"ports"`
+}
+
+// proxySOCKS5 describes the SOCKS5 proxy that the cluster is reached through when the proxy mode is "socks5".
+type proxySOCKS5 struct {
+	Address     string `json:"address"`
+	Limitations string `json:"limitations"`
+}
+
+type proxyPortStatus struct {
+	Service   string `json:"service"`
+	Port      int32  `json:"port"`
+	PortName  string `json:"port_name,omitempty"`
+	LocalPort int32  `json:"local_port"`
+}
+
+type interceptStatus struct {
+	Name     string `json:"name"`
+	Client   string `json:"client"`
+	Forwards int32  `json:"forwards"`
+	Pending  bool   `json:"pending,omitempty"`
+}
+
+// withStartedDaemon is a variable so that tests can fake the root daemon.
+var withStartedDaemon = cliutil.WithStartedDaemon
+
+// getRemoteLogLevels is a variable so that tests can fake the traffic-manager.
+var getRemoteLogLevels = func(ctx context.Context, session *manager.SessionInfo) (rl *manager.RemoteLogLevels, err error) {
+	err = cliutil.WithStartedManager(client.WithSessionContext(ctx, sessionContext()), func(ctx context.Context, managerClient manager.ManagerClient) error {
+		rl, err = managerClient.GetRemoteLogLevels(ctx, session)
+		return err
+	})
+	return rl, err
+}
+
+// statusStates are the names of the states that the exit codes of the status command represent.
+var statusStates = map[int]string{
+	StatusConnected:    "connected",
+	StatusDisconnected: "disconnected",
+	StatusNotRunning:   "not_running",
+	StatusError:        "error",
+}
+
 func statusCommand() *cobra.Command {
-	return &cobra.Command{
+	var output string
+	var quiet bool
+	cmd := &cobra.Command{
 		Use:  "status",
 		Args: cobra.NoArgs,
 
 		Short: "Show connectivity status",
-		RunE:  status,
+		Long: `Show connectivity status
+
+The exit code tells the state of the daemons:
+  0  both daemons are running and connected to a cluster, or the user daemon
+     is connected using proxy mode "ports" or "socks5", which need no root
+     daemon
+  1  one or both daemons are running, but not connected to a cluster
+  2  no daemon is running
+  3  the status could not be retrieved from the daemons
+`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return status(cmd, output, quiet)
+		},
 	}
+	flags := cmd.Flags()
+	flags.StringVarP(&output, "output", "o", "", `Set the output format. The only supported format is "json"`)
+	flags.BoolVarP(&quiet, "quiet", "q", false, "Don't print anything, the exit code tells the state of the daemons")
+	return cmd
 }
 
-// status will retrieve connectivity status from the daemon and print it on stdout.
-func status(cmd *cobra.Command, _ []string) error {
-	if err := daemonStatus(cmd); err != nil {
-		return err
+// status will retrieve connectivity status from the daemons and print it on stdout. The returned error
+// is an *ExitCodeError unless the daemons are connected.
+func status(cmd *cobra.Command, output string, quiet bool) error {
+	if output != "" && output != "json" {
+		return errcat.User.Newf("unsupported output format %q", output)
+	}
+	si, err := collectStatus(cmd.Context())
+	code := StatusError
+	if err == nil {
+		code = si.exitCode()
+	} else {
+		si.Error = err.Error()
+	}
+	si.State = statusStates[code]
+	if !quiet {
+		if output == "json" {
+			if err := si.writeJSON(cmd.OutOrStdout()); err != nil {
+				return err
+			}
+		} else if err == nil {
+			si.writeText(cmd.OutOrStdout())
+		}
+	}
+	if code == StatusConnected {
+		return nil
+	}
+	codeErr := &ExitCodeError{Code: code}
+	if err != nil && !quiet && output != "json" {
+		codeErr.Err = err
 	}
+	return codeErr
+}
 
-	if err := connectorStatus(cmd); err != nil {
-		return err
+// collectStatus retrieves the status of the daemons. The returned status is incomplete when an error
+// is returned.
+func collectStatus(ctx context.Context) (*statusInfo, error) {
+	si := &statusInfo{}
+	dd, err := cliutil.DockerDaemon(ctx)
+	if err != nil {
+		return si, err
+	}
+	wd, err := cliutil.WindowsDaemons(ctx)
+	if err != nil {
+		return si, err
+	}
+	switch {
+	case dd != nil:
+		// The root daemon in the container isn't reachable from the host
+		si.RootDaemon = &rootDaemonStatus{Running: true, Container: dd.ContainerName}
+		si.Network = &networkStatus{Reason: "the root daemon runs in Docker container " + dd.ContainerName}
+	case wd != nil:
+		// The root daemon on Windows isn't reachable from WSL
+		si.RootDaemon = &rootDaemonStatus{Running: true, Windows: true}
+		si.Network = &networkStatus{Reason: "the root daemon runs on Windows"}
+		si.WSL = newWSLStatus(wd)
+	default:
+		if si.RootDaemon, si.Network, err = daemonStatus(ctx); err != nil {
+			return si, err
+		}
+	}
+	if si.UserDaemon, err = connectorStatus(ctx); err != nil {
+		return si, err
 	}
+	if si.UserDaemon.Running {
+		switch {
+		case dd != nil:
+			si.UserDaemon.Container = dd.ContainerName
+		case wd != nil:
+			si.UserDaemon.Windows = true
+		}
+	}
+	if dd == nil && wd == nil {
+		// The daemons serve their metrics on the addresses of the config that they share with the CLI
+		mc := client.GetConfig(ctx).Metrics
+		if si.RootDaemon.Running {
+			si.RootDaemon.Metrics = metricsURL(mc.DaemonAddress(true))
+		}
+		if si.UserDaemon.Running {
+			si.UserDaemon.Metrics = metricsURL(mc.DaemonAddress(false))
+		}
+	}
+	si.checkGatewayRoutes()
+	enabled, source := client.GetTelemetry(ctx)
+	si.Telemetry = &telemetryStatus{Enabled: enabled, Source: string(source)}
+	return si, nil
+}
+
+// newWSLStatus returns the status of the WSL endpoint of the user daemon on Windows of the given record.
+func newWSLStatus(wd *cache.WindowsDaemons) *wslStatus {
+	ws := &wslStatus{Distro: wd.Distro, WindowsExe: wd.Exe, EndpointFile: wd.EndpointFile}
+	if ep, err := cliutil.WindowsEndpoint(wd); err == nil {
+		ws.Endpoint = ep.Address
+		ws.Interface = ep.Interface
+	} else {
+		ws.Error = err.Error()
+	}
+	return ws
+}
+
+// checkGatewayRoutes changes the routing strategy of the sessions that use the docker network of a local
+// cluster to "tunnel" when the root daemon has no routes via their gateway. The root daemon routes the
+// cluster subnets of such a session to the TUN device when it fails to add the routes.
+func (si *statusInfo) checkGatewayRoutes() {
+	if si.Network == nil || !si.Network.Available || !si.UserDaemon.Running {
+		return
+	}
+	sessions := si.UserDaemon.Sessions
+	if len(sessions) == 0 {
+		sessions = []*sessionStatus{&si.UserDaemon.sessionStatus}
+	}
+	for _, ss := range sessions {
+		rs := ss.RoutingStrategy
+		if rs == nil || rs.Strategy != client.RoutingStrategyDockerNetwork {
+			continue
+		}
+		found := false
+		for _, gr := range si.Network.GatewayRoutes {
+			if gr.Gateway == rs.Gateway {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ss.RoutingStrategy = &routingStrategy{
+				Strategy: client.RoutingStrategyTunnel,
+				Provider: rs.Provider,
+				Reason:   "the root daemon was unable to add the routes via " + rs.Gateway,
+			}
+		}
+	}
+}
 
-	return nil
+// exitCode returns the exit code of the status command that reflects the given status.
+func (si *statusInfo) exitCode() int {
+	us := si.UserDaemon
+	switch {
+	case !si.RootDaemon.Running && !us.Running:
+		return StatusNotRunning
+	case us.Running && us.isConnected() && (si.RootDaemon.Running || !us.needsRootDaemon()):
+		return StatusConnected
+	default:
+		return StatusDisconnected
+	}
 }
 
-func daemonStatus(cmd *cobra.Command) error {
-	out := cmd.OutOrStdout()
+// isConnected returns true if the user daemon has a session, or one of its sessions is connected.
+func (us *userDaemonStatus) isConnected() bool {
+	if us.connected {
+		return true
+	}
+	for _, ss := range us.Sessions {
+		if ss.connected {
+			return true
+		}
+	}
+	return false
+}
 
-	err := cliutil.WithStartedDaemon(cmd.Context(), func(ctx context.Context, daemonClient daemon.DaemonClient) error {
-		var err error
-		status, err := daemonClient.Status(cmd.Context(), &empty.Empty{})
+// needsRootDaemon returns true if one of the connected sessions of the user daemon uses the root daemon,
+// i.e. it uses neither the proxy mode "ports" nor the proxy mode "socks5".
+func (us *userDaemonStatus) needsRootDaemon() bool {
+	sessions := us.Sessions
+	if len(sessions) == 0 {
+		sessions = []*sessionStatus{&us.sessionStatus}
+	}
+	for _, ss := range sessions {
+		if ss.connected && ss.ProxyMode != client.ProxyModePorts && ss.ProxyMode != client.ProxyModeSOCKS5 {
+			return true
+		}
+	}
+	return false
+}
+
+// metricsURL returns the URL of the metrics that are served on the given address, or an empty string
+// when the address is empty.
+func metricsURL(address string) string {
+	if address == "" {
+		return ""
+	}
+	return "http://" + address + "/metrics"
+}
+
+func daemonStatus(ctx context.Context) (*rootDaemonStatus, *networkStatus, error) {
+	var ds *rootDaemonStatus
+	var ns *networkStatus
+	err := withStartedDaemon(ctx, func(ctx context.Context, daemonClient daemon.DaemonClient) error {
+		status, err := daemonClient.Status(ctx, &empty.Empty{})
 		if err != nil {
 			return err
 		}
-		version, err := daemonClient.Version(cmd.Context(), &empty.Empty{})
+		version, err := daemonClient.Version(ctx, &empty.Empty{})
 		if err != nil {
 			return err
 		}
-
-		dns := status.OutboundConfig.Dns
-		fmt.Fprintln(out, "Root Daemon: Running")
-		fmt.Fprintf(out, "  Version   : %s (api %d)\n", version.Version, version.ApiVersion)
-		fmt.Fprintf(out, "  DNS       :\n")
-		if dns.LocalIp != nil {
-			// Local IP is only set when the overriding resolver is used
-			fmt.Fprintf(out, "    Local IP        : %v\n", net.IP(dns.LocalIp))
-		}
-		fmt.Fprintf(out, "    Remote IP       : %v\n", net.IP(dns.RemoteIp))
-		fmt.Fprintf(out, "    Exclude suffixes: %v\n", dns.ExcludeSuffixes)
-		fmt.Fprintf(out, "    Include suffixes: %v\n", dns.IncludeSuffixes)
-		fmt.Fprintf(out, "    Timeout         : %v\n", dns.LookupTimeout.AsDuration())
-		fmt.Fprintf(out, "  Also Proxy: (%d subnets)\n", len(status.OutboundConfig.AlsoProxySubnets))
-		for _, subnet := range status.OutboundConfig.AlsoProxySubnets {
-			fmt.Fprintf(out, "    - %s\n", iputil.IPNetFromRPC(subnet))
-		}
-
+		ds = newRootDaemonStatus(status, version)
+		ns = newNetworkStatus(status)
 		return nil
 	})
 	if err != nil {
 		if errors.Is(err, cliutil.ErrNoDaemon) {
-			fmt.Fprintln(out, "Root Daemon: Not running")
-			return nil
+			return &rootDaemonStatus{}, notRunningNetworkStatus(), nil
 		}
-		return err
+		return nil, nil, err
 	}
-	return nil
+	return ds, ns, nil
 }
 
-func connectorStatus(cmd *cobra.Command) error {
-	out := cmd.OutOrStdout()
+func notRunningNetworkStatus() *networkStatus {
+	return &networkStatus{Reason: "the root daemon is not running"}
+}
 
-	err := cliutil.WithStartedConnector(cmd.Context(), func(ctx context.Context, connectorClient connector.ConnectorClient) error {
-		fmt.Fprintln(out, "User Daemon: Running")
+// routedSubnetSources are the names used for the sources of routed subnets.
+var routedSubnetSources = map[daemon.RoutedSubnet_Source]string{
+	daemon.RoutedSubnet_UNSPECIFIED: "unknown",
+	daemon.RoutedSubnet_SERVICES:    "serviceCIDR",
+	daemon.RoutedSubnet_PODS:        "podCIDR",
+	daemon.RoutedSubnet_ALSO_PROXY:  "alsoProxy",
+}
 
-		type kv struct {
-			Key   string
-			Value string
-		}
-		var fields []kv
-		defer func() {
-			klen := 0
-			for _, kv := range fields {
-				if len(kv.Key) > klen {
-					klen = len(kv.Key)
-				}
-			}
-			for _, kv := range fields {
-				vlines := strings.Split(strings.TrimSpace(kv.Value), "\n")
-				fmt.Fprintf(out, "  %-*s: %s\n", klen, kv.Key, vlines[0])
-				for _, vline := range vlines[1:] {
-					fmt.Fprintf(out, "    %s\n", vline)
-				}
-			}
-		}()
+func newRootDaemonStatus(status *daemon.DaemonStatus, version *common.VersionInfo) *rootDaemonStatus {
+	ds := &rootDaemonStatus{
+		Running:    true,
+		Version:    version.Version,
+		APIVersion: version.ApiVersion,
+		StartedBy:  "sudo",
+	}
+	switch {
+	case status.PrivilegedHelper:
+		ds.StartedBy = "privileged helper"
+	case status.WindowsService:
+		ds.StartedBy = "Windows service"
+	}
+	for _, subnet := range status.GetOutboundConfig().GetAlsoProxySubnets() {
+		ds.AlsoProxy = append(ds.AlsoProxy, iputil.IPNetFromRPC(subnet).String())
+	}
+	for _, subnet := range status.GetOutboundConfig().GetNeverProxySubnets() {
+		ds.NeverProxy = append(ds.NeverProxy, iputil.IPNetFromRPC(subnet).String())
+	}
+	for _, c := range status.SubnetConflicts {
+		ds.Conflicts = append(ds.Conflicts, routing.ConflictFromRPC(c).String())
+	}
+	for _, c := range status.SessionConflicts {
+		ds.SessionConflicts = append(ds.SessionConflicts, fmt.Sprintf("subnet %s of session %s overlaps with subnet %s of session %s",
+			iputil.IPNetFromRPC(c.Subnet), c.SessionName, iputil.IPNetFromRPC(c.OtherSubnet), c.OtherSessionName))
+	}
+	return ds
+}
 
+func newNetworkStatus(status *daemon.DaemonStatus) *networkStatus {
+	ns := &networkStatus{
+		Available:       true,
+		TunName:         status.TunName,
+		TunMTU:          status.TunMtu,
+		TunMTULimitedBy: status.TunMtuLimitedBy,
+		ManagedRoutes:   status.ManagedRoutes,
+	}
+	if policy := status.GetOutboundConfig().GetOutboundTrafficPolicy(); policy == client.OutboundTrafficPolicyMappedNamespaces {
+		ns.OutboundTrafficPolicy = policy
+	}
+	for _, gr := range status.GatewayRoutes {
+		ns.GatewayRoutes = append(ns.GatewayRoutes, gatewayRoute{
+			Subnet:  iputil.IPNetFromRPC(gr.Subnet).String(),
+			Gateway: net.IP(gr.Gateway).String(),
+		})
+	}
+	if cs := status.Connections; cs != nil {
+		ns.Connections = &connectionsStatus{
+			Tracked:        cs.Tracked,
+			MaxTracked:     cs.MaxTracked,
+			IdleEvictions:  cs.IdleEvictions,
+			LimitEvictions: cs.LimitEvictions,
+			Refused:        cs.Refused,
+		}
+	}
+	for _, rs := range status.RoutedSubnets {
+		ns.RoutedSubnets = append(ns.RoutedSubnets, routedSubnet{
+			Subnet: iputil.IPNetFromRPC(rs.Subnet).String(),
+			Source: routedSubnetSources[rs.Source],
+		})
+	}
+	if dns := status.GetOutboundConfig().GetDns(); dns != nil {
+		ns.DNS = &dnsStatus{
+			Mode:            status.DnsMode,
+			Listener:        status.DnsListener,
+			Forwarder:       status.DnsForwarder,
+			RemoteIP:        net.IP(dns.RemoteIp).String(),
+			ExcludeSuffixes: dns.ExcludeSuffixes,
+			IncludeSuffixes: dns.IncludeSuffixes,
+			SearchPaths:     status.SearchPaths,
+			LookupTimeout:   dns.LookupTimeout.AsDuration().String(),
+		}
+		if dns.LocalIp != nil {
+			// Local IP is only set when the overriding resolver is used
+			ns.DNS.LocalIP = net.IP(dns.LocalIp).String()
+		}
+	}
+	return ns
+}
+
+func connectorStatus(ctx context.Context) (*userDaemonStatus, error) {
+	var us *userDaemonStatus
+	err := withStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
 		version, err := connectorClient.Version(ctx, &empty.Empty{})
 		if err != nil {
 			return err
 		}
-		fields = append(fields, kv{"Version", fmt.Sprintf("%s (api %d)", version.Version, version.ApiVersion)})
 
+		var cloud string
 		if !cliutil.HasLoggedIn(ctx) {
-			fields = append(fields, kv{"Ambassador Cloud", "Logged out"})
+			cloud = "Logged out"
 		} else if _, err := cliutil.GetCloudUserInfo(ctx, false, true); err != nil {
-			fields = append(fields, kv{"Ambassador Cloud", "Login expired (or otherwise no-longer-operational)"})
+			cloud = "Login expired (or otherwise no-longer-operational)"
 		} else {
-			fields = append(fields, kv{"Ambassador Cloud", "Logged in"})
+			cloud = "Logged in"
 		}
 
 		status, err := connectorClient.Status(ctx, &connector.ConnectRequest{
-			KubeFlags: kubeFlagMap(),
+			KubeFlags: connectorKubeFlagMap(ctx),
 		})
 		if err != nil {
 			return err
 		}
-		switch status.Error {
-		case connector.ConnectInfo_UNSPECIFIED, connector.ConnectInfo_ALREADY_CONNECTED:
-			fields = append(fields, kv{"Status", "Connected"})
-		case connector.ConnectInfo_MUST_RESTART:
-			fields = append(fields, kv{"Status", "Connected, but must restart"})
-		case connector.ConnectInfo_DISCONNECTED:
-			fields = append(fields, kv{"Status", "Not connected"})
-			return nil
-		case connector.ConnectInfo_CLUSTER_FAILED:
-			fields = append(fields, kv{"Status", "Not connected, error talking to cluster"})
-			fields = append(fields, kv{"Error", status.ErrorText})
-			return nil
-		case connector.ConnectInfo_TRAFFIC_MANAGER_FAILED:
-			fields = append(fields, kv{"Status", "Not connected, error talking to in-cluster Telepresence traffic-manager"})
-			fields = append(fields, kv{"Error", status.ErrorText})
-			return nil
-		}
-		fields = append(fields, kv{"Kubernetes server", status.ClusterServer})
-		fields = append(fields, kv{"Kubernetes context", status.ClusterContext})
-		if status.BridgeOk {
-			fields = append(fields, kv{"Telepresence proxy", "ON (networking to the cluster is enabled)"})
-		} else {
-			fields = append(fields, kv{"Telepresence proxy", "OFF (attempting to connect...)"})
-		}
-		intercepts := fmt.Sprintf("%d total\n", len(status.GetIntercepts().GetIntercepts()))
-		for _, icept := range status.GetIntercepts().GetIntercepts() {
-			intercepts += fmt.Sprintf("%s: %s\n", icept.Spec.Name, icept.Spec.Client)
+		us = newUserDaemonStatus(version, cloud, status)
+		if us.connected && status.SessionInfo != nil {
+			// A traffic-manager that is too old to have remote log-levels has none to show
+			if rl, err := getRemoteLogLevels(ctx, status.SessionInfo); err == nil {
+				us.RemoteLogLevels = newRemoteLogLevels(rl)
+			} else {
+				dlog.Debugf(ctx, "unable to get the remote log-levels: %v", err)
+			}
 		}
-		fields = append(fields, kv{"Intercepts", intercepts})
-
 		return nil
 	})
 	if err != nil {
 		if errors.Is(err, cliutil.ErrNoConnector) {
-			fmt.Fprintln(out, "User Daemon: Not running")
-			return nil
+			return &userDaemonStatus{}, nil
 		}
-		return err
+		return nil, err
+	}
+	return us, nil
+}
+
+func newUserDaemonStatus(version *common.VersionInfo, cloud string, status *connector.ConnectInfo) *userDaemonStatus {
+	us := &userDaemonStatus{
+		Running:         true,
+		Version:         version.Version,
+		APIVersion:      version.ApiVersion,
+		AmbassadorCloud: cloud,
+	}
+	if len(status.Sessions) > 1 {
+		us.Status = fmt.Sprintf("Connected to %d sessions", len(status.Sessions))
+		for _, ss := range status.Sessions {
+			us.Sessions = append(us.Sessions, newSessionStatus(ss))
+		}
+		return us
+	}
+	us.sessionStatus = *newSessionStatus(status)
+	return us
+}
+
+func newSessionStatus(status *connector.ConnectInfo) *sessionStatus {
+	ss := &sessionStatus{}
+	switch status.Error {
+	case connector.ConnectInfo_UNSPECIFIED, connector.ConnectInfo_ALREADY_CONNECTED:
+		ss.Status = "Connected"
+	case connector.ConnectInfo_MUST_RESTART:
+		ss.Status = "Connected, but must restart"
+	case connector.ConnectInfo_DISCONNECTED:
+		ss.Status = "Not connected"
+		return ss
+	case connector.ConnectInfo_CLUSTER_FAILED:
+		ss.Status = "Not connected, error talking to cluster"
+		ss.Error = status.ErrorText
+		return ss
+	case connector.ConnectInfo_TRAFFIC_MANAGER_FAILED:
+		ss.Status = "Not connected, error talking to in-cluster Telepresence traffic-manager"
+		ss.Error = status.ErrorText
+		return ss
+	}
+	if rs := status.ReconnectingSince; rs != nil {
+		ss.Status = "Reconnecting"
+		ss.ReconnectingSince = rs.AsTime().Format(time.RFC3339)
+	}
+	ss.connected = true
+	ss.KubernetesServer = status.ClusterServer
+	ss.KubernetesContext = status.ClusterContext
+	ss.KubernetesNamespace = status.ClusterNamespace
+	ss.ManagerNamespace = status.ManagerNamespace
+	ss.AgentImage = status.AgentImage
+	ss.AgentImageSource = status.AgentImageSource
+	ss.ManagedNamespaces = status.ManagedNamespaces
+	ss.MappedNamespaces = status.MappedNamespaces
+	ss.ProxyOK = status.BridgeOk
+	ss.ProxyMode = status.ProxyMode
+	if pp := status.ProxyPorts; pp != nil {
+		ss.ProxyPorts = &proxyPortsStatus{
+			Limitations: client.ProxyPortsLimitations,
+			DNSListener: pp.DnsListener,
+			HostsFile:   pp.HostsFile,
+			Ports:       make([]proxyPortStatus, len(pp.Ports)),
+		}
+		for i, p := range pp.Ports {
+			ss.ProxyPorts.Ports[i] = proxyPortStatus{
+				Service:   p.Name + "." + p.Namespace,
+				Port:      p.Port,
+				PortName:  p.PortName,
+				LocalPort: p.LocalPort,
+			}
+		}
+	}
+	if status.ProxyMode == client.ProxyModeSOCKS5 {
+		ss.ProxySOCKS5 = &proxySOCKS5{
+			Address:     status.Socks5Address,
+			Limitations: client.ProxySOCKS5Limitations,
+		}
+	}
+	if rs := status.RoutingStrategy; rs != nil {
+		ss.RoutingStrategy = &routingStrategy{
+			Strategy: rs.Strategy,
+			Provider: rs.Provider,
+			Reason:   rs.Reason,
+		}
+		if rs.Gateway != nil {
+			ss.RoutingStrategy.Gateway = net.IP(rs.Gateway).String()
+		}
+	}
+	for _, icept := range status.GetIntercepts().GetIntercepts() {
+		ss.Intercepts = append(ss.Intercepts, interceptStatus{
+			Name:     icept.Spec.Name,
+			Client:   icept.Spec.Client,
+			Forwards: status.ForwardCounts[icept.Spec.Name],
+			Pending:  interceptPending(icept),
+		})
+	}
+	sort.Slice(ss.Intercepts, func(i, j int) bool { return ss.Intercepts[i].Name < ss.Intercepts[j].Name })
+	for _, pf := range status.PortForwards {
+		ss.PortForwards = append(ss.PortForwards, describePortForward(pf)...)
+	}
+	return ss
+}
+
+func (si *statusInfo) writeJSON(out io.Writer) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(si)
+}
+
+func (si *statusInfo) writeText(out io.Writer) {
+	si.RootDaemon.writeText(out)
+	si.UserDaemon.writeText(out)
+	if si.Network != nil {
+		si.Network.writeText(out)
+	}
+	if si.WSL != nil {
+		si.WSL.writeText(out)
+	}
+	if si.Telemetry != nil {
+		si.Telemetry.writeText(out)
+	}
+}
+
+func (ts *telemetryStatus) writeText(out io.Writer) {
+	state := "Disabled"
+	if ts.Enabled {
+		state = "Enabled"
+	}
+	switch client.TelemetrySource(ts.Source) {
+	case client.TelemetryEnvironment:
+		fmt.Fprintf(out, "Telemetry: %s (by the SCOUT_DISABLE environment variable)\n", state)
+	case client.TelemetryConfig:
+		fmt.Fprintf(out, "Telemetry: %s (by telemetry.enabled in the config)\n", state)
+	default:
+		fmt.Fprintf(out, "Telemetry: %s\n", state)
+	}
+}
+
+// statusTree is a key/value tree that is rendered with aligned keys on each level.
+type statusTree []statusNode
+
+type statusNode struct {
+	key      string
+	value    string
+	children statusTree
+}
+
+func (t statusTree) write(out io.Writer, indent string) {
+	klen := 0
+	for _, n := range t {
+		if len(n.key) > klen {
+			klen = len(n.key)
+		}
+	}
+	for _, n := range t {
+		if n.key == "" {
+			fmt.Fprintf(out, "%s- %s\n", indent, n.value)
+		} else if n.value == "" {
+			fmt.Fprintf(out, "%s%-*s:\n", indent, klen, n.key)
+		} else {
+			fmt.Fprintf(out, "%s%-*s: %s\n", indent, klen, n.key, n.value)
+		}
+		n.children.write(out, indent+"  ")
+	}
+}
+
+func listNodes(items []string) statusTree {
+	t := make(statusTree, len(items))
+	for i, item := range items {
+		t[i] = statusNode{value: item}
+	}
+	return t
+}
+
+func (ds *rootDaemonStatus) writeText(out io.Writer) {
+	if !ds.Running {
+		fmt.Fprintln(out, "Root Daemon: Not running")
+		return
+	}
+	if ds.Container != "" {
+		fmt.Fprintf(out, "Root Daemon: Running in Docker container %s\n", ds.Container)
+		return
+	}
+	if ds.Windows {
+		fmt.Fprintln(out, "Root Daemon: Running on Windows")
+		return
+	}
+	fmt.Fprintln(out, "Root Daemon: Running")
+	t := statusTree{
+		{key: "Version", value: fmt.Sprintf("%s (api %d)", ds.Version, ds.APIVersion)},
+		{key: "Started by", value: ds.StartedBy},
+	}
+	if ds.Metrics != "" {
+		t = append(t, statusNode{key: "Metrics", value: ds.Metrics})
+	}
+	t = append(t, statusNode{key: "Also Proxy", value: fmt.Sprintf("(%d subnets)", len(ds.AlsoProxy)), children: listNodes(ds.AlsoProxy)})
+	t = append(t, statusNode{key: "Never Proxy", value: fmt.Sprintf("(%d subnets)", len(ds.NeverProxy)), children: listNodes(ds.NeverProxy)})
+	if len(ds.Conflicts) > 0 {
+		t = append(t, statusNode{key: "Conflicts", value: fmt.Sprintf("(%d)", len(ds.Conflicts)), children: listNodes(ds.Conflicts)})
+	}
+	if len(ds.SessionConflicts) > 0 {
+		t = append(t, statusNode{key: "Session conflicts", value: fmt.Sprintf("(%d)", len(ds.SessionConflicts)), children: listNodes(ds.SessionConflicts)})
+	}
+	t.write(out, "  ")
+}
+
+func (ns *networkStatus) writeText(out io.Writer) {
+	if !ns.Available {
+		fmt.Fprintf(out, "Network: Not available (%s)\n", ns.Reason)
+		return
+	}
+	fmt.Fprintln(out, "Network:")
+	var t statusTree
+	if ns.TunName != "" {
+		tun := ns.TunName
+		switch {
+		case ns.TunMTU > 0 && ns.TunMTULimitedBy != "":
+			tun = fmt.Sprintf("%s (MTU %d, limited by %s)", tun, ns.TunMTU, ns.TunMTULimitedBy)
+		case ns.TunMTU > 0:
+			tun = fmt.Sprintf("%s (MTU %d)", tun, ns.TunMTU)
+		}
+		t = append(t, statusNode{key: "TUN device", value: tun})
+	}
+	routes := make([]string, len(ns.RoutedSubnets))
+	for i, rs := range ns.RoutedSubnets {
+		routes[i] = fmt.Sprintf("%s (%s)", rs.Subnet, rs.Source)
+	}
+	t = append(t, statusNode{key: "Routes", value: fmt.Sprintf("(%d subnets)", len(routes)), children: listNodes(routes)})
+	if len(ns.GatewayRoutes) > 0 {
+		grs := make([]string, len(ns.GatewayRoutes))
+		for i, gr := range ns.GatewayRoutes {
+			grs[i] = fmt.Sprintf("%s via %s", gr.Subnet, gr.Gateway)
+		}
+		t = append(t, statusNode{key: "Gateway routes", value: fmt.Sprintf("(%d routes)", len(grs)), children: listNodes(grs)})
+	}
+	if ns.OutboundTrafficPolicy != "" {
+		t = append(t,
+			statusNode{key: "Outbound traffic policy", value: ns.OutboundTrafficPolicy},
+			statusNode{key: "Managed routes", value: fmt.Sprintf("%d (IPs of the services and pods in the mapped namespaces)", ns.ManagedRoutes)})
+	}
+	if cs := ns.Connections; cs != nil {
+		tracked := fmt.Sprintf("%d tracked", cs.Tracked)
+		if cs.MaxTracked > 0 {
+			tracked = fmt.Sprintf("%d tracked (max %d)", cs.Tracked, cs.MaxTracked)
+		}
+		t = append(t, statusNode{key: "Connections", value: tracked, children: listNodes([]string{
+			fmt.Sprintf("%d closed when idle", cs.IdleEvictions),
+			fmt.Sprintf("%d closed at the maximum", cs.LimitEvictions),
+			fmt.Sprintf("%d refused at the maximum", cs.Refused),
+		})})
+	}
+	if dns := ns.DNS; dns != nil {
+		var dt statusTree
+		if dns.Mode != "" {
+			dt = append(dt, statusNode{key: "Mode", value: dns.Mode})
+		}
+		if dns.Listener != "" {
+			dt = append(dt, statusNode{key: "Listener", value: dns.Listener})
+		}
+		if dns.Forwarder != "" {
+			dt = append(dt, statusNode{key: "Forwarder", value: dns.Forwarder})
+		}
+		if dns.LocalIP != "" {
+			dt = append(dt, statusNode{key: "Local IP", value: dns.LocalIP})
+		}
+		dt = append(dt,
+			statusNode{key: "Remote IP", value: dns.RemoteIP},
+			statusNode{key: "Exclude suffixes", value: fmt.Sprintf("%v", dns.ExcludeSuffixes)},
+			statusNode{key: "Include suffixes", value: fmt.Sprintf("%v", dns.IncludeSuffixes)},
+			statusNode{key: "Search paths", value: fmt.Sprintf("%v", dns.SearchPaths)},
+			statusNode{key: "Timeout", value: dns.LookupTimeout})
+		t = append(t, statusNode{key: "DNS", children: dt})
+	}
+	t.write(out, "  ")
+}
+
+func (ws *wslStatus) writeText(out io.Writer) {
+	fmt.Fprintf(out, "WSL: CLI in distribution %s, daemons on Windows\n", ws.Distro)
+	t := statusTree{
+		{key: "Windows executable", value: ws.WindowsExe},
+		{key: "Endpoint file", value: ws.EndpointFile},
+	}
+	switch {
+	case ws.Error != "":
+		t = append(t, statusNode{key: "Error", value: ws.Error})
+	case ws.Interface != "":
+		t = append(t, statusNode{key: "Endpoint", value: fmt.Sprintf("%s (%s)", ws.Endpoint, ws.Interface)})
+	default:
+		t = append(t, statusNode{key: "Endpoint", value: ws.Endpoint})
+	}
+	t.write(out, "  ")
+}
+
+func (us *userDaemonStatus) writeText(out io.Writer) {
+	if !us.Running {
+		fmt.Fprintln(out, "User Daemon: Not running")
+		return
+	}
+	switch {
+	case us.Container != "":
+		fmt.Fprintf(out, "User Daemon: Running in Docker container %s\n", us.Container)
+	case us.Windows:
+		fmt.Fprintln(out, "User Daemon: Running on Windows")
+	default:
+		fmt.Fprintln(out, "User Daemon: Running")
+	}
+	t := statusTree{
+		{key: "Version", value: fmt.Sprintf("%s (api %d)", us.Version, us.APIVersion)},
+		{key: "Ambassador Cloud", value: us.AmbassadorCloud},
+	}
+	if us.Metrics != "" {
+		t = append(t, statusNode{key: "Metrics", value: us.Metrics})
+	}
+	if len(us.Sessions) > 0 {
+		t = append(t, statusNode{key: "Status", value: us.Status})
+		for _, ss := range us.Sessions {
+			t = append(t, statusNode{
+				key:      "Session",
+				value:    fmt.Sprintf("%s (manager namespace %s)", ss.KubernetesContext, ss.ManagerNamespace),
+				children: ss.tree(),
+			})
+		}
+	} else {
+		t = append(t, us.sessionStatus.tree()...)
+	}
+	t.write(out, "  ")
+}
+
+// tree returns the status tree of the session.
+func (ss *sessionStatus) tree() statusTree {
+	t := statusTree{
+		{key: "Status", value: ss.Status},
+	}
+	if ss.ReconnectingSince != "" {
+		t = append(t, statusNode{key: "Reconnecting since", value: ss.ReconnectingSince})
+	}
+	if ss.Error != "" {
+		t = append(t, statusNode{key: "Error", value: ss.Error})
+	}
+	if ss.connected {
+		t = append(t,
+			statusNode{key: "Kubernetes server", value: ss.KubernetesServer},
+			statusNode{key: "Kubernetes context", value: ss.KubernetesContext})
+		if ss.KubernetesNamespace != "" {
+			t = append(t, statusNode{key: "Kubernetes namespace", value: ss.KubernetesNamespace})
+		}
+		t = append(t, statusNode{key: "Manager namespace", value: ss.ManagerNamespace})
+		if ss.AgentImage != "" {
+			t = append(t, statusNode{key: "Agent image", value: fmt.Sprintf("%s (from %s)", ss.AgentImage, ss.AgentImageSource)})
+		}
+		if len(ss.ManagedNamespaces) > 0 {
+			t = append(t, statusNode{key: "Managed namespaces", value: strings.Join(ss.ManagedNamespaces, ", ")})
+		}
+		if len(ss.MappedNamespaces) > 0 {
+			t = append(t, statusNode{key: "Mapped namespaces", value: strings.Join(ss.MappedNamespaces, ", ")})
+		} else {
+			t = append(t, statusNode{key: "Mapped namespaces", value: "All namespaces"})
+		}
+		if ss.ProxyOK {
+			t = append(t, statusNode{key: "Telepresence proxy", value: "ON (networking to the cluster is enabled)"})
+		} else {
+			t = append(t, statusNode{key: "Telepresence proxy", value: "OFF (attempting to connect...)"})
+		}
+		if ss.ProxyMode != "" {
+			children := ss.ProxyPorts.tree()
+			if ps := ss.ProxySOCKS5; ps != nil {
+				children = statusTree{{key: "Address", value: ps.Address}, {key: "Limitations", value: ps.Limitations}}
+			}
+			t = append(t, statusNode{key: "Proxy mode", value: ss.ProxyMode, children: children})
+		}
+		if rs := ss.RoutingStrategy; rs != nil {
+			t = append(t, statusNode{key: "Routing strategy", value: rs.describe()})
+		}
+		icepts := make([]string, len(ss.Intercepts))
+		for i, ic := range ss.Intercepts {
+			if ic.Pending {
+				icepts[i] = fmt.Sprintf("%s: %s (pending)", ic.Name, ic.Client)
+			} else {
+				icepts[i] = fmt.Sprintf("%s: %s (%d forwards)", ic.Name, ic.Client, ic.Forwards)
+			}
+		}
+		t = append(t, statusNode{key: "Intercepts", value: fmt.Sprintf("%d total", len(icepts)), children: listNodes(icepts)})
+		if len(ss.PortForwards) > 0 {
+			t = append(t, statusNode{key: "Port forwards", value: fmt.Sprintf("%d total", len(ss.PortForwards)), children: listNodes(ss.PortForwards)})
+		}
+		if ss.RemoteLogLevels != nil {
+			t = append(t, statusNode{key: "Remote log-levels", children: ss.RemoteLogLevels.tree()})
+		}
+	}
+	return t
+}
+
+// describe returns a description of the routing strategy, such as "docker-network (kind cluster, via
+// 172.18.0.2)".
+func (rs *routingStrategy) describe() string {
+	switch {
+	case rs.Gateway != "":
+		return fmt.Sprintf("%s (%s cluster, via %s)", rs.Strategy, rs.Provider, rs.Gateway)
+	case rs.Provider != "":
+		return fmt.Sprintf("%s (%s cluster, %s)", rs.Strategy, rs.Provider, rs.Reason)
+	default:
+		return rs.Strategy
+	}
+}
+
+// newRemoteLogLevels returns the remote log-levels to show, or nil when there are none.
+func newRemoteLogLevels(rl *manager.RemoteLogLevels) *remoteLogLevels {
+	if rl.TrafficManager == nil && len(rl.Agents) == 0 {
+		return nil
+	}
+	newLevel := func(ll *manager.RemoteLogLevel) remoteLogLevel {
+		l := remoteLogLevel{LogLevel: ll.GetLogLevel()}
+		if ex := ll.GetExpires(); ex != nil {
+			l.Expires = ex.AsTime().Format(time.RFC3339)
+		}
+		return l
+	}
+	rls := &remoteLogLevels{AllAgents: rl.AllAgents}
+	if rl.TrafficManager != nil {
+		l := newLevel(rl.TrafficManager)
+		rls.TrafficManager = &l
+	}
+	for _, al := range rl.Agents {
+		rls.Agents = append(rls.Agents, agentLogLevel{
+			Name:           al.Name,
+			Namespace:      al.Namespace,
+			PodIP:          al.PodIp,
+			remoteLogLevel: newLevel(al.LogLevel),
+		})
+	}
+	return rls
+}
+
+// tree returns the status tree of the remote log-levels.
+func (rls *remoteLogLevels) tree() statusTree {
+	describe := func(l *remoteLogLevel) string {
+		if l.Expires != "" {
+			return fmt.Sprintf("%s (expires %s)", l.LogLevel, l.Expires)
+		}
+		return l.LogLevel
+	}
+	var t statusTree
+	if tm := rls.TrafficManager; tm != nil {
+		t = append(t, statusNode{key: "traffic-manager", value: describe(tm)})
+		if rls.AllAgents {
+			t = append(t, statusNode{key: "All traffic-agents", value: describe(tm)})
+		}
+	}
+	for i := range rls.Agents {
+		al := &rls.Agents[i]
+		t = append(t, statusNode{key: fmt.Sprintf("%s.%s (%s)", al.Name, al.Namespace, al.PodIP), value: describe(&al.remoteLogLevel)})
+	}
+	return t
+}
+
+// tree returns the status tree of the proxied ports.
+func (ps *proxyPortsStatus) tree() statusTree {
+	if ps == nil {
+		return nil
+	}
+	t := statusTree{{key: "Limitations", value: ps.Limitations}}
+	if ps.DNSListener != "" {
+		t = append(t, statusNode{key: "DNS listener", value: ps.DNSListener})
+	}
+	if ps.HostsFile != "" {
+		t = append(t, statusNode{key: "Hosts file", value: ps.HostsFile})
+	}
+	ports := make([]string, len(ps.Ports))
+	for i, p := range ps.Ports {
+		port := fmt.Sprintf("%s:%d", p.Service, p.Port)
+		if p.PortName != "" {
+			port += " (" + p.PortName + ")"
+		}
+		ports[i] = fmt.Sprintf("%s -> 127.0.0.1:%d", port, p.LocalPort)
 	}
-	return nil
+	return append(t, statusNode{key: "Ports", value: fmt.Sprintf("%d total", len(ports)), children: listNodes(ports)})
 }
@@ -0,0 +1,549 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/durationpb"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/common"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+func mustParseCIDR(t *testing.T, cidr string) *manager.IPNet {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	require.NoError(t, err)
+	return iputil.IPNetToRPC(ipNet)
+}
+
+// fakeStatusInfo returns the status of a connected session with one intercept.
+func fakeStatusInfo(t *testing.T) *statusInfo {
+	version := &common.VersionInfo{ApiVersion: 3, Version: "v2.4.5"}
+	ds := &daemon.DaemonStatus{
+		OutboundConfig: &daemon.OutboundInfo{
+			Dns: &daemon.DNSConfig{
+				RemoteIp:        net.IP{10, 0, 0, 10},
+				ExcludeSuffixes: []string{".com", ".io"},
+				IncludeSuffixes: []string{},
+				LookupTimeout:   durationpb.New(4 * time.Second),
+			},
+			AlsoProxySubnets:  []*manager.IPNet{mustParseCIDR(t, "192.168.0.0/24")},
+			NeverProxySubnets: []*manager.IPNet{mustParseCIDR(t, "10.244.0.0/17"), mustParseCIDR(t, "10.0.0.1/32")},
+		},
+		TunName:          "tel0",
+		TunMtu:           1414,
+		TunMtuLimitedBy:  "wg0",
+		PrivilegedHelper: true,
+		RoutedSubnets: []*daemon.RoutedSubnet{
+			{Subnet: mustParseCIDR(t, "10.96.0.0/12"), Source: daemon.RoutedSubnet_SERVICES},
+			{Subnet: mustParseCIDR(t, "10.244.128.0/17"), Source: daemon.RoutedSubnet_PODS},
+			{Subnet: mustParseCIDR(t, "192.168.0.0/24"), Source: daemon.RoutedSubnet_ALSO_PROXY},
+		},
+		Connections: &daemon.ConnectionStats{
+			Tracked:        42,
+			MaxTracked:     1000,
+			IdleEvictions:  7,
+			LimitEvictions: 1,
+		},
+		DnsMode:      "overriding",
+		DnsListener:  "127.0.0.1:46551",
+		DnsForwarder: "192.168.1.1:53",
+		SearchPaths:  []string{"default", "blue"},
+		SubnetConflicts: []*daemon.SubnetConflict{{
+			Subnet:    mustParseCIDR(t, "192.168.0.0/24"),
+			Route:     mustParseCIDR(t, "192.168.0.0/24"),
+			Interface: "eth0",
+			Severity:  daemon.SubnetConflict_WARNING,
+		}},
+	}
+	ci := &connector.ConnectInfo{
+		Error:            connector.ConnectInfo_ALREADY_CONNECTED,
+		ClusterServer:    "https://127.0.0.1:6443",
+		ClusterContext:   "default",
+		ClusterNamespace: "blue",
+		ManagerNamespace: "ambassador",
+		AgentImage:       "registry.example.com/datawire/tel2:2.4.5",
+		AgentImageSource: "traffic-manager",
+		BridgeOk:         true,
+		MappedNamespaces: []string{"default", "blue"},
+		Intercepts: &manager.InterceptInfoSnapshot{
+			Intercepts: []*manager.InterceptInfo{
+				{Spec: &manager.InterceptSpec{Name: "echo", Client: "alice@example.com"}},
+				{Spec: &manager.InterceptSpec{Name: "api", Client: "alice@example.com"}},
+			},
+		},
+		ForwardCounts: map[string]int32{"echo": 3},
+	}
+	si := &statusInfo{
+		RootDaemon: newRootDaemonStatus(ds, version),
+		UserDaemon: newUserDaemonStatus(version, "Logged out", ci),
+		Network:    newNetworkStatus(ds),
+		Telemetry:  &telemetryStatus{Enabled: false, Source: "environment"},
+	}
+	si.RootDaemon.Metrics = metricsURL("127.0.0.1:9091")
+	si.UserDaemon.Metrics = metricsURL("127.0.0.1:9090")
+	return si
+}
+
+func TestStatus_render(t *testing.T) {
+	tests := []struct {
+		name string
+		si   *statusInfo
+	}{
+		{"connected", fakeStatusInfo(t)},
+		{"not-running", &statusInfo{RootDaemon: &rootDaemonStatus{}, UserDaemon: &userDaemonStatus{}, Network: notRunningNetworkStatus()}},
+		{"disconnected", &statusInfo{
+			RootDaemon: &rootDaemonStatus{},
+			Network:    notRunningNetworkStatus(),
+			UserDaemon: newUserDaemonStatus(
+				&common.VersionInfo{ApiVersion: 3, Version: "v2.4.5"},
+				"Logged in",
+				&connector.ConnectInfo{Error: connector.ConnectInfo_CLUSTER_FAILED, ErrorText: "unable to reach cluster"}),
+		}},
+		{"reconnecting", &statusInfo{
+			RootDaemon: &rootDaemonStatus{},
+			Network:    notRunningNetworkStatus(),
+			UserDaemon: newUserDaemonStatus(
+				&common.VersionInfo{ApiVersion: 3, Version: "v2.4.5"},
+				"Logged out",
+				&connector.ConnectInfo{
+					Error:             connector.ConnectInfo_ALREADY_CONNECTED,
+					ClusterServer:     "https://127.0.0.1:6443",
+					ClusterContext:    "default",
+					ManagerNamespace:  "ambassador",
+					BridgeOk:          true,
+					ReconnectingSince: timestamppb.New(time.Date(2021, 11, 4, 9, 30, 0, 0, time.UTC)),
+				}),
+		}},
+		{"namespaced", &statusInfo{
+			RootDaemon: &rootDaemonStatus{},
+			Network:    notRunningNetworkStatus(),
+			UserDaemon: newUserDaemonStatus(
+				&common.VersionInfo{ApiVersion: 3, Version: "v2.4.5"},
+				"Logged out",
+				&connector.ConnectInfo{
+					Error:             connector.ConnectInfo_ALREADY_CONNECTED,
+					ClusterServer:     "https://127.0.0.1:6443",
+					ClusterContext:    "default",
+					ManagerNamespace:  "blue",
+					ManagedNamespaces: []string{"blue", "red"},
+					BridgeOk:          true,
+				}),
+		}},
+		{"ports", &statusInfo{
+			RootDaemon: &rootDaemonStatus{},
+			Network:    notRunningNetworkStatus(),
+			UserDaemon: newUserDaemonStatus(
+				&common.VersionInfo{ApiVersion: 3, Version: "v2.4.5"},
+				"Logged out",
+				&connector.ConnectInfo{
+					Error:            connector.ConnectInfo_ALREADY_CONNECTED,
+					ClusterServer:    "https://127.0.0.1:6443",
+					ClusterContext:   "default",
+					ManagerNamespace: "ambassador",
+					MappedNamespaces: []string{"default"},
+					BridgeOk:         true,
+					ProxyMode:        "ports",
+					ProxyPorts: &connector.ProxyPorts{
+						DnsListener: "127.0.0.1:53535",
+						HostsFile:   "/etc/hosts",
+						Ports: []*connector.ProxyPort{
+							{Name: "echo", Namespace: "default", Port: 8080, PortName: "http", LocalPort: 50123},
+							{Name: "postgres", Namespace: "default", Port: 5432, LocalPort: 50124},
+						},
+					},
+				}),
+		}},
+		{"socks5", &statusInfo{
+			RootDaemon: &rootDaemonStatus{},
+			Network:    notRunningNetworkStatus(),
+			UserDaemon: newUserDaemonStatus(
+				&common.VersionInfo{ApiVersion: 3, Version: "v2.4.5"},
+				"Logged out",
+				&connector.ConnectInfo{
+					Error:            connector.ConnectInfo_ALREADY_CONNECTED,
+					ClusterServer:    "https://127.0.0.1:6443",
+					ClusterContext:   "default",
+					ManagerNamespace: "ambassador",
+					BridgeOk:         true,
+					ProxyMode:        "socks5",
+					Socks5Address:    "127.0.0.1:1080",
+				}),
+		}},
+		{"docker", func() *statusInfo {
+			si := &statusInfo{
+				RootDaemon: &rootDaemonStatus{Running: true, Container: "telepresence-daemons"},
+				Network:    &networkStatus{Reason: "the root daemon runs in Docker container telepresence-daemons"},
+				UserDaemon: newUserDaemonStatus(
+					&common.VersionInfo{ApiVersion: 3, Version: "v2.4.5"},
+					"Logged out",
+					&connector.ConnectInfo{
+						Error:            connector.ConnectInfo_ALREADY_CONNECTED,
+						ClusterServer:    "https://host.docker.internal:6443",
+						ClusterContext:   "default",
+						ManagerNamespace: "team-tp",
+						AgentImage:       "localhost:5000/tel2:2.4.5",
+						AgentImageSource: "config",
+						BridgeOk:         true,
+					}),
+			}
+			si.UserDaemon.Container = "telepresence-daemons"
+			return si
+		}()},
+		{"wsl", func() *statusInfo {
+			si := &statusInfo{
+				RootDaemon: &rootDaemonStatus{Running: true, Windows: true},
+				Network:    &networkStatus{Reason: "the root daemon runs on Windows"},
+				UserDaemon: newUserDaemonStatus(
+					&common.VersionInfo{ApiVersion: 3, Version: "v2.4.5"},
+					"Logged out",
+					&connector.ConnectInfo{
+						Error:            connector.ConnectInfo_ALREADY_CONNECTED,
+						ClusterServer:    "https://kubernetes.docker.internal:6443",
+						ClusterContext:   "docker-desktop",
+						ManagerNamespace: "ambassador",
+						AgentImage:       "docker.io/datawire/tel2:2.4.5",
+						AgentImageSource: "default",
+						BridgeOk:         true,
+					}),
+				WSL: &wslStatus{
+					Distro:       "Ubuntu",
+					WindowsExe:   "/mnt/c/Program Files/telepresence/telepresence.exe",
+					EndpointFile: "/mnt/c/Users/alice/AppData/Local/telepresence/wsl-connector.json",
+					Endpoint:     "172.29.64.1:49875",
+					Interface:    "vEthernet (WSL)",
+				},
+			}
+			si.UserDaemon.Windows = true
+			return si
+		}()},
+		{"mapped-namespaces", func() *statusInfo {
+			si := fakeStatusInfo(t)
+			si.Network = newNetworkStatus(&daemon.DaemonStatus{
+				OutboundConfig: &daemon.OutboundInfo{
+					Dns: &daemon.DNSConfig{
+						RemoteIp:        net.IP{10, 0, 0, 10},
+						ExcludeSuffixes: []string{".com", ".io"},
+						IncludeSuffixes: []string{},
+						LookupTimeout:   durationpb.New(4 * time.Second),
+					},
+					OutboundTrafficPolicy: "mappedNamespaces",
+				},
+				TunName:       "tel0",
+				TunMtu:        1500,
+				ManagedRoutes: 14,
+				DnsMode:       "systemd-resolved",
+				SearchPaths:   []string{"default", "blue"},
+			})
+			return si
+		}()},
+		{"remote-log-levels", func() *statusInfo {
+			si := fakeStatusInfo(t)
+			expires := timestamppb.New(time.Date(2021, 11, 4, 10, 0, 0, 0, time.UTC))
+			si.UserDaemon.RemoteLogLevels = newRemoteLogLevels(&manager.RemoteLogLevels{
+				TrafficManager: &manager.RemoteLogLevel{LogLevel: "debug", Expires: expires},
+				Agents: []*manager.AgentLogLevel{
+					{Name: "echo", Namespace: "blue", PodIp: "10.244.0.5", LogLevel: &manager.RemoteLogLevel{LogLevel: "debug", Expires: expires}},
+					{Name: "echo", Namespace: "blue", PodIp: "10.244.0.6", LogLevel: &manager.RemoteLogLevel{LogLevel: "trace"}},
+				},
+			})
+			return si
+		}()},
+		{"pending-intercept", &statusInfo{
+			RootDaemon: &rootDaemonStatus{},
+			Network:    notRunningNetworkStatus(),
+			UserDaemon: newUserDaemonStatus(
+				&common.VersionInfo{ApiVersion: 3, Version: "v2.4.5"},
+				"Logged out",
+				&connector.ConnectInfo{
+					Error:            connector.ConnectInfo_ALREADY_CONNECTED,
+					ClusterServer:    "https://127.0.0.1:6443",
+					ClusterContext:   "default",
+					ManagerNamespace: "ambassador",
+					BridgeOk:         true,
+					Intercepts: &manager.InterceptInfoSnapshot{
+						Intercepts: []*manager.InterceptInfo{
+							{
+								Spec:        &manager.InterceptSpec{Name: "echo", Client: "alice@example.com"},
+								Disposition: manager.InterceptDispositionType_ACTIVE,
+							},
+							{
+								// Created with --no-wait, and the traffic-agent is yet to arrive
+								Spec:        &manager.InterceptSpec{Name: "api", Client: "alice@example.com"},
+								Disposition: manager.InterceptDispositionType_NO_AGENT,
+							},
+						},
+					},
+					ForwardCounts: map[string]int32{"echo": 3},
+				}),
+		}},
+		{"docker-network", func() *statusInfo {
+			si := fakeStatusInfo(t)
+			si.Network = newNetworkStatus(&daemon.DaemonStatus{
+				TunName: "tel0",
+				TunMtu:  1500,
+				GatewayRoutes: []*daemon.GatewayRoute{
+					{Subnet: mustParseCIDR(t, "10.96.0.0/16"), Gateway: net.IP{172, 18, 0, 2}},
+					{Subnet: mustParseCIDR(t, "10.244.0.0/16"), Gateway: net.IP{172, 18, 0, 2}},
+					{Subnet: mustParseCIDR(t, "10.244.1.0/24"), Gateway: net.IP{172, 18, 0, 3}},
+				},
+			})
+			si.UserDaemon = newUserDaemonStatus(
+				&common.VersionInfo{ApiVersion: 3, Version: "v2.4.5"},
+				"Logged out",
+				&connector.ConnectInfo{
+					Error: connector.ConnectInfo_ALREADY_CONNECTED,
+					Sessions: []*connector.ConnectInfo{
+						{
+							Error:            connector.ConnectInfo_ALREADY_CONNECTED,
+							ClusterServer:    "https://127.0.0.1:40145",
+							ClusterContext:   "kind-kind",
+							ManagerNamespace: "ambassador",
+							BridgeOk:         true,
+							ProxyMode:        "tun",
+							RoutingStrategy: &connector.RoutingStrategy{
+								Strategy: "docker-network",
+								Provider: "kind",
+								Gateway:  net.IP{172, 18, 0, 2},
+							},
+						},
+						{
+							// The root daemon failed to add the routes of this session
+							Error:            connector.ConnectInfo_ALREADY_CONNECTED,
+							ClusterServer:    "https://0.0.0.0:36227",
+							ClusterContext:   "k3d-k3s-default",
+							ManagerNamespace: "ambassador",
+							BridgeOk:         true,
+							ProxyMode:        "tun",
+							RoutingStrategy: &connector.RoutingStrategy{
+								Strategy: "docker-network",
+								Provider: "k3d",
+								Gateway:  net.IP{172, 19, 0, 3},
+							},
+						},
+						{
+							Error:            connector.ConnectInfo_ALREADY_CONNECTED,
+							ClusterServer:    "https://192.168.64.2:8443",
+							ClusterContext:   "minikube",
+							ManagerNamespace: "ambassador",
+							BridgeOk:         true,
+							ProxyMode:        "tun",
+							RoutingStrategy: &connector.RoutingStrategy{
+								Strategy: "tunnel",
+								Provider: "minikube",
+								Reason:   "node minikube has IP 192.168.64.2, which is not on a docker network of this host",
+							},
+						},
+					},
+				})
+			si.checkGatewayRoutes()
+			return si
+		}()},
+		{"sessions", &statusInfo{
+			RootDaemon: &rootDaemonStatus{
+				Running:    true,
+				Version:    "v2.4.5",
+				APIVersion: 3,
+				SessionConflicts: []string{
+					"subnet 10.96.0.0/12 of session staging/ambassador overlaps with subnet 10.96.0.0/12 of session dev/ambassador",
+				},
+			},
+			Network: notRunningNetworkStatus(),
+			UserDaemon: newUserDaemonStatus(
+				&common.VersionInfo{ApiVersion: 3, Version: "v2.4.5"},
+				"Logged out",
+				&connector.ConnectInfo{
+					Error: connector.ConnectInfo_ALREADY_CONNECTED,
+					Sessions: []*connector.ConnectInfo{
+						{
+							Error:            connector.ConnectInfo_ALREADY_CONNECTED,
+							ClusterServer:    "https://127.0.0.1:6443",
+							ClusterContext:   "dev",
+							ManagerNamespace: "ambassador",
+							BridgeOk:         true,
+						},
+						{
+							Error:            connector.ConnectInfo_ALREADY_CONNECTED,
+							ClusterServer:    "https://10.0.0.5:6443",
+							ClusterContext:   "staging",
+							ManagerNamespace: "ambassador",
+							BridgeOk:         true,
+						},
+					},
+				}),
+		}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.si.State = statusStates[tt.si.exitCode()]
+			out := &bytes.Buffer{}
+			tt.si.writeText(out)
+			expected, err := os.ReadFile(filepath.Join("testdata", "status", tt.name+".txt"))
+			require.NoError(t, err)
+			assert.Equal(t, string(expected), out.String())
+
+			out.Reset()
+			require.NoError(t, tt.si.writeJSON(out))
+			expected, err = os.ReadFile(filepath.Join("testdata", "status", tt.name+".json"))
+			require.NoError(t, err)
+			assert.JSONEq(t, string(expected), out.String())
+		})
+	}
+}
+
+// statusUserDaemon is a user daemon that reports the given status, or that fails when err is set.
+type statusUserDaemon struct {
+	connector.ConnectorClient
+	status *connector.ConnectInfo
+	err    error
+}
+
+func (c *statusUserDaemon) Version(context.Context, *empty.Empty, ...grpc.CallOption) (*common.VersionInfo, error) {
+	return &common.VersionInfo{ApiVersion: 3, Version: "v2.4.5"}, nil
+}
+
+func (c *statusUserDaemon) Status(context.Context, *connector.ConnectRequest, ...grpc.CallOption) (*connector.ConnectInfo, error) {
+	return c.status, c.err
+}
+
+type statusRootDaemon struct {
+	daemon.DaemonClient
+}
+
+func (d *statusRootDaemon) Version(context.Context, *empty.Empty, ...grpc.CallOption) (*common.VersionInfo, error) {
+	return &common.VersionInfo{ApiVersion: 3, Version: "v2.4.5"}, nil
+}
+
+func (d *statusRootDaemon) Status(context.Context, *empty.Empty, ...grpc.CallOption) (*daemon.DaemonStatus, error) {
+	return &daemon.DaemonStatus{}, nil
+}
+
+// withFakeDaemon makes the status command talk to the given root daemon, or find no root daemon
+// when it's nil.
+func withFakeDaemon(t *testing.T, dc daemon.DaemonClient) {
+	old := withStartedDaemon
+	withStartedDaemon = func(ctx context.Context, fn func(context.Context, daemon.DaemonClient) error) error {
+		if dc == nil {
+			return cliutil.ErrNoDaemon
+		}
+		return fn(ctx, dc)
+	}
+	t.Cleanup(func() { withStartedDaemon = old })
+}
+
+func TestStatus_exitCode(t *testing.T) {
+	ctx := client.WithEnv(dlog.NewTestContext(t, false), &client.Env{ScoutDisable: "1"})
+	cfg := client.GetDefaultConfig(ctx)
+	ctx = client.WithConfig(ctx, &cfg)
+	ctx = filelocation.WithAppUserConfigDir(ctx, t.TempDir())
+	ctx = filelocation.WithUserHomeDir(ctx, t.TempDir())
+
+	connected := &connector.ConnectInfo{Error: connector.ConnectInfo_ALREADY_CONNECTED, ClusterContext: "default"}
+	disconnected := &connector.ConnectInfo{Error: connector.ConnectInfo_DISCONNECTED}
+	connectedPorts := &connector.ConnectInfo{
+		Error:          connector.ConnectInfo_ALREADY_CONNECTED,
+		ClusterContext: "default",
+		ProxyMode:      client.ProxyModePorts,
+		ProxyPorts: &connector.ProxyPorts{
+			DnsListener: "127.0.0.1:53535",
+			Ports:       []*connector.ProxyPort{{Name: "echo", Namespace: "default", Port: 8080, PortName: "http", LocalPort: 50123}},
+		},
+	}
+	connectedSOCKS5 := &connector.ConnectInfo{
+		Error:          connector.ConnectInfo_ALREADY_CONNECTED,
+		ClusterContext: "default",
+		ProxyMode:      client.ProxyModeSOCKS5,
+		Socks5Address:  "127.0.0.1:1080",
+	}
+	tests := []struct {
+		name      string
+		daemon    daemon.DaemonClient
+		connector connector.ConnectorClient
+		code      int
+		state     string
+	}{
+		{"connected", &statusRootDaemon{}, &statusUserDaemon{status: connected}, StatusConnected, "connected"},
+		{"disconnected", &statusRootDaemon{}, &statusUserDaemon{status: disconnected}, StatusDisconnected, "disconnected"},
+		{"only user daemon", nil, &statusUserDaemon{status: connected}, StatusDisconnected, "disconnected"},
+		{"proxy mode ports", nil, &statusUserDaemon{status: connectedPorts}, StatusConnected, "connected"},
+		{"proxy mode socks5", nil, &statusUserDaemon{status: connectedSOCKS5}, StatusConnected, "connected"},
+		{"only root daemon", &statusRootDaemon{}, nil, StatusDisconnected, "disconnected"},
+		{"not running", nil, nil, StatusNotRunning, "not_running"},
+		{"error", &statusRootDaemon{}, &statusUserDaemon{err: errors.New("connection reset by peer")}, StatusError, "error"},
+	}
+	run := func(t *testing.T, args ...string) (string, error) {
+		cmd := statusCommand()
+		out := &bytes.Buffer{}
+		cmd.SetOut(out)
+		cmd.SetErr(out)
+		cmd.SetArgs(args)
+		cmd.SilenceErrors = true
+		cmd.SilenceUsage = true
+		err := cmd.ExecuteContext(ctx)
+		return out.String(), err
+	}
+	exitCode := func(err error) int {
+		if err == nil {
+			return 0
+		}
+		var codeErr *ExitCodeError
+		require.True(t, errors.As(err, &codeErr), "%v is not an *ExitCodeError", err)
+		return codeErr.Code
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			withFakeDaemon(t, tt.daemon)
+			withFakeConnector(t, tt.connector)
+
+			out, err := run(t)
+			assert.Equal(t, tt.code, exitCode(err))
+			if tt.code == StatusError {
+				assert.Empty(t, out)
+				assert.Contains(t, err.Error(), "connection reset by peer")
+			} else {
+				assert.NotEmpty(t, out)
+			}
+
+			out, err = run(t, "--quiet")
+			assert.Equal(t, tt.code, exitCode(err))
+			assert.Empty(t, out)
+			if err != nil {
+				assert.Nil(t, errors.Unwrap(err), "a quiet status must not have a message")
+			}
+
+			// The JSON output agrees with the exit code
+			out, err = run(t, "--output", "json")
+			assert.Equal(t, tt.code, exitCode(err))
+			var si struct {
+				State string `json:"state"`
+				Error string `json:"error"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(out), &si))
+			assert.Equal(t, tt.state, si.State)
+			if tt.code == StatusError {
+				assert.Contains(t, si.Error, "connection reset by peer")
+			}
+		})
+	}
+}
@@ -2,8 +2,9 @@ package cli
 
 import (
 	"context"
+	"fmt"
+	"io"
 
-	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
@@ -16,13 +17,14 @@ type uninstallInfo struct {
 	agent      bool
 	allAgents  bool
 	everything bool
+	force      bool
 	namespace  string
 }
 
 func uninstallCommand() *cobra.Command {
 	ui := &uninstallInfo{}
 	cmd := &cobra.Command{
-		Use:  "uninstall [flags] { --agent <agents...> |--all-agents | --everything }",
+		Use:  "uninstall [flags] { --agent <agents...> | --all-agents | --everything [--force] }",
 		Args: ui.args,
 
 		Short: "Uninstall telepresence agents and manager",
@@ -33,8 +35,17 @@ func uninstallCommand() *cobra.Command {
 	flags.BoolVarP(&ui.agent, "agent", "d", false, "uninstall intercept agent on specific deployments")
 	flags.BoolVarP(&ui.allAgents, "all-agents", "a", false, "uninstall intercept agent on all deployments")
 	flags.BoolVarP(&ui.everything, "everything", "e", false, "uninstall agents and the traffic manager")
+	flags.BoolVarP(&ui.force, "force", "", false, "uninstall everything also when other clients are connected to the traffic manager")
 	flags.StringVarP(&ui.namespace, "namespace", "n", "", "If present, the namespace scope for this CLI request")
+	_ = cmd.RegisterFlagCompletionFunc("namespace", completeNamespaces)
 
+	completeAgents := completeWorkloads(connector.ListRequest_INSTALLED_AGENTS, &ui.namespace, 0)
+	cmd.ValidArgsFunction = func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if !ui.agent {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return completeAgents(cmd, args, toComplete)
+	}
 	return cmd
 }
 
@@ -45,6 +56,9 @@ func (u *uninstallInfo) args(cmd *cobra.Command, args []string) error {
 	if !(u.agent || u.allAgents || u.everything) {
 		return errcat.User.New("please specify --agent, --all-agents, or --everything")
 	}
+	if u.force && !u.everything {
+		return errcat.User.New("--force can only be used with --everything")
+	}
 	switch {
 	case u.agent && len(args) == 0:
 		return errcat.User.New("at least one argument (the name of an agent) is expected")
@@ -61,6 +75,8 @@ func (u *uninstallInfo) run(cmd *cobra.Command, args []string) error {
 		ur := &connector.UninstallRequest{
 			UninstallType: 0,
 			Namespace:     u.namespace,
+			Force:         u.force,
+			Context:       sessionContext(),
 		}
 		switch {
 		case u.agent:
@@ -76,7 +92,14 @@ func (u *uninstallInfo) run(cmd *cobra.Command, args []string) error {
 			return err
 		}
 		if r.ErrorText != "" {
-			return errors.New(r.ErrorText)
+			cat := errcat.Unknown
+			if r.ErrorCategory > 0 {
+				cat = errcat.Category(r.ErrorCategory)
+			}
+			return cat.New(r.ErrorText)
+		}
+		if err = reportRemovals(cmd.OutOrStdout(), r.Removals); err != nil {
+			return err
 		}
 
 		if ur.UninstallType == connector.UninstallRequest_EVERYTHING {
@@ -92,6 +115,31 @@ func (u *uninstallInfo) run(cmd *cobra.Command, args []string) error {
 	return err
 }
 
+// reportRemovals prints the result of each removal and returns an error if any of them failed.
+func reportRemovals(out io.Writer, removals []*connector.UninstallResult_Removal) error {
+	failed := 0
+	cat := errcat.User
+	for _, r := range removals {
+		what := fmt.Sprintf("the agent from %s.%s", r.Name, r.Namespace)
+		if r.Kind == "traffic-manager" {
+			what = "the traffic-manager from namespace " + r.Namespace
+		}
+		if r.ErrorText == "" {
+			fmt.Fprintf(out, "Removed %s\n", what)
+			continue
+		}
+		fmt.Fprintf(out, "Unable to remove %s: %s\n", what, r.ErrorText)
+		failed++
+		if errcat.Category(r.ErrorCategory) != errcat.User {
+			cat = errcat.Unknown
+		}
+	}
+	if failed > 0 {
+		return cat.Newf("%d of %d removals failed", failed, len(removals))
+	}
+	return nil
+}
+
 func removeClusterFromUserCache(ctx context.Context, connInfo *connector.ConnectInfo) (err error) {
 	// Login token is affined to the traffic-manager that just got removed. The user-info
 	// in turn, is info obtained using that token so both are removed here as a
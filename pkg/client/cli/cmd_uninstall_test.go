@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+func Test_reportRemovals(t *testing.T) {
+	out := &bytes.Buffer{}
+	err := reportRemovals(out, []*connector.UninstallResult_Removal{
+		{Kind: "agent", Name: "echo", Namespace: "default"},
+		{Kind: "agent", Name: "api", Namespace: "default", ErrorText: "insufficient permissions: forbidden", ErrorCategory: int32(errcat.User)},
+		{Kind: "agent", Name: "web", Namespace: "default"},
+		{Kind: "traffic-manager", Name: "traffic-manager", Namespace: "ambassador", ErrorText: "the traffic-manager is kept", ErrorCategory: int32(errcat.User)},
+	})
+	require.Error(t, err)
+	assert.Equal(t, "2 of 4 removals failed", err.Error())
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+	assert.Equal(t, `Removed the agent from echo.default
+Unable to remove the agent from api.default: insufficient permissions: forbidden
+Removed the agent from web.default
+Unable to remove the traffic-manager from namespace ambassador: the traffic-manager is kept
+`, out.String())
+
+	// Errors that aren't caused by the user point to the logs
+	err = reportRemovals(&bytes.Buffer{}, []*connector.UninstallResult_Removal{
+		{Kind: "agent", Name: "echo", Namespace: "default", ErrorText: "timeout", ErrorCategory: int32(errcat.Unknown)},
+	})
+	assert.Equal(t, errcat.Unknown, errcat.GetCategory(err))
+
+	out.Reset()
+	assert.NoError(t, reportRemovals(out, []*connector.UninstallResult_Removal{
+		{Kind: "traffic-manager", Name: "traffic-manager", Namespace: "ambassador"},
+	}))
+	assert.Equal(t, "Removed the traffic-manager from namespace ambassador\n", out.String())
+}
@@ -0,0 +1,266 @@
+package cli
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/blang/semver"
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+)
+
+// upgrader replaces the executable of the CLI with another release.
+type upgrader struct {
+	// baseURL is the URL that the releases for the current os/arch are downloaded from
+	baseURL        string
+	exe            string
+	current        semver.Version
+	allowDowngrade bool
+}
+
+func upgradeCommand() *cobra.Command {
+	var version string
+	u := &upgrader{}
+	cmd := &cobra.Command{
+		Use:  "upgrade",
+		Args: cobra.NoArgs,
+
+		Short: "Upgrade telepresence to the latest, or to a given, version",
+		Long: `Upgrade telepresence to the latest, or to a given, version
+
+The release for this OS and architecture is downloaded from the same location as the update check uses, its
+checksum is verified, and it then replaces the executable of this command. The replacement requires root
+privileges when the executable is in a location that the user can't write to. Running daemons must be
+restarted with "telepresence quit -s" to use the new version.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := cmd.Context()
+			exe, err := os.Executable()
+			if err == nil {
+				exe, err = filepath.EvalSymlinks(exe)
+			}
+			if err != nil {
+				return err
+			}
+			u.baseURL = downloadURL(ctx)
+			u.exe = exe
+			u.current = client.Semver()
+			target, err := u.targetVersion(ctx, version)
+			if err != nil {
+				return err
+			}
+			err = u.upgrade(ctx, cmd.OutOrStdout(), target)
+			if errors.Is(err, fs.ErrPermission) && !proc.IsAdmin() {
+				// Let the executable replace itself with root privileges, using the same release
+				fmt.Fprintf(cmd.OutOrStdout(), "Need root privileges to replace %s\n", exe)
+				args := []string{exe, "upgrade", "--version", target.String()}
+				if u.allowDowngrade {
+					args = append(args, "--allow-downgrade")
+				}
+				err = proc.RunAsRoot(ctx, args...)
+			}
+			return err
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&version, "version", "", "The version to upgrade to, e.g. v2.4.5. Defaults to the latest version")
+	flags.BoolVar(&u.allowDowngrade, "allow-downgrade", false, "Allow the version to be older than the current version")
+	return cmd
+}
+
+// artifactName returns the name of the release artifact for the current OS. The Windows release is a
+// zip file that contains the executable along with its dependencies.
+func artifactName() string {
+	if runtime.GOOS == "windows" {
+		return binaryName + ".zip"
+	}
+	return binaryName
+}
+
+// targetVersion returns the given version, or the latest version when it's empty.
+func (u *upgrader) targetVersion(ctx context.Context, version string) (semver.Version, error) {
+	if version != "" {
+		v, err := semver.ParseTolerant(version)
+		if err != nil {
+			return semver.Version{}, errcat.User.Newf("invalid version %q: %v", version, err)
+		}
+		return v, nil
+	}
+	data, err := u.get(ctx, "stable.txt")
+	if err != nil {
+		return semver.Version{}, fmt.Errorf("unable to get the latest version: %w", err)
+	}
+	vs := strings.TrimSpace(string(data))
+	v, err := semver.Parse(vs)
+	if err != nil {
+		return semver.Version{}, fmt.Errorf("unable to parse the latest version %q: %w", vs, err)
+	}
+	return v, nil
+}
+
+// upgrade replaces the executable with the release of the given version. It refuses to downgrade unless
+// allowDowngrade is set. An error that satisfies errors.Is(err, fs.ErrPermission) is returned when the
+// directory of the executable isn't writable.
+func (u *upgrader) upgrade(ctx context.Context, out io.Writer, target semver.Version) error {
+	switch {
+	case target.EQ(u.current):
+		fmt.Fprintf(out, "%s is already at version %s\n", binaryName, &target)
+		return nil
+	case target.LT(u.current) && !u.allowDowngrade:
+		return errcat.User.Newf("refusing to downgrade %s from version %s to %s. Use --allow-downgrade to downgrade anyway",
+			binaryName, &u.current, &target)
+	}
+	newExe, err := u.download(ctx, target)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(newExe)
+	if err = replaceExecutable(u.exe, newExe); err != nil {
+		return fmt.Errorf("unable to replace %s: %w", u.exe, err)
+	}
+	fmt.Fprintf(out, "%s has been upgraded from version %s to %s.\n", binaryName, &u.current, &target)
+	fmt.Fprintf(out, "Restart running daemons with \"%s quit -s\" so that they use the new version.\n", binaryName)
+	return nil
+}
+
+// download downloads the release of the given version into a new file in the directory of the executable,
+// so that it can be renamed to replace it, and verifies its checksum. The returned file has the mode of the
+// executable.
+func (u *upgrader) download(ctx context.Context, target semver.Version) (string, error) {
+	fi, err := os.Stat(u.exe)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Dir(u.exe)
+	tmp, err := os.CreateTemp(dir, "."+binaryName+"-upgrade-*")
+	if err != nil {
+		return "", err
+	}
+	tmpName := tmp.Name()
+	err = u.downloadArtifact(ctx, target, tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && strings.HasSuffix(artifactName(), ".zip") {
+		var exeName string
+		if exeName, err = extractExecutable(tmpName, dir); err == nil {
+			_ = os.Remove(tmpName)
+			tmpName = exeName
+		}
+	}
+	if err == nil {
+		err = os.Chmod(tmpName, fi.Mode().Perm())
+	}
+	if err != nil {
+		_ = os.Remove(tmpName)
+		return "", err
+	}
+	return tmpName, nil
+}
+
+// downloadArtifact writes the artifact of the given version to the given file, and verifies that its
+// SHA-256 checksum is the one that is published along with it.
+func (u *upgrader) downloadArtifact(ctx context.Context, target semver.Version, f *os.File) error {
+	artifact := target.String() + "/" + artifactName()
+	sumData, err := u.get(ctx, artifact+".sha256")
+	if err != nil {
+		return fmt.Errorf("unable to get the checksum of %s: %w", artifact, err)
+	}
+	// The checksum file is in the format of sha256sum, i.e. the checksum is followed by the file name
+	fields := strings.Fields(string(sumData))
+	if len(fields) == 0 {
+		return fmt.Errorf("the checksum of %s is empty", artifact)
+	}
+	expected, err := hex.DecodeString(fields[0])
+	if err != nil || len(expected) != sha256.Size {
+		return fmt.Errorf("the checksum of %s is not a SHA-256 checksum", artifact)
+	}
+
+	rc, err := u.open(ctx, artifact)
+	if err != nil {
+		return fmt.Errorf("unable to download %s: %w", artifact, err)
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(f, h), rc); err != nil {
+		return fmt.Errorf("unable to download %s: %w", artifact, err)
+	}
+	if sum := h.Sum(nil); string(sum) != string(expected) {
+		return fmt.Errorf("checksum verification of %s failed: expected %x, got %x", artifact, expected, sum)
+	}
+	return nil
+}
+
+// extractExecutable extracts the executable from the given zip file into a new file in dir.
+func extractExecutable(zipFile, dir string) (string, error) {
+	zr, err := zip.OpenReader(zipFile)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+	for _, zf := range zr.File {
+		if zf.Name != binaryName+".exe" {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+		tmp, err := os.CreateTemp(dir, "."+binaryName+"-upgrade-*.exe")
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(tmp, rc)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+			return "", err
+		}
+		return tmp.Name(), nil
+	}
+	return "", fmt.Errorf("%s contains no %s.exe", filepath.Base(zipFile), binaryName)
+}
+
+// open returns the body of the given path of the base URL.
+func (u *upgrader) open(ctx context.Context, path string) (io.ReadCloser, error) {
+	url := u.baseURL + "/" + path
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(rq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// get returns the contents of the given path of the base URL.
+func (u *upgrader) get(ctx context.Context, path string) ([]byte, error) {
+	rc, err := u.open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
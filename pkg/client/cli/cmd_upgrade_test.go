@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/blang/semver"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func Test_upgrade(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the Windows release is a zip file")
+	}
+	ctx := dlog.NewTestContext(t, false)
+
+	// The releases. The checksum of 2.5.1 doesn't match its artifact.
+	releases := map[string]string{
+		"stable.txt":                "2.5.0\n",
+		"2.5.0/telepresence":        "binary 2.5.0",
+		"2.5.1/telepresence":        "binary 2.5.1",
+		"2.5.1/telepresence.sha256": fmt.Sprintf("%x  telepresence\n", sha256.Sum256([]byte("tampered"))),
+		"2.3.0/telepresence":        "binary 2.3.0",
+	}
+	for _, v := range []string{"2.5.0", "2.3.0"} {
+		releases[v+"/telepresence.sha256"] = fmt.Sprintf("%x  telepresence\n", sha256.Sum256([]byte(releases[v+"/telepresence"])))
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := releases[strings.TrimPrefix(r.URL.Path, "/download/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	}))
+	defer srv.Close()
+
+	// newInstallation returns an upgrader of an installation of version 2.4.5 in a temporary directory.
+	newInstallation := func(t *testing.T) *upgrader {
+		exe := filepath.Join(t.TempDir(), "telepresence")
+		require.NoError(t, os.WriteFile(exe, []byte("binary 2.4.5"), 0o755))
+		return &upgrader{
+			baseURL: srv.URL + "/download",
+			exe:     exe,
+			current: semver.MustParse("2.4.5"),
+		}
+	}
+	// checkInstallation asserts that the installation has the given binary, and nothing else.
+	checkInstallation := func(t *testing.T, u *upgrader, binary string) {
+		content, err := os.ReadFile(u.exe)
+		require.NoError(t, err)
+		assert.Equal(t, binary, string(content))
+		fi, err := os.Stat(u.exe)
+		require.NoError(t, err)
+		assert.Equal(t, fs.FileMode(0o755), fi.Mode().Perm())
+		entries, err := os.ReadDir(filepath.Dir(u.exe))
+		require.NoError(t, err)
+		require.Len(t, entries, 1, "temporary files are removed")
+	}
+
+	t.Run("latest", func(t *testing.T) {
+		u := newInstallation(t)
+		target, err := u.targetVersion(ctx, "")
+		require.NoError(t, err)
+		assert.Equal(t, "2.5.0", target.String())
+
+		out := &bytes.Buffer{}
+		require.NoError(t, u.upgrade(ctx, out, target))
+		checkInstallation(t, u, "binary 2.5.0")
+		assert.Contains(t, out.String(), "upgraded from version 2.4.5 to 2.5.0")
+		assert.Contains(t, out.String(), `"telepresence quit -s"`, "the user is advised to restart the daemons")
+	})
+
+	t.Run("already at version", func(t *testing.T) {
+		u := newInstallation(t)
+		out := &bytes.Buffer{}
+		require.NoError(t, u.upgrade(ctx, out, semver.MustParse("2.4.5")))
+		checkInstallation(t, u, "binary 2.4.5")
+		assert.Contains(t, out.String(), "already at version 2.4.5")
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		u := newInstallation(t)
+		target, err := u.targetVersion(ctx, "v2.5.1")
+		require.NoError(t, err)
+		err = u.upgrade(ctx, &bytes.Buffer{}, target)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "checksum verification of 2.5.1/telepresence failed")
+		checkInstallation(t, u, "binary 2.4.5")
+	})
+
+	t.Run("missing release", func(t *testing.T) {
+		u := newInstallation(t)
+		err := u.upgrade(ctx, &bytes.Buffer{}, semver.MustParse("2.6.0"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "404")
+		checkInstallation(t, u, "binary 2.4.5")
+	})
+
+	t.Run("downgrade", func(t *testing.T) {
+		u := newInstallation(t)
+		target := semver.MustParse("2.3.0")
+		err := u.upgrade(ctx, &bytes.Buffer{}, target)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "--allow-downgrade")
+		checkInstallation(t, u, "binary 2.4.5")
+
+		u.allowDowngrade = true
+		require.NoError(t, u.upgrade(ctx, &bytes.Buffer{}, target))
+		checkInstallation(t, u, "binary 2.3.0")
+	})
+
+	t.Run("not writable", func(t *testing.T) {
+		if os.Getuid() == 0 {
+			t.Skip("root can write to any directory")
+		}
+		u := newInstallation(t)
+		dir := filepath.Dir(u.exe)
+		require.NoError(t, os.Chmod(dir, 0o555))
+		defer func() { _ = os.Chmod(dir, 0o755) }()
+		err := u.upgrade(ctx, &bytes.Buffer{}, semver.MustParse("2.5.0"))
+		assert.True(t, errors.Is(err, fs.ErrPermission), "%v is not a permission error", err)
+	})
+
+	t.Run("invalid version", func(t *testing.T) {
+		_, err := newInstallation(t).targetVersion(ctx, "latest")
+		assert.Error(t, err)
+	})
+}
@@ -2,7 +2,9 @@ package cli
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 
 	"github.com/spf13/cobra"
 	empty "google.golang.org/protobuf/types/known/emptypb"
@@ -12,56 +14,114 @@ import (
 	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 )
 
+// versionInfo is the version of the client and of the daemons.
+type versionInfo struct {
+	Client     string             `json:"client"`
+	RootDaemon *daemonVersionInfo `json:"root_daemon"`
+	UserDaemon *daemonVersionInfo `json:"user_daemon"`
+
+	// TrafficManagerNamespace is the namespace of the traffic-manager that the user daemon is connected to.
+	TrafficManagerNamespace string `json:"traffic_manager_namespace,omitempty"`
+}
+
+type daemonVersionInfo struct {
+	Running    bool   `json:"running"`
+	Version    string `json:"version,omitempty"`
+	APIVersion int32  `json:"api_version,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
 func versionCommand() *cobra.Command {
-	return &cobra.Command{
+	var output string
+	cmd := &cobra.Command{
 		Use:  "version",
 		Args: cobra.NoArgs,
 
 		Short:   "Show version",
 		PreRunE: forcedUpdateCheck,
-		RunE:    printVersion,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if output != "" && output != "json" {
+				return errcat.User.Newf("unsupported output format %q", output)
+			}
+			return printVersion(cmd, output)
+		},
 	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", `Set the output format. The only supported format is "json"`)
+	return cmd
 }
 
-// printVersion requests version info from the daemon and prints both client and daemon version.
-func printVersion(cmd *cobra.Command, _ []string) error {
-	fmt.Fprintf(cmd.OutOrStdout(), "Client: %s\n",
-		client.DisplayVersion())
+// printVersion requests version info from the daemons and prints both client and daemon version.
+func printVersion(cmd *cobra.Command, output string) error {
+	vi, err := collectVersion(cmd.Context())
+	out := cmd.OutOrStdout()
+	if output == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if encErr := enc.Encode(vi); encErr != nil {
+			return encErr
+		}
+		return err
+	}
+	fmt.Fprintf(out, "Client: %s\n", vi.Client)
+	vi.RootDaemon.writeText(out, "Root Daemon")
+	vi.UserDaemon.writeText(out, "User Daemon")
+	if vi.TrafficManagerNamespace != "" {
+		fmt.Fprintf(out, "Traffic Manager namespace: %s\n", vi.TrafficManagerNamespace)
+	}
+	return err
+}
 
+// collectVersion requests version info from the daemons. A daemon that isn't running isn't an error, but
+// the last error returned by a daemon that is running is returned along with the complete info.
+func collectVersion(ctx context.Context) (*versionInfo, error) {
+	vi := &versionInfo{Client: client.DisplayVersion()}
 	var retErr error
 
-	version, err := daemonVersion(cmd.Context())
+	version, err := daemonVersion(ctx)
+	vi.RootDaemon = newDaemonVersionInfo(version, err, cliutil.ErrNoDaemon)
+	if vi.RootDaemon.Error != "" {
+		retErr = err
+	}
+
+	version, err = connectorVersion(ctx)
+	vi.UserDaemon = newDaemonVersionInfo(version, err, cliutil.ErrNoConnector)
 	switch {
-	case err == nil:
-		fmt.Fprintf(cmd.OutOrStdout(), "Root Daemon: %s (api v%d)\n",
-			version.Version, version.ApiVersion)
-	case err == cliutil.ErrNoDaemon:
-		fmt.Fprintf(cmd.OutOrStdout(), "Root Daemon: not running\n")
-	default:
-		fmt.Fprintf(cmd.OutOrStdout(), "Root Daemon: error: %v\n", err)
+	case vi.UserDaemon.Error != "":
 		retErr = err
+	case vi.UserDaemon.Running:
+		vi.TrafficManagerNamespace = connectedManagerNamespace(ctx)
 	}
+	return vi, retErr
+}
 
-	version, err = connectorVersion(cmd.Context())
+func newDaemonVersionInfo(version *common.VersionInfo, err, errNotRunning error) *daemonVersionInfo {
 	switch {
 	case err == nil:
-		fmt.Fprintf(cmd.OutOrStdout(), "User Daemon: %s (api v%d)\n",
-			version.Version, version.ApiVersion)
-	case err == cliutil.ErrNoConnector:
-		fmt.Fprintf(cmd.OutOrStdout(), "User Daemon: not running\n")
+		return &daemonVersionInfo{Running: true, Version: version.Version, APIVersion: version.ApiVersion}
+	case err == errNotRunning:
+		return &daemonVersionInfo{}
 	default:
-		fmt.Fprintf(cmd.OutOrStdout(), "User Daemon: error: %v\n", err)
-		retErr = err
+		return &daemonVersionInfo{Running: true, Error: err.Error()}
 	}
+}
 
-	return retErr
+func (dv *daemonVersionInfo) writeText(out io.Writer, name string) {
+	switch {
+	case dv.Error != "":
+		fmt.Fprintf(out, "%s: error: %s\n", name, dv.Error)
+	case dv.Running:
+		fmt.Fprintf(out, "%s: %s (api v%d)\n", name, dv.Version, dv.APIVersion)
+	default:
+		fmt.Fprintf(out, "%s: not running\n", name)
+	}
 }
 
 func daemonVersion(ctx context.Context) (*common.VersionInfo, error) {
 	var version *common.VersionInfo
-	err := cliutil.WithStartedDaemon(ctx, func(ctx context.Context, daemonClient daemon.DaemonClient) error {
+	err := withStartedDaemon(ctx, func(ctx context.Context, daemonClient daemon.DaemonClient) error {
 		var err error
 		version, err = daemonClient.Version(ctx, &empty.Empty{})
 		if err != nil {
@@ -77,7 +137,7 @@ func daemonVersion(ctx context.Context) (*common.VersionInfo, error) {
 
 func connectorVersion(ctx context.Context) (*common.VersionInfo, error) {
 	var version *common.VersionInfo
-	err := cliutil.WithStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+	err := withStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
 		var err error
 		version, err = connectorClient.Version(ctx, &empty.Empty{})
 		if err != nil {
@@ -90,3 +150,21 @@ func connectorVersion(ctx context.Context) (*common.VersionInfo, error) {
 	}
 	return version, nil
 }
+
+// connectedManagerNamespace returns the namespace of the traffic-manager that the user daemon is connected to,
+// or an empty string when it isn't connected.
+func connectedManagerNamespace(ctx context.Context) string {
+	var ns string
+	_ = withStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+		status, err := connectorClient.Status(ctx, &connector.ConnectRequest{KubeFlags: connectorKubeFlagMap(ctx)})
+		if err != nil {
+			return err
+		}
+		switch status.Error {
+		case connector.ConnectInfo_UNSPECIFIED, connector.ConnectInfo_ALREADY_CONNECTED, connector.ConnectInfo_MUST_RESTART:
+			ns = status.ManagerNamespace
+		}
+		return nil
+	})
+	return ns
+}
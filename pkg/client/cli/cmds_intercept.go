@@ -9,18 +9,24 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/docker/docker/pkg/term"
 	"github.com/spf13/cobra"
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/datawire/dlib/dcontext"
 	"github.com/datawire/dlib/dexec"
+	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
@@ -29,30 +35,67 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/extensions"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/scout"
+	"github.com/telepresenceio/telepresence/v2/pkg/forwarder"
 	"github.com/telepresenceio/telepresence/v2/pkg/log"
 	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
+)
+
+// The values of the --mount-type flag
+const (
+	mountTypeAuto  = "auto"
+	mountTypeSSHFS = "sshfs"
+	mountTypeSFTP  = "sftp"
 )
 
 type interceptArgs struct {
-	name        string // Args[0] || `${Args[0]}-${--namespace}` // which depends on a combinationof --workload and --namespace
-	agentName   string // --workload || Args[0] // only valid if !localOnly
-	namespace   string // --namespace
-	port        string // --port // only valid if !localOnly
-	serviceName string // --service // only valid if !localOnly
-	localOnly   bool   // --local-only
+	name        string   // Args[0] || `${Args[0]}-${--namespace}` // which depends on a combinationof --workload and --namespace
+	agentName   string   // --workload || Args[0] // only valid if !localOnly
+	namespace   string   // --namespace
+	ports       []string // --port // only valid if !localOnly
+	serviceName string   // --service // only valid if !localOnly
+	container   string   // --container // only valid if !localOnly
+	localOnly   bool     // --local-only
 
 	previewEnabled bool                 // --preview-url // only valid if !localOnly
 	previewSpec    *manager.PreviewSpec // --preview-url-* // only valid if !localOnly
-
-	envFile  string   // --env-file
-	envJSON  string   // --env-json
-	mount    string   // --mount // "true", "false", or desired mount point // only valid if !localOnly
-	mountSet bool     // whether --mount was passed
-	toPod    []string // --to-pod
+	ingress        ingressArgs          // --ingress-* // only valid if previewEnabled
+
+	envFile           string   // --env-file
+	envJSON           string   // --env-json
+	envFileUnfiltered string   // --env-file-unfiltered
+	envInclude        []string // --env-include
+	envExclude        []string // --env-exclude
+	mount             string   // --mount // "true", "false", or desired mount point // only valid if !localOnly
+	mountSet          bool     // whether --mount was passed
+	mountReadOnly     bool     // --mount-readonly // only valid if !localOnly
+	mountVolumes      []string // --mount-volume // only valid if !localOnly
+	mountType         string   // --mount-type // "auto", "sshfs", or "sftp" // only valid if !localOnly
+	toPod             []string // --to-pod
+
+	httpHeaders    []string // --http-header // only valid if !localOnly
+	httpPathPrefix string   // --http-path-prefix // only valid if !localOnly
+	replace        bool     // --replace // only valid if !localOnly
+	steal          bool     // --steal // only valid if !localOnly
+
+	agentTLSTerminate bool   // --agent-tls-terminate // only valid if !localOnly
+	agentTLSSecret    string // --agent-tls-secret // only valid if !localOnly
+	localTLS          bool   // --local-tls // only valid if !localOnly
+	localCAFile       string // --local-ca-file // only valid if !localOnly
+
+	ttl    time.Duration // --ttl // only valid if !localOnly
+	extend time.Duration // --extend // extends the intercept instead of creating it
 
 	dockerRun   bool   // --docker-run
 	dockerMount string // --docker-mount // where to mount in a docker container. Defaults to mount unless mount is "true" or "false".
 
+	dryRun bool          // --dry-run
+	output string        // --output // not valid with a command, --docker-run, or --extend
+	json   *jsonDocument // the stdout of the command when output is "json", which then never prompts
+
+	wait   bool // --wait // only used to resolve noWait
+	noWait bool // --no-wait || !--wait // don't wait for a ready traffic-agent to make the intercept active
+
 	extState         *extensions.ExtensionsState // extension flags
 	extRequiresLogin bool                        // pre-extracted from extState
 
@@ -88,11 +131,28 @@ type interceptState struct {
 	managerClient   manager.ManagerClient
 	connInfo        *connector.ConnectInfo
 
+	// dockerContainer is the container of the daemons when they run in docker mode
+	dockerContainer string
+
+	// wslPaths translates the paths that the daemons see when the CLI in WSL uses the daemons on Windows
+	wslPaths *cliutil.WSLPaths
+
+	// envFilters are the envFilters of the config with the --env-include and --env-exclude patterns added
+	envFilters client.EnvFilters
+
 	// set later ///////////////////////////////////////////////////////////
 
-	env        map[string]string
-	mountPoint string // if non-empty, this the final mount point of a successful mount
-	localPort  uint16 // the parsed <local port>
+	unfilteredEnv map[string]string // the environment as reported by the traffic-agent plus the variables added by telepresence
+	remoteEnv     map[string]string // the environment as reported by the traffic-agent, filtered by the envFilters
+	env           map[string]string // the remoteEnv plus the variables added by telepresence
+	mountPoint    string            // if non-empty, this the final mount point of a successful mount
+	mountProblem  error             // if non-nil, the reason why the remote volumes can't be mounted
+	mountType     string            // the resolved --mount-type, "sshfs" or "sftp"
+	localPort     uint16            // the parsed <local port>
+	protocol      string            // the parsed <protocol>, empty means TCP
+
+	// the parsed --port flags that follow the first one
+	extraPortMappings []*manager.InterceptPortMapping
 
 	dockerPort uint16
 }
@@ -102,22 +162,32 @@ func interceptCommand(ctx context.Context) *cobra.Command {
 		Use:  "intercept [flags] <intercept_base_name> [-- <command with arguments...>]",
 		Args: cobra.MinimumNArgs(1),
 
-		Short:    "Intercept a service",
+		Short: "Intercept a service",
+		Long: `Intercept a service
+
+A command given after -- is started with the environment of the intercepted container merged over the
+local environment, and the intercept is left when the command exits or is interrupted.`,
 		PreRunE:  updateCheckIfDue,
 		PostRunE: raiseCloudMessage,
 	}
 	args := interceptArgs{}
 	flags := cmd.Flags()
 
-	flags.StringVarP(&args.agentName, "workload", "w", "", "Name of workload (Deployment, ReplicaSet) to intercept, if different from <name>")
-	flags.StringVarP(&args.port, "port", "p", "8080", ``+
+	flags.StringVarP(&args.agentName, "workload", "w", "", "Name of workload (Deployment, ReplicaSet, StatefulSet, Rollout) to intercept, if different from <name>")
+	flags.StringSliceVarP(&args.ports, "port", "p", []string{"8080"}, ``+
 		`Local port to forward to. If intercepting a service with multiple ports, `+
 		`use <local port>:<svcPortIdentifier>, where the identifier is the port name or port number. `+
-		`With --docker-run, use <local port>:<container port> or <local port>:<container port>:<svcPortIdentifier>.`,
+		`With --docker-run, use <local port>:<container port> or <local port>:<container port>:<svcPortIdentifier>. `+
+		`Can be repeated to intercept more than one port of the service. Each additional port must be given as `+
+		`<local port>:<svcPortIdentifier>, and the traffic to that service port is sent to the local port.`,
 	)
 
 	flags.StringVar(&args.serviceName, "service", "", "Name of service to intercept. If not provided, we will try to auto-detect one")
 
+	flags.StringVar(&args.container, "container", "", ``+
+		`Name of the container to intercept. Only needed when the target port of the service is declared by more `+
+		`than one container of the workload`)
+
 	flags.BoolVarP(&args.localOnly, "local-only", "l", false, ``+
 		`Declare a local-only intercept for the purpose of getting direct outbound access to the intercept's namespace`)
 
@@ -127,21 +197,92 @@ func interceptCommand(ctx context.Context) *cobra.Command {
 	)
 	args.previewSpec = &manager.PreviewSpec{}
 	addPreviewFlags("preview-url-", flags, args.previewSpec)
+	addIngressFlags(flags, &args.ingress)
 
 	flags.StringVarP(&args.envFile, "env-file", "e", "", ``+
-		`Also emit the remote environment to an env file in Docker Compose format. `+
-		`See https://docs.docker.com/compose/env-file/ for more information on the limitations of this format.`)
+		`Also emit the remote environment to an env file in dotenv format. Values are quoted when needed, and `+
+		`multiline values are written with escaped newlines. The file is readable by the current user only.`)
+
+	flags.StringVarP(&args.envJSON, "env-json", "j", "", ``+
+		`Also emit the remote environment to a file as a JSON object of name/value pairs. The values are written `+
+		`exactly as reported by the traffic-agent. Can be combined with --env-file.`)
+
+	flags.StringSliceVar(&args.envInclude, "env-include", nil, ``+
+		`Only pass variables with a name that matches this glob pattern, e.g. 'APP_*', to --env-file, --env-json, `+
+		`and the command. Can be repeated. The patterns are added to the envFilters.include of the config.`)
 
-	flags.StringVarP(&args.envJSON, "env-json", "j", "", `Also emit the remote environment to a file as a JSON blob.`)
+	flags.StringSliceVar(&args.envExclude, "env-exclude", nil, ``+
+		`Don't pass variables with a name that matches this glob pattern, e.g. 'KUBERNETES_*', to --env-file, `+
+		`--env-json, and the command. Can be repeated, and takes precedence over --env-include. The patterns are `+
+		`added to the envFilters.exclude of the config.`)
+
+	flags.StringVar(&args.envFileUnfiltered, "env-file-unfiltered", "", ``+
+		`Also emit the complete remote environment, ignoring --env-include, --env-exclude, and the envFilters `+
+		`of the config, to an env file in dotenv format.`)
 
 	flags.StringVarP(&args.mount, "mount", "", "true", ``+
 		`The absolute path for the root directory where volumes will be mounted, $TELEPRESENCE_ROOT. Use "true" to `+
 		`have Telepresence pick a random mount point (default). Use "false" to disable filesystem mounting entirely.`)
 
+	flags.BoolVar(&args.mountReadOnly, "mount-readonly", false, ``+
+		`Mount the remote volumes read-only, so that local processes cannot modify them`)
+
+	flags.StringArrayVar(&args.mountVolumes, "mount-volume", nil, ``+
+		`Only mount this volume of the intercepted container, given as <name>[:<subpath>] where the optional subpath `+
+		`is relative to the mount path of the volume. Can be repeated. All volumes are mounted when not given.`)
+
+	flags.StringVar(&args.mountType, "mount-type", mountTypeAuto, ``+
+		`How to mount the remote volumes. Use "sshfs" to mount them using sshfs and FUSE, or "sftp" to mirror them `+
+		`in the mount point using a built-in SFTP client. A mirror doesn't need sshfs or FUSE, but remote changes are `+
+		`picked up periodically, local changes are written back periodically, and there are no file system `+
+		`notifications. Use "auto" to use sshfs when it's available and sftp otherwise.`)
+
 	flags.StringSliceVar(&args.toPod, "to-pod", []string{}, ``+
-		`An additional port to forward from the intercepted pod, will be made available at localhost:PORT `+
+		`An additional port to forward from the intercepted pod, will be made available at localhost:PORT. `+
+		`Use <port>/UDP to forward a UDP port. Can be repeated. `+
 		`Use this to, for example, access proxy/helper sidecars in the intercepted pod.`)
 
+	flags.StringArrayVar(&args.httpHeaders, "http-header", nil, ``+
+		`Only intercept HTTP requests that have a header matching this "NAME=REGEXP" specifier. The regular `+
+		`expression must match the header value in full. Can be repeated, in which case all headers must match. `+
+		`Requests that don't match are served by the intercepted container.`)
+
+	flags.StringVar(&args.httpPathPrefix, "http-path-prefix", "", ``+
+		`Only intercept HTTP requests with a path that starts with this prefix. `+
+		`Requests that don't match are served by the intercepted container.`)
+
+	flags.BoolVar(&args.replace, "replace", false, ``+
+		`Idle the intercepted container for the duration of the intercept, so that nothing but the local process `+
+		`serves the workload. The container is restored when the intercept ends. A replaced workload cannot `+
+		`be intercepted by others. Can't be used with Argo Rollouts.`)
+
+	flags.BoolVar(&args.steal, "steal", false, ``+
+		`End the intercepts of others that conflict with this one. Requires permission to update the workload.`)
+
+	flags.BoolVar(&args.agentTLSTerminate, "agent-tls-terminate", false, ``+
+		`Let the traffic-agent terminate the TLS of the intercepted connections, so that the local process receives `+
+		`plaintext. Requires --agent-tls-secret.`)
+
+	flags.StringVar(&args.agentTLSSecret, "agent-tls-secret", "", ``+
+		`The name of the kubernetes.io/tls secret, in the namespace of the intercepted workload, that holds the `+
+		`certificate and key that the traffic-agent uses to terminate TLS.`)
+
+	flags.BoolVar(&args.localTLS, "local-tls", false, ``+
+		`Encrypt the intercepted connections again toward the local process, which then must serve TLS. Requires `+
+		`--agent-tls-terminate. The certificate of the local process isn't verified unless --local-ca-file is given.`)
+
+	flags.StringVar(&args.localCAFile, "local-ca-file", "", ``+
+		`A PEM file with the CA certificates that the certificate of the local process is verified with when `+
+		`using --local-tls. The certificate must be valid for the IP address of the local process, e.g. 127.0.0.1.`)
+
+	flags.DurationVar(&args.ttl, "ttl", 0, ``+
+		`End the intercept when this much time has passed, even if it's still in use. Defaults to the `+
+		`intercept.defaultTTL of the config. Zero means that the intercept never expires.`)
+
+	flags.DurationVar(&args.extend, "extend", 0, ``+
+		`Make the existing intercept with the given name expire this much time from now, instead of creating `+
+		`an intercept. E.g. "telepresence intercept my-intercept --extend 1h".`)
+
 	flags.BoolVarP(&args.dockerRun, "docker-run", "", false, ``+
 		`Run a Docker container with intercepted environment, volume mount, by passing arguments after -- to 'docker run', `+
 		`e.g. '--docker-run -- -it --rm ubuntu:20.04 /bin/bash'`)
@@ -150,22 +291,58 @@ func interceptCommand(ctx context.Context) *cobra.Command {
 		`The volume mount point in docker. Defaults to same as "--mount"`)
 
 	flags.StringVarP(&args.namespace, "namespace", "n", "", "If present, the namespace scope for this CLI request")
+	_ = cmd.RegisterFlagCompletionFunc("namespace", completeNamespaces)
+
+	flags.BoolVar(&args.dryRun, "dry-run", false, ``+
+		`Resolve the workload and service, and show the intercept and the changes that it makes to the cluster `+
+		`without making them`)
+	flags.StringVar(&args.output, "output", "", ``+
+		`Set the output format. The only supported format is "json", which writes a single JSON document that describes `+
+		`the intercept, or the error that prevented it, to stdout, and fails rather than prompts for anything that isn't `+
+		`given by a flag. Cannot be combined with a command, --docker-run, or --extend.`)
+
+	flags.BoolVar(&args.wait, "wait", true, ``+
+		`Wait, for at most the intercept timeout of the config, for the workload to have a ready pod with a `+
+		`traffic-agent that makes the intercept active, and show the progress while waiting.`)
+	flags.BoolVar(&args.noWait, "no-wait", false, ``+
+		`Return as soon as the traffic-manager has accepted the intercept. The intercept is pending until a `+
+		`traffic-agent picks it up, which "telepresence list" and "telepresence status" show. Cannot be combined `+
+		`with a command, --docker-run, or the flags that write the environment to a file.`)
+	cmd.ValidArgsFunction = completeWorkloads(connector.ListRequest_INTERCEPTABLE, &args.namespace, 1)
 
 	var extErr error
 	args.extState, extErr = extensions.LoadExtensions(ctx, flags)
 
-	cmd.RunE = func(cmd *cobra.Command, positional []string) error {
+	cmd.RunE = func(cmd *cobra.Command, positional []string) (err error) {
+		switch args.output {
+		case "":
+		case "json":
+			// Everything but the JSON document, which also describes errors, goes to stderr
+			args.json = &jsonDocument{out: cmd.OutOrStdout()}
+			cmd.SetOut(cmd.ErrOrStderr())
+			defer func() { err = args.json.writeError(err) }()
+		default:
+			return errcat.User.Newf("unsupported output format %q", args.output)
+		}
+		if args.output != "" && (len(positional) > 1 || args.dockerRun || args.extend != 0) {
+			return errcat.User.New("--output cannot be used with a command, --docker-run, or --extend")
+		}
 		if extErr != nil {
 			return extErr
 		}
 		// arg-parsing
-		var err error
 		args.extRequiresLogin, err = args.extState.RequiresAPIKeyOrLicense()
 		if err != nil {
 			return err
 		}
 		args.name = positional[0]
 		args.cmdline = positional[1:]
+		if err = args.ingress.resolve(flags); err != nil {
+			return err
+		}
+		if args.ingress.given() && !args.previewEnabled {
+			return errcat.User.New("the --ingress flags can only be used with --preview-url")
+		}
 		switch args.localOnly { // a switch instead of an if/else to get gocritic to not suggest "else if"
 		case true:
 			// Not actually intercepting anything -- check that the flags make sense for that
@@ -175,15 +352,33 @@ func interceptCommand(ctx context.Context) *cobra.Command {
 			if args.serviceName != "" {
 				return errcat.User.New("a local-only intercept cannot have a service")
 			}
+			if args.container != "" {
+				return errcat.User.New("a local-only intercept cannot have a container")
+			}
 			if cmd.Flag("port").Changed {
 				return errcat.User.New("a local-only intercept cannot have a port")
 			}
-			if cmd.Flag("mount").Changed {
+			if cmd.Flag("mount").Changed || args.mountReadOnly || len(args.mountVolumes) > 0 || cmd.Flag("mount-type").Changed {
 				return errcat.User.New("a local-only intercept cannot have mounts")
 			}
 			if cmd.Flag("preview-url").Changed && args.previewEnabled {
 				return errcat.User.New("a local-only intercept cannot be previewed")
 			}
+			if len(args.httpHeaders) > 0 || args.httpPathPrefix != "" {
+				return errcat.User.New("a local-only intercept cannot have HTTP conditions")
+			}
+			if args.replace {
+				return errcat.User.New("a local-only intercept cannot replace a container")
+			}
+			if args.steal {
+				return errcat.User.New("a local-only intercept cannot steal an intercept")
+			}
+			if args.agentTLSTerminate || args.agentTLSSecret != "" || args.localTLS || args.localCAFile != "" {
+				return errcat.User.New("a local-only intercept cannot have TLS options")
+			}
+			if args.ttl != 0 || args.extend != 0 {
+				return errcat.User.New("a local-only intercept cannot expire")
+			}
 		case false:
 			// Actually intercepting something
 			if args.agentName == "" {
@@ -194,11 +389,34 @@ func interceptCommand(ctx context.Context) *cobra.Command {
 			}
 		}
 		args.mountSet = cmd.Flag("mount").Changed
+		if args.ttl < 0 || args.extend < 0 {
+			return errcat.User.New("--ttl and --extend cannot be negative")
+		}
+		if !cmd.Flag("ttl").Changed {
+			args.ttl = client.GetConfig(cmd.Context()).Intercept.DefaultTTL
+		}
+		if args.extend != 0 {
+			if len(args.cmdline) > 0 || args.dockerRun || args.dryRun {
+				return errcat.User.New("--extend cannot be used with a command, --docker-run, or --dry-run")
+			}
+			return extendIntercept(cmd, args.name, args.extend)
+		}
+		if args.dryRun && len(args.cmdline) > 0 {
+			return errcat.User.New("--dry-run cannot be used with a command")
+		}
 		if args.dockerRun {
 			if err := validateDockerArgs(args.cmdline); err != nil {
 				return err
 			}
 		}
+		if err := resolveWait(&args, cmd.Flag("wait").Changed); err != nil {
+			return err
+		}
+		for _, pattern := range append(append([]string{}, args.envInclude...), args.envExclude...) {
+			if err := client.ValidateEnvPattern(pattern); err != nil {
+				return errcat.User.New(err)
+			}
+		}
 		// run
 		return intercept(cmd, args)
 	}
@@ -206,24 +424,35 @@ func interceptCommand(ctx context.Context) *cobra.Command {
 	return cmd
 }
 
-func leaveCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:  "leave [flags] <intercept_name>",
-		Args: cobra.ExactArgs(1),
-
-		Short: "Remove existing intercept",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			return removeIntercept(cmd.Context(), strings.TrimSpace(args[0]))
-		},
+// resolveWait applies --wait to the noWait of the given args and checks that an intercept that isn't
+// waited for isn't used by something that needs the environment of the intercepted container, because
+// there's no environment until a traffic-agent has picked it up. The waitSet is true if --wait was given.
+func resolveWait(args *interceptArgs, waitSet bool) error {
+	if waitSet {
+		if args.wait && args.noWait {
+			return errcat.User.New("--wait and --no-wait are mutually exclusive")
+		}
+		args.noWait = !args.wait
+	}
+	if !args.noWait || args.localOnly {
+		return nil
+	}
+	if len(args.cmdline) > 0 || args.dockerRun {
+		return errcat.User.New("--no-wait cannot be used with a command or --docker-run")
+	}
+	if args.envFile != "" || args.envJSON != "" || args.envFileUnfiltered != "" {
+		return errcat.User.New("--no-wait cannot be used with --env-file, --env-json, or --env-file-unfiltered")
 	}
+	return nil
 }
 
 // Checks if login is necessary and then takes the necessary actions
-// depending if the cluster can connect to Ambassador Cloud
+// depending if the cluster can connect to Ambassador Cloud. The login
+// fails rather than opens a browser when the user can't be prompted.
 func loginIfNeeded(ctx context.Context, args interceptArgs) error {
 	if !client.GetConfig(ctx).Cloud.SkipLogin && (args.previewEnabled || args.extRequiresLogin) {
 		return cliutil.WithConnector(ctx, func(ctx context.Context, _ connector.ConnectorClient) error {
-			return cliutil.WithManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
+			return withManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
 				// We default to assuming they can connect to Ambassador Cloud
 				// unless the cluster tells us they can't
 				canConnect := true
@@ -232,6 +461,10 @@ func loginIfNeeded(ctx context.Context, args interceptArgs) error {
 					canConnect = resp.CanConnect
 				}
 				if canConnect {
+					if reason := proc.NoPromptReason(ctx); reason != "" && !cliutil.HasLoggedIn(ctx) {
+						return errcat.User.Newf("unable to log in to Ambassador Cloud because %s. Use "+
+							"\"telepresence login --apikey=<key>\" to log in first", reason)
+					}
 					if _, err := cliutil.EnsureLoggedIn(ctx, ""); err != nil {
 						return err
 					}
@@ -243,14 +476,45 @@ func loginIfNeeded(ctx context.Context, args interceptArgs) error {
 	return nil
 }
 
+// extendIntercept makes the intercept with the given name expire the given duration from now.
+func extendIntercept(cmd *cobra.Command, name string, ttl time.Duration) error {
+	return withConnector(cmd, true, func(ctx context.Context, _ connector.ConnectorClient, connInfo *connector.ConnectInfo) error {
+		return withManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
+			ii, err := managerClient.UpdateIntercept(ctx, &manager.UpdateInterceptRequest{
+				Session: connInfo.SessionInfo,
+				Name:    name,
+				Ttl:     int64(ttl),
+			})
+			if err != nil {
+				if st, ok := grpcStatus.FromError(err); ok {
+					switch st.Code() {
+					case grpcCodes.NotFound:
+						return errcat.User.Newf("intercept %q not found", name)
+					case grpcCodes.FailedPrecondition:
+						return errcat.User.Newf("%s, use \"telepresence leave %s\" and create it again", st.Message(), name)
+					}
+				}
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Intercept %s now expires at %s\n", name, ii.Expires.AsTime().Local().Format(time.RFC1123))
+			return nil
+		})
+	})
+}
+
 func intercept(cmd *cobra.Command, args interceptArgs) error {
+	if args.dryRun {
+		return withConnector(cmd, true, func(ctx context.Context, connectorClient connector.ConnectorClient, connInfo *connector.ConnectInfo) error {
+			return newInterceptState(ctx, safeCobraCommandImpl{cmd}, args, connectorClient, nil, connInfo).dryRun(ctx)
+		})
+	}
 	if len(args.cmdline) == 0 && !args.dockerRun {
 		// start and retain the intercept
 		return withConnector(cmd, true, func(ctx context.Context, connectorClient connector.ConnectorClient, connInfo *connector.ConnectInfo) error {
 			if err := loginIfNeeded(ctx, args); err != nil {
 				return err
 			}
-			return cliutil.WithManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
+			return withManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
 				is := newInterceptState(ctx, safeCobraCommandImpl{cmd}, args, connectorClient, managerClient, connInfo)
 				return client.WithEnsuredState(ctx, is, true, func() error { return nil })
 			})
@@ -262,18 +526,50 @@ func intercept(cmd *cobra.Command, args interceptArgs) error {
 		if err := loginIfNeeded(ctx, args); err != nil {
 			return err
 		}
-		return cliutil.WithManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
+		return withManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
 			is := newInterceptState(ctx, safeCobraCommandImpl{cmd}, args, connectorClient, managerClient, connInfo)
 			return client.WithEnsuredState(ctx, is, false, func() error {
-				if args.dockerRun {
-					return is.runInDocker(ctx, is.cmd, args.cmdline)
-				}
-				return proc.Run(ctx, is.env, args.cmdline[0], args.cmdline[1:]...)
+				defer is.holdIntercept(ctx)()
+				return is.runCommand(ctx)
 			})
 		})
 	})
 }
 
+// runCommand runs the command given after -- with the intercepted environment merged over the local
+// one, and with stdin, stdout, and stderr attached. Signals to this process are forwarded to the command's
+// process group, so that the intercept is left when the command exits, regardless of why it exits. A
+// command that fails results in a *proc.ExitError that carries its exit status.
+func (is *interceptState) runCommand(ctx context.Context) error {
+	if is.args.dockerRun {
+		return is.runInDocker(ctx, is.cmd, is.args.cmdline)
+	}
+	return proc.Run(ctx, is.env, is.args.cmdline[0], is.args.cmdline[1:]...)
+}
+
+// handlerBound returns true if the intercept is bound to a handler that this process runs, in which
+// case the user daemon removes the intercept if this process disappears without removing it. The
+// user daemon can't see the processes of the host when it runs in a container, nor the processes of WSL
+// when it runs on Windows.
+func (is *interceptState) handlerBound() bool {
+	return len(is.args.cmdline) > 0 && is.dockerContainer == "" && is.wslPaths == nil
+}
+
+// holdIntercept holds a handler bound intercept in the user daemon until the returned function is
+// called, so that the user daemon notices when this process disappears.
+func (is *interceptState) holdIntercept(ctx context.Context) func() {
+	if !is.handlerBound() {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	_, err := is.connectorClient.HoldIntercept(ctx, &connector.HoldInterceptRequest{Name: is.args.name, Context: sessionContext()})
+	if err != nil {
+		// The intercept is still removed by this process, or when the session ends
+		dlog.Debugf(ctx, "unable to hold intercept %s: %v", is.args.name, err)
+	}
+	return cancel
+}
+
 func newInterceptState(
 	ctx context.Context,
 	cmd safeCobraCommand,
@@ -282,7 +578,7 @@ func newInterceptState(
 	managerClient manager.ManagerClient,
 	connInfo *connector.ConnectInfo,
 ) *interceptState {
-	return &interceptState{
+	is := &interceptState{
 		cmd:  cmd,
 		args: args,
 
@@ -292,6 +588,16 @@ func newInterceptState(
 		managerClient:   managerClient,
 		connInfo:        connInfo,
 	}
+	if dd := cliutil.GetDockerDaemon(ctx); dd != nil {
+		is.dockerContainer = dd.ContainerName
+	}
+	if wd := cliutil.GetWindowsDaemons(ctx); wd != nil {
+		is.wslPaths = cliutil.NewWSLPaths(wd.Distro)
+	}
+	ef := client.GetConfig(ctx).EnvFilters
+	is.envFilters.Include = append(append([]string{}, ef.Include...), args.envInclude...)
+	is.envFilters.Exclude = append(append([]string{}, ef.Exclude...), args.envExclude...)
+	return is
 }
 
 func interceptMessage(r *connector.InterceptResult) error {
@@ -346,10 +652,16 @@ func interceptMessage(r *connector.InterceptResult) error {
 	if id := r.GetInterceptInfo().GetId(); id != "" {
 		msg = fmt.Sprintf("%s: id = %q", msg, id)
 	}
-	return errCat.Newf(msg)
+	err := &interceptResultError{result: r, err: errCat.Newf(msg)}
+	if r.Error == connector.InterceptError_ALREADY_EXISTS {
+		return &ExitCodeError{Code: ExitAlreadyExists, Err: err}
+	}
+	return err
 }
 
-func checkMountCapability(ctx context.Context) error {
+// checkMountCapability returns an error if the local machine lacks the ability to mount the remote
+// volumes. It's a variable so that tests can replace it.
+var checkMountCapability = func(ctx context.Context) error {
 	// Use CombinedOutput to include stderr which has information about whether they
 	// need to upgrade to a newer version of macFUSE or not
 	var cmd *dexec.Cmd
@@ -375,12 +687,202 @@ func checkMountCapability(ctx context.Context) error {
 	return nil
 }
 
+func parsePort(portStr string) (uint16, error) {
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return 0, errcat.User.Newf("port numbers must be a valid, positive int, you gave: %q", portStr)
+	}
+	return uint16(port), nil
+}
+
+// splitProtocol splits an optional "/TCP" or "/UDP" suffix from the given port mapping. The returned
+// protocol is empty when no suffix is present.
+func splitProtocol(portStr string) (string, string, error) {
+	slash := strings.LastIndexByte(portStr, '/')
+	if slash < 0 {
+		return portStr, "", nil
+	}
+	proto := strings.ToUpper(portStr[slash+1:])
+	switch proto {
+	case "TCP", "UDP":
+		return portStr[:slash], proto, nil
+	default:
+		return "", "", errcat.User.Newf("unsupported protocol %q, must be TCP or UDP", portStr[slash+1:])
+	}
+}
+
+// parseExtraPortMappings parses the <local port>:<svcPortIdentifier> mappings given by the --port flags that
+// follow the first one. Each service port is intercepted together with the one of the first --port and its
+// traffic is sent to the local port. The local ports must be unique and differ from the primaryPort.
+func parseExtraPortMappings(primaryPort uint16, ports []string) ([]*manager.InterceptPortMapping, error) {
+	if len(ports) == 0 {
+		return nil, nil
+	}
+	used := map[uint16]struct{}{primaryPort: {}}
+	pms := make([]*manager.InterceptPortMapping, len(ports))
+	for i, portStr := range ports {
+		portMapping := strings.Split(portStr, ":")
+		if len(portMapping) != 2 || portMapping[1] == "" {
+			return nil, errcat.User.Newf("additional ports must be of the format --port <local-port>:<svcPortIdentifier>, you gave: %q", portStr)
+		}
+		port, err := parsePort(portMapping[0])
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := used[port]; ok {
+			return nil, errcat.User.Newf("local port %d is used in more than one --port", port)
+		}
+		used[port] = struct{}{}
+		pms[i] = &manager.InterceptPortMapping{
+			TargetPort:            int32(port),
+			ServicePortIdentifier: portMapping[1],
+		}
+	}
+	return pms, nil
+}
+
+// httpHeaderNameRx matches a valid HTTP header name, as defined by the "token" in RFC 7230.
+var httpHeaderNameRx = regexp.MustCompile("^[!#$%&'*+\\-.^_`|~0-9A-Za-z]+$")
+
+// parseHTTPHeaderMatches parses the NAME=REGEXP values given by the --http-header flags.
+func parseHTTPHeaderMatches(headers []string) ([]*manager.HTTPHeaderMatch, error) {
+	var hms []*manager.HTTPHeaderMatch
+	for _, header := range headers {
+		eq := strings.IndexByte(header, '=')
+		if eq <= 0 {
+			return nil, errcat.User.Newf(`--http-header must be of the format NAME=REGEXP, you gave: %q`, header)
+		}
+		name, valueRx := header[:eq], header[eq+1:]
+		if !httpHeaderNameRx.MatchString(name) {
+			return nil, errcat.User.Newf("--http-header %q: invalid header name %q", header, name)
+		}
+		if _, err := forwarder.CompileHeaderRegex(valueRx); err != nil {
+			return nil, errcat.User.Newf("--http-header %q: %w", header, err)
+		}
+		hms = append(hms, &manager.HTTPHeaderMatch{Name: name, ValueRegex: valueRx})
+	}
+	return hms, nil
+}
+
+// parseHTTPPathPrefix validates the value given by the --http-path-prefix flag.
+func parseHTTPPathPrefix(prefix string) (string, error) {
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		return "", errcat.User.Newf(`--http-path-prefix must start with "/", you gave: %q`, prefix)
+	}
+	return prefix, nil
+}
+
+// httpConditionsHint returns a description of what a caller must do to reach an intercept that has HTTP
+// conditions, or an empty string if the intercept has no such conditions.
+func httpConditionsHint(spec *manager.InterceptSpec) string {
+	if !forwarder.HasHTTPConditions(spec) {
+		return ""
+	}
+	sb := strings.Builder{}
+	sb.WriteString("Only HTTP requests that meet the following conditions are intercepted. Other requests are served by the cluster.")
+	for _, hm := range spec.HttpHeaders {
+		if rx, err := regexp.Compile(hm.ValueRegex); err == nil {
+			if literal, complete := rx.LiteralPrefix(); complete {
+				fmt.Fprintf(&sb, "\n    Set the header: %s: %s", hm.Name, literal)
+				continue
+			}
+		}
+		fmt.Fprintf(&sb, "\n    Set the header %s to a value matching: %s", hm.Name, hm.ValueRegex)
+	}
+	if spec.HttpPathPrefix != "" {
+		fmt.Fprintf(&sb, "\n    Use a path that starts with: %s", spec.HttpPathPrefix)
+	}
+	return sb.String()
+}
+
+// parseToPodPorts parses the <port>[/<protocol>] values given by the --to-pod flags and assigns them to
+// the ExtraPorts and ExtraUdpPorts of the given spec. A port cannot be used more than once for the same
+// protocol, and it cannot be the same as a local port that intercepted traffic is sent to, i.e. the one of
+// the first --port or of one of the ExtraPortMappings of the spec, since a --to-pod port is bound on localhost.
+func parseToPodPorts(spec *manager.InterceptSpec, localPort uint16, protocol string, toPods []string) error {
+	type protoPort struct {
+		proto string
+		port  uint16
+	}
+	if protocol == "" {
+		protocol = "TCP"
+	}
+	used := map[protoPort]string{{protocol, localPort}: "--port"}
+	for _, pm := range spec.ExtraPortMappings {
+		used[protoPort{"TCP", uint16(pm.TargetPort)}] = "--port"
+	}
+	for _, toPod := range toPods {
+		portStr, proto, err := splitProtocol(toPod)
+		if err != nil {
+			return err
+		}
+		port, err := parsePort(portStr)
+		if err != nil {
+			return errcat.User.Newf("Unable to parse port %s: %w", toPod, err)
+		}
+		if proto == "" {
+			proto = "TCP"
+		}
+		pp := protoPort{proto, port}
+		if flag, ok := used[pp]; ok {
+			return errcat.User.Newf("local port %d/%s of --to-pod is also used by %s", port, proto, flag)
+		}
+		used[pp] = "--to-pod"
+		if proto == "UDP" {
+			spec.ExtraUdpPorts = append(spec.ExtraUdpPorts, int32(port))
+		} else {
+			spec.ExtraPorts = append(spec.ExtraPorts, int32(port))
+		}
+	}
+	return nil
+}
+
+// resolveTLS applies the TLS flags to the given spec and request. The local CA file is read here, because
+// the user daemon may not be able to read it.
+func (is *interceptState) resolveTLS(spec *manager.InterceptSpec, ir *connector.CreateInterceptRequest) error {
+	args := &is.args
+	if args.agentTLSTerminate != (args.agentTLSSecret != "") {
+		return errcat.User.New("--agent-tls-terminate and --agent-tls-secret must be used together")
+	}
+	if args.localTLS && !args.agentTLSTerminate {
+		return errcat.User.New("--local-tls can only be used with --agent-tls-terminate")
+	}
+	if args.localCAFile != "" && !args.localTLS {
+		return errcat.User.New("--local-ca-file can only be used with --local-tls")
+	}
+	if !args.agentTLSTerminate {
+		return nil
+	}
+	if spec.Mechanism != "tcp" {
+		return errcat.User.Newf("--agent-tls-terminate cannot be used with the %q mechanism", spec.Mechanism)
+	}
+	if is.protocol == "UDP" {
+		return errcat.User.New("--agent-tls-terminate cannot be used when intercepting UDP")
+	}
+	spec.AgentTlsSecret = args.agentTLSSecret
+	ir.LocalTls = args.localTLS
+	if args.localCAFile != "" {
+		ca, err := os.ReadFile(args.localCAFile)
+		if err != nil {
+			return errcat.User.Newf("unable to read --local-ca-file: %w", err)
+		}
+		ir.LocalCa = ca
+	}
+	return nil
+}
+
 func (is *interceptState) createRequest(ctx context.Context) (*connector.CreateInterceptRequest, error) {
 	spec := &manager.InterceptSpec{
 		Name:      is.args.name,
 		Namespace: is.args.namespace,
 	}
-	ir := &connector.CreateInterceptRequest{Spec: spec}
+	ir := &connector.CreateInterceptRequest{Spec: spec, Context: sessionContext()}
+	if err := is.translateWindowsPaths(); err != nil {
+		return nil, err
+	}
+	if is.handlerBound() {
+		ir.OwnerPid = int32(os.Getpid())
+	}
 
 	if is.args.agentName == "" {
 		// local-only
@@ -390,25 +892,32 @@ func (is *interceptState) createRequest(ctx context.Context) (*connector.CreateI
 	if is.args.serviceName != "" {
 		spec.ServiceName = is.args.serviceName
 	}
+	spec.ContainerName = is.args.container
 
 	spec.Agent = is.args.agentName
 	spec.TargetHost = "127.0.0.1"
+	if is.dockerContainer != "" && !is.args.dockerRun {
+		// The user daemon runs in a container and reaches the local process on the host
+		spec.TargetHost = dockerHostName
+	}
+
+	if len(is.args.ports) == 0 {
+		return nil, errcat.User.New("at least one --port must be given")
+	}
 
 	// Parse port into spec based on how it's formatted
-	portMapping := strings.Split(is.args.port, ":")
+	portStr, proto, err := splitProtocol(is.args.ports[0])
+	if err != nil {
+		return nil, err
+	}
+	spec.Protocol = proto
+	is.protocol = proto
+	portMapping := strings.Split(portStr, ":")
 	portError := func() error {
 		if is.args.dockerRun {
-			return errcat.User.New("ports must be of the format --ports <local-port>:<container-port>[:<svcPortIdentifier>]")
+			return errcat.User.New("ports must be of the format --ports <local-port>:<container-port>[:<svcPortIdentifier>][/<protocol>]")
 		}
-		return errcat.User.New("ports must be of the format --ports <local-port>[:<svcPortIdentifier>]")
-	}
-
-	parsePort := func(portStr string) (uint16, error) {
-		port, err := strconv.ParseUint(portStr, 10, 16)
-		if err != nil {
-			return 0, errcat.User.Newf("port numbers must be a valid, positive int, you gave: %q", is.args.port)
-		}
-		return uint16(port), nil
+		return errcat.User.New("ports must be of the format --ports <local-port>[:<svcPortIdentifier>][/<protocol>]")
 	}
 
 	port, err := parsePort(portMapping[0])
@@ -439,31 +948,42 @@ func (is *interceptState) createRequest(ctx context.Context) (*connector.CreateI
 		return nil, portError()
 	}
 
+	if spec.ExtraPortMappings, err = parseExtraPortMappings(is.localPort, is.args.ports[1:]); err != nil {
+		return nil, err
+	}
+	is.extraPortMappings = spec.ExtraPortMappings
+
 	if is.args.dockerRun && is.dockerPort == 0 {
 		is.dockerPort = is.localPort
 	}
+	if is.args.dockerRun && is.dockerContainer != "" {
+		// The container shares the network of the daemons container, so the intercepted traffic goes
+		// straight to the container port.
+		spec.TargetPort = int32(is.dockerPort)
+	}
 
-	doMount := false
-	err = checkMountCapability(ctx)
-	if err == nil {
-		if ir.MountPoint, doMount, err = is.getMountPoint(); err != nil {
-			return nil, err
+	var doMount bool
+	if ir.MountPoint, doMount, err = is.resolveMount(ctx); err != nil {
+		return nil, err
+	}
+
+	if err = parseToPodPorts(spec, is.localPort, is.protocol, is.args.toPod); err != nil {
+		return nil, err
+	}
+
+	if len(is.args.mountVolumes) > 0 || is.args.mountReadOnly {
+		if !doMount && is.args.mountSet {
+			return nil, errcat.User.New("--mount-readonly and --mount-volume cannot be used with --mount=false")
 		}
-	} else if is.args.mountSet {
-		var boolErr error
-		doMount, boolErr = strconv.ParseBool(is.args.mount)
-		if boolErr != nil || doMount {
-			// not --mount=false, so refuse.
-			return nil, errcat.User.Newf("remote volume mounts are disabled: %w", err)
+		if len(is.args.mountVolumes) > 0 && runtime.GOOS == "windows" && is.mountType != mountTypeSFTP {
+			return nil, errcat.User.New("--mount-volume is only supported with --mount-type=sftp on windows")
 		}
+		ir.MountReadOnly = is.args.mountReadOnly
+		ir.MountVolumes = is.args.mountVolumes
 	}
 
-	for _, toPod := range is.args.toPod {
-		port, err := parsePort(toPod)
-		if err != nil {
-			return nil, errcat.User.Newf("Unable to parse port %s: %w", toPod, err)
-		}
-		spec.ExtraPorts = append(spec.ExtraPorts, int32(port))
+	if doMount {
+		ir.MountType = is.mountType
 	}
 
 	if is.args.dockerMount != "" {
@@ -479,6 +999,30 @@ func (is *interceptState) createRequest(ctx context.Context) (*connector.CreateI
 	if err != nil {
 		return nil, err
 	}
+	if spec.HttpHeaders, err = parseHTTPHeaderMatches(is.args.httpHeaders); err != nil {
+		return nil, err
+	}
+	if spec.HttpPathPrefix, err = parseHTTPPathPrefix(is.args.httpPathPrefix); err != nil {
+		return nil, err
+	}
+	if forwarder.HasHTTPConditions(spec) {
+		if spec.Mechanism != "tcp" {
+			return nil, errcat.User.Newf("--http-header and --http-path-prefix cannot be used with the %q mechanism", spec.Mechanism)
+		}
+		if is.protocol == "UDP" {
+			return nil, errcat.User.New("--http-header and --http-path-prefix cannot be used when intercepting UDP")
+		}
+		if is.args.replace {
+			return nil, errcat.User.New("--http-header and --http-path-prefix cannot be used with --replace")
+		}
+	}
+	if err = is.resolveTLS(spec, ir); err != nil {
+		return nil, err
+	}
+	spec.Replace = is.args.replace
+	spec.Ttl = int64(is.args.ttl)
+	ir.Steal = is.args.steal
+	ir.NoWait = is.args.noWait
 	spec.MechanismArgs, err = is.args.extState.MechanismArgs()
 	if err != nil {
 		return nil, err
@@ -491,6 +1035,67 @@ func (is *interceptState) createRequest(ctx context.Context) (*connector.CreateI
 	return ir, nil
 }
 
+// resolveMount returns the mount point to use for the remote volumes and whether they should be mounted
+// at all. The "auto" mount type resolves to "sftp" when the local machine is unable to mount using sshfs.
+// When sshfs was requested explicitly and the local machine is unable to use it, the mount is skipped
+// with a warning, unless the user explicitly asked for it using the --mount flag, in which case an error
+// is returned.
+func (is *interceptState) resolveMount(ctx context.Context) (string, bool, error) {
+	switch is.args.mountType {
+	case mountTypeAuto, mountTypeSSHFS, mountTypeSFTP:
+	default:
+		return "", false, errcat.User.Newf(`--mount-type must be "auto", "sshfs", or "sftp", you gave: %q`, is.args.mountType)
+	}
+	mountPath := ""
+	doMount, err := strconv.ParseBool(is.args.mount)
+	if err != nil {
+		mountPath = is.args.mount
+		// On windows, the sshfs mount point is a drive letter which is validated by prepareMount
+		if (runtime.GOOS != "windows" || is.args.mountType == mountTypeSFTP) && !filepath.IsAbs(is.args.mount) {
+			return "", false, errcat.User.Newf(`--mount must be "true", "false", or an absolute path, you gave: %q`, is.args.mount)
+		}
+		doMount = true
+	}
+	if !doMount {
+		return "", false, nil
+	}
+
+	is.mountType = is.args.mountType
+	if is.dockerContainer != "" {
+		is.mountProblem = errors.New("the user daemon runs in a container and cannot mount the remote volumes on the host")
+	} else if is.wslPaths != nil {
+		// An sshfs mount on Windows uses a drive letter that WSL doesn't see, but the remote volumes can be
+		// mirrored into a directory of WSL through the share of the distribution
+		if is.mountType == mountTypeSSHFS {
+			is.mountProblem = errors.New("the user daemon runs on Windows, where sshfs mounts on a drive letter that WSL " +
+				"doesn't see; use --mount-type sftp")
+		} else {
+			is.mountType = mountTypeSFTP
+		}
+	} else if is.mountType != mountTypeSFTP {
+		if is.mountProblem = checkMountCapability(ctx); is.mountProblem != nil && is.mountType == mountTypeAuto {
+			// A drive letter given with --mount can't be used for a mirror
+			if runtime.GOOS != "windows" || mountPath == "" || filepath.IsAbs(mountPath) {
+				fmt.Fprintf(is.cmd.ErrOrStderr(), "Warning: %v. The remote volumes are mirrored using SFTP, so remote "+
+					"and local changes are synced periodically and there are no file system notifications\n", is.mountProblem)
+				is.mountProblem = nil
+				is.mountType = mountTypeSFTP
+			}
+		}
+	}
+	if is.mountProblem != nil {
+		if is.args.mountSet {
+			return "", false, errcat.User.Newf("remote volume mounts are disabled: %w", is.mountProblem)
+		}
+		fmt.Fprintf(is.cmd.ErrOrStderr(), "Warning: remote volume mounts are disabled: %v\n", is.mountProblem)
+		return "", false, nil
+	}
+	if is.mountType == mountTypeAuto {
+		is.mountType = mountTypeSSHFS
+	}
+	return is.getMountPoint()
+}
+
 func (is *interceptState) getMountPoint() (string, bool, error) {
 	mountPoint := ""
 	doMount, err := strconv.ParseBool(is.args.mount)
@@ -499,16 +1104,44 @@ func (is *interceptState) getMountPoint() (string, bool, error) {
 		doMount = len(mountPoint) > 0
 		err = nil
 	}
-	if doMount {
-		mountPoint, err = prepareMount(mountPoint)
+	if doMount && !is.args.dryRun {
+		if is.mountType == mountTypeSFTP {
+			mountPoint, err = prepareMirror(mountPoint)
+		} else {
+			mountPoint, err = prepareMount(mountPoint)
+		}
 	}
 	return mountPoint, doMount, err
 }
 
+// prepareMirror creates the directory where the remote volumes are mirrored when the "sftp" mount type
+// is used. Unlike an sshfs mount point on windows, it's always a directory. An existing directory must be
+// empty, because the mirror writes local files back to the remote volumes and removes local files that
+// are removed remotely.
+func prepareMirror(mountPoint string) (string, error) {
+	if mountPoint == "" {
+		return os.MkdirTemp("", "telfs-")
+	}
+	if err := os.MkdirAll(mountPoint, 0700); err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(mountPoint)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) > 0 {
+		return "", errcat.User.Newf("the remote volumes can't be mirrored into %s because it isn't empty", mountPoint)
+	}
+	return mountPoint, nil
+}
+
 func (is *interceptState) EnsureState(ctx context.Context) (acquired bool, err error) {
+	ctx, span := tracing.StartSpan(ctx, "intercept create")
+	defer func() { tracing.EndSpan(span, err) }()
+
 	// Add whatever metadata we already have to scout
 	is.Scout.SetMetadatum("service_name", is.args.agentName)
-	is.Scout.SetMetadatum("cluster_id", is.connInfo.ClusterId)
+	is.Scout.SetMetadatum("cluster_id", is.connInfo.TelemetryClusterId)
 	mechanism, _ := is.args.extState.Mechanism()
 	mechanismArgs, _ := is.args.extState.MechanismArgs()
 	is.Scout.SetMetadatum("intercept_mechanism", mechanism)
@@ -524,7 +1157,7 @@ func (is *interceptState) EnsureState(ctx context.Context) (acquired bool, err e
 
 	// Fill defaults
 	if is.args.previewEnabled && is.args.previewSpec.Ingress == nil {
-		ingress, err := selectIngress(ctx, is.cmd.InOrStdin(), is.cmd.OutOrStdout(), is.connInfo, is.args.name, is.args.namespace)
+		ingress, err := selectIngress(ctx, is.cmd.InOrStdin(), is.cmd.OutOrStdout(), is.connInfo, is.args.name, is.args.namespace, &is.args.ingress)
 		if err != nil {
 			return false, err
 		}
@@ -537,13 +1170,19 @@ func (is *interceptState) EnsureState(ctx context.Context) (acquired bool, err e
 	}
 
 	if ir.MountPoint != "" {
+		mountPoint := ir.MountPoint
 		defer func() {
-			if !acquired && runtime.GOOS != "windows" {
+			if !acquired && (runtime.GOOS != "windows" || is.mountType == mountTypeSFTP) {
 				// remove if empty
-				_ = os.Remove(ir.MountPoint)
+				_ = os.Remove(mountPoint)
 			}
 		}()
-		is.mountPoint = ir.MountPoint
+		is.mountPoint = mountPoint
+		if is.wslPaths != nil {
+			if ir.MountPoint, err = is.wslPaths.ToWindows(mountPoint); err != nil {
+				return false, errcat.User.New(err)
+			}
+		}
 	}
 
 	// Submit the request
@@ -556,6 +1195,9 @@ func (is *interceptState) EnsureState(ctx context.Context) (acquired bool, err e
 	case connector.InterceptError_UNSPECIFIED:
 		if is.args.agentName == "" {
 			// local-only
+			if is.args.json != nil {
+				return true, is.args.json.write(newLocalOnlyOutput(&is.args))
+			}
 			return true, nil
 		}
 		fmt.Fprintf(is.cmd.OutOrStdout(), "Using %s %s\n", r.WorkloadKind, is.args.agentName)
@@ -589,25 +1231,31 @@ func (is *interceptState) EnsureState(ctx context.Context) (acquired bool, err e
 		}
 		is.Scout.SetMetadatum("intercept_id", intercept.Id)
 
-		is.env = r.Environment
-		is.env["TELEPRESENCE_INTERCEPT_ID"] = intercept.Id
-		if is.args.envFile != "" {
-			if err = is.writeEnvFile(); err != nil {
-				return true, err
-			}
+		if is.wslPaths != nil {
+			is.toWSLPaths(intercept, r.Environment)
 		}
-		if is.args.envJSON != "" {
-			if err = is.writeEnvJSON(); err != nil {
-				return true, err
+		if interceptPending(intercept) {
+			// Created with --no-wait. There's no environment until a traffic-agent picks it up
+			fmt.Fprintln(is.cmd.OutOrStdout(), DescribeIntercept(intercept, is.mountProblem, false))
+			fmt.Fprintf(is.cmd.OutOrStdout(), "The intercept becomes active when a ready traffic-agent picks it up. "+
+				"Use \"telepresence list\" to see its state.\n")
+			if is.args.json != nil {
+				return true, is.args.json.write(newInterceptOutput(intercept, &is.args, is.mountProblem))
 			}
+			return true, nil
+		}
+		is.setEnvironment(r.Environment, intercept.Id)
+		if err = is.writeEnvFiles(); err != nil {
+			return true, err
 		}
 
-		var volumeMountProblem error
-		doMount, err := strconv.ParseBool(is.args.mount)
-		if doMount || err != nil {
-			volumeMountProblem = checkMountCapability(ctx)
+		fmt.Fprintln(is.cmd.OutOrStdout(), DescribeIntercept(intercept, is.mountProblem, false))
+		if hint := httpConditionsHint(intercept.Spec); hint != "" {
+			fmt.Fprintln(is.cmd.OutOrStdout(), hint)
+		}
+		if is.args.json != nil {
+			return true, is.args.json.write(newInterceptOutput(intercept, &is.args, is.mountProblem))
 		}
-		fmt.Fprintln(is.cmd.OutOrStdout(), DescribeIntercept(intercept, volumeMountProblem, false))
 		return true, nil
 	default:
 		if r.GetInterceptInfo().GetDisposition() == manager.InterceptDispositionType_BAD_ARGS {
@@ -619,67 +1267,154 @@ func (is *interceptState) EnsureState(ctx context.Context) (acquired bool, err e
 }
 
 func (is *interceptState) DeactivateState(ctx context.Context) error {
-	return removeIntercept(ctx, strings.TrimSpace(is.args.name))
+	// The intercept must be left also when the command that ran in it was interrupted
+	return removeIntercept(dcontext.WithoutCancel(ctx), strings.TrimSpace(is.args.name))
 }
 
 func removeIntercept(ctx context.Context, name string) error {
-	return cliutil.WithStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
-		var r *connector.InterceptResult
-		var err error
-		r, err = connectorClient.RemoveIntercept(dcontext.WithoutCancel(ctx), &manager.RemoveInterceptRequest2{Name: name})
-		if err != nil {
-			return err
-		}
-		if r.Error != connector.InterceptError_UNSPECIFIED {
-			return interceptMessage(r)
-		}
-		return nil
+	r, err := leaveIntercept(ctx, name)
+	if err != nil {
+		return err
+	}
+	return interceptMessage(r)
+}
+
+// leaveIntercept asks the connector to remove the named intercept and returns its result, which
+// tells if the intercept didn't exist.
+func leaveIntercept(ctx context.Context, name string) (r *connector.InterceptResult, err error) {
+	ctx, span := tracing.StartSpan(ctx, "leave")
+	defer func() { tracing.EndSpan(span, err) }()
+	err = withStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+		r, err = connectorClient.RemoveIntercept(dcontext.WithoutCancel(ctx), &connector.RemoveInterceptRequest{Name: name, Context: sessionContext()})
+		return err
 	})
+	return r, err
 }
 
-func validateDockerArgs(args []string) error {
-	for _, arg := range args {
-		if arg == "-d" || arg == "--detach" {
-			return errcat.User.New("running docker container in background using -d or --detach is not supported")
+// leaveCheckInterval is how often a container started with --docker-run checks if its intercept
+// has been left.
+var leaveCheckInterval = 5 * time.Second
+
+// dockerBoolFlags are the flags of docker run that don't take a value.
+var dockerBoolFlags = map[string]bool{
+	"-d": true, "--detach": true,
+	"-i": true, "--interactive": true,
+	"-t": true, "--tty": true,
+	"-P": true, "--publish-all": true,
+	"-q": true, "--quiet": true,
+	"--rm":                    true,
+	"--init":                  true,
+	"--privileged":            true,
+	"--read-only":             true,
+	"--no-healthcheck":        true,
+	"--oom-kill-disable":      true,
+	"--disable-content-trust": true,
+	"--sig-proxy":             true,
+}
+
+// dockerRunOptions returns the leading options of the given docker run arguments, i.e. everything
+// up to the image. Arguments after the image belong to the command of the container.
+func dockerRunOptions(args []string) []string {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case !strings.HasPrefix(arg, "-") || arg == "-":
+			return args[:i]
+		case strings.Contains(arg, "="):
+		case strings.HasPrefix(arg, "--"):
+			if !dockerBoolFlags[arg] {
+				i++ // skip the value
+			}
+		case !dockerBoolFlags[arg[:2]]:
+			if len(arg) == 2 {
+				i++ // skip the value
+			}
 		}
 	}
-	return nil
+	return args
 }
 
-func (is *interceptState) runInDocker(ctx context.Context, cmd safeCobraCommand, args []string) error {
-	envFile := is.args.envFile
-	if envFile == "" {
-		file, err := os.CreateTemp("", "tel-*.env")
-		if err != nil {
-			return errcat.NoLogs.Newf("failed to create temporary environment file. %w", err)
+// hasDockerFlag returns true if the given docker run options contain a flag with any of the given
+// names, in any of the forms "--name value", "--name=value", or, for boolean short flags, combined
+// as in "-dit".
+func hasDockerFlag(opts []string, names ...string) bool {
+	for _, opt := range opts {
+		if i := strings.IndexByte(opt, '='); i > 0 {
+			opt = opt[:i]
+		}
+		for _, name := range names {
+			if opt == name {
+				return true
+			}
+			if len(name) == 2 && isCombinedDockerBoolFlags(opt) && strings.Contains(opt[1:], name[1:]) {
+				return true
+			}
 		}
-		defer os.Remove(file.Name())
+	}
+	return false
+}
 
-		if err = is.writeEnvToFileAndClose(file); err != nil {
-			return err
+// isCombinedDockerBoolFlags returns true if the given option is a combination of boolean short flags,
+// such as "-it".
+func isCombinedDockerBoolFlags(opt string) bool {
+	if len(opt) < 3 || opt[0] != '-' || opt[1] == '-' {
+		return false
+	}
+	for _, c := range opt[1:] {
+		if !dockerBoolFlags["-"+string(c)] {
+			return false
 		}
-		envFile = file.Name()
 	}
+	return true
+}
+
+func validateDockerArgs(args []string) error {
+	opts := dockerRunOptions(args)
+	if hasDockerFlag(opts, "-d", "--detach") {
+		return errcat.User.New("running docker container in background using -d or --detach is not supported")
+	}
+	if hasDockerFlag(opts, "--name") {
+		return errcat.User.New("the --name of the docker container cannot be given with --docker-run. " +
+			"Telepresence names the container intercept-<intercept name>-<local port> so that it can stop it when the intercept ends")
+	}
+	return nil
+}
 
+// dockerContainerName returns the name of the container that is started with --docker-run.
+func (is *interceptState) dockerContainerName() string {
+	return fmt.Sprintf("intercept-%s-%d", is.args.name, is.localPort)
+}
+
+// dockerRunArgs returns the arguments to docker that run the container of the intercept handler with
+// the given environment file, followed by the docker run arguments given by the user.
+func (is *interceptState) dockerRunArgs(envFile string, args []string) ([]string, error) {
 	ourArgs := []string{
 		"run",
-		"--dns-search", "tel2-search",
 		"--env-file", envFile,
+		"--name", is.dockerContainerName(),
 	}
-	hasArg := func(s string) bool {
-		for _, arg := range args {
-			if s == arg {
-				return true
+	if is.dockerContainer != "" {
+		if hasDockerFlag(dockerRunOptions(args), "--network", "--net") {
+			return nil, errcat.User.Newf("the --network of the docker container cannot be given with --docker-run when "+
+				"the daemons run in Docker. The container joins the network of the %s container, which has the "+
+				"routes and DNS of the cluster, and where the intercepted traffic arrives", is.dockerContainer)
+		}
+		// Join the network namespace of the daemons container, which has the routes and DNS of the
+		// cluster, and where the user daemon forwards the intercepted traffic to localhost.
+		ourArgs = append(ourArgs, "--network", "container:"+is.dockerContainer)
+	} else {
+		ourArgs = append(ourArgs, "--dns-search", "tel2-search")
+		if is.dockerPort != 0 {
+			portArg := fmt.Sprintf("%d:%d", is.localPort, is.dockerPort)
+			if is.protocol == "UDP" {
+				portArg += "/udp"
 			}
+			ourArgs = append(ourArgs, "-p", portArg)
+		}
+		// The traffic of the other intercepted ports is sent to the same port in the container
+		for _, pm := range is.extraPortMappings {
+			ourArgs = append(ourArgs, "-p", fmt.Sprintf("%d:%d", pm.TargetPort, pm.TargetPort))
 		}
-		return false
-	}
-	if !hasArg("--name") {
-		ourArgs = append(ourArgs, "--name", fmt.Sprintf("intercept-%s-%d", is.args.name, is.localPort))
-	}
-
-	if is.dockerPort != 0 {
-		ourArgs = append(ourArgs, "-p", fmt.Sprintf("%d:%d", is.localPort, is.dockerPort))
 	}
 
 	dockerMount := ""
@@ -689,19 +1424,188 @@ func (is *interceptState) runInDocker(ctx context.Context, cmd safeCobraCommand,
 		}
 	}
 	if dockerMount != "" {
-		ourArgs = append(ourArgs, "-v", fmt.Sprintf("%s:%s", is.mountPoint, dockerMount))
+		volume := fmt.Sprintf("%s:%s", is.mountPoint, dockerMount)
+		if is.args.mountReadOnly {
+			volume += ":ro"
+		}
+		ourArgs = append(ourArgs, "-v", volume)
+	}
+	return append(ourArgs, args...), nil
+}
+
+// runInDocker runs the intercept handler in a docker container, and stops that container when it's
+// done, when the CLI is interrupted, or when the intercept is left.
+func (is *interceptState) runInDocker(ctx context.Context, cmd safeCobraCommand, args []string) error {
+	// The file given with --env-file is in dotenv format, which docker doesn't understand, so docker
+	// always gets its own file.
+	file, err := os.CreateTemp("", "tel-*.env")
+	if err != nil {
+		return errcat.NoLogs.Newf("failed to create temporary environment file. %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	if err = is.writeEnvToFileAndClose(file); err != nil {
+		return err
+	}
+	dockerArgs, err := is.dockerRunArgs(file.Name(), args)
+	if err != nil {
+		return err
+	}
+
+	name := is.dockerContainerName()
+	defer func() {
+		// The container keeps running when the docker CLI is killed, e.g. because this command was interrupted
+		if err := cliutil.StopDockerContainer(dcontext.WithoutCancel(ctx), name); err != nil {
+			dlog.Errorf(ctx, "unable to stop docker container %s: %v", name, err)
+		}
+	}()
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	left := make(chan struct{})
+	go func() {
+		if is.waitForLeave(runCtx) {
+			fmt.Fprintf(cmd.OutOrStdout(), "Intercept %s was left, stopping docker container %s\n", is.args.name, name)
+			close(left)
+			if err := cliutil.StopDockerContainer(runCtx, name); err != nil {
+				dlog.Errorf(ctx, "unable to stop docker container %s: %v", name, err)
+			}
+		}
+	}()
+	err = proc.Run(runCtx, nil, "docker", dockerArgs...)
+	select {
+	case <-left:
+		return nil
+	default:
+		return err
+	}
+}
+
+// waitForLeave waits until the intercept is no longer known to the user daemon and returns true, or
+// returns false when the context is cancelled. An intercept that is missing while the session is being
+// recovered hasn't been left.
+func (is *interceptState) waitForLeave(ctx context.Context) bool {
+	ticker := time.NewTicker(leaveCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+		ci, err := is.connectorClient.Status(ctx, &connector.ConnectRequest{KubeFlags: connectorKubeFlagMap(ctx)})
+		if err != nil || ci.ReconnectingSince != nil {
+			continue
+		}
+		if ci.Error == connector.ConnectInfo_DISCONNECTED {
+			return true
+		}
+		intercepts := ci.GetIntercepts().GetIntercepts()
+		if ci.Error == connector.ConnectInfo_SESSION_AMBIGUOUS {
+			// A session with another context was added after the intercept was created
+			for _, sci := range ci.Sessions {
+				intercepts = append(intercepts, sci.GetIntercepts().GetIntercepts()...)
+			}
+		}
+		found := false
+		for _, ii := range intercepts {
+			if ii.Spec.Name == is.args.name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return true
+		}
+	}
+}
+
+// translateWindowsPaths translates the paths of files given in Windows form into paths in WSL when the
+// CLI in WSL uses the daemons on Windows. Such paths are accepted because that's how the daemons see the
+// files.
+func (is *interceptState) translateWindowsPaths() error {
+	if is.wslPaths == nil {
+		return nil
+	}
+	for _, p := range []*string{&is.args.envFile, &is.args.envFileUnfiltered, &is.args.envJSON, &is.args.mount} {
+		if cliutil.IsWindowsPath(*p) {
+			lp, err := is.wslPaths.ToLinux(*p)
+			if err != nil {
+				return errcat.User.New(err)
+			}
+			*p = lp
+		}
+	}
+	return nil
+}
+
+// toWSLPaths translates the mount point that the user daemon on Windows reports, in the given intercept
+// and environment, into the mount point in WSL.
+func (is *interceptState) toWSLPaths(intercept *manager.InterceptInfo, env map[string]string) {
+	if intercept.Spec.MountPoint != "" {
+		intercept.Spec.MountPoint = is.mountPoint
+	}
+	if _, ok := env["TELEPRESENCE_ROOT"]; ok {
+		env["TELEPRESENCE_ROOT"] = is.mountPoint
+	}
+}
+
+// setEnvironment sets the environment of the intercepted container, as reported by the traffic-agent, and
+// the environment that is used by the local process. Both are filtered by the envFilters, but the variables
+// added by telepresence are not. An agent that is older than the client might not report any environment
+// at all. That's not an error, but it's worth a warning.
+func (is *interceptState) setEnvironment(remoteEnv map[string]string, interceptID string) {
+	if remoteEnv == nil {
+		fmt.Fprintln(is.cmd.ErrOrStderr(), "Warning: the traffic-agent did not report the environment of the intercepted container")
+		remoteEnv = map[string]string{}
+	}
+	is.unfilteredEnv = make(map[string]string, len(remoteEnv)+1)
+	for k, v := range remoteEnv {
+		is.unfilteredEnv[k] = v
+	}
+	is.unfilteredEnv["TELEPRESENCE_INTERCEPT_ID"] = interceptID
+
+	is.remoteEnv = filterEnv(remoteEnv, is.envFilters)
+	is.env = make(map[string]string, len(is.remoteEnv)+1)
+	for k, v := range is.remoteEnv {
+		is.env[k] = v
+	}
+	is.env["TELEPRESENCE_INTERCEPT_ID"] = interceptID
+}
+
+// writeEnvFiles writes the intercepted environment to the files given by the --env-file, --env-json, and
+// --env-file-unfiltered flags. The files are written regardless of whether the remote volumes are mounted or not.
+func (is *interceptState) writeEnvFiles() error {
+	if is.args.envFile != "" {
+		if err := is.writeEnvFile(is.args.envFile, is.env); err != nil {
+			return err
+		}
+	}
+	if is.args.envFileUnfiltered != "" {
+		if err := is.writeEnvFile(is.args.envFileUnfiltered, is.unfilteredEnv); err != nil {
+			return err
+		}
+	}
+	if is.args.envJSON != "" {
+		if err := is.writeEnvJSON(); err != nil {
+			return err
+		}
 	}
-	return proc.Run(ctx, nil, "docker", append(ourArgs, args...)...)
+	return nil
 }
 
-func (is *interceptState) writeEnvFile() error {
-	file, err := os.Create(is.args.envFile)
+func (is *interceptState) writeEnvFile(path string, env map[string]string) error {
+	err := writeFileAtomic(path, func(w io.Writer) error {
+		return writeDotenv(w, env)
+	})
 	if err != nil {
-		return errcat.NoLogs.Newf("failed to create environment file %q: %w", is.args.envFile, err)
+		return errcat.NoLogs.Newf("failed to write environment file %q: %w", path, err)
 	}
-	return is.writeEnvToFileAndClose(file)
+	return nil
 }
 
+// writeEnvToFileAndClose writes the environment in the format used by docker run --env-file, where
+// values are taken verbatim.
 func (is *interceptState) writeEnvToFileAndClose(file *os.File) (err error) {
 	defer file.Close()
 	w := bufio.NewWriter(file)
@@ -731,13 +1635,19 @@ func (is *interceptState) writeEnvToFileAndClose(file *os.File) (err error) {
 	return w.Flush()
 }
 
+// writeEnvJSON writes the filtered environment, with values exactly as reported by the traffic-agent, as a
+// JSON object.
 func (is *interceptState) writeEnvJSON() error {
-	data, err := json.MarshalIndent(is.env, "", "  ")
+	err := writeFileAtomic(is.args.envJSON, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return enc.Encode(is.remoteEnv)
+	})
 	if err != nil {
-		// Creating JSON from a map[string]string should never fail
-		panic(err)
+		return errcat.NoLogs.Newf("failed to write environment file %q: %w", is.args.envJSON, err)
 	}
-	return os.WriteFile(is.args.envJSON, data, 0644)
+	return nil
 }
 
 var hostRx = regexp.MustCompile(`^[a-zA-Z0-9](?:[a-zA-Z0-9\-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9\-]*[a-zA-Z0-9])?)*$`)
@@ -830,6 +1740,17 @@ func askForUseTLS(cachedUseTLS bool, reader *bufio.Reader, out io.Writer) (bool,
 	}
 }
 
+// stdinIsTerminal returns true if the given reader is a terminal that the user can answer questions in.
+var stdinIsTerminal = func(in io.Reader) bool {
+	f, ok := in.(*os.File)
+	return ok && term.IsTerminal(f.Fd())
+}
+
+// selectIngress returns the ingress of a preview URL. The ingress that was last used with the cluster is used
+// without asking, as is an ingress that is completely given by the ingress flags, and the flags that are
+// given take precedence over what was last used. Otherwise, the user is asked to confirm or select the
+// ingress, unless stdin isn't a terminal or prompts aren't allowed, in which case the error names the flags
+// that must be given. The ingress is cached per cluster.
 func selectIngress(
 	ctx context.Context,
 	in io.Reader,
@@ -837,32 +1758,51 @@ func selectIngress(
 	connInfo *connector.ConnectInfo,
 	interceptName string,
 	interceptNamespace string,
+	args *ingressArgs,
 ) (*manager.IngressInfo, error) {
 	infos, err := cache.LoadIngressesFromUserCache(ctx)
 	if err != nil {
 		return nil, err
 	}
 	key := connInfo.ClusterServer + "/" + connInfo.ClusterContext
-	selectOrConfirm := "Confirm"
 	cachedIngressInfo := infos[key]
-	if cachedIngressInfo == nil {
-		iis := connInfo.IngressInfos
-		if len(iis) > 0 {
-			cachedIngressInfo = iis[0] // TODO: Better handling when there are several alternatives. Perhaps use SystemA for this?
-		} else {
-			selectOrConfirm = "Select" // Hard to confirm unless there's a default.
-			if interceptNamespace == "" {
-				interceptNamespace = "default"
-			}
-			cachedIngressInfo = &manager.IngressInfo{
-				// Default Settings
-				Host:   fmt.Sprintf("%s.%s", interceptName, interceptNamespace),
-				Port:   80,
-				UseTls: false,
+	if cachedIngressInfo != nil || len(args.missingFlags()) == 0 {
+		reply := args.apply(cachedIngressInfo)
+		if cachedIngressInfo == nil || !ingressInfoEqual(cachedIngressInfo, reply) {
+			infos[key] = reply
+			if err = cache.SaveIngressesToUserCache(ctx, infos); err != nil {
+				return nil, err
 			}
 		}
+		return reply, nil
 	}
 
+	selectOrConfirm := "Confirm"
+	iis := connInfo.IngressInfos
+	if len(iis) > 0 {
+		cachedIngressInfo = iis[0] // TODO: Better handling when there are several alternatives. Perhaps use SystemA for this?
+	} else {
+		selectOrConfirm = "Select" // Hard to confirm unless there's a default.
+		if interceptNamespace == "" {
+			interceptNamespace = "default"
+		}
+		cachedIngressInfo = &manager.IngressInfo{
+			// Default Settings
+			Host:   fmt.Sprintf("%s.%s", interceptName, interceptNamespace),
+			Port:   80,
+			UseTls: false,
+		}
+	}
+	reason := proc.NoPromptReason(ctx)
+	if reason == "" && !stdinIsTerminal(in) {
+		reason = "stdin isn't a terminal"
+	}
+	if reason != "" {
+		return nil, errcat.User.Newf("unable to ask for the ingress of the preview URL because %s. "+
+			"Use %s to declare it, or --preview-url=false to not create a preview URL", reason, strings.Join(args.missingFlags(), " and "))
+	}
+	cachedIngressInfo = args.apply(cachedIngressInfo)
+
 	reader := bufio.NewReader(in)
 
 	fmt.Fprintf(out, "\n"+ingressDesc+"\n", selectOrConfirm)
@@ -876,7 +1816,7 @@ func selectIngress(
 	if reply.UseTls, err = askForUseTLS(cachedIngressInfo.UseTls, reader, out); err != nil {
 		return nil, err
 	}
-	if cachedIngressInfo.L5Host == "" {
+	if cachedIngressInfo.L5Host == "" || cachedIngressInfo.L5Host == cachedIngressInfo.Host {
 		cachedIngressInfo.L5Host = reply.Host
 	}
 	if reply.L5Host, err = askForHost(ingressQ4, cachedIngressInfo.L5Host, reader, out); err != nil {
@@ -884,11 +1824,9 @@ func selectIngress(
 	}
 	fmt.Fprintln(out)
 
-	if !ingressInfoEqual(cachedIngressInfo, reply) {
-		infos[key] = reply
-		if err = cache.SaveIngressesToUserCache(ctx, infos); err != nil {
-			return nil, err
-		}
+	infos[key] = reply
+	if err = cache.SaveIngressesToUserCache(ctx, infos); err != nil {
+		return nil, err
 	}
 	return reply, nil
 }
@@ -0,0 +1,776 @@
+//go:build !windows
+// +build !windows
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/extensions"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+)
+
+func withMountCapability(t *testing.T, err error) {
+	old := checkMountCapability
+	checkMountCapability = func(context.Context) error { return err }
+	t.Cleanup(func() { checkMountCapability = old })
+}
+
+func newMountTestState(mount string, mountSet bool) (*interceptState, *bytes.Buffer) {
+	stderr := &bytes.Buffer{}
+	cmd := &cobra.Command{}
+	cmd.SetErr(stderr)
+	return &interceptState{
+		cmd:  safeCobraCommandImpl{Command: cmd},
+		args: interceptArgs{mount: mount, mountSet: mountSet, mountType: mountTypeAuto},
+	}, stderr
+}
+
+func Test_resolveMount(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	withMountCapability(t, nil)
+
+	t.Run("true", func(t *testing.T) {
+		is, _ := newMountTestState("true", false)
+		mp, doMount, err := is.resolveMount(ctx)
+		require.NoError(t, err)
+		assert.True(t, doMount)
+		assert.DirExists(t, mp)
+		_ = os.Remove(mp)
+	})
+
+	t.Run("false", func(t *testing.T) {
+		is, _ := newMountTestState("false", true)
+		mp, doMount, err := is.resolveMount(ctx)
+		require.NoError(t, err)
+		assert.False(t, doMount)
+		assert.Empty(t, mp)
+	})
+
+	t.Run("absolute path", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "remote")
+		is, _ := newMountTestState(dir, true)
+		mp, doMount, err := is.resolveMount(ctx)
+		require.NoError(t, err)
+		assert.True(t, doMount)
+		assert.Equal(t, dir, mp)
+		assert.DirExists(t, dir)
+	})
+
+	t.Run("relative path", func(t *testing.T) {
+		is, _ := newMountTestState("remote", true)
+		_, _, err := is.resolveMount(ctx)
+		assert.Error(t, err)
+	})
+
+	t.Run("auto uses sshfs when available", func(t *testing.T) {
+		is, _ := newMountTestState(t.TempDir(), true)
+		_, _, err := is.resolveMount(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, mountTypeSSHFS, is.mountType)
+	})
+
+	t.Run("invalid mount type", func(t *testing.T) {
+		is, _ := newMountTestState("true", false)
+		is.args.mountType = "nfs"
+		_, _, err := is.resolveMount(ctx)
+		assert.Error(t, err)
+	})
+}
+
+func Test_resolveMount_unableToMount(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	withMountCapability(t, errors.New("sshfs is not installed on your local machine"))
+
+	// The default falls back to an SFTP mirror
+	dir := t.TempDir()
+	is, stderr := newMountTestState(dir, true)
+	mp, doMount, err := is.resolveMount(ctx)
+	require.NoError(t, err)
+	assert.True(t, doMount)
+	assert.Equal(t, dir, mp)
+	assert.Equal(t, mountTypeSFTP, is.mountType)
+	assert.NoError(t, is.mountProblem)
+	assert.Contains(t, stderr.String(), "Warning: sshfs is not installed on your local machine. The remote volumes are mirrored using SFTP")
+
+	// An explicit request for sftp doesn't need the capability
+	is, stderr = newMountTestState(dir, true)
+	is.args.mountType = mountTypeSFTP
+	_, doMount, err = is.resolveMount(ctx)
+	require.NoError(t, err)
+	assert.True(t, doMount)
+	assert.Equal(t, mountTypeSFTP, is.mountType)
+	assert.Empty(t, stderr.String())
+
+	// An explicit request for sshfs degrades to a warning
+	is, stderr = newMountTestState("true", false)
+	is.args.mountType = mountTypeSSHFS
+	mp, doMount, err = is.resolveMount(ctx)
+	require.NoError(t, err)
+	assert.False(t, doMount)
+	assert.Empty(t, mp)
+	assert.Contains(t, stderr.String(), "Warning: remote volume mounts are disabled: sshfs is not installed")
+	assert.Error(t, is.mountProblem)
+
+	// An explicit request to mount using sshfs is an error
+	is, _ = newMountTestState(t.TempDir(), true)
+	is.args.mountType = mountTypeSSHFS
+	_, _, err = is.resolveMount(ctx)
+	assert.Error(t, err)
+
+	// An explicit request to not mount doesn't need the capability
+	is, stderr = newMountTestState("false", true)
+	_, doMount, err = is.resolveMount(ctx)
+	require.NoError(t, err)
+	assert.False(t, doMount)
+	assert.Empty(t, stderr.String())
+}
+
+func Test_prepareMirror(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mirror")
+	mp, err := prepareMirror(dir)
+	require.NoError(t, err)
+	assert.Equal(t, dir, mp)
+	assert.DirExists(t, dir)
+
+	// An empty directory is fine, one with content is refused
+	_, err = prepareMirror(dir)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("mine"), 0600))
+	_, err = prepareMirror(dir)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "isn't empty")
+	assert.FileExists(t, filepath.Join(dir, "notes.txt"))
+
+	mp, err = prepareMirror("")
+	require.NoError(t, err)
+	defer os.Remove(mp)
+	assert.DirExists(t, mp)
+}
+
+func Test_parseToPodPorts(t *testing.T) {
+	pms := []*manager.InterceptPortMapping{{TargetPort: 9090, ServicePortIdentifier: "grpc"}}
+	spec := &manager.InterceptSpec{ExtraPortMappings: pms}
+	require.NoError(t, parseToPodPorts(spec, 8080, "", []string{"8081", "8125/udp", "8082/TCP", "8080/UDP"}))
+	assert.Equal(t, []int32{8081, 8082}, spec.ExtraPorts)
+	assert.Equal(t, []int32{8125, 8080}, spec.ExtraUdpPorts)
+
+	for _, toPod := range [][]string{
+		{"8080"},         // intercepted local port
+		{"9090/TCP"},     // local port of another intercepted port
+		{"8081", "8081"}, // repeated
+		{"8081/SCTP"},    // unsupported protocol
+		{"http"},         // not a number
+	} {
+		assert.Error(t, parseToPodPorts(&manager.InterceptSpec{ExtraPortMappings: pms}, 8080, "", toPod), "%v", toPod)
+	}
+
+	// A UDP intercept doesn't conflict with a TCP --to-pod on the same port
+	spec = &manager.InterceptSpec{}
+	require.NoError(t, parseToPodPorts(spec, 8080, "UDP", []string{"8080"}))
+	assert.Equal(t, []int32{8080}, spec.ExtraPorts)
+}
+
+func Test_parseExtraPortMappings(t *testing.T) {
+	pms, err := parseExtraPortMappings(8080, []string{"9090:grpc", "9091:9091"})
+	require.NoError(t, err)
+	require.Len(t, pms, 2)
+	assert.Equal(t, int32(9090), pms[0].TargetPort)
+	assert.Equal(t, "grpc", pms[0].ServicePortIdentifier)
+	assert.Equal(t, "9091", pms[1].ServicePortIdentifier)
+
+	for _, ports := range [][]string{
+		{"9090"},                     // no service port
+		{"8080:grpc"},                // intercepted local port
+		{"9090:grpc", "9090:health"}, // repeated local port
+	} {
+		_, err = parseExtraPortMappings(8080, ports)
+		assert.Error(t, err, "%v", ports)
+	}
+}
+
+func Test_createRequest_twoPorts(t *testing.T) {
+	// Every --port is intercepted. The ones that follow the first are carried in the spec
+	ctx := newTestContext(t)
+	client.GetConfig(ctx).Images.AgentImage = "tel2:test"
+	flags := pflag.NewFlagSet("intercept", pflag.ContinueOnError)
+	extState, err := extensions.LoadExtensions(ctx, flags)
+	require.NoError(t, err)
+	args := interceptArgs{name: "echo", agentName: "echo", mount: "false", mountType: mountTypeAuto, extState: extState}
+
+	args.ports = []string{"8080:http", "9090:grpc"}
+	is := &interceptState{args: args}
+	ir, err := is.createRequest(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int32(8080), ir.Spec.TargetPort)
+	assert.Equal(t, "http", ir.Spec.ServicePortIdentifier)
+	require.Len(t, ir.Spec.ExtraPortMappings, 1)
+	assert.Equal(t, int32(9090), ir.Spec.ExtraPortMappings[0].TargetPort)
+	assert.Equal(t, "grpc", ir.Spec.ExtraPortMappings[0].ServicePortIdentifier)
+
+	args.ports = []string{"8080:http", "8080:grpc"}
+	is = &interceptState{args: args}
+	_, err = is.createRequest(ctx)
+	assert.Error(t, err)
+}
+
+func Test_parseHTTPHeaderMatches(t *testing.T) {
+	hms, err := parseHTTPHeaderMatches([]string{"x-dev=alice", "x-tenant=acme|initech", "x-query=a=b"})
+	require.NoError(t, err)
+	require.Len(t, hms, 3)
+	assert.Equal(t, "x-dev", hms[0].Name)
+	assert.Equal(t, "alice", hms[0].ValueRegex)
+	assert.Equal(t, "acme|initech", hms[1].ValueRegex)
+	assert.Equal(t, "a=b", hms[2].ValueRegex)
+
+	for _, bad := range []string{"x-dev", "=alice", "x dev=alice", "x-dev=("} {
+		_, err = parseHTTPHeaderMatches([]string{bad})
+		assert.Error(t, err, bad)
+	}
+
+	_, err = parseHTTPPathPrefix("api")
+	assert.Error(t, err)
+	prefix, err := parseHTTPPathPrefix("/api")
+	require.NoError(t, err)
+	assert.Equal(t, "/api", prefix)
+}
+
+func Test_httpConditionsHint(t *testing.T) {
+	assert.Empty(t, httpConditionsHint(&manager.InterceptSpec{}))
+
+	hint := httpConditionsHint(&manager.InterceptSpec{
+		HttpHeaders: []*manager.HTTPHeaderMatch{
+			{Name: "x-dev", ValueRegex: "alice"},
+			{Name: "x-tenant", ValueRegex: "acme|initech"},
+		},
+		HttpPathPrefix: "/api",
+	})
+	assert.Contains(t, hint, "Set the header: x-dev: alice\n")
+	assert.Contains(t, hint, "Set the header x-tenant to a value matching: acme|initech\n")
+	assert.Contains(t, hint, "Use a path that starts with: /api")
+}
+
+func Test_resolveTLS(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, []byte("the CA"), 0o600))
+
+	resolve := func(args interceptArgs) (*manager.InterceptSpec, *connector.CreateInterceptRequest, error) {
+		spec := &manager.InterceptSpec{Mechanism: "tcp"}
+		ir := &connector.CreateInterceptRequest{Spec: spec}
+		is := &interceptState{args: args, protocol: "TCP"}
+		return spec, ir, is.resolveTLS(spec, ir)
+	}
+
+	spec, ir, err := resolve(interceptArgs{})
+	require.NoError(t, err)
+	assert.Empty(t, spec.AgentTlsSecret)
+	assert.False(t, ir.LocalTls)
+
+	spec, ir, err = resolve(interceptArgs{agentTLSTerminate: true, agentTLSSecret: "echo-tls", localTLS: true, localCAFile: caFile})
+	require.NoError(t, err)
+	assert.Equal(t, "echo-tls", spec.AgentTlsSecret)
+	assert.True(t, ir.LocalTls)
+	assert.Equal(t, "the CA", string(ir.LocalCa))
+
+	for _, args := range []interceptArgs{
+		{agentTLSTerminate: true},
+		{agentTLSSecret: "echo-tls"},
+		{localTLS: true},
+		{agentTLSTerminate: true, agentTLSSecret: "echo-tls", localCAFile: caFile},
+		{agentTLSTerminate: true, agentTLSSecret: "echo-tls", localTLS: true, localCAFile: caFile + ".missing"},
+	} {
+		_, _, err = resolve(args)
+		require.Error(t, err, "%+v", args)
+		assert.Equal(t, errcat.User, errcat.GetCategory(err))
+	}
+}
+
+func Test_selectIngress(t *testing.T) {
+	// The traffic-manager reports an ingress that the user is asked to confirm
+	connInfo := &connector.ConnectInfo{
+		ClusterServer:  "https://cluster.example.com",
+		ClusterContext: "dev",
+		IngressInfos:   []*manager.IngressInfo{{Host: "ambassador.ambassador", Port: 443, UseTls: true, L5Host: "ambassador.ambassador"}},
+	}
+	testCtx := func(t *testing.T) context.Context {
+		return filelocation.WithUserHomeDir(dlog.NewTestContext(t, false), t.TempDir())
+	}
+	selectWith := func(ctx context.Context, t *testing.T, in string, args ingressArgs) (*manager.IngressInfo, string, error) {
+		t.Helper()
+		out := &bytes.Buffer{}
+		ii, err := selectIngress(ctx, bytes.NewBufferString(in), out, connInfo, "echo", "default", &args)
+		return ii, out.String(), err
+	}
+	withTerminal := func(t *testing.T) {
+		old := stdinIsTerminal
+		stdinIsTerminal = func(io.Reader) bool { return true }
+		t.Cleanup(func() { stdinIsTerminal = old })
+	}
+
+	t.Run("flag-complete", func(t *testing.T) {
+		ctx := testCtx(t)
+		ii, out, err := selectWith(ctx, t, "", ingressArgs{host: "ingress.example.com", port: 8443, useTLS: true, tlsSet: true})
+		require.NoError(t, err)
+		assert.Empty(t, out, "nothing is asked")
+		assert.Equal(t, &manager.IngressInfo{Host: "ingress.example.com", Port: 8443, UseTls: true, L5Host: "ingress.example.com"}, ii)
+
+		// The ingress is remembered for the next intercept in the same cluster
+		cached, err := cache.LoadIngressesFromUserCache(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, ii, cached["https://cluster.example.com/dev"])
+	})
+
+	t.Run("cached-default", func(t *testing.T) {
+		ctx := testCtx(t)
+		_, _, err := selectWith(ctx, t, "", ingressArgs{host: "ingress.example.com", port: 8443, l5Host: "echo.example.com"})
+		require.NoError(t, err)
+
+		ii, out, err := selectWith(ctx, t, "", ingressArgs{})
+		require.NoError(t, err)
+		assert.Empty(t, out, "the last used ingress is used without asking")
+		assert.Equal(t, &manager.IngressInfo{Host: "ingress.example.com", Port: 8443, L5Host: "echo.example.com"}, ii)
+
+		// Flags take precedence over what was last used
+		ii, _, err = selectWith(ctx, t, "", ingressArgs{port: 443, useTLS: true, tlsSet: true})
+		require.NoError(t, err)
+		assert.Equal(t, &manager.IngressInfo{Host: "ingress.example.com", Port: 443, UseTls: true, L5Host: "echo.example.com"}, ii)
+	})
+
+	t.Run("non-TTY-missing-value", func(t *testing.T) {
+		ctx := testCtx(t)
+		_, _, err := selectWith(ctx, t, "", ingressArgs{})
+		require.Error(t, err)
+		assert.Equal(t, errcat.User, errcat.GetCategory(err))
+		assert.Contains(t, err.Error(), "Use --ingress-host and --ingress-port to declare it")
+
+		_, _, err = selectWith(ctx, t, "", ingressArgs{host: "ingress.example.com"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Use --ingress-port to declare it")
+	})
+
+	t.Run("interactive", func(t *testing.T) {
+		ctx := testCtx(t)
+		withTerminal(t)
+
+		// The defaults of the questions are the ingress of the traffic-manager, with the flags applied
+		ii, out, err := selectWith(ctx, t, "\n\nn\n\n", ingressArgs{port: 8443})
+		require.NoError(t, err)
+		assert.Contains(t, out, "Confirm the ingress to use")
+		assert.Equal(t, &manager.IngressInfo{Host: "ambassador.ambassador", Port: 8443, L5Host: "ambassador.ambassador"}, ii)
+
+		ii, out, err = selectWith(ctx, t, "", ingressArgs{})
+		require.NoError(t, err)
+		assert.Empty(t, out)
+		assert.Equal(t, "ambassador.ambassador", ii.Host)
+	})
+
+	t.Run("no-prompts", func(t *testing.T) {
+		ctx := proc.WithoutPrompts(testCtx(t), "--output json is used")
+		withTerminal(t)
+
+		_, out, err := selectWith(ctx, t, "\n\n\n\n", ingressArgs{port: 8443})
+		require.Error(t, err)
+		assert.Empty(t, out, "nothing is asked")
+		assert.Equal(t, "unable to ask for the ingress of the preview URL because --output json is used. "+
+			"Use --ingress-host to declare it, or --preview-url=false to not create a preview URL", err.Error())
+
+		// An ingress that is completely given by the flags doesn't need a prompt
+		_, _, err = selectWith(ctx, t, "", ingressArgs{host: "ingress.example.com", port: 8443, useTLS: true, tlsSet: true})
+		require.NoError(t, err)
+	})
+}
+
+func Test_interceptMessage_exitCode(t *testing.T) {
+	err := interceptMessage(&connector.InterceptResult{Error: connector.InterceptError_ALREADY_EXISTS, ErrorText: "echo"})
+	var codeErr *ExitCodeError
+	require.True(t, errors.As(err, &codeErr))
+	assert.Equal(t, ExitAlreadyExists, codeErr.ExitCode())
+	assert.Equal(t, `Intercept with name "echo" already exists`, err.Error())
+
+	err = interceptMessage(&connector.InterceptResult{Error: connector.InterceptError_FAILED_TO_ESTABLISH, ErrorText: "timed out"})
+	require.Error(t, err)
+	assert.False(t, errors.As(err, &codeErr), "a failure has the default exit code")
+}
+
+func Test_validateDockerArgs(t *testing.T) {
+	for _, args := range [][]string{
+		{"--rm", "-it", "myimage:dev"},
+		{"-e", "DEBUG=1", "-v", "data:/data", "myimage:dev"},
+		{"--network", "my-net", "myimage:dev"},
+		{"myimage:dev", "--name", "x", "-d"}, // arguments to the command in the container
+	} {
+		assert.NoError(t, validateDockerArgs(args), "%v", args)
+	}
+	for _, args := range [][]string{
+		{"-d", "myimage:dev"},
+		{"--detach", "myimage:dev"},
+		{"-dit", "myimage:dev"},
+		{"--name", "handler", "myimage:dev"},
+		{"--rm", "--name=handler", "myimage:dev"},
+	} {
+		assert.Error(t, validateDockerArgs(args), "%v", args)
+	}
+}
+
+func Test_resolveWait(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       interceptArgs
+		waitSet    bool
+		wantNoWait bool
+		wantErr    string
+	}{
+		{name: "default", args: interceptArgs{wait: true}},
+		{name: "wait", args: interceptArgs{wait: true}, waitSet: true},
+		{name: "no-wait", args: interceptArgs{wait: true, noWait: true}, wantNoWait: true},
+		{name: "wait=false", args: interceptArgs{}, waitSet: true, wantNoWait: true},
+		{name: "wait=false no-wait", args: interceptArgs{noWait: true}, waitSet: true, wantNoWait: true},
+		{name: "wait no-wait", args: interceptArgs{wait: true, noWait: true}, waitSet: true, wantErr: "mutually exclusive"},
+		{name: "command", args: interceptArgs{wait: true, noWait: true, cmdline: []string{"sh"}}, wantErr: "with a command or --docker-run"},
+		{name: "docker-run", args: interceptArgs{wait: true, noWait: true, dockerRun: true}, wantErr: "with a command or --docker-run"},
+		{name: "env-file", args: interceptArgs{wait: true, noWait: true, envFile: "echo.env"}, wantErr: "with --env-file"},
+		{name: "env-json", args: interceptArgs{wait: true, noWait: true, envJSON: "echo.json"}, wantErr: "with --env-file"},
+		{name: "waited command", args: interceptArgs{wait: true, cmdline: []string{"sh"}, envFile: "echo.env"}},
+		{name: "local-only", args: interceptArgs{wait: true, noWait: true, localOnly: true, cmdline: []string{"sh"}}, wantNoWait: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			err := resolveWait(&tt.args, tt.waitSet)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Equal(t, errcat.User, errcat.GetCategory(err))
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantNoWait, tt.args.noWait)
+		})
+	}
+}
+
+func Test_dockerRunArgs(t *testing.T) {
+	newState := func() *interceptState {
+		return &interceptState{args: interceptArgs{name: "echo"}, localPort: 8080}
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		is := newState()
+		args, err := is.dockerRunArgs("/tmp/tel.env", []string{"--rm", "myimage:dev"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"run",
+			"--env-file", "/tmp/tel.env",
+			"--name", "intercept-echo-8080",
+			"--dns-search", "tel2-search",
+			"--rm", "myimage:dev",
+		}, args)
+	})
+
+	t.Run("port and mount", func(t *testing.T) {
+		is := newState()
+		is.dockerPort = 80
+		is.protocol = "UDP"
+		is.mountPoint = "/tmp/tel-mount"
+		args, err := is.dockerRunArgs("/tmp/tel.env", []string{"myimage:dev", "serve"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"run",
+			"--env-file", "/tmp/tel.env",
+			"--name", "intercept-echo-8080",
+			"--dns-search", "tel2-search",
+			"-p", "8080:80/udp",
+			"-v", "/tmp/tel-mount:/tmp/tel-mount",
+			"myimage:dev", "serve",
+		}, args)
+	})
+
+	t.Run("docker mount", func(t *testing.T) {
+		is := newState()
+		is.mountPoint = "/tmp/tel-mount"
+		is.args.dockerMount = "/var/run/secrets"
+		args, err := is.dockerRunArgs("/tmp/tel.env", []string{"--network", "my-net", "myimage:dev"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"run",
+			"--env-file", "/tmp/tel.env",
+			"--name", "intercept-echo-8080",
+			"--dns-search", "tel2-search",
+			"-v", "/tmp/tel-mount:/var/run/secrets",
+			"--network", "my-net", "myimage:dev",
+		}, args)
+	})
+
+	t.Run("read-only mount", func(t *testing.T) {
+		is := newState()
+		is.mountPoint = "/tmp/tel-mount"
+		is.args.mountReadOnly = true
+		args, err := is.dockerRunArgs("/tmp/tel.env", []string{"myimage:dev"})
+		require.NoError(t, err)
+		assert.Contains(t, args, "/tmp/tel-mount:/tmp/tel-mount:ro")
+	})
+
+	t.Run("daemons in docker", func(t *testing.T) {
+		is := newState()
+		is.dockerPort = 80
+		is.dockerContainer = "telepresence-daemons"
+		args, err := is.dockerRunArgs("/tmp/tel.env", []string{"-it", "myimage:dev"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"run",
+			"--env-file", "/tmp/tel.env",
+			"--name", "intercept-echo-8080",
+			"--network", "container:telepresence-daemons",
+			"-it", "myimage:dev",
+		}, args)
+
+		for _, userArgs := range [][]string{
+			{"--network", "my-net", "myimage:dev"},
+			{"--net=host", "myimage:dev"},
+		} {
+			_, err = is.dockerRunArgs("/tmp/tel.env", userArgs)
+			assert.Error(t, err, "%v", userArgs)
+		}
+	})
+}
+
+type statusConnector struct {
+	connector.ConnectorClient
+	statuses chan *connector.ConnectInfo
+}
+
+func (c *statusConnector) Status(context.Context, *connector.ConnectRequest, ...grpc.CallOption) (*connector.ConnectInfo, error) {
+	return <-c.statuses, nil
+}
+
+func Test_waitForLeave(t *testing.T) {
+	oldInterval, oldFlags := leaveCheckInterval, kubeFlags
+	leaveCheckInterval, kubeFlags = time.Millisecond, pflag.NewFlagSet("", 0)
+	t.Cleanup(func() { leaveCheckInterval, kubeFlags = oldInterval, oldFlags })
+
+	intercepted := &connector.ConnectInfo{Intercepts: &manager.InterceptInfoSnapshot{
+		Intercepts: []*manager.InterceptInfo{{Spec: &manager.InterceptSpec{Name: "echo"}}},
+	}}
+	statuses := make(chan *connector.ConnectInfo, 3)
+	statuses <- intercepted
+	// The intercept is gone while the session is being recovered
+	statuses <- &connector.ConnectInfo{ReconnectingSince: timestamppb.Now()}
+	statuses <- intercepted
+	is := &interceptState{args: interceptArgs{name: "echo"}, connectorClient: &statusConnector{statuses: statuses}}
+
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	done := make(chan bool)
+	go func() { done <- is.waitForLeave(ctx) }()
+
+	// Consumed, and still waiting
+	assert.Eventually(t, func() bool { return len(statuses) == 0 }, 5*time.Second, time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("intercept reported as left")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	statuses <- &connector.ConnectInfo{}
+	select {
+	case left := <-done:
+		assert.True(t, left)
+	case <-time.After(5 * time.Second):
+		t.Fatal("leave was not detected")
+	}
+	cancel()
+}
+
+type leaveRecorder struct {
+	left bool
+}
+
+func (r *leaveRecorder) EnsureState(context.Context) (bool, error) {
+	return true, nil
+}
+
+func (r *leaveRecorder) DeactivateState(context.Context) error {
+	r.left = true
+	return nil
+}
+
+func Test_runCommand(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	t.Setenv("GREETING", "local")
+	t.Setenv("LOCAL_ONLY", "yes")
+	out := filepath.Join(t.TempDir(), "out")
+
+	t.Run("environment", func(t *testing.T) {
+		is := &interceptState{
+			args: interceptArgs{cmdline: []string{"sh", "-c", `echo "$GREETING $LOCAL_ONLY $TELEPRESENCE_ROOT" > "$0"`, out}},
+			env:  map[string]string{"GREETING": "remote", "TELEPRESENCE_ROOT": "/tmp/tel-root"},
+		}
+		lr := &leaveRecorder{}
+		require.NoError(t, client.WithEnsuredState(ctx, lr, false, func() error { return is.runCommand(ctx) }))
+		assert.True(t, lr.left)
+		data, err := os.ReadFile(out)
+		require.NoError(t, err)
+		assert.Equal(t, "remote yes /tmp/tel-root\n", string(data))
+	})
+
+	t.Run("interrupted", func(t *testing.T) {
+		// Keep the test process alive, regardless of when the command starts forwarding signals.
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT)
+		defer signal.Stop(sigCh)
+
+		started := filepath.Join(t.TempDir(), "started")
+		is := &interceptState{args: interceptArgs{cmdline: []string{"sh", "-c", `touch "$0"; exec sleep 30`, started}}}
+		go func() {
+			assert.Eventually(t, func() bool {
+				_, err := os.Stat(started)
+				return err == nil
+			}, 10*time.Second, 10*time.Millisecond)
+			_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+		}()
+		lr := &leaveRecorder{}
+		begin := time.Now()
+		err := client.WithEnsuredState(ctx, lr, false, func() error { return is.runCommand(ctx) })
+		assert.True(t, lr.left)
+		assert.Less(t, time.Since(begin), 20*time.Second, "the command was not interrupted")
+		var exitErr *proc.ExitError
+		require.True(t, errors.As(err, &exitErr))
+		assert.Equal(t, 130, exitErr.ExitCode())
+	})
+
+	t.Run("exit code", func(t *testing.T) {
+		is := &interceptState{args: interceptArgs{cmdline: []string{"sh", "-c", "exit 3"}}}
+		lr := &leaveRecorder{}
+		err := client.WithEnsuredState(ctx, lr, false, func() error { return is.runCommand(ctx) })
+		assert.True(t, lr.left)
+		var exitErr *proc.ExitError
+		require.True(t, errors.As(err, &exitErr))
+		assert.Equal(t, 3, exitErr.ExitCode())
+		assert.Nil(t, exitErr.Signal)
+	})
+
+	t.Run("terminated", func(t *testing.T) {
+		// The handler starts a process of its own, which must receive the signal too.
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGTERM)
+		defer signal.Stop(sigCh)
+
+		dir := t.TempDir()
+		started, terminated := filepath.Join(dir, "started"), filepath.Join(dir, "terminated")
+		script := `sh -c 'trap "touch \"$1\"; exit 0" TERM; touch "$0"; while :; do sleep 0.1; done' "$0" "$1" & wait`
+		is := &interceptState{args: interceptArgs{cmdline: []string{"sh", "-c", script, started, terminated}}}
+		go func() {
+			assert.Eventually(t, func() bool {
+				_, err := os.Stat(started)
+				return err == nil
+			}, 10*time.Second, 10*time.Millisecond)
+			_ = syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		}()
+		lr := &leaveRecorder{}
+		err := client.WithEnsuredState(ctx, lr, false, func() error { return is.runCommand(ctx) })
+		assert.True(t, lr.left)
+		var exitErr *proc.ExitError
+		require.True(t, errors.As(err, &exitErr))
+		assert.Equal(t, 128+int(syscall.SIGTERM), exitErr.ExitCode())
+		assert.Equal(t, syscall.SIGTERM, exitErr.Signal)
+		assert.Eventually(t, func() bool {
+			_, err := os.Stat(terminated)
+			return err == nil
+		}, 10*time.Second, 10*time.Millisecond, "the signal was not forwarded to the process group")
+	})
+}
+
+// holdConnector is a user daemon that records the holds of intercepts.
+type holdConnector struct {
+	connector.ConnectorClient
+	held     chan string
+	released chan string
+}
+
+func (c *holdConnector) HoldIntercept(ctx context.Context, hr *connector.HoldInterceptRequest, _ ...grpc.CallOption) (connector.Connector_HoldInterceptClient, error) {
+	c.held <- hr.Name
+	go func() {
+		<-ctx.Done()
+		c.released <- hr.Name
+	}()
+	return nil, nil
+}
+
+func Test_handlerBoundIntercept(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cc := &holdConnector{held: make(chan string, 1), released: make(chan string, 1)}
+	is := &interceptState{args: interceptArgs{name: "echo", cmdline: []string{"sh"}}, connectorClient: cc}
+	ir, err := is.createRequest(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int32(os.Getpid()), ir.OwnerPid)
+
+	release := is.holdIntercept(ctx)
+	assert.Equal(t, "echo", <-cc.held)
+	release()
+	assert.Equal(t, "echo", <-cc.released)
+
+	// Without a handler, or when the user daemon can't see this process, there's no owner
+	for _, is := range []*interceptState{
+		{args: interceptArgs{name: "echo"}, connectorClient: cc},
+		{args: interceptArgs{name: "echo", cmdline: []string{"sh"}}, connectorClient: cc, dockerContainer: "tp-daemons"},
+		{args: interceptArgs{name: "echo", cmdline: []string{"sh"}}, connectorClient: cc, wslPaths: &cliutil.WSLPaths{Distro: "Ubuntu", MountRoot: "/mnt/"}},
+	} {
+		ir, err := is.createRequest(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, ir.OwnerPid)
+		is.holdIntercept(ctx)()
+		assert.Empty(t, cc.held)
+	}
+}
+
+func Test_translateWindowsPaths(t *testing.T) {
+	is := &interceptState{
+		args: interceptArgs{
+			envFile:  `C:\Users\alice\echo.env`,
+			envJSON:  "/home/alice/echo.json",
+			mount:    `\\wsl$\Ubuntu\home\alice\mnt`,
+			mountSet: true,
+		},
+		wslPaths: &cliutil.WSLPaths{Distro: "Ubuntu", MountRoot: "/mnt/"},
+	}
+	require.NoError(t, is.translateWindowsPaths())
+	assert.Equal(t, "/mnt/c/Users/alice/echo.env", is.args.envFile)
+	assert.Equal(t, "/home/alice/echo.json", is.args.envJSON)
+	assert.Equal(t, "/home/alice/mnt", is.args.mount)
+
+	is.args.envFileUnfiltered = `\\fileserver\share\echo.env`
+	err := is.translateWindowsPaths()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can't be reached from WSL")
+
+	// The mount point that the user daemon on Windows reports is the one in WSL
+	is.mountPoint = "/home/alice/mnt"
+	intercept := &manager.InterceptInfo{Spec: &manager.InterceptSpec{MountPoint: `\\wsl$\Ubuntu\home\alice\mnt`}}
+	env := map[string]string{"TELEPRESENCE_ROOT": `\\wsl$\Ubuntu\home\alice\mnt`}
+	is.toWSLPaths(intercept, env)
+	assert.Equal(t, "/home/alice/mnt", intercept.Spec.MountPoint)
+	assert.Equal(t, "/home/alice/mnt", env["TELEPRESENCE_ROOT"])
+}
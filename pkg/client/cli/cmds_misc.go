@@ -2,51 +2,106 @@ package cli
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"strings"
 
 	"github.com/pkg/browser"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/validation"
 
-	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/datawire/dlib/dcontext"
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
-	"github.com/telepresenceio/telepresence/v2/pkg/client/actions"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_k8s"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/install/helm"
 	"github.com/telepresenceio/telepresence/v2/pkg/proc"
 )
 
-// getClusterID is a simple command that makes it easier for users to
-// figure out what their cluster ID is. For now this is just used when
-// people are making licenses for air-gapped environments
-func ClusterIdCommand() *cobra.Command {
-	return &cobra.Command{
-		Use:  "current-cluster-id",
-		Args: cobra.NoArgs,
-
-		Short: "Get cluster ID for your kubernetes cluster",
-		Long:  "Get cluster ID for your kubernetes cluster, mostly used for licenses in air-gapped environments",
-		RunE: func(cmd *cobra.Command, _ []string) error {
-			client, err := kates.NewClientFromConfigFlags(kubeConfig)
-			if err != nil {
-				return err
-			}
-			clusterID, err := actions.GetClusterID(cmd.Context(), client)
-			if err != nil {
-				return err
-			}
-			fmt.Fprintf(cmd.OutOrStdout(), "Cluster ID: %s\n", clusterID)
-			return nil
-		},
-	}
-}
-
 func connectCommand() *cobra.Command {
-	return &cobra.Command{
+	var dryRun, docker, windowsDaemons, switchSession bool
+	var valueFiles, setValues, kubeFlagPairs []string
+	cmd := &cobra.Command{
 		Use:  "connect [flags] [-- <command to run while connected>]",
 		Args: cobra.ArbitraryArgs,
 
 		Short: "Connect to a cluster",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := applyKubeFlags(kubeFlagPairs); err != nil {
+				return err
+			}
+			if managerNamespace != "" {
+				if errs := validation.IsDNS1123Label(managerNamespace); len(errs) > 0 {
+					return errcat.User.Newf("--manager-namespace %q is not a valid namespace name: %s", managerNamespace, strings.Join(errs, ", "))
+				}
+			}
+			var err error
+			if proxyMode, socks5Address, err = parseProxyMode(proxyMode); err != nil {
+				return err
+			}
+			userSpaceProxy := proxyMode == client.ProxyModePorts || proxyMode == client.ProxyModeSOCKS5
+			if hostsFile != "" && proxyMode != client.ProxyModePorts {
+				return errcat.User.Newf("--hosts-file requires --proxy-mode %s", client.ProxyModePorts)
+			}
+			if docker && userSpaceProxy {
+				return errcat.User.Newf("--proxy-mode %s can't be combined with --docker", proxyMode)
+			}
+			if windowsDaemons && docker {
+				return errcat.User.New("--windows-daemons can't be combined with --docker")
+			}
+			if windowsDaemons && userSpaceProxy {
+				// The loopback ports on Windows can't be reached from WSL
+				return errcat.User.Newf("--proxy-mode %s can't be combined with --windows-daemons", proxyMode)
+			}
+			if forceTunnel && userSpaceProxy {
+				return errcat.User.Newf("--force-tunnel can't be combined with --proxy-mode %s", proxyMode)
+			}
+			if len(valueFiles) > 0 || len(setValues) > 0 {
+				if managerValues, err = parseManagerValues(valueFiles, setValues); err != nil {
+					return err
+				}
+			}
+			if dryRun {
+				return connectDryRun(cmd.Context(), cmd.OutOrStdout())
+			}
+			if switchSession {
+				if err := endMismatchedSession(cmd.Context(), cmd.OutOrStdout()); err != nil {
+					return err
+				}
+			}
+			if docker {
+				started, err := startDockerDaemons(cmd)
+				if err != nil {
+					return err
+				}
+				if started && len(args) > 0 {
+					// Like the daemons that withConnector launches, the container only lives as long as the command
+					defer func() {
+						_ = cliutil.Quit(dcontext.WithoutCancel(cmd.Context()), cmd.OutOrStdout(), false)
+					}()
+				}
+			}
+			if windowsDaemons {
+				_, started, err := cliutil.StartWindowsDaemons(cmd.Context(), cmd.OutOrStdout())
+				if err != nil {
+					return err
+				}
+				if started && len(args) > 0 {
+					defer func() {
+						_ = cliutil.Quit(dcontext.WithoutCancel(cmd.Context()), cmd.OutOrStdout(), false)
+					}()
+				}
+			}
+			if !docker && !windowsDaemons {
+				if err := preflightExecCredentials(cmd); err != nil {
+					return err
+				}
+			}
 			if len(args) == 0 {
 				return withConnector(cmd, true, func(_ context.Context, _ connector.ConnectorClient, _ *connector.ConnectInfo) error {
 					return nil
@@ -57,6 +112,162 @@ func connectCommand() *cobra.Command {
 			})
 		},
 	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false,
+		"Don't connect. Print the local routes, the subnets to route, and the conflicts between them")
+	cmd.Flags().BoolVar(&docker, "docker", false,
+		"Run the daemons in a Docker container instead of on the host. Requires no root privileges, and confines "+
+			"the network changes to the container, which intercept handlers started with --docker-run will share")
+	cmd.Flags().BoolVar(&windowsDaemons, "windows-daemons", false,
+		"Use the daemons on Windows when the CLI runs in WSL2, so that the network and VPN setup of Windows applies. "+
+			"The daemons are started using the telepresence.exe of Windows unless they're already running")
+	cmd.Flags().BoolVar(&switchSession, "switch", false,
+		"End the current session first if it uses another kubernetes context or configuration. When there are "+
+			"sessions with several contexts, only a session of the requested context that uses another configuration is ended")
+	cmd.Flags().StringArrayVar(&kubeFlagPairs, "kubeflag", nil,
+		"Kubernetes flag as NAME=VALUE, e.g. --kubeflag as=alice. Can be repeated, and is passed to the daemon "+
+			"like the kubernetes flags that have their own option")
+	cmd.Flags().StringVar(&managerNamespace, "manager-namespace", "",
+		"The namespace where the traffic-manager is found, or installed when it's missing. Overrides the manager.namespace "+
+			"of the config and the manager namespace of the kubeconfig extension")
+	cmd.Flags().StringSliceVar(&valueFiles, "values", nil,
+		"Helm values file(s) for the traffic-manager. Applied when the traffic-manager is installed or upgraded, and "+
+			"reused by subsequent upgrades")
+	cmd.Flags().StringArrayVar(&setValues, "set", nil,
+		"Helm value for the traffic-manager, e.g. --set nodeSelector.disk=ssd. Can be repeated, and takes precedence "+
+			"over the --values files")
+	cmd.Flags().StringVar(&proxyMode, "proxy-mode", "",
+		`How the cluster is reached, "tun" (the default), "ports", or "socks5[:<address>]". The "ports" mode needs no root `+
+			"daemon. It proxies a loopback port to each TCP port of the services in the mapped namespaces, and resolves their "+
+			`names with a DNS server on a loopback port. See "telepresence status" for the ports. The "socks5" mode needs no `+
+			"root daemon either. It serves a SOCKS5 proxy on the given loopback address, or on the socks5Address of the config "+
+			"("+client.DefaultSOCKS5Address+" by default), which resolves names in the cluster and relays TCP connections to it")
+	cmd.Flags().StringVar(&hostsFile, "hosts-file", "",
+		"Publish the names of the services that are proxied with --proxy-mode ports in a section of this hosts file, "+
+			"e.g. /etc/hosts. The section is removed on disconnect")
+	cmd.Flags().BoolVar(&forceTunnel, "force-tunnel", false,
+		"Route the cluster subnets through the traffic-manager even when the cluster runs on a docker network of this "+
+			"host, like a kind, k3d, or minikube cluster does, which is otherwise routed to directly")
+	return cmd
+}
+
+// parseProxyMode parses the value of the --proxy-mode flag, and returns the proxy mode and, for a "socks5"
+// value with an address, such as "socks5:127.0.0.1:1080", the address of the SOCKS5 proxy.
+func parseProxyMode(value string) (string, string, error) {
+	mode, address := value, ""
+	if i := strings.IndexByte(value, ':'); i >= 0 {
+		mode, address = value[:i], value[i+1:]
+	}
+	switch mode {
+	case "", client.ProxyModeTUN, client.ProxyModePorts:
+		if address == "" {
+			return value, "", nil
+		}
+	case client.ProxyModeSOCKS5:
+		if address == "" {
+			return mode, "", nil
+		}
+		address, err := client.ParseSOCKS5Address(address)
+		if err != nil {
+			return "", "", errcat.User.Newf("--proxy-mode %q: %v", value, err)
+		}
+		return mode, address, nil
+	}
+	return "", "", errcat.User.Newf("--proxy-mode %q is not one of %q, %q, or %q",
+		value, client.ProxyModeTUN, client.ProxyModePorts, client.ProxyModeSOCKS5)
+}
+
+// preflightExecCredentials gives the exec credential plugin of the kubeconfig, if any, a chance to
+// interact with the user before the connector, which has no terminal, runs it. The plugin runs in
+// the container when the daemons run in docker mode, and on Windows when the CLI in WSL uses the daemons
+// on Windows, so nothing is done then.
+func preflightExecCredentials(cmd *cobra.Command) error {
+	ctx := cmd.Context()
+	dd, err := cliutil.DockerDaemon(ctx)
+	if err != nil || dd != nil {
+		return err
+	}
+	wd, err := cliutil.WindowsDaemons(ctx)
+	if err != nil || wd != nil {
+		return err
+	}
+	return userd_k8s.PreflightExecCredentials(ctx, connectorKubeFlagMap(ctx), cmd.InOrStdin(), cmd.ErrOrStderr())
+}
+
+// endMismatchedSession ends the current session when it can't be reused for a connect with the current
+// flags, so that the connect that follows creates a new session. When the user daemon has sessions with
+// several contexts, only a session of the requested context is ended.
+func endMismatchedSession(ctx context.Context, out io.Writer) error {
+	dd, err := cliutil.DockerDaemon(ctx)
+	if err != nil {
+		return err
+	}
+	wd, err := cliutil.WindowsDaemons(ctx)
+	if err != nil {
+		return err
+	}
+	mustRestart := false
+	err = cliutil.WithStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+		ci, err := connectorClient.Status(ctx, &connector.ConnectRequest{
+			KubeFlags:        connectorKubeFlagMap(ctx),
+			ManagerNamespace: managerNamespace,
+			ManagerValues:    managerValues,
+			ProxyMode:        proxyMode,
+			ForceTunnel:      forceTunnel,
+		})
+		if err != nil {
+			return err
+		}
+		switch {
+		case ci.Error == connector.ConnectInfo_MUST_RESTART && len(ci.Sessions) > 1:
+			// Only the mismatched session ends, and the sessions of other contexts remain
+			r, err := connectorClient.Disconnect(ctx, &connector.DisconnectRequest{
+				Context:          ci.ClusterContext,
+				ManagerNamespace: ci.ManagerNamespace,
+			})
+			if err != nil {
+				return err
+			}
+			if r.ErrorText != "" {
+				return errcat.Category(r.ErrorCategory).New(r.ErrorText)
+			}
+			fmt.Fprintf(out, "Disconnected from context %s\n", r.ClusterContext)
+		case ci.Error == connector.ConnectInfo_MUST_RESTART:
+			mustRestart = true
+		case ci.Error == connector.ConnectInfo_DISCONNECTED && len(ci.Sessions) == 1:
+			// The only session uses another context
+			mustRestart = true
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, cliutil.ErrNoConnector) {
+			return nil
+		}
+		return err
+	}
+	if !mustRestart {
+		return nil
+	}
+	if dd != nil {
+		return errcat.User.New("--switch can't be used when the daemons run in a Docker container, use telepresence quit first")
+	}
+	if wd != nil {
+		return errcat.User.New("--switch can't be used when the daemons run on Windows, use telepresence quit first")
+	}
+	return cliutil.Quit(ctx, out, true)
+}
+
+// parseManagerValues merges the given values files and --set values into the JSON encoded Helm values that are
+// sent to the connector. Values that the traffic-manager chart doesn't declare are rejected.
+func parseManagerValues(valueFiles, setValues []string) ([]byte, error) {
+	vals, err := helm.ParseValues(valueFiles, setValues)
+	if err != nil {
+		return nil, err
+	}
+	if err = helm.ValidateValues(vals); err != nil {
+		return nil, err
+	}
+	return json.Marshal(vals)
 }
 
 func dashboardCommand() *cobra.Command {
@@ -88,13 +299,94 @@ func dashboardCommand() *cobra.Command {
 }
 
 func quitCommand() *cobra.Command {
-	return &cobra.Command{
+	var stopDaemons, disconnectOnly, all bool
+	cmd := &cobra.Command{
 		Use:  "quit",
 		Args: cobra.NoArgs,
 
-		Short: "Tell telepresence daemon to quit",
+		Short: "Tell telepresence daemons to quit",
+		Long: `Tell telepresence daemons to quit
+
+When the user daemon has sessions with several kubernetes contexts, only the session of the context given
+with --context ends, and the daemons keep running for the other sessions. Use --all or --stop-daemons to
+end all sessions and stop the daemons.`,
 		RunE: func(cmd *cobra.Command, _ []string) error {
-			return cliutil.QuitDaemon(cmd.Context())
+			if stopDaemons && disconnectOnly {
+				return errcat.User.New("--stop-daemons and --disconnect-only are mutually exclusive")
+			}
+			if !(all || stopDaemons || disconnectOnly) {
+				if disconnected, err := disconnectSession(cmd.Context(), cmd.OutOrStdout()); disconnected || err != nil {
+					return err
+				}
+			}
+			return cliutil.Quit(cmd.Context(), cmd.OutOrStdout(), disconnectOnly)
 		},
 	}
+	flags := cmd.Flags()
+
+	// The -s of this command shadows the shorthand of the global --server flag, which is therefore
+	// redeclared without it. Setting it sets the global flag.
+	server := *kubeFlags.Lookup("server")
+	server.Shorthand = ""
+	server.Hidden = true
+	server.Value = kubeFlagValue{Value: server.Value, name: server.Name}
+	flags.AddFlag(&server)
+
+	flags.BoolVarP(&stopDaemons, "stop-daemons", "s", false,
+		"Stop both the user daemon and the root daemon, also when the user daemon has sessions with other contexts")
+	flags.BoolVarP(&disconnectOnly, "disconnect-only", "u", false,
+		"Only end the session with the cluster by stopping the user daemon. The root daemon keeps running")
+	flags.BoolVar(&all, "all", false,
+		"End the sessions of all kubernetes contexts. Implied by --stop-daemons and --disconnect-only")
+	flags.StringVar(&managerNamespace, "manager-namespace", "",
+		"The namespace of the traffic-manager of the session to end, when there are sessions with several traffic-managers "+
+			"in the given context")
+	return cmd
+}
+
+// kubeFlagValue is the value of a flag that redeclares the global kube flag with the given name.
+type kubeFlagValue struct {
+	pflag.Value
+	name string
+}
+
+func (v kubeFlagValue) Set(s string) error {
+	return kubeFlags.Set(v.name, s)
+}
+
+// disconnectSession ends the session of the context given with --context when the user daemon has sessions
+// with other contexts too, and returns true. False is returned when the user daemon has no more than one
+// session, so that quitting ends it.
+func disconnectSession(ctx context.Context, out io.Writer) (bool, error) {
+	disconnected := false
+	err := withStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+		ci, err := connectorClient.Status(ctx, &connector.ConnectRequest{KubeFlags: connectorKubeFlagMap(ctx)})
+		if err != nil {
+			return err
+		}
+		if len(ci.Sessions) < 2 {
+			return nil
+		}
+		kubeContext := sessionContext()
+		if kubeContext == "" && managerNamespace == "" {
+			return errcat.User.Newf("%s, or --all to end all of them", ci.ErrorText)
+		}
+		r, err := connectorClient.Disconnect(ctx, &connector.DisconnectRequest{
+			Context:          kubeContext,
+			ManagerNamespace: managerNamespace,
+		})
+		if err != nil {
+			return err
+		}
+		if r.ErrorText != "" {
+			return errcat.Category(r.ErrorCategory).New(r.ErrorText)
+		}
+		fmt.Fprintf(out, "Disconnected from context %s\n", r.ClusterContext)
+		disconnected = true
+		return nil
+	})
+	if err != nil && !errors.Is(err, cliutil.ErrNoConnector) {
+		return false, err
+	}
+	return disconnected, nil
 }
@@ -0,0 +1,192 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// sessionsConnector is a user daemon with a session for each of the given contexts, all with a
+// traffic-manager in the "ambassador" namespace.
+type sessionsConnector struct {
+	connector.ConnectorClient
+	contexts     []string
+	disconnected []string
+}
+
+func (c *sessionsConnector) Status(_ context.Context, cr *connector.ConnectRequest, _ ...grpc.CallOption) (*connector.ConnectInfo, error) {
+	ci := &connector.ConnectInfo{Error: connector.ConnectInfo_ALREADY_CONNECTED}
+	for _, kc := range c.contexts {
+		ci.Sessions = append(ci.Sessions, &connector.ConnectInfo{
+			Error:            connector.ConnectInfo_ALREADY_CONNECTED,
+			ClusterContext:   kc,
+			ManagerNamespace: "ambassador",
+		})
+	}
+	if len(c.contexts) > 1 && cr.KubeFlags["context"] == "" {
+		ci.Error = connector.ConnectInfo_SESSION_AMBIGUOUS
+		ci.ErrorText = "connected to contexts dev and staging; use --context to select one"
+		ci.ErrorCategory = int32(errcat.User)
+	}
+	return ci, nil
+}
+
+func (c *sessionsConnector) Disconnect(_ context.Context, dr *connector.DisconnectRequest, _ ...grpc.CallOption) (*connector.DisconnectResult, error) {
+	for _, kc := range c.contexts {
+		if kc == dr.Context {
+			c.disconnected = append(c.disconnected, kc)
+			return &connector.DisconnectResult{ClusterContext: kc, ManagerNamespace: "ambassador"}, nil
+		}
+	}
+	return &connector.DisconnectResult{
+		ErrorText:     "not connected to context " + dr.Context,
+		ErrorCategory: int32(errcat.User),
+	}, nil
+}
+
+// withFakeSessionContext makes the given context the one given with --context.
+func withFakeSessionContext(t *testing.T, kubeContext string) {
+	kubeFlags.String("context", "", "")
+	require.NoError(t, kubeFlags.Set("context", kubeContext))
+}
+
+func Test_disconnectSession(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	tests := []struct {
+		name                 string
+		contexts             []string
+		kubeContext          string
+		expectedDisconnected []string
+		expectedDone         bool
+		expectedStdout       string
+		expectedError        string
+	}{
+		{
+			name:     "single session",
+			contexts: []string{"dev"},
+		},
+		{
+			name:          "ambiguous",
+			contexts:      []string{"dev", "staging"},
+			expectedError: "connected to contexts dev and staging; use --context to select one, or --all to end all of them",
+		},
+		{
+			name:                 "selected",
+			contexts:             []string{"dev", "staging"},
+			kubeContext:          "staging",
+			expectedDisconnected: []string{"staging"},
+			expectedDone:         true,
+			expectedStdout:       "Disconnected from context staging\n",
+		},
+		{
+			name:          "not connected",
+			contexts:      []string{"dev", "staging"},
+			kubeContext:   "prod",
+			expectedError: "not connected to context prod",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			cc := &sessionsConnector{contexts: tt.contexts}
+			withFakeConnector(t, cc)
+			if tt.kubeContext != "" {
+				withFakeSessionContext(t, tt.kubeContext)
+			}
+			stdout := &bytes.Buffer{}
+			done, err := disconnectSession(ctx, stdout)
+			if tt.expectedError != "" {
+				require.Error(t, err)
+				assert.Equal(t, tt.expectedError, err.Error())
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedDone, done)
+			assert.Equal(t, tt.expectedDisconnected, cc.disconnected)
+			assert.Equal(t, tt.expectedStdout, stdout.String())
+		})
+	}
+
+	t.Run("no connector", func(t *testing.T) {
+		withFakeConnector(t, nil)
+		done, err := disconnectSession(ctx, &bytes.Buffer{})
+		require.NoError(t, err)
+		assert.False(t, done)
+	})
+}
+
+func Test_leaveAmbiguousSession(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	withFakeConnector(t, &sessionsConnector{contexts: []string{"dev", "staging"}})
+	err := leave(ctx, &bytes.Buffer{}, &bytes.Buffer{}, []string{"echo-*"}, false)
+	require.Error(t, err)
+	assert.Equal(t, "connected to contexts dev and staging; use --context to select one", err.Error())
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+}
+
+func Test_parseProxyMode(t *testing.T) {
+	tests := []struct {
+		value           string
+		expectedMode    string
+		expectedAddress string
+		expectedError   string
+	}{
+		{value: ""},
+		{value: "tun", expectedMode: "tun"},
+		{value: "ports", expectedMode: "ports"},
+		{value: "socks5", expectedMode: "socks5"},
+		{value: "socks5:127.0.0.1:1081", expectedMode: "socks5", expectedAddress: "127.0.0.1:1081"},
+		{value: "socks5:[::1]:1081", expectedMode: "socks5", expectedAddress: "[::1]:1081"},
+		{value: "socks5:0.0.0.0:1080", expectedError: `--proxy-mode "socks5:0.0.0.0:1080": invalid address "0.0.0.0:1080": host must be a loopback address`},
+		{value: "ports:127.0.0.1:1080", expectedError: `--proxy-mode "ports:127.0.0.1:1080" is not one of "tun", "ports", or "socks5"`},
+		{value: "vpn", expectedError: `--proxy-mode "vpn" is not one of "tun", "ports", or "socks5"`},
+	}
+	for _, tt := range tests {
+		mode, address, err := parseProxyMode(tt.value)
+		if tt.expectedError != "" {
+			if assert.Error(t, err, tt.value) {
+				assert.Equal(t, tt.expectedError, err.Error())
+				assert.Equal(t, errcat.User, errcat.GetCategory(err))
+			}
+			continue
+		}
+		if assert.NoError(t, err, tt.value) {
+			assert.Equal(t, tt.expectedMode, mode, tt.value)
+			assert.Equal(t, tt.expectedAddress, address, tt.value)
+		}
+	}
+}
+
+func Test_quitServerShorthand(t *testing.T) {
+	ctx := client.WithEnv(dlog.NewTestContext(t, false), &client.Env{ScoutDisable: "1"})
+	cfg := client.GetDefaultConfig(ctx)
+	ctx = client.WithConfig(ctx, &cfg)
+	ctx = filelocation.WithAppUserConfigDir(ctx, t.TempDir())
+	root := Command(ctx)
+	find := func(args ...string) *cobra.Command {
+		cmd, flags, err := root.Find(args)
+		require.NoError(t, err)
+		require.NoError(t, cmd.ParseFlags(flags))
+		return cmd
+	}
+
+	// -s is the --server of the other commands
+	cmd := find("connect", "-s", "https://example.com:6443")
+	assert.Equal(t, "https://example.com:6443", cmd.Flag("server").Value.String())
+
+	// and the --stop-daemons of quit, which still accepts --server
+	cmd = find("quit", "-s", "--server", "https://example.com:6444")
+	assert.Equal(t, "true", cmd.Flag("stop-daemons").Value.String())
+	assert.Equal(t, "https://example.com:6444", kubeFlagMap()["server"])
+}
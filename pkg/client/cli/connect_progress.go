@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/pkg/term"
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// connectProgressWriter renders the progress of a connect. A terminal gets a single line that is updated
+// as the steps progress, and that is cleared when the connect is done unless a step failed. Other writers
+// get one line per event.
+type connectProgressWriter struct {
+	out     io.Writer
+	live    bool
+	lineLen int // length of the live line that is currently shown
+}
+
+func newConnectProgressWriter(out io.Writer) *connectProgressWriter {
+	live := false
+	if f, ok := out.(*os.File); ok {
+		live = term.IsTerminal(f.Fd())
+	}
+	return &connectProgressWriter{out: out, live: live}
+}
+
+func (w *connectProgressWriter) report(p *connector.ConnectProgress) {
+	if p.Step == "" {
+		return
+	}
+	var line string
+	switch p.State {
+	case connector.ConnectProgress_STARTED:
+		line = p.Step
+		if p.Detail != "" {
+			line += " (" + p.Detail + ")"
+		}
+		line += "..."
+	case connector.ConnectProgress_DONE:
+		line = p.Step + ": done"
+	case connector.ConnectProgress_FAILED:
+		line = p.Step + ": failed"
+		if p.Detail != "" {
+			line += ": " + p.Detail
+		}
+	}
+	if !w.live {
+		fmt.Fprintln(w.out, line)
+		return
+	}
+	w.show(line)
+	if p.State == connector.ConnectProgress_FAILED {
+		// Keep the failure visible
+		fmt.Fprintln(w.out)
+		w.lineLen = 0
+	}
+}
+
+// show replaces the live line with the given line.
+func (w *connectProgressWriter) show(line string) {
+	pad := ""
+	if n := w.lineLen - len(line); n > 0 {
+		pad = strings.Repeat(" ", n) + strings.Repeat("\b", n)
+	}
+	fmt.Fprint(w.out, "\r", line, pad)
+	w.lineLen = len(line)
+}
+
+// done clears the live line.
+func (w *connectProgressWriter) done() {
+	if w.live && w.lineLen > 0 {
+		fmt.Fprint(w.out, "\r", strings.Repeat(" ", w.lineLen), "\r")
+		w.lineLen = 0
+	}
+}
+
+// connectWithProgress makes the connector connect and passes the progress that it streams on to the given
+// report function. A connector that is older than the ConnectStream call is asked to Connect instead, in
+// which case nothing is reported.
+func connectWithProgress(
+	ctx context.Context,
+	connectorClient connector.ConnectorClient,
+	cr *connector.ConnectRequest,
+	report func(*connector.ConnectProgress),
+) (*connector.ConnectInfo, error) {
+	stream, err := connectorClient.ConnectStream(ctx, cr)
+	if err == nil {
+		for {
+			var p *connector.ConnectProgress
+			if p, err = stream.Recv(); err != nil {
+				break
+			}
+			if p.Info != nil {
+				return p.Info, nil
+			}
+			report(p)
+		}
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("connector.ConnectStream: the stream ended without a result")
+		}
+	}
+	if grpcStatus.Code(err) == grpcCodes.Unimplemented {
+		return connectorClient.Connect(ctx, cr)
+	}
+	return nil, err
+}
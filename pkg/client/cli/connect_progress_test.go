@@ -0,0 +1,180 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// progressConnector is a user daemon that streams the given progress from ConnectStream, or that doesn't
+// implement ConnectStream at all when legacy is true.
+type progressConnector struct {
+	connector.ConnectorClient
+	progress []*connector.ConnectProgress
+	legacy   bool
+	info     *connector.ConnectInfo
+}
+
+func (c *progressConnector) Connect(context.Context, *connector.ConnectRequest, ...grpc.CallOption) (*connector.ConnectInfo, error) {
+	return c.info, nil
+}
+
+func (c *progressConnector) ConnectStream(context.Context, *connector.ConnectRequest, ...grpc.CallOption) (connector.Connector_ConnectStreamClient, error) {
+	return &progressStream{connector: c}, nil
+}
+
+type progressStream struct {
+	grpc.ClientStream
+	connector *progressConnector
+	sent      int
+}
+
+func (s *progressStream) Recv() (*connector.ConnectProgress, error) {
+	c := s.connector
+	if c.legacy {
+		return nil, grpcStatus.Error(grpcCodes.Unimplemented, "unknown method ConnectStream")
+	}
+	if s.sent == len(c.progress) {
+		return nil, io.EOF
+	}
+	p := c.progress[s.sent]
+	s.sent++
+	return p, nil
+}
+
+func Test_connectWithProgress(t *testing.T) {
+	started := func(step, detail string) *connector.ConnectProgress {
+		return &connector.ConnectProgress{Step: step, State: connector.ConnectProgress_STARTED, Detail: detail}
+	}
+	done := func(step string) *connector.ConnectProgress {
+		return &connector.ConnectProgress{Step: step, State: connector.ConnectProgress_DONE}
+	}
+	connected := &connector.ConnectInfo{ClusterContext: "kind"}
+	failed := &connector.ConnectInfo{Error: connector.ConnectInfo_TRAFFIC_MANAGER_FAILED, ErrorText: "helm install failed"}
+
+	tests := []struct {
+		name           string
+		connector      *progressConnector
+		expectedInfo   *connector.ConnectInfo
+		expectedError  string
+		expectedOutput string
+		expectedScreen []string // what remains visible on a terminal
+	}{
+		{
+			name: "success",
+			connector: &progressConnector{progress: []*connector.ConnectProgress{
+				started(client.ConnectStepCluster, "context kind"),
+				done(client.ConnectStepCluster),
+				started(client.ConnectStepTrafficManager, "namespace ambassador"),
+				done(client.ConnectStepTrafficManager),
+				started(client.ConnectStepTunnel, ""),
+				done(client.ConnectStepTunnel),
+				started(client.ConnectStepDNS, ""),
+				done(client.ConnectStepDNS),
+				{Info: connected},
+			}},
+			expectedInfo: connected,
+			expectedOutput: "contacting cluster (context kind)...\n" +
+				"contacting cluster: done\n" +
+				"ensuring traffic-manager (namespace ambassador)...\n" +
+				"ensuring traffic-manager: done\n" +
+				"establishing tunnel...\n" +
+				"establishing tunnel: done\n" +
+				"configuring DNS...\n" +
+				"configuring DNS: done\n",
+			expectedScreen: []string{""},
+		},
+		{
+			name: "failure",
+			connector: &progressConnector{progress: []*connector.ConnectProgress{
+				started(client.ConnectStepCluster, "context kind"),
+				done(client.ConnectStepCluster),
+				started(client.ConnectStepTrafficManager, "namespace ambassador"),
+				{Step: client.ConnectStepTrafficManager, State: connector.ConnectProgress_FAILED, Detail: "helm install failed"},
+				{Info: failed},
+			}},
+			expectedInfo: failed,
+			expectedOutput: "contacting cluster (context kind)...\n" +
+				"contacting cluster: done\n" +
+				"ensuring traffic-manager (namespace ambassador)...\n" +
+				"ensuring traffic-manager: failed: helm install failed\n",
+			expectedScreen: []string{"ensuring traffic-manager: failed: helm install failed", ""},
+		},
+		{
+			name:           "no result",
+			connector:      &progressConnector{progress: []*connector.ConnectProgress{started(client.ConnectStepCluster, "")}},
+			expectedError:  "the stream ended without a result",
+			expectedOutput: "contacting cluster...\n",
+			expectedScreen: []string{""},
+		},
+		{
+			name:         "legacy connector",
+			connector:    &progressConnector{legacy: true, info: connected},
+			expectedInfo: connected,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			for _, live := range []bool{false, true} {
+				out := &bytes.Buffer{}
+				pw := &connectProgressWriter{out: out, live: live}
+				info, err := connectWithProgress(context.Background(), tt.connector, &connector.ConnectRequest{}, pw.report)
+				pw.done()
+				if tt.expectedError != "" {
+					require.Error(t, err)
+					assert.Contains(t, err.Error(), tt.expectedError)
+				} else {
+					require.NoError(t, err)
+					assert.Equal(t, tt.expectedInfo, info)
+				}
+				if live {
+					if tt.expectedScreen != nil {
+						assert.Equal(t, tt.expectedScreen, terminalScreen(out.String()))
+					}
+				} else {
+					assert.Equal(t, tt.expectedOutput, out.String())
+				}
+			}
+		})
+	}
+}
+
+// terminalScreen returns the lines that a terminal shows after it has written the given output.
+func terminalScreen(output string) []string {
+	var lines []string
+	var line []rune
+	col := 0
+	for _, r := range output {
+		switch r {
+		case '\r':
+			col = 0
+		case '\b':
+			if col > 0 {
+				col--
+			}
+		case '\n':
+			lines = append(lines, strings.TrimRight(string(line), " "))
+			line, col = nil, 0
+		default:
+			if col < len(line) {
+				line[col] = r
+			} else {
+				line = append(line, r)
+			}
+			col++
+		}
+	}
+	return append(lines, strings.TrimRight(string(line), " "))
+}
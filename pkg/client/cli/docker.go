@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// dockerHostName is the name that the daemons container uses for the host
+const dockerHostName = "host.docker.internal"
+
+// startDockerDaemons starts the container that runs the daemons, unless it's already running, and
+// returns true if it was started. The container is given a self-contained kubeconfig with only the
+// context that the kubernetes flags select.
+func startDockerDaemons(cmd *cobra.Command) (bool, error) {
+	config, err := kubeConfig.ToRawKubeConfigLoader().RawConfig()
+	if err != nil {
+		return false, err
+	}
+	if kubeConfig.Context != nil && *kubeConfig.Context != "" {
+		config.CurrentContext = *kubeConfig.Context
+	}
+	data, err := dockerKubeConfig(&config, cmd.ErrOrStderr())
+	if err != nil {
+		return false, err
+	}
+	_, started, err := cliutil.StartDockerDaemons(cmd.Context(), cmd.OutOrStdout(), data)
+	return started, err
+}
+
+// dockerKubeConfig minifies and flattens the given kubeconfig so that it can be mounted into the
+// daemons container. Servers on the loopback interface of the host are reached using the address
+// that the container has for the host.
+func dockerKubeConfig(config *clientcmdapi.Config, warnings io.Writer) ([]byte, error) {
+	if _, ok := config.Contexts[config.CurrentContext]; !ok {
+		return nil, errcat.Config.Newf("context %q does not exist in the kubeconfig", config.CurrentContext)
+	}
+	if err := clientcmdapi.MinifyConfig(config); err != nil {
+		return nil, errcat.Config.New(err)
+	}
+	if err := clientcmdapi.FlattenConfig(config); err != nil {
+		return nil, errcat.Config.New(err)
+	}
+	for _, cluster := range config.Clusters {
+		u, err := url.Parse(cluster.Server)
+		if err != nil {
+			return nil, errcat.Config.Newf("invalid server %q in kubeconfig: %w", cluster.Server, err)
+		}
+		host := u.Hostname()
+		if ip := net.ParseIP(host); host == "localhost" || ip != nil && ip.IsLoopback() {
+			if cluster.TLSServerName == "" {
+				cluster.TLSServerName = host
+			}
+			if port := u.Port(); port != "" {
+				u.Host = net.JoinHostPort(dockerHostName, port)
+			} else {
+				u.Host = dockerHostName
+			}
+			cluster.Server = u.String()
+		}
+	}
+	for name, authInfo := range config.AuthInfos {
+		if authInfo.Exec != nil {
+			fmt.Fprintf(warnings, "Warning: the kubeconfig user %q uses the credential plugin %q, which must be available in the daemons container\n",
+				name, authInfo.Exec.Command)
+		}
+	}
+	return clientcmd.Write(*config)
+}
@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func Test_dockerKubeConfig(t *testing.T) {
+	config := clientcmdapi.NewConfig()
+	config.Clusters["local"] = &clientcmdapi.Cluster{Server: "https://127.0.0.1:6443", CertificateAuthorityData: []byte("ca")}
+	config.Clusters["remote"] = &clientcmdapi.Cluster{Server: "https://k8s.example.com"}
+	config.AuthInfos["local"] = &clientcmdapi.AuthInfo{Token: "secret"}
+	config.AuthInfos["remote"] = &clientcmdapi.AuthInfo{Exec: &clientcmdapi.ExecConfig{Command: "aws"}}
+	config.Contexts["local"] = &clientcmdapi.Context{Cluster: "local", AuthInfo: "local"}
+	config.Contexts["remote"] = &clientcmdapi.Context{Cluster: "remote", AuthInfo: "remote"}
+
+	t.Run("loopback server", func(t *testing.T) {
+		cfg := config.DeepCopy()
+		cfg.CurrentContext = "local"
+		warnings := &bytes.Buffer{}
+		data, err := dockerKubeConfig(cfg, warnings)
+		require.NoError(t, err)
+		assert.Empty(t, warnings.String())
+
+		result, err := clientcmd.Load(data)
+		require.NoError(t, err)
+		assert.Len(t, result.Contexts, 1)
+		require.Contains(t, result.Clusters, "local")
+		cluster := result.Clusters["local"]
+		assert.Equal(t, "https://host.docker.internal:6443", cluster.Server)
+		assert.Equal(t, "127.0.0.1", cluster.TLSServerName)
+		assert.Equal(t, []byte("ca"), cluster.CertificateAuthorityData)
+	})
+
+	t.Run("credential plugin", func(t *testing.T) {
+		cfg := config.DeepCopy()
+		cfg.CurrentContext = "remote"
+		warnings := &bytes.Buffer{}
+		data, err := dockerKubeConfig(cfg, warnings)
+		require.NoError(t, err)
+		assert.Contains(t, warnings.String(), `credential plugin "aws"`)
+
+		result, err := clientcmd.Load(data)
+		require.NoError(t, err)
+		assert.Equal(t, "https://k8s.example.com", result.Clusters["remote"].Server)
+		assert.Empty(t, result.Clusters["remote"].TLSServerName)
+	})
+
+	t.Run("missing context", func(t *testing.T) {
+		cfg := config.DeepCopy()
+		cfg.CurrentContext = "nope"
+		_, err := dockerKubeConfig(cfg, &bytes.Buffer{})
+		assert.Error(t, err)
+	})
+}
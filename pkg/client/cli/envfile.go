@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// dotenvPlainRx matches values that can be written to a dotenv file without quotes.
+var dotenvPlainRx = regexp.MustCompile(`^[a-zA-Z0-9_./:@%+,=-]*$`)
+
+// dotenvEscaper escapes the characters that have a special meaning in a double quoted dotenv value.
+var dotenvEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	`$`, `\$`,
+	"`", "\\`",
+	"\n", `\n`,
+	"\r", `\r`,
+)
+
+// dotenvValue returns the given value in a form that can be used as a value in a dotenv file. Values
+// that contain anything but plain characters are double quoted, and multiline values, such as
+// certificates, are written on one line with escaped newlines.
+func dotenvValue(v string) string {
+	if dotenvPlainRx.MatchString(v) {
+		return v
+	}
+	return `"` + dotenvEscaper.Replace(v) + `"`
+}
+
+// filterEnv returns the variables of the given environment that pass the given filters. When there are
+// include patterns, only variables with a name that matches one of them are kept. Variables with a name that
+// matches an exclude pattern are then removed, so an exclude pattern takes precedence over an include pattern.
+func filterEnv(env map[string]string, filters client.EnvFilters) map[string]string {
+	filtered := make(map[string]string, len(env))
+	for k, v := range env {
+		if len(filters.Include) > 0 && !matchesAnyPattern(k, filters.Include) {
+			continue
+		}
+		if matchesAnyPattern(k, filters.Exclude) {
+			continue
+		}
+		filtered[k] = v
+	}
+	return filtered
+}
+
+// matchesAnyPattern returns true if the given name matches one of the given patterns. The patterns have been
+// validated, so a pattern that is malformed never matches.
+func matchesAnyPattern(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// writeDotenv writes the given environment to the given writer in dotenv format, sorted by key.
+func writeDotenv(out io.Writer, env map[string]string) error {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	w := bufio.NewWriter(out)
+	for _, k := range keys {
+		if _, err := w.WriteString(k + "=" + dotenvValue(env[k]) + "\n"); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// writeFileAtomic creates a temporary file in the directory of the given path, lets the write function
+// fill it, and then renames it to the given path. The file is created with mode 0600 so that it is
+// readable by the current user only. Readers of the file will never see partially written content.
+func writeFileAtomic(path string, write func(io.Writer) error) (err error) {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}
+	}()
+	if err = file.Chmod(0600); err != nil {
+		return err
+	}
+	if err = write(file); err != nil {
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
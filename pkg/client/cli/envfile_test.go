@@ -0,0 +1,236 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+func Test_dotenvValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{"empty", "", ``},
+		{"plain", "postgres://db:5432/app?sslmode=disable", `"postgres://db:5432/app?sslmode=disable"`},
+		{"url without query", "http://echo.default:8080/x", `http://echo.default:8080/x`},
+		{"spaces", "hello world", `"hello world"`},
+		{"double quotes", `say "hi"`, `"say \"hi\""`},
+		{"single quotes", `it's`, `"it's"`},
+		{"backslash", `C:\temp`, `"C:\\temp"`},
+		{"dollar", "pa$$word", `"pa\$\$word"`},
+		{"backtick", "`id`", "\"\\`id\\`\""},
+		{"hash", "#ff0000", `"#ff0000"`},
+		{"multiline", "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n", `"-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"`},
+		{"crlf", "a\r\nb", `"a\r\nb"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, dotenvValue(tt.value))
+		})
+	}
+}
+
+func Test_writeDotenv(t *testing.T) {
+	buf := bytes.Buffer{}
+	require.NoError(t, writeDotenv(&buf, map[string]string{
+		"B_CERT": "line1\nline2",
+		"A_URL":  "redis://cache:6379",
+		"C_FLAG": "",
+	}))
+	assert.Equal(t, "A_URL=redis://cache:6379\nB_CERT=\"line1\\nline2\"\nC_FLAG=\n", buf.String())
+}
+
+func Test_writeEnvFiles(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "intercept.env")
+
+	// An existing file is replaced
+	require.NoError(t, os.WriteFile(envFile, []byte("OLD=value\nSTALE=value\n"), 0644))
+
+	is := &interceptState{
+		args: interceptArgs{
+			envFile: envFile,
+			mount:   "false",
+		},
+		env: map[string]string{
+			"DATABASE_URL":              "postgres://db:5432/app",
+			"TELEPRESENCE_INTERCEPT_ID": "abc:echo",
+		},
+	}
+	require.NoError(t, is.writeEnvFiles())
+
+	data, err := os.ReadFile(envFile)
+	require.NoError(t, err)
+	assert.Equal(t, "DATABASE_URL=postgres://db:5432/app\nTELEPRESENCE_INTERCEPT_ID=abc:echo\n", string(data))
+
+	if runtime.GOOS != "windows" {
+		st, err := os.Stat(envFile)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), st.Mode().Perm())
+	}
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func Test_writeEnvJSON(t *testing.T) {
+	dir := t.TempDir()
+	remoteEnv := map[string]string{
+		"CERT":     "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n",
+		"GREETING": "héllo wörld ☃",
+		"OPTS":     "a=b=c --flag=\"x y\"",
+		"HTML":     "<a href='x'>&</a>",
+	}
+	is := &interceptState{
+		args: interceptArgs{
+			envFile: filepath.Join(dir, "intercept.env"),
+			envJSON: filepath.Join(dir, "intercept.json"),
+		},
+	}
+	is.setEnvironment(remoteEnv, "abc:echo")
+	require.NoError(t, is.writeEnvFiles())
+
+	// The JSON contains the values exactly as reported by the agent
+	data, err := os.ReadFile(is.args.envJSON)
+	require.NoError(t, err)
+	var actual map[string]string
+	require.NoError(t, json.Unmarshal(data, &actual))
+	assert.Equal(t, remoteEnv, actual)
+	assert.Contains(t, string(data), "<a href='x'>&</a>")
+
+	// The dotenv file was written too, and includes the intercept id
+	data, err = os.ReadFile(is.args.envFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "OPTS=\"a=b=c --flag=\\\"x y\\\"\"\n")
+	assert.Contains(t, string(data), "TELEPRESENCE_INTERCEPT_ID=abc:echo\n")
+}
+
+func Test_writeEnvJSON_noEnvironment(t *testing.T) {
+	stderr := bytes.Buffer{}
+	cmd := &cobra.Command{}
+	cmd.SetErr(&stderr)
+	is := &interceptState{
+		cmd:  safeCobraCommandImpl{Command: cmd},
+		args: interceptArgs{envJSON: filepath.Join(t.TempDir(), "intercept.json")},
+	}
+	is.setEnvironment(nil, "abc:echo")
+	require.NoError(t, is.writeEnvFiles())
+	assert.Contains(t, stderr.String(), "did not report the environment")
+
+	data, err := os.ReadFile(is.args.envJSON)
+	require.NoError(t, err)
+	assert.Equal(t, "{}\n", string(data))
+	assert.Equal(t, "abc:echo", is.env["TELEPRESENCE_INTERCEPT_ID"])
+}
+
+func Test_filterEnv(t *testing.T) {
+	env := map[string]string{
+		"APP_URL":                 "http://app",
+		"APP_SECRET":              "s3cr3t",
+		"KUBERNETES_PORT":         "tcp://10.0.0.1:443",
+		"KUBERNETES_SERVICE_HOST": "10.0.0.1",
+		"HOME":                    "/root",
+	}
+	tests := []struct {
+		name     string
+		filters  client.EnvFilters
+		expected []string
+	}{
+		{
+			name:     "no filters",
+			expected: []string{"APP_URL", "APP_SECRET", "KUBERNETES_PORT", "KUBERNETES_SERVICE_HOST", "HOME"},
+		},
+		{
+			name:     "exclude",
+			filters:  client.EnvFilters{Exclude: []string{"KUBERNETES_*"}},
+			expected: []string{"APP_URL", "APP_SECRET", "HOME"},
+		},
+		{
+			name:     "include",
+			filters:  client.EnvFilters{Include: []string{"APP_*", "HOME"}},
+			expected: []string{"APP_URL", "APP_SECRET", "HOME"},
+		},
+		{
+			name:     "exclude takes precedence over include",
+			filters:  client.EnvFilters{Include: []string{"APP_*"}, Exclude: []string{"*_SECRET"}},
+			expected: []string{"APP_URL"},
+		},
+		{
+			name:     "character class",
+			filters:  client.EnvFilters{Include: []string{"[AH]*"}, Exclude: []string{"?PP_URL"}},
+			expected: []string{"APP_SECRET", "HOME"},
+		},
+		{
+			name:    "nothing included",
+			filters: client.EnvFilters{Include: []string{"DATABASE_*"}},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var actual []string
+			for k := range filterEnv(env, tt.filters) {
+				actual = append(actual, k)
+			}
+			assert.ElementsMatch(t, tt.expected, actual)
+		})
+	}
+}
+
+func Test_setEnvironment_filtered(t *testing.T) {
+	dir := t.TempDir()
+	remoteEnv := map[string]string{
+		"APP_URL":         "http://app",
+		"APP_SECRET":      "s3cr3t",
+		"KUBERNETES_PORT": "tcp://10.0.0.1:443",
+	}
+	is := &interceptState{
+		args: interceptArgs{
+			envFile:           filepath.Join(dir, "intercept.env"),
+			envJSON:           filepath.Join(dir, "intercept.json"),
+			envFileUnfiltered: filepath.Join(dir, "unfiltered.env"),
+		},
+		envFilters: client.EnvFilters{Include: []string{"APP_*", "KUBERNETES_*"}, Exclude: []string{"KUBERNETES_*", "*_SECRET"}},
+	}
+	is.setEnvironment(remoteEnv, "abc:echo")
+	require.NoError(t, is.writeEnvFiles())
+
+	// The env file
+	data, err := os.ReadFile(is.args.envFile)
+	require.NoError(t, err)
+	assert.Equal(t, "APP_URL=http://app\nTELEPRESENCE_INTERCEPT_ID=abc:echo\n", string(data))
+
+	// The JSON
+	data, err = os.ReadFile(is.args.envJSON)
+	require.NoError(t, err)
+	var actual map[string]string
+	require.NoError(t, json.Unmarshal(data, &actual))
+	assert.Equal(t, map[string]string{"APP_URL": "http://app"}, actual)
+
+	// The environment of the command, and of a docker container
+	assert.Equal(t, map[string]string{"APP_URL": "http://app", "TELEPRESENCE_INTERCEPT_ID": "abc:echo"}, is.env)
+	file, err := os.Create(filepath.Join(dir, "docker.env"))
+	require.NoError(t, err)
+	require.NoError(t, is.writeEnvToFileAndClose(file))
+	data, err = os.ReadFile(file.Name())
+	require.NoError(t, err)
+	assert.Equal(t, "APP_URL=http://app\nTELEPRESENCE_INTERCEPT_ID=abc:echo\n", string(data))
+
+	// The unfiltered env file has everything
+	data, err = os.ReadFile(is.args.envFileUnfiltered)
+	require.NoError(t, err)
+	assert.Equal(t, "APP_SECRET=s3cr3t\nAPP_URL=http://app\nKUBERNETES_PORT=tcp://10.0.0.1:443\nTELEPRESENCE_INTERCEPT_ID=abc:echo\n", string(data))
+}
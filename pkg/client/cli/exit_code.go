@@ -0,0 +1,59 @@
+package cli
+
+import "fmt"
+
+// The exit codes of the CLI that scripts can rely on. A command that fails for any other reason exits
+// with ExitFailure.
+const (
+	// ExitFailure is the exit code of a command that fails.
+	ExitFailure = 1
+
+	// ExitAlreadyExists is the exit code of an intercept that can't be created because an intercept with
+	// the same name already exists.
+	ExitAlreadyExists = 4
+
+	// ExitNotFound is the exit code of a resolve of a name that doesn't exist in the cluster.
+	ExitNotFound = 5
+
+	// ExitLookupFailed is the exit code of a resolve that failed to get an answer from the cluster.
+	ExitLookupFailed = 6
+)
+
+// The exit codes of the status command.
+const (
+	// StatusConnected means that both daemons run and that the user daemon is connected to a cluster.
+	StatusConnected = 0
+
+	// StatusDisconnected means that one or both daemons run, but that there's no connection to a cluster.
+	StatusDisconnected = 1
+
+	// StatusNotRunning means that neither of the daemons run.
+	StatusNotRunning = 2
+
+	// StatusError means that the status couldn't be retrieved from the daemons.
+	StatusError = 3
+)
+
+// ExitCodeError is an error that makes the CLI exit with a specific exit code. The CLI prints the
+// message of the wrapped error, if any, so an ExitCodeError without one makes the exit code the only
+// output of the command.
+type ExitCodeError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitCodeError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit status %d", e.Code)
+	}
+	return e.Err.Error()
+}
+
+func (e *ExitCodeError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode returns the exit code that the CLI exits with.
+func (e *ExitCodeError) ExitCode() int {
+	return e.Code
+}
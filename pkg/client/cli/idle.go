@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/pkg/term"
+	"github.com/spf13/cobra"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// checkIdleDisconnect reports a session that the connector ended because it was idle. A connect
+// command reconnects without asking, and so do commands that don't run in a terminal, and commands
+// that use --output json, which report to stderr. Other commands ask the user whether to reconnect.
+func checkIdleDisconnect(cmd *cobra.Command) error {
+	if jsonOutput(cmd) {
+		return reportIdleDisconnect(cmd.Context(), nil, cmd.ErrOrStderr(), false)
+	}
+	ask := cmd.Name() != "connect" && term.IsTerminal(os.Stdin.Fd())
+	return reportIdleDisconnect(cmd.Context(), cmd.InOrStdin(), cmd.OutOrStdout(), ask)
+}
+
+// reportIdleDisconnect prints when the last session was disconnected due to idle timeout, if it
+// was, and removes that record so that it's reported only once. An errcat.User error is returned if
+// the user is asked and declines to reconnect.
+func reportIdleDisconnect(ctx context.Context, in io.Reader, out io.Writer, ask bool) error {
+	idle, err := cache.LoadIdleDisconnectFromUserCache(ctx)
+	if err != nil {
+		dlog.Errorf(ctx, "unable to load the idle disconnect from the user cache: %v", err)
+		return nil
+	}
+	if idle == nil {
+		return nil
+	}
+	if err = cache.DeleteIdleDisconnectFromUserCache(ctx); err != nil {
+		dlog.Errorf(ctx, "unable to delete the idle disconnect from the user cache: %v", err)
+	}
+	fmt.Fprintf(out, "Disconnected due to idle timeout at %s (idle for %s)\n", idle.Time.Local().Format("2006-01-02 15:04:05"), idle.Timeout)
+	if !ask {
+		return nil
+	}
+	reader := bufio.NewReader(in)
+	fmt.Fprint(out, "Reconnect? [Y/n]: ")
+	for {
+		reply, err := reader.ReadString('\n')
+		if err != nil && (err != io.EOF || reply == "") {
+			return err
+		}
+		switch strings.TrimSpace(reply) {
+		case "", "y", "Y":
+			return nil
+		case "n", "N":
+			return errcat.User.New("not connected")
+		}
+		fmt.Fprint(out, "Please answer 'y' or 'n' [Y/n]: ")
+	}
+}
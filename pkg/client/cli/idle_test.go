@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+func Test_reportIdleDisconnect(t *testing.T) {
+	disconnected := time.Date(2021, time.October, 1, 17, 30, 0, 0, time.Local)
+	tests := []struct {
+		name    string
+		ask     bool
+		in      string
+		wantErr bool
+	}{
+		{name: "no prompt", ask: false},
+		{name: "default answer", ask: true, in: "\n"},
+		{name: "yes", ask: true, in: "y\n"},
+		{name: "no", ask: true, in: "n\n", wantErr: true},
+		{name: "invalid then no", ask: true, in: "maybe\nN\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newTestContext(t)
+			require.NoError(t, cache.SaveIdleDisconnectToUserCache(ctx, &cache.IdleDisconnect{Time: disconnected, Timeout: 2 * time.Hour}))
+
+			out := &strings.Builder{}
+			err := reportIdleDisconnect(ctx, strings.NewReader(tt.in), out, tt.ask)
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Equal(t, errcat.User, errcat.GetCategory(err))
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Contains(t, out.String(), "Disconnected due to idle timeout at 2021-10-01 17:30:00 (idle for 2h0m0s)")
+			assert.Equal(t, tt.ask, strings.Contains(out.String(), "Reconnect? [Y/n]"))
+
+			// The disconnect is reported only once
+			out.Reset()
+			require.NoError(t, reportIdleDisconnect(ctx, strings.NewReader(tt.in), out, tt.ask))
+			assert.Empty(t, out.String())
+		})
+	}
+}
+
+func Test_checkIdleDisconnect_jsonOutput(t *testing.T) {
+	ctx := newTestContext(t)
+	require.NoError(t, cache.SaveIdleDisconnectToUserCache(ctx, &cache.IdleDisconnect{Time: time.Now(), Timeout: 2 * time.Hour}))
+
+	// A command with --output json never asks, and keeps stdout for its JSON document
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := &cobra.Command{Use: "intercept", RunE: func(cmd *cobra.Command, _ []string) error { return checkIdleDisconnect(cmd) }}
+	cmd.Flags().String("output", "", "")
+	cmd.SetIn(strings.NewReader("n\n"))
+	cmd.SetOut(stdout)
+	cmd.SetErr(stderr)
+	cmd.SetArgs([]string{"--output", "json"})
+	require.NoError(t, cmd.ExecuteContext(ctx))
+	assert.Empty(t, stdout.String())
+	assert.Contains(t, stderr.String(), "Disconnected due to idle timeout")
+	assert.NotContains(t, stderr.String(), "Reconnect?")
+}
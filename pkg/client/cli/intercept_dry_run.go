@@ -0,0 +1,103 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// dryRun shows the intercept and the changes that creating it makes to the cluster, without creating it.
+func (is *interceptState) dryRun(ctx context.Context) error {
+	ir, err := is.createRequest(ctx)
+	if err != nil {
+		return err
+	}
+	return is.reportPlan(ctx, ir)
+}
+
+// reportPlan asks the user daemon to plan the given intercept and writes the plan. An error is returned
+// when the intercept can't be created, or when the user lacks the permissions to make the changes.
+func (is *interceptState) reportPlan(ctx context.Context, ir *connector.CreateInterceptRequest) error {
+	plan, err := is.connectorClient.PlanIntercept(ctx, ir)
+	if err != nil {
+		if grpcStatus.Code(err) == grpcCodes.Unimplemented {
+			return errcat.User.New("the user daemon is too old to do a --dry-run. Restart it using \"telepresence quit\" and try again")
+		}
+		return fmt.Errorf("connector.PlanIntercept: %w", err)
+	}
+
+	if is.args.json != nil {
+		if err = is.args.json.write(plan); err != nil {
+			return err
+		}
+	} else if plan.Failure == nil {
+		writeInterceptPlan(is.cmd.OutOrStdout(), plan)
+	}
+
+	if plan.Failure != nil {
+		return interceptMessage(plan.Failure)
+	}
+	if len(plan.MissingPermissions) > 0 {
+		return errcat.User.Newf("the intercept requires permissions that you don't have:\n  %s",
+			strings.Join(plan.MissingPermissions, "\n  "))
+	}
+	return nil
+}
+
+// writeInterceptPlan writes a human-readable description of the given plan.
+func writeInterceptPlan(out io.Writer, plan *connector.InterceptPlan) {
+	spec := plan.Spec
+	fmt.Fprintf(out, "Intercept %s (dry run)\n", spec.Name)
+	if spec.Agent == "" {
+		fmt.Fprintln(out, "    A local-only intercept doesn't change the cluster")
+		return
+	}
+
+	type kv struct {
+		Key   string
+		Value string
+	}
+	fields := []kv{
+		{"Workload", fmt.Sprintf("%s %s.%s", spec.WorkloadKind, spec.Agent, spec.Namespace)},
+		{"Service", fmt.Sprintf("%s, port %s", plan.ServiceName, plan.ServicePort)},
+		{"Destination", net.JoinHostPort(spec.TargetHost, strconv.Itoa(int(spec.TargetPort)))},
+		{"Mechanism", spec.Mechanism},
+	}
+	klen := 0
+	for _, kv := range fields {
+		if len(kv.Key) > klen {
+			klen = len(kv.Key)
+		}
+	}
+	for _, kv := range fields {
+		fmt.Fprintf(out, "    %-*s: %s\n", klen, kv.Key, kv.Value)
+	}
+
+	if len(plan.Changes) == 0 {
+		fmt.Fprintln(out, "The traffic-agent is in place, so the cluster isn't changed")
+		return
+	}
+	fmt.Fprintln(out, "The following changes are made to the cluster:")
+	for _, oc := range plan.Changes {
+		fmt.Fprintf(out, "  %s %s %s", oc.Verb, oc.Kind, oc.Name)
+		if oc.Namespace != "" {
+			fmt.Fprintf(out, ".%s", oc.Namespace)
+		}
+		fmt.Fprintf(out, ": %s\n", oc.Description)
+		if oc.Diff != "" {
+			for _, line := range strings.SplitAfter(strings.TrimSuffix(oc.Diff, "\n"), "\n") {
+				fmt.Fprintf(out, "    %s", line)
+			}
+			fmt.Fprintln(out)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+// planConnector is a user daemon that returns the given plan from PlanIntercept, or that doesn't
+// implement PlanIntercept when the plan is nil.
+type planConnector struct {
+	connector.ConnectorClient
+	plan *connector.InterceptPlan
+}
+
+func (c *planConnector) PlanIntercept(context.Context, *connector.CreateInterceptRequest, ...grpc.CallOption) (*connector.InterceptPlan, error) {
+	if c.plan == nil {
+		return nil, grpcStatus.Error(grpcCodes.Unimplemented, "unknown method PlanIntercept")
+	}
+	return c.plan, nil
+}
+
+func Test_reportPlan(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	spec := &manager.InterceptSpec{
+		Name:         "echo",
+		Agent:        "echo",
+		Namespace:    "default",
+		WorkloadKind: "Deployment",
+		TargetHost:   "127.0.0.1",
+		TargetPort:   8080,
+		Mechanism:    "tcp",
+	}
+	plan := &connector.InterceptPlan{
+		Spec:        spec,
+		ServiceName: "echo",
+		ServicePort: "http",
+		Changes: []*connector.ObjectChange{{
+			Kind:        "Deployment",
+			Name:        "echo",
+			Namespace:   "default",
+			Verb:        "patch",
+			Description: "restart the pods so that the mutating webhook injects the traffic-agent",
+			Diff: "--- a/deployment/echo.default\n" +
+				"+++ b/deployment/echo.default\n" +
+				"@@ -1,2 +1,3 @@\n" +
+				"       annotations:\n" +
+				"+        telepresence.getambassador.io/restartedAt: \"2021-10-15T12:00:00Z\"\n",
+		}},
+	}
+
+	report := func(t *testing.T, plan *connector.InterceptPlan, output string) (string, error) {
+		out := &bytes.Buffer{}
+		cmd := &cobra.Command{}
+		cmd.SetOut(out)
+		is := &interceptState{
+			cmd:             safeCobraCommandImpl{Command: cmd},
+			args:            interceptArgs{name: "echo", dryRun: true, output: output},
+			connectorClient: &planConnector{plan: plan},
+		}
+		if output == "json" {
+			is.args.json = &jsonDocument{out: out}
+		}
+		err := is.reportPlan(ctx, &connector.CreateInterceptRequest{Spec: spec})
+		return out.String(), err
+	}
+
+	t.Run("changes", func(t *testing.T) {
+		out, err := report(t, plan, "")
+		require.NoError(t, err)
+		assert.Equal(t, `Intercept echo (dry run)
+    Workload   : Deployment echo.default
+    Service    : echo, port http
+    Destination: 127.0.0.1:8080
+    Mechanism  : tcp
+The following changes are made to the cluster:
+  patch Deployment echo.default: restart the pods so that the mutating webhook injects the traffic-agent
+    --- a/deployment/echo.default
+    +++ b/deployment/echo.default
+    @@ -1,2 +1,3 @@
+           annotations:
+    +        telepresence.getambassador.io/restartedAt: "2021-10-15T12:00:00Z"
+`, out)
+	})
+
+	t.Run("no changes", func(t *testing.T) {
+		out, err := report(t, &connector.InterceptPlan{Spec: spec, ServiceName: "echo", ServicePort: "80"}, "")
+		require.NoError(t, err)
+		assert.Contains(t, out, "The traffic-agent is in place, so the cluster isn't changed\n")
+	})
+
+	t.Run("json", func(t *testing.T) {
+		out, err := report(t, plan, "json")
+		require.NoError(t, err)
+		var decoded connector.InterceptPlan
+		require.NoError(t, json.Unmarshal([]byte(out), &decoded))
+		assert.Equal(t, "echo", decoded.ServiceName)
+		require.Len(t, decoded.Changes, 1)
+		assert.Equal(t, plan.Changes[0].Diff, decoded.Changes[0].Diff)
+	})
+
+	t.Run("missing permissions", func(t *testing.T) {
+		denied := &connector.InterceptPlan{
+			Spec:               spec,
+			Changes:            plan.Changes,
+			MissingPermissions: []string{"patch deployments.apps/echo in namespace default"},
+		}
+		out, err := report(t, denied, "")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "patch deployments.apps/echo in namespace default")
+		assert.Contains(t, out, "patch Deployment echo.default")
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		failed := &connector.InterceptPlan{Spec: spec, Failure: &connector.InterceptResult{
+			Error:     connector.InterceptError_FAILED_TO_ESTABLISH,
+			ErrorText: "found matching service with multiple ports",
+		}}
+		out, err := report(t, failed, "")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "found matching service with multiple ports")
+		assert.Empty(t, out)
+
+		out, err = report(t, failed, "json")
+		require.Error(t, err)
+		assert.Contains(t, out, "found matching service with multiple ports")
+	})
+
+	t.Run("old user daemon", func(t *testing.T) {
+		_, err := report(t, nil, "")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "too old")
+	})
+}
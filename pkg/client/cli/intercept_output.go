@@ -0,0 +1,210 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// jsonDocument is the stdout of a command that uses --output json. It gets exactly one JSON document, which
+// describes either the result of the command or why it failed.
+type jsonDocument struct {
+	out     io.Writer
+	written bool
+}
+
+func (d *jsonDocument) write(v interface{}) error {
+	d.written = true
+	enc := json.NewEncoder(d.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// writeError writes the document of the given error, and returns an *ExitCodeError that makes the CLI exit
+// with the exit code that the document tells, without repeating the error. The error is returned as is when
+// it's nil, or when a document has been written already, e.g. the plan of a --dry-run that tells why the
+// intercept can't be created.
+func (d *jsonDocument) writeError(err error) error {
+	if err == nil || d.written {
+		return err
+	}
+	code := ExitFailure
+	var codeErr *ExitCodeError
+	if errors.As(err, &codeErr) {
+		code = codeErr.Code
+	}
+	if werr := d.write(&errorDocument{Error: newErrorOutput(err), ExitCode: code}); werr != nil {
+		return err
+	}
+	return &ExitCodeError{Code: code}
+}
+
+// errorDocument is the JSON document of a command that failed.
+type errorDocument struct {
+	Error    *errorOutput `json:"error"`
+	ExitCode int          `json:"exit_code"`
+}
+
+// errorOutput describes an error. The reason is set for the errors that the user daemon reports for an
+// intercept, and the remediation when the error tells what can be done about it.
+type errorOutput struct {
+	Category    string `json:"category"`
+	Reason      string `json:"reason,omitempty"`
+	Message     string `json:"message"`
+	Remediation string `json:"remediation,omitempty"`
+}
+
+// The reasons of the intercept errors that scripts are most likely to act on. Other intercept errors have
+// the lower case name of their connector.InterceptError as their reason.
+const (
+	reasonAlreadyExists = "already_exists"
+	reasonConflict      = "conflict"
+	reasonTimeout       = "timeout"
+)
+
+func newErrorOutput(err error) *errorOutput {
+	eo := &errorOutput{
+		Category: errcat.GetCategory(err).String(),
+		Message:  err.Error(),
+	}
+	var ire *interceptResultError
+	if errors.As(err, &ire) {
+		eo.Reason = strings.ToLower(ire.result.Error.String())
+	}
+
+	// The user daemon appends the advice to the messages of conflicts and timeouts
+	msg := eo.Message
+	switch {
+	case eo.Reason == reasonAlreadyExists:
+		eo.Remediation = fmt.Sprintf(`use "telepresence leave %s" to end the existing intercept, or use another intercept name`,
+			ire.result.ErrorText)
+	case strings.Contains(msg, " is already intercepted by ") || strings.Contains(msg, "; use --steal "):
+		eo.Reason = reasonConflict
+		if i := strings.Index(msg, "; use "); i >= 0 {
+			eo.Message, eo.Remediation = msg[:i], msg[i+2:]
+		}
+	case strings.Contains(msg, " timed out"):
+		eo.Reason = reasonTimeout
+		if i := strings.Index(msg, ".  The current timeout "); i >= 0 {
+			eo.Message, eo.Remediation = msg[:i], msg[i+3:]
+		}
+	}
+	return eo
+}
+
+// interceptOutput is the JSON document of an intercept that was created. A local-only intercept has
+// nothing but its name, its namespace, and the local-only flag.
+type interceptOutput struct {
+	ID                string         `json:"id,omitempty"`
+	Name              string         `json:"name"`
+	LocalOnly         bool           `json:"local_only,omitempty"`
+	State             string         `json:"state,omitempty"`
+	Message           string         `json:"message,omitempty"`
+	Workload          string         `json:"workload,omitempty"`
+	WorkloadKind      string         `json:"workload_kind,omitempty"`
+	Namespace         string         `json:"namespace,omitempty"`
+	ServicePort       string         `json:"service_port,omitempty"`
+	Container         string         `json:"container,omitempty"`
+	ContainerPort     int32          `json:"container_port,omitempty"`
+	TargetHost        string         `json:"target_host,omitempty"`
+	TargetPort        int32          `json:"target_port,omitempty"`
+	Expires           string         `json:"expires,omitempty"`
+	PreviewURL        string         `json:"preview_url,omitempty"`
+	Ingress           *ingressOutput `json:"ingress,omitempty"`
+	EnvFile           string         `json:"env_file,omitempty"`
+	EnvJSON           string         `json:"env_json,omitempty"`
+	EnvFileUnfiltered string         `json:"env_file_unfiltered,omitempty"`
+	MountPoint        string         `json:"mount_point,omitempty"`
+	MountError        string         `json:"mount_error,omitempty"`
+}
+
+type ingressOutput struct {
+	Host   string `json:"host"`
+	Port   int32  `json:"port"`
+	UseTLS bool   `json:"use_tls"`
+	L5Host string `json:"l5_host,omitempty"`
+}
+
+// newInterceptOutput returns the JSON document of the given intercept. The files of the environment are
+// only written for an intercept that isn't pending, and the mountProblem, if any, tells why the remote
+// volumes weren't mounted.
+func newInterceptOutput(ii *manager.InterceptInfo, args *interceptArgs, mountProblem error) *interceptOutput {
+	spec := ii.Spec
+	out := &interceptOutput{
+		ID:            ii.Id,
+		Name:          spec.Name,
+		State:         ii.Disposition.String(),
+		Message:       ii.Message,
+		Workload:      spec.Agent,
+		WorkloadKind:  spec.WorkloadKind,
+		Namespace:     spec.Namespace,
+		ServicePort:   spec.ServicePortIdentifier,
+		Container:     spec.ContainerName,
+		ContainerPort: spec.ContainerPort,
+		TargetHost:    spec.TargetHost,
+		TargetPort:    spec.TargetPort,
+		PreviewURL:    previewURL(ii),
+		MountPoint:    spec.MountPoint,
+	}
+	if ii.Expires != nil {
+		out.Expires = ii.Expires.AsTime().UTC().Format(time.RFC3339)
+	}
+	if ingress := ii.GetPreviewSpec().GetIngress(); ingress != nil {
+		out.Ingress = &ingressOutput{Host: ingress.Host, Port: ingress.Port, UseTLS: ingress.UseTls, L5Host: ingress.L5Host}
+	}
+	if !interceptPending(ii) {
+		out.EnvFile = args.envFile
+		out.EnvJSON = args.envJSON
+		out.EnvFileUnfiltered = args.envFileUnfiltered
+	}
+	if out.MountPoint == "" && mountProblem != nil {
+		out.MountError = mountProblem.Error()
+	}
+	return out
+}
+
+// newLocalOnlyOutput returns the JSON document of the local-only intercept of the given args.
+func newLocalOnlyOutput(args *interceptArgs) *interceptOutput {
+	return &interceptOutput{
+		Name:      args.name,
+		LocalOnly: true,
+		Namespace: args.namespace,
+	}
+}
+
+// previewURL returns the URL of the preview domain of the given intercept, or an empty string when it
+// has none.
+func previewURL(ii *manager.InterceptInfo) string {
+	url := ii.PreviewDomain
+	if url == "" {
+		return ""
+	}
+	// Right now SystemA gives back domains with the leading "https://", but
+	// let's not rely on that.
+	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+		url = "https://" + url
+	}
+	return url
+}
+
+// interceptResultError is the error of an intercept result. It keeps the result so that the JSON output
+// can tell the reason of the error.
+type interceptResultError struct {
+	result *connector.InterceptResult
+	err    error
+}
+
+func (e *interceptResultError) Error() string {
+	return e.err.Error()
+}
+
+func (e *interceptResultError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,186 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+func Test_interceptOutput(t *testing.T) {
+	spec := func() *manager.InterceptSpec {
+		return &manager.InterceptSpec{
+			Name:                  "echo",
+			Agent:                 "echo",
+			WorkloadKind:          "Deployment",
+			Namespace:             "default",
+			ServicePortIdentifier: "http",
+			ContainerName:         "echo",
+			ContainerPort:         8080,
+			TargetHost:            "127.0.0.1",
+			TargetPort:            8080,
+			Mechanism:             "tcp",
+		}
+	}
+	expires := timestamppb.New(time.Date(2022, 3, 1, 13, 0, 0, 0, time.UTC))
+	envArgs := interceptArgs{name: "echo", envFile: "echo.env", envJSON: "echo.json"}
+
+	// Successful intercepts are compared to the JSON of their document, failures to the JSON of the
+	// error document and to the exit code.
+	tests := []struct {
+		name         string
+		ii           *manager.InterceptInfo
+		args         interceptArgs
+		mountProblem error
+		result       *connector.InterceptResult
+		exitCode     int
+	}{
+		{
+			name: "success",
+			ii: func() *manager.InterceptInfo {
+				s := spec()
+				s.MountPoint = "/tmp/telfs-123"
+				return &manager.InterceptInfo{
+					Id:          "0b9a6b9c-3e8a-4d0b-9a5c-5f3c0d4c7e8f:echo",
+					Spec:        s,
+					Disposition: manager.InterceptDispositionType_ACTIVE,
+					Expires:     expires,
+				}
+			}(),
+			args: envArgs,
+		},
+		{
+			name: "preview",
+			ii: &manager.InterceptInfo{
+				Id:            "0b9a6b9c-3e8a-4d0b-9a5c-5f3c0d4c7e8f:echo",
+				Spec:          spec(),
+				Disposition:   manager.InterceptDispositionType_ACTIVE,
+				PreviewDomain: "gallant-hertz-1234.preview.edgestack.me",
+				PreviewSpec: &manager.PreviewSpec{Ingress: &manager.IngressInfo{
+					Host:   "ambassador.ambassador",
+					Port:   443,
+					UseTls: true,
+					L5Host: "echo.example.com",
+				}},
+			},
+			args:         interceptArgs{name: "echo"},
+			mountProblem: errors.New("sshfs is not installed on your local machine"),
+		},
+		{
+			name: "pending",
+			ii: &manager.InterceptInfo{
+				Id:          "0b9a6b9c-3e8a-4d0b-9a5c-5f3c0d4c7e8f:echo",
+				Spec:        spec(),
+				Disposition: manager.InterceptDispositionType_WAITING,
+				Message:     "waiting for a traffic-agent",
+			},
+			args: envArgs,
+		},
+		{
+			name: "local-only",
+			args: interceptArgs{name: "mylocal", namespace: "dev", localOnly: true},
+		},
+		{
+			name: "conflict",
+			result: &connector.InterceptResult{
+				Error:         connector.InterceptError_TRAFFIC_MANAGER_ERROR,
+				ErrorCategory: int32(errcat.User),
+				ErrorText: `echo.default is already intercepted by alice@laptop since 10:42 (all traffic); ` +
+					`use --steal to end intercept "echo"`,
+			},
+			exitCode: ExitFailure,
+		},
+		{
+			name: "timeout",
+			result: &connector.InterceptResult{
+				Error: connector.InterceptError_FAILED_TO_ESTABLISH,
+				ErrorText: `the intercept timed out.  The current timeout 30s can be configured as "timeouts.intercept" in ` +
+					`"/home/alice/.config/telepresence/config.yml"`,
+			},
+			exitCode: ExitFailure,
+		},
+		{
+			name: "already-exists",
+			result: &connector.InterceptResult{
+				Error:         connector.InterceptError_ALREADY_EXISTS,
+				ErrorCategory: int32(errcat.User),
+				ErrorText:     "echo",
+			},
+			exitCode: ExitAlreadyExists,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			doc := &jsonDocument{out: out}
+			switch {
+			case tt.result != nil:
+				err := doc.writeError(interceptMessage(tt.result))
+				var codeErr *ExitCodeError
+				require.True(t, errors.As(err, &codeErr))
+				assert.NoError(t, codeErr.Err, "the JSON document tells what went wrong")
+				assert.Equal(t, tt.exitCode, codeErr.Code)
+			case tt.ii != nil:
+				require.NoError(t, doc.write(newInterceptOutput(tt.ii, &tt.args, tt.mountProblem)))
+			default:
+				require.NoError(t, doc.write(newLocalOnlyOutput(&tt.args)))
+			}
+			expected, err := os.ReadFile(filepath.Join("testdata", "intercept_output", tt.name+".json"))
+			require.NoError(t, err)
+			assert.JSONEq(t, string(expected), out.String())
+		})
+	}
+}
+
+func Test_jsonDocument_writeError(t *testing.T) {
+	doc := &jsonDocument{out: &bytes.Buffer{}}
+	assert.NoError(t, doc.writeError(nil))
+	assert.False(t, doc.written)
+
+	// An error that occurs after the document was written, such as the failure of a --dry-run that the
+	// plan tells, is returned as is
+	require.NoError(t, doc.write(&connector.InterceptPlan{}))
+	err := errcat.User.New("boom")
+	assert.Equal(t, err, doc.writeError(err))
+}
+
+func Test_interceptCommand_output(t *testing.T) {
+	ctx := newTestContext(t)
+	run := func(t *testing.T, args ...string) (string, error) {
+		t.Helper()
+		cmd := interceptCommand(ctx)
+		cmd.SilenceErrors = true
+		cmd.SilenceUsage = true
+		stdout := &bytes.Buffer{}
+		cmd.SetOut(stdout)
+		cmd.SetErr(&bytes.Buffer{})
+		cmd.SetArgs(args)
+		err := cmd.ExecuteContext(ctx)
+		return stdout.String(), err
+	}
+
+	// Also the errors of the flags are written as a JSON document
+	out, err := run(t, "echo", "--output", "json", "--", "echo", "hello")
+	var codeErr *ExitCodeError
+	require.True(t, errors.As(err, &codeErr))
+	assert.Equal(t, ExitFailure, codeErr.Code)
+	assert.JSONEq(t, `{
+  "error": {"category": "user", "message": "--output cannot be used with a command, --docker-run, or --extend"},
+  "exit_code": 1
+}`, out)
+
+	out, err = run(t, "echo", "--output", "yaml")
+	assert.EqualError(t, err, `unsupported output format "yaml"`)
+	assert.Empty(t, out)
+}
@@ -0,0 +1,320 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// runSpec is the content of a file given to "telepresence run --file". The format is described in
+// the help of that command. The fields of an intercept correspond to the flags of "telepresence
+// intercept".
+type runSpec struct {
+	Connection connectionSpec   `json:"connection"`
+	Intercepts []*interceptSpec `json:"intercepts"`
+}
+
+type connectionSpec struct {
+	Context   string `json:"context,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type interceptSpec struct {
+	Name           string   `json:"name"`
+	Workload       string   `json:"workload"`
+	Namespace      string   `json:"namespace,omitempty"`
+	Service        string   `json:"service,omitempty"`
+	Ports          []string `json:"ports"`
+	Mount          string   `json:"mount"`
+	MountType      string   `json:"mountType,omitempty"`
+	EnvFile        string   `json:"envFile,omitempty"`
+	EnvJSON        string   `json:"envJSON,omitempty"`
+	ToPod          []string `json:"toPod,omitempty"`
+	HTTPHeaders    []string `json:"httpHeaders,omitempty"`
+	HTTPPathPrefix string   `json:"httpPathPrefix,omitempty"`
+	Replace        bool     `json:"replace,omitempty"`
+	Mechanism      string   `json:"mechanism,omitempty"`
+	Handler        []string `json:"handler,omitempty"`
+
+	mountSet bool // whether the mount was given explicitly
+	line     int  // the line in the spec file where the intercept is declared
+}
+
+// loadRunSpec reads and validates the given spec file.
+func loadRunSpec(path string) (*runSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errcat.User.New(err)
+	}
+	return parseRunSpec(path, data)
+}
+
+// yamlLineRx matches the line number that the yaml parser includes in its errors.
+var yamlLineRx = regexp.MustCompile(`^yaml: line (\d+): `)
+
+// parseRunSpec parses and validates the content of the given spec file. Errors are prefixed with
+// the file name and the line of the offending element.
+func parseRunSpec(file string, data []byte) (*runSpec, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		msg := err.Error()
+		if m := yamlLineRx.FindStringSubmatch(msg); m != nil {
+			return nil, errcat.User.Newf("%s:%s: %s", file, m[1], msg[len(m[0]):])
+		}
+		return nil, errcat.User.Newf("%s: %s", file, strings.TrimPrefix(msg, "yaml: "))
+	}
+	if len(doc.Content) == 0 {
+		return nil, errcat.User.Newf("%s: the spec is empty", file)
+	}
+	p := specParser{file: file, dir: filepath.Dir(file)}
+	spec := &runSpec{}
+	var interceptsKey, intercepts *yaml.Node
+	err := p.fields(doc.Content[0], "the spec", func(k, v *yaml.Node) (err error) {
+		switch k.Value {
+		case "connection":
+			err = p.fields(v, "connection", func(k, v *yaml.Node) (err error) {
+				switch key := k.Value; key {
+				case "context":
+					spec.Connection.Context, err = p.str(v, key)
+				case "namespace":
+					spec.Connection.Namespace, err = p.str(v, key)
+				default:
+					err = p.errorf(k, "unknown field %q in connection", key)
+				}
+				return err
+			})
+		case "intercepts":
+			interceptsKey, intercepts = k, v
+		default:
+			err = p.errorf(k, "unknown field %q", k.Value)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The intercepts are parsed last, because their namespace defaults to the one of the connection
+	if intercepts == nil {
+		return nil, p.errorf(doc.Content[0], "the spec must have intercepts")
+	}
+	if intercepts.Kind != yaml.SequenceNode || len(intercepts.Content) == 0 {
+		return nil, p.errorf(interceptsKey, "intercepts must be a non-empty list")
+	}
+	lines := make(map[string]int)
+	for _, n := range intercepts.Content {
+		ic, err := p.intercept(n, spec.Connection.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		if line, ok := lines[ic.Name]; ok {
+			return nil, p.errorf(n, "intercept %q is already declared on line %d", ic.Name, line)
+		}
+		lines[ic.Name] = ic.line
+		spec.Intercepts = append(spec.Intercepts, ic)
+	}
+	return spec, nil
+}
+
+type specParser struct {
+	file string
+	dir  string
+}
+
+func (p *specParser) errorf(n *yaml.Node, format string, args ...interface{}) error {
+	return errcat.User.Newf("%s:%d: "+format, append([]interface{}{p.file, n.Line}, args...)...)
+}
+
+// fields calls f with each key and value of the given mapping node. Errors that concern a field as
+// a whole refer to the line of the key, and errors that concern a value to the line of the value.
+func (p *specParser) fields(n *yaml.Node, what string, f func(k, v *yaml.Node) error) error {
+	if n.Kind != yaml.MappingNode {
+		return p.errorf(n, "%s must be a mapping", what)
+	}
+	seen := make(map[string]bool, len(n.Content)/2)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		if seen[k.Value] {
+			return p.errorf(k, "field %q is repeated", k.Value)
+		}
+		seen[k.Value] = true
+		if err := f(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *specParser) str(n *yaml.Node, key string) (string, error) {
+	if n.Kind != yaml.ScalarNode || n.Tag == "!!null" {
+		return "", p.errorf(n, "%s must be a string", key)
+	}
+	return n.Value, nil
+}
+
+func (p *specParser) boolean(n *yaml.Node, key string) (bool, error) {
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!bool" {
+		if b, err := strconv.ParseBool(n.Value); err == nil {
+			return b, nil
+		}
+	}
+	return false, p.errorf(n, "%s must be true or false", key)
+}
+
+// strList returns the elements of a list of strings. A single string is a list with one element.
+func (p *specParser) strList(n *yaml.Node, key string) ([]string, error) {
+	if n.Kind == yaml.ScalarNode && n.Tag != "!!null" {
+		return []string{n.Value}, nil
+	}
+	if n.Kind != yaml.SequenceNode {
+		return nil, p.errorf(n, "%s must be a list of strings", key)
+	}
+	l := make([]string, len(n.Content))
+	for i, e := range n.Content {
+		if e.Kind != yaml.ScalarNode || e.Tag == "!!null" {
+			return nil, p.errorf(e, "%s must be a list of strings", key)
+		}
+		l[i] = e.Value
+	}
+	return l, nil
+}
+
+// path returns the given path relative to the directory of the spec file.
+func (p *specParser) path(n *yaml.Node, key string) (string, error) {
+	path, err := p.str(n, key)
+	if err != nil || path == "" || filepath.IsAbs(path) {
+		return path, err
+	}
+	return filepath.Join(p.dir, path), nil
+}
+
+// intercept parses and validates one element of the intercepts list.
+func (p *specParser) intercept(n *yaml.Node, namespace string) (*interceptSpec, error) {
+	ic := &interceptSpec{Namespace: namespace, Ports: []string{"8080"}, Mount: "true", line: n.Line}
+	var nameNode, portsNode, toPodNode, headersNode, prefixNode *yaml.Node
+	err := p.fields(n, "an intercept", func(k, v *yaml.Node) (err error) {
+		switch key := k.Value; key {
+		case "name":
+			nameNode = v
+			ic.Name, err = p.str(v, key)
+		case "workload":
+			ic.Workload, err = p.str(v, key)
+		case "namespace":
+			ic.Namespace, err = p.str(v, key)
+		case "service":
+			ic.Service, err = p.str(v, key)
+		case "ports":
+			portsNode = k
+			ic.Ports, err = p.strList(v, key)
+		case "mount":
+			ic.mountSet = true
+			if ic.Mount, err = p.str(v, key); err == nil && v.Tag != "!!bool" {
+				// On windows, the mount point is a drive letter
+				if runtime.GOOS != "windows" {
+					ic.Mount, err = p.path(v, key)
+				}
+			}
+		case "mountType":
+			if ic.MountType, err = p.str(v, key); err == nil {
+				switch ic.MountType {
+				case mountTypeAuto, mountTypeSSHFS, mountTypeSFTP:
+				default:
+					err = p.errorf(v, `mountType must be "auto", "sshfs", or "sftp"`)
+				}
+			}
+		case "envFile":
+			ic.EnvFile, err = p.path(v, key)
+		case "envJSON":
+			ic.EnvJSON, err = p.path(v, key)
+		case "toPod":
+			toPodNode = k
+			ic.ToPod, err = p.strList(v, key)
+		case "httpHeaders":
+			headersNode = k
+			ic.HTTPHeaders, err = p.strList(v, key)
+		case "httpPathPrefix":
+			prefixNode = k
+			ic.HTTPPathPrefix, err = p.str(v, key)
+		case "replace":
+			ic.Replace, err = p.boolean(v, key)
+		case "mechanism":
+			ic.Mechanism, err = p.str(v, key)
+		case "handler":
+			if v.Kind != yaml.SequenceNode || len(v.Content) == 0 {
+				return p.errorf(v, "handler must be a non-empty list with the command and its arguments")
+			}
+			ic.Handler, err = p.strList(v, key)
+		default:
+			err = p.errorf(k, "unknown field %q in intercept", key)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if nameNode == nil {
+		return nil, p.errorf(n, "an intercept must have a name")
+	}
+	if errs := validation.IsDNS1123Label(ic.Name); len(errs) > 0 {
+		return nil, p.errorf(nameNode, "invalid intercept name %q: %s", ic.Name, strings.Join(errs, ", "))
+	}
+	if ic.Workload == "" {
+		ic.Workload = ic.Name
+	}
+	if portsNode != nil {
+		if err = validatePorts(ic.Ports); err != nil {
+			return nil, p.errorf(portsNode, "ports: %w", err)
+		}
+	}
+	for _, tp := range ic.ToPod {
+		portStr, _, err := splitProtocol(tp)
+		if err == nil {
+			_, err = parsePort(portStr)
+		}
+		if err != nil {
+			return nil, p.errorf(toPodNode, "toPod: %w", err)
+		}
+	}
+	if _, err = parseHTTPHeaderMatches(ic.HTTPHeaders); err != nil {
+		return nil, p.errorf(headersNode, "httpHeaders: %w", err)
+	}
+	if _, err = parseHTTPPathPrefix(ic.HTTPPathPrefix); err != nil {
+		return nil, p.errorf(prefixNode, "httpPathPrefix: %w", err)
+	}
+	if ic.Replace && (len(ic.HTTPHeaders) > 0 || ic.HTTPPathPrefix != "") {
+		return nil, p.errorf(n, "an intercept with httpHeaders or httpPathPrefix cannot replace the container")
+	}
+	return ic, nil
+}
+
+// validatePorts validates ports the same way as the --port flags of an intercept. The first port
+// is the one given by the intercept's local port and service port identifier, and each port that
+// follows intercepts another port of the service and must be given as <local-port>:<svcPortIdentifier>.
+func validatePorts(ports []string) error {
+	if len(ports) == 0 {
+		return errcat.User.New("at least one port must be given")
+	}
+	portStr, _, err := splitProtocol(ports[0])
+	if err != nil {
+		return err
+	}
+	portMapping := strings.Split(portStr, ":")
+	if len(portMapping) > 2 {
+		return errcat.User.New("ports must be of the format <local-port>[:<svcPortIdentifier>][/<protocol>]")
+	}
+	port, err := parsePort(portMapping[0])
+	if err != nil {
+		return err
+	}
+	_, err = parseExtraPortMappings(port, ports[1:])
+	return err
+}
@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseRunSpec(t *testing.T) {
+	// Valid specs are compared to the JSON of the parsed spec, invalid ones to the error message
+	specs, err := filepath.Glob(filepath.Join("testdata", "spec", "*.yaml"))
+	require.NoError(t, err)
+	require.NotEmpty(t, specs)
+	for _, file := range specs {
+		file := filepath.ToSlash(file)
+		name := strings.TrimSuffix(filepath.Base(file), ".yaml")
+		t.Run(name, func(t *testing.T) {
+			spec, err := loadRunSpec(file)
+			golden := strings.TrimSuffix(file, ".yaml")
+			if expected, readErr := os.ReadFile(golden + ".err"); readErr == nil {
+				require.Error(t, err)
+				assert.Equal(t, strings.TrimSpace(string(expected)), filepath.ToSlash(err.Error()))
+				return
+			}
+			require.NoError(t, err)
+			expected, err := os.ReadFile(golden + ".json")
+			require.NoError(t, err)
+			actual, err := json.Marshal(spec)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(expected), filepath.ToSlash(string(actual)))
+		})
+	}
+}
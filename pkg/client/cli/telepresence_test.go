@@ -702,7 +702,7 @@ func (cs *connectedSuite) TestJ_ListOnlyMapped() {
 
 	stdout, stderr = telepresence(cs.T(), "list", "--namespace", cs.ns())
 	require.Empty(stderr)
-	require.Contains(stdout, "No Workloads (Deployments, StatefulSets, or ReplicaSets)")
+	require.Contains(stdout, "No Workloads (Deployments, StatefulSets, ReplicaSets, or Rollouts)")
 
 	stdout, stderr = telepresence(cs.T(), "connect", "--mapped-namespaces", "all")
 	require.Empty(stderr)
@@ -710,7 +710,7 @@ func (cs *connectedSuite) TestJ_ListOnlyMapped() {
 
 	stdout, stderr = telepresence(cs.T(), "list", "--namespace", cs.ns())
 	require.Empty(stderr)
-	require.NotContains(stdout, "No Workloads (Deployments, StatefulSets, or ReplicaSets)")
+	require.NotContains(stdout, "No Workloads (Deployments, StatefulSets, ReplicaSets, or Rollouts)")
 }
 
 func (cs *connectedSuite) TestK_DockerRun() {
@@ -787,7 +787,7 @@ func (cs *connectedSuite) TestL_LegacySwapDeploymentDoesIntercept() {
 		if stderr != "" {
 			return false
 		}
-		return strings.Contains(stdout, "No Workloads (Deployments, StatefulSets, or ReplicaSets)")
+		return strings.Contains(stdout, "No Workloads (Deployments, StatefulSets, ReplicaSets, or Rollouts)")
 	},
 		10*time.Second,
 		1*time.Second,
@@ -1054,7 +1054,7 @@ func (cs *connectedSuite) TestZ_Uninstall() {
 		require.Eventually(
 			func() bool {
 				stdout, _ := telepresence(cs.T(), "list", "--namespace", cs.ns(), "--agents")
-				return stdout == "No Workloads (Deployments, StatefulSets, or ReplicaSets)"
+				return stdout == "No Workloads (Deployments, StatefulSets, ReplicaSets, or Rollouts)"
 			},
 			30*time.Second,     // waitFor
 			2*time.Millisecond, // polling interval
@@ -1344,7 +1344,7 @@ func (is *interceptedSuite) TestE_RestartInterceptedPod() {
 		stdout, _ := telepresence(is.T(), "--namespace", is.ns(), "list")
 		if match := rx.FindStringSubmatch(stdout); match != nil {
 			dlog.Infof(c, "Got match '%s'", match[1])
-			return match[1] == "WAITING" || strings.Contains(match[1], `No agent found for "hello-0"`)
+			return strings.HasPrefix(match[1], "pending")
 		}
 		return false
 	}, 15*time.Second, time.Second)
@@ -42,6 +42,12 @@ func newUpdateChecker(ctx context.Context, url string) (*updateChecker, error) {
 	return ts, nil
 }
 
+// downloadURL returns the URL that the releases of the telepresence binary for the current os/arch are
+// downloaded from.
+func downloadURL(ctx context.Context) string {
+	return fmt.Sprintf("https://%s/download/tel2/%s/%s", client.GetConfig(ctx).Cloud.SystemaHost, runtime.GOOS, runtime.GOARCH)
+}
+
 func updateCheckIfDue(cmd *cobra.Command, _ []string) error {
 	return updateCheck(cmd, false)
 }
@@ -57,8 +63,7 @@ func forcedUpdateCheck(cmd *cobra.Command, _ []string) error {
 //   cmd:         the command that provides Context and stout/stderr
 //   forcedCheck: if true, perform check regardless of if it's due or not
 func updateCheck(cmd *cobra.Command, forceCheck bool) error {
-	cloudCfg := client.GetConfig(cmd.Context()).Cloud
-	uc, err := newUpdateChecker(cmd.Context(), fmt.Sprintf("https://%s/download/tel2/%s/%s/stable.txt", cloudCfg.SystemaHost, runtime.GOOS, runtime.GOARCH))
+	uc, err := newUpdateChecker(cmd.Context(), downloadURL(cmd.Context())+"/stable.txt")
 	if err != nil || !(forceCheck || uc.timeToCheck()) {
 		return err
 	}
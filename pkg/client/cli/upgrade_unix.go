@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package cli
+
+import (
+	"os"
+)
+
+// replaceExecutable atomically replaces the executable with the given file, which must be in the same
+// directory. The running process keeps using the replaced executable.
+func replaceExecutable(exe, newExe string) error {
+	return os.Rename(newExe, exe)
+}
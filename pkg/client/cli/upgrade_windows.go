@@ -0,0 +1,27 @@
+package cli
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// replaceExecutable replaces the executable with the given file, which must be in the same directory. A
+// running executable can't be overwritten or removed on Windows, but it can be renamed, so it's moved
+// aside first, and moved back if the new file can't take its place. The old executable is removed when
+// the system restarts, or by the next upgrade.
+func replaceExecutable(exe, newExe string) error {
+	old := exe + ".old"
+	_ = os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err := os.Rename(newExe, exe); err != nil {
+		_ = os.Rename(old, exe)
+		return err
+	}
+	if oldPtr, err := windows.UTF16PtrFromString(old); err == nil {
+		_ = windows.MoveFileEx(oldPtr, nil, windows.MOVEFILE_DELAY_UNTIL_REBOOT)
+	}
+	return nil
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -11,8 +14,15 @@ import (
 	"github.com/datawire/dlib/dcontext"
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
 	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+	"github.com/telepresenceio/telepresence/v2/pkg/routing"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 )
 
 func kubeFlagMap() map[string]string {
@@ -25,17 +35,172 @@ func kubeFlagMap() map[string]string {
 	return kubeFlagMap
 }
 
+// sessionContext returns the kubernetes context given with --context, which selects the session of
+// the user daemon that a command is for. It's empty when the flag isn't given, and the user daemon
+// then uses its only session.
+func sessionContext() string {
+	if kubeFlags == nil {
+		return ""
+	}
+	return kubeFlagMap()["context"]
+}
+
+// withManager is like cliutil.WithManager, but the calls to the traffic-manager are made for the session
+// of the kubernetes context given with --context.
+func withManager(ctx context.Context, fn func(context.Context, manager.ManagerClient) error) error {
+	return cliutil.WithManager(client.WithSessionContext(ctx, sessionContext()), fn)
+}
+
+// kubePathFlags are the kubernetes flags with a file or directory value.
+var kubePathFlags = []string{"kubeconfig", "certificate-authority", "client-certificate", "client-key", "cache-dir"}
+
+// connectorKubeFlagMap returns the kubernetes flags to send to the connector. The connector doesn't share
+// the working directory and environment of the CLI, so relative paths are made absolute, and the kubeconfig
+// that the CLI would use is always passed explicitly. A connector that runs in docker mode uses the
+// kubeconfig that is mounted into its container, and a connector on Windows that serves the CLI in WSL gets
+// the paths in Windows form.
+func connectorKubeFlagMap(ctx context.Context) map[string]string {
+	flagMap := kubeFlagMap()
+	if cliutil.GetDockerDaemon(ctx) != nil {
+		delete(flagMap, "kubeconfig")
+		return flagMap
+	}
+	if _, ok := flagMap["kubeconfig"]; !ok {
+		if kc := defaultKubeconfig(ctx); kc != "" {
+			flagMap["kubeconfig"] = kc
+		}
+	}
+	for _, name := range kubePathFlags {
+		if path, ok := flagMap[name]; ok && path != "" && !filepath.IsAbs(path) {
+			if abs, err := filepath.Abs(path); err == nil {
+				flagMap[name] = abs
+			}
+		}
+	}
+	if wd := cliutil.GetWindowsDaemons(ctx); wd != nil {
+		paths := cliutil.NewWSLPaths(wd.Distro)
+		for _, name := range kubePathFlags {
+			if path, ok := flagMap[name]; ok && path != "" {
+				if winPath, err := paths.ToWindows(path); err == nil {
+					flagMap[name] = winPath
+				}
+			}
+		}
+	}
+	return flagMap
+}
+
+// defaultKubeconfig returns the kubeconfig file that is used when no --kubeconfig flag is given. An
+// empty string is returned when the KUBECONFIG environment variable lists several files, because
+// they can't be passed as one flag. The connector then merges the files of its own environment.
+func defaultKubeconfig(ctx context.Context) string {
+	if env := os.Getenv("KUBECONFIG"); env != "" {
+		var paths []string
+		for _, path := range filepath.SplitList(env) {
+			if path != "" {
+				paths = append(paths, path)
+			}
+		}
+		if len(paths) == 1 {
+			return paths[0]
+		}
+		return ""
+	}
+	home, err := filelocation.UserHomeDir(ctx)
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".kube", "config")
+}
+
+// applyKubeFlags sets the kubernetes flags given as NAME=VALUE pairs using the generic --kubeflag flag.
+func applyKubeFlags(pairs []string) error {
+	for _, pair := range pairs {
+		eq := strings.IndexByte(pair, '=')
+		if eq <= 0 {
+			return errcat.User.Newf("--kubeflag %q is not in the form NAME=VALUE", pair)
+		}
+		name := strings.TrimPrefix(pair[:eq], "--")
+		if kubeFlags.Lookup(name) == nil {
+			return errcat.User.Newf("--kubeflag %q: unknown kubernetes flag %q", pair, name)
+		}
+		if err := kubeFlags.Set(name, pair[eq+1:]); err != nil {
+			return errcat.User.Newf("--kubeflag %q: %w", pair, err)
+		}
+	}
+	return nil
+}
+
 // withConnector is like cliutil.WithConnector, but also
 //
-//  - Ensures that the damon is running too
+//  - Ensures that the damon is running too, unless the daemons run in docker mode or on Windows, or the
+//    proxy mode is "ports" or "socks5"
 //
 //  - Cleans up after itself if !retain (If it launches the daemon or connector, then it will shut
 //    them down when it's done.  If they were already running, it will leave them running.)
 //
-//  - Makes the connector.Connect gRPC call to set up networking
+//  - Reports if the previous session was disconnected due to idle timeout, and asks whether to reconnect
+//
+//  - Makes the connector.ConnectStream gRPC call to set up networking, and shows its progress
+//
+//  - Never prompts when the command uses --output json, and then reports to stderr so that stdout is
+//    left to the JSON document
 func withConnector(cmd *cobra.Command, retain bool, f func(context.Context, connector.ConnectorClient, *connector.ConnectInfo) error) error {
-	return cliutil.WithDaemon(cmd.Context(), dnsIP, func(ctx context.Context, daemonClient daemon.DaemonClient) (err error) {
+	if err := checkIdleDisconnect(cmd); err != nil {
+		return err
+	}
+	ctx := cmd.Context()
+	out := cmd.OutOrStdout()
+	if jsonOutput(cmd) {
+		ctx = proc.WithoutPrompts(ctx, "--output json is used")
+		out = cmd.ErrOrStderr()
+	}
+	ctx = cliutil.WithStdout(ctx, out)
+	if !noUpgradeDaemons {
+		if err := cliutil.QuitOutdatedDaemons(ctx, out); err != nil {
+			return err
+		}
+	}
+	pw := newConnectProgressWriter(out)
+	dd, err := cliutil.DockerDaemon(ctx)
+	if err != nil {
+		return err
+	}
+	wd, err := cliutil.WindowsDaemons(ctx)
+	if err != nil {
+		return err
+	}
+	if dd != nil || wd != nil {
+		// The root daemon runs in the same container as the connector, or was launched on Windows by
+		// the process that runs the connector there
+		return cliutil.WithConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+			connInfo, err := setConnectInfo(ctx, out, pw)
+			if err != nil {
+				return err
+			}
+			return f(ctx, connectorClient, connInfo)
+		})
+	}
+	if usesUserSpaceProxy(ctx) {
+		// There's no root daemon in the "ports" and "socks5" proxy modes
+		return cliutil.WithConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) (err error) {
+			if cliutil.DidLaunchConnector(ctx) {
+				defer func() {
+					if err != nil || !retain {
+						_ = cliutil.QuitConnector(dcontext.WithoutCancel(ctx))
+					}
+				}()
+			}
+			connInfo, err := setConnectInfo(ctx, out, pw)
+			if err != nil {
+				return err
+			}
+			return f(ctx, connectorClient, connInfo)
+		})
+	}
+	return cliutil.WithDaemon(ctx, dnsIP, func(ctx context.Context, daemonClient daemon.DaemonClient) (err error) {
 		if cliutil.DidLaunchDaemon(ctx) {
+			pw.report(&connector.ConnectProgress{Step: client.ConnectStepLaunchDaemon, State: connector.ConnectProgress_DONE})
 			defer func() {
 				if err != nil || !retain {
 					_ = cliutil.QuitDaemon(dcontext.WithoutCancel(ctx))
@@ -53,7 +218,7 @@ func withConnector(cmd *cobra.Command, retain bool, f func(context.Context, conn
 					}
 				}()
 			}
-			connInfo, err := setConnectInfo(ctx, cmd.OutOrStdout())
+			connInfo, err := setConnectInfo(ctx, out, pw)
 			if err != nil {
 				return err
 			}
@@ -62,14 +227,56 @@ func withConnector(cmd *cobra.Command, retain bool, f func(context.Context, conn
 	})
 }
 
-func setConnectInfo(ctx context.Context, stdout io.Writer) (*connector.ConnectInfo, error) {
+// jsonOutput returns true if the given command has an --output flag that asks for JSON. Such a command
+// writes nothing but its JSON document to stdout, and never prompts.
+func jsonOutput(cmd *cobra.Command) bool {
+	flag := cmd.Flags().Lookup("output")
+	return flag != nil && flag.Value.String() == "json"
+}
+
+// usesUserSpaceProxy returns true if the connect uses the "ports" or the "socks5" proxy mode, which need no
+// root daemon, either because it's requested, or because the session that the connect is for uses it.
+func usesUserSpaceProxy(ctx context.Context) bool {
+	isUserSpace := func(mode string) bool {
+		return mode == client.ProxyModePorts || mode == client.ProxyModeSOCKS5
+	}
+	if proxyMode != "" {
+		return isUserSpace(proxyMode)
+	}
+	userSpace := false
+	_ = cliutil.WithStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+		ci, err := connectorClient.Status(ctx, &connector.ConnectRequest{
+			KubeFlags:        connectorKubeFlagMap(ctx),
+			ManagerNamespace: managerNamespace,
+		})
+		if err == nil {
+			userSpace = isUserSpace(ci.ProxyMode)
+		}
+		return nil
+	})
+	return userSpace
+}
+
+func setConnectInfo(ctx context.Context, stdout io.Writer, pw *connectProgressWriter) (*connector.ConnectInfo, error) {
+	ctx, span := tracing.StartSpan(ctx, "connect")
 	var resp *connector.ConnectInfo
 	err := cliutil.WithStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
 		var err error
-		resp, err = connectorClient.Connect(ctx, &connector.ConnectRequest{
-			KubeFlags:        kubeFlagMap(),
+		resp, err = connectWithProgress(ctx, connectorClient, &connector.ConnectRequest{
+			KubeFlags:        connectorKubeFlagMap(ctx),
 			MappedNamespaces: mappedNamespaces,
-		})
+			IncludeSuffixes:  dnsIncludeSuffixes,
+			ExcludeSuffixes:  dnsExcludeSuffixes,
+			AlsoProxy:        alsoProxy.toRPC(),
+			NeverProxy:       neverProxy.toRPC(),
+			ManagerNamespace: managerNamespace,
+			ManagerValues:    managerValues,
+			ProxyMode:        proxyMode,
+			HostsFile:        hostsFile,
+			ForceTunnel:      forceTunnel,
+			Socks5Address:    socks5Address,
+		}, pw.report)
+		pw.done()
 		if err != nil {
 			return err
 		}
@@ -79,6 +286,30 @@ func setConnectInfo(ctx context.Context, stdout io.Writer) (*connector.ConnectIn
 		switch resp.Error {
 		case connector.ConnectInfo_UNSPECIFIED:
 			fmt.Fprintf(stdout, "Connected to context %s (%s)\n", resp.ClusterContext, resp.ClusterServer)
+			switch resp.ProxyMode {
+			case client.ProxyModePorts:
+				fmt.Fprintf(stdout, "Using proxy mode %s: %s. Use \"telepresence status\" to see the proxied ports\n",
+					client.ProxyModePorts, client.ProxyPortsLimitations)
+			case client.ProxyModeSOCKS5:
+				fmt.Fprintf(stdout, "Using proxy mode %s: the SOCKS5 proxy listens on %s; %s\n",
+					client.ProxyModeSOCKS5, resp.Socks5Address, client.ProxySOCKS5Limitations)
+			}
+			if rs := resp.RoutingStrategy; rs.GetStrategy() == client.RoutingStrategyDockerNetwork {
+				fmt.Fprintf(stdout, "Routing the cluster subnets via the docker network of the %s cluster. Use --force-tunnel to "+
+					"route them through the traffic-manager instead\n", rs.Provider)
+			}
+			for _, rc := range resp.SubnetConflicts {
+				c := routing.ConflictFromRPC(rc)
+				if c.Severity == routing.Error {
+					fmt.Fprintf(stdout, "%s. The subnet is not routed unless it is added to allowConflictingSubnets in the config\n", c)
+				} else {
+					fmt.Fprintln(stdout, c)
+				}
+			}
+			for _, sc := range resp.SessionConflicts {
+				fmt.Fprintf(stdout, "Subnet %s is not routed because it overlaps with subnet %s of session %s\n",
+					iputil.IPNetFromRPC(sc.Subnet), iputil.IPNetFromRPC(sc.OtherSubnet), sc.OtherSessionName)
+			}
 			return nil
 		case connector.ConnectInfo_ALREADY_CONNECTED:
 			return nil
@@ -86,7 +317,14 @@ func setConnectInfo(ctx context.Context, stdout io.Writer) (*connector.ConnectIn
 			msg = "Not connected"
 		case connector.ConnectInfo_MUST_RESTART:
 			msg = "Cluster configuration changed, please quit telepresence and reconnect"
-		case connector.ConnectInfo_TRAFFIC_MANAGER_FAILED, connector.ConnectInfo_CLUSTER_FAILED, connector.ConnectInfo_DAEMON_FAILED:
+			if resp.ErrorText != "" {
+				msg = resp.ErrorText
+			}
+			if resp.ErrorCategory != 0 {
+				cat = errcat.Category(resp.ErrorCategory)
+			}
+		case connector.ConnectInfo_TRAFFIC_MANAGER_FAILED, connector.ConnectInfo_CLUSTER_FAILED, connector.ConnectInfo_DAEMON_FAILED,
+			connector.ConnectInfo_SESSION_AMBIGUOUS:
 			msg = resp.ErrorText
 			if resp.ErrorCategory != 0 {
 				cat = errcat.Category(resp.ErrorCategory)
@@ -94,6 +332,7 @@ func setConnectInfo(ctx context.Context, stdout io.Writer) (*connector.ConnectIn
 		}
 		return cat.Newf("connector.Connect: %s", msg) // Return err != nil to ensure disconnect
 	})
+	tracing.EndSpan(span, err)
 	if err != nil {
 		return nil, err
 	}
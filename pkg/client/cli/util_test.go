@@ -0,0 +1,161 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_k8s"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+const testKubeConfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com:6443
+- name: prod
+  cluster:
+    server: https://prod.example.com:6443
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: test
+- name: prod
+  context:
+    cluster: prod
+    namespace: team-a
+    user: test
+current-context: dev
+users:
+- name: test
+  user:
+    token: abc
+`
+
+// setKubeFlags replaces the global kubernetes flags with fresh ones that are set to the given values.
+func setKubeFlags(t *testing.T, values map[string]string) {
+	oldFlags := kubeFlags
+	t.Cleanup(func() { kubeFlags = oldFlags })
+	kubeFlags = pflag.NewFlagSet("", 0)
+	kates.NewConfigFlags(false).AddFlags(kubeFlags)
+	for k, v := range values {
+		require.NoError(t, kubeFlags.Set(k, v))
+	}
+}
+
+// chdir changes the working directory for the duration of the test.
+func chdir(t *testing.T, dir string) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+}
+
+func TestConnectorKubeFlagMap(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "home", ".kube"), 0700))
+	kubeconfig := filepath.Join(dir, "kubeconfig")
+	require.NoError(t, os.WriteFile(kubeconfig, []byte(testKubeConfig), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "home", ".kube", "config"), []byte(testKubeConfig), 0600))
+
+	tests := []struct {
+		name        string
+		flags       map[string]string
+		kubeFlags   []string
+		env         string
+		want        map[string]string
+		wantContext string
+		wantServer  string
+	}{
+		{
+			name:        "default kubeconfig",
+			want:        map[string]string{"kubeconfig": filepath.Join(dir, "home", ".kube", "config")},
+			wantContext: "dev",
+			wantServer:  "https://dev.example.com:6443",
+		},
+		{
+			name:        "KUBECONFIG",
+			env:         kubeconfig,
+			flags:       map[string]string{"context": "prod"},
+			want:        map[string]string{"kubeconfig": kubeconfig, "context": "prod"},
+			wantContext: "prod",
+			wantServer:  "https://prod.example.com:6443",
+		},
+		{
+			name:        "relative kubeconfig",
+			flags:       map[string]string{"kubeconfig": "kubeconfig", "context": "prod"},
+			want:        map[string]string{"kubeconfig": kubeconfig, "context": "prod"},
+			wantContext: "prod",
+			wantServer:  "https://prod.example.com:6443",
+		},
+		{
+			name:        "relative KUBECONFIG",
+			env:         "kubeconfig",
+			want:        map[string]string{"kubeconfig": kubeconfig},
+			wantContext: "dev",
+			wantServer:  "https://dev.example.com:6443",
+		},
+		{
+			name:        "flag takes precedence over KUBECONFIG",
+			env:         filepath.Join(dir, "missing"),
+			flags:       map[string]string{"kubeconfig": kubeconfig},
+			want:        map[string]string{"kubeconfig": kubeconfig},
+			wantContext: "dev",
+			wantServer:  "https://dev.example.com:6443",
+		},
+		{
+			name:        "KUBECONFIG list",
+			env:         kubeconfig + string(os.PathListSeparator) + filepath.Join(dir, "other"),
+			flags:       map[string]string{"context": "prod"},
+			want:        map[string]string{"context": "prod"},
+			wantContext: "prod",
+			wantServer:  "https://prod.example.com:6443",
+		},
+		{
+			name:        "kubeflag",
+			kubeFlags:   []string{"context=prod", "--kubeconfig=kubeconfig"},
+			want:        map[string]string{"kubeconfig": kubeconfig, "context": "prod"},
+			wantContext: "prod",
+			wantServer:  "https://prod.example.com:6443",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			chdir(t, dir)
+			t.Setenv("KUBECONFIG", tt.env)
+			setKubeFlags(t, tt.flags)
+			require.NoError(t, applyKubeFlags(tt.kubeFlags))
+			ctx := filelocation.WithUserHomeDir(dlog.NewTestContext(t, false), filepath.Join(dir, "home"))
+
+			flagMap := connectorKubeFlagMap(ctx)
+			assert.Equal(t, tt.want, flagMap)
+
+			// The connector must end up with the same config regardless of its own directory
+			chdir(t, t.TempDir())
+			ctx = client.WithEnv(ctx, &client.Env{ManagerNamespace: "ambassador"})
+			cfg := client.GetDefaultConfig(ctx)
+			config, err := userd_k8s.NewConfig(client.WithConfig(ctx, &cfg), flagMap)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantContext, config.Context)
+			assert.Equal(t, tt.wantServer, config.Server)
+		})
+	}
+}
+
+func TestApplyKubeFlags_invalid(t *testing.T) {
+	setKubeFlags(t, nil)
+	for _, pair := range []string{"context", "=prod", "no-such-flag=x", "insecure-skip-tls-verify=maybe"} {
+		assert.Error(t, applyKubeFlags([]string{pair}), pair)
+	}
+}
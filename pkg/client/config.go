@@ -4,18 +4,24 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
 
-	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
 )
 
 const configFile = "config.yml"
@@ -27,6 +33,55 @@ type Config struct {
 	Images    Images    `json:"images,omitempty" yaml:"images,omitempty"`
 	Cloud     Cloud     `json:"cloud,omitempty" yaml:"cloud,omitempty"`
 	Grpc      Grpc      `json:"grpc,omitempty" yaml:"grpc,omitempty"`
+	Tracing   Tracing   `json:"tracing,omitempty" yaml:"tracing,omitempty"`
+	Telemetry Telemetry `json:"telemetry,omitempty" yaml:"telemetry,omitempty"`
+	Metrics   Metrics   `json:"metrics,omitempty" yaml:"metrics,omitempty"`
+	DNS       DNS       `json:"dns,omitempty" yaml:"dns,omitempty"`
+	Manager   Manager   `json:"manager,omitempty" yaml:"manager,omitempty"`
+	Intercept Intercept `json:"intercept,omitempty" yaml:"intercept,omitempty"`
+
+	// Connections limits the connections that the root daemon tracks.
+	Connections Connections `json:"connections,omitempty" yaml:"connections,omitempty"`
+
+	// EnvFilters are the default filters of the environment of an intercepted container before it's
+	// written to an env-file or passed to a command.
+	EnvFilters EnvFilters `json:"envFilters,omitempty" yaml:"envFilters,omitempty"`
+
+	// MappedNamespaces is the default list of namespaces that the connector maps when no
+	// namespaces are given with the --mapped-namespaces flag.
+	MappedNamespaces []string `json:"mappedNamespaces,omitempty" yaml:"mappedNamespaces,omitempty"`
+
+	// AlsoProxy are subnets outside of the cluster's pod and service subnets that are routed to the
+	// cluster and reached from the traffic-manager.
+	AlsoProxy []*iputil.Subnet `json:"alsoProxy,omitempty" yaml:"alsoProxy,omitempty"`
+
+	// NeverProxy are subnets that are never routed to the cluster, even when they overlap with
+	// the cluster's subnets or with AlsoProxy.
+	NeverProxy []*iputil.Subnet `json:"neverProxy,omitempty" yaml:"neverProxy,omitempty"`
+
+	// AllowConflictingSubnets are subnets that are routed to the cluster even when they collide
+	// with the routes of another VPN.
+	AllowConflictingSubnets []*iputil.Subnet `json:"allowConflictingSubnets,omitempty" yaml:"allowConflictingSubnets,omitempty"`
+
+	// IdleTimeout is the time that a session may remain without intercepts and without traffic to
+	// the cluster before it is disconnected. Zero means that the session never times out.
+	IdleTimeout time.Duration `json:"idleTimeout,omitempty" yaml:"idleTimeout,omitempty"`
+
+	// OutboundTrafficPolicy is either OutboundTrafficPolicyAll, which is the default, or
+	// OutboundTrafficPolicyMappedNamespaces.
+	OutboundTrafficPolicy string `json:"outboundTrafficPolicy,omitempty" yaml:"outboundTrafficPolicy,omitempty"`
+
+	// UserDaemonAddress is the unix socket path or loopback "tcp://<host>:<port>" address that the
+	// user daemon listens to. The TELEPRESENCE_USER_DAEMON_ADDRESS environment variable overrides it.
+	UserDaemonAddress string `json:"userDaemonAddress,omitempty" yaml:"userDaemonAddress,omitempty"`
+
+	// SOCKS5Address is the loopback "<host>:<port>" address that the SOCKS5 proxy of the "socks5" proxy
+	// mode listens to when the connect doesn't tell. DefaultSOCKS5Address is used when it's empty.
+	SOCKS5Address string `json:"socks5Address,omitempty" yaml:"socks5Address,omitempty"`
+
+	// Overrides are the values that apply to the sessions with the kube contexts that match their
+	// patterns, in the order of the config files that declare them.
+	Overrides []*ConfigOverride `json:"-" yaml:"-"`
 }
 
 // merge merges this instance with the non-zero values of the given argument. The argument values take priority.
@@ -36,6 +91,39 @@ func (c *Config) Merge(o *Config) {
 	c.Images.merge(&o.Images)
 	c.Cloud.merge(&o.Cloud)
 	c.Grpc.merge(&o.Grpc)
+	c.Tracing.merge(&o.Tracing)
+	c.Telemetry.merge(&o.Telemetry)
+	c.Metrics.merge(&o.Metrics)
+	c.DNS.merge(&o.DNS)
+	c.Manager.merge(&o.Manager)
+	c.Intercept.merge(&o.Intercept)
+	c.Connections.merge(&o.Connections)
+	c.EnvFilters.merge(&o.EnvFilters)
+	if len(o.MappedNamespaces) > 0 {
+		c.MappedNamespaces = o.MappedNamespaces
+	}
+	if len(o.AlsoProxy) > 0 {
+		c.AlsoProxy = o.AlsoProxy
+	}
+	if len(o.NeverProxy) > 0 {
+		c.NeverProxy = o.NeverProxy
+	}
+	if len(o.AllowConflictingSubnets) > 0 {
+		c.AllowConflictingSubnets = o.AllowConflictingSubnets
+	}
+	if o.IdleTimeout != 0 {
+		c.IdleTimeout = o.IdleTimeout
+	}
+	if o.OutboundTrafficPolicy != "" {
+		c.OutboundTrafficPolicy = o.OutboundTrafficPolicy
+	}
+	if o.UserDaemonAddress != "" {
+		c.UserDaemonAddress = o.UserDaemonAddress
+	}
+	if o.SOCKS5Address != "" {
+		c.SOCKS5Address = o.SOCKS5Address
+	}
+	c.Overrides = append(c.Overrides, o.Overrides...)
 }
 
 func stringKey(n *yaml.Node) (string, error) {
@@ -47,47 +135,116 @@ func stringKey(n *yaml.Node) (string, error) {
 }
 
 func (c *Config) UnmarshalYAML(node *yaml.Node) (err error) {
-	if node.Kind != yaml.MappingNode {
-		return errors.New(withLoc("config must be an object", node))
+	var errs configErrors
+	if err := expectObject("config", node); err != nil {
+		errs.add(err)
+		return errs
 	}
 	ms := node.Content
 	top := len(ms)
 	for i := 0; i < top; i += 2 {
 		kv, err := stringKey(ms[i])
 		if err != nil {
-			return err
+			errs.add(err)
+			continue
 		}
-		switch {
-		case kv == "timeouts":
-			err := ms[i+1].Decode(&c.Timeouts)
-			if err != nil {
-				return err
-			}
-		case kv == "logLevels":
-			err := ms[i+1].Decode(&c.LogLevels)
-			if err != nil {
-				return err
+		v := ms[i+1]
+		switch kv {
+		case "timeouts":
+			errs.add(v.Decode(&c.Timeouts))
+		case "logLevels":
+			errs.add(v.Decode(&c.LogLevels))
+		case "images":
+			errs.add(v.Decode(&c.Images))
+		case "cloud":
+			errs.add(v.Decode(&c.Cloud))
+		case "grpc":
+			errs.add(v.Decode(&c.Grpc))
+		case "tracing":
+			errs.add(v.Decode(&c.Tracing))
+		case "telemetry":
+			errs.add(v.Decode(&c.Telemetry))
+		case "metrics":
+			errs.add(v.Decode(&c.Metrics))
+		case "dns":
+			errs.add(v.Decode(&c.DNS))
+		case "manager":
+			errs.add(v.Decode(&c.Manager))
+		case "intercept":
+			errs.add(v.Decode(&c.Intercept))
+		case "connections":
+			errs.add(v.Decode(&c.Connections))
+		case "envFilters":
+			errs.add(v.Decode(&c.EnvFilters))
+		case "mappedNamespaces":
+			c.MappedNamespaces, _ = errs.stringListValue(kv, "namespace names", v)
+		case "alsoProxy":
+			c.AlsoProxy, err = decodeSubnets(kv, v)
+			errs.add(err)
+		case "neverProxy":
+			c.NeverProxy, err = decodeSubnets(kv, v)
+			errs.add(err)
+		case "allowConflictingSubnets":
+			c.AllowConflictingSubnets, err = decodeSubnets(kv, v)
+			errs.add(err)
+		case "idleTimeout":
+			if d, ok := errs.durationValue(kv, v); ok {
+				if d < 0 {
+					errs.addf(v, "idleTimeout must be a positive duration, got %q", v.Value)
+				} else {
+					c.IdleTimeout = d
+				}
 			}
-		case kv == "images":
-			err := ms[i+1].Decode(&c.Images)
-			if err != nil {
-				return err
+		case "outboundTrafficPolicy":
+			if s, ok := errs.stringValue(kv, v); ok {
+				switch s {
+				case OutboundTrafficPolicyAll, OutboundTrafficPolicyMappedNamespaces:
+					c.OutboundTrafficPolicy = s
+				default:
+					errs.addf(v, "outboundTrafficPolicy must be %q or %q, got %q",
+						OutboundTrafficPolicyAll, OutboundTrafficPolicyMappedNamespaces, s)
+				}
 			}
-		case kv == "cloud":
-			err := ms[i+1].Decode(&c.Cloud)
-			if err != nil {
-				return err
+		case "userDaemonAddress":
+			if s, ok := errs.stringValue(kv, v); ok {
+				if c.UserDaemonAddress, err = ParseDaemonAddress(s); err != nil {
+					errs.addf(v, "userDaemonAddress: %v", err)
+				}
 			}
-		case kv == "grpc":
-			err := ms[i+1].Decode(&c.Grpc)
-			if err != nil {
-				return err
+		case "socks5Address":
+			if s, ok := errs.stringValue(kv, v); ok {
+				if c.SOCKS5Address, err = ParseSOCKS5Address(s); err != nil {
+					errs.addf(v, "socks5Address: %v", err)
+				}
 			}
-		case parseContext != nil:
-			dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
+		case "overrides":
+			c.Overrides, err = decodeOverrides(v)
+			errs.add(err)
+		default:
+			errs.unknownKey("", kv, ms[i], reflect.TypeOf(Config{}))
 		}
 	}
-	return nil
+	return errs.err()
+}
+
+// decodeSubnets decodes a list of CIDRs. An invalid entry is an error that names the entry.
+func decodeSubnets(key string, node *yaml.Node) ([]*iputil.Subnet, error) {
+	if node.Kind != yaml.SequenceNode {
+		return nil, errors.New(withLoc(fmt.Sprintf("%s: expected a list of CIDRs, got %s", key, kindName(node)), node))
+	}
+	var errs configErrors
+	subnets := make([]*iputil.Subnet, len(node.Content))
+	for i, n := range node.Content {
+		sn := &iputil.Subnet{}
+		if err := n.Decode(sn); err != nil {
+			errs.addf(n, "%s entry %q: %v", key, n.Value, err)
+		}
+		subnets[i] = sn
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return subnets, nil
 }
 
 type Timeouts struct {
@@ -135,6 +292,20 @@ const (
 	TimeoutTrafficManagerConnect
 )
 
+// timeoutKeys are the keys of the "timeouts" section in the config file, indexed by TimeoutID.
+var timeoutKeys = [...]string{
+	TimeoutAgentInstall:          "agentInstall",
+	TimeoutApply:                 "apply",
+	TimeoutClusterConnect:        "clusterConnect",
+	TimeoutEndpointDial:          "endpointDial",
+	TimeoutHelm:                  "helm",
+	TimeoutIntercept:             "intercept",
+	TimeoutProxyDial:             "proxyDial",
+	TimeoutRoundtripLatency:      "roundtripLatency",
+	TimeoutTrafficManagerAPI:     "trafficManagerAPI",
+	TimeoutTrafficManagerConnect: "trafficManagerConnect",
+}
+
 type timeoutContext struct {
 	context.Context
 	timeoutID  TimeoutID
@@ -183,6 +354,11 @@ func (t *Timeouts) Get(timeoutID TimeoutID) time.Duration {
 	return timeoutVal
 }
 
+// GetTimeout returns the duration of the given timeout in the config of the given context.
+func GetTimeout(ctx context.Context, timeoutID TimeoutID) time.Duration {
+	return GetConfig(ctx).Timeouts.Get(timeoutID)
+}
+
 func (t *Timeouts) TimeoutContext(ctx context.Context, timeoutID TimeoutID) (context.Context, context.CancelFunc) {
 	timeoutVal := t.Get(timeoutID)
 	ctx, cancel := context.WithTimeout(ctx, timeoutVal)
@@ -202,43 +378,33 @@ type timeoutErr struct {
 }
 
 func (e timeoutErr) Error() string {
-	var yamlName, humanName string
+	var humanName string
 	switch e.timeoutID {
 	case TimeoutAgentInstall:
-		yamlName = "agentInstall"
 		humanName = "agent install"
 	case TimeoutApply:
-		yamlName = "apply"
 		humanName = "apply"
 	case TimeoutClusterConnect:
-		yamlName = "clusterConnect"
 		humanName = "cluster connect"
 	case TimeoutEndpointDial:
-		yamlName = "endpointDial"
 		humanName = "tunnel endpoint dial with known IP"
 	case TimeoutHelm:
-		yamlName = "helm"
 		humanName = "helm operation"
 	case TimeoutIntercept:
-		yamlName = "intercept"
 		humanName = "intercept"
 	case TimeoutProxyDial:
-		yamlName = "proxyDial"
 		humanName = "proxy dial"
 	case TimeoutRoundtripLatency:
-		yamlName = "roundtripDelay"
 		humanName = "additional delay for tunnel roundtrip"
 	case TimeoutTrafficManagerAPI:
-		yamlName = "trafficManagerAPI"
 		humanName = "traffic manager gRPC API"
 	case TimeoutTrafficManagerConnect:
-		yamlName = "trafficManagerConnect"
 		humanName = "port-forward connection to the traffic manager"
 	default:
 		panic("should not happen")
 	}
 	return fmt.Sprintf("the %s timed out.  The current timeout %s can be configured as %q in %q",
-		humanName, e.timeoutVal, "timeouts."+yamlName, e.configFile)
+		humanName, e.timeoutVal, "timeouts."+timeoutKeys[e.timeoutID], e.configFile)
 }
 
 func (e timeoutErr) Unwrap() error {
@@ -254,15 +420,17 @@ func CheckTimeout(ctx context.Context, err error) error {
 
 // UnmarshalYAML caters for the unfortunate fact that time.Duration doesn't do YAML or JSON at all.
 func (t *Timeouts) UnmarshalYAML(node *yaml.Node) (err error) {
-	if node.Kind != yaml.MappingNode {
-		return errors.New(withLoc("timeouts must be an object", node))
+	if err := expectObject("timeouts", node); err != nil {
+		return err
 	}
+	var errs configErrors
 	ms := node.Content
 	top := len(ms)
 	for i := 0; i < top; i += 2 {
 		kv, err := stringKey(ms[i])
 		if err != nil {
-			return err
+			errs.add(err)
+			continue
 		}
 		var dp *time.Duration
 		switch kv {
@@ -287,29 +455,38 @@ func (t *Timeouts) UnmarshalYAML(node *yaml.Node) (err error) {
 		case "trafficManagerConnect":
 			dp = &t.PrivateTrafficManagerConnect
 		default:
-			if parseContext != nil {
-				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
-			}
+			errs.unknownKey("timeouts", kv, ms[i], reflect.TypeOf(Timeouts{}))
 			continue
 		}
 
 		v := ms[i+1]
 		var vv interface{}
-		if err = v.Decode(&vv); err != nil {
-			return errors.New(withLoc("unable to parse value", v))
+		if v.Kind != yaml.ScalarNode || v.Decode(&vv) != nil {
+			errs.addf(v, "timeouts.%s: expected a duration, got %s", kv, kindName(v))
+			continue
 		}
+		var d time.Duration
 		switch vv := vv.(type) {
 		case int:
-			*dp = time.Duration(vv) * time.Second
+			d = time.Duration(vv) * time.Second
 		case float64:
-			*dp = time.Duration(vv * float64(time.Second))
+			d = time.Duration(vv * float64(time.Second))
 		case string:
-			if *dp, err = time.ParseDuration(vv); err != nil {
-				return errors.New(withLoc(fmt.Sprintf("%q is not a valid duration", vv), v))
+			if d, err = time.ParseDuration(vv); err != nil {
+				errs.addf(v, "timeouts.%s: expected a duration, got %q", kv, vv)
+				continue
 			}
+		default:
+			errs.addf(v, "timeouts.%s: expected a duration, got %s", kv, kindName(v))
+			continue
 		}
+		if d <= 0 {
+			errs.addf(v, "timeouts.%s must be a positive duration, got %q", kv, v.Value)
+			continue
+		}
+		*dp = d
 	}
-	return nil
+	return errs.err()
 }
 
 const defaultTimeoutsAgentInstall = 120 * time.Second
@@ -400,34 +577,36 @@ type LogLevels struct {
 
 // UnmarshalYAML parses the logrus log-levels
 func (ll *LogLevels) UnmarshalYAML(node *yaml.Node) (err error) {
-	if node.Kind != yaml.MappingNode {
-		return errors.New(withLoc("timeouts must be an object", node))
+	if err := expectObject("logLevels", node); err != nil {
+		return err
 	}
-
+	var errs configErrors
 	ms := node.Content
 	top := len(ms)
 	for i := 0; i < top; i += 2 {
 		kv, err := stringKey(ms[i])
 		if err != nil {
-			return err
-		}
-		v := ms[i+1]
-		level, err := logrus.ParseLevel(v.Value)
-		if err != nil {
-			return errors.New(withLoc("invalid log-level", v))
+			errs.add(err)
+			continue
 		}
+		var lp *logrus.Level
 		switch kv {
 		case "userDaemon":
-			ll.UserDaemon = level
+			lp = &ll.UserDaemon
 		case "rootDaemon":
-			ll.RootDaemon = level
+			lp = &ll.RootDaemon
 		default:
-			if parseContext != nil {
-				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
+			errs.unknownKey("logLevels", kv, ms[i], reflect.TypeOf(LogLevels{}))
+			continue
+		}
+		v := ms[i+1]
+		if s, ok := errs.stringValue("logLevels."+kv, v); ok {
+			if *lp, err = logrus.ParseLevel(s); err != nil {
+				errs.addf(v, "logLevels.%s: expected a log-level, got %q", kv, s)
 			}
 		}
 	}
-	return nil
+	return errs.err()
 }
 
 func (ll *LogLevels) merge(o *LogLevels) {
@@ -439,43 +618,86 @@ func (ll *LogLevels) merge(o *LogLevels) {
 	}
 }
 
+// Images configures the registry and the names of the images that telepresence uses. The webhookRegistry
+// and webhookAgentImage, which the agent injector of an installed traffic-manager uses, default to the
+// registry and agentImage.
 type Images struct {
 	Registry          string `json:"registry,omitempty" yaml:"registry,omitempty"`
 	AgentImage        string `json:"agentImage,omitempty" yaml:"agentImage,omitempty"`
 	WebhookRegistry   string `json:"webhookRegistry,omitempty" yaml:"webhookRegistry,omitempty"`
 	WebhookAgentImage string `json:"webhookAgentImage,omitempty" yaml:"webhookAgentImage,omitempty"`
+
+	// ClientImage is the image that runs the daemons when connecting with --docker. It defaults
+	// to telepresence:<version> in the registry.
+	ClientImage string `json:"clientImage,omitempty" yaml:"clientImage,omitempty"`
+
+	// AgentImagePullSecrets are the names of the secrets that pods with a traffic-agent use to pull
+	// the agent image. Secrets that a pod already declares are retained.
+	AgentImagePullSecrets []string `json:"agentImagePullSecrets,omitempty" yaml:"agentImagePullSecrets,omitempty"`
 }
 
 // UnmarshalYAML parses the images YAML
 func (img *Images) UnmarshalYAML(node *yaml.Node) (err error) {
-	if node.Kind != yaml.MappingNode {
-		return errors.New(withLoc("images must be an object", node))
+	if err := expectObject("images", node); err != nil {
+		return err
 	}
-
+	var errs configErrors
 	ms := node.Content
 	top := len(ms)
 	for i := 0; i < top; i += 2 {
 		kv, err := stringKey(ms[i])
 		if err != nil {
-			return err
+			errs.add(err)
+			continue
 		}
 		v := ms[i+1]
 		switch kv {
 		case "registry":
-			img.Registry = v.Value
+			img.Registry = errs.imageValue(kv, v, ValidateRegistry)
 		case "agentImage":
-			img.AgentImage = v.Value
+			img.AgentImage = errs.imageValue(kv, v, ValidateImage)
 		case "webhookRegistry":
-			img.WebhookRegistry = v.Value
+			img.WebhookRegistry = errs.imageValue(kv, v, ValidateRegistry)
 		case "webhookAgentImage":
-			img.WebhookAgentImage = v.Value
+			img.WebhookAgentImage = errs.imageValue(kv, v, ValidateImage)
+		case "clientImage":
+			img.ClientImage = errs.imageValue(kv, v, ValidateImage)
+		case "agentImagePullSecrets":
+			img.AgentImagePullSecrets = errs.pullSecretsValue(kv, v)
 		default:
-			if parseContext != nil {
-				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
-			}
+			errs.unknownKey("images", kv, ms[i], reflect.TypeOf(Images{}))
 		}
 	}
-	return nil
+	return errs.err()
+}
+
+// imageValue returns the value of the given images node, or adds an error if the validate function rejects it.
+func (es *configErrors) imageValue(key string, v *yaml.Node, validate func(string) error) string {
+	s, ok := es.stringValue("images."+key, v)
+	if !ok {
+		return ""
+	}
+	if err := validate(s); err != nil {
+		es.addf(v, "images.%s: %v", key, err)
+		return ""
+	}
+	return s
+}
+
+// pullSecretsValue returns the secret names of the given images node, or adds an error unless it's a list of
+// valid secret names.
+func (es *configErrors) pullSecretsValue(key string, v *yaml.Node) []string {
+	names, ok := es.stringListValue("images."+key, "secret names", v)
+	if !ok {
+		return nil
+	}
+	for i, name := range names {
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			es.addf(v.Content[i], "images.%s: %q is not a valid secret name: %s", key, name, strings.Join(errs, ", "))
+			return nil
+		}
+	}
+	return names
 }
 
 func (i *Images) merge(o *Images) {
@@ -491,6 +713,12 @@ func (i *Images) merge(o *Images) {
 	if o.WebhookRegistry != "" {
 		i.WebhookRegistry = o.WebhookRegistry
 	}
+	if o.ClientImage != "" {
+		i.ClientImage = o.ClientImage
+	}
+	if len(o.AgentImagePullSecrets) > 0 {
+		i.AgentImagePullSecrets = o.AgentImagePullSecrets
+	}
 }
 
 type Cloud struct {
@@ -500,46 +728,36 @@ type Cloud struct {
 	SystemaPort     string        `json:"systemaPort,omitempty" yaml:"systemaPort,omitempty"`
 }
 
-// UnmarshalYAML parses the images YAML
+// UnmarshalYAML parses the cloud YAML
 func (cloud *Cloud) UnmarshalYAML(node *yaml.Node) (err error) {
-	if node.Kind != yaml.MappingNode {
-		return errors.New(withLoc("cloud must be an object", node))
+	if err := expectObject("cloud", node); err != nil {
+		return err
 	}
-
+	var errs configErrors
 	ms := node.Content
 	top := len(ms)
 	for i := 0; i < top; i += 2 {
 		kv, err := stringKey(ms[i])
 		if err != nil {
-			return err
+			errs.add(err)
+			continue
 		}
 		v := ms[i+1]
+		path := "cloud." + kv
 		switch kv {
 		case "skipLogin":
-			val, err := strconv.ParseBool(v.Value)
-			if err != nil {
-				dlog.Warn(parseContext, withLoc(fmt.Sprintf("bool expected for key %q", kv), ms[i]))
-			} else {
-				cloud.SkipLogin = val
-			}
+			cloud.SkipLogin, _ = errs.boolValue(path, v)
 		case "refreshMessages":
-			duration, err := time.ParseDuration(v.Value)
-			if err != nil {
-				dlog.Warn(parseContext, withLoc(fmt.Sprintf("duration expected for key %q", kv), ms[i]))
-			} else {
-				cloud.RefreshMessages = duration
-			}
+			cloud.RefreshMessages, _ = errs.durationValue(path, v)
 		case "systemaHost":
-			cloud.SystemaHost = v.Value
+			cloud.SystemaHost, _ = errs.stringValue(path, v)
 		case "systemaPort":
-			cloud.SystemaPort = v.Value
+			cloud.SystemaPort, _ = errs.stringValue(path, v)
 		default:
-			if parseContext != nil {
-				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
-			}
+			errs.unknownKey("cloud", kv, ms[i], reflect.TypeOf(Cloud{}))
 		}
 	}
-	return nil
+	return errs.err()
 }
 
 const defaultCloudSystemAHost = "app.getambassador.io"
@@ -583,43 +801,71 @@ type Grpc struct {
 	// MaxReceiveSize is the maximum message size in bytes the client can receive in a gRPC call or stream message.
 	// Overrides the gRPC default of 4MB.
 	MaxReceiveSize *resource.Quantity `json:"maxReceiveSize,omitempty" yaml:"maxReceiveSize,omitempty"`
+
+	// KeepAliveTime is the time after which a client sends a keepalive ping on a connection that has been
+	// idle. Keepalive pings are disabled when it's zero.
+	KeepAliveTime time.Duration `json:"keepAliveTime,omitempty" yaml:"keepAliveTime,omitempty"`
+
+	// KeepAliveTimeout is the time that a client waits for the response to a keepalive ping before it
+	// closes the connection.
+	KeepAliveTimeout time.Duration `json:"keepAliveTimeout,omitempty" yaml:"keepAliveTimeout,omitempty"`
 }
 
 func (g *Grpc) merge(o *Grpc) {
 	if o.MaxReceiveSize != nil {
 		g.MaxReceiveSize = o.MaxReceiveSize
 	}
+	if o.KeepAliveTime != 0 {
+		g.KeepAliveTime = o.KeepAliveTime
+	}
+	if o.KeepAliveTimeout != 0 {
+		g.KeepAliveTimeout = o.KeepAliveTimeout
+	}
 }
 
-// UnmarshalYAML parses the images YAML
+// UnmarshalYAML parses the grpc YAML
 func (g *Grpc) UnmarshalYAML(node *yaml.Node) (err error) {
-	if node.Kind != yaml.MappingNode {
-		return errors.New(withLoc("grpc must be an object", node))
+	if err := expectObject("grpc", node); err != nil {
+		return err
 	}
-
+	var errs configErrors
 	ms := node.Content
 	top := len(ms)
 	for i := 0; i < top; i += 2 {
 		kv, err := stringKey(ms[i])
 		if err != nil {
-			return err
+			errs.add(err)
+			continue
 		}
 		v := ms[i+1]
 		switch kv {
 		case "maxReceiveSize":
-			val, err := resource.ParseQuantity(v.Value)
-			if err != nil {
-				dlog.Warningf(parseContext, "unable to parse quantity %q: %v", v.Value, withLoc(err.Error(), ms[i]))
+			if v.Kind == yaml.ScalarNode {
+				if val, err := resource.ParseQuantity(v.Value); err == nil {
+					g.MaxReceiveSize = &val
+					continue
+				}
+			}
+			errs.addf(v, "grpc.%s: expected a quantity, got %s", kv, kindName(v))
+		case "keepAliveTime", "keepAliveTimeout":
+			d, ok := errs.durationValue("grpc."+kv, v)
+			if !ok {
+				continue
+			}
+			if d <= 0 {
+				errs.addf(v, "grpc.%s must be a positive duration, got %q", kv, v.Value)
+				continue
+			}
+			if kv == "keepAliveTime" {
+				g.KeepAliveTime = d
 			} else {
-				g.MaxReceiveSize = &val
+				g.KeepAliveTimeout = d
 			}
 		default:
-			if parseContext != nil {
-				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
-			}
+			errs.unknownKey("grpc", kv, ms[i], reflect.TypeOf(Grpc{}))
 		}
 	}
-	return nil
+	return errs.err()
 }
 
 // MarshalYAML is not using pointer receiver here, because Cloud is not pointer in the Config struct
@@ -628,9 +874,633 @@ func (g Grpc) MarshalYAML() (interface{}, error) {
 	if g.MaxReceiveSize != nil {
 		cm["maxReceiveSize"] = g.MaxReceiveSize.String()
 	}
+	if g.KeepAliveTime != 0 {
+		cm["keepAliveTime"] = g.KeepAliveTime.String()
+	}
+	if g.KeepAliveTimeout != 0 {
+		cm["keepAliveTimeout"] = g.KeepAliveTimeout.String()
+	}
+	return cm, nil
+}
+
+type Tracing struct {
+	// Enabled makes the CLI and the daemons record trace spans of their work. The daemons
+	// keep the spans in memory until they are retrieved with "telepresence gather-traces".
+	Enabled bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+}
+
+func (tr *Tracing) merge(o *Tracing) {
+	if o.Enabled {
+		tr.Enabled = o.Enabled
+	}
+}
+
+// UnmarshalYAML parses the tracing YAML
+func (tr *Tracing) UnmarshalYAML(node *yaml.Node) (err error) {
+	if err := expectObject("tracing", node); err != nil {
+		return err
+	}
+	var errs configErrors
+	ms := node.Content
+	top := len(ms)
+	for i := 0; i < top; i += 2 {
+		kv, err := stringKey(ms[i])
+		if err != nil {
+			errs.add(err)
+			continue
+		}
+		v := ms[i+1]
+		switch kv {
+		case "enabled":
+			tr.Enabled, _ = errs.boolValue("tracing."+kv, v)
+		default:
+			errs.unknownKey("tracing", kv, ms[i], reflect.TypeOf(Tracing{}))
+		}
+	}
+	return errs.err()
+}
+
+// MarshalYAML is not using pointer receiver here, because Tracing is not pointer in the Config struct
+func (tr Tracing) MarshalYAML() (interface{}, error) {
+	cm := make(map[string]interface{})
+	if tr.Enabled {
+		cm["enabled"] = true
+	}
+	return cm, nil
+}
+
+type Telemetry struct {
+	// Enabled is false when the CLI, the daemons, and the traffic-manager that the CLI installs must not
+	// send anonymous usage reports. Nil means that telemetry is enabled.
+	Enabled *bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+}
+
+func (te *Telemetry) merge(o *Telemetry) {
+	if o.Enabled != nil {
+		te.Enabled = o.Enabled
+	}
+}
+
+// UnmarshalYAML parses the telemetry YAML
+func (te *Telemetry) UnmarshalYAML(node *yaml.Node) (err error) {
+	if err := expectObject("telemetry", node); err != nil {
+		return err
+	}
+	var errs configErrors
+	ms := node.Content
+	top := len(ms)
+	for i := 0; i < top; i += 2 {
+		kv, err := stringKey(ms[i])
+		if err != nil {
+			errs.add(err)
+			continue
+		}
+		v := ms[i+1]
+		switch kv {
+		case "enabled":
+			if val, ok := errs.boolValue("telemetry."+kv, v); ok {
+				te.Enabled = &val
+			}
+		default:
+			errs.unknownKey("telemetry", kv, ms[i], reflect.TypeOf(Telemetry{}))
+		}
+	}
+	return errs.err()
+}
+
+// MarshalYAML is not using pointer receiver here, because Telemetry is not pointer in the Config struct
+func (te Telemetry) MarshalYAML() (interface{}, error) {
+	cm := make(map[string]interface{})
+	if te.Enabled != nil {
+		cm["enabled"] = *te.Enabled
+	}
+	return cm, nil
+}
+
+type Metrics struct {
+	// Address is the loopback "<host>:<port>" address that the user daemon serves its Prometheus metrics on.
+	// The root daemon serves its metrics on the next port. The metrics are not served when it's empty.
+	Address string `json:"address,omitempty" yaml:"address,omitempty"`
+}
+
+func (m *Metrics) merge(o *Metrics) {
+	if o.Address != "" {
+		m.Address = o.Address
+	}
+}
+
+// UnmarshalYAML parses the metrics YAML
+func (m *Metrics) UnmarshalYAML(node *yaml.Node) (err error) {
+	if err := expectObject("metrics", node); err != nil {
+		return err
+	}
+	var errs configErrors
+	ms := node.Content
+	top := len(ms)
+	for i := 0; i < top; i += 2 {
+		kv, err := stringKey(ms[i])
+		if err != nil {
+			errs.add(err)
+			continue
+		}
+		v := ms[i+1]
+		switch kv {
+		case "address":
+			if s, ok := errs.stringValue("metrics."+kv, v); ok {
+				if err = validateMetricsAddress(s); err != nil {
+					errs.addf(v, "metrics.address: %v", err)
+				} else {
+					m.Address = s
+				}
+			}
+		default:
+			errs.unknownKey("metrics", kv, ms[i], reflect.TypeOf(Metrics{}))
+		}
+	}
+	return errs.err()
+}
+
+// MarshalYAML is not using pointer receiver here, because Metrics is not pointer in the Config struct
+func (m Metrics) MarshalYAML() (interface{}, error) {
+	cm := make(map[string]interface{})
+	if m.Address != "" {
+		cm["address"] = m.Address
+	}
 	return cm, nil
 }
 
+// validateMetricsAddress checks that the given address is a loopback "<host>:<port>" address, and that the
+// next port, which the root daemon uses, is valid too.
+func validateMetricsAddress(address string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %w", address, err)
+	}
+	if pn, err := strconv.Atoi(port); err != nil || pn <= 0 || pn >= 0xffff {
+		return fmt.Errorf("invalid address %q: port must be a number between 1 and 65534", address)
+	}
+	if host != "localhost" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return fmt.Errorf("invalid address %q: host must be a loopback address", address)
+		}
+	}
+	return nil
+}
+
+// DaemonAddress returns the address that the user daemon, or the root daemon when rootDaemon is true,
+// serves its metrics on, or an empty string when the metrics are not served.
+func (m *Metrics) DaemonAddress(rootDaemon bool) string {
+	if m.Address == "" || !rootDaemon {
+		return m.Address
+	}
+	host, port, err := net.SplitHostPort(m.Address)
+	if err != nil {
+		return ""
+	}
+	pn, err := strconv.Atoi(port)
+	if err != nil {
+		return ""
+	}
+	return net.JoinHostPort(host, strconv.Itoa(pn+1))
+}
+
+type Manager struct {
+	// Namespace is the namespace where the connector looks for the traffic-manager, and where it
+	// installs it when it's not found. A manager namespace in the kubeconfig extension of the cluster
+	// takes precedence.
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+}
+
+func (m *Manager) merge(o *Manager) {
+	if o.Namespace != "" {
+		m.Namespace = o.Namespace
+	}
+}
+
+// UnmarshalYAML parses the manager YAML
+func (m *Manager) UnmarshalYAML(node *yaml.Node) (err error) {
+	if err := expectObject("manager", node); err != nil {
+		return err
+	}
+	var errs configErrors
+	ms := node.Content
+	top := len(ms)
+	for i := 0; i < top; i += 2 {
+		kv, err := stringKey(ms[i])
+		if err != nil {
+			errs.add(err)
+			continue
+		}
+		v := ms[i+1]
+		switch kv {
+		case "namespace":
+			if s, ok := errs.stringValue("manager."+kv, v); ok {
+				if verrs := validation.IsDNS1123Label(s); len(verrs) > 0 {
+					errs.addf(v, "manager.namespace %q is not a valid namespace name: %s", s, strings.Join(verrs, ", "))
+				} else {
+					m.Namespace = s
+				}
+			}
+		default:
+			errs.unknownKey("manager", kv, ms[i], reflect.TypeOf(Manager{}))
+		}
+	}
+	return errs.err()
+}
+
+// MarshalYAML is not using pointer receiver here, because Manager is not pointer in the Config struct
+func (m Manager) MarshalYAML() (interface{}, error) {
+	cm := make(map[string]interface{})
+	if m.Namespace != "" {
+		cm["namespace"] = m.Namespace
+	}
+	return cm, nil
+}
+
+const (
+	// MissingWebhookError makes an intercept fail when the workload is annotated for injection of the
+	// traffic-agent but the traffic-manager's mutating webhook isn't installed.
+	MissingWebhookError = "error"
+
+	// MissingWebhookPatch makes an intercept fall back to patching the workload with the traffic-agent
+	// when the workload is annotated for injection but the mutating webhook isn't installed.
+	MissingWebhookPatch = "patch"
+)
+
+type Intercept struct {
+	// MissingWebhook is what an intercept does when the workload is annotated for injection of the
+	// traffic-agent but the mutating webhook isn't installed; either MissingWebhookError or
+	// MissingWebhookPatch. The empty string means MissingWebhookError.
+	MissingWebhook string `json:"missingWebhook,omitempty" yaml:"missingWebhook,omitempty"`
+
+	// DefaultTTL is the time-to-live of an intercept that is created without the --ttl flag. The
+	// traffic-manager ends the intercept when it expires. Zero means that the intercept never expires.
+	DefaultTTL time.Duration `json:"defaultTTL,omitempty" yaml:"defaultTTL,omitempty"`
+}
+
+func (ic *Intercept) merge(o *Intercept) {
+	if o.MissingWebhook != "" {
+		ic.MissingWebhook = o.MissingWebhook
+	}
+	if o.DefaultTTL != 0 {
+		ic.DefaultTTL = o.DefaultTTL
+	}
+}
+
+// UnmarshalYAML parses the intercept YAML
+func (ic *Intercept) UnmarshalYAML(node *yaml.Node) (err error) {
+	if err := expectObject("intercept", node); err != nil {
+		return err
+	}
+	var errs configErrors
+	ms := node.Content
+	top := len(ms)
+	for i := 0; i < top; i += 2 {
+		kv, err := stringKey(ms[i])
+		if err != nil {
+			errs.add(err)
+			continue
+		}
+		v := ms[i+1]
+		switch kv {
+		case "missingWebhook":
+			if s, ok := errs.stringValue("intercept."+kv, v); ok {
+				switch s {
+				case MissingWebhookError, MissingWebhookPatch:
+					ic.MissingWebhook = s
+				default:
+					errs.addf(v, "intercept.missingWebhook must be %q or %q, got %q", MissingWebhookError, MissingWebhookPatch, s)
+				}
+			}
+		case "defaultTTL":
+			if d, ok := errs.durationValue("intercept."+kv, v); ok {
+				if d < 0 {
+					errs.addf(v, "intercept.defaultTTL must be a positive duration, got %q", v.Value)
+				} else {
+					ic.DefaultTTL = d
+				}
+			}
+		default:
+			errs.unknownKey("intercept", kv, ms[i], reflect.TypeOf(Intercept{}))
+		}
+	}
+	return errs.err()
+}
+
+// MarshalYAML is not using pointer receiver here, because Intercept is not pointer in the Config struct
+func (ic Intercept) MarshalYAML() (interface{}, error) {
+	cm := make(map[string]interface{})
+	if ic.MissingWebhook != "" && ic.MissingWebhook != MissingWebhookError {
+		cm["missingWebhook"] = ic.MissingWebhook
+	}
+	if ic.DefaultTTL != 0 {
+		cm["defaultTTL"] = ic.DefaultTTL.String()
+	}
+	return cm, nil
+}
+
+const (
+	// ConnectionEvictionLRU makes the root daemon close the least recently used connection when a new
+	// connection would exceed the maximum number of tracked connections.
+	ConnectionEvictionLRU = "lru"
+
+	// ConnectionEvictionRefuse makes the root daemon refuse a new connection that would exceed the maximum
+	// number of tracked connections. The application gets a TCP reset, or an ICMP port unreachable for UDP.
+	ConnectionEvictionRefuse = "refuse"
+)
+
+// defaultConnectionsUDPIdleTimeout is the time after which the root daemon forgets an unused UDP flow.
+const defaultConnectionsUDPIdleTimeout = 5 * time.Second
+
+// defaultConnectionsTCPKeepAlive is the period between keep-alive probes on TCP connections that the user
+// daemon dials or accepts on behalf of the tunnel.
+const defaultConnectionsTCPKeepAlive = 15 * time.Second
+
+type Connections struct {
+	// MaxTracked is the maximum number of TCP connections and UDP flows that the root daemon tracks. Zero
+	// means that there's no maximum.
+	MaxTracked int `json:"maxTracked,omitempty" yaml:"maxTracked,omitempty"`
+
+	// Eviction is what the root daemon does when a new connection would exceed MaxTracked; either
+	// ConnectionEvictionLRU or ConnectionEvictionRefuse. The empty string means ConnectionEvictionLRU.
+	Eviction string `json:"eviction,omitempty" yaml:"eviction,omitempty"`
+
+	// TCPIdleTimeout is the time after which an unused TCP connection is closed. Zero means never.
+	TCPIdleTimeout time.Duration `json:"tcpIdleTimeout,omitempty" yaml:"tcpIdleTimeout,omitempty"`
+
+	// UDPIdleTimeout is the time after which an unused UDP flow is forgotten. Zero means never.
+	UDPIdleTimeout time.Duration `json:"udpIdleTimeout,omitempty" yaml:"udpIdleTimeout,omitempty"`
+
+	// TCPKeepAlive is the period between keep-alive probes on TCP connections that are dialed or accepted
+	// for the tunnel. An idle connection also sends a keep-alive through the tunnel after this period, so
+	// that it isn't closed by the other side. A negative value disables keep-alives.
+	TCPKeepAlive time.Duration `json:"tcpKeepAlive,omitempty" yaml:"tcpKeepAlive,omitempty"`
+}
+
+func (cn *Connections) merge(o *Connections) {
+	if o.MaxTracked != 0 {
+		cn.MaxTracked = o.MaxTracked
+	}
+	if o.Eviction != "" {
+		cn.Eviction = o.Eviction
+	}
+	if o.TCPIdleTimeout != 0 {
+		cn.TCPIdleTimeout = o.TCPIdleTimeout
+	}
+	if o.UDPIdleTimeout != 0 {
+		cn.UDPIdleTimeout = o.UDPIdleTimeout
+	}
+	if o.TCPKeepAlive != 0 {
+		cn.TCPKeepAlive = o.TCPKeepAlive
+	}
+}
+
+// UnmarshalYAML parses the connections YAML
+func (cn *Connections) UnmarshalYAML(node *yaml.Node) (err error) {
+	if err := expectObject("connections", node); err != nil {
+		return err
+	}
+	var errs configErrors
+	ms := node.Content
+	top := len(ms)
+	for i := 0; i < top; i += 2 {
+		kv, err := stringKey(ms[i])
+		if err != nil {
+			errs.add(err)
+			continue
+		}
+		v := ms[i+1]
+		switch kv {
+		case "maxTracked":
+			if n, ok := errs.intValue("connections."+kv, v); ok {
+				if n < 0 {
+					errs.addf(v, "connections.maxTracked must be zero or a positive number, got %d", n)
+				} else {
+					cn.MaxTracked = n
+				}
+			}
+		case "eviction":
+			if s, ok := errs.stringValue("connections."+kv, v); ok {
+				switch s {
+				case ConnectionEvictionLRU, ConnectionEvictionRefuse:
+					cn.Eviction = s
+				default:
+					errs.addf(v, "connections.eviction must be %q or %q, got %q", ConnectionEvictionLRU, ConnectionEvictionRefuse, s)
+				}
+			}
+		case "tcpIdleTimeout", "udpIdleTimeout":
+			if d, ok := errs.durationValue("connections."+kv, v); ok {
+				switch {
+				case d < 0:
+					errs.addf(v, "connections.%s must be a positive duration, got %q", kv, v.Value)
+				case kv == "tcpIdleTimeout":
+					cn.TCPIdleTimeout = d
+				default:
+					cn.UDPIdleTimeout = d
+				}
+			}
+		case "tcpKeepAlive":
+			if d, ok := errs.durationValue("connections."+kv, v); ok {
+				cn.TCPKeepAlive = d
+			}
+		default:
+			errs.unknownKey("connections", kv, ms[i], reflect.TypeOf(Connections{}))
+		}
+	}
+	return errs.err()
+}
+
+// MarshalYAML is not using pointer receiver here, because Connections is not pointer in the Config struct
+func (cn Connections) MarshalYAML() (interface{}, error) {
+	cm := make(map[string]interface{})
+	if cn.MaxTracked != 0 {
+		cm["maxTracked"] = cn.MaxTracked
+	}
+	if cn.Eviction != "" && cn.Eviction != ConnectionEvictionLRU {
+		cm["eviction"] = cn.Eviction
+	}
+	if cn.TCPIdleTimeout != 0 {
+		cm["tcpIdleTimeout"] = cn.TCPIdleTimeout.String()
+	}
+	if cn.UDPIdleTimeout != 0 && cn.UDPIdleTimeout != defaultConnectionsUDPIdleTimeout {
+		cm["udpIdleTimeout"] = cn.UDPIdleTimeout.String()
+	}
+	if cn.TCPKeepAlive != 0 && cn.TCPKeepAlive != defaultConnectionsTCPKeepAlive {
+		cm["tcpKeepAlive"] = cn.TCPKeepAlive.String()
+	}
+	return cm, nil
+}
+
+// EnvFilters are glob patterns, in the syntax of path.Match, of names of environment variables. When
+// Include isn't empty, only variables with a name that matches one of its patterns are kept. Variables
+// with a name that matches a pattern in Exclude are then removed.
+type EnvFilters struct {
+	Include []string `json:"include,omitempty" yaml:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty" yaml:"exclude,omitempty"`
+}
+
+// ValidateEnvPattern returns an error if the given string isn't a valid pattern of an EnvFilters.
+func ValidateEnvPattern(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("%q is not a valid pattern: %w", pattern, err)
+	}
+	return nil
+}
+
+func (ef *EnvFilters) merge(o *EnvFilters) {
+	if len(o.Include) > 0 {
+		ef.Include = o.Include
+	}
+	if len(o.Exclude) > 0 {
+		ef.Exclude = o.Exclude
+	}
+}
+
+// UnmarshalYAML parses the envFilters YAML
+func (ef *EnvFilters) UnmarshalYAML(node *yaml.Node) (err error) {
+	if err := expectObject("envFilters", node); err != nil {
+		return err
+	}
+	var errs configErrors
+	ms := node.Content
+	top := len(ms)
+	for i := 0; i < top; i += 2 {
+		kv, err := stringKey(ms[i])
+		if err != nil {
+			errs.add(err)
+			continue
+		}
+		v := ms[i+1]
+		switch kv {
+		case "include", "exclude":
+			patterns, ok := errs.stringListValue("envFilters."+kv, "patterns", v)
+			if !ok {
+				continue
+			}
+			for pi, pattern := range patterns {
+				if err := ValidateEnvPattern(pattern); err != nil {
+					errs.addf(v.Content[pi], "envFilters.%s: %v", kv, err)
+					ok = false
+				}
+			}
+			if !ok {
+				continue
+			}
+			if kv == "include" {
+				ef.Include = patterns
+			} else {
+				ef.Exclude = patterns
+			}
+		default:
+			errs.unknownKey("envFilters", kv, ms[i], reflect.TypeOf(EnvFilters{}))
+		}
+	}
+	return errs.err()
+}
+
+// MarshalYAML is not using pointer receiver here, because EnvFilters is not pointer in the Config struct
+func (ef EnvFilters) MarshalYAML() (interface{}, error) {
+	em := make(map[string]interface{})
+	if len(ef.Include) > 0 {
+		em["include"] = ef.Include
+	}
+	if len(ef.Exclude) > 0 {
+		em["exclude"] = ef.Exclude
+	}
+	return em, nil
+}
+
+type DNS struct {
+	// IncludeSuffixes are suffixes of names that the root daemon always resolves in the cluster.
+	IncludeSuffixes []string `json:"includeSuffixes,omitempty" yaml:"includeSuffixes,omitempty"`
+
+	// ExcludeSuffixes are suffixes of names that the root daemon never resolves in the cluster.
+	ExcludeSuffixes []string `json:"excludeSuffixes,omitempty" yaml:"excludeSuffixes,omitempty"`
+
+	// LookupTimeout is the maximum time that the root daemon waits for a cluster side lookup. A
+	// lookup-timeout in the kubeconfig extension of the cluster takes precedence.
+	LookupTimeout time.Duration `json:"lookupTimeout,omitempty" yaml:"lookupTimeout,omitempty"`
+
+	// NegativeCacheTTL is the time that the root daemon caches a failed cluster side lookup.
+	NegativeCacheTTL time.Duration `json:"negativeCacheTTL,omitempty" yaml:"negativeCacheTTL,omitempty"`
+
+	// MaxTTL is the maximum time that the root daemon caches a successful cluster side lookup.
+	MaxTTL time.Duration `json:"maxTTL,omitempty" yaml:"maxTTL,omitempty"`
+}
+
+func (d *DNS) merge(o *DNS) {
+	if len(o.IncludeSuffixes) > 0 {
+		d.IncludeSuffixes = o.IncludeSuffixes
+	}
+	if len(o.ExcludeSuffixes) > 0 {
+		d.ExcludeSuffixes = o.ExcludeSuffixes
+	}
+	if o.LookupTimeout != 0 {
+		d.LookupTimeout = o.LookupTimeout
+	}
+	if o.NegativeCacheTTL != 0 {
+		d.NegativeCacheTTL = o.NegativeCacheTTL
+	}
+	if o.MaxTTL != 0 {
+		d.MaxTTL = o.MaxTTL
+	}
+}
+
+// UnmarshalYAML parses the dns YAML
+func (d *DNS) UnmarshalYAML(node *yaml.Node) (err error) {
+	if err := expectObject("dns", node); err != nil {
+		return err
+	}
+	var errs configErrors
+	ms := node.Content
+	top := len(ms)
+	for i := 0; i < top; i += 2 {
+		kv, err := stringKey(ms[i])
+		if err != nil {
+			errs.add(err)
+			continue
+		}
+		v := ms[i+1]
+		path := "dns." + kv
+		switch kv {
+		case "includeSuffixes":
+			d.IncludeSuffixes, _ = errs.stringListValue(path, "suffixes", v)
+		case "excludeSuffixes":
+			d.ExcludeSuffixes, _ = errs.stringListValue(path, "suffixes", v)
+		case "lookupTimeout":
+			d.LookupTimeout, _ = errs.durationValue(path, v)
+		case "negativeCacheTTL":
+			d.NegativeCacheTTL, _ = errs.durationValue(path, v)
+		case "maxTTL":
+			d.MaxTTL, _ = errs.durationValue(path, v)
+		default:
+			errs.unknownKey("dns", kv, ms[i], reflect.TypeOf(DNS{}))
+		}
+	}
+	return errs.err()
+}
+
+// MarshalYAML is not using pointer receiver here, because DNS is not pointer in the Config struct
+func (d DNS) MarshalYAML() (interface{}, error) {
+	dm := make(map[string]interface{})
+	if len(d.IncludeSuffixes) > 0 {
+		dm["includeSuffixes"] = d.IncludeSuffixes
+	}
+	if len(d.ExcludeSuffixes) > 0 {
+		dm["excludeSuffixes"] = d.ExcludeSuffixes
+	}
+	if d.LookupTimeout != 0 {
+		dm["lookupTimeout"] = d.LookupTimeout.String()
+	}
+	if d.NegativeCacheTTL != 0 {
+		dm["negativeCacheTTL"] = d.NegativeCacheTTL.String()
+	}
+	if d.MaxTTL != 0 {
+		dm["maxTTL"] = d.MaxTTL.String()
+	}
+	return dm, nil
+}
+
 var parseContext context.Context
 
 type parsedFile struct{}
@@ -638,25 +1508,82 @@ type parsedFile struct{}
 func withLoc(s string, n *yaml.Node) string {
 	if parseContext != nil {
 		if fileName, ok := parseContext.Value(parsedFile{}).(string); ok {
-			return fmt.Sprintf("file %s, line %d: %s", fileName, n.Line, s)
+			return fmt.Sprintf("%s:%d:%d: %s", fileName, n.Line, n.Column, s)
 		}
 	}
-	return fmt.Sprintf("line %d: %s", n.Line, s)
+	return fmt.Sprintf("line %d, column %d: %s", n.Line, n.Column, s)
 }
 
 type configKey struct{}
 
+// configHolder holds the Config of a context, so that it can be replaced when the config files change.
+type configHolder struct {
+	config atomic.Value // *Config
+
+	// parent is set when the Config is the Config of the parent with the overrides of kubeContext applied.
+	parent      *configHolder
+	kubeContext string
+}
+
+// derivedConfig is the Config of a configHolder that has a parent, and the Config of the parent that
+// it was derived from.
+type derivedConfig struct {
+	from   *Config
+	config *Config
+}
+
+func (h *configHolder) get() *Config {
+	if h.parent == nil {
+		return h.config.Load().(*Config)
+	}
+	from := h.parent.get()
+	if d, ok := h.config.Load().(*derivedConfig); ok && d.from == from {
+		return d.config
+	}
+	d := &derivedConfig{from: from, config: from.ForContext(h.kubeContext)}
+	h.config.Store(d)
+	return d.config
+}
+
 // WithConfig returns a context with the given Config
 func WithConfig(ctx context.Context, config *Config) context.Context {
-	return context.WithValue(ctx, configKey{}, config)
+	h := &configHolder{}
+	h.config.Store(config)
+	return context.WithValue(ctx, configKey{}, h)
+}
+
+// WithKubeContextConfig returns a context with the Config of the given context with the overrides for
+// the given kube context applied. The overrides are applied again when the Config of the given context
+// is replaced. The given context is returned when it has no Config.
+func WithKubeContextConfig(ctx context.Context, kubeContext string) context.Context {
+	h, ok := ctx.Value(configKey{}).(*configHolder)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, configKey{}, &configHolder{parent: h, kubeContext: kubeContext})
 }
 
 func GetConfig(ctx context.Context) *Config {
-	config, ok := ctx.Value(configKey{}).(*Config)
+	h, ok := ctx.Value(configKey{}).(*configHolder)
 	if !ok {
 		return nil
 	}
-	return config
+	return h.get()
+}
+
+// ReplaceConfig replaces the Config of the given context, and of all contexts derived from it, and returns
+// false if the context has no Config. A Config must not be modified once it's in a context, so that a
+// caller of GetConfig keeps a consistent view of it. The overrides of a context that was created using
+// WithKubeContextConfig are applied to the given Config.
+func ReplaceConfig(ctx context.Context, config *Config) bool {
+	h, ok := ctx.Value(configKey{}).(*configHolder)
+	if ok {
+		for h.parent != nil {
+			h = h.parent
+		}
+		h.config.Store(config)
+	}
+	return ok
 }
 
 // GetConfigFile gets the path to the configFile as stored in filelocation.AppUserConfigDir
@@ -691,12 +1618,15 @@ func GetDefaultConfig(c context.Context) Config {
 			SystemaPort:     defaultCloudSystemAPort,
 		},
 		Grpc: Grpc{},
+		Connections: Connections{
+			UDPIdleTimeout: defaultConnectionsUDPIdleTimeout,
+			TCPKeepAlive:   defaultConnectionsTCPKeepAlive,
+		},
 	}
 	env := GetEnv(c)
 	cfg.Images.Registry = env.Registry
-	cfg.Images.WebhookRegistry = env.Registry
 	cfg.Images.AgentImage = env.AgentImage
-	cfg.Images.WebhookAgentImage = env.AgentImage
+	cfg.Manager.Namespace = env.ManagerNamespace
 	return cfg
 }
 
@@ -709,52 +1639,13 @@ func LoadConfig(c context.Context) (cfg *Config, err error) {
 		}
 	}()
 
-	var dirs []string
-	dirs, err = filelocation.AppSystemConfigDirs(c)
+	layers, err := loadConfigLayers(c)
 	if err != nil {
 		return nil, err
 	}
-
-	dflt := GetDefaultConfig(c)
-	cfg = &dflt
-	readMerge := func(dir string) error {
-		if stat, err := os.Stat(dir); err != nil || !stat.IsDir() { // skip unless directory
-			return nil
-		}
-		fileName := filepath.Join(dir, configFile)
-		bs, err := os.ReadFile(fileName)
-		if err != nil {
-			if os.IsNotExist(err) {
-				err = nil
-			}
-			return err
-		}
-		parseContext = context.WithValue(c, parsedFile{}, fileName)
-		defer func() {
-			parseContext = nil
-		}()
-		fileConfig := Config{}
-		if err = yaml.Unmarshal(bs, &fileConfig); err != nil {
-			return err
-		}
-		cfg.Merge(&fileConfig)
-		return nil
-	}
-
-	for _, dir := range dirs {
-		if err = readMerge(dir); err != nil {
-			return nil, err
-		}
-	}
-	appDir, err := filelocation.AppUserConfigDir(c)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return cfg, nil
-		}
-		return nil, err
-	}
-	if err = readMerge(appDir); err != nil {
-		return nil, err
+	cfg = layers[0].Config
+	for _, layer := range layers[1:] {
+		cfg.Merge(layer.Config)
 	}
 
 	// Sanity check
@@ -764,3 +1655,30 @@ func LoadConfig(c context.Context) (cfg *Config, err error) {
 
 	return cfg, nil
 }
+
+// loadConfigLayers returns the layers that LoadConfig merges, in order of precedence. The first layer
+// is the default configuration. It's followed by one layer for each environment variable that changes
+// a default, and one layer for each config file that exists. The returned error describes the problems
+// of all config files that can't be parsed.
+func loadConfigLayers(c context.Context) ([]*ConfigLayer, error) {
+	files, err := ConfigFiles(c)
+	if err != nil {
+		return nil, err
+	}
+
+	dflt := GetDefaultConfig(c)
+	layers := append([]*ConfigLayer{{Source: SourceDefault, Config: &dflt}}, envConfigLayers(GetEnv(c))...)
+	var errs configErrors
+	for _, fileName := range files {
+		fileConfig, err := ParseConfigFile(c, fileName)
+		if err != nil {
+			errs.add(err)
+			continue
+		}
+		layers = append(layers, &ConfigLayer{Source: fileName, Config: fileConfig})
+	}
+	if err = errs.err(); err != nil {
+		return nil, err
+	}
+	return layers, nil
+}
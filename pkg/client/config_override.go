@@ -0,0 +1,161 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// nonOverridableKeys are the keys of the config that apply to the daemons rather than to a session, and
+// therefore can't be overridden for a kube context.
+var nonOverridableKeys = map[string]struct{}{
+	"logLevels":         {},
+	"cloud":             {},
+	"grpc":              {},
+	"tracing":           {},
+	"telemetry":         {},
+	"metrics":           {},
+	"connections":       {},
+	"userDaemonAddress": {},
+	"overrides":         {},
+}
+
+// ConfigOverride is a part of the config that applies to the sessions with the kube contexts whose names
+// match its pattern. The values of the override are merged over the values of the top-level config.
+type ConfigOverride struct {
+	// Pattern is the name of a kube context, or a pattern in which "*" matches any sequence of characters
+	// and "?" matches any single character.
+	Pattern string
+
+	// Source is the config file that declares the override.
+	Source string
+
+	// Config holds the values of the override. Its zero values are not part of the override.
+	Config *Config
+}
+
+// decodeOverrides decodes the overrides section of a config file, which maps kube context names or
+// patterns to the values that override the top-level config.
+func decodeOverrides(node *yaml.Node) ([]*ConfigOverride, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, errors.New(withLoc(fmt.Sprintf("overrides must be an object with kube context names or patterns as keys, got %s", kindName(node)), node))
+	}
+	var source string
+	if parseContext != nil {
+		source, _ = parseContext.Value(parsedFile{}).(string)
+	}
+	var errs configErrors
+	ms := node.Content
+	overrides := make([]*ConfigOverride, 0, len(ms)/2)
+	for i := 0; i < len(ms); i += 2 {
+		pattern, err := stringKey(ms[i])
+		if err != nil {
+			errs.add(err)
+			continue
+		}
+		v := ms[i+1]
+		if v.Kind != yaml.MappingNode {
+			errs.addf(v, "overrides.%s: expected an object, got %s", pattern, kindName(v))
+			continue
+		}
+		overridable := true
+		for j := 0; j < len(v.Content); j += 2 {
+			if _, ok := nonOverridableKeys[v.Content[j].Value]; ok {
+				errs.addf(v.Content[j], "overrides.%s: %s can't be overridden for a kube context", pattern, v.Content[j].Value)
+				overridable = false
+			}
+		}
+		if !overridable {
+			continue
+		}
+		cfg := &Config{}
+		if err = v.Decode(cfg); err != nil {
+			errs.add(err)
+			continue
+		}
+		overrides = append(overrides, &ConfigOverride{Pattern: pattern, Source: source, Config: cfg})
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// Matches returns true if the pattern of the override matches the given kube context.
+func (o *ConfigOverride) Matches(kubeContext string) bool {
+	var sb strings.Builder
+	sb.WriteByte('^')
+	for _, r := range o.Pattern {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteByte('.')
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteByte('$')
+	return regexp.MustCompile(sb.String()).MatchString(kubeContext)
+}
+
+// specificity returns how specific the pattern of the override is. A name without wildcards is the most
+// specific, and a pattern is more specific than another pattern when it has more literal characters.
+func (o *ConfigOverride) specificity() int {
+	wildcards := strings.Count(o.Pattern, "*") + strings.Count(o.Pattern, "?")
+	if wildcards == 0 {
+		return math.MaxInt
+	}
+	return len(o.Pattern) - wildcards
+}
+
+// MatchingOverrides returns the overrides that match the given kube context, ordered so that the most
+// specific override comes last. Overrides that are equally specific retain the order of the config
+// files, so that an override of the user's config file takes precedence over one of a system config file.
+func (c *Config) MatchingOverrides(kubeContext string) []*ConfigOverride {
+	var matches []*ConfigOverride
+	for _, o := range c.Overrides {
+		if o.Matches(kubeContext) {
+			matches = append(matches, o)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].specificity() < matches[j].specificity()
+	})
+	return matches
+}
+
+// ForContext returns the config of a session with the given kube context. That's this config when no
+// override matches the kube context, or else a copy of it with the matching overrides merged over it,
+// in order of specificity. A list of an override replaces the list of the top-level config.
+func (c *Config) ForContext(kubeContext string) *Config {
+	matches := c.MatchingOverrides(kubeContext)
+	if len(matches) == 0 {
+		return c
+	}
+	cfg := *c
+	for _, o := range matches {
+		cfg.Merge(o.Config)
+	}
+	return &cfg
+}
+
+// OverrideConfigLayers returns one layer for each override of the given layers that matches the given
+// kube context, in the order in which ForContext merges them.
+func OverrideConfigLayers(layers []*ConfigLayer, kubeContext string) []*ConfigLayer {
+	all := &Config{}
+	for _, layer := range layers {
+		all.Overrides = append(all.Overrides, layer.Config.Overrides...)
+	}
+	matches := all.MatchingOverrides(kubeContext)
+	overrideLayers := make([]*ConfigLayer, len(matches))
+	for i, o := range matches {
+		overrideLayers[i] = &ConfigLayer{Source: fmt.Sprintf("override %q in %s", o.Pattern, o.Source), Config: o.Config}
+	}
+	return overrideLayers
+}
@@ -0,0 +1,192 @@
+package client
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+func loadOverridesConfig(t *testing.T, sysConfig, userConfig string) (context.Context, *Config) {
+	tmp := t.TempDir()
+	sys := filepath.Join(tmp, "sys")
+	user := filepath.Join(tmp, "user")
+	require.NoError(t, os.MkdirAll(sys, 0700))
+	require.NoError(t, os.MkdirAll(user, 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(sys, configFile), []byte(sysConfig), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(user, configFile), []byte(userConfig), 0600))
+
+	c := dlog.NewTestContext(t, false)
+	c = filelocation.WithAppSystemConfigDirs(c, []string{sys})
+	c = filelocation.WithAppUserConfigDir(c, user)
+	env, err := LoadEnv(c)
+	require.NoError(t, err)
+	c = WithEnv(c, env)
+	cfg, err := LoadConfig(c)
+	require.NoError(t, err)
+	return c, cfg
+}
+
+func TestConfig_ForContext(t *testing.T) {
+	_, cfg := loadOverridesConfig(t, `
+images:
+  registry: docker.io/datawire
+alsoProxy:
+  - 10.88.0.0/16
+overrides:
+  "*":
+    timeouts:
+      apply: 40s
+  "*-prod":
+    images:
+      registry: prod.example.com/tp
+    manager:
+      namespace: tp-prod
+`, `
+timeouts:
+  apply: 20s
+mappedNamespaces:
+  - default
+overrides:
+  eu-*-prod:
+    alsoProxy:
+      - 10.99.0.0/16
+      - 10.98.0.0/16
+    manager:
+      namespace: tp-eu
+  eu-west-prod:
+    mappedNamespaces:
+      - team-a
+      - team-b
+  "*-prod":
+    images:
+      registry: registry.example.com/tp
+`)
+
+	t.Run("no match", func(t *testing.T) {
+		// The catch-all "*" matches every context, so use a config without it
+		noCatchAll := *cfg
+		noCatchAll.Overrides = nil
+		for _, o := range cfg.Overrides {
+			if o.Pattern != "*" {
+				noCatchAll.Overrides = append(noCatchAll.Overrides, o)
+			}
+		}
+		assert.Empty(t, noCatchAll.MatchingOverrides("dev"))
+		assert.Same(t, &noCatchAll, noCatchAll.ForContext("dev"))
+	})
+
+	t.Run("catch-all", func(t *testing.T) {
+		dev := cfg.ForContext("dev")
+		assert.Equal(t, 40*time.Second, dev.Timeouts.PrivateApply)
+		assert.Equal(t, "docker.io/datawire", dev.Images.Registry)
+		assert.Equal(t, 20*time.Second, cfg.Timeouts.PrivateApply, "the top-level config must not be modified")
+	})
+
+	t.Run("most specific wins", func(t *testing.T) {
+		var patterns []string
+		for _, o := range cfg.MatchingOverrides("eu-west-prod") {
+			patterns = append(patterns, o.Pattern)
+		}
+		// "*-prod" of the user file comes after the one of the system file because they're equally specific
+		assert.Equal(t, []string{"*", "*-prod", "*-prod", "eu-*-prod", "eu-west-prod"}, patterns)
+
+		prod := cfg.ForContext("eu-west-prod")
+		assert.Equal(t, "registry.example.com/tp", prod.Images.Registry)
+		assert.Equal(t, "tp-eu", prod.Manager.Namespace)
+		assert.Equal(t, 40*time.Second, prod.Timeouts.PrivateApply)
+
+		usProd := cfg.ForContext("us-east-prod")
+		assert.Equal(t, "tp-prod", usProd.Manager.Namespace)
+	})
+
+	t.Run("lists replace the top-level lists", func(t *testing.T) {
+		prod := cfg.ForContext("eu-west-prod")
+		assert.Equal(t, []string{"team-a", "team-b"}, prod.MappedNamespaces)
+		require.Len(t, prod.AlsoProxy, 2)
+		assert.Equal(t, "10.99.0.0/16", prod.AlsoProxy[0].String())
+		assert.Equal(t, "10.98.0.0/16", prod.AlsoProxy[1].String())
+
+		usProd := cfg.ForContext("us-east-prod")
+		assert.Equal(t, []string{"default"}, usProd.MappedNamespaces)
+		require.Len(t, usProd.AlsoProxy, 1)
+		assert.Equal(t, "10.88.0.0/16", usProd.AlsoProxy[0].String())
+	})
+}
+
+func TestConfigOverride_Matches(t *testing.T) {
+	tests := []struct {
+		pattern     string
+		kubeContext string
+		expected    bool
+	}{
+		{"dev", "dev", true},
+		{"dev", "dev2", false},
+		{"*-prod", "arn:aws:eks:eu-west-1:1234:cluster/shop-prod", true},
+		{"*-prod", "prod", false},
+		{"gke_*_us-?", "gke_shop_us-1", true},
+		{"gke_*_us-?", "gke_shop_us-12", false},
+		{"kind.[a]", "kind.[a]", true},
+		{"kind.[a]", "kinda", false},
+	}
+	for _, tt := range tests {
+		o := &ConfigOverride{Pattern: tt.pattern}
+		assert.Equal(t, tt.expected, o.Matches(tt.kubeContext), "%s matches %s", tt.pattern, tt.kubeContext)
+	}
+}
+
+func TestGetConfig_invalidOverrides(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		errMsg string
+	}{
+		{"not an object", "overrides:\n  - dev\n", "overrides must be an object"},
+		{"daemon setting", "overrides:\n  dev:\n    logLevels:\n      userDaemon: debug\n", "config.yml:3:5: overrides.dev: logLevels can't be overridden"},
+		{"nested", "overrides:\n  dev:\n    overrides:\n      x: {}\n", "overrides.dev: overrides can't be overridden"},
+		{"invalid value", "overrides:\n  dev:\n    timeouts:\n      apply: never\n", `config.yml:4:14: timeouts.apply: expected a duration, got "never"`},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tmp := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte(tt.config), 0600))
+			c := dlog.NewTestContext(t, false)
+			c = filelocation.WithAppSystemConfigDirs(c, nil)
+			c = filelocation.WithAppUserConfigDir(c, tmp)
+			c = WithEnv(c, &Env{})
+			_, err := LoadConfig(c)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+}
+
+func TestWithKubeContextConfig(t *testing.T) {
+	c, cfg := loadOverridesConfig(t, "", `
+overrides:
+  "*-prod":
+    manager:
+      namespace: tp-prod
+`)
+	c = WithConfig(c, cfg)
+	pc := WithKubeContextConfig(c, "shop-prod")
+	assert.Equal(t, "tp-prod", GetConfig(pc).Manager.Namespace)
+	assert.Same(t, GetConfig(pc), GetConfig(pc))
+	assert.Equal(t, "ambassador", GetConfig(c).Manager.Namespace)
+
+	// A replaced config is seen with the overrides applied
+	replaced := *cfg
+	replaced.Timeouts.PrivateApply = time.Minute
+	require.True(t, ReplaceConfig(pc, &replaced))
+	assert.Same(t, &replaced, GetConfig(c))
+	assert.Equal(t, time.Minute, GetConfig(pc).Timeouts.PrivateApply)
+	assert.Equal(t, "tp-prod", GetConfig(pc).Manager.Namespace)
+}
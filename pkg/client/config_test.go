@@ -1,6 +1,9 @@
 package client
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"testing"
@@ -14,6 +17,7 @@ import (
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
 )
 
 func TestGetConfig(t *testing.T) {
@@ -30,6 +34,26 @@ timeouts:
   apply: 33s
 logLevels:
   userDaemon: debug
+mappedNamespaces:
+  - default
+  - blue
+alsoProxy:
+  - 10.88.0.0/16
+  - fd00:88::/48
+neverProxy:
+  - 10.88.1.0/24
+  - fd00:88:1::/64
+allowConflictingSubnets:
+  - 10.8.0.0/16
+idleTimeout: 2h
+outboundTrafficPolicy: mappedNamespaces
+tracing:
+  enabled: true
+dns:
+  includeSuffixes:
+    - .corp.example.com
+  excludeSuffixes:
+    - .com
 `,
 		/* user */ `
 timeouts:
@@ -41,6 +65,14 @@ images:
   registry: testregistry.io
   agentImage: ambassador-telepresence-client-image:0.0.1
   webhookAgentImage: ambassador-telepresence-webhook-image:0.0.2
+  clientImage: telepresence-client:0.0.3
+dns:
+  excludeSuffixes:
+    - .vpn.corp.example.com
+  lookupTimeout: 2s
+  negativeCacheTTL: 500ms
+manager:
+  namespace: team-tp
 `,
 	}
 
@@ -77,6 +109,27 @@ images:
 	assert.Equal(t, "testregistry.io", cfg.Images.Registry)                                      // from user
 	assert.Equal(t, "ambassador-telepresence-client-image:0.0.1", cfg.Images.AgentImage)         // from user
 	assert.Equal(t, "ambassador-telepresence-webhook-image:0.0.2", cfg.Images.WebhookAgentImage) // from user
+	assert.Equal(t, "telepresence-client:0.0.3", cfg.Images.ClientImage)                         // from user
+
+	assert.Equal(t, []string{"default", "blue"}, cfg.MappedNamespaces) // from sys2
+	assert.True(t, cfg.Tracing.Enabled)                                // from sys2
+	require.Len(t, cfg.AlsoProxy, 2)                                   // from sys2
+	assert.Equal(t, "10.88.0.0/16", cfg.AlsoProxy[0].String())
+	assert.Equal(t, "fd00:88::/48", cfg.AlsoProxy[1].String())
+	require.Len(t, cfg.NeverProxy, 2) // from sys2
+	assert.Equal(t, "10.88.1.0/24", cfg.NeverProxy[0].String())
+	assert.Equal(t, "fd00:88:1::/64", cfg.NeverProxy[1].String())
+	require.Len(t, cfg.AllowConflictingSubnets, 1) // from sys2
+	assert.Equal(t, "10.8.0.0/16", cfg.AllowConflictingSubnets[0].String())
+	assert.Equal(t, 2*time.Hour, cfg.IdleTimeout)                                     // from sys2
+	assert.Equal(t, OutboundTrafficPolicyMappedNamespaces, cfg.OutboundTrafficPolicy) // from sys2
+
+	assert.Equal(t, []string{".corp.example.com"}, cfg.DNS.IncludeSuffixes)     // from sys2
+	assert.Equal(t, []string{".vpn.corp.example.com"}, cfg.DNS.ExcludeSuffixes) // from user
+	assert.Equal(t, 2*time.Second, cfg.DNS.LookupTimeout)                       // from user
+	assert.Equal(t, 500*time.Millisecond, cfg.DNS.NegativeCacheTTL)             // from user
+
+	assert.Equal(t, "team-tp", cfg.Manager.Namespace) // from user
 }
 
 func Test_ConfigMarshalYAML(t *testing.T) {
@@ -91,6 +144,23 @@ func Test_ConfigMarshalYAML(t *testing.T) {
 	cfg.LogLevels.UserDaemon = logrus.TraceLevel
 	mrSize, _ := resource.ParseQuantity("20Mi")
 	cfg.Grpc.MaxReceiveSize = &mrSize
+	cfg.MappedNamespaces = []string{"blue", "green"}
+	_, apNet, _ := net.ParseCIDR("192.168.10.0/24")
+	cfg.AlsoProxy = []*iputil.Subnet{(*iputil.Subnet)(apNet)}
+	_, npNet, _ := net.ParseCIDR("192.168.10.128/25")
+	cfg.NeverProxy = []*iputil.Subnet{(*iputil.Subnet)(npNet)}
+	cfg.AllowConflictingSubnets = []*iputil.Subnet{(*iputil.Subnet)(apNet)}
+	cfg.IdleTimeout = 90 * time.Minute
+	cfg.OutboundTrafficPolicy = OutboundTrafficPolicyMappedNamespaces
+	cfg.SOCKS5Address = "127.0.0.1:1081"
+	cfg.Tracing.Enabled = true
+	cfg.Metrics.Address = "127.0.0.1:9090"
+	cfg.DNS.IncludeSuffixes = []string{".corp.example.com"}
+	cfg.DNS.ExcludeSuffixes = []string{".com", ".io"}
+	cfg.DNS.LookupTimeout = 3 * time.Second
+	cfg.DNS.NegativeCacheTTL = 2 * time.Second
+	cfg.DNS.MaxTTL = 30 * time.Second
+	cfg.Manager.Namespace = "team-tp"
 	cfgBytes, err := yaml.Marshal(cfg)
 	require.NoError(t, err)
 
@@ -104,3 +174,398 @@ func Test_ConfigMarshalYAML(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, &cfg, cfg2)
 }
+
+func TestGetConfig_invalidAlsoProxy(t *testing.T) {
+	tmp := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte("alsoProxy:\n  - 10.0.0.0/8\n  - 10.0.0/8\n"), 0600))
+
+	c := dlog.NewTestContext(t, false)
+	c = filelocation.WithAppSystemConfigDirs(c, nil)
+	c = filelocation.WithAppUserConfigDir(c, tmp)
+	env, err := LoadEnv(c)
+	require.NoError(t, err)
+	c = WithEnv(c, env)
+
+	_, err = LoadConfig(c)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"10.0.0/8"`)
+}
+
+func TestGetConfig_managerNamespace(t *testing.T) {
+	c := dlog.NewTestContext(t, false)
+	c = filelocation.WithAppSystemConfigDirs(c, nil)
+	env, err := LoadEnv(c)
+	require.NoError(t, err)
+	c = WithEnv(c, env)
+
+	// The namespace defaults to the one of the environment
+	c = filelocation.WithAppUserConfigDir(c, t.TempDir())
+	cfg, err := LoadConfig(c)
+	require.NoError(t, err)
+	assert.Equal(t, env.ManagerNamespace, cfg.Manager.Namespace)
+
+	tmp := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte("manager:\n  namespace: Not_Valid\n"), 0600))
+	c = filelocation.WithAppUserConfigDir(c, tmp)
+	_, err = LoadConfig(c)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"Not_Valid" is not a valid namespace name`)
+}
+
+func TestGetConfig_interceptMissingWebhook(t *testing.T) {
+	c := dlog.NewTestContext(t, false)
+	c = filelocation.WithAppSystemConfigDirs(c, nil)
+	env, err := LoadEnv(c)
+	require.NoError(t, err)
+	c = WithEnv(c, env)
+
+	// An intercept fails by default when the mutating webhook is missing
+	cfg, err := LoadConfig(filelocation.WithAppUserConfigDir(c, t.TempDir()))
+	require.NoError(t, err)
+	assert.Equal(t, "", cfg.Intercept.MissingWebhook)
+
+	tmp := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte("intercept:\n  missingWebhook: patch\n"), 0600))
+	cfg, err = LoadConfig(filelocation.WithAppUserConfigDir(c, tmp))
+	require.NoError(t, err)
+	assert.Equal(t, MissingWebhookPatch, cfg.Intercept.MissingWebhook)
+
+	tmp = t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte("intercept:\n  missingWebhook: ignore\n"), 0600))
+	_, err = LoadConfig(filelocation.WithAppUserConfigDir(c, tmp))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `intercept.missingWebhook must be "error" or "patch", got "ignore"`)
+}
+
+func TestGetConfig_interceptDefaultTTL(t *testing.T) {
+	c := dlog.NewTestContext(t, false)
+	c = filelocation.WithAppSystemConfigDirs(c, nil)
+	env, err := LoadEnv(c)
+	require.NoError(t, err)
+	c = WithEnv(c, env)
+
+	// Intercepts never expire by default
+	cfg, err := LoadConfig(filelocation.WithAppUserConfigDir(c, t.TempDir()))
+	require.NoError(t, err)
+	assert.Zero(t, cfg.Intercept.DefaultTTL)
+
+	tmp := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte("intercept:\n  defaultTTL: 2h\n"), 0600))
+	cfg, err = LoadConfig(filelocation.WithAppUserConfigDir(c, tmp))
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Hour, cfg.Intercept.DefaultTTL)
+
+	tmp = t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte("intercept:\n  defaultTTL: -1h\n"), 0600))
+	_, err = LoadConfig(filelocation.WithAppUserConfigDir(c, tmp))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `intercept.defaultTTL must be a positive duration, got "-1h"`)
+}
+
+func TestGetConfig_connections(t *testing.T) {
+	c := dlog.NewTestContext(t, false)
+	c = filelocation.WithAppSystemConfigDirs(c, nil)
+	env, err := LoadEnv(c)
+	require.NoError(t, err)
+	c = WithEnv(c, env)
+
+	// The defaults track any number of connections, forget UDP flows after 5 seconds, and send TCP
+	// keep-alives every 15 seconds
+	cfg, err := LoadConfig(filelocation.WithAppUserConfigDir(c, t.TempDir()))
+	require.NoError(t, err)
+	assert.Equal(t, Connections{UDPIdleTimeout: 5 * time.Second, TCPKeepAlive: 15 * time.Second}, cfg.Connections)
+
+	tmp := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile),
+		[]byte("connections:\n  maxTracked: 2000\n  eviction: refuse\n  tcpIdleTimeout: 30m\n  udpIdleTimeout: 1m\n  tcpKeepAlive: -1s\n"), 0600))
+	cfg, err = LoadConfig(filelocation.WithAppUserConfigDir(c, tmp))
+	require.NoError(t, err)
+	assert.Equal(t, Connections{
+		MaxTracked:     2000,
+		Eviction:       ConnectionEvictionRefuse,
+		TCPIdleTimeout: 30 * time.Minute,
+		UDPIdleTimeout: time.Minute,
+		TCPKeepAlive:   -time.Second,
+	}, cfg.Connections)
+
+	tmp = t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile),
+		[]byte("connections:\n  maxTracked: -1\n  eviction: fifo\n  tcpIdleTimeout: -1s\n  udpIdleTimeout: [1m]\n"), 0600))
+	_, err = LoadConfig(filelocation.WithAppUserConfigDir(c, tmp))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `connections.maxTracked must be zero or a positive number, got -1`)
+	assert.Contains(t, err.Error(), `connections.eviction must be "lru" or "refuse", got "fifo"`)
+	assert.Contains(t, err.Error(), `connections.tcpIdleTimeout must be a positive duration, got "-1s"`)
+	assert.Contains(t, err.Error(), `connections.udpIdleTimeout: expected a duration, got a list`)
+}
+
+func TestGetConfig_envFilters(t *testing.T) {
+	c := dlog.NewTestContext(t, false)
+	c = filelocation.WithAppSystemConfigDirs(c, nil)
+	env, err := LoadEnv(c)
+	require.NoError(t, err)
+	c = WithEnv(c, env)
+
+	tmp := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte("envFilters:\n  include: [\"APP_*\", \"KUBERNETES_*\"]\n  exclude: [\"*_SECRET\"]\n"), 0600))
+	cfg, err := LoadConfig(filelocation.WithAppUserConfigDir(c, tmp))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"APP_*", "KUBERNETES_*"}, cfg.EnvFilters.Include)
+	assert.Equal(t, []string{"*_SECRET"}, cfg.EnvFilters.Exclude)
+
+	tmp = t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte("envFilters:\n  exclude: \"KUBERNETES_*\"\n"), 0600))
+	_, err = LoadConfig(filelocation.WithAppUserConfigDir(c, tmp))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), filepath.Join(tmp, configFile)+`:2:12: envFilters.exclude: expected a list of patterns, got "KUBERNETES_*"`)
+
+	tmp = t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte("envFilters:\n  exclude: [\"KUBERNETES_[\"]\n"), 0600))
+	_, err = LoadConfig(filelocation.WithAppUserConfigDir(c, tmp))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `envFilters.exclude: "KUBERNETES_[" is not a valid pattern`)
+}
+
+func TestGetConfig_invalidImages(t *testing.T) {
+	c := dlog.NewTestContext(t, false)
+	c = filelocation.WithAppSystemConfigDirs(c, nil)
+	env, err := LoadEnv(c)
+	require.NoError(t, err)
+	c = WithEnv(c, env)
+
+	for _, images := range []string{
+		"images:\n  registry: Registry.Example.com/\n",
+		"images:\n  agentImage: tel2:bad tag\n",
+		"images:\n  webhookAgentImage: ':2.4.5'\n",
+		"images:\n  agentImagePullSecrets: registry-creds\n",
+		"images:\n  agentImagePullSecrets: [Registry_Creds]\n",
+	} {
+		tmp := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte(images), 0600))
+		_, err = LoadConfig(filelocation.WithAppUserConfigDir(c, tmp))
+		require.Error(t, err, images)
+		assert.Contains(t, err.Error(), filepath.Join(tmp, configFile)+":2:", images)
+		assert.Contains(t, err.Error(), ": images.", images)
+	}
+}
+
+func TestLoadEnv_invalidImages(t *testing.T) {
+	c := dlog.NewTestContext(t, false)
+	t.Setenv("TELEPRESENCE_REGISTRY", "localhost:5000")
+	t.Setenv("TELEPRESENCE_AGENT_IMAGE", "tel2:custom")
+	env, err := LoadEnv(c)
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:5000", env.Registry)
+	assert.Equal(t, "tel2:custom", env.AgentImage)
+
+	t.Setenv("TELEPRESENCE_REGISTRY", "localhost:5000/")
+	_, err = LoadEnv(c)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "TELEPRESENCE_REGISTRY")
+
+	t.Setenv("TELEPRESENCE_REGISTRY", "localhost:5000")
+	t.Setenv("TELEPRESENCE_AGENT_IMAGE", "Tel2")
+	_, err = LoadEnv(c)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "TELEPRESENCE_AGENT_IMAGE")
+}
+
+func TestGetConfig_agentImagePullSecrets(t *testing.T) {
+	c := dlog.NewTestContext(t, false)
+	c = filelocation.WithAppSystemConfigDirs(c, nil)
+	env, err := LoadEnv(c)
+	require.NoError(t, err)
+	c = WithEnv(c, env)
+
+	tmp := t.TempDir()
+	data := "images:\n  agentImagePullSecrets:\n    - registry-creds\n    - mirror-creds\n"
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte(data), 0600))
+	cfg, err := LoadConfig(filelocation.WithAppUserConfigDir(c, tmp))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"registry-creds", "mirror-creds"}, cfg.Images.AgentImagePullSecrets)
+}
+
+func TestGetConfig_timeouts(t *testing.T) {
+	c := dlog.NewTestContext(t, false)
+	c = filelocation.WithAppSystemConfigDirs(c, nil)
+	env, err := LoadEnv(c)
+	require.NoError(t, err)
+	c = WithEnv(c, env)
+
+	for id, key := range timeoutKeys {
+		t.Run(key, func(t *testing.T) {
+			tmp := t.TempDir()
+			data := fmt.Sprintf("timeouts:\n  %s: 1m17s\n", key)
+			require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte(data), 0600))
+			cfg, err := LoadConfig(filelocation.WithAppUserConfigDir(c, tmp))
+			require.NoError(t, err)
+			tc := WithConfig(c, cfg)
+			assert.Equal(t, 77*time.Second, GetTimeout(tc, TimeoutID(id)))
+
+			// The error of an expired timeout names the key that configures it
+			err = timeoutErr{timeoutID: TimeoutID(id), timeoutVal: 77 * time.Second, err: context.DeadlineExceeded}
+			assert.Contains(t, err.Error(), fmt.Sprintf("%q", "timeouts."+key))
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+		})
+	}
+}
+
+func TestGetConfig_invalidTimeouts(t *testing.T) {
+	c := dlog.NewTestContext(t, false)
+	c = filelocation.WithAppSystemConfigDirs(c, nil)
+	env, err := LoadEnv(c)
+	require.NoError(t, err)
+	c = WithEnv(c, env)
+
+	for _, timeouts := range []string{
+		"timeouts:\n  helm: 0\n",
+		"timeouts:\n  helm: -5s\n",
+		"timeouts:\n  helm: soon\n",
+		"timeouts:\n  helm: [10s]\n",
+	} {
+		tmp := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte(timeouts), 0600))
+		_, err = LoadConfig(filelocation.WithAppUserConfigDir(c, tmp))
+		require.Error(t, err, timeouts)
+		assert.Contains(t, err.Error(), filepath.Join(tmp, configFile)+":2:9: timeouts.helm", timeouts)
+	}
+}
+
+func TestGetConfig_unknownTimeout(t *testing.T) {
+	c := dlog.NewTestContext(t, false)
+	c = filelocation.WithAppSystemConfigDirs(c, nil)
+	env, err := LoadEnv(c)
+	require.NoError(t, err)
+	c = WithEnv(c, env)
+
+	tmp := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte("timeouts:\n  agentInstal: 3m\n"), 0600))
+	_, err = LoadConfig(filelocation.WithAppUserConfigDir(c, tmp))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), filepath.Join(tmp, configFile)+
+		`:2:3: unknown key "timeouts.agentInstal", valid keys are agentInstall, apply, clusterConnect`)
+}
+
+func TestGetConfig_grpc(t *testing.T) {
+	c := dlog.NewTestContext(t, false)
+	c = filelocation.WithAppSystemConfigDirs(c, nil)
+	env, err := LoadEnv(c)
+	require.NoError(t, err)
+	c = WithEnv(c, env)
+
+	tmp := t.TempDir()
+	data := "grpc:\n  maxReceiveSize: 10Mi\n  keepAliveTime: 30s\n  keepAliveTimeout: 5s\n"
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte(data), 0600))
+	cfg, err := LoadConfig(filelocation.WithAppUserConfigDir(c, tmp))
+	require.NoError(t, err)
+	mz, ok := cfg.Grpc.MaxReceiveSize.AsInt64()
+	require.True(t, ok)
+	assert.Equal(t, int64(10*1024*1024), mz)
+	assert.Equal(t, 30*time.Second, cfg.Grpc.KeepAliveTime)
+	assert.Equal(t, 5*time.Second, cfg.Grpc.KeepAliveTimeout)
+
+	for _, grpc := range []string{
+		"grpc:\n  keepAliveTime: 0s\n",
+		"grpc:\n  keepAliveTimeout: often\n",
+	} {
+		tmp := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte(grpc), 0600))
+		_, err = LoadConfig(filelocation.WithAppUserConfigDir(c, tmp))
+		require.Error(t, err, grpc)
+		assert.Contains(t, err.Error(), filepath.Join(tmp, configFile)+":2:", grpc)
+		assert.Contains(t, err.Error(), ": grpc.", grpc)
+	}
+}
+
+func TestGetTelemetry(t *testing.T) {
+	c := dlog.NewTestContext(t, false)
+	c = filelocation.WithAppSystemConfigDirs(c, nil)
+	c = WithEnv(c, &Env{})
+
+	cfg, err := LoadConfig(filelocation.WithAppUserConfigDir(c, t.TempDir()))
+	require.NoError(t, err)
+	enabled, source := GetTelemetry(WithConfig(c, cfg))
+	assert.True(t, enabled)
+	assert.Equal(t, TelemetryDefault, source)
+
+	tmp := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte("telemetry:\n  enabled: false\n"), 0600))
+	cfg, err = LoadConfig(filelocation.WithAppUserConfigDir(c, tmp))
+	require.NoError(t, err)
+	enabled, source = GetTelemetry(WithConfig(c, cfg))
+	assert.False(t, enabled)
+	assert.Equal(t, TelemetryConfig, source)
+
+	// The environment takes precedence over the config
+	tmp = t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte("telemetry:\n  enabled: true\n"), 0600))
+	cfg, err = LoadConfig(filelocation.WithAppUserConfigDir(c, tmp))
+	require.NoError(t, err)
+	enabled, source = GetTelemetry(WithConfig(WithEnv(c, &Env{ScoutDisable: "1"}), cfg))
+	assert.False(t, enabled)
+	assert.Equal(t, TelemetryEnvironment, source)
+}
+
+func TestGetConfig_userDaemonAddress(t *testing.T) {
+	c := dlog.NewTestContext(t, false)
+	c = filelocation.WithAppSystemConfigDirs(c, nil)
+	env, err := LoadEnv(c)
+	require.NoError(t, err)
+	c = WithEnv(c, env)
+
+	tmp := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte("userDaemonAddress: tcp://127.0.0.1:4711\n"), 0600))
+	cfg, err := LoadConfig(filelocation.WithAppUserConfigDir(c, tmp))
+	require.NoError(t, err)
+	assert.Equal(t, "tcp://127.0.0.1:4711", cfg.UserDaemonAddress)
+
+	tmp = t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte("userDaemonAddress: tcp://10.0.0.1:4711\n"), 0600))
+	_, err = LoadConfig(filelocation.WithAppUserConfigDir(c, tmp))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), filepath.Join(tmp, configFile)+":1:20: userDaemonAddress:")
+}
+
+func TestGetConfig_metrics(t *testing.T) {
+	c := dlog.NewTestContext(t, false)
+	c = filelocation.WithAppSystemConfigDirs(c, nil)
+	env, err := LoadEnv(c)
+	require.NoError(t, err)
+	c = WithEnv(c, env)
+
+	cfg, err := LoadConfig(filelocation.WithAppUserConfigDir(c, t.TempDir()))
+	require.NoError(t, err)
+	assert.Empty(t, cfg.Metrics.DaemonAddress(false), "metrics are off by default")
+	assert.Empty(t, cfg.Metrics.DaemonAddress(true), "metrics are off by default")
+
+	tmp := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte("metrics:\n  address: 127.0.0.1:9090\n"), 0600))
+	cfg, err = LoadConfig(filelocation.WithAppUserConfigDir(c, tmp))
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:9090", cfg.Metrics.DaemonAddress(false))
+	assert.Equal(t, "127.0.0.1:9091", cfg.Metrics.DaemonAddress(true))
+
+	for _, address := range []string{"0.0.0.0:9090", "127.0.0.1", "localhost:65535"} {
+		tmp = t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte("metrics:\n  address: "+address+"\n"), 0600))
+		_, err = LoadConfig(filelocation.WithAppUserConfigDir(c, tmp))
+		require.Error(t, err, address)
+		assert.Contains(t, err.Error(), filepath.Join(tmp, configFile)+":2:12: metrics.address:")
+	}
+}
+
+func TestGetConfig_invalidOutboundTrafficPolicy(t *testing.T) {
+	tmp := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmp, configFile), []byte("outboundTrafficPolicy: some\n"), 0600))
+
+	c := dlog.NewTestContext(t, false)
+	c = filelocation.WithAppSystemConfigDirs(c, nil)
+	c = filelocation.WithAppUserConfigDir(c, tmp)
+	env, err := LoadEnv(c)
+	require.NoError(t, err)
+	c = WithEnv(c, env)
+
+	_, err = LoadConfig(c)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `outboundTrafficPolicy must be "all" or "mappedNamespaces", got "some"`)
+}
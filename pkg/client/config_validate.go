@@ -0,0 +1,210 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// configErrors are the problems found when parsing a config. The parser collects all of them rather than
+// stopping at the first one, so that a user can correct them all at once.
+type configErrors []error
+
+func (es configErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// add adds the given error, or the errors that it collects, unless it's nil.
+func (es *configErrors) add(err error) {
+	var ces configErrors
+	switch {
+	case err == nil:
+	case errors.As(err, &ces):
+		*es = append(*es, ces...)
+	default:
+		*es = append(*es, err)
+	}
+}
+
+// addf adds an error with the location of the given node.
+func (es *configErrors) addf(n *yaml.Node, format string, args ...interface{}) {
+	*es = append(*es, errors.New(withLoc(fmt.Sprintf(format, args...), n)))
+}
+
+// err returns the collected errors, or nil when there are none.
+func (es configErrors) err() error {
+	if len(es) == 0 {
+		return nil
+	}
+	return es
+}
+
+// unknownKey adds an error for a key of the given section that isn't in the schema of that section. Keys
+// with the "x-" prefix are reserved for extensions and tools, and are ignored.
+func (es *configErrors) unknownKey(section, key string, n *yaml.Node, schema reflect.Type) {
+	if strings.HasPrefix(key, "x-") {
+		return
+	}
+	path, keys := key, schemaKeys(schema)
+	if section != "" {
+		path = section + "." + key
+	}
+	es.addf(n, "unknown key %q, valid keys are %s", path, strings.Join(keys, ", "))
+}
+
+// schemaKeys returns the sorted keys that the YAML of the given struct type may contain.
+func schemaKeys(t reflect.Type) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		if key := yamlKey(t.Field(i)); key != "-" {
+			keys = append(keys, key)
+		}
+	}
+	if t == reflect.TypeOf(Config{}) {
+		keys = append(keys, "overrides")
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// kindName describes the given node in an error message about a value of the wrong type.
+func kindName(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "an object"
+	case yaml.SequenceNode:
+		return "a list"
+	case yaml.AliasNode:
+		return "an alias"
+	default:
+		return strconv.Quote(n.Value)
+	}
+}
+
+// expectObject returns an error unless the given node of the named section is an object.
+func expectObject(section string, n *yaml.Node) error {
+	if n.Kind != yaml.MappingNode {
+		return errors.New(withLoc(fmt.Sprintf("%s: expected an object, got %s", section, kindName(n)), n))
+	}
+	return nil
+}
+
+// stringValue returns the value of the given node, and adds an error unless it's a scalar.
+func (es *configErrors) stringValue(path string, n *yaml.Node) (string, bool) {
+	if n.Kind != yaml.ScalarNode {
+		es.addf(n, "%s: expected a string, got %s", path, kindName(n))
+		return "", false
+	}
+	return n.Value, true
+}
+
+// boolValue returns the boolean value of the given node, and adds an error unless it's a boolean.
+func (es *configErrors) boolValue(path string, n *yaml.Node) (bool, bool) {
+	if n.Kind == yaml.ScalarNode {
+		if b, err := strconv.ParseBool(n.Value); err == nil {
+			return b, true
+		}
+	}
+	es.addf(n, "%s: expected a boolean, got %s", path, kindName(n))
+	return false, false
+}
+
+// durationValue returns the duration value of the given node, and adds an error unless it's a duration.
+func (es *configErrors) durationValue(path string, n *yaml.Node) (time.Duration, bool) {
+	if n.Kind == yaml.ScalarNode {
+		if d, err := time.ParseDuration(n.Value); err == nil {
+			return d, true
+		}
+	}
+	es.addf(n, "%s: expected a duration, got %s", path, kindName(n))
+	return 0, false
+}
+
+// intValue returns the integer value of the given node, and adds an error unless it's an integer.
+func (es *configErrors) intValue(path string, n *yaml.Node) (int, bool) {
+	if n.Kind == yaml.ScalarNode {
+		if i, err := strconv.Atoi(n.Value); err == nil {
+			return i, true
+		}
+	}
+	es.addf(n, "%s: expected an integer, got %s", path, kindName(n))
+	return 0, false
+}
+
+// stringListValue returns the values of the given node, and adds an error unless it's a list of strings.
+// The what argument describes the strings in the error.
+func (es *configErrors) stringListValue(path, what string, n *yaml.Node) ([]string, bool) {
+	if n.Kind != yaml.SequenceNode {
+		es.addf(n, "%s: expected a list of %s, got %s", path, what, kindName(n))
+		return nil, false
+	}
+	ok := true
+	ss := make([]string, len(n.Content))
+	for i, e := range n.Content {
+		if ss[i], ok = es.stringValue(fmt.Sprintf("%s[%d]", path, i), e); !ok {
+			break
+		}
+	}
+	return ss, ok
+}
+
+// ParseConfigFile parses the given config file and validates it against the schema of the Config. The
+// returned error describes every problem that was found, each with its file, line, and column.
+func ParseConfigFile(c context.Context, fileName string) (*Config, error) {
+	bs, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	parseContext = context.WithValue(c, parsedFile{}, fileName)
+	defer func() {
+		parseContext = nil
+	}()
+	cfg := &Config{}
+	if err = yaml.Unmarshal(bs, cfg); err != nil {
+		if _, ok := err.(configErrors); !ok {
+			err = fmt.Errorf("%s: %w", fileName, err)
+		}
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// ConfigFiles returns the config files that LoadConfig reads, in order of precedence. Only files that
+// exist are included.
+func ConfigFiles(c context.Context) ([]string, error) {
+	dirs, err := filelocation.AppSystemConfigDirs(c)
+	if err != nil {
+		return nil, err
+	}
+	appDir, err := filelocation.AppUserConfigDir(c)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else {
+		dirs = append(dirs, appDir)
+	}
+	var files []string
+	for _, dir := range dirs {
+		fileName := filepath.Join(dir, configFile)
+		if stat, err := os.Stat(fileName); err == nil && !stat.IsDir() {
+			files = append(files, fileName)
+		}
+	}
+	return files, nil
+}
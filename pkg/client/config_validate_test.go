@@ -0,0 +1,180 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+func TestParseConfigFile_malformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		errs   []string
+	}{
+		{
+			"not an object",
+			"- timeouts\n",
+			[]string{`1:1: config: expected an object, got a list`},
+		},
+		{
+			"unknown key",
+			"mappedNamespace: [default]\n",
+			[]string{`1:1: unknown key "mappedNamespace", valid keys are allowConflictingSubnets, alsoProxy, cloud, connections, ` +
+				`dns, envFilters, grpc, idleTimeout, images, intercept, logLevels, manager, mappedNamespaces, metrics, neverProxy, ` +
+				`outboundTrafficPolicy, overrides, socks5Address, telemetry, timeouts, tracing, userDaemonAddress`},
+		},
+		{
+			"unknown section key",
+			"dns:\n  lookupTimout: 5s\n",
+			[]string{`2:3: unknown key "dns.lookupTimout", valid keys are excludeSuffixes, includeSuffixes, lookupTimeout, maxTTL, negativeCacheTTL`},
+		},
+		{
+			"section not an object",
+			"timeouts: 10s\n",
+			[]string{`1:11: timeouts: expected an object, got "10s"`},
+		},
+		{
+			"boolean",
+			"cloud:\n  skipLogin: maybe\n",
+			[]string{`2:14: cloud.skipLogin: expected a boolean, got "maybe"`},
+		},
+		{
+			"duration",
+			"dns:\n  lookupTimeout: [1s]\n",
+			[]string{`2:18: dns.lookupTimeout: expected a duration, got a list`},
+		},
+		{
+			"string",
+			"manager:\n  namespace:\n    name: tp\n",
+			[]string{`3:5: manager.namespace: expected a string, got an object`},
+		},
+		{
+			"list",
+			"dns:\n  includeSuffixes: .corp\n",
+			[]string{`2:20: dns.includeSuffixes: expected a list of suffixes, got ".corp"`},
+		},
+		{
+			"list entry",
+			"mappedNamespaces:\n  - default\n  - {name: dev}\n",
+			[]string{`3:5: mappedNamespaces[1]: expected a string, got an object`},
+		},
+		{
+			"log-level",
+			"logLevels:\n  userDaemon: loud\n",
+			[]string{`2:15: logLevels.userDaemon: expected a log-level, got "loud"`},
+		},
+		{
+			"quantity",
+			"grpc:\n  maxReceiveSize: lots\n",
+			[]string{`2:19: grpc.maxReceiveSize: expected a quantity, got "lots"`},
+		},
+		{
+			"subnet",
+			"alsoProxy:\n  - 10.0.0.0/8\n  - 10.0.0.0/33\n",
+			[]string{`3:5: alsoProxy entry "10.0.0.0/33": invalid CIDR address: 10.0.0.0/33`},
+		},
+		{
+			"all problems",
+			"timeouts:\n  helm: soon\n  agentInstal: 3m\ntracing:\n  enabled: sure\nintercept:\n  missingWebhook: ignore\nfoo: bar\n",
+			[]string{
+				`2:9: timeouts.helm: expected a duration, got "soon"`,
+				`3:3: unknown key "timeouts.agentInstal", valid keys are agentInstall, apply, clusterConnect, endpointDial, ` +
+					`helm, intercept, proxyDial, roundtripLatency, trafficManagerAPI, trafficManagerConnect`,
+				`5:12: tracing.enabled: expected a boolean, got "sure"`,
+				`7:19: intercept.missingWebhook must be "error" or "patch", got "ignore"`,
+				`8:1: unknown key "foo", valid keys are allowConflictingSubnets, alsoProxy, cloud, connections, dns, envFilters, grpc, ` +
+					`idleTimeout, images, intercept, logLevels, manager, mappedNamespaces, metrics, neverProxy, ` +
+					`outboundTrafficPolicy, overrides, socks5Address, telemetry, timeouts, tracing, userDaemonAddress`,
+			},
+		},
+		{
+			"override",
+			"overrides:\n  dev:\n    timeouts:\n      apply: never\n    cloud: {}\n",
+			[]string{
+				`5:5: overrides.dev: cloud can't be overridden for a kube context`,
+			},
+		},
+		{
+			"override value",
+			"overrides:\n  dev:\n    timeouts:\n      apply: never\n  prod: tp-prod\n",
+			[]string{
+				`4:14: timeouts.apply: expected a duration, got "never"`,
+				`5:9: overrides.prod: expected an object, got "tp-prod"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), configFile)
+			require.NoError(t, os.WriteFile(fileName, []byte(tt.config), 0600))
+			_, err := ParseConfigFile(dlog.NewTestContext(t, false), fileName)
+			require.Error(t, err)
+			expected := make([]string, len(tt.errs))
+			for i, e := range tt.errs {
+				expected[i] = fileName + ":" + e
+			}
+			assert.Equal(t, strings.Join(expected, "\n"), err.Error())
+		})
+	}
+}
+
+func TestParseConfigFile_syntaxError(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), configFile)
+	require.NoError(t, os.WriteFile(fileName, []byte("timeouts:\n  helm: 10s\n apply: 20s\n"), 0600))
+	_, err := ParseConfigFile(dlog.NewTestContext(t, false), fileName)
+	require.Error(t, err)
+	assert.Equal(t, fileName+": yaml: line 2: did not find expected key", err.Error())
+}
+
+func TestParseConfigFile_extensionKeys(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), configFile)
+	require.NoError(t, os.WriteFile(fileName, []byte(`
+x-team: platform
+x-defaults: &timeouts
+  apply: 20s
+timeouts:
+  x-comment: raised for the CI cluster
+  helm: 1m
+dns:
+  x-lookupTimeout: [1s]
+`), 0600))
+	cfg, err := ParseConfigFile(dlog.NewTestContext(t, false), fileName)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, cfg.Timeouts.PrivateHelm)
+	assert.Zero(t, cfg.Timeouts.PrivateApply)
+	assert.Zero(t, cfg.DNS.LookupTimeout)
+}
+
+func TestLoadConfig_allFiles(t *testing.T) {
+	tmp := t.TempDir()
+	sys := filepath.Join(tmp, "sys")
+	user := filepath.Join(tmp, "user")
+	require.NoError(t, os.MkdirAll(sys, 0700))
+	require.NoError(t, os.MkdirAll(user, 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(sys, configFile), []byte("cloud:\n  skipLogin: maybe\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(user, configFile), []byte("timeouts:\n  apply: -1s\n"), 0600))
+
+	c := dlog.NewTestContext(t, false)
+	c = filelocation.WithAppSystemConfigDirs(c, []string{sys, filepath.Join(tmp, "missing")})
+	c = filelocation.WithAppUserConfigDir(c, user)
+	c = WithEnv(c, &Env{})
+
+	files, err := ConfigFiles(c)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(sys, configFile), filepath.Join(user, configFile)}, files)
+
+	_, err = LoadConfig(c)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), filepath.Join(sys, configFile)+`:2:14: cloud.skipLogin: expected a boolean, got "maybe"`)
+	assert.Contains(t, err.Error(), filepath.Join(user, configFile)+`:2:10: timeouts.apply must be a positive duration, got "-1s"`)
+}
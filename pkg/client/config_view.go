@@ -0,0 +1,300 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// SourceDefault is the source of the values of the default configuration.
+const SourceDefault = "default"
+
+// ConfigLayer is a configuration together with the source that it comes from. The effective configuration
+// is the result of merging layers in order, so that the values of a layer take precedence over the values
+// of the layers that precede it.
+type ConfigLayer struct {
+	// Source describes where the configuration comes from, e.g. the name of a file or an environment variable.
+	Source string
+
+	// Config holds the values of the layer. Its zero values are not part of the layer.
+	Config *Config
+
+	// Extend makes the lists of the layer extend the lists of the preceding layers rather than replace them.
+	Extend bool
+}
+
+// envConfigLayers returns one layer for each environment variable that changes a value of the default
+// configuration.
+func envConfigLayers(env *Env) []*ConfigLayer {
+	var layers []*ConfigLayer
+	if env.Registry != "" && env.Registry != defaultRegistry {
+		layers = append(layers, &ConfigLayer{Source: "env TELEPRESENCE_REGISTRY", Config: &Config{Images: Images{Registry: env.Registry}}})
+	}
+	if env.AgentImage != "" {
+		layers = append(layers, &ConfigLayer{Source: "env TELEPRESENCE_AGENT_IMAGE", Config: &Config{Images: Images{AgentImage: env.AgentImage}}})
+	}
+	if env.ManagerNamespace != "" && env.ManagerNamespace != defaultManagerNamespace {
+		layers = append(layers, &ConfigLayer{Source: "env TELEPRESENCE_MANAGER_NAMESPACE", Config: &Config{Manager: Manager{Namespace: env.ManagerNamespace}}})
+	}
+	return layers
+}
+
+// LoadConfigViewLayers returns the layers of the effective configuration. Those are the layers that
+// LoadConfig merges, preceded by the values that the default configuration leaves empty but that have
+// a documented default, and followed by the environment variables that take precedence over the config
+// files. The layer of the kubeconfig extension of the cluster, which depends on the kubernetes flags, is
+// not included.
+func LoadConfigViewLayers(c context.Context) ([]*ConfigLayer, error) {
+	layers, err := loadConfigLayers(c)
+	if err != nil {
+		return nil, errcat.Config.New(err)
+	}
+	enabled := true
+	implicit := &ConfigLayer{Source: SourceDefault, Config: &Config{
+		Telemetry:   Telemetry{Enabled: &enabled},
+		Intercept:   Intercept{MissingWebhook: MissingWebhookError},
+		Connections: Connections{Eviction: ConnectionEvictionLRU},
+	}}
+	layers = append([]*ConfigLayer{layers[0], implicit}, layers[1:]...)
+
+	env := GetEnv(c)
+	if env.UserDaemonAddress != "" {
+		layers = append(layers, &ConfigLayer{Source: "env TELEPRESENCE_USER_DAEMON_ADDRESS", Config: &Config{UserDaemonAddress: env.UserDaemonAddress}})
+	}
+	if env.ScoutDisable != "" {
+		disabled := false
+		layers = append(layers, &ConfigLayer{Source: "env SCOUT_DISABLE", Config: &Config{Telemetry: Telemetry{Enabled: &disabled}}})
+	}
+	return layers, nil
+}
+
+// configValue is a value of the effective configuration and the source of that value.
+type configValue struct {
+	value  interface{}
+	source string
+}
+
+// configEntry is a key of the effective configuration. It has either entries, a value, or a list of items.
+type configEntry struct {
+	key     string
+	entries []*configEntry
+	value   *configValue
+	items   []*configValue
+}
+
+// ConfigView is the effective configuration that results from merging a set of layers, with the source
+// of each value.
+type ConfigView struct {
+	entries []*configEntry
+}
+
+// NewConfigView merges the given layers into a ConfigView. The keys of the view are in the order of the
+// fields of the Config, and keys that have no value in any layer are omitted.
+func NewConfigView(layers []*ConfigLayer) *ConfigView {
+	values := make(map[string]*configValue)
+	lists := make(map[string][]*configValue)
+	for _, layer := range layers {
+		walkConfig(reflect.ValueOf(layer.Config).Elem(), "", func(path string, v reflect.Value) {
+			if v.Kind() == reflect.Slice {
+				if v.Len() == 0 {
+					return
+				}
+				items := make([]*configValue, v.Len())
+				for i := range items {
+					items[i] = &configValue{value: scalarValue(v.Index(i)), source: layer.Source}
+				}
+				if layer.Extend {
+					items = append(lists[path], items...)
+				}
+				lists[path] = items
+			} else if !v.IsZero() {
+				values[path] = &configValue{value: scalarValue(v), source: layer.Source}
+			}
+		})
+	}
+	return &ConfigView{entries: viewEntries(reflect.TypeOf(Config{}), "", values, lists)}
+}
+
+// yamlKey returns the name of the given field in the YAML of the config.
+func yamlKey(f reflect.StructField) string {
+	return strings.Split(f.Tag.Get("yaml"), ",")[0]
+}
+
+// walkConfig calls the given function with the dotted path and the value of each leaf of the given
+// Config value. The fields that aren't part of the YAML, such as the overrides, are skipped.
+func walkConfig(v reflect.Value, prefix string, f func(string, reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := yamlKey(t.Field(i))
+		if key == "-" {
+			continue
+		}
+		path := prefix + key
+		if fv := v.Field(i); fv.Kind() == reflect.Struct {
+			walkConfig(fv, path+".", f)
+		} else {
+			f(path, fv)
+		}
+	}
+}
+
+func viewEntries(t reflect.Type, prefix string, values map[string]*configValue, lists map[string][]*configValue) []*configEntry {
+	var entries []*configEntry
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := yamlKey(f)
+		path := prefix + key
+		switch {
+		case key == "-":
+		case f.Type.Kind() == reflect.Struct:
+			if es := viewEntries(f.Type, path+".", values, lists); len(es) > 0 {
+				entries = append(entries, &configEntry{key: key, entries: es})
+			}
+		case f.Type.Kind() == reflect.Slice:
+			if items, ok := lists[path]; ok {
+				for _, item := range items {
+					item.value = maskValue(key, item.value)
+				}
+				entries = append(entries, &configEntry{key: key, items: items})
+			}
+		default:
+			if value, ok := values[path]; ok {
+				value.value = maskValue(key, value.value)
+				entries = append(entries, &configEntry{key: key, value: value})
+			}
+		}
+	}
+	return entries
+}
+
+// scalarValue returns the given leaf value as a string, a bool, or an int64.
+func scalarValue(v reflect.Value) interface{} {
+	switch x := v.Interface().(type) {
+	case fmt.Stringer:
+		return x.String()
+	case *bool:
+		return *x
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.String:
+		return v.String()
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+// maskedValue replaces the values that maskValue hides.
+const maskedValue = "********"
+
+// secretKeyRx matches the keys whose values are masked in the view.
+var secretKeyRx = regexp.MustCompile(`(?i)(password|passwd|token|apikey|api_key|credential|privatekey)`)
+
+// maskValue returns the given value, or a mask when the value is a secret. A value is a secret when its
+// key suggests that it is. The password of a URL value is redacted.
+func maskValue(key string, value interface{}) interface{} {
+	s, ok := value.(string)
+	if !ok {
+		return value
+	}
+	if secretKeyRx.MatchString(key) {
+		return maskedValue
+	}
+	if strings.Contains(s, "://") {
+		if u, err := url.Parse(s); err == nil {
+			return u.Redacted()
+		}
+	}
+	return s
+}
+
+// YAML returns the view in YAML. When withSources is true, each value is followed by a comment with its source.
+func (v *ConfigView) YAML(withSources bool) ([]byte, error) {
+	doc, err := yamlEntries(v.entries, withSources)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
+
+func yamlEntries(entries []*configEntry, withSources bool) (*yaml.Node, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, e := range entries {
+		var vn *yaml.Node
+		var err error
+		switch {
+		case e.entries != nil:
+			vn, err = yamlEntries(e.entries, withSources)
+		case e.items != nil:
+			vn = &yaml.Node{Kind: yaml.SequenceNode}
+			for _, item := range e.items {
+				var in *yaml.Node
+				if in, err = yamlValue(item, withSources); err != nil {
+					break
+				}
+				vn.Content = append(vn.Content, in)
+			}
+		default:
+			vn, err = yamlValue(e.value, withSources)
+		}
+		if err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: e.key}, vn)
+	}
+	return node, nil
+}
+
+func yamlValue(cv *configValue, withSources bool) (*yaml.Node, error) {
+	node := &yaml.Node{}
+	if err := node.Encode(cv.value); err != nil {
+		return nil, err
+	}
+	if withSources {
+		node.LineComment = cv.source
+	}
+	return node, nil
+}
+
+// JSON returns the view in JSON. When withSources is true, each value is replaced by an object with the
+// value and its source.
+func (v *ConfigView) JSON(withSources bool) ([]byte, error) {
+	return json.MarshalIndent(jsonEntries(v.entries, withSources), "", "  ")
+}
+
+func jsonEntries(entries []*configEntry, withSources bool) map[string]interface{} {
+	m := make(map[string]interface{}, len(entries))
+	for _, e := range entries {
+		switch {
+		case e.entries != nil:
+			m[e.key] = jsonEntries(e.entries, withSources)
+		case e.items != nil:
+			items := make([]interface{}, len(e.items))
+			for i, item := range e.items {
+				items[i] = jsonValue(item, withSources)
+			}
+			m[e.key] = items
+		default:
+			m[e.key] = jsonValue(e.value, withSources)
+		}
+	}
+	return m
+}
+
+func jsonValue(cv *configValue, withSources bool) interface{} {
+	if withSources {
+		return map[string]interface{}{"value": cv.value, "source": cv.source}
+	}
+	return cv.value
+}
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// configReloadDelay is the time that the config watcher waits after a change of a config file before it
+// reloads the config, so that an editor that saves a file in several steps causes only one reload.
+var configReloadDelay = 300 * time.Millisecond
+
+// liveConfigKeys are the keys, or key prefixes, of the config values that the daemons apply when the
+// config is reloaded.
+var liveConfigKeys = []string{"logLevels.", "timeouts.", "dns.includeSuffixes", "dns.excludeSuffixes", "telemetry.enabled", "intercept.defaultTTL", "connections."}
+
+// restartConfigKeys are the keys, or key prefixes, of the config values that the daemons only read when
+// they start.
+var restartConfigKeys = []string{"grpc.", "tracing.", "metrics.", "userDaemonAddress"}
+
+// ConfigReload describes a reload of the config that changed some of its values.
+type ConfigReload struct {
+	// Previous is the config that was active before the reload.
+	Previous *Config
+
+	// Current is the config that is active after the reload.
+	Current *Config
+
+	// Applied are the keys of the changed values that the daemons apply right away.
+	Applied []string
+
+	// RequireReconnect are the keys of the changed values that take effect for new sessions.
+	RequireReconnect []string
+
+	// RequireRestart are the keys of the changed values that take effect when the daemons are
+	// restarted. Those values retain their previous value in the Current config.
+	RequireRestart []string
+}
+
+// ConfigChanges returns the keys, in dotted notation, of the values that differ between the given configs.
+func ConfigChanges(previous, current *Config) []string {
+	values := make(map[string]interface{})
+	walkConfig(reflect.ValueOf(previous).Elem(), "", func(path string, v reflect.Value) {
+		values[path] = v.Interface()
+	})
+	var keys []string
+	walkConfig(reflect.ValueOf(current).Elem(), "", func(path string, v reflect.Value) {
+		if !reflect.DeepEqual(values[path], v.Interface()) {
+			keys = append(keys, path)
+		}
+	})
+	if !reflect.DeepEqual(previous.Overrides, current.Overrides) {
+		keys = append(keys, "overrides")
+	}
+	return keys
+}
+
+func matchesConfigKey(key string, keys []string) bool {
+	for _, k := range keys {
+		if key == k || strings.HasSuffix(k, ".") && strings.HasPrefix(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchConfig watches the config files that LoadConfig reads until the context is cancelled. The context
+// must have a Config. When the files change, the config is loaded again and replaces the Config of the
+// context, and the given function is called so that the daemon can apply the changes. A config that
+// can't be loaded is logged, and the previous config then remains active. A failure to watch the files is
+// logged rather than returned, because the reloads are not essential to a daemon.
+func WatchConfig(c context.Context, apply func(context.Context, *ConfigReload)) error {
+	watcher, err := newConfigWatcher(c)
+	if err != nil {
+		dlog.Errorf(c, "unable to watch the config files: %v", err)
+		<-c.Done()
+		return nil
+	}
+	return runConfigWatcher(c, watcher, apply)
+}
+
+// newConfigWatcher returns a watcher of the directories of the config files. The directories are watched
+// rather than the files, because a file that doesn't exist can't be watched, and because editors often
+// replace a file when they save it.
+func newConfigWatcher(c context.Context) (*fsnotify.Watcher, error) {
+	dirs, err := filelocation.AppSystemConfigDirs(c)
+	if err != nil {
+		return nil, err
+	}
+	if userDir, err := filelocation.AppUserConfigDir(c); err == nil {
+		dirs = append(dirs, userDir)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			dlog.Debugf(c, "unable to watch config directory %s: %v", dir, err)
+		}
+	}
+	return watcher, nil
+}
+
+func runConfigWatcher(c context.Context, watcher *fsnotify.Watcher, apply func(context.Context, *ConfigReload)) error {
+	defer watcher.Close()
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-c.Done():
+			return nil
+		case err := <-watcher.Errors:
+			dlog.Errorf(c, "config watcher: %v", err)
+		case event := <-watcher.Events:
+			if filepath.Base(event.Name) == configFile {
+				reload = time.After(configReloadDelay)
+			}
+		case <-reload:
+			reload = nil
+			if r := reloadConfig(c); r != nil {
+				apply(c, r)
+			}
+		}
+	}
+}
+
+// reloadConfig loads the config and replaces the Config of the given context with it. It returns nil
+// when the config can't be loaded or when no value has changed.
+func reloadConfig(c context.Context) *ConfigReload {
+	current, err := LoadConfig(c)
+	if err != nil {
+		dlog.Errorf(c, "Unable to reload the config, the previous config remains active: %v", err)
+		return nil
+	}
+	previous := GetConfig(c)
+	r := &ConfigReload{Previous: previous, Current: current}
+	for _, key := range ConfigChanges(previous, current) {
+		switch {
+		case matchesConfigKey(key, liveConfigKeys):
+			r.Applied = append(r.Applied, key)
+		case matchesConfigKey(key, restartConfigKeys):
+			r.RequireRestart = append(r.RequireRestart, key)
+		default:
+			r.RequireReconnect = append(r.RequireReconnect, key)
+		}
+	}
+	if len(r.RequireRestart) > 0 {
+		current.Grpc = previous.Grpc
+		current.Tracing = previous.Tracing
+		current.Metrics = previous.Metrics
+		current.UserDaemonAddress = previous.UserDaemonAddress
+	}
+	if len(r.Applied) == 0 && len(r.RequireReconnect) == 0 && len(r.RequireRestart) == 0 {
+		return nil
+	}
+	ReplaceConfig(c, current)
+
+	if len(r.Applied) > 0 {
+		dlog.Infof(c, "Config reloaded, applied %s", strings.Join(r.Applied, ", "))
+	}
+	if len(r.RequireReconnect) > 0 {
+		dlog.Warnf(c, "Config reloaded, but %s requires reconnect", strings.Join(r.RequireReconnect, ", "))
+	}
+	if len(r.RequireRestart) > 0 {
+		dlog.Warnf(c, "Config reloaded, but %s requires a restart of the daemons (telepresence quit -s)", strings.Join(r.RequireRestart, ", "))
+	}
+	return r
+}
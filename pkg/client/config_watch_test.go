@@ -0,0 +1,134 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// syncBuffer is a bytes.Buffer that can be written by the config watcher while the test reads it.
+type syncBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(data []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(data)
+}
+
+func (b *syncBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
+
+func TestWatchConfig(t *testing.T) {
+	defer func(d time.Duration) { configReloadDelay = d }(configReloadDelay)
+	configReloadDelay = 20 * time.Millisecond
+
+	logBuf := &syncBuffer{}
+	logger := logrus.New()
+	logger.SetOutput(logBuf)
+	c, cancel := context.WithCancel(dlog.WithLogger(context.Background(), dlog.WrapLogrus(logger)))
+	defer cancel()
+
+	userDir := t.TempDir()
+	configPath := filepath.Join(userDir, configFile)
+	writeConfig := func(content string) {
+		require.NoError(t, os.WriteFile(configPath, []byte(content), 0600))
+	}
+	writeConfig("logLevels:\n  userDaemon: info\ntimeouts:\n  apply: 10s\n")
+
+	c = filelocation.WithAppSystemConfigDirs(c, nil)
+	c = filelocation.WithAppUserConfigDir(c, userDir)
+	c = WithEnv(c, &Env{})
+	cfg, err := LoadConfig(c)
+	require.NoError(t, err)
+	c = WithConfig(c, cfg)
+
+	watcher, err := newConfigWatcher(c)
+	require.NoError(t, err)
+	reloads := make(chan *ConfigReload, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = runConfigWatcher(c, watcher, func(_ context.Context, r *ConfigReload) { reloads <- r })
+	}()
+	nextReload := func() *ConfigReload {
+		select {
+		case r := <-reloads:
+			return r
+		case <-time.After(5 * time.Second):
+			t.Fatal("the config was not reloaded")
+			return nil
+		}
+	}
+
+	t.Run("applied", func(t *testing.T) {
+		writeConfig("logLevels:\n  userDaemon: debug\ntimeouts:\n  apply: 20s\ndns:\n  includeSuffixes:\n    - .corp.example.com\n")
+		r := nextReload()
+		assert.Equal(t, []string{"timeouts.apply", "logLevels.userDaemon", "dns.includeSuffixes"}, r.Applied)
+		assert.Empty(t, r.RequireReconnect)
+		assert.Empty(t, r.RequireRestart)
+		assert.Equal(t, logrus.InfoLevel, r.Previous.LogLevels.UserDaemon)
+		assert.Same(t, r.Current, GetConfig(c))
+		assert.Equal(t, logrus.DebugLevel, GetConfig(c).LogLevels.UserDaemon)
+		assert.Equal(t, 20*time.Second, GetConfig(c).Timeouts.PrivateApply)
+		assert.Contains(t, logBuf.String(), "Config reloaded, applied timeouts.apply, logLevels.userDaemon, dns.includeSuffixes")
+	})
+
+	t.Run("requires reconnect", func(t *testing.T) {
+		writeConfig("logLevels:\n  userDaemon: debug\ntimeouts:\n  apply: 20s\ndns:\n  includeSuffixes:\n    - .corp.example.com\n" +
+			"manager:\n  namespace: team-tp\nalsoProxy:\n  - 10.88.0.0/16\nuserDaemonAddress: tcp://127.0.0.1:4711\n")
+		r := nextReload()
+		assert.Empty(t, r.Applied)
+		assert.Equal(t, []string{"manager.namespace", "alsoProxy"}, r.RequireReconnect)
+		assert.Equal(t, []string{"userDaemonAddress"}, r.RequireRestart)
+		cfg := GetConfig(c)
+		assert.Equal(t, "team-tp", cfg.Manager.Namespace)
+		require.Len(t, cfg.AlsoProxy, 1)
+		assert.Equal(t, "10.88.0.0/16", cfg.AlsoProxy[0].String())
+		assert.Empty(t, cfg.UserDaemonAddress, "a value that requires a restart must retain its previous value")
+		assert.Contains(t, logBuf.String(), "Config reloaded, but manager.namespace, alsoProxy requires reconnect")
+		assert.Contains(t, logBuf.String(), "Config reloaded, but userDaemonAddress requires a restart of the daemons")
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		previous := GetConfig(c)
+		writeConfig("logLevels:\n  userDaemon: trace\ntimeouts:\n  apply: -1s\n")
+		assert.Eventually(t, func() bool {
+			return strings.Contains(logBuf.String(), "Unable to reload the config, the previous config remains active")
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Contains(t, logBuf.String(), "config.yml:4:")
+		assert.Same(t, previous, GetConfig(c))
+		select {
+		case <-reloads:
+			t.Fatal("a malformed config must not be applied")
+		default:
+		}
+	})
+
+	cancel()
+	<-done
+}
+
+func TestConfigChanges(t *testing.T) {
+	previous := &Config{Timeouts: Timeouts{PrivateApply: time.Second}, MappedNamespaces: []string{"default"}}
+	current := &Config{Timeouts: Timeouts{PrivateApply: 2 * time.Second}, MappedNamespaces: []string{"default"}, IdleTimeout: time.Hour}
+	assert.Equal(t, []string{"timeouts.apply", "idleTimeout"}, ConfigChanges(previous, current))
+	assert.Empty(t, ConfigChanges(current, current))
+}
@@ -2,7 +2,9 @@ package connector
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"sort"
@@ -10,8 +12,11 @@ import (
 	"time"
 
 	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
+	empty "google.golang.org/protobuf/types/known/emptypb"
 
+	"github.com/datawire/dlib/dcontext"
 	"github.com/datawire/dlib/derror"
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dhttp"
@@ -29,6 +34,9 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/client/logging"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/scout"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/metrics"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 )
 
 const ProcessName = "connector"
@@ -48,6 +56,15 @@ to troubleshoot problems.
 type parsedConnectRequest struct {
 	*rpc.ConnectRequest
 	*userd_k8s.Config
+
+	// managerValues are the decoded ConnectRequest.ManagerValues
+	managerValues map[string]interface{}
+
+	// spanContext is the span of the Connect call, so that the connectWorker can continue its trace.
+	spanContext trace.SpanContext
+
+	// progress receives the progress of the connectWorker. It's nil unless the call is a ConnectStream.
+	progress func(*rpc.ConnectProgress)
 }
 
 type ScoutReport = scout.ScoutReport
@@ -58,18 +75,26 @@ type service struct {
 
 	cancel func()
 
-	// Must hold connectMu to use the sharedState.MaybeSetXXX methods.
+	// Must hold connectMu to add sessions and to use the Session.MaybeSetXXX methods.
 	connectMu   sync.Mutex
 	sharedState *sharedstate.State
+	sessionSeq  int
+
+	// group runs the goroutines of the sessions.
+	group *dgroup.Group
+
+	// managerServers are the proxies of the traffic-managers of the sessions, used by the root daemon.
+	managerServers *userd_grpc.ManagerServers
 
 	// These are used to communicate between the various goroutines.
-	scout           chan ScoutReport          // any-of-scoutUsers -> background-metriton
-	connectRequest  chan parsedConnectRequest // server-grpc.connect() -> connectWorker
-	connectResponse chan *rpc.ConnectInfo     // connectWorker -> server-grpc.connect()
+	scout      chan ScoutReport // any-of-scoutUsers -> background-metriton
+	scoutUsers sync.WaitGroup
 }
 
 // Command returns the CLI sub-command for "connector-foreground"
 func Command() *cobra.Command {
+	var address string
+	var wsl bool
 	c := &cobra.Command{
 		Use:    ProcessName + "-foreground",
 		Short:  "Launch Telepresence " + titleName + " in the foreground (debug)",
@@ -77,9 +102,13 @@ func Command() *cobra.Command {
 		Hidden: true,
 		Long:   help,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return run(cmd.Context())
+			return run(cmd.Context(), address, wsl)
 		},
 	}
+	c.Flags().StringVar(&address, "address", "",
+		"Listen to the given TCP address instead of the connector socket. Used when running in a container")
+	c.Flags().BoolVar(&wsl, "wsl", false,
+		"Also serve the CLI in WSL2, on the interface of Windows on the virtual switch of WSL. Used on Windows")
 	return c
 }
 
@@ -91,8 +120,28 @@ func connectError(t rpc.ConnectInfo_ErrType, err error) *rpc.ConnectInfo {
 	}
 }
 
-// connect the connector to a cluster
-func (s *service) connect(c context.Context, cr *rpc.ConnectRequest, dryRun bool) *rpc.ConnectInfo {
+// switchContextReason explains why a session that uses the current config can't be reused for a
+// connect that requests the new config. The new config is nil when it couldn't be created.
+func switchContextReason(current, requested *userd_k8s.Config) string {
+	target := "the requested context"
+	if requested != nil {
+		target = fmt.Sprintf("context %s (%s)", requested.Context, requested.Server)
+	}
+	return fmt.Sprintf("already connected to context %s (%s); quit first or use telepresence connect --switch to connect to %s",
+		current.Context, current.Server, target)
+}
+
+// mtuString describes the given MTU of the kubeconfig extension.
+func mtuString(mtu int) string {
+	if mtu == 0 {
+		return "the default MTU"
+	}
+	return fmt.Sprintf("MTU %d", mtu)
+}
+
+// connect the connector to a cluster. The progress of a new connection is sent to the given progress
+// function unless it's nil. A connect that doesn't select an existing session creates a new one.
+func (s *service) connect(c context.Context, cr *rpc.ConnectRequest, dryRun bool, progress func(*rpc.ConnectProgress)) *rpc.ConnectInfo {
 	s.connectMu.Lock()
 	defer s.connectMu.Unlock()
 
@@ -100,115 +149,509 @@ func (s *service) connect(c context.Context, cr *rpc.ConnectRequest, dryRun bool
 	if err != nil && !dryRun {
 		return connectError(rpc.ConnectInfo_CLUSTER_FAILED, err)
 	}
-	if cluster := s.sharedState.GetClusterNonBlocking(); cluster != nil {
-		if cluster.Config.ContextServiceAndFlagsEqual(config) {
-			cluster.Config = config // namespace might have changed
-			if mns := cr.MappedNamespaces; len(mns) > 0 {
-				if len(mns) == 1 && mns[0] == "all" {
-					mns = nil
-				}
-				sort.Strings(mns)
-				cluster.SetMappedNamespaces(c, mns)
-			}
-			ingressInfo, err := cluster.DetectIngressBehavior(c)
-			if err != nil {
-				return connectError(rpc.ConnectInfo_CLUSTER_FAILED, err)
-			}
-			ret := &rpc.ConnectInfo{
-				Error:          rpc.ConnectInfo_ALREADY_CONNECTED,
-				ClusterContext: cluster.Config.Context,
-				ClusterServer:  cluster.Config.Server,
-				ClusterId:      cluster.GetClusterId(c),
-				IngressInfos:   ingressInfo,
-			}
-			s.sharedState.GetTrafficManagerNonBlocking().SetStatus(c, ret)
-			return ret
-		} else {
-			ret := &rpc.ConnectInfo{
-				Error:          rpc.ConnectInfo_MUST_RESTART,
-				ClusterContext: cluster.Config.Context,
-				ClusterServer:  cluster.Config.Server,
-				ClusterId:      cluster.GetClusterId(c),
-			}
-			s.sharedState.GetTrafficManagerNonBlocking().SetStatus(c, ret)
-			return ret
+	if config != nil {
+		// The overrides of the client config for the kube context apply to the session
+		c = client.WithKubeContextConfig(c, config.Context)
+		dns := client.GetConfig(c).DNS
+		config.AddDNSSuffixes(dns.IncludeSuffixes, dns.ExcludeSuffixes)
+		config.AddDNSSuffixes(cr.IncludeSuffixes, cr.ExcludeSuffixes)
+		config.AddAlsoProxy(client.GetConfig(c).AlsoProxy)
+		config.AddAlsoProxy(subnetsFromRPC(cr.AlsoProxy))
+		config.AddNeverProxy(client.GetConfig(c).NeverProxy)
+		config.AddNeverProxy(subnetsFromRPC(cr.NeverProxy))
+		if cr.ManagerNamespace != "" {
+			config.SetManagerNamespace(cr.ManagerNamespace)
 		}
-	} else {
-		// This is the first call to Connect; we have to tell the background connect
-		// goroutine to actually do the work.
-		if dryRun {
-			return &rpc.ConnectInfo{
-				Error: rpc.ConnectInfo_DISCONNECTED,
-			}
-		} else {
-			s.connectRequest <- parsedConnectRequest{
-				ConnectRequest: cr,
-				Config:         config,
+	}
+
+	var ret *rpc.ConnectInfo
+	sess, err := s.selectSession(cr)
+	switch {
+	case err != nil:
+		ret = connectError(rpc.ConnectInfo_SESSION_AMBIGUOUS, err)
+		ret.Sessions = s.sessionInfos(c)
+		return ret
+	case sess != nil:
+		ret = s.connectSession(c, sess, cr, config)
+	case dryRun:
+		ret = &rpc.ConnectInfo{
+			Error: rpc.ConnectInfo_DISCONNECTED,
+		}
+	default:
+		// This is the first call to Connect for the context and manager namespace; we have to
+		// start the goroutines of a new session to actually do the work.
+		var managerValues map[string]interface{}
+		if len(cr.ManagerValues) > 0 {
+			if err = json.Unmarshal(cr.ManagerValues, &managerValues); err != nil {
+				return connectError(rpc.ConnectInfo_TRAFFIC_MANAGER_FAILED, errcat.User.Newf("invalid traffic-manager Helm values: %w", err))
 			}
-			close(s.connectRequest)
-			return <-s.connectResponse
 		}
+		return s.startSession(c, parsedConnectRequest{
+			ConnectRequest: cr,
+			Config:         config,
+			managerValues:  managerValues,
+			spanContext:    trace.SpanContextFromContext(c),
+			progress:       progress,
+		})
+	}
+	if dryRun {
+		ret.Sessions = s.sessionInfos(c)
+	}
+	return ret
+}
+
+// selectSession returns the session that a connect with the given request is for, or nil when it's
+// for a new session. A connect without an explicit context is for the only session, and a connect with
+// an explicit context is for the session of that context and the requested manager namespace, if any.
+func (s *service) selectSession(cr *rpc.ConnectRequest) (*sharedstate.Session, error) {
+	kubeContext := cr.KubeFlags["context"]
+	if kubeContext == "" {
+		switch sessions := s.sharedState.Sessions(); len(sessions) {
+		case 0:
+			return nil, nil
+		case 1:
+			return sessions[0], nil
+		default:
+			return nil, sharedstate.AmbiguousSessionError(sessions)
+		}
+	}
+	return s.sharedState.SelectSession(kubeContext, cr.ManagerNamespace)
+}
+
+// connectSession reuses the given session for a connect with the given request and config, or explains
+// why it can't be reused.
+func (s *service) connectSession(c context.Context, sess *sharedstate.Session, cr *rpc.ConnectRequest, config *userd_k8s.Config) *rpc.ConnectInfo {
+	cluster := sess.GetClusterNonBlocking()
+	if cluster == nil {
+		return &rpc.ConnectInfo{
+			Error: rpc.ConnectInfo_DISCONNECTED,
+		}
+	}
+	mgrNs := cluster.GetManagerNamespace()
+	var restartReason string
+	switch {
+	case cr.ManagerNamespace != "" && cr.ManagerNamespace != mgrNs:
+		restartReason = fmt.Sprintf("already connected to the traffic-manager in namespace %s, please quit telepresence and reconnect to use namespace %s", mgrNs, cr.ManagerNamespace)
+	case len(cr.ManagerValues) > 0:
+		restartReason = "already connected, please quit telepresence and reconnect to apply the traffic-manager Helm values"
+	case cr.ProxyMode != "" && proxyMode(cr) != proxyModeOf(sess):
+		restartReason = fmt.Sprintf("already connected with proxy mode %s, please quit telepresence and reconnect to use proxy mode %s",
+			proxyModeOf(sess), proxyMode(cr))
+	case cr.ForceTunnel && cluster.LocalCluster().UsesDockerNetwork():
+		restartReason = fmt.Sprintf("already connected using the docker network of the %s cluster, please quit telepresence and reconnect to use --force-tunnel",
+			cluster.LocalCluster().Provider)
+	case cluster.Config.ContextServiceAndFlagsEqual(config) && config.MTU != cluster.Config.MTU:
+		// The TUN device is routing traffic, so its MTU can't be changed
+		restartReason = fmt.Sprintf("already connected with %s, please quit telepresence and reconnect to use %s",
+			mtuString(cluster.Config.MTU), mtuString(config.MTU))
+	}
+	if restartReason != "" {
+		ret := &rpc.ConnectInfo{
+			Error:              rpc.ConnectInfo_MUST_RESTART,
+			ErrorText:          restartReason,
+			ErrorCategory:      int32(errcat.User),
+			ClusterContext:     cluster.Config.Context,
+			ClusterServer:      cluster.Config.Server,
+			ClusterNamespace:   cluster.Config.Namespace,
+			ClusterId:          cluster.GetClusterId(c),
+			TelemetryClusterId: cluster.GetTelemetryClusterId(c),
+			ManagerNamespace:   cluster.GetManagerNamespace(),
+		}
+		setStatus(c, sess, ret)
+		return ret
+	}
+	if cluster.Config.ContextServiceAndFlagsEqual(config) {
+		// namespace might have changed, but the traffic-manager stays where it was found
+		config.SetManagerNamespace(mgrNs)
+		if err := config.InheritExecCredentials(c, cluster.Config); err != nil {
+			return connectError(rpc.ConnectInfo_CLUSTER_FAILED, err)
+		}
+		cluster.Config = config
+		if len(cr.MappedNamespaces) > 0 {
+			cluster.SetMappedNamespaces(c, resolveMappedNamespaces(c, cr))
+		}
+		ingressInfo, err := cluster.DetectIngressBehavior(c)
+		if err != nil {
+			return connectError(rpc.ConnectInfo_CLUSTER_FAILED, err)
+		}
+		ret := &rpc.ConnectInfo{
+			Error:              rpc.ConnectInfo_ALREADY_CONNECTED,
+			ClusterContext:     cluster.Config.Context,
+			ClusterServer:      cluster.Config.Server,
+			ClusterNamespace:   cluster.Config.Namespace,
+			ClusterId:          cluster.GetClusterId(c),
+			TelemetryClusterId: cluster.GetTelemetryClusterId(c),
+			ManagerNamespace:   cluster.GetManagerNamespace(),
+			IngressInfos:       ingressInfo,
+			MappedNamespaces:   cluster.GetMappedNamespaces(),
+		}
+		setStatus(c, sess, ret)
+		return ret
+	}
+	ret := &rpc.ConnectInfo{
+		Error:              rpc.ConnectInfo_MUST_RESTART,
+		ErrorText:          switchContextReason(cluster.Config, config),
+		ErrorCategory:      int32(errcat.User),
+		ClusterContext:     cluster.Config.Context,
+		ClusterServer:      cluster.Config.Server,
+		ClusterNamespace:   cluster.Config.Namespace,
+		ClusterId:          cluster.GetClusterId(c),
+		TelemetryClusterId: cluster.GetTelemetryClusterId(c),
+		ManagerNamespace:   cluster.GetManagerNamespace(),
 	}
+	setStatus(c, sess, ret)
+	return ret
 }
 
-func (s *service) connectWorker(c context.Context, cr *rpc.ConnectRequest, k8sConfig *userd_k8s.Config, svc *grpc.Server) *rpc.ConnectInfo {
-	mappedNamespaces := cr.MappedNamespaces
-	if len(mappedNamespaces) == 1 && mappedNamespaces[0] == "all" {
-		mappedNamespaces = nil
+// setStatus adds the status of the traffic-manager of the given session, if it has one, to the given info.
+func setStatus(c context.Context, sess *sharedstate.Session, ci *rpc.ConnectInfo) {
+	if tm := sess.GetTrafficManagerNonBlocking(); tm != nil {
+		tm.SetStatus(c, ci)
 	}
-	sort.Strings(mappedNamespaces)
+}
 
-	s.scout <- ScoutReport{
-		Action: "connect",
+// sessionInfos returns the status of all sessions.
+func (s *service) sessionInfos(c context.Context) []*rpc.ConnectInfo {
+	sessions := s.sharedState.Sessions()
+	infos := make([]*rpc.ConnectInfo, len(sessions))
+	for i, sess := range sessions {
+		ci := &rpc.ConnectInfo{
+			Error:            rpc.ConnectInfo_DISCONNECTED,
+			ClusterContext:   sess.Context,
+			ManagerNamespace: sess.ManagerNamespace,
+		}
+		if cluster := sess.GetClusterNonBlocking(); cluster != nil {
+			ci.Error = rpc.ConnectInfo_ALREADY_CONNECTED
+			ci.ClusterServer = cluster.Config.Server
+			ci.ClusterNamespace = cluster.Config.Namespace
+			ci.ClusterId = cluster.GetClusterId(c)
+			ci.TelemetryClusterId = cluster.GetTelemetryClusterId(c)
+			ci.MappedNamespaces = cluster.GetMappedNamespaces()
+			setStatus(c, sess, ci)
+		}
+		infos[i] = ci
+	}
+	return infos
+}
+
+// startSession starts the goroutines of a new session for the given connect request, and returns the
+// result of its connect. The goroutines run in the main group, so that the connect request getting
+// cancelled doesn't cancel the work.
+func (s *service) startSession(c context.Context, pcr parsedConnectRequest) *rpc.ConnectInfo {
+	mgrNs := pcr.Config.Manager.Namespace
+	sess := sharedstate.NewSession(pcr.Config.Context+"/"+mgrNs, pcr.Config.Context, mgrNs)
+	if mode := proxyMode(pcr.ConnectRequest); mode != client.ProxyModeTUN {
+		sess.ProxyMode = mode
+	}
+	if err := s.sharedState.AddSession(sess); err != nil {
+		return connectError(rpc.ConnectInfo_CLUSTER_FAILED, err)
+	}
+	for _, o := range client.GetConfig(c).MatchingOverrides(sess.Context) {
+		dlog.Infof(c, "Using config override %q of %s for context %s", o.Pattern, o.Source, sess.Context)
+	}
+	s.sessionSeq++
+	s.scoutUsers.Add(1)
+	response := make(chan *rpc.ConnectInfo, 1)
+	s.group.Go(fmt.Sprintf("session-%d", s.sessionSeq), func(c context.Context) error {
+		defer s.scoutUsers.Done()
+		err := s.runSession(c, sess, pcr, response)
+		if err != nil {
+			dlog.Errorf(c, "session with %s ended with: %v", sess.DisplayName(), err)
+		}
+		if s.disconnect(c, sess) == 0 {
+			// The connector quits with the error of its last session
+			return err
+		}
+		<-c.Done() // Don't trip ShutdownOnNonError in the parent group.
+		return nil
+	})
+	return <-response
+}
+
+// runSession runs the goroutines of the given session until it ends, or until the connector quits.
+func (s *service) runSession(c context.Context, sess *sharedstate.Session, pcr parsedConnectRequest, response chan<- *rpc.ConnectInfo) error {
+	outer := c
+	end := func() { s.disconnect(outer, sess) }
+	c = client.WithKubeContextConfig(c, sess.Context)
+	c, cancel := context.WithCancel(c)
+	defer cancel()
+	go func() {
+		select {
+		case <-c.Done():
+		case <-sess.Ended():
+			cancel()
+		}
+	}()
+
+	g := dgroup.NewGroup(c, dgroup.GroupConfig{})
+
+	// init handles the work done by the connector.Connect RPC call that created the session.
+	g.Go("init", func(c context.Context) error {
+		defer func() {
+			sess.MaybeSetCluster(nil)
+			sess.MaybeSetTrafficManager(nil)
+		}()
+		progress := newConnectProgress(pcr.progress)
+		response <- s.connectWorker(trace.ContextWithSpanContext(c, pcr.spanContext), sess, pcr, progress, end)
+		return nil
+	})
+
+	// k8swatch watches all of the nescessary Kubernetes resources.
+	g.Go("k8swatch", func(c context.Context) error {
+		cluster, _ := sess.GetClusterBlocking(c)
+		if cluster == nil {
+			return nil
+		}
+		return cluster.RunWatchers(c)
+	})
+
+	// manager (1) starts up with ensuring that the manager is installed and running,
+	// but then for most of its life
+	//  - (2) calls manager.ArriveAsClient and then periodically calls manager.Remain
+	//  - watch the intercepts (manager.WatchIntercepts) and then
+	//    + (3) listen on the appropriate local ports and forward them to the intercepted
+	//      Services, and
+	//    + (4) mount the appropriate remote valumes.
+	g.Go("manager", func(c context.Context) error {
+		mgr, _ := sess.GetTrafficManagerBlocking(c)
+		if mgr == nil {
+			return nil
+		}
+		return mgr.Run(c)
+	})
+	return g.Wait()
+}
+
+// disconnect ends the given session, and returns the number of remaining sessions, or -1 if the session
+// had already ended. The connector quits when no sessions remain. The root daemon then keeps the routes
+// of the last session, so that they're restored when the session is replaced by a new connect.
+func (s *service) disconnect(c context.Context, sess *sharedstate.Session) int {
+	remaining := s.sharedState.RemoveSession(sess)
+	if remaining < 0 {
+		return remaining
+	}
+	dlog.Infof(c, "Disconnecting from %s", sess.DisplayName())
+	sess.End()
+	s.managerServers.Delete(sess.Name)
+	if remaining == 0 {
+		s.cancel()
+		return remaining
+	}
+	if c.Err() != nil {
+		// The connector is quitting
+		return remaining
+	}
+	if sess.ProxyMode != "" {
+		// The root daemon doesn't know the session
+		return remaining
 	}
 
-	// establish a connection to the daemon gRPC service
-	dlog.Info(c, "Connecting to daemon...")
+	// The other sessions remain, so the root daemon must stop routing to this one.
+	c, cancel := context.WithTimeout(dcontext.WithoutCancel(c), 5*time.Second)
+	defer cancel()
 	conn, err := client.DialSocket(c, client.DaemonSocketName)
 	if err != nil {
-		dlog.Errorf(c, "unable to connect to daemon: %+v", err)
-		s.sharedState.MaybeSetCluster(nil)
-		s.sharedState.MaybeSetTrafficManager(nil)
-		s.cancel()
-		return connectError(rpc.ConnectInfo_DAEMON_FAILED, err)
+		dlog.Errorf(c, "unable to connect to daemon: %v", err)
+		return remaining
+	}
+	defer conn.Close()
+	if _, err = daemon.NewDaemonClient(conn).RemoveSession(c, &daemon.SessionName{Name: sess.Name}); err != nil {
+		// A session that failed to connect is unknown to the root daemon
+		dlog.Debugf(c, "unable to remove session %s from the root daemon: %v", sess.Name, err)
+	}
+	return remaining
+}
+
+// resolveMappedNamespaces returns the sorted list of namespaces that the connector should map.
+// Namespaces given in the request take precedence over those in the client config. A nil return
+// value means that all namespaces are mapped.
+func resolveMappedNamespaces(c context.Context, cr *rpc.ConnectRequest) []string {
+	mns := cr.MappedNamespaces
+	if len(mns) == 0 {
+		mns = client.GetConfig(c).MappedNamespaces
+	}
+	if len(mns) == 0 || len(mns) == 1 && mns[0] == "all" {
+		return nil
+	}
+	mns = append(make([]string, 0, len(mns)), mns...)
+	sort.Strings(mns)
+	return mns
+}
+
+// proxyMode returns the proxy mode that the given request asks for.
+func proxyMode(cr *rpc.ConnectRequest) string {
+	if cr.ProxyMode == "" {
+		return client.ProxyModeTUN
+	}
+	return cr.ProxyMode
+}
+
+// proxyModeOf returns the proxy mode of the given session.
+func proxyModeOf(sess *sharedstate.Session) string {
+	if sess.ProxyMode == "" {
+		return client.ProxyModeTUN
+	}
+	return sess.ProxyMode
+}
+
+// connectWorker connects the given session using the given request. The session is ended using the
+// given end function when the connect fails.
+func (s *service) connectWorker(
+	c context.Context,
+	sess *sharedstate.Session,
+	pcr parsedConnectRequest,
+	progress *connectProgress,
+	end func(),
+) *rpc.ConnectInfo {
+	c, span := tracing.StartSpan(c, "connect")
+	var err error
+	defer func() { tracing.EndSpan(span, err) }()
+	defer func() {
+		if err != nil {
+			progress.fail(err)
+		}
+	}()
+
+	start := time.Now()
+	defer func() {
+		if err != nil {
+			s.scout <- ScoutReport{
+				Action: "connect_fail",
+				Metadata: map[string]interface{}{
+					"connect_duration_ms": time.Since(start).Milliseconds(),
+					"failure_reason":      scout.FailureReason(err),
+				},
+			}
+		}
+	}()
+
+	k8sConfig := pcr.Config
+	mappedNamespaces := resolveMappedNamespaces(c, pcr.ConnectRequest)
+
+	s.scout <- ScoutReport{
+		Action: "connect",
+	}
+
+	var daemonClient daemon.DaemonClient
+	var portProxy *userd_trafficmgr.PortProxy
+	var socksProxy *userd_trafficmgr.SOCKSProxy
+	var k8sCallbacks userd_k8s.Callbacks
+	tmCallbacks := userd_trafficmgr.Callbacks{
+		GetCloudAPIKey: s.sharedState.GetCloudAPIKey,
+		RegisterManagerServer: func(mgrSrv manager.ManagerServer) {
+			s.managerServers.Set(sess.Name, sess.Context, mgrSrv)
+		},
+		Disconnect: end,
+		Notify:     s.sharedState.UserNotifications.Push,
+		Progress: func(step string) {
+			progress.start(step, "")
+		},
+	}
+	switch sess.ProxyMode {
+	case client.ProxyModePorts:
+		// There's no root daemon. The services are proxied by the connector.
+		dlog.Info(c, "Using proxy mode ports")
+		portProxy = userd_trafficmgr.NewPortProxy(pcr.HostsFile)
+		k8sCallbacks.SetServices = portProxy.SetServices
+	case client.ProxyModeSOCKS5:
+		// There's no root daemon. The connections of SOCKS5 clients are relayed by the connector.
+		address := pcr.Socks5Address
+		if address == "" {
+			address = client.SOCKS5Address(c)
+		}
+		dlog.Infof(c, "Using proxy mode socks5 on %s", address)
+		if socksProxy, err = userd_trafficmgr.NewSOCKSProxy(address); err != nil {
+			sess.MaybeSetCluster(nil)
+			sess.MaybeSetTrafficManager(nil)
+			end()
+			return connectError(rpc.ConnectInfo_CLUSTER_FAILED, errcat.User.New(err))
+		}
+		go func() {
+			<-c.Done()
+			_ = socksProxy.Close()
+		}()
+	default:
+		// establish a connection to the daemon gRPC service
+		dlog.Info(c, "Connecting to daemon...")
+		sc, stage := tracing.StartSpan(c, "connect daemon")
+		conn, err := client.DialSocket(sc, client.DaemonSocketName)
+		tracing.EndSpan(stage, err)
+		if err != nil {
+			dlog.Errorf(c, "unable to connect to daemon: %+v", err)
+			sess.MaybeSetCluster(nil)
+			sess.MaybeSetTrafficManager(nil)
+			end()
+			return connectError(rpc.ConnectInfo_DAEMON_FAILED, err)
+		}
+		go func() {
+			<-c.Done()
+			_ = conn.Close()
+		}()
+		daemonClient = daemon.NewDaemonClient(conn)
+		k8sCallbacks.SetDNSSearchPath = func(ctx context.Context, in *daemon.Paths, opts ...grpc.CallOption) (*empty.Empty, error) {
+			in.SessionName = sess.Name
+			return daemonClient.SetDnsSearchPath(ctx, in, opts...)
+		}
+		k8sCallbacks.SetHeadlessServices = func(ctx context.Context, in *daemon.HeadlessServices, opts ...grpc.CallOption) (*empty.Empty, error) {
+			in.SessionName = sess.Name
+			return daemonClient.SetHeadlessServices(ctx, in, opts...)
+		}
+		if client.GetConfig(c).OutboundTrafficPolicy == client.OutboundTrafficPolicyMappedNamespaces {
+			k8sCallbacks.SetRoutedIPs = func(ctx context.Context, in *daemon.RoutedIPs, opts ...grpc.CallOption) (*empty.Empty, error) {
+				in.SessionName = sess.Name
+				return daemonClient.SetRoutedIPs(ctx, in, opts...)
+			}
+		}
+		tmCallbacks.SetOutboundInfo = func(ctx context.Context, in *daemon.OutboundInfo, opts ...grpc.CallOption) (*empty.Empty, error) {
+			in.SessionName = sess.Name
+			return daemonClient.SetOutboundInfo(ctx, in, opts...)
+		}
+		tmCallbacks.DaemonStatus = daemonClient.Status
+		tmCallbacks.QuitRootDaemon = func(ctx context.Context) error {
+			if len(s.sharedState.Sessions()) > 1 {
+				// Disconnecting removes the session from the root daemon, which keeps running for the others
+				return nil
+			}
+			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			defer cancel()
+			_, err := daemonClient.Quit(ctx, &empty.Empty{})
+			return err
+		}
 	}
-	// Don't bother calling 'conn.Close()', it should remain open until we shut down, and just
-	// prefer to let the OS close it when we exit.
-	daemonClient := daemon.NewDaemonClient(conn)
 
 	dlog.Info(c, "Connecting to k8s cluster...")
+	progress.start(client.ConnectStepCluster, "context "+k8sConfig.Context)
+	sc, stage := tracing.StartSpan(c, "connect cluster")
 	cluster, err := func() (*userd_k8s.Cluster, error) {
-		c, cancel := client.GetConfig(c).Timeouts.TimeoutContext(c, client.TimeoutClusterConnect)
+		c, cancel := client.GetConfig(c).Timeouts.TimeoutContext(sc, client.TimeoutClusterConnect)
 		defer cancel()
-		cluster, err := userd_k8s.NewCluster(c,
-			k8sConfig,
-			mappedNamespaces,
-			userd_k8s.Callbacks{
-				SetDNSSearchPath: daemonClient.SetDnsSearchPath,
-			},
-		)
+		cluster, err := userd_k8s.NewCluster(c, k8sConfig, mappedNamespaces, k8sCallbacks)
 		if err != nil {
 			return nil, err
 		}
 		return cluster, nil
 	}()
+	tracing.EndSpan(stage, err)
 	if err != nil {
 		dlog.Errorf(c, "unable to track k8s cluster: %+v", err)
-		s.sharedState.MaybeSetCluster(nil)
-		s.sharedState.MaybeSetTrafficManager(nil)
-		s.cancel()
+		sess.MaybeSetCluster(nil)
+		sess.MaybeSetTrafficManager(nil)
+		end()
 		return connectError(rpc.ConnectInfo_CLUSTER_FAILED, err)
 	}
-	s.sharedState.MaybeSetCluster(cluster)
+	sess.MaybeSetCluster(cluster)
 	dlog.Infof(c, "Connected to context %s (%s)", cluster.Context, cluster.Server)
+	if proxyMode(pcr.ConnectRequest) == client.ProxyModeTUN {
+		cluster.DetectLocalCluster(c, pcr.ForceTunnel)
+	}
 
 	// Phone home with the information about the size of the cluster
 	s.scout <- func() ScoutReport {
 		report := ScoutReport{
 			Action: "connecting_traffic_manager",
 			PersistentMetadata: map[string]interface{}{
-				"cluster_id":        cluster.GetClusterId(c),
-				"mapped_namespaces": len(cr.MappedNamespaces),
+				"cluster_id":        cluster.GetTelemetryClusterId(c),
+				"mapped_namespaces": len(mappedNamespaces),
 			},
 		}
 		return report
@@ -217,98 +660,147 @@ func (s *service) connectWorker(c context.Context, cr *rpc.ConnectRequest, k8sCo
 	connectStart := time.Now()
 
 	dlog.Info(c, "Connecting to traffic manager...")
+	progress.start(client.ConnectStepTrafficManager, "namespace "+cluster.GetManagerNamespace())
 	tmgr, err := userd_trafficmgr.New(c,
 		cluster,
-		s.scoutClient.Reporter.InstallID(),
-		userd_trafficmgr.Callbacks{
-			GetCloudAPIKey: s.sharedState.GetCloudAPIKey,
-			RegisterManagerServer: func(mgrSrv manager.ManagerServer) {
-				manager.RegisterManagerServer(svc, mgrSrv)
-			},
-			SetOutboundInfo: daemonClient.SetOutboundInfo,
-		})
+		s.scoutClient.InstallID(c),
+		pcr.managerValues,
+		portProxy,
+		socksProxy,
+		tmCallbacks)
 	if err != nil {
 		dlog.Errorf(c, "Unable to connect to TrafficManager: %s", err)
 		// No point in continuing without a traffic manager
-		s.sharedState.MaybeSetTrafficManager(nil)
-		s.cancel()
+		sess.MaybeSetTrafficManager(nil)
+		end()
 		return connectError(rpc.ConnectInfo_TRAFFIC_MANAGER_FAILED, err)
 	}
-	s.sharedState.MaybeSetTrafficManager(tmgr)
+	sess.MaybeSetTrafficManager(tmgr)
 
 	// Wait for traffic manager to connect
 	dlog.Info(c, "Waiting for TrafficManager to connect")
-	tc, cancel := client.GetConfig(c).Timeouts.TimeoutContext(c, client.TimeoutTrafficManagerConnect)
+	// The background-manager installs the traffic-manager if needed and then arrives as a client
+	sc, stage = tracing.StartSpan(c, "wait for traffic-manager session")
+	tc, cancel := client.GetConfig(sc).Timeouts.TimeoutContext(sc, client.TimeoutTrafficManagerConnect)
 	defer cancel()
-	if _, err := tmgr.GetClientBlocking(tc); err != nil {
+	_, err = tmgr.GetClientBlocking(tc)
+	tracing.EndSpan(stage, err)
+	if err != nil {
 		dlog.Errorf(c, "Failed to initialize session with traffic-manager: %v", err)
 		// No point in continuing without a traffic manager
-		s.cancel()
+		end()
 		return connectError(rpc.ConnectInfo_TRAFFIC_MANAGER_FAILED, err)
 	}
 
-	// Wait until all of the k8s watches (in the "background-k8swatch" goroutine) are running.
+	// Wait until all of the k8s watches (in the "background-k8swatch" goroutine) are running. The
+	// namespace watcher provides the DNS search path of the root daemon.
+	progress.start(client.ConnectStepDNS, "")
 	if err = cluster.WaitUntilReady(c); err != nil {
-		s.cancel()
+		end()
 		return connectError(rpc.ConnectInfo_CLUSTER_FAILED, err)
 	}
+	progress.finish()
 
 	// Collect data on how long connection time took
 	s.scout <- ScoutReport{
 		Action: "finished_connecting_traffic_manager",
 		Metadata: map[string]interface{}{
-			"connect_duration": time.Since(connectStart).Seconds(),
+			"connect_duration":    time.Since(connectStart).Seconds(),
+			"connect_duration_ms": time.Since(start).Milliseconds(),
 		},
 	}
 
 	ingressInfo, err := cluster.DetectIngressBehavior(c)
 	if err != nil {
-		s.cancel()
+		end()
 		return connectError(rpc.ConnectInfo_CLUSTER_FAILED, err)
 	}
 
 	ret := &rpc.ConnectInfo{
-		Error:          rpc.ConnectInfo_UNSPECIFIED,
-		ClusterContext: cluster.Config.Context,
-		ClusterServer:  cluster.Config.Server,
-		ClusterId:      cluster.GetClusterId(c),
-		IngressInfos:   ingressInfo,
+		Error:              rpc.ConnectInfo_UNSPECIFIED,
+		ClusterContext:     cluster.Config.Context,
+		ClusterServer:      cluster.Config.Server,
+		ClusterNamespace:   cluster.Config.Namespace,
+		ClusterId:          cluster.GetClusterId(c),
+		TelemetryClusterId: cluster.GetTelemetryClusterId(c),
+		ManagerNamespace:   cluster.GetManagerNamespace(),
+		IngressInfos:       ingressInfo,
+		MappedNamespaces:   mappedNamespaces,
+	}
+	// Report the conflicts between the routed subnets and the local routes, and between the cluster
+	// subnets of this session and those of other sessions, that the daemon found. Without a root
+	// daemon, nothing is routed.
+	if daemonClient != nil {
+		if ds, err := daemonClient.Status(c, &empty.Empty{}); err != nil {
+			dlog.Errorf(c, "unable to get the status of the root daemon: %v", err)
+		} else {
+			ret.SubnetConflicts = ds.SubnetConflicts
+			for _, sc := range ds.SessionConflicts {
+				if sc.SessionName == sess.Name {
+					ret.SessionConflicts = append(ret.SessionConflicts, sc)
+				}
+			}
+		}
 	}
 	tmgr.SetStatus(c, ret)
 	return ret
 }
 
-// run is the main function when executing as the connector
-func run(c context.Context) error {
+// run is the main function when executing as the connector. The gRPC API is served on the given TCP
+// address, or on the connector socket when the address is empty, and also on the WSL endpoint when wsl
+// is true.
+func run(c context.Context, address string, wsl bool) error {
 	cfg, err := client.LoadConfig(c)
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 	c = client.WithConfig(c, cfg)
+	if cfg.Tracing.Enabled {
+		defer tracing.Start(c, ProcessName, tracing.DefaultBufferSize)(c)
+	}
 	c = dgroup.WithGoroutineName(c, "/"+ProcessName)
 	c, err = logging.InitContext(c, ProcessName)
 	if err != nil {
 		return err
 	}
 
-	// Listen on domain unix domain socket or windows named pipe. The listener must be opened
-	// before other tasks because the CLI client will only wait for a short period of time for
-	// the socket/pipe to appear before it gives up.
-	grpcListener, err := client.ListenSocket(c, ProcessName, client.ConnectorSocketName)
-	if err != nil {
-		return err
+	// Listen on domain unix domain socket, windows named pipe, or the configured user daemon address.
+	// The listener must be opened before other tasks because the CLI client will only wait for a short
+	// period of time for the socket/pipe to appear before it gives up.
+	var grpcListener net.Listener
+	if address != "" {
+		if grpcListener, err = net.Listen("tcp", address); err != nil {
+			return err
+		}
+	} else {
+		if grpcListener, err = client.ListenSocket(c, ProcessName, client.UserDaemonAddress(c)); err != nil {
+			return err
+		}
+		defer func() {
+			_ = client.RemoveSocket(grpcListener)
+		}()
+	}
+	var wslListener net.Listener
+	if wsl {
+		endpointFile, err := client.WSLEndpointFile(c)
+		if err != nil {
+			return err
+		}
+		if wslListener, err = client.ListenWSLSocket(c, ProcessName, "", endpointFile); err != nil {
+			return err
+		}
+		defer func() {
+			_ = client.RemoveSocket(wslListener)
+		}()
+		dlog.Infof(c, "Serving the CLI in WSL on %s", wslListener.Addr())
 	}
-	defer func() {
-		_ = client.RemoveSocket(grpcListener)
-	}()
 	dlog.Debug(c, "Listener opened")
 
 	s := &service{
 		scoutClient: scout.NewScout(c, "connector"),
 
-		scout:           make(chan ScoutReport, 10),
-		connectRequest:  make(chan parsedConnectRequest),
-		connectResponse: make(chan *rpc.ConnectInfo),
+		scout:          make(chan ScoutReport, 10),
+		managerServers: userd_grpc.NewManagerServers(),
 	}
 	if s.sharedState, err = sharedstate.NewState(c, ProcessName); err != nil {
 		return err
@@ -318,12 +810,12 @@ func run(c context.Context) error {
 		EnableSignalHandling: true,
 		ShutdownOnNonError:   true,
 	})
+	s.group = g
 	s.cancel = func() { g.Go("quit", func(_ context.Context) error { return nil }) }
 	s.sharedState.LoginExecutor = userd_auth.NewStandardLoginExecutor(&s.sharedState.UserNotifications, s.scout)
-	var scoutUsers sync.WaitGroup
-	scoutUsers.Add(1) // how many of the goroutines might write to s.scout
+	s.scoutUsers.Add(1) // the server-grpc goroutine, and each session that it starts, might write to s.scout
 	go func() {
-		scoutUsers.Wait()
+		s.scoutUsers.Wait()
 		close(s.scout)
 	}()
 
@@ -332,20 +824,12 @@ func run(c context.Context) error {
 	dlog.Infof(c, "PID is %d", os.Getpid())
 	dlog.Info(c, "")
 
-	svcCh := make(chan *grpc.Server, 1)
 	g.Go("server-grpc", func(c context.Context) (err error) {
 		defer func() {
-			close(svcCh)
+			s.scoutUsers.Done()
 			if perr := derror.PanicToError(recover()); perr != nil {
 				dlog.Error(c, perr)
 			}
-
-			// Close s.connectRequest if it hasn't already been closed.
-			select {
-			case <-s.connectRequest:
-			default:
-				close(s.connectRequest)
-			}
 		}()
 
 		defer func() {
@@ -356,87 +840,72 @@ func run(c context.Context) error {
 			}
 		}()
 
-		opts := []grpc.ServerOption{}
-		if mxRecvSize := client.GetConfig(c).Grpc.MaxReceiveSize; mxRecvSize != nil {
-			if mz, ok := mxRecvSize.AsInt64(); ok {
-				opts = append(opts, grpc.MaxRecvMsgSize(int(mz)))
-			}
-		}
+		opts := append(tracing.ServerOptions(), client.GrpcServerOptions(c)...)
+		opts = append(opts, userd_grpc.VersionServerOptions()...)
 		svc := grpc.NewServer(opts...)
 		rpc.RegisterConnectorServer(svc, userd_grpc.NewGRPCService(
 			userd_grpc.Callbacks{
 				InterceptStatus: s.interceptStatus,
 				Cancel:          s.cancel,
 				Connect:         s.connect,
+				Disconnect: func(c context.Context, sess *sharedstate.Session) {
+					s.disconnect(c, sess)
+				},
 			},
 			s.sharedState,
+			s.scout,
 		))
-		svcCh <- svc
+		s.managerServers.Register(svc)
 
 		sc := &dhttp.ServerConfig{
 			Handler: svc,
 		}
 		dlog.Info(c, "gRPC server started")
-		return sc.Serve(c, grpcListener)
-	})
-
-	// background-init handles the work done by the initial connector.Connect RPC call.  This
-	// happens in a separate goroutine from the gRPC server's connection handler so that the
-	// request getting cancelled doesn't cancel the work.
-	g.Go("background-init", func(c context.Context) error {
-		defer func() {
-			close(s.connectResponse) // -> server-grpc.connect()
-			s.sharedState.MaybeSetCluster(nil)
-			s.sharedState.MaybeSetTrafficManager(nil)
-			<-c.Done() // Don't trip ShutdownOnNonError in the parent group.
-			scoutUsers.Done()
-		}()
-
-		pcr, ok := <-s.connectRequest
-		if !ok {
-			return nil
-		}
-		svc, ok := <-svcCh
-		if !ok {
-			return nil
-		}
-		s.connectResponse <- s.connectWorker(c, pcr.ConnectRequest, pcr.Config, svc)
-
-		return nil
-	})
-
-	// background-k8swatch watches all of the nescessary Kubernetes resources.
-	g.Go("background-k8swatch", func(c context.Context) error {
-		cluster, _ := s.sharedState.GetClusterBlocking(c)
-		if cluster == nil {
-			return nil
-		}
-		return cluster.RunWatchers(c)
-	})
-
-	// background-manager (1) starts up with ensuring that the manager is installed and running,
-	// but then for most of its life
-	//  - (2) calls manager.ArriveAsClient and then periodically calls manager.Remain
-	//  - watch the intercepts (manager.WatchIntercepts) and then
-	//    + (3) listen on the appropriate local ports and forward them to the intercepted
-	//      Services, and
-	//    + (4) mount the appropriate remote valumes.
-	g.Go("background-manager", func(c context.Context) error {
-		mgr, _ := s.sharedState.GetTrafficManagerBlocking(c)
-		if mgr == nil {
-			return nil
+		if wslListener == nil {
+			return sc.Serve(c, grpcListener)
 		}
-		return mgr.Run(c)
+		// The CLI in WSL is served by the same gRPC server
+		sg := dgroup.NewGroup(c, dgroup.GroupConfig{ShutdownOnNonError: true})
+		sg.Go("socket", func(c context.Context) error {
+			return sc.Serve(c, grpcListener)
+		})
+		sg.Go("wsl", func(c context.Context) error {
+			return sc.Serve(c, wslListener)
+		})
+		return sg.Wait()
 	})
 
 	// background-systema runs a localhost HTTP server for handling callbacks from the
 	// Ambassador Cloud login flow.
 	g.Go("background-systema", s.sharedState.LoginExecutor.Worker)
 
+	// config-watcher reloads the config when the config files change. The timeouts and the telemetry
+	// opt-out are read from the config when they're used, so only the log level must be applied.
+	g.Go("config-watcher", func(c context.Context) error {
+		return client.WatchConfig(c, func(c context.Context, r *client.ConfigReload) {
+			s.sharedState.SetDefaultLogLevel(c, r.Current.LogLevels.UserDaemon.String())
+		})
+	})
+
+	// server-metrics serves the Prometheus metrics of the connector when an address is configured.
+	if address := cfg.Metrics.DaemonAddress(false); address != "" {
+		g.Go("server-metrics", func(c context.Context) error {
+			return metrics.ListenAndServe(c, address)
+		})
+	}
+
 	// background-metriton is the goroutine that handles all telemetry reports, so that calls to
-	// metriton don't block the functional goroutines.
+	// metriton don't block the functional goroutines. The reports are sent in batches by
+	// background-metriton-batch, which sends the remaining reports when background-metriton ends.
+	batcher := s.scoutClient.StartBatching()
+	g.Go("background-metriton-batch", batcher.Run)
 	g.Go("background-metriton", func(c context.Context) error {
+		defer batcher.Close()
 		for report := range s.scout {
+			if s.scoutClient.Disabled() {
+				// Drain the channel so that its users don't block
+				continue
+			}
 			for k, v := range report.PersistentMetadata {
 				s.scoutClient.SetMetadatum(k, v)
 			}
@@ -459,3 +928,11 @@ func run(c context.Context) error {
 	}
 	return err
 }
+
+func subnetsFromRPC(rs []*manager.IPNet) []*iputil.Subnet {
+	subnets := make([]*iputil.Subnet, len(rs))
+	for i, r := range rs {
+		subnets[i] = (*iputil.Subnet)(iputil.IPNetFromRPC(r))
+	}
+	return subnets
+}
@@ -0,0 +1,206 @@
+package connector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/sharedstate"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_grpc"
+)
+
+// testKubeConfig has two contexts with an API server that refuses connections.
+const testKubeConfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://127.0.0.1:1
+contexts:
+- name: dev
+  context:
+    cluster: test
+    user: test
+- name: shared
+  context:
+    cluster: test
+    user: test
+current-context: dev
+users:
+- name: test
+  user:
+    token: abc
+`
+
+// testService returns a service without a scout client, root daemon, or cluster, and a counter of the
+// calls to its cancel function, which the connector uses to quit.
+func testService(t *testing.T) (context.Context, *service, *int32) {
+	ctx := client.WithEnv(dlog.NewTestContext(t, false), &client.Env{ManagerNamespace: "ambassador"})
+	cfg := client.GetDefaultConfig(ctx)
+	cfg.Timeouts.PrivateClusterConnect = 2 * time.Second
+	ctx, cancel := context.WithCancel(client.WithConfig(ctx, &cfg))
+
+	var cancels int32
+	s := &service{
+		cancel:         func() { atomic.AddInt32(&cancels, 1) },
+		sharedState:    &sharedstate.State{},
+		group:          dgroup.NewGroup(ctx, dgroup.GroupConfig{}),
+		managerServers: userd_grpc.NewManagerServers(),
+		scout:          make(chan ScoutReport, 100),
+	}
+	go func() {
+		for range s.scout {
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		_ = s.group.Wait()
+		close(s.scout)
+	})
+	return ctx, s, &cancels
+}
+
+// connectRequest returns a request to connect to the given context using proxy mode ports, so that no
+// root daemon is needed.
+func connectRequest(t *testing.T, kubeContext string) *rpc.ConnectRequest {
+	file := filepath.Join(t.TempDir(), "kubeconfig")
+	require.NoError(t, os.WriteFile(file, []byte(testKubeConfig), 0600))
+	return &rpc.ConnectRequest{
+		KubeFlags: map[string]string{"kubeconfig": file, "context": kubeContext},
+		ProxyMode: client.ProxyModePorts,
+	}
+}
+
+// addSession adds a session to the given service, as if it had been created by a connect that still
+// runs.
+func addSession(t *testing.T, s *service, kubeContext string) *sharedstate.Session {
+	sess := sharedstate.NewSession(kubeContext+"/ambassador", kubeContext, "ambassador")
+	sess.ProxyMode = client.ProxyModePorts
+	require.NoError(t, s.sharedState.AddSession(sess))
+	return sess
+}
+
+func TestService_connect_create(t *testing.T) {
+	ctx, s, cancels := testService(t)
+	other := addSession(t, s, "shared")
+
+	// The session must exist while it connects, and end when its connect fails.
+	var connecting []*sharedstate.Session
+	ci := s.connect(ctx, connectRequest(t, "dev"), false, func(p *rpc.ConnectProgress) {
+		if p.Step == client.ConnectStepCluster && p.State == rpc.ConnectProgress_STARTED {
+			connecting = s.sharedState.Sessions()
+		}
+	})
+	assert.Equal(t, rpc.ConnectInfo_CLUSTER_FAILED, ci.Error)
+	require.Len(t, connecting, 2)
+	assert.Equal(t, "dev", connecting[0].Context)
+	assert.Equal(t, "ambassador", connecting[0].ManagerNamespace)
+	assert.Equal(t, client.ProxyModePorts, connecting[0].ProxyMode)
+
+	select {
+	case <-connecting[0].Ended():
+	default:
+		t.Fatal("the session that failed to connect didn't end")
+	}
+	assert.Equal(t, []*sharedstate.Session{other}, s.sharedState.Sessions())
+	assert.Zero(t, atomic.LoadInt32(cancels), "the connector must not quit while other sessions remain")
+}
+
+func TestService_connect_createLast(t *testing.T) {
+	ctx, s, cancels := testService(t)
+	ci := s.connect(ctx, connectRequest(t, "dev"), false, nil)
+	assert.Equal(t, rpc.ConnectInfo_CLUSTER_FAILED, ci.Error)
+	assert.Empty(t, s.sharedState.Sessions())
+	assert.Equal(t, int32(1), atomic.LoadInt32(cancels), "the connector must quit when its last session ends")
+}
+
+func TestService_connect_dryRun(t *testing.T) {
+	ctx, s, _ := testService(t)
+	ci := s.connect(ctx, connectRequest(t, "dev"), true, nil)
+	assert.Equal(t, rpc.ConnectInfo_DISCONNECTED, ci.Error)
+	assert.Empty(t, ci.Sessions)
+	assert.Empty(t, s.sharedState.Sessions(), "a dry run must not create a session")
+
+	addSession(t, s, "dev")
+	ci = s.connect(ctx, connectRequest(t, "dev"), true, nil)
+	assert.Equal(t, rpc.ConnectInfo_DISCONNECTED, ci.Error)
+	require.Len(t, ci.Sessions, 1)
+	assert.Equal(t, "dev", ci.Sessions[0].ClusterContext)
+}
+
+func TestService_connect_select(t *testing.T) {
+	ctx, s, _ := testService(t)
+	dev := addSession(t, s, "dev")
+
+	// A connect without a context is for the only session, which hasn't connected yet.
+	cr := connectRequest(t, "")
+	ci := s.connect(ctx, cr, false, nil)
+	assert.Equal(t, rpc.ConnectInfo_DISCONNECTED, ci.Error)
+	assert.Equal(t, []*sharedstate.Session{dev}, s.sharedState.Sessions())
+
+	// ... and is ambiguous when there are several sessions.
+	addSession(t, s, "shared")
+	ci = s.connect(ctx, cr, false, nil)
+	assert.Equal(t, rpc.ConnectInfo_SESSION_AMBIGUOUS, ci.Error)
+	assert.Contains(t, ci.ErrorText, "use --context to select one")
+	require.Len(t, ci.Sessions, 2)
+	assert.Equal(t, "dev", ci.Sessions[0].ClusterContext)
+	assert.Equal(t, "shared", ci.Sessions[1].ClusterContext)
+
+	// A connect with a context is for the session of that context.
+	ci = s.connect(ctx, connectRequest(t, "shared"), false, nil)
+	assert.Equal(t, rpc.ConnectInfo_DISCONNECTED, ci.Error)
+	assert.Len(t, s.sharedState.Sessions(), 2)
+}
+
+func TestService_disconnect(t *testing.T) {
+	ctx, s, cancels := testService(t)
+	dev := addSession(t, s, "dev")
+	shared := addSession(t, s, "shared")
+
+	assert.Equal(t, 1, s.disconnect(ctx, dev))
+	select {
+	case <-dev.Ended():
+	default:
+		t.Fatal("the disconnected session didn't end")
+	}
+	assert.Equal(t, []*sharedstate.Session{shared}, s.sharedState.Sessions())
+	assert.Zero(t, atomic.LoadInt32(cancels))
+
+	// Ending a session twice is harmless.
+	assert.Equal(t, -1, s.disconnect(ctx, dev))
+	assert.Zero(t, atomic.LoadInt32(cancels))
+
+	assert.Equal(t, 0, s.disconnect(ctx, shared))
+	assert.Empty(t, s.sharedState.Sessions())
+	assert.Equal(t, int32(1), atomic.LoadInt32(cancels))
+}
+
+func TestService_connect_replace(t *testing.T) {
+	ctx, s, _ := testService(t)
+	addSession(t, s, "shared")
+	dev := addSession(t, s, "dev")
+	s.disconnect(ctx, dev)
+
+	// A connect to the context of an ended session creates a new session that replaces it.
+	var replacement *sharedstate.Session
+	ci := s.connect(ctx, connectRequest(t, "dev"), false, func(p *rpc.ConnectProgress) {
+		if p.Step == client.ConnectStepCluster && p.State == rpc.ConnectProgress_STARTED {
+			replacement, _ = s.sharedState.SelectSession("dev", "")
+		}
+	})
+	assert.Equal(t, rpc.ConnectInfo_CLUSTER_FAILED, ci.Error)
+	require.NotNil(t, replacement)
+	assert.NotSame(t, dev, replacement)
+	assert.Equal(t, dev.Name, replacement.Name, "the root daemon must see the same session")
+}
@@ -0,0 +1,65 @@
+package connector
+
+import (
+	"sync"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// connectProgress reports the steps that the connectWorker takes to the caller of ConnectStream. A
+// step is done when the next one starts, and it's the current step that fails when the connect fails.
+// The zero value, and a nil pointer, report nothing.
+type connectProgress struct {
+	sync.Mutex
+	send func(*rpc.ConnectProgress)
+	step string
+}
+
+func newConnectProgress(send func(*rpc.ConnectProgress)) *connectProgress {
+	return &connectProgress{send: send}
+}
+
+// start marks the current step as done and starts the given one.
+func (p *connectProgress) start(step, detail string) {
+	if p == nil {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	if p.send == nil {
+		return
+	}
+	p.endStep(rpc.ConnectProgress_DONE, "")
+	p.step = step
+	p.send(&rpc.ConnectProgress{Step: step, State: rpc.ConnectProgress_STARTED, Detail: detail})
+}
+
+// fail marks the current step as failed with the given error and stops the reporting.
+func (p *connectProgress) fail(err error) {
+	p.close(rpc.ConnectProgress_FAILED, err.Error())
+}
+
+// finish marks the current step as done and stops the reporting. Steps that are started later, e.g.
+// by a traffic-manager that reconnects, are not reported.
+func (p *connectProgress) finish() {
+	p.close(rpc.ConnectProgress_DONE, "")
+}
+
+func (p *connectProgress) close(state rpc.ConnectProgress_State, detail string) {
+	if p == nil {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	if p.send != nil {
+		p.endStep(state, detail)
+		p.send = nil
+	}
+}
+
+func (p *connectProgress) endStep(state rpc.ConnectProgress_State, detail string) {
+	if p.step != "" {
+		p.send(&rpc.ConnectProgress{Step: p.step, State: state, Detail: detail})
+		p.step = ""
+	}
+}
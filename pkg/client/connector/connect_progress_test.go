@@ -0,0 +1,79 @@
+package connector
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+func Test_connectProgress(t *testing.T) {
+	started := func(step, detail string) *rpc.ConnectProgress {
+		return &rpc.ConnectProgress{Step: step, State: rpc.ConnectProgress_STARTED, Detail: detail}
+	}
+	done := func(step string) *rpc.ConnectProgress {
+		return &rpc.ConnectProgress{Step: step, State: rpc.ConnectProgress_DONE}
+	}
+
+	// record returns a connectProgress that records what it sends
+	record := func() (*connectProgress, *[]*rpc.ConnectProgress) {
+		var sent []*rpc.ConnectProgress
+		return newConnectProgress(func(p *rpc.ConnectProgress) { sent = append(sent, p) }), &sent
+	}
+
+	t.Run("success", func(t *testing.T) {
+		p, sent := record()
+		p.start(client.ConnectStepCluster, "context kind")
+		p.start(client.ConnectStepTrafficManager, "namespace ambassador")
+		p.start(client.ConnectStepTunnel, "")
+		p.start(client.ConnectStepDNS, "")
+		p.finish()
+
+		// A traffic-manager that reconnects later doesn't report anything
+		p.start(client.ConnectStepTunnel, "")
+		p.fail(errors.New("lost the session"))
+
+		assert.Equal(t, []*rpc.ConnectProgress{
+			started(client.ConnectStepCluster, "context kind"),
+			done(client.ConnectStepCluster),
+			started(client.ConnectStepTrafficManager, "namespace ambassador"),
+			done(client.ConnectStepTrafficManager),
+			started(client.ConnectStepTunnel, ""),
+			done(client.ConnectStepTunnel),
+			started(client.ConnectStepDNS, ""),
+			done(client.ConnectStepDNS),
+		}, *sent)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		p, sent := record()
+		p.start(client.ConnectStepCluster, "context kind")
+		p.start(client.ConnectStepTrafficManager, "namespace ambassador")
+		p.fail(errors.New("helm install failed"))
+		p.start(client.ConnectStepTunnel, "")
+		p.finish()
+
+		assert.Equal(t, []*rpc.ConnectProgress{
+			started(client.ConnectStepCluster, "context kind"),
+			done(client.ConnectStepCluster),
+			started(client.ConnectStepTrafficManager, "namespace ambassador"),
+			{Step: client.ConnectStepTrafficManager, State: rpc.ConnectProgress_FAILED, Detail: "helm install failed"},
+		}, *sent)
+	})
+
+	t.Run("failure before first step", func(t *testing.T) {
+		p, sent := record()
+		p.fail(errors.New("no daemon"))
+		assert.Empty(t, *sent)
+	})
+
+	t.Run("unary connect", func(t *testing.T) {
+		var p *connectProgress
+		p.start(client.ConnectStepCluster, "")
+		p.fail(errors.New("unreachable"))
+		newConnectProgress(nil).start(client.ConnectStepCluster, "")
+	})
+}
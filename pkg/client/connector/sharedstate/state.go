@@ -2,6 +2,10 @@ package sharedstate
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
@@ -11,6 +15,7 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_auth"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_auth/authdata"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_k8s"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/logging"
 	"github.com/telepresenceio/telepresence/v2/pkg/log"
 )
@@ -34,38 +39,65 @@ type TrafficManager interface {
 	GetClientNonBlocking() (manager.ManagerClient, error)
 
 	AddIntercept(context.Context, *connector.CreateInterceptRequest) (*connector.InterceptResult, error)
+	PlanIntercept(context.Context, *connector.CreateInterceptRequest) (*connector.InterceptPlan, error)
 	RemoveIntercept(context.Context, string) error
+	HoldIntercept(context.Context, string) error
 	WorkloadInfoSnapshot(context.Context, *connector.ListRequest) *connector.WorkloadInfoSnapshot
 	Uninstall(context.Context, *connector.UninstallRequest) (*connector.UninstallResult, error)
+	PortForward(context.Context, *connector.PortForwardRequest, func(*connector.PortForwardSnapshot) error) error
+	Resolve(context.Context, *connector.ResolveRequest) (*connector.ResolveResult, error)
 	SetStatus(context.Context, *connector.ConnectInfo)
 }
 
-type State struct {
-	LoginExecutor     userd_auth.LoginExecutor
-	UserNotifications broadcastqueue.BroadcastQueue
+// A Session is the connection to the traffic-manager in one namespace of the cluster of one kubernetes
+// context. The cluster and the traffic manager of a session are set once, by its connect worker.
+type Session struct {
+	// Name identifies the session in the root daemon.
+	Name             string
+	Context          string
+	ManagerNamespace string
+
+	// ProxyMode is client.ProxyModePorts or client.ProxyModeSOCKS5 when the session has no root daemon, and
+	// empty otherwise.
+	ProxyMode string
+
+	ended   chan struct{}
+	endOnce sync.Once
 
 	clusterFinalized chan struct{}
 	cluster          *userd_k8s.Cluster
 
 	trafficMgrFinalized chan struct{}
 	trafficMgr          TrafficManager
-	procName            string
-	timedLogLevel       log.TimedLevel
 }
 
-func NewState(ctx context.Context, procName string) (*State, error) {
-	s := &State{
-		//LoginExecutor:     "Caller will initialize this later",
-		//UserNotifications: "The zero value is fine",
+func NewSession(name, kubeContext, managerNamespace string) *Session {
+	return &Session{
+		Name:                name,
+		Context:             kubeContext,
+		ManagerNamespace:    managerNamespace,
+		ended:               make(chan struct{}),
 		clusterFinalized:    make(chan struct{}),
 		trafficMgrFinalized: make(chan struct{}),
-		procName:            procName,
-		timedLogLevel:       log.NewTimedLevel(client.GetConfig(ctx).LogLevels.UserDaemon.String(), log.SetLevel),
 	}
-	return s, logging.LoadTimedLevelFromCache(ctx, s.timedLogLevel, procName)
 }
 
-func (s *State) MaybeSetCluster(cluster *userd_k8s.Cluster) bool {
+// DisplayName describes the session in messages to the user.
+func (s *Session) DisplayName() string {
+	return fmt.Sprintf("context %s (traffic-manager in namespace %s)", s.Context, s.ManagerNamespace)
+}
+
+// End tells the goroutines of the session to end.
+func (s *Session) End() {
+	s.endOnce.Do(func() { close(s.ended) })
+}
+
+// Ended returns a channel that is closed when the session has been told to end.
+func (s *Session) Ended() <-chan struct{} {
+	return s.ended
+}
+
+func (s *Session) MaybeSetCluster(cluster *userd_k8s.Cluster) bool {
 	select {
 	case <-s.clusterFinalized:
 		return false
@@ -76,7 +108,7 @@ func (s *State) MaybeSetCluster(cluster *userd_k8s.Cluster) bool {
 	}
 }
 
-func (s *State) GetClusterBlocking(ctx context.Context) (*userd_k8s.Cluster, error) {
+func (s *Session) GetClusterBlocking(ctx context.Context) (*userd_k8s.Cluster, error) {
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
@@ -85,7 +117,7 @@ func (s *State) GetClusterBlocking(ctx context.Context) (*userd_k8s.Cluster, err
 	}
 }
 
-func (s *State) GetClusterNonBlocking() *userd_k8s.Cluster {
+func (s *Session) GetClusterNonBlocking() *userd_k8s.Cluster {
 	select {
 	case <-s.clusterFinalized:
 		return s.cluster
@@ -94,7 +126,7 @@ func (s *State) GetClusterNonBlocking() *userd_k8s.Cluster {
 	}
 }
 
-func (s *State) MaybeSetTrafficManager(mgr TrafficManager) bool {
+func (s *Session) MaybeSetTrafficManager(mgr TrafficManager) bool {
 	select {
 	case <-s.trafficMgrFinalized:
 		return false
@@ -105,7 +137,7 @@ func (s *State) MaybeSetTrafficManager(mgr TrafficManager) bool {
 	}
 }
 
-func (s *State) GetTrafficManagerBlocking(ctx context.Context) (TrafficManager, error) {
+func (s *Session) GetTrafficManagerBlocking(ctx context.Context) (TrafficManager, error) {
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
@@ -114,7 +146,7 @@ func (s *State) GetTrafficManagerBlocking(ctx context.Context) (TrafficManager,
 	}
 }
 
-func (s *State) GetTrafficManagerNonBlocking() TrafficManager {
+func (s *Session) GetTrafficManagerNonBlocking() TrafficManager {
 	select {
 	case <-s.trafficMgrFinalized:
 		return s.trafficMgr
@@ -123,6 +155,120 @@ func (s *State) GetTrafficManagerNonBlocking() TrafficManager {
 	}
 }
 
+type State struct {
+	LoginExecutor     userd_auth.LoginExecutor
+	UserNotifications broadcastqueue.BroadcastQueue
+
+	sessionsLock sync.Mutex
+	sessions     []*Session
+
+	procName      string
+	timedLogLevel log.TimedLevel
+}
+
+func NewState(ctx context.Context, procName string) (*State, error) {
+	s := &State{
+		//LoginExecutor:     "Caller will initialize this later",
+		//UserNotifications: "The zero value is fine",
+		procName:      procName,
+		timedLogLevel: log.NewTimedLevel(client.GetConfig(ctx).LogLevels.UserDaemon.String(), log.SetLevel),
+	}
+	return s, logging.LoadTimedLevelFromCache(ctx, s.timedLogLevel, procName)
+}
+
+// AddSession adds the given session. It's an error to add a session with the context and manager
+// namespace of an existing session.
+func (s *State) AddSession(sess *Session) error {
+	s.sessionsLock.Lock()
+	defer s.sessionsLock.Unlock()
+	for _, other := range s.sessions {
+		if other.Context == sess.Context && other.ManagerNamespace == sess.ManagerNamespace {
+			return fmt.Errorf("already connected to %s", sess.DisplayName())
+		}
+	}
+	s.sessions = append(s.sessions, sess)
+	return nil
+}
+
+// RemoveSession removes the given session and returns the number of remaining sessions, or -1 if the
+// session had already been removed.
+func (s *State) RemoveSession(sess *Session) int {
+	s.sessionsLock.Lock()
+	defer s.sessionsLock.Unlock()
+	for i, other := range s.sessions {
+		if other == sess {
+			sessions := make([]*Session, 0, len(s.sessions)-1)
+			sessions = append(sessions, s.sessions[:i]...)
+			s.sessions = append(sessions, s.sessions[i+1:]...)
+			return len(s.sessions)
+		}
+	}
+	return -1
+}
+
+// Sessions returns all sessions, ordered by context and manager namespace.
+func (s *State) Sessions() []*Session {
+	s.sessionsLock.Lock()
+	sessions := make([]*Session, len(s.sessions))
+	copy(sessions, s.sessions)
+	s.sessionsLock.Unlock()
+	sort.Slice(sessions, func(i, j int) bool {
+		si, sj := sessions[i], sessions[j]
+		if si.Context != sj.Context {
+			return si.Context < sj.Context
+		}
+		return si.ManagerNamespace < sj.ManagerNamespace
+	})
+	return sessions
+}
+
+// SelectSession returns the session that uses the given kubernetes context and manager namespace. An
+// empty context or manager namespace matches all sessions. Nil is returned when no session matches,
+// and an error when several sessions match.
+func (s *State) SelectSession(kubeContext, managerNamespace string) (*Session, error) {
+	var matches []*Session
+	for _, sess := range s.Sessions() {
+		if (kubeContext == "" || sess.Context == kubeContext) && (managerNamespace == "" || sess.ManagerNamespace == managerNamespace) {
+			matches = append(matches, sess)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, nil
+	case 1:
+		return matches[0], nil
+	}
+	return nil, AmbiguousSessionError(matches)
+}
+
+// AmbiguousSessionError returns the error that explains that a request must select one of the given
+// sessions, which must be ordered by context.
+func AmbiguousSessionError(sessions []*Session) error {
+	var names []string
+	for _, sess := range sessions {
+		if len(names) == 0 || names[len(names)-1] != sess.Context {
+			names = append(names, sess.Context)
+		}
+	}
+	if len(names) == 1 {
+		names = names[:0]
+		for _, sess := range sessions {
+			names = append(names, sess.ManagerNamespace)
+		}
+		return errcat.User.Newf("connected to context %s with traffic-managers in namespaces %s; use --manager-namespace to select one",
+			sessions[0].Context, joinNames(names))
+	}
+	return errcat.User.Newf("connected to contexts %s; use --context to select one", joinNames(names))
+}
+
+// joinNames joins the given names with commas and a final "and".
+func joinNames(names []string) string {
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
 func (s *State) GetCloudUserInfo(ctx context.Context, refresh, autoLogin bool) (*authdata.UserInfo, error) {
 	info, err := s.LoginExecutor.GetUserInfo(ctx, refresh)
 	if autoLogin && err != nil {
@@ -154,3 +300,8 @@ func (s *State) GetCloudAPIKey(ctx context.Context, desc string, autoLogin bool)
 func (s *State) SetLogLevel(ctx context.Context, level string, duration time.Duration) error {
 	return logging.SetAndStoreTimedLevel(ctx, s.timedLogLevel, level, duration, s.procName)
 }
+
+// SetDefaultLogLevel sets the log level that is active when no level has been set with SetLogLevel.
+func (s *State) SetDefaultLogLevel(ctx context.Context, level string) {
+	s.timedLogLevel.SetDefault(ctx, level)
+}
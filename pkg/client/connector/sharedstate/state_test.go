@@ -0,0 +1,88 @@
+package sharedstate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestState_Sessions(t *testing.T) {
+	s := &State{}
+	staging := NewSession("staging/ambassador", "staging", "ambassador")
+	dev := NewSession("dev/ambassador", "dev", "ambassador")
+	devBlue := NewSession("dev/blue", "dev", "blue")
+
+	require.NoError(t, s.AddSession(staging))
+	require.NoError(t, s.AddSession(dev))
+	require.NoError(t, s.AddSession(devBlue))
+	assert.Error(t, s.AddSession(NewSession("dev/ambassador", "dev", "ambassador")))
+	assert.Equal(t, []*Session{dev, devBlue, staging}, s.Sessions())
+
+	assert.Equal(t, 2, s.RemoveSession(dev))
+	assert.Equal(t, -1, s.RemoveSession(dev))
+	assert.Equal(t, []*Session{devBlue, staging}, s.Sessions())
+	assert.Equal(t, 1, s.RemoveSession(staging))
+	assert.Equal(t, 0, s.RemoveSession(devBlue))
+	assert.Empty(t, s.Sessions())
+}
+
+func TestState_SelectSession(t *testing.T) {
+	s := &State{}
+	sess, err := s.SelectSession("", "")
+	require.NoError(t, err)
+	assert.Nil(t, sess)
+
+	dev := NewSession("dev/ambassador", "dev", "ambassador")
+	require.NoError(t, s.AddSession(dev))
+	sess, err = s.SelectSession("", "")
+	require.NoError(t, err)
+	assert.Equal(t, dev, sess, "the only session is selected when no context is given")
+	sess, err = s.SelectSession("staging", "")
+	require.NoError(t, err)
+	assert.Nil(t, sess)
+
+	devBlue := NewSession("dev/blue", "dev", "blue")
+	staging := NewSession("staging/ambassador", "staging", "ambassador")
+	require.NoError(t, s.AddSession(devBlue))
+	require.NoError(t, s.AddSession(staging))
+
+	_, err = s.SelectSession("", "")
+	require.Error(t, err)
+	assert.Equal(t, "connected to contexts dev and staging; use --context to select one", err.Error())
+
+	_, err = s.SelectSession("dev", "")
+	require.Error(t, err)
+	assert.Equal(t, "connected to context dev with traffic-managers in namespaces ambassador and blue; use --manager-namespace to select one", err.Error())
+
+	_, err = s.SelectSession("", "ambassador")
+	require.Error(t, err)
+	assert.Equal(t, "connected to contexts dev and staging; use --context to select one", err.Error())
+
+	sess, err = s.SelectSession("dev", "blue")
+	require.NoError(t, err)
+	assert.Equal(t, devBlue, sess)
+	sess, err = s.SelectSession("", "blue")
+	require.NoError(t, err)
+	assert.Equal(t, devBlue, sess)
+	sess, err = s.SelectSession("staging", "")
+	require.NoError(t, err)
+	assert.Equal(t, staging, sess)
+}
+
+func TestSession_End(t *testing.T) {
+	sess := NewSession("dev/ambassador", "dev", "ambassador")
+	assert.Equal(t, "context dev (traffic-manager in namespace ambassador)", sess.DisplayName())
+	select {
+	case <-sess.Ended():
+		t.Fatal("session ended before End was called")
+	default:
+	}
+	sess.End()
+	sess.End()
+	select {
+	case <-sess.Ended():
+	default:
+		t.Fatal("session did not end")
+	}
+}
@@ -0,0 +1,93 @@
+package userd_grpc
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// connectorMethodPrefix is the prefix of the full names of the methods of the Connector service. The gRPC
+// server of the user daemon also serves the traffic-manager proxy, whose calls aren't versioned.
+var connectorMethodPrefix = "/" + rpc.Connector_ServiceDesc.ServiceName + "/"
+
+// unversionedMethods are the methods that are served regardless of the caller's connector API version, so
+// that a CLI that the user daemon can't serve still can learn its version, and quit it.
+var unversionedMethods = map[string]bool{
+	connectorMethodPrefix + "Version":           true,
+	connectorMethodPrefix + "Quit":              true,
+	connectorMethodPrefix + "UserNotifications": true,
+}
+
+// VersionServerOptions returns the server options that make the user daemon announce its version in the
+// header of its responses to the CLI, and reject the calls of a CLI that speaks a connector API version
+// that it doesn't serve.
+func VersionServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if strings.HasPrefix(info.FullMethod, connectorMethodPrefix) {
+				_ = grpc.SetHeader(ctx, client.VersionHeader())
+				if err := checkPeerVersion(ctx, info.FullMethod); err != nil {
+					return nil, err
+				}
+			}
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if strings.HasPrefix(info.FullMethod, connectorMethodPrefix) {
+				_ = ss.SetHeader(client.VersionHeader())
+				if err := checkPeerVersion(ss.Context(), info.FullMethod); err != nil {
+					return err
+				}
+			}
+			return handler(srv, ss)
+		}),
+	}
+}
+
+// checkPeerVersion returns a FailedPrecondition error when the caller of the given method speaks a
+// connector API version that isn't served.
+func checkPeerVersion(ctx context.Context, method string) error {
+	if unversionedMethods[method] {
+		return nil
+	}
+	peer := client.PeerVersionFromContext(ctx)
+	own := client.OwnVersion()
+	if own.Serves(peer) {
+		return nil
+	}
+	return grpcStatus.Error(grpcCodes.FailedPrecondition, client.VersionMismatch(peer, own))
+}
+
+// adaptConnectInfo returns the ConnectInfo in the shape that the caller's connector API version expects.
+// The given ConnectInfo is never modified.
+//
+// Version 1: SESSION_AMBIGUOUS is unknown, so CLUSTER_FAILED is returned instead, and the agents are sent.
+// Version 2: The deprecated agents are no longer sent.
+func adaptConnectInfo(c context.Context, ci *rpc.ConnectInfo) *rpc.ConnectInfo {
+	if ci == nil {
+		return nil
+	}
+	ci = proto.Clone(ci).(*rpc.ConnectInfo)
+	adaptConnectInfoTo(client.PeerVersionFromContext(c).API, ci)
+	return ci
+}
+
+func adaptConnectInfoTo(api int, ci *rpc.ConnectInfo) {
+	if api < 2 {
+		if ci.Error == rpc.ConnectInfo_SESSION_AMBIGUOUS {
+			ci.Error = rpc.ConnectInfo_CLUSTER_FAILED
+		}
+	} else {
+		ci.Agents = nil
+	}
+	for _, sci := range ci.Sessions {
+		adaptConnectInfoTo(api, sci)
+	}
+}
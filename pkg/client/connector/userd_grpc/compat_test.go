@@ -0,0 +1,152 @@
+package userd_grpc
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// ambiguousConnect is a connect that finds that the request is ambiguous. It always returns the same
+// ConnectInfo, so that modifications of it are detected.
+func ambiguousConnect() func(context.Context, *rpc.ConnectRequest, bool, func(*rpc.ConnectProgress)) *rpc.ConnectInfo {
+	ci := &rpc.ConnectInfo{
+		Error:  rpc.ConnectInfo_SESSION_AMBIGUOUS,
+		Agents: &manager.AgentInfoSnapshot{Agents: []*manager.AgentInfo{{Name: "echo"}}},
+		Sessions: []*rpc.ConnectInfo{
+			{Error: rpc.ConnectInfo_SESSION_AMBIGUOUS, Agents: &manager.AgentInfoSnapshot{}},
+		},
+	}
+	return func(context.Context, *rpc.ConnectRequest, bool, func(*rpc.ConnectProgress)) *rpc.ConnectInfo {
+		return ci
+	}
+}
+
+// dialVersionedConnector serves a connector that uses the VersionServerOptions on a bufconn, and returns
+// a function that dials it with the given options.
+func dialVersionedConnector(t *testing.T) func(ctx context.Context, opts ...grpc.DialOption) rpc.ConnectorClient {
+	lis := bufconn.Listen(1024 * 1024)
+	svc := grpc.NewServer(VersionServerOptions()...)
+	rpc.RegisterConnectorServer(svc, NewGRPCService(Callbacks{Connect: ambiguousConnect()}, nil, nil))
+	go func() { _ = svc.Serve(lis) }()
+	t.Cleanup(svc.Stop)
+
+	return func(ctx context.Context, opts ...grpc.DialOption) rpc.ConnectorClient {
+		conn, err := grpc.DialContext(ctx, "bufconn", append([]grpc.DialOption{
+			grpc.WithInsecure(),
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+				return lis.Dial()
+			}),
+		}, opts...)...)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		return rpc.NewConnectorClient(conn)
+	}
+}
+
+// connectStream returns the ConnectInfo of the last message of a ConnectStream.
+func connectStream(ctx context.Context, cc rpc.ConnectorClient) (*rpc.ConnectInfo, error) {
+	stream, err := cc.ConnectStream(ctx, &rpc.ConnectRequest{})
+	if err != nil {
+		return nil, err
+	}
+	var ci *rpc.ConnectInfo
+	for {
+		p, err := stream.Recv()
+		if err != nil {
+			if ci != nil {
+				return ci, nil
+			}
+			return nil, err
+		}
+		ci = p.Info
+	}
+}
+
+func TestVersionServerOptions_previousCLI(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	// A CLI that predates the versioning announces nothing, and gets the ConnectInfo of version 1
+	cc := dialVersionedConnector(t)(ctx)
+	var md metadata.MD
+	ci, err := cc.Connect(ctx, &rpc.ConnectRequest{}, grpc.Header(&md))
+	require.NoError(t, err)
+	assert.Equal(t, rpc.ConnectInfo_CLUSTER_FAILED, ci.Error)
+	assert.Equal(t, rpc.ConnectInfo_CLUSTER_FAILED, ci.Sessions[0].Error)
+	require.NotNil(t, ci.Agents)
+	assert.Equal(t, "echo", ci.Agents.Agents[0].Name)
+	assert.Equal(t, client.OwnVersion(), client.PeerVersionFromMetadata(md), "the user daemon announces its version")
+
+	ci, err = cc.Status(ctx, &rpc.ConnectRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, rpc.ConnectInfo_CLUSTER_FAILED, ci.Error)
+	assert.NotNil(t, ci.Agents)
+
+	ci, err = connectStream(ctx, cc)
+	require.NoError(t, err)
+	assert.Equal(t, rpc.ConnectInfo_CLUSTER_FAILED, ci.Error)
+	assert.NotNil(t, ci.Agents)
+}
+
+func TestVersionServerOptions_currentCLI(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	dial := dialVersionedConnector(t)
+
+	cc := dial(ctx, client.VersionDialOptions()...)
+	ci, err := cc.Connect(ctx, &rpc.ConnectRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, rpc.ConnectInfo_SESSION_AMBIGUOUS, ci.Error)
+	assert.Equal(t, rpc.ConnectInfo_SESSION_AMBIGUOUS, ci.Sessions[0].Error)
+	assert.Nil(t, ci.Agents, "the deprecated agents are only sent to a CLI that speaks version 1")
+	assert.Nil(t, ci.Sessions[0].Agents)
+
+	ci, err = connectStream(ctx, cc)
+	require.NoError(t, err)
+	assert.Equal(t, rpc.ConnectInfo_SESSION_AMBIGUOUS, ci.Error)
+	assert.Nil(t, ci.Agents)
+
+	// The ConnectInfo of the callback is never modified
+	ci, err = dial(ctx).Status(ctx, &rpc.ConnectRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, rpc.ConnectInfo_CLUSTER_FAILED, ci.Error)
+	assert.NotNil(t, ci.Agents)
+}
+
+func TestVersionServerOptions_futureCLI(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	api := strconv.Itoa(client.ConnectorAPIVersion + 1)
+	cc := dialVersionedConnector(t)(ctx)
+	ctx = metadata.AppendToOutgoingContext(ctx,
+		"x-telepresence-api-version", api,
+		"x-telepresence-min-api-version", api,
+		"x-telepresence-version", "v9.9.9")
+
+	_, err := cc.Connect(ctx, &rpc.ConnectRequest{})
+	st := status.Convert(err)
+	assert.Equal(t, codes.FailedPrecondition, st.Code())
+	assert.Contains(t, st.Message(), "telepresence v9.9.9")
+	assert.Contains(t, st.Message(), "telepresence "+client.Version())
+	assert.Contains(t, st.Message(), "telepresence quit -s")
+
+	_, err = connectStream(ctx, cc)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// The version can still be obtained, so that the CLI can tell the user what's wrong
+	vi, err := cc.Version(ctx, &emptypb.Empty{})
+	require.NoError(t, err)
+	assert.Equal(t, client.Version(), vi.Version)
+}
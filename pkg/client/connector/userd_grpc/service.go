@@ -2,10 +2,13 @@ package userd_grpc
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	grpcCodes "google.golang.org/grpc/codes"
@@ -22,12 +25,15 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/sharedstate"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_auth"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/scout"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 )
 
 type Callbacks struct {
-	InterceptStatus func() *rpc.InterceptResult
+	InterceptStatus func(*sharedstate.Session) *rpc.InterceptResult
 	Cancel          func()
-	Connect         func(c context.Context, cr *rpc.ConnectRequest, dryRun bool) *rpc.ConnectInfo
+	Connect         func(c context.Context, cr *rpc.ConnectRequest, dryRun bool, progress func(*rpc.ConnectProgress)) *rpc.ConnectInfo
+	Disconnect      func(c context.Context, sess *sharedstate.Session)
 }
 
 type service struct {
@@ -35,6 +41,7 @@ type service struct {
 
 	callbacks   Callbacks
 	sharedState *sharedstate.State
+	scout       chan<- scout.ScoutReport
 
 	ucn int64
 }
@@ -42,10 +49,12 @@ type service struct {
 func NewGRPCService(
 	callbacks Callbacks,
 	sharedState *sharedstate.State,
+	scout chan<- scout.ScoutReport,
 ) rpc.ConnectorServer {
 	return &service{
 		callbacks:   callbacks,
 		sharedState: sharedState,
+		scout:       scout,
 	}
 }
 
@@ -68,6 +77,29 @@ func (s *service) callCtx(ctx context.Context, name string) context.Context {
 	return dgroup.WithGoroutineName(ctx, fmt.Sprintf("/%s-%d", name, atomic.AddInt64(&s.ucn, 1)))
 }
 
+// session returns the session that uses the given kubernetes context, or the only session when the
+// context is empty, together with a context that has the client config of that session. Nil is returned
+// when there's no such session.
+func (s *service) session(c context.Context, kubeContext string) (context.Context, *sharedstate.Session, error) {
+	sess, err := s.sharedState.SelectSession(kubeContext, "")
+	if err != nil {
+		return c, nil, grpcStatus.Error(grpcCodes.FailedPrecondition, err.Error())
+	}
+	if sess != nil {
+		c = client.WithKubeContextConfig(c, sess.Context)
+	}
+	return c, sess, nil
+}
+
+// notConnectedError returns the error of a call that requires a session when there's no session that
+// uses the given kubernetes context.
+func notConnectedError(kubeContext string) error {
+	if kubeContext == "" {
+		return grpcStatus.Error(grpcCodes.FailedPrecondition, "not connected")
+	}
+	return grpcStatus.Errorf(grpcCodes.FailedPrecondition, "not connected to context %s", kubeContext)
+}
+
 func (s *service) Version(_ context.Context, _ *empty.Empty) (*common.VersionInfo, error) {
 	return &common.VersionInfo{
 		ApiVersion: client.APIVersion,
@@ -79,16 +111,45 @@ func (s *service) Connect(c context.Context, cr *rpc.ConnectRequest) (ci *rpc.Co
 	c = s.callCtx(c, "Connect")
 	dlog.Debug(c, "called")
 	defer func() { err = callRecovery(c, recover(), err) }()
-	ci, err = s.callbacks.Connect(c, cr, false), nil
+	ci, err = adaptConnectInfo(c, s.callbacks.Connect(c, cr, false, nil)), nil
 	dlog.Debug(c, "returned")
 	return
 }
 
+func (s *service) ConnectStream(cr *rpc.ConnectRequest, stream rpc.Connector_ConnectStreamServer) (err error) {
+	c := s.callCtx(stream.Context(), "ConnectStream")
+	dlog.Debug(c, "called")
+	defer func() { err = callRecovery(c, recover(), err) }()
+
+	// The connect continues when the client goes away, and may report progress from other goroutines,
+	// so sending stops at the first error and when the connect returns.
+	var mu sync.Mutex
+	closed := false
+	send := func(p *rpc.ConnectProgress) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		if err := stream.Send(p); err != nil {
+			dlog.Debugf(c, "unable to send progress: %v", err)
+			closed = true
+		}
+	}
+	ci := s.callbacks.Connect(c, cr, false, send)
+	mu.Lock()
+	closed = true
+	mu.Unlock()
+	err = stream.Send(&rpc.ConnectProgress{Info: adaptConnectInfo(c, ci)})
+	dlog.Debug(c, "returned")
+	return err
+}
+
 func (s *service) Status(c context.Context, cr *rpc.ConnectRequest) (ci *rpc.ConnectInfo, err error) {
 	c = s.callCtx(c, "Status")
 	dlog.Debug(c, "called")
 	defer func() { err = callRecovery(c, recover(), err) }()
-	ci, err = s.callbacks.Connect(c, cr, true), nil
+	ci, err = adaptConnectInfo(c, s.callbacks.Connect(c, cr, true, nil)), nil
 	dlog.Debug(c, "returned")
 	return
 }
@@ -96,32 +157,97 @@ func (s *service) Status(c context.Context, cr *rpc.ConnectRequest) (ci *rpc.Con
 func (s *service) CreateIntercept(c context.Context, ir *rpc.CreateInterceptRequest) (result *rpc.InterceptResult, err error) {
 	c = s.callCtx(c, "CreateIntercept")
 	dlog.Debug(c, "called")
-	result = s.callbacks.InterceptStatus()
+	c, sess, err := s.session(c, ir.Context)
+	if err != nil {
+		dlog.Debug(c, "returned")
+		return nil, err
+	}
+	result = s.callbacks.InterceptStatus(sess)
 	if result != nil {
 		dlog.Debug(c, "returned")
 		return result, nil
 	}
 	defer func() { err = callRecovery(c, recover(), err) }()
-	mgr, err := s.sharedState.GetTrafficManagerBlocking(c)
+	mgr, err := sess.GetTrafficManagerBlocking(c)
 	if mgr == nil {
 		dlog.Debug(c, "returned")
 		return nil, err
 	}
+	start := time.Now()
 	result, err = mgr.AddIntercept(c, ir)
+	s.reportCreateIntercept(time.Since(start), result, err)
+	dlog.Debug(c, "returned")
+	return
+}
+
+func (s *service) PlanIntercept(c context.Context, ir *rpc.CreateInterceptRequest) (plan *rpc.InterceptPlan, err error) {
+	c = s.callCtx(c, "PlanIntercept")
+	dlog.Debug(c, "called")
+	c, sess, err := s.session(c, ir.Context)
+	if err != nil {
+		dlog.Debug(c, "returned")
+		return nil, err
+	}
+	if result := s.callbacks.InterceptStatus(sess); result != nil {
+		dlog.Debug(c, "returned")
+		return &rpc.InterceptPlan{Spec: ir.Spec, Failure: result}, nil
+	}
+	defer func() { err = callRecovery(c, recover(), err) }()
+	mgr, err := sess.GetTrafficManagerBlocking(c)
+	if mgr == nil {
+		dlog.Debug(c, "returned")
+		return nil, err
+	}
+	plan, err = mgr.PlanIntercept(c, ir)
 	dlog.Debug(c, "returned")
 	return
 }
 
-func (s *service) RemoveIntercept(c context.Context, rr *manager.RemoveInterceptRequest2) (result *rpc.InterceptResult, err error) {
+// reportCreateIntercept sends the outcome of a CreateIntercept call to scout. Only the category of a
+// failure is reported, never its error text.
+func (s *service) reportCreateIntercept(duration time.Duration, result *rpc.InterceptResult, err error) {
+	report := scout.ScoutReport{
+		Action: "intercept_create_success",
+		Metadata: map[string]interface{}{
+			"intercept_create_duration_ms": duration.Milliseconds(),
+		},
+	}
+	if err = interceptResultError(result, err); err != nil {
+		report.Action = "intercept_create_fail"
+		report.Metadata["failure_reason"] = scout.FailureReason(err)
+	}
+	s.scout <- report
+}
+
+// interceptResultError returns the error that an InterceptResult represents, or nil if it represents success.
+func interceptResultError(result *rpc.InterceptResult, err error) error {
+	switch {
+	case err != nil:
+		return err
+	case result == nil || result.Error == rpc.InterceptError_UNSPECIFIED:
+		return nil
+	case result.Error == rpc.InterceptError_LOCAL_TARGET_IN_USE:
+		return fmt.Errorf("%s: %w", result.ErrorText, syscall.EADDRINUSE)
+	default:
+		return fmt.Errorf("%s: %s", result.Error, result.ErrorText)
+	}
+}
+
+func (s *service) RemoveIntercept(c context.Context, rr *rpc.RemoveInterceptRequest) (result *rpc.InterceptResult, err error) {
 	c = s.callCtx(c, "RemoveIntercept")
 	dlog.Debug(c, "called")
-	result = s.callbacks.InterceptStatus()
+	c, sess, err := s.session(c, rr.Context)
+	if err != nil {
+		dlog.Debug(c, "returned")
+		return nil, err
+	}
+	result = s.callbacks.InterceptStatus(sess)
 	if result != nil {
 		dlog.Debug(c, "returned")
 		return result, nil
 	}
 	defer func() { err = callRecovery(c, recover(), err) }()
-	mgr, err := s.sharedState.GetTrafficManagerBlocking(c)
+	mgr, err := sess.GetTrafficManagerBlocking(c)
 	if mgr == nil {
 		dlog.Debug(c, "returned")
 		return nil, err
@@ -142,11 +268,41 @@ func (s *service) RemoveIntercept(c context.Context, rr *manager.RemoveIntercept
 	return result, nil
 }
 
+func (s *service) HoldIntercept(hr *rpc.HoldInterceptRequest, stream rpc.Connector_HoldInterceptServer) (err error) {
+	c := s.callCtx(stream.Context(), "HoldIntercept")
+	dlog.Debug(c, "called")
+	defer func() { err = callRecovery(c, recover(), err) }()
+	c, sess, err := s.session(c, hr.Context)
+	if err == nil && sess == nil {
+		err = notConnectedError(hr.Context)
+	}
+	if err != nil {
+		dlog.Debug(c, "returned")
+		return err
+	}
+	mgr, err := sess.GetTrafficManagerBlocking(c)
+	if mgr == nil {
+		dlog.Debug(c, "returned")
+		return err
+	}
+	err = mgr.HoldIntercept(c, hr.Name)
+	dlog.Debug(c, "returned")
+	return err
+}
+
 func (s *service) List(c context.Context, lr *rpc.ListRequest) (result *rpc.WorkloadInfoSnapshot, err error) {
 	c = s.callCtx(c, "List")
 	dlog.Debug(c, "called")
+	c, sess, err := s.session(c, lr.Context)
+	if err != nil {
+		dlog.Debug(c, "returned")
+		return nil, err
+	}
 	haveManager := false
-	manager, _ := s.sharedState.GetTrafficManagerBlocking(c)
+	var manager sharedstate.TrafficManager
+	if sess != nil {
+		manager, _ = sess.GetTrafficManagerBlocking(c)
+	}
 	if manager != nil {
 		managerClient, _ := manager.GetClientNonBlocking()
 		haveManager = (managerClient != nil)
@@ -165,7 +321,15 @@ func (s *service) Uninstall(c context.Context, ur *rpc.UninstallRequest) (result
 	c = s.callCtx(c, "Uninstall")
 	dlog.Debug(c, "called")
 	defer func() { err = callRecovery(c, recover(), err) }()
-	mgr, err := s.sharedState.GetTrafficManagerBlocking(c)
+	c, sess, err := s.session(c, ur.Context)
+	if err == nil && sess == nil {
+		err = notConnectedError(ur.Context)
+	}
+	if err != nil {
+		dlog.Debug(c, "returned")
+		return nil, err
+	}
+	mgr, err := sess.GetTrafficManagerBlocking(c)
 	if mgr == nil {
 		dlog.Debug(c, "returned")
 		return nil, err
@@ -175,6 +339,50 @@ func (s *service) Uninstall(c context.Context, ur *rpc.UninstallRequest) (result
 	return
 }
 
+func (s *service) PortForward(pr *rpc.PortForwardRequest, stream rpc.Connector_PortForwardServer) (err error) {
+	c := s.callCtx(stream.Context(), "PortForward")
+	dlog.Debug(c, "called")
+	defer func() { err = callRecovery(c, recover(), err) }()
+	c, sess, err := s.session(c, pr.Context)
+	if err == nil && sess == nil {
+		err = notConnectedError(pr.Context)
+	}
+	if err != nil {
+		dlog.Debug(c, "returned")
+		return err
+	}
+	mgr, err := sess.GetTrafficManagerBlocking(c)
+	if mgr == nil {
+		dlog.Debug(c, "returned")
+		return err
+	}
+	err = mgr.PortForward(c, pr, stream.Send)
+	dlog.Debug(c, "returned")
+	return err
+}
+
+func (s *service) Resolve(c context.Context, rr *rpc.ResolveRequest) (result *rpc.ResolveResult, err error) {
+	c = s.callCtx(c, "Resolve")
+	dlog.Debug(c, "called")
+	defer func() { err = callRecovery(c, recover(), err) }()
+	c, sess, err := s.session(c, rr.Context)
+	if err == nil && sess == nil {
+		err = notConnectedError(rr.Context)
+	}
+	if err != nil {
+		dlog.Debug(c, "returned")
+		return nil, err
+	}
+	mgr, err := sess.GetTrafficManagerBlocking(c)
+	if mgr == nil {
+		dlog.Debug(c, "returned")
+		return nil, err
+	}
+	result, err = mgr.Resolve(c, rr)
+	dlog.Debug(c, "returned")
+	return
+}
+
 func (s *service) UserNotifications(_ *empty.Empty, stream rpc.Connector_UserNotificationsServer) error {
 	ctx := s.callCtx(stream.Context(), "UserNotifications")
 	dlog.Debug(ctx, "called")
@@ -286,6 +494,50 @@ func (s *service) SetLogLevel(ctx context.Context, request *manager.LogLevelRequ
 	return &empty.Empty{}, s.sharedState.SetLogLevel(ctx, request.LogLevel, duration)
 }
 
+func (s *service) GatherTraces(ctx context.Context, _ *empty.Empty) (*common.Traces, error) {
+	ctx = s.callCtx(ctx, "GatherTraces")
+	dlog.Debug(ctx, "called")
+	defer dlog.Debug(ctx, "returned")
+	return tracing.Traces(), nil
+}
+
+func (s *service) ReportTelemetry(ctx context.Context, tr *rpc.TelemetryReport) (*empty.Empty, error) {
+	ctx = s.callCtx(ctx, "ReportTelemetry")
+	dlog.Debug(ctx, "called")
+	defer dlog.Debug(ctx, "returned")
+	var metadata map[string]interface{}
+	if err := json.Unmarshal(tr.Metadata, &metadata); err != nil {
+		return nil, grpcStatus.Error(grpcCodes.InvalidArgument, err.Error())
+	}
+	select {
+	case s.scout <- scout.ScoutReport{Action: tr.Action, Metadata: metadata}:
+		return &empty.Empty{}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *service) Disconnect(c context.Context, dr *rpc.DisconnectRequest) (result *rpc.DisconnectResult, err error) {
+	c = s.callCtx(c, "Disconnect")
+	dlog.Debug(c, "called")
+	defer func() { err = callRecovery(c, recover(), err) }()
+	sess, err := s.sharedState.SelectSession(dr.Context, dr.ManagerNamespace)
+	if err == nil && sess == nil {
+		if dr.Context == "" {
+			err = errcat.User.New("not connected")
+		} else {
+			err = errcat.User.Newf("not connected to context %s", dr.Context)
+		}
+	}
+	if err != nil {
+		dlog.Debug(c, "returned")
+		return &rpc.DisconnectResult{ErrorText: err.Error(), ErrorCategory: int32(errcat.GetCategory(err))}, nil
+	}
+	s.callbacks.Disconnect(c, sess)
+	dlog.Debug(c, "returned")
+	return &rpc.DisconnectResult{ClusterContext: sess.Context, ManagerNamespace: sess.ManagerNamespace}, nil
+}
+
 func (s *service) Quit(ctx context.Context, _ *empty.Empty) (*empty.Empty, error) {
 	ctx = s.callCtx(ctx, "Quit")
 	dlog.Debug(ctx, "called")
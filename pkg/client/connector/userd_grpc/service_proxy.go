@@ -8,11 +8,15 @@ import (
 	"sync"
 
 	"google.golang.org/grpc"
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/common"
 	managerrpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
 )
 
 // mgrProxy implements rpc.ManagerServer, but just proxies all requests through a rpc.ManagerClient.
@@ -51,6 +55,14 @@ func (p *mgrProxy) GetCloudConfig(ctx context.Context, arg *empty.Empty) (*manag
 	return p.client.GetCloudConfig(ctx, arg, p.callOptions...)
 }
 
+func (p *mgrProxy) GetAgentImage(ctx context.Context, arg *empty.Empty) (*managerrpc.AgentImage, error) {
+	return p.client.GetAgentImage(ctx, arg, p.callOptions...)
+}
+
+func (p *mgrProxy) GetAgentConfig(ctx context.Context, arg *empty.Empty) (*managerrpc.AgentConfig, error) {
+	return p.client.GetAgentConfig(ctx, arg, p.callOptions...)
+}
+
 func (p *mgrProxy) ArriveAsClient(ctx context.Context, arg *managerrpc.ClientInfo) (*managerrpc.SessionInfo, error) {
 	return p.client.ArriveAsClient(ctx, arg, p.callOptions...)
 }
@@ -64,6 +76,10 @@ func (p *mgrProxy) Depart(ctx context.Context, arg *managerrpc.SessionInfo) (*em
 	return p.client.Depart(ctx, arg, p.callOptions...)
 }
 
+func (p *mgrProxy) GetClients(ctx context.Context, arg *empty.Empty) (*managerrpc.ClientInfoSnapshot, error) {
+	return p.client.GetClients(ctx, arg, p.callOptions...)
+}
+
 func (p *mgrProxy) WatchAgents(arg *managerrpc.SessionInfo, srv managerrpc.Manager_WatchAgentsServer) error {
 	cli, err := p.client.WatchAgents(srv.Context(), arg, p.callOptions...)
 	if err != nil {
@@ -113,6 +129,9 @@ func (p *mgrProxy) UpdateIntercept(ctx context.Context, arg *managerrpc.UpdateIn
 func (p *mgrProxy) ReviewIntercept(ctx context.Context, arg *managerrpc.ReviewInterceptRequest) (*empty.Empty, error) {
 	return p.client.ReviewIntercept(ctx, arg, p.callOptions...)
 }
+func (p *mgrProxy) GetAgentTLSCertificate(context.Context, *managerrpc.AgentTLSCertificateRequest) (*managerrpc.AgentTLSCertificate, error) {
+	return nil, errors.New("must call manager.GetAgentTLSCertificate from an agent (intercepted Pod), not from a client (workstation)")
+}
 
 func (p *mgrProxy) ClientTunnel(fhDaemon managerrpc.Manager_ClientTunnelServer) error {
 	ctx := fhDaemon.Context()
@@ -292,10 +311,137 @@ func (p *mgrProxy) SetLogLevel(ctx context.Context, request *managerrpc.LogLevel
 	return p.client.SetLogLevel(ctx, request, p.callOptions...)
 }
 
+func (p *mgrProxy) SetRemoteLogLevel(ctx context.Context, request *managerrpc.RemoteLogLevelRequest) (*managerrpc.RemoteLogLevels, error) {
+	return p.client.SetRemoteLogLevel(ctx, request, p.callOptions...)
+}
+
+func (p *mgrProxy) GetRemoteLogLevels(ctx context.Context, session *managerrpc.SessionInfo) (*managerrpc.RemoteLogLevels, error) {
+	return p.client.GetRemoteLogLevels(ctx, session, p.callOptions...)
+}
+
 func (p *mgrProxy) GetLogs(ctx context.Context, request *managerrpc.GetLogsRequest) (*managerrpc.LogsResponse, error) {
 	return p.client.GetLogs(ctx, request, p.callOptions...)
 }
 
+func (p *mgrProxy) StreamLogs(request *managerrpc.StreamLogsRequest, srv managerrpc.Manager_StreamLogsServer) error {
+	cli, err := p.client.StreamLogs(srv.Context(), request, p.callOptions...)
+	if err != nil {
+		return err
+	}
+	for {
+		chunk, err := cli.Recv()
+		if err != nil {
+			if err == io.EOF || srv.Context().Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if err = srv.Send(chunk); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *mgrProxy) GatherTraces(ctx context.Context, arg *empty.Empty) (*common.Traces, error) {
+	return p.client.GatherTraces(ctx, arg, p.callOptions...)
+}
+
 func (p *mgrProxy) WatchLogLevel(e *empty.Empty, server managerrpc.Manager_WatchLogLevelServer) error {
 	return errors.New("must call manager.WatchLogLevel from an agent (intercepted Pod), not from a client (workstation)")
 }
+
+func (p *mgrProxy) WatchAgentLogLevel(*managerrpc.SessionInfo, managerrpc.Manager_WatchAgentLogLevelServer) error {
+	return errors.New("must call manager.WatchAgentLogLevel from an agent (intercepted Pod), not from a client (workstation)")
+}
+
+// ManagerServers dispatches the calls to the Manager service of the connector to the ManagerServer of
+// the session that a call is made for. The root daemon names the session using
+// client.SessionNameDialOptions, and the CLI gives the kubernetes context of the session using
+// client.WithSessionContext. Calls that do neither are dispatched to the only session.
+type ManagerServers struct {
+	lock     sync.Mutex
+	sessions map[string]*managerSession
+}
+
+type managerSession struct {
+	kubeContext string
+	server      managerrpc.ManagerServer
+}
+
+func NewManagerServers() *ManagerServers {
+	return &ManagerServers{sessions: make(map[string]*managerSession)}
+}
+
+// Set sets the ManagerServer of the named session, which uses the given kubernetes context.
+func (m *ManagerServers) Set(sessionName, kubeContext string, server managerrpc.ManagerServer) {
+	m.lock.Lock()
+	m.sessions[sessionName] = &managerSession{kubeContext: kubeContext, server: server}
+	m.lock.Unlock()
+}
+
+// Delete removes the ManagerServer of the named session.
+func (m *ManagerServers) Delete(sessionName string) {
+	m.lock.Lock()
+	delete(m.sessions, sessionName)
+	m.lock.Unlock()
+}
+
+func (m *ManagerServers) serverFor(ctx context.Context) (managerrpc.ManagerServer, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if name, ok := client.SessionNameFromContext(ctx); ok {
+		if ms, ok := m.sessions[name]; ok {
+			return ms.server, nil
+		}
+		return nil, grpcStatus.Errorf(grpcCodes.Unavailable, "no session named %q", name)
+	}
+	kubeContext, hasContext := client.SessionContextFromContext(ctx)
+	var matches []*managerSession
+	for _, ms := range m.sessions {
+		if !hasContext || ms.kubeContext == kubeContext {
+			matches = append(matches, ms)
+		}
+	}
+	switch {
+	case len(matches) == 1:
+		return matches[0].server, nil
+	case len(matches) == 0 && hasContext:
+		return nil, grpcStatus.Errorf(grpcCodes.Unavailable, "not connected to context %s", kubeContext)
+	case len(matches) == 0:
+		return nil, grpcStatus.Error(grpcCodes.Unavailable, "not connected")
+	default:
+		return nil, grpcStatus.Errorf(grpcCodes.FailedPrecondition, "the call is ambiguous, there are %d sessions; use --context to select one", len(matches))
+	}
+}
+
+// Register registers the Manager service on the given server. The service must not be registered
+// by other means, and the sessions can be set and deleted at any time.
+func (m *ManagerServers) Register(svc *grpc.Server) {
+	desc := managerrpc.Manager_ServiceDesc
+	desc.Methods = make([]grpc.MethodDesc, len(managerrpc.Manager_ServiceDesc.Methods))
+	for i, md := range managerrpc.Manager_ServiceDesc.Methods {
+		handler := md.Handler
+		md.Handler = func(_ interface{}, ctx context.Context, dec func(interface{}) error, ic grpc.UnaryServerInterceptor) (interface{}, error) {
+			server, err := m.serverFor(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return handler(server, ctx, dec, ic)
+		}
+		desc.Methods[i] = md
+	}
+	desc.Streams = make([]grpc.StreamDesc, len(managerrpc.Manager_ServiceDesc.Streams))
+	for i, sd := range managerrpc.Manager_ServiceDesc.Streams {
+		handler := sd.Handler
+		sd.Handler = func(_ interface{}, stream grpc.ServerStream) error {
+			server, err := m.serverFor(stream.Context())
+			if err != nil {
+				return err
+			}
+			return handler(server, stream)
+		}
+		desc.Streams[i] = sd
+	}
+	// The handlers dispatch to the session servers, so no implementation is registered
+	svc.RegisterService(&desc, nil)
+}
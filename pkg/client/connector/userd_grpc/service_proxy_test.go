@@ -0,0 +1,110 @@
+package userd_grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	managerrpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+type namedManager struct {
+	managerrpc.UnimplementedManagerServer
+	name string
+}
+
+func (m *namedManager) Version(context.Context, *emptypb.Empty) (*managerrpc.VersionInfo2, error) {
+	return &managerrpc.VersionInfo2{Version: m.name}, nil
+}
+
+func (m *namedManager) WatchLogLevel(_ *emptypb.Empty, srv managerrpc.Manager_WatchLogLevelServer) error {
+	return srv.Send(&managerrpc.LogLevelRequest{LogLevel: m.name})
+}
+
+func TestManagerServers(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	lis := bufconn.Listen(1024 * 1024)
+	svc := grpc.NewServer()
+	servers := NewManagerServers()
+	servers.Register(svc)
+	go func() { _ = svc.Serve(lis) }()
+	t.Cleanup(svc.Stop)
+
+	dial := func(opts ...grpc.DialOption) managerrpc.ManagerClient {
+		conn, err := grpc.DialContext(ctx, "bufconn", append([]grpc.DialOption{
+			grpc.WithInsecure(),
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+				return lis.Dial()
+			}),
+		}, opts...)...)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		return managerrpc.NewManagerClient(conn)
+	}
+	version := func(ctx context.Context, mc managerrpc.ManagerClient) (string, codes.Code) {
+		vi, err := mc.Version(ctx, &emptypb.Empty{})
+		if err != nil {
+			return "", status.Code(err)
+		}
+		return vi.Version, codes.OK
+	}
+	watchLogLevel := func(ctx context.Context, mc managerrpc.ManagerClient) (string, codes.Code) {
+		stream, err := mc.WatchLogLevel(ctx, &emptypb.Empty{})
+		if err == nil {
+			var ll *managerrpc.LogLevelRequest
+			if ll, err = stream.Recv(); err == nil {
+				return ll.LogLevel, codes.OK
+			}
+		}
+		return "", status.Code(err)
+	}
+
+	mc := dial()
+	devMC := dial(client.SessionNameDialOptions("dev/ambassador")...)
+	_, code := version(ctx, mc)
+	assert.Equal(t, codes.Unavailable, code)
+
+	servers.Set("dev/ambassador", "dev", &namedManager{name: "dev"})
+	name, code := version(ctx, mc)
+	require.Equal(t, codes.OK, code)
+	assert.Equal(t, "dev", name, "the only session is used when the call doesn't select one")
+
+	servers.Set("staging/ambassador", "staging", &namedManager{name: "staging"})
+	_, code = version(ctx, mc)
+	assert.Equal(t, codes.FailedPrecondition, code)
+	_, code = watchLogLevel(ctx, mc)
+	assert.Equal(t, codes.FailedPrecondition, code)
+
+	name, code = version(client.WithSessionContext(ctx, "staging"), mc)
+	require.Equal(t, codes.OK, code)
+	assert.Equal(t, "staging", name)
+	name, code = watchLogLevel(client.WithSessionContext(ctx, "staging"), mc)
+	require.Equal(t, codes.OK, code)
+	assert.Equal(t, "staging", name)
+	_, code = version(client.WithSessionContext(ctx, "prod"), mc)
+	assert.Equal(t, codes.Unavailable, code)
+
+	name, code = version(ctx, devMC)
+	require.Equal(t, codes.OK, code)
+	assert.Equal(t, "dev", name)
+	name, code = watchLogLevel(ctx, devMC)
+	require.Equal(t, codes.OK, code)
+	assert.Equal(t, "dev", name)
+
+	servers.Delete("dev/ambassador")
+	_, code = version(ctx, devMC)
+	assert.Equal(t, codes.Unavailable, code)
+	name, code = version(ctx, mc)
+	require.Equal(t, codes.OK, code)
+	assert.Equal(t, "staging", name)
+}
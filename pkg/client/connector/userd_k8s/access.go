@@ -0,0 +1,42 @@
+package userd_k8s
+
+import (
+	"context"
+	"fmt"
+
+	authv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// CanI returns true if the user is allowed to perform the access that the given attributes describe. It
+// asks the API server the same way as "kubectl auth can-i" does.
+func (kc *Cluster) CanI(c context.Context, ra *authv1.ResourceAttributes) (bool, error) {
+	cs, err := kubernetes.NewForConfig(kc.config)
+	if err != nil {
+		return false, err
+	}
+	ar, err := cs.AuthorizationV1().SelfSubjectAccessReviews().Create(c, &authv1.SelfSubjectAccessReview{
+		Spec: authv1.SelfSubjectAccessReviewSpec{ResourceAttributes: ra},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, fmt.Errorf("unable to review access to %s: %w", DescribeAccess(ra), err)
+	}
+	return ar.Status.Allowed, nil
+}
+
+// DescribeAccess returns a description of the given attributes, e.g. "update deployments.apps/echo in
+// namespace default".
+func DescribeAccess(ra *authv1.ResourceAttributes) string {
+	resource := ra.Resource
+	if ra.Group != "" {
+		resource += "." + ra.Group
+	}
+	if ra.Name != "" {
+		resource += "/" + ra.Name
+	}
+	if ra.Namespace == "" {
+		return ra.Verb + " " + resource
+	}
+	return fmt.Sprintf("%s %s in namespace %s", ra.Verb, resource, ra.Namespace)
+}
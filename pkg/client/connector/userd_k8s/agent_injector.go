@@ -0,0 +1,50 @@
+package userd_k8s
+
+import (
+	"context"
+	"fmt"
+
+	k8err "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// InjectionRemovalPatch removes the annotation that makes the traffic-manager's mutating webhook inject the
+// traffic-agent from the pod template of a workload. It is both a valid merge patch and a valid strategic
+// merge patch.
+var InjectionRemovalPatch = fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{%q:null}}}}}`, install.InjectAnnotation)
+
+// AgentInjectorInstalled returns true if the mutating webhook of the traffic-manager in the given namespace
+// is installed, i.e. if annotating a workload makes the traffic-agent get injected into its pods.
+func (kc *Cluster) AgentInjectorInstalled(c context.Context, managerNamespace string) (bool, error) {
+	cs, err := kubernetes.NewForConfig(kc.config)
+	if err != nil {
+		return false, err
+	}
+	return agentInjectorInstalled(c, cs, managerNamespace)
+}
+
+func agentInjectorInstalled(c context.Context, cs kubernetes.Interface, managerNamespace string) (bool, error) {
+	mwcs, err := cs.AdmissionregistrationV1().MutatingWebhookConfigurations().List(c, metav1.ListOptions{})
+	if err != nil {
+		if k8err.IsForbidden(err) {
+			// The configurations are cluster-wide, so a user that is limited to namespaces can't list them.
+			// The webhook is then assumed to be installed, which is what the traffic-manager's chart does
+			// by default.
+			dlog.Debugf(c, "unable to list the mutating webhook configurations, assuming that the agent-injector is installed: %v", err)
+			return true, nil
+		}
+		return false, fmt.Errorf("unable to list the mutating webhook configurations: %w", err)
+	}
+	for i := range mwcs.Items {
+		for _, wh := range mwcs.Items[i].Webhooks {
+			if svc := wh.ClientConfig.Service; svc != nil && svc.Name == install.AgentInjectorName && svc.Namespace == managerNamespace {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
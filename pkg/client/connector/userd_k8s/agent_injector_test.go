@@ -0,0 +1,74 @@
+package userd_k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admreg "k8s.io/api/admissionregistration/v1"
+	k8err "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+func webhookFixture(name, svcName, svcNamespace string) *admreg.MutatingWebhookConfiguration {
+	return &admreg.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Webhooks: []admreg.MutatingWebhook{{
+			Name: "agent-injector.getambassador.io",
+			ClientConfig: admreg.WebhookClientConfig{
+				Service: &admreg.ServiceReference{Name: svcName, Namespace: svcNamespace},
+			},
+		}},
+	}
+}
+
+func Test_agentInjectorInstalled(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	tests := []struct {
+		name     string
+		objs     []runtime.Object
+		expected bool
+	}{
+		{
+			name:     "installed",
+			objs:     []runtime.Object{webhookFixture("agent-injector-webhook-ambassador", install.AgentInjectorName, "ambassador")},
+			expected: true,
+		},
+		{
+			name:     "not installed",
+			objs:     []runtime.Object{webhookFixture("cert-manager-webhook", "cert-manager-webhook", "cert-manager")},
+			expected: false,
+		},
+		{
+			name:     "other manager namespace",
+			objs:     []runtime.Object{webhookFixture("agent-injector-webhook-team-tp", install.AgentInjectorName, "team-tp")},
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := agentInjectorInstalled(ctx, fake.NewSimpleClientset(tt.objs...), "ambassador")
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, ok)
+		})
+	}
+
+	t.Run("forbidden", func(t *testing.T) {
+		cs := fake.NewSimpleClientset()
+		cs.PrependReactor("list", "mutatingwebhookconfigurations", func(k8stesting.Action) (bool, runtime.Object, error) {
+			gr := schema.GroupResource{Group: "admissionregistration.k8s.io", Resource: "mutatingwebhookconfigurations"}
+			return true, nil, k8err.NewForbidden(gr, "", nil)
+		})
+		ok, err := agentInjectorInstalled(ctx, cs, "ambassador")
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+}
@@ -0,0 +1,284 @@
+package userd_k8s
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// execTimeout is the maximum time that the connector waits for an exec credential plugin.
+var execTimeout = 30 * time.Second
+
+// execPreflightTimeout is the maximum time that the CLI waits for an exec credential plugin. It's
+// generous because the plugin may ask the user to log in using a browser.
+var execPreflightTimeout = 5 * time.Minute
+
+// execCredential is the part of the ExecCredential that an exec credential plugin prints that
+// is of interest here. It's the same for all versions of client.authentication.k8s.io.
+type execCredential struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Status     *struct {
+		ExpirationTimestamp   *metav1.Time `json:"expirationTimestamp,omitempty"`
+		Token                 string       `json:"token,omitempty"`
+		ClientCertificateData string       `json:"clientCertificateData,omitempty"`
+		ClientKeyData         string       `json:"clientKeyData,omitempty"`
+	} `json:"status,omitempty"`
+}
+
+// credentialsExpired is the error that the user sees when the exec credential plugin no longer
+// produces credentials that the API server accepts.
+func credentialsExpired(err error) error {
+	return errcat.User.Newf("kubernetes credentials expired, re-run telepresence connect: %w", err)
+}
+
+// runExecPlugin runs the exec credential plugin of the given config and returns the credential that
+// it prints. The plugin is told that it's interactive only when it is, so that it fails instead of
+// prompting for input that it will never get.
+func runExecPlugin(c context.Context, config *clientcmdapi.ExecConfig, stdin io.Reader, stderr io.Writer) (*execCredential, error) {
+	interactive := false
+	if f, ok := stdin.(*os.File); ok {
+		interactive = term.IsTerminal(int(f.Fd()))
+	}
+	info, err := json.Marshal(map[string]interface{}{
+		"apiVersion": config.APIVersion,
+		"kind":       "ExecCredential",
+		"spec":       map[string]interface{}{"interactive": interactive},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := dexec.CommandContext(c, config.Command, config.Args...)
+	// The output contains the credentials, so it must not end up in the log
+	cmd.DisableLogging = true
+	cmd.Env = os.Environ()
+	for _, env := range config.Env {
+		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+	}
+	cmd.Env = append(cmd.Env, "KUBERNETES_EXEC_INFO="+string(info))
+	cmd.Stdin = stdin
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		switch {
+		case errors.Is(c.Err(), context.DeadlineExceeded):
+			err = fmt.Errorf("exec plugin %s did not return credentials in time", config.Command)
+		case errors.Is(err, exec.ErrNotFound) && config.InstallHint != "":
+			err = fmt.Errorf("exec plugin %s: %w\n%s", config.Command, err, config.InstallHint)
+		default:
+			err = fmt.Errorf("exec plugin %s: %w", config.Command, err)
+		}
+		return nil, err
+	}
+
+	var cred execCredential
+	if err := json.Unmarshal(stdout.Bytes(), &cred); err != nil {
+		return nil, fmt.Errorf("exec plugin %s: unable to decode its output: %w", config.Command, err)
+	}
+	if cred.APIVersion != config.APIVersion {
+		return nil, fmt.Errorf("exec plugin %s: apiVersion %q doesn't match the expected %q", config.Command, cred.APIVersion, config.APIVersion)
+	}
+	if cred.Status == nil || cred.Status.Token == "" && cred.Status.ClientCertificateData == "" {
+		return nil, fmt.Errorf("exec plugin %s: no credentials were returned", config.Command)
+	}
+	return &cred, nil
+}
+
+// execCredentials provides the tokens of an exec credential plugin in place of client-go. Unlike
+// client-go, it runs the plugin without a terminal and with a timeout, so that a plugin that wants
+// the user to log in again fails instead of hanging the connector, and it retries requests that
+// were rejected because the token was revoked before its expiration time.
+//
+// Plugins that provide client certificates are left to client-go, because a certificate can't be
+// replaced in an existing transport.
+type execCredentials struct {
+	config *clientcmdapi.ExecConfig
+
+	sync.Mutex
+	resolved    bool
+	clientCerts bool
+	token       string
+	expiry      time.Time
+}
+
+// newExecCredentials returns the execCredentials for the given config, or nil when it doesn't use
+// an exec credential plugin that this package can handle.
+func newExecCredentials(config *rest.Config) *execCredentials {
+	if config.ExecProvider == nil || config.ExecProvider.ProvideClusterInfo {
+		return nil
+	}
+	return &execCredentials{config: config.ExecProvider}
+}
+
+// refreshLocked runs the plugin and stores the credentials that it returns.
+func (ec *execCredentials) refreshLocked(c context.Context) error {
+	c, cancel := context.WithTimeout(c, execTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cred, err := runExecPlugin(c, ec.config, nil, &stderr)
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		dlog.Debugf(c, "exec plugin %s: %s", ec.config.Command, msg)
+		if err != nil {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+	}
+	if err != nil {
+		ec.token = ""
+		return credentialsExpired(err)
+	}
+	ec.resolved = true
+	if cred.Status.Token == "" {
+		ec.clientCerts = true
+		return nil
+	}
+	ec.token = cred.Status.Token
+	ec.expiry = time.Time{}
+	if exp := cred.Status.ExpirationTimestamp; exp != nil {
+		ec.expiry = exp.Time
+	}
+	return nil
+}
+
+// resolve runs the plugin unless that has been done already, and finds out what kind of
+// credentials it provides.
+func (ec *execCredentials) resolve(c context.Context) error {
+	ec.Lock()
+	defer ec.Unlock()
+	if ec.resolved {
+		return nil
+	}
+	return ec.refreshLocked(c)
+}
+
+// refresh runs the plugin so that the credentials that it provides are reevaluated.
+func (ec *execCredentials) refresh(c context.Context) error {
+	ec.Lock()
+	defer ec.Unlock()
+	if ec.clientCerts {
+		return nil
+	}
+	return ec.refreshLocked(c)
+}
+
+// getToken returns the current token, and runs the plugin to get a new one when the current token
+// has expired or is equal to the given rejected token.
+func (ec *execCredentials) getToken(c context.Context, rejected string) (string, error) {
+	ec.Lock()
+	defer ec.Unlock()
+	if ec.token == "" || ec.token == rejected || !ec.expiry.IsZero() && !time.Now().Before(ec.expiry) {
+		if err := ec.refreshLocked(c); err != nil {
+			return "", err
+		}
+	}
+	return ec.token, nil
+}
+
+// wrapConfig makes the given config use the tokens of this execCredentials instead of the exec
+// provider of client-go. The config is returned for convenience.
+func (ec *execCredentials) wrapConfig(config *rest.Config) *rest.Config {
+	if ec == nil || config.ExecProvider == nil {
+		return config
+	}
+	ec.Lock()
+	useTokens := ec.resolved && !ec.clientCerts
+	ec.Unlock()
+	if useTokens {
+		config.ExecProvider = nil
+		config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+			return &execRoundTripper{creds: ec, base: rt}
+		})
+	}
+	return config
+}
+
+type execRoundTripper struct {
+	creds *execCredentials
+	base  http.RoundTripper
+}
+
+func withBearer(req *http.Request, token string) *http.Request {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func (rt *execRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" {
+		return rt.base.RoundTrip(req)
+	}
+	token, err := rt.creds.getToken(req.Context(), "")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := rt.base.RoundTrip(withBearer(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// The token was rejected before it expired. Get a new one and retry once, provided that
+	// the request body can be sent again.
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		_, _ = rt.creds.getToken(req.Context(), token)
+		return resp, nil
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if token, err = rt.creds.getToken(req.Context(), token); err != nil {
+		return nil, err
+	}
+	retry := withBearer(req, token)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	if resp, err = rt.base.RoundTrip(retry); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+	return nil, credentialsExpired(fmt.Errorf("the API server rejected the token of exec plugin %s", rt.creds.config.Command))
+}
+
+// PreflightExecCredentials runs the exec credential plugin of the kubeconfig that the given flags
+// select, if any, with the given stdin and stderr. It gives the plugin a chance to interact with
+// the user before the connector, which has no terminal, needs the credentials.
+func PreflightExecCredentials(c context.Context, flagMap map[string]string, stdin io.Reader, stderr io.Writer) error {
+	fm := make(map[string]string, len(flagMap))
+	for k, v := range flagMap {
+		fm[k] = v
+	}
+	config, err := NewConfig(c, fm)
+	if err != nil {
+		return err
+	}
+	ep := config.config.ExecProvider
+	if ep == nil {
+		return nil
+	}
+	c, cancel := context.WithTimeout(c, execPreflightTimeout)
+	defer cancel()
+	if _, err = runExecPlugin(c, ep, stdin, stderr); err != nil {
+		return errcat.User.Newf("unable to get kubernetes credentials: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package userd_k8s
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// fakeExecPlugin prints a token that is numbered by the number of times that it has been invoked.
+// The files in PLUGIN_DIR control its behavior: "expiry" contains the expirationTimestamp of the
+// token, "expired" makes it fail like a plugin that needs the user to log in again, and "hang" makes
+// it hang. It also fails when it isn't told that it runs non-interactively.
+const fakeExecPlugin = `#!/bin/sh
+case "$KUBERNETES_EXEC_INFO" in
+  *'"interactive":false'*) ;;
+  *) echo "expected a non-interactive invocation" >&2; exit 2 ;;
+esac
+n=$(cat "$PLUGIN_DIR/count" 2>/dev/null || echo 0)
+n=$((n+1))
+echo $n > "$PLUGIN_DIR/count"
+if [ -f "$PLUGIN_DIR/expired" ]; then
+  echo "the session has expired, please log in" >&2
+  exit 1
+fi
+if [ -f "$PLUGIN_DIR/hang" ]; then
+  exec sleep 10
+fi
+expiry=$(cat "$PLUGIN_DIR/expiry" 2>/dev/null || echo 2100-01-01T00:00:00Z)
+printf '{"apiVersion":"client.authentication.k8s.io/v1beta1","kind":"ExecCredential","status":{"token":"token-%d","expirationTimestamp":"%s"}}' $n $expiry
+`
+
+const execKubeConfigTemplate = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: %s
+    insecure-skip-tls-verify: true
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+users:
+- name: test
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1beta1
+      command: %s
+      env:
+      - name: PLUGIN_DIR
+        value: %s
+`
+
+type execTestEnv struct {
+	dir        string
+	kubeconfig string
+
+	// minToken is the lowest token number that the API server accepts
+	minToken int32
+}
+
+func newExecTestEnv(t *testing.T) *execTestEnv {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake exec plugin is a shell script")
+	}
+	env := &execTestEnv{dir: t.TempDir(), minToken: 1}
+	apiServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer token-"))
+		if err != nil || int32(n) < atomic.LoadInt32(&env.minToken) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"major":"1","minor":"21","gitVersion":"v1.21.0"}`))
+	}))
+	t.Cleanup(apiServer.Close)
+
+	plugin := filepath.Join(env.dir, "plugin.sh")
+	require.NoError(t, os.WriteFile(plugin, []byte(fakeExecPlugin), 0700))
+	env.kubeconfig = filepath.Join(env.dir, "kubeconfig")
+	require.NoError(t, os.WriteFile(env.kubeconfig, []byte(fmt.Sprintf(execKubeConfigTemplate, apiServer.URL, plugin, env.dir)), 0600))
+	return env
+}
+
+func (env *execTestEnv) config(t *testing.T, ctx context.Context) *Config {
+	cfg, err := NewConfig(ctx, map[string]string{"kubeconfig": env.kubeconfig})
+	require.NoError(t, err)
+	return cfg
+}
+
+func (env *execTestEnv) touch(t *testing.T, name, content string) {
+	require.NoError(t, os.WriteFile(filepath.Join(env.dir, name), []byte(content), 0600))
+}
+
+func (env *execTestEnv) invocations(t *testing.T) int {
+	data, err := os.ReadFile(filepath.Join(env.dir, "count"))
+	require.NoError(t, err)
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	require.NoError(t, err)
+	return n
+}
+
+func serverVersion(t *testing.T, config *rest.Config) error {
+	dc, err := discovery.NewDiscoveryClientForConfig(config)
+	require.NoError(t, err)
+	_, err = dc.ServerVersion()
+	return err
+}
+
+func TestExecCredentials_revoked(t *testing.T) {
+	ctx := testContext(t, "")
+	env := newExecTestEnv(t)
+	cfg := env.config(t, ctx)
+	require.NoError(t, cfg.resolveExecCredentials(ctx))
+	assert.Nil(t, cfg.config.ExecProvider, "the tokens are provided by execCredentials")
+	require.NoError(t, serverVersion(t, cfg.config))
+	assert.Equal(t, 1, env.invocations(t))
+
+	// The token is revoked before it expires. The request is retried with a new token.
+	atomic.StoreInt32(&env.minToken, 2)
+	require.NoError(t, serverVersion(t, cfg.config))
+	assert.Equal(t, 2, env.invocations(t))
+
+	// Clients that are created later use the same tokens
+	restConfig, err := cfg.ConfigFlags.ToRESTConfig()
+	require.NoError(t, err)
+	require.NoError(t, serverVersion(t, restConfig))
+	assert.Equal(t, 2, env.invocations(t))
+}
+
+func TestExecCredentials_expiry(t *testing.T) {
+	ctx := testContext(t, "")
+	env := newExecTestEnv(t)
+	env.touch(t, "expiry", time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+	cfg := env.config(t, ctx)
+	require.NoError(t, cfg.resolveExecCredentials(ctx))
+
+	// A token that has expired is replaced before the request is made
+	atomic.StoreInt32(&env.minToken, 2)
+	require.NoError(t, serverVersion(t, cfg.config))
+	assert.Equal(t, 2, env.invocations(t))
+	atomic.StoreInt32(&env.minToken, 3)
+	require.NoError(t, serverVersion(t, cfg.config))
+	assert.Equal(t, 3, env.invocations(t))
+}
+
+func TestExecCredentials_expired(t *testing.T) {
+	ctx := testContext(t, "")
+	env := newExecTestEnv(t)
+	cfg := env.config(t, ctx)
+	require.NoError(t, cfg.resolveExecCredentials(ctx))
+
+	// The session of the plugin expires, so it can't provide a new token
+	env.touch(t, "expired", "")
+	atomic.StoreInt32(&env.minToken, 2)
+	err := serverVersion(t, cfg.config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "kubernetes credentials expired, re-run telepresence connect")
+	assert.Contains(t, err.Error(), "the session has expired, please log in")
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+
+	// A new connect refreshes the credentials of the session once the user has logged in again
+	require.Error(t, env.config(t, ctx).InheritExecCredentials(ctx, cfg))
+	require.NoError(t, os.Remove(filepath.Join(env.dir, "expired")))
+	newCfg := env.config(t, ctx)
+	require.NoError(t, newCfg.InheritExecCredentials(ctx, cfg))
+	require.NoError(t, serverVersion(t, cfg.config))
+	require.NoError(t, serverVersion(t, newCfg.config))
+}
+
+func TestExecCredentials_timeout(t *testing.T) {
+	saveTimeout := execTimeout
+	execTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { execTimeout = saveTimeout })
+
+	ctx := testContext(t, "")
+	env := newExecTestEnv(t)
+	env.touch(t, "hang", "")
+	start := time.Now()
+	err := env.config(t, ctx).resolveExecCredentials(ctx)
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Contains(t, err.Error(), "did not return credentials in time")
+	assert.Contains(t, err.Error(), "re-run telepresence connect")
+}
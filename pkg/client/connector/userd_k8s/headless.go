@@ -0,0 +1,156 @@
+package userd_k8s
+
+import (
+	"context"
+	"net"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/datawire/dlib/derror"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+)
+
+// watchHeadlessServices watches the EndpointSlices of headless services and sends the services in the
+// mapped namespaces to the DNS-resolver in the daemon each time they change. The cluster DNS has no
+// single IP for a headless service, so the resolver must know its pods to answer queries for it.
+//
+// A cluster that has no EndpointSlices, or that doesn't allow them to be listed, just means that the
+// names of headless services won't resolve, so this function doesn't return an error.
+func (kc *Cluster) watchHeadlessServices(c context.Context) (err error) {
+	defer func() {
+		if r := derror.PanicToError(recover()); r != nil {
+			dlog.Errorf(c, "unable to watch the EndpointSlices of headless services: %v", r)
+		}
+	}()
+
+	acc := kc.client.Watch(c,
+		kates.Query{
+			Name:          "EndpointSlices",
+			Kind:          "EndpointSlice",
+			LabelSelector: corev1.IsHeadlessService,
+		})
+	var snapshot struct {
+		EndpointSlices []*discoveryv1.EndpointSlice
+	}
+	for {
+		select {
+		case <-c.Done():
+			return nil
+		case <-acc.Changed():
+			kc.accLock.Lock()
+			changed := acc.Update(&snapshot)
+			if changed {
+				kc.endpointSlices = snapshot.EndpointSlices
+			}
+			kc.accLock.Unlock()
+			if changed {
+				kc.updateDaemonHeadlessServices(c)
+			}
+		}
+	}
+}
+
+// updateDaemonHeadlessServices sends the headless services in the mapped namespaces to the
+// DNS-resolver in the daemon.
+func (kc *Cluster) updateDaemonHeadlessServices(c context.Context) {
+	if kc.callbacks.SetHeadlessServices == nil {
+		return
+	}
+	kc.accLock.Lock()
+	services := headlessServices(kc.endpointSlices, kc.lastNamespaces)
+	kc.accLock.Unlock()
+
+	dlog.Debugf(c, "posting %d headless services", len(services))
+	if _, err := kc.callbacks.SetHeadlessServices(c, &daemon.HeadlessServices{Services: services}); err != nil {
+		dlog.Errorf(c, "error posting headless services to root daemon: %v", err)
+	}
+}
+
+// headlessServices returns the services that the given EndpointSlices of headless services belong
+// to, provided that they are in one of the given namespaces. Only endpoints that are ready are
+// included. A service that has no ready endpoints is included nonetheless, so that the resolver
+// knows that it exists. The services are sorted by namespace and name.
+func headlessServices(slices []*discoveryv1.EndpointSlice, namespaces []string) []*daemon.HeadlessService {
+	mapped := make(map[string]struct{}, len(namespaces))
+	for _, ns := range namespaces {
+		mapped[ns] = struct{}{}
+	}
+
+	type svcKey struct{ namespace, name string }
+	svcs := make(map[svcKey]*daemon.HeadlessService)
+	for _, slice := range slices {
+		name := slice.Labels[discoveryv1.LabelServiceName]
+		if name == "" {
+			continue
+		}
+		if _, ok := mapped[slice.Namespace]; !ok {
+			continue
+		}
+		key := svcKey{namespace: slice.Namespace, name: name}
+		svc, ok := svcs[key]
+		if !ok {
+			svc = &daemon.HeadlessService{Name: name, Namespace: slice.Namespace}
+			svcs[key] = svc
+		}
+		for _, port := range slice.Ports {
+			svc.Ports = appendPort(svc.Ports, port)
+		}
+		for _, ep := range slice.Endpoints {
+			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				continue
+			}
+			hep := &daemon.HeadlessService_Endpoint{}
+			if ep.Hostname != nil {
+				hep.Hostname = *ep.Hostname
+			}
+			for _, addr := range ep.Addresses {
+				if ip := net.ParseIP(addr); ip != nil {
+					if ip4 := ip.To4(); ip4 != nil {
+						ip = ip4
+					}
+					hep.Ips = append(hep.Ips, ip)
+				}
+			}
+			if len(hep.Ips) > 0 {
+				svc.Endpoints = append(svc.Endpoints, hep)
+			}
+		}
+	}
+
+	services := make([]*daemon.HeadlessService, 0, len(svcs))
+	for _, svc := range svcs {
+		services = append(services, svc)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Namespace != services[j].Namespace {
+			return services[i].Namespace < services[j].Namespace
+		}
+		return services[i].Name < services[j].Name
+	})
+	return services
+}
+
+// appendPort appends the given EndpointSlice port to the given ports unless it's already present. The
+// slices of a dual-stack service declare the same ports.
+func appendPort(ports []*daemon.HeadlessService_Port, port discoveryv1.EndpointPort) []*daemon.HeadlessService_Port {
+	hp := &daemon.HeadlessService_Port{Protocol: string(corev1.ProtocolTCP)}
+	if port.Name != nil {
+		hp.Name = *port.Name
+	}
+	if port.Protocol != nil {
+		hp.Protocol = string(*port.Protocol)
+	}
+	if port.Port != nil {
+		hp.Port = *port.Port
+	}
+	for _, p := range ports {
+		if p.Name == hp.Name && p.Protocol == hp.Protocol && p.Port == hp.Port {
+			return ports
+		}
+	}
+	return append(ports, hp)
+}
@@ -0,0 +1,80 @@
+package userd_k8s
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+)
+
+func strPtr(s string) *string { return &s }
+
+func boolPtr(b bool) *bool { return &b }
+
+func endpointSlice(namespace, service string, addressType discoveryv1.AddressType, endpoints ...discoveryv1.Endpoint) *discoveryv1.EndpointSlice {
+	protocol := corev1.ProtocolTCP
+	port := int32(5432)
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      service + "-" + string(addressType),
+			Namespace: namespace,
+			Labels: map[string]string{
+				discoveryv1.LabelServiceName: service,
+				corev1.IsHeadlessService:     "",
+			},
+		},
+		AddressType: addressType,
+		Endpoints:   endpoints,
+		Ports:       []discoveryv1.EndpointPort{{Name: strPtr("postgres"), Protocol: &protocol, Port: &port}},
+	}
+}
+
+func TestHeadlessServices(t *testing.T) {
+	slices := []*discoveryv1.EndpointSlice{
+		// A dual-stack StatefulSet with one pod that isn't ready
+		endpointSlice("db", "postgres", discoveryv1.AddressTypeIPv4,
+			discoveryv1.Endpoint{Addresses: []string{"10.1.0.5"}, Hostname: strPtr("postgres-0")},
+			discoveryv1.Endpoint{Addresses: []string{"10.1.0.6"}, Hostname: strPtr("postgres-1"), Conditions: discoveryv1.EndpointConditions{Ready: boolPtr(true)}},
+			discoveryv1.Endpoint{Addresses: []string{"10.1.0.7"}, Hostname: strPtr("postgres-2"), Conditions: discoveryv1.EndpointConditions{Ready: boolPtr(false)}},
+		),
+		endpointSlice("db", "postgres", discoveryv1.AddressTypeIPv6,
+			discoveryv1.Endpoint{Addresses: []string{"fd00::5"}, Hostname: strPtr("postgres-0")},
+		),
+		// Pods without hostname
+		endpointSlice("blue", "echo", discoveryv1.AddressTypeIPv4,
+			discoveryv1.Endpoint{Addresses: []string{"10.1.1.2"}},
+		),
+		// A namespace that isn't mapped
+		endpointSlice("green", "echo", discoveryv1.AddressTypeIPv4,
+			discoveryv1.Endpoint{Addresses: []string{"10.1.2.2"}},
+		),
+	}
+	// A slice that isn't managed for a service
+	orphan := endpointSlice("db", "", discoveryv1.AddressTypeIPv4, discoveryv1.Endpoint{Addresses: []string{"10.1.0.9"}})
+	delete(orphan.Labels, discoveryv1.LabelServiceName)
+	slices = append(slices, orphan)
+
+	services := headlessServices(slices, []string{"blue", "db"})
+	require.Len(t, services, 2)
+
+	echo := services[0]
+	assert.Equal(t, "blue", echo.Namespace)
+	assert.Equal(t, "echo", echo.Name)
+	assert.Equal(t, []*daemon.HeadlessService_Endpoint{{Ips: [][]byte{net.IP{10, 1, 1, 2}}}}, echo.Endpoints)
+
+	pg := services[1]
+	assert.Equal(t, "db", pg.Namespace)
+	assert.Equal(t, "postgres", pg.Name)
+	assert.Equal(t, []*daemon.HeadlessService_Port{{Name: "postgres", Protocol: "TCP", Port: 5432}}, pg.Ports, "the ports of both IP families are merged")
+	assert.Equal(t, []*daemon.HeadlessService_Endpoint{
+		{Hostname: "postgres-0", Ips: [][]byte{net.IP{10, 1, 0, 5}}},
+		{Hostname: "postgres-1", Ips: [][]byte{net.IP{10, 1, 0, 6}}},
+		{Hostname: "postgres-0", Ips: [][]byte{net.ParseIP("fd00::5")}},
+	}, pg.Endpoints)
+}
@@ -11,15 +11,20 @@ import (
 	"google.golang.org/grpc"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	k8err "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
 
 	"github.com/datawire/ambassador/v2/pkg/kates"
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/actions"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/dnet"
 )
 
 const supportedKubeAPIVersion = "1.17.0"
@@ -33,7 +38,16 @@ type objName struct {
 }
 
 type Callbacks struct {
-	SetDNSSearchPath func(ctx context.Context, in *daemon.Paths, opts ...grpc.CallOption) (*empty.Empty, error)
+	SetDNSSearchPath    func(ctx context.Context, in *daemon.Paths, opts ...grpc.CallOption) (*empty.Empty, error)
+	SetHeadlessServices func(ctx context.Context, in *daemon.HeadlessServices, opts ...grpc.CallOption) (*empty.Empty, error)
+
+	// SetServices receives the services in the mapped namespaces each time they change. The services
+	// are only watched when it's set.
+	SetServices func(ctx context.Context, services []*kates.Service)
+
+	// SetRoutedIPs receives the IPs of the services, and of their pods, in the mapped namespaces each
+	// time they change. The services and their EndpointSlices are only watched for it when it's set.
+	SetRoutedIPs func(ctx context.Context, in *daemon.RoutedIPs, opts ...grpc.CallOption) (*empty.Empty, error)
 }
 
 // k8sCluster is a Kubernetes cluster reference
@@ -41,10 +55,19 @@ type Cluster struct {
 	*Config
 	mappedNamespaces []string
 
+	// The namespaces that the traffic-manager is limited to. Empty when it manages all namespaces.
+	managedNamespaces []string
+
 	// Main
 	client    *kates.Client
 	callbacks Callbacks
 
+	// dynamic is used for custom resources, such as Argo Rollouts, that kates has no types for
+	dynamic dynamic.Interface
+
+	// localCluster is set by DetectLocalCluster
+	localCluster *LocalCluster
+
 	lastNamespaces []string
 
 	// Currently intercepted namespaces by remote intercepts
@@ -61,6 +84,19 @@ type Cluster struct {
 	curSnapshot struct {
 		Namespaces []*objName
 	}
+
+	// The EndpointSlices of the headless services in all namespaces, set by watchHeadlessServices.
+	endpointSlices []*discoveryv1.EndpointSlice
+
+	// The services in all namespaces, set by watchServices.
+	services []*kates.Service
+
+	// The EndpointSlices of the services in all namespaces, set by watchServiceEndpointSlices.
+	serviceEndpointSlices []*discoveryv1.EndpointSlice
+
+	// The routed IPs that were last sent to the daemon, and whether they have been sent at all.
+	lastRoutedIPs string
+	routedIPsSent bool
 }
 
 func (kc *Cluster) ActualNamespace(namespace string) string {
@@ -114,7 +150,7 @@ func (kc *Cluster) check(c context.Context) error {
 			return nil
 		}
 		if c.Err() == nil {
-			return fmt.Errorf("initial cluster check failed: %w", client.RunError(err))
+			return fmt.Errorf("initial cluster check failed: %w", dnet.WithProxyError(kc.config, client.RunError(err)))
 		}
 	}
 	return c.Err()
@@ -232,25 +268,108 @@ func (kc *Cluster) FindPod(c context.Context, namespace, name string) (*kates.Po
 // 1. Deployments
 // 2. ReplicaSets
 // 3. StatefulSets
-// And return the kind as soon as we find one that matches
+// 4. Argo Rollouts
+// And return the kind as soon as we find one that matches. A ReplicaSet
+// that is owned by a Deployment or a Rollout is never returned. The owner
+// is returned instead, because it would revert any modification of the
+// ReplicaSet's pod template.
+//
+// When no workload is found, but a pod with the given name exists, an
+// error explaining why that pod can't be intercepted is returned.
 func (kc *Cluster) FindWorkload(c context.Context, namespace, name string) (kates.Object, error) {
+	return findWorkload(c, namespace, name, func(c context.Context, obj kates.Object) error {
+		if IsRollout(obj) {
+			return kc.getRollout(c, obj.(*kates.Unstructured))
+		}
+		return kc.client.Get(c, obj, obj)
+	})
+}
+
+func findWorkload(c context.Context, namespace, name string, get func(context.Context, kates.Object) error) (kates.Object, error) {
 	type workLoad struct {
 		kind string
 		obj  kates.Object
 	}
-	for _, wl := range []workLoad{{"Deployment", &kates.Deployment{}}, {"ReplicaSet", &kates.ReplicaSet{}}, {"StatefulSet", &kates.StatefulSet{}}} {
-		wl.obj.(schema.ObjectKind).SetGroupVersionKind(schema.GroupVersionKind{Kind: wl.kind})
+	for _, wl := range []workLoad{
+		{"Deployment", &kates.Deployment{}},
+		{"ReplicaSet", &kates.ReplicaSet{}},
+		{"StatefulSet", &kates.StatefulSet{}},
+		{RolloutKind, newRollout(namespace, name)},
+	} {
+		if !IsRollout(wl.obj) {
+			wl.obj.(schema.ObjectKind).SetGroupVersionKind(schema.GroupVersionKind{Kind: wl.kind})
+		}
 		wl.obj.SetName(name)
 		wl.obj.SetNamespace(namespace)
-		if err := kc.client.Get(c, wl.obj, wl.obj); err != nil {
+		if err := get(c, wl.obj); err != nil {
 			if kates.IsNotFound(err) {
 				continue
 			}
 			return nil, err
 		}
+		if rs, ok := wl.obj.(*kates.ReplicaSet); ok {
+			return replicaSetWorkload(c, rs, get)
+		}
 		return wl.obj, nil
 	}
-	return nil, k8err.NewNotFound(corev1.Resource("workload"), name+"."+namespace)
+
+	pod := &kates.Pod{
+		TypeMeta:   kates.TypeMeta{Kind: "Pod"},
+		ObjectMeta: kates.ObjectMeta{Name: name, Namespace: namespace},
+	}
+	if err := get(c, pod); err != nil {
+		if kates.IsNotFound(err) {
+			return nil, k8err.NewNotFound(corev1.Resource("workload"), name+"."+namespace)
+		}
+		return nil, err
+	}
+	return nil, podNotInterceptable(pod)
+}
+
+// replicaSetWorkload returns the Deployment or Rollout that owns the given ReplicaSet, or the ReplicaSet
+// itself when no such owner exists.
+func replicaSetWorkload(c context.Context, rs *kates.ReplicaSet, get func(context.Context, kates.Object) error) (kates.Object, error) {
+	for _, owner := range rs.OwnerReferences {
+		var obj kates.Object
+		switch owner.Kind {
+		case "Deployment":
+			obj = &kates.Deployment{
+				TypeMeta:   kates.TypeMeta{Kind: "Deployment"},
+				ObjectMeta: kates.ObjectMeta{Name: owner.Name, Namespace: rs.Namespace},
+			}
+		case RolloutKind:
+			obj = newRollout(rs.Namespace, owner.Name)
+		default:
+			continue
+		}
+		if err := get(c, obj); err != nil {
+			if kates.IsNotFound(err) {
+				// The owner is gone, so the ReplicaSet is about to be garbage collected
+				continue
+			}
+			return nil, err
+		}
+		dlog.Infof(c, "Using %s %s.%s, the owner of ReplicaSet %s", owner.Kind, obj.GetName(), obj.GetNamespace(), rs.Name)
+		return obj, nil
+	}
+	return rs, nil
+}
+
+// podNotInterceptable returns an error that explains why the given pod can't be intercepted.
+func podNotInterceptable(pod *kates.Pod) error {
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		switch owner.Kind {
+		case "Deployment", "ReplicaSet", "StatefulSet", RolloutKind:
+			return errcat.User.Newf("pod %s.%s can't be intercepted by name; intercept %s %s, the workload that controls it",
+				pod.Name, pod.Namespace, owner.Kind, owner.Name)
+		default:
+			return errcat.User.Newf("pod %s.%s is controlled by %s %s, and telepresence can only intercept "+
+				"Deployments, ReplicaSets, StatefulSets, and Argo Rollouts", pod.Name, pod.Namespace, owner.Kind, owner.Name)
+		}
+	}
+	return errcat.User.Newf("pod %s.%s is a bare pod that isn't controlled by a workload, so it can't be intercepted. "+
+		"Telepresence adds its traffic-agent to the pod template of a workload, and the containers of a running pod "+
+		"can't be changed. Create a Deployment with the pod's spec as its template instead", pod.Name, pod.Namespace)
 }
 
 // FindSvc finds a service with the given name in the given Namespace and returns
@@ -266,6 +385,17 @@ func (kc *Cluster) FindSvc(c context.Context, namespace, name string) (*kates.Se
 	return rs, nil
 }
 
+// ServiceEndpointSlices returns the EndpointSlices of the service with the given name in the given
+// Namespace.
+func (kc *Cluster) ServiceEndpointSlices(c context.Context, namespace, name string) ([]*discoveryv1.EndpointSlice, error) {
+	var slices []*discoveryv1.EndpointSlice
+	q := kates.Query{Kind: "EndpointSlice", Namespace: namespace, LabelSelector: discoveryv1.LabelServiceName + "=" + name}
+	if err := kc.client.List(c, q, &slices); err != nil {
+		return nil, err
+	}
+	return slices, nil
+}
+
 // findAllSvc finds services with the given service type in all namespaces of the cluster returns
 // a slice containing a copy of those services.
 func (kc *Cluster) findAllSvcByType(c context.Context, svcType corev1.ServiceType) ([]*kates.Service, error) {
@@ -298,16 +428,24 @@ func (kc *Cluster) namespaceExists(namespace string) (exists bool) {
 }
 
 func NewCluster(c context.Context, kubeFlags *Config, mappedNamespaces []string, callbacks Callbacks) (*Cluster, error) {
+	if err := kubeFlags.resolveExecCredentials(c); err != nil {
+		return nil, err
+	}
 	// TODO: Add constructor to kates that takes an additional restConfig argument to prevent that kates recreates it.
 	kc, err := kates.NewClientFromConfigFlags(kubeFlags.ConfigFlags)
 	if err != nil {
 		return nil, client.CheckTimeout(c, fmt.Errorf("k8s client create failed: %w", err))
 	}
+	dc, err := newDynamicClient(kubeFlags)
+	if err != nil {
+		return nil, fmt.Errorf("k8s dynamic client create failed: %w", err)
+	}
 
 	ret := &Cluster{
 		Config:           kubeFlags,
 		mappedNamespaces: mappedNamespaces,
 		client:           kc,
+		dynamic:          dc,
 		callbacks:        callbacks,
 		LocalIntercepts:  map[string]string{},
 		accWait:          make(chan struct{}),
@@ -337,6 +475,12 @@ func (kc *Cluster) GetClusterId(ctx context.Context) string {
 	return clusterID
 }
 
+// GetTelemetryClusterId returns the anonymized cluster ID that is used in telemetry reports.
+func (kc *Cluster) GetTelemetryClusterId(ctx context.Context) string {
+	clusterID, _ := actions.GetTelemetryClusterID(ctx, kc.client)
+	return clusterID
+}
+
 func (kc *Cluster) Client() *kates.Client {
 	return kc.client
 }
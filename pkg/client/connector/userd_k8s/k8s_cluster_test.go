@@ -0,0 +1,106 @@
+package userd_k8s
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	k8err "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// fakeGet returns a function that finds the given objects by kind, name, and namespace.
+func fakeGet(objs ...kates.Object) func(context.Context, kates.Object) error {
+	return func(_ context.Context, obj kates.Object) error {
+		kind := obj.GetObjectKind().GroupVersionKind().Kind
+		for _, o := range objs {
+			if o.GetObjectKind().GroupVersionKind().Kind == kind && o.GetName() == obj.GetName() && o.GetNamespace() == obj.GetNamespace() {
+				reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(o).Elem())
+				return nil
+			}
+		}
+		return k8err.NewNotFound(corev1.Resource(kind), obj.GetName())
+	}
+}
+
+func objectMeta(name string, owners ...metav1.OwnerReference) kates.ObjectMeta {
+	return kates.ObjectMeta{Name: name, Namespace: "default", OwnerReferences: owners}
+}
+
+func controller(kind, name string) metav1.OwnerReference {
+	isController := true
+	return metav1.OwnerReference{Kind: kind, Name: name, Controller: &isController}
+}
+
+func TestFindWorkload(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	get := fakeGet(
+		&kates.Deployment{TypeMeta: kates.TypeMeta{Kind: "Deployment"}, ObjectMeta: objectMeta("echo")},
+		&kates.ReplicaSet{TypeMeta: kates.TypeMeta{Kind: "ReplicaSet"}, ObjectMeta: objectMeta("echo-697464c6c5", controller("Deployment", "echo"))},
+		&kates.ReplicaSet{TypeMeta: kates.TypeMeta{Kind: "ReplicaSet"}, ObjectMeta: objectMeta("legacy-rs")},
+		&kates.ReplicaSet{TypeMeta: kates.TypeMeta{Kind: "ReplicaSet"}, ObjectMeta: objectMeta("deleted-697464c6c5", controller("Deployment", "deleted"))},
+		&kates.StatefulSet{TypeMeta: kates.TypeMeta{Kind: "StatefulSet"}, ObjectMeta: objectMeta("db")},
+		&kates.Pod{TypeMeta: kates.TypeMeta{Kind: "Pod"}, ObjectMeta: objectMeta("bare")},
+		&kates.Pod{TypeMeta: kates.TypeMeta{Kind: "Pod"}, ObjectMeta: objectMeta("echo-697464c6c5-x2vqp", controller("ReplicaSet", "echo-697464c6c5"))},
+		&kates.Pod{TypeMeta: kates.TypeMeta{Kind: "Pod"}, ObjectMeta: objectMeta("logs-b7kq2", controller("DaemonSet", "logs"))},
+	)
+
+	t.Run("owned ReplicaSet", func(t *testing.T) {
+		// The Deployment is preferred over the ReplicaSet that it owns
+		obj, err := findWorkload(ctx, "default", "echo-697464c6c5", get)
+		require.NoError(t, err)
+		assert.IsType(t, &kates.Deployment{}, obj)
+		assert.Equal(t, "echo", obj.GetName())
+	})
+
+	t.Run("orphan ReplicaSet", func(t *testing.T) {
+		obj, err := findWorkload(ctx, "default", "legacy-rs", get)
+		require.NoError(t, err)
+		assert.IsType(t, &kates.ReplicaSet{}, obj)
+		assert.Equal(t, "legacy-rs", obj.GetName())
+
+		// A ReplicaSet whose Deployment is gone is used as is
+		obj, err = findWorkload(ctx, "default", "deleted-697464c6c5", get)
+		require.NoError(t, err)
+		assert.IsType(t, &kates.ReplicaSet{}, obj)
+	})
+
+	t.Run("StatefulSet", func(t *testing.T) {
+		obj, err := findWorkload(ctx, "default", "db", get)
+		require.NoError(t, err)
+		assert.IsType(t, &kates.StatefulSet{}, obj)
+	})
+
+	t.Run("bare pod", func(t *testing.T) {
+		_, err := findWorkload(ctx, "default", "bare", get)
+		require.Error(t, err)
+		assert.False(t, k8err.IsNotFound(err))
+		assert.Equal(t, errcat.User, errcat.GetCategory(err))
+		assert.Contains(t, err.Error(), "pod bare.default is a bare pod that isn't controlled by a workload")
+		assert.Contains(t, err.Error(), "Create a Deployment")
+	})
+
+	t.Run("controlled pod", func(t *testing.T) {
+		_, err := findWorkload(ctx, "default", "echo-697464c6c5-x2vqp", get)
+		require.Error(t, err)
+		assert.Equal(t, errcat.User, errcat.GetCategory(err))
+		assert.Contains(t, err.Error(), "intercept ReplicaSet echo-697464c6c5")
+
+		_, err = findWorkload(ctx, "default", "logs-b7kq2", get)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "is controlled by DaemonSet logs")
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := findWorkload(ctx, "default", "missing", get)
+		require.Error(t, err)
+		assert.True(t, k8err.IsNotFound(err))
+	})
+}
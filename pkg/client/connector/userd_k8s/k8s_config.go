@@ -9,11 +9,15 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 
 	"github.com/datawire/ambassador/v2/pkg/kates"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/dnet"
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/buffer"
 )
 
 // The dnsConfig is part of the kubeconfigExtension struct
@@ -48,9 +52,14 @@ type managerConfig struct {
 
 // kubeconfigExtension is an extension read from the selected kubeconfig Cluster.
 type kubeconfigExtension struct {
-	DNS       *dnsConfig       `json:"dns,omitempty"`
-	AlsoProxy []*iputil.Subnet `json:"also-proxy,omitempty"`
-	Manager   *managerConfig   `json:"manager,omitempty"`
+	DNS        *dnsConfig       `json:"dns,omitempty"`
+	AlsoProxy  []*iputil.Subnet `json:"also-proxy,omitempty"`
+	NeverProxy []*iputil.Subnet `json:"never-proxy,omitempty"`
+	Manager    *managerConfig   `json:"manager,omitempty"`
+
+	// MTU is the MTU of the TUN device. Zero means that the root daemon's default is used,
+	// limited by the MTU of the interface of the default route.
+	MTU int `json:"mtu,omitempty"`
 }
 
 type Config struct {
@@ -62,6 +71,9 @@ type Config struct {
 	flagArgs    []string
 	ConfigFlags *kates.ConfigFlags
 	config      *rest.Config
+
+	// execCredentials is set when the kubeconfig user obtains credentials from an exec plugin
+	execCredentials *execCredentials
 }
 
 const configExtension = "telepresence.io"
@@ -88,29 +100,16 @@ func NewConfig(c context.Context, flagMap map[string]string) (*Config, error) {
 		return nil, err
 	}
 
-	if len(config.Contexts) == 0 {
-		return nil, errcat.Config.New("kubeconfig has no context definition")
-	}
-
-	ctxName := flagMap["context"]
-	if ctxName == "" {
-		ctxName = config.CurrentContext
-	}
-
-	ctx, ok := config.Contexts[ctxName]
-	if !ok {
-		return nil, errcat.Config.Newf("context %q does not exist in the kubeconfig", ctxName)
-	}
-
-	cluster, ok := config.Clusters[ctx.Cluster]
-	if !ok {
-		return nil, errcat.Config.Newf("the cluster %q declared in context %q does exists in the kubeconfig", ctx.Cluster, ctxName)
+	ctxName, ctx, cluster, err := selectedCluster(&config, flagMap)
+	if err != nil {
+		return nil, err
 	}
 
 	restConfig, err := configLoader.ClientConfig()
 	if err != nil {
 		return nil, err
 	}
+	dnet.WithProxy(restConfig)
 
 	namespace := ctx.Namespace
 	if namespace == "" {
@@ -128,18 +127,25 @@ func NewConfig(c context.Context, flagMap map[string]string) (*Config, error) {
 		flagArgs:    flagArgs,
 		ConfigFlags: configFlags,
 		config:      restConfig,
+
+		execCredentials: newExecCredentials(restConfig),
 	}
+	configFlags.WrapConfigFn = k.wrapConfig
 
-	if ext, ok := cluster.Extensions[configExtension].(*runtime.Unknown); ok {
-		if err = json.Unmarshal(ext.Raw, &k.kubeconfigExtension); err != nil {
-			return nil, errcat.Config.Newf("unable to parse extension %s in kubeconfig: %w", configExtension, err)
-		}
+	if _, err = parseExtension(cluster, &k.kubeconfigExtension); err != nil {
+		return nil, err
 	}
 
 	if k.kubeconfigExtension.Manager == nil {
 		k.kubeconfigExtension.Manager = &managerConfig{}
 	}
 
+	// The manager namespace of the kubeconfig extension takes precedence over the one of the client
+	// config and its overrides for the context, which in turn defaults to the TELEPRESENCE_MANAGER_NAMESPACE
+	// environment variable.
+	if cfg := client.GetConfig(c); cfg != nil && k.kubeconfigExtension.Manager.Namespace == "" {
+		k.kubeconfigExtension.Manager.Namespace = cfg.ForContext(ctxName).Manager.Namespace
+	}
 	if k.kubeconfigExtension.Manager.Namespace == "" {
 		k.kubeconfigExtension.Manager.Namespace = client.GetEnv(c).ManagerNamespace
 	}
@@ -147,6 +153,149 @@ func NewConfig(c context.Context, flagMap map[string]string) (*Config, error) {
 	return k, nil
 }
 
+// selectedCluster returns the name of the context that the given flags select, the context, and its cluster.
+func selectedCluster(config *clientcmdapi.Config, flagMap map[string]string) (string, *clientcmdapi.Context, *clientcmdapi.Cluster, error) {
+	if len(config.Contexts) == 0 {
+		return "", nil, nil, errcat.Config.New("kubeconfig has no context definition")
+	}
+
+	ctxName := flagMap["context"]
+	if ctxName == "" {
+		ctxName = config.CurrentContext
+	}
+
+	ctx, ok := config.Contexts[ctxName]
+	if !ok {
+		return "", nil, nil, errcat.Config.Newf("context %q does not exist in the kubeconfig", ctxName)
+	}
+
+	cluster, ok := config.Clusters[ctx.Cluster]
+	if !ok {
+		return "", nil, nil, errcat.Config.Newf("the cluster %q declared in context %q does exists in the kubeconfig", ctx.Cluster, ctxName)
+	}
+	return ctxName, ctx, cluster, nil
+}
+
+// parseExtension parses the telepresence.io extension of the given cluster into ext, and returns false if
+// the cluster has no such extension.
+func parseExtension(cluster *clientcmdapi.Cluster, ext *kubeconfigExtension) (bool, error) {
+	raw, ok := cluster.Extensions[configExtension].(*runtime.Unknown)
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(raw.Raw, ext); err != nil {
+		return false, errcat.Config.Newf("unable to parse extension %s in kubeconfig: %w", configExtension, err)
+	}
+	if mtu := ext.MTU; mtu != 0 && (mtu < vif.MinMTU || mtu > buffer.DataPool.MTU) {
+		return false, errcat.Config.Newf("the mtu %d of extension %s in kubeconfig must be between %d and %d",
+			mtu, configExtension, vif.MinMTU, buffer.DataPool.MTU)
+	}
+	return true, nil
+}
+
+// ExtensionConfigLayer returns the name of the kube context that the given flags select, and the layer of
+// the client config that the telepresence.io extension of its cluster contributes, or nil when the cluster
+// has no such extension. The manager namespace and the DNS lookup timeout of the extension take precedence
+// over the client config, and its DNS suffixes and subnets are added to those of the client config.
+func ExtensionConfigLayer(flagMap map[string]string) (string, *client.ConfigLayer, error) {
+	configFlags := kates.NewConfigFlags(false)
+	flags := pflag.NewFlagSet("", 0)
+	configFlags.AddFlags(flags)
+	for k, v := range flagMap {
+		if err := flags.Set(k, v); err != nil {
+			return "", nil, errcat.User.Newf("error processing kubectl flag --%s=%s: %w", k, v, err)
+		}
+	}
+	config, err := configFlags.ToRawKubeConfigLoader().RawConfig()
+	if err != nil {
+		return "", nil, err
+	}
+	ctxName, ctx, cluster, err := selectedCluster(&config, flagMap)
+	if err != nil {
+		return "", nil, err
+	}
+	var ext kubeconfigExtension
+	if ok, err := parseExtension(cluster, &ext); !ok || err != nil {
+		return ctxName, nil, err
+	}
+
+	cfg := &client.Config{AlsoProxy: ext.AlsoProxy, NeverProxy: ext.NeverProxy}
+	if dns := ext.DNS; dns != nil {
+		cfg.DNS.IncludeSuffixes = dns.IncludeSuffixes
+		cfg.DNS.ExcludeSuffixes = dns.ExcludeSuffixes
+		cfg.DNS.LookupTimeout = dns.LookupTimeout.Duration
+	}
+	if ext.Manager != nil {
+		cfg.Manager.Namespace = ext.Manager.Namespace
+	}
+	return ctxName, &client.ConfigLayer{
+		Source: "kubeconfig extension of cluster " + ctx.Cluster,
+		Config: cfg,
+		Extend: true,
+	}, nil
+}
+
+// AddDNSSuffixes adds the given suffixes to those declared in the kubeconfig extension.
+func (kf *Config) AddDNSSuffixes(include, exclude []string) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return
+	}
+	if kf.DNS == nil {
+		kf.DNS = &dnsConfig{}
+	}
+	kf.DNS.IncludeSuffixes = append(kf.DNS.IncludeSuffixes, include...)
+	kf.DNS.ExcludeSuffixes = append(kf.DNS.ExcludeSuffixes, exclude...)
+}
+
+// AddAlsoProxy adds the given subnets to the also-proxy subnets declared in the kubeconfig extension.
+func (kf *Config) AddAlsoProxy(subnets []*iputil.Subnet) {
+	kf.AlsoProxy = append(kf.AlsoProxy, subnets...)
+}
+
+// AddNeverProxy adds the given subnets to the never-proxy subnets declared in the kubeconfig extension.
+func (kf *Config) AddNeverProxy(subnets []*iputil.Subnet) {
+	kf.NeverProxy = append(kf.NeverProxy, subnets...)
+}
+
+// SetManagerNamespace sets the namespace of the traffic-manager, overriding the one of the kubeconfig
+// extension and the client config.
+func (kf *Config) SetManagerNamespace(namespace string) {
+	kf.Manager = &managerConfig{Namespace: namespace}
+}
+
+// wrapConfig is applied to all rest configs that are created from the ConfigFlags.
+func (kf *Config) wrapConfig(config *rest.Config) *rest.Config {
+	return kf.execCredentials.wrapConfig(dnet.WithProxy(config))
+}
+
+// resolveExecCredentials runs the exec credential plugin, if any, and makes the clients that are
+// created from this config use the tokens that it provides.
+func (kf *Config) resolveExecCredentials(c context.Context) error {
+	if kf.execCredentials == nil {
+		return nil
+	}
+	if err := kf.execCredentials.resolve(c); err != nil {
+		return err
+	}
+	kf.execCredentials.wrapConfig(kf.config)
+	return nil
+}
+
+// InheritExecCredentials is used when this config replaces the current config of a session. It
+// refreshes the credentials of the exec credential plugin that the clients of the session use, so
+// that a new connect recovers from expired credentials, and makes this config share them.
+func (kf *Config) InheritExecCredentials(c context.Context, current *Config) error {
+	if current.execCredentials == nil {
+		return nil
+	}
+	if err := current.execCredentials.refresh(c); err != nil {
+		return err
+	}
+	kf.execCredentials = current.execCredentials
+	kf.execCredentials.wrapConfig(kf.config)
+	return nil
+}
+
 // ContextServiceAndFlagsEqual determines if this instance is equal to the given instance with respect to context,
 // server, and flag arguments.
 func (kf *Config) ContextServiceAndFlagsEqual(okf *Config) bool {
@@ -0,0 +1,252 @@
+package userd_k8s
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+// The providers of local clusters, i.e. of clusters whose nodes run in containers on a docker network.
+const (
+	ProviderKind     = "kind"
+	ProviderK3d      = "k3d"
+	ProviderMinikube = "minikube"
+)
+
+// dockerBridgeRanges are the private ranges that docker allocates the subnets of its bridge networks from.
+var dockerBridgeRanges = []*net.IPNet{
+	{IP: net.IP{172, 16, 0, 0}, Mask: net.CIDRMask(12, 32)},
+	{IP: net.IP{192, 168, 0, 0}, Mask: net.CIDRMask(16, 32)},
+}
+
+// LocalCluster is the outcome of the detection of a local cluster.
+type LocalCluster struct {
+	// Provider is "kind", "k3d", or "minikube", or empty when the cluster isn't a local one.
+	Provider string
+
+	// Gateway is the IP of the node that the cluster subnets are routed via. It's nil when the cluster
+	// subnets are routed through the traffic-manager.
+	Gateway net.IP
+
+	// NodeRoutes route the pod subnet of each node via the node.
+	NodeRoutes []*daemon.GatewayRoute
+
+	// Reason tells why a local cluster is routed through the traffic-manager.
+	Reason string
+}
+
+// UsesDockerNetwork returns true when the cluster subnets are routed via the docker network of the nodes.
+func (lc *LocalCluster) UsesDockerNetwork() bool {
+	return lc != nil && lc.Gateway != nil
+}
+
+// DockerNetworkRoutes returns the routes that the root daemon adds for the cluster subnets, or nil when
+// they're routed through the traffic-manager.
+func (lc *LocalCluster) DockerNetworkRoutes() *daemon.DockerNetworkRoutes {
+	if !lc.UsesDockerNetwork() {
+		return nil
+	}
+	return &daemon.DockerNetworkRoutes{Gateway: lc.Gateway, NodeRoutes: lc.NodeRoutes}
+}
+
+// DetectLocalCluster detects if the cluster runs in containers on a docker network of the host, such as
+// a kind, k3d, or minikube cluster using the docker driver. The cluster subnets of such a cluster are
+// routed via its nodes, unless forceTunnel is true, or the detection is inconclusive.
+func (kc *Cluster) DetectLocalCluster(c context.Context, forceTunnel bool) {
+	lc := &LocalCluster{}
+	var nodes []*corev1.Node
+	if err := kc.client.List(c, kates.Query{Kind: "Node"}, &nodes); err != nil {
+		dlog.Infof(c, "Unable to list the nodes, so a local cluster can't be detected: %v", err)
+	} else {
+		apiServer := &corev1.Endpoints{
+			TypeMeta:   kates.TypeMeta{Kind: "Endpoints"},
+			ObjectMeta: kates.ObjectMeta{Name: "kubernetes", Namespace: "default"},
+		}
+		if err = kc.client.Get(c, apiServer, apiServer); err != nil {
+			dlog.Infof(c, "Unable to get the endpoints of the API server: %v", err)
+			apiServer = nil
+		}
+		lc = detectLocalCluster(nodes, apiServer, dockerBridges(c))
+	}
+	if lc.Provider != "" {
+		var reason string
+		switch {
+		case forceTunnel:
+			reason = "the tunnel is forced using --force-tunnel"
+		case client.GetConfig(c).OutboundTrafficPolicy == client.OutboundTrafficPolicyMappedNamespaces:
+			reason = "the outbound traffic policy is " + client.OutboundTrafficPolicyMappedNamespaces
+		}
+		if reason != "" && lc.Reason == "" {
+			lc = &LocalCluster{Provider: lc.Provider, Reason: reason}
+		}
+		if lc.UsesDockerNetwork() {
+			dlog.Infof(c, "Detected a %s cluster, routing its subnets via the docker network, using gateway %s", lc.Provider, lc.Gateway)
+		} else {
+			dlog.Infof(c, "Detected a %s cluster, routing its subnets through the traffic-manager because %s", lc.Provider, lc.Reason)
+		}
+	}
+	kc.localCluster = lc
+}
+
+// LocalCluster returns the outcome of DetectLocalCluster, or nil when it hasn't been called.
+func (kc *Cluster) LocalCluster() *LocalCluster {
+	return kc.localCluster
+}
+
+// detectLocalCluster detects if the given nodes, and the given endpoints of the API server, belong to a
+// local cluster whose nodes are on one of the given docker bridge subnets of the host. The docker network is
+// only used when all nodes agree. A cluster that looks local in one respect, but not in another, is
+// routed through the traffic-manager, and the returned Reason tells why.
+func detectLocalCluster(nodes []*corev1.Node, apiServer *corev1.Endpoints, bridges []*net.IPNet) *LocalCluster {
+	lc := &LocalCluster{}
+	for _, node := range nodes {
+		if p := nodeProvider(node); p != "" {
+			lc.Provider = p
+			break
+		}
+	}
+	if lc.Provider == "" {
+		return lc
+	}
+
+	var nodeRoutes []*daemon.GatewayRoute
+	nodeIPs := make(map[string]struct{}, len(nodes))
+	for _, node := range nodes {
+		if p := nodeProvider(node); p != lc.Provider {
+			lc.Reason = fmt.Sprintf("node %s is not a %s node", node.Name, lc.Provider)
+			return lc
+		}
+		ip := nodeInternalIP(node)
+		switch {
+		case ip == nil:
+			lc.Reason = fmt.Sprintf("node %s has no internal IP", node.Name)
+		case !containedIn(dockerBridgeRanges, ip):
+			lc.Reason = fmt.Sprintf("node %s has IP %s, which is outside the docker bridge ranges", node.Name, ip)
+		case !containedIn(bridges, ip):
+			lc.Reason = fmt.Sprintf("node %s has IP %s, which is not on a docker network of this host", node.Name, ip)
+		}
+		if lc.Reason != "" {
+			return lc
+		}
+		nodeIPs[ip.String()] = struct{}{}
+		for _, cidr := range nodePodCIDRs(node) {
+			if _, sn, err := net.ParseCIDR(cidr); err == nil && (sn.IP.To4() != nil) == (ip.To4() != nil) {
+				nodeRoutes = append(nodeRoutes, &daemon.GatewayRoute{Subnet: iputil.IPNetToRPC(sn), Gateway: ip})
+			}
+		}
+	}
+
+	// The API server must run on one of the nodes. If it doesn't, then the nodes aren't what they seem.
+	var gateway net.IP
+	if apiServer != nil {
+		for _, ss := range apiServer.Subsets {
+			for _, addr := range ss.Addresses {
+				if _, ok := nodeIPs[addr.IP]; !ok {
+					lc.Reason = fmt.Sprintf("the API server endpoint %s is not one of the nodes", addr.IP)
+					return lc
+				}
+				if gateway == nil {
+					gateway = net.ParseIP(addr.IP)
+				}
+			}
+		}
+	}
+	if gateway == nil {
+		lc.Reason = "the API server has no endpoints"
+		return lc
+	}
+	if ip4 := gateway.To4(); ip4 != nil {
+		gateway = ip4
+	}
+	lc.Gateway = gateway
+	lc.NodeRoutes = nodeRoutes
+	return lc
+}
+
+// nodeProvider returns the provider of the local cluster that the given node belongs to, or an empty string
+// when it doesn't belong to a local cluster.
+func nodeProvider(node *corev1.Node) string {
+	pid := node.Spec.ProviderID
+	switch {
+	case strings.HasPrefix(pid, "kind://"):
+		return ProviderKind
+	case strings.HasPrefix(pid, "k3s://") && strings.HasPrefix(node.Name, "k3d-"):
+		// A k3s cluster that isn't run by k3d is typically installed on real machines
+		return ProviderK3d
+	case node.Labels["minikube.k8s.io/name"] != "":
+		return ProviderMinikube
+	}
+	return ""
+}
+
+// nodeInternalIP returns the internal IP of the given node, or nil when it has none.
+func nodeInternalIP(node *corev1.Node) net.IP {
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == corev1.NodeInternalIP {
+			if ip := net.ParseIP(addr.Address); ip != nil {
+				if ip4 := ip.To4(); ip4 != nil {
+					return ip4
+				}
+				return ip
+			}
+		}
+	}
+	return nil
+}
+
+// nodePodCIDRs returns the pod subnets of the given node.
+func nodePodCIDRs(node *corev1.Node) []string {
+	if len(node.Spec.PodCIDRs) > 0 {
+		return node.Spec.PodCIDRs
+	}
+	if node.Spec.PodCIDR != "" {
+		return []string{node.Spec.PodCIDR}
+	}
+	return nil
+}
+
+func containedIn(subnets []*net.IPNet, ip net.IP) bool {
+	for _, sn := range subnets {
+		if sn.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// dockerBridges returns the subnets of the docker bridge networks of the host, i.e. of its interfaces named
+// docker0, or br-<network id>. There are none when docker runs in a VM, like Docker Desktop does on macOS
+// and Windows, or when the connector itself runs in a container.
+func dockerBridges(c context.Context) []*net.IPNet {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		dlog.Errorf(c, "unable to list the network interfaces: %v", err)
+		return nil
+	}
+	var bridges []*net.IPNet
+	for i := range ifaces {
+		iface := &ifaces[i]
+		if iface.Name != "docker0" && !strings.HasPrefix(iface.Name, "br-") {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				bridges = append(bridges, ipNet)
+			}
+		}
+	}
+	return bridges
+}
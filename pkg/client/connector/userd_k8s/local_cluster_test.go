@@ -0,0 +1,184 @@
+package userd_k8s
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+// loadLocalClusterFixture returns the nodes and the endpoints of the API server of the cluster in the given
+// file of testdata/localCluster.
+func loadLocalClusterFixture(t *testing.T, name string) ([]*corev1.Node, *corev1.Endpoints) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "localCluster", name+".yaml"))
+	require.NoError(t, err)
+	var fixture struct {
+		Nodes     []*corev1.Node    `json:"nodes"`
+		Endpoints *corev1.Endpoints `json:"endpoints"`
+	}
+	require.NoError(t, yaml.Unmarshal(data, &fixture))
+	return fixture.Nodes, fixture.Endpoints
+}
+
+func Test_detectLocalCluster(t *testing.T) {
+	cidr := func(s string) *net.IPNet {
+		ip, sn, err := net.ParseCIDR(s)
+		require.NoError(t, err)
+		sn.IP = ip
+		return sn
+	}
+	// The bridges of the default docker network, and of the networks that kind, k3d, and minikube create
+	hostBridges := []*net.IPNet{
+		cidr("172.17.0.1/16"),
+		cidr("172.18.0.1/16"),
+		cidr("172.19.0.1/16"),
+		cidr("192.168.49.1/24"),
+	}
+	tests := []struct {
+		name     string
+		fixture  string
+		modify   func(nodes []*corev1.Node, apiServer *corev1.Endpoints) *corev1.Endpoints
+		bridges  []*net.IPNet
+		provider string
+		gateway  string
+		routes   []string
+		reason   string
+	}{
+		{
+			name:     "kind",
+			fixture:  "kind",
+			bridges:  hostBridges,
+			provider: ProviderKind,
+			gateway:  "172.18.0.2",
+			routes:   []string{"10.244.0.0/24 via 172.18.0.2", "10.244.1.0/24 via 172.18.0.3"},
+		},
+		{
+			name:     "k3d",
+			fixture:  "k3d",
+			bridges:  hostBridges,
+			provider: ProviderK3d,
+			gateway:  "172.19.0.3",
+			routes:   []string{"10.42.0.0/24 via 172.19.0.3", "10.42.1.0/24 via 172.19.0.4"},
+		},
+		{
+			name:     "minikube-docker",
+			fixture:  "minikube-docker",
+			bridges:  hostBridges,
+			provider: ProviderMinikube,
+			gateway:  "192.168.49.2",
+			routes:   []string{"10.244.0.0/24 via 192.168.49.2"},
+		},
+		{
+			name:    "gke",
+			fixture: "gke",
+			bridges: hostBridges,
+		},
+		{
+			// Docker Desktop runs the containers in a VM, so the host has no bridge that reaches them
+			name:     "kind in a VM",
+			fixture:  "kind",
+			provider: ProviderKind,
+			reason:   "node kind-control-plane has IP 172.18.0.2, which is not on a docker network of this host",
+		},
+		{
+			// The VM drivers of minikube use addresses that may be in the docker bridge ranges
+			name:    "minikube-hyperkit",
+			fixture: "minikube-docker",
+			modify: func(nodes []*corev1.Node, apiServer *corev1.Endpoints) *corev1.Endpoints {
+				nodes[0].Status.Addresses[0].Address = "192.168.64.2"
+				apiServer.Subsets[0].Addresses[0].IP = "192.168.64.2"
+				return apiServer
+			},
+			bridges:  hostBridges,
+			provider: ProviderMinikube,
+			reason:   "node minikube has IP 192.168.64.2, which is not on a docker network of this host",
+		},
+		{
+			name:    "minikube with a public IP",
+			fixture: "minikube-docker",
+			modify: func(nodes []*corev1.Node, apiServer *corev1.Endpoints) *corev1.Endpoints {
+				nodes[0].Status.Addresses[0].Address = "35.192.10.20"
+				return apiServer
+			},
+			bridges:  append(hostBridges, cidr("35.192.10.1/24")),
+			provider: ProviderMinikube,
+			reason:   "node minikube has IP 35.192.10.20, which is outside the docker bridge ranges",
+		},
+		{
+			name:    "k3s on real machines",
+			fixture: "k3d",
+			modify: func(nodes []*corev1.Node, apiServer *corev1.Endpoints) *corev1.Endpoints {
+				for _, node := range nodes {
+					node.Name = node.Name[len("k3d-"):]
+				}
+				return apiServer
+			},
+			bridges: hostBridges,
+		},
+		{
+			name:    "mixed providers",
+			fixture: "kind",
+			modify: func(nodes []*corev1.Node, apiServer *corev1.Endpoints) *corev1.Endpoints {
+				nodes[1].Spec.ProviderID = "gce://acme-prod/us-central1-a/kind-worker"
+				return apiServer
+			},
+			bridges:  hostBridges,
+			provider: ProviderKind,
+			reason:   "node kind-worker is not a kind node",
+		},
+		{
+			name:    "API server elsewhere",
+			fixture: "kind",
+			modify: func(nodes []*corev1.Node, apiServer *corev1.Endpoints) *corev1.Endpoints {
+				apiServer.Subsets[0].Addresses[0].IP = "172.18.0.9"
+				return apiServer
+			},
+			bridges:  hostBridges,
+			provider: ProviderKind,
+			reason:   "the API server endpoint 172.18.0.9 is not one of the nodes",
+		},
+		{
+			name:    "API server unknown",
+			fixture: "kind",
+			modify: func(nodes []*corev1.Node, apiServer *corev1.Endpoints) *corev1.Endpoints {
+				return nil
+			},
+			bridges:  hostBridges,
+			provider: ProviderKind,
+			reason:   "the API server has no endpoints",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			nodes, apiServer := loadLocalClusterFixture(t, tt.fixture)
+			if tt.modify != nil {
+				apiServer = tt.modify(nodes, apiServer)
+			}
+			lc := detectLocalCluster(nodes, apiServer, tt.bridges)
+			assert.Equal(t, tt.provider, lc.Provider)
+			assert.Equal(t, tt.reason, lc.Reason)
+			if tt.gateway == "" {
+				assert.False(t, lc.UsesDockerNetwork())
+				assert.Nil(t, lc.DockerNetworkRoutes())
+				return
+			}
+			require.True(t, lc.UsesDockerNetwork())
+			dn := lc.DockerNetworkRoutes()
+			assert.Equal(t, tt.gateway, net.IP(dn.Gateway).String())
+			routes := make([]string, len(dn.NodeRoutes))
+			for i, r := range dn.NodeRoutes {
+				routes[i] = iputil.IPNetFromRPC(r.Subnet).String() + " via " + net.IP(r.Gateway).String()
+			}
+			assert.Equal(t, tt.routes, routes)
+		})
+	}
+}
@@ -21,7 +21,7 @@ import (
 // daemon.
 //
 // The filtered list of namespaces is also used for creating a DNS search path which is propagated to
-// the DNS-resolver in the daemon.
+// the DNS-resolver in the daemon, together with the headless services in those namespaces.
 //
 // When an update arrives in the namespace watcher, it will refresh the DNS-search path and the current
 // set of watchers so that new watchers are added for added namespaces and watchers for namespaces that
@@ -59,6 +59,13 @@ func (kc *Cluster) RunWatchers(c context.Context) (err error) {
 			}
 		}
 	})
+	g.Go("headless-services", kc.watchHeadlessServices)
+	if kc.callbacks.SetServices != nil || kc.callbacks.SetRoutedIPs != nil {
+		g.Go("services", kc.watchServices)
+	}
+	if kc.callbacks.SetRoutedIPs != nil {
+		g.Go("service-endpoint-slices", kc.watchServiceEndpointSlices)
+	}
 	return g.Wait()
 }
 
@@ -82,6 +89,58 @@ func (kc *Cluster) SetMappedNamespaces(c context.Context, namespaces []string) {
 	kc.refreshNamespaces(c, nil)
 }
 
+// GetMappedNamespaces returns a copy of the namespaces that are currently mapped. An empty
+// list means that all namespaces are mapped.
+func (kc *Cluster) GetMappedNamespaces() []string {
+	kc.accLock.Lock()
+	defer kc.accLock.Unlock()
+	return append([]string(nil), kc.mappedNamespaces...)
+}
+
+// SetManagedNamespaces limits the mapped namespaces to the given namespaces, which are the namespaces that
+// a traffic-manager with namespace-scoped RBAC manages. An empty list means that all namespaces are managed.
+// The given slice is neither retained nor modified.
+func (kc *Cluster) SetManagedNamespaces(c context.Context, namespaces []string) {
+	namespaces = append([]string(nil), namespaces...)
+	sort.Strings(namespaces)
+	kc.accLock.Lock()
+	kc.managedNamespaces = namespaces
+	for _, ns := range kc.mappedNamespaces {
+		if !kc.isManagedLocked(ns) {
+			dlog.Warnf(c, "namespace %s will not be mapped because it is not managed by the traffic-manager", ns)
+		}
+	}
+	kc.accLock.Unlock()
+	kc.refreshNamespaces(c, nil)
+}
+
+// GetManagedNamespaces returns a copy of the namespaces that the traffic-manager is limited to. An
+// empty list means that the traffic-manager manages all namespaces.
+func (kc *Cluster) GetManagedNamespaces() []string {
+	kc.accLock.Lock()
+	defer kc.accLock.Unlock()
+	return append([]string(nil), kc.managedNamespaces...)
+}
+
+// IsManaged returns true if the given namespace is managed by the traffic-manager.
+func (kc *Cluster) IsManaged(namespace string) bool {
+	kc.accLock.Lock()
+	defer kc.accLock.Unlock()
+	return kc.isManagedLocked(namespace)
+}
+
+func (kc *Cluster) isManagedLocked(namespace string) bool {
+	if len(kc.managedNamespaces) == 0 {
+		return true
+	}
+	for _, n := range kc.managedNamespaces {
+		if n == namespace {
+			return true
+		}
+	}
+	return false
+}
+
 func (kc *Cluster) refreshNamespaces(c context.Context, accWait chan<- struct{}) bool {
 	kc.accLock.Lock()
 	namespaces := make([]string, 0, len(kc.curSnapshot.Namespaces))
@@ -108,14 +167,25 @@ func (kc *Cluster) refreshNamespaces(c context.Context, accWait chan<- struct{})
 
 	if nsChange {
 		kc.updateDaemonNamespaces(c)
+		kc.updateDaemonHeadlessServices(c)
+		kc.updateServices(c)
+		kc.updateDaemonRoutedIPs(c)
 	}
 	return nsChange
 }
 
 func (kc *Cluster) shouldBeWatched(namespace string) bool {
 	// The "kube-system" namespace must be mapped when hijacking the IP of the
-	// kube-dns service in the daemon.
-	if len(kc.mappedNamespaces) == 0 || namespace == "kube-system" {
+	// kube-dns service in the daemon. Other namespaces are only mapped when
+	// they're managed by the traffic-manager. The session namespace is then
+	// always mapped so that the workloads in it remain reachable.
+	if namespace == "kube-system" {
+		return true
+	}
+	if !kc.isManagedLocked(namespace) {
+		return false
+	}
+	if len(kc.mappedNamespaces) == 0 || namespace == kc.Namespace {
 		return true
 	}
 	for _, n := range kc.mappedNamespaces {
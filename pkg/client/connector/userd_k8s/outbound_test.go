@@ -0,0 +1,65 @@
+package userd_k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCluster_shouldBeWatched(t *testing.T) {
+	tests := []struct {
+		name     string
+		mapped   []string
+		managed  []string
+		expected []string
+	}{
+		{
+			name:     "all",
+			expected: []string{"default", "blue", "green", "red", "kube-system"},
+		},
+		{
+			name:     "mapped",
+			mapped:   []string{"blue"},
+			expected: []string{"default", "blue", "kube-system"},
+		},
+		{
+			name:     "managed",
+			managed:  []string{"blue", "red"},
+			expected: []string{"blue", "red", "kube-system"},
+		},
+		{
+			name:     "mapped and managed",
+			mapped:   []string{"blue", "green"},
+			managed:  []string{"default", "red", "green"},
+			expected: []string{"default", "green", "kube-system"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			kc := &Cluster{
+				Config:            &Config{Namespace: "default"},
+				mappedNamespaces:  tt.mapped,
+				managedNamespaces: tt.managed,
+			}
+			var watched []string
+			for _, ns := range []string{"default", "blue", "green", "red", "kube-system"} {
+				if kc.shouldBeWatched(ns) {
+					watched = append(watched, ns)
+				}
+			}
+			assert.ElementsMatch(t, tt.expected, watched)
+		})
+	}
+}
+
+func TestCluster_GetMappedNamespaces(t *testing.T) {
+	kc := &Cluster{mappedNamespaces: []string{"blue", "red"}}
+	mapped := kc.GetMappedNamespaces()
+	assert.Equal(t, []string{"blue", "red"}, mapped)
+
+	// The returned slice is a copy, so changing it doesn't change the mapped namespaces
+	mapped[0] = "green"
+	assert.Equal(t, []string{"blue", "red"}, kc.GetMappedNamespaces())
+	assert.Empty(t, (&Cluster{}).GetMappedNamespaces())
+}
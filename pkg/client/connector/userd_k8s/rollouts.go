@@ -0,0 +1,200 @@
+package userd_k8s
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	k8err "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// RolloutKind is the kind of an Argo Rollout. Rollouts are custom resources, so they are represented
+// by *kates.Unstructured objects.
+const RolloutKind = "Rollout"
+
+// RolloutsHashLabel is the label that Argo Rollouts adds to the ReplicaSets of a Rollout and their pods.
+const RolloutsHashLabel = "rollouts-pod-template-hash"
+
+var (
+	RolloutGVR    = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts"}
+	replicaSetGVR = appsv1.SchemeGroupVersion.WithResource("replicasets")
+)
+
+// IsRollout returns true if the given workload is an Argo Rollout.
+func IsRollout(obj kates.Object) bool {
+	_, ok := obj.(*kates.Unstructured)
+	return ok && obj.GetObjectKind().GroupVersionKind().Kind == RolloutKind
+}
+
+func newRollout(namespace, name string) *kates.Unstructured {
+	ro := &kates.Unstructured{}
+	ro.SetAPIVersion(RolloutGVR.GroupVersion().String())
+	ro.SetKind(RolloutKind)
+	ro.SetName(name)
+	ro.SetNamespace(namespace)
+	return ro
+}
+
+func newDynamicClient(kubeFlags *Config) (dynamic.Interface, error) {
+	return dynamic.NewForConfig(kubeFlags.config)
+}
+
+// Rollouts returns all Argo Rollouts found in the given Namespace. An empty slice is returned when the
+// Rollout CRD isn't installed in the cluster.
+func (kc *Cluster) Rollouts(c context.Context, namespace string) ([]kates.Object, error) {
+	list, err := kc.dynamic.Resource(RolloutGVR).Namespace(namespace).List(c, metav1.ListOptions{})
+	if err != nil {
+		if k8err.IsNotFound(err) {
+			// The CRD isn't installed, so there can't be any rollouts
+			return nil, nil
+		}
+		return nil, err
+	}
+	objs := make([]kates.Object, len(list.Items))
+	for i := range list.Items {
+		objs[i] = &list.Items[i]
+	}
+	return objs, nil
+}
+
+// getRollout fills the given Rollout with the one in the cluster that has the same name and namespace. A
+// NotFound error is returned when no such Rollout exists, and also when the Rollout CRD isn't installed.
+func (kc *Cluster) getRollout(c context.Context, ro *kates.Unstructured) error {
+	found, err := kc.dynamic.Resource(RolloutGVR).Namespace(ro.GetNamespace()).Get(c, ro.GetName(), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	ro.Object = found.Object
+	return nil
+}
+
+// WorkloadPodTemplate returns the pod template of the given workload. The template of a Rollout is
+// the template of its stable ReplicaSet.
+func (kc *Cluster) WorkloadPodTemplate(c context.Context, obj kates.Object) (*kates.PodTemplateSpec, error) {
+	if IsRollout(obj) {
+		return kc.RolloutPodTemplate(c, obj.(*kates.Unstructured))
+	}
+	return install.GetPodTemplateFromObject(obj)
+}
+
+// RolloutPodTemplate returns the pod template of the stable ReplicaSet of the given Rollout. A canary
+// strategy may keep two ReplicaSets active, and an intercept is based on the stable one because that's
+// the one that the stable service routes to. The Rollout's own template is returned when it has no
+// stable ReplicaSet yet.
+func (kc *Cluster) RolloutPodTemplate(c context.Context, ro *kates.Unstructured) (*kates.PodTemplateSpec, error) {
+	if hash := rolloutStableHash(ro); hash != "" {
+		rss, err := kc.dynamic.Resource(replicaSetGVR).Namespace(ro.GetNamespace()).List(c, metav1.ListOptions{
+			LabelSelector: RolloutsHashLabel + "=" + hash,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for i := range rss.Items {
+			if !metav1.IsControlledBy(&rss.Items[i], ro) {
+				continue
+			}
+			var rs kates.ReplicaSet
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(rss.Items[i].Object, &rs); err != nil {
+				return nil, install.ObjErrorf(ro, "unable to parse ReplicaSet %s: %w", rs.Name, err)
+			}
+			return &rs.Spec.Template, nil
+		}
+	}
+	return rolloutTemplate(ro)
+}
+
+// rolloutStableHash returns the pod template hash of the stable ReplicaSet of the given Rollout, or an
+// empty string when it has none.
+func rolloutStableHash(ro *kates.Unstructured) string {
+	if hash, _, _ := unstructured.NestedString(ro.Object, "status", "stableRS"); hash != "" {
+		return hash
+	}
+	// Older versions of Argo Rollouts only record the active ReplicaSet of a blue-green strategy
+	hash, _, _ := unstructured.NestedString(ro.Object, "status", "blueGreen", "activeSelector")
+	return hash
+}
+
+// rolloutTemplate returns the pod template in the spec of the given Rollout.
+func rolloutTemplate(ro *kates.Unstructured) (*kates.PodTemplateSpec, error) {
+	tm, ok, err := unstructured.NestedMap(ro.Object, "spec", "template")
+	if err != nil {
+		return nil, install.ObjErrorf(ro, "unable to parse spec.template: %w", err)
+	}
+	if !ok {
+		if _, ok, _ = unstructured.NestedMap(ro.Object, "spec", "workloadRef"); ok {
+			return nil, install.ObjErrorf(ro, "a Rollout that references its pod template using spec.workloadRef can't be intercepted")
+		}
+		return nil, install.ObjErrorf(ro, "spec.template is not set")
+	}
+	var tpl kates.PodTemplateSpec
+	if err = runtime.DefaultUnstructuredConverter.FromUnstructured(tm, &tpl); err != nil {
+		return nil, install.ObjErrorf(ro, "unable to parse spec.template: %w", err)
+	}
+	return &tpl, nil
+}
+
+// RolloutReplicas returns the number of pods of the given Rollout.
+func RolloutReplicas(ro *kates.Unstructured) int64 {
+	replicas, _, _ := unstructured.NestedInt64(ro.Object, "status", "replicas")
+	return replicas
+}
+
+// IsCanaryRollout returns true if the given Rollout uses a canary strategy.
+func IsCanaryRollout(ro *kates.Unstructured) bool {
+	_, ok, _ := unstructured.NestedMap(ro.Object, "spec", "strategy", "canary")
+	return ok
+}
+
+// RolloutInjectionEnabled returns true if the pod template in the spec of the given Rollout has the annotation
+// that makes the traffic-manager's mutating webhook inject the traffic-agent.
+func RolloutInjectionEnabled(ro *kates.Unstructured) bool {
+	a, _, _ := unstructured.NestedStringMap(ro.Object, "spec", "template", "metadata", "annotations")
+	return a[install.InjectAnnotation] == "enabled"
+}
+
+// RolloutInjectionPatch is the merge patch that EnableRolloutInjection applies to a Rollout.
+var RolloutInjectionPatch = fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{%q:"enabled"}}}}}`, install.InjectAnnotation)
+
+// RolloutRestartPatch returns the merge patch that RestartRollout applies to a Rollout at the given time.
+func RolloutRestartPatch(t time.Time) string {
+	return fmt.Sprintf(`{"spec":{"restartAt":%q}}`, t.UTC().Format(time.RFC3339))
+}
+
+// EnableRolloutInjection adds the annotation that makes the traffic-manager's mutating webhook inject the
+// traffic-agent to the pod template of the given Rollout. The Rollout then rolls out a new revision with
+// the traffic-agent according to its strategy.
+func (kc *Cluster) EnableRolloutInjection(c context.Context, ro *kates.Unstructured) error {
+	return kc.patchRollout(c, ro, RolloutInjectionPatch)
+}
+
+// DisableRolloutInjection removes the annotation that makes the traffic-manager's mutating webhook inject the
+// traffic-agent from the pod template of the given Rollout. The Rollout then rolls out a new revision without
+// the traffic-agent according to its strategy.
+func (kc *Cluster) DisableRolloutInjection(c context.Context, ro *kates.Unstructured) error {
+	return kc.patchRollout(c, ro, InjectionRemovalPatch)
+}
+
+// RestartRollout restarts the pods of the given Rollout the same way as "kubectl argo rollouts restart" does.
+func (kc *Cluster) RestartRollout(c context.Context, ro *kates.Unstructured) error {
+	return kc.patchRollout(c, ro, RolloutRestartPatch(time.Now()))
+}
+
+func (kc *Cluster) patchRollout(c context.Context, ro *kates.Unstructured, patch string) error {
+	patched, err := kc.dynamic.Resource(RolloutGVR).Namespace(ro.GetNamespace()).Patch(
+		c, ro.GetName(), types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	if err != nil {
+		return err
+	}
+	ro.Object = patched.Object
+	return nil
+}
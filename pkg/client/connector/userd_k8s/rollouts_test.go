@@ -0,0 +1,241 @@
+package userd_k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	k8err "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+func podTemplate(image, hash string) map[string]interface{} {
+	labels := map[string]interface{}{"app": "web"}
+	if hash != "" {
+		labels[RolloutsHashLabel] = hash
+	}
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": labels},
+		"spec": map[string]interface{}{
+			"containers": []interface{}{map[string]interface{}{"name": "web", "image": image}},
+		},
+	}
+}
+
+func rolloutFixture(name, uid string, spec, status map[string]interface{}) *unstructured.Unstructured {
+	ro := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       RolloutKind,
+		"metadata":   map[string]interface{}{"name": name, "namespace": "default", "uid": uid},
+		"spec":       spec,
+	}}
+	if status != nil {
+		ro.Object["status"] = status
+	}
+	return ro
+}
+
+func replicaSetFixture(name, hash, ownerName, ownerUID, image string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "ReplicaSet",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "default",
+			"labels":    map[string]interface{}{"app": "web", RolloutsHashLabel: hash},
+			"ownerReferences": []interface{}{map[string]interface{}{
+				"apiVersion": "argoproj.io/v1alpha1",
+				"kind":       RolloutKind,
+				"name":       ownerName,
+				"uid":        ownerUID,
+				"controller": true,
+			}},
+		},
+		"spec": map[string]interface{}{"template": podTemplate(image, hash)},
+	}}
+}
+
+func fakeRolloutCluster(objs ...runtime.Object) (*Cluster, *dynamicfake.FakeDynamicClient) {
+	dc := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		RolloutGVR:    "RolloutList",
+		replicaSetGVR: "ReplicaSetList",
+	}, objs...)
+	return &Cluster{dynamic: dc}, dc
+}
+
+func rolloutFixtures() []runtime.Object {
+	return []runtime.Object{
+		// A canary that is in progress, so both the stable and the canary ReplicaSet are active
+		rolloutFixture("web", "web-uid", map[string]interface{}{
+			"replicas": int64(3),
+			"strategy": map[string]interface{}{"canary": map[string]interface{}{
+				"steps": []interface{}{map[string]interface{}{"setWeight": int64(20)}, map[string]interface{}{"pause": map[string]interface{}{}}},
+			}},
+			"template": podTemplate("web:2.0", ""),
+		}, map[string]interface{}{"replicas": int64(4), "stableRS": "5b4f8c7d9", "currentPodHash": "6c9d7f8b4"}),
+		replicaSetFixture("web-5b4f8c7d9", "5b4f8c7d9", "web", "web-uid", "web:1.0"),
+		replicaSetFixture("web-6c9d7f8b4", "6c9d7f8b4", "web", "web-uid", "web:2.0"),
+
+		// A blue-green that only records its active ReplicaSet, with a preview that awaits promotion
+		rolloutFixture("api", "api-uid", map[string]interface{}{
+			"replicas": int64(2),
+			"strategy": map[string]interface{}{"blueGreen": map[string]interface{}{
+				"activeService":  "api",
+				"previewService": "api-preview",
+			}},
+			"template": podTemplate("api:2.0", ""),
+		}, map[string]interface{}{"replicas": int64(4), "blueGreen": map[string]interface{}{"activeSelector": "7d8f9c6b5"}}),
+		replicaSetFixture("api-7d8f9c6b5", "7d8f9c6b5", "api", "api-uid", "api:1.0"),
+		replicaSetFixture("api-8e9a0d7c6", "8e9a0d7c6", "api", "api-uid", "api:2.0"),
+
+		// A Rollout that has yet to create its first ReplicaSet
+		rolloutFixture("new", "new-uid", map[string]interface{}{"template": podTemplate("new:1.0", "")}, nil),
+
+		rolloutFixture("ref", "ref-uid", map[string]interface{}{
+			"workloadRef": map[string]interface{}{"apiVersion": "apps/v1", "kind": "Deployment", "name": "ref"},
+		}, nil),
+	}
+}
+
+func TestRollouts(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	kc, _ := fakeRolloutCluster(rolloutFixtures()...)
+
+	ros, err := kc.Rollouts(ctx, "default")
+	require.NoError(t, err)
+	names := make([]string, len(ros))
+	for i, ro := range ros {
+		assert.True(t, IsRollout(ro))
+		names[i] = ro.GetName()
+	}
+	assert.ElementsMatch(t, []string{"web", "api", "new", "ref"}, names)
+
+	assert.False(t, IsRollout(&kates.Deployment{TypeMeta: kates.TypeMeta{Kind: RolloutKind}}))
+}
+
+func TestRollouts_crdNotInstalled(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	kc, dc := fakeRolloutCluster()
+	dc.PrependReactor("*", "rollouts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, k8err.NewNotFound(RolloutGVR.GroupResource(), "")
+	})
+
+	ros, err := kc.Rollouts(ctx, "default")
+	require.NoError(t, err)
+	assert.Empty(t, ros)
+
+	err = kc.getRollout(ctx, newRollout("default", "web"))
+	assert.True(t, k8err.IsNotFound(err))
+}
+
+func TestRolloutPodTemplate(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	kc, _ := fakeRolloutCluster(rolloutFixtures()...)
+	image := func(name string) string {
+		ro := newRollout("default", name)
+		require.NoError(t, kc.getRollout(ctx, ro))
+		tpl, err := kc.WorkloadPodTemplate(ctx, ro)
+		require.NoError(t, err)
+		return tpl.Spec.Containers[0].Image
+	}
+
+	t.Run("canary", func(t *testing.T) {
+		// The stable template is used while the canary is in progress
+		assert.Equal(t, "web:1.0", image("web"))
+	})
+
+	t.Run("blue-green", func(t *testing.T) {
+		// The active template is used until the preview is promoted
+		assert.Equal(t, "api:1.0", image("api"))
+	})
+
+	t.Run("no stable ReplicaSet", func(t *testing.T) {
+		assert.Equal(t, "new:1.0", image("new"))
+	})
+
+	t.Run("workloadRef", func(t *testing.T) {
+		ro := newRollout("default", "ref")
+		require.NoError(t, kc.getRollout(ctx, ro))
+		_, err := kc.RolloutPodTemplate(ctx, ro)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "spec.workloadRef")
+	})
+}
+
+func TestRolloutInjection(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	kc, dc := fakeRolloutCluster(rolloutFixtures()...)
+
+	web := newRollout("default", "web")
+	require.NoError(t, kc.getRollout(ctx, web))
+	assert.True(t, IsCanaryRollout(web))
+	assert.Equal(t, int64(4), RolloutReplicas(web))
+	assert.False(t, RolloutInjectionEnabled(web))
+
+	require.NoError(t, kc.EnableRolloutInjection(ctx, web))
+	assert.True(t, RolloutInjectionEnabled(web))
+
+	// The patch only adds the annotation
+	found, err := dc.Resource(RolloutGVR).Namespace("default").Get(ctx, "web", metav1.GetOptions{})
+	require.NoError(t, err)
+	annotations, _, _ := unstructured.NestedStringMap(found.Object, "spec", "template", "metadata", "annotations")
+	assert.Equal(t, map[string]string{install.InjectAnnotation: "enabled"}, annotations)
+	containers, _, _ := unstructured.NestedSlice(found.Object, "spec", "template", "spec", "containers")
+	assert.Len(t, containers, 1)
+
+	// Disabling the injection removes the annotation and nothing else
+	require.NoError(t, kc.DisableRolloutInjection(ctx, web))
+	assert.False(t, RolloutInjectionEnabled(web))
+	found, err = dc.Resource(RolloutGVR).Namespace("default").Get(ctx, "web", metav1.GetOptions{})
+	require.NoError(t, err)
+	annotations, _, _ = unstructured.NestedStringMap(found.Object, "spec", "template", "metadata", "annotations")
+	assert.Empty(t, annotations)
+	containers, _, _ = unstructured.NestedSlice(found.Object, "spec", "template", "spec", "containers")
+	assert.Len(t, containers, 1)
+
+	api := newRollout("default", "api")
+	require.NoError(t, kc.getRollout(ctx, api))
+	assert.False(t, IsCanaryRollout(api))
+	require.NoError(t, kc.RestartRollout(ctx, api))
+	restartAt, _, _ := unstructured.NestedString(api.Object, "spec", "restartAt")
+	assert.NotEmpty(t, restartAt)
+
+	var patchTypes []types.PatchType
+	for _, action := range dc.Actions() {
+		if pa, ok := action.(k8stesting.PatchAction); ok {
+			patchTypes = append(patchTypes, pa.GetPatchType())
+		}
+	}
+	assert.Equal(t, []types.PatchType{types.MergePatchType, types.MergePatchType, types.MergePatchType}, patchTypes)
+}
+
+func TestFindWorkload_rollout(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	var web kates.Unstructured
+	web.Object = rolloutFixture("web", "web-uid", map[string]interface{}{"template": podTemplate("web:1.0", "")}, nil).Object
+	var rs kates.ReplicaSet
+	require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(
+		replicaSetFixture("web-5b4f8c7d9", "5b4f8c7d9", "web", "web-uid", "web:1.0").Object, &rs))
+	rs.Kind = "ReplicaSet"
+	get := fakeGet(&web, &rs)
+
+	obj, err := findWorkload(ctx, "default", "web", get)
+	require.NoError(t, err)
+	assert.True(t, IsRollout(obj))
+
+	// The Rollout is preferred over the ReplicaSet that it owns
+	obj, err = findWorkload(ctx, "default", "web-5b4f8c7d9", get)
+	require.NoError(t, err)
+	assert.True(t, IsRollout(obj))
+	assert.Equal(t, "web", obj.GetName())
+}
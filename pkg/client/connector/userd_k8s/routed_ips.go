@@ -0,0 +1,123 @@
+package userd_k8s
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/datawire/dlib/derror"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+// watchServiceEndpointSlices watches the EndpointSlices of all services and sends the IPs of the
+// services, and of their pods, in the mapped namespaces to the daemon each time they change. It's only
+// used when the outbound traffic policy is "mappedNamespaces", in which case the daemon routes those IPs
+// rather than the cluster subnets.
+//
+// A cluster that doesn't allow EndpointSlices to be listed just means that only the cluster IPs of the
+// services are routed, so this function doesn't return an error.
+func (kc *Cluster) watchServiceEndpointSlices(c context.Context) (err error) {
+	defer func() {
+		if r := derror.PanicToError(recover()); r != nil {
+			dlog.Errorf(c, "unable to watch the EndpointSlices of services: %v", r)
+		}
+	}()
+
+	acc := kc.client.Watch(c,
+		kates.Query{
+			Name: "ServiceEndpointSlices",
+			Kind: "EndpointSlice",
+		})
+	var snapshot struct {
+		ServiceEndpointSlices []*discoveryv1.EndpointSlice
+	}
+	for {
+		select {
+		case <-c.Done():
+			return nil
+		case <-acc.Changed():
+			kc.accLock.Lock()
+			changed := acc.Update(&snapshot)
+			if changed {
+				kc.serviceEndpointSlices = snapshot.ServiceEndpointSlices
+			}
+			kc.accLock.Unlock()
+			if changed {
+				kc.updateDaemonRoutedIPs(c)
+			}
+		}
+	}
+}
+
+// updateDaemonRoutedIPs sends the IPs of the services, and of their pods, in the mapped namespaces to
+// the daemon, unless they're the same as the ones that were sent last.
+func (kc *Cluster) updateDaemonRoutedIPs(c context.Context) {
+	if kc.callbacks.SetRoutedIPs == nil {
+		return
+	}
+	kc.accLock.Lock()
+	ips := routedIPs(kc.services, kc.serviceEndpointSlices, kc.lastNamespaces)
+	ipsStr := ips.String()
+	unchanged := kc.routedIPsSent && ipsStr == kc.lastRoutedIPs
+	kc.lastRoutedIPs = ipsStr
+	kc.routedIPsSent = true
+	kc.accLock.Unlock()
+	if unchanged {
+		return
+	}
+
+	dlog.Debugf(c, "posting %d routed IPs", len(ips))
+	if _, err := kc.callbacks.SetRoutedIPs(c, &daemon.RoutedIPs{Ips: ips.BytesSlice()}); err != nil {
+		dlog.Errorf(c, "error posting routed IPs to root daemon: %v", err)
+	}
+}
+
+// routedIPs returns the cluster IPs of the given services, and the IPs of the endpoints in the given
+// EndpointSlices, that are in one of the given namespaces. The endpoints are included whether they're
+// ready or not, so that a pod that fails its readiness probe can still be reached. The IPs are unique
+// and sorted.
+func routedIPs(services []*kates.Service, slices []*discoveryv1.EndpointSlice, namespaces []string) iputil.IPs {
+	mapped := make(map[string]struct{}, len(namespaces))
+	for _, ns := range namespaces {
+		mapped[ns] = struct{}{}
+	}
+	var ips iputil.IPs
+	add := func(s string) {
+		if ip := iputil.Parse(s); ip != nil {
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			ips = append(ips, ip)
+		}
+	}
+	for _, svc := range services {
+		if _, ok := mapped[svc.Namespace]; !ok || svc.Spec.Type == corev1.ServiceTypeExternalName {
+			continue
+		}
+		clusterIPs := svc.Spec.ClusterIPs
+		if len(clusterIPs) == 0 {
+			// Cluster that predates dual-stack support
+			clusterIPs = []string{svc.Spec.ClusterIP}
+		}
+		for _, clusterIP := range clusterIPs {
+			if clusterIP != corev1.ClusterIPNone {
+				add(clusterIP)
+			}
+		}
+	}
+	for _, slice := range slices {
+		if _, ok := mapped[slice.Namespace]; !ok || slice.Labels[discoveryv1.LabelServiceName] == "" {
+			continue
+		}
+		for _, ep := range slice.Endpoints {
+			for _, addr := range ep.Addresses {
+				add(addr)
+			}
+		}
+	}
+	return ips.UniqueSorted()
+}
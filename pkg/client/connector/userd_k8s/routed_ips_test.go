@@ -0,0 +1,84 @@
+package userd_k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+func TestRoutedIPs(t *testing.T) {
+	svc := func(namespace, name string, spec corev1.ServiceSpec) *kates.Service {
+		return &kates.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}, Spec: spec}
+	}
+	services := []*kates.Service{
+		svc("default", "web", corev1.ServiceSpec{ClusterIP: "10.96.0.11"}),
+		svc("red", "echo", corev1.ServiceSpec{ClusterIP: "10.96.0.12"}),
+		svc("blue", "postgres", corev1.ServiceSpec{ClusterIP: corev1.ClusterIPNone, ClusterIPs: []string{corev1.ClusterIPNone}}),
+		svc("blue", "external", corev1.ServiceSpec{Type: corev1.ServiceTypeExternalName, ExternalName: "db.example.com"}),
+		svc("blue", "echo", corev1.ServiceSpec{ClusterIP: "10.96.0.10", ClusterIPs: []string{"10.96.0.10", "fd00:96::10"}}),
+	}
+	orphan := endpointSlice("blue", "", discoveryv1.AddressTypeIPv4, discoveryv1.Endpoint{Addresses: []string{"10.1.0.9"}})
+	delete(orphan.Labels, discoveryv1.LabelServiceName)
+	slices := []*discoveryv1.EndpointSlice{
+		endpointSlice("blue", "postgres", discoveryv1.AddressTypeIPv4,
+			discoveryv1.Endpoint{Addresses: []string{"10.1.0.5"}},
+			discoveryv1.Endpoint{Addresses: []string{"10.1.0.6"}, Conditions: discoveryv1.EndpointConditions{Ready: boolPtr(false)}},
+		),
+		endpointSlice("blue", "echo", discoveryv1.AddressTypeIPv4, discoveryv1.Endpoint{Addresses: []string{"10.1.1.2"}}),
+		endpointSlice("blue", "echo", discoveryv1.AddressTypeIPv6, discoveryv1.Endpoint{Addresses: []string{"fd00::1:2"}}),
+		endpointSlice("red", "echo", discoveryv1.AddressTypeIPv4, discoveryv1.Endpoint{Addresses: []string{"10.1.2.2"}}),
+		orphan,
+	}
+	assert.Equal(t,
+		"10.1.0.5,10.1.0.6,10.1.1.2,10.96.0.10,10.96.0.11,fd00::1:2,fd00:96::10",
+		routedIPs(services, slices, []string{"blue", "default"}).String())
+}
+
+func TestCluster_updateDaemonRoutedIPs(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	var posted []string
+	kc := &Cluster{
+		callbacks: Callbacks{
+			SetRoutedIPs: func(_ context.Context, in *daemon.RoutedIPs, _ ...grpc.CallOption) (*empty.Empty, error) {
+				posted = append(posted, iputil.IPsFromBytesSlice(in.Ips).String())
+				return &empty.Empty{}, nil
+			},
+		},
+		lastNamespaces: []string{"blue"},
+	}
+	svc := &kates.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "blue"},
+		Spec:       corev1.ServiceSpec{ClusterIP: "10.96.0.10"},
+	}
+
+	// The first update is posted even when there are no IPs
+	kc.updateDaemonRoutedIPs(ctx)
+
+	// A service is created, and then its pods
+	kc.services = []*kates.Service{svc}
+	kc.updateDaemonRoutedIPs(ctx)
+	kc.serviceEndpointSlices = []*discoveryv1.EndpointSlice{
+		endpointSlice("blue", "echo", discoveryv1.AddressTypeIPv4, discoveryv1.Endpoint{Addresses: []string{"10.1.1.2"}}),
+	}
+	kc.updateDaemonRoutedIPs(ctx)
+
+	// A change that doesn't change the IPs isn't posted
+	kc.updateDaemonRoutedIPs(ctx)
+
+	// A namespace that is no longer mapped removes its IPs
+	kc.lastNamespaces = []string{"default"}
+	kc.updateDaemonRoutedIPs(ctx)
+
+	assert.Equal(t, []string{"", "10.96.0.10", "10.1.1.2,10.96.0.10", ""}, posted)
+}
@@ -0,0 +1,88 @@
+package userd_k8s
+
+import (
+	"context"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/datawire/dlib/derror"
+	"github.com/datawire/dlib/dlog"
+)
+
+// watchServices watches the services in all namespaces and passes those in the mapped namespaces to
+// the SetServices and SetRoutedIPs callbacks each time they change.
+func (kc *Cluster) watchServices(c context.Context) (err error) {
+	defer func() {
+		if r := derror.PanicToError(recover()); r != nil {
+			err = r
+		}
+	}()
+
+	acc := kc.client.Watch(c,
+		kates.Query{
+			Name: "Services",
+			Kind: "Service",
+		})
+	var snapshot struct {
+		Services []*kates.Service
+	}
+	for {
+		select {
+		case <-c.Done():
+			return nil
+		case <-acc.Changed():
+			kc.accLock.Lock()
+			changed := acc.Update(&snapshot)
+			if changed {
+				kc.services = snapshot.Services
+			}
+			kc.accLock.Unlock()
+			if changed {
+				kc.updateServices(c)
+				kc.updateDaemonRoutedIPs(c)
+			}
+		}
+	}
+}
+
+// updateServices passes the services in the mapped namespaces to the SetServices callback.
+func (kc *Cluster) updateServices(c context.Context) {
+	if kc.callbacks.SetServices == nil {
+		return
+	}
+	kc.accLock.Lock()
+	services := mappedServices(kc.services, kc.lastNamespaces)
+	kc.accLock.Unlock()
+
+	dlog.Debugf(c, "posting %d services", len(services))
+	kc.callbacks.SetServices(c, services)
+}
+
+// mappedServices returns the services that are in one of the given namespaces and that have a
+// cluster IP, sorted by namespace and name. Headless services and services of type ExternalName
+// have no cluster IP.
+func mappedServices(services []*kates.Service, namespaces []string) []*kates.Service {
+	mapped := make(map[string]struct{}, len(namespaces))
+	for _, ns := range namespaces {
+		mapped[ns] = struct{}{}
+	}
+	var result []*kates.Service
+	for _, svc := range services {
+		if _, ok := mapped[svc.Namespace]; !ok {
+			continue
+		}
+		if svc.Spec.Type == corev1.ServiceTypeExternalName || svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == corev1.ClusterIPNone {
+			continue
+		}
+		result = append(result, svc)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Namespace != result[j].Namespace {
+			return result[i].Namespace < result[j].Namespace
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
@@ -0,0 +1,29 @@
+package userd_k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+)
+
+func TestMappedServices(t *testing.T) {
+	svc := func(namespace, name string, spec corev1.ServiceSpec) *kates.Service {
+		return &kates.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}, Spec: spec}
+	}
+	services := []*kates.Service{
+		svc("default", "web", corev1.ServiceSpec{ClusterIP: "10.96.0.11"}),
+		svc("red", "echo", corev1.ServiceSpec{ClusterIP: "10.96.0.12"}),
+		svc("blue", "postgres", corev1.ServiceSpec{ClusterIP: corev1.ClusterIPNone}),
+		svc("blue", "external", corev1.ServiceSpec{Type: corev1.ServiceTypeExternalName, ExternalName: "db.example.com"}),
+		svc("blue", "echo", corev1.ServiceSpec{ClusterIP: "10.96.0.10"}),
+	}
+	var names []string
+	for _, s := range mappedServices(services, []string{"blue", "default"}) {
+		names = append(names, s.Name+"."+s.Namespace)
+	}
+	assert.Equal(t, []string{"echo.blue", "web.default"}, names)
+}
@@ -7,15 +7,115 @@ import (
 	"io"
 	"time"
 
+	"github.com/blang/semver"
 	"google.golang.org/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
 
+	"github.com/datawire/ambassador/v2/pkg/kates"
 	"github.com/datawire/dlib/dlog"
 	"github.com/datawire/dlib/dtime"
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
 )
 
+// httpConditionsMinAgentVersion is the first version of the traffic-agent that is able to route HTTP requests
+// based on the HTTP conditions of an intercept.
+var httpConditionsMinAgentVersion = semver.MustParse("2.4.5")
+
+// tlsTerminationMinAgentVersion is the first version of the traffic-agent that is able to terminate the TLS
+// of intercepted connections using the agent_tls_secret of an intercept.
+var tlsTerminationMinAgentVersion = semver.MustParse("2.4.5")
+
+// extraPortsMinAgentVersion is the first version of the traffic-agent that is able to intercept the
+// extra_port_mappings of an intercept.
+var extraPortsMinAgentVersion = semver.MustParse("2.4.5")
+
+// checkAgentsSupportHTTPConditions returns an error unless all the given agents, i.e. the agents of all replicas
+// of the intercepted workload, are able to route HTTP requests based on the conditions of the intercept. An
+// agent that isn't able to do that would otherwise intercept everything.
+func checkAgentsSupportHTTPConditions(name, namespace string, agents []*manager.AgentInfo) error {
+	return checkAgentsMinVersion(name, namespace, agents, httpConditionsMinAgentVersion, "--http-header or --http-path-prefix")
+}
+
+// checkAgentsSupportTLSTermination returns an error unless all the given agents are able to terminate the TLS
+// of the intercepted connections. An agent that isn't able to do that would otherwise send the encrypted
+// traffic to the workstation.
+func checkAgentsSupportTLSTermination(name, namespace string, agents []*manager.AgentInfo) error {
+	return checkAgentsMinVersion(name, namespace, agents, tlsTerminationMinAgentVersion, "--agent-tls-terminate")
+}
+
+// checkAgentsSupportExtraPorts returns an error unless all the given agents are able to intercept more than
+// one port. An agent that isn't able to do that would otherwise leave the other ports without a listener.
+func checkAgentsSupportExtraPorts(name, namespace string, agents []*manager.AgentInfo) error {
+	return checkAgentsMinVersion(name, namespace, agents, extraPortsMinAgentVersion, "more than one --port")
+}
+
+// servicePortIdentifiers returns the service port identifiers of the given port mappings.
+func servicePortIdentifiers(pms []*manager.InterceptPortMapping) []string {
+	if len(pms) == 0 {
+		return nil
+	}
+	ids := make([]string, len(pms))
+	for i, pm := range pms {
+		ids[i] = pm.ServicePortIdentifier
+	}
+	return ids
+}
+
+// checkAgentsMinVersion returns an error unless all the given agents have the given version or later, which is
+// required to intercept using the given flags. No agents at all is an error too, because then there's nothing
+// that tells what version the agent that receives the intercepted traffic will have.
+func checkAgentsMinVersion(name, namespace string, agents []*manager.AgentInfo, minVersion semver.Version, flags string) error {
+	if len(agents) == 0 {
+		return errcat.User.Newf("no traffic-agent of %s.%s has arrived at the traffic-manager, so it's unknown if it "+
+			"has version %s or later, which is required to intercept using %s", name, namespace, minVersion, flags)
+	}
+	for _, agent := range agents {
+		v, err := semver.ParseTolerant(agent.Version)
+		if err == nil {
+			// Pre-releases of the minimum version are OK
+			v.Pre = nil
+			if v.GE(minVersion) {
+				continue
+			}
+		}
+		return errcat.User.Newf("the traffic-agent of %s.%s has version %q, but version %s or later is required to intercept "+
+			"using %s. Use \"telepresence uninstall --agent %s\" to remove the old agent "+
+			"so that it's replaced with a new one on the next intercept",
+			agent.Name, agent.Namespace, agent.Version, minVersion, flags, agent.Name)
+	}
+	return nil
+}
+
+// getAgents returns copies of all agents in the current agent snapshot that have the given name and namespace
+func (tm *trafficManager) getAgents(name, namespace string) []*manager.AgentInfo {
+	tm.currentAgentsLock.Lock()
+	var agents []*manager.AgentInfo
+	for _, ai := range tm.currentAgents {
+		if ai.Name == name && ai.Namespace == namespace {
+			agents = append(agents, proto.Clone(ai).(*manager.AgentInfo))
+		}
+	}
+	tm.currentAgentsLock.Unlock()
+	return agents
+}
+
+// getAgentsForVersionCheck returns the agents with the given name and namespace in the current agent snapshot.
+// The snapshot will not contain the agent when it hasn't arrived yet, e.g. because it was installed with
+// --no-wait or because the first snapshot hasn't been received, so this function will then wait for it.
+func (tm *trafficManager) getAgentsForVersionCheck(ctx context.Context, name, namespace string) ([]*manager.AgentInfo, error) {
+	if agents := tm.getAgents(name, namespace); len(agents) > 0 {
+		return agents, nil
+	}
+	if _, err := tm.waitForAgent(ctx, name, namespace); err != nil {
+		return nil, err
+	}
+	return tm.getAgents(name, namespace), nil
+}
+
 // getCurrentAgents returns a copy of the current agent snapshot
 func (tm *trafficManager) getCurrentAgents() []*manager.AgentInfo {
 	// Copy the current snapshot
@@ -56,7 +156,8 @@ func (tm *trafficManager) agentInfoWatcher(ctx context.Context) error {
 	backoff := 100 * time.Millisecond
 	for ctx.Err() == nil {
 		<-tm.startup
-		stream, err := tm.managerClient.WatchAgents(ctx, tm.session())
+		session := tm.session()
+		stream, err := tm.managerClient.WatchAgents(ctx, session)
 		if err != nil {
 			err = fmt.Errorf("manager.WatchAgents dial: %w", err)
 		}
@@ -65,6 +166,9 @@ func (tm *trafficManager) agentInfoWatcher(ctx context.Context) error {
 			if err != nil {
 				if ctx.Err() == nil && !errors.Is(err, io.EOF) {
 					dlog.Errorf(ctx, "manager.WatchAgents recv: %v", err)
+					if isUnknownSession(err) {
+						tm.sessionUnknown(ctx, session.SessionId)
+					}
 				}
 				tm.setCurrentAgents(nil)
 				break
@@ -93,25 +197,57 @@ func (tm *trafficManager) agentInfoWatcher(ctx context.Context) error {
 	return nil
 }
 
-func (tm *trafficManager) addAgent(c context.Context, namespace, agentName, svcName, svcPortIdentifier, agentImageName string) *rpc.InterceptResult {
-	svcUID, kind, err := tm.ensureAgent(c, namespace, agentName, svcName, svcPortIdentifier, agentImageName)
-	if err != nil {
-		if err == agentNotFound {
-			return &rpc.InterceptResult{
-				Error:     rpc.InterceptError_NOT_FOUND,
-				ErrorText: agentName,
-			}
-		}
-		dlog.Error(c, err)
+// agentError returns the result that explains why the traffic-agent of the given workload can't be
+// installed.
+func agentError(agentName string, err error) *rpc.InterceptResult {
+	if err == agentNotFound {
 		return &rpc.InterceptResult{
-			Error:     rpc.InterceptError_FAILED_TO_ESTABLISH,
-			ErrorText: err.Error(),
+			Error:     rpc.InterceptError_NOT_FOUND,
+			ErrorText: agentName,
 		}
 	}
+	return &rpc.InterceptResult{
+		Error:         rpc.InterceptError_FAILED_TO_ESTABLISH,
+		ErrorText:     err.Error(),
+		ErrorCategory: int32(errcat.GetCategory(err)),
+	}
+}
+
+// addAgent ensures that the given workload has a traffic-agent that also intercepts the service ports of the
+// given extraPorts, and sets the ContainerPort of each of them. Unless wait is false, it then waits for the
+// workload to have a ready pod with a traffic-agent that has arrived at the traffic-manager.
+func (tm *trafficManager) addAgent(
+	c context.Context,
+	namespace, agentName, svcName, svcPortIdentifier, containerName, agentImageName string,
+	extraPorts []*manager.InterceptPortMapping,
+	wait bool,
+) *rpc.InterceptResult {
+	p, err := tm.ensureAgent(c, namespace, agentName, svcName, svcPortIdentifier, containerName, agentImageName,
+		servicePortIdentifiers(extraPorts), tm.agentPullSecrets, tm.agentConfig)
+	if err != nil {
+		dlog.Error(c, err)
+		return agentError(agentName, err)
+	}
+	for i, pm := range extraPorts {
+		pm.ContainerPort = p.extraContainerPorts[i]
+	}
+	kind := p.kind
+	result := &rpc.InterceptResult{
+		Error:         rpc.InterceptError_UNSPECIFIED,
+		ServiceUid:    string(p.svc.GetUID()),
+		WorkloadKind:  kind,
+		ContainerName: p.container,
+		ContainerPort: p.containerPort,
+	}
+	if !wait {
+		dlog.Infof(c, "Not waiting for agent for %s %s.%s", kind, agentName, namespace)
+		return result
+	}
 
 	dlog.Infof(c, "Waiting for agent for %s %s.%s", kind, agentName, namespace)
+	dw := install.WatchDiagnostics(c, tm.Client(), kind, namespace, agentName)
 	agent, err := tm.waitForAgent(c, agentName, namespace)
-	if err != nil {
+	if err = dw.Stop(c, err); err != nil {
 		dlog.Error(c, err)
 		return &rpc.InterceptResult{
 			Error:     rpc.InterceptError_FAILED_TO_ESTABLISH,
@@ -119,34 +255,141 @@ func (tm *trafficManager) addAgent(c context.Context, namespace, agentName, svcN
 		}
 	}
 	dlog.Infof(c, "Agent found or created for %s %s.%s", kind, agentName, namespace)
-	return &rpc.InterceptResult{
-		Error:        rpc.InterceptError_UNSPECIFIED,
-		Environment:  agent.Environment,
-		ServiceUid:   svcUID,
-		WorkloadKind: kind,
-	}
+	result.Environment = agent.Environment
+	return result
 }
 
+// waitForAgent waits for the workload with the given name to have a ready pod with a traffic-agent that has
+// arrived at the traffic-manager. The pods of a workload that is mid-rollout come and go, so an agent that
+// has arrived isn't good enough until its pod is ready. How far the rollout has come is reported to the user
+// of the CLI while waiting.
 func (tm *trafficManager) waitForAgent(ctx context.Context, name, namespace string) (*manager.AgentInfo, error) {
 	fullName := name + "." + namespace
-	waitCh := make(chan *manager.AgentInfo)
-	tm.agentWaiters.Store(fullName, waitCh)
-	defer tm.agentWaiters.Delete(fullName)
+	ctx, cancel := client.GetConfig(ctx).Timeouts.TimeoutContext(ctx, client.TimeoutAgentInstall) // installing a new agent can take some time
+	defer cancel()
 
-	// Agent may already exist.
-	for _, agent := range tm.getCurrentAgentsInNamespace(namespace) {
-		if agent.Name == name {
+	reported := ""
+	check := func() (*manager.AgentInfo, error) {
+		agents := tm.getAgents(name, namespace)
+		pods, err := tm.Pods(ctx, namespace)
+		if err != nil {
+			dlog.Debugf(ctx, "unable to check the pods of agent %s: %v", fullName, err)
+			if len(agents) > 0 {
+				// Trust the traffic-manager
+				return agents[0], nil
+			}
+			return nil, nil
+		}
+		if agent := readyAgent(agents, pods); agent != nil {
 			return agent, nil
 		}
+		// An agent with an image that can't be pulled will never arrive, so there's no point in waiting for the timeout
+		if err = agentImagePullError(pods, name); err != nil {
+			return nil, err
+		}
+		if progress := agentRolloutProgress(pods, name); progress != reported {
+			reported = progress
+			tm.notifyProgress(fmt.Sprintf("Waiting for a ready traffic-agent in %s: %s", fullName, progress))
+		}
+		return nil, nil
 	}
 
-	ctx, cancel := client.GetConfig(ctx).Timeouts.TimeoutContext(ctx, client.TimeoutAgentInstall) // installing a new agent can take some time
-	defer cancel()
+	ticker := time.NewTicker(agentImagePullCheckInterval)
+	defer ticker.Stop()
+	for {
+		// The watcher never blocks on the buffered channel, even when this function has returned
+		waitCh := make(chan *manager.AgentInfo, 1)
+		tm.agentWaiters.Store(fullName, waitCh)
+		agent, err := check()
+		if err != nil || agent != nil {
+			tm.agentWaiters.Delete(fullName)
+			return agent, err
+		}
+		select {
+		case <-ctx.Done():
+			tm.agentWaiters.Delete(fullName)
+			return nil, client.CheckTimeout(ctx, fmt.Errorf("waiting for agent %q to be present", fullName))
+		case <-waitCh:
+		case <-ticker.C:
+		}
+	}
+}
+
+// readyAgent returns the first of the given agents that runs in one of the given pods that is ready, or nil
+// when there's no such agent.
+func readyAgent(agents []*manager.AgentInfo, pods []*kates.Pod) *manager.AgentInfo {
+	for _, agent := range agents {
+		for _, pod := range pods {
+			if pod.Status.PodIP == agent.PodIp && hasAgent(pod, agent.Name) && podIsUsable(pod) {
+				return agent
+			}
+		}
+	}
+	return nil
+}
+
+// agentRolloutProgress describes how many of the given pods have a traffic-agent with the given name, and how
+// many of those are ready.
+func agentRolloutProgress(pods []*kates.Pod, agentName string) string {
+	total, ready := 0, 0
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil || !hasAgent(pod, agentName) {
+			continue
+		}
+		total++
+		if podIsUsable(pod) {
+			ready++
+		}
+	}
+	if total == 0 {
+		return "no pod has a traffic-agent yet"
+	}
+	return fmt.Sprintf("%d of %d pods with a traffic-agent are ready", ready, total)
+}
+
+// notifyProgress passes the given progress message on to the user of the CLI.
+func (tm *trafficManager) notifyProgress(msg string) {
+	if tm.callbacks.Notify != nil {
+		tm.callbacks.Notify(msg)
+	}
+}
+
+// agentImagePullCheckInterval is how often the pods are checked for a ready traffic-agent, and for a
+// traffic-agent image that can't be pulled, while waiting for an agent to arrive.
+var agentImagePullCheckInterval = 2 * time.Second
 
-	select {
-	case <-ctx.Done():
-		return nil, client.CheckTimeout(ctx, fmt.Errorf("waiting for agent %q to be present", fullName))
-	case agent := <-waitCh:
-		return agent, nil
+// agentImagePullError returns an error if one of the given pods that has a traffic-agent with the given name
+// is unable to pull the agent image.
+func agentImagePullError(pods []*kates.Pod, agentName string) error {
+	for _, pod := range pods {
+		if !hasAgent(pod, agentName) {
+			continue
+		}
+		statuses := make([]corev1.ContainerStatus, 0, len(pod.Status.InitContainerStatuses)+len(pod.Status.ContainerStatuses))
+		statuses = append(statuses, pod.Status.InitContainerStatuses...)
+		statuses = append(statuses, pod.Status.ContainerStatuses...)
+		for _, cs := range statuses {
+			if cs.Name != install.AgentContainerName && cs.Name != install.InitContainerName {
+				continue
+			}
+			if w := cs.State.Waiting; w != nil && (w.Reason == "ImagePullBackOff" || w.Reason == "ErrImagePull") {
+				return errcat.User.Newf("pod %s.%s is unable to pull the traffic-agent image %s (%s: %s). If the registry "+
+					"requires credentials, declare the secrets that hold them using images.agentImagePullSecrets in the "+
+					"client config, or agentInjector.agentImage.pullSecrets in the Helm values of the traffic-manager",
+					pod.Name, pod.Namespace, cs.Image, w.Reason, w.Message)
+			}
+		}
+	}
+	return nil
+}
+
+// hasAgent returns true if the given pod has a traffic-agent with the given name.
+func hasAgent(pod *kates.Pod, agentName string) bool {
+	cns := pod.Spec.Containers
+	for i := range cns {
+		if cn := &cns[i]; cn.Name == install.AgentContainerName && install.AgentName(cn) == agentName {
+			return true
+		}
 	}
+	return false
 }
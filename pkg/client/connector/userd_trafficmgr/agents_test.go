@@ -0,0 +1,132 @@
+package userd_trafficmgr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+func TestCheckAgentsSupportHTTPConditions(t *testing.T) {
+	agent := func(version string) *manager.AgentInfo {
+		return &manager.AgentInfo{Name: "echo", Namespace: "default", Version: version}
+	}
+
+	assert.NoError(t, checkAgentsSupportHTTPConditions("echo", "default", []*manager.AgentInfo{agent("v2.4.5"), agent("v2.5.0")}))
+	assert.NoError(t, checkAgentsSupportHTTPConditions("echo", "default", []*manager.AgentInfo{agent("v2.4.5-rc.1")}))
+
+	// One old replica is enough to fail, since it would intercept everything that reaches it
+	err := checkAgentsSupportHTTPConditions("echo", "default", []*manager.AgentInfo{agent("v2.5.0"), agent("v2.4.4")})
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+	assert.Contains(t, err.Error(), `echo.default has version "v2.4.4"`)
+	assert.Contains(t, err.Error(), "telepresence uninstall --agent echo")
+
+	assert.Error(t, checkAgentsSupportHTTPConditions("echo", "default", []*manager.AgentInfo{agent("")}))
+
+	// An agent that hasn't arrived can't be trusted to be recent enough
+	err = checkAgentsSupportHTTPConditions("echo", "default", nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no traffic-agent of echo.default has arrived")
+}
+
+func TestCheckAgentsSupportTLSTermination(t *testing.T) {
+	agent := func(version string) *manager.AgentInfo {
+		return &manager.AgentInfo{Name: "echo", Namespace: "default", Version: version}
+	}
+
+	assert.NoError(t, checkAgentsSupportTLSTermination("echo", "default", []*manager.AgentInfo{agent("v2.4.5"), agent("v2.4.5-rc.1")}))
+	err := checkAgentsSupportTLSTermination("echo", "default", []*manager.AgentInfo{agent("v2.4.4")})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "using --agent-tls-terminate")
+}
+
+func TestAgentImagePullError(t *testing.T) {
+	const image = "registry.example.com/datawire/tel2:2.4.5"
+	pod := func(name, agentName string, waiting *corev1.ContainerStateWaiting, init bool) *kates.Pod {
+		agent := corev1.Container{
+			Name:  install.AgentContainerName,
+			Image: image,
+			Env:   []corev1.EnvVar{{Name: install.EnvPrefix + "NAME", Value: agentName}},
+		}
+		status := corev1.ContainerStatus{Name: install.AgentContainerName, Image: image, State: corev1.ContainerState{Waiting: waiting}}
+		p := &kates.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "echo"}, agent}},
+		}
+		if init {
+			status.Name = install.InitContainerName
+			p.Status.InitContainerStatuses = []corev1.ContainerStatus{status}
+		} else {
+			p.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: "echo"}, status}
+		}
+		return p
+	}
+	backOff := &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: `Back-off pulling image "` + image + `"`}
+	errPull := &corev1.ContainerStateWaiting{Reason: "ErrImagePull", Message: "unauthorized: authentication required"}
+	creating := &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}
+
+	assert.NoError(t, agentImagePullError(nil, "echo"))
+	assert.NoError(t, agentImagePullError([]*kates.Pod{pod("echo-1", "echo", creating, false)}, "echo"))
+
+	// The pods of other agents are not considered
+	assert.NoError(t, agentImagePullError([]*kates.Pod{pod("web-1", "web", backOff, false)}, "echo"))
+
+	err := agentImagePullError([]*kates.Pod{pod("echo-1", "echo", creating, false), pod("echo-2", "echo", backOff, false)}, "echo")
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+	assert.Contains(t, err.Error(), "pod echo-2.default is unable to pull the traffic-agent image "+image+" (ImagePullBackOff")
+	assert.Contains(t, err.Error(), "images.agentImagePullSecrets")
+	assert.Contains(t, err.Error(), "agentInjector.agentImage.pullSecrets")
+
+	// The init container uses the same image
+	err = agentImagePullError([]*kates.Pod{pod("echo-1", "echo", errPull, true)}, "echo")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "(ErrImagePull: unauthorized: authentication required)")
+}
+
+func TestReadyAgent(t *testing.T) {
+	pod := func(name, ip string, ready bool) *kates.Pod {
+		p := &kates.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "echo"}, {
+				Name: install.AgentContainerName,
+				Env:  []corev1.EnvVar{{Name: install.EnvPrefix + "NAME", Value: "echo"}},
+			}}},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning, PodIP: ip},
+		}
+		status := corev1.ConditionFalse
+		if ready {
+			status = corev1.ConditionTrue
+		}
+		p.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: status}}
+		return p
+	}
+	agent := func(ip string) *manager.AgentInfo {
+		return &manager.AgentInfo{Name: "echo", Namespace: "default", PodIp: ip}
+	}
+
+	// Mid-rollout, the agent of a pod that isn't ready yet has arrived, while the ready pod has no agent
+	noAgent := pod("echo-old", "10.1.0.1", true)
+	noAgent.Spec.Containers = noAgent.Spec.Containers[:1]
+	pods := []*kates.Pod{noAgent, pod("echo-new", "10.1.0.2", false)}
+	assert.Nil(t, readyAgent([]*manager.AgentInfo{agent("10.1.0.2")}, pods))
+	assert.Equal(t, "0 of 1 pods with a traffic-agent are ready", agentRolloutProgress(pods, "echo"))
+
+	// The agent of a terminated pod lingers until the traffic-manager notices that it's gone
+	pods = []*kates.Pod{pod("echo-new", "10.1.0.2", true)}
+	ready := agent("10.1.0.2")
+	assert.Equal(t, ready, readyAgent([]*manager.AgentInfo{agent("10.1.0.1"), ready}, pods))
+	assert.Equal(t, "1 of 1 pods with a traffic-agent are ready", agentRolloutProgress(pods, "echo"))
+
+	assert.Nil(t, readyAgent(nil, pods))
+	assert.Equal(t, "no pod has a traffic-agent yet", agentRolloutProgress([]*kates.Pod{noAgent}, "echo"))
+	assert.Equal(t, "no pod has a traffic-agent yet", agentRolloutProgress(pods, "web"))
+}
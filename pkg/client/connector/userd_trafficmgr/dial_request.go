@@ -2,17 +2,37 @@ package userd_trafficmgr
 
 import (
 	"context"
+	"time"
 
+	"github.com/datawire/dlib/dlog"
+	"github.com/datawire/dlib/dtime"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
 )
 
 func (tm *trafficManager) dialRequestWatcher(ctx context.Context) error {
 	<-tm.startup
-	// Deal with dial requests from the manager
-	dialerStream, err := tm.managerClient.WatchDial(ctx, tm.sessionInfo)
-	if err != nil {
-		return err
+	ctx = tunnel.WithTCPKeepAlive(ctx, client.GetConfig(ctx).Connections.TCPKeepAlive)
+	ctx = tunnel.WithTLSConfig(ctx, tm.localTLSConfig)
+	// Deal with dial requests from the manager. The stream ends when the session is broken, so it's
+	// watched again using the session that replaces it.
+	backoff := 100 * time.Millisecond
+	for ctx.Err() == nil {
+		session := tm.session()
+		dialerStream, err := tm.managerClient.WatchDial(ctx, session)
+		if err != nil {
+			dlog.Errorf(ctx, "manager.WatchDial dial: %v", err)
+		} else {
+			err = tunnel.DialWaitLoop(ctx, tm.managerClient, dialerStream, session.SessionId)
+		}
+		if isUnknownSession(err) {
+			tm.sessionUnknown(ctx, session.SessionId)
+		}
+		dtime.SleepWithContext(ctx, backoff)
+		backoff *= 2
+		if backoff > 3*time.Second {
+			backoff = 3 * time.Second
+		}
 	}
-	tunnel.DialWaitLoop(ctx, tm.managerClient, dialerStream, tm.sessionInfo.SessionId)
 	return nil
 }
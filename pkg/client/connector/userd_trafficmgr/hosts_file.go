@@ -0,0 +1,80 @@
+package userd_trafficmgr
+
+import (
+	"bytes"
+	"os"
+	"strings"
+)
+
+// The markers of the section of a hosts file that the port proxy manages. Everything outside the
+// section is left as it is.
+const (
+	hostsSectionBegin = "# BEGIN telepresence"
+	hostsSectionEnd   = "# END telepresence"
+)
+
+// updateHostsSection makes the telepresence section of the given hosts file contain the given lines, or
+// removes the section when there are no lines. The file is only written when its content changes, and
+// the returned boolean tells if it did.
+func updateHostsSection(path string, lines []string) (bool, error) {
+	data, err := os.ReadFile(path)
+	perm := os.FileMode(0644)
+	switch {
+	case err == nil:
+		if fi, err := os.Stat(path); err == nil {
+			perm = fi.Mode().Perm()
+		}
+	case os.IsNotExist(err):
+		if len(lines) == 0 {
+			return false, nil
+		}
+	default:
+		return false, err
+	}
+	content := replaceHostsSection(data, lines)
+	if bytes.Equal(content, data) {
+		return false, nil
+	}
+	if err = os.WriteFile(path, content, perm); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// replaceHostsSection returns the given content of a hosts file with its telepresence section replaced
+// by one that contains the given lines. The section is appended when the content has none, and removed
+// when there are no lines.
+func replaceHostsSection(data []byte, lines []string) []byte {
+	var buf bytes.Buffer
+	inSection := false
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		switch strings.TrimSpace(line) {
+		case hostsSectionBegin:
+			inSection = true
+			continue
+		case hostsSectionEnd:
+			if inSection {
+				inSection = false
+				continue
+			}
+		}
+		if !inSection {
+			buf.WriteString(line)
+		}
+	}
+	if len(lines) == 0 {
+		return buf.Bytes()
+	}
+	if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+	buf.WriteString(hostsSectionBegin + "\n")
+	for _, line := range lines {
+		buf.WriteString(line + "\n")
+	}
+	buf.WriteString(hostsSectionEnd + "\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,70 @@
+package userd_trafficmgr
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateHostsSection(t *testing.T) {
+	const original = "127.0.0.1 localhost\n::1 localhost\n"
+	path := filepath.Join(t.TempDir(), "hosts")
+	require.NoError(t, os.WriteFile(path, []byte(original), 0644))
+
+	lines := []string{"127.0.0.1 echo.default echo.default.svc echo.default.svc.cluster.local"}
+	changed, err := updateHostsSection(path, lines)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, original+`# BEGIN telepresence
+127.0.0.1 echo.default echo.default.svc echo.default.svc.cluster.local
+# END telepresence
+`, string(data))
+
+	// Writing the same lines again changes nothing
+	changed, err = updateHostsSection(path, lines)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// The section is replaced in place, and the lines that were added after it are kept
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString("10.1.0.2 vpn.example.com\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	changed, err = updateHostsSection(path, []string{"127.0.0.1 web.blue web.blue.svc web.blue.svc.cluster.local"})
+	require.NoError(t, err)
+	assert.True(t, changed)
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, original+`10.1.0.2 vpn.example.com
+# BEGIN telepresence
+127.0.0.1 web.blue web.blue.svc web.blue.svc.cluster.local
+# END telepresence
+`, string(data))
+
+	// The section is removed when there are no lines
+	changed, err = updateHostsSection(path, nil)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, original+"10.1.0.2 vpn.example.com\n", string(data))
+
+	changed, err = updateHostsSection(path, nil)
+	require.NoError(t, err)
+	assert.False(t, changed)
+}
+
+func TestUpdateHostsSection_missingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	changed, err := updateHostsSection(path, nil)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,127 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"time"
+
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
+)
+
+// Clock is the mechanism used by the idle watcher to get the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+type wall struct{}
+
+func (wall) Now() time.Time {
+	return time.Now()
+}
+
+// idleCheckInterval is the interval between the checks for activity in the session.
+var idleCheckInterval = 30 * time.Second
+
+// idleWatcher keeps track of the last activity of a session, i.e. the last time that an intercept
+// was active or that a connection to the cluster passed through the TUN device or the port proxy.
+type idleWatcher struct {
+	clock   Clock
+	timeout time.Duration
+
+	// intercepting returns true when the session has active intercepts
+	intercepting func() bool
+
+	// lastTraffic returns the time of the last packet that was routed to the cluster
+	lastTraffic func(context.Context) time.Time
+
+	lastActivity time.Time
+}
+
+func newIdleWatcher(clock Clock, timeout time.Duration, intercepting func() bool, lastTraffic func(context.Context) time.Time) *idleWatcher {
+	return &idleWatcher{
+		clock:        clock,
+		timeout:      timeout,
+		intercepting: intercepting,
+		lastTraffic:  lastTraffic,
+		lastActivity: clock.Now(),
+	}
+}
+
+// idle updates the time of the last activity from the activity signals and returns true if the
+// session has been idle longer than the timeout.
+func (w *idleWatcher) idle(c context.Context) bool {
+	now := w.clock.Now()
+	if w.intercepting() {
+		w.lastActivity = now
+	}
+	if lt := w.lastTraffic(c); lt.After(w.lastActivity) {
+		w.lastActivity = lt
+	}
+	return now.Sub(w.lastActivity) >= w.timeout
+}
+
+// idleWatcher ends the session when it has been idle longer than the configured idleTimeout. The
+// reason is saved in the user cache so that the next CLI command can report it.
+func (tm *trafficManager) idleWatcher(c context.Context) error {
+	timeout := client.GetConfig(c).IdleTimeout
+	if timeout <= 0 {
+		return nil
+	}
+	<-tm.startup
+	w := newIdleWatcher(wall{}, timeout, tm.intercepting, tm.lastTraffic)
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if w.idle(c) {
+			tm.idleDisconnect(c, w.clock.Now(), timeout)
+			return nil
+		}
+	}
+}
+
+func (tm *trafficManager) intercepting() bool {
+	tm.currentInterceptsLock.Lock()
+	defer tm.currentInterceptsLock.Unlock()
+	return len(tm.currentIntercepts) > 0
+}
+
+func (tm *trafficManager) lastTraffic(c context.Context) time.Time {
+	switch {
+	case tm.portProxy != nil:
+		return tm.portProxy.getLastActivity()
+	case tm.socksProxy != nil:
+		return tm.socksProxy.getLastActivity()
+	}
+	ds, err := tm.callbacks.DaemonStatus(c, &empty.Empty{})
+	if err != nil {
+		dlog.Errorf(c, "unable to get the status of the root daemon: %v", err)
+		return time.Time{}
+	}
+	if ds.LastActivity == nil {
+		return time.Time{}
+	}
+	return ds.LastActivity.AsTime()
+}
+
+func (tm *trafficManager) idleDisconnect(c context.Context, now time.Time, timeout time.Duration) {
+	dlog.Warnf(c, "Session has been idle for %s, disconnecting", timeout)
+	if err := cache.SaveIdleDisconnectToUserCache(c, &cache.IdleDisconnect{Time: now, Timeout: timeout}); err != nil {
+		dlog.Errorf(c, "unable to save the idle disconnect to the user cache: %v", err)
+	}
+	// Ending the session in the connector alone would leave the TUN device, the routes, and the DNS
+	// of the root daemon in place
+	if tm.callbacks.QuitRootDaemon != nil {
+		if err := tm.callbacks.QuitRootDaemon(c); err != nil {
+			dlog.Errorf(c, "unable to quit the root daemon: %v", err)
+		}
+	}
+	tm.callbacks.Disconnect()
+}
@@ -0,0 +1,102 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (fc *fakeClock) Now() time.Time {
+	return fc.now
+}
+
+func (fc *fakeClock) advance(d time.Duration) {
+	fc.now = fc.now.Add(d)
+}
+
+func TestIdleWatcher(t *testing.T) {
+	const timeout = time.Hour
+	ctx := dlog.NewTestContext(t, false)
+	setup := func() (*fakeClock, *bool, *time.Time, *idleWatcher) {
+		clock := &fakeClock{now: time.Date(2000, time.January, 1, 8, 0, 0, 0, time.UTC)}
+		intercepting := false
+		var traffic time.Time
+		w := newIdleWatcher(clock, timeout,
+			func() bool { return intercepting },
+			func(context.Context) time.Time { return traffic })
+		return clock, &intercepting, &traffic, w
+	}
+
+	t.Run("expiry", func(t *testing.T) {
+		clock, _, _, w := setup()
+		clock.advance(timeout - time.Minute)
+		assert.False(t, w.idle(ctx))
+		clock.advance(time.Minute)
+		assert.True(t, w.idle(ctx))
+	})
+
+	t.Run("traffic resets timer", func(t *testing.T) {
+		clock, _, traffic, w := setup()
+		clock.advance(45 * time.Minute)
+		*traffic = clock.Now()
+		assert.False(t, w.idle(ctx))
+		clock.advance(45 * time.Minute)
+		assert.False(t, w.idle(ctx))
+		clock.advance(15 * time.Minute)
+		assert.True(t, w.idle(ctx))
+	})
+
+	t.Run("intercept resets timer", func(t *testing.T) {
+		clock, intercepting, _, w := setup()
+		*intercepting = true
+		clock.advance(3 * timeout)
+		assert.False(t, w.idle(ctx))
+
+		// The timer starts when the last intercept ends
+		*intercepting = false
+		clock.advance(timeout - time.Minute)
+		assert.False(t, w.idle(ctx))
+		clock.advance(time.Minute)
+		assert.True(t, w.idle(ctx))
+	})
+}
+
+func TestIdleDisconnect(t *testing.T) {
+	ctx := filelocation.WithUserHomeDir(dlog.NewTestContext(t, false), t.TempDir())
+	var calls []string
+	tm := &trafficManager{callbacks: Callbacks{
+		QuitRootDaemon: func(context.Context) error {
+			calls = append(calls, "quit root daemon")
+			return nil
+		},
+		Disconnect: func() {
+			calls = append(calls, "disconnect")
+		},
+	}}
+	now := time.Date(2000, time.January, 1, 8, 0, 0, 0, time.UTC)
+	tm.idleDisconnect(ctx, now, time.Hour)
+
+	// The root daemon must quit while the connection to it still exists
+	assert.Equal(t, []string{"quit root daemon", "disconnect"}, calls)
+	idle, err := cache.LoadIdleDisconnectFromUserCache(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, idle)
+	assert.Equal(t, time.Hour, idle.Timeout)
+
+	// Without a root daemon, only the connector's session ends
+	calls = nil
+	tm.callbacks.QuitRootDaemon = nil
+	tm.idleDisconnect(ctx, now, time.Hour)
+	assert.Equal(t, []string{"disconnect"}, calls)
+}
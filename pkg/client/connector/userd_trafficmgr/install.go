@@ -1,35 +1,43 @@
 package userd_trafficmgr
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	errors2 "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/util/intstr"
 
 	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/datawire/dlib/dcontext"
 	"github.com/datawire/dlib/dlog"
 	"github.com/datawire/dlib/dtime"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_k8s"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 	"github.com/telepresenceio/telepresence/v2/pkg/install"
 	"github.com/telepresenceio/telepresence/v2/pkg/install/helm"
 )
 
 type installer struct {
 	*userd_k8s.Cluster
+
+	// managerValues are the Helm values that are applied when the traffic-manager is installed or upgraded
+	managerValues map[string]interface{}
+
+	// notify sends a message to the user of the CLI. It may be nil
+	notify func(string)
 }
 
-func newTrafficManagerInstaller(kc *userd_k8s.Cluster) (*installer, error) {
-	return &installer{Cluster: kc}, nil
+func newTrafficManagerInstaller(kc *userd_k8s.Cluster, managerValues map[string]interface{}) (*installer, error) {
+	return &installer{Cluster: kc, managerValues: managerValues}, nil
 }
 
 const annTelepresenceActions = install.DomainPrefix + "actions"
@@ -38,103 +46,165 @@ func managerImageName(ctx context.Context) string {
 	return fmt.Sprintf("%s/tel2:%s", client.GetConfig(ctx).Images.Registry, strings.TrimPrefix(client.Version(), "v"))
 }
 
-// removeManager will remove the agent from all deployments listed in the given agents slice. Unless agentsOnly is true,
-// it will also remove the traffic-manager service and deployment.
-func (ki *installer) removeManagerAndAgents(c context.Context, agentsOnly bool, agents []*manager.AgentInfo) error {
-	// Removes the manager and all agents from the cluster
-	var errs []error
-	var errsLock sync.Mutex
-	addError := func(e error) {
-		errsLock.Lock()
-		errs = append(errs, e)
-		errsLock.Unlock()
-	}
-
-	// Remove the agent from all deployments
-	webhookAgentChannel := make(chan kates.Object, len(agents))
-	wg := sync.WaitGroup{}
-	wg.Add(len(agents))
-	for _, ai := range agents {
-		ai := ai // pin it
-		go func() {
-			defer wg.Done()
-			agent, err := ki.FindWorkload(c, ai.Namespace, ai.Name)
-			if err != nil {
-				if !errors2.IsNotFound(err) {
-					addError(err)
-				}
-				return
-			}
+// removeManagerAndAgents will remove the agent from all workloads listed in the given agents slice. Unless agentsOnly
+// is true, it will also remove the traffic-manager service and deployment. The result of each removal is returned. A
+// failure to remove one agent doesn't prevent the removal of the others, but the traffic-manager is kept when an agent
+// couldn't be removed.
+func (ki *installer) removeManagerAndAgents(c context.Context, agentsOnly bool, agents []*manager.AgentInfo) []*rpc.UninstallResult_Removal {
+	// Remove the agent from all workloads. Agents that were added using the mutating webhook are removed by
+	// removing the annotation that makes the webhook inject them when the traffic-manager remains, and by a
+	// rollout once the webhook is gone otherwise.
+	removals := make([]*rpc.UninstallResult_Removal, len(agents))
+	webhookAgents := make([]kates.Object, len(agents))
+	removeEach(len(agents), func(i int) error {
+		obj, err := ki.removeAgent(c, agents[i])
+		if err != nil || obj == nil {
+			return err
+		}
+		if agentsOnly {
+			return ki.removeInjection(c, obj)
+		}
+		webhookAgents[i] = obj
+		return nil
+	}, func(i int, err error) {
+		removals[i] = newRemoval(removalKindAgent, agents[i].Name, agents[i].Namespace, err)
+	})
 
-			// Assume that the agent was added using the mutating webhook when no actions
-			// annotation can be found in the workload.
-			ann := agent.GetAnnotations()
-			if ann == nil {
-				webhookAgentChannel <- agent
-				return
-			}
-			if _, ok := ann[annTelepresenceActions]; !ok {
-				webhookAgentChannel <- agent
-				return
-			}
-			if err = ki.undoObjectMods(c, agent); err != nil {
-				addError(err)
-				return
+	failed := 0
+	for _, r := range removals {
+		if r.ErrorText != "" {
+			failed++
+		}
+	}
+	if agentsOnly {
+		return removals
+	}
+
+	mgrNs := ki.GetManagerNamespace()
+	if failed > 0 {
+		return append(removals, newRemoval(removalKindManager, install.ManagerAppName, mgrNs,
+			errcat.User.Newf("the traffic-manager is kept because the agent could not be removed from %d workload(s)", failed)))
+	}
+	err := helm.DeleteTrafficManager(c, ki.ConfigFlags, ki.Client(), mgrNs)
+	mgrRemoval := newRemoval(removalKindManager, install.ManagerAppName, mgrNs, err)
+	if err == nil {
+		// roll all agents installed by webhook
+		removeEach(len(agents), func(i int) error {
+			if webhookAgents[i] == nil {
+				return nil
 			}
-			if err = ki.waitForApply(c, ai.Namespace, ai.Name, agent); err != nil {
-				addError(err)
+			return ki.rolloutRestart(c, webhookAgents[i])
+		}, func(i int, err error) {
+			if err != nil {
+				removals[i] = newRemoval(removalKindAgent, agents[i].Name, agents[i].Namespace, err)
 			}
-		}()
+		})
 	}
-	// wait for all agents to be removed
-	wg.Wait()
-	close(webhookAgentChannel)
+	return append(removals, mgrRemoval)
+}
 
-	if !agentsOnly && len(errs) == 0 {
-		// agent removal succeeded. Remove the manager resources
-		if err := helm.DeleteTrafficManager(c, ki.ConfigFlags, ki.GetManagerNamespace()); err != nil {
-			addError(err)
+// removeAgent removes the agent from the workload of the given agent. The workload is returned without
+// modifications when the agent was added using the mutating webhook.
+func (ki *installer) removeAgent(c context.Context, ai *manager.AgentInfo) (kates.Object, error) {
+	agent, err := ki.FindWorkload(c, ai.Namespace, ai.Name)
+	if err != nil {
+		if errors2.IsNotFound(err) {
+			err = nil
 		}
+		return nil, err
+	}
 
-		// roll all agents installed by webhook
-		webhookWaitGroup := sync.WaitGroup{}
-		webhookWaitGroup.Add(len(webhookAgentChannel))
-		for agent := range webhookAgentChannel {
-			go func(obj kates.Object) {
-				defer webhookWaitGroup.Done()
-				err := ki.rolloutRestart(c, obj)
-				if err != nil {
-					addError(err)
-				}
-			}(agent)
-		}
-		// wait for all agents to be removed
-		webhookWaitGroup.Wait()
+	// Assume that the agent was added using the mutating webhook when no actions
+	// annotation can be found in the workload.
+	if _, ok := agent.GetAnnotations()[annTelepresenceActions]; !ok {
+		return agent, nil
+	}
+	if err = ki.undoObjectMods(c, agent); err != nil {
+		return nil, err
 	}
+	return nil, ki.waitForApply(c, ai.Namespace, ai.Name, agent)
+}
 
-	switch len(errs) {
-	case 0:
-	case 1:
-		return errs[0]
-	default:
-		bld := bytes.NewBufferString("multiple errors:")
-		for _, err := range errs {
-			bld.WriteString("\n  ")
-			bld.WriteString(err.Error())
+// removeInjection removes the annotation that makes the traffic-manager's mutating webhook inject the
+// traffic-agent from the pod template of the given workload, and waits for the workload's pods to be
+// recreated without the traffic-agent.
+func (ki *installer) removeInjection(c context.Context, obj kates.Object) error {
+	if userd_k8s.IsRollout(obj) {
+		ro := obj.(*kates.Unstructured)
+		if !userd_k8s.RolloutInjectionEnabled(ro) {
+			return errNotAnnotated
 		}
-		return errors.New(bld.String())
+		dlog.Infof(c, "Disabling traffic-agent injection for %s %s.%s", userd_k8s.RolloutKind, ro.GetName(), ro.GetNamespace())
+		return ki.DisableRolloutInjection(c, ro)
 	}
-	return nil
+	podTemplate, err := install.GetPodTemplateFromObject(obj)
+	if err != nil {
+		return err
+	}
+	if podTemplate.Annotations[install.InjectAnnotation] != "enabled" {
+		return errNotAnnotated
+	}
+	dlog.Infof(c, "Disabling traffic-agent injection for %s %s.%s",
+		obj.GetObjectKind().GroupVersionKind().Kind, obj.GetName(), obj.GetNamespace())
+	if err = ki.Client().Patch(c, obj, kates.StrategicMergePatchType, []byte(userd_k8s.InjectionRemovalPatch), obj); err != nil {
+		return err
+	}
+	return ki.waitForApply(c, obj.GetNamespace(), obj.GetName(), obj)
 }
 
-// recreates "kubectl rollout restart <obj>" for kates.obj
-func (ki *installer) rolloutRestart(c context.Context, obj kates.Object) error {
-	restartAnnotation := fmt.Sprintf(
+// errNotAnnotated is returned by removeInjection when the webhook injects the traffic-agent into a workload
+// that isn't annotated, e.g. because its namespace is labeled for injection.
+var errNotAnnotated = errcat.User.New(
+	"the agent was injected by the traffic-manager's mutating webhook and is removed when the traffic-manager is uninstalled")
+
+// restartPatch returns the strategic merge patch that restarts the pods of a workload at the given time.
+func restartPatch(t time.Time) string {
+	return fmt.Sprintf(
 		`{"spec": {"template": {"metadata": {"annotations": {"%srestartedAt": "%s"}}}}}`,
 		install.DomainPrefix,
-		time.Now().Format(time.RFC3339),
+		t.Format(time.RFC3339),
 	)
-	return ki.Client().Patch(c, obj, kates.StrategicMergePatchType, []byte(restartAnnotation), obj)
+}
+
+// recreates "kubectl rollout restart <obj>" for kates.obj
+func (ki *installer) rolloutRestart(c context.Context, obj kates.Object) error {
+	if userd_k8s.IsRollout(obj) {
+		return ki.RestartRollout(c, obj.(*kates.Unstructured))
+	}
+	if err := ki.Client().Patch(c, obj, kates.StrategicMergePatchType, []byte(restartPatch(time.Now())), obj); err != nil {
+		return err
+	}
+	// A changed pod template doesn't restart the pods of a ReplicaSet, nor those of a StatefulSet that
+	// uses the OnDelete strategy
+	if _, ok := obj.(*kates.ReplicaSet); ok {
+		return ki.deleteOwnedPods(c, obj.GetNamespace(), obj)
+	}
+	if warning := onDeleteRestartWarning(obj); warning != "" {
+		ki.warn(c, warning)
+		return ki.deleteOwnedPods(c, obj.GetNamespace(), obj)
+	}
+	return nil
+}
+
+// warn logs the given warning and passes it on to the user of the CLI.
+func (ki *installer) warn(c context.Context, warning string) {
+	dlog.Warn(c, warning)
+	if ki.notify != nil {
+		ki.notify("Warning: " + warning)
+	}
+}
+
+// onDeleteRestartWarning returns a warning about the pod restarts that telepresence must do itself when the pod
+// template of the given workload is modified, or an empty string when the workload's controller restarts the pods.
+// That's the case for all workloads except StatefulSets that use the OnDelete update strategy.
+func onDeleteRestartWarning(obj kates.Object) string {
+	ss, ok := obj.(*kates.StatefulSet)
+	if !ok || ss.Spec.UpdateStrategy.Type != appsv1.OnDeleteStatefulSetStrategyType {
+		return ""
+	}
+	return fmt.Sprintf("StatefulSet %s.%s uses the OnDelete update strategy, so telepresence deletes its pods to "+
+		"restart them with the modified pod template. The pods are recreated one by one, and are unavailable meanwhile",
+		ss.Name, ss.Namespace)
 }
 
 // Finds the Referenced Service in an objects' annotations
@@ -195,59 +265,180 @@ func checkSvcSame(c context.Context, obj kates.Object, svcName, portNameOrNumber
 	return nil
 }
 
+// checkAgentManagerNamespace returns an error if the given agent container of the workload belongs to a
+// traffic-manager in another namespace than the given one. Two traffic-managers must never share an agent.
+func checkAgentManagerNamespace(obj kates.Object, agent *kates.Container, managerNamespace string) error {
+	if ns := install.AgentManagerNamespace(agent); ns != "" && ns != managerNamespace {
+		return errcat.User.New(install.ObjErrorf(obj,
+			"the agent belongs to the traffic-manager in namespace %s, not to the one in namespace %s. Connect using "+
+				"--manager-namespace %s to intercept it", ns, managerNamespace, ns))
+	}
+	return nil
+}
+
 var agentNotFound = errors.New("no such agent")
 
+// agentPlan is what ensureAgent must do in order to install the traffic-agent in a workload. It's
+// computed without changing anything, so that it can also be reported by a dry-run.
+type agentPlan struct {
+	obj  kates.Object // the workload as found in the cluster
+	kind string
+
+	// svc is the service that the intercept targets, and svcPort the name or number of its port
+	svc     *kates.Service
+	svcPort string
+
+	// container is the name of the intercepted container, and containerPort the number of the container
+	// port that the target port of svc resolves to
+	container     string
+	containerPort int32
+
+	// extraContainerPorts are the numbers of the container ports that the other intercepted ports of svc
+	// resolve to, in the order that those ports were given
+	extraContainerPorts []int32
+
+	// enableInjection is true when the Rollout must be annotated so that the mutating webhook injects the
+	// traffic-agent into the pods of its next revision
+	enableInjection bool
+
+	// restart is true when the pods must be restarted so that the mutating webhook injects the traffic-agent
+	restart bool
+
+	// missingWebhook is true when the workload is annotated for injection by the mutating webhook, but the
+	// webhook isn't installed, so the traffic-agent is added by patching the workload instead
+	missingWebhook bool
+
+	// updatedObj and updatedSvc are the workload and service with the traffic-agent added or updated, or
+	// nil when they remain unchanged
+	updatedObj kates.Object
+	updatedSvc *kates.Service
+}
+
 // This does a lot of things but at a high level it ensures that the traffic agent
 // is installed alongside the proper workload. In doing that, it also ensures that
 // the workload is referenced by a service. Lastly, it returns the service UID
 // associated with the workload since this is where that correlation is made.
-func (ki *installer) ensureAgent(c context.Context, namespace, name, svcName, portNameOrNumber, agentImageName string) (string, string, error) {
+// The agentConfig is the default configuration of the agent, which the
+// annotations of the workload's pod template take precedence over.
+func (ki *installer) ensureAgent(
+	c context.Context,
+	namespace, name, svcName, portNameOrNumber, containerName, agentImageName string,
+	extraPorts []string,
+	pullSecrets []string,
+	agentConfig *install.AgentConfig,
+) (*agentPlan, error) {
 	obj, err := ki.FindWorkload(c, namespace, name)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
-	podTemplate, err := install.GetPodTemplateFromObject(obj)
+	p, err := ki.planAgent(c, obj, svcName, portNameOrNumber, containerName, agentImageName, extraPorts, pullSecrets, agentConfig)
 	if err != nil {
-		return "", "", err
+		return nil, err
+	}
+	if err = ki.applyAgentPlan(c, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// planAgent determines what must be done to install the traffic-agent in the given workload so that
+// it can be intercepted using the given service and port, together with the extraPorts of the same
+// service. Neither the workload nor the cluster is changed.
+func (ki *installer) planAgent(
+	c context.Context,
+	obj kates.Object,
+	svcName, portNameOrNumber, containerName, agentImageName string,
+	extraPorts []string,
+	pullSecrets []string,
+	agentConfig *install.AgentConfig,
+) (*agentPlan, error) {
+	podTemplate, err := ki.WorkloadPodTemplate(c, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	name := obj.GetName()
+	namespace := obj.GetNamespace()
+	p := &agentPlan{obj: obj, kind: obj.GetObjectKind().GroupVersionKind().Kind}
+
+	// The pod template of a Rollout is owned by the Argo Rollouts controller, so the traffic-agent is
+	// always injected into its pods by the mutating webhook.
+	isRollout := userd_k8s.IsRollout(obj)
+	if isRollout && !userd_k8s.RolloutInjectionEnabled(obj.(*kates.Unstructured)) {
+		p.enableInjection = true
+	}
+
+	a := podTemplate.ObjectMeta.Annotations
+	injected := isRollout || a != nil && a[install.InjectAnnotation] == "enabled"
+	if injected {
+		mgrNs := ki.GetManagerNamespace()
+		installed, err := ki.AgentInjectorInstalled(c, mgrNs)
+		if err != nil {
+			return nil, err
+		}
+		if !installed {
+			if err = missingWebhookError(obj, mgrNs, client.GetConfig(c).Intercept.MissingWebhook); err != nil {
+				return nil, err
+			}
+			p.missingWebhook = true
+			injected = false
+		}
 	}
 
-	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	if injected {
+		if len(extraPorts) > 0 {
+			return nil, errcat.User.New(install.ObjErrorf(obj, "the traffic-agent is injected by the traffic-manager's "+
+				"mutating webhook, which intercepts one port only, so --port can't be repeated"))
+		}
 
-	var svc *kates.Service
-	if a := podTemplate.ObjectMeta.Annotations; a != nil && a[install.InjectAnnotation] == "enabled" {
 		// agent is injected using a mutating webhook. Get its service and skip the rest
-		svc, err = install.FindMatchingService(c, ki.Client(), portNameOrNumber, svcName, namespace, podTemplate.Labels)
+		p.svc, err = install.FindMatchingService(c, ki.Client(), portNameOrNumber, svcName, namespace, podTemplate.Labels)
+		if err != nil {
+			return nil, err
+		}
+		sp, err := install.FindServicePort(p.svc, portNameOrNumber)
 		if err != nil {
-			return "", "", err
+			return nil, install.ObjErrorf(obj, err.Error())
+		}
+		p.svcPort = servicePortIdentifier(sp)
+
+		p.container, p.containerPort, err = injectedContainerPort(obj, podTemplate, p.svc)
+		if err != nil {
+			return nil, err
+		}
+		if err = checkIgnoredPort(obj, podTemplate, p.container, p.containerPort); err != nil {
+			return nil, err
+		}
+		if containerName != "" && containerName != p.container {
+			return nil, errcat.User.New(install.ObjErrorf(obj, "the traffic-agent is injected by the traffic-manager's "+
+				"mutating webhook, which intercepts container %s, not %s", p.container, containerName))
+		}
+
+		if p.enableInjection {
+			// The new revision of the Rollout brings the traffic-agent
+			return p, nil
 		}
 
 		// Find pod from svc. On fail, assume agent not present and roll
-		pod, err := ki.FindPodFromSelector(c, namespace, svc.Spec.Selector)
+		pod, err := ki.FindPodFromSelector(c, namespace, p.svc.Spec.Selector)
 		if err != nil {
 			dlog.Warnf(c, "Error finding pod for %s, rolling and proceeding anyway: %v", name, err)
-			err = ki.rolloutRestart(c, obj)
-			if err != nil {
-				return "", "", err
-			}
-			return string(svc.GetUID()), kind, nil
+			p.restart = true
+			return p, nil
 		}
 
 		// Check pod for agent. If missing, roll pod
-		roll := true
-		for _, containter := range pod.Spec.Containers {
-			if containter.Name == install.AgentContainerName {
-				roll = false
+		p.restart = true
+		for i := range pod.Spec.Containers {
+			if container := &pod.Spec.Containers[i]; container.Name == install.AgentContainerName {
+				if err = checkAgentManagerNamespace(obj, container, ki.GetManagerNamespace()); err != nil {
+					return nil, err
+				}
+				p.restart = false
 				break
 			}
 		}
-		if roll {
-			err = ki.rolloutRestart(c, obj)
-			if err != nil {
-				return "", "", err
-			}
-		}
-
-		return string(svc.GetUID()), kind, nil
+		return p, nil
 	}
 
 	var agentContainer *kates.Container
@@ -259,75 +450,207 @@ func (ki *installer) ensureAgent(c context.Context, namespace, name, svcName, po
 		}
 	}
 
+	if agentContainer != nil {
+		if err := checkAgentManagerNamespace(obj, agentContainer, ki.GetManagerNamespace()); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := checkSvcSame(c, obj, svcName, portNameOrNumber); err != nil {
 		msg := fmt.Sprintf(
 			`%s already being used for intercept with a different service
 configuration. To intercept this with your new configuration, please use
 telepresence uninstall --agent %s This will cancel any intercepts that
-already exist for this service`, kind, obj.GetName())
-		return "", "", errors.Wrap(err, msg)
+already exist for this service`, p.kind, name)
+		return nil, errors.Wrap(err, msg)
 	}
 
-	update := true
-	switch {
-	case agentContainer == nil:
-		dlog.Infof(c, "no agent found for %s %s.%s", kind, name, namespace)
+	if agentContainer == nil {
+		dlog.Infof(c, "no agent found for %s %s.%s", p.kind, name, namespace)
 		dlog.Infof(c, "Using port name or number %q", portNameOrNumber)
-		matchingSvc, err := install.FindMatchingService(c, ki.Client(), portNameOrNumber, svcName, namespace, podTemplate.Labels)
+		p.svc, err = install.FindMatchingService(c, ki.Client(), portNameOrNumber, svcName, namespace, podTemplate.Labels)
 		if err != nil {
-			return "", "", err
+			return nil, err
 		}
-		obj, svc, err = addAgentToWorkload(c, portNameOrNumber, agentImageName, ki.GetManagerNamespace(), obj, matchingSvc)
+		p.container, p.containerPort, err = resolveContainerPort(obj, podTemplate.Spec.Containers, portNameOrNumber, containerName, p.svc)
 		if err != nil {
-			return "", "", err
+			return nil, err
 		}
-	case agentContainer.Image != agentImageName:
-		var actions workloadActions
-		ok, err := getAnnotation(obj, &actions)
+		if err = checkIgnoredPort(obj, podTemplate, p.container, p.containerPort); err != nil {
+			return nil, err
+		}
+		p.extraContainerPorts = make([]int32, len(extraPorts))
+		for i, extraPort := range extraPorts {
+			var cn string
+			if cn, p.extraContainerPorts[i], err = resolveContainerPort(obj, podTemplate.Spec.Containers, extraPort, containerName, p.svc); err != nil {
+				return nil, err
+			}
+			if err = checkIgnoredPort(obj, podTemplate, cn, p.extraContainerPorts[i]); err != nil {
+				return nil, err
+			}
+		}
+		p.updatedObj, p.updatedSvc, err = addAgentToWorkload(c, portNameOrNumber, extraPorts, containerName, agentImageName, pullSecrets,
+			agentConfig, ki.GetManagerNamespace(), obj.DeepCopyObject().(kates.Object), p.svc.DeepCopy())
 		if err != nil {
-			return "", "", err
-		} else if !ok {
-			// This can only happen if someone manually tampered with the annTelepresenceActions annotation
-			return "", "", install.ObjErrorf(obj, "annotations[%q]: annotation is not set", annTelepresenceActions)
+			return nil, err
+		}
+		if sp, err := install.FindServicePort(p.svc, portNameOrNumber); err == nil {
+			p.svcPort = servicePortIdentifier(sp)
+		}
+		return p, nil
+	}
+
+	var actions workloadActions
+	ok, err := getAnnotation(obj, &actions)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		// This can only happen if someone manually tampered with the annTelepresenceActions annotation
+		return nil, install.ObjErrorf(obj, "annotations[%q]: annotation is not set", annTelepresenceActions)
+	}
+	p.svcPort = actions.ReferencedServicePortName
+	if p.svcPort == "" {
+		p.svcPort = actions.ReferencedServicePort
+	}
+	p.container, p.containerPort = agentAppContainer(&actions, podTemplate.Spec.Containers)
+	if containerName != "" && p.container != "" && containerName != p.container {
+		return nil, errcat.User.New(install.ObjErrorf(obj, "the installed traffic-agent intercepts container %s, not %s. "+
+			"To intercept container %s, please use telepresence uninstall --agent %s", p.container, containerName, containerName, name))
+	}
+	p.extraContainerPorts = make([]int32, len(extraPorts))
+	for i, extraPort := range extraPorts {
+		var ok bool
+		if actions.AddTrafficAgent != nil {
+			p.extraContainerPorts[i], ok = actions.AddTrafficAgent.extraContainerPort(extraPort)
+		}
+		if !ok {
+			return nil, errcat.User.New(install.ObjErrorf(obj, "the installed traffic-agent doesn't intercept service port %q. "+
+				"To intercept it, please use telepresence uninstall --agent %s", extraPort, name))
 		}
+	}
 
-		dlog.Debugf(c, "Updating agent for %s %s.%s", kind, name, namespace)
+	if agentContainer.Image != agentImageName {
+		dlog.Debugf(c, "Updating agent for %s %s.%s", p.kind, name, namespace)
 		aaa := &workloadActions{
 			Version:         actions.Version,
 			AddTrafficAgent: actions.AddTrafficAgent,
 		}
 		explainUndo(c, aaa, obj)
 		aaa.AddTrafficAgent.ImageName = agentImageName
-		agentContainer.Image = agentImageName
-		explainDo(c, aaa, obj)
-	default:
-		dlog.Debugf(c, "%s %s.%s already has an installed and up-to-date agent", kind, name, namespace)
-		update = false
+		p.updatedObj = obj.DeepCopyObject().(kates.Object)
+		podTemplate, err := install.GetPodTemplateFromObject(p.updatedObj)
+		if err != nil {
+			return nil, err
+		}
+		for i := range podTemplate.Spec.Containers {
+			if container := &podTemplate.Spec.Containers[i]; container.Name == install.AgentContainerName {
+				container.Image = agentImageName
+			}
+		}
+		explainDo(c, aaa, p.updatedObj)
+	} else {
+		dlog.Debugf(c, "%s %s.%s already has an installed and up-to-date agent", p.kind, name, namespace)
+	}
+
+	// An agent already exists that we can reuse, so we get the service from the workload's
+	// annotation so that we can extract the UID.
+	p.svc, err = ki.getSvcFromObjAnnotation(c, obj)
+	if err != nil {
+		return nil, err
 	}
+	return p, nil
+}
 
-	if update {
-		if err := ki.Client().Update(c, obj, obj); err != nil {
-			return "", "", err
+// replaceError returns the error that an intercept using --replace fails with when the traffic-manager
+// can't idle the application container of the given workload. Nil is returned when it can.
+func replaceError(obj kates.Object) error {
+	if userd_k8s.IsRollout(obj) {
+		// The traffic-manager replaces the container by updating the pod template, which in a Rollout is
+		// reverted by the Argo Rollouts controller
+		return errcat.User.New(install.ObjErrorf(obj, "--replace can't be used with an Argo Rollout, because the "+
+			"traffic-manager can't idle the container of its pods. Please intercept it without --replace"))
+	}
+	return nil
+}
+
+// missingWebhookError returns the error that an intercept of the given workload, which is annotated for injection
+// of the traffic-agent, fails with when the mutating webhook of the traffic-manager in the given namespace isn't
+// installed. The missingWebhook argument is the intercept.missingWebhook setting of the client config. Nil is
+// returned when the traffic-agent should be added by patching the workload instead.
+func missingWebhookError(obj kates.Object, managerNamespace, missingWebhook string) error {
+	if userd_k8s.IsRollout(obj) {
+		// The pod template of a Rollout is owned by the Argo Rollouts controller, so it can't be patched
+		return errcat.User.New(install.ObjErrorf(obj, "the traffic-agent of a Rollout is injected by the traffic-manager's "+
+			"mutating webhook, but the webhook isn't installed in namespace %s. Upgrade the traffic-manager using its "+
+			"Helm chart with the agent-injector enabled", managerNamespace))
+	}
+	if missingWebhook == client.MissingWebhookPatch {
+		return nil
+	}
+	return errcat.User.New(install.ObjErrorf(obj, "annotations[%q]: the workload is annotated for injection of the traffic-agent, "+
+		"but the traffic-manager's mutating webhook isn't installed in namespace %s. Install the webhook, or set "+
+		"intercept.missingWebhook to %q in the config.yml to add the traffic-agent by patching the workload instead",
+		install.InjectAnnotation, managerNamespace, client.MissingWebhookPatch))
+}
+
+// applyAgentPlan makes the changes of the given plan and waits for them to be applied.
+func (ki *installer) applyAgentPlan(c context.Context, p *agentPlan) error {
+	obj := p.obj
+	name := obj.GetName()
+	namespace := obj.GetNamespace()
+	if p.enableInjection {
+		ro := obj.(*kates.Unstructured)
+		dlog.Infof(c, "Enabling traffic-agent injection for %s %s.%s", p.kind, name, namespace)
+		if err := ki.EnableRolloutInjection(c, ro); err != nil {
+			return err
 		}
-		if svc != nil {
-			if err := ki.Client().Update(c, svc, svc); err != nil {
-				return "", "", err
-			}
+		if userd_k8s.IsCanaryRollout(ro) {
+			ki.warn(c, canaryRolloutWarning(ro))
+		}
+	}
+	if p.restart {
+		if err := ki.rolloutRestart(c, obj); err != nil {
+			return err
 		}
-		if err := ki.waitForApply(c, namespace, name, obj); err != nil {
-			return "", "", err
+	}
+	if p.updatedObj == nil {
+		return nil
+	}
+	if p.missingWebhook {
+		ki.warn(c, missingWebhookWarning(obj, ki.GetManagerNamespace()))
+	}
+	if err := ki.Client().Update(c, p.updatedObj, p.updatedObj); err != nil {
+		return err
+	}
+	if p.updatedSvc != nil {
+		if err := ki.Client().Update(c, p.updatedSvc, p.updatedSvc); err != nil {
+			return err
 		}
 	}
+	return ki.waitForApply(c, namespace, name, p.updatedObj)
+}
 
-	if svc == nil {
-		// If the service is still nil, that's because an agent already exists that we can reuse.
-		// So we get the service from the deployments annotation so that we can extract the UID.
-		svc, err = ki.getSvcFromObjAnnotation(c, obj)
-		if err != nil {
-			return "", "", err
-		}
+// missingWebhookWarning explains why a workload that is annotated for injection of the traffic-agent is
+// patched with the traffic-agent anyway.
+func missingWebhookWarning(obj kates.Object, managerNamespace string) string {
+	return fmt.Sprintf("%s %s.%s is annotated for injection of the traffic-agent, but the traffic-manager's mutating "+
+		"webhook isn't installed in namespace %s, so the traffic-agent is added by patching the workload instead",
+		obj.GetObjectKind().GroupVersionKind().Kind, obj.GetName(), obj.GetNamespace(), managerNamespace)
+}
+
+// canaryRolloutWarning explains why a Rollout that uses a canary strategy doesn't get the traffic-agent in
+// all of its pods right away.
+func canaryRolloutWarning(ro kates.Object) string {
+	return fmt.Sprintf("Rollout %s.%s uses a canary strategy, so the traffic-agent is added to its pods "+
+		"as the new revision progresses. Promote the rollout to add the traffic-agent to all of its pods", ro.GetName(), ro.GetNamespace())
+}
+
+// servicePortIdentifier returns the name of the given service port, or its number if it has no name.
+func servicePortIdentifier(sp *kates.ServicePort) string {
+	if sp.Name != "" {
+		return sp.Name
 	}
-	return string(svc.GetUID()), kind, nil
+	return strconv.Itoa(int(sp.Port))
 }
 
 // The following <workload>Updated functions all contain the logic for
@@ -356,9 +679,13 @@ func statefulSetUpdated(statefulSet *kates.StatefulSet, origGeneration int64) bo
 	applied := statefulSet.ObjectMeta.Generation >= origGeneration &&
 		statefulSet.Status.ObservedGeneration == statefulSet.ObjectMeta.Generation &&
 		(statefulSet.Spec.Replicas == nil || statefulSet.Status.UpdatedReplicas >= *statefulSet.Spec.Replicas) &&
-		statefulSet.Status.UpdatedReplicas == statefulSet.Status.Replicas &&
-		statefulSet.Status.CurrentReplicas == statefulSet.Status.Replicas
-	return applied
+		statefulSet.Status.UpdatedReplicas == statefulSet.Status.Replicas
+	if statefulSet.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType {
+		// The current revision is never promoted when the OnDelete strategy is used, so the
+		// current replicas can't be trusted. The updated replicas must be ready instead.
+		return applied && statefulSet.Status.ReadyReplicas == statefulSet.Status.Replicas
+	}
+	return applied && statefulSet.Status.CurrentReplicas == statefulSet.Status.Replicas
 }
 
 func (ki *installer) waitForApply(c context.Context, namespace, name string, obj kates.Object) error {
@@ -372,15 +699,23 @@ func (ki *installer) waitForApply(c context.Context, namespace, name string, obj
 	}
 
 	var err error
-	if rs, ok := obj.(*kates.ReplicaSet); ok {
-		if err = ki.refreshReplicaSet(c, namespace, rs); err != nil {
+	switch obj := obj.(type) {
+	case *kates.ReplicaSet:
+		if err = ki.deleteOwnedPods(c, namespace, obj); err != nil {
 			return err
 		}
+	case *kates.StatefulSet:
+		if warning := onDeleteRestartWarning(obj); warning != "" {
+			ki.warn(c, warning)
+			if err = ki.deleteOwnedPods(c, namespace, obj); err != nil {
+				return err
+			}
+		}
 	}
 	for {
 		dtime.SleepWithContext(c, time.Second)
 		if err = c.Err(); err != nil {
-			return err
+			return ki.withDiagnostics(c, obj, err)
 		}
 
 		if obj, err = ki.FindAgain(c, obj); err != nil {
@@ -403,10 +738,27 @@ func (ki *installer) waitForApply(c context.Context, namespace, name string, obj
 	}
 }
 
-// refreshReplicaSet finds pods owned by a given ReplicaSet and deletes them.
+// withDiagnostics returns the given error with the diagnostics of the given workload appended, so that
+// the reason why its rollout doesn't complete is visible without inspecting the cluster.
+func (ki *installer) withDiagnostics(c context.Context, obj kates.Object, err error) error {
+	if obj == nil {
+		return err
+	}
+	// The given context has often timed out at this point
+	c, cancel := context.WithTimeout(dcontext.WithoutCancel(c), 5*time.Second)
+	defer cancel()
+	diagnostics, derr := install.WorkloadDiagnostics(c, ki.Client(), obj)
+	if derr != nil {
+		dlog.Debugf(c, "unable to collect the diagnostics of %s.%s: %v", obj.GetName(), obj.GetNamespace(), derr)
+	}
+	return install.WithDiagnostics(err, obj.GetNamespace(), diagnostics)
+}
+
+// deleteOwnedPods finds pods owned by a given ReplicaSet or StatefulSet and deletes them.
 // We need this because updating a Replica Set does *not* generate new
-// pods if the desired amount already exists.
-func (ki *installer) refreshReplicaSet(c context.Context, namespace string, rs *kates.ReplicaSet) error {
+// pods if the desired amount already exists, and neither does updating
+// a Stateful Set that uses the OnDelete update strategy.
+func (ki *installer) deleteOwnedPods(c context.Context, namespace string, owner kates.Object) error {
 	pods, err := ki.Pods(c, namespace)
 	if err != nil {
 		return err
@@ -414,8 +766,9 @@ func (ki *installer) refreshReplicaSet(c context.Context, namespace string, rs *
 
 	for _, pod := range pods {
 		for _, ownerRef := range pod.OwnerReferences {
-			if ownerRef.UID == rs.UID {
-				dlog.Infof(c, "Deleting pod %s.%s owned by rs %s", pod.Name, pod.Namespace, rs.Name)
+			if ownerRef.UID == owner.GetUID() {
+				dlog.Infof(c, "Deleting pod %s.%s owned by %s %s",
+					pod.Name, pod.Namespace, owner.GetObjectKind().GroupVersionKind().Kind, owner.GetName())
 				pod := &kates.Pod{
 					TypeMeta: kates.TypeMeta{
 						Kind: "Pod",
@@ -535,13 +888,182 @@ func undoServiceMods(c context.Context, svc *kates.Service) error {
 	return nil
 }
 
+// resolveContainerPort returns the name of the container of the given workload that the given port of the
+// service targets, and the number of that container's port. A named target port is resolved by looking up
+// the containerPort with that name, and a non-empty containerName selects the container when more than one
+// container declares the port.
+func resolveContainerPort(obj kates.Object, cns []kates.Container, portNameOrNumber, containerName string, svc *kates.Service) (string, int32, error) {
+	servicePort, container, containerPortIndex, err := install.FindMatchingPort(cns, portNameOrNumber, containerName, svc)
+	if err != nil {
+		return "", 0, errcat.User.New(install.ObjErrorf(obj, err.Error()))
+	}
+	switch {
+	case containerPortIndex >= 0:
+		return container.Name, container.Ports[containerPortIndex].ContainerPort, nil
+	case servicePort.TargetPort.Type == intstr.Int && servicePort.TargetPort.IntVal != 0:
+		return container.Name, servicePort.TargetPort.IntVal, nil
+	default:
+		return container.Name, servicePort.Port, nil
+	}
+}
+
+// injectedContainerPort returns the name of the container and the number of the port that the mutating
+// webhook intercepts when it injects the traffic-agent into the pods of the given pod template. The webhook
+// resolves them from the service port of the install.ServicePortAnnotation, without knowledge of the
+// requested port and container.
+func injectedContainerPort(obj kates.Object, podTemplate *kates.PodTemplateSpec, svc *kates.Service) (string, int32, error) {
+	return resolveContainerPort(obj, podTemplate.Spec.Containers, podTemplate.Annotations[install.ServicePortAnnotation], "", svc)
+}
+
+// checkIgnoredPort returns an error when the given port of the given container is one that the
+// install.IgnorePortsAnnotation of the pod template excludes from interception.
+func checkIgnoredPort(obj kates.Object, podTemplate *kates.PodTemplateSpec, containerName string, port int32) error {
+	agentConfig, err := (*install.AgentConfig)(nil).WithAnnotations(podTemplate.Annotations)
+	if err != nil {
+		return errcat.User.New(install.ObjErrorf(obj, "%v", err))
+	}
+	if agentConfig.IgnoresPort(port) {
+		return errcat.User.New(install.ObjErrorf(obj, "port %d of container %s can't be intercepted, because it's excluded by annotations[%q]",
+			port, containerName, install.IgnorePortsAnnotation))
+	}
+	return nil
+}
+
+// agentAppContainer returns the name and port number of the container that an installed traffic-agent
+// intercepts, as recorded by the given actions. The name is empty when it can't be determined.
+func agentAppContainer(actions *workloadActions, cns []kates.Container) (string, int32) {
+	if actions.AddTrafficAgent == nil {
+		return "", 0
+	}
+	port := int32(actions.AddTrafficAgent.ContainerPortNumber)
+	if hcp := actions.HideContainerPort; hcp != nil {
+		return hcp.ContainerName, port
+	}
+	for i := range cns {
+		cn := &cns[i]
+		if cn.Name == install.AgentContainerName {
+			continue
+		}
+		for _, cp := range cn.Ports {
+			if cp.ContainerPort == port {
+				return cn.Name, port
+			}
+		}
+	}
+	return "", port
+}
+
+// agentPort is a port of a service that the traffic-agent takes over, together with the container port
+// that the service port targets.
+type agentPort struct {
+	servicePort *kates.ServicePort
+	container   *kates.Container
+
+	// The container port. If the existing container port doesn't have a name, we'll make one up.
+	name     string
+	number   uint16
+	protocol corev1.Protocol
+
+	// svcHasTargetPort is false when the service port relies on that its targetPort defaults to its port
+	svcHasTargetPort bool
+
+	// usedContainerName is true when the name was taken from the container port
+	usedContainerName bool
+}
+
+// findAgentPort resolves the container port that the given port of the service targets.
+func findAgentPort(object kates.Object, cns []kates.Container, portNameOrNumber, containerName string, matchingService *kates.Service) (*agentPort, error) {
+	servicePort, container, containerPortIndex, err := install.FindMatchingPort(cns, portNameOrNumber, containerName, matchingService)
+	if err != nil {
+		return nil, install.ObjErrorf(object, err.Error())
+	}
+	ap := &agentPort{servicePort: servicePort, container: container, svcHasTargetPort: true}
+
+	// Start by filling from the servicePort; if these are the zero values, that's OK.
+	if servicePort.TargetPort.Type == intstr.Int {
+		if servicePort.TargetPort.IntVal == 0 {
+			ap.number = uint16(servicePort.Port)
+			ap.svcHasTargetPort = false
+		} else {
+			ap.number = uint16(servicePort.TargetPort.IntVal)
+		}
+	} else {
+		ap.name = servicePort.TargetPort.StrVal
+	}
+	ap.protocol = servicePort.Protocol
+
+	// Now fill from the Deployment's containerPort.
+	if containerPortIndex >= 0 {
+		if ap.name == "" {
+			ap.name = container.Ports[containerPortIndex].Name
+			if ap.name != "" {
+				ap.usedContainerName = true
+			}
+		}
+		if ap.number == 0 {
+			ap.number = uint16(container.Ports[containerPortIndex].ContainerPort)
+		}
+		if ap.protocol == "" {
+			ap.protocol = container.Ports[containerPortIndex].Protocol
+		}
+	}
+	if ap.number == 0 {
+		return nil, install.ObjErrorf(object, "unable to add: the container port cannot be determined")
+	}
+	if ap.name == "" {
+		ap.name = fmt.Sprintf("tx-%d", ap.number)
+	}
+	return ap, nil
+}
+
+// svcPortAction returns the action that makes the service port target the traffic-agent by the symbolic
+// name of the container port, or nil if the service port already refers to the container port by name.
+func (ap *agentPort) svcPortAction(appProtocol string) partialAction {
+	if ap.servicePort.TargetPort.Type != intstr.Int {
+		return nil
+	}
+	mps := makePortSymbolicAction{
+		PortName:     ap.servicePort.Name,
+		TargetPort:   ap.number,
+		SymbolicName: ap.name,
+		AppProtocol:  appProtocol,
+	}
+	if ap.svcHasTargetPort {
+		return &mps
+	}
+	return &addSymbolicPortAction{mps}
+}
+
+// hideAction returns the action that hides the named container port from the service, so that the service
+// port targets the traffic-agent instead, or nil if the container port needn't be hidden. The ordinal
+// separates the hidden names of the ports of a workload.
+func (ap *agentPort) hideAction(ordinal int) *hideContainerPortAction {
+	// A service that refers to the port by number is changed to refer to it by name. If that name came
+	// from the container, then we need to hide it. A service that refers to the port by name targets the
+	// traffic-agent once the port in the container is hidden.
+	if ap.servicePort.TargetPort.Type == intstr.Int && !ap.usedContainerName {
+		return nil
+	}
+	return &hideContainerPortAction{
+		ContainerName: ap.container.Name,
+		PortName:      ap.name,
+		ordinal:       ordinal,
+	}
+}
+
 // addAgentToWorkload takes a given workload object and a service and
 // determines which container + port to use for an intercept. It also
-// prepares and performs modifications to the obj and/or service.
+// prepares and performs modifications to the obj and/or service. The
+// traffic-agent also takes over the container ports that the extraPorts
+// of the service target.
 func addAgentToWorkload(
 	c context.Context,
 	portNameOrNumber string,
+	extraPorts []string,
+	containerName string,
 	agentImageName string,
+	pullSecrets []string,
+	defaultAgentConfig *install.AgentConfig,
 	trafficManagerNamespace string,
 	object kates.Object, matchingService *kates.Service,
 ) (
@@ -554,73 +1076,50 @@ func addAgentToWorkload(
 		return nil, nil, err
 	}
 
+	agentConfig, err := defaultAgentConfig.WithAnnotations(podTemplate.Annotations)
+	if err != nil {
+		return nil, nil, errcat.User.New(install.ObjErrorf(object, "%v", err))
+	}
+
 	cns := podTemplate.Spec.Containers
-	servicePort, container, containerPortIndex, err := install.FindMatchingPort(cns, portNameOrNumber, matchingService)
+	containerPort, err := findAgentPort(object, cns, portNameOrNumber, containerName, matchingService)
 	if err != nil {
-		return nil, nil, install.ObjErrorf(object, err.Error())
+		return nil, nil, err
 	}
+	servicePort := containerPort.servicePort
+	container := containerPort.container
 	dlog.Debugf(c, "using service %q port %q when intercepting %s %q",
 		matchingService.Name,
-		func() string {
-			if servicePort.Name != "" {
-				return servicePort.Name
-			}
-			return strconv.Itoa(int(servicePort.Port))
-		}(),
+		servicePortIdentifier(servicePort),
 		object.GetObjectKind().GroupVersionKind().Kind,
 		object.GetName())
 
-	version := client.Semver().String()
-
-	// Try to detect the container port we'll be taking over.
-	var containerPort struct {
-		Name     string // If the existing container port doesn't have a name, we'll make one up.
-		Number   uint16
-		Protocol corev1.Protocol
-	}
-
-	// Start by filling from the servicePort; if these are the zero values, that's OK.
-	svcHasTargetPort := true
-	if servicePort.TargetPort.Type == intstr.Int {
-		if servicePort.TargetPort.IntVal == 0 {
-			containerPort.Number = uint16(servicePort.Port)
-			svcHasTargetPort = false
-		} else {
-			containerPort.Number = uint16(servicePort.TargetPort.IntVal)
-		}
-	} else {
-		containerPort.Name = servicePort.TargetPort.StrVal
-	}
-	containerPort.Protocol = servicePort.Protocol
-
-	// Now fill from the Deployment's containerPort.
-	usedContainerName := false
-	if containerPortIndex >= 0 {
-		if containerPort.Name == "" {
-			containerPort.Name = container.Ports[containerPortIndex].Name
-			if containerPort.Name != "" {
-				usedContainerName = true
-			}
-		}
-		if containerPort.Number == 0 {
-			containerPort.Number = uint16(container.Ports[containerPortIndex].ContainerPort)
+	extraContainerPorts := make([]*agentPort, len(extraPorts))
+	used := map[uint16]string{containerPort.number: servicePortIdentifier(servicePort)}
+	for i, extraPort := range extraPorts {
+		ecp, err := findAgentPort(object, cns, extraPort, containerName, matchingService)
+		if err != nil {
+			return nil, nil, err
 		}
-		if containerPort.Protocol == "" {
-			containerPort.Protocol = container.Ports[containerPortIndex].Protocol
+		if other, ok := used[ecp.number]; ok {
+			return nil, nil, errcat.User.New(install.ObjErrorf(object,
+				"service ports %q and %q both target container port %d", other, extraPort, ecp.number))
 		}
+		used[ecp.number] = extraPort
+		extraContainerPorts[i] = ecp
 	}
-	if containerPort.Number == 0 {
-		return nil, nil, install.ObjErrorf(object, "unable to add: the container port cannot be determined")
-	}
-	if containerPort.Name == "" {
-		containerPort.Name = fmt.Sprintf("tx-%d", containerPort.Number)
+	if len(extraPorts) > 0 && matchingService.Spec.ClusterIP == "None" {
+		return nil, nil, errcat.User.New(install.ObjErrorf(object,
+			"service %s is headless, and the traffic-agent of a headless service intercepts one port only", matchingService.Name))
 	}
 
+	version := client.Semver().String()
+
 	var initContainerAction *addInitContainerAction
 	if matchingService.Spec.ClusterIP == "None" {
 		initContainerAction = &addInitContainerAction{
-			AppPortProto:  containerPort.Protocol,
-			AppPortNumber: containerPort.Number,
+			AppPortProto:  containerPort.protocol,
+			AppPortNumber: containerPort.number,
 			ImageName:     agentImageName,
 		}
 	}
@@ -635,49 +1134,50 @@ func addAgentToWorkload(
 		AddTrafficAgent: &addTrafficAgentAction{
 			containerName:           container.Name,
 			trafficManagerNamespace: trafficManagerNamespace,
-			ContainerPortName:       containerPort.Name,
-			ContainerPortProto:      containerPort.Protocol,
-			ContainerPortNumber:     containerPort.Number,
+			agentConfig:             agentConfig,
+			ContainerPortName:       containerPort.name,
+			ContainerPortProto:      containerPort.protocol,
+			ContainerPortNumber:     containerPort.number,
 			ImageName:               agentImageName,
+			TmpVolume:               agentConfig.ReadOnlyRootFilesystem(),
 		},
 	}
+	if missing := install.MissingPullSecrets(podTemplate.Spec.ImagePullSecrets, pullSecrets); len(missing) > 0 {
+		workloadMod.AddPullSecrets = &addPullSecretsAction{Names: missing}
+	}
 	// Depending on whether the Service refers to the port by name or by number, we either need
 	// to patch the names in the deployment, or the number in the service.
 	var serviceMod *svcActions
-	if servicePort.TargetPort.Type == intstr.Int {
-		// Change the port number that the Service refers to.
-		serviceMod = &svcActions{Version: version}
-		if svcHasTargetPort {
-			serviceMod.MakePortSymbolic = &makePortSymbolicAction{
-				PortName:     servicePort.Name,
-				TargetPort:   containerPort.Number,
-				SymbolicName: containerPort.Name,
-			}
-		} else {
-			serviceMod.AddSymbolicPort = &addSymbolicPortAction{
-				makePortSymbolicAction{
-					PortName:     servicePort.Name,
-					TargetPort:   containerPort.Number,
-					SymbolicName: containerPort.Name,
-				},
+	switch a := containerPort.svcPortAction(agentConfig.AppProtocol).(type) {
+	case *makePortSymbolicAction:
+		serviceMod = &svcActions{Version: version, MakePortSymbolic: a}
+	case *addSymbolicPortAction:
+		serviceMod = &svcActions{Version: version, AddSymbolicPort: a}
+	}
+	workloadMod.HideContainerPort = containerPort.hideAction(0)
+
+	for i, ecp := range extraContainerPorts {
+		workloadMod.AddTrafficAgent.ExtraPorts = append(workloadMod.AddTrafficAgent.ExtraPorts, &agentExtraPort{
+			ServicePort:         strconv.Itoa(int(ecp.servicePort.Port)),
+			ServicePortName:     ecp.servicePort.Name,
+			ContainerPortName:   ecp.name,
+			ContainerPortProto:  ecp.protocol,
+			ContainerPortNumber: ecp.number,
+			AgentPort:           uint16(firstExtraAgentPort + i),
+		})
+		if a := ecp.svcPortAction(agentConfig.AppProtocol); a != nil {
+			if serviceMod == nil {
+				serviceMod = &svcActions{Version: version}
 			}
-		}
-		// Since we are updating the service to use the containerPort.Name
-		// if that value came from the container, then we need to hide it
-		// since the service is using the targetPort's int.
-		if usedContainerName {
-			workloadMod.HideContainerPort = &hideContainerPortAction{
-				ContainerName: container.Name,
-				PortName:      containerPort.Name,
-				ordinal:       0,
+			switch a := a.(type) {
+			case *makePortSymbolicAction:
+				serviceMod.MakeExtraPortsSymbolic = append(serviceMod.MakeExtraPortsSymbolic, a)
+			case *addSymbolicPortAction:
+				serviceMod.AddExtraSymbolicPorts = append(serviceMod.AddExtraSymbolicPorts, a)
 			}
 		}
-	} else {
-		// Hijack the port name in the Deployment.
-		workloadMod.HideContainerPort = &hideContainerPortAction{
-			ContainerName: container.Name,
-			PortName:      containerPort.Name,
-			ordinal:       0,
+		if a := ecp.hideAction(i + 1); a != nil {
+			workloadMod.HideExtraContainerPorts = append(workloadMod.HideExtraContainerPorts, a)
 		}
 	}
 
@@ -716,6 +1216,10 @@ func addAgentToWorkload(
 	return object, matchingService, nil
 }
 
+// ensureManager installs or upgrades the traffic-manager. The diagnostics of its deployment are collected
+// during the install, because a failed install is rolled back.
 func (ki *installer) ensureManager(c context.Context) error {
-	return helm.EnsureTrafficManager(c, ki.ConfigFlags, ki.Client(), ki.GetManagerNamespace())
+	dw := install.WatchDiagnostics(c, ki.Client(), "Deployment", ki.GetManagerNamespace(), install.ManagerAppName)
+	err := helm.EnsureTrafficManager(c, ki.ConfigFlags, ki.Client(), ki.GetManagerNamespace(), ki.managerValues)
+	return dw.Stop(c, err)
 }
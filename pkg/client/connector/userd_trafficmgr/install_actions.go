@@ -158,6 +158,11 @@ type makePortSymbolicAction struct {
 	PortName     string
 	TargetPort   uint16
 	SymbolicName string
+
+	// AppProtocol is the appProtocol that the port declares while it targets the traffic-agent, and
+	// OrigAppProtocol the one that it declared before. Both are empty when the appProtocol isn't changed.
+	AppProtocol     string  `json:"app_protocol,omitempty"`
+	OrigAppProtocol *string `json:"orig_app_protocol,omitempty"`
 }
 
 var _ partialAction = (*makePortSymbolicAction)(nil)
@@ -187,12 +192,37 @@ func (m *makePortSymbolicAction) Do(svc kates.Object) error {
 		return err
 	}
 	p.TargetPort = intstr.FromString(m.SymbolicName)
+	m.setAppProtocol(p)
 	return nil
 }
 
+// setAppProtocol sets the AppProtocol of the given port, and records the one that it replaces.
+func (m *makePortSymbolicAction) setAppProtocol(p *kates.ServicePort) {
+	if m.AppProtocol == "" {
+		return
+	}
+	m.OrigAppProtocol = p.AppProtocol
+	ap := m.AppProtocol
+	p.AppProtocol = &ap
+}
+
+// restoreAppProtocol restores the AppProtocol that the given port declared before setAppProtocol was called.
+func (m *makePortSymbolicAction) restoreAppProtocol(p *kates.ServicePort) {
+	if m.AppProtocol != "" {
+		p.AppProtocol = m.OrigAppProtocol
+	}
+}
+
+func (m *makePortSymbolicAction) explainAppProtocol(out io.Writer) {
+	if m.AppProtocol != "" {
+		fmt.Fprintf(out, " and appProtocol %q", m.AppProtocol)
+	}
+}
+
 func (m *makePortSymbolicAction) ExplainDo(_ kates.Object, out io.Writer) {
 	fmt.Fprintf(out, "make service port %s symbolic with name %q",
 		m.portName(strconv.Itoa(int(m.TargetPort))), m.SymbolicName)
+	m.explainAppProtocol(out)
 }
 
 func (m *makePortSymbolicAction) ExplainUndo(_ kates.Object, out io.Writer) {
@@ -211,6 +241,7 @@ func (m *makePortSymbolicAction) Undo(ver semver.Version, svc kates.Object) erro
 		return install.NewAlreadyUndone(err, "symbolic port has already been removed")
 	}
 	p.TargetPort = intstr.FromInt(int(m.TargetPort))
+	m.restoreAppProtocol(p)
 	return nil
 }
 
@@ -240,6 +271,7 @@ func (m *addSymbolicPortAction) getPort(svc kates.Object, targetPort int32) (*ka
 func (m *addSymbolicPortAction) ExplainDo(_ kates.Object, out io.Writer) {
 	fmt.Fprintf(out, "add targetPort to service port %s symbolic with name %q",
 		m.portName(strconv.Itoa(int(m.TargetPort))), m.SymbolicName)
+	m.explainAppProtocol(out)
 }
 
 func (m *addSymbolicPortAction) Do(svc kates.Object) error {
@@ -248,6 +280,7 @@ func (m *addSymbolicPortAction) Do(svc kates.Object) error {
 		return err
 	}
 	p.TargetPort = intstr.FromString(m.SymbolicName)
+	m.setAppProtocol(p)
 	return nil
 }
 
@@ -261,6 +294,7 @@ func (m *addSymbolicPortAction) Undo(ver semver.Version, svc kates.Object) error
 		return install.NewAlreadyUndone(err, "symbolic port has already been removed")
 	}
 	p.TargetPort = intstr.IntOrString{}
+	m.restoreAppProtocol(p)
 	return nil
 }
 
@@ -270,6 +304,10 @@ type svcActions struct {
 	Version          string                  `json:"version"`
 	MakePortSymbolic *makePortSymbolicAction `json:"make_port_symbolic,omitempty"`
 	AddSymbolicPort  *addSymbolicPortAction  `json:"add_symbolic_port,omitempty"`
+
+	// The actions on the other ports of the service that the traffic-agent intercepts
+	MakeExtraPortsSymbolic []*makePortSymbolicAction `json:"make_extra_ports_symbolic,omitempty"`
+	AddExtraSymbolicPorts  []*addSymbolicPortAction  `json:"add_extra_symbolic_ports,omitempty"`
 }
 
 var _ completeAction = (*svcActions)(nil)
@@ -281,6 +319,12 @@ func (s *svcActions) actions() (actions multiAction) {
 	if s.AddSymbolicPort != nil {
 		actions = append(actions, s.AddSymbolicPort)
 	}
+	for _, a := range s.MakeExtraPortsSymbolic {
+		actions = append(actions, a)
+	}
+	for _, a := range s.AddExtraSymbolicPorts {
+		actions = append(actions, a)
+	}
 	return actions
 }
 
@@ -333,15 +377,44 @@ type addTrafficAgentAction struct {
 	// The image name of the agent to add
 	ImageName string `json:"image_name"`
 
+	// TmpVolume is true when the agent's root filesystem is read-only, so that a volume must be added
+	// for the agent to write to.
+	TmpVolume bool `json:"tmp_volume,omitempty"`
+
+	// ExtraPorts are the other container ports that the agent takes over, one for each additional port
+	// of the service that is intercepted.
+	ExtraPorts []*agentExtraPort `json:"extra_ports,omitempty"`
+
 	// The name of the app container. Not exported because its not needed for undo.
 	containerName string
 
 	// The name of the namespace where the traffic manager that "owns" this agent is to be found.
 	trafficManagerNamespace string
+
+	// The configuration of the agent container. Not exported because its not needed for undo.
+	agentConfig *install.AgentConfig
 }
 
 var _ partialAction = (*addTrafficAgentAction)(nil)
 
+// agentExtraPort is a container port that the traffic-agent takes over in addition to the one of the
+// addTrafficAgentAction. The agent listens to the AgentPort, and forwards what isn't intercepted to
+// the ContainerPortNumber.
+type agentExtraPort struct {
+	// The number and name of the service port that targets the container port
+	ServicePort     string `json:"service_port"`
+	ServicePortName string `json:"service_port_name,omitempty"`
+
+	ContainerPortName   string          `json:"container_port_name"`
+	ContainerPortProto  corev1.Protocol `json:"container_port_proto"`
+	ContainerPortNumber uint16          `json:"app_port"`
+	AgentPort           uint16          `json:"agent_port"`
+}
+
+// firstExtraAgentPort is the port that the traffic-agent listens to for the first of its extra ports. The
+// ports that follow use the numbers after it.
+const firstExtraAgentPort = 9901
+
 func (ata *addTrafficAgentAction) appContainer(cns []kates.Container) *kates.Container {
 	for i := range cns {
 		cn := &cns[i]
@@ -366,24 +439,55 @@ func (ata *addTrafficAgentAction) Do(obj kates.Object) error {
 	// Under some odd circumstances, the agent volume can be left over after an uninstall.
 	// Drop it if we get here and it's present, since it'll cause errors.
 	// We ignore the error from this since we don't care if the volume isn't already present
-	_ = ata.dropAgentAnnotationVolume(obj, tplSpec)
+	_ = dropVolume(obj, tplSpec, install.AgentAnnotationVolumeName)
 
 	tplSpec.Spec.Volumes = append(tplSpec.Spec.Volumes, install.AgentVolume())
-	tplSpec.Spec.Containers = append(tplSpec.Spec.Containers,
-		install.AgentContainer(
-			obj.GetName(),
-			ata.ImageName,
-			appContainer,
-			corev1.ContainerPort{
-				Name:          ata.ContainerPortName,
-				Protocol:      ata.ContainerPortProto,
-				ContainerPort: 9900,
-			},
-			int(ata.ContainerPortNumber),
-			ata.trafficManagerNamespace))
+	if ata.TmpVolume {
+		_ = dropVolume(obj, tplSpec, install.AgentTmpVolumeName)
+		tplSpec.Spec.Volumes = append(tplSpec.Spec.Volumes, install.AgentTmpVolume())
+	}
+	agentContainer := install.AgentContainer(
+		obj.GetName(),
+		ata.ImageName,
+		appContainer,
+		corev1.ContainerPort{
+			Name:          ata.ContainerPortName,
+			Protocol:      ata.ContainerPortProto,
+			ContainerPort: 9900,
+		},
+		int(ata.ContainerPortNumber),
+		ata.trafficManagerNamespace,
+		ata.agentConfig)
+	if len(ata.ExtraPorts) > 0 {
+		extraPorts := make([]string, len(ata.ExtraPorts))
+		for i, ep := range ata.ExtraPorts {
+			agentContainer.Ports = append(agentContainer.Ports, corev1.ContainerPort{
+				Name:          ep.ContainerPortName,
+				Protocol:      ep.ContainerPortProto,
+				ContainerPort: int32(ep.AgentPort),
+			})
+			extraPorts[i] = fmt.Sprintf("%d=%d", ep.AgentPort, ep.ContainerPortNumber)
+		}
+		agentContainer.Env = append(agentContainer.Env, corev1.EnvVar{
+			Name:  install.EnvPrefix + "EXTRA_PORTS",
+			Value: strings.Join(extraPorts, ","),
+		})
+	}
+	tplSpec.Spec.Containers = append(tplSpec.Spec.Containers, agentContainer)
 	return nil
 }
 
+// extraContainerPort returns the number of the container port that the given service port, identified by its
+// name or number, targets when it's one of the ExtraPorts, and false when it isn't.
+func (ata *addTrafficAgentAction) extraContainerPort(portNameOrNumber string) (int32, bool) {
+	for _, ep := range ata.ExtraPorts {
+		if portNameOrNumber == ep.ServicePort || portNameOrNumber == ep.ServicePortName {
+			return int32(ep.ContainerPortNumber), true
+		}
+	}
+	return 0, false
+}
+
 func (ata *addTrafficAgentAction) ExplainDo(_ kates.Object, out io.Writer) {
 	fmt.Fprintf(out, "add traffic-agent container with image %s", ata.ImageName)
 }
@@ -407,17 +511,18 @@ func (ata *addTrafficAgentAction) IsDone(obj kates.Object) bool {
 	return false
 }
 
-func (ata *addTrafficAgentAction) dropAgentAnnotationVolume(obj kates.Object, tplSpec *corev1.PodTemplateSpec) error {
+// dropVolume removes the volume with the given name from the given pod template spec.
+func dropVolume(obj kates.Object, tplSpec *corev1.PodTemplateSpec, name string) error {
 	volumeIdx := -1
 	for i := range tplSpec.Spec.Volumes {
-		if tplSpec.Spec.Volumes[i].Name == install.AgentAnnotationVolumeName {
+		if tplSpec.Spec.Volumes[i].Name == name {
 			volumeIdx = i
 			break
 		}
 	}
 
 	if volumeIdx < 0 {
-		return install.NewAlreadyUndone(install.ObjErrorf(obj, "does not contain a %q volume", install.AgentAnnotationVolumeName), "cannot delete volume")
+		return install.NewAlreadyUndone(install.ObjErrorf(obj, "does not contain a %q volume", name), "cannot delete volume")
 	}
 	if len(tplSpec.Spec.Volumes) == 1 {
 		tplSpec.Spec.Volumes = nil
@@ -446,11 +551,16 @@ func (ata *addTrafficAgentAction) Undo(ver semver.Version, obj kates.Object) err
 	tplSpec.Spec.Containers = append(tplSpec.Spec.Containers[:containerIdx], tplSpec.Spec.Containers[containerIdx+1:]...)
 
 	if ver.GE(semver.MustParse("2.1.5")) {
-		err := ata.dropAgentAnnotationVolume(obj, tplSpec)
+		err := dropVolume(obj, tplSpec, install.AgentAnnotationVolumeName)
 		if err != nil {
 			return err
 		}
 	}
+	if ata.TmpVolume {
+		if err := dropVolume(obj, tplSpec, install.AgentTmpVolumeName); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -540,6 +650,68 @@ func (ica *addInitContainerAction) Undo(ver semver.Version, obj kates.Object) er
 	return nil
 }
 
+// addPullSecretsAction ////////////////////////////////////////////////////////
+
+// addPullSecretsAction is a partialAction that adds the image pull secrets of the traffic-agent to a pod
+// template spec.
+type addPullSecretsAction struct {
+	// The names of the secrets to add. Secrets that the pod template spec already declares are not included,
+	// so that they are retained on undo.
+	Names []string `json:"names"`
+}
+
+var _ partialAction = (*addPullSecretsAction)(nil)
+
+func (aps *addPullSecretsAction) Do(obj kates.Object) error {
+	tplSpec, err := install.GetPodTemplateFromObject(obj)
+	if err != nil {
+		return err
+	}
+	for _, name := range install.MissingPullSecrets(tplSpec.Spec.ImagePullSecrets, aps.Names) {
+		tplSpec.Spec.ImagePullSecrets = append(tplSpec.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+	}
+	return nil
+}
+
+func (aps *addPullSecretsAction) ExplainDo(_ kates.Object, out io.Writer) {
+	fmt.Fprintf(out, "add image pull secrets %s", strings.Join(aps.Names, ", "))
+}
+
+func (aps *addPullSecretsAction) ExplainUndo(_ kates.Object, out io.Writer) {
+	fmt.Fprintf(out, "remove image pull secrets %s", strings.Join(aps.Names, ", "))
+}
+
+func (aps *addPullSecretsAction) IsDone(obj kates.Object) bool {
+	tplSpec, err := install.GetPodTemplateFromObject(obj)
+	if err != nil {
+		return false
+	}
+	return len(install.MissingPullSecrets(tplSpec.Spec.ImagePullSecrets, aps.Names)) == 0
+}
+
+func (aps *addPullSecretsAction) Undo(_ semver.Version, obj kates.Object) error {
+	tplSpec, err := install.GetPodTemplateFromObject(obj)
+	if err != nil {
+		return err
+	}
+	remove := make(map[string]bool, len(aps.Names))
+	for _, name := range aps.Names {
+		remove[name] = true
+	}
+	var kept []corev1.LocalObjectReference
+	for _, ps := range tplSpec.Spec.ImagePullSecrets {
+		if !remove[ps.Name] {
+			kept = append(kept, ps)
+		}
+	}
+	if len(kept) == len(tplSpec.Spec.ImagePullSecrets) {
+		return install.NewAlreadyUndone(install.ObjErrorf(obj, "does not contain the image pull secrets %s", strings.Join(aps.Names, ", ")),
+			"cannot undo image pull secrets")
+	}
+	tplSpec.Spec.ImagePullSecrets = kept
+	return nil
+}
+
 // hideContainerPortAction /////////////////////////////////////////////////////
 
 // A hideContainerPortAction will replace the symbolic name of a container port
@@ -553,7 +725,7 @@ type hideContainerPortAction struct {
 
 	// ordinal is only used for avoiding ambiguities when generating the HiddenName. It
 	// is the zero based order of all hideContainerPortAction instances for a workload.
-	// Right now we only use one port so this is always zero.
+	// It's zero for the intercepted port, and the ports that follow are the extra ports.
 	ordinal int
 }
 
@@ -648,6 +820,10 @@ type workloadActions struct {
 	HideContainerPort         *hideContainerPortAction `json:"hide_container_port,omitempty"`
 	AddTrafficAgent           *addTrafficAgentAction   `json:"add_traffic_agent,omitempty"`
 	AddInitContainer          *addInitContainerAction  `json:"add_init_container,omitempty"`
+	AddPullSecrets            *addPullSecretsAction    `json:"add_pull_secrets,omitempty"`
+
+	// HideExtraContainerPorts hide the container ports of the ExtraPorts of the AddTrafficAgent action
+	HideExtraContainerPorts []*hideContainerPortAction `json:"hide_extra_container_ports,omitempty"`
 }
 
 var _ completeAction = (*workloadActions)(nil)
@@ -656,12 +832,18 @@ func (d *workloadActions) actions() (actions multiAction) {
 	if d.HideContainerPort != nil {
 		actions = append(actions, d.HideContainerPort)
 	}
+	for _, a := range d.HideExtraContainerPorts {
+		actions = append(actions, a)
+	}
 	if d.AddTrafficAgent != nil {
 		actions = append(actions, d.AddTrafficAgent)
 	}
 	if d.AddInitContainer != nil {
 		actions = append(actions, d.AddInitContainer)
 	}
+	if d.AddPullSecrets != nil {
+		actions = append(actions, d.AddPullSecrets)
+	}
 	return actions
 }
 
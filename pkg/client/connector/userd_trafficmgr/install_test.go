@@ -15,9 +15,14 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/clientcmd/api"
 	"sigs.k8s.io/yaml"
@@ -29,6 +34,7 @@ import (
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_k8s"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
 	"github.com/telepresenceio/telepresence/v2/pkg/install"
 	"github.com/telepresenceio/telepresence/v2/pkg/version"
@@ -142,7 +148,7 @@ func (is *installSuite) Test_findTrafficManager_notPresent() {
 	require.NoError(err)
 	kc, err := userd_k8s.NewCluster(ctx, cfgAndFlags, nil, userd_k8s.Callbacks{})
 	require.NoError(err)
-	ti, err := newTrafficManagerInstaller(kc)
+	ti, err := newTrafficManagerInstaller(kc, nil)
 	require.NoError(err)
 	version.Version = "v0.0.0-bogus"
 
@@ -185,7 +191,7 @@ func (is *installSuite) Test_ensureTrafficManager_toleratesFailedInstall() {
 
 	defer is.removeManager(is.managerNamespace)
 
-	ti, err := newTrafficManagerInstaller(kc)
+	ti, err := newTrafficManagerInstaller(kc, nil)
 	require.NoError(err)
 
 	require.Error(ti.ensureManager(ctx))
@@ -206,7 +212,7 @@ func (is *installSuite) Test_ensureTrafficManager_toleratesLeftoverState() {
 	kc, err := userd_k8s.NewCluster(ctx, cfgAndFlags, nil, userd_k8s.Callbacks{})
 	require.NoError(err)
 
-	ti, err := newTrafficManagerInstaller(kc)
+	ti, err := newTrafficManagerInstaller(kc, nil)
 	require.NoError(err)
 
 	require.NoError(ti.ensureManager(ctx))
@@ -233,16 +239,16 @@ func (is *installSuite) Test_ensureTrafficManager_canUninstall() {
 	kc, err := userd_k8s.NewCluster(ctx, cfgAndFlags, nil, userd_k8s.Callbacks{})
 	require.NoError(err)
 
-	ti, err := newTrafficManagerInstaller(kc)
+	ti, err := newTrafficManagerInstaller(kc, nil)
 	require.NoError(err)
 
 	require.NoError(ti.ensureManager(ctx))
-	require.NoError(ti.removeManagerAndAgents(ctx, false, []*manager.AgentInfo{}))
+	require.NoError(removalsError(ti.removeManagerAndAgents(ctx, false, []*manager.AgentInfo{})))
 	// We want to make sure that we can re-install the agent after it's been uninstalled,
 	// so try to ensureManager again.
 	require.NoError(ti.ensureManager(ctx))
 	// Uninstall the agent one last time -- this should behave the same way as the previous uninstall
-	require.NoError(ti.removeManagerAndAgents(ctx, false, []*manager.AgentInfo{}))
+	require.NoError(removalsError(ti.removeManagerAndAgents(ctx, false, []*manager.AgentInfo{})))
 }
 
 func (is *installSuite) Test_ensureTrafficManager_upgrades() {
@@ -254,7 +260,7 @@ func (is *installSuite) Test_ensureTrafficManager_upgrades() {
 	kc, err := userd_k8s.NewCluster(ctx, cfgAndFlags, nil, userd_k8s.Callbacks{})
 	require.NoError(err)
 
-	ti, err := newTrafficManagerInstaller(kc)
+	ti, err := newTrafficManagerInstaller(kc, nil)
 	require.NoError(err)
 
 	require.NoError(ti.ensureManager(ctx))
@@ -302,7 +308,7 @@ func (is *installSuite) Test_ensureTrafficManager_doesNotChangeExistingHelm() {
 
 	defer is.removeManager(is.managerNamespace)
 
-	ti, err := newTrafficManagerInstaller(kc)
+	ti, err := newTrafficManagerInstaller(kc, nil)
 	require.NoError(err)
 
 	require.NoError(ti.ensureManager(ctx))
@@ -355,7 +361,7 @@ func (is *installSuite) Test_ensureTrafficManager_notPresent() {
 	require.NoError(err)
 	kc, err := userd_k8s.NewCluster(ctx, cfgAndFlags, nil, userd_k8s.Callbacks{})
 	require.NoError(err)
-	ti, err := newTrafficManagerInstaller(kc)
+	ti, err := newTrafficManagerInstaller(kc, nil)
 	require.NoError(err)
 	require.NoError(ti.ensureManager(ctx))
 }
@@ -397,7 +403,7 @@ func (is *installSuite) findTrafficManagerPresent(namespace string) {
 	defer waitCancel()
 
 	require.NoError(kc.WaitUntilReady(waitCtx))
-	ti, err := newTrafficManagerInstaller(kc)
+	ti, err := newTrafficManagerInstaller(kc, nil)
 	require.NoError(err)
 	require.NoError(ti.ensureManager(c))
 	require.Eventually(func() bool {
@@ -513,7 +519,11 @@ func TestAddAgentToWorkload(t *testing.T) {
 
 				actualWrk, actualSvc, actualErr := addAgentToWorkload(ctx,
 					tc.InputPortName,
+					nil,
+					"",
 					managerImageName(ctx), // ignore extensions
+					nil,
+					nil,
 					env.ManagerNamespace,
 					deepCopyObject(tc.InputWorkload),
 					tc.InputService.DeepCopy(),
@@ -588,6 +598,443 @@ func TestAddAgentToWorkload(t *testing.T) {
 	}
 }
 
+func Test_checkAgentManagerNamespace(t *testing.T) {
+	dep := &kates.Deployment{
+		TypeMeta:   metav1.TypeMeta{Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "default"},
+	}
+	agent := func(env ...kates.EnvVar) *kates.Container {
+		return &kates.Container{Name: install.AgentContainerName, Env: env}
+	}
+	managerHost := func(ns string) kates.EnvVar {
+		return kates.EnvVar{Name: install.EnvPrefix + "MANAGER_HOST", Value: install.ManagerAppName + "." + ns}
+	}
+
+	assert.NoError(t, checkAgentManagerNamespace(dep, agent(managerHost("team-tp")), "team-tp"))
+
+	// Agents of older versions might not declare the manager host
+	assert.NoError(t, checkAgentManagerNamespace(dep, agent(), "team-tp"))
+
+	err := checkAgentManagerNamespace(dep, agent(managerHost("ambassador")), "team-tp")
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+	assert.Contains(t, err.Error(), `name="echo" namespace="default"`)
+	assert.Contains(t, err.Error(), "belongs to the traffic-manager in namespace ambassador")
+	assert.Contains(t, err.Error(), "--manager-namespace ambassador")
+}
+
+func Test_missingWebhookError(t *testing.T) {
+	dep := &kates.Deployment{
+		TypeMeta:   metav1.TypeMeta{Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "default"},
+	}
+	ro := &kates.Unstructured{}
+	ro.SetKind(userd_k8s.RolloutKind)
+	ro.SetName("web")
+	ro.SetNamespace("default")
+
+	// The intercept fails by default
+	for _, missingWebhook := range []string{"", client.MissingWebhookError} {
+		err := missingWebhookError(dep, "ambassador", missingWebhook)
+		require.Error(t, err)
+		assert.Equal(t, errcat.User, errcat.GetCategory(err))
+		assert.Contains(t, err.Error(), `name="echo" namespace="default"`)
+		assert.Contains(t, err.Error(), "mutating webhook isn't installed in namespace ambassador")
+		assert.Contains(t, err.Error(), `intercept.missingWebhook to "patch"`)
+	}
+
+	// The workload is patched instead when the config says so
+	assert.NoError(t, missingWebhookError(dep, "ambassador", client.MissingWebhookPatch))
+
+	// A Rollout can't be patched
+	err := missingWebhookError(ro, "ambassador", client.MissingWebhookPatch)
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+	assert.Contains(t, err.Error(), "the traffic-agent of a Rollout is injected")
+}
+
+func Test_replaceError(t *testing.T) {
+	dep := &kates.Deployment{
+		TypeMeta:   metav1.TypeMeta{Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "default"},
+	}
+	assert.NoError(t, replaceError(dep))
+
+	ro := &kates.Unstructured{}
+	ro.SetKind(userd_k8s.RolloutKind)
+	ro.SetName("web")
+	ro.SetNamespace("default")
+	err := replaceError(ro)
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+	assert.Contains(t, err.Error(), `Rollout name="web" namespace="default"`)
+	assert.Contains(t, err.Error(), "--replace can't be used with an Argo Rollout")
+}
+
+func Test_resolveContainerPort(t *testing.T) {
+	dep := &kates.Deployment{
+		TypeMeta:   metav1.TypeMeta{Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "default"},
+	}
+	cns := []kates.Container{
+		{Name: "web", Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}, {Name: "metrics", ContainerPort: 9100}}},
+		{Name: "proxy", Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8081}, {ContainerPort: 8443}}},
+		{Name: "worker"},
+	}
+	svc := &kates.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "default"},
+		Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{
+			{Name: "http", Port: 80, TargetPort: intstr.FromString("http")},
+			{Name: "metrics", Port: 9100, TargetPort: intstr.FromString("metrics")},
+			{Name: "https", Port: 443, TargetPort: intstr.FromInt(8443)},
+			{Name: "grpc", Port: 9090, TargetPort: intstr.FromInt(9000)},
+		}},
+	}
+
+	// A named target port resolves to the number of the container port with that name
+	name, port, err := resolveContainerPort(dep, cns, "metrics", "", svc)
+	require.NoError(t, err)
+	assert.Equal(t, "web", name)
+	assert.Equal(t, int32(9100), port)
+
+	// A numeric target port resolves to the container that declares it, or else to a container without ports
+	name, port, err = resolveContainerPort(dep, cns, "443", "", svc)
+	require.NoError(t, err)
+	assert.Equal(t, "proxy", name)
+	assert.Equal(t, int32(8443), port)
+	name, port, err = resolveContainerPort(dep, cns, "grpc", "", svc)
+	require.NoError(t, err)
+	assert.Equal(t, "worker", name)
+	assert.Equal(t, int32(9000), port)
+
+	// Duplicate port names are an error that lists the candidates, unless the container is given
+	_, _, err = resolveContainerPort(dep, cns, "http", "", svc)
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+	assert.Contains(t, err.Error(), "declared by the containers web, proxy")
+	assert.Contains(t, err.Error(), "--container")
+	name, port, err = resolveContainerPort(dep, cns, "http", "proxy", svc)
+	require.NoError(t, err)
+	assert.Equal(t, "proxy", name)
+	assert.Equal(t, int32(8081), port)
+
+	_, _, err = resolveContainerPort(dep, cns, "http", "sidecar", svc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `found no container named "sidecar"`)
+	assert.Contains(t, err.Error(), "web, proxy, worker")
+}
+
+func Test_injectedContainerPort(t *testing.T) {
+	dep := &kates.Deployment{
+		TypeMeta:   metav1.TypeMeta{Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "default"},
+	}
+	podTemplate := &kates.PodTemplateSpec{
+		Spec: corev1.PodSpec{Containers: []kates.Container{
+			{Name: "web", Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}}},
+			{Name: "exporter", Ports: []corev1.ContainerPort{{Name: "metrics", ContainerPort: 9100}}},
+		}},
+	}
+	svc := &kates.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "default"},
+		Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{
+			{Name: "http", Port: 80, TargetPort: intstr.FromString("http")},
+			{Name: "metrics", Port: 9100, TargetPort: intstr.FromString("metrics")},
+		}},
+	}
+
+	// The webhook can't choose between the ports of the service without the annotation
+	_, _, err := injectedContainerPort(dep, podTemplate, svc)
+	require.Error(t, err)
+
+	podTemplate.Annotations = map[string]string{install.ServicePortAnnotation: "metrics"}
+	name, port, err := injectedContainerPort(dep, podTemplate, svc)
+	require.NoError(t, err)
+	assert.Equal(t, "exporter", name)
+	assert.Equal(t, int32(9100), port)
+
+	podTemplate.Annotations[install.ServicePortAnnotation] = "80"
+	name, port, err = injectedContainerPort(dep, podTemplate, svc)
+	require.NoError(t, err)
+	assert.Equal(t, "web", name)
+	assert.Equal(t, int32(8080), port)
+}
+
+func Test_checkIgnoredPort(t *testing.T) {
+	dep := &kates.Deployment{
+		TypeMeta:   metav1.TypeMeta{Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "default"},
+	}
+	podTemplate := &kates.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{install.IgnorePortsAnnotation: "15020,9090"}},
+	}
+	assert.NoError(t, checkIgnoredPort(dep, podTemplate, "web", 8080))
+	err := checkIgnoredPort(dep, podTemplate, "web", 9090)
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+	assert.Contains(t, err.Error(), "port 9090 of container web can't be intercepted")
+	assert.Contains(t, err.Error(), install.IgnorePortsAnnotation)
+
+	// Ports are ignored regardless of the container that declares them
+	assert.Error(t, checkIgnoredPort(dep, podTemplate, "istio-proxy", 15020))
+
+	podTemplate.Annotations[install.IgnorePortsAnnotation] = "metrics"
+	err = checkIgnoredPort(dep, podTemplate, "web", 8080)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"metrics" is not a valid port number`)
+}
+
+func Test_addAgentToWorkload_defaultAgentConfig(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	obj, svc, _, err := loadFile("cur/deployment-tc-0.input.yaml", version.Version)
+	require.NoError(t, err)
+	dflt := &install.AgentConfig{
+		Resources:   &corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")}},
+		AppProtocol: "kubernetes.io/h2c",
+	}
+
+	// The default configuration of the traffic-manager is used when the pod template has no annotations
+	updatedObj, updatedSvc, err := addAgentToWorkload(ctx, "", nil, "", "docker.io/datawire/tel2:2.4.5", nil, dflt, "ambassador",
+		deepCopyObject(obj), svc.DeepCopy())
+	require.NoError(t, err)
+	podTemplate, err := install.GetPodTemplateFromObject(updatedObj)
+	require.NoError(t, err)
+	var agent *corev1.Container
+	for i := range podTemplate.Spec.Containers {
+		if cn := &podTemplate.Spec.Containers[i]; cn.Name == install.AgentContainerName {
+			agent = cn
+		}
+	}
+	require.NotNil(t, agent)
+	assert.Equal(t, "128Mi", agent.Resources.Limits.Memory().String())
+	require.Len(t, updatedSvc.Spec.Ports, 1)
+	require.NotNil(t, updatedSvc.Spec.Ports[0].AppProtocol)
+	assert.Equal(t, "kubernetes.io/h2c", *updatedSvc.Spec.Ports[0].AppProtocol)
+
+	// The annotations of the pod template take precedence
+	podTemplate, err = install.GetPodTemplateFromObject(obj)
+	require.NoError(t, err)
+	podTemplate.Annotations = map[string]string{install.AgentAppProtocolAnnotation: "http"}
+	_, updatedSvc, err = addAgentToWorkload(ctx, "", nil, "", "docker.io/datawire/tel2:2.4.5", nil, dflt, "ambassador",
+		deepCopyObject(obj), svc.DeepCopy())
+	require.NoError(t, err)
+	require.NotNil(t, updatedSvc.Spec.Ports[0].AppProtocol)
+	assert.Equal(t, "http", *updatedSvc.Spec.Ports[0].AppProtocol)
+}
+
+func Test_addAgentToWorkload_extraPorts(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	dep := &kates.Deployment{
+		TypeMeta:   metav1.TypeMeta{Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "web", Image: "echo", Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}, {ContainerPort: 9090}}},
+			{Name: "health", Image: "health"},
+		}}}},
+	}
+	svc := &kates.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "default"},
+		Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{
+			{Name: "http", Port: 80, TargetPort: intstr.FromString("http")},
+			{Name: "grpc", Port: 9090, TargetPort: intstr.FromInt(9090)},
+			{Name: "health", Port: 9091},
+		}},
+	}
+
+	// The traffic-agent takes over the container port of each of the service ports
+	updatedObj, updatedSvc, err := addAgentToWorkload(ctx, "http", []string{"grpc", "9091"}, "", "docker.io/datawire/tel2:2.4.5",
+		nil, nil, "ambassador", deepCopyObject(dep), svc.DeepCopy())
+	require.NoError(t, err)
+	podTemplate, err := install.GetPodTemplateFromObject(updatedObj)
+	require.NoError(t, err)
+	require.Len(t, podTemplate.Spec.Containers, 3)
+	agent := &podTemplate.Spec.Containers[2]
+	require.Equal(t, install.AgentContainerName, agent.Name)
+	assert.Equal(t, []corev1.ContainerPort{
+		{Name: "http", ContainerPort: 9900},
+		{Name: "tx-9090", ContainerPort: 9901},
+		{Name: "tx-9091", ContainerPort: 9902},
+	}, agent.Ports)
+	assert.Contains(t, agent.Env, corev1.EnvVar{Name: install.EnvPrefix + "EXTRA_PORTS", Value: "9901=9090,9902=9091"})
+	assert.NotEqual(t, "http", podTemplate.Spec.Containers[0].Ports[0].Name)
+	assert.Equal(t, intstr.FromString("tx-9090"), updatedSvc.Spec.Ports[1].TargetPort)
+	assert.Equal(t, intstr.FromString("tx-9091"), updatedSvc.Spec.Ports[2].TargetPort)
+
+	// An installed agent is found to intercept the extra ports by the name or number of their service ports
+	var actions workloadActions
+	ok, err := getAnnotation(updatedObj, &actions)
+	require.NoError(t, err)
+	require.True(t, ok)
+	for portNameOrNumber, expected := range map[string]int32{"grpc": 9090, "9090": 9090, "health": 9091, "9091": 9091} {
+		port, ok := actions.AddTrafficAgent.extraContainerPort(portNameOrNumber)
+		assert.True(t, ok, portNameOrNumber)
+		assert.Equal(t, expected, port, portNameOrNumber)
+	}
+	_, ok = actions.AddTrafficAgent.extraContainerPort("http")
+	assert.False(t, ok)
+
+	// Uninstalling the agent restores all the ports
+	_, err = undoObjectMods(ctx, updatedObj)
+	require.NoError(t, err)
+	require.NoError(t, undoServiceMods(ctx, updatedSvc))
+	sanitizeWorkload(updatedObj)
+	assert.Equal(t, dep.Spec.Template.Spec, updatedObj.(*kates.Deployment).Spec.Template.Spec)
+	assert.Equal(t, svc.Spec, updatedSvc.Spec)
+
+	// Two service ports that target the same container port can't both be intercepted
+	svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{Name: "grpc-alt", Port: 9092, TargetPort: intstr.FromInt(9090)})
+	_, _, err = addAgentToWorkload(ctx, "http", []string{"grpc", "grpc-alt"}, "", "docker.io/datawire/tel2:2.4.5",
+		nil, nil, "ambassador", deepCopyObject(dep), svc.DeepCopy())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `service ports "grpc" and "grpc-alt" both target container port 9090`)
+
+	// The traffic-agent of a headless service intercepts one port only
+	svc.Spec.ClusterIP = "None"
+	_, _, err = addAgentToWorkload(ctx, "http", []string{"grpc"}, "", "docker.io/datawire/tel2:2.4.5",
+		nil, nil, "ambassador", deepCopyObject(dep), svc.DeepCopy())
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+	assert.Contains(t, err.Error(), "headless")
+}
+
+func Test_agentAppContainer(t *testing.T) {
+	cns := []kates.Container{
+		{Name: "web", Ports: []corev1.ContainerPort{{ContainerPort: 8080}}},
+		{Name: install.AgentContainerName, Ports: []corev1.ContainerPort{{Name: "tx-8080", ContainerPort: 9900}}},
+	}
+	name, port := agentAppContainer(&workloadActions{
+		AddTrafficAgent:   &addTrafficAgentAction{ContainerPortName: "http", ContainerPortNumber: 8081},
+		HideContainerPort: &hideContainerPortAction{ContainerName: "proxy", PortName: "http"},
+	}, cns)
+	assert.Equal(t, "proxy", name)
+	assert.Equal(t, int32(8081), port)
+
+	// The container port isn't hidden when the service targets it by number
+	name, port = agentAppContainer(&workloadActions{
+		AddTrafficAgent: &addTrafficAgentAction{ContainerPortName: "tx-8080", ContainerPortNumber: 8080},
+	}, cns)
+	assert.Equal(t, "web", name)
+	assert.Equal(t, int32(8080), port)
+}
+
+func Test_injectionRemovalPatch(t *testing.T) {
+	annotations := map[string]string{
+		install.InjectAnnotation:             "enabled",
+		install.DomainPrefix + "restartedAt": "2021-10-15T12:00:00Z",
+	}
+	dep := &kates.Deployment{
+		TypeMeta:   metav1.TypeMeta{Kind: "Deployment", APIVersion: "apps/v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "echo", Image: "echo:1.0"}}},
+		}},
+	}
+	patched, err := strategicPatch(dep, userd_k8s.InjectionRemovalPatch)
+	require.NoError(t, err)
+	podTemplate, err := install.GetPodTemplateFromObject(patched)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{install.DomainPrefix + "restartedAt": "2021-10-15T12:00:00Z"}, podTemplate.Annotations)
+	assert.Len(t, podTemplate.Spec.Containers, 1)
+
+	ro := &kates.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       userd_k8s.RolloutKind,
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "default"},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{"annotations": map[string]interface{}{install.InjectAnnotation: "enabled"}},
+			},
+		},
+	}}
+	require.True(t, userd_k8s.RolloutInjectionEnabled(ro))
+	patched, err = mergePatch(ro, userd_k8s.InjectionRemovalPatch)
+	require.NoError(t, err)
+	assert.False(t, userd_k8s.RolloutInjectionEnabled(patched.(*kates.Unstructured)))
+}
+
+func Test_onDeleteRestartWarning(t *testing.T) {
+	ss := func(strategy appsv1.StatefulSetUpdateStrategyType) *kates.StatefulSet {
+		return &kates.StatefulSet{
+			TypeMeta:   metav1.TypeMeta{Kind: "StatefulSet"},
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+			Spec:       appsv1.StatefulSetSpec{UpdateStrategy: appsv1.StatefulSetUpdateStrategy{Type: strategy}},
+		}
+	}
+
+	// The controller restarts the pods of other workloads, and of StatefulSets that use the RollingUpdate strategy
+	assert.Empty(t, onDeleteRestartWarning(&kates.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "db"}}))
+	assert.Empty(t, onDeleteRestartWarning(ss(appsv1.RollingUpdateStatefulSetStrategyType)))
+	assert.Empty(t, onDeleteRestartWarning(ss("")))
+
+	warning := onDeleteRestartWarning(ss(appsv1.OnDeleteStatefulSetStrategyType))
+	assert.Contains(t, warning, "StatefulSet db.default uses the OnDelete update strategy")
+	assert.Contains(t, warning, "deletes its pods")
+
+	// The warning reaches the user of the CLI
+	var notified []string
+	ki := &installer{notify: func(msg string) { notified = append(notified, msg) }}
+	ki.warn(dlog.NewTestContext(t, false), warning)
+	assert.Equal(t, []string{"Warning: " + warning}, notified)
+}
+
+func Test_statefulSetUpdated(t *testing.T) {
+	replicas := int32(3)
+	ss := &kates.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Generation: 2},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+		Status: appsv1.StatefulSetStatus{
+			ObservedGeneration: 2,
+			Replicas:           3,
+			UpdatedReplicas:    3,
+			CurrentReplicas:    3,
+			ReadyReplicas:      2,
+		},
+	}
+	assert.True(t, statefulSetUpdated(ss, 2))
+
+	// The current revision isn't promoted when the OnDelete strategy is used
+	ss.Spec.UpdateStrategy.Type = appsv1.OnDeleteStatefulSetStrategyType
+	ss.Status.CurrentReplicas = 0
+	assert.False(t, statefulSetUpdated(ss, 2))
+	ss.Status.ReadyReplicas = 3
+	assert.True(t, statefulSetUpdated(ss, 2))
+
+	ss.Status.UpdatedReplicas = 2
+	assert.False(t, statefulSetUpdated(ss, 2))
+}
+
+func Test_addPullSecretsAction(t *testing.T) {
+	dep := &kates.Deployment{
+		TypeMeta:   metav1.TypeMeta{Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "default"},
+	}
+	dep.Spec.Template.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "app-creds"}, {Name: "mirror-creds"}}
+	secrets := func() []string {
+		var names []string
+		for _, ps := range dep.Spec.Template.Spec.ImagePullSecrets {
+			names = append(names, ps.Name)
+		}
+		return names
+	}
+
+	// Only the secrets that the workload doesn't already declare are added, and later removed
+	missing := install.MissingPullSecrets(dep.Spec.Template.Spec.ImagePullSecrets, []string{"mirror-creds", "other-creds", "other-creds"})
+	assert.Equal(t, []string{"other-creds"}, missing)
+	action := &addPullSecretsAction{Names: missing}
+	assert.False(t, action.IsDone(dep))
+	require.NoError(t, action.Do(dep))
+	assert.True(t, action.IsDone(dep))
+	assert.Equal(t, []string{"app-creds", "mirror-creds", "other-creds"}, secrets())
+
+	require.NoError(t, action.Undo(client.Semver(), dep))
+	assert.Equal(t, []string{"app-creds", "mirror-creds"}, secrets())
+
+	err := action.Undo(client.Semver(), dep)
+	require.Error(t, err)
+	assert.True(t, install.IsAlreadyUndone(err))
+}
+
 func sanitizeWorkload(obj kates.Object) {
 	obj.SetResourceVersion("")
 	obj.SetGeneration(int64(0))
@@ -7,13 +7,19 @@ import (
 	"io"
 	"net"
 	"os"
+	"path"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/pkg/sftp"
 	grpcCodes "google.golang.org/grpc/codes"
 	grpcStatus "google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
+	authv1 "k8s.io/api/authorization/v1"
 
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dlog"
@@ -23,10 +29,14 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/a8rcloud"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_auth"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_k8s"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 	"github.com/telepresenceio/telepresence/v2/pkg/dpipe"
 	"github.com/telepresenceio/telepresence/v2/pkg/forwarder"
 	"github.com/telepresenceio/telepresence/v2/pkg/install"
+	"github.com/telepresenceio/telepresence/v2/pkg/metrics"
+	"github.com/telepresenceio/telepresence/v2/pkg/sftpmount"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 )
 
 type forwardKey struct {
@@ -42,6 +52,7 @@ type mountForward struct {
 type portForward struct {
 	forwardKey
 	Port int32
+	UDP  bool
 }
 
 // The livePortForward struct provides synchronization for cancellation of port forwards.
@@ -77,7 +88,7 @@ func (lpf livePortForwards) start(ctx context.Context, tm *trafficManager, ii *m
 		if _, isLive := lpf.live[fk]; !isLive {
 			pfCtx, pfCancel := context.WithCancel(ctx)
 			livePortForward := &livePortForward{cancel: pfCancel}
-			tm.startForwards(pfCtx, &livePortForward.wg, fk, ii.SftpPort, ii.Spec.ExtraPorts)
+			tm.startForwards(pfCtx, &livePortForward.wg, fk, ii.SftpPort, ii.Spec.ExtraPorts, ii.Spec.ExtraUdpPorts)
 			dlog.Debugf(ctx, "Started forward for %+v", fk)
 			lpf.live[fk] = livePortForward
 		}
@@ -111,7 +122,8 @@ func (tm *trafficManager) reconcileMountPoints(ctx context.Context, existingInte
 		return true
 	})
 	for _, key := range mountsToDelete {
-		if _, loaded := tm.mountPoints.LoadAndDelete(key); loaded {
+		if name, loaded := tm.mountPoints.LoadAndDelete(key); loaded {
+			tm.mountOptions.Delete(name)
 			mountPoint := key.(string)
 			if err := os.Remove(mountPoint); err != nil {
 				if os.IsNotExist(err) {
@@ -135,10 +147,12 @@ func (tm *trafficManager) workerPortForwardIntercepts(ctx context.Context) error
 	//  3. because we want a per-worker cancel, we'd have to implement our own Context
 	//     management on top anyway, so dgroup wouldn't actually save us any complexity.
 	portForwards := newPortForwards()
+	expired := make(map[string]struct{})
 	backoff := 100 * time.Millisecond
 	for ctx.Err() == nil {
 		<-tm.startup
-		stream, err := tm.managerClient.WatchIntercepts(ctx, tm.session())
+		session := tm.session()
+		stream, err := tm.managerClient.WatchIntercepts(ctx, session)
 		if err != nil {
 			err = fmt.Errorf("manager.WatchIntercepts dial: %w", err)
 		}
@@ -160,6 +174,7 @@ func (tm *trafficManager) workerPortForwardIntercepts(ctx context.Context) error
 				intercepts = snapshot.Intercepts
 			}
 			tm.setCurrentIntercepts(intercepts)
+			logExpiredIntercepts(ctx, intercepts, expired)
 
 			// allNames contains the names of all intercepts, irrespective of their status
 			allNames := make(map[string]struct{})
@@ -170,9 +185,12 @@ func (tm *trafficManager) workerPortForwardIntercepts(ctx context.Context) error
 				allNames[intercept.Spec.Name] = struct{}{}
 
 				var iceptError error
-				switch intercept.Disposition {
-				case manager.InterceptDispositionType_ACTIVE:
-				case manager.InterceptDispositionType_WAITING:
+				switch {
+				case intercept.Disposition == manager.InterceptDispositionType_ACTIVE:
+				case interceptPending(intercept):
+					// The traffic-agent hasn't picked up the intercept yet. During a rollout, the pods of
+					// the workload come and go, so the intercept may move between these states a couple
+					// of times before it becomes active.
 					continue
 				default:
 					iceptError = fmt.Errorf("intercept in error state %v: %v", intercept.Disposition, intercept.Message)
@@ -196,6 +214,7 @@ func (tm *trafficManager) workerPortForwardIntercepts(ctx context.Context) error
 			}
 			portForwards.cancelUnwanted(ctx)
 			tm.reconcileMountPoints(ctx, allNames)
+			tm.reconcileLocalTLS(ctx, allNames)
 			if ctx.Err() == nil {
 				tm.SetInterceptedNamespaces(ctx, namespaces)
 			}
@@ -203,6 +222,10 @@ func (tm *trafficManager) workerPortForwardIntercepts(ctx context.Context) error
 
 		if ctx.Err() == nil {
 			dlog.Errorf(ctx, "reading port-forwards from manager: %v", err)
+			if isUnknownSession(err) {
+				// The port-forwards and mounts remain until the intercepts are re-created in a new session
+				tm.sessionUnknown(ctx, session.SessionId)
+			}
 			dtime.SleepWithContext(ctx, backoff)
 			backoff *= 2
 			if backoff > 3*time.Second {
@@ -213,6 +236,32 @@ func (tm *trafficManager) workerPortForwardIntercepts(ctx context.Context) error
 	return nil
 }
 
+// logExpiredIntercepts logs the intercepts of the given snapshot that the traffic-manager has expired, and
+// returns their names. The given set holds the names of the intercepts that have been logged already, so
+// that each expiry is logged once. Names of intercepts that no longer exist are removed from it.
+func logExpiredIntercepts(ctx context.Context, intercepts []*manager.InterceptInfo, logged map[string]struct{}) []string {
+	var names []string
+	current := make(map[string]struct{}, len(intercepts))
+	for _, ii := range intercepts {
+		name := ii.Spec.Name
+		current[name] = struct{}{}
+		if ii.Disposition != manager.InterceptDispositionType_EXPIRED {
+			continue
+		}
+		if _, ok := logged[name]; !ok {
+			logged[name] = struct{}{}
+			dlog.Warnf(ctx, "Intercept %s has ended because its time-to-live has passed: %s", name, ii.Message)
+			names = append(names, name)
+		}
+	}
+	for name := range logged {
+		if _, ok := current[name]; !ok {
+			delete(logged, name)
+		}
+	}
+	return names
+}
+
 // getCurrentIntercepts returns a copy of the current intercept snapshot amended with
 // the local filesystem mount point.
 func (tm *trafficManager) getCurrentIntercepts() []*manager.InterceptInfo {
@@ -240,6 +289,8 @@ func (tm *trafficManager) getCurrentIntercepts() []*manager.InterceptInfo {
 
 func (tm *trafficManager) setCurrentIntercepts(intercepts []*manager.InterceptInfo) {
 	tm.currentInterceptsLock.Lock()
+	// The gauge is shared by all sessions, so it's adjusted by the change of this session's count
+	metrics.Intercepts.Add(float64(len(intercepts) - len(tm.currentIntercepts)))
 	tm.currentIntercepts = intercepts
 	tm.currentInterceptsLock.Unlock()
 }
@@ -252,33 +303,66 @@ func interceptError(tp rpc.InterceptError, err error) *rpc.InterceptResult {
 	}
 }
 
-// AddIntercept adds one intercept
-func (tm *trafficManager) AddIntercept(c context.Context, ir *rpc.CreateInterceptRequest) (*rpc.InterceptResult, error) {
-	spec := ir.Spec
+// errNamespaceNotManaged is the error of an intercept in a namespace that a traffic-manager with
+// namespace-scoped RBAC doesn't manage.
+func errNamespaceNotManaged(namespace string) error {
+	return errcat.User.Newf("namespace %s is not managed by this traffic-manager", namespace)
+}
+
+// checkIntercept resolves the namespace of the given spec and checks that it doesn't clash with the
+// intercepts of this client. A non-nil result explains why the intercept can't be created.
+func (tm *trafficManager) checkIntercept(spec *manager.InterceptSpec) *rpc.InterceptResult {
+	if spec.Agent != "" {
+		ns := spec.Namespace
+		if ns == "" {
+			ns = tm.Namespace
+		}
+		if !tm.IsManaged(ns) {
+			return interceptError(rpc.InterceptError_TRAFFIC_MANAGER_ERROR, errNamespaceNotManaged(ns))
+		}
+	}
 	spec.Namespace = tm.ActualNamespace(spec.Namespace)
 	if spec.Namespace == "" {
 		// namespace is not currently mapped
-		return interceptError(rpc.InterceptError_NO_ACCEPTABLE_WORKLOAD, errcat.User.Newf(spec.Name)), nil
+		return interceptError(rpc.InterceptError_NO_ACCEPTABLE_WORKLOAD, errcat.User.Newf(spec.Name))
 	}
 
 	if _, inUse := tm.LocalIntercepts[spec.Name]; inUse {
-		return interceptError(rpc.InterceptError_ALREADY_EXISTS, errcat.User.Newf(spec.Name)), nil
+		return interceptError(rpc.InterceptError_ALREADY_EXISTS, errcat.User.Newf(spec.Name))
 	}
 
 	<-tm.startup
 	for _, iCept := range tm.getCurrentIntercepts() {
 		if iCept.Spec.Name == spec.Name {
-			return interceptError(rpc.InterceptError_ALREADY_EXISTS, errcat.User.Newf(spec.Name)), nil
+			return interceptError(rpc.InterceptError_ALREADY_EXISTS, errcat.User.Newf(spec.Name))
 		}
-		if iCept.Spec.TargetPort == spec.TargetPort && iCept.Spec.TargetHost == spec.TargetHost {
+		if sameLocalTarget(iCept.Spec, spec) {
 			return &rpc.InterceptResult{
 				Error:         rpc.InterceptError_LOCAL_TARGET_IN_USE,
 				ErrorText:     spec.Name,
 				ErrorCategory: int32(errcat.User),
 				InterceptInfo: iCept,
-			}, nil
+			}
 		}
 	}
+	return nil
+}
+
+// AddIntercept adds one intercept. An intercept that is created with an owner is removed when the
+// owner no longer exists.
+func (tm *trafficManager) AddIntercept(c context.Context, ir *rpc.CreateInterceptRequest) (*rpc.InterceptResult, error) {
+	result, err := tm.addIntercept(c, ir)
+	if err == nil && result.Error == rpc.InterceptError_UNSPECIFIED && ir.OwnerPid > 0 {
+		tm.setInterceptOwner(ir.Spec.Name, ir.OwnerPid)
+	}
+	return result, err
+}
+
+func (tm *trafficManager) addIntercept(c context.Context, ir *rpc.CreateInterceptRequest) (*rpc.InterceptResult, error) {
+	spec := ir.Spec
+	if result := tm.checkIntercept(spec); result != nil {
+		return result, nil
+	}
 
 	if spec.Agent == "" {
 		return tm.AddLocalOnlyIntercept(c, spec)
@@ -289,15 +373,76 @@ func (tm *trafficManager) AddIntercept(c context.Context, ir *rpc.CreateIntercep
 		spec.Mechanism = "tcp"
 	}
 
+	if ir.Steal {
+		if err := tm.checkStealAccess(c, spec); err != nil {
+			return interceptError(rpc.InterceptError_FAILED_TO_ESTABLISH, err), nil
+		}
+	}
+
+	if spec.Replace {
+		obj, err := tm.FindWorkload(c, spec.Namespace, spec.Agent)
+		if err != nil {
+			return agentError(spec.Agent, err), nil
+		}
+		if err = replaceError(obj); err != nil {
+			return interceptError(rpc.InterceptError_FAILED_TO_ESTABLISH, err), nil
+		}
+	}
+
 	// It's OK to just call addAgent every time; if the agent is already installed then it's a
 	// no-op.
-	var result *rpc.InterceptResult
-	if result = tm.addAgent(c, spec.Namespace, spec.Agent, spec.ServiceName, spec.ServicePortIdentifier, ir.AgentImage); result.Error != rpc.InterceptError_UNSPECIFIED {
+	ac, span := tracing.StartSpan(c, "add agent")
+	result := tm.addAgent(ac, spec.Namespace, spec.Agent, spec.ServiceName, spec.ServicePortIdentifier, spec.ContainerName,
+		tm.agentImageFor(ir.AgentImage), spec.ExtraPortMappings, !ir.NoWait)
+	if result.Error != rpc.InterceptError_UNSPECIFIED {
+		tracing.EndSpan(span, errors.New(result.ErrorText))
 		return result, nil
 	}
+	span.End()
 
 	spec.ServiceUid = result.ServiceUid
 	spec.WorkloadKind = result.WorkloadKind
+	spec.ContainerName = result.ContainerName
+	spec.ContainerPort = result.ContainerPort
+
+	if forwarder.HasHTTPConditions(spec) || spec.AgentTlsSecret != "" || len(spec.ExtraPortMappings) > 0 {
+		agents, err := tm.getAgentsForVersionCheck(c, spec.Agent, spec.Namespace)
+		if err == nil && forwarder.HasHTTPConditions(spec) {
+			err = checkAgentsSupportHTTPConditions(spec.Agent, spec.Namespace, agents)
+		}
+		if err == nil && spec.AgentTlsSecret != "" {
+			err = checkAgentsSupportTLSTermination(spec.Agent, spec.Namespace, agents)
+		}
+		if err == nil && len(spec.ExtraPortMappings) > 0 {
+			err = checkAgentsSupportExtraPorts(spec.Agent, spec.Namespace, agents)
+		}
+		if err != nil {
+			return interceptError(rpc.InterceptError_FAILED_TO_ESTABLISH, err), nil
+		}
+	}
+	lt, err := newLocalTLS(spec, ir)
+	if err != nil {
+		return interceptError(rpc.InterceptError_FAILED_TO_ESTABLISH, err), nil
+	}
+
+	mo := &mountOptions{readOnly: ir.MountReadOnly, mountType: ir.MountType}
+	if ir.MountPoint != "" && len(ir.MountVolumes) > 0 {
+		agents := tm.getAgents(spec.Agent, spec.Namespace)
+		if len(agents) == 0 {
+			if ir.NoWait {
+				return interceptError(rpc.InterceptError_FAILED_TO_ESTABLISH,
+					errcat.User.Newf("no traffic-agent found for %s.%s; volumes can't be selected using --mount-volumes "+
+						"until it has arrived, so don't use --no-wait", spec.Agent, spec.Namespace)), nil
+			}
+			return interceptError(rpc.InterceptError_FAILED_TO_ESTABLISH,
+				errcat.User.Newf("no traffic-agent found for %s.%s", spec.Agent, spec.Namespace)), nil
+		}
+		paths, err := selectVolumeMounts(agents[0], ir.MountVolumes)
+		if err != nil {
+			return interceptError(rpc.InterceptError_FAILED_TO_ESTABLISH, err), nil
+		}
+		mo.paths = paths
+	}
 
 	deleteMount := false
 	if ir.MountPoint != "" {
@@ -316,6 +461,10 @@ func (tm *trafficManager) AddIntercept(c context.Context, ir *rpc.CreateIntercep
 		}()
 	}
 
+	if err := checkExtraPortsAvailable(spec); err != nil {
+		return interceptError(rpc.InterceptError_FAILED_TO_ESTABLISH, err), nil
+	}
+
 	apiKey, err := tm.callbacks.GetCloudAPIKey(c, a8rcloud.KeyDescAgent(spec), false)
 	if err != nil {
 		if !errors.Is(err, userd_auth.ErrNotLoggedIn) {
@@ -331,49 +480,263 @@ func (tm *trafficManager) AddIntercept(c context.Context, ir *rpc.CreateIntercep
 	<-tm.startup
 
 	// The agent is in place and the traffic-manager has acknowledged the creation of the intercept. It
-	// should become active within a few seconds.
-	waitCh := make(chan interceptResult)
-	tm.activeInterceptsWaiters.Store(spec.Name, waitCh)
-	defer tm.activeInterceptsWaiters.Delete(spec.Name)
+	// should become active within a few seconds. The channel is buffered so that the watcher never blocks
+	// on it, even when the wait has timed out.
+	var waitCh chan interceptResult
+	if !ir.NoWait {
+		waitCh = make(chan interceptResult, 1)
+		tm.activeInterceptsWaiters.Store(spec.Name, waitCh)
+		defer tm.activeInterceptsWaiters.Delete(spec.Name)
+	}
 
 	ii, err := tm.managerClient.CreateIntercept(c, &manager.CreateInterceptRequest{
 		Session:       tm.session(),
 		InterceptSpec: spec,
 		ApiKey:        apiKey,
+		Steal:         ir.Steal,
 	})
 	if err != nil {
 		dlog.Debugf(c, "manager responded to CreateIntercept with error %v", err)
-		err = client.CheckTimeout(c, err)
-		return &rpc.InterceptResult{Error: rpc.InterceptError_TRAFFIC_MANAGER_ERROR, ErrorText: err.Error()}, nil
+		return createInterceptError(c, spec, err), nil
 	}
 	dlog.Debugf(c, "created intercept %s", ii.Spec.Name)
+	if lt != nil {
+		// The connections of the intercept are dialed as soon as it becomes active, so the local TLS must be
+		// known by then. It's forgotten again unless the intercept is successfully established.
+		tm.localTLS.Store(spec.Name, lt)
+		defer func() {
+			if lt != nil {
+				tm.localTLS.Delete(spec.Name)
+			}
+		}()
+	}
 
-	select {
-	case <-c.Done():
-		return interceptError(rpc.InterceptError_FAILED_TO_ESTABLISH, c.Err()), nil
-	case wr := <-waitCh:
-		ii = wr.intercept
-		if wr.err != nil {
-			return interceptError(rpc.InterceptError_FAILED_TO_ESTABLISH, wr.err), nil
-		}
-		result.InterceptInfo = wr.intercept
-		if ir.MountPoint != "" && ii.SftpPort > 0 {
-			result.Environment["TELEPRESENCE_ROOT"] = ir.MountPoint
-			deleteMount = false // Mount-point is busy until intercept ends
+	if ir.NoWait {
+		// The intercept is pending. The watcher makes it active when a traffic-agent picks it up, and the
+		// volumes are mounted then, so the mount-point is kept until it ends.
+		result.InterceptInfo = ii
+		if ir.MountPoint != "" {
+			tm.mountOptions.Store(spec.Name, mo)
+			deleteMount = false
 			ii.Spec.MountPoint = ir.MountPoint
 		}
+		lt = nil
 		return result, nil
 	}
+
+	_, span = tracing.StartSpan(c, "wait for active intercept")
+	ii, err = tm.waitForActiveIntercept(c, spec.Name, waitCh)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		return interceptError(rpc.InterceptError_FAILED_TO_ESTABLISH, err), nil
+	}
+	result.InterceptInfo = ii
+	if ir.MountPoint != "" && ii.SftpPort > 0 {
+		result.Environment["TELEPRESENCE_ROOT"] = ir.MountPoint
+		if len(mo.paths) > 0 {
+			result.Environment["TELEPRESENCE_MOUNTS"] = strings.Join(mo.paths, ":")
+		}
+		tm.mountOptions.Store(spec.Name, mo)
+		deleteMount = false // Mount-point is busy until intercept ends
+		ii.Spec.MountPoint = ir.MountPoint
+	}
+	lt = nil // Local TLS is used until intercept ends
+	return result, nil
+}
+
+// pendingInterceptReportInterval is how often the state of a pending intercept is checked, so that changes
+// to it can be reported to the user of the CLI, while waiting for it to become active.
+var pendingInterceptReportInterval = 2 * time.Second
+
+// waitForActiveIntercept waits for the watcher to report that the intercept with the given name is active
+// on the given channel. Until then, the reason why the intercept is pending is reported to the user of the
+// CLI whenever it changes.
+func (tm *trafficManager) waitForActiveIntercept(c context.Context, name string, waitCh <-chan interceptResult) (*manager.InterceptInfo, error) {
+	ticker := time.NewTicker(pendingInterceptReportInterval)
+	defer ticker.Stop()
+	reported := ""
+	for {
+		select {
+		case <-c.Done():
+			return nil, client.CheckTimeout(c, fmt.Errorf("waiting for intercept %q to become active", name))
+		case wr := <-waitCh:
+			return wr.intercept, wr.err
+		case <-ticker.C:
+			for _, ii := range tm.getCurrentIntercepts() {
+				if ii.Spec.Name != name || !interceptPending(ii) {
+					continue
+				}
+				reason := ii.Message
+				if reason == "" {
+					reason = ii.Disposition.String()
+				}
+				if reason != reported {
+					reported = reason
+					tm.notifyProgress(fmt.Sprintf("Intercept %s is pending: %s", name, reason))
+				}
+			}
+		}
+	}
+}
+
+// interceptPending returns true if the given intercept has been accepted by the traffic-manager, but is
+// yet to be picked up by a traffic-agent.
+func interceptPending(ii *manager.InterceptInfo) bool {
+	switch ii.Disposition {
+	case manager.InterceptDispositionType_WAITING, manager.InterceptDispositionType_NO_AGENT:
+		return true
+	default:
+		return false
+	}
+}
+
+// createInterceptError returns the result of an intercept that the traffic-manager refused to create
+// with the given error.
+func createInterceptError(c context.Context, spec *manager.InterceptSpec, err error) *rpc.InterceptResult {
+	if st, ok := grpcStatus.FromError(err); ok {
+		switch st.Code() {
+		case grpcCodes.FailedPrecondition:
+			// The workload is replaced by, or intercepted by, someone else
+			for _, d := range st.Details() {
+				if conflict, ok := d.(*manager.InterceptConflict); ok {
+					return interceptError(rpc.InterceptError_TRAFFIC_MANAGER_ERROR, interceptConflictError(spec, conflict, st.Message(), time.Now()))
+				}
+			}
+			return interceptError(rpc.InterceptError_TRAFFIC_MANAGER_ERROR, errcat.User.New(st.Message()))
+		case grpcCodes.PermissionDenied:
+			// The namespace isn't managed by the traffic-manager
+			return interceptError(rpc.InterceptError_TRAFFIC_MANAGER_ERROR, errNamespaceNotManaged(spec.Namespace))
+		}
+	}
+	err = client.CheckTimeout(c, err)
+	return &rpc.InterceptResult{Error: rpc.InterceptError_TRAFFIC_MANAGER_ERROR, ErrorText: err.Error()}
+}
+
+// interceptConflictError returns the error that tells the user who intercepts the workload of the given
+// spec, since when, and what traffic, along with how the conflict can be resolved.
+func interceptConflictError(spec *manager.InterceptSpec, conflict *manager.InterceptConflict, msg string, now time.Time) error {
+	if conflict.Replace {
+		return errcat.User.Newf("%s; use --steal to end intercept %q", msg, conflict.Name)
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s.%s is already intercepted by %s", spec.Agent, spec.Namespace, conflict.Client)
+	if conflict.Created != nil {
+		created := conflict.Created.AsTime().In(now.Location())
+		layout := "15:04"
+		if y, m, d := created.Date(); y != now.Year() || m != now.Month() || d != now.Day() {
+			layout = "Jan 2 15:04"
+		}
+		fmt.Fprintf(&sb, " since %s", created.Format(layout))
+	}
+	fmt.Fprintf(&sb, " (%s); ", describeMatch(conflict.HttpHeaders, conflict.HttpPathPrefix))
+	if len(conflict.HttpHeaders) > 0 || conflict.HttpPathPrefix != "" {
+		sb.WriteString("use --http-header with other values to share the workload, or ")
+	}
+	fmt.Fprintf(&sb, "use --steal to end intercept %q", conflict.Name)
+	return errcat.User.New(sb.String())
+}
+
+// describeMatch returns a short description of the traffic that an intercept with the given HTTP
+// conditions intercepts.
+func describeMatch(headers []*manager.HTTPHeaderMatch, pathPrefix string) string {
+	var conds []string
+	for _, h := range headers {
+		conds = append(conds, fmt.Sprintf("header %q matching %q", h.Name, h.ValueRegex))
+	}
+	if pathPrefix != "" {
+		conds = append(conds, fmt.Sprintf("path prefix %q", pathPrefix))
+	}
+	if len(conds) == 0 {
+		return "all traffic"
+	}
+	return strings.Join(conds, " and ")
+}
+
+// checkStealAccess returns an error unless the user is allowed to update the workload of the given spec,
+// which is what it takes to end the intercepts of others.
+func (tm *trafficManager) checkStealAccess(c context.Context, spec *manager.InterceptSpec) error {
+	obj, err := tm.FindWorkload(c, spec.Namespace, spec.Agent)
+	if err != nil {
+		return err
+	}
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	ra := &authv1.ResourceAttributes{
+		Namespace: obj.GetNamespace(),
+		Verb:      "update",
+		Group:     gvk.Group,
+		Resource:  strings.ToLower(gvk.Kind) + "s",
+		Name:      obj.GetName(),
+	}
+	ok, err := tm.CanI(c, ra)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errcat.User.Newf("--steal requires permission to %s", userd_k8s.DescribeAccess(ra))
+	}
+	return nil
+}
+
+// sameLocalTarget returns true if the given intercept specs send traffic to the same port on the same
+// target host using the same protocol. The ports of the ExtraPortMappings are TCP ports.
+func sameLocalTarget(a, b *manager.InterceptSpec) bool {
+	if a.TargetHost != b.TargetHost {
+		return false
+	}
+	type protoPort struct {
+		proto string
+		port  int32
+	}
+	targets := func(spec *manager.InterceptSpec) []protoPort {
+		proto := spec.Protocol
+		if proto == "" {
+			proto = "TCP"
+		}
+		pps := []protoPort{{proto, spec.TargetPort}}
+		for _, pm := range spec.ExtraPortMappings {
+			pps = append(pps, protoPort{"TCP", pm.TargetPort})
+		}
+		return pps
+	}
+	for _, ap := range targets(a) {
+		for _, bp := range targets(b) {
+			if ap == bp {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // shouldForward returns true if the intercept info given should result in mounts or ports being forwarded
 func (tm *trafficManager) shouldForward(ii *manager.InterceptInfo) bool {
-	return ii.SftpPort > 0 || len(ii.Spec.ExtraPorts) > 0
+	return ii.SftpPort > 0 || len(ii.Spec.ExtraPorts) > 0 || len(ii.Spec.ExtraUdpPorts) > 0
+}
+
+// checkExtraPortsAvailable returns an error if any of the extra ports of the given spec cannot be bound
+// on localhost. The forwards for those ports aren't started until the intercept is active, so a port
+// that is already in use must be detected up front.
+func checkExtraPortsAvailable(spec *manager.InterceptSpec) error {
+	for _, port := range spec.ExtraPorts {
+		l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err != nil {
+			return errcat.User.Newf("unable to forward port %d/TCP to the pod: %w", port, err)
+		}
+		_ = l.Close()
+	}
+	for _, port := range spec.ExtraUdpPorts {
+		pc, err := net.ListenPacket("udp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err != nil {
+			return errcat.User.Newf("unable to forward port %d/UDP to the pod: %w", port, err)
+		}
+		_ = pc.Close()
+	}
+	return nil
 }
 
 // startForwards starts port forwards and mounts for the given forwardKey.
 // It assumes that the user has called shouldForward and is sure that something will be started.
-func (tm *trafficManager) startForwards(ctx context.Context, wg *sync.WaitGroup, fk forwardKey, sftpPort int32, extraPorts []int32) {
+func (tm *trafficManager) startForwards(ctx context.Context, wg *sync.WaitGroup, fk forwardKey, sftpPort int32, extraPorts, extraUDPPorts []int32) {
 	if sftpPort > 0 {
 		// There's nothing to mount if the SftpPort is zero
 		mntCtx := dgroup.WithGoroutineName(ctx, fmt.Sprintf("/%s:%d", fk.PodIP, sftpPort))
@@ -383,12 +746,39 @@ func (tm *trafficManager) startForwards(ctx context.Context, wg *sync.WaitGroup,
 	for _, port := range extraPorts {
 		pfCtx := dgroup.WithGoroutineName(ctx, fmt.Sprintf("/%s:%d", fk.PodIP, port))
 		wg.Add(1)
-		go tm.workerPortForwardIntercept(pfCtx, portForward{fk, port}, wg)
+		go tm.workerPortForwardIntercept(pfCtx, portForward{fk, port, false}, wg)
+	}
+	for _, port := range extraUDPPorts {
+		pfCtx := dgroup.WithGoroutineName(ctx, fmt.Sprintf("/%s:%d/UDP", fk.PodIP, port))
+		wg.Add(1)
+		go tm.workerPortForwardIntercept(pfCtx, portForward{fk, port, true}, wg)
 	}
 }
 
+// forwardStarted increments the number of live forwards of the intercept with the given name, and returns
+// a function that decrements it.
+func (tm *trafficManager) forwardStarted(name string) func() {
+	v, _ := tm.liveForwards.LoadOrStore(name, new(int32))
+	count := v.(*int32)
+	atomic.AddInt32(count, 1)
+	return func() { atomic.AddInt32(count, -1) }
+}
+
+// forwardCounts returns the number of live forwards and mounts of each intercept.
+func (tm *trafficManager) forwardCounts() map[string]int32 {
+	counts := make(map[string]int32)
+	tm.liveForwards.Range(func(key, value interface{}) bool {
+		if n := atomic.LoadInt32(value.(*int32)); n > 0 {
+			counts[key.(string)] = n
+		}
+		return true
+	})
+	return counts
+}
+
 func (tm *trafficManager) workerPortForwardIntercept(ctx context.Context, pf portForward, wg *sync.WaitGroup) {
 	defer wg.Done()
+	defer tm.forwardStarted(pf.Name)()
 	// Using kubectl port-forward here would require the pod name to be either fetched from the API server, or threaded
 	// all the way through from the intercept request to the agent and into the WatchIntercepts; it would also create
 	// additional connections that would have to be recovered in case of failure. Instead, we re-use the forwarder from
@@ -399,7 +789,12 @@ func (tm *trafficManager) workerPortForwardIntercept(ctx context.Context, pf por
 		Port: int(pf.Port),
 	}
 	f := forwarder.NewForwarder(&addr, pf.PodIP, pf.Port)
-	err := f.Serve(ctx)
+	var err error
+	if pf.UDP {
+		err = f.ServeUDP(ctx)
+	} else {
+		err = f.Serve(ctx)
+	}
 	if err != nil && ctx.Err() == nil {
 		dlog.Errorf(ctx, "port-forwarder failed with %v", err)
 	}
@@ -420,6 +815,7 @@ func (tm *trafficManager) workerMountForwardIntercept(ctx context.Context, mf mo
 		dlog.Errorf(ctx, "No mount point found for intercept %q", mf.Name)
 		return
 	}
+	defer tm.forwardStarted(mf.Name)()
 
 	dlog.Infof(ctx, "Mounting file system for intercept %q at %q", mf.Name, mountPoint)
 
@@ -441,38 +837,103 @@ func (tm *trafficManager) workerMountForwardIntercept(ctx context.Context, mf mo
 		mountMutex.Unlock()
 	}()
 
+	mo := &mountOptions{}
+	if v, ok := tm.mountOptions.Load(mf.Name); ok {
+		mo = v.(*mountOptions)
+	}
+	if len(mo.paths) == 0 {
+		tm.mount(ctx, mf, "/", mountPoint, mo)
+		return
+	}
+
+	// Each selected volume is mounted separately at its path below the mount point
+	var mountWg sync.WaitGroup
+	for _, p := range mo.paths {
+		localDir := filepath.Join(mountPoint, filepath.FromSlash(p))
+		if err := os.MkdirAll(localDir, 0700); err != nil {
+			dlog.Errorf(ctx, "Failed to create directory %q: %v", localDir, err)
+			continue
+		}
+		mountWg.Add(1)
+		go func(p string) {
+			defer mountWg.Done()
+			tm.mount(ctx, mf, p, localDir, mo)
+		}(p)
+	}
+	mountWg.Wait()
+
+	// Remove the directories that were created for the mounts, so that the mount point can be removed
+	// when the intercept ends. Non-empty directories are left as they are.
+	for _, p := range mo.paths {
+		for dir := filepath.Join(mountPoint, filepath.FromSlash(p)); dir != mountPoint && len(dir) > len(mountPoint); dir = filepath.Dir(dir) {
+			if err := os.Remove(dir); err != nil {
+				break
+			}
+		}
+	}
+}
+
+// mount mounts the given path of the app container at the given local directory using the mount type of
+// the given options, and keeps it mounted until the context is cancelled.
+func (tm *trafficManager) mount(ctx context.Context, mf mountForward, appPath, localDir string, mo *mountOptions) {
+	if mo.mountType == mountTypeSFTP {
+		tm.sftpMount(ctx, mf, appPath, localDir, mo.readOnly)
+	} else {
+		tm.sshfsMount(ctx, mf, appPath, localDir, mo.readOnly)
+	}
+}
+
+// sshfsMount mounts the given path of the app container at the given local directory and keeps it
+// mounted until the context is cancelled.
+func (tm *trafficManager) sshfsMount(ctx context.Context, mf mountForward, appPath, localDir string, readOnly bool) {
 	// Retry mount in case it gets disconnected
 	err := client.Retry(ctx, "sshfs", func(ctx context.Context) error {
-		dl := &net.Dialer{Timeout: 3 * time.Second}
+		dl := &net.Dialer{Timeout: client.GetTimeout(ctx, client.TimeoutProxyDial)}
 		conn, err := dl.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", mf.PodIP, mf.SftpPort))
 		if err != nil {
 			return err
 		}
 		defer conn.Close()
-		sshfsArgs := []string{
-			"-F", "none", // don't load the user's config file
-			"-f", // foreground operation
-
-			// connection settings
-			"-C", // compression
-			"-oConnectTimeout=10",
-			"-oStrictHostKeyChecking=no",     // don't bother checking the host key...
-			"-oUserKnownHostsFile=/dev/null", // and since we're not checking it, don't bother remembering it either
-			"-o", "slave",                    // Unencrypted via stdin/stdout
-
-			// mount directives
-			"-o", "follow_symlinks",
-			"-o", "allow_root", // needed to make --docker-run work as docker runs as root
-			"localhost:" + install.TelAppMountPoint, // what to mount
-			mountPoint,                              // where to mount it
-		}
+		args := sshfsArgs(appPath, localDir, readOnly)
 		exe := "sshfs"
 		if runtime.GOOS == "windows" {
 			// Use sshfs-win to launch the sshfs
-			sshfsArgs = append([]string{"cmd", "-ouid=-1", "-ogid=-1"}, sshfsArgs...)
+			args = append([]string{"cmd", "-ouid=-1", "-ogid=-1"}, args...)
 			exe = "sshfs-win"
 		}
-		return dpipe.DPipe(ctx, conn, exe, sshfsArgs...)
+		return dpipe.DPipe(ctx, conn, exe, args...)
+	}, 3*time.Second, 6*time.Second)
+
+	if err != nil && ctx.Err() == nil {
+		dlog.Error(ctx, err)
+	}
+}
+
+// sftpMount mirrors the given path of the app container in the given local directory using the built-in
+// SFTP client and keeps the mirror in sync until the context is cancelled. The mirror is removed when it ends.
+func (tm *trafficManager) sftpMount(ctx context.Context, mf mountForward, appPath, localDir string, readOnly bool) {
+	m := sftpmount.NewMount(path.Join(install.TelAppMountPoint, appPath), localDir, readOnly)
+	defer func() {
+		if err := m.Clean(); err != nil {
+			dlog.Errorf(ctx, "Failed to remove the mirror at %q: %v", localDir, err)
+		}
+	}()
+
+	// Retry in case the connection breaks. The mirror retains its state, so local changes that weren't
+	// written back are written back using the next connection.
+	err := client.Retry(ctx, "sftp", func(ctx context.Context) error {
+		dl := &net.Dialer{Timeout: client.GetTimeout(ctx, client.TimeoutProxyDial)}
+		conn, err := dl.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", mf.PodIP, mf.SftpPort))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		sc, err := sftp.NewClientPipe(conn, conn)
+		if err != nil {
+			return err
+		}
+		defer sc.Close()
+		return m.Run(ctx, sc)
 	}, 3*time.Second, 6*time.Second)
 
 	if err != nil && ctx.Err() == nil {
@@ -481,13 +942,19 @@ func (tm *trafficManager) workerMountForwardIntercept(ctx context.Context, mf mo
 }
 
 // RemoveIntercept removes one intercept by name
-func (tm *trafficManager) RemoveIntercept(c context.Context, name string) error {
+func (tm *trafficManager) RemoveIntercept(c context.Context, name string) (err error) {
+	defer func() {
+		if err == nil || grpcStatus.Code(err) == grpcCodes.NotFound {
+			tm.removeInterceptOwner(name)
+		}
+	}()
 	if ns, ok := tm.LocalIntercepts[name]; ok {
 		return tm.RemoveLocalOnlyIntercept(c, name, ns)
 	}
+	tm.localTLS.Delete(name)
 	dlog.Debugf(c, "telling manager to remove intercept %s", name)
 	<-tm.startup
-	_, err := tm.managerClient.RemoveIntercept(c, &manager.RemoveInterceptRequest2{
+	_, err = tm.managerClient.RemoveIntercept(c, &manager.RemoveInterceptRequest2{
 		Session: tm.session(),
 		Name:    name,
 	})
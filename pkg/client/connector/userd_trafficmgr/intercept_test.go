@@ -0,0 +1,422 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_k8s"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/metrics"
+)
+
+// fakePodIP is used as the IP of the intercepted pod. It's a loopback address that differs from the one
+// that the forwards listen to, so that the pod's servers can use the same port numbers as the forwards.
+const fakePodIP = "127.0.0.2"
+
+// fakePodTCPServer starts a TCP server on the fake pod that writes the given greeting to each connection
+// and returns its port.
+func fakePodTCPServer(t *testing.T, greeting string) int32 {
+	l, err := net.Listen("tcp", fakePodIP+":0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte(greeting))
+			_ = conn.Close()
+		}
+	}()
+	return int32(l.Addr().(*net.TCPAddr).Port)
+}
+
+// fakePodUDPServer starts a UDP server on the fake pod that responds to each datagram with the given
+// greeting and returns its port.
+func fakePodUDPServer(t *testing.T, greeting string) int32 {
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(fakePodIP)})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = pc.Close() })
+	go func() {
+		buf := make([]byte, 0x100)
+		for {
+			_, src, err := pc.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			_, _ = pc.WriteToUDP([]byte(greeting), src)
+		}
+	}()
+	return int32(pc.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func eventuallyRead(t *testing.T, network string, port int32) string {
+	t.Helper()
+	var data string
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial(network, fmt.Sprintf("127.0.0.1:%d", port))
+		if err != nil {
+			return false
+		}
+		defer conn.Close()
+		if network == "udp" {
+			if _, err = conn.Write([]byte("hello")); err != nil {
+				return false
+			}
+			_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			buf := make([]byte, 0x100)
+			n, err := conn.Read(buf)
+			if err != nil {
+				return false
+			}
+			data = string(buf[:n])
+			return true
+		}
+		bs, err := io.ReadAll(conn)
+		if err != nil || len(bs) == 0 {
+			return false
+		}
+		data = string(bs)
+		return true
+	}, 5*time.Second, 50*time.Millisecond)
+	return data
+}
+
+func TestStartForwards_ToPod(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("test requires that all of 127.0.0.0/8 is routed to the loopback interface")
+	}
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	envoyPort := fakePodTCPServer(t, "envoy")
+	redisPort := fakePodTCPServer(t, "redis")
+	statsdPort := fakePodUDPServer(t, "statsd")
+
+	ii := &manager.InterceptInfo{
+		Spec: &manager.InterceptSpec{
+			Name:          "echo",
+			ExtraPorts:    []int32{envoyPort, redisPort},
+			ExtraUdpPorts: []int32{statsdPort},
+		},
+		PodIp: fakePodIP,
+	}
+	tm := &trafficManager{}
+	require.True(t, tm.shouldForward(ii))
+	require.NoError(t, checkExtraPortsAvailable(ii.Spec))
+
+	wg := sync.WaitGroup{}
+	tm.startForwards(ctx, &wg, forwardKey{Name: ii.Spec.Name, PodIP: ii.PodIp}, ii.SftpPort, ii.Spec.ExtraPorts, ii.Spec.ExtraUdpPorts)
+
+	assert.Equal(t, "envoy", eventuallyRead(t, "tcp", envoyPort))
+	assert.Equal(t, "redis", eventuallyRead(t, "tcp", redisPort))
+	assert.Equal(t, "statsd", eventuallyRead(t, "udp", statsdPort))
+	assert.Equal(t, map[string]int32{"echo": 3}, tm.forwardCounts())
+
+	// The ports are busy while the forwards are alive, and released when they terminate
+	assert.Error(t, checkExtraPortsAvailable(ii.Spec))
+	cancel()
+	wg.Wait()
+	assert.NoError(t, checkExtraPortsAvailable(ii.Spec))
+	assert.Empty(t, tm.forwardCounts())
+}
+
+func TestCheckExtraPortsAvailable(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer busy.Close()
+	busyPort := int32(busy.Addr().(*net.TCPAddr).Port)
+
+	err = checkExtraPortsAvailable(&manager.InterceptSpec{ExtraPorts: []int32{busyPort}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), fmt.Sprintf("%d/TCP", busyPort))
+
+	// The same port number is available for UDP
+	assert.NoError(t, checkExtraPortsAvailable(&manager.InterceptSpec{ExtraUdpPorts: []int32{busyPort}}))
+}
+
+func TestCheckIntercept_namespaceNotManaged(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	tm := &trafficManager{
+		installer: &installer{Cluster: &userd_k8s.Cluster{Config: &userd_k8s.Config{Namespace: "default"}}},
+		startup:   make(chan struct{}),
+	}
+	close(tm.startup)
+	tm.SetManagedNamespaces(ctx, []string{"blue"})
+
+	assertNotManaged := func(t *testing.T, ns string, r *rpc.InterceptResult) {
+		t.Helper()
+		require.NotNil(t, r)
+		assert.Equal(t, rpc.InterceptError_TRAFFIC_MANAGER_ERROR, r.Error)
+		assert.Equal(t, "namespace "+ns+" is not managed by this traffic-manager", r.ErrorText)
+		assert.Equal(t, int32(errcat.User), r.ErrorCategory)
+	}
+	assertNotManaged(t, "red", tm.checkIntercept(&manager.InterceptSpec{Name: "echo", Agent: "echo", Namespace: "red"}))
+
+	// The namespace of the session is used when no namespace is given
+	assertNotManaged(t, "default", tm.checkIntercept(&manager.InterceptSpec{Name: "echo", Agent: "echo"}))
+
+	// A managed namespace passes the gate. It isn't mapped though, because the cluster has no namespaces.
+	r := tm.checkIntercept(&manager.InterceptSpec{Name: "echo", Agent: "echo", Namespace: "blue"})
+	require.NotNil(t, r)
+	assert.Equal(t, rpc.InterceptError_NO_ACCEPTABLE_WORKLOAD, r.Error)
+}
+
+func TestCreateInterceptError(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	spec := &manager.InterceptSpec{Name: "echo", Agent: "echo", Namespace: "red"}
+
+	r := createInterceptError(ctx, spec, grpcStatus.Error(grpcCodes.PermissionDenied, "namespace red is not managed by this traffic-manager"))
+	assert.Equal(t, rpc.InterceptError_TRAFFIC_MANAGER_ERROR, r.Error)
+	assert.Equal(t, "namespace red is not managed by this traffic-manager", r.ErrorText)
+	assert.Equal(t, int32(errcat.User), r.ErrorCategory)
+
+	r = createInterceptError(ctx, spec, grpcStatus.Error(grpcCodes.FailedPrecondition, "echo.red is replaced"))
+	assert.Equal(t, "echo.red is replaced", r.ErrorText)
+	assert.Equal(t, int32(errcat.User), r.ErrorCategory)
+
+	r = createInterceptError(ctx, spec, grpcStatus.Error(grpcCodes.Unavailable, "connection refused"))
+	assert.Equal(t, rpc.InterceptError_TRAFFIC_MANAGER_ERROR, r.Error)
+	assert.Contains(t, r.ErrorText, "connection refused")
+	assert.Zero(t, r.ErrorCategory)
+
+	st, err := grpcStatus.New(grpcCodes.FailedPrecondition, "echo.red is already intercepted").WithDetails(&manager.InterceptConflict{
+		Name:   "echo-alice",
+		Client: "alice@host",
+	})
+	require.NoError(t, err)
+	r = createInterceptError(ctx, spec, st.Err())
+	assert.Equal(t, `echo.red is already intercepted by alice@host (all traffic); use --steal to end intercept "echo-alice"`, r.ErrorText)
+	assert.Equal(t, int32(errcat.User), r.ErrorCategory)
+}
+
+func TestInterceptConflictError(t *testing.T) {
+	spec := &manager.InterceptSpec{Name: "echo", Agent: "echo", Namespace: "red"}
+	now := time.Date(2021, 11, 3, 14, 5, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		conflict *manager.InterceptConflict
+		expected string
+	}{
+		{
+			"all traffic",
+			&manager.InterceptConflict{
+				Name:    "echo-alice",
+				Client:  "alice@host",
+				Created: timestamppb.New(time.Date(2021, 11, 3, 10, 32, 0, 0, time.UTC)),
+			},
+			`echo.red is already intercepted by alice@host since 10:32 (all traffic); use --steal to end intercept "echo-alice"`,
+		},
+		{
+			"header matched",
+			&manager.InterceptConflict{
+				Name:           "echo-alice",
+				Client:         "alice@host",
+				Created:        timestamppb.New(time.Date(2021, 11, 2, 10, 32, 0, 0, time.UTC)),
+				HttpHeaders:    []*manager.HTTPHeaderMatch{{Name: "x-dev", ValueRegex: "alice"}},
+				HttpPathPrefix: "/api",
+			},
+			`echo.red is already intercepted by alice@host since Nov 2 10:32 (header "x-dev" matching "alice" and path prefix "/api"); ` +
+				`use --http-header with other values to share the workload, or use --steal to end intercept "echo-alice"`,
+		},
+		{
+			"replace",
+			&manager.InterceptConflict{Name: "echo-alice", Client: "alice@host", Replace: true},
+			`echo.red is replaced; use --steal to end intercept "echo-alice"`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			err := interceptConflictError(spec, tt.conflict, "echo.red is replaced", now)
+			assert.Equal(t, tt.expected, err.Error())
+			assert.Equal(t, errcat.User, errcat.GetCategory(err))
+		})
+	}
+}
+
+func TestSetCurrentIntercepts_metrics(t *testing.T) {
+	base := testutil.ToFloat64(metrics.Intercepts)
+	ii := func(name string) *manager.InterceptInfo {
+		return &manager.InterceptInfo{Spec: &manager.InterceptSpec{Name: name}}
+	}
+
+	// The gauge counts the intercepts of all sessions
+	tm1, tm2 := &trafficManager{}, &trafficManager{}
+	tm1.setCurrentIntercepts([]*manager.InterceptInfo{ii("echo"), ii("api")})
+	tm2.setCurrentIntercepts([]*manager.InterceptInfo{ii("db")})
+	assert.Equal(t, float64(3), testutil.ToFloat64(metrics.Intercepts)-base)
+	tm1.setCurrentIntercepts([]*manager.InterceptInfo{ii("echo")})
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.Intercepts)-base)
+	tm1.setCurrentIntercepts(nil)
+	tm2.setCurrentIntercepts(nil)
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.Intercepts)-base)
+}
+
+func TestLogExpiredIntercepts(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	ii := func(name string, disposition manager.InterceptDispositionType) *manager.InterceptInfo {
+		return &manager.InterceptInfo{Spec: &manager.InterceptSpec{Name: name}, Disposition: disposition}
+	}
+	logged := make(map[string]struct{})
+	assert.Empty(t, logExpiredIntercepts(ctx, []*manager.InterceptInfo{
+		ii("echo", manager.InterceptDispositionType_ACTIVE),
+		ii("web", manager.InterceptDispositionType_ACTIVE),
+	}, logged))
+
+	// Each expiry is reported once
+	snapshot := []*manager.InterceptInfo{
+		ii("echo", manager.InterceptDispositionType_EXPIRED),
+		ii("web", manager.InterceptDispositionType_ACTIVE),
+	}
+	assert.Equal(t, []string{"echo"}, logExpiredIntercepts(ctx, snapshot, logged))
+	assert.Empty(t, logExpiredIntercepts(ctx, snapshot, logged))
+
+	// The traffic-manager removes the expired intercept, after which an intercept with the same name may expire again
+	assert.Empty(t, logExpiredIntercepts(ctx, snapshot[1:], logged))
+	assert.Empty(t, logged)
+	assert.Equal(t, []string{"echo"}, logExpiredIntercepts(ctx, snapshot, logged))
+}
+
+// pendingManager is a traffic-manager whose watch of the client's intercepts delivers the snapshots
+// that are sent to it.
+type pendingManager struct {
+	manager.ManagerClient
+	snapshots chan *manager.InterceptInfoSnapshot
+}
+
+type pendingStream struct {
+	grpc.ClientStream
+	ctx       context.Context
+	snapshots chan *manager.InterceptInfoSnapshot
+}
+
+func (s *pendingStream) Recv() (*manager.InterceptInfoSnapshot, error) {
+	select {
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	case snapshot := <-s.snapshots:
+		return snapshot, nil
+	}
+}
+
+func (m *pendingManager) WatchIntercepts(ctx context.Context, _ *manager.SessionInfo, _ ...grpc.CallOption) (manager.Manager_WatchInterceptsClient, error) {
+	return &pendingStream{ctx: ctx, snapshots: m.snapshots}, nil
+}
+
+func TestWatchIntercepts_pendingBecomesActive(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	pm := &pendingManager{snapshots: make(chan *manager.InterceptInfoSnapshot)}
+	tm := &trafficManager{
+		installer:     &installer{Cluster: &userd_k8s.Cluster{}},
+		managerClient: pm,
+		startup:       make(chan struct{}),
+		sessionInfo:   &manager.SessionInfo{SessionId: "session-1"},
+	}
+	close(tm.startup)
+	done := make(chan struct{})
+	go func() {
+		_ = tm.workerPortForwardIntercepts(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitCh := make(chan interceptResult, 1)
+	tm.activeInterceptsWaiters.Store("echo", waitCh)
+	send := func(disposition manager.InterceptDispositionType, msg string) {
+		pm.snapshots <- &manager.InterceptInfoSnapshot{Intercepts: []*manager.InterceptInfo{{
+			Spec:        &manager.InterceptSpec{Name: "echo", Agent: "echo", Namespace: "default"},
+			Disposition: disposition,
+			Message:     msg,
+		}}}
+	}
+
+	// The pods of the workload are replaced when the agent is injected, so there is no agent at first
+	send(manager.InterceptDispositionType_NO_AGENT, `No agent found for "echo"`)
+	send(manager.InterceptDispositionType_WAITING, "")
+	send(manager.InterceptDispositionType_WAITING, "")
+	assert.Empty(t, waitCh, "a pending intercept is not a failure")
+	iis := tm.getCurrentIntercepts()
+	require.Len(t, iis, 1)
+	assert.True(t, interceptPending(iis[0]))
+
+	send(manager.InterceptDispositionType_ACTIVE, "")
+	select {
+	case wr := <-waitCh:
+		require.NoError(t, wr.err)
+		assert.Equal(t, manager.InterceptDispositionType_ACTIVE, wr.intercept.Disposition)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the waiter was not notified when the intercept became active")
+	}
+}
+
+func TestWaitForActiveIntercept(t *testing.T) {
+	defer func(ri time.Duration) { pendingInterceptReportInterval = ri }(pendingInterceptReportInterval)
+	pendingInterceptReportInterval = 10 * time.Millisecond
+	ctx := dlog.NewTestContext(t, false)
+
+	var notified []string
+	var mu sync.Mutex
+	tm := &trafficManager{callbacks: Callbacks{Notify: func(msg string) {
+		mu.Lock()
+		notified = append(notified, msg)
+		mu.Unlock()
+	}}}
+	pending := &manager.InterceptInfo{
+		Spec:        &manager.InterceptSpec{Name: "echo"},
+		Disposition: manager.InterceptDispositionType_NO_AGENT,
+		Message:     `No agent found for "echo"`,
+	}
+	tm.setCurrentIntercepts([]*manager.InterceptInfo{pending})
+	defer tm.setCurrentIntercepts(nil)
+
+	t.Run("timeout", func(t *testing.T) {
+		tos := &client.Timeouts{PrivateIntercept: 100 * time.Millisecond}
+		tc, cancel := tos.TimeoutContext(ctx, client.TimeoutIntercept)
+		defer cancel()
+		_, err := tm.waitForActiveIntercept(tc, "echo", make(chan interceptResult))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "the intercept timed out")
+
+		// The reason is reported once, no matter how many times it's checked
+		mu.Lock()
+		assert.Equal(t, []string{`Intercept echo is pending: No agent found for "echo"`}, notified)
+		mu.Unlock()
+	})
+
+	t.Run("active", func(t *testing.T) {
+		waitCh := make(chan interceptResult, 1)
+		active := &manager.InterceptInfo{Spec: pending.Spec, Disposition: manager.InterceptDispositionType_ACTIVE}
+		waitCh <- interceptResult{intercept: active}
+		ii, err := tm.waitForActiveIntercept(ctx, "echo", waitCh)
+		require.NoError(t, err)
+		assert.Equal(t, active, ii)
+
+		waitCh <- interceptResult{err: errors.New("intercept in error state AGENT_ERROR")}
+		_, err = tm.waitForActiveIntercept(ctx, "echo", waitCh)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,73 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strconv"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+// localTLS is the TLS that the connections of an intercept are encrypted with toward the local server,
+// after the traffic-agent terminated their original TLS.
+type localTLS struct {
+	// target is the address of the local server, as it appears in the dial requests of the intercept
+	target string
+	config *tls.Config
+}
+
+// newLocalTLS returns the local TLS of the given intercept request, or nil when the request doesn't use
+// local_tls. The certificate of the local server is verified using the local_ca of the request, and isn't
+// verified at all when the request has none.
+func newLocalTLS(spec *manager.InterceptSpec, ir *rpc.CreateInterceptRequest) (*localTLS, error) {
+	if !ir.LocalTls {
+		return nil, nil
+	}
+	config := &tls.Config{InsecureSkipVerify: true}
+	if len(ir.LocalCa) > 0 {
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(ir.LocalCa) {
+			return nil, errcat.User.New("the local CA contains no PEM encoded certificates")
+		}
+		config = &tls.Config{RootCAs: roots}
+	}
+	host := spec.TargetHost
+	if ip := iputil.Parse(host); ip != nil {
+		host = ip.String()
+	}
+	return &localTLS{
+		target: net.JoinHostPort(host, strconv.Itoa(int(spec.TargetPort))),
+		config: config,
+	}, nil
+}
+
+// localTLSConfig returns the configuration that connections to the given destination are encrypted with,
+// or nil when they are not encrypted.
+func (tm *trafficManager) localTLSConfig(destination string) *tls.Config {
+	var config *tls.Config
+	tm.localTLS.Range(func(_, value interface{}) bool {
+		if lt := value.(*localTLS); lt.target == destination {
+			config = lt.config
+			return false
+		}
+		return true
+	})
+	return config
+}
+
+// reconcileLocalTLS forgets the local TLS of intercepts that no longer exist.
+func (tm *trafficManager) reconcileLocalTLS(c context.Context, existingIntercepts map[string]struct{}) {
+	tm.localTLS.Range(func(key, _ interface{}) bool {
+		if _, ok := existingIntercepts[key.(string)]; !ok {
+			dlog.Debugf(c, "Dropping local TLS of intercept %s", key)
+			tm.localTLS.Delete(key)
+		}
+		return true
+	})
+}
@@ -0,0 +1,36 @@
+package userd_trafficmgr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+func TestLocalTLS(t *testing.T) {
+	spec := &manager.InterceptSpec{Name: "echo", TargetHost: "127.0.0.1", TargetPort: 8443}
+	tm := &trafficManager{}
+
+	lt, err := newLocalTLS(spec, &rpc.CreateInterceptRequest{Spec: spec})
+	require.NoError(t, err)
+	assert.Nil(t, lt)
+
+	lt, err = newLocalTLS(spec, &rpc.CreateInterceptRequest{Spec: spec, LocalTls: true})
+	require.NoError(t, err)
+	require.NotNil(t, lt)
+	assert.True(t, lt.config.InsecureSkipVerify, "the local server isn't verified without a CA")
+	tm.localTLS.Store(spec.Name, lt)
+
+	assert.Equal(t, lt.config, tm.localTLSConfig("127.0.0.1:8443"))
+	assert.Nil(t, tm.localTLSConfig("127.0.0.1:8080"))
+
+	tm.reconcileLocalTLS(dlog.NewTestContext(t, false), map[string]struct{}{"other": {}})
+	assert.Nil(t, tm.localTLSConfig("127.0.0.1:8443"), "the local TLS of an intercept that ended is forgotten")
+
+	_, err = newLocalTLS(spec, &rpc.CreateInterceptRequest{Spec: spec, LocalTls: true, LocalCa: []byte("not a certificate")})
+	assert.Error(t, err)
+}
@@ -0,0 +1,105 @@
+package userd_trafficmgr
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// mountTypeSFTP is the mount type that mirrors the remote volumes using the built-in SFTP client
+// instead of mounting them using sshfs.
+const mountTypeSFTP = "sftp"
+
+// mountOptions are the options of the remote volume mounts of an intercept.
+type mountOptions struct {
+	readOnly bool
+
+	// mountType is "sftp" when the remote volumes are mirrored, and "sshfs" or empty when they are mounted
+	mountType string
+
+	// paths are the paths in the app container to mount. All volumes are mounted when it's empty.
+	paths []string
+}
+
+// selectVolumeMounts returns the paths in the app container of the volumes that are selected by the given
+// "<name>[:<subpath>]" selectors. A selector that doesn't match a volume of the app container is an error that
+// names the available volumes.
+func selectVolumeMounts(agent *manager.AgentInfo, selectors []string) ([]string, error) {
+	vms := agent.GetVolumeMounts()
+	if len(vms) == 0 {
+		return nil, errcat.User.Newf(
+			"the traffic-agent of %s.%s doesn't report any volumes. A traffic-agent that is older than the client "+
+				"doesn't report its volumes. Use \"telepresence uninstall --agent %s\" to remove the old agent so "+
+				"that it's replaced with a new one on the next intercept", agent.Name, agent.Namespace, agent.Name)
+	}
+	var paths []string
+	seen := make(map[string]struct{})
+	for _, selector := range selectors {
+		name, subPath := selector, ""
+		if colon := strings.IndexByte(selector, ':'); colon >= 0 {
+			name, subPath = selector[:colon], selector[colon+1:]
+			if subPath == "" || path.IsAbs(subPath) || path.Clean(subPath) != subPath || subPath == ".." || strings.HasPrefix(subPath, "../") {
+				return nil, errcat.User.Newf("invalid volume selector %q: the subpath must be a clean relative path within the volume", selector)
+			}
+		}
+		found := false
+		for _, vm := range vms {
+			if vm.Name == name {
+				found = true
+				p := path.Join(vm.MountPath, subPath)
+				if _, ok := seen[p]; !ok {
+					seen[p] = struct{}{}
+					paths = append(paths, p)
+				}
+			}
+		}
+		if !found {
+			// A volume can be mounted more than once
+			names := make([]string, 0, len(vms))
+			for _, vm := range vms {
+				names = append(names, vm.Name)
+			}
+			sort.Strings(names)
+			var unique []string
+			for _, n := range names {
+				if len(unique) == 0 || unique[len(unique)-1] != n {
+					unique = append(unique, n)
+				}
+			}
+			return nil, errcat.User.Newf("the container intercepted by %s.%s has no volume %q. Available volumes are: %s",
+				agent.Name, agent.Namespace, name, strings.Join(unique, ", "))
+		}
+	}
+	return paths, nil
+}
+
+// sshfsArgs returns the arguments of the sshfs command that mounts the given path of the app container at
+// the given local directory.
+func sshfsArgs(appPath, localDir string, readOnly bool) []string {
+	args := []string{
+		"-F", "none", // don't load the user's config file
+		"-f", // foreground operation
+
+		// connection settings
+		"-C", // compression
+		"-oConnectTimeout=10",
+		"-oStrictHostKeyChecking=no",     // don't bother checking the host key...
+		"-oUserKnownHostsFile=/dev/null", // and since we're not checking it, don't bother remembering it either
+		"-o", "slave",                    // Unencrypted via stdin/stdout
+
+		// mount directives
+		"-o", "follow_symlinks",
+		"-o", "allow_root", // needed to make --docker-run work as docker runs as root
+	}
+	if readOnly {
+		args = append(args, "-o", "ro")
+	}
+	return append(args,
+		"localhost:"+path.Join(install.TelAppMountPoint, appPath), // what to mount
+		localDir, // where to mount it
+	)
+}
@@ -0,0 +1,92 @@
+package userd_trafficmgr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+func Test_selectVolumeMounts(t *testing.T) {
+	agent := &manager.AgentInfo{
+		Name:      "echo",
+		Namespace: "default",
+		VolumeMounts: []*manager.AgentInfo_VolumeMount{
+			{Name: "config", MountPath: "/etc/echo"},
+			{Name: "data", MountPath: "/var/lib/echo"},
+			{Name: "data", MountPath: "/var/cache/echo"},
+			{Name: "kube-api-access-x7q2z", MountPath: "/var/run/secrets/kubernetes.io/serviceaccount"},
+		},
+	}
+	tests := []struct {
+		name      string
+		selectors []string
+		expected  []string
+		err       string
+	}{
+		{
+			name:      "one volume",
+			selectors: []string{"config"},
+			expected:  []string{"/etc/echo"},
+		},
+		{
+			name:      "volume mounted twice",
+			selectors: []string{"data", "config"},
+			expected:  []string{"/var/lib/echo", "/var/cache/echo", "/etc/echo"},
+		},
+		{
+			name:      "subpath",
+			selectors: []string{"config:certs/ca", "config:certs/ca"},
+			expected:  []string{"/etc/echo/certs/ca"},
+		},
+		{
+			name:      "missing volume",
+			selectors: []string{"config", "logs"},
+			err:       `the container intercepted by echo.default has no volume "logs". Available volumes are: config, data, kube-api-access-x7q2z`,
+		},
+		{
+			name:      "absolute subpath",
+			selectors: []string{"config:/etc"},
+			err:       `invalid volume selector "config:/etc"`,
+		},
+		{
+			name:      "subpath outside of volume",
+			selectors: []string{"config:../passwd"},
+			err:       `invalid volume selector "config:../passwd"`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			paths, err := selectVolumeMounts(agent, tt.selectors)
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, paths)
+		})
+	}
+
+	t.Run("old agent", func(t *testing.T) {
+		_, err := selectVolumeMounts(&manager.AgentInfo{Name: "echo", Namespace: "default"}, []string{"config"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "doesn't report any volumes")
+	})
+}
+
+func Test_sshfsArgs(t *testing.T) {
+	t.Run("all volumes", func(t *testing.T) {
+		args := sshfsArgs("/", "/tmp/tel-mount", false)
+		assert.Equal(t, []string{"localhost:/tel_app_mounts", "/tmp/tel-mount"}, args[len(args)-2:])
+		assert.NotContains(t, args, "ro")
+	})
+
+	t.Run("read-only volume", func(t *testing.T) {
+		args := sshfsArgs("/etc/echo", "/tmp/tel-mount/etc/echo", true)
+		assert.Equal(t, []string{"-o", "ro", "localhost:/tel_app_mounts/etc/echo", "/tmp/tel-mount/etc/echo"}, args[len(args)-4:])
+	})
+}
@@ -0,0 +1,132 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+)
+
+// orphanGracePeriod is the time that an intercept must have been without a hold before its owner is
+// checked. It gives the CLI time to remove the intercept after the handler has exited.
+var orphanGracePeriod = 5 * time.Second
+
+// orphanCheckInterval is the interval between the checks for orphaned intercepts.
+var orphanCheckInterval = 2 * time.Second
+
+// processAlive returns true if a process with the given id exists.
+var processAlive = proc.IsAlive
+
+// interceptOwner is the CLI process that runs the handler of an intercept.
+type interceptOwner struct {
+	pid int32
+
+	// holds is the number of HoldIntercept calls for the intercept that are in progress
+	holds int
+
+	// unheldSince is when the last hold ended, or when the owner was registered if no hold has
+	// started yet.
+	unheldSince time.Time
+}
+
+// setInterceptOwner registers the process with the given id as the owner of the named intercept.
+func (tm *trafficManager) setInterceptOwner(name string, pid int32) {
+	tm.interceptOwnersLock.Lock()
+	defer tm.interceptOwnersLock.Unlock()
+	if tm.interceptOwners == nil {
+		tm.interceptOwners = make(map[string]*interceptOwner)
+	}
+	tm.interceptOwners[name] = &interceptOwner{pid: pid, unheldSince: time.Now()}
+}
+
+func (tm *trafficManager) removeInterceptOwner(name string) {
+	tm.interceptOwnersLock.Lock()
+	delete(tm.interceptOwners, name)
+	tm.interceptOwnersLock.Unlock()
+}
+
+// getInterceptOwners returns the process ids of the owners of intercepts, keyed by intercept name.
+func (tm *trafficManager) getInterceptOwners() map[string]int32 {
+	tm.interceptOwnersLock.Lock()
+	defer tm.interceptOwnersLock.Unlock()
+	if len(tm.interceptOwners) == 0 {
+		return nil
+	}
+	pids := make(map[string]int32, len(tm.interceptOwners))
+	for name, owner := range tm.interceptOwners {
+		pids[name] = owner.pid
+	}
+	return pids
+}
+
+// HoldIntercept holds the named intercept on behalf of its owner until the context is cancelled,
+// i.e. until the owner ends the call or its connection breaks.
+func (tm *trafficManager) HoldIntercept(c context.Context, name string) error {
+	tm.interceptOwnersLock.Lock()
+	owner, ok := tm.interceptOwners[name]
+	if ok {
+		owner.holds++
+	}
+	tm.interceptOwnersLock.Unlock()
+	if !ok {
+		return errcat.User.Newf("intercept %s has no owner", name)
+	}
+
+	<-c.Done()
+	tm.interceptOwnersLock.Lock()
+	if owner.holds--; owner.holds == 0 {
+		owner.unheldSince = time.Now()
+	}
+	tm.interceptOwnersLock.Unlock()
+	return nil
+}
+
+// orphans returns the names of the intercepts that have been without a hold for longer than the grace
+// period and whose owner no longer exists.
+func (tm *trafficManager) orphans(now time.Time) []string {
+	tm.interceptOwnersLock.Lock()
+	defer tm.interceptOwnersLock.Unlock()
+	var names []string
+	for name, owner := range tm.interceptOwners {
+		if owner.holds == 0 && now.Sub(owner.unheldSince) >= orphanGracePeriod && !processAlive(int(owner.pid)) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// orphanWatcher removes the intercepts whose owner has disappeared without removing them, e.g.
+// because the terminal of the CLI that ran the handler was closed.
+func (tm *trafficManager) orphanWatcher(c context.Context) error {
+	ticker := time.NewTicker(orphanCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.Done():
+			return nil
+		case <-ticker.C:
+		}
+		tm.removeOrphans(c)
+	}
+}
+
+func (tm *trafficManager) removeOrphans(c context.Context) {
+	for _, name := range tm.orphans(time.Now()) {
+		pid := tm.getInterceptOwners()[name]
+		switch err := tm.RemoveIntercept(c, name); {
+		case err == nil:
+			dlog.Infof(c, "AUDIT: removed intercept %s because its owner, process %d, no longer exists", name, pid)
+		case grpcStatus.Code(err) == grpcCodes.NotFound:
+			dlog.Debugf(c, "intercept %s of process %d, which no longer exists, is already removed", name, pid)
+		default:
+			dlog.Errorf(c, "unable to remove intercept %s of process %d, which no longer exists: %v", name, pid, err)
+		}
+	}
+}
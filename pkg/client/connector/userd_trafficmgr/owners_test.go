@@ -0,0 +1,131 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_k8s"
+)
+
+// fakeProcesses is the set of processes that exist.
+type fakeProcesses struct {
+	sync.Mutex
+	alive map[int]bool
+}
+
+func (p *fakeProcesses) isAlive(pid int) bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.alive[pid]
+}
+
+func (p *fakeProcesses) exit(pid int) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.alive, pid)
+}
+
+func TestOrphanWatcher(t *testing.T) {
+	defer func(gp, ci time.Duration, pa func(int) bool) {
+		orphanGracePeriod = gp
+		orphanCheckInterval = ci
+		processAlive = pa
+	}(orphanGracePeriod, orphanCheckInterval, processAlive)
+	orphanGracePeriod = 50 * time.Millisecond
+	orphanCheckInterval = 10 * time.Millisecond
+	procs := &fakeProcesses{alive: map[int]bool{100: true, 200: true}}
+	processAlive = procs.isAlive
+
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	mgr := newFakeManager()
+	tm := &trafficManager{
+		installer:     &installer{Cluster: &userd_k8s.Cluster{}},
+		managerClient: mgr,
+		startup:       make(chan struct{}),
+	}
+	close(tm.startup)
+	si, err := mgr.ArriveAsClient(ctx, &manager.ClientInfo{})
+	require.NoError(t, err)
+	tm.setSession(si)
+	for _, name := range []string{"echo", "api", "db"} {
+		_, err = mgr.CreateIntercept(ctx, &manager.CreateInterceptRequest{
+			Session:       si,
+			InterceptSpec: &manager.InterceptSpec{Name: name, Namespace: "default", Agent: name},
+		})
+		require.NoError(t, err)
+	}
+	tm.setInterceptOwner("echo", 100)
+	tm.setInterceptOwner("api", 200)
+	assert.Equal(t, map[string]int32{"echo": 100, "api": 200}, tm.getInterceptOwners())
+	assert.Error(t, tm.HoldIntercept(ctx, "db"), "an intercept without owner can't be held")
+
+	holdCtx, endHold := context.WithCancel(ctx)
+	held := make(chan error, 1)
+	go func() { held <- tm.HoldIntercept(holdCtx, "echo") }()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = tm.orphanWatcher(ctx)
+	}()
+
+	// The owner of echo is gone, but its hold is still in place
+	procs.exit(100)
+	time.Sleep(4 * orphanGracePeriod)
+	assert.Len(t, mgr.interceptSessions(), 3)
+
+	// The hold breaks, e.g. because the terminal of the CLI was closed
+	endHold()
+	require.NoError(t, <-held)
+	assert.Eventually(t, func() bool {
+		_, ok := mgr.interceptSessions()["echo"]
+		return !ok
+	}, 5*time.Second, 10*time.Millisecond, "the orphaned intercept was not removed")
+	assert.Equal(t, map[string]int32{"api": 200}, tm.getInterceptOwners())
+
+	// The owner of api never held its intercept
+	procs.exit(200)
+	assert.Eventually(t, func() bool {
+		_, ok := mgr.interceptSessions()["api"]
+		return !ok
+	}, 5*time.Second, 10*time.Millisecond, "the orphaned intercept was not removed")
+	assert.Empty(t, tm.getInterceptOwners())
+	assert.Equal(t, map[string]string{"db": si.SessionId}, mgr.interceptSessions())
+
+	cancel()
+	<-done
+}
+
+func TestRemoveIntercept_removesOwner(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	mgr := newFakeManager()
+	tm := &trafficManager{
+		installer:     &installer{Cluster: &userd_k8s.Cluster{}},
+		managerClient: mgr,
+		startup:       make(chan struct{}),
+	}
+	close(tm.startup)
+	si, err := mgr.ArriveAsClient(ctx, &manager.ClientInfo{})
+	require.NoError(t, err)
+	tm.setSession(si)
+	_, err = mgr.CreateIntercept(ctx, &manager.CreateInterceptRequest{
+		Session:       si,
+		InterceptSpec: &manager.InterceptSpec{Name: "echo", Namespace: "default", Agent: "echo"},
+	})
+	require.NoError(t, err)
+	tm.setInterceptOwner("echo", 100)
+	tm.setInterceptOwner("gone", 100)
+
+	require.NoError(t, tm.RemoveIntercept(ctx, "echo"))
+	require.Error(t, tm.RemoveIntercept(ctx, "gone"))
+	assert.Empty(t, tm.getInterceptOwners())
+}
@@ -0,0 +1,299 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/pmezard/go-difflib/difflib"
+	authv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/yaml"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_k8s"
+	"github.com/telepresenceio/telepresence/v2/pkg/forwarder"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// plannedChange is a change that an agentPlan makes to the cluster, and the access that the change
+// requires.
+type plannedChange struct {
+	*rpc.ObjectChange
+	access []*authv1.ResourceAttributes
+}
+
+// PlanIntercept resolves the workload and service of the given intercept the same way as AddIntercept
+// does, and reports the changes that installing the traffic-agent makes to the cluster. Nothing is
+// changed, and the traffic-manager isn't asked to create the intercept.
+func (tm *trafficManager) PlanIntercept(c context.Context, ir *rpc.CreateInterceptRequest) (*rpc.InterceptPlan, error) {
+	spec := ir.Spec
+	plan := &rpc.InterceptPlan{Spec: spec}
+	if plan.Failure = tm.checkIntercept(spec); plan.Failure != nil {
+		return plan, nil
+	}
+	if spec.Agent == "" {
+		// A local-only intercept doesn't change the cluster
+		return plan, nil
+	}
+
+	spec.Client = tm.userAndHost
+	if spec.Mechanism == "" {
+		spec.Mechanism = "tcp"
+	}
+
+	obj, err := tm.FindWorkload(c, spec.Namespace, spec.Agent)
+	if err != nil {
+		plan.Failure = agentError(spec.Agent, err)
+		return plan, nil
+	}
+	if spec.Replace {
+		if err = replaceError(obj); err != nil {
+			plan.Failure = interceptError(rpc.InterceptError_FAILED_TO_ESTABLISH, err)
+			return plan, nil
+		}
+	}
+	p, err := tm.planAgent(c, obj, spec.ServiceName, spec.ServicePortIdentifier, spec.ContainerName, tm.agentImageFor(ir.AgentImage),
+		servicePortIdentifiers(spec.ExtraPortMappings), tm.agentPullSecrets, tm.agentConfig)
+	if err != nil {
+		plan.Failure = agentError(spec.Agent, err)
+		return plan, nil
+	}
+	spec.ServiceUid = string(p.svc.GetUID())
+	spec.WorkloadKind = p.kind
+	spec.ContainerName = p.container
+	spec.ContainerPort = p.containerPort
+	for i, pm := range spec.ExtraPortMappings {
+		pm.ContainerPort = p.extraContainerPorts[i]
+	}
+	plan.ServiceName = p.svc.Name
+	plan.ServicePort = p.svcPort
+
+	changes, err := p.changes(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 && (forwarder.HasHTTPConditions(spec) || spec.AgentTlsSecret != "" || len(spec.ExtraPortMappings) > 0) {
+		// A traffic-agent that is installed now is always recent enough
+		agents, err := tm.getAgentsForVersionCheck(c, spec.Agent, spec.Namespace)
+		if err == nil && forwarder.HasHTTPConditions(spec) {
+			err = checkAgentsSupportHTTPConditions(spec.Agent, spec.Namespace, agents)
+		}
+		if err == nil && spec.AgentTlsSecret != "" {
+			err = checkAgentsSupportTLSTermination(spec.Agent, spec.Namespace, agents)
+		}
+		if err == nil && len(spec.ExtraPortMappings) > 0 {
+			err = checkAgentsSupportExtraPorts(spec.Agent, spec.Namespace, agents)
+		}
+		if err != nil {
+			plan.Failure = interceptError(rpc.InterceptError_FAILED_TO_ESTABLISH, err)
+			return plan, nil
+		}
+	}
+	if _, err := newLocalTLS(spec, ir); err != nil {
+		plan.Failure = interceptError(rpc.InterceptError_FAILED_TO_ESTABLISH, err)
+		return plan, nil
+	}
+	if err := checkExtraPortsAvailable(spec); err != nil {
+		plan.Failure = interceptError(rpc.InterceptError_FAILED_TO_ESTABLISH, err)
+		return plan, nil
+	}
+
+	for _, pc := range changes {
+		plan.Changes = append(plan.Changes, pc.ObjectChange)
+		for _, ra := range pc.access {
+			ok, err := tm.CanI(c, ra)
+			if err != nil {
+				dlog.Warn(c, err)
+				continue
+			}
+			if !ok {
+				plan.MissingPermissions = append(plan.MissingPermissions, userd_k8s.DescribeAccess(ra))
+			}
+		}
+	}
+	return plan, nil
+}
+
+// changes returns the changes that applying the plan at the given time makes to the cluster.
+func (p *agentPlan) changes(now time.Time) ([]*plannedChange, error) {
+	var pcs []*plannedChange
+	obj := p.obj
+	if p.enableInjection {
+		patched, err := mergePatch(obj, userd_k8s.RolloutInjectionPatch)
+		if err != nil {
+			return nil, err
+		}
+		description := "enable the injection of the traffic-agent by the mutating webhook"
+		if userd_k8s.IsCanaryRollout(obj.(*kates.Unstructured)) {
+			description += ". " + canaryRolloutWarning(obj)
+		}
+		pc, err := newPlannedChange(obj, patched, "patch", description)
+		if err != nil {
+			return nil, err
+		}
+		pcs = append(pcs, pc)
+	}
+
+	if p.restart {
+		var patched kates.Object
+		var err error
+		if userd_k8s.IsRollout(obj) {
+			patched, err = mergePatch(obj, userd_k8s.RolloutRestartPatch(now))
+		} else {
+			patched, err = strategicPatch(obj, restartPatch(now))
+		}
+		if err != nil {
+			return nil, err
+		}
+		pc, err := newPlannedChange(obj, patched, "patch", "restart the pods so that the mutating webhook injects the traffic-agent")
+		if err != nil {
+			return nil, err
+		}
+		pc.addPodDeletion(obj)
+		pcs = append(pcs, pc)
+	}
+
+	if p.updatedObj != nil {
+		description := "add the traffic-agent"
+		if podTemplate, err := install.GetPodTemplateFromObject(obj); err == nil {
+			for i := range podTemplate.Spec.Containers {
+				if podTemplate.Spec.Containers[i].Name == install.AgentContainerName {
+					description = "update the image of the traffic-agent"
+					break
+				}
+			}
+		}
+		if p.missingWebhook {
+			description += ", because the mutating webhook that the workload is annotated for isn't installed"
+		}
+		pc, err := newPlannedChange(obj, p.updatedObj, "update", description)
+		if err != nil {
+			return nil, err
+		}
+		pc.addPodDeletion(obj)
+		pcs = append(pcs, pc)
+	}
+
+	if p.updatedSvc != nil {
+		pc, err := newPlannedChange(p.svc, p.updatedSvc, "update", "let the intercepted port target the traffic-agent")
+		if err != nil {
+			return nil, err
+		}
+		pcs = append(pcs, pc)
+	}
+	return pcs, nil
+}
+
+// newPlannedChange returns the change that turns the given object into the changed one using the given
+// verb.
+func newPlannedChange(obj, changed kates.Object, verb, description string) (*plannedChange, error) {
+	diff, err := objectDiff(obj, changed)
+	if err != nil {
+		return nil, err
+	}
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	return &plannedChange{
+		ObjectChange: &rpc.ObjectChange{
+			Kind:        gvk.Kind,
+			Name:        obj.GetName(),
+			Namespace:   obj.GetNamespace(),
+			Verb:        verb,
+			Description: description,
+			Diff:        diff,
+		},
+		access: []*authv1.ResourceAttributes{{
+			Namespace: obj.GetNamespace(),
+			Verb:      verb,
+			Group:     gvk.Group,
+			Resource:  strings.ToLower(gvk.Kind) + "s",
+			Name:      obj.GetName(),
+		}},
+	}, nil
+}
+
+// addPodDeletion adds the deletion of pods to the change of the given workload, if the pods of the
+// workload must be deleted in order to be restarted.
+func (pc *plannedChange) addPodDeletion(obj kates.Object) {
+	if _, ok := obj.(*kates.ReplicaSet); !ok && onDeleteRestartWarning(obj) == "" {
+		return
+	}
+	pc.Description += ". The pods are deleted so that they are recreated"
+	pc.access = append(pc.access, &authv1.ResourceAttributes{
+		Namespace: obj.GetNamespace(),
+		Verb:      "delete",
+		Resource:  "pods",
+	})
+}
+
+// objectDiff returns a unified diff between the YAML of the given objects.
+func objectDiff(obj, changed kates.Object) (string, error) {
+	toLines := func(obj kates.Object) ([]string, error) {
+		obj = obj.DeepCopyObject().(kates.Object)
+		obj.SetManagedFields(nil)
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		lines := strings.SplitAfter(string(data), "\n")
+		return lines[:len(lines)-1], nil
+	}
+	a, err := toLines(obj)
+	if err != nil {
+		return "", err
+	}
+	b, err := toLines(changed)
+	if err != nil {
+		return "", err
+	}
+	path := strings.ToLower(obj.GetObjectKind().GroupVersionKind().Kind) + "/" + obj.GetName()
+	if ns := obj.GetNamespace(); ns != "" {
+		path += "." + ns
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        a,
+		B:        b,
+		FromFile: "a/" + path,
+		ToFile:   "b/" + path,
+		Context:  3,
+	})
+}
+
+// mergePatch returns a copy of the given object with the given JSON merge patch applied.
+func mergePatch(obj kates.Object, patch string) (kates.Object, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = jsonpatch.MergePatch(data, []byte(patch)); err != nil {
+		return nil, err
+	}
+	return unmarshalCopy(obj, data)
+}
+
+// strategicPatch returns a copy of the given object with the given strategic merge patch applied.
+func strategicPatch(obj kates.Object, patch string) (kates.Object, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = strategicpatch.StrategicMergePatch(data, []byte(patch), obj); err != nil {
+		return nil, err
+	}
+	return unmarshalCopy(obj, data)
+}
+
+// unmarshalCopy returns a new object of the same type as the given one that is unmarshalled from the given
+// JSON. A deep copy can't be used, because unmarshalling into it retains the map entries that the JSON lacks.
+func unmarshalCopy(obj kates.Object, data []byte) (kates.Object, error) {
+	cp := reflect.New(reflect.TypeOf(obj).Elem()).Interface().(kates.Object)
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
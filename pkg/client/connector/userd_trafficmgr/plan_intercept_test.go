@@ -0,0 +1,177 @@
+package userd_trafficmgr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_k8s"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+	"github.com/telepresenceio/telepresence/v2/pkg/version"
+)
+
+func Test_agentPlanChanges(t *testing.T) {
+	const agentImage = "docker.io/datawire/tel2:2.999.999"
+	ctx := dlog.NewTestContext(t, false)
+	oldVersion := version.Version
+	version.Version = "v2.999.999-plan"
+	t.Cleanup(func() { version.Version = oldVersion })
+	now := time.Date(2021, 10, 15, 12, 0, 0, 0, time.UTC)
+
+	load := func(t *testing.T, filename string) (kates.Object, *kates.Service) {
+		t.Helper()
+		obj, svc, _, err := loadFile(filename, version.Version)
+		require.NoError(t, err)
+		return obj, svc
+	}
+
+	// withInjection returns the given workload with the annotation that makes the webhook inject the agent
+	withInjection := func(obj kates.Object) kates.Object {
+		podTemplate, err := install.GetPodTemplateFromObject(obj)
+		require.NoError(t, err)
+		podTemplate.Annotations = map[string]string{install.InjectAnnotation: "enabled"}
+		return obj
+	}
+
+	tests := []struct {
+		name   string
+		plan   func(t *testing.T) *agentPlan
+		access []string
+	}{
+		{
+			name: "annotation-restart",
+			plan: func(t *testing.T) *agentPlan {
+				obj, svc := load(t, "cur/deployment-tc-0.input.yaml")
+				return &agentPlan{obj: withInjection(obj), kind: "Deployment", svc: svc, restart: true}
+			},
+			access: []string{"patch deployments.extensions/hello-0 in namespace telepresence-5759"},
+		},
+		{
+			name: "annotation-restart-replicaset",
+			plan: func(t *testing.T) *agentPlan {
+				obj, svc := load(t, "cur/replicaset-tc-0.input.yaml")
+				return &agentPlan{obj: withInjection(obj), kind: "ReplicaSet", svc: svc, restart: true}
+			},
+			access: []string{
+				"patch replicasets.apps/app",
+				"delete pods",
+			},
+		},
+		{
+			name: "annotation-rollout",
+			plan: func(t *testing.T) *agentPlan {
+				ro := &kates.Unstructured{Object: map[string]interface{}{
+					"apiVersion": "argoproj.io/v1alpha1",
+					"kind":       "Rollout",
+					"metadata":   map[string]interface{}{"name": "web", "namespace": "default"},
+					"spec": map[string]interface{}{
+						"strategy": map[string]interface{}{"canary": map[string]interface{}{}},
+						"template": map[string]interface{}{
+							"metadata": map[string]interface{}{"labels": map[string]interface{}{"app": "web"}},
+						},
+					},
+				}}
+				return &agentPlan{obj: ro, kind: userd_k8s.RolloutKind, enableInjection: true}
+			},
+			access: []string{"patch rollouts.argoproj.io/web in namespace default"},
+		},
+		{
+			name: "annotation-rollout-restart",
+			plan: func(t *testing.T) *agentPlan {
+				ro := &kates.Unstructured{Object: map[string]interface{}{
+					"apiVersion": "argoproj.io/v1alpha1",
+					"kind":       "Rollout",
+					"metadata":   map[string]interface{}{"name": "web", "namespace": "default"},
+					"spec": map[string]interface{}{
+						"strategy": map[string]interface{}{"blueGreen": map[string]interface{}{}},
+						"template": map[string]interface{}{
+							"metadata": map[string]interface{}{
+								"annotations": map[string]interface{}{install.InjectAnnotation: "enabled"},
+								"labels":      map[string]interface{}{"app": "web"},
+							},
+						},
+					},
+				}}
+				return &agentPlan{obj: ro, kind: userd_k8s.RolloutKind, restart: true}
+			},
+			access: []string{"patch rollouts.argoproj.io/web in namespace default"},
+		},
+		{
+			name: "legacy-add",
+			plan: func(t *testing.T) *agentPlan {
+				obj, svc := load(t, "cur/deployment-tc-0.input.yaml")
+				updatedObj, updatedSvc, err := addAgentToWorkload(ctx, "", nil, "", agentImage, nil, nil, "ambassador", deepCopyObject(obj), svc.DeepCopy())
+				require.NoError(t, err)
+				return &agentPlan{obj: obj, kind: "Deployment", svc: svc, updatedObj: updatedObj, updatedSvc: updatedSvc}
+			},
+			access: []string{
+				"update deployments.extensions/hello-0 in namespace telepresence-5759",
+				"update services/hello-0 in namespace telepresence-5759",
+			},
+		},
+		{
+			name: "missing-webhook",
+			plan: func(t *testing.T) *agentPlan {
+				obj, svc := load(t, "cur/deployment-tc-0.input.yaml")
+				obj = withInjection(obj)
+				updatedObj, updatedSvc, err := addAgentToWorkload(ctx, "", nil, "", agentImage, nil, nil, "ambassador", deepCopyObject(obj), svc.DeepCopy())
+				require.NoError(t, err)
+				return &agentPlan{obj: obj, kind: "Deployment", svc: svc, missingWebhook: true, updatedObj: updatedObj, updatedSvc: updatedSvc}
+			},
+			access: []string{
+				"update deployments.extensions/hello-0 in namespace telepresence-5759",
+				"update services/hello-0 in namespace telepresence-5759",
+			},
+		},
+		{
+			name: "legacy-update",
+			plan: func(t *testing.T) *agentPlan {
+				obj, svc := load(t, "cur/deployment-tc-0.output.yaml")
+				updatedObj := deepCopyObject(obj)
+				podTemplate, err := install.GetPodTemplateFromObject(updatedObj)
+				require.NoError(t, err)
+				for i := range podTemplate.Spec.Containers {
+					if c := &podTemplate.Spec.Containers[i]; c.Name == install.AgentContainerName {
+						c.Image = agentImage
+					}
+				}
+				return &agentPlan{obj: obj, kind: "Deployment", svc: svc, updatedObj: updatedObj}
+			},
+			access: []string{"update deployments.extensions/hello-0 in namespace telepresence-5759"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			pcs, err := tt.plan(t).changes(now)
+			require.NoError(t, err)
+
+			sb := strings.Builder{}
+			var access []string
+			for _, pc := range pcs {
+				fmt.Fprintf(&sb, "%s %s %s: %s\n%s", pc.Verb, pc.Kind, pc.Name, pc.Description, pc.Diff)
+				for _, ra := range pc.access {
+					access = append(access, userd_k8s.DescribeAccess(ra))
+				}
+			}
+			assert.Equal(t, tt.access, access)
+
+			goldFile := filepath.Join("testdata", "planIntercept", tt.name+".diff")
+			actual := strings.ReplaceAll(sb.String(), strings.TrimPrefix(version.Version, "v"), "{{.Version}}")
+			if os.Getenv("DEV_TELEPRESENCE_GENERATE_GOLD") != "" {
+				require.NoError(t, os.WriteFile(goldFile, []byte(actual), 0644))
+			}
+			expected, err := os.ReadFile(goldFile)
+			require.NoError(t, err)
+			assert.Equal(t, string(expected), actual)
+		})
+	}
+}
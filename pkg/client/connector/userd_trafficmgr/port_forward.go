@@ -0,0 +1,374 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/forwarder"
+)
+
+// portForwardCheckInterval is the interval between checks of whether the pod of a port forward is
+// still there.
+const portForwardCheckInterval = 2 * time.Second
+
+// portForwardCluster is the part of the cluster that a port forward uses to find its pod.
+type portForwardCluster interface {
+	FindSvc(c context.Context, namespace, name string) (*kates.Service, error)
+	FindPod(c context.Context, namespace, name string) (*kates.Pod, error)
+	Pods(c context.Context, namespace string) ([]*kates.Pod, error)
+}
+
+// podForward forwards local ports to the pod that currently backs a service or pod. The forwards move
+// to another pod when that pod goes away.
+type podForward struct {
+	spec      *rpc.PortForwardSpec
+	cluster   portForwardCluster
+	forwards  []*forwarder.Forwarder
+	listeners []*net.TCPListener
+
+	// checkInterval is the interval between checks of whether the pod is still there
+	checkInterval time.Duration
+
+	// changed is signalled when the pod changes
+	changed chan<- struct{}
+
+	mu       sync.Mutex
+	pod      *kates.Pod // nil while waiting for a pod
+	podPorts []int32
+}
+
+// podIsUsable returns true if the given pod can receive forwarded connections.
+func podIsUsable(pod *kates.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+		return false
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// selectPod returns the usable pod that matches the given selector. The oldest pod is preferred so that
+// the same pod is selected for as long as it exists.
+func selectPod(pods []*kates.Pod, selector map[string]string) *kates.Pod {
+	sel := labels.SelectorFromSet(selector)
+	var candidates []*kates.Pod
+	for _, pod := range pods {
+		if sel.Matches(labels.Set(pod.Labels)) && podIsUsable(pod) {
+			candidates = append(candidates, pod)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		ti, tj := candidates[i].CreationTimestamp, candidates[j].CreationTimestamp
+		if !ti.Equal(&tj) {
+			return ti.Before(&tj)
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+	return candidates[0]
+}
+
+// containerPort returns the number of the container port with the given name or number in the given pod.
+func containerPort(pod *kates.Pod, port string) (int32, error) {
+	if n, err := strconv.Atoi(port); err == nil {
+		return int32(n), nil
+	}
+	for _, cn := range pod.Spec.Containers {
+		for _, cp := range cn.Ports {
+			if cp.Name == port {
+				return cp.ContainerPort, nil
+			}
+		}
+	}
+	return 0, errcat.User.Newf("pod %s.%s has no container port named %q", pod.Name, pod.Namespace, port)
+}
+
+// resolvePortForward returns the pod that currently backs the target of the given spec, and the pod port
+// of each of its ports.
+func resolvePortForward(c context.Context, cluster portForwardCluster, spec *rpc.PortForwardSpec) (*kates.Pod, []int32, error) {
+	var pod *kates.Pod
+	podPorts := make([]int32, len(spec.Ports))
+	switch spec.Kind {
+	case "svc":
+		svc, err := cluster.FindSvc(c, spec.Namespace, spec.Name)
+		if err != nil {
+			return nil, nil, errcat.User.Newf("unable to find service %s.%s: %w", spec.Name, spec.Namespace, err)
+		}
+		if len(svc.Spec.Selector) == 0 {
+			return nil, nil, errcat.User.Newf("service %s.%s has no selector, so there are no pods to forward to", spec.Name, spec.Namespace)
+		}
+		targetPorts := make([]intstr.IntOrString, len(spec.Ports))
+		for i, p := range spec.Ports {
+			found := false
+			for _, sp := range svc.Spec.Ports {
+				if sp.Name == p.RemotePort || strconv.Itoa(int(sp.Port)) == p.RemotePort {
+					targetPorts[i] = sp.TargetPort
+					if targetPorts[i].Type == intstr.Int && targetPorts[i].IntVal == 0 {
+						targetPorts[i] = intstr.FromInt(int(sp.Port))
+					}
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, nil, errcat.User.Newf("service %s.%s has no port %q", spec.Name, spec.Namespace, p.RemotePort)
+			}
+		}
+		pods, err := cluster.Pods(c, spec.Namespace)
+		if err != nil {
+			return nil, nil, err
+		}
+		if pod = selectPod(pods, svc.Spec.Selector); pod == nil {
+			return nil, nil, errcat.User.Newf("no running pod backs service %s.%s", spec.Name, spec.Namespace)
+		}
+		for i, tp := range targetPorts {
+			if podPorts[i], err = containerPort(pod, tp.String()); err != nil {
+				return nil, nil, err
+			}
+		}
+	case "pod":
+		var err error
+		if pod, err = cluster.FindPod(c, spec.Namespace, spec.Name); err != nil {
+			return nil, nil, errcat.User.Newf("unable to find pod %s.%s: %w", spec.Name, spec.Namespace, err)
+		}
+		if !podIsUsable(pod) {
+			return nil, nil, errcat.User.Newf("pod %s.%s is not running and ready", spec.Name, spec.Namespace)
+		}
+		for i, p := range spec.Ports {
+			if podPorts[i], err = containerPort(pod, p.RemotePort); err != nil {
+				return nil, nil, err
+			}
+		}
+	default:
+		return nil, nil, errcat.User.Newf("unable to forward to a %q, only \"svc\" and \"pod\" are supported", spec.Kind)
+	}
+	return pod, podPorts, nil
+}
+
+// newPodForward resolves the pod of the given spec and binds one local listener for each of its ports. The
+// returned forward must either be served using run() or discarded using close().
+func newPodForward(c context.Context, cluster portForwardCluster, spec *rpc.PortForwardSpec, changed chan<- struct{}) (*podForward, error) {
+	pod, podPorts, err := resolvePortForward(c, cluster, spec)
+	if err != nil {
+		return nil, err
+	}
+	pf := &podForward{
+		spec:          spec,
+		cluster:       cluster,
+		checkInterval: portForwardCheckInterval,
+		changed:       changed,
+		pod:           pod,
+		podPorts:      podPorts,
+	}
+	for i, p := range spec.Ports {
+		addr := &net.TCPAddr{
+			IP:   net.IPv4(127, 0, 0, 1),
+			Port: int(p.LocalPort),
+		}
+		f := forwarder.NewForwarder(addr, pod.Status.PodIP, podPorts[i])
+		l, err := f.Listen(c)
+		if err != nil {
+			pf.close()
+			return nil, errcat.User.Newf("unable to forward local port %d: %w", p.LocalPort, err)
+		}
+		pf.forwards = append(pf.forwards, f)
+		pf.listeners = append(pf.listeners, l)
+	}
+	return pf, nil
+}
+
+// run serves the listeners and moves the forwards to another pod when the current one goes away, until
+// the context is cancelled.
+func (pf *podForward) run(c context.Context) {
+	var wg sync.WaitGroup
+	for i, f := range pf.forwards {
+		f, l := f, pf.listeners[i]
+		ctx := dgroup.WithGoroutineName(c, fmt.Sprintf("/%s", l.Addr()))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := f.ServeListener(ctx, l); err != nil && ctx.Err() == nil {
+				dlog.Errorf(ctx, "port-forwarder failed with %v", err)
+			}
+		}()
+	}
+	defer wg.Wait()
+
+	ticker := time.NewTicker(pf.checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.Done():
+			return
+		case <-ticker.C:
+			pf.checkPod(c)
+		}
+	}
+}
+
+// checkPod moves the forwards to another pod if the current pod is gone or no longer usable.
+func (pf *podForward) checkPod(c context.Context) {
+	pf.mu.Lock()
+	current := pf.pod
+	pf.mu.Unlock()
+	if current != nil {
+		if pod, err := pf.cluster.FindPod(c, current.Namespace, current.Name); err == nil && pod.UID == current.UID && podIsUsable(pod) {
+			return
+		}
+	}
+
+	pod, podPorts, err := resolvePortForward(c, pf.cluster, pf.spec)
+	if err != nil {
+		if current != nil {
+			dlog.Infof(c, "Port forward to %s/%s.%s is waiting for a pod: %v", pf.spec.Kind, pf.spec.Name, pf.spec.Namespace, err)
+			pf.setPod(nil, nil)
+		}
+		return
+	}
+	if current != nil && pod.UID == current.UID {
+		return
+	}
+	dlog.Infof(c, "Port forward to %s/%s.%s moved to pod %s", pf.spec.Kind, pf.spec.Name, pf.spec.Namespace, pod.Name)
+	for i, f := range pf.forwards {
+		f.SetTarget(pod.Status.PodIP, podPorts[i])
+	}
+	pf.setPod(pod, podPorts)
+}
+
+func (pf *podForward) setPod(pod *kates.Pod, podPorts []int32) {
+	pf.mu.Lock()
+	pf.pod = pod
+	pf.podPorts = podPorts
+	pf.mu.Unlock()
+	select {
+	case pf.changed <- struct{}{}:
+	default:
+	}
+}
+
+// info returns a description of the port forward and the pod that it currently goes to.
+func (pf *podForward) info() *rpc.PortForwardInfo {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	info := &rpc.PortForwardInfo{
+		Spec: &rpc.PortForwardSpec{
+			Kind:      pf.spec.Kind,
+			Name:      pf.spec.Name,
+			Namespace: pf.spec.Namespace,
+			Ports:     make([]*rpc.PortForwardPort, len(pf.spec.Ports)),
+		},
+	}
+	for i, p := range pf.spec.Ports {
+		info.Spec.Ports[i] = &rpc.PortForwardPort{LocalPort: p.LocalPort, RemotePort: p.RemotePort}
+		if pf.pod != nil {
+			info.Spec.Ports[i].PodPort = pf.podPorts[i]
+		}
+	}
+	if pf.pod != nil {
+		info.PodName = pf.pod.Name
+		info.PodIp = pf.pod.Status.PodIP
+	}
+	return info
+}
+
+// close closes all listeners that haven't been served.
+func (pf *podForward) close() {
+	for _, l := range pf.listeners {
+		_ = l.Close()
+	}
+}
+
+// PortForward establishes the port forwards of the given request and keeps them alive until the context
+// is cancelled. A snapshot of the forwards is sent when they are established and each time one of them
+// moves to another pod. When the forwards can't be established, a snapshot with the error is sent.
+func (tm *trafficManager) PortForward(c context.Context, pr *rpc.PortForwardRequest, send func(*rpc.PortForwardSnapshot) error) error {
+	changed := make(chan struct{}, 1)
+	var pfs []*podForward
+	for _, spec := range pr.Forwards {
+		var pf *podForward
+		ns := tm.ActualNamespace(spec.Namespace)
+		err := errcat.User.Newf("namespace %q doesn't exist", spec.Namespace)
+		if ns != "" {
+			spec = &rpc.PortForwardSpec{Kind: spec.Kind, Name: spec.Name, Namespace: ns, Ports: spec.Ports}
+			pf, err = newPodForward(c, tm, spec, changed)
+		}
+		if err != nil {
+			for _, pf := range pfs {
+				pf.close()
+			}
+			return send(&rpc.PortForwardSnapshot{ErrorText: err.Error(), ErrorCategory: int32(errcat.GetCategory(err))})
+		}
+		pfs = append(pfs, pf)
+	}
+	return tm.runPortForwards(c, pfs, changed, send)
+}
+
+// runPortForwards runs the given forwards and sends a snapshot of them initially and each time one of
+// them changes. The forwards are listed in the status while they run.
+func (tm *trafficManager) runPortForwards(c context.Context, pfs []*podForward, changed <-chan struct{}, send func(*rpc.PortForwardSnapshot) error) error {
+	c, cancel := context.WithCancel(c)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, pf := range pfs {
+		pf := pf
+		tm.portForwards.Store(pf, struct{}{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer tm.portForwards.Delete(pf)
+			pf.run(c)
+		}()
+	}
+	defer wg.Wait()
+
+	snapshot := func() *rpc.PortForwardSnapshot {
+		s := &rpc.PortForwardSnapshot{Forwards: make([]*rpc.PortForwardInfo, len(pfs))}
+		for i, pf := range pfs {
+			s.Forwards[i] = pf.info()
+		}
+		return s
+	}
+	for {
+		if err := send(snapshot()); err != nil {
+			return err
+		}
+		select {
+		case <-c.Done():
+			return nil
+		case <-changed:
+		}
+	}
+}
+
+// getPortForwards returns a description of all active port forwards, ordered by their first local port.
+func (tm *trafficManager) getPortForwards() []*rpc.PortForwardInfo {
+	var infos []*rpc.PortForwardInfo
+	tm.portForwards.Range(func(key, _ interface{}) bool {
+		infos = append(infos, key.(*podForward).info())
+		return true
+	})
+	sort.Slice(infos, func(i, j int) bool {
+		pi, pj := infos[i].Spec.Ports, infos[j].Spec.Ports
+		return len(pi) > 0 && len(pj) > 0 && pi[0].LocalPort < pj[0].LocalPort
+	})
+	return infos
+}
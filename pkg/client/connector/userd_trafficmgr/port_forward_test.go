@@ -0,0 +1,281 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// freePort returns a local port that is free at the time of the call.
+func freePort(t *testing.T) int32 {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return int32(l.Addr().(*net.TCPAddr).Port)
+}
+
+// echoServer starts a server that writes the given greeting to each connection and returns its port.
+func echoServer(t *testing.T, greeting string) int32 {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte(greeting))
+			_ = conn.Close()
+		}
+	}()
+	return int32(l.Addr().(*net.TCPAddr).Port)
+}
+
+type fakePortForwardCluster struct {
+	sync.Mutex
+	svcs []*kates.Service
+	pods []*kates.Pod
+}
+
+func (f *fakePortForwardCluster) FindSvc(_ context.Context, namespace, name string) (*kates.Service, error) {
+	f.Lock()
+	defer f.Unlock()
+	for _, svc := range f.svcs {
+		if svc.Namespace == namespace && svc.Name == name {
+			return svc, nil
+		}
+	}
+	return nil, fmt.Errorf("service %s.%s not found", name, namespace)
+}
+
+func (f *fakePortForwardCluster) FindPod(_ context.Context, namespace, name string) (*kates.Pod, error) {
+	f.Lock()
+	defer f.Unlock()
+	for _, pod := range f.pods {
+		if pod.Namespace == namespace && pod.Name == name {
+			return pod, nil
+		}
+	}
+	return nil, fmt.Errorf("pod %s.%s not found", name, namespace)
+}
+
+func (f *fakePortForwardCluster) Pods(_ context.Context, namespace string) ([]*kates.Pod, error) {
+	f.Lock()
+	defer f.Unlock()
+	var pods []*kates.Pod
+	for _, pod := range f.pods {
+		if pod.Namespace == namespace {
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
+}
+
+func (f *fakePortForwardCluster) deletePod(name string) {
+	f.Lock()
+	defer f.Unlock()
+	for i, pod := range f.pods {
+		if pod.Name == name {
+			f.pods = append(f.pods[:i], f.pods[i+1:]...)
+			return
+		}
+	}
+}
+
+func (f *fakePortForwardCluster) addPod(pod *kates.Pod) {
+	f.Lock()
+	f.pods = append(f.pods, pod)
+	f.Unlock()
+}
+
+func testPod(name string, created time.Time, ready bool, httpPort int32) *kates.Pod {
+	readyStatus := corev1.ConditionFalse
+	if ready {
+		readyStatus = corev1.ConditionTrue
+	}
+	return &kates.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "default",
+			UID:               types.UID(name + "-uid"),
+			Labels:            map[string]string{"app": "echo"},
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:  "echo",
+				Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: httpPort}},
+			}},
+		},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			PodIP:      "127.0.0.1",
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: readyStatus}},
+		},
+	}
+}
+
+func testSvc() *kates.Service {
+	return &kates.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "default"},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app": "echo"},
+			Ports: []corev1.ServicePort{
+				{Name: "http", Port: 80, TargetPort: intstr.FromString("http")},
+				{Name: "raw", Port: 9000},
+			},
+		},
+	}
+}
+
+func TestResolvePortForward_Service(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	now := time.Now()
+	cluster := &fakePortForwardCluster{
+		svcs: []*kates.Service{testSvc()},
+		pods: []*kates.Pod{
+			testPod("echo-new", now, true, 8081),
+			testPod("echo-old", now.Add(-time.Hour), true, 8080),
+			testPod("echo-unready", now.Add(-2*time.Hour), false, 8082),
+		},
+	}
+	other := testPod("other", now.Add(-3*time.Hour), true, 8083)
+	other.Labels = map[string]string{"app": "other"}
+	cluster.addPod(other)
+
+	spec := &rpc.PortForwardSpec{
+		Kind:      "svc",
+		Name:      "echo",
+		Namespace: "default",
+		Ports: []*rpc.PortForwardPort{
+			{LocalPort: 8080, RemotePort: "80"},
+			{LocalPort: 8081, RemotePort: "http"},
+			{LocalPort: 9000, RemotePort: "raw"},
+		},
+	}
+	pod, podPorts, err := resolvePortForward(ctx, cluster, spec)
+	require.NoError(t, err)
+
+	// The oldest ready pod that matches the selector is selected
+	assert.Equal(t, "echo-old", pod.Name)
+
+	// Named target ports are resolved using the container ports, and a missing target port means the service port
+	assert.Equal(t, []int32{8080, 8080, 9000}, podPorts)
+
+	spec.Ports = []*rpc.PortForwardPort{{LocalPort: 8080, RemotePort: "443"}}
+	_, _, err = resolvePortForward(ctx, cluster, spec)
+	assert.Error(t, err)
+
+	cluster.deletePod("echo-old")
+	cluster.deletePod("echo-new")
+	spec.Ports = []*rpc.PortForwardPort{{LocalPort: 8080, RemotePort: "80"}}
+	_, _, err = resolvePortForward(ctx, cluster, spec)
+	assert.Error(t, err)
+}
+
+func TestResolvePortForward_Pod(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	cluster := &fakePortForwardCluster{
+		pods: []*kates.Pod{testPod("echo", time.Now(), true, 8080)},
+	}
+	spec := &rpc.PortForwardSpec{
+		Kind:      "pod",
+		Name:      "echo",
+		Namespace: "default",
+		Ports: []*rpc.PortForwardPort{
+			{LocalPort: 8080, RemotePort: "http"},
+			{LocalPort: 9090, RemotePort: "9090"},
+		},
+	}
+	pod, podPorts, err := resolvePortForward(ctx, cluster, spec)
+	require.NoError(t, err)
+	assert.Equal(t, "echo", pod.Name)
+	assert.Equal(t, []int32{8080, 9090}, podPorts)
+
+	spec.Ports = []*rpc.PortForwardPort{{LocalPort: 8080, RemotePort: "grpc"}}
+	_, _, err = resolvePortForward(ctx, cluster, spec)
+	assert.Error(t, err)
+
+	spec.Kind = "deploy"
+	_, _, err = resolvePortForward(ctx, cluster, spec)
+	assert.Error(t, err)
+}
+
+func TestPodForward_ReconnectAfterPodDelete(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	now := time.Now()
+	cluster := &fakePortForwardCluster{
+		svcs: []*kates.Service{testSvc()},
+		pods: []*kates.Pod{testPod("echo-1", now.Add(-time.Hour), true, echoServer(t, "first"))},
+	}
+	localPort := freePort(t)
+	spec := &rpc.PortForwardSpec{
+		Kind:      "svc",
+		Name:      "echo",
+		Namespace: "default",
+		Ports:     []*rpc.PortForwardPort{{LocalPort: localPort, RemotePort: "http"}},
+	}
+	changed := make(chan struct{}, 1)
+	pf, err := newPodForward(ctx, cluster, spec, changed)
+	require.NoError(t, err)
+	pf.checkInterval = 10 * time.Millisecond
+	done := make(chan struct{})
+	go func() {
+		pf.run(ctx)
+		close(done)
+	}()
+
+	read := func() string {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", localPort))
+		require.NoError(t, err)
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		return string(data)
+	}
+	assert.Equal(t, "first", read())
+	assert.Equal(t, "echo-1", pf.info().PodName)
+
+	// Simulate that the pod is deleted and replaced by the deployment
+	cluster.deletePod("echo-1")
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the forward to notice that the pod is gone")
+	}
+	assert.Empty(t, pf.info().PodName)
+
+	cluster.addPod(testPod("echo-2", now, true, echoServer(t, "second")))
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the forward to move to the new pod")
+	}
+	info := pf.info()
+	assert.Equal(t, "echo-2", info.PodName)
+	assert.Equal(t, "second", read())
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the forward to stop")
+	}
+}
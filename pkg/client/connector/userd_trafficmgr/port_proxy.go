@@ -0,0 +1,349 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	dnsServer "github.com/telepresenceio/telepresence/v2/pkg/client/daemon/dns"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// PortProxy implements the "ports" proxy mode, which needs no root daemon. A loopback port is allocated
+// for each TCP port of each service in the mapped namespaces, and the connections that it accepts are
+// forwarded over the tunnel of the traffic-manager to the cluster IP and port of the service.
+//
+// The names of the services resolve to the loopback address in a DNS server that listens on a loopback
+// port, and the SRV records of the service ports tell their local ports. The names can also be published
+// in a section of a hosts file that is removed when the proxy ends.
+type PortProxy struct {
+	hostsFile string
+
+	// forward forwards the given connection to the cluster. It's set by the traffic-manager.
+	forward func(ctx context.Context, id tunnel.ConnID, conn net.Conn)
+
+	// servicesCh holds the latest services that were set and not yet applied.
+	servicesCh chan []*kates.Service
+
+	mu            sync.Mutex
+	clusterDomain string
+	listeners     map[proxyKey]*proxyListener
+	dnsListener   string
+	lastActivity  time.Time
+}
+
+type proxyKey struct {
+	namespace string
+	name      string
+	port      int32
+}
+
+type proxyListener struct {
+	port      *rpc.ProxyPort
+	clusterIP net.IP
+	listener  net.Listener
+}
+
+// NewPortProxy returns a PortProxy that publishes the names of the services in the given hosts file,
+// unless it's empty.
+func NewPortProxy(hostsFile string) *PortProxy {
+	return &PortProxy{
+		hostsFile:     hostsFile,
+		servicesCh:    make(chan []*kates.Service, 1),
+		clusterDomain: "cluster.local",
+		listeners:     make(map[proxyKey]*proxyListener),
+	}
+}
+
+// SetServices sets the services to proxy. It doesn't block. Services that are set before the previous
+// ones have been applied replace them.
+func (p *PortProxy) SetServices(_ context.Context, services []*kates.Service) {
+	for {
+		select {
+		case p.servicesCh <- services:
+			return
+		default:
+		}
+		select {
+		case <-p.servicesCh:
+		default:
+		}
+	}
+}
+
+func (p *PortProxy) setClusterDomain(domain string) {
+	if domain = strings.TrimSuffix(domain, "."); domain != "" {
+		p.mu.Lock()
+		p.clusterDomain = domain
+		p.mu.Unlock()
+	}
+}
+
+// run serves the DNS server and applies the services that are set until the given context is cancelled.
+// All listeners are then closed and the section of the hosts file is removed.
+func (p *PortProxy) run(c context.Context) error {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("failed to listen for DNS queries: %w", err)
+	}
+	p.mu.Lock()
+	p.dnsListener = pc.LocalAddr().String()
+	p.mu.Unlock()
+	dlog.Infof(c, "Resolving the names of the proxied services on %s", pc.LocalAddr())
+
+	defer p.close(c)
+	g := dgroup.NewGroup(c, dgroup.GroupConfig{})
+	g.Go("dns", dnsServer.NewServer(c, []net.PacketConn{pc}, nil, p.resolve, p.resolveSRV).Run)
+	g.Go("services", func(c context.Context) error {
+		for {
+			select {
+			case <-c.Done():
+				return nil
+			case services := <-p.servicesCh:
+				p.update(c, services)
+			}
+		}
+	})
+	return g.Wait()
+}
+
+// update makes the listeners match the given services. A service port that is already proxied keeps its
+// local port.
+func (p *PortProxy) update(c context.Context, services []*kates.Service) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	wanted := make(map[proxyKey]struct{})
+	for _, svc := range services {
+		clusterIP := iputil.Parse(svc.Spec.ClusterIP)
+		if clusterIP == nil {
+			continue
+		}
+		for _, sp := range svc.Spec.Ports {
+			if sp.Protocol != "" && sp.Protocol != corev1.ProtocolTCP {
+				continue
+			}
+			key := proxyKey{namespace: svc.Namespace, name: svc.Name, port: sp.Port}
+			wanted[key] = struct{}{}
+			if pl, ok := p.listeners[key]; ok {
+				// The service may have been recreated with another cluster IP
+				pl.clusterIP = clusterIP
+				pl.port.PortName = sp.Name
+				continue
+			}
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				dlog.Errorf(c, "unable to proxy port %d of service %s.%s: %v", sp.Port, svc.Name, svc.Namespace, err)
+				continue
+			}
+			pl := &proxyListener{
+				port: &rpc.ProxyPort{
+					Name:      svc.Name,
+					Namespace: svc.Namespace,
+					Port:      sp.Port,
+					PortName:  sp.Name,
+					LocalPort: int32(l.Addr().(*net.TCPAddr).Port),
+				},
+				clusterIP: clusterIP,
+				listener:  l,
+			}
+			p.listeners[key] = pl
+			dlog.Debugf(c, "Proxying %s to port %d of service %s.%s", l.Addr(), sp.Port, svc.Name, svc.Namespace)
+			go p.accept(c, key, pl)
+		}
+	}
+	for key, pl := range p.listeners {
+		if _, ok := wanted[key]; !ok {
+			dlog.Debugf(c, "Ending the proxy of port %d of service %s.%s", key.port, key.name, key.namespace)
+			_ = pl.listener.Close()
+			delete(p.listeners, key)
+		}
+	}
+	p.updateHostsFileLocked(c)
+}
+
+// accept forwards the connections that the given listener accepts until the listener is closed.
+func (p *PortProxy) accept(c context.Context, key proxyKey, pl *proxyListener) {
+	for {
+		conn, err := pl.listener.Accept()
+		if err != nil {
+			return
+		}
+		p.mu.Lock()
+		p.lastActivity = time.Now()
+		dstIP := pl.clusterIP
+		p.mu.Unlock()
+
+		srcIP, srcPort, err := iputil.SplitToIPPort(conn.RemoteAddr())
+		if err != nil {
+			dlog.Error(c, err)
+			_ = conn.Close()
+			continue
+		}
+		id := tunnel.NewConnID(ipproto.TCP, srcIP, dstIP, srcPort, uint16(key.port))
+		go p.forward(c, id, conn)
+	}
+}
+
+// close closes all listeners and removes the section of the hosts file.
+func (p *PortProxy) close(c context.Context) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, pl := range p.listeners {
+		_ = pl.listener.Close()
+		delete(p.listeners, key)
+	}
+	p.dnsListener = ""
+	p.updateHostsFileLocked(c)
+}
+
+// servicesLocked returns the sorted "<name>.<namespace>" of the proxied services.
+func (p *PortProxy) servicesLocked() []string {
+	seen := make(map[string]struct{})
+	var services []string
+	for key := range p.listeners {
+		base := key.name + "." + key.namespace
+		if _, ok := seen[base]; !ok {
+			seen[base] = struct{}{}
+			services = append(services, base)
+		}
+	}
+	sort.Strings(services)
+	return services
+}
+
+// namesLocked returns the names of the given "<name>.<namespace>" of a service.
+func (p *PortProxy) namesLocked(base string) []string {
+	return []string{base, base + ".svc", base + ".svc." + p.clusterDomain}
+}
+
+func (p *PortProxy) updateHostsFileLocked(c context.Context) {
+	if p.hostsFile == "" {
+		return
+	}
+	var lines []string
+	for _, base := range p.servicesLocked() {
+		lines = append(lines, "127.0.0.1 "+strings.Join(p.namesLocked(base), " "))
+	}
+	changed, err := updateHostsSection(p.hostsFile, lines)
+	switch {
+	case err != nil:
+		dlog.Errorf(c, "unable to update the hosts file: %v", err)
+	case changed:
+		dlog.Infof(c, "Published %d service names in %s", len(lines), p.hostsFile)
+	}
+}
+
+// resolve implements dns.Resolver. The names of the proxied services resolve to the loopback address.
+func (p *PortProxy) resolve(_ context.Context, _ uint16, domain string) []net.IP {
+	domain = strings.TrimSuffix(domain, ".")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key := range p.listeners {
+		for _, name := range p.namesLocked(key.name + "." + key.namespace) {
+			if name == domain {
+				return []net.IP{{127, 0, 0, 1}}
+			}
+		}
+	}
+	return nil
+}
+
+// resolveSRV implements dns.SRVResolver. The SRV record of a named port of a proxied service, e.g.
+// _http._tcp.echo.default, has the local port of the proxy.
+func (p *PortProxy) resolveSRV(_ context.Context, domain string) []*dns.SRV {
+	parts := strings.SplitN(strings.TrimSuffix(domain, "."), ".", 3)
+	if len(parts) < 3 || parts[1] != "_tcp" || !strings.HasPrefix(parts[0], "_") {
+		return nil
+	}
+	portName := parts[0][1:]
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, pl := range p.listeners {
+		if pl.port.PortName != portName {
+			continue
+		}
+		base := pl.port.Name + "." + pl.port.Namespace
+		for _, name := range p.namesLocked(base) {
+			if name == parts[2] {
+				return []*dns.SRV{{Target: base + ".", Port: uint16(pl.port.LocalPort)}}
+			}
+		}
+	}
+	return nil
+}
+
+// getLastActivity returns the time when the last connection was accepted.
+func (p *PortProxy) getLastActivity() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastActivity
+}
+
+// info returns the status of the proxy.
+func (p *PortProxy) info() *rpc.ProxyPorts {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	info := &rpc.ProxyPorts{
+		DnsListener: p.dnsListener,
+		HostsFile:   p.hostsFile,
+		Ports:       make([]*rpc.ProxyPort, 0, len(p.listeners)),
+	}
+	for _, pl := range p.listeners {
+		info.Ports = append(info.Ports, &rpc.ProxyPort{
+			Name:      pl.port.Name,
+			Namespace: pl.port.Namespace,
+			Port:      pl.port.Port,
+			PortName:  pl.port.PortName,
+			LocalPort: pl.port.LocalPort,
+		})
+	}
+	sort.Slice(info.Ports, func(i, j int) bool {
+		a, b := info.Ports[i], info.Ports[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Port < b.Port
+	})
+	return info
+}
+
+// forwardToCluster forwards the given connection over the tunnel of the traffic-manager.
+func (tm *trafficManager) forwardToCluster(c context.Context, id tunnel.ConnID, conn net.Conn) {
+	defer conn.Close()
+	ct, err := tm.managerClient.Tunnel(c)
+	if err != nil {
+		dlog.Errorf(c, "call to manager.Tunnel() failed. Id %s: %v", id, err)
+		return
+	}
+	tos := &client.GetConfig(c).Timeouts
+	sessionID := tm.session().SessionId
+	s, err := tunnel.NewClientStream(c, ct, id, sessionID, tos.Get(client.TimeoutRoundtripLatency), tos.Get(client.TimeoutEndpointDial))
+	if err != nil {
+		dlog.Errorf(c, "unable to open a tunnel stream for %s: %v", id, err)
+		if isUnknownSession(err) {
+			tm.sessionUnknown(c, sessionID)
+		}
+		return
+	}
+	ep := tunnel.NewConnEndpoint(s, conn)
+	ep.Start(tunnel.WithTCPKeepAlive(c, client.GetConfig(c).Connections.TCPKeepAlive))
+	<-ep.Done()
+}
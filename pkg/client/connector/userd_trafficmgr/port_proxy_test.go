@@ -0,0 +1,144 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+func proxyService(namespace, name, clusterIP string, ports ...corev1.ServicePort) *kates.Service {
+	return &kates.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       corev1.ServiceSpec{ClusterIP: clusterIP, Ports: ports},
+	}
+}
+
+// localPorts returns the local ports of the proxy keyed by "<name>.<namespace>:<port>".
+func localPorts(p *PortProxy) map[string]int32 {
+	ports := make(map[string]int32)
+	for _, pp := range p.info().Ports {
+		ports[fmt.Sprintf("%s.%s:%d", pp.Name, pp.Namespace, pp.Port)] = pp.LocalPort
+	}
+	return ports
+}
+
+func TestPortProxy_update(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	hostsFile := filepath.Join(t.TempDir(), "hosts")
+	require.NoError(t, os.WriteFile(hostsFile, []byte("127.0.0.1 localhost\n"), 0644))
+
+	forwarded := make(chan tunnel.ConnID, 1)
+	p := NewPortProxy(hostsFile)
+	p.forward = func(_ context.Context, id tunnel.ConnID, conn net.Conn) {
+		_ = conn.Close()
+		forwarded <- id
+	}
+	p.setClusterDomain("cluster.local.")
+
+	http := corev1.ServicePort{Name: "http", Port: 80}
+	p.update(ctx, []*kates.Service{
+		proxyService("default", "echo", "10.96.0.10", http, corev1.ServicePort{Name: "dns", Port: 53, Protocol: corev1.ProtocolUDP}),
+		proxyService("blue", "web", "10.96.0.11", http, corev1.ServicePort{Name: "https", Port: 443, Protocol: corev1.ProtocolTCP}),
+	})
+	ports := localPorts(p)
+	require.Len(t, ports, 3, "UDP ports must not be proxied")
+
+	// The ports are ordered by namespace, name, and port
+	var order []string
+	for _, pp := range p.info().Ports {
+		order = append(order, fmt.Sprintf("%s.%s:%d", pp.Name, pp.Namespace, pp.Port))
+	}
+	assert.Equal(t, []string{"web.blue:80", "web.blue:443", "echo.default:80"}, order)
+
+	// A connection to the local port is forwarded to the cluster IP and port of the service
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", ports["echo.default:80"]))
+	require.NoError(t, err)
+	defer conn.Close()
+	select {
+	case id := <-forwarded:
+		assert.Equal(t, "10.96.0.10", id.Destination().String())
+		assert.Equal(t, uint16(80), id.DestinationPort())
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection was not forwarded")
+	}
+	assert.False(t, p.getLastActivity().IsZero())
+
+	// The names of the services are published in the hosts file
+	data, err := os.ReadFile(hostsFile)
+	require.NoError(t, err)
+	assert.Equal(t, `127.0.0.1 localhost
+# BEGIN telepresence
+127.0.0.1 echo.default echo.default.svc echo.default.svc.cluster.local
+127.0.0.1 web.blue web.blue.svc web.blue.svc.cluster.local
+# END telepresence
+`, string(data))
+
+	// The ports that remain keep their local ports, and the ports that are gone are closed
+	p.update(ctx, []*kates.Service{
+		proxyService("blue", "web", "10.96.0.12", http),
+	})
+	assert.Equal(t, map[string]int32{"web.blue:80": ports["web.blue:80"]}, localPorts(p))
+	_, err = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", ports["echo.default:80"]))
+	assert.Error(t, err)
+	data, err = os.ReadFile(hostsFile)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "echo.default")
+
+	// Teardown closes all ports and removes the section of the hosts file
+	p.close(ctx)
+	assert.Empty(t, localPorts(p))
+	_, err = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", ports["web.blue:80"]))
+	assert.Error(t, err)
+	data, err = os.ReadFile(hostsFile)
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1 localhost\n", string(data))
+}
+
+func TestPortProxy_resolve(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	p := NewPortProxy("")
+	p.forward = func(_ context.Context, _ tunnel.ConnID, conn net.Conn) { _ = conn.Close() }
+	p.update(ctx, []*kates.Service{proxyService("default", "echo", "10.96.0.10", corev1.ServicePort{Name: "http", Port: 80})})
+	defer p.close(ctx)
+
+	for _, name := range []string{"echo.default.", "echo.default.svc.", "echo.default.svc.cluster.local."} {
+		assert.Equal(t, []net.IP{{127, 0, 0, 1}}, p.resolve(ctx, dns.TypeA, name), name)
+	}
+	assert.Empty(t, p.resolve(ctx, dns.TypeA, "echo.blue."))
+	assert.Empty(t, p.resolve(ctx, dns.TypeA, "10.96.0.10."))
+
+	srvs := p.resolveSRV(ctx, "_http._tcp.echo.default.svc.cluster.local.")
+	require.Len(t, srvs, 1)
+	assert.Equal(t, "echo.default.", srvs[0].Target)
+	assert.Equal(t, uint16(localPorts(p)["echo.default:80"]), srvs[0].Port)
+	assert.Empty(t, p.resolveSRV(ctx, "_https._tcp.echo.default."))
+}
+
+func TestPortProxy_SetServices(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	p := NewPortProxy("")
+
+	// SetServices doesn't block, and the latest services replace those that haven't been applied
+	p.SetServices(ctx, []*kates.Service{proxyService("default", "echo", "10.96.0.10")})
+	latest := []*kates.Service{proxyService("default", "web", "10.96.0.11")}
+	p.SetServices(ctx, latest)
+	assert.Equal(t, latest, <-p.servicesCh)
+	assert.Equal(t, &rpc.ProxyPorts{Ports: []*rpc.ProxyPort{}}, p.info())
+}
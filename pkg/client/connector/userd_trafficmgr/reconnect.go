@@ -0,0 +1,146 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	grpcStatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/datawire/dlib/dtime"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/a8rcloud"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/metrics"
+)
+
+// reconnectBackoff is the initial delay between attempts to replace a broken session. It doubles
+// for each failed attempt, up to maxReconnectBackoff.
+var reconnectBackoff = time.Second
+
+const maxReconnectBackoff = 30 * time.Second
+
+func (tm *trafficManager) getReconnectingSince() time.Time {
+	tm.sessionLock.RLock()
+	defer tm.sessionLock.RUnlock()
+	return tm.reconnectingSince
+}
+
+func (tm *trafficManager) setReconnectingSince(since time.Time) {
+	tm.sessionLock.Lock()
+	tm.reconnectingSince = since
+	tm.sessionLock.Unlock()
+}
+
+// isUnknownSession returns true if the given error, or an error that it wraps, is the error that the
+// traffic-manager returns when it doesn't know the session of a request. A manager with more than one
+// replica returns it when a request reaches another replica than the one that the session arrived at, and
+// any manager returns it after a restart.
+func isUnknownSession(err error) bool {
+	var se interface{ GRPCStatus() *grpcStatus.Status }
+	if !errors.As(err, &se) {
+		return false
+	}
+	for _, d := range se.GRPCStatus().Details() {
+		if _, ok := d.(*manager.UnknownSession); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionUnknown tells the remain loop that the traffic-manager doesn't know the session with the given
+// ID, so that it's replaced without waiting for the next Remain call. It never blocks, and a report of a
+// session that has been replaced already is ignored.
+func (tm *trafficManager) sessionUnknown(c context.Context, sessionID string) {
+	select {
+	case tm.unknownSession <- sessionID:
+		dlog.Debugf(c, "The traffic-manager doesn't know session %s", sessionID)
+	default:
+	}
+}
+
+// reconnect replaces a broken session with a new one, retrying with an exponential backoff until it
+// succeeds or the context is cancelled. The session is reported as reconnecting in the meantime.
+//
+// The port-forwards and mounts of the intercepts are re-established by the watchers once the
+// intercepts arrive in the new session.
+func (tm *trafficManager) reconnect(c context.Context) {
+	broken := tm.session()
+	since := time.Now()
+	tm.setReconnectingSince(since)
+	defer tm.setReconnectingSince(time.Time{})
+	dlog.Warnf(c, "Session %s is broken, reconnecting", broken.SessionId)
+
+	// The intercepts that must be re-created in the new session
+	tm.currentInterceptsLock.Lock()
+	intercepts := tm.currentIntercepts
+	tm.currentInterceptsLock.Unlock()
+
+	backoff := reconnectBackoff
+	for c.Err() == nil {
+		metrics.ReconnectAttempts.Inc()
+		err := tm.replaceSession(c, broken, intercepts)
+		if err == nil {
+			dlog.Infof(c, "Session recovered after %s", time.Since(since).Round(time.Millisecond))
+			return
+		}
+		dlog.Errorf(c, "Reconnect failed, retrying in %s: %v", backoff, err)
+		dtime.SleepWithContext(c, backoff)
+		backoff *= 2
+		if backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+// replaceSession arrives with a new session, tells the root daemon about it so that it can restore its
+// routes and DNS, and re-creates the given intercepts of the broken session. The root daemon isn't told
+// when the proxy mode doesn't use it.
+func (tm *trafficManager) replaceSession(c context.Context, broken *manager.SessionInfo, intercepts []*manager.InterceptInfo) error {
+	tc, cancel := client.GetConfig(c).Timeouts.TimeoutContext(c, client.TimeoutTrafficManagerAPI)
+	defer cancel()
+	si, err := tm.arrive(tc, tm.managerClient)
+	if err != nil {
+		return client.CheckTimeout(tc, err)
+	}
+
+	// The traffic-manager still knows the broken session if it was the connection that broke. Departing
+	// from it removes its intercepts, so that they can be re-created.
+	_, _ = tm.managerClient.Depart(tc, broken)
+	tm.setSession(si)
+	dlog.Infof(c, "Session %s replaces session %s", si.SessionId, broken.SessionId)
+
+	if tm.usesRootDaemon() {
+		if _, err = tm.callbacks.SetOutboundInfo(tc, tm.getOutboundInfo(c)); err != nil {
+			dlog.Errorf(c, "daemon.SetOutboundInfo: %v", err)
+		}
+	}
+	for _, ii := range intercepts {
+		tm.rearmIntercept(c, ii.Spec)
+	}
+	return nil
+}
+
+// rearmIntercept re-creates an intercept of a broken session in the current session.
+func (tm *trafficManager) rearmIntercept(c context.Context, spec *manager.InterceptSpec) {
+	spec = proto.Clone(spec).(*manager.InterceptSpec)
+	apiKey, err := tm.callbacks.GetCloudAPIKey(c, a8rcloud.KeyDescAgent(spec), false)
+	if err != nil {
+		dlog.Debugf(c, "unable to get apiKey for agent: %v", err)
+	}
+	tc, cancel := client.GetConfig(c).Timeouts.TimeoutContext(c, client.TimeoutIntercept)
+	defer cancel()
+	_, err = tm.managerClient.CreateIntercept(tc, &manager.CreateInterceptRequest{
+		Session:       tm.session(),
+		InterceptSpec: spec,
+		ApiKey:        apiKey,
+	})
+	if err != nil {
+		dlog.Errorf(c, "Unable to re-create intercept %s: %v", spec.Name, client.CheckTimeout(tc, err))
+		return
+	}
+	dlog.Infof(c, "Re-created intercept %s", spec.Name)
+}
@@ -0,0 +1,343 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_k8s"
+	"github.com/telepresenceio/telepresence/v2/pkg/metrics"
+)
+
+// fakeManager is a traffic-manager that forgets a session when it's dropped, just like a manager
+// does when the session expires while the laptop sleeps.
+type fakeManager struct {
+	manager.ManagerClient
+	sync.Mutex
+	name       string
+	sessions   map[string]bool
+	arrivals   int
+	intercepts map[string]string // intercept name -> session ID
+	stopped    chan struct{}
+}
+
+func newFakeManager() *fakeManager {
+	return &fakeManager{
+		name:       "session",
+		sessions:   make(map[string]bool),
+		intercepts: make(map[string]string),
+		stopped:    make(chan struct{}),
+	}
+}
+
+func (m *fakeManager) ArriveAsClient(context.Context, *manager.ClientInfo, ...grpc.CallOption) (*manager.SessionInfo, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.arrivals++
+	id := fmt.Sprintf("%s-%d", m.name, m.arrivals)
+	m.sessions[id] = true
+	return &manager.SessionInfo{SessionId: id}, nil
+}
+
+func (m *fakeManager) Remain(_ context.Context, rq *manager.RemainRequest, _ ...grpc.CallOption) (*empty.Empty, error) {
+	m.Lock()
+	defer m.Unlock()
+	if !m.sessions[rq.Session.SessionId] {
+		return nil, grpcStatus.Errorf(grpcCodes.NotFound, "Session %q not found", rq.Session.SessionId)
+	}
+	return &empty.Empty{}, nil
+}
+
+func (m *fakeManager) Depart(_ context.Context, si *manager.SessionInfo, _ ...grpc.CallOption) (*empty.Empty, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.dropLocked(si.SessionId)
+	return &empty.Empty{}, nil
+}
+
+func (m *fakeManager) CreateIntercept(_ context.Context, rq *manager.CreateInterceptRequest, _ ...grpc.CallOption) (*manager.InterceptInfo, error) {
+	m.Lock()
+	defer m.Unlock()
+	if !m.sessions[rq.Session.SessionId] {
+		return nil, grpcStatus.Errorf(grpcCodes.NotFound, "Session %q not found", rq.Session.SessionId)
+	}
+	m.intercepts[rq.InterceptSpec.Name] = rq.Session.SessionId
+	return &manager.InterceptInfo{Spec: rq.InterceptSpec, Id: rq.Session.SessionId + ":" + rq.InterceptSpec.Name}, nil
+}
+
+func (m *fakeManager) RemoveIntercept(_ context.Context, rq *manager.RemoveInterceptRequest2, _ ...grpc.CallOption) (*empty.Empty, error) {
+	m.Lock()
+	defer m.Unlock()
+	if m.intercepts[rq.Name] != rq.Session.SessionId {
+		return nil, grpcStatus.Errorf(grpcCodes.NotFound, "Intercept %q not found", rq.Name)
+	}
+	delete(m.intercepts, rq.Name)
+	return &empty.Empty{}, nil
+}
+
+func (m *fakeManager) WatchAgents(ctx context.Context, si *manager.SessionInfo, _ ...grpc.CallOption) (manager.Manager_WatchAgentsClient, error) {
+	return &fakeAgentsStream{ctx: ctx, m: m, sessionID: si.SessionId}, nil
+}
+
+// fakeAgentsStream sends one snapshot with the agent "echo", and then blocks until its context is cancelled or its
+// traffic-manager stops. A traffic-manager that doesn't know the session ends it with the error that a
+// real traffic-manager returns.
+type fakeAgentsStream struct {
+	grpc.ClientStream
+	ctx       context.Context
+	m         *fakeManager
+	sessionID string
+	sent      bool
+}
+
+func (s *fakeAgentsStream) Recv() (*manager.AgentInfoSnapshot, error) {
+	s.m.Lock()
+	known := s.m.sessions[s.sessionID]
+	s.m.Unlock()
+	if !known {
+		st, err := grpcStatus.New(grpcCodes.NotFound, "Session not found").WithDetails(&manager.UnknownSession{SessionId: s.sessionID})
+		if err != nil {
+			return nil, err
+		}
+		return nil, st.Err()
+	}
+	if !s.sent {
+		s.sent = true
+		return &manager.AgentInfoSnapshot{Agents: []*manager.AgentInfo{{Name: "echo", Namespace: "default"}}}, nil
+	}
+	select {
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	case <-s.m.stopped:
+		return nil, grpcStatus.Error(grpcCodes.Unavailable, "transport is closing")
+	}
+}
+
+// drop simulates that the traffic-manager loses a session, together with its intercepts.
+func (m *fakeManager) drop(sessionID string) {
+	m.Lock()
+	defer m.Unlock()
+	m.dropLocked(sessionID)
+}
+
+func (m *fakeManager) dropLocked(sessionID string) {
+	delete(m.sessions, sessionID)
+	for name, id := range m.intercepts {
+		if id == sessionID {
+			delete(m.intercepts, name)
+		}
+	}
+}
+
+func (m *fakeManager) interceptSessions() map[string]string {
+	m.Lock()
+	defer m.Unlock()
+	is := make(map[string]string, len(m.intercepts))
+	for k, v := range m.intercepts {
+		is[k] = v
+	}
+	return is
+}
+
+// replicatedManager is a traffic-manager service that sends all requests to one of its replicas, and
+// switches to another replica when the one that it uses goes away.
+type replicatedManager struct {
+	manager.ManagerClient
+	sync.Mutex
+	replicas []*fakeManager
+	current  int
+}
+
+func (r *replicatedManager) replica() *fakeManager {
+	r.Lock()
+	defer r.Unlock()
+	return r.replicas[r.current]
+}
+
+// switchover stops the current replica, and sends the requests that follow to the next one.
+func (r *replicatedManager) switchover() {
+	r.Lock()
+	defer r.Unlock()
+	close(r.replicas[r.current].stopped)
+	r.current = (r.current + 1) % len(r.replicas)
+}
+
+func (r *replicatedManager) ArriveAsClient(ctx context.Context, ci *manager.ClientInfo, opts ...grpc.CallOption) (*manager.SessionInfo, error) {
+	return r.replica().ArriveAsClient(ctx, ci, opts...)
+}
+
+func (r *replicatedManager) Remain(ctx context.Context, rq *manager.RemainRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	return r.replica().Remain(ctx, rq, opts...)
+}
+
+func (r *replicatedManager) Depart(ctx context.Context, si *manager.SessionInfo, opts ...grpc.CallOption) (*empty.Empty, error) {
+	return r.replica().Depart(ctx, si, opts...)
+}
+
+func (r *replicatedManager) CreateIntercept(ctx context.Context, rq *manager.CreateInterceptRequest, opts ...grpc.CallOption) (*manager.InterceptInfo, error) {
+	return r.replica().CreateIntercept(ctx, rq, opts...)
+}
+
+func (r *replicatedManager) RemoveIntercept(ctx context.Context, rq *manager.RemoveInterceptRequest2, opts ...grpc.CallOption) (*empty.Empty, error) {
+	return r.replica().RemoveIntercept(ctx, rq, opts...)
+}
+
+func (r *replicatedManager) WatchAgents(ctx context.Context, si *manager.SessionInfo, opts ...grpc.CallOption) (manager.Manager_WatchAgentsClient, error) {
+	return r.replica().WatchAgents(ctx, si, opts...)
+}
+
+func TestReconnect_rearmsIntercepts(t *testing.T) {
+	defer func(ri, rb time.Duration) {
+		remainInterval = ri
+		reconnectBackoff = rb
+	}(remainInterval, reconnectBackoff)
+	remainInterval = 10 * time.Millisecond
+	reconnectBackoff = 10 * time.Millisecond
+
+	ctx := client.WithEnv(dlog.NewTestContext(t, false), &client.Env{})
+	cfg := client.GetDefaultConfig(ctx)
+	ctx, cancel := context.WithCancel(client.WithConfig(ctx, &cfg))
+	defer cancel()
+
+	mgr := newFakeManager()
+	outboundSessions := make(chan string, 10)
+	tm := &trafficManager{
+		installer: &installer{Cluster: &userd_k8s.Cluster{Config: &userd_k8s.Config{Server: "https://127.0.0.1:6443"}}},
+		callbacks: Callbacks{
+			GetCloudAPIKey: func(context.Context, string, bool) (string, error) { return "", nil },
+			SetOutboundInfo: func(_ context.Context, in *daemon.OutboundInfo, _ ...grpc.CallOption) (*empty.Empty, error) {
+				outboundSessions <- in.Session.SessionId
+				return &empty.Empty{}, nil
+			},
+		},
+		managerClient: mgr,
+		startup:       make(chan struct{}),
+	}
+	close(tm.startup)
+	si, err := tm.arrive(ctx, mgr)
+	require.NoError(t, err)
+	tm.setSession(si)
+	for _, name := range []string{"echo", "api"} {
+		ii, err := mgr.CreateIntercept(ctx, &manager.CreateInterceptRequest{
+			Session:       si,
+			InterceptSpec: &manager.InterceptSpec{Name: name, Namespace: "default", Agent: name},
+		})
+		require.NoError(t, err)
+		tm.currentIntercepts = append(tm.currentIntercepts, ii)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = tm.remain(ctx)
+	}()
+
+	// The laptop wakes up, and the traffic-manager has forgotten the session
+	attempts := testutil.ToFloat64(metrics.ReconnectAttempts)
+	mgr.drop(si.SessionId)
+	select {
+	case id := <-outboundSessions:
+		assert.Equal(t, "session-2", id)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the daemon was never told about the new session")
+	}
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(map[string]string{"echo": "session-2", "api": "session-2"}, mgr.interceptSessions())
+	}, 5*time.Second, 10*time.Millisecond, "intercepts were not re-created")
+	assert.Equal(t, "session-2", tm.session().SessionId)
+	assert.Eventually(t, func() bool { return tm.getReconnectingSince().IsZero() }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.ReconnectAttempts)-attempts)
+
+	cancel()
+	<-done
+}
+
+func TestReconnect_replicaSwitchover(t *testing.T) {
+	defer func(ri, rb time.Duration) {
+		remainInterval = ri
+		reconnectBackoff = rb
+	}(remainInterval, reconnectBackoff)
+	// The unknown session must be found by the watcher, long before the session is kept alive
+	remainInterval = time.Hour
+	reconnectBackoff = 10 * time.Millisecond
+
+	ctx := client.WithEnv(dlog.NewTestContext(t, false), &client.Env{})
+	cfg := client.GetDefaultConfig(ctx)
+	ctx, cancel := context.WithCancel(client.WithConfig(ctx, &cfg))
+	defer cancel()
+
+	replicaA, replicaB := newFakeManager(), newFakeManager()
+	replicaA.name, replicaB.name = "a", "b"
+	mgr := &replicatedManager{replicas: []*fakeManager{replicaA, replicaB}}
+	outboundSessions := make(chan string, 10)
+	tm := &trafficManager{
+		installer: &installer{Cluster: &userd_k8s.Cluster{Config: &userd_k8s.Config{Server: "https://127.0.0.1:6443"}}},
+		callbacks: Callbacks{
+			GetCloudAPIKey: func(context.Context, string, bool) (string, error) { return "", nil },
+			SetOutboundInfo: func(_ context.Context, in *daemon.OutboundInfo, _ ...grpc.CallOption) (*empty.Empty, error) {
+				outboundSessions <- in.Session.SessionId
+				return &empty.Empty{}, nil
+			},
+		},
+		managerClient:  mgr,
+		startup:        make(chan struct{}),
+		unknownSession: make(chan string, 1),
+	}
+	close(tm.startup)
+	si, err := tm.arrive(ctx, mgr)
+	require.NoError(t, err)
+	tm.setSession(si)
+	ii, err := mgr.CreateIntercept(ctx, &manager.CreateInterceptRequest{
+		Session:       si,
+		InterceptSpec: &manager.InterceptSpec{Name: "echo", Namespace: "default", Agent: "echo"},
+	})
+	require.NoError(t, err)
+	tm.currentIntercepts = []*manager.InterceptInfo{ii}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_ = tm.remain(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		_ = tm.agentInfoWatcher(ctx)
+	}()
+
+	// The replica that the session arrived at goes away, and the watch lands on a replica that doesn't know it
+	assert.Eventually(t, func() bool {
+		tm.currentAgentsLock.Lock()
+		defer tm.currentAgentsLock.Unlock()
+		return tm.currentAgents != nil
+	}, 5*time.Second, 10*time.Millisecond, "the agents were never watched")
+	mgr.switchover()
+	select {
+	case id := <-outboundSessions:
+		assert.Equal(t, "b-1", id)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the daemon was never told about the new session")
+	}
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(map[string]string{"echo": "b-1"}, replicaB.interceptSessions())
+	}, 5*time.Second, 10*time.Millisecond, "the intercept was not re-created on the new replica")
+	assert.Equal(t, "b-1", tm.session().SessionId)
+	assert.Eventually(t, func() bool { return tm.getReconnectingSince().IsZero() }, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	wg.Wait()
+}
@@ -0,0 +1,222 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	dnsServer "github.com/telepresenceio/telepresence/v2/pkg/client/daemon/dns"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+// resolveCluster is the part of the cluster, and of its traffic-manager, that a resolve uses.
+type resolveCluster interface {
+	FindSvc(c context.Context, namespace, name string) (*kates.Service, error)
+	ServiceEndpointSlices(c context.Context, namespace, name string) ([]*discoveryv1.EndpointSlice, error)
+
+	// lookupHost looks up the IPs of the given name in the cluster. It returns no IPs when the name
+	// doesn't exist.
+	lookupHost(c context.Context, name string) (iputil.IPs, error)
+}
+
+// Resolve looks up the name of the given request in the cluster. The A and AAAA records come from the
+// same cluster side lookup that the DNS server of the root daemon uses, and the SRV records are those
+// that the cluster DNS declares for the named ports of a service.
+func (tm *trafficManager) Resolve(c context.Context, rr *rpc.ResolveRequest) (*rpc.ResolveResult, error) {
+	tm.sessionLock.RLock()
+	clusterDomain := tm.clusterDomain
+	tm.sessionLock.RUnlock()
+	return resolve(c, tm, tm.Namespace, clusterDomain, rr)
+}
+
+func (tm *trafficManager) lookupHost(c context.Context, name string) (iputil.IPs, error) {
+	r, err := tm.managerClient.LookupHost(c, &manager.LookupHostRequest{Session: tm.session(), Host: name})
+	if err != nil {
+		return nil, client.CheckTimeout(c, err)
+	}
+	return iputil.IPsFromBytesSlice(r.Ips), nil
+}
+
+// resolve looks up the name of the given request in the given cluster. A name that isn't fully qualified
+// is looked up using the search path of a pod in the given namespace, and the first name of that path
+// that exists is answered.
+func resolve(c context.Context, rc resolveCluster, namespace, clusterDomain string, rr *rpc.ResolveRequest) (*rpc.ResolveResult, error) {
+	qType := strings.ToUpper(rr.Type)
+	if qType == "" {
+		qType = "A"
+	}
+	var lookup func(context.Context, resolveCluster, string, string) ([]*rpc.ResolveAnswer, bool, error)
+	switch qType {
+	case "A", "AAAA":
+		lookup = lookupAddresses
+	case "SRV":
+		lookup = lookupSRV
+	default:
+		return nil, errcat.User.Newf("unsupported record type %q, must be A, AAAA, or SRV", rr.Type)
+	}
+	if rr.Name == "" {
+		return nil, errcat.User.New("no name to resolve")
+	}
+	if clusterDomain == "" {
+		clusterDomain = "cluster.local"
+	}
+	for _, name := range searchNames(rr.Name, namespace, clusterDomain) {
+		dlog.Debugf(c, "Resolving %s %s", qType, name)
+		answers, found, err := lookup(c, rc, name, qType)
+		if err != nil {
+			return &rpc.ResolveResult{
+				Rcode:         rpc.ResolveResult_SERVFAIL,
+				Name:          name,
+				ErrorText:     err.Error(),
+				ErrorCategory: int32(errcat.GetCategory(err)),
+			}, nil
+		}
+		if found {
+			return &rpc.ResolveResult{Rcode: rpc.ResolveResult_NOERROR, Name: name, Answers: answers}, nil
+		}
+	}
+	return &rpc.ResolveResult{Rcode: rpc.ResolveResult_NXDOMAIN, Name: strings.TrimSuffix(rr.Name, ".")}, nil
+}
+
+// searchNames returns the fully qualified names, without trailing dot, that the given name is looked up
+// as. Like in the resolv.conf of a pod, which has "ndots:5", a name with less than five dots is looked up
+// in the search path of the namespace before it's looked up as is. A name that ends with a dot, or with
+// the cluster domain, is fully qualified.
+func searchNames(name, namespace, clusterDomain string) []string {
+	name = strings.ToLower(name)
+	if strings.HasSuffix(name, ".") {
+		return []string{strings.TrimSuffix(name, ".")}
+	}
+	if name == clusterDomain || strings.HasSuffix(name, "."+clusterDomain) {
+		return []string{name}
+	}
+	search := []string{
+		name + "." + namespace + ".svc." + clusterDomain,
+		name + ".svc." + clusterDomain,
+		name + "." + clusterDomain,
+	}
+	if strings.Count(name, ".") < 5 {
+		return append(search, name)
+	}
+	return append([]string{name}, search...)
+}
+
+// lookupAddresses returns the A or AAAA records of the given name. The name is found when it has an
+// address of either family.
+func lookupAddresses(c context.Context, rc resolveCluster, name, qType string) ([]*rpc.ResolveAnswer, bool, error) {
+	ips, err := rc.lookupHost(c, name)
+	if err != nil || len(ips) == 0 {
+		return nil, false, err
+	}
+	var answers []*rpc.ResolveAnswer
+	for _, ip := range ips.UniqueSorted() {
+		ip4 := ip.To4()
+		if (ip4 != nil) != (qType == "A") {
+			continue
+		}
+		if ip4 != nil {
+			ip = ip4
+		}
+		answers = append(answers, &rpc.ResolveAnswer{Name: name, Type: qType, Ttl: dnsServer.RecordTTL, Ip: ip})
+	}
+	return answers, true, nil
+}
+
+// lookupSRV returns the SRV records of a name on the form _<port>._<protocol>.<service>.<namespace>.svc.<cluster domain>,
+// which are declared for the named ports of a service. The records of a service that has a cluster IP
+// target the service, and the records of a headless service target each of its ready endpoints.
+func lookupSRV(c context.Context, rc resolveCluster, name, _ string) ([]*rpc.ResolveAnswer, bool, error) {
+	parts := strings.SplitN(name, ".", 6)
+	if len(parts) < 6 || parts[4] != "svc" || parts[5] == "" || !strings.HasPrefix(parts[0], "_") || !strings.HasPrefix(parts[1], "_") {
+		return nil, false, nil
+	}
+	portName, protocol, svcName, namespace, clusterDomain := parts[0][1:], strings.ToUpper(parts[1][1:]), parts[2], parts[3], parts[5]
+
+	svc, err := rc.FindSvc(c, namespace, svcName)
+	if err != nil {
+		if kates.IsNotFound(err) {
+			err = nil
+		}
+		return nil, false, err
+	}
+	var port *corev1.ServicePort
+	for i := range svc.Spec.Ports {
+		sp := &svc.Spec.Ports[i]
+		spProtocol := string(sp.Protocol)
+		if spProtocol == "" {
+			spProtocol = string(corev1.ProtocolTCP)
+		}
+		if strings.EqualFold(sp.Name, portName) && spProtocol == protocol {
+			port = sp
+			break
+		}
+	}
+	if port == nil || svc.Spec.Type == corev1.ServiceTypeExternalName {
+		return nil, false, nil
+	}
+
+	fqdn := svcName + "." + namespace + ".svc." + clusterDomain
+	srv := func(target string, weight uint32) *rpc.ResolveAnswer {
+		return &rpc.ResolveAnswer{Name: name, Type: "SRV", Ttl: dnsServer.RecordTTL, Target: target + ".", Port: uint32(port.Port), Weight: weight}
+	}
+	if svc.Spec.ClusterIP != corev1.ClusterIPNone {
+		return []*rpc.ResolveAnswer{srv(fqdn, 100)}, true, nil
+	}
+
+	slices, err := rc.ServiceEndpointSlices(c, namespace, svcName)
+	if err != nil {
+		return nil, false, err
+	}
+	hosts := endpointHosts(slices)
+	if len(hosts) == 0 {
+		return nil, false, nil
+	}
+	weight := uint32(100 / len(hosts))
+	if weight == 0 {
+		weight = 1
+	}
+	answers := make([]*rpc.ResolveAnswer, len(hosts))
+	for i, host := range hosts {
+		answers[i] = srv(host+"."+fqdn, weight)
+	}
+	return answers, true, nil
+}
+
+// endpointHosts returns the sorted and unique hostnames of the ready endpoints in the given EndpointSlices.
+// An endpoint without a hostname gets a name derived from its IP, like in the cluster DNS.
+func endpointHosts(slices []*discoveryv1.EndpointSlice) []string {
+	seen := make(map[string]struct{})
+	var hosts []string
+	for _, slice := range slices {
+		for _, ep := range slice.Endpoints {
+			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready || len(ep.Addresses) == 0 {
+				continue
+			}
+			var host string
+			if ep.Hostname != nil && *ep.Hostname != "" {
+				host = *ep.Hostname
+			} else if ip := net.ParseIP(ep.Addresses[0]); ip != nil {
+				host = strings.NewReplacer(".", "-", ":", "-").Replace(ip.String())
+			} else {
+				continue
+			}
+			host = strings.ToLower(host)
+			if _, ok := seen[host]; !ok {
+				seen[host] = struct{}{}
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
@@ -0,0 +1,214 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	k8err "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+type fakeResolveCluster struct {
+	hosts   map[string]iputil.IPs
+	svcs    []*kates.Service
+	slices  []*discoveryv1.EndpointSlice
+	lookups []string
+	err     error
+}
+
+func (f *fakeResolveCluster) FindSvc(_ context.Context, namespace, name string) (*kates.Service, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	for _, svc := range f.svcs {
+		if svc.Namespace == namespace && svc.Name == name {
+			return svc, nil
+		}
+	}
+	return nil, k8err.NewNotFound(schema.GroupResource{Resource: "services"}, name)
+}
+
+func (f *fakeResolveCluster) ServiceEndpointSlices(_ context.Context, namespace, name string) ([]*discoveryv1.EndpointSlice, error) {
+	var slices []*discoveryv1.EndpointSlice
+	for _, slice := range f.slices {
+		if slice.Namespace == namespace && slice.Labels[discoveryv1.LabelServiceName] == name {
+			slices = append(slices, slice)
+		}
+	}
+	return slices, nil
+}
+
+func (f *fakeResolveCluster) lookupHost(_ context.Context, name string) (iputil.IPs, error) {
+	f.lookups = append(f.lookups, name)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.hosts[name], nil
+}
+
+func Test_searchNames(t *testing.T) {
+	assert.Equal(t, []string{
+		"echo.blue.svc.cluster.local",
+		"echo.svc.cluster.local",
+		"echo.cluster.local",
+		"echo",
+	}, searchNames("Echo", "blue", "cluster.local"))
+	assert.Equal(t, []string{"echo.red.svc.cluster.local"}, searchNames("echo.red.svc.cluster.local", "blue", "cluster.local"))
+	assert.Equal(t, []string{"example.com"}, searchNames("example.com.", "blue", "cluster.local"))
+	assert.Equal(t, []string{
+		"a.b.c.d.e.f",
+		"a.b.c.d.e.f.blue.svc.cluster.local",
+		"a.b.c.d.e.f.svc.cluster.local",
+		"a.b.c.d.e.f.cluster.local",
+	}, searchNames("a.b.c.d.e.f", "blue", "cluster.local"))
+}
+
+func Test_resolve(t *testing.T) {
+	ready := func(b bool) *bool { return &b }
+	hostname := "postgres-0"
+	rc := &fakeResolveCluster{
+		hosts: map[string]iputil.IPs{
+			"echo.blue.svc.cluster.local":     {net.IP{10, 96, 0, 10}, net.ParseIP("fd00:96::10")},
+			"echo.red.svc.cluster.local":      {net.IP{10, 96, 1, 10}},
+			"postgres.blue.svc.cluster.local": {net.IP{10, 1, 0, 6}, net.IP{10, 1, 0, 5}},
+		},
+		svcs: []*kates.Service{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "blue"},
+				Spec: corev1.ServiceSpec{ClusterIP: "10.96.0.10", Ports: []corev1.ServicePort{
+					{Name: "http", Port: 80},
+					{Name: "dns", Port: 53, Protocol: corev1.ProtocolUDP},
+				}},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "postgres", Namespace: "blue"},
+				Spec: corev1.ServiceSpec{ClusterIP: corev1.ClusterIPNone, Ports: []corev1.ServicePort{
+					{Name: "postgres", Port: 5432, Protocol: corev1.ProtocolTCP},
+				}},
+			},
+		},
+		slices: []*discoveryv1.EndpointSlice{{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "postgres-abcde",
+				Namespace: "blue",
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "postgres"},
+			},
+			Endpoints: []discoveryv1.Endpoint{
+				{Addresses: []string{"10.1.0.5"}, Hostname: &hostname},
+				{Addresses: []string{"10.1.0.6"}},
+				{Addresses: []string{"10.1.0.7"}, Conditions: discoveryv1.EndpointConditions{Ready: ready(false)}},
+			},
+		}},
+	}
+	ctx := dlog.NewTestContext(t, false)
+	lookup := func(name, qType string) *rpc.ResolveResult {
+		t.Helper()
+		rc.lookups = nil
+		r, err := resolve(ctx, rc, "blue", "cluster.local", &rpc.ResolveRequest{Name: name, Type: qType})
+		require.NoError(t, err)
+		return r
+	}
+
+	t.Run("A", func(t *testing.T) {
+		r := lookup("echo", "")
+		assert.Equal(t, rpc.ResolveResult_NOERROR, r.Rcode)
+		assert.Equal(t, "echo.blue.svc.cluster.local", r.Name)
+		require.Len(t, r.Answers, 1)
+		assert.Equal(t, "A", r.Answers[0].Type)
+		assert.Equal(t, uint32(60), r.Answers[0].Ttl)
+		assert.Equal(t, "10.96.0.10", net.IP(r.Answers[0].Ip).String())
+		assert.Equal(t, []string{"echo.blue.svc.cluster.local"}, rc.lookups)
+
+		// A name in another namespace is found further down the search path
+		r = lookup("echo.red", "A")
+		assert.Equal(t, "echo.red.svc.cluster.local", r.Name)
+		assert.Equal(t, []string{"echo.red.blue.svc.cluster.local", "echo.red.svc.cluster.local"}, rc.lookups)
+
+		// Addresses are sorted
+		r = lookup("postgres.blue.svc.cluster.local", "a")
+		require.Len(t, r.Answers, 2)
+		assert.Equal(t, "10.1.0.5", net.IP(r.Answers[0].Ip).String())
+		assert.Equal(t, "10.1.0.6", net.IP(r.Answers[1].Ip).String())
+	})
+
+	t.Run("AAAA", func(t *testing.T) {
+		r := lookup("echo.blue.svc.cluster.local.", "AAAA")
+		assert.Equal(t, rpc.ResolveResult_NOERROR, r.Rcode)
+		require.Len(t, r.Answers, 1)
+		assert.Equal(t, "AAAA", r.Answers[0].Type)
+		assert.Equal(t, "fd00:96::10", net.IP(r.Answers[0].Ip).String())
+
+		// A name that only has IPv4 addresses exists, but has no AAAA records
+		r = lookup("echo.red", "AAAA")
+		assert.Equal(t, rpc.ResolveResult_NOERROR, r.Rcode)
+		assert.Empty(t, r.Answers)
+	})
+
+	t.Run("SRV", func(t *testing.T) {
+		r := lookup("_http._tcp.echo", "SRV")
+		assert.Equal(t, rpc.ResolveResult_NOERROR, r.Rcode)
+		assert.Equal(t, "_http._tcp.echo.blue.svc.cluster.local", r.Name)
+		require.Len(t, r.Answers, 1)
+		a := r.Answers[0]
+		assert.Equal(t, "SRV", a.Type)
+		assert.Equal(t, "echo.blue.svc.cluster.local.", a.Target)
+		assert.Equal(t, uint32(80), a.Port)
+		assert.Equal(t, uint32(100), a.Weight)
+
+		r = lookup("_dns._udp.echo.blue", "SRV")
+		require.Len(t, r.Answers, 1)
+		assert.Equal(t, uint32(53), r.Answers[0].Port)
+
+		// A headless service has one record per ready endpoint
+		r = lookup("_postgres._tcp.postgres.blue.svc.cluster.local", "SRV")
+		require.Len(t, r.Answers, 2)
+		assert.Equal(t, "10-1-0-6.postgres.blue.svc.cluster.local.", r.Answers[0].Target)
+		assert.Equal(t, "postgres-0.postgres.blue.svc.cluster.local.", r.Answers[1].Target)
+		assert.Equal(t, uint32(5432), r.Answers[1].Port)
+		assert.Equal(t, uint32(50), r.Answers[1].Weight)
+
+		// A port with another protocol, or without a name, has no record
+		assert.Equal(t, rpc.ResolveResult_NXDOMAIN, lookup("_http._udp.echo", "SRV").Rcode)
+		assert.Equal(t, rpc.ResolveResult_NXDOMAIN, lookup("_https._tcp.echo", "SRV").Rcode)
+	})
+
+	t.Run("NXDOMAIN", func(t *testing.T) {
+		r := lookup("nothing", "A")
+		assert.Equal(t, rpc.ResolveResult_NXDOMAIN, r.Rcode)
+		assert.Equal(t, "nothing", r.Name)
+		assert.Len(t, rc.lookups, 4)
+
+		assert.Equal(t, rpc.ResolveResult_NXDOMAIN, lookup("_http._tcp.nothing", "SRV").Rcode)
+	})
+
+	t.Run("SERVFAIL", func(t *testing.T) {
+		rc.err = errors.New("rpc error: code = Unavailable desc = connection refused")
+		defer func() { rc.err = nil }()
+		r := lookup("echo", "A")
+		assert.Equal(t, rpc.ResolveResult_SERVFAIL, r.Rcode)
+		assert.Equal(t, "echo.blue.svc.cluster.local", r.Name)
+		assert.Contains(t, r.ErrorText, "connection refused")
+		assert.Len(t, rc.lookups, 1)
+
+		r = lookup("_http._tcp.echo", "SRV")
+		assert.Equal(t, rpc.ResolveResult_SERVFAIL, r.Rcode)
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		_, err := resolve(ctx, rc, "blue", "cluster.local", &rpc.ResolveRequest{Name: "echo", Type: "MX"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `unsupported record type "MX"`)
+	})
+}
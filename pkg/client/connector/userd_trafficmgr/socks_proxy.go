@@ -0,0 +1,245 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// The parts of the SOCKS5 protocol (RFC 1928) that the SOCKSProxy implements.
+const (
+	socksVersion = 5
+
+	socksAuthNone         = 0x00
+	socksAuthNoAcceptable = 0xff
+
+	socksCmdConnect = 0x01
+
+	socksAddrIPv4   = 0x01
+	socksAddrDomain = 0x03
+	socksAddrIPv6   = 0x04
+
+	socksReplySucceeded           = 0x00
+	socksReplyGeneralFailure      = 0x01
+	socksReplyHostUnreachable     = 0x04
+	socksReplyCmdNotSupported     = 0x07
+	socksReplyAddrTypeUnsupported = 0x08
+)
+
+// socksHandshakeTimeout is how long a client may take to tell where it wants to connect.
+const socksHandshakeTimeout = 30 * time.Second
+
+// SOCKSProxy implements the "socks5" proxy mode, which needs no root daemon. It's a SOCKS5 server that
+// relays the TCP connection of each CONNECT request over the tunnel of the traffic-manager. A domain name
+// in a request is resolved in the cluster, using the search path of the namespace of the connect, so a
+// client that leaves the name resolution to the proxy (e.g. curl --socks5-hostname) reaches the services
+// by their short names. Other commands, such as UDP ASSOCIATE, are rejected.
+type SOCKSProxy struct {
+	listener net.Listener
+
+	// forward forwards the given connection to the cluster. It's set by the traffic-manager.
+	forward func(ctx context.Context, id tunnel.ConnID, conn net.Conn)
+
+	// lookup resolves the given name in the cluster. It's set by the traffic-manager.
+	lookup func(ctx context.Context, name string) (net.IP, error)
+
+	mu           sync.Mutex
+	lastActivity time.Time
+}
+
+// NewSOCKSProxy returns a SOCKSProxy that listens to the given address. The listener is created right
+// away, so that a connect fails when the address is in use. It's closed when the proxy ends.
+func NewSOCKSProxy(address string) (*SOCKSProxy, error) {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen for SOCKS5 connections: %w", err)
+	}
+	return &SOCKSProxy{listener: l}, nil
+}
+
+// Close closes the listener of the proxy. The connections that it relays remain until the context of
+// the proxy is cancelled.
+func (p *SOCKSProxy) Close() error {
+	return p.listener.Close()
+}
+
+// run accepts the connections of SOCKS5 clients until the given context is cancelled.
+func (p *SOCKSProxy) run(c context.Context) error {
+	dlog.Infof(c, "Serving SOCKS5 on %s", p.listener.Addr())
+	go func() {
+		<-c.Done()
+		_ = p.Close()
+	}()
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			if c.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("SOCKS5 listener failed: %w", err)
+		}
+		go p.serve(c, conn)
+	}
+}
+
+// serve reads the request of the given client connection and relays the connection to the cluster.
+func (p *SOCKSProxy) serve(c context.Context, conn net.Conn) {
+	_ = conn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+	id, err := p.handshake(c, conn)
+	if err != nil {
+		dlog.Debugf(c, "SOCKS5 request from %s failed: %v", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+	p.mu.Lock()
+	p.lastActivity = time.Now()
+	p.mu.Unlock()
+	p.forward(c, id, conn)
+}
+
+// handshake negotiates the authentication method and reads the CONNECT request of the client. It returns
+// the ID of the connection to the destination, after the client has been told that it succeeded. A
+// request that can't be served gets the reply that tells why, and an error.
+func (p *SOCKSProxy) handshake(c context.Context, conn net.Conn) (tunnel.ConnID, error) {
+	// The greeting is the version, followed by the number of authentication methods, and the methods
+	buf := make([]byte, 255)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return "", err
+	}
+	if buf[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d", buf[0])
+	}
+	methods := buf[:buf[1]]
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	method := byte(socksAuthNoAcceptable)
+	for _, m := range methods {
+		if m == socksAuthNone {
+			method = socksAuthNone
+			break
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return "", err
+	}
+	if method == socksAuthNoAcceptable {
+		return "", errors.New("the client requires authentication")
+	}
+
+	// The request is the version, the command, a reserved byte, the address type, the address, and the port
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return "", err
+	}
+	if buf[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d", buf[0])
+	}
+	cmd, addrType := buf[1], buf[3]
+	var ip net.IP
+	var name string
+	switch addrType {
+	case socksAddrIPv4, socksAddrIPv6:
+		ip = make(net.IP, net.IPv4len)
+		if addrType == socksAddrIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+	case socksAddrDomain:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return "", err
+		}
+		domain := buf[:buf[0]]
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		name = string(domain)
+	default:
+		return "", p.reply(conn, socksReplyAddrTypeUnsupported, fmt.Errorf("unsupported address type %d", addrType))
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return "", err
+	}
+	port := binary.BigEndian.Uint16(buf)
+	if cmd != socksCmdConnect {
+		return "", p.reply(conn, socksReplyCmdNotSupported, fmt.Errorf("unsupported command %d, only CONNECT is supported", cmd))
+	}
+
+	if ip == nil {
+		var err error
+		if ip, err = p.lookup(c, name); err != nil {
+			return "", p.reply(conn, socksReplyGeneralFailure, fmt.Errorf("unable to resolve %s: %w", name, err))
+		}
+		if ip == nil {
+			return "", p.reply(conn, socksReplyHostUnreachable, fmt.Errorf("%s doesn't resolve in the cluster", name))
+		}
+		dlog.Debugf(c, "SOCKS5 resolved %s to %s", name, ip)
+	}
+	srcIP, srcPort, err := iputil.SplitToIPPort(conn.RemoteAddr())
+	if err != nil {
+		return "", p.reply(conn, socksReplyGeneralFailure, err)
+	}
+
+	// The bound address is unknown, because the connection is made by the traffic-manager or an agent
+	if err := p.reply(conn, socksReplySucceeded, nil); err != nil {
+		return "", err
+	}
+	return tunnel.NewConnID(ipproto.TCP, srcIP, ip, srcPort, port), nil
+}
+
+// reply writes the given reply to the client and returns the given error, or the error of the write.
+func (p *SOCKSProxy) reply(conn net.Conn, code byte, err error) error {
+	if _, werr := conn.Write([]byte{socksVersion, code, 0, socksAddrIPv4, 0, 0, 0, 0, 0, 0}); werr != nil && err == nil {
+		err = werr
+	}
+	return err
+}
+
+// getLastActivity returns the time when the last connection was relayed.
+func (p *SOCKSProxy) getLastActivity() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastActivity
+}
+
+// address returns the address that the proxy listens to.
+func (p *SOCKSProxy) address() string {
+	return p.listener.Addr().String()
+}
+
+// lookupClusterHost resolves the given name in the cluster, like the Resolve of the user daemon does, and
+// returns its first IPv4 address, or its first IPv6 address when it has no IPv4 address. A name that
+// doesn't exist resolves to nil.
+func (tm *trafficManager) lookupClusterHost(c context.Context, name string) (net.IP, error) {
+	for _, qType := range []string{"A", "AAAA"} {
+		r, err := tm.Resolve(c, &rpc.ResolveRequest{Name: name, Type: qType})
+		if err != nil {
+			return nil, err
+		}
+		if r.Rcode == rpc.ResolveResult_SERVFAIL {
+			return nil, errors.New(r.ErrorText)
+		}
+		for _, a := range r.Answers {
+			if len(a.Ip) > 0 {
+				return a.Ip, nil
+			}
+		}
+		if r.Rcode == rpc.ResolveResult_NXDOMAIN {
+			return nil, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,172 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// fakeSOCKSTunnel is the cluster side of a SOCKSProxy. A forwarded connection is relayed to the local
+// listener of its destination, and the names of the cluster resolve to their IPs.
+type fakeSOCKSTunnel struct {
+	names     map[string]net.IP
+	endpoints map[string]string
+
+	mu        sync.Mutex
+	lookups   []string
+	forwarded []tunnel.ConnID
+}
+
+func (ft *fakeSOCKSTunnel) lookup(_ context.Context, name string) (net.IP, error) {
+	ft.mu.Lock()
+	ft.lookups = append(ft.lookups, name)
+	ft.mu.Unlock()
+	if name == "broken" {
+		return nil, errors.New("connection refused")
+	}
+	return ft.names[name], nil
+}
+
+func (ft *fakeSOCKSTunnel) forward(_ context.Context, id tunnel.ConnID, conn net.Conn) {
+	defer conn.Close()
+	ft.mu.Lock()
+	ft.forwarded = append(ft.forwarded, id)
+	ft.mu.Unlock()
+	ep, err := net.Dial("tcp", ft.endpoints[id.DestinationAddr().String()])
+	if err != nil {
+		return
+	}
+	defer ep.Close()
+	go func() { _, _ = io.Copy(ep, conn) }()
+	_, _ = io.Copy(conn, ep)
+}
+
+func startSOCKSProxy(t *testing.T, ft *fakeSOCKSTunnel) *SOCKSProxy {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	p, err := NewSOCKSProxy("127.0.0.1:0")
+	require.NoError(t, err)
+	p.forward = ft.forward
+	p.lookup = ft.lookup
+	done := make(chan error, 1)
+	go func() { done <- p.run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+	})
+	return p
+}
+
+// assertGreeting asserts that the given connection reaches the echoServer that greets with "hello".
+func assertGreeting(t *testing.T, conn net.Conn) {
+	t.Helper()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	greeting, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(greeting))
+}
+
+func TestSOCKSProxy_connect(t *testing.T) {
+	ft := &fakeSOCKSTunnel{
+		names:     map[string]net.IP{"echo": {10, 96, 0, 10}},
+		endpoints: map[string]string{"10.96.0.10:80": fmt.Sprintf("127.0.0.1:%d", echoServer(t, "hello"))},
+	}
+	p := startSOCKSProxy(t, ft)
+	assert.True(t, p.getLastActivity().IsZero())
+	dialer, err := proxy.SOCKS5("tcp", p.address(), nil, proxy.Direct)
+	require.NoError(t, err)
+
+	// A name is resolved in the cluster, and the connection is relayed to its IP
+	conn, err := dialer.Dial("tcp", "echo:80")
+	require.NoError(t, err)
+	defer conn.Close()
+	assertGreeting(t, conn)
+	assert.False(t, p.getLastActivity().IsZero())
+
+	// An IP is relayed to as is
+	conn, err = dialer.Dial("tcp", "10.96.0.10:80")
+	require.NoError(t, err)
+	defer conn.Close()
+	assertGreeting(t, conn)
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	assert.Equal(t, []string{"echo"}, ft.lookups)
+	require.Len(t, ft.forwarded, 2)
+	for _, id := range ft.forwarded {
+		assert.Equal(t, "10.96.0.10", id.Destination().String())
+		assert.Equal(t, uint16(80), id.DestinationPort())
+		assert.Equal(t, "127.0.0.1", id.Source().String())
+	}
+}
+
+func TestSOCKSProxy_unresolved(t *testing.T) {
+	p := startSOCKSProxy(t, &fakeSOCKSTunnel{})
+	dialer, err := proxy.SOCKS5("tcp", p.address(), nil, proxy.Direct)
+	require.NoError(t, err)
+
+	_, err = dialer.Dial("tcp", "missing:80")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "host unreachable")
+
+	_, err = dialer.Dial("tcp", "broken:80")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "general SOCKS server failure")
+}
+
+func TestSOCKSProxy_rejects(t *testing.T) {
+	ft := &fakeSOCKSTunnel{}
+	p := startSOCKSProxy(t, ft)
+
+	// exchange writes the given messages, and returns the reply of each one
+	exchange := func(t *testing.T, msgs ...[]byte) [][]byte {
+		conn, err := net.Dial("tcp", p.address())
+		require.NoError(t, err)
+		defer conn.Close()
+		require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+		var replies [][]byte
+		for i, msg := range msgs {
+			_, err = conn.Write(msg)
+			require.NoError(t, err)
+			reply := make([]byte, 2)
+			if i > 0 {
+				reply = make([]byte, 10)
+			}
+			_, err = io.ReadFull(conn, reply)
+			require.NoError(t, err)
+			replies = append(replies, reply)
+		}
+		return replies
+	}
+
+	t.Run("udp associate", func(t *testing.T) {
+		replies := exchange(t, []byte{5, 1, socksAuthNone}, []byte{5, 3, 0, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+		assert.Equal(t, []byte{5, socksAuthNone}, replies[0])
+		assert.Equal(t, byte(socksReplyCmdNotSupported), replies[1][1])
+	})
+
+	t.Run("bind", func(t *testing.T) {
+		replies := exchange(t, []byte{5, 1, socksAuthNone}, []byte{5, 2, 0, socksAddrIPv4, 10, 96, 0, 10, 0, 80})
+		assert.Equal(t, byte(socksReplyCmdNotSupported), replies[1][1])
+	})
+
+	t.Run("authentication required", func(t *testing.T) {
+		replies := exchange(t, []byte{5, 1, 2})
+		assert.Equal(t, []byte{5, socksAuthNoAcceptable}, replies[0])
+	})
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	assert.Empty(t, ft.forwarded)
+}
@@ -4,15 +4,20 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/url"
 	"os"
 	"os/user"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/durationpb"
 	empty "google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/datawire/ambassador/v2/pkg/kates"
 	"github.com/datawire/dlib/dcontext"
@@ -25,15 +30,30 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_grpc"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_k8s"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 	"github.com/telepresenceio/telepresence/v2/pkg/dnet"
+	"github.com/telepresenceio/telepresence/v2/pkg/forwarder"
 	"github.com/telepresenceio/telepresence/v2/pkg/install"
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 )
 
 type Callbacks struct {
 	GetCloudAPIKey        func(context.Context, string, bool) (string, error)
 	RegisterManagerServer func(server manager.ManagerServer)
 	SetOutboundInfo       func(ctx context.Context, in *daemon.OutboundInfo, opts ...grpc.CallOption) (*empty.Empty, error)
+	DaemonStatus          func(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*daemon.DaemonStatus, error)
+	Disconnect            func()
+
+	// QuitRootDaemon tells the root daemon to quit, the same way as "telepresence quit" does, unless other
+	// sessions use it. It is nil when the session doesn't use the root daemon.
+	QuitRootDaemon func(context.Context) error
+
+	// Notify passes a message on to the user of the CLI. It may be nil
+	Notify func(string)
+
+	// Progress reports that the given step of the initial connect has started. It may be nil
+	Progress func(step string)
 }
 
 // trafficManager is a handle to access the Traffic Manager in a
@@ -60,13 +80,51 @@ type trafficManager struct {
 
 	sessionInfo *manager.SessionInfo // sessionInfo returned by the traffic-manager
 
+	// agentImage is the image used when agents are installed, agentImageSource tells where it
+	// comes from, and agentPullSecrets are the secrets used to pull it. All are set before .startup
+	// is closed.
+	agentImage       string
+	agentImageSource client.AgentImageSource
+	agentPullSecrets []string
+
+	// agentConfig is the default configuration of the agents that are installed, as configured in the
+	// traffic-manager. It's nil when the traffic-manager doesn't tell. It's set before .startup is closed.
+	agentConfig *install.AgentConfig
+
+	// reconnectingSince is the time when the session was found to be broken, or zero when the
+	// session is healthy.
+	reconnectingSince time.Time
+
+	// unknownSession receives the ID of a session that the traffic-manager doesn't know, as found
+	// by a watcher or a tunnel. See sessionUnknown.
+	unknownSession chan string
+
+	// clusterDomain is the cluster domain that the traffic-manager reports, e.g. "cluster.local".
+	clusterDomain string
+
+	// sessionLock protects sessionInfo and reconnectingSince, which change when a broken session
+	// is replaced, and clusterDomain.
+	sessionLock sync.RWMutex
+
 	// Map of desired mount points for intercepts
 	mountPoints sync.Map
 
+	// Map of *mountOptions keyed by intercept name
+	mountOptions sync.Map
+
 	// Map of mutexes, so that we don't create and delete
 	// mount points concurrently
 	mountMutexes sync.Map
 
+	// Map of *localTLS keyed by intercept name
+	localTLS sync.Map
+
+	// Map of *int32 keyed by intercept name, counting the live port forwards and mounts to the intercepted pod
+	liveForwards sync.Map
+
+	// Set of active *podForward created by the port-forward command
+	portForwards sync.Map
+
 	// currentIntercepts is the latest snapshot returned by the intercept watcher
 	currentIntercepts     []*manager.InterceptInfo
 	currentInterceptsLock sync.Mutex
@@ -80,6 +138,19 @@ type trafficManager struct {
 
 	// agentWaiters contains chan *manager.AgentInfo keyed by agent <name>.<namespace>
 	agentWaiters sync.Map
+
+	// interceptOwners contains the owners of the intercepts that are bound to a handler, keyed by
+	// intercept name
+	interceptOwners     map[string]*interceptOwner
+	interceptOwnersLock sync.Mutex
+
+	// portProxy proxies the services when the proxy mode is "ports", in which case there's no root
+	// daemon. It's nil in the other modes.
+	portProxy *PortProxy
+
+	// socksProxy relays the connections of SOCKS5 clients when the proxy mode is "socks5", in which case
+	// there's no root daemon either. It's nil in the other modes.
+	socksProxy *SOCKSProxy
 }
 
 // interceptResult is what gets written to the activeInterceptsWaiters channels
@@ -88,11 +159,16 @@ type interceptResult struct {
 	err       error
 }
 
-// New returns a TrafficManager resource for the given cluster if it has a Traffic Manager service.
+// New returns a TrafficManager resource for the given cluster if it has a Traffic Manager service. The
+// given portProxy is nil unless the proxy mode is "ports", and the given socksProxy is nil unless the proxy
+// mode is "socks5". The SetOutboundInfo and DaemonStatus callbacks are not used in those modes.
 func New(
 	_ context.Context,
 	cluster *userd_k8s.Cluster,
 	installID string,
+	managerValues map[string]interface{},
+	portProxy *PortProxy,
+	socksProxy *SOCKSProxy,
 	callbacks Callbacks,
 ) (*trafficManager, error) {
 	userinfo, err := user.Current()
@@ -105,25 +181,42 @@ func New(
 	}
 
 	// Ensure that we have a traffic-manager to talk to.
-	ti, err := newTrafficManagerInstaller(cluster)
+	ti, err := newTrafficManagerInstaller(cluster, managerValues)
 	if err != nil {
 		return nil, errors.Wrap(err, "new installer")
 	}
+	ti.notify = callbacks.Notify
 	tm := &trafficManager{
-		installer:   ti,
-		installID:   installID,
-		startup:     make(chan struct{}),
-		userAndHost: fmt.Sprintf("%s@%s", userinfo.Username, host),
-		callbacks:   callbacks,
+		installer:      ti,
+		installID:      installID,
+		startup:        make(chan struct{}),
+		unknownSession: make(chan string, 1),
+		userAndHost:    fmt.Sprintf("%s@%s", userinfo.Username, host),
+		callbacks:      callbacks,
+		portProxy:      portProxy,
+		socksProxy:     socksProxy,
+	}
+	if portProxy != nil {
+		portProxy.forward = tm.forwardToCluster
+	}
+	if socksProxy != nil {
+		socksProxy.forward = tm.forwardToCluster
+		socksProxy.lookup = tm.lookupClusterHost
 	}
 
 	return tm, nil
 }
 
+// usesRootDaemon returns true if the root daemon routes the cluster subnets of the session, i.e. if its
+// proxy mode is "tun".
+func (tm *trafficManager) usesRootDaemon() bool {
+	return tm.portProxy == nil && tm.socksProxy == nil
+}
+
 func (tm *trafficManager) Run(c context.Context) error {
 	err := tm.ensureManager(c)
 	if err != nil {
-		tm.managerErr = fmt.Errorf("failed to start traffic manager: %w", err)
+		tm.managerErr = fmt.Errorf("failed to start traffic manager in namespace %s: %w", tm.GetManagerNamespace(), err)
 		close(tm.startup)
 		return err
 	}
@@ -158,67 +251,204 @@ func (tm *trafficManager) Run(c context.Context) error {
 		}
 	}()
 
-	opts := []grpc.DialOption{grpc.WithContextDialer(grpcDialer),
+	opts := append([]grpc.DialOption{grpc.WithContextDialer(grpcDialer),
 		grpc.WithInsecure(),
 		grpc.WithNoProxy(),
 		grpc.WithBlock(),
-		grpc.WithReturnConnectionError()}
+		grpc.WithReturnConnectionError()}, append(tracing.DialOptions(), client.GrpcDialOptions(c)...)...)
 
 	conn, err = grpc.DialContext(tc, grpcAddr, opts...)
 	if err != nil {
-		return client.CheckTimeout(tc, fmt.Errorf("dial manager: %w", err))
+		return client.CheckTimeout(tc, fmt.Errorf("dial manager in namespace %s: %w", tm.GetManagerNamespace(), err))
 	}
 
 	mClient := manager.NewManagerClient(conn)
-	si, err := mClient.ArriveAsClient(tc, &manager.ClientInfo{
-		Name:      tm.userAndHost,
-		InstallId: tm.installID,
-		Product:   "telepresence",
-		Version:   client.Version(),
-		ApiKey: func() string {
-			// Discard any errors; including an apikey with this request is optional.
-			// We might not even be logged in.
-			tok, _ := tm.callbacks.GetCloudAPIKey(c, a8rcloud.KeyDescTrafficManager, false)
-			return tok
-		}(),
-	})
+	si, err := tm.arrive(tc, mClient)
 	if err != nil {
-		return client.CheckTimeout(tc, fmt.Errorf("manager.ArriveAsClient: %w", err))
+		return client.CheckTimeout(tc, err)
 	}
 	tm.managerClient = mClient
-	tm.sessionInfo = si
+	tm.setSession(si)
+	tm.resolveAgentImage(c, mClient)
+	tm.resolveAgentConfig(c, mClient)
+	tm.resolveManagedNamespaces(c, mClient)
 
 	// Gotta call RegisterManagerServer before we call daemon.SetOutboundInfo which tells the
 	// daemon to use the proxy.
 	tm.callbacks.RegisterManagerServer(userd_grpc.NewManagerProxy(tm.managerClient))
 
 	// Tell daemon what it needs to know in order to establish outbound traffic to the cluster
-	if _, err := tm.callbacks.SetOutboundInfo(c, tm.getOutboundInfo()); err != nil {
-		tm.managerClient = nil
-		return fmt.Errorf("daemon.SetOutboundInfo: %w", err)
+	if tm.callbacks.Progress != nil {
+		tm.callbacks.Progress(client.ConnectStepTunnel)
+	}
+	if tm.usesRootDaemon() {
+		if _, err := tm.callbacks.SetOutboundInfo(c, tm.getOutboundInfo(c)); err != nil {
+			tm.managerClient = nil
+			return fmt.Errorf("daemon.SetOutboundInfo: %w", err)
+		}
 	}
 
 	close(tm.startup)
 
 	g := dgroup.NewGroup(c, dgroup.GroupConfig{})
+	if tm.portProxy != nil {
+		g.Go("port-proxy", tm.portProxy.run)
+	}
+	if tm.socksProxy != nil {
+		g.Go("socks-proxy", tm.socksProxy.run)
+	}
 	g.Go("remain", tm.remain)
 	g.Go("refresh-cloud-APIKey", tm.refreshCloudAPIKey)
 	g.Go("intercept-port-forward", tm.workerPortForwardIntercepts)
 	g.Go("agent-watcher", tm.agentInfoWatcher)
 	g.Go("dial-request-watcher", tm.dialRequestWatcher)
+	g.Go("idle-watcher", tm.idleWatcher)
+	g.Go("orphan-watcher", tm.orphanWatcher)
 	return g.Wait()
 }
 
+// arrive creates a new session with the traffic-manager.
+func (tm *trafficManager) arrive(c context.Context, mClient manager.ManagerClient) (*manager.SessionInfo, error) {
+	si, err := mClient.ArriveAsClient(c, &manager.ClientInfo{
+		Name:      tm.userAndHost,
+		InstallId: tm.installID,
+		Product:   "telepresence",
+		Version:   client.Version(),
+		ApiKey: func() string {
+			// Discard any errors; including an apikey with this request is optional.
+			// We might not even be logged in.
+			tok, _ := tm.callbacks.GetCloudAPIKey(c, a8rcloud.KeyDescTrafficManager, false)
+			return tok
+		}(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("manager.ArriveAsClient: %w", err)
+	}
+	return si, nil
+}
+
+// resolveAgentImage determines the image that is used when agents are installed, and the secrets used to
+// pull it. A traffic-manager that is unable to tell what image it uses for the agents that it injects is
+// treated as one that uses the default image without pull secrets. The pull secrets of the client config
+// take precedence over those of the traffic-manager.
+func (tm *trafficManager) resolveAgentImage(c context.Context, mClient manager.ManagerClient) {
+	ai, err := mClient.GetAgentImage(c, &empty.Empty{})
+	if err != nil {
+		dlog.Debugf(c, "unable to get the agent image of the traffic-manager: %v", err)
+		ai = &manager.AgentImage{}
+	}
+	tm.agentImage, tm.agentImageSource = client.ResolveAgentImage(c, ai.Name)
+	dlog.Infof(c, "Using agent image %s from %s", tm.agentImage, tm.agentImageSource)
+
+	tm.agentPullSecrets = client.GetConfig(c).Images.AgentImagePullSecrets
+	if len(tm.agentPullSecrets) == 0 {
+		tm.agentPullSecrets = ai.PullSecrets
+	}
+}
+
+// resolveAgentConfig determines the default configuration of the agents that are installed, so that they
+// are configured like the agents that the traffic-manager injects. A traffic-manager that is unable to tell
+// what configuration it uses is treated as one that uses the default configuration.
+func (tm *trafficManager) resolveAgentConfig(c context.Context, mClient manager.ManagerClient) {
+	tm.agentConfig = nil
+	ac, err := mClient.GetAgentConfig(c, &empty.Empty{})
+	if err != nil {
+		dlog.Debugf(c, "unable to get the agent config of the traffic-manager: %v", err)
+		return
+	}
+	if tm.agentConfig, err = agentConfigFromRPC(ac); err != nil {
+		dlog.Errorf(c, "ignoring the agent config of the traffic-manager: %v", err)
+	}
+}
+
+// agentConfigFromRPC returns the install.AgentConfig of the given traffic-manager config.
+func agentConfigFromRPC(ac *manager.AgentConfig) (*install.AgentConfig, error) {
+	cfg := &install.AgentConfig{}
+	if ac.Resources != "" {
+		rr, err := install.ParseAgentResources(ac.Resources)
+		if err != nil {
+			return nil, fmt.Errorf("resources: %w", err)
+		}
+		cfg.Resources = rr
+	}
+	if ac.SecurityContext != "" {
+		sc, err := install.ParseAgentSecurityContext(ac.SecurityContext)
+		if err != nil {
+			return nil, fmt.Errorf("securityContext: %w", err)
+		}
+		cfg.SecurityContext = *sc
+	}
+	if ac.AppProtocol != "" {
+		if err := install.ValidateAppProtocol(ac.AppProtocol); err != nil {
+			return nil, fmt.Errorf("appProtocol: %w", err)
+		}
+		cfg.AppProtocol = ac.AppProtocol
+	}
+	if len(ac.IgnorePorts) > 0 {
+		cfg.IgnorePorts = append([]int32(nil), ac.IgnorePorts...)
+	}
+	return cfg, nil
+}
+
+// resolveManagedNamespaces limits the mapped namespaces to the namespaces that the traffic-manager manages.
+// They're found in the first ClusterInfo that the traffic-manager sends. A traffic-manager that predates
+// namespace-scoped RBAC never sends any, which means that it manages all namespaces. The cluster domain of
+// the ClusterInfo is kept, and passed on to the port proxy, if any.
+func (tm *trafficManager) resolveManagedNamespaces(c context.Context, mClient manager.ManagerClient) {
+	wc, cancel := context.WithCancel(c)
+	defer cancel()
+	var nss []string
+	stream, err := mClient.WatchClusterInfo(wc, tm.session())
+	if err == nil {
+		var ci *manager.ClusterInfo
+		if ci, err = stream.Recv(); err == nil {
+			nss = ci.ManagedNamespaces
+			tm.sessionLock.Lock()
+			tm.clusterDomain = strings.TrimSuffix(ci.ClusterDomain, ".")
+			tm.sessionLock.Unlock()
+			if tm.portProxy != nil {
+				tm.portProxy.setClusterDomain(ci.ClusterDomain)
+			}
+		}
+	}
+	if err != nil {
+		dlog.Errorf(c, "unable to get the managed namespaces of the traffic-manager: %v", err)
+		return
+	}
+	if len(nss) > 0 {
+		dlog.Infof(c, "The traffic-manager manages namespaces %s", strings.Join(nss, ", "))
+	}
+	tm.SetManagedNamespaces(c, nss)
+}
+
+// agentImageFor returns the image to use for an agent when the client asks for the given image. Unless
+// overridden by the client config or by the intercept mechanism, the client asks for the default image,
+// and the image that the traffic-manager uses takes precedence over that.
+func (tm *trafficManager) agentImageFor(requested string) string {
+	if requested == "" || requested == client.DefaultAgentImage() && tm.agentImageSource == client.AgentImageFromTrafficManager {
+		return tm.agentImage
+	}
+	return requested
+}
+
 func (tm *trafficManager) session() *manager.SessionInfo {
+	tm.sessionLock.RLock()
+	defer tm.sessionLock.RUnlock()
 	return tm.sessionInfo
 }
 
+func (tm *trafficManager) setSession(si *manager.SessionInfo) {
+	tm.sessionLock.Lock()
+	tm.sessionInfo = si
+	tm.sessionLock.Unlock()
+}
+
 // hasOwner parses an object and determines whether the object has an
-// owner that is of a kind we prefer. Currently the only owner that we
-// prefer is a Deployment, but this may grow in the future
+// owner that is of a kind we prefer. Currently the owners that we
+// prefer are Deployments and Argo Rollouts, but this may grow in the future
 func (tm *trafficManager) hasOwner(obj kates.Object) bool {
 	for _, owner := range obj.GetOwnerReferences() {
-		if owner.Kind == "Deployment" {
+		if owner.Kind == "Deployment" || owner.Kind == userd_k8s.RolloutKind {
 			return true
 		}
 	}
@@ -227,7 +457,7 @@ func (tm *trafficManager) hasOwner(obj kates.Object) bool {
 
 // getReasonAndLabels gets the workload's associated labels, as well as a reason
 // it cannot be intercepted if that is the case.
-func (tm *trafficManager) getReasonAndLabels(workload kates.Object, namespace, name string) (map[string]string, string, error) {
+func (tm *trafficManager) getReasonAndLabels(ctx context.Context, workload kates.Object, namespace, name string) (map[string]string, string, error) {
 	var labels map[string]string
 	var reason string
 	switch workload := workload.(type) {
@@ -248,6 +478,21 @@ func (tm *trafficManager) getReasonAndLabels(workload kates.Object, namespace, n
 			reason = "Has 0 replicas"
 		}
 		labels = workload.Spec.Template.Labels
+
+	case *kates.Unstructured:
+		if !userd_k8s.IsRollout(workload) {
+			reason = "No workload telepresence knows how to intercept"
+			break
+		}
+		if userd_k8s.RolloutReplicas(workload) == 0 {
+			reason = "Has 0 replicas"
+		}
+		podTemplate, err := tm.RolloutPodTemplate(ctx, workload)
+		if err != nil {
+			reason = err.Error()
+			break
+		}
+		labels = podTemplate.Labels
 	default:
 		reason = "No workload telepresence knows how to intercept"
 	}
@@ -264,6 +509,7 @@ func (tm *trafficManager) getInfosForWorkloads(
 	namespace string,
 	iMap map[string]*manager.InterceptInfo,
 	aMap map[string]*manager.AgentInfo,
+	wiMap map[string][]*rpc.WorkloadInfo_Intercept,
 	filter rpc.ListRequest_Filter,
 ) []*rpc.WorkloadInfo {
 	workloadInfos := make([]*rpc.WorkloadInfo, 0)
@@ -281,7 +527,7 @@ func (tm *trafficManager) getInfosForWorkloads(
 		if agent == nil && iCept == nil {
 			var labels map[string]string
 			var err error
-			if labels, reason, err = tm.getReasonAndLabels(workload, namespace, name); err != nil {
+			if labels, reason, err = tm.getReasonAndLabels(ctx, workload, namespace, name); err != nil {
 				continue
 			}
 			if reason == "" {
@@ -315,6 +561,7 @@ func (tm *trafficManager) getInfosForWorkloads(
 			AgentInfo:              agent,
 			InterceptInfo:          iCept,
 			WorkloadResourceType:   workload.GetObjectKind().GroupVersionKind().Kind,
+			Intercepts:             wiMap[name],
 		})
 	}
 	return workloadInfos
@@ -338,6 +585,7 @@ func (tm *trafficManager) WorkloadInfoSnapshot(ctx context.Context, rq *rpc.List
 		}
 	}
 	aMap := tm.getCurrentAgentsInNamespace(namespace)
+	wiMap := workloadIntercepts(tm.getAllIntercepts(ctx), namespace)
 	filter := rq.Filter
 	workloadInfos := make([]*rpc.WorkloadInfo, 0)
 
@@ -347,6 +595,7 @@ func (tm *trafficManager) WorkloadInfoSnapshot(ctx context.Context, rq *rpc.List
 		"Deployment":  tm.Deployments,
 		"ReplicaSet":  tm.ReplicaSets,
 		"StatefulSet": tm.StatefulSets,
+		"Rollout":     tm.Rollouts,
 	}
 
 	for workloadKind, getFunc := range workloadsToGet {
@@ -356,7 +605,7 @@ func (tm *trafficManager) WorkloadInfoSnapshot(ctx context.Context, rq *rpc.List
 			dlog.Infof(ctx, "Skipping getting info for workloads: %s", workloadKind)
 			continue
 		}
-		newWorkloadInfos := tm.getInfosForWorkloads(ctx, workloads, namespace, iMap, aMap, filter)
+		newWorkloadInfos := tm.getInfosForWorkloads(ctx, workloads, namespace, iMap, aMap, wiMap, filter)
 		workloadInfos = append(workloadInfos, newWorkloadInfos...)
 	}
 
@@ -373,10 +622,83 @@ func (tm *trafficManager) WorkloadInfoSnapshot(ctx context.Context, rq *rpc.List
 	return &rpc.WorkloadInfoSnapshot{Workloads: workloadInfos}
 }
 
+const (
+	mechanismAllTraffic    = "all-traffic"
+	mechanismHeaderMatched = "header-matched"
+)
+
+// allInterceptsTimeout limits the time spent asking the traffic-manager for the intercepts of all clients.
+var allInterceptsTimeout = 3 * time.Second
+
+// getAllIntercepts returns the intercepts of all clients. Only the intercepts of this client are returned
+// when the traffic-manager can't be asked.
+func (tm *trafficManager) getAllIntercepts(ctx context.Context) []*manager.InterceptInfo {
+	ctx, cancel := context.WithTimeout(ctx, allInterceptsTimeout)
+	defer cancel()
+
+	// A watch without a session reports the intercepts of all clients.
+	stream, err := tm.managerClient.WatchIntercepts(ctx, &manager.SessionInfo{})
+	if err == nil {
+		var snapshot *manager.InterceptInfoSnapshot
+		if snapshot, err = stream.Recv(); err == nil {
+			return snapshot.Intercepts
+		}
+	}
+	dlog.Debugf(ctx, "unable to get the intercepts of all clients: %v", err)
+	return tm.getCurrentIntercepts()
+}
+
+// workloadIntercepts returns the intercepts that hold a workload in the given namespace, keyed by
+// the name of the workload.
+func workloadIntercepts(iis []*manager.InterceptInfo, namespace string) map[string][]*rpc.WorkloadInfo_Intercept {
+	wiMap := make(map[string][]*rpc.WorkloadInfo_Intercept)
+	for _, ii := range iis {
+		spec := ii.Spec
+		if spec.Namespace != namespace {
+			continue
+		}
+		switch ii.Disposition {
+		case manager.InterceptDispositionType_WAITING, manager.InterceptDispositionType_NO_AGENT, manager.InterceptDispositionType_ACTIVE:
+			wiMap[spec.Agent] = append(wiMap[spec.Agent], &rpc.WorkloadInfo_Intercept{
+				Name:      spec.Name,
+				Client:    spec.Client,
+				Mechanism: interceptMechanism(spec),
+			})
+		}
+	}
+	return wiMap
+}
+
+// interceptMechanism tells whether the given intercept intercepts all traffic, or only the HTTP
+// requests that match certain conditions.
+func interceptMechanism(spec *manager.InterceptSpec) string {
+	if forwarder.HasHTTPConditions(spec) {
+		return mechanismHeaderMatched
+	}
+	if spec.Mechanism != "tcp" {
+		// The http mechanism of the extensions matches headers unless told otherwise
+		for _, arg := range spec.MechanismArgs {
+			if arg == "--http-match=all" {
+				return mechanismAllTraffic
+			}
+		}
+		return mechanismHeaderMatched
+	}
+	return mechanismAllTraffic
+}
+
+// remainInterval is the interval between the Remain calls that keep the session alive.
+var remainInterval = 5 * time.Second
+
+// maxRemainFailures is the number of consecutive Remain failures after which the session is
+// considered broken.
+const maxRemainFailures = 3
+
 func (tm *trafficManager) remain(c context.Context) error {
 	<-tm.startup
-	ticker := time.NewTicker(5 * time.Second)
+	ticker := time.NewTicker(remainInterval)
 	defer ticker.Stop()
+	failures := 0
 	for {
 		select {
 		case <-c.Done():
@@ -391,8 +713,24 @@ func (tm *trafficManager) remain(c context.Context) error {
 				Session: tm.session(),
 				ApiKey:  apiKey,
 			})
-			if err != nil && c.Err() == nil {
-				dlog.Error(c, err)
+			if err == nil || c.Err() != nil {
+				failures = 0
+				continue
+			}
+			dlog.Error(c, err)
+
+			// A session that the traffic-manager no longer knows about, or that can't be kept alive, is
+			// broken. This typically happens when the laptop wakes up from sleep or changes network.
+			failures++
+			if grpcStatus.Code(err) == grpcCodes.NotFound || failures >= maxRemainFailures {
+				tm.reconnect(c)
+				failures = 0
+			}
+		case id := <-tm.unknownSession:
+			// A watch or a tunnel reached a traffic-manager, or a replica of it, that doesn't know the session
+			if id == tm.session().SessionId {
+				tm.reconnect(c)
+				failures = 0
 			}
 		}
 	}
@@ -424,6 +762,17 @@ func (tm *trafficManager) SetStatus(ctx context.Context, r *rpc.ConnectInfo) {
 	if tm == nil {
 		return
 	}
+	switch {
+	case tm.portProxy != nil:
+		r.ProxyMode = client.ProxyModePorts
+		r.ProxyPorts = tm.portProxy.info()
+	case tm.socksProxy != nil:
+		r.ProxyMode = client.ProxyModeSOCKS5
+		r.Socks5Address = tm.socksProxy.address()
+	default:
+		r.ProxyMode = client.ProxyModeTUN
+		r.RoutingStrategy = routingStrategy(tm.LocalCluster())
+	}
 	<-tm.startup
 	if tm.managerClient == nil {
 		r.BridgeOk = false
@@ -436,7 +785,16 @@ func (tm *trafficManager) SetStatus(ctx context.Context, r *rpc.ConnectInfo) {
 		r.Agents = &manager.AgentInfoSnapshot{Agents: tm.getCurrentAgents()}
 		r.Intercepts = &manager.InterceptInfoSnapshot{Intercepts: tm.getCurrentIntercepts()}
 		r.SessionInfo = tm.session()
+		r.ForwardCounts = tm.forwardCounts()
+		r.AgentImage = tm.agentImage
+		r.AgentImageSource = string(tm.agentImageSource)
+		r.ManagedNamespaces = tm.GetManagedNamespaces()
+		r.PortForwards = tm.getPortForwards()
+		r.InterceptOwners = tm.getInterceptOwners()
 		r.BridgeOk = true
+		if since := tm.getReconnectingSince(); !since.IsZero() {
+			r.ReconnectingSince = timestamppb.New(since)
+		}
 	}
 }
 
@@ -469,15 +827,15 @@ func (tm *trafficManager) Uninstall(c context.Context, ur *rpc.UninstallRequest)
 	// workload n times for n replicas, which could cause race conditions
 	agents = getRepresentativeAgents(c, agents)
 
-	_ = tm.clearIntercepts(c)
 	switch ur.UninstallType {
 	case rpc.UninstallRequest_UNSPECIFIED:
 		return nil, errors.New("invalid uninstall request")
 	case rpc.UninstallRequest_NAMED_AGENTS:
+		_ = tm.clearIntercepts(c)
 		var selectedAgents []*manager.AgentInfo
+		namespace := tm.ActualNamespace(ur.Namespace)
 		for _, di := range ur.Agents {
 			found := false
-			namespace := tm.ActualNamespace(ur.Namespace)
 			if namespace != "" {
 				for _, ai := range agents {
 					if namespace == ai.Namespace && di == ai.Name {
@@ -488,37 +846,92 @@ func (tm *trafficManager) Uninstall(c context.Context, ur *rpc.UninstallRequest)
 				}
 			}
 			if !found {
-				result.ErrorText = fmt.Sprintf("unable to find a workload named %s.%s with an agent installed", di, namespace)
+				result.Removals = append(result.Removals, newRemoval(removalKindAgent, di, namespace,
+					errcat.User.Newf("unable to find a workload named %s.%s with an agent installed", di, namespace)))
 			}
 		}
-		agents = selectedAgents
-		fallthrough
+		result.Removals = append(result.Removals, tm.removeManagerAndAgents(c, true, selectedAgents)...)
 	case rpc.UninstallRequest_ALL_AGENTS:
-		if len(agents) > 0 {
-			if err := tm.removeManagerAndAgents(c, true, agents); err != nil {
-				result.ErrorText = err.Error()
+		_ = tm.clearIntercepts(c)
+		result.Removals = tm.removeManagerAndAgents(c, true, tm.agentsInScope(ur.Namespace, agents))
+	default:
+		if !ur.Force {
+			others, err := tm.otherClients(c)
+			switch {
+			case err != nil:
+				result.ErrorText = fmt.Sprintf("unable to determine if other clients are connected to the traffic-manager: %v. "+
+					"Use --force to uninstall anyway", err)
+				result.ErrorCategory = int32(errcat.GetCategory(err))
+				return result, nil
+			case len(others) > 0:
+				result.ErrorText = fmt.Sprintf("the traffic-manager is used by other clients: %s. Use --force to uninstall anyway",
+					strings.Join(others, ", "))
+				result.ErrorCategory = int32(errcat.User)
+				return result, nil
 			}
 		}
-	default:
+		_ = tm.clearIntercepts(c)
 		// Cancel all communication with the manager
-		if err := tm.removeManagerAndAgents(c, false, agents); err != nil {
-			result.ErrorText = err.Error()
-		}
+		result.Removals = tm.removeManagerAndAgents(c, false, agents)
 	}
 	return result, nil
 }
 
+// agentsInScope returns the given agents that are in the given namespace or, when no namespace is given, in
+// the mapped namespaces.
+func (tm *trafficManager) agentsInScope(namespace string, agents []*manager.AgentInfo) []*manager.AgentInfo {
+	var namespaces []string
+	if namespace != "" {
+		namespaces = []string{namespace}
+	} else {
+		namespaces = tm.GetMappedNamespaces()
+	}
+	if len(namespaces) == 0 {
+		return agents
+	}
+	var inScope []*manager.AgentInfo
+	for _, ai := range agents {
+		for _, ns := range namespaces {
+			if ai.Namespace == ns {
+				inScope = append(inScope, ai)
+				break
+			}
+		}
+	}
+	return inScope
+}
+
 // getClusterCIDRs finds the service CIDR and the pod CIDRs of all nodes in the cluster
-func (tm *trafficManager) getOutboundInfo() *daemon.OutboundInfo {
+func (tm *trafficManager) getOutboundInfo(ctx context.Context) *daemon.OutboundInfo {
 	info := &daemon.OutboundInfo{
-		Session: tm.sessionInfo,
+		Session: tm.session(),
+		Dns:     &daemon.DNSConfig{},
+		Mtu:     int32(tm.MTU),
+	}
+
+	// A lookup timeout in the kubeconfig extension takes precedence over the one in the client config
+	if cfg := client.GetConfig(ctx); cfg != nil {
+		dns := &cfg.DNS
+		if dns.LookupTimeout > 0 {
+			info.Dns.LookupTimeout = durationpb.New(dns.LookupTimeout)
+		}
+		if dns.NegativeCacheTTL > 0 {
+			info.Dns.NegativeCacheTtl = durationpb.New(dns.NegativeCacheTTL)
+		}
+		if dns.MaxTTL > 0 {
+			info.Dns.MaxTtl = durationpb.New(dns.MaxTTL)
+		}
+		for _, ac := range cfg.AllowConflictingSubnets {
+			info.AllowConflictingSubnets = append(info.AllowConflictingSubnets, iputil.IPNetToRPC((*net.IPNet)(ac)))
+		}
+		info.OutboundTrafficPolicy = cfg.OutboundTrafficPolicy
 	}
 
 	if tm.DNS != nil {
-		info.Dns = &daemon.DNSConfig{
-			ExcludeSuffixes: tm.DNS.ExcludeSuffixes,
-			IncludeSuffixes: tm.DNS.IncludeSuffixes,
-			LookupTimeout:   durationpb.New(tm.DNS.LookupTimeout.Duration),
+		info.Dns.ExcludeSuffixes = tm.DNS.ExcludeSuffixes
+		info.Dns.IncludeSuffixes = tm.DNS.IncludeSuffixes
+		if tm.DNS.LookupTimeout.Duration > 0 {
+			info.Dns.LookupTimeout = durationpb.New(tm.DNS.LookupTimeout.Duration)
 		}
 		if len(tm.DNS.LocalIP) > 0 {
 			info.Dns.LocalIp = tm.DNS.LocalIP.IP()
@@ -534,9 +947,59 @@ func (tm *trafficManager) getOutboundInfo() *daemon.OutboundInfo {
 			info.AlsoProxySubnets[i] = iputil.IPNetToRPC((*net.IPNet)(ap))
 		}
 	}
+
+	for _, np := range tm.NeverProxy {
+		info.NeverProxySubnets = append(info.NeverProxySubnets, iputil.IPNetToRPC((*net.IPNet)(np)))
+	}
+	// Routing the API server's address to the cluster would cut off the connection to the cluster
+	for _, ip := range apiServerIPs(ctx, tm.Server) {
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		info.NeverProxySubnets = append(info.NeverProxySubnets, iputil.IPNetToRPC(&net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}))
+	}
+	info.DockerNetwork = tm.LocalCluster().DockerNetworkRoutes()
 	return info
 }
 
+// routingStrategy returns the routing strategy of the session, given the outcome of the detection of a
+// local cluster.
+func routingStrategy(lc *userd_k8s.LocalCluster) *rpc.RoutingStrategy {
+	if lc.UsesDockerNetwork() {
+		return &rpc.RoutingStrategy{Strategy: client.RoutingStrategyDockerNetwork, Provider: lc.Provider, Gateway: lc.Gateway}
+	}
+	rs := &rpc.RoutingStrategy{Strategy: client.RoutingStrategyTunnel}
+	if lc != nil {
+		rs.Provider = lc.Provider
+		rs.Reason = lc.Reason
+	}
+	return rs
+}
+
+// apiServerIPs returns the IPs of the host in the given API server URL.
+func apiServerIPs(ctx context.Context, server string) []net.IP {
+	u, err := url.Parse(server)
+	if err != nil {
+		dlog.Errorf(ctx, "unable to parse API server URL %q: %v", server, err)
+		return nil
+	}
+	host := u.Hostname()
+	if ip := iputil.Parse(host); ip != nil {
+		return []net.IP{ip}
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		dlog.Errorf(ctx, "unable to resolve API server host %q: %v", host, err)
+		return nil
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips
+}
+
 func (tm *trafficManager) GetClientBlocking(ctx context.Context) (manager.ManagerClient, error) {
 	select {
 	case <-ctx.Done():
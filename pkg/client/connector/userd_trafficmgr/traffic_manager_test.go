@@ -0,0 +1,272 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_k8s"
+)
+
+type agentImageManager struct {
+	manager.ManagerClient
+	image       string
+	pullSecrets []string
+}
+
+func (m *agentImageManager) GetAgentImage(context.Context, *empty.Empty, ...grpc.CallOption) (*manager.AgentImage, error) {
+	if m.image == "" {
+		return nil, grpcStatus.Error(grpcCodes.Unimplemented, "unknown method GetAgentImage")
+	}
+	return &manager.AgentImage{Name: m.image, PullSecrets: m.pullSecrets}, nil
+}
+
+func TestTrafficManager_agentImage(t *testing.T) {
+	const managerImage = "registry.example.com/datawire/tel2:2.4.5"
+	const extensionImage = "docker.io/datawire/ambassador-telepresence-agent:1.11.0"
+	newContext := func(cfgRegistry string) context.Context {
+		ctx := client.WithEnv(dlog.NewTestContext(t, false), &client.Env{Registry: client.DefaultRegistry})
+		cfg := client.GetDefaultConfig(ctx)
+		if cfgRegistry != "" {
+			cfg.Images.Registry = cfgRegistry
+		}
+		return client.WithConfig(ctx, &cfg)
+	}
+
+	t.Run("traffic-manager", func(t *testing.T) {
+		tm := &trafficManager{}
+		tm.resolveAgentImage(newContext(""), &agentImageManager{image: managerImage})
+		assert.Equal(t, client.AgentImageFromTrafficManager, tm.agentImageSource)
+
+		// The image of the traffic-manager replaces the default, but not the image of an extension
+		assert.Equal(t, managerImage, tm.agentImageFor(client.DefaultAgentImage()))
+		assert.Equal(t, managerImage, tm.agentImageFor(""))
+		assert.Equal(t, extensionImage, tm.agentImageFor(extensionImage))
+	})
+
+	t.Run("config", func(t *testing.T) {
+		tm := &trafficManager{}
+		tm.resolveAgentImage(newContext("localhost:5000"), &agentImageManager{image: managerImage})
+		assert.Equal(t, client.AgentImageFromConfig, tm.agentImageSource)
+		assert.Equal(t, "localhost:5000/tel2:"+client.Semver().String(), tm.agentImage)
+
+		// The client already applied its config to the image that it asks for
+		assert.Equal(t, tm.agentImage, tm.agentImageFor(tm.agentImage))
+	})
+
+	t.Run("older traffic-manager", func(t *testing.T) {
+		tm := &trafficManager{}
+		tm.resolveAgentImage(newContext(""), &agentImageManager{})
+		assert.Equal(t, client.AgentImageDefault, tm.agentImageSource)
+		assert.Equal(t, client.DefaultAgentImage(), tm.agentImageFor(client.DefaultAgentImage()))
+	})
+}
+
+func TestTrafficManager_agentPullSecrets(t *testing.T) {
+	const managerImage = "registry.example.com/datawire/tel2:2.4.5"
+	newContext := func(cfgSecrets ...string) context.Context {
+		ctx := client.WithEnv(dlog.NewTestContext(t, false), &client.Env{Registry: client.DefaultRegistry})
+		cfg := client.GetDefaultConfig(ctx)
+		cfg.Images.AgentImagePullSecrets = cfgSecrets
+		return client.WithConfig(ctx, &cfg)
+	}
+	mgr := &agentImageManager{image: managerImage, pullSecrets: []string{"manager-creds"}}
+
+	tm := &trafficManager{}
+	tm.resolveAgentImage(newContext(), mgr)
+	assert.Equal(t, []string{"manager-creds"}, tm.agentPullSecrets)
+
+	tm = &trafficManager{}
+	tm.resolveAgentImage(newContext("client-creds"), mgr)
+	assert.Equal(t, []string{"client-creds"}, tm.agentPullSecrets)
+
+	tm = &trafficManager{}
+	tm.resolveAgentImage(newContext(), &agentImageManager{})
+	assert.Empty(t, tm.agentPullSecrets)
+}
+
+type agentConfigManager struct {
+	manager.ManagerClient
+	config *manager.AgentConfig
+}
+
+func (m *agentConfigManager) GetAgentConfig(context.Context, *empty.Empty, ...grpc.CallOption) (*manager.AgentConfig, error) {
+	if m.config == nil {
+		return nil, grpcStatus.Error(grpcCodes.Unimplemented, "unknown method GetAgentConfig")
+	}
+	return m.config, nil
+}
+
+func TestTrafficManager_agentConfig(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	tm := &trafficManager{}
+	tm.resolveAgentConfig(ctx, &agentConfigManager{config: &manager.AgentConfig{
+		Resources:       `{"limits":{"memory":"128Mi"}}`,
+		SecurityContext: `{"readOnlyRootFilesystem":true}`,
+		AppProtocol:     "kubernetes.io/h2c",
+		IgnorePorts:     []int32{9090, 15020},
+	}})
+	require.NotNil(t, tm.agentConfig)
+	assert.Equal(t, "128Mi", tm.agentConfig.Resources.Limits.Memory().String())
+	assert.True(t, tm.agentConfig.ReadOnlyRootFilesystem())
+	assert.Equal(t, "kubernetes.io/h2c", tm.agentConfig.AppProtocol)
+	assert.True(t, tm.agentConfig.IgnoresPort(15020))
+
+	// An older traffic-manager, or an invalid config, leaves the defaults in place
+	tm.resolveAgentConfig(ctx, &agentConfigManager{})
+	assert.Nil(t, tm.agentConfig)
+	tm.resolveAgentConfig(ctx, &agentConfigManager{config: &manager.AgentConfig{AppProtocol: "not valid"}})
+	assert.Nil(t, tm.agentConfig)
+}
+
+type clusterInfoManager struct {
+	manager.ManagerClient
+	info *manager.ClusterInfo
+}
+
+type clusterInfoStream struct {
+	grpc.ClientStream
+	info *manager.ClusterInfo
+}
+
+func (s *clusterInfoStream) Recv() (*manager.ClusterInfo, error) {
+	return s.info, nil
+}
+
+func (m *clusterInfoManager) WatchClusterInfo(context.Context, *manager.SessionInfo, ...grpc.CallOption) (manager.Manager_WatchClusterInfoClient, error) {
+	return &clusterInfoStream{info: m.info}, nil
+}
+
+func TestTrafficManager_managedNamespaces(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	newTM := func() *trafficManager {
+		return &trafficManager{installer: &installer{Cluster: &userd_k8s.Cluster{Config: &userd_k8s.Config{Namespace: "default"}}}}
+	}
+
+	tm := newTM()
+	info := &manager.ClusterInfo{ManagedNamespaces: []string{"red", "blue"}}
+	tm.resolveManagedNamespaces(ctx, &clusterInfoManager{info: info})
+	assert.Equal(t, []string{"blue", "red"}, tm.GetManagedNamespaces())
+	assert.Equal(t, []string{"red", "blue"}, info.ManagedNamespaces, "the namespaces of the traffic-manager are not sorted in place")
+
+	// The returned namespaces are a copy
+	nss := tm.GetManagedNamespaces()
+	nss[0] = "green"
+	assert.Equal(t, []string{"blue", "red"}, tm.GetManagedNamespaces())
+	assert.True(t, tm.IsManaged("blue"))
+	assert.False(t, tm.IsManaged("default"))
+
+	r := &rpc.ConnectInfo{}
+	tm.startup = make(chan struct{})
+	close(tm.startup)
+	tm.managerClient = &clusterInfoManager{}
+	tm.SetStatus(ctx, r)
+	assert.Equal(t, []string{"blue", "red"}, r.ManagedNamespaces)
+
+	// A traffic-manager that predates namespace-scoped RBAC manages all namespaces
+	tm = newTM()
+	tm.resolveManagedNamespaces(ctx, &clusterInfoManager{info: &manager.ClusterInfo{}})
+	assert.Empty(t, tm.GetManagedNamespaces())
+	assert.True(t, tm.IsManaged("default"))
+}
+
+type interceptsManager struct {
+	manager.ManagerClient
+	snapshot *manager.InterceptInfoSnapshot
+}
+
+type interceptsStream struct {
+	grpc.ClientStream
+	snapshot *manager.InterceptInfoSnapshot
+}
+
+func (s *interceptsStream) Recv() (*manager.InterceptInfoSnapshot, error) {
+	return s.snapshot, nil
+}
+
+func (m *interceptsManager) WatchIntercepts(_ context.Context, session *manager.SessionInfo, _ ...grpc.CallOption) (manager.Manager_WatchInterceptsClient, error) {
+	if session.SessionId != "" {
+		return nil, grpcStatus.Error(grpcCodes.InvalidArgument, "only a watch of all intercepts is expected")
+	}
+	if m.snapshot == nil {
+		return nil, grpcStatus.Error(grpcCodes.PermissionDenied, "watching all intercepts is not permitted")
+	}
+	return &interceptsStream{snapshot: m.snapshot}, nil
+}
+
+func TestTrafficManager_workloadIntercepts(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	aliceEcho := &manager.InterceptInfo{
+		Spec: &manager.InterceptSpec{
+			Name: "echo-alice", Client: "alice@laptop", Agent: "echo", Namespace: "default", Mechanism: "tcp",
+			HttpHeaders: []*manager.HTTPHeaderMatch{{Name: "x-dev", ValueRegex: "alice"}},
+		},
+		Disposition: manager.InterceptDispositionType_ACTIVE,
+	}
+	bobEcho := &manager.InterceptInfo{
+		Spec:        &manager.InterceptSpec{Name: "echo", Client: "bob@desktop", Agent: "echo", Namespace: "default", Mechanism: "tcp"},
+		Disposition: manager.InterceptDispositionType_WAITING,
+	}
+	bobAPI := &manager.InterceptInfo{
+		Spec:        &manager.InterceptSpec{Name: "api", Client: "bob@desktop", Agent: "api", Namespace: "default", Mechanism: "http", MechanismArgs: []string{"--http-match=auto"}},
+		Disposition: manager.InterceptDispositionType_NO_AGENT, // pending, waiting for the agent to arrive
+	}
+	other := []*manager.InterceptInfo{
+		{
+			Spec:        &manager.InterceptSpec{Name: "web", Client: "carol@host", Agent: "web", Namespace: "blue", Mechanism: "tcp"},
+			Disposition: manager.InterceptDispositionType_ACTIVE,
+		},
+		{
+			Spec:        &manager.InterceptSpec{Name: "db", Client: "carol@host", Agent: "db", Namespace: "default", Mechanism: "tcp"},
+			Disposition: manager.InterceptDispositionType_AGENT_ERROR,
+		},
+	}
+
+	t.Run("all clients", func(t *testing.T) {
+		tm := &trafficManager{managerClient: &interceptsManager{snapshot: &manager.InterceptInfoSnapshot{
+			Intercepts: append([]*manager.InterceptInfo{aliceEcho, bobEcho, bobAPI}, other...),
+		}}}
+		wiMap := workloadIntercepts(tm.getAllIntercepts(ctx), "default")
+		assert.Equal(t, []string{"echo-alice:alice@laptop:header-matched", "echo:bob@desktop:all-traffic"}, describeWorkloadIntercepts(wiMap["echo"]))
+		assert.Equal(t, []string{"api:bob@desktop:header-matched"}, describeWorkloadIntercepts(wiMap["api"]))
+		assert.NotContains(t, wiMap, "web", "intercepts in other namespaces are excluded")
+		assert.NotContains(t, wiMap, "db", "intercepts that don't hold the workload are excluded")
+	})
+
+	t.Run("own intercepts only", func(t *testing.T) {
+		tm := &trafficManager{managerClient: &interceptsManager{}, currentIntercepts: []*manager.InterceptInfo{aliceEcho}}
+		wiMap := workloadIntercepts(tm.getAllIntercepts(ctx), "default")
+		assert.Equal(t, []string{"echo-alice:alice@laptop:header-matched"}, describeWorkloadIntercepts(wiMap["echo"]))
+	})
+
+	t.Run("no client identity", func(t *testing.T) {
+		anonymous := &manager.InterceptInfo{
+			Spec:        &manager.InterceptSpec{Name: "echo", Agent: "echo", Namespace: "default", Mechanism: "http", MechanismArgs: []string{"--http-match=all"}},
+			Disposition: manager.InterceptDispositionType_ACTIVE,
+		}
+		tm := &trafficManager{managerClient: &interceptsManager{snapshot: &manager.InterceptInfoSnapshot{
+			Intercepts: []*manager.InterceptInfo{anonymous},
+		}}}
+		wiMap := workloadIntercepts(tm.getAllIntercepts(ctx), "default")
+		assert.Equal(t, []string{"echo::all-traffic"}, describeWorkloadIntercepts(wiMap["echo"]))
+	})
+}
+
+func describeWorkloadIntercepts(wis []*rpc.WorkloadInfo_Intercept) []string {
+	ds := make([]string, len(wis))
+	for i, wi := range wis {
+		ds[i] = wi.Name + ":" + wi.Client + ":" + wi.Mechanism
+	}
+	return ds
+}
@@ -0,0 +1,90 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+const (
+	removalKindAgent   = "agent"
+	removalKindManager = "traffic-manager"
+)
+
+// newRemoval returns the result of removing the named thing of the given kind. Failures caused by
+// insufficient permissions are reported as user errors.
+func newRemoval(kind, name, namespace string, err error) *rpc.UninstallResult_Removal {
+	r := &rpc.UninstallResult_Removal{Kind: kind, Name: name, Namespace: namespace}
+	if err != nil {
+		if errors2.IsForbidden(err) {
+			err = errcat.User.Newf("insufficient permissions: %w", err)
+		}
+		r.ErrorText = err.Error()
+		r.ErrorCategory = int32(errcat.GetCategory(err))
+	}
+	return r
+}
+
+// removeEach calls remove concurrently for each index in [0, n) and then calls report with the
+// index and the error that remove returned. The calls to report are not concurrent.
+func removeEach(n int, remove func(int) error, report func(int, error)) {
+	errs := make([]error, n)
+	wg := sync.WaitGroup{}
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = remove(i)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		report(i, err)
+	}
+}
+
+// removalsError returns an error that describes the failed removals, or nil if all removals succeeded.
+func removalsError(removals []*rpc.UninstallResult_Removal) error {
+	var msgs []string
+	for _, r := range removals {
+		if r.ErrorText != "" {
+			msgs = append(msgs, fmt.Sprintf("%s %s.%s: %s", r.Kind, r.Name, r.Namespace, r.ErrorText))
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(msgs, "\n"))
+}
+
+// otherClients returns the names of the clients other than this one that have a session with the
+// traffic-manager.
+func (tm *trafficManager) otherClients(c context.Context) ([]string, error) {
+	cs, err := tm.managerClient.GetClients(c, &empty.Empty{})
+	if err != nil {
+		if grpcStatus.Code(err) == grpcCodes.Unimplemented {
+			err = errcat.User.New("the traffic-manager is too old to tell if other clients are connected to it")
+		}
+		return nil, err
+	}
+	own := tm.session().SessionId
+	var names []string
+	for id, ci := range cs.Clients {
+		if id != own {
+			names = append(names, ci.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
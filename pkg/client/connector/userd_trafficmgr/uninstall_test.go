@@ -0,0 +1,90 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+func Test_removeEach_partialFailure(t *testing.T) {
+	names := []string{"echo", "api", "web"}
+	forbidden := errors2.NewForbidden(schema.GroupResource{Group: "apps", Resource: "deployments"}, "api", errors.New("RBAC denied"))
+	removals := make([]*rpc.UninstallResult_Removal, len(names))
+	removeEach(len(names), func(i int) error {
+		if names[i] == "api" {
+			return forbidden
+		}
+		return nil
+	}, func(i int, err error) {
+		removals[i] = newRemoval(removalKindAgent, names[i], "default", err)
+	})
+
+	for i, r := range removals {
+		assert.Equal(t, names[i], r.Name)
+		assert.Equal(t, "default", r.Namespace)
+		if r.Name == "api" {
+			assert.Contains(t, r.ErrorText, "insufficient permissions")
+			assert.Equal(t, int32(errcat.User), r.ErrorCategory)
+		} else {
+			assert.Empty(t, r.ErrorText, "a failure must not prevent the other removals")
+		}
+	}
+
+	err := removalsError(removals)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "agent api.default: insufficient permissions")
+	assert.NotContains(t, err.Error(), "echo")
+	assert.NoError(t, removalsError(removals[:1]))
+}
+
+type clientsManager struct {
+	manager.ManagerClient
+	clients map[string]*manager.ClientInfo
+	err     error
+}
+
+func (m *clientsManager) GetClients(context.Context, *empty.Empty, ...grpc.CallOption) (*manager.ClientInfoSnapshot, error) {
+	return &manager.ClientInfoSnapshot{Clients: m.clients}, m.err
+}
+
+func TestUninstall_everythingWithOtherClients(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	newTM := func(mgr manager.ManagerClient) *trafficManager {
+		tm := &trafficManager{managerClient: mgr, startup: make(chan struct{})}
+		close(tm.startup)
+		tm.setSession(&manager.SessionInfo{SessionId: "alice-session"})
+		return tm
+	}
+
+	tm := newTM(&clientsManager{clients: map[string]*manager.ClientInfo{
+		"alice-session": {Name: "alice@laptop"},
+		"bob-session":   {Name: "bob@desktop"},
+	}})
+	result, err := tm.Uninstall(ctx, &rpc.UninstallRequest{UninstallType: rpc.UninstallRequest_EVERYTHING})
+	require.NoError(t, err)
+	assert.Equal(t, "the traffic-manager is used by other clients: bob@desktop. Use --force to uninstall anyway", result.ErrorText)
+	assert.Equal(t, int32(errcat.User), result.ErrorCategory)
+	assert.Empty(t, result.Removals)
+
+	// A traffic-manager that can't tell which clients are connected
+	tm = newTM(&clientsManager{err: grpcStatus.Error(grpcCodes.Unimplemented, "unknown method GetClients")})
+	result, err = tm.Uninstall(ctx, &rpc.UninstallRequest{UninstallType: rpc.UninstallRequest_EVERYTHING})
+	require.NoError(t, err)
+	assert.Contains(t, result.ErrorText, "too old")
+	assert.Contains(t, result.ErrorText, "--force")
+	assert.Empty(t, result.Removals)
+}
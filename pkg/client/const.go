@@ -8,6 +8,68 @@ import (
 const (
 	// APIVersion is the API version of the daemon and connector API
 	APIVersion = 3
+
+	// ConnectorAPIVersion is the version of the API between the CLI and the user daemon. The CLI and the
+	// user daemon announce it in the metadata of their calls and responses, and it's bumped when a change
+	// of the API would make an older CLI misbehave. The user daemon adapts its responses to the version of
+	// the CLI that it serves.
+	//
+	//  - 1 is the API of telepresence 2.4.4 and older, which don't announce a version.
+	//
+	//  - 2 adds the SESSION_AMBIGUOUS connect error, and the ConnectInfo no longer has the agents.
+	ConnectorAPIVersion = 2
+
+	// MinConnectorAPIVersion is the oldest version of the API that the user daemon serves, and that the
+	// CLI can use.
+	MinConnectorAPIVersion = 1
+)
+
+// The steps of a connect, in the order that they are taken. The progress of all steps but the first
+// is streamed by the connector.
+const (
+	ConnectStepLaunchDaemon   = "launching root daemon"
+	ConnectStepCluster        = "contacting cluster"
+	ConnectStepTrafficManager = "ensuring traffic-manager"
+	ConnectStepTunnel         = "establishing tunnel"
+	ConnectStepDNS            = "configuring DNS"
+)
+
+// The proxy modes of a connect. In the "tun" mode, the root daemon routes the cluster subnets to a TUN
+// device. In the "ports" mode, there's no root daemon, and the connector proxies a loopback port to each
+// TCP port of the services in the mapped namespaces. In the "socks5" mode, there's no root daemon either,
+// and the connector serves a SOCKS5 proxy that relays TCP connections to the cluster.
+const (
+	ProxyModeTUN    = "tun"
+	ProxyModePorts  = "ports"
+	ProxyModeSOCKS5 = "socks5"
+)
+
+// ProxyPortsLimitations describes what doesn't work in the "ports" proxy mode.
+const ProxyPortsLimitations = "only TCP connections to services in the mapped namespaces are proxied; " +
+	"there's no raw IP access to the cluster, and no UDP"
+
+// ProxySOCKS5Limitations describes what doesn't work in the "socks5" proxy mode.
+const ProxySOCKS5Limitations = "only TCP connections of programs that use the SOCKS5 proxy reach the cluster; " +
+	"the cluster subnets aren't routed, the cluster DNS isn't used by the system resolver, and there's no UDP"
+
+// DefaultSOCKS5Address is the address that the SOCKS5 proxy of the "socks5" proxy mode listens to, unless
+// another one is configured.
+const DefaultSOCKS5Address = "127.0.0.1:1080"
+
+// The outbound traffic policies. With "all", the root daemon routes the service and pod subnets of the
+// cluster. With "mappedNamespaces", it only routes the IPs of the services, and of their pods, in the
+// mapped namespaces, using one host route per IP.
+const (
+	OutboundTrafficPolicyAll              = "all"
+	OutboundTrafficPolicyMappedNamespaces = "mappedNamespaces"
+)
+
+// The routing strategies of a session in the "tun" proxy mode. With "tunnel", the cluster subnets are
+// routed to the TUN device, and through the traffic-manager. With "docker-network", they're routed via the
+// nodes of a cluster that runs in containers on a docker network of the host, such as a kind cluster.
+const (
+	RoutingStrategyTunnel        = "tunnel"
+	RoutingStrategyDockerNetwork = "docker-network"
 )
 
 // DisplayVersion returns a printable version for `telepresence`
@@ -0,0 +1,71 @@
+package daemon
+
+import (
+	"context"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/metrics"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+// idleEvictionInterval is the interval between the checks for idle connections.
+var idleEvictionInterval = time.Second
+
+// poolLimits returns the limits of the connection table that the given connections config declares.
+func poolLimits(cn *client.Connections) tunnel.PoolLimits {
+	limits := tunnel.PoolLimits{
+		MaxConnections: cn.MaxTracked,
+		IdleTimeouts: map[int]time.Duration{
+			ipproto.TCP: cn.TCPIdleTimeout,
+			ipproto.UDP: cn.UDPIdleTimeout,
+		},
+	}
+	if cn.Eviction == client.ConnectionEvictionRefuse {
+		limits.Eviction = tunnel.RefuseNew
+	}
+	return limits
+}
+
+// trackConnections closes the idle connections and publishes the statistics of the connection table as
+// metrics, until the given context is cancelled.
+func (t *tunRouter) trackConnections(c context.Context) error {
+	ticker := time.NewTicker(idleEvictionInterval)
+	defer ticker.Stop()
+	var published tunnel.PoolStats
+	for {
+		select {
+		case <-c.Done():
+			return nil
+		case <-ticker.C:
+			if ids := t.handlers.EvictIdle(c); len(ids) > 0 {
+				dlog.Debugf(c, "Closed %d idle connections", len(ids))
+			}
+			published = publishConnectionStats(t.handlers.Stats(), published)
+		}
+	}
+}
+
+// publishConnectionStats updates the metrics of the connection table with the given stats, which follow
+// the previously published ones, and returns them.
+func publishConnectionStats(stats, published tunnel.PoolStats) tunnel.PoolStats {
+	metrics.TrackedConnections.Set(float64(stats.Tracked))
+	metrics.ConnectionEvictions.WithLabelValues(metrics.EvictedIdle).Add(float64(stats.IdleEvictions - published.IdleEvictions))
+	metrics.ConnectionEvictions.WithLabelValues(metrics.EvictedLimit).Add(float64(stats.LimitEvictions - published.LimitEvictions))
+	metrics.RefusedConnections.Add(float64(stats.Refused - published.Refused))
+	return stats
+}
+
+func (t *tunRouter) connectionStatsToRPC() *rpc.ConnectionStats {
+	stats := t.handlers.Stats()
+	return &rpc.ConnectionStats{
+		Tracked:        int32(stats.Tracked),
+		MaxTracked:     int32(t.handlers.Limits().MaxConnections),
+		IdleEvictions:  stats.IdleEvictions,
+		LimitEvictions: stats.LimitEvictions,
+		Refused:        stats.Refused,
+	}
+}
@@ -0,0 +1,47 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/metrics"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+func TestPoolLimits(t *testing.T) {
+	assert.Equal(t, tunnel.PoolLimits{
+		IdleTimeouts: map[int]time.Duration{ipproto.TCP: 0, ipproto.UDP: 5 * time.Second},
+	}, poolLimits(&client.Connections{UDPIdleTimeout: 5 * time.Second}))
+
+	assert.Equal(t, tunnel.PoolLimits{
+		MaxConnections: 100,
+		Eviction:       tunnel.RefuseNew,
+		IdleTimeouts:   map[int]time.Duration{ipproto.TCP: time.Hour, ipproto.UDP: time.Minute},
+	}, poolLimits(&client.Connections{
+		MaxTracked:     100,
+		Eviction:       client.ConnectionEvictionRefuse,
+		TCPIdleTimeout: time.Hour,
+		UDPIdleTimeout: time.Minute,
+	}))
+}
+
+func TestPublishConnectionStats(t *testing.T) {
+	idle := metrics.ConnectionEvictions.WithLabelValues(metrics.EvictedIdle)
+	limit := metrics.ConnectionEvictions.WithLabelValues(metrics.EvictedLimit)
+	idleBefore, limitBefore, refusedBefore := testutil.ToFloat64(idle), testutil.ToFloat64(limit), testutil.ToFloat64(metrics.RefusedConnections)
+
+	published := publishConnectionStats(tunnel.PoolStats{Tracked: 10, IdleEvictions: 3, LimitEvictions: 1}, tunnel.PoolStats{})
+	published = publishConnectionStats(tunnel.PoolStats{Tracked: 8, IdleEvictions: 5, LimitEvictions: 1, Refused: 2}, published)
+
+	// The counters are increased by the difference to the published stats
+	assert.Equal(t, float64(8), testutil.ToFloat64(metrics.TrackedConnections))
+	assert.Equal(t, float64(5), testutil.ToFloat64(idle)-idleBefore)
+	assert.Equal(t, float64(1), testutil.ToFloat64(limit)-limitBefore)
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.RefusedConnections)-refusedBefore)
+	assert.Equal(t, uint64(2), published.Refused)
+}
@@ -11,26 +11,35 @@ import (
 	"github.com/datawire/dlib/dcontext"
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/metrics"
 )
 
+// RecordTTL is the time to live, in seconds, of the records that the Server answers with.
+const RecordTTL = 60
+
 type Resolver func(ctx context.Context, qType uint16, domain string) []net.IP
 
+// SRVResolver returns the SRV records of the given domain. The header of the records is set by the Server.
+type SRVResolver func(ctx context.Context, domain string) []*dns.SRV
+
 // Server is a DNS server which implements the github.com/miekg/dns Handler interface
 type Server struct {
 	ctx          context.Context // necessary to make logging work in ServeDNS function
 	listeners    []net.PacketConn
 	fallback     *dns.Conn
 	resolve      Resolver
+	resolveSRV   SRVResolver
 	requestCount int64
 }
 
 // NewServer returns a new dns.Server
-func NewServer(c context.Context, listeners []net.PacketConn, fallback *dns.Conn, resolve Resolver) *Server {
+func NewServer(c context.Context, listeners []net.PacketConn, fallback *dns.Conn, resolve Resolver, resolveSRV SRVResolver) *Server {
 	return &Server{
-		ctx:       c,
-		listeners: listeners,
-		fallback:  fallback,
-		resolve:   resolve,
+		ctx:        c,
+		listeners:  listeners,
+		fallback:   fallback,
+		resolve:    resolve,
+		resolveSRV: resolveSRV,
 	}
 }
 
@@ -71,28 +80,46 @@ func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 		// from intercepting all queries
 		msg.RecursionAvailable = true
 		for _, ip := range ips {
-			if ip.To4() != nil {
+			// if we don't give back the same domain
+			// requested, then mac dns seems to return an
+			// nxdomain
+			hdr := dns.RR_Header{Name: domain, Rrtype: qType, Class: dns.ClassINET, Ttl: RecordTTL}
+			if ip4 := ip.To4(); ip4 != nil {
 				if qType != dns.TypeA {
 					continue
 				}
+				msg.Answer = append(msg.Answer, &dns.A{Hdr: hdr, A: ip4})
 			} else {
 				if qType != dns.TypeAAAA {
 					continue
 				}
+				msg.Answer = append(msg.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
 			}
 			dlog.Debugf(c, "QUERY[%v] %s -> %s", qType, domain, ip)
-			// if we don't give back the same domain
-			// requested, then mac dns seems to return an
-			// nxdomain
-			msg.Answer = append(msg.Answer, &dns.A{
-				Hdr: dns.RR_Header{Name: domain, Rrtype: qType, Class: dns.ClassINET, Ttl: 60},
-				A:   ip,
-			})
 		}
 		if len(msg.Answer) == 0 {
 			dlog.Debugf(c, "QUERY[%v] %s -> EMPTY", qType, domain)
 		}
 		_ = w.WriteMsg(&msg)
+		metrics.DNSQueries.WithLabelValues(metrics.DNSRouted).Inc()
+		return
+	case dns.TypeSRV:
+		srvs := s.resolveSRV(s.ctx, domain)
+		if len(srvs) == 0 {
+			break
+		}
+		msg := dns.Msg{}
+		msg.SetReply(r)
+		msg.Authoritative = true
+		msg.RecursionAvailable = true
+		for _, srv := range srvs {
+			dlog.Debugf(c, "QUERY[%v] %s -> %s:%d", qType, domain, srv.Target, srv.Port)
+			rr := *srv
+			rr.Hdr = dns.RR_Header{Name: domain, Rrtype: qType, Class: dns.ClassINET, Ttl: RecordTTL}
+			msg.Answer = append(msg.Answer, &rr)
+		}
+		_ = w.WriteMsg(&msg)
+		metrics.DNSQueries.WithLabelValues(metrics.DNSRouted).Inc()
 		return
 	default:
 		ips := s.resolve(s.ctx, qType, domain)
@@ -103,9 +130,11 @@ func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 			msg.Authoritative = true
 			msg.RecursionAvailable = true
 			_ = w.WriteMsg(&msg)
+			metrics.DNSQueries.WithLabelValues(metrics.DNSRouted).Inc()
 			return
 		}
 	}
+	metrics.DNSQueries.WithLabelValues(metrics.DNSBypassed).Inc()
 	if s.fallback != nil {
 		dlog.Debugf(c, "QTYPE[%v] %s -> FALLBACK", qType, domain)
 		client := dns.Client{Net: "udp"}
@@ -0,0 +1,91 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+type responseWriter struct {
+	dns.ResponseWriter
+	msg *dns.Msg
+}
+
+func (w *responseWriter) WriteMsg(msg *dns.Msg) error {
+	w.msg = msg
+	return nil
+}
+
+func TestServer_ServeDNS_ipFamilies(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	hosts := map[string][]net.IP{
+		"v4.default.":   {{10, 96, 0, 10}},
+		"v6.default.":   {net.ParseIP("fd00:10:96::a")},
+		"dual.default.": {{10, 96, 0, 11}, net.ParseIP("fd00:10:96::b")},
+	}
+	resolve := func(_ context.Context, _ uint16, domain string) []net.IP {
+		return hosts[domain]
+	}
+	s := NewServer(ctx, nil, nil, resolve, nil)
+
+	query := func(qType uint16, name string) *dns.Msg {
+		w := &responseWriter{}
+		r := new(dns.Msg)
+		r.SetQuestion(name, qType)
+		s.ServeDNS(w, r)
+		require.NotNil(t, w.msg)
+		return w.msg
+	}
+
+	tests := []struct {
+		name  string
+		qType uint16
+		want  []string
+	}{
+		{"v4.default.", dns.TypeA, []string{"10.96.0.10"}},
+		{"v4.default.", dns.TypeAAAA, nil},
+		{"v6.default.", dns.TypeA, nil},
+		{"v6.default.", dns.TypeAAAA, []string{"fd00:10:96::a"}},
+		{"dual.default.", dns.TypeA, []string{"10.96.0.11"}},
+		{"dual.default.", dns.TypeAAAA, []string{"fd00:10:96::b"}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name+dns.TypeToString[tt.qType], func(t *testing.T) {
+			msg := query(tt.qType, tt.name)
+
+			// A known host is never reported as non-existent, so that a client that asks for both
+			// families gets the answers of the one that exists.
+			assert.Equal(t, dns.RcodeSuccess, msg.Rcode)
+			var got []string
+			for _, rr := range msg.Answer {
+				assert.Equal(t, tt.name, rr.Header().Name)
+				assert.Equal(t, tt.qType, rr.Header().Rrtype)
+				switch rr := rr.(type) {
+				case *dns.A:
+					require.Equal(t, dns.TypeA, tt.qType)
+					require.Len(t, rr.A, net.IPv4len)
+					got = append(got, rr.A.String())
+				case *dns.AAAA:
+					require.Equal(t, dns.TypeAAAA, tt.qType)
+					got = append(got, rr.AAAA.String())
+				default:
+					t.Fatalf("unexpected record %s", rr)
+				}
+			}
+			assert.Equal(t, tt.want, got)
+
+			// The answer must survive the wire format
+			_, err := msg.Pack()
+			assert.NoError(t, err)
+		})
+	}
+
+	assert.Equal(t, dns.RcodeNameError, query(dns.TypeAAAA, "unknown.default.").Rcode)
+}
@@ -0,0 +1,68 @@
+package daemon
+
+import (
+	"sync"
+	"time"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+// dnsCacheEntry is the cached result of a cluster side lookup. An entry without IPs is a negative entry.
+type dnsCacheEntry struct {
+	ips     iputil.IPs
+	expires time.Time
+}
+
+// dnsCache caches the results of cluster side lookups until they expire or the cache is flushed.
+type dnsCache struct {
+	sync.Mutex
+	entries map[string]*dnsCacheEntry
+}
+
+// dnsCachePurgeThreshold is the number of entries that triggers a purge of expired entries on put.
+const dnsCachePurgeThreshold = 1024
+
+func newDNSCache() *dnsCache {
+	return &dnsCache{entries: make(map[string]*dnsCacheEntry)}
+}
+
+// get returns the cached IPs for the given query and true, or nil and false when the query isn't
+// cached or its entry has expired. The returned IPs are empty for a negative entry.
+func (dc *dnsCache) get(query string) (iputil.IPs, bool) {
+	dc.Lock()
+	defer dc.Unlock()
+	e, ok := dc.entries[query]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expires) {
+		delete(dc.entries, query)
+		return nil, false
+	}
+	return e.ips, true
+}
+
+// put caches the given IPs for the given query during ttl. Empty IPs are cached as a negative entry.
+func (dc *dnsCache) put(query string, ips iputil.IPs, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	dc.Lock()
+	defer dc.Unlock()
+	if len(dc.entries) >= dnsCachePurgeThreshold {
+		for q, e := range dc.entries {
+			if now.After(e.expires) {
+				delete(dc.entries, q)
+			}
+		}
+	}
+	dc.entries[query] = &dnsCacheEntry{ips: ips, expires: now.Add(ttl)}
+}
+
+// flush discards all entries.
+func (dc *dnsCache) flush() {
+	dc.Lock()
+	dc.entries = make(map[string]*dnsCacheEntry)
+	dc.Unlock()
+}
@@ -0,0 +1,182 @@
+package daemon
+
+import (
+	"context"
+	"net"
+	"sort"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/routing"
+	"github.com/telepresenceio/telepresence/v2/pkg/subnet"
+)
+
+// addGatewayRoute and removeGatewayRoute are variables so that tests can verify the gateway routes without
+// touching the host.
+var (
+	addGatewayRoute    = routing.AddGatewayRoute
+	removeGatewayRoute = routing.RemoveGatewayRoute
+)
+
+// gatewayRoute is a route of a cluster subnet via a node of a local cluster, see
+// daemon.DockerNetworkRoutes.
+type gatewayRoute struct {
+	subnet  *net.IPNet
+	gateway net.IP
+
+	// session is the session that the route belongs to
+	session *routerSession
+}
+
+func (r *gatewayRoute) String() string {
+	return r.subnet.String() + " via " + r.gateway.String()
+}
+
+// viaDockerNetwork returns true when the given cluster subnet of the session is routed via the docker
+// network of a local cluster instead of to the TUN device. That's the case for the subnets of the IP family
+// of the gateway. Must be called with the subnetsLock of the router held.
+func (s *routerSession) viaDockerNetwork(sn *net.IPNet) bool {
+	return s.dockerNetwork != nil && (sn.IP.To4() != nil) == (net.IP(s.dockerNetwork.Gateway).To4() != nil)
+}
+
+// desiredGatewayRoutesLocked returns the gateway routes of the sessions that use the docker network of a
+// local cluster, keyed by their string form. The cluster subnets are routed via the gateway of the
+// session, and the pod subnets of the nodes, which are more specific, via the nodes themselves. The
+// never-proxy subnets are subtracted. Must be called with the subnetsLock held.
+func (t *tunRouter) desiredGatewayRoutesLocked() map[string]*gatewayRoute {
+	desired := make(map[string]*gatewayRoute)
+	add := func(s *routerSession, sns []*net.IPNet, gateway net.IP) {
+		for _, sn := range subnet.SubtractAll(sns, t.neverProxySubnets) {
+			r := &gatewayRoute{subnet: sn, gateway: gateway, session: s}
+			desired[r.String()] = r
+		}
+	}
+	for _, s := range t.getSessions() {
+		if s.dockerNetwork == nil {
+			continue
+		}
+		var sns []*net.IPNet
+		for _, sn := range s.routedClusterSubnets {
+			if s.viaDockerNetwork(sn) {
+				sns = append(sns, sn)
+			}
+		}
+		add(s, sns, s.dockerNetwork.Gateway)
+		for _, nr := range s.dockerNetwork.NodeRoutes {
+			add(s, []*net.IPNet{iputil.IPNetFromRPC(nr.Subnet)}, nr.Gateway)
+		}
+	}
+	return desired
+}
+
+// gatewayRouteChanges returns the routes that must be added to, and removed from, the given current
+// routes for them to become the given desired routes. Both results are sorted.
+func gatewayRouteChanges(cur, desired map[string]*gatewayRoute) (added, removed []*gatewayRoute) {
+	for key, r := range desired {
+		if _, ok := cur[key]; !ok {
+			added = append(added, r)
+		}
+	}
+	for key, r := range cur {
+		if _, ok := desired[key]; !ok {
+			removed = append(removed, r)
+		}
+	}
+	sortGatewayRoutes(added)
+	sortGatewayRoutes(removed)
+	return added, removed
+}
+
+func sortGatewayRoutes(rs []*gatewayRoute) {
+	sort.Slice(rs, func(i, j int) bool { return rs[i].String() < rs[j].String() })
+}
+
+// refreshGatewayRoutesLocked makes the gateway routes match the desired ones. A session whose routes can't
+// be added stops using the docker network, so that its cluster subnets are routed to the TUN device by the
+// refreshSubnets call that this is a part of. Must be called with the routesLock held.
+func (t *tunRouter) refreshGatewayRoutesLocked(ctx context.Context) {
+	for {
+		t.subnetsLock.RLock()
+		added, removed := gatewayRouteChanges(t.gatewayRoutes, t.desiredGatewayRoutesLocked())
+		t.subnetsLock.RUnlock()
+
+		for _, r := range removed {
+			if err := removeGatewayRoute(ctx, r.subnet, r.gateway); err != nil {
+				dlog.Errorf(ctx, "failed to remove route %s: %v", r, err)
+				continue
+			}
+			t.state.removeRoute(ctx, r.String())
+			t.subnetsLock.Lock()
+			delete(t.gatewayRoutes, r.String())
+			t.subnetsLock.Unlock()
+		}
+
+		var failed []*routerSession
+		for _, r := range added {
+			// Record the route before it's added, so that a crash in between doesn't leave an
+			// unrecorded route behind.
+			t.state.addRoute(ctx, r.String())
+			if err := addGatewayRoute(ctx, r.subnet, r.gateway); err != nil {
+				dlog.Errorf(ctx, "failed to add route %s, the cluster subnets of session %s are routed to the TUN device instead: %v",
+					r, r.session.displayName(), err)
+				t.state.removeRoute(ctx, r.String())
+				failed = append(failed, r.session)
+				continue
+			}
+			dlog.Infof(ctx, "Added route %s", r)
+			t.subnetsLock.Lock()
+			t.gatewayRoutes[r.String()] = r
+			t.subnetsLock.Unlock()
+		}
+		if len(failed) == 0 {
+			return
+		}
+
+		// The next round removes the routes that were added for the failed sessions
+		t.subnetsLock.Lock()
+		for _, s := range failed {
+			s.dockerNetwork = nil
+		}
+		t.mergeSessionSubnets()
+		t.subnetsLock.Unlock()
+	}
+}
+
+// removeGatewayRoutes removes all gateway routes. It's called when the router terminates, because unlike
+// the routes to the TUN device, they don't vanish with it.
+func (t *tunRouter) removeGatewayRoutes(ctx context.Context) {
+	t.routesLock.Lock()
+	defer t.routesLock.Unlock()
+	t.subnetsLock.Lock()
+	rs := make([]*gatewayRoute, 0, len(t.gatewayRoutes))
+	for _, r := range t.gatewayRoutes {
+		rs = append(rs, r)
+	}
+	t.gatewayRoutes = make(map[string]*gatewayRoute)
+	t.subnetsLock.Unlock()
+	sortGatewayRoutes(rs)
+	for _, r := range rs {
+		if err := removeGatewayRoute(ctx, r.subnet, r.gateway); err != nil {
+			dlog.Errorf(ctx, "failed to remove route %s: %v", r, err)
+			continue
+		}
+		t.state.removeRoute(ctx, r.String())
+	}
+}
+
+// gatewayRoutesToRPC returns the current gateway routes, sorted.
+func (t *tunRouter) gatewayRoutesToRPC() []*daemon.GatewayRoute {
+	t.subnetsLock.RLock()
+	rs := make([]*gatewayRoute, 0, len(t.gatewayRoutes))
+	for _, r := range t.gatewayRoutes {
+		rs = append(rs, r)
+	}
+	t.subnetsLock.RUnlock()
+	sortGatewayRoutes(rs)
+	rpcRoutes := make([]*daemon.GatewayRoute, len(rs))
+	for i, r := range rs {
+		rpcRoutes[i] = &daemon.GatewayRoute{Subnet: iputil.IPNetToRPC(r.subnet), Gateway: r.gateway}
+	}
+	return rpcRoutes
+}
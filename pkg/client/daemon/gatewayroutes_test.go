@@ -0,0 +1,106 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+// withFakeGatewayRoutes replaces the functions that add and remove gateway routes with functions that
+// record the routes in the returned map, and that fail to add routes via the given gateway.
+func withFakeGatewayRoutes(t *testing.T, failingGateway net.IP) map[string]bool {
+	routes := make(map[string]bool)
+	oldAdd, oldRemove := addGatewayRoute, removeGatewayRoute
+	addGatewayRoute = func(_ context.Context, sn *net.IPNet, gw net.IP) error {
+		if gw.Equal(failingGateway) {
+			return errors.New("network is unreachable")
+		}
+		routes[sn.String()+" via "+gw.String()] = true
+		return nil
+	}
+	removeGatewayRoute = func(_ context.Context, sn *net.IPNet, gw net.IP) error {
+		delete(routes, sn.String()+" via "+gw.String())
+		return nil
+	}
+	t.Cleanup(func() { addGatewayRoute, removeGatewayRoute = oldAdd, oldRemove })
+	return routes
+}
+
+func TestTunRouter_gatewayRoutes(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	nodeRoute := func(cidr string, gw net.IP) *daemon.GatewayRoute {
+		_, sn, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		return &daemon.GatewayRoute{Subnet: iputil.IPNetToRPC(sn), Gateway: gw}
+	}
+	kindGW := net.IP{172, 18, 0, 2}
+	kind := &routerSession{
+		name:           "kind-ambassador",
+		clusterSubnets: cidrs(t, "10.96.0.0/16", "fd00:10:96::/112", "10.244.0.0/16"),
+		serviceSubnets: cidrs(t, "10.96.0.0/16", "fd00:10:96::/112"),
+		dockerNetwork: &daemon.DockerNetworkRoutes{
+			Gateway: kindGW,
+			NodeRoutes: []*daemon.GatewayRoute{
+				nodeRoute("10.244.0.0/24", kindGW),
+				nodeRoute("10.244.1.0/24", net.IP{172, 18, 0, 3}),
+			},
+		},
+	}
+	k3dGW := net.IP{172, 19, 0, 3}
+	k3d := &routerSession{
+		name:           "k3d-ambassador",
+		clusterSubnets: cidrs(t, "10.43.0.0/16", "10.42.0.0/16"),
+		serviceSubnets: cidrs(t, "10.43.0.0/16"),
+		dockerNetwork: &daemon.DockerNetworkRoutes{
+			Gateway:    k3dGW,
+			NodeRoutes: []*daemon.GatewayRoute{nodeRoute("10.42.0.0/24", k3dGW)},
+		},
+	}
+	gke := &routerSession{
+		name:           "gke-ambassador",
+		clusterSubnets: cidrs(t, "10.100.0.0/16"),
+		serviceSubnets: cidrs(t, "10.100.0.0/16"),
+	}
+	tr := &tunRouter{
+		sessions:      []*routerSession{kind, k3d, gke},
+		gatewayRoutes: make(map[string]*gatewayRoute),
+	}
+	routes := withFakeGatewayRoutes(t, k3dGW)
+	tr.mergeSessionSubnets()
+
+	// Only the subnets of another IP family than the gateway, and those of sessions that don't use the
+	// docker network, are routed to the TUN device
+	assert.Equal(t, []string{"10.100.0.0/16", "fd00:10:96::/112"}, cidrStrings(tr.clusterSubnets))
+	assert.Equal(t, []string{"10.100.0.0/16", "fd00:10:96::/112"}, cidrStrings(tr.serviceSubnets))
+	assert.Equal(t, kind, tr.sessionFor(net.IP{10, 244, 0, 8}))
+
+	// The routes of the session whose gateway is unreachable fail, so its subnets are routed to the TUN
+	// device after all
+	tr.refreshGatewayRoutesLocked(ctx)
+	assert.Equal(t, map[string]bool{
+		"10.96.0.0/16 via 172.18.0.2":  true,
+		"10.244.0.0/16 via 172.18.0.2": true,
+		"10.244.0.0/24 via 172.18.0.2": true,
+		"10.244.1.0/24 via 172.18.0.3": true,
+	}, routes)
+	assert.Nil(t, k3d.dockerNetwork)
+	assert.Equal(t, []string{"10.100.0.0/16", "10.42.0.0/16", "10.43.0.0/16", "fd00:10:96::/112"}, cidrStrings(tr.clusterSubnets))
+	assert.Len(t, tr.gatewayRoutesToRPC(), len(routes))
+
+	// Nothing changes when the routes are refreshed again
+	tr.refreshGatewayRoutesLocked(ctx)
+	assert.Len(t, routes, 4)
+
+	// The routes are removed when the router terminates
+	tr.removeGatewayRoutes(ctx)
+	assert.Empty(t, routes)
+	assert.Empty(t, tr.gatewayRoutesToRPC())
+}
@@ -0,0 +1,168 @@
+package daemon
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+// headlessRecords are the DNS records of the headless services in the mapped namespaces. They
+// are kept up to date by the connector, which watches the EndpointSlices of those services, and
+// are used instead of a cluster side lookup.
+type headlessRecords struct {
+	// hosts maps names (with trailing dot) of services and their pods to IPs
+	hosts map[string]iputil.IPs
+
+	// srv maps names of the form _<port>._<protocol>.<service>.<namespace>... to SRV records
+	srv map[string][]*dns.SRV
+}
+
+// podHostname returns the hostname of an endpoint, or a name derived from its IP, like the cluster
+// DNS does, when it has none.
+func podHostname(ep *rpc.HeadlessService_Endpoint) string {
+	if ep.Hostname != "" {
+		return ep.Hostname
+	}
+	if len(ep.Ips) == 0 {
+		return ""
+	}
+	return strings.NewReplacer(".", "-", ":", "-").Replace(net.IP(ep.Ips[0]).String())
+}
+
+// newHeadlessRecords creates the records of the given services. Each name is declared both in its
+// fully qualified form, <service>.<namespace>.svc.<cluster domain>, and in the short form,
+// <service>.<namespace>, that the cluster resolves using its search path.
+func newHeadlessRecords(services []*rpc.HeadlessService, clusterDomain string) *headlessRecords {
+	hr := &headlessRecords{
+		hosts: make(map[string]iputil.IPs),
+		srv:   make(map[string][]*dns.SRV),
+	}
+	for _, svc := range services {
+		short := strings.ToLower(svc.Name + "." + svc.Namespace + ".")
+		fqdn := short + "svc." + clusterDomain
+		var svcIPs iputil.IPs
+		var srvs []*dns.SRV
+		targets := make(map[string]struct{})
+		for _, ep := range svc.Endpoints {
+			ips := iputil.IPsFromBytesSlice(ep.Ips)
+			if len(ips) == 0 {
+				continue
+			}
+			svcIPs = append(svcIPs, ips...)
+			host := strings.ToLower(podHostname(ep))
+			hr.hosts[host+"."+short] = append(hr.hosts[host+"."+short], ips...)
+			hr.hosts[host+"."+fqdn] = append(hr.hosts[host+"."+fqdn], ips...)
+			// The endpoints of a dual-stack service are declared once per IP family
+			if _, ok := targets[host]; !ok {
+				targets[host] = struct{}{}
+				srvs = append(srvs, &dns.SRV{Target: host + "." + fqdn})
+			}
+		}
+		if len(svcIPs) == 0 {
+			continue
+		}
+		hr.hosts[short] = svcIPs
+		hr.hosts[fqdn] = svcIPs
+
+		sort.Slice(srvs, func(i, j int) bool { return srvs[i].Target < srvs[j].Target })
+		weight := uint16(100 / len(srvs))
+		if weight == 0 {
+			weight = 1
+		}
+		for _, port := range svc.Ports {
+			if port.Name == "" {
+				// Only named ports have SRV records
+				continue
+			}
+			prefix := strings.ToLower("_" + port.Name + "._" + port.Protocol + ".")
+			records := make([]*dns.SRV, len(srvs))
+			for i, srv := range srvs {
+				records[i] = &dns.SRV{Target: srv.Target, Port: uint16(port.Port), Weight: weight}
+			}
+			hr.srv[prefix+short] = records
+			hr.srv[prefix+fqdn] = records
+		}
+	}
+	return hr
+}
+
+func (hr *headlessRecords) lookupHost(query string) iputil.IPs {
+	if hr == nil {
+		return nil
+	}
+	return hr.hosts[query]
+}
+
+func (hr *headlessRecords) lookupSRV(query string) []*dns.SRV {
+	if hr == nil {
+		return nil
+	}
+	return hr.srv[query]
+}
+
+// setHeadlessServices replaces the records of the headless services of the given session.
+func (o *outbound) setHeadlessServices(sessionName string, services []*rpc.HeadlessService) {
+	clusterDomain := o.router.clusterDomain
+	if s := o.router.getSession(sessionName); s != nil && s.clusterDomain != "" {
+		clusterDomain = s.clusterDomain
+	}
+	hr := newHeadlessRecords(services, clusterDomain)
+	o.headlessLock.Lock()
+	o.sessionHeadless[sessionName] = hr
+	o.headless = o.mergedHeadlessRecords()
+	o.headlessLock.Unlock()
+}
+
+// mergedHeadlessRecords returns the records of the headless services of all sessions. A name that is
+// declared by more than one session resolves using the records of the first session. Must be called
+// with the headlessLock held.
+func (o *outbound) mergedHeadlessRecords() *headlessRecords {
+	names := make([]string, 0, len(o.sessionHeadless))
+	for name := range o.sessionHeadless {
+		names = append(names, name)
+	}
+	names = o.orderSessionNames(names)
+	if len(names) == 1 {
+		return o.sessionHeadless[names[0]]
+	}
+	merged := &headlessRecords{
+		hosts: make(map[string]iputil.IPs),
+		srv:   make(map[string][]*dns.SRV),
+	}
+	for _, name := range names {
+		hr := o.sessionHeadless[name]
+		for k, v := range hr.hosts {
+			if _, ok := merged.hosts[k]; !ok {
+				merged.hosts[k] = v
+			}
+		}
+		for k, v := range hr.srv {
+			if _, ok := merged.srv[k]; !ok {
+				merged.srv[k] = v
+			}
+		}
+	}
+	return merged
+}
+
+func (o *outbound) headlessRecords() *headlessRecords {
+	o.headlessLock.RLock()
+	defer o.headlessLock.RUnlock()
+	return o.headless
+}
+
+// resolveSRV returns the SRV records of the given query. Only headless services have SRV records.
+func (o *outbound) resolveSRV(_ context.Context, query string) []*dns.SRV {
+	query = strings.ToLower(query)
+	query = strings.TrimSuffix(query, tel2SubDomainDot)
+	if !o.shouldDoClusterLookup(query) {
+		return nil
+	}
+	return o.headlessRecords().lookupSRV(query)
+}
@@ -0,0 +1,153 @@
+package daemon
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	dns2 "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/daemon/dns"
+)
+
+// postgresService is a dual-stack StatefulSet service as described by the EndpointSlices of its two IP families.
+func postgresService(ips ...net.IP) *rpc.HeadlessService {
+	svc := &rpc.HeadlessService{
+		Name:      "postgres",
+		Namespace: "db",
+		Ports: []*rpc.HeadlessService_Port{
+			{Name: "postgres", Protocol: "TCP", Port: 5432},
+			{Protocol: "TCP", Port: 9187},
+		},
+	}
+	for i, ip := range ips {
+		svc.Endpoints = append(svc.Endpoints, &rpc.HeadlessService_Endpoint{Hostname: "postgres-" + strconv.Itoa(i), Ips: [][]byte{ip}})
+	}
+	svc.Endpoints = append(svc.Endpoints, &rpc.HeadlessService_Endpoint{Hostname: "postgres-0", Ips: [][]byte{net.ParseIP("fd00::5")}})
+	return svc
+}
+
+func newHeadlessOutbound(services ...*rpc.HeadlessService) *outbound {
+	upstream := &fakeUpstream{}
+	o := newCachingOutbound(upstream, &rpc.DNSConfig{})
+	o.namespaces = map[string]struct{}{"db": {}, "blue": {}}
+	o.setHeadlessServices("", services)
+	return o
+}
+
+func TestResolveInCluster_headless(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	pg0 := net.IP{10, 1, 0, 5}
+	pg1 := net.IP{10, 1, 0, 6}
+	pg0v6 := net.ParseIP("fd00::5")
+	echo := &rpc.HeadlessService{
+		Name:      "echo",
+		Namespace: "blue",
+		Endpoints: []*rpc.HeadlessService_Endpoint{{Ips: [][]byte{{10, 1, 1, 2}}}},
+	}
+	o := newHeadlessOutbound(postgresService(pg0, pg1), echo)
+
+	tests := []struct {
+		query string
+		ips   []net.IP
+	}{
+		{"postgres.db.svc.cluster.local.", []net.IP{pg0, pg1, pg0v6}},
+		{"postgres.db.", []net.IP{pg0, pg1, pg0v6}},
+		{"postgres-0.postgres.db.svc.cluster.local.", []net.IP{pg0, pg0v6}},
+		{"postgres-1.postgres.db.", []net.IP{pg1}},
+		{"postgres-1.postgres.db.tel2-search.", []net.IP{pg1}},
+		{"Postgres-1.Postgres.DB.svc.cluster.local.", []net.IP{pg1}},
+		{"echo.blue.svc.cluster.local.", []net.IP{{10, 1, 1, 2}}},
+		{"10-1-1-2.echo.blue.svc.cluster.local.", []net.IP{{10, 1, 1, 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			ips := o.resolveInCluster(ctx, dns2.TypeA, tt.query)
+			require.Len(t, ips, len(tt.ips))
+			for i, ip := range tt.ips {
+				assert.True(t, ip.Equal(ips[i]), "%s != %s", ip, ips[i])
+			}
+		})
+	}
+
+	// Names of pods that aren't known are looked up in the cluster
+	assert.Nil(t, o.resolveInCluster(ctx, dns2.TypeA, "postgres-2.postgres.db."))
+	assert.Equal(t, 1, o.router.firstSession().managerClient.(*fakeUpstream).lookupCount())
+}
+
+func TestResolveSRV_headless(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	o := newHeadlessOutbound(postgresService(net.IP{10, 1, 0, 5}, net.IP{10, 1, 0, 6}))
+
+	expected := []*dns2.SRV{
+		{Target: "postgres-0.postgres.db.svc.cluster.local.", Port: 5432, Weight: 50},
+		{Target: "postgres-1.postgres.db.svc.cluster.local.", Port: 5432, Weight: 50},
+	}
+	assert.Equal(t, expected, o.resolveSRV(ctx, "_postgres._tcp.postgres.db.svc.cluster.local."))
+	assert.Equal(t, expected, o.resolveSRV(ctx, "_postgres._tcp.postgres.db."))
+	assert.Nil(t, o.resolveSRV(ctx, "_http._tcp.postgres.db.svc.cluster.local."), "port doesn't exist")
+	assert.Nil(t, o.resolveSRV(ctx, "_postgres._tcp.postgres.green.svc.cluster.local."), "namespace isn't mapped")
+}
+
+func TestSetHeadlessServices_update(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	o := newHeadlessOutbound(postgresService(net.IP{10, 1, 0, 5}))
+	assert.Len(t, o.resolveSRV(ctx, "_postgres._tcp.postgres.db."), 1)
+
+	// A pod is added
+	o.setHeadlessServices("", []*rpc.HeadlessService{postgresService(net.IP{10, 1, 0, 5}, net.IP{10, 1, 0, 6})})
+	assert.Len(t, o.resolveSRV(ctx, "_postgres._tcp.postgres.db."), 2)
+	assert.Equal(t, []net.IP{{10, 1, 0, 6}}, []net.IP(o.resolveInCluster(ctx, dns2.TypeA, "postgres-1.postgres.db.")))
+
+	// The service is deleted
+	o.setHeadlessServices("", nil)
+	assert.Nil(t, o.resolveSRV(ctx, "_postgres._tcp.postgres.db."))
+}
+
+type fakeResponseWriter struct {
+	dns2.ResponseWriter
+	msg *dns2.Msg
+}
+
+func (w *fakeResponseWriter) WriteMsg(msg *dns2.Msg) error {
+	w.msg = msg
+	return nil
+}
+
+func TestServeDNS_headless(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	o := newHeadlessOutbound(postgresService(net.IP{10, 1, 0, 5}))
+	s := dns.NewServer(ctx, nil, nil, o.resolveInCluster, o.resolveSRV)
+
+	query := func(qType uint16, name string) *dns2.Msg {
+		w := &fakeResponseWriter{}
+		r := new(dns2.Msg)
+		r.SetQuestion(name, qType)
+		s.ServeDNS(w, r)
+		require.NotNil(t, w.msg)
+		return w.msg
+	}
+
+	msg := query(dns2.TypeA, "postgres-0.postgres.db.svc.cluster.local.")
+	require.Len(t, msg.Answer, 1)
+	assert.Equal(t, "10.1.0.5", msg.Answer[0].(*dns2.A).A.String())
+
+	msg = query(dns2.TypeAAAA, "postgres-0.postgres.db.svc.cluster.local.")
+	require.Len(t, msg.Answer, 1)
+	assert.Equal(t, "fd00::5", msg.Answer[0].(*dns2.AAAA).AAAA.String())
+
+	msg = query(dns2.TypeSRV, "_postgres._tcp.postgres.db.svc.cluster.local.")
+	require.Len(t, msg.Answer, 1)
+	srv := msg.Answer[0].(*dns2.SRV)
+	assert.Equal(t, "_postgres._tcp.postgres.db.svc.cluster.local.", srv.Hdr.Name)
+	assert.Equal(t, dns2.TypeSRV, srv.Hdr.Rrtype)
+	assert.Equal(t, "postgres-0.postgres.db.svc.cluster.local.", srv.Target)
+	assert.Equal(t, uint16(5432), srv.Port)
+
+	msg = query(dns2.TypeSRV, "_http._tcp.postgres.db.svc.cluster.local.")
+	assert.Equal(t, dns2.RcodeNameError, msg.Rcode)
+}
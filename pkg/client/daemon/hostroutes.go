@@ -0,0 +1,196 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/datawire/dlib/dtime"
+)
+
+// hostRoutesBatchDelay is the time that the router waits after a change of the routed IPs before it
+// updates the host routes, so that the many changes of a rollout are applied in one batch.
+var hostRoutesBatchDelay = time.Second
+
+// hostRoutesInterval is the minimum time between two updates of the host routes.
+var hostRoutesInterval = 5 * time.Second
+
+// setRoutedIPs replaces the IPs that are routed to the session with the given name, provided that its
+// outbound traffic policy is "mappedNamespaces", and schedules an update of the host routes. The IPs
+// are kept also when the session hasn't been added yet, because the connector may start watching the
+// services before it sends the outbound info.
+func (t *tunRouter) setRoutedIPs(ctx context.Context, sessionName string, rpcIPs [][]byte) {
+	ips := make([]net.IP, 0, len(rpcIPs))
+	for _, ip := range rpcIPs {
+		if len(ip) == net.IPv4len || len(ip) == net.IPv6len {
+			ips = append(ips, ip)
+		}
+	}
+	if t.helperScope {
+		ips = ipsInScope(ctx, ips)
+	}
+	dlog.Debugf(ctx, "Received %d routed IPs for session %q", len(ips), sessionName)
+	t.subnetsLock.Lock()
+	t.routedIPs[sessionName] = ips
+	t.subnetsLock.Unlock()
+	t.scheduleHostRoutes()
+}
+
+// scheduleHostRoutes makes runHostRoutes update the host routes in its next batch.
+func (t *tunRouter) scheduleHostRoutes() {
+	select {
+	case t.hostRoutesCh <- struct{}{}:
+	default:
+	}
+}
+
+// runHostRoutes updates the host routes each time they are scheduled, in batches.
+func (t *tunRouter) runHostRoutes(c context.Context) error {
+	batchUpdates(c, t.hostRoutesCh, hostRoutesBatchDelay, hostRoutesInterval, t.refreshHostRoutes)
+	return nil
+}
+
+// batchUpdates calls update for the signals received on the given channel until the context is
+// cancelled. It waits for the given delay after a signal, so that the signals that arrive meanwhile are
+// handled by the same call, and it lets at least the given interval pass between two calls.
+func batchUpdates(c context.Context, signals chan struct{}, delay, interval time.Duration, update func(context.Context)) {
+	var last time.Time
+	for {
+		select {
+		case <-c.Done():
+			return
+		case <-signals:
+		}
+		wait := delay
+		if d := time.Until(last.Add(interval)); d > wait {
+			wait = d
+		}
+		dtime.SleepWithContext(c, wait)
+		if c.Err() != nil {
+			return
+		}
+		select {
+		case <-signals:
+		default:
+		}
+		last = time.Now()
+		update(c)
+	}
+}
+
+// desiredHostIPsLocked returns the IPs that get host routes, which are the routed IPs and the cluster DNS
+// IP of the sessions whose outbound traffic policy is "mappedNamespaces". Must be called with the
+// subnetsLock held.
+func (t *tunRouter) desiredHostIPsLocked() []net.IP {
+	var ips []net.IP
+	for _, s := range t.getSessions() {
+		if !s.mappedNamespacesOnly {
+			continue
+		}
+		if s.dnsIP != nil {
+			ips = append(ips, s.dnsIP)
+		}
+		ips = append(ips, t.routedIPs[s.name]...)
+	}
+	return ips
+}
+
+// refreshHostRoutes makes the host routes of the TUN device match the desired host IPs.
+func (t *tunRouter) refreshHostRoutes(ctx context.Context) {
+	t.routesLock.Lock()
+	defer t.routesLock.Unlock()
+
+	t.subnetsLock.RLock()
+	added, removed := hostRouteChanges(t.hostRoutes, t.desiredHostIPsLocked(), t.neverProxySubnets)
+	t.subnetsLock.RUnlock()
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	dlog.Infof(ctx, "Updating host routes, adding %d and removing %d", len(added), len(removed))
+
+	for _, sn := range removed {
+		if err := t.routes.RemoveSubnet(ctx, sn); err != nil {
+			dlog.Errorf(ctx, "failed to remove host route %s: %v", sn, err)
+			continue
+		}
+		t.state.removeSubnet(ctx, sn)
+		t.subnetsLock.Lock()
+		delete(t.hostRoutes, sn.IP.String())
+		t.subnetsLock.Unlock()
+	}
+
+	for _, sn := range added {
+		t.state.addSubnet(ctx, sn)
+		if err := t.routes.AddSubnet(ctx, sn); err != nil {
+			dlog.Errorf(ctx, "failed to add host route %s: %v", sn, err)
+			t.state.removeSubnet(ctx, sn)
+			continue
+		}
+		t.subnetsLock.Lock()
+		t.hostRoutes[sn.IP.String()] = sn
+		t.subnetsLock.Unlock()
+	}
+}
+
+// hostRouteChanges returns the host routes that must be added to, and removed from, the given current
+// routes, keyed by IP, for them to become the routes of the given IPs. IPs that are in one of the given
+// never-proxy subnets get no route. Both results are sorted by IP.
+func hostRouteChanges(cur map[string]*net.IPNet, ips []net.IP, neverProxy []*net.IPNet) (added, removed []*net.IPNet) {
+	desired := make(map[string]*net.IPNet, len(ips))
+nextIP:
+	for _, ip := range ips {
+		for _, np := range neverProxy {
+			if np.Contains(ip) {
+				continue nextIP
+			}
+		}
+		sn := hostSubnet(ip)
+		desired[sn.IP.String()] = sn
+	}
+	for key, sn := range desired {
+		if _, ok := cur[key]; !ok {
+			added = append(added, sn)
+		}
+	}
+	for key, sn := range cur {
+		if _, ok := desired[key]; !ok {
+			removed = append(removed, sn)
+		}
+	}
+	sortSubnets(added)
+	sortSubnets(removed)
+	return added, removed
+}
+
+// hostSubnet returns the /32, or for IPv6 the /128, subnet of the given IP.
+func hostSubnet(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+func sortSubnets(sns []*net.IPNet) {
+	sort.Slice(sns, func(i, j int) bool {
+		return bytes.Compare(sns[i].IP.To16(), sns[j].IP.To16()) < 0
+	})
+}
+
+// hostRoutesLocked returns the current host routes. Must be called with the subnetsLock held.
+func (t *tunRouter) hostRoutesLocked() []*net.IPNet {
+	sns := make([]*net.IPNet, 0, len(t.hostRoutes))
+	for _, sn := range t.hostRoutes {
+		sns = append(sns, sn)
+	}
+	return sns
+}
+
+// managedRoutes returns the number of host routes.
+func (t *tunRouter) managedRoutes() int32 {
+	t.subnetsLock.RLock()
+	defer t.subnetsLock.RUnlock()
+	return int32(len(t.hostRoutes))
+}
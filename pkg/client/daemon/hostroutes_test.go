@@ -0,0 +1,121 @@
+package daemon
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func Test_hostRouteChanges(t *testing.T) {
+	cur := make(map[string]*net.IPNet)
+	apply := func(added, removed []*net.IPNet) {
+		for _, sn := range removed {
+			delete(cur, sn.IP.String())
+		}
+		for _, sn := range added {
+			cur[sn.IP.String()] = sn
+		}
+	}
+	neverProxy := cidrs(t, "10.96.9.0/24")
+
+	ips := []net.IP{{10, 96, 0, 10}, {10, 244, 1, 5}, {10, 244, 1, 6}, net.ParseIP("fd00:96::a"), {10, 96, 9, 1}}
+	added, removed := hostRouteChanges(cur, ips, neverProxy)
+	assert.Equal(t, []string{"10.244.1.5/32", "10.244.1.6/32", "10.96.0.10/32", "fd00:96::a/128"}, cidrStrings(added))
+	assert.Empty(t, removed)
+	apply(added, removed)
+
+	// A rollout replaces a pod
+	ips = []net.IP{{10, 96, 0, 10}, {10, 244, 1, 5}, {10, 244, 2, 7}, net.ParseIP("fd00:96::a")}
+	added, removed = hostRouteChanges(cur, ips, neverProxy)
+	assert.Equal(t, []string{"10.244.2.7/32"}, cidrStrings(added))
+	assert.Equal(t, []string{"10.244.1.6/32"}, cidrStrings(removed))
+	apply(added, removed)
+
+	// Nothing changes when the IPs are the same, in whatever order and with duplicates
+	ips = []net.IP{net.ParseIP("fd00:96::a"), net.ParseIP("10.244.2.7"), {10, 244, 1, 5}, {10, 96, 0, 10}, {10, 96, 0, 10}}
+	added, removed = hostRouteChanges(cur, ips, neverProxy)
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+
+	// All routes are removed when the namespaces are no longer mapped
+	added, removed = hostRouteChanges(cur, nil, neverProxy)
+	assert.Empty(t, added)
+	assert.Equal(t, []string{"10.244.1.5/32", "10.244.2.7/32", "10.96.0.10/32", "fd00:96::a/128"}, cidrStrings(removed))
+}
+
+func TestTunRouter_mappedNamespacesOnly(t *testing.T) {
+	dev := &routerSession{
+		name:                 "dev-ambassador",
+		clusterSubnets:       cidrs(t, "10.96.0.0/12", "10.244.0.0/16"),
+		serviceSubnets:       cidrs(t, "10.96.0.0/12"),
+		dnsIP:                net.IP{10, 96, 0, 10},
+		mappedNamespacesOnly: true,
+	}
+	shared := &routerSession{
+		name:           "shared-ambassador",
+		clusterSubnets: cidrs(t, "10.128.0.0/16"),
+		serviceSubnets: cidrs(t, "10.128.0.0/16"),
+		dnsIP:          net.IP{10, 128, 0, 10},
+	}
+	tr := &tunRouter{
+		sessions: []*routerSession{dev, shared},
+		routedIPs: map[string][]net.IP{
+			"dev-ambassador":    {{10, 96, 3, 1}, {10, 244, 0, 8}},
+			"shared-ambassador": {{10, 128, 3, 1}},
+		},
+	}
+	tr.mergeSessionSubnets()
+
+	// The cluster subnets of the session aren't routed, but they still tell where its IPs are routed
+	assert.Equal(t, []string{"10.128.0.0/16"}, cidrStrings(tr.clusterSubnets))
+	assert.Equal(t, []string{"10.128.0.0/16"}, cidrStrings(tr.serviceSubnets))
+	assert.Equal(t, dev, tr.sessionFor(net.IP{10, 244, 0, 8}))
+
+	// Only the IPs of the session that routes the mapped namespaces get host routes, along with its cluster DNS IP
+	added, _ := hostRouteChanges(nil, tr.desiredHostIPsLocked(), nil)
+	assert.Equal(t, []string{"10.244.0.8/32", "10.96.0.10/32", "10.96.3.1/32"}, cidrStrings(added))
+}
+
+func Test_batchUpdates(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	const delay = 50 * time.Millisecond
+	const interval = 300 * time.Millisecond
+	signals := make(chan struct{}, 1)
+	signal := func() {
+		select {
+		case signals <- struct{}{}:
+		default:
+		}
+	}
+	var calls int32
+	updated := make(chan time.Time, 10)
+	go batchUpdates(ctx, signals, delay, interval, func(context.Context) {
+		atomic.AddInt32(&calls, 1)
+		updated <- time.Now()
+	})
+
+	// Many changes in a quick succession are applied in one batch
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		signal()
+		time.Sleep(time.Millisecond)
+	}
+	first := <-updated
+	assert.GreaterOrEqual(t, first.Sub(start), delay)
+	time.Sleep(2 * delay)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// The next batch is applied no sooner than the interval after the previous one
+	signal()
+	second := <-updated
+	assert.GreaterOrEqual(t, second.Sub(first), interval)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,64 @@
+package daemon
+
+import (
+	"context"
+	"net"
+
+	"golang.org/x/net/ipv6"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/routing"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/buffer"
+)
+
+// tunnelOverhead is the number of bytes that the tunnel to the cluster adds to each packet that is
+// routed to it: an IPv6 header, a TCP header with the timestamp option, an HTTP/2 frame header, and
+// the prefix of a gRPC message.
+const tunnelOverhead = ipv6.HeaderLen + 32 + 9 + 5
+
+// tunMTU returns the MTU of the TUN device. A configured MTU is used as is. Otherwise, the default
+// MTU is used, unless the given MTU of the interface that carries the tunnel, less the overhead of
+// the tunnel, is smaller. Packets that don't fit that interface would otherwise be lost when they
+// pass through a VPN that doesn't report them as too big. The second return value is true when the
+// interface MTU limited the result.
+func tunMTU(configured, underlying int) (int, bool) {
+	if configured > 0 {
+		return configured, false
+	}
+	mtu := buffer.DataPool.MTU
+	if underlying <= 0 || underlying-tunnelOverhead >= mtu {
+		return mtu, false
+	}
+	mtu = underlying - tunnelOverhead
+	if mtu < vif.MinMTU {
+		mtu = vif.MinMTU
+	}
+	return mtu, true
+}
+
+// configureMTU sets the MTU of the TUN device. The MTU can't be changed once the device is
+// routing traffic, so it is set once, when the first session is established.
+func (t *tunRouter) configureMTU(ctx context.Context, configured int32) {
+	var underlying int
+	var ifName string
+	if ifaces, err := net.Interfaces(); err != nil {
+		dlog.Errorf(ctx, "unable to list the network interfaces, the MTU of the TUN device will not be limited: %v", err)
+	} else if table, err := getRoutingTable(ctx); err != nil {
+		dlog.Errorf(ctx, "unable to read the routing table, the MTU of the TUN device will not be limited: %v", err)
+	} else {
+		underlying, ifName = routing.DefaultRouteMTU(table, ifaces, t.ownInterfaces()...)
+	}
+
+	mtu, limited := tunMTU(int(configured), underlying)
+	if limited {
+		dlog.Infof(ctx, "Setting MTU of the TUN device to %d, limited by the MTU %d of %s", mtu, underlying, ifName)
+		t.mtuLimitedBy = ifName
+	} else {
+		dlog.Infof(ctx, "Setting MTU of the TUN device to %d", mtu)
+	}
+	t.mtu = configured
+	if err := t.dev.SetMTU(mtu); err != nil {
+		dlog.Errorf(ctx, "unable to set the MTU of the TUN device: %v", err)
+	}
+}
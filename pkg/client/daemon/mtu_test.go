@@ -0,0 +1,35 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTunMTU(t *testing.T) {
+	tests := []struct {
+		name        string
+		configured  int
+		underlying  int
+		want        int
+		wantLimited bool
+	}{
+		{name: "unknown interface MTU", want: 1500},
+		{name: "jumbo frames", underlying: 9000, want: 1500},
+		{name: "ethernet", underlying: 1500, want: 1500 - tunnelOverhead, wantLimited: true},
+		{name: "large enough", underlying: 1500 + tunnelOverhead, want: 1500},
+		{name: "WireGuard", underlying: 1420, want: 1420 - tunnelOverhead, wantLimited: true},
+		{name: "Cisco AnyConnect", underlying: 1390, want: 1390 - tunnelOverhead, wantLimited: true},
+		{name: "below the IPv6 minimum", underlying: 1280, want: 1280, wantLimited: true},
+		{name: "configured", configured: 1400, underlying: 1420, want: 1400},
+		{name: "configured above the limit", configured: 1500, underlying: 1420, want: 1500},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mtu, limited := tunMTU(tt.configured, tt.underlying)
+			assert.Equal(t, tt.want, mtu)
+			assert.Equal(t, tt.wantLimited, limited)
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+	"github.com/telepresenceio/telepresence/v2/pkg/routing"
+	"github.com/telepresenceio/telepresence/v2/pkg/subnet"
+)
+
+// netState is the record of the changes that the daemon has made to the network configuration of
+// the host. The meaning of a DNS entry is platform specific, e.g. the path of a resolver file on
+// macOS or the name of an iptables chain on Linux. A route is a "<subnet> via <gateway>" route
+// that doesn't use the interface.
+type netState struct {
+	Interface string   `json:"interface"`
+	Subnets   []string `json:"subnets,omitempty"`
+	Routes    []string `json:"routes,omitempty"`
+	DNS       []string `json:"dns,omitempty"`
+}
+
+// netStateFile keeps the netState of a running daemon in the client.DaemonNetworkStateFile so
+// that a daemon that didn't terminate gracefully can be cleaned up after. All methods are no-ops
+// on a nil netStateFile.
+type netStateFile struct {
+	sync.Mutex
+	path  string
+	state netState
+}
+
+func newNetStateFile(ctx context.Context, iface string) *netStateFile {
+	s := &netStateFile{path: client.DaemonNetworkStateFile(ctx), state: netState{Interface: iface}}
+	s.save(ctx)
+	return s
+}
+
+func (s *netStateFile) addSubnet(ctx context.Context, sn *net.IPNet) {
+	s.update(ctx, func(st *netState) { st.Subnets = addEntry(st.Subnets, sn.String()) })
+}
+
+func (s *netStateFile) removeSubnet(ctx context.Context, sn *net.IPNet) {
+	s.update(ctx, func(st *netState) { st.Subnets = removeEntry(st.Subnets, sn.String()) })
+}
+
+func (s *netStateFile) addRoute(ctx context.Context, route string) {
+	s.update(ctx, func(st *netState) { st.Routes = addEntry(st.Routes, route) })
+}
+
+func (s *netStateFile) removeRoute(ctx context.Context, route string) {
+	s.update(ctx, func(st *netState) { st.Routes = removeEntry(st.Routes, route) })
+}
+
+func (s *netStateFile) addDNS(ctx context.Context, change string) {
+	s.update(ctx, func(st *netState) { st.DNS = addEntry(st.DNS, change) })
+}
+
+func (s *netStateFile) removeDNS(ctx context.Context, change string) {
+	s.update(ctx, func(st *netState) { st.DNS = removeEntry(st.DNS, change) })
+}
+
+func (s *netStateFile) update(ctx context.Context, f func(*netState)) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	f(&s.state)
+	s.save(ctx)
+}
+
+// save writes the state to a temporary file which is then renamed, so that a crash never leaves
+// a truncated file behind.
+func (s *netStateFile) save(ctx context.Context) {
+	data, err := json.Marshal(&s.state)
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(s.path), 0755); err == nil {
+			tmp := s.path + ".tmp"
+			if err = os.WriteFile(tmp, data, 0644); err == nil {
+				err = os.Rename(tmp, s.path)
+			}
+		}
+	}
+	if err != nil {
+		dlog.Errorf(ctx, "unable to record the network state in %s: %v", s.path, err)
+	}
+}
+
+// remove removes the file. It's called when the daemon terminates gracefully, at which point all
+// recorded changes have been reverted.
+func (s *netStateFile) remove(ctx context.Context) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		dlog.Errorf(ctx, "unable to remove %s: %v", s.path, err)
+	}
+}
+
+func addEntry(entries []string, entry string) []string {
+	for _, e := range entries {
+		if e == entry {
+			return entries
+		}
+	}
+	return append(entries, entry)
+}
+
+func removeEntry(entries []string, entry string) []string {
+	for i, e := range entries {
+		if e == entry {
+			return append(entries[:i:i], entries[i+1:]...)
+		}
+	}
+	return entries
+}
+
+// interfaceHasSubnet returns true if the given interface exists and has an address in the given subnet
+// with the mask of the subnet.
+func interfaceHasSubnet(iface string, subnet *net.IPNet) bool {
+	ifc, err := net.InterfaceByName(iface)
+	if err != nil {
+		return false
+	}
+	addrs, err := ifc.Addrs()
+	if err != nil {
+		return false
+	}
+	ones, bits := subnet.Mask.Size()
+	for _, a := range addrs {
+		if ipn, ok := a.(*net.IPNet); ok && subnet.Contains(ipn.IP) {
+			if o, b := ipn.Mask.Size(); o == ones && b == bits {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseGatewayRoute parses a "<subnet> via <gateway>" route.
+func parseGatewayRoute(route string) (*net.IPNet, net.IP, error) {
+	parts := strings.Split(route, " via ")
+	if len(parts) != 2 {
+		return nil, nil, errors.New("not a \"<subnet> via <gateway>\" route")
+	}
+	_, sn, err := net.ParseCIDR(parts[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	gw := net.ParseIP(parts[1])
+	if gw == nil {
+		return nil, nil, fmt.Errorf("invalid gateway %q", parts[1])
+	}
+	return sn, gw, nil
+}
+
+// removeGatewayRoute removes a route that was added by refreshGatewayRoutesLocked. Such routes don't
+// vanish with the interface, but they do vanish with the docker network of the gateway.
+func (systemReverter) removeGatewayRoute(ctx context.Context, sn *net.IPNet, gateway net.IP) error {
+	if table, err := routing.GetRoutingTable(ctx); err == nil {
+		found := false
+		for _, r := range table {
+			if r.Gateway.Equal(gateway) && subnet.Equal(r.RoutedNet, sn) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("route %s via %s: %w", sn, gateway, os.ErrNotExist)
+		}
+	}
+	return routing.RemoveGatewayRoute(ctx, sn, gateway)
+}
+
+// netReverter reverts the changes recorded in a netState. Its methods return an error that wraps
+// os.ErrNotExist when the change no longer exists, e.g. because the interface is gone.
+type netReverter interface {
+	removeSubnet(ctx context.Context, iface string, subnet *net.IPNet) error
+	removeGatewayRoute(ctx context.Context, subnet *net.IPNet, gateway net.IP) error
+	revertDNS(ctx context.Context, iface, change string) error
+}
+
+// leftoverReverter is a variable so that tests can verify the cleanup without touching the host.
+var leftoverReverter netReverter = systemReverter{}
+
+// cleanupLeftovers reverts the changes recorded in the given state file by a daemon that didn't
+// terminate gracefully, and removes the file. Nothing is done when the file doesn't exist, and a
+// file that wasn't written by a daemon is discarded.
+func cleanupLeftovers(ctx context.Context, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !writtenByDaemon(path) {
+		dlog.Errorf(ctx, "discarding network state in %s that wasn't written by the %s", path, titleName)
+		return os.Remove(path)
+	}
+	var st netState
+	if err = json.Unmarshal(data, &st); err != nil {
+		dlog.Errorf(ctx, "discarding unreadable network state in %s: %v", path, err)
+		return os.Remove(path)
+	}
+	dlog.Infof(ctx, "Cleaning up the network state left behind by a previous daemon using interface %s", st.Interface)
+
+	failures := 0
+	report := func(what string, err error) {
+		switch {
+		case err == nil:
+			dlog.Infof(ctx, "Reverted %s", what)
+		case errors.Is(err, os.ErrNotExist):
+			dlog.Debugf(ctx, "%s no longer exists", what)
+		default:
+			failures++
+			dlog.Errorf(ctx, "unable to revert %s: %v", what, err)
+		}
+	}
+	for _, change := range st.DNS {
+		report("DNS change "+change, leftoverReverter.revertDNS(ctx, st.Interface, change))
+	}
+	for _, s := range st.Subnets {
+		_, sn, err := net.ParseCIDR(s)
+		if err != nil {
+			dlog.Errorf(ctx, "discarding invalid subnet %q: %v", s, err)
+			continue
+		}
+		report("route to subnet "+s, leftoverReverter.removeSubnet(ctx, st.Interface, sn))
+	}
+	for _, r := range st.Routes {
+		sn, gw, err := parseGatewayRoute(r)
+		if err != nil {
+			dlog.Errorf(ctx, "discarding invalid route %q: %v", r, err)
+			continue
+		}
+		report("route "+r, leftoverReverter.removeGatewayRoute(ctx, sn, gw))
+	}
+	// The file is removed even when some changes couldn't be reverted, because a retry is unlikely
+	// to succeed, and the file will be replaced by the next daemon anyway.
+	if err = os.Remove(path); err != nil {
+		return err
+	}
+	if failures > 0 {
+		return fmt.Errorf("failed to revert %d of the changes recorded in %s", failures, path)
+	}
+	return nil
+}
+
+// CleanupCommand returns the telepresence sub-command that reverts the network changes left
+// behind by a root daemon that didn't terminate gracefully. The CLI runs it with elevated
+// privileges when it stops the daemons.
+func CleanupCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:    client.DaemonCleanupCommand,
+		Short:  "Revert the network changes left behind by a Telepresence " + titleName + " that crashed",
+		Args:   cobra.NoArgs,
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if !proc.IsAdmin() {
+				return fmt.Errorf("telepresence %s must run with elevated privileges", client.DaemonCleanupCommand)
+			}
+			c := cmd.Context()
+			return cleanupLeftovers(c, client.DaemonNetworkStateFile(c))
+		},
+	}
+}
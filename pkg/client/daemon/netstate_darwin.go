@@ -0,0 +1,46 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/daemon/dns"
+)
+
+type systemReverter struct{}
+
+// utunNameRx matches the names of the utun interfaces that vif.OpenTun creates.
+var utunNameRx = regexp.MustCompile(`^utun\d+$`)
+
+// removeSubnet removes the route that vif.Device.AddSubnet added. The utun interface, and with it
+// the route, is normally gone when the daemon that created it is gone.
+func (systemReverter) removeSubnet(ctx context.Context, iface string, subnet *net.IPNet) error {
+	if !interfaceHasSubnet(iface, subnet) {
+		return fmt.Errorf("subnet %s on interface %s: %w", subnet, iface, os.ErrNotExist)
+	}
+	if !utunNameRx.MatchString(iface) {
+		return fmt.Errorf("interface %s isn't a utun interface created by telepresence", iface)
+	}
+	return dexec.CommandContext(ctx, "route", "-n", "delete", "-net", subnet.String(), "-interface", iface).Run()
+}
+
+// revertDNS removes a file that was added to the /etc/resolver directory, unless it has since been
+// replaced by a file that isn't generated by telepresence.
+func (systemReverter) revertDNS(ctx context.Context, _, change string) error {
+	data, err := os.ReadFile(change)
+	if err != nil {
+		return err
+	}
+	if !isResolverFileOwned(data) {
+		return fmt.Errorf("%s is no longer generated by telepresence: %w", change, os.ErrNotExist)
+	}
+	if err = os.Remove(change); err != nil {
+		return err
+	}
+	dns.Flush(ctx)
+	return nil
+}
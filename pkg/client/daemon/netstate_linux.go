@@ -0,0 +1,54 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/datawire/dlib/dexec"
+)
+
+type systemReverter struct{}
+
+// tunNameRx matches the names that the kernel generates from the "tel%d" template that vif.OpenTun uses.
+var tunNameRx = regexp.MustCompile(`^tel\d+$`)
+
+// isDaemonInterface returns true if the given interface is a TUN device with a name that the root
+// daemon would give it. Other interfaces are never touched.
+func isDaemonInterface(iface string) bool {
+	if !tunNameRx.MatchString(iface) {
+		return false
+	}
+	_, err := os.Stat(filepath.Join("/sys/class/net", iface, "tun_flags"))
+	return err == nil
+}
+
+// removeSubnet removes the address that vif.Device.AddSubnet assigned to the interface, and with
+// it, the route. The interface is normally gone when the daemon that created it is gone.
+func (systemReverter) removeSubnet(ctx context.Context, iface string, subnet *net.IPNet) error {
+	if !interfaceHasSubnet(iface, subnet) {
+		return fmt.Errorf("subnet %s on interface %s: %w", subnet, iface, os.ErrNotExist)
+	}
+	if !isDaemonInterface(iface) {
+		return fmt.Errorf("interface %s isn't a TUN device created by telepresence", iface)
+	}
+	return dexec.CommandContext(ctx, "ip", "a", "del", subnet.String(), "dev", iface).Run()
+}
+
+// revertDNS removes the iptables chain installed by routeDNS. DNS configured using
+// systemd-resolved is bound to the interface and doesn't need to be reverted.
+func (systemReverter) revertDNS(ctx context.Context, _, change string) error {
+	if change != tpDNSChain {
+		return fmt.Errorf("unknown DNS change %q", change)
+	}
+	cmd := dexec.CommandContext(ctx, "iptables", "-t", "nat", "-n", "-L", tpDNSChain)
+	cmd.DisableLogging = true
+	if cmd.Run() != nil {
+		return fmt.Errorf("iptables chain %s: %w", tpDNSChain, os.ErrNotExist)
+	}
+	unrouteDNS(ctx)
+	return nil
+}
@@ -0,0 +1,178 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// fakeReverter records the calls and reports the changes in gone as no longer existing and the
+// changes in broken as failing.
+type fakeReverter struct {
+	calls  []string
+	gone   map[string]bool
+	broken map[string]bool
+}
+
+func (f *fakeReverter) result(call, entry string) error {
+	f.calls = append(f.calls, call)
+	switch {
+	case f.gone[entry]:
+		return fmt.Errorf("%s: %w", entry, os.ErrNotExist)
+	case f.broken[entry]:
+		return errors.New("permission denied")
+	}
+	return nil
+}
+
+func (f *fakeReverter) removeSubnet(_ context.Context, iface string, subnet *net.IPNet) error {
+	return f.result("removeSubnet "+iface+" "+subnet.String(), subnet.String())
+}
+
+func (f *fakeReverter) removeGatewayRoute(_ context.Context, subnet *net.IPNet, gateway net.IP) error {
+	route := subnet.String() + " via " + gateway.String()
+	return f.result("removeGatewayRoute "+route, route)
+}
+
+func (f *fakeReverter) revertDNS(_ context.Context, iface, change string) error {
+	return f.result("revertDNS "+iface+" "+change, change)
+}
+
+func withFakeReverter(t *testing.T, f *fakeReverter) {
+	old := leftoverReverter
+	leftoverReverter = f
+	t.Cleanup(func() { leftoverReverter = old })
+}
+
+func Test_cleanupLeftovers(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	path := filepath.Join(t.TempDir(), "daemon-network.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+  "interface": "tel0",
+  "subnets": ["10.96.0.0/12", "10.244.0.0/16", "fd00:10:96::/112"],
+  "routes": ["10.43.0.0/16 via 172.19.0.3", "10.42.1.0/24 via 172.19.0.4", "bogus"],
+  "dns": ["/etc/resolver/telepresence.local", "/etc/resolver/telepresence.default.local"]
+}`), 0600))
+
+	f := &fakeReverter{gone: map[string]bool{
+		"10.42.1.0/24 via 172.19.0.4":              true,
+		"10.244.0.0/16":                            true,
+		"/etc/resolver/telepresence.default.local": true,
+	}}
+	withFakeReverter(t, f)
+	require.NoError(t, cleanupLeftovers(ctx, path))
+	assert.Equal(t, []string{
+		"revertDNS tel0 /etc/resolver/telepresence.local",
+		"revertDNS tel0 /etc/resolver/telepresence.default.local",
+		"removeSubnet tel0 10.96.0.0/12",
+		"removeSubnet tel0 10.244.0.0/16",
+		"removeSubnet tel0 fd00:10:96::/112",
+		"removeGatewayRoute 10.43.0.0/16 via 172.19.0.3",
+		"removeGatewayRoute 10.42.1.0/24 via 172.19.0.4",
+	}, f.calls)
+	assert.NoFileExists(t, path)
+
+	// Nothing to do when there is no file
+	f.calls = nil
+	require.NoError(t, cleanupLeftovers(ctx, path))
+	assert.Empty(t, f.calls)
+}
+
+func Test_cleanupLeftovers_failure(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	path := filepath.Join(t.TempDir(), "daemon-network.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"interface":"tel0","subnets":["10.96.0.0/12","10.244.0.0/16"],"dns":["telepresence-dns"]}`), 0600))
+
+	f := &fakeReverter{broken: map[string]bool{"10.96.0.0/12": true}}
+	withFakeReverter(t, f)
+	err := cleanupLeftovers(ctx, path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to revert 1 of the changes")
+
+	// The failure doesn't prevent the remaining changes from being reverted
+	assert.Equal(t, []string{
+		"revertDNS tel0 telepresence-dns",
+		"removeSubnet tel0 10.96.0.0/12",
+		"removeSubnet tel0 10.244.0.0/16",
+	}, f.calls)
+	assert.NoFileExists(t, path)
+}
+
+func Test_cleanupLeftovers_unreadable(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	path := filepath.Join(t.TempDir(), "daemon-network.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"interface":"tel0","sub`), 0600))
+
+	f := &fakeReverter{}
+	withFakeReverter(t, f)
+	require.NoError(t, cleanupLeftovers(ctx, path))
+	assert.Empty(t, f.calls)
+	assert.NoFileExists(t, path)
+}
+
+func Test_cleanupLeftovers_writableByOthers(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes don't control who can write to a file on Windows")
+	}
+	ctx := dlog.NewTestContext(t, false)
+	path := filepath.Join(t.TempDir(), "daemon-network.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"interface":"eth0","subnets":["10.0.0.0/8"]}`), 0600))
+	require.NoError(t, os.Chmod(path, 0666))
+
+	f := &fakeReverter{}
+	withFakeReverter(t, f)
+	require.NoError(t, cleanupLeftovers(ctx, path))
+	assert.Empty(t, f.calls, "nothing recorded in a file that others can write to is reverted")
+	assert.NoFileExists(t, path)
+}
+
+func Test_netStateFile(t *testing.T) {
+	ctx := filelocation.WithAppSystemRuntimeDir(dlog.NewTestContext(t, false), t.TempDir())
+	path := client.DaemonNetworkStateFile(ctx)
+
+	_, sn1, _ := net.ParseCIDR("10.96.0.0/12")
+	_, sn2, _ := net.ParseCIDR("10.244.0.0/16")
+	s := newNetStateFile(ctx, "tel0")
+	s.addSubnet(ctx, sn1)
+	s.addSubnet(ctx, sn2)
+	s.addSubnet(ctx, sn1)
+	s.addDNS(ctx, "telepresence-dns")
+	s.removeSubnet(ctx, sn1)
+	s.addRoute(ctx, "10.42.0.0/24 via 172.18.0.2")
+	s.addRoute(ctx, "10.96.0.0/12 via 172.18.0.2")
+	s.removeRoute(ctx, "10.42.0.0/24 via 172.18.0.2")
+
+	// What a crashed daemon leaves behind is what a new daemon reverts
+	f := &fakeReverter{}
+	withFakeReverter(t, f)
+	require.NoError(t, cleanupLeftovers(ctx, path))
+	assert.Equal(t, []string{
+		"revertDNS tel0 telepresence-dns",
+		"removeSubnet tel0 10.244.0.0/16",
+		"removeGatewayRoute 10.96.0.0/12 via 172.18.0.2",
+	}, f.calls)
+
+	// A daemon that terminates gracefully leaves nothing behind
+	s = newNetStateFile(ctx, "tel0")
+	s.addSubnet(ctx, sn1)
+	assert.FileExists(t, path)
+	s.remove(ctx)
+	assert.NoFileExists(t, path)
+
+	// A nil netStateFile is a no-op
+	var ns *netStateFile
+	ns.addSubnet(ctx, sn1)
+	ns.remove(ctx)
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package daemon
+
+import (
+	"os"
+	"syscall"
+)
+
+// writtenByDaemon returns true if the given file is owned by the user that runs this process, i.e. by
+// root, and others can't write to it.
+func writtenByDaemon(path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && int(st.Uid) == os.Getuid() && fi.Mode().Perm()&0022 == 0
+}
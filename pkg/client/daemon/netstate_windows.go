@@ -0,0 +1,74 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/sys/windows"
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
+)
+
+// dnsOnInterface is the DNS change recorded when the DNS of the TUN device is configured.
+const dnsOnInterface = "interface"
+
+type systemReverter struct{}
+
+// daemonInterface is the name of the Wintun adapter that vif.OpenTun creates.
+const daemonInterface = "tel0"
+
+// interfaceLUID returns the LUID of the given interface. Only the interface created by the root daemon
+// is ever touched.
+func interfaceLUID(iface string) (winipcfg.LUID, error) {
+	if iface != daemonInterface {
+		return 0, fmt.Errorf("interface %s isn't the TUN device created by telepresence", iface)
+	}
+	ifc, err := net.InterfaceByName(iface)
+	if err != nil {
+		return 0, fmt.Errorf("interface %s: %w", iface, os.ErrNotExist)
+	}
+	return winipcfg.LUIDFromIndex(uint32(ifc.Index))
+}
+
+// writtenByDaemon returns true if the given file is owned by the local system account or the
+// administrators, i.e. if it was written by the root daemon.
+func writtenByDaemon(path string) bool {
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.OWNER_SECURITY_INFORMATION)
+	if err != nil {
+		return false
+	}
+	owner, _, err := sd.Owner()
+	if err != nil {
+		return false
+	}
+	return owner.IsWellKnown(windows.WinLocalSystemSid) || owner.IsWellKnown(windows.WinBuiltinAdministratorsSid)
+}
+
+// removeSubnet removes the address that vif.Device.AddSubnet assigned to the interface, and with
+// it, the route. The interface is normally gone when the daemon that created it is gone.
+func (systemReverter) removeSubnet(_ context.Context, iface string, subnet *net.IPNet) error {
+	if !interfaceHasSubnet(iface, subnet) {
+		return fmt.Errorf("subnet %s on interface %s: %w", subnet, iface, os.ErrNotExist)
+	}
+	luid, err := interfaceLUID(iface)
+	if err != nil {
+		return err
+	}
+	return luid.DeleteIPAddress(*subnet)
+}
+
+// revertDNS removes the DNS servers and search domains from the interface.
+func (systemReverter) revertDNS(_ context.Context, iface, change string) error {
+	if change != dnsOnInterface {
+		return fmt.Errorf("unknown DNS change %q", change)
+	}
+	luid, err := interfaceLUID(iface)
+	if err != nil {
+		return err
+	}
+	if err = luid.FlushDNS(windows.AF_INET); err != nil {
+		return err
+	}
+	return luid.FlushDNS(windows.AF_INET6)
+}
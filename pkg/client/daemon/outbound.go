@@ -4,20 +4,27 @@ import (
 	"context"
 	"math/rand"
 	"net"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	dns2 "github.com/miekg/dns"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dlog"
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/daemon/dns"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/scout"
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 )
 
 type awaitLookupResult struct {
@@ -42,11 +49,38 @@ type outbound struct {
 
 	searchPathCh chan []string
 
+	// sessionPaths are the search paths, and sessionNamespaces the mapped namespaces, of each session of
+	// the user daemon, keyed by session name. The paths of all sessions are merged into the ones that are
+	// sent to the searchPathCh.
+	sessionPaths      map[string][]string
+	sessionNamespaces map[string]map[string]struct{}
+	sessionPathsLock  sync.Mutex
+
+	// dnsReapplyCh requests that the current search paths are applied again, e.g. after a network
+	// change that may have reset the DNS configuration of the OS.
+	dnsReapplyCh chan struct{}
+
 	// dnsQueriesInProgress unique set of DNS queries currently in progress.
 	dnsInProgress  map[string]*awaitLookupResult
 	dnsQueriesLock sync.Mutex
 
 	dnsConfig *rpc.DNSConfig
+	dnsCache  *dnsCache
+
+	// headless are the records of the headless services in the mapped namespaces of all sessions,
+	// merged from the sessionHeadless records of each session.
+	headless        *headlessRecords
+	sessionHeadless map[string]*headlessRecords
+	headlessLock    sync.RWMutex
+
+	// dnsListener and dnsForwarder are the addresses of the local DNS server and of the DNS server
+	// that it forwards to, when the local DNS server isn't configured on the TUN device.
+	dnsListener  net.Addr
+	dnsForwarder net.Addr
+
+	// dnsMode is the mode in which the local DNS server is hooked into the resolver of the host.
+	dnsMode      string
+	dnsAddrsLock sync.RWMutex
 
 	scout chan<- scout.ScoutReport
 }
@@ -67,13 +101,18 @@ func newOutbound(c context.Context, dnsIPStr string, noSearch bool, scout chan<-
 		dnsConfig: &rpc.DNSConfig{
 			LocalIp: iputil.Parse(dnsIPStr),
 		},
-		noSearch:      noSearch,
-		namespaces:    make(map[string]struct{}),
-		domains:       make(map[string]struct{}),
-		dnsInProgress: make(map[string]*awaitLookupResult),
-		search:        []string{""},
-		searchPathCh:  make(chan []string, 5),
-		scout:         scout,
+		noSearch:          noSearch,
+		namespaces:        make(map[string]struct{}),
+		domains:           make(map[string]struct{}),
+		dnsInProgress:     make(map[string]*awaitLookupResult),
+		dnsCache:          newDNSCache(),
+		search:            []string{""},
+		searchPathCh:      make(chan []string, 5),
+		sessionPaths:      make(map[string][]string),
+		sessionNamespaces: make(map[string]map[string]struct{}),
+		sessionHeadless:   make(map[string]*headlessRecords),
+		dnsReapplyCh:      make(chan struct{}, 1),
+		scout:             scout,
 	}
 
 	var err error
@@ -91,30 +130,144 @@ func newOutbound(c context.Context, dnsIPStr string, noSearch bool, scout chan<-
 const tel2SubDomain = "tel2-search"
 const tel2SubDomainDot = tel2SubDomain + "."
 
+// The modes in which the local DNS server is hooked into the resolver of the host, as reported by the
+// status of the daemon.
+const (
+	// dnsModeResolverFiles is the macOS mode that places one file for each domain under /etc/resolver.
+	dnsModeResolverFiles = "resolver files"
+
+	// dnsModeSearchPath is the macOS mode that places a file that declares the search path of the
+	// resolver under /etc/resolver. It's used when the resolver files can't be written.
+	dnsModeSearchPath = "search path"
+
+	// dnsModeResolved is the Linux mode that configures systemd-resolved to use the local DNS server
+	// for the TUN device.
+	dnsModeResolved = "systemd-resolved"
+
+	// dnsModeOverriding is the Linux mode that redirects all DNS queries to the local DNS server.
+	dnsModeOverriding = "overriding"
+
+	// dnsModeInterface is the Windows mode that configures the local DNS server on the TUN device.
+	dnsModeInterface = "interface"
+)
+
 var localhostIPv6 = []net.IP{{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}}
 var localhostIPv4 = []net.IP{{127, 0, 0, 1}}
 
+// shouldDoClusterLookup returns true if the given lower case query (with trailing dot) should be
+// resolved in the cluster.
 func (o *outbound) shouldDoClusterLookup(query string) bool {
+	// Configured suffixes take precedence over all other rules. When both an included and an excluded
+	// suffix match, the longest one wins, so that a subdomain of an excluded domain can be included
+	// and vice versa. An include wins over an exclude of the same length.
+	name := query[:len(query)-1] // skip last dot
+	include := longestSuffixMatch(name, o.dnsConfig.IncludeSuffixes)
+	exclude := longestSuffixMatch(name, o.dnsConfig.ExcludeSuffixes)
+	if include >= 0 && include >= exclude {
+		return true
+	}
+	if exclude >= 0 {
+		return false
+	}
+
 	if strings.HasSuffix(query, "."+o.router.clusterDomain) && strings.Count(query, ".") < 4 {
 		return false
 	}
 
-	query = query[:len(query)-1] // skip last dot
+	// Skip names that belong to namespaces that aren't mapped so that they fall through to the
+	// normal resolver.
+	if ns := o.namespaceOf(name); ns != "" && !o.isMappedNamespace(ns) {
+		return false
+	}
+	return true
+}
 
-	// Always include configured includeSuffixes
-	for _, sfx := range o.dnsConfig.IncludeSuffixes {
-		if strings.HasSuffix(query, sfx) {
-			return true
+// longestSuffixMatch returns the length of the longest of the given normalized suffixes that the name
+// ends with, or -1 if no suffix matches. A suffix only matches whole labels, so ".example.com" matches
+// "example.com" and "www.example.com" but not "myexample.com".
+func longestSuffixMatch(name string, sfxs []string) int {
+	name = "." + name
+	longest := -1
+	for _, sfx := range sfxs {
+		if len(sfx) > longest && strings.HasSuffix(name, sfx) {
+			longest = len(sfx)
 		}
 	}
+	return longest
+}
 
-	// Skip configured excludeSuffixes
-	for _, sfx := range o.dnsConfig.ExcludeSuffixes {
-		if strings.HasSuffix(query, sfx) {
-			return false
+// normalizeSuffixes returns the given suffixes in lower case, with a leading dot, and without a trailing
+// dot, so that "Example.COM", ".example.com", and "example.com." are equal. Empty and duplicate entries are
+// dropped.
+func normalizeSuffixes(sfxs []string) []string {
+	normalized := make([]string, 0, len(sfxs))
+	seen := make(map[string]struct{}, len(sfxs))
+	for _, sfx := range sfxs {
+		sfx = strings.Trim(strings.ToLower(strings.TrimSpace(sfx)), ".")
+		if sfx == "" {
+			continue
+		}
+		sfx = "." + sfx
+		if _, ok := seen[sfx]; !ok {
+			seen[sfx] = struct{}{}
+			normalized = append(normalized, sfx)
 		}
 	}
-	return true
+	return normalized
+}
+
+// normalizeDNSConfig normalizes the suffixes of the given config and assigns defaults to the exclude
+// suffixes, the lookup timeout, and the cache TTLs when they are not set.
+func normalizeDNSConfig(cfg *rpc.DNSConfig) {
+	cfg.IncludeSuffixes = normalizeSuffixes(cfg.IncludeSuffixes)
+	cfg.ExcludeSuffixes = normalizeSuffixes(cfg.ExcludeSuffixes)
+	if len(cfg.ExcludeSuffixes) == 0 {
+		cfg.ExcludeSuffixes = []string{
+			".arpa",
+			".com",
+			".io",
+			".net",
+			".org",
+			".ru",
+		}
+	}
+	if cfg.LookupTimeout.AsDuration() <= 0 {
+		cfg.LookupTimeout = durationpb.New(4 * time.Second)
+	}
+	if cfg.NegativeCacheTtl.AsDuration() <= 0 {
+		cfg.NegativeCacheTtl = durationpb.New(3 * time.Second)
+	}
+	if cfg.MaxTtl.AsDuration() <= 0 {
+		cfg.MaxTtl = durationpb.New(dns.RecordTTL * time.Second)
+	}
+}
+
+// namespaceOf returns the namespace of a query (without trailing dot) of the form <name>.<namespace>
+// or <name>.<namespace>.svc.<cluster domain>, or an empty string when the query has another form.
+func (o *outbound) namespaceOf(query string) string {
+	if cd := ".svc." + strings.TrimSuffix(o.router.clusterDomain, "."); strings.HasSuffix(query, cd) {
+		query = strings.TrimSuffix(query, cd)
+		if i := strings.LastIndexByte(query, '.'); i >= 0 {
+			return query[i+1:]
+		}
+		return ""
+	}
+	if parts := strings.Split(query, "."); len(parts) == 2 {
+		return parts[1]
+	}
+	return ""
+}
+
+// isMappedNamespace returns true if the given namespace is mapped, or if the set of mapped
+// namespaces is still unknown.
+func (o *outbound) isMappedNamespace(namespace string) bool {
+	o.domainsLock.RLock()
+	defer o.domainsLock.RUnlock()
+	if len(o.namespaces) == 0 {
+		return true
+	}
+	_, ok := o.namespaces[namespace]
+	return ok
 }
 
 func (o *outbound) resolveInCluster(c context.Context, qType uint16, query string) (results []net.IP) {
@@ -138,6 +291,9 @@ func (o *outbound) resolveInCluster(c context.Context, qType uint16, query strin
 	if !o.shouldDoClusterLookup(query) {
 		return nil
 	}
+	if ips := o.headlessRecords().lookupHost(query); len(ips) > 0 {
+		return ips
+	}
 	// Don't report queries that won't be resolved in-cluster, since that'll report every single DNS query on the user's machine
 	defer func() {
 		r := scout.ScoutReport{
@@ -153,6 +309,13 @@ func (o *outbound) resolveInCluster(c context.Context, qType uint16, query strin
 		}
 	}()
 
+	if ips, ok := o.dnsCache.get(query); ok {
+		if len(ips) == 0 {
+			return nil
+		}
+		return ips
+	}
+
 	var firstLookupResult *awaitLookupResult
 	o.dnsQueriesLock.Lock()
 	awaitResult := o.dnsInProgress[query]
@@ -173,6 +336,7 @@ func (o *outbound) resolveInCluster(c context.Context, qType uint16, query strin
 	}
 
 	// Give the cluster lookup a reasonable timeout.
+	pc := c
 	c, cancel := context.WithTimeout(c, o.dnsConfig.LookupTimeout.AsDuration())
 	defer func() {
 		cancel()
@@ -183,22 +347,38 @@ func (o *outbound) resolveInCluster(c context.Context, qType uint16, query strin
 	}()
 
 	queryWithNoTrailingDot := query[:len(query)-1]
+	s := o.sessionForQuery(queryWithNoTrailingDot)
+	if s == nil {
+		return nil
+	}
+	c, span := tracing.StartSpan(c, "dns lookup", trace.WithAttributes(attribute.String("query", queryWithNoTrailingDot)))
 	dlog.Debugf(c, "LookupHost %q", queryWithNoTrailingDot)
-	response, err := o.router.managerClient.LookupHost(c, &manager.LookupHostRequest{
-		Session: o.router.session,
+	response, err := s.managerClient.LookupHost(c, &manager.LookupHostRequest{
+		Session: s.getInfo(),
 		Host:    queryWithNoTrailingDot,
 	})
+	tracing.EndSpan(span, err)
 	if err != nil {
 		dlog.Error(c, client.CheckTimeout(c, err))
+		if pc.Err() == nil {
+			// The lookup failed or timed out on its own, so don't retry it until the negative TTL expires
+			o.dnsCache.put(query, nil, o.dnsConfig.NegativeCacheTtl.AsDuration())
+		}
 		return nil
 	}
 	if len(response.Ips) == 0 {
+		o.dnsCache.put(query, nil, o.dnsConfig.NegativeCacheTtl.AsDuration())
 		return nil
 	}
 	ips := make(iputil.IPs, len(response.Ips))
 	for i, ip := range response.Ips {
 		ips[i] = ip
 	}
+	ttl := o.dnsConfig.MaxTtl.AsDuration()
+	if recordTTL := dns.RecordTTL * time.Second; ttl > recordTTL {
+		ttl = recordTTL
+	}
+	o.dnsCache.put(query, ips, ttl)
 	firstLookupResult.result = ips
 	return ips
 }
@@ -210,21 +390,138 @@ func (o *outbound) setInfo(ctx context.Context, info *rpc.OutboundInfo) error {
 	if oldIP := o.dnsConfig.GetLocalIp(); len(oldIP) > 0 {
 		info.Dns.LocalIp = oldIP
 	}
-	if len(info.Dns.ExcludeSuffixes) == 0 {
-		info.Dns.ExcludeSuffixes = []string{
-			".arpa",
-			".com",
-			".io",
-			".net",
-			".org",
-			".ru",
+	if o.router.helperScope {
+		if err := checkOutboundInfoScope(info); err != nil {
+			return err
+		}
+	}
+	o.setDNSConfig(info.Dns)
+
+	// A new manager session for an existing session means that the connector replaced a broken
+	// session, typically after a laptop sleep or a network change.
+	replaced, err := o.router.setOutboundInfo(ctx, info)
+	if err != nil {
+		return err
+	}
+	if replaced {
+		o.reapplyDNS()
+	}
+	return nil
+}
+
+// sessionForQuery returns the session that the given query (without trailing dot) is looked up in. That's
+// the first session that maps the namespace of the query, or the first session when no session does.
+func (o *outbound) sessionForQuery(query string) *routerSession {
+	ss := o.router.getSessions()
+	if len(ss) > 1 {
+		if ns := o.namespaceOf(query); ns != "" {
+			o.sessionPathsLock.Lock()
+			defer o.sessionPathsLock.Unlock()
+			for _, s := range ss {
+				if _, ok := o.sessionNamespaces[s.name][ns]; ok {
+					return s
+				}
+			}
 		}
 	}
-	if info.Dns.LookupTimeout.AsDuration() <= 0 {
-		info.Dns.LookupTimeout = durationpb.New(4 * time.Second)
+	if len(ss) > 0 {
+		return ss[0]
+	}
+	return nil
+}
+
+// removeSession removes the given session from the router along with its search paths and headless
+// services.
+func (o *outbound) removeSession(ctx context.Context, name string) error {
+	// A session that failed to connect may have DNS search paths but no routes, so the paths are
+	// removed also when the router doesn't know the session.
+	err := o.router.removeSession(ctx, name)
+	o.sessionPathsLock.Lock()
+	delete(o.sessionPaths, name)
+	delete(o.sessionNamespaces, name)
+	paths := o.mergedSearchPaths()
+	o.sessionPathsLock.Unlock()
+
+	o.headlessLock.Lock()
+	delete(o.sessionHeadless, name)
+	o.headless = o.mergedHeadlessRecords()
+	o.headlessLock.Unlock()
+
+	o.dnsCache.flush()
+	select {
+	case <-ctx.Done():
+	case o.searchPathCh <- paths:
 	}
-	o.dnsConfig = info.Dns
-	return o.router.setOutboundInfo(ctx, info)
+	return err
+}
+
+// orderSessionNames orders the given session names like the sessions of the router. Names of sessions
+// that the router doesn't know yet come last, in alphabetical order.
+func (o *outbound) orderSessionNames(names []string) []string {
+	index := make(map[string]int)
+	for i, s := range o.router.getSessions() {
+		index[s.name] = i
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ii, iok := index[names[i]]
+		ji, jok := index[names[j]]
+		switch {
+		case iok && jok:
+			return ii < ji
+		case iok != jok:
+			return iok
+		default:
+			return names[i] < names[j]
+		}
+	})
+	return names
+}
+
+// reapplyDNS requests that the DNS configuration is applied again.
+func (o *outbound) reapplyDNS() {
+	select {
+	case o.dnsReapplyCh <- struct{}{}:
+	default:
+		// A request is already pending
+	}
+}
+
+// setDNSConfig normalizes and assigns the given config. The config is set for each new session, so
+// the results of lookups made in a previous session are discarded.
+func (o *outbound) setDNSConfig(cfg *rpc.DNSConfig) {
+	normalizeDNSConfig(cfg)
+	o.dnsConfig = cfg
+	o.dnsCache.flush()
+}
+
+// updateConfigSuffixes replaces the DNS suffixes of the previous client config with the ones of the current
+// client config. The suffixes that the kubeconfig extension of the cluster or the flags of the connect
+// added are retained.
+func (o *outbound) updateConfigSuffixes(previous, current *client.DNS) {
+	if len(o.router.getSessions()) == 0 {
+		// The suffixes are sent by the connector when it starts a session
+		return
+	}
+	cfg := proto.Clone(o.dnsConfig).(*rpc.DNSConfig)
+	cfg.IncludeSuffixes = replaceSuffixes(cfg.IncludeSuffixes, previous.IncludeSuffixes, current.IncludeSuffixes)
+	cfg.ExcludeSuffixes = replaceSuffixes(cfg.ExcludeSuffixes, previous.ExcludeSuffixes, current.ExcludeSuffixes)
+	o.setDNSConfig(cfg)
+	o.reapplyDNS()
+}
+
+// replaceSuffixes returns the given suffixes with the removed suffixes replaced by the added suffixes.
+func replaceSuffixes(sfxs, removed, added []string) []string {
+	drop := make(map[string]struct{}, len(removed))
+	for _, sfx := range normalizeSuffixes(removed) {
+		drop[sfx] = struct{}{}
+	}
+	kept := make([]string, 0, len(sfxs)+len(added))
+	for _, sfx := range sfxs {
+		if _, ok := drop[sfx]; !ok {
+			kept = append(kept, sfx)
+		}
+	}
+	return append(kept, added...)
 }
 
 func (o *outbound) getInfo() *rpc.OutboundInfo {
@@ -238,30 +535,148 @@ func (o *outbound) getInfo() *rpc.OutboundInfo {
 		info.Dns.ExcludeSuffixes = o.dnsConfig.ExcludeSuffixes
 		info.Dns.IncludeSuffixes = o.dnsConfig.IncludeSuffixes
 		info.Dns.LookupTimeout = o.dnsConfig.LookupTimeout
+		info.Dns.NegativeCacheTtl = o.dnsConfig.NegativeCacheTtl
+		info.Dns.MaxTtl = o.dnsConfig.MaxTtl
 	}
 
+	info.Mtu = o.router.mtu
+
+	o.router.subnetsLock.RLock()
 	if len(o.router.alsoProxySubnets) > 0 {
 		info.AlsoProxySubnets = make([]*manager.IPNet, len(o.router.alsoProxySubnets))
 		for i, ap := range o.router.alsoProxySubnets {
 			info.AlsoProxySubnets[i] = iputil.IPNetToRPC(ap)
 		}
 	}
+	if len(o.router.neverProxySubnets) > 0 {
+		info.NeverProxySubnets = make([]*manager.IPNet, len(o.router.neverProxySubnets))
+		for i, np := range o.router.neverProxySubnets {
+			info.NeverProxySubnets[i] = iputil.IPNetToRPC(np)
+		}
+	}
+	if len(o.router.allowConflictingSubnets) > 0 {
+		info.AllowConflictingSubnets = make([]*manager.IPNet, len(o.router.allowConflictingSubnets))
+		for i, ac := range o.router.allowConflictingSubnets {
+			info.AllowConflictingSubnets[i] = iputil.IPNetToRPC(ac)
+		}
+	}
+	o.router.subnetsLock.RUnlock()
 
+	for _, s := range o.router.getSessions() {
+		if s.mappedNamespacesOnly {
+			info.OutboundTrafficPolicy = client.OutboundTrafficPolicyMappedNamespaces
+			break
+		}
+	}
 	return &info
 }
 
-// SetSearchPath updates the DNS search path used by the resolver
-func (o *outbound) setSearchPath(ctx context.Context, paths, namespaces []string) {
+// getStatus returns the outbound info along with diagnostics about the TUN device, the routed subnets,
+// and the DNS resolver.
+func (o *outbound) getStatus() *rpc.DaemonStatus {
+	st := &rpc.DaemonStatus{
+		OutboundConfig:   o.getInfo(),
+		TunName:          o.router.dev.Name(),
+		RoutedSubnets:    o.router.routedSubnets(),
+		SubnetConflicts:  o.router.subnetConflictsToRPC(),
+		SessionConflicts: o.router.sessionConflictsToRPC(),
+		ManagedRoutes:    o.router.managedRoutes(),
+		GatewayRoutes:    o.router.gatewayRoutesToRPC(),
+		Connections:      o.router.connectionStatsToRPC(),
+	}
+	if la := o.router.getLastActivity(); !la.IsZero() {
+		st.LastActivity = timestamppb.New(la)
+	}
+	if iface, err := net.InterfaceByName(st.TunName); err == nil {
+		st.TunMtu = int32(iface.MTU)
+	}
+	st.TunMtuLimitedBy = o.router.mtuLimitedBy
+	if addr := o.router.dnsLocalAddr; addr != nil {
+		st.DnsListener = addr.String()
+	}
+	o.dnsAddrsLock.RLock()
+	if st.DnsListener == "" && o.dnsListener != nil {
+		st.DnsListener = o.dnsListener.String()
+	}
+	if o.dnsForwarder != nil {
+		st.DnsForwarder = o.dnsForwarder.String()
+	}
+	st.DnsMode = o.dnsMode
+	o.dnsAddrsLock.RUnlock()
+	o.domainsLock.RLock()
+	for _, sp := range o.search {
+		if sp != "" {
+			st.SearchPaths = append(st.SearchPaths, sp)
+		}
+	}
+	o.domainsLock.RUnlock()
+	return st
+}
+
+func (o *outbound) setDNSAddrs(listener, forwarder net.Addr) {
+	o.dnsAddrsLock.Lock()
+	o.dnsListener = listener
+	o.dnsForwarder = forwarder
+	o.dnsAddrsLock.Unlock()
+}
+
+func (o *outbound) setDNSMode(mode string) {
+	o.dnsAddrsLock.Lock()
+	o.dnsMode = mode
+	o.dnsAddrsLock.Unlock()
+}
+
+// SetSearchPath updates the DNS search path of the given session. The search paths of all sessions
+// are merged into the one used by the resolver.
+func (o *outbound) setSearchPath(ctx context.Context, sessionName string, paths, namespaces []string) {
+	mapped := make(map[string]struct{}, len(paths))
+	for _, path := range paths {
+		if !strings.ContainsRune(path, '.') {
+			mapped[path] = struct{}{}
+		}
+	}
+
 	// Provide direct access to intercepted namespaces
+	clusterDomain := o.router.clusterDomain
+	if s := o.router.getSession(sessionName); s != nil && s.clusterDomain != "" {
+		clusterDomain = s.clusterDomain
+	}
 	for _, ns := range namespaces {
-		paths = append(paths, ns+".svc."+o.router.clusterDomain)
+		paths = append(paths, ns+".svc."+clusterDomain)
 	}
+
+	o.sessionPathsLock.Lock()
+	o.sessionPaths[sessionName] = paths
+	o.sessionNamespaces[sessionName] = mapped
+	paths = o.mergedSearchPaths()
+	o.sessionPathsLock.Unlock()
+
 	select {
 	case <-ctx.Done():
 	case o.searchPathCh <- paths:
 	}
 }
 
+// mergedSearchPaths returns the unique search paths of all sessions. Must be called with the
+// sessionPathsLock held.
+func (o *outbound) mergedSearchPaths() []string {
+	var merged []string
+	seen := make(map[string]struct{})
+	names := make([]string, 0, len(o.sessionPaths))
+	for name := range o.sessionPaths {
+		names = append(names, name)
+	}
+	for _, name := range o.orderSessionNames(names) {
+		for _, path := range o.sessionPaths[name] {
+			if _, ok := seen[path]; !ok {
+				seen[path] = struct{}{}
+				merged = append(merged, path)
+			}
+		}
+	}
+	return merged
+}
+
 func (o *outbound) processSearchPaths(g *dgroup.Group, processor func(context.Context, []string) error) {
 	g.Go("SearchPaths", func(c context.Context) error {
 		var prevPaths []string
@@ -293,6 +708,17 @@ func (o *outbound) processSearchPaths(g *dgroup.Group, processor func(context.Co
 					if err := processor(c, paths); err != nil {
 						return err
 					}
+					// The mapped namespaces may have changed, and with them the names that resolve
+					o.dnsCache.flush()
+				}
+			case <-o.dnsReapplyCh:
+				if prevPaths != nil {
+					dlog.Infof(c, "Re-applying search paths %v", prevPaths)
+					paths := make([]string, len(prevPaths))
+					copy(paths, prevPaths)
+					if err := processor(c, paths); err != nil {
+						return err
+					}
 				}
 			}
 		}
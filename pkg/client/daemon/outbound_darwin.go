@@ -88,7 +88,7 @@ func readResolveFile(fileName string) (*resolveFile, error) {
 }
 
 func (r *resolveFile) write(fileName string) error {
-	buf := bytes.NewBufferString("# Generated by telepresence\n")
+	buf := bytes.NewBufferString(resolverFileHeader)
 	fmt.Fprintf(buf, "port %d\n", r.port)
 	if r.domain != "" {
 		fmt.Fprintf(buf, "domain %s\n", r.domain)
@@ -120,10 +120,14 @@ func (r *resolveFile) setSearchPaths(paths ...string) {
 	r.search = ps
 }
 
-// dnsServerWorker places a file under the /etc/resolver directory so that it is picked up by the
-// macOS resolver. The file is configured with a single nameserver that points to the local IP
-// that the Telepresence DNS server listens to. The file is removed, and the DNS is flushed when
-// the worker terminates
+// dnsServerWorker makes the macOS resolver send the queries for the cluster domain, the search domains,
+// the namespaces, and the included suffixes to the local DNS server by placing one file for each such
+// domain under the /etc/resolver directory. The files are removed, and the DNS is flushed when the
+// worker terminates.
+//
+// A VPN client that overrides the system resolver leaves those files alone, but writing them can still
+// fail. The worker then falls back to a file that declares the search path of the resolver, and one
+// file for each namespace.
 //
 // For more information about /etc/resolver files, please view the man pages available at
 //
@@ -144,33 +148,47 @@ func (o *outbound) dnsServerWorker(c context.Context) error {
 	}
 	o.router.configureDNS(c, dnsAddr)
 
-	err = os.MkdirAll(resolverDirName, 0755)
-	if err != nil {
-		return err
-	}
-
 	kubernetesZone := o.router.clusterDomain
 	kubernetesZone = kubernetesZone[:len(kubernetesZone)-1] // strip trailing dot
-	rf := resolveFile{
-		port:        dnsAddr.Port,
-		domain:      kubernetesZone,
-		nameservers: []net.IP{dnsAddr.IP},
-		search:      []string{kubernetesZone},
+
+	// The mode is only accessed by the SearchPaths goroutine, and by the deferred cleanup once that
+	// goroutine has terminated.
+	mode := dnsModeResolverFiles
+	rfs := newResolverFiles(osResolverFS{}, resolverDirName, dnsAddr, o.router.state)
+	fallBack := func(c context.Context, err error) error {
+		dlog.Errorf(c, "Unable to use per-domain resolver files, falling back to a search path: %v", err)
+		rfs.removeAll(c)
+		mode = dnsModeSearchPath
+		o.setDNSMode(mode)
+		return o.writeSearchPathResolverFile(c, resolverDirName, resolverFileName, dnsAddr, kubernetesZone)
 	}
-	if err = rf.write(resolverFileName); err != nil {
-		return err
+
+	o.setDNSMode(mode)
+	if _, err = rfs.update(c, []string{kubernetesZone}); err != nil {
+		if err = fallBack(c, err); err != nil {
+			return err
+		}
 	}
-	dlog.Infof(c, "Generated new %s", resolverFileName)
+	dns.Flush(c)
 
 	defer func() {
-		// Remove the main resolver file
-		_ = os.Remove(resolverFileName)
+		c := dcontext.HardContext(c)
+		o.setDNSMode("")
+		if mode == dnsModeResolverFiles {
+			rfs.removeAll(c)
+		} else {
+			// Remove the main resolver file
+			_ = os.Remove(resolverFileName)
+			o.router.state.removeDNS(c, resolverFileName)
 
-		// Remove each namespace resolver file
-		for namespace := range o.domains {
-			_ = os.Remove(namespaceResolverFile(resolverDirName, namespace))
+			// Remove each namespace resolver file
+			for namespace := range o.domains {
+				nsFile := namespaceResolverFile(resolverDirName, namespace)
+				_ = os.Remove(nsFile)
+				o.router.state.removeDNS(c, nsFile)
+			}
 		}
-		dns.Flush(dcontext.HardContext(c))
+		dns.Flush(c)
 	}()
 
 	// Start local DNS server
@@ -182,15 +200,78 @@ func (o *outbound) dnsServerWorker(c context.Context) error {
 		case <-o.router.configured():
 			// Server will close the listener, so no need to close it here.
 			o.processSearchPaths(g, func(c context.Context, paths []string) error {
+				if mode == dnsModeResolverFiles {
+					err := o.updateDomainResolverFiles(c, rfs, kubernetesZone, paths)
+					if err == nil {
+						return nil
+					}
+					if err = fallBack(c, err); err != nil {
+						return err
+					}
+				}
 				return o.updateResolverFiles(c, resolverDirName, resolverFileName, dnsAddr, paths)
 			})
-			v := dns.NewServer(c, []net.PacketConn{listener}, nil, o.resolveInCluster)
+			v := dns.NewServer(c, []net.PacketConn{listener}, nil, o.resolveInCluster, o.resolveSRV)
 			return v.Run(c)
 		}
 	})
 	return g.Wait()
 }
 
+// updateDomainResolverFiles makes the per-domain resolver files match the given search paths, the
+// namespaces of which become domains of their own, and the included suffixes.
+func (o *outbound) updateDomainResolverFiles(c context.Context, rfs *resolverFiles, kubernetesZone string, paths []string) error {
+	dlog.Infof(c, "setting resolver domains for search paths %s", strings.Join(paths, " "))
+	namespaces := make(map[string]struct{})
+	search := make([]string, 0)
+	for _, path := range paths {
+		if strings.ContainsRune(path, '.') {
+			search = append(search, path)
+		} else if path != "" {
+			namespaces[path] = struct{}{}
+		}
+	}
+	namespaces[tel2SubDomain] = struct{}{}
+
+	domains := make([]string, 0, 1+len(search)+len(namespaces)+len(o.dnsConfig.IncludeSuffixes))
+	domains = append(domains, kubernetesZone)
+	domains = append(domains, search...)
+	for ns := range namespaces {
+		domains = append(domains, ns)
+	}
+	domains = append(domains, o.dnsConfig.IncludeSuffixes...)
+
+	o.domainsLock.Lock()
+	o.search = search
+	o.namespaces = namespaces
+	o.domainsLock.Unlock()
+
+	changed, err := rfs.update(c, domains)
+	if changed {
+		dns.Flush(c)
+	}
+	return err
+}
+
+// writeSearchPathResolverFile writes the main resolver file of the search path mode.
+func (o *outbound) writeSearchPathResolverFile(c context.Context, resolverDirName, resolverFileName string, dnsAddr *net.UDPAddr, kubernetesZone string) error {
+	if err := os.MkdirAll(resolverDirName, 0755); err != nil {
+		return err
+	}
+	rf := resolveFile{
+		port:        dnsAddr.Port,
+		domain:      kubernetesZone,
+		nameservers: []net.IP{dnsAddr.IP},
+		search:      []string{kubernetesZone},
+	}
+	o.router.state.addDNS(c, resolverFileName)
+	if err := rf.write(resolverFileName); err != nil {
+		return err
+	}
+	dlog.Infof(c, "Generated new %s", resolverFileName)
+	return nil
+}
+
 func (o *outbound) updateResolverFiles(c context.Context, resolverDirName, resolverFileName string, dnsAddr *net.UDPAddr, paths []string) error {
 	dlog.Infof(c, "setting search paths %s", strings.Join(paths, " "))
 	rf, err := readResolveFile(resolverFileName)
@@ -245,6 +326,7 @@ func (o *outbound) updateResolverFiles(c context.Context, resolverDirName, resol
 		if err = os.Remove(nsFile); err != nil {
 			dlog.Error(c, err)
 		}
+		o.router.state.removeDNS(c, nsFile)
 	}
 	for _, namespace := range additions {
 		df := resolveFile{
@@ -254,6 +336,7 @@ func (o *outbound) updateResolverFiles(c context.Context, resolverDirName, resol
 		}
 		nsFile := namespaceResolverFile(resolverDirName, namespace)
 		dlog.Infof(c, "Generated new %s", nsFile)
+		o.router.state.addDNS(c, nsFile)
 		if err = df.write(nsFile); err != nil {
 			dlog.Error(c, err)
 		}
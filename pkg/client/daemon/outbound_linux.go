@@ -135,6 +135,10 @@ func (o *outbound) runOverridingServer(c context.Context) error {
 	defer func() {
 		_ = conn.Close()
 	}()
+	o.setDNSAddrs(dnsResolverAddr, conn.RemoteAddr())
+	defer o.setDNSAddrs(nil, nil)
+	o.setDNSMode(dnsModeOverriding)
+	defer o.setDNSMode("")
 
 	serverStarted := make(chan struct{})
 	serverDone := make(chan struct{})
@@ -163,7 +167,7 @@ func (o *outbound) runOverridingServer(c context.Context) error {
 				dns.Flush(c)
 				return nil
 			})
-			v := dns.NewServer(c, listeners, conn, o.resolveInSearch)
+			v := dns.NewServer(c, listeners, conn, o.resolveInSearch, o.resolveSRV)
 			close(serverStarted)
 			return v.Run(c)
 		}
@@ -176,6 +180,7 @@ func (o *outbound) runOverridingServer(c context.Context) error {
 			// Give DNS server time to start before rerouting NAT
 			dtime.SleepWithContext(c, time.Millisecond)
 
+			o.router.state.addDNS(c, tpDNSChain)
 			err := routeDNS(c, o.dnsConfig.LocalIp, dnsResolverAddr.Port, conn.LocalAddr().(*net.UDPAddr))
 			if err != nil {
 				return err
@@ -183,6 +188,7 @@ func (o *outbound) runOverridingServer(c context.Context) error {
 			defer func() {
 				c := context.Background()
 				unrouteDNS(c)
+				o.router.state.removeDNS(c, tpDNSChain)
 				dns.Flush(c)
 			}()
 			dns.Flush(c)
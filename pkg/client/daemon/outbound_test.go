@@ -0,0 +1,264 @@
+package daemon
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dns2 "github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+func TestShouldDoClusterLookup(t *testing.T) {
+	o := &outbound{
+		router:    &tunRouter{clusterDomain: "cluster.local."},
+		dnsConfig: &rpc.DNSConfig{ExcludeSuffixes: []string{".com"}},
+		namespaces: map[string]struct{}{
+			"default":     {},
+			"blue":        {},
+			tel2SubDomain: {},
+		},
+	}
+	tests := []struct {
+		query  string
+		expect bool
+	}{
+		{"echo.", true},
+		{"echo.blue.", true},
+		{"echo.green.", false},
+		{"echo.blue.svc.cluster.local.", true},
+		{"echo.green.svc.cluster.local.", false},
+		{"pod.echo.blue.svc.cluster.local.", true},
+		{"pod.echo.green.svc.cluster.local.", false},
+		{"www.example.com.", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			assert.Equal(t, tt.expect, o.shouldDoClusterLookup(tt.query))
+		})
+	}
+
+	// All namespaces are considered mapped until the namespaces are known
+	o.namespaces = map[string]struct{}{}
+	assert.True(t, o.shouldDoClusterLookup("echo.green."))
+}
+
+func TestShouldDoClusterLookup_suffixes(t *testing.T) {
+	dnsConfig := &rpc.DNSConfig{
+		IncludeSuffixes: []string{"Corp.Example.COM.", ".internal", ".vpn.corp.example.com", ".lab.vpn.corp.example.com"},
+		ExcludeSuffixes: []string{".com", "vpn.corp.example.com", ".svc.internal", ".internal"},
+	}
+	normalizeDNSConfig(dnsConfig)
+	assert.Equal(t, []string{".corp.example.com", ".internal", ".vpn.corp.example.com", ".lab.vpn.corp.example.com"}, dnsConfig.IncludeSuffixes)
+	assert.Equal(t, []string{".com", ".vpn.corp.example.com", ".svc.internal", ".internal"}, dnsConfig.ExcludeSuffixes)
+
+	o := &outbound{
+		router:     &tunRouter{clusterDomain: "cluster.local."},
+		dnsConfig:  dnsConfig,
+		namespaces: map[string]struct{}{"default": {}},
+	}
+	tests := []struct {
+		query  string
+		expect bool
+	}{
+		{"www.example.com.", false},              // excluded
+		{"corp.example.com.", true},              // included, exact match
+		{"api.corp.example.com.", true},          // include is more specific than the exclude
+		{"mycorp.example.com.", false},           // suffixes only match whole labels
+		{"host.vpn.corp.example.com.", true},     // include wins over an exclude of the same length
+		{"host.lab.vpn.corp.example.com.", true}, // the most specific include wins
+		{"db.internal.", true},                   // include wins over an exclude of the same length
+		{"db.svc.internal.", false},              // exclude is more specific than the include
+		{"echo.default.", true},                  // neither included nor excluded
+		{"echo.green.", false},                   // neither included nor excluded, and not mapped
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			assert.Equal(t, tt.expect, o.shouldDoClusterLookup(tt.query))
+		})
+	}
+}
+
+func TestShouldDoClusterLookup_defaults(t *testing.T) {
+	dnsConfig := &rpc.DNSConfig{}
+	normalizeDNSConfig(dnsConfig)
+	assert.Empty(t, dnsConfig.IncludeSuffixes)
+	assert.Equal(t, []string{".arpa", ".com", ".io", ".net", ".org", ".ru"}, dnsConfig.ExcludeSuffixes)
+
+	o := &outbound{
+		router:     &tunRouter{clusterDomain: "cluster.local."},
+		dnsConfig:  dnsConfig,
+		namespaces: map[string]struct{}{"default": {}},
+	}
+	tests := []struct {
+		query  string
+		expect bool
+	}{
+		{"echo.", true},
+		{"echo.default.", true},
+		{"www.example.com.", false},
+		{"1.0.0.10.in-addr.arpa.", false},
+		{"example.io.", false},
+		{"example.dev.", false},
+		{"kubernetes.default.svc.cluster.local.", true},
+		{"svc.cluster.local.", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			assert.Equal(t, tt.expect, o.shouldDoClusterLookup(tt.query))
+		})
+	}
+}
+
+// fakeUpstream is a manager client that answers host lookups using a map, and blocks on hosts
+// that aren't in the map until the context of the call is done.
+type fakeUpstream struct {
+	manager.ManagerClient
+	hosts   map[string][]net.IP
+	lookups int32
+}
+
+func (f *fakeUpstream) LookupHost(ctx context.Context, in *manager.LookupHostRequest, _ ...grpc.CallOption) (*manager.LookupHostResponse, error) {
+	atomic.AddInt32(&f.lookups, 1)
+	ips, ok := f.hosts[in.Host]
+	if !ok {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	r := &manager.LookupHostResponse{}
+	for _, ip := range ips {
+		r.Ips = append(r.Ips, ip)
+	}
+	return r, nil
+}
+
+func (f *fakeUpstream) lookupCount() int {
+	return int(atomic.LoadInt32(&f.lookups))
+}
+
+func newCachingOutbound(upstream manager.ManagerClient, dns *rpc.DNSConfig) *outbound {
+	o := &outbound{
+		router: &tunRouter{
+			clusterDomain: "cluster.local.",
+			sessions:      []*routerSession{{managerClient: upstream}},
+		},
+		namespaces:        map[string]struct{}{},
+		dnsInProgress:     make(map[string]*awaitLookupResult),
+		dnsCache:          newDNSCache(),
+		searchPathCh:      make(chan []string, 5),
+		sessionPaths:      make(map[string][]string),
+		sessionNamespaces: make(map[string]map[string]struct{}),
+		sessionHeadless:   make(map[string]*headlessRecords),
+	}
+	o.setDNSConfig(dns)
+	return o
+}
+
+func TestResolveInCluster_timeout(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	upstream := &fakeUpstream{}
+	o := newCachingOutbound(upstream, &rpc.DNSConfig{
+		LookupTimeout:    durationpb.New(50 * time.Millisecond),
+		NegativeCacheTtl: durationpb.New(time.Minute),
+	})
+
+	start := time.Now()
+	assert.Nil(t, o.resolveInCluster(ctx, dns2.TypeA, "hang.default."))
+	assert.Less(t, int64(time.Since(start)), int64(time.Second), "lookup didn't time out")
+	assert.Equal(t, 1, upstream.lookupCount())
+
+	// The timed out lookup is cached negatively
+	assert.Nil(t, o.resolveInCluster(ctx, dns2.TypeA, "hang.default."))
+	assert.Equal(t, 1, upstream.lookupCount())
+}
+
+func TestResolveInCluster_negativeCache(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	upstream := &fakeUpstream{hosts: map[string][]net.IP{"missing.default": nil}}
+	o := newCachingOutbound(upstream, &rpc.DNSConfig{NegativeCacheTtl: durationpb.New(100 * time.Millisecond)})
+
+	assert.Nil(t, o.resolveInCluster(ctx, dns2.TypeA, "missing.default."))
+	assert.Nil(t, o.resolveInCluster(ctx, dns2.TypeA, "missing.default."))
+	assert.Equal(t, 1, upstream.lookupCount())
+
+	// The negative entry expires
+	time.Sleep(150 * time.Millisecond)
+	assert.Nil(t, o.resolveInCluster(ctx, dns2.TypeA, "missing.default."))
+	assert.Equal(t, 2, upstream.lookupCount())
+}
+
+func TestResolveInCluster_positiveCache(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	ip := net.IP{10, 0, 0, 1}
+	upstream := &fakeUpstream{hosts: map[string][]net.IP{"echo.default": {ip}}}
+	o := newCachingOutbound(upstream, &rpc.DNSConfig{MaxTtl: durationpb.New(100 * time.Millisecond)})
+
+	assert.Equal(t, []net.IP{ip}, o.resolveInCluster(ctx, dns2.TypeA, "echo.default."))
+	assert.Equal(t, []net.IP{ip}, o.resolveInCluster(ctx, dns2.TypeA, "echo.default."))
+	assert.Equal(t, 1, upstream.lookupCount())
+
+	// The max TTL caps the TTL of the record
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, []net.IP{ip}, o.resolveInCluster(ctx, dns2.TypeA, "echo.default."))
+	assert.Equal(t, 2, upstream.lookupCount())
+}
+
+func TestResolveInCluster_flushOnReconnect(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	upstream := &fakeUpstream{hosts: map[string][]net.IP{"missing.default": nil}}
+	o := newCachingOutbound(upstream, &rpc.DNSConfig{NegativeCacheTtl: durationpb.New(time.Minute)})
+
+	assert.Nil(t, o.resolveInCluster(ctx, dns2.TypeA, "missing.default."))
+	assert.Equal(t, 1, upstream.lookupCount())
+
+	// A new session sets the DNS config again, and the host may now exist
+	ip := net.IP{10, 0, 0, 2}
+	upstream.hosts["missing.default"] = []net.IP{ip}
+	o.setDNSConfig(&rpc.DNSConfig{NegativeCacheTtl: durationpb.New(time.Minute)})
+	assert.Equal(t, []net.IP{ip}, o.resolveInCluster(ctx, dns2.TypeA, "missing.default."))
+	assert.Equal(t, 2, upstream.lookupCount())
+}
+
+func TestSetSearchPath_sessions(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	ip := net.IP{10, 0, 0, 1}
+	dev := &fakeUpstream{hosts: map[string][]net.IP{"echo.blue": {ip}, "echo.default": {ip}}}
+	shared := &fakeUpstream{hosts: map[string][]net.IP{"echo.auth": {ip}}}
+	o := newCachingOutbound(dev, &rpc.DNSConfig{})
+	o.router.sessions = []*routerSession{
+		{name: "dev-ambassador", managerClient: dev, clusterDomain: "cluster.local."},
+		{name: "shared-ambassador", managerClient: shared, clusterDomain: "shared.local."},
+	}
+
+	o.setSearchPath(ctx, "shared-ambassador", []string{"default", "auth"}, []string{"auth"})
+	o.setSearchPath(ctx, "dev-ambassador", []string{"default", "blue"}, nil)
+	var paths []string
+	for len(o.searchPathCh) > 0 {
+		paths = <-o.searchPathCh
+	}
+	// The paths are merged in session order, and the intercepted namespaces use the domain of their session
+	assert.Equal(t, []string{"default", "blue", "auth", "auth.svc.shared.local."}, paths)
+
+	// Names are looked up in the first session that maps their namespace
+	assert.Equal(t, []net.IP{ip}, o.resolveInCluster(ctx, dns2.TypeA, "echo.auth."))
+	assert.Equal(t, []net.IP{ip}, o.resolveInCluster(ctx, dns2.TypeA, "echo.blue."))
+	assert.Equal(t, []net.IP{ip}, o.resolveInCluster(ctx, dns2.TypeA, "echo.default."))
+	assert.Equal(t, 2, dev.lookupCount())
+	assert.Equal(t, 1, shared.lookupCount())
+}
+
+func TestReplaceSuffixes(t *testing.T) {
+	sfxs := []string{".corp.example.com", ".svc.example.com", ".old.example.com"}
+	assert.Equal(t,
+		[]string{".corp.example.com", ".svc.example.com", ".new.example.com"},
+		replaceSuffixes(sfxs, []string{"Old.Example.com."}, []string{".new.example.com"}))
+	assert.Equal(t, sfxs, replaceSuffixes(sfxs, nil, nil))
+}
@@ -20,6 +20,8 @@ func (o *outbound) dnsServerWorker(c context.Context) error {
 		return err
 	}
 	o.router.configureDNS(c, dnsAddr)
+	o.setDNSMode(dnsModeInterface)
+	defer o.setDNSMode("")
 
 	// Start local DNS server
 	g := dgroup.NewGroup(c, dgroup.GroupConfig{})
@@ -30,7 +32,7 @@ func (o *outbound) dnsServerWorker(c context.Context) error {
 			return nil
 		case <-o.router.configured():
 			o.processSearchPaths(g, o.updateRouterDNS)
-			v := dns.NewServer(c, []net.PacketConn{listener}, nil, o.resolveInCluster)
+			v := dns.NewServer(c, []net.PacketConn{listener}, nil, o.resolveInCluster, o.resolveSRV)
 			return v.Run(c)
 		}
 	})
@@ -52,6 +54,7 @@ func (o *outbound) updateRouterDNS(c context.Context, paths []string) error {
 	o.namespaces = namespaces
 	o.search = search
 	o.domainsLock.Unlock()
+	o.router.state.addDNS(c, dnsOnInterface)
 	err := o.router.dev.SetDNS(c, o.router.dnsIP, search)
 	if err != nil {
 		return fmt.Errorf("failed to set DNS: %w", err)
@@ -16,9 +16,79 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/vif"
 )
 
+// resolvedAPI is the part of the D-Bus API of systemd-resolved that configures the DNS of a link.
+type resolvedAPI interface {
+	IsRunning(c context.Context) bool
+	SetLinkDNS(c context.Context, networkIndex int, ips ...net.IP) error
+	SetLinkDomains(c context.Context, networkIndex int, domains ...string) error
+	RevertLink(c context.Context, networkIndex int) error
+}
+
+type dbusResolved struct{}
+
+func (dbusResolved) IsRunning(c context.Context) bool {
+	return dbus.IsResolveDRunning(c)
+}
+
+func (dbusResolved) SetLinkDNS(c context.Context, networkIndex int, ips ...net.IP) error {
+	return dbus.SetLinkDNS(c, networkIndex, ips...)
+}
+
+func (dbusResolved) SetLinkDomains(c context.Context, networkIndex int, domains ...string) error {
+	return dbus.SetLinkDomains(c, networkIndex, domains...)
+}
+
+func (dbusResolved) RevertLink(c context.Context, networkIndex int) error {
+	return dbus.RevertLink(c, networkIndex)
+}
+
+// resolved is a variable so that tests can verify the configuration without a system bus.
+var resolved resolvedAPI = dbusResolved{}
+
+// resolvedLink is the DNS configuration of the TUN device in systemd-resolved. The configuration is
+// scoped to the link, so that only the queries for the domains of the link are sent to the local DNS
+// server, and everything else keeps using the DNS servers of the other links.
+type resolvedLink struct {
+	api        resolvedAPI
+	index      int
+	name       string
+	configured bool
+}
+
+// setup makes the given IP the DNS server of the link. The link is given the routing-only tel2SubDomain
+// right away, so that it can be verified that queries reach the local DNS server before the search paths
+// are known.
+func (l *resolvedLink) setup(c context.Context, dnsIP net.IP) error {
+	l.configured = true
+	if err := l.api.SetLinkDNS(c, l.index, dnsIP); err != nil {
+		return fmt.Errorf("failed to set the DNS server of %q: %w", l.name, err)
+	}
+	return l.setDomains(c, []string{"~" + tel2SubDomain})
+}
+
+// setDomains sets the domains of the link. A domain prefixed with "~" is routing-only, i.e. it's not
+// added to the search path.
+func (l *resolvedLink) setDomains(c context.Context, domains []string) error {
+	if err := l.api.SetLinkDomains(c, l.index, domains...); err != nil {
+		return fmt.Errorf("failed to set link domains on %q: %w", l.name, err)
+	}
+	dlog.Debugf(c, "Link domains on device %q set to [%s]", l.name, strings.Join(domains, ","))
+	return nil
+}
+
+// revert reverts the settings of the link, if setup has been called, and only those.
+func (l *resolvedLink) revert(c context.Context) error {
+	if !l.configured {
+		return nil
+	}
+	l.configured = false
+	dlog.Debugf(c, "Reverting Link settings for %s", l.name)
+	return l.api.RevertLink(c, l.index)
+}
+
 func (o *outbound) tryResolveD(c context.Context, dev *vif.Device) error {
 	// Connect to ResolveD via DBUS.
-	if !dbus.IsResolveDRunning(c) {
+	if !resolved.IsRunning(c) {
 		dlog.Error(c, "systemd-resolved is not running")
 		return errResolveDNotConfigured
 	}
@@ -43,6 +113,7 @@ func (o *outbound) tryResolveD(c context.Context, dev *vif.Device) error {
 	initDone := make(chan struct{})
 
 	var dnsServer *dns.Server
+	link := &resolvedLink{api: resolved, index: int(dev.Index()), name: dev.Name()}
 	g.Go("Server", func(c context.Context) error {
 		select {
 		case <-c.Done():
@@ -51,19 +122,13 @@ func (o *outbound) tryResolveD(c context.Context, dev *vif.Device) error {
 		case <-o.router.configured():
 			dnsIP := o.router.dnsIP
 			dlog.Infof(c, "Configuring DNS IP %s", dnsIP)
-			if err = dbus.SetLinkDNS(c, int(dev.Index()), dnsIP); err != nil {
-				dlog.Error(c, err)
-				initDone <- struct{}{}
-				return errResolveDNotConfigured
-			}
 			defer func() {
 				// It's very likely that the context is cancelled here. We use it
 				// anyway, stripped from cancellation, to retain logging.
 				c, cancel := context.WithTimeout(dcontext.WithoutCancel(c), time.Second)
 				defer cancel()
-				dlog.Debugf(c, "Reverting Link settings for %s", dev.Name())
 				o.router.configureDNS(c, nil) // Don't route from TUN-device
-				if err = dbus.RevertLink(c, int(dev.Index())); err != nil {
+				if err := link.revert(c); err != nil {
 					dlog.Error(c, err)
 				}
 				// No need to close listeners here. They are closed by the dnsServer
@@ -72,12 +137,14 @@ func (o *outbound) tryResolveD(c context.Context, dev *vif.Device) error {
 			// If two interfaces with DefaultRoute: yes present, the one with the
 			// routing key used and SanityCheck fails. Hence, tel2SubDomain
 			// must be used as a routing key.
-			if err = o.updateLinkDomains(c, []string{tel2SubDomain}); err != nil {
+			if err = link.setup(c, dnsIP); err != nil {
 				dlog.Error(c, err)
 				initDone <- struct{}{}
 				return errResolveDNotConfigured
 			}
-			dnsServer = dns.NewServer(c, listeners, nil, o.resolveInCluster)
+			o.setDNSMode(dnsModeResolved)
+			defer o.setDNSMode("")
+			dnsServer = dns.NewServer(c, listeners, nil, o.resolveInCluster, o.resolveSRV)
 			close(initDone)
 			return dnsServer.Run(c)
 		}
@@ -98,7 +165,9 @@ func (o *outbound) tryResolveD(c context.Context, dev *vif.Device) error {
 			if dnsServer.RequestCount() > 0 {
 				// The query went all way through. Start processing search paths systemd-resolved style
 				// and return nil for successful validation.
-				o.processSearchPaths(g, o.updateLinkDomains)
+				o.processSearchPaths(g, func(c context.Context, paths []string) error {
+					return link.setDomains(c, o.linkDomains(paths))
+				})
 				return nil
 			}
 			dns.Flush(c)
@@ -109,32 +178,33 @@ func (o *outbound) tryResolveD(c context.Context, dev *vif.Device) error {
 	return g.Wait()
 }
 
-func (o *outbound) updateLinkDomains(c context.Context, paths []string) error {
+// linkDomains returns the domains of the link for the given search paths. A namespace becomes a
+// routing-only domain. So do the cluster domain, the included suffixes, and the tel2SubDomain, which means
+// that only the queries for those domains reach the local DNS server. The search paths that contain a dot
+// are both search and routing domains.
+func (o *outbound) linkDomains(paths []string) []string {
 	namespaces := make(map[string]struct{})
 	search := make([]string, 0)
-	for i, path := range paths {
+	domains := make([]string, 0, len(paths)+len(o.dnsConfig.IncludeSuffixes)+2)
+	for _, path := range paths {
 		if strings.ContainsRune(path, '.') {
 			search = append(search, path)
-		} else {
+			domains = append(domains, path)
+		} else if path != "" {
 			namespaces[path] = struct{}{}
 			// Turn namespace into a route
-			paths[i] = "~" + path
+			domains = append(domains, "~"+path)
 		}
 	}
 	for _, sfx := range o.dnsConfig.IncludeSuffixes {
-		paths = append(paths, "~"+strings.TrimPrefix(sfx, "."))
+		domains = append(domains, "~"+strings.TrimPrefix(sfx, "."))
 	}
-	paths = append(paths, o.router.clusterDomain)
+	domains = append(domains, "~"+strings.TrimSuffix(o.router.clusterDomain, "."), "~"+tel2SubDomain)
 	namespaces[tel2SubDomain] = struct{}{}
 
 	o.domainsLock.Lock()
 	o.namespaces = namespaces
 	o.search = search
 	o.domainsLock.Unlock()
-	dev := o.router.dev
-	if err := dbus.SetLinkDomains(c, int(dev.Index()), paths...); err != nil {
-		return fmt.Errorf("failed to set link domains on %q: %w", dev.Name(), err)
-	}
-	dlog.Debugf(c, "Link domains on device %q set to [%s]", dev.Name(), strings.Join(paths, ","))
-	return nil
+	return domains
 }
@@ -0,0 +1,114 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+)
+
+// fakeResolved records the calls made to the D-Bus API of systemd-resolved. The calls named in broken fail.
+type fakeResolved struct {
+	calls      []string
+	broken     map[string]bool
+	notRunning bool
+}
+
+func (f *fakeResolved) call(name string, networkIndex int, args string) error {
+	f.calls = append(f.calls, fmt.Sprintf("%s %d %s", name, networkIndex, args))
+	if f.broken[name] {
+		return errors.New("access denied")
+	}
+	return nil
+}
+
+func (f *fakeResolved) IsRunning(context.Context) bool {
+	return !f.notRunning
+}
+
+func (f *fakeResolved) SetLinkDNS(_ context.Context, networkIndex int, ips ...net.IP) error {
+	return f.call("SetLinkDNS", networkIndex, fmt.Sprint(ips))
+}
+
+func (f *fakeResolved) SetLinkDomains(_ context.Context, networkIndex int, domains ...string) error {
+	return f.call("SetLinkDomains", networkIndex, strings.Join(domains, ","))
+}
+
+func (f *fakeResolved) RevertLink(_ context.Context, networkIndex int) error {
+	return f.call("RevertLink", networkIndex, "")
+}
+
+func Test_resolvedLink(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	dnsConfig := &rpc.DNSConfig{IncludeSuffixes: []string{".corp.example.com"}}
+	normalizeDNSConfig(dnsConfig)
+	o := &outbound{
+		router:    &tunRouter{clusterDomain: "cluster.local."},
+		dnsConfig: dnsConfig,
+		dnsCache:  newDNSCache(),
+	}
+	f := &fakeResolved{}
+	link := &resolvedLink{api: f, index: 7, name: "tel0"}
+
+	// Nothing is reverted before the link has been configured
+	require.NoError(t, link.revert(ctx))
+	assert.Empty(t, f.calls)
+
+	require.NoError(t, link.setup(ctx, net.IP{10, 0, 0, 2}))
+	require.NoError(t, link.setDomains(ctx, o.linkDomains([]string{"default", "blue", "", "blue.svc.cluster.local"})))
+	assert.Equal(t, []string{"blue.svc.cluster.local"}, o.search)
+	assert.Equal(t, map[string]struct{}{"default": {}, "blue": {}, tel2SubDomain: {}}, o.namespaces)
+
+	// A change of the config changes the domains
+	dnsConfig = &rpc.DNSConfig{IncludeSuffixes: []string{".internal"}}
+	o.setDNSConfig(dnsConfig)
+	require.NoError(t, link.setDomains(ctx, o.linkDomains([]string{"default"})))
+
+	require.NoError(t, link.revert(ctx))
+	require.NoError(t, link.revert(ctx))
+	assert.Equal(t, []string{
+		"SetLinkDNS 7 [10.0.0.2]",
+		"SetLinkDomains 7 ~tel2-search",
+		"SetLinkDomains 7 ~default,~blue,blue.svc.cluster.local,~corp.example.com,~cluster.local,~tel2-search",
+		"SetLinkDomains 7 ~default,~internal,~cluster.local,~tel2-search",
+		"RevertLink 7 ",
+	}, f.calls)
+}
+
+func Test_resolvedLink_setupFailure(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	f := &fakeResolved{broken: map[string]bool{"SetLinkDomains": true}}
+	link := &resolvedLink{api: f, index: 7, name: "tel0"}
+
+	err := link.setup(ctx, net.IP{10, 0, 0, 2})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `failed to set link domains on "tel0"`)
+
+	// The DNS server that was set is reverted
+	require.NoError(t, link.revert(ctx))
+	assert.Equal(t, []string{
+		"SetLinkDNS 7 [10.0.0.2]",
+		"SetLinkDomains 7 ~tel2-search",
+		"RevertLink 7 ",
+	}, f.calls)
+}
+
+func Test_tryResolveD_notRunning(t *testing.T) {
+	f := &fakeResolved{notRunning: true}
+	old := resolved
+	resolved = f
+	t.Cleanup(func() { resolved = old })
+
+	// The daemon falls back to the overriding resolver
+	o := &outbound{}
+	assert.Equal(t, errResolveDNotConfigured, o.tryResolveD(dlog.NewTestContext(t, false), nil))
+	assert.Empty(t, f.calls)
+}
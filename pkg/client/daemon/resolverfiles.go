@@ -0,0 +1,162 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// resolverFileHeader is the first line of each file that the daemon writes to the resolver directory. A
+// file without it belongs to someone else, e.g. a VPN client, and is never overwritten or removed.
+const resolverFileHeader = "# Generated by telepresence\n"
+
+// resolverFS is the part of the file system that resolverFiles uses. Tests replace it with a fake so that
+// the files can be verified without touching /etc/resolver.
+type resolverFS interface {
+	MkdirAll(path string, perm fs.FileMode) error
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	Remove(name string) error
+}
+
+type osResolverFS struct{}
+
+func (osResolverFS) MkdirAll(path string, perm fs.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osResolverFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (osResolverFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+func (osResolverFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// resolverFiles maintains one file in a resolver directory for each domain that the local DNS server
+// resolves. The macOS resolver sends the queries for a domain that has such a file, and only those, to
+// the nameserver of the file, so unlike a search path, the files don't interfere with the DNS
+// configuration of other software. See "man 5 resolver" for more information.
+//
+// The files that are written are recorded in the netStateFile so that they can be removed after a crash,
+// and in the written map so that exactly those files are removed by removeAll.
+type resolverFiles struct {
+	fs      resolverFS
+	dir     string
+	addr    *net.UDPAddr
+	state   *netStateFile
+	written map[string]string // domain -> file name
+}
+
+func newResolverFiles(rfs resolverFS, dir string, addr *net.UDPAddr, state *netStateFile) *resolverFiles {
+	return &resolverFiles{
+		fs:      rfs,
+		dir:     dir,
+		addr:    addr,
+		state:   state,
+		written: make(map[string]string),
+	}
+}
+
+// isResolverFileOwned returns true if the given content of a resolver file was generated by telepresence.
+func isResolverFileOwned(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(resolverFileHeader))
+}
+
+// content returns the content of the resolver file of the given domain.
+func (r *resolverFiles) content(domain string) []byte {
+	buf := bytes.NewBufferString(resolverFileHeader)
+	fmt.Fprintf(buf, "domain %s\n", domain)
+	fmt.Fprintf(buf, "nameserver %s\n", r.addr.IP)
+	fmt.Fprintf(buf, "port %d\n", r.addr.Port)
+	return buf.Bytes()
+}
+
+// update makes the files of the resolver directory match the given domains. A file is written for each
+// domain that lacks one, and the files written for domains that are no longer given are removed. A file
+// of a domain that isn't generated by telepresence is left alone. The returned boolean is true if any
+// file was written or removed, in which case the DNS cache should be flushed.
+func (r *resolverFiles) update(ctx context.Context, domains []string) (bool, error) {
+	wanted := make(map[string]struct{}, len(domains))
+	for _, domain := range domains {
+		domain = strings.Trim(strings.ToLower(domain), ".")
+		if domain != "" {
+			wanted[domain] = struct{}{}
+		}
+	}
+	changed := false
+	for domain, fileName := range r.written {
+		if _, ok := wanted[domain]; !ok {
+			changed = true
+			r.remove(ctx, domain, fileName)
+		}
+	}
+	if len(wanted) == 0 {
+		return changed, nil
+	}
+	if err := r.fs.MkdirAll(r.dir, 0755); err != nil {
+		return changed, err
+	}
+
+	sorted := make([]string, 0, len(wanted))
+	for domain := range wanted {
+		sorted = append(sorted, domain)
+	}
+	sort.Strings(sorted)
+	for _, domain := range sorted {
+		fileName := filepath.Join(r.dir, domain)
+		content := r.content(domain)
+		if data, err := r.fs.ReadFile(fileName); err == nil {
+			if bytes.Equal(data, content) {
+				r.state.addDNS(ctx, fileName)
+				r.written[domain] = fileName
+				continue
+			}
+			if !isResolverFileOwned(data) {
+				dlog.Warnf(ctx, "Not resolving %s in the cluster, because %s isn't generated by telepresence", domain, fileName)
+				continue
+			}
+		}
+		changed = true
+		r.state.addDNS(ctx, fileName)
+		if err := r.fs.WriteFile(fileName, content, 0644); err != nil {
+			r.state.removeDNS(ctx, fileName)
+			return changed, fmt.Errorf("failed to write resolver file %s: %w", fileName, err)
+		}
+		r.written[domain] = fileName
+		dlog.Infof(ctx, "Generated new %s", fileName)
+	}
+	return changed, nil
+}
+
+// removeAll removes all files that have been written, and returns true if there were any.
+func (r *resolverFiles) removeAll(ctx context.Context) bool {
+	changed := len(r.written) > 0
+	for domain, fileName := range r.written {
+		r.remove(ctx, domain, fileName)
+	}
+	return changed
+}
+
+func (r *resolverFiles) remove(ctx context.Context, domain, fileName string) {
+	dlog.Infof(ctx, "Removing %s", fileName)
+	delete(r.written, domain)
+	if err := r.fs.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		// Keep the record, so that the file is removed by the cleanup of the next daemon
+		dlog.Error(ctx, err)
+		return
+	}
+	r.state.removeDNS(ctx, fileName)
+}
@@ -0,0 +1,163 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// fakeResolverFS is a resolverFS that keeps the files in memory. Writes to the files in readOnly fail.
+type fakeResolverFS struct {
+	files    map[string]string
+	readOnly map[string]bool
+}
+
+func (f *fakeResolverFS) MkdirAll(string, fs.FileMode) error {
+	return nil
+}
+
+func (f *fakeResolverFS) ReadFile(name string) ([]byte, error) {
+	if data, ok := f.files[name]; ok {
+		return []byte(data), nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (f *fakeResolverFS) WriteFile(name string, data []byte, _ fs.FileMode) error {
+	if f.readOnly[name] {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	f.files[name] = string(data)
+	return nil
+}
+
+func (f *fakeResolverFS) Remove(name string) error {
+	if _, ok := f.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(f.files, name)
+	return nil
+}
+
+func (f *fakeResolverFS) names() []string {
+	names := make([]string, 0, len(f.files))
+	for name := range f.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func recordedDNS(t *testing.T, path string) []string {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var st netState
+	require.NoError(t, json.Unmarshal(data, &st))
+	sort.Strings(st.DNS)
+	return st.DNS
+}
+
+func Test_resolverFiles(t *testing.T) {
+	ctx := filelocation.WithAppSystemRuntimeDir(dlog.NewTestContext(t, false), t.TempDir())
+	statePath := client.DaemonNetworkStateFile(ctx)
+
+	const vpnFile = "# Generated by the corporate VPN\nnameserver 10.1.0.2\n"
+	f := &fakeResolverFS{files: map[string]string{
+		"/etc/resolver/corp.example.com": vpnFile,
+		"/etc/resolver/other.example":    "nameserver 10.1.0.3\n",
+	}}
+	addr := &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: 53533}
+	r := newResolverFiles(f, "/etc/resolver", addr, newNetStateFile(ctx, "utun4"))
+
+	changed, err := r.update(ctx, []string{"cluster.local", "default", "default.svc.cluster.local", ".internal.", "Cluster.Local", ".corp.example.com"})
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, `# Generated by telepresence
+domain cluster.local
+nameserver 127.0.0.1
+port 53533
+`, f.files["/etc/resolver/cluster.local"])
+	assert.Equal(t, `# Generated by telepresence
+domain internal
+nameserver 127.0.0.1
+port 53533
+`, f.files["/etc/resolver/internal"])
+
+	// The file of the VPN client is left alone
+	assert.Equal(t, vpnFile, f.files["/etc/resolver/corp.example.com"])
+	ours := []string{
+		"/etc/resolver/cluster.local",
+		"/etc/resolver/default",
+		"/etc/resolver/default.svc.cluster.local",
+		"/etc/resolver/internal",
+	}
+	assert.Equal(t, ours, recordedDNS(t, statePath))
+
+	// Nothing changes when the domains are the same
+	changed, err = r.update(ctx, []string{"cluster.local", "default", "default.svc.cluster.local", "internal", "corp.example.com"})
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	// A file that has been removed by someone else is written again
+	delete(f.files, "/etc/resolver/default")
+	changed, err = r.update(ctx, []string{"cluster.local", "default", "default.svc.cluster.local", "internal"})
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Contains(t, f.files, "/etc/resolver/default")
+
+	// Only the files of the domains that are gone are removed
+	changed, err = r.update(ctx, []string{"cluster.local", "blue", "internal"})
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []string{
+		"/etc/resolver/blue",
+		"/etc/resolver/cluster.local",
+		"/etc/resolver/corp.example.com",
+		"/etc/resolver/internal",
+		"/etc/resolver/other.example",
+	}, f.names())
+	assert.Equal(t, []string{
+		"/etc/resolver/blue",
+		"/etc/resolver/cluster.local",
+		"/etc/resolver/internal",
+	}, recordedDNS(t, statePath))
+
+	// removeAll removes exactly the files that were written
+	assert.True(t, r.removeAll(ctx))
+	assert.Equal(t, []string{"/etc/resolver/corp.example.com", "/etc/resolver/other.example"}, f.names())
+	assert.Empty(t, recordedDNS(t, statePath))
+	assert.False(t, r.removeAll(ctx))
+}
+
+func Test_resolverFiles_writeFailure(t *testing.T) {
+	ctx := filelocation.WithAppSystemRuntimeDir(dlog.NewTestContext(t, false), t.TempDir())
+	statePath := client.DaemonNetworkStateFile(ctx)
+
+	f := &fakeResolverFS{
+		files:    map[string]string{},
+		readOnly: map[string]bool{"/etc/resolver/default": true},
+	}
+	addr := &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: 53533}
+	r := newResolverFiles(f, "/etc/resolver", addr, newNetStateFile(ctx, "utun4"))
+
+	_, err := r.update(ctx, []string{"cluster.local", "default"})
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, fs.ErrPermission))
+
+	// The file that couldn't be written isn't recorded, and the ones that were written are removed
+	assert.Equal(t, []string{"/etc/resolver/cluster.local"}, recordedDNS(t, statePath))
+	r.removeAll(ctx)
+	assert.Empty(t, f.names())
+	assert.Empty(t, recordedDNS(t, statePath))
+}
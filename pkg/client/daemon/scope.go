@@ -0,0 +1,130 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/helper"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+// A daemon that the privileged helper started is restricted to the network changes that are within the
+// scope that the helper grants, see helper.CheckSubnet and its siblings. Requests from the user daemon
+// that are outside that scope are refused, and the out-of-scope subnets, IPs, and domains that the
+// traffic-manager reports are ignored. The never-proxy subnets aren't checked, because they only
+// remove routes.
+
+// checkOutboundInfoScope returns a PermissionDenied error when the given outbound info asks for routes
+// or DNS changes that are outside the scope of a daemon started by the privileged helper.
+func checkOutboundInfoScope(info *rpc.OutboundInfo) error {
+	err := func() error {
+		if len(info.AllowConflictingSubnets) > 0 {
+			return fmt.Errorf("routing subnets that conflict with the routes of the host is %w", helper.ErrOutOfScope)
+		}
+		for _, sn := range info.AlsoProxySubnets {
+			if err := helper.CheckSubnet(iputil.IPNetFromRPC(sn)); err != nil {
+				return err
+			}
+		}
+		if dn := info.DockerNetwork; dn != nil {
+			if err := helper.CheckIP(dn.Gateway); err != nil {
+				return err
+			}
+			for _, nr := range dn.NodeRoutes {
+				if err := helper.CheckSubnet(iputil.IPNetFromRPC(nr.Subnet)); err != nil {
+					return err
+				}
+				if err := helper.CheckIP(nr.Gateway); err != nil {
+					return err
+				}
+			}
+		}
+		if dns := info.Dns; dns != nil {
+			if len(dns.LocalIp) > 0 {
+				if err := helper.CheckNameserver(dns.LocalIp); err != nil {
+					return err
+				}
+			}
+			if len(dns.RemoteIp) > 0 {
+				if err := helper.CheckIP(dns.RemoteIp); err != nil {
+					return err
+				}
+			}
+			for _, sfx := range dns.IncludeSuffixes {
+				if err := helper.CheckDomain(sfx); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
+
+// checkSearchPathScope returns a PermissionDenied error when one of the given search paths or namespaces
+// isn't a single DNS label.
+func checkSearchPathScope(paths, namespaces []string) error {
+	for _, ps := range [][]string{paths, namespaces} {
+		for _, p := range ps {
+			if err := helper.CheckLabel(p); err != nil {
+				return status.Error(codes.PermissionDenied, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// subnetsInScope returns the given subnets, except those that are outside the scope of a daemon started
+// by the privileged helper, which are logged.
+func subnetsInScope(ctx context.Context, sns []*net.IPNet) []*net.IPNet {
+	inScope := make([]*net.IPNet, 0, len(sns))
+	for _, sn := range sns {
+		if err := helper.CheckSubnet(sn); err != nil {
+			dlog.Errorf(ctx, "Subnet %s is not routed: %v", sn, err)
+			continue
+		}
+		inScope = append(inScope, sn)
+	}
+	return inScope
+}
+
+// ipsInScope returns the given IPs, except those that are outside the scope of a daemon started by the
+// privileged helper, which are logged.
+func ipsInScope(ctx context.Context, ips []net.IP) []net.IP {
+	inScope := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if err := helper.CheckIP(ip); err != nil {
+			dlog.Errorf(ctx, "IP %s is not routed: %v", ip, err)
+			continue
+		}
+		inScope = append(inScope, ip)
+	}
+	return inScope
+}
+
+// checkDNSIPScope returns an error when the router is restricted to the scope of the privileged helper
+// and the given cluster DNS IP is outside of it.
+func (t *tunRouter) checkDNSIPScope(ip net.IP) error {
+	if !t.helperScope {
+		return nil
+	}
+	return helper.CheckIP(ip)
+}
+
+// checkDomainScope returns an error when the router is restricted to the scope of the privileged helper
+// and the given cluster domain is outside of it.
+func (t *tunRouter) checkDomainScope(domain string) error {
+	if !t.helperScope {
+		return nil
+	}
+	return helper.CheckDomain(domain)
+}
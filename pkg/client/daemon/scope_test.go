@@ -0,0 +1,113 @@
+package daemon
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/helper"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+func TestCheckOutboundInfoScope(t *testing.T) {
+	subnets := func(strs ...string) []*manager.IPNet {
+		var sns []*manager.IPNet
+		for _, sn := range cidrs(t, strs...) {
+			sns = append(sns, iputil.IPNetToRPC(sn))
+		}
+		return sns
+	}
+	tests := []struct {
+		name    string
+		info    *rpc.OutboundInfo
+		inScope bool
+	}{
+		{"cluster routes and DNS", &rpc.OutboundInfo{
+			AlsoProxySubnets:  subnets("10.10.0.0/16"),
+			NeverProxySubnets: subnets("0.0.0.0/0"),
+			Dns:               &rpc.DNSConfig{RemoteIp: net.IP{10, 96, 0, 10}, IncludeSuffixes: []string{".corp.example.com"}},
+		}, true},
+		{"default route", &rpc.OutboundInfo{AlsoProxySubnets: subnets("0.0.0.0/0")}, false},
+		{"half of the internet", &rpc.OutboundInfo{AlsoProxySubnets: subnets("128.0.0.0/1")}, false},
+		{"IPv6 default route", &rpc.OutboundInfo{AlsoProxySubnets: subnets("::/0")}, false},
+		{"loopback", &rpc.OutboundInfo{AlsoProxySubnets: subnets("127.0.0.0/24")}, false},
+		{"conflicting subnets", &rpc.OutboundInfo{AllowConflictingSubnets: subnets("192.168.1.0/24")}, false},
+		{"docker node route", &rpc.OutboundInfo{DockerNetwork: &rpc.DockerNetworkRoutes{
+			Gateway:    net.IP{172, 17, 0, 1},
+			NodeRoutes: []*rpc.GatewayRoute{{Subnet: subnets("0.0.0.0/0")[0], Gateway: net.IP{172, 17, 0, 2}}},
+		}}, false},
+		{"docker node gateway", &rpc.OutboundInfo{DockerNetwork: &rpc.DockerNetworkRoutes{
+			Gateway:    net.IP{172, 17, 0, 1},
+			NodeRoutes: []*rpc.GatewayRoute{{Subnet: subnets("10.244.0.0/24")[0], Gateway: net.IP{127, 0, 0, 1}}},
+		}}, false},
+		{"loopback remote DNS", &rpc.OutboundInfo{Dns: &rpc.DNSConfig{RemoteIp: net.IP{127, 0, 0, 53}}}, false},
+		{"top-level domain", &rpc.OutboundInfo{Dns: &rpc.DNSConfig{IncludeSuffixes: []string{".com"}}}, false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkOutboundInfoScope(tt.info)
+			if tt.inScope {
+				assert.NoError(t, err)
+			} else {
+				assert.Equal(t, codes.PermissionDenied, status.Code(err), err)
+			}
+		})
+	}
+}
+
+func TestOutbound_setInfo_helperScope(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	o := &outbound{router: &tunRouter{helperScope: true}}
+	_, sn, _ := net.ParseCIDR("0.0.0.0/0")
+	err := o.setInfo(ctx, &rpc.OutboundInfo{AlsoProxySubnets: []*manager.IPNet{iputil.IPNetToRPC(sn)}})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Nil(t, o.dnsConfig)
+}
+
+func TestService_SetDnsSearchPath_helperScope(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	d := &service{startedBy: runOptions{privilegedHelper: true}}
+	for _, paths := range []*rpc.Paths{
+		{Paths: []string{"default", "com"}, Namespaces: []string{"example.com"}},
+		{Paths: []string{"default", "."}},
+		{Paths: []string{"com."}},
+	} {
+		_, err := d.SetDnsSearchPath(ctx, paths)
+		assert.Equal(t, codes.PermissionDenied, status.Code(err), paths.String())
+	}
+	assert.NoError(t, checkSearchPathScope([]string{"default", "kube-system"}, []string{"blue"}))
+}
+
+func TestTunRouter_helperScope(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	tr := &tunRouter{
+		helperScope:  true,
+		routedIPs:    make(map[string][]net.IP),
+		hostRoutesCh: make(chan struct{}, 1),
+	}
+
+	// Routed IPs that the host must reach itself are ignored
+	tr.setRoutedIPs(ctx, "default", [][]byte{{10, 96, 0, 1}, {127, 0, 0, 1}, {169, 254, 169, 254}, {0, 0, 0, 0}})
+	assert.Equal(t, []net.IP{{10, 96, 0, 1}}, tr.routedIPs["default"])
+
+	// So are the cluster subnets that would route the traffic of the host to the cluster
+	assert.Equal(t, []string{"10.96.0.0/12"}, cidrStrings(subnetsInScope(ctx, cidrs(t, "0.0.0.0/0", "10.96.0.0/12", "127.0.0.0/8"))))
+	assert.Error(t, tr.checkDNSIPScope(net.IP{127, 0, 0, 53}))
+	assert.True(t, errors.Is(tr.checkDomainScope("com."), helper.ErrOutOfScope))
+	require.NoError(t, tr.checkDomainScope("cluster.local."))
+
+	// A daemon that wasn't started by the helper isn't restricted
+	tr.helperScope = false
+	tr.setRoutedIPs(ctx, "default", [][]byte{{127, 0, 0, 1}})
+	assert.Equal(t, []net.IP{{127, 0, 0, 1}}, tr.routedIPs["default"])
+	assert.NoError(t, tr.checkDomainScope("com."))
+}
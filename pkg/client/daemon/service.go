@@ -24,11 +24,14 @@ import (
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/helper"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/logging"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/scout"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
 	"github.com/telepresenceio/telepresence/v2/pkg/log"
+	"github.com/telepresenceio/telepresence/v2/pkg/metrics"
 	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 )
 
 const ProcessName = "daemon"
@@ -53,22 +56,56 @@ type service struct {
 	cancel        context.CancelFunc
 	timedLogLevel log.TimedLevel
 
+	// startedBy tells how the daemon was started
+	startedBy runOptions
+
 	scoutClient *scout.Scout           // don't use this directly; use the 'scout' chan instead
 	scout       chan scout.ScoutReport // any-of-scoutUsers -> background-metriton
 }
 
 // Command returns the telepresence sub-command "daemon-foreground"
 func Command() *cobra.Command {
-	return &cobra.Command{
+	var userDaemonAddress, runtimeDir string
+	var privilegedHelper, disableTelemetry bool
+	var uid int
+	c := &cobra.Command{
 		Use:    ProcessName + "-foreground <logging dir> <config dir> <dns>",
 		Short:  "Launch Telepresence " + titleName + " in the foreground (debug)",
 		Args:   cobra.ExactArgs(3),
 		Hidden: true,
 		Long:   help,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return run(cmd.Context(), args[0], args[1], args[2])
+			c := withUserEnv(cmd.Context(), userDaemonAddress, runtimeDir, disableTelemetry)
+			return run(c, args[0], args[1], args[2], runOptions{privilegedHelper: privilegedHelper, uid: uid})
 		},
 	}
+	c.Flags().StringVar(&userDaemonAddress, "user-daemon-address", "", "The address of the user daemon")
+	c.Flags().StringVar(&runtimeDir, "runtime-dir", "", "The runtime directory of the user that started the daemon")
+	c.Flags().BoolVar(&privilegedHelper, "privileged-helper", false, "The daemon was started by the privileged helper")
+	c.Flags().IntVar(&uid, "uid", 0, "Only accept connections from the user with this id (and from root)")
+	c.Flags().BoolVar(&disableTelemetry, "disable-telemetry", false, "The user that started the daemon disabled telemetry using SCOUT_DISABLE")
+	return c
+}
+
+// withUserEnv returns a context with the settings of the environment of the user that started the
+// daemon, so that the daemon finds the user daemon of that user and honors its telemetry setting. The
+// environment of the user isn't propagated by sudo, the privileged helper, or the service control
+// manager.
+func withUserEnv(c context.Context, userDaemonAddress, runtimeDir string, disableTelemetry bool) context.Context {
+	if userDaemonAddress != "" || disableTelemetry {
+		env := *client.GetEnv(c)
+		if userDaemonAddress != "" {
+			env.UserDaemonAddress = userDaemonAddress
+		}
+		if disableTelemetry {
+			env.ScoutDisable = "1"
+		}
+		c = client.WithEnv(c, &env)
+	}
+	if runtimeDir != "" {
+		c = filelocation.WithAppUserRuntimeDir(c, runtimeDir)
+	}
+	return c
 }
 
 func (d *service) Version(_ context.Context, _ *empty.Empty) (*common.VersionInfo, error) {
@@ -79,10 +116,10 @@ func (d *service) Version(_ context.Context, _ *empty.Empty) (*common.VersionInf
 }
 
 func (d *service) Status(_ context.Context, _ *empty.Empty) (*rpc.DaemonStatus, error) {
-	r := &rpc.DaemonStatus{
-		OutboundConfig: d.outbound.getInfo(),
-	}
-	return r, nil
+	status := d.outbound.getStatus()
+	status.PrivilegedHelper = d.startedBy.privilegedHelper
+	status.WindowsService = d.startedBy.windowsService
+	return status, nil
 }
 
 func (d *service) Quit(ctx context.Context, _ *empty.Empty) (*empty.Empty, error) {
@@ -92,7 +129,22 @@ func (d *service) Quit(ctx context.Context, _ *empty.Empty) (*empty.Empty, error
 }
 
 func (d *service) SetDnsSearchPath(ctx context.Context, paths *rpc.Paths) (*empty.Empty, error) {
-	d.outbound.setSearchPath(ctx, paths.Paths, paths.Namespaces)
+	if d.startedBy.privilegedHelper {
+		if err := checkSearchPathScope(paths.Paths, paths.Namespaces); err != nil {
+			return nil, err
+		}
+	}
+	d.outbound.setSearchPath(ctx, paths.SessionName, paths.Paths, paths.Namespaces)
+	return &empty.Empty{}, nil
+}
+
+func (d *service) SetHeadlessServices(_ context.Context, services *rpc.HeadlessServices) (*empty.Empty, error) {
+	d.outbound.setHeadlessServices(services.SessionName, services.Services)
+	return &empty.Empty{}, nil
+}
+
+func (d *service) SetRoutedIPs(ctx context.Context, ips *rpc.RoutedIPs) (*empty.Empty, error) {
+	d.outbound.router.setRoutedIPs(ctx, ips.SessionName, ips.Ips)
 	return &empty.Empty{}, nil
 }
 
@@ -100,6 +152,10 @@ func (d *service) SetOutboundInfo(ctx context.Context, info *rpc.OutboundInfo) (
 	return &empty.Empty{}, d.outbound.setInfo(ctx, info)
 }
 
+func (d *service) RemoveSession(ctx context.Context, name *rpc.SessionName) (*empty.Empty, error) {
+	return &empty.Empty{}, d.outbound.removeSession(ctx, name.Name)
+}
+
 func (d *service) SetLogLevel(ctx context.Context, request *manager.LogLevelRequest) (*empty.Empty, error) {
 	duration := time.Duration(0)
 	if request.Duration != nil {
@@ -108,8 +164,27 @@ func (d *service) SetLogLevel(ctx context.Context, request *manager.LogLevelRequ
 	return &empty.Empty{}, logging.SetAndStoreTimedLevel(ctx, d.timedLogLevel, request.LogLevel, duration, ProcessName)
 }
 
+func (d *service) GatherTraces(_ context.Context, _ *empty.Empty) (*common.Traces, error) {
+	return tracing.Traces(), nil
+}
+
+// runOptions tells how the daemon was started.
+type runOptions struct {
+	// privilegedHelper is true when the daemon was started by the privileged helper rather than with sudo
+	privilegedHelper bool
+
+	// uid, when greater than zero, is the id of the only user besides root that may connect to the daemon
+	uid int
+
+	// windowsService is true when the daemon runs as a Windows service
+	windowsService bool
+
+	// stop, when not nil, is closed when the daemon must quit without being told so by the CLI
+	stop <-chan struct{}
+}
+
 // run is the main function when executing as the daemon
-func run(c context.Context, loggingDir, configDir, dns string) error {
+func run(c context.Context, loggingDir, configDir, dns string, opts runOptions) error {
 	if !proc.IsAdmin() {
 		return fmt.Errorf("telepresence %s must run with elevated privileges", ProcessName)
 	}
@@ -123,6 +198,9 @@ func run(c context.Context, loggingDir, configDir, dns string) error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 	c = client.WithConfig(c, cfg)
+	if cfg.Tracing.Enabled {
+		defer tracing.Start(c, ProcessName, tracing.DefaultBufferSize)(c)
+	}
 
 	c = dgroup.WithGoroutineName(c, "/"+ProcessName)
 	c, err = logging.InitContext(c, ProcessName)
@@ -145,6 +223,11 @@ func run(c context.Context, loggingDir, configDir, dns string) error {
 	defer func() {
 		_ = client.RemoveSocket(grpcListener)
 	}()
+	if opts.uid > 0 {
+		if grpcListener, err = helper.RestrictToUser(c, grpcListener, opts.uid); err != nil {
+			return err
+		}
+	}
 	dlog.Debug(c, "Listener opened")
 
 	d := &service{
@@ -164,15 +247,23 @@ func run(c context.Context, loggingDir, configDir, dns string) error {
 		scoutClient:   scout.NewScout(c, "daemon"),
 		scout:         make(chan scout.ScoutReport, 25),
 		timedLogLevel: log.NewTimedLevel(cfg.LogLevels.RootDaemon.String(), log.SetLevel),
+		startedBy:     opts,
 	}
 	if err = logging.LoadTimedLevelFromCache(c, d.timedLogLevel, ProcessName); err != nil {
 		return err
 	}
 
+	// Revert the routes and DNS changes left behind by a previous daemon that didn't terminate
+	// gracefully before new ones are applied.
+	if err = cleanupLeftovers(c, client.DaemonNetworkStateFile(c)); err != nil {
+		dlog.Error(c, err)
+	}
+
 	d.outbound, err = newOutbound(c, dns, false, d.scout)
 	if err != nil {
 		return err
 	}
+	d.outbound.router.helperScope = opts.privilegedHelper
 
 	g := dgroup.NewGroup(c, dgroup.GroupConfig{
 		SoftShutdownTimeout:  2 * time.Second,
@@ -183,6 +274,18 @@ func run(c context.Context, loggingDir, configDir, dns string) error {
 	// The d.cancel will start a "quit" go-routine that will cause the group to initiate a shutdown when it returns.
 	d.cancel = func() { g.Go(ProcessName+"-quit", d.quitAll) }
 
+	if opts.stop != nil {
+		g.Go("service-stop", func(c context.Context) error {
+			select {
+			case <-c.Done():
+				return nil
+			case <-opts.stop:
+				dlog.Info(c, "Received stop request from the service control manager")
+				return d.quitAll(c)
+			}
+		})
+	}
+
 	// server-dns runs a local DNS server that resolves *.cluster.local names.  Exactly where it
 	// listens varies by platform.
 	var scoutUsers sync.WaitGroup // how many of the goroutines might write to d.scout; any that use d.outbound are liable to do this, as it's passed into it.
@@ -226,12 +329,7 @@ func run(c context.Context, loggingDir, configDir, dns string) error {
 			}
 		}()
 
-		opts := []grpc.ServerOption{}
-		if mxRecvSize := client.GetConfig(c).Grpc.MaxReceiveSize; mxRecvSize != nil {
-			if mz, ok := mxRecvSize.AsInt64(); ok {
-				opts = append(opts, grpc.MaxRecvMsgSize(int(mz)))
-			}
-		}
+		opts := append(tracing.ServerOptions(), client.GrpcServerOptions(c)...)
 		svc := grpc.NewServer(opts...)
 		rpc.RegisterDaemonServer(svc, d)
 
@@ -242,10 +340,36 @@ func run(c context.Context, loggingDir, configDir, dns string) error {
 		return sc.Serve(c, grpcListener)
 	})
 
+	// config-watcher reloads the config when the config files change. The timeouts and the telemetry
+	// opt-out are read from the config when they're used, so only the log level, the DNS suffixes, and
+	// the limits of the connection table must be applied.
+	g.Go("config-watcher", func(c context.Context) error {
+		return client.WatchConfig(c, func(c context.Context, r *client.ConfigReload) {
+			d.timedLogLevel.SetDefault(c, r.Current.LogLevels.RootDaemon.String())
+			d.outbound.updateConfigSuffixes(&r.Previous.DNS, &r.Current.DNS)
+			d.outbound.router.handlers.SetLimits(poolLimits(&r.Current.Connections))
+		})
+	})
+
+	// server-metrics serves the Prometheus metrics of the daemon when an address is configured.
+	if address := cfg.Metrics.DaemonAddress(true); address != "" {
+		g.Go("server-metrics", func(c context.Context) error {
+			return metrics.ListenAndServe(c, address)
+		})
+	}
+
 	// background-metriton is the goroutine that handles all telemetry reports, so that calls to
-	// metriton don't block the functional goroutines.
+	// metriton don't block the functional goroutines. The reports are sent in batches by
+	// background-metriton-batch, which sends the remaining reports when background-metriton ends.
+	batcher := d.scoutClient.StartBatching()
+	g.Go("background-metriton-batch", batcher.Run)
 	g.Go("background-metriton", func(c context.Context) error {
+		defer batcher.Close()
 		for report := range d.scout {
+			if d.scoutClient.Disabled() {
+				// Drain the channel so that its users don't block
+				continue
+			}
 			for k, v := range report.PersistentMetadata {
 				d.scoutClient.SetMetadatum(k, v)
 			}
@@ -270,6 +394,8 @@ func run(c context.Context, loggingDir, configDir, dns string) error {
 	if err != nil {
 		dlog.Error(c, err)
 	}
+	// All goroutines that changed the network configuration have reverted their changes.
+	d.outbound.router.state.remove(c)
 	return err
 }
 
@@ -281,7 +407,8 @@ func (d *service) quitAll(c context.Context) error {
 
 // quitConnector ensures that the connector quits gracefully.
 func (d *service) quitConnector(c context.Context) error {
-	exists, err := client.SocketExists(client.ConnectorSocketName)
+	address := client.UserDaemonAddress(c)
+	exists, err := client.SocketExists(c, address)
 	if err != nil {
 		// connector socket problem, so nothing to shut down
 		dlog.Errorf(c, "Daemon cannot quit connector: %v", err)
@@ -296,7 +423,7 @@ func (d *service) quitConnector(c context.Context) error {
 	dlog.Info(c, "Shutting down connector")
 	c, cancel := context.WithTimeout(c, 500*time.Millisecond)
 	defer cancel()
-	conn, err := client.DialSocket(c, client.ConnectorSocketName)
+	conn, err := client.DialSocket(c, address)
 	if err != nil {
 		return nil
 	}
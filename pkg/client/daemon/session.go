@@ -0,0 +1,594 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blang/semver"
+	"google.golang.org/grpc"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dlog"
+	"github.com/datawire/dlib/dtime"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/connpool"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/subnet"
+)
+
+// routerSession is the part of the router that belongs to one session of the user daemon, i.e. to the
+// connection to the traffic-manager of one cluster. The user daemon identifies the session by a name
+// that is unique among its sessions.
+type routerSession struct {
+	// name is the name that the user daemon identifies the session with
+	name string
+
+	// seq is a sequence number that gives the goroutines of the session unique names
+	seq int
+
+	// conn is the connection to the user daemon that carries the calls of managerClient
+	conn *grpc.ClientConn
+
+	// managerClient provides the gRPC tunnel to the traffic-manager of the session
+	managerClient manager.ManagerClient
+
+	// muxTunnel is the bidirectional gRPC tunnel to the traffic-manager of the session. It's nil
+	// when the traffic-manager uses tunnel.Stream.
+	muxTunnel connpool.MuxTunnel
+
+	// info contains the manager session. It's replaced when the connector recovers from a broken
+	// session.
+	info     *manager.SessionInfo
+	infoLock sync.RWMutex
+
+	// clusterDomain reported by the traffic-manager of the session
+	clusterDomain string
+
+	// dnsIP is the IP of the DNS server of the cluster of the session. Protected by the subnetsLock of
+	// the router once the session has been added.
+	dnsIP net.IP
+
+	// mappedNamespacesOnly is true when the outbound traffic policy of the session is
+	// "mappedNamespaces", i.e. when only the IPs of the services, and their pods, in the mapped
+	// namespaces are routed, rather than the cluster subnets.
+	mappedNamespacesOnly bool
+
+	// dockerNetwork is set when the cluster runs in containers on a docker network of the host. The
+	// cluster subnets of the session are then routed via its nodes instead of to the TUN device. It's
+	// cleared when those routes can't be added. Protected by the subnetsLock of the router.
+	dockerNetwork *daemon.DockerNetworkRoutes
+
+	// The subnets below are protected by the subnetsLock of the router.
+
+	// Cluster subnets reported by the traffic-manager of the session
+	clusterSubnets []*net.IPNet
+
+	// The cluster subnets that contain the services, one per IP family
+	serviceSubnets []*net.IPNet
+
+	// The cluster subnets that are routed to this session, i.e. those that don't overlap with a cluster
+	// subnet of a session that was added before this one.
+	routedClusterSubnets []*net.IPNet
+
+	// Subnets configured for the session by the user
+	alsoProxySubnets        []*net.IPNet
+	neverProxySubnets       []*net.IPNet
+	allowConflictingSubnets []*net.IPNet
+
+	// cfgComplete will be closed as soon as the cluster info of the session has been received
+	cfgComplete chan struct{}
+
+	// tmVerOk will be closed as soon as the correct tunnel version has been negotiated with the
+	// traffic manager of the session
+	tmVerOk chan struct{}
+
+	// removed is closed when the user daemon removes the session
+	removed     chan struct{}
+	removedOnce sync.Once
+}
+
+func (s *routerSession) getInfo() *manager.SessionInfo {
+	s.infoLock.RLock()
+	defer s.infoLock.RUnlock()
+	return s.info
+}
+
+func (s *routerSession) setInfo(info *manager.SessionInfo) {
+	s.infoLock.Lock()
+	s.info = info
+	s.infoLock.Unlock()
+}
+
+func (s *routerSession) remove() {
+	s.removedOnce.Do(func() { close(s.removed) })
+}
+
+// displayName returns the name of the session, or a placeholder for the unnamed session of a user
+// daemon that predates multiple sessions.
+func (s *routerSession) displayName() string {
+	if s.name == "" {
+		return "<default>"
+	}
+	return s.name
+}
+
+// getSessions returns the current sessions, in the order that they were added.
+func (t *tunRouter) getSessions() []*routerSession {
+	t.sessionLock.RLock()
+	defer t.sessionLock.RUnlock()
+	return t.sessions
+}
+
+// getSession returns the session with the given name, or nil when no such session exists.
+func (t *tunRouter) getSession(name string) *routerSession {
+	for _, s := range t.getSessions() {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// firstSession returns the session that was added first, or nil when there are no sessions.
+func (t *tunRouter) firstSession() *routerSession {
+	if ss := t.getSessions(); len(ss) > 0 {
+		return ss[0]
+	}
+	return nil
+}
+
+// sessionFor returns the session that the given destination IP is routed to. That's the session
+// whose routed cluster subnets contain the IP, or the first session when no cluster subnet does, which
+// is the case for the also-proxy subnets.
+func (t *tunRouter) sessionFor(ip net.IP) *routerSession {
+	ss := t.getSessions()
+	if len(ss) == 0 {
+		return nil
+	}
+	if len(ss) > 1 {
+		t.subnetsLock.RLock()
+		defer t.subnetsLock.RUnlock()
+		for _, s := range ss {
+			for _, sn := range s.routedClusterSubnets {
+				if sn.Contains(ip) {
+					return s
+				}
+			}
+			for _, sn := range s.alsoProxySubnets {
+				if sn.Contains(ip) {
+					return s
+				}
+			}
+		}
+	}
+	return ss[0]
+}
+
+// addSession dials the user daemon with the name of a new session, applies the given outbound info to
+// it, and starts it.
+func (t *tunRouter) addSession(ctx context.Context, mi *daemon.OutboundInfo) error {
+	clientConfig := client.GetConfig(ctx)
+	tos := &clientConfig.Timeouts
+	tc, cancel := tos.TimeoutContext(ctx, client.TimeoutTrafficManagerAPI)
+	defer cancel()
+
+	conn, err := client.DialSocket(tc, client.UserDaemonAddress(tc), client.SessionNameDialOptions(mi.SessionName)...)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// The connector called us, and then it died which means we will die too. This is
+			// a race, but it's not an error.
+			return nil
+		}
+		return client.CheckTimeout(tc, err)
+	}
+
+	s := &routerSession{
+		name:                    mi.SessionName,
+		conn:                    conn,
+		managerClient:           manager.NewManagerClient(conn),
+		info:                    mi.Session,
+		dnsIP:                   mi.Dns.GetRemoteIp(),
+		mappedNamespacesOnly:    mi.OutboundTrafficPolicy == client.OutboundTrafficPolicyMappedNamespaces,
+		dockerNetwork:           dockerNetworkFromRPC(mi.DockerNetwork),
+		alsoProxySubnets:        subnetsFromRPC(ctx, "also-proxy", mi.AlsoProxySubnets),
+		neverProxySubnets:       subnetsFromRPC(ctx, "never-proxy", mi.NeverProxySubnets),
+		allowConflictingSubnets: subnetsFromRPC(ctx, "", mi.AllowConflictingSubnets),
+		cfgComplete:             make(chan struct{}),
+		tmVerOk:                 make(chan struct{}),
+		removed:                 make(chan struct{}),
+	}
+
+	t.sessionLock.Lock()
+	first := t.sessionSeq == 0
+	t.sessionSeq++
+	s.seq = t.sessionSeq
+	t.sessions = append(t.sessions, s)
+	t.sessionLock.Unlock()
+
+	if first {
+		// The TUN device is shared by all sessions, so its MTU is configured by the first one
+		t.configureMTU(ctx, mi.Mtu)
+	} else if mi.Mtu != t.mtu {
+		dlog.Warnf(ctx, "The MTU of the TUN device remains unchanged. A change from %d to %d requires that all sessions are disconnected", t.mtu, mi.Mtu)
+	}
+
+	t.subnetsLock.Lock()
+	t.mergeSessionSubnets()
+	t.subnetsLock.Unlock()
+	if s.mappedNamespacesOnly {
+		dlog.Infof(ctx, "Session %s only routes the IPs of the services in its mapped namespaces", s.displayName())
+		t.scheduleHostRoutes()
+	}
+	if dn := s.dockerNetwork; dn != nil {
+		dlog.Infof(ctx, "Session %s routes its cluster subnets via %s on the docker network of a local cluster", s.displayName(), net.IP(dn.Gateway))
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case t.sessionCh <- s:
+	}
+
+	// Wait until the cluster subnets have been routed, so that the connector can report
+	// conflicting subnets in the result of the connect.
+	select {
+	case <-tc.Done():
+		dlog.Warn(ctx, "timeout waiting for the cluster subnets to be routed")
+	case <-s.cfgComplete:
+	}
+	return nil
+}
+
+// removeSession stops the session with the given name and removes its subnets from the TUN device.
+func (t *tunRouter) removeSession(ctx context.Context, name string) error {
+	t.sessionLock.Lock()
+	var s *routerSession
+	for i, es := range t.sessions {
+		if es.name == name {
+			s = es
+			t.sessions = append(t.sessions[:i:i], t.sessions[i+1:]...)
+			break
+		}
+	}
+	t.sessionLock.Unlock()
+	t.subnetsLock.Lock()
+	delete(t.routedIPs, name)
+	t.subnetsLock.Unlock()
+	t.scheduleHostRoutes()
+	if s == nil {
+		return fmt.Errorf("no session named %q", name)
+	}
+	dlog.Infof(ctx, "Removing session %s", s.displayName())
+	s.remove()
+	_ = s.conn.Close()
+
+	t.subnetsLock.Lock()
+	t.mergeSessionSubnets()
+	t.subnetsLock.Unlock()
+	return t.refreshSubnets(ctx)
+}
+
+func subnetsFromRPC(ctx context.Context, kind string, rpcSubnets []*manager.IPNet) []*net.IPNet {
+	if len(rpcSubnets) == 0 {
+		return nil
+	}
+	sns := make([]*net.IPNet, len(rpcSubnets))
+	for i, rsn := range rpcSubnets {
+		sns[i] = iputil.IPNetFromRPC(rsn)
+		if kind != "" {
+			dlog.Infof(ctx, "Adding %s subnet %s", kind, sns[i])
+		}
+	}
+	return sns
+}
+
+// dockerNetworkFromRPC returns the given routes, or nil when they have no valid gateway.
+func dockerNetworkFromRPC(dn *daemon.DockerNetworkRoutes) *daemon.DockerNetworkRoutes {
+	if gw := dn.GetGateway(); len(gw) != net.IPv4len && len(gw) != net.IPv6len {
+		return nil
+	}
+	return dn
+}
+
+// mergeSessionSubnets assigns the union of the subnets of all sessions to the router. A cluster subnet
+// of a session that overlaps with a cluster subnet of a session that was added before it isn't routed,
+// because the router couldn't tell which cluster a packet is destined for. It's reported as a session
+// conflict instead. Must be called with the subnetsLock held.
+func (t *tunRouter) mergeSessionSubnets() {
+	var clusterSubnets, serviceSubnets, alsoProxy, neverProxy, allowConflicting []*net.IPNet
+	var conflicts []*daemon.SessionConflict
+	sessions := t.getSessions()
+	for i, s := range sessions {
+		var routed []*net.IPNet
+	nextSubnet:
+		for _, sn := range s.clusterSubnets {
+			for _, other := range sessions[:i] {
+				for _, osn := range other.routedClusterSubnets {
+					if subnet.Overlaps(sn, osn) {
+						conflicts = append(conflicts, &daemon.SessionConflict{
+							SessionName:      s.name,
+							Subnet:           iputil.IPNetToRPC(sn),
+							OtherSessionName: other.name,
+							OtherSubnet:      iputil.IPNetToRPC(osn),
+						})
+						continue nextSubnet
+					}
+				}
+			}
+			routed = append(routed, sn)
+			if s.mappedNamespacesOnly || s.viaDockerNetwork(sn) {
+				continue
+			}
+			for _, ssn := range s.serviceSubnets {
+				if subnet.Equal(sn, ssn) {
+					serviceSubnets = append(serviceSubnets, sn)
+					break
+				}
+			}
+		}
+		s.routedClusterSubnets = routed
+		if !s.mappedNamespacesOnly {
+			// A session that only routes the IPs in its mapped namespaces uses host routes instead,
+			// but its cluster subnets still tell which session an IP belongs to. The same goes for
+			// the subnets that are routed via the docker network of a local cluster.
+			for _, sn := range routed {
+				if !s.viaDockerNetwork(sn) {
+					clusterSubnets = append(clusterSubnets, sn)
+				}
+			}
+		}
+		alsoProxy = append(alsoProxy, s.alsoProxySubnets...)
+		neverProxy = append(neverProxy, s.neverProxySubnets...)
+		allowConflicting = append(allowConflicting, s.allowConflictingSubnets...)
+	}
+	t.clusterSubnets = clusterSubnets
+	t.serviceSubnets = serviceSubnets
+	t.alsoProxySubnets = subnet.Unique(alsoProxy)
+	t.neverProxySubnets = subnet.Unique(neverProxy)
+	t.allowConflictingSubnets = subnet.Unique(allowConflicting)
+	t.sessionConflicts = conflicts
+}
+
+// sessionConflictsToRPC returns the cluster subnets that aren't routed because they overlap with the
+// cluster subnets of another session.
+func (t *tunRouter) sessionConflictsToRPC() []*daemon.SessionConflict {
+	t.subnetsLock.RLock()
+	defer t.subnetsLock.RUnlock()
+	return t.sessionConflicts
+}
+
+// runSession runs the goroutines of the given session until the session is removed or the context
+// is cancelled.
+func (t *tunRouter) runSession(ctx context.Context, s *routerSession) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.removed:
+			cancel()
+		}
+	}()
+
+	g := dgroup.NewGroup(ctx, dgroup.GroupConfig{})
+	g.Go("watch-cluster-info", func(ctx context.Context) error {
+		err := t.watchClusterInfo(ctx, s)
+		var recvErr *client.RecvEOF
+		if errors.As(err, &recvErr) {
+			// If the remote end, which is the connector, has hung up mid-stream, that usually means that
+			// the daemon will be shutting down soon too.
+			<-ctx.Done()
+		}
+		return err
+	})
+	g.Go("MGR stream", func(ctx context.Context) error {
+		return t.runManagerStream(ctx, s)
+	})
+	err := g.Wait()
+	select {
+	case <-s.removed:
+		// Errors caused by the removal are of no interest
+		return nil
+	default:
+		return err
+	}
+}
+
+// watchClusterInfo watches the cluster info of the traffic-manager of the given session. A stream that
+// is broken by a broken session is watched again, using the session that the connector replaces it with.
+func (t *tunRouter) watchClusterInfo(ctx context.Context, s *routerSession) error {
+	cfgComplete := s.cfgComplete
+	backoff := 100 * time.Millisecond
+	for {
+		err := t.watchClusterInfoStream(ctx, s, &cfgComplete)
+		if ctx.Err() != nil {
+			return nil
+		}
+		// The stream also ends when the connector hangs up, and then the daemon will be told to quit
+		// soon, so there's no reason to be loud about it.
+		var recvErr *client.RecvEOF
+		if errors.As(err, &recvErr) {
+			dlog.Infof(ctx, "%v, retrying in %s", err, backoff)
+		} else {
+			dlog.Errorf(ctx, "%v, retrying in %s", err, backoff)
+		}
+		dtime.SleepWithContext(ctx, backoff)
+		backoff *= 2
+		if backoff > 3*time.Second {
+			backoff = 3 * time.Second
+		}
+	}
+}
+
+func (t *tunRouter) watchClusterInfoStream(ctx context.Context, s *routerSession, cfgCompletePtr *chan struct{}) error {
+	infoStream, err := s.managerClient.WatchClusterInfo(ctx, s.getInfo())
+	if err != nil {
+		return fmt.Errorf("error when calling WatchClusterInfo: %w", err)
+	}
+
+	for {
+		mgrInfo, err := infoStream.Recv()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return client.WrapRecvErr(err, "error when reading WatchClusterInfo")
+		}
+
+		t.setClusterSubnets(ctx, s, mgrInfo)
+
+		if cfgComplete := *cfgCompletePtr; cfgComplete != nil {
+			// Only set clusterDNS when it hasn't been explicitly set with the --dns option
+			t.subnetsLock.Lock()
+			if s.dnsIP == nil {
+				if err := t.checkDNSIPScope(mgrInfo.KubeDnsIp); err != nil {
+					dlog.Errorf(ctx, "Cluster DNS %s is not used: %v", net.IP(mgrInfo.KubeDnsIp), err)
+				} else {
+					dlog.Infof(ctx, "Setting cluster DNS to %s", net.IP(mgrInfo.KubeDnsIp))
+					s.dnsIP = mgrInfo.KubeDnsIp
+				}
+			}
+			t.subnetsLock.Unlock()
+			if s.mappedNamespacesOnly {
+				// The cluster DNS IP gets a host route
+				t.scheduleHostRoutes()
+			}
+			dlog.Infof(ctx, "Setting cluster domain to %q", mgrInfo.ClusterDomain)
+			s.clusterDomain = mgrInfo.ClusterDomain
+			if s.clusterDomain == "" {
+				// Traffic manager predates 2.4.3 and doesn't report a cluster domain. Only thing
+				// left to do then is to assume it's the standard one.
+				s.clusterDomain = "cluster.local."
+			} else if err := t.checkDomainScope(s.clusterDomain); err != nil {
+				dlog.Errorf(ctx, "Cluster domain %q is not used: %v", s.clusterDomain, err)
+				s.clusterDomain = "cluster.local."
+			}
+			t.cfgOnce.Do(func() {
+				// The DNS server of the TUN device and the cluster domain of the local resolver are
+				// those of the first session that is configured.
+				t.dnsIP = s.dnsIP
+				t.clusterDomain = s.clusterDomain
+				close(t.cfgComplete)
+			})
+			close(cfgComplete)
+			*cfgCompletePtr = nil
+		}
+	}
+}
+
+// setClusterSubnets updates the cluster subnets of the given session to those of the given cluster info,
+// and the routes of the TUN device to the changed subnets. The traffic-manager sends a new cluster info when
+// the pod subnets change, e.g. when nodes join or leave the cluster, so only the routes of the subnets that
+// were added or removed change.
+func (t *tunRouter) setClusterSubnets(ctx context.Context, s *routerSession, mgrInfo *manager.ClusterInfo) {
+	rpcServiceSubnets := mgrInfo.ServiceSubnets
+	if len(rpcServiceSubnets) == 0 && mgrInfo.ServiceSubnet != nil {
+		// Traffic manager predates dual-stack support and reports one service subnet.
+		rpcServiceSubnets = []*manager.IPNet{mgrInfo.ServiceSubnet}
+	}
+	subnets := make([]*net.IPNet, 0, len(rpcServiceSubnets)+len(mgrInfo.PodSubnets))
+	serviceSubnets := make([]*net.IPNet, len(rpcServiceSubnets))
+	for i, sn := range rpcServiceSubnets {
+		cidr := iputil.IPNetFromRPC(sn)
+		serviceSubnets[i] = cidr
+		subnets = append(subnets, cidr)
+	}
+	for _, sn := range mgrInfo.PodSubnets {
+		subnets = append(subnets, iputil.IPNetFromRPC(sn))
+	}
+	if t.helperScope {
+		serviceSubnets = subnetsInScope(ctx, serviceSubnets)
+		subnets = subnetsInScope(ctx, subnets)
+	}
+	dlog.Debugf(ctx, "Session %s has service subnets %v and cluster subnets %v", s.name, serviceSubnets, subnets)
+
+	t.subnetsLock.Lock()
+	s.clusterSubnets = subnets
+	s.serviceSubnets = serviceSubnets
+	t.mergeSessionSubnets()
+	for _, c := range t.sessionConflicts {
+		if c.SessionName == s.name {
+			dlog.Errorf(ctx, "Subnet %s is not routed because it overlaps with subnet %s of session %s",
+				iputil.IPNetFromRPC(c.Subnet), iputil.IPNetFromRPC(c.OtherSubnet), c.OtherSessionName)
+		}
+	}
+	t.subnetsLock.Unlock()
+	if err := t.refreshSubnets(ctx); err != nil {
+		dlog.Error(ctx, err)
+	}
+}
+
+// runManagerStream negotiates the tunnel version with the traffic-manager of the given session and,
+// when the traffic-manager is old enough to need it, runs its multiplexing tunnel.
+func (t *tunRouter) runManagerStream(c context.Context, s *routerSession) error {
+	dlog.Debug(c, "Waiting until manager gRPC is configured")
+	select {
+	case <-c.Done():
+		return nil
+	case <-s.cfgComplete:
+	}
+	ver, err := s.managerClient.Version(c, &empty.Empty{})
+	if err != nil {
+		return err
+	}
+	verStr := strings.TrimPrefix(ver.Version, "v")
+	dlog.Infof(c, "Connected to Manager %s", verStr)
+	mgrVer, err := semver.Parse(verStr)
+	if err != nil {
+		return fmt.Errorf("failed to parse manager version %q: %s", verStr, err)
+	}
+
+	clientTunnel, err := s.managerClient.ClientTunnel(c)
+	if err != nil {
+		return err
+	}
+	muxTunnel := connpool.NewMuxTunnel(clientTunnel)
+	if err = muxTunnel.Send(c, connpool.SessionInfoControl(s.getInfo())); err != nil {
+		return err
+	}
+
+	var peerVersion uint16
+	if mgrVer.LE(semver.MustParse("2.4.2")) {
+		peerVersion = 0
+	} else {
+		if err = muxTunnel.Send(c, connpool.VersionControl()); err != nil {
+			return err
+		}
+		peerVersion, err = muxTunnel.ReadPeerVersion(c)
+		if err != nil {
+			return err
+		}
+	}
+	// Versions >= 2 don't use connpool.Tunnel. They use tunnel.Stream.
+	if peerVersion < 2 {
+		s.muxTunnel = muxTunnel
+		s.versionOk(t)
+		dlog.Debug(c, "MGR read loop starting")
+		err = s.muxTunnel.DialLoop(c, t.handlers)
+		var recvErr *client.RecvEOF
+		if errors.As(err, &recvErr) {
+			<-c.Done()
+		}
+	} else {
+		s.versionOk(t)
+		dlog.Debug(c, "closing since a more recent system detected")
+		err = muxTunnel.CloseSend()
+	}
+	return err
+}
+
+// versionOk closes the tmVerOk channel of the session, and the one of the router when it's the first
+// session to negotiate a version.
+func (s *routerSession) versionOk(t *tunRouter) {
+	close(s.tmVerOk)
+	t.verOnce.Do(func() { close(t.tmVerOk) })
+}
@@ -0,0 +1,186 @@
+package daemon
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/buffer"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/ip"
+)
+
+// tunBatchSize is the maximum number of packets that are read from the TUN device in one call.
+const tunBatchSize = 64
+
+// packetReader is the part of the vif.Device that the TUN reader uses.
+type packetReader interface {
+	ReadPackets(bufs []*buffer.Data) (int, error)
+}
+
+// packetWriter is the part of the vif.Device that the tunWriter uses.
+type packetWriter interface {
+	WritePackets(bufs []*buffer.Data) (int, error)
+}
+
+// packetBatch is a batch of packets that the TUN reader passes to the packet dispatcher. Batches are
+// pooled, and the dispatcher takes over the ownership of the buffers of each batch.
+type packetBatch struct {
+	bufs [tunBatchSize]*buffer.Data
+	n    int
+}
+
+var packetBatchPool = sync.Pool{New: func() interface{} { return &packetBatch{} }}
+
+func (pb *packetBatch) packets() []*buffer.Data {
+	return pb.bufs[:pb.n]
+}
+
+// release returns the batch to the pool. The buffers of the batch aren't affected.
+func (pb *packetBatch) release() {
+	for i := 0; i < pb.n; i++ {
+		pb.bufs[i] = nil
+	}
+	pb.n = 0
+	packetBatchPool.Put(pb)
+}
+
+// readTunBatches reads batches of packets from the given device, and sends them to the given channel in
+// the order that they were read, until the read fails or the context is cancelled. The buffers are taken
+// from the buffer.DataPool, and the buffers that a read leaves unused are kept for the next read.
+func readTunBatches(c context.Context, dev packetReader, batches chan<- *packetBatch) error {
+	bufs := make([]*buffer.Data, tunBatchSize)
+	defer func() {
+		for _, b := range bufs {
+			if b != nil {
+				buffer.DataPool.Put(b)
+			}
+		}
+	}()
+	for {
+		for i, b := range bufs {
+			if b == nil {
+				bufs[i] = buffer.DataPool.Get(buffer.DataPool.MTU)
+			}
+		}
+		n, err := dev.ReadPackets(bufs)
+		if n > 0 {
+			pb := packetBatchPool.Get().(*packetBatch)
+			pb.n = copy(pb.bufs[:], bufs[:n])
+			for i := 0; i < n; i++ {
+				bufs[i] = nil
+			}
+			select {
+			case <-c.Done():
+				for _, b := range pb.packets() {
+					buffer.DataPool.Put(b)
+				}
+				pb.release()
+				return nil
+			case batches <- pb:
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// tunWriter is an ip.Writer that writes the packets of concurrent writers in batches. A writer that finds
+// no write in progress writes its packet right away, so a lone packet isn't delayed. The packets that
+// arrive while a write is in progress are queued, and written in one batch by the first writer in the
+// queue once the write in progress is done. Each writer waits until its own packet is written, so the
+// packets of one connection retain their order.
+type tunWriter struct {
+	dev packetWriter
+
+	sync.Mutex
+	writing bool
+	queue   []*pendingWrite
+	spare   []*pendingWrite
+
+	// bufs is only used by the writer that writes the current batch
+	bufs []*buffer.Data
+}
+
+// pendingWrite is a packet in the queue of a tunWriter. The done channel receives true when the packet
+// has been written, or false when its writer must write the queue.
+type pendingWrite struct {
+	data *buffer.Data
+	err  error
+	done chan bool
+}
+
+var pendingWritePool = sync.Pool{New: func() interface{} { return &pendingWrite{done: make(chan bool, 1)} }}
+
+func newTunWriter(dev packetWriter) *tunWriter {
+	return &tunWriter{dev: dev}
+}
+
+func (w *tunWriter) Write(ctx context.Context, pkt ip.Packet) error {
+	dlog.Tracef(ctx, "-> TUN %s", pkt)
+	pw := pendingWritePool.Get().(*pendingWrite)
+	pw.data = pkt.Data()
+	defer func() {
+		pw.data = nil
+		pw.err = nil
+		pendingWritePool.Put(pw)
+	}()
+
+	w.Lock()
+	w.queue = append(w.queue, pw)
+	if w.writing {
+		w.Unlock()
+		if <-pw.done {
+			return pw.err
+		}
+	} else {
+		w.writing = true
+		w.Unlock()
+	}
+	w.writeQueue(pw)
+	return pw.err
+}
+
+// writeQueue writes the queued packets, which include the given one, in one batch, and then hands the
+// writing over to the first writer of the packets that were queued meanwhile.
+func (w *tunWriter) writeQueue(own *pendingWrite) {
+	w.Lock()
+	batch := w.queue
+	w.queue = w.spare
+	w.spare = nil
+	w.Unlock()
+
+	bufs := w.bufs[:0]
+	for _, pw := range batch {
+		bufs = append(bufs, pw.data)
+	}
+	n, err := w.dev.WritePackets(bufs)
+	if err == nil && n < len(bufs) {
+		err = io.ErrShortWrite
+	}
+	for i, pw := range batch {
+		if i >= n {
+			pw.err = err
+		}
+		if pw != own {
+			pw.done <- true
+		}
+	}
+	for i := range bufs {
+		bufs[i] = nil
+	}
+	w.bufs = bufs
+	for i := range batch {
+		batch[i] = nil
+	}
+
+	w.Lock()
+	w.spare = batch[:0]
+	if len(w.queue) > 0 {
+		w.queue[0].done <- false
+	} else {
+		w.writing = false
+	}
+	w.Unlock()
+}
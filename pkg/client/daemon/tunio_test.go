@@ -0,0 +1,250 @@
+package daemon
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/buffer"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/ip"
+)
+
+// seqPacket is an ip.Packet that carries a sender and a sequence number.
+type seqPacket struct {
+	data *buffer.Data
+}
+
+func newSeqPacket(sender, seq int) *seqPacket {
+	data := buffer.DataPool.Get(8)
+	binary.BigEndian.PutUint32(data.Buf(), uint32(sender))
+	binary.BigEndian.PutUint32(data.Buf()[4:], uint32(seq))
+	return &seqPacket{data: data}
+}
+
+func (p *seqPacket) IPHeader() ip.Header                            { return nil }
+func (p *seqPacket) Data() *buffer.Data                             { return p.data }
+func (p *seqPacket) Release()                                       { buffer.DataPool.Put(p.data) }
+func (p *seqPacket) SetDataAndIPHeader(d *buffer.Data, _ ip.Header) { p.data = d }
+func (p *seqPacket) String() string                                 { return "seq packet" }
+
+// recordingDevice records the sender and sequence number of each packet written to it. Each write takes
+// a while, so that the writes of concurrent writers are queued.
+type recordingDevice struct {
+	sync.Mutex
+	seqs    map[int][]int
+	batches int
+}
+
+func (d *recordingDevice) WritePackets(bufs []*buffer.Data) (int, error) {
+	time.Sleep(20 * time.Microsecond)
+	d.Lock()
+	defer d.Unlock()
+	d.batches++
+	for _, b := range bufs {
+		buf := b.Buf()
+		sender := int(binary.BigEndian.Uint32(buf))
+		d.seqs[sender] = append(d.seqs[sender], int(binary.BigEndian.Uint32(buf[4:])))
+	}
+	return len(bufs), nil
+}
+
+func TestTunWriter_order(t *testing.T) {
+	const senders = 16
+	const count = 500
+	// Trace logging would serialize the writers, so a plain context is used here.
+	ctx := context.Background()
+	dev := &recordingDevice{seqs: make(map[int][]int)}
+	w := newTunWriter(dev)
+
+	wg := sync.WaitGroup{}
+	wg.Add(senders)
+	for s := 0; s < senders; s++ {
+		go func(s int) {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				pkt := newSeqPacket(s, i)
+				assert.NoError(t, w.Write(ctx, pkt))
+				pkt.Release()
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	for s := 0; s < senders; s++ {
+		seqs := dev.seqs[s]
+		require.Len(t, seqs, count)
+		for i, seq := range seqs {
+			require.Equal(t, i, seq, "packet of sender %d out of order", s)
+		}
+	}
+	assert.False(t, w.writing)
+	assert.Empty(t, w.queue)
+	assert.Less(t, dev.batches, senders*count)
+}
+
+type failingDevice struct{}
+
+func (failingDevice) WritePackets(bufs []*buffer.Data) (int, error) {
+	return len(bufs) / 2, io.ErrClosedPipe
+}
+
+func TestTunWriter_error(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	w := newTunWriter(failingDevice{})
+	pkt := newSeqPacket(0, 0)
+	defer pkt.Release()
+	assert.ErrorIs(t, w.Write(ctx, pkt), io.ErrClosedPipe)
+	assert.False(t, w.writing)
+}
+
+// scriptedReader returns the given number of packets on each read, numbering them in the order that
+// they're read, and then fails.
+type scriptedReader struct {
+	reads []int
+	seq   int
+}
+
+var errScriptDone = errors.New("script done")
+
+func (r *scriptedReader) ReadPackets(bufs []*buffer.Data) (int, error) {
+	if len(r.reads) == 0 {
+		return 0, errScriptDone
+	}
+	n := r.reads[0]
+	r.reads = r.reads[1:]
+	for i := 0; i < n; i++ {
+		bufs[i].SetLength(4)
+		binary.BigEndian.PutUint32(bufs[i].Buf(), uint32(r.seq))
+		r.seq++
+	}
+	return n, nil
+}
+
+func TestReadTunBatches(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	rd := &scriptedReader{reads: []int{1, tunBatchSize, 0, 3}}
+	batches := make(chan *packetBatch, 10)
+	require.ErrorIs(t, readTunBatches(ctx, rd, batches), errScriptDone)
+	close(batches)
+
+	var sizes []int
+	seq := 0
+	for pb := range batches {
+		sizes = append(sizes, pb.n)
+		for _, data := range pb.packets() {
+			assert.Equal(t, uint32(seq), binary.BigEndian.Uint32(data.Buf()))
+			seq++
+			buffer.DataPool.Put(data)
+		}
+		pb.release()
+	}
+	assert.Equal(t, []int{1, tunBatchSize, 3}, sizes)
+}
+
+func TestReadTunBatches_cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	rd := &scriptedReader{reads: []int{2, 2}}
+	batches := make(chan *packetBatch)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- readTunBatches(ctx, rd, batches)
+	}()
+
+	// The first batch is received, the second is discarded when the context is cancelled.
+	pb := <-batches
+	assert.Equal(t, 2, pb.n)
+	cancel()
+	assert.NoError(t, <-errCh)
+}
+
+type loopReader struct {
+	n int
+}
+
+func (r *loopReader) ReadPackets(bufs []*buffer.Data) (int, error) {
+	if r.n <= 0 {
+		return 0, io.EOF
+	}
+	n := len(bufs)
+	if n > r.n {
+		n = r.n
+	}
+	for i := 0; i < n; i++ {
+		bufs[i].SetLength(64)
+	}
+	r.n -= n
+	return n, nil
+}
+
+// BenchmarkDispatch_perPacket passes one packet at a time from the reader to the dispatcher, the way
+// the TUN reader did before it read batches. Compare with BenchmarkDispatch_batched.
+func BenchmarkDispatch_perPacket(b *testing.B) {
+	rd := &loopReader{n: b.N}
+	ch := make(chan *buffer.Data, 100)
+	done := make(chan struct{})
+	go func() {
+		for data := range ch {
+			buffer.DataPool.Put(data)
+		}
+		close(done)
+	}()
+	bufs := make([]*buffer.Data, 1)
+	b.ResetTimer()
+	for {
+		bufs[0] = buffer.DataPool.Get(buffer.DataPool.MTU)
+		if n, _ := rd.ReadPackets(bufs); n == 0 {
+			buffer.DataPool.Put(bufs[0])
+			break
+		}
+		ch <- bufs[0]
+	}
+	close(ch)
+	<-done
+}
+
+func BenchmarkDispatch_batched(b *testing.B) {
+	rd := &loopReader{n: b.N}
+	ch := make(chan *packetBatch, 16)
+	done := make(chan struct{})
+	go func() {
+		for pb := range ch {
+			for _, data := range pb.packets() {
+				buffer.DataPool.Put(data)
+			}
+			pb.release()
+		}
+		close(done)
+	}()
+	b.ResetTimer()
+	_ = readTunBatches(context.Background(), rd, ch)
+	close(ch)
+	<-done
+}
+
+// BenchmarkTunWriter writes from concurrent connections through the tunWriter, which batches the writes
+// that overlap.
+func BenchmarkTunWriter(b *testing.B) {
+	ctx := context.Background()
+	w := newTunWriter(discardDevice{})
+	b.RunParallel(func(pb *testing.PB) {
+		pkt := newSeqPacket(0, 0)
+		defer pkt.Release()
+		for pb.Next() {
+			_ = w.Write(ctx, pkt)
+		}
+	})
+}
+
+type discardDevice struct{}
+
+func (discardDevice) WritePackets(bufs []*buffer.Data) (int, error) {
+	return len(bufs), nil
+}
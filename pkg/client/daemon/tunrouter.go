@@ -6,24 +6,21 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
-	"os"
-	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/blang/semver"
 	"golang.org/x/net/ipv4"
-	"google.golang.org/grpc"
-	empty "google.golang.org/protobuf/types/known/emptypb"
 
+	"github.com/datawire/dlib/dcontext"
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
-	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
-	"github.com/telepresenceio/telepresence/v2/pkg/connpool"
 	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/metrics"
+	"github.com/telepresenceio/telepresence/v2/pkg/routing"
 	"github.com/telepresenceio/telepresence/v2/pkg/subnet"
 	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
 	"github.com/telepresenceio/telepresence/v2/pkg/vif"
@@ -60,15 +57,25 @@ import (
 // takes place in the client and the same bidirectional muxTunnel is then used to send both TCP and UDP
 // packets to the manager. TCP will send some control packets. One to verify that a connection can
 // be established at the manager side, and one when the connection is closed (from either side).
+// routeProgrammer adds and removes the routes of subnets to the TUN device.
+type routeProgrammer interface {
+	AddSubnet(context.Context, *net.IPNet) error
+	RemoveSubnet(context.Context, *net.IPNet) error
+}
+
 type tunRouter struct {
+	// lastActivity is the time, in Unix nanoseconds, of the last packet that was routed to the
+	// cluster. It's first in the struct to guarantee 64-bit alignment of atomic access.
+	lastActivity int64
+
 	// dev is the TUN device that gets configured with the subnets found in the cluster
 	dev *vif.Device
 
-	// managerClient provides the gRPC tunnel to the traffic-manager
-	managerClient manager.ManagerClient
+	// routes programs the routes of the dev. It's the dev itself, except in tests.
+	routes routeProgrammer
 
-	// tunnel is the bidirectional gRPC tunnel to the traffic-manager
-	muxTunnel connpool.MuxTunnel
+	// writer writes the packets of all handlers to the dev in batches
+	writer *tunWriter
 
 	// connPool contains handlers that represent active connections. Those handlers
 	// are obtained using a connpool.ConnID.
@@ -87,38 +94,104 @@ type tunRouter struct {
 	// dnsLocalAddr is the address of the local DNS server
 	dnsLocalAddr *net.UDPAddr
 
-	// clusterDomain reported by the traffic-manager
+	// clusterDomain reported by the traffic-manager of the first session
 	clusterDomain string
 
-	// Cluster subnets reported by the traffic-manager
+	// Cluster subnets reported by the traffic-managers of all sessions, except those that overlap
+	// with the cluster subnets of an earlier session
 	clusterSubnets []*net.IPNet
 
+	// The cluster subnets that contain the services, one per IP family and session. Also present in
+	// clusterSubnets.
+	serviceSubnets []*net.IPNet
+
 	// Subnets configured by the user
 	alsoProxySubnets []*net.IPNet
 
+	// Subnets that the user, or the connector on behalf of the API server, never wants routed
+	// to the cluster. They are subtracted from the other subnets.
+	neverProxySubnets []*net.IPNet
+
+	// Subnets that are routed even when they collide with the routes of another VPN
+	allowConflictingSubnets []*net.IPNet
+
+	// Conflicts between the desired subnets and the routes of the local host, found by the
+	// last refreshSubnets() call
+	subnetConflicts []*routing.Conflict
+
+	// Cluster subnets of a session that aren't routed because they overlap with the cluster subnets of
+	// an earlier session
+	sessionConflicts []*daemon.SessionConflict
+
 	// Subnets that the router is currently configured with. Managed, and only used in
 	// the refreshSubnets() method.
 	curSubnets []*net.IPNet
 
+	// The IPs of the services, and their pods, in the mapped namespaces of each session, keyed by
+	// session name. They get host routes when the outbound traffic policy of the session is
+	// "mappedNamespaces".
+	routedIPs map[string][]net.IP
+
+	// Host routes that the router is currently configured with, keyed by IP. Managed, and only used
+	// in the refreshHostRoutes() method.
+	hostRoutes map[string]*net.IPNet
+
+	// hostRoutesCh schedules an update of the host routes
+	hostRoutesCh chan struct{}
+
+	// Routes of cluster subnets via the nodes of local clusters that the router is currently
+	// configured with, keyed by their string form. Managed, and only used, in the
+	// refreshGatewayRoutesLocked() method.
+	gatewayRoutes map[string]*gatewayRoute
+
+	// subnetsLock protects clusterSubnets, serviceSubnets, alsoProxySubnets, neverProxySubnets,
+	// allowConflictingSubnets, subnetConflicts, sessionConflicts, curSubnets, routedIPs, hostRoutes,
+	// gatewayRoutes, and the subnets of the sessions from concurrent access by the Status call.
+	subnetsLock sync.RWMutex
+
+	// routesLock serializes the changes to the routes of the TUN device
+	routesLock sync.Mutex
+
 	// closing is set during shutdown and can have the values:
 	//   0 = running
 	//   1 = closing
 	//   2 = closed
 	closing int32
 
-	// session contains the manager session
-	session *manager.SessionInfo
+	// sessions are the sessions of the user daemon, in the order that they were added. The slice is
+	// replaced, never modified, when a session is added or removed.
+	sessions    []*routerSession
+	sessionSeq  int
+	sessionLock sync.RWMutex
+
+	// sessionCh passes added sessions to the run() method, which runs them
+	sessionCh chan *routerSession
 
-	// cfgComplete will be closed as soon as the connector has sent over the correct port to
-	// the traffic manager and the managerClient has been connected.
+	// cfgComplete will be closed as soon as the cluster info of the first session has been received.
 	cfgComplete chan struct{}
+	cfgOnce     sync.Once
 
 	// tmVerOk will be closed as soon as the correct tunnel version has been negotiated with
-	// the traffic manager
+	// the traffic manager of the first session
 	tmVerOk chan struct{}
+	verOnce sync.Once
 
 	// rndSource is the source for the random number generator in the TCP handlers
 	rndSource rand.Source
+
+	// mtu is the MTU of the TUN device that the connector configured, or zero when it uses the default
+	mtu int32
+
+	// mtuLimitedBy is the name of the interface of the default route when its MTU limited the
+	// default MTU of the TUN device
+	mtuLimitedBy string
+
+	// state records the routes and DNS changes so that they can be reverted should the daemon crash
+	state *netStateFile
+
+	// helperScope is true when the daemon was started by the privileged helper, and must therefore refuse
+	// the network changes that are outside the scope that the helper grants.
+	helperScope bool
 }
 
 func newTunRouter(ctx context.Context) (*tunRouter, error) {
@@ -127,12 +200,20 @@ func newTunRouter(ctx context.Context) (*tunRouter, error) {
 		return nil, err
 	}
 	return &tunRouter{
-		dev:         td,
-		handlers:    tunnel.NewPool(),
-		cfgComplete: make(chan struct{}),
-		tmVerOk:     make(chan struct{}),
-		fragmentMap: make(map[uint16][]*buffer.Data),
-		rndSource:   rand.NewSource(time.Now().UnixNano()),
+		dev:           td,
+		routes:        td,
+		writer:        newTunWriter(td),
+		handlers:      tunnel.NewLimitedPool(poolLimits(&client.GetConfig(ctx).Connections)),
+		sessionCh:     make(chan *routerSession),
+		cfgComplete:   make(chan struct{}),
+		tmVerOk:       make(chan struct{}),
+		fragmentMap:   make(map[uint16][]*buffer.Data),
+		rndSource:     rand.NewSource(time.Now().UnixNano()),
+		state:         newNetStateFile(ctx, td.Name()),
+		routedIPs:     make(map[string][]net.IP),
+		hostRoutes:    make(map[string]*net.IPNet),
+		hostRoutesCh:  make(chan struct{}, 1),
+		gatewayRoutes: make(map[string]*gatewayRoute),
 	}, nil
 }
 
@@ -145,15 +226,99 @@ func (t *tunRouter) configureDNS(_ context.Context, dnsLocalAddr *net.UDPAddr) {
 	t.dnsLocalAddr = dnsLocalAddr
 }
 
+// getRoutingTable is a variable so that tests can replace it.
+var getRoutingTable = routing.GetRoutingTable
+
 func (t *tunRouter) refreshSubnets(ctx context.Context) error {
+	t.routesLock.Lock()
+	defer t.routesLock.Unlock()
+	t.refreshGatewayRoutesLocked(ctx)
+	table, err := getRoutingTable(ctx)
+	if err != nil {
+		dlog.Errorf(ctx, "unable to read the routing table, conflicting subnets will not be detected: %v", err)
+	}
+
+	t.subnetsLock.Lock()
+	added, removed := t.updateCurSubnets(table, t.ownInterfaces()...)
+	conflicts := t.subnetConflicts
+	t.subnetsLock.Unlock()
+
+	for _, c := range conflicts {
+		if c.Severity == routing.Error {
+			dlog.Errorf(ctx, "%s. The subnet is not routed unless it is added to allowConflictingSubnets in the config", c)
+		} else {
+			dlog.Warn(ctx, c)
+		}
+	}
+
+	// Only the routes of subnets that changed are touched, so connections to the other subnets are
+	// unaffected.
+	for _, sn := range removed {
+		if err := t.routes.RemoveSubnet(ctx, sn); err != nil {
+			dlog.Errorf(ctx, "failed to remove subnet %s: %v", sn, err)
+		} else {
+			dlog.Infof(ctx, "Removed the route of subnet %s", sn)
+			metrics.RouteChanges.WithLabelValues(metrics.RouteRemoved).Inc()
+			t.state.removeSubnet(ctx, sn)
+		}
+	}
+
+	for _, sn := range added {
+		// Record the subnet before it's added, so that a crash in between doesn't leave an
+		// unrecorded route behind.
+		t.state.addSubnet(ctx, sn)
+		if err := t.routes.AddSubnet(ctx, sn); err != nil {
+			dlog.Errorf(ctx, "failed to add subnet %s: %v", sn, err)
+			t.state.removeSubnet(ctx, sn)
+		} else {
+			dlog.Infof(ctx, "Added the route of subnet %s", sn)
+			metrics.RouteChanges.WithLabelValues(metrics.RouteAdded).Inc()
+		}
+	}
+	return nil
+}
+
+// getLastActivity returns the time of the last packet that was routed to the cluster, or the
+// zero time if no packet has been routed yet.
+func (t *tunRouter) getLastActivity() time.Time {
+	if ns := atomic.LoadInt64(&t.lastActivity); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// ownInterfaces returns the identifiers that the routing table may use for the TUN device, i.e.
+// its name, its index, and its addresses.
+func (t *tunRouter) ownInterfaces() []string {
+	if t.dev == nil {
+		return nil
+	}
+	return routing.InterfaceIdentifiers(t.dev.Name())
+}
+
+// updateCurSubnets updates the currently routed subnets to the desired ones, and returns the subnets
+// that must be added to, and removed from, the TUN device. Desired subnets that collide with the
+// routes of another VPN in the given routing table are not routed.
+func (t *tunRouter) updateCurSubnets(table []*routing.Route, ownInterfaces ...string) (added, removed []*net.IPNet) {
 	// Create a unique slice of all desired subnets.
 	desired := make([]*net.IPNet, len(t.clusterSubnets)+len(t.alsoProxySubnets))
 	copy(desired, t.clusterSubnets)
 	copy(desired[len(t.clusterSubnets):], t.alsoProxySubnets)
-	desired = subnet.Unique(desired)
+	desired = subnet.SubtractAll(subnet.Unique(desired), t.neverProxySubnets)
+
+	t.subnetConflicts = routing.FindConflicts(table, desired, t.allowConflictingSubnets, ownInterfaces...)
+	if errs := routing.Errors(t.subnetConflicts); len(errs) > 0 {
+		desired, _ = subnet.Partition(desired, func(_ int, sn *net.IPNet) bool {
+			for _, e := range errs {
+				if subnet.Equal(sn, e) {
+					return false
+				}
+			}
+			return true
+		})
+	}
 
 	// Remove all no longer desired subnets from the t.curSubnets
-	var removed []*net.IPNet
 	t.curSubnets, removed = subnet.Partition(t.curSubnets, func(_ int, sn *net.IPNet) bool {
 		for _, d := range desired {
 			if subnet.Equal(sn, d) {
@@ -164,7 +329,7 @@ func (t *tunRouter) refreshSubnets(ctx context.Context) error {
 	})
 
 	// Remove already routed subnets from the desiredSubnets
-	added, _ := subnet.Partition(desired, func(_ int, sn *net.IPNet) bool {
+	added, _ = subnet.Partition(desired, func(_ int, sn *net.IPNet) bool {
 		for _, d := range t.curSubnets {
 			if subnet.Equal(sn, d) {
 				return false
@@ -175,118 +340,116 @@ func (t *tunRouter) refreshSubnets(ctx context.Context) error {
 
 	// Add desiredSubnets to the currently routed subnets
 	t.curSubnets = append(t.curSubnets, added...)
+	return added, removed
+}
 
-	for _, sn := range removed {
-		if err := t.dev.RemoveSubnet(ctx, sn); err != nil {
-			dlog.Errorf(ctx, "failed to remove subnet %s: %v", sn, err)
+// routedSubnets returns the subnets that are currently routed to the TUN device along with their source.
+func (t *tunRouter) routedSubnets() []*daemon.RoutedSubnet {
+	t.subnetsLock.RLock()
+	defer t.subnetsLock.RUnlock()
+	// A routed subnet is either one of the desired subnets, or a sub-range of one that
+	// remains when the never-proxy subnets are subtracted.
+	covers := func(sns []*net.IPNet, sn *net.IPNet) bool {
+		for _, s := range sns {
+			if subnet.Covers(s, sn) {
+				return true
+			}
 		}
+		return false
 	}
-
-	for _, sn := range added {
-		if err := t.dev.AddSubnet(ctx, sn); err != nil {
-			dlog.Errorf(ctx, "failed to add subnet %s: %v", sn, err)
-		}
+	rss := make([]*daemon.RoutedSubnet, len(t.curSubnets))
+	for i, sn := range t.curSubnets {
+		src := daemon.RoutedSubnet_UNSPECIFIED
+		switch {
+		case covers(t.serviceSubnets, sn):
+			src = daemon.RoutedSubnet_SERVICES
+		case covers(t.clusterSubnets, sn):
+			src = daemon.RoutedSubnet_PODS
+		case covers(t.alsoProxySubnets, sn):
+			src = daemon.RoutedSubnet_ALSO_PROXY
+		}
+		rss[i] = &daemon.RoutedSubnet{Subnet: iputil.IPNetToRPC(sn), Source: src}
 	}
-	return nil
+	return rss
 }
 
-func (t *tunRouter) setOutboundInfo(ctx context.Context, mi *daemon.OutboundInfo) (err error) {
-	if t.managerClient == nil {
-		// First check. Establish connection
-		clientConfig := client.GetConfig(ctx)
-		tos := &clientConfig.Timeouts
-		tc, cancel := tos.TimeoutContext(ctx, client.TimeoutTrafficManagerAPI)
-		defer cancel()
+// setOutboundInfo adds the session of the given info, or, when the session exists, replaces the manager
+// session that it uses. It returns true when the manager session was replaced.
+func (t *tunRouter) setOutboundInfo(ctx context.Context, mi *daemon.OutboundInfo) (replaced bool, err error) {
+	s := t.getSession(mi.SessionName)
+	if s == nil {
+		return false, t.addSession(ctx, mi)
+	}
+	if mi.Mtu != t.mtu {
+		dlog.Warnf(ctx, "The MTU of the TUN device remains unchanged. A change from %d to %d requires a new connect", t.mtu, mi.Mtu)
+	}
+	if mi.Session.GetSessionId() == s.getInfo().GetSessionId() {
+		return false, nil
+	}
+	// The connector replaced a broken session, typically after a laptop sleep or a network change
+	// that may also have caused the OS to drop the routes of the TUN device.
+	dlog.Infof(ctx, "Session %s replaces session %s", mi.Session.GetSessionId(), s.getInfo().GetSessionId())
+	s.setInfo(mi.Session)
+	t.restoreRoutes(ctx)
+	return true, nil
+}
 
-		var conn *grpc.ClientConn
+// routeCheckInterval is the interval between the checks that restore the routes that the OS dropped.
+const routeCheckInterval = 10 * time.Second
 
-		conn, err = client.DialSocket(tc, client.ConnectorSocketName)
-		if err != nil {
-			if errors.Is(err, os.ErrNotExist) {
-				// The connector called us, and then it died which means we will die too. This is
-				// a race, but it's not an error.
-				return nil
-			}
-			return client.CheckTimeout(tc, err)
-		}
-		t.session = mi.Session
-		t.managerClient = manager.NewManagerClient(conn)
-
-		if len(mi.AlsoProxySubnets) > 0 {
-			t.alsoProxySubnets = make([]*net.IPNet, len(mi.AlsoProxySubnets))
-			for i, ap := range mi.AlsoProxySubnets {
-				apSn := iputil.IPNetFromRPC(ap)
-				dlog.Infof(ctx, "Adding also-proxy subnet %s", apSn)
-				t.alsoProxySubnets[i] = apSn
-			}
+// restoreRoutes re-adds the routes of the currently routed subnets that are missing from the routing
+// table. The OS may drop them when the network changes or the host wakes up from sleep.
+func (t *tunRouter) restoreRoutes(ctx context.Context) {
+	t.routesLock.Lock()
+	defer t.routesLock.Unlock()
+	table, err := getRoutingTable(ctx)
+	if err != nil {
+		dlog.Errorf(ctx, "unable to read the routing table: %v", err)
+		return
+	}
+	t.subnetsLock.RLock()
+	missing := missingRoutes(table, append(t.hostRoutesLocked(), t.curSubnets...), t.ownInterfaces()...)
+	t.subnetsLock.RUnlock()
+	for _, sn := range missing {
+		dlog.Warnf(ctx, "Restoring the dropped route for subnet %s", sn)
+		if err := t.routes.AddSubnet(ctx, sn); err != nil {
+			dlog.Errorf(ctx, "failed to restore the route for subnet %s: %v", sn, err)
 		}
-		t.dnsIP = mi.Dns.RemoteIp
-
-		dgroup.ParentGroup(ctx).Go("watch-cluster-info", func(ctx context.Context) error {
-			err := t.watchClusterInfo(ctx)
-			var recvErr *client.RecvEOF
-			if errors.As(err, &recvErr) {
-				// If the remote end, which is the connector, has hung up mid-stream, that usually means that
-				// the daemon will be shutting down soon too.
-				<-ctx.Done()
-			}
-			return err
-		})
 	}
-	return nil
 }
 
-func (t *tunRouter) watchClusterInfo(ctx context.Context) error {
-	infoStream, err := t.managerClient.WatchClusterInfo(ctx, t.session)
-	if err != nil {
-		return fmt.Errorf("error when calling WatchClusterInfo: %w", err)
+// missingRoutes returns the subnets that have no route on one of the given own interfaces in the
+// given routing table.
+func missingRoutes(table []*routing.Route, subnets []*net.IPNet, ownInterfaces ...string) []*net.IPNet {
+	own := make(map[string]bool, len(ownInterfaces))
+	for _, i := range ownInterfaces {
+		own[i] = true
 	}
-
-	cfgComplete := t.cfgComplete
-	for {
-		mgrInfo, err := infoStream.Recv()
-		if err != nil {
-			if ctx.Err() != nil {
-				return nil
+	var missing []*net.IPNet
+	for _, sn := range subnets {
+		found := false
+		for _, r := range table {
+			if own[r.Interface] && subnet.Equal(sn, r.RoutedNet) {
+				found = true
+				break
 			}
-			return client.WrapRecvErr(err, "error when reading WatchClusterInfo")
 		}
-
-		subnets := make([]*net.IPNet, 0, 1+len(mgrInfo.PodSubnets))
-		if mgrInfo.ServiceSubnet != nil {
-			cidr := iputil.IPNetFromRPC(mgrInfo.ServiceSubnet)
-			dlog.Infof(ctx, "Adding service subnet %s", cidr)
-			subnets = append(subnets, cidr)
-		}
-
-		for _, sn := range mgrInfo.PodSubnets {
-			cidr := iputil.IPNetFromRPC(sn)
-			dlog.Infof(ctx, "Adding pod subnet %s", cidr)
-			subnets = append(subnets, cidr)
-		}
-
-		t.clusterSubnets = subnets
-		if err := t.refreshSubnets(ctx); err != nil {
-			dlog.Error(ctx, err)
+		if !found {
+			missing = append(missing, sn)
 		}
+	}
+	return missing
+}
 
-		if cfgComplete != nil {
-			// Only set clusterDNS when it hasn't been explicitly set with the --dns option
-			if t.dnsIP == nil {
-				dlog.Infof(ctx, "Setting cluster DNS to %s", net.IP(mgrInfo.KubeDnsIp))
-				t.dnsIP = mgrInfo.KubeDnsIp
-			}
-			dlog.Infof(ctx, "Setting cluster domain to %q", mgrInfo.ClusterDomain)
-			t.clusterDomain = mgrInfo.ClusterDomain
-			if t.clusterDomain == "" {
-				// Traffic manager predates 2.4.3 and doesn't report a cluster domain. Only thing
-				// left to do then is to assume it's the standard one.
-				t.clusterDomain = "cluster.local."
-			}
-			close(cfgComplete)
-			cfgComplete = nil
-		}
+// subnetConflictsToRPC returns the conflicts found by the last refreshSubnets() call.
+func (t *tunRouter) subnetConflictsToRPC() []*daemon.SubnetConflict {
+	t.subnetsLock.RLock()
+	defer t.subnetsLock.RUnlock()
+	rcs := make([]*daemon.SubnetConflict, len(t.subnetConflicts))
+	for i, c := range t.subnetConflicts {
+		rcs[i] = c.ToRPC()
 	}
+	return rcs
 }
 
 func (t *tunRouter) stop(c context.Context) {
@@ -314,63 +477,49 @@ var blockedUDPPorts = map[uint16]bool{
 func (t *tunRouter) run(c context.Context) error {
 	g := dgroup.NewGroup(c, dgroup.GroupConfig{})
 
-	g.Go("MGR stream", func(c context.Context) error {
-		dlog.Debug(c, "Waiting until manager gRPC is configured")
+	g.Go("sessions", func(c context.Context) error {
+		for {
+			select {
+			case <-c.Done():
+				return nil
+			case s := <-t.sessionCh:
+				g.Go(fmt.Sprintf("session-%d", s.seq), func(c context.Context) error {
+					dlog.Infof(c, "Running session %s", s.displayName())
+					return t.runSession(c, s)
+				})
+			}
+		}
+	})
+
+	g.Go("route-watcher", func(c context.Context) error {
 		select {
 		case <-c.Done():
 			return nil
 		case <-t.cfgComplete:
 		}
-		ver, err := t.managerClient.Version(c, &empty.Empty{})
-		if err != nil {
-			return err
-		}
-		verStr := strings.TrimPrefix(ver.Version, "v")
-		dlog.Infof(c, "Connected to Manager %s", verStr)
-		mgrVer, err := semver.Parse(verStr)
-		if err != nil {
-			return fmt.Errorf("failed to parse manager version %q: %s", verStr, err)
+		ticker := time.NewTicker(routeCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.Done():
+				return nil
+			case <-ticker.C:
+				t.restoreRoutes(c)
+			}
 		}
+	})
 
-		clientTunnel, err := t.managerClient.ClientTunnel(c)
-		if err != nil {
-			return err
-		}
-		muxTunnel := connpool.NewMuxTunnel(clientTunnel)
-		if err = muxTunnel.Send(c, connpool.SessionInfoControl(t.session)); err != nil {
-			return err
-		}
+	g.Go("host-routes", t.runHostRoutes)
 
-		var peerVersion uint16
-		if mgrVer.LE(semver.MustParse("2.4.2")) {
-			peerVersion = 0
-		} else {
-			if err = muxTunnel.Send(c, connpool.VersionControl()); err != nil {
-				return err
-			}
-			peerVersion, err = muxTunnel.ReadPeerVersion(c)
-			if err != nil {
-				return err
-			}
-		}
-		// Versions >= 2 don't use connpool.Tunnel. They use tunnel.Stream.
-		if peerVersion < 2 {
-			t.muxTunnel = muxTunnel
-			close(t.tmVerOk)
-			dlog.Debug(c, "MGR read loop starting")
-			err = t.muxTunnel.DialLoop(c, t.handlers)
-			var recvErr *client.RecvEOF
-			if errors.As(err, &recvErr) {
-				<-c.Done()
-			}
-		} else {
-			close(t.tmVerOk)
-			dlog.Debug(c, "closing since a more recent system detected")
-			err = muxTunnel.CloseSend()
-		}
-		return err
+	g.Go("gateway-routes", func(c context.Context) error {
+		// Unlike the routes to the TUN device, the gateway routes don't vanish with it
+		<-c.Done()
+		t.removeGatewayRoutes(dcontext.WithoutCancel(c))
+		return nil
 	})
 
+	g.Go("connection-tracker", t.trackConnections)
+
 	g.Go("TUN reader", func(c context.Context) error {
 		dlog.Debug(c, "Waiting until manager gRPC is configured")
 		select {
@@ -381,34 +530,26 @@ func (t *tunRouter) run(c context.Context) error {
 
 		dlog.Debug(c, "TUN read loop starting")
 
-		// bufCh is just a small buffer to enable better parallel processing between
-		// the actual TUN reader loop and the packet handlers.
-		bufCh := make(chan *buffer.Data, 100)
-		defer close(bufCh)
+		// batchCh is just a small buffer to enable better parallel processing between
+		// the actual TUN reader loop and the packet dispatcher. The dispatcher handles
+		// the packets in the order that they were read.
+		batchCh := make(chan *packetBatch, 16)
+		defer close(batchCh)
 
 		go func() {
-			for data := range bufCh {
-				t.handlePacket(c, data)
+			for pb := range batchCh {
+				for _, data := range pb.packets() {
+					t.handlePacket(c, data)
+				}
+				pb.release()
 			}
 		}()
 
-		for atomic.LoadInt32(&t.closing) < 2 {
-			data := buffer.DataPool.Get(buffer.DataPool.MTU)
-			for {
-				n, err := t.dev.ReadPacket(data)
-				if err != nil {
-					buffer.DataPool.Put(data)
-					if c.Err() != nil || atomic.LoadInt32(&t.closing) == 2 {
-						return nil
-					}
-					return fmt.Errorf("read packet error: %w", err)
-				}
-				if n > 0 {
-					data.SetLength(n)
-					bufCh <- data
-					break
-				}
+		if err := readTunBatches(c, t.dev, batchCh); err != nil {
+			if c.Err() != nil || atomic.LoadInt32(&t.closing) == 2 {
+				return nil
 			}
+			return fmt.Errorf("read packet error: %w", err)
 		}
 		return nil
 	})
@@ -423,8 +564,7 @@ func (t *tunRouter) handlePacket(c context.Context, data *buffer.Data) {
 	}()
 
 	reply := func(pkt ip.Packet) {
-		_, err := t.dev.WritePacket(pkt.Data(), 0)
-		if err != nil {
+		if err := t.writer.Write(c, pkt); err != nil {
 			dlog.Errorf(c, "TUN write failed: %v", err)
 		}
 	}
@@ -441,6 +581,7 @@ func (t *tunRouter) handlePacket(c context.Context, data *buffer.Data) {
 		reply(icmp.DestinationUnreachablePacket(ipHdr, icmp.MustFragment))
 		return
 	}
+	atomic.StoreInt64(&t.lastActivity, time.Now().UnixNano())
 
 	if ipHdr.Version() == ipv4.Version {
 		v4Hdr := ipHdr.(ip.V4Header)
@@ -488,28 +629,6 @@ func (t *tunRouter) handlePacket(c context.Context, data *buffer.Data) {
 	}
 }
 
-type vifWriter struct {
-	*vif.Device
-}
-
-func (w vifWriter) Write(ctx context.Context, pkt ip.Packet) (err error) {
-	dlog.Tracef(ctx, "-> TUN %s", pkt)
-	d := pkt.Data()
-	l := len(d.Buf())
-	o := 0
-	for {
-		var n int
-		if n, err = w.WritePacket(d, o); err != nil {
-			return err
-		}
-		l -= n
-		if l == 0 {
-			return nil
-		}
-		o += n
-	}
-}
-
 func (t *tunRouter) tcp(c context.Context, pkt tcp.Packet) {
 	ipHdr := pkt.IPHeader()
 	tcpHdr := pkt.Header()
@@ -534,10 +653,22 @@ func (t *tunRouter) tcp(c context.Context, pkt tcp.Packet) {
 	}
 
 	wf, _, err := t.handlers.GetOrCreate(c, connID, func(c context.Context, remove func()) (tunnel.Handler, error) {
-		return tcp.NewHandler(t.streamCreator(connID), t.muxTunnel, &t.closing, vifWriter{t.dev}, connID, remove, t.rndSource), nil
+		s := t.sessionFor(ipHdr.Destination())
+		if s == nil {
+			return nil, errors.New("no session")
+		}
+		return tcp.NewHandler(s.streamCreator(connID), s.muxTunnel, &t.closing, t.writer, connID, countConnection(remove), t.rndSource), nil
 	})
 	if err != nil {
-		dlog.Error(c, err)
+		if errors.Is(err, tunnel.ErrPoolFull) {
+			// Refuse the connection
+			dlog.Errorf(c, "!! CON %s refused: %v", connID, err)
+			if err := t.writer.Write(c, pkt.Reset()); err != nil {
+				dlog.Errorf(c, "TUN write failed: %v", err)
+			}
+		} else {
+			dlog.Error(c, err)
+		}
 		pkt.Release()
 		return
 	}
@@ -549,39 +680,87 @@ func (t *tunRouter) udp(c context.Context, dg udp.Datagram) {
 	udpHdr := dg.Header()
 	connID := tunnel.NewConnID(ipproto.UDP, ipHdr.Source(), ipHdr.Destination(), udpHdr.SourcePort(), udpHdr.DestinationPort())
 	uh, _, err := t.handlers.GetOrCreate(c, connID, func(c context.Context, remove func()) (tunnel.Handler, error) {
-		w := vifWriter{t.dev}
+		w := t.writer
 		if t.dnsLocalAddr != nil && udpHdr.DestinationPort() == t.dnsPort && ipHdr.Destination().Equal(t.dnsIP) {
 			return udp.NewDnsInterceptor(w, connID, remove, t.dnsLocalAddr)
 		}
-		stream, err := t.maybeOpenStream(c, connID)
+		s := t.sessionFor(ipHdr.Destination())
+		if s == nil {
+			return nil, errors.New("no session")
+		}
+		stream, err := s.maybeOpenStream(c, connID)
 		if err != nil {
 			return nil, err
 		}
-		return udp.NewHandler(stream, t.muxTunnel, w, connID, remove), nil
+		return udp.NewHandler(stream, s.muxTunnel, w, connID, countConnection(remove)), nil
 	})
 	if err != nil {
-		dlog.Error(c, err)
+		if errors.Is(err, tunnel.ErrPoolFull) {
+			dlog.Errorf(c, "!! CON %s refused: %v", connID, err)
+			if err := t.writer.Write(c, icmp.DestinationUnreachablePacket(ipHdr, icmp.PortUnreachable)); err != nil {
+				dlog.Errorf(c, "TUN write failed: %v", err)
+			}
+			dg.Release()
+		} else {
+			dlog.Error(c, err)
+		}
 		return
 	}
 	uh.(udp.DatagramHandler).HandleDatagram(c, dg)
 }
 
-func (t *tunRouter) maybeOpenStream(c context.Context, id tunnel.ConnID) (tunnel.Stream, error) {
-	if t.muxTunnel != nil {
+func (s *routerSession) maybeOpenStream(c context.Context, id tunnel.ConnID) (tunnel.Stream, error) {
+	if s.muxTunnel != nil {
 		// tunnelVersion <= 2, so use the multiplexing tunnel
 		return nil, nil
 	}
-	return t.streamCreator(id)(c)
+	return s.streamCreator(id)(c)
 }
 
-func (t *tunRouter) streamCreator(id tunnel.ConnID) tcp.StreamCreator {
+func (s *routerSession) streamCreator(id tunnel.ConnID) tcp.StreamCreator {
 	return func(c context.Context) (tunnel.Stream, error) {
 		dlog.Debugf(c, "Opening tunnel for id %s", id)
-		ct, err := t.managerClient.Tunnel(c)
+		ct, err := s.managerClient.Tunnel(c)
 		if err != nil {
 			return nil, err
 		}
 		tc := client.GetConfig(c).Timeouts
-		return tunnel.NewClientStream(c, ct, id, t.session.SessionId, tc.Get(client.TimeoutRoundtripLatency), tc.Get(client.TimeoutEndpointDial))
+		stream, err := tunnel.NewClientStream(c, ct, id, s.getInfo().SessionId, tc.Get(client.TimeoutRoundtripLatency), tc.Get(client.TimeoutEndpointDial))
+		if err != nil {
+			return nil, err
+		}
+		return countingStream{Stream: stream}, nil
+	}
+}
+
+// countConnection counts a connection as active until the returned function, which calls the given
+// remove function, is called.
+func countConnection(remove func()) func() {
+	metrics.ActiveConnections.Inc()
+	var once sync.Once
+	return func() {
+		once.Do(metrics.ActiveConnections.Dec)
+		remove()
+	}
+}
+
+// countingStream is a tunnel.Stream that counts the payload bytes that it sends and receives.
+type countingStream struct {
+	tunnel.Stream
+}
+
+func (s countingStream) Receive(c context.Context) (tunnel.Message, error) {
+	m, err := s.Stream.Receive(c)
+	if err == nil && m.Code() == tunnel.Normal {
+		metrics.TunnelBytes.WithLabelValues(metrics.TunnelIn).Add(float64(len(m.Payload())))
+	}
+	return m, err
+}
+
+func (s countingStream) Send(c context.Context, m tunnel.Message) error {
+	err := s.Stream.Send(c, m)
+	if err == nil && m.Code() == tunnel.Normal {
+		metrics.TunnelBytes.WithLabelValues(metrics.TunnelOut).Add(float64(len(m.Payload())))
 	}
+	return err
 }
@@ -0,0 +1,372 @@
+package daemon
+
+import (
+	"context"
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/metrics"
+	"github.com/telepresenceio/telepresence/v2/pkg/routing"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+)
+
+func cidrs(t *testing.T, strs ...string) []*net.IPNet {
+	sns := make([]*net.IPNet, len(strs))
+	for i, s := range strs {
+		_, sn, err := net.ParseCIDR(s)
+		require.NoError(t, err)
+		sns[i] = sn
+	}
+	return sns
+}
+
+func cidrStrings(sns []*net.IPNet) []string {
+	strs := make([]string, len(sns))
+	for i, sn := range sns {
+		strs[i] = sn.String()
+	}
+	sort.Strings(strs)
+	return strs
+}
+
+func TestTunRouter_updateCurSubnets(t *testing.T) {
+	tr := &tunRouter{
+		clusterSubnets: cidrs(t, "10.96.0.0/12", "10.244.0.0/16"),
+		serviceSubnets: cidrs(t, "10.96.0.0/12"),
+		alsoProxySubnets: cidrs(t,
+			"192.168.10.0/24",
+			"192.168.10.0/24", // duplicate
+			"10.244.1.0/24",   // covered by a pod subnet
+			"172.16.0.0/16",
+		),
+	}
+	added, removed := tr.updateCurSubnets(nil)
+	assert.Equal(t, []string{"10.244.0.0/16", "10.96.0.0/12", "172.16.0.0/16", "192.168.10.0/24"}, cidrStrings(added))
+	assert.Empty(t, removed)
+
+	sources := make(map[string]daemon.RoutedSubnet_Source)
+	for _, rs := range tr.routedSubnets() {
+		sources[iputil.IPNetFromRPC(rs.Subnet).String()] = rs.Source
+	}
+	assert.Equal(t, map[string]daemon.RoutedSubnet_Source{
+		"10.96.0.0/12":    daemon.RoutedSubnet_SERVICES,
+		"10.244.0.0/16":   daemon.RoutedSubnet_PODS,
+		"172.16.0.0/16":   daemon.RoutedSubnet_ALSO_PROXY,
+		"192.168.10.0/24": daemon.RoutedSubnet_ALSO_PROXY,
+	}, sources)
+
+	// An also-proxy subnet that covers a cluster subnet replaces it, and a removed one is no longer routed
+	tr.alsoProxySubnets = cidrs(t, "10.244.0.0/15")
+	added, removed = tr.updateCurSubnets(nil)
+	assert.Equal(t, []string{"10.244.0.0/15"}, cidrStrings(added))
+	assert.Equal(t, []string{"10.244.0.0/16", "172.16.0.0/16", "192.168.10.0/24"}, cidrStrings(removed))
+	assert.Equal(t, []string{"10.244.0.0/15", "10.96.0.0/12"}, cidrStrings(tr.curSubnets))
+
+	// Nothing changes when the subnets are unchanged
+	added, removed = tr.updateCurSubnets(nil)
+	assert.Empty(t, added)
+	assert.Empty(t, removed)
+}
+
+func TestTunRouter_neverProxy(t *testing.T) {
+	tr := &tunRouter{
+		clusterSubnets:    cidrs(t, "10.96.0.0/12", "10.244.0.0/16"),
+		serviceSubnets:    cidrs(t, "10.96.0.0/12"),
+		alsoProxySubnets:  cidrs(t, "192.168.10.0/24"),
+		neverProxySubnets: cidrs(t, "10.244.0.0/17", "192.168.10.0/24", "10.100.0.1/32"),
+	}
+	added, removed := tr.updateCurSubnets(nil)
+	assert.Empty(t, removed)
+
+	// The service subnet is split into 20 sub-ranges around the never-proxied address, the pod subnet
+	// keeps its upper half, and the also-proxy subnet isn't routed at all.
+	assert.Len(t, added, 21)
+	strs := cidrStrings(added)
+	assert.Contains(t, strs, "10.96.0.0/14")
+	assert.Contains(t, strs, "10.100.0.0/32")
+	assert.Contains(t, strs, "10.100.0.2/31")
+	assert.Contains(t, strs, "10.104.0.0/13")
+	assert.Contains(t, strs, "10.244.128.0/17")
+	assert.NotContains(t, strs, "192.168.10.0/24")
+	for _, sn := range added {
+		assert.False(t, sn.Contains(net.IP{10, 100, 0, 1}), "%s contains a never-proxied address", sn)
+		assert.False(t, sn.Contains(net.IP{10, 244, 1, 1}), "%s contains a never-proxied address", sn)
+	}
+
+	for _, rs := range tr.routedSubnets() {
+		sn := iputil.IPNetFromRPC(rs.Subnet)
+		if sn.String() == "10.244.128.0/17" {
+			assert.Equal(t, daemon.RoutedSubnet_PODS, rs.Source)
+		} else {
+			assert.Equal(t, daemon.RoutedSubnet_SERVICES, rs.Source, sn.String())
+		}
+	}
+}
+
+func TestTunRouter_dualStack(t *testing.T) {
+	tr := &tunRouter{
+		clusterSubnets:    cidrs(t, "10.96.0.0/12", "fd00:10:96::/112", "10.244.0.0/16", "fd00:10:244::/56"),
+		serviceSubnets:    cidrs(t, "10.96.0.0/12", "fd00:10:96::/112"),
+		alsoProxySubnets:  cidrs(t, "2001:db8:1::/48", "fd00:10:244:1::/64"),
+		neverProxySubnets: cidrs(t, "fd00:10:244:80::/57", "2001:db8:1::/64"),
+	}
+	table := []*routing.Route{
+		{RoutedNet: cidrs(t, "0.0.0.0/0")[0], Interface: "eth0", Gateway: net.IP{192, 168, 1, 1}},
+		{RoutedNet: cidrs(t, "::/0")[0], Interface: "eth0", Gateway: net.ParseIP("fe80::1")},
+		{RoutedNet: cidrs(t, "fd00:10:96::/112")[0], Interface: "tel0"},
+	}
+	added, removed := tr.updateCurSubnets(table, "tel0")
+	assert.Empty(t, removed)
+	assert.Empty(t, tr.subnetConflicts)
+
+	// The never-proxy subnets are subtracted from the IPv6 subnets and the IPv4 subnets are unaffected
+	strs := cidrStrings(added)
+	assert.Contains(t, strs, "10.96.0.0/12")
+	assert.Contains(t, strs, "10.244.0.0/16")
+	assert.Contains(t, strs, "fd00:10:96::/112")
+	assert.Contains(t, strs, "fd00:10:244::/57")
+	assert.NotContains(t, strs, "fd00:10:244:1::/64", "covered by a pod subnet")
+	assert.Contains(t, strs, "2001:db8:1:1::/64")
+	assert.Contains(t, strs, "2001:db8:1:8000::/49")
+	for _, sn := range added {
+		assert.False(t, sn.Contains(net.ParseIP("fd00:10:244:80::1")), "%s contains a never-proxied address", sn)
+		assert.False(t, sn.Contains(net.ParseIP("2001:db8:1::1")), "%s contains a never-proxied address", sn)
+	}
+
+	sources := make(map[string]daemon.RoutedSubnet_Source)
+	for _, rs := range tr.routedSubnets() {
+		sources[iputil.IPNetFromRPC(rs.Subnet).String()] = rs.Source
+	}
+	assert.Equal(t, daemon.RoutedSubnet_SERVICES, sources["10.96.0.0/12"])
+	assert.Equal(t, daemon.RoutedSubnet_SERVICES, sources["fd00:10:96::/112"])
+	assert.Equal(t, daemon.RoutedSubnet_PODS, sources["10.244.0.0/16"])
+	assert.Equal(t, daemon.RoutedSubnet_PODS, sources["fd00:10:244::/57"])
+	assert.Equal(t, daemon.RoutedSubnet_ALSO_PROXY, sources["2001:db8:1:1::/64"])
+
+	// An IPv6 route of another VPN is a conflict
+	table = append(table, &routing.Route{RoutedNet: cidrs(t, "fd00:10:244::/57")[0], Interface: "tun0", Gateway: net.ParseIP("fd00:10:244::1")})
+	_, removed = tr.updateCurSubnets(table, "tel0")
+	assert.Equal(t, []string{"fd00:10:244::/57"}, cidrStrings(removed))
+	require.Len(t, routing.Errors(tr.subnetConflicts), 1)
+}
+
+func TestTunRouter_subnetConflicts(t *testing.T) {
+	tr := &tunRouter{
+		clusterSubnets:   cidrs(t, "10.96.0.0/12", "10.8.0.0/16"),
+		serviceSubnets:   cidrs(t, "10.96.0.0/12"),
+		alsoProxySubnets: cidrs(t, "192.168.0.0/16"),
+	}
+	table := []*routing.Route{
+		{RoutedNet: cidrs(t, "0.0.0.0/0")[0], Interface: "eth0", Gateway: net.IP{192, 168, 1, 1}},
+		{RoutedNet: cidrs(t, "192.168.1.0/24")[0], Interface: "eth0"},
+		{RoutedNet: cidrs(t, "10.8.0.0/16")[0], Interface: "tun0", Gateway: net.IP{10, 8, 0, 1}},
+		{RoutedNet: cidrs(t, "10.96.0.0/12")[0], Interface: "tel0"},
+	}
+
+	// The subnet that collides with the VPN isn't routed, and the routes of the own device are ignored
+	added, _ := tr.updateCurSubnets(table, "tel0")
+	assert.Equal(t, []string{"10.96.0.0/12", "192.168.0.0/16"}, cidrStrings(added))
+	require.Len(t, tr.subnetConflicts, 2)
+	assert.Equal(t, "10.8.0.0/16", tr.subnetConflicts[0].Subnet.String())
+	assert.Equal(t, routing.Error, tr.subnetConflicts[0].Severity)
+	assert.Equal(t, "192.168.0.0/16", tr.subnetConflicts[1].Subnet.String())
+	assert.Equal(t, routing.Warning, tr.subnetConflicts[1].Severity)
+	assert.Len(t, tr.subnetConflictsToRPC(), 2)
+
+	// An allowed subnet is routed in spite of the collision
+	tr.allowConflictingSubnets = cidrs(t, "10.8.0.0/16")
+	added, removed := tr.updateCurSubnets(table, "tel0")
+	assert.Equal(t, []string{"10.8.0.0/16"}, cidrStrings(added))
+	assert.Empty(t, removed)
+	assert.Empty(t, routing.Errors(tr.subnetConflicts))
+}
+
+func TestMissingRoutes(t *testing.T) {
+	subnets := cidrs(t, "10.96.0.0/12", "10.244.0.0/16", "192.168.10.0/24")
+	table := []*routing.Route{
+		{RoutedNet: cidrs(t, "0.0.0.0/0")[0], Interface: "eth0", Gateway: net.IP{192, 168, 1, 1}},
+		{RoutedNet: cidrs(t, "10.96.0.0/12")[0], Interface: "tel0"},
+		{RoutedNet: cidrs(t, "10.244.0.0/16")[0], Interface: "eth0"}, // not the own device
+	}
+	assert.Equal(t, []string{"10.244.0.0/16", "192.168.10.0/24"}, cidrStrings(missingRoutes(table, subnets, "tel0")))
+	assert.Empty(t, missingRoutes(append(table,
+		&routing.Route{RoutedNet: cidrs(t, "10.244.0.0/16")[0], Interface: "7"},
+		&routing.Route{RoutedNet: cidrs(t, "192.168.10.0/24")[0], Interface: "tel0"},
+	), subnets, "tel0", "7"))
+}
+
+func TestTunRouter_sessions(t *testing.T) {
+	dev := &routerSession{
+		name:             "dev-ambassador",
+		clusterSubnets:   cidrs(t, "10.96.0.0/12", "10.244.0.0/16"),
+		serviceSubnets:   cidrs(t, "10.96.0.0/12"),
+		alsoProxySubnets: cidrs(t, "192.168.10.0/24"),
+	}
+	shared := &routerSession{
+		name:           "shared-ambassador",
+		clusterSubnets: cidrs(t, "10.100.0.0/16", "10.128.0.0/14"),
+		serviceSubnets: cidrs(t, "10.100.0.0/16"),
+	}
+	tr := &tunRouter{sessions: []*routerSession{dev, shared}}
+	tr.mergeSessionSubnets()
+
+	// The service subnet of the second session overlaps with the one of the first session, so it isn't routed
+	assert.Equal(t, []string{"10.128.0.0/14", "10.244.0.0/16", "10.96.0.0/12"}, cidrStrings(tr.clusterSubnets))
+	assert.Equal(t, []string{"10.96.0.0/12"}, cidrStrings(tr.serviceSubnets))
+	assert.Equal(t, []string{"10.128.0.0/14"}, cidrStrings(shared.routedClusterSubnets))
+	conflicts := tr.sessionConflictsToRPC()
+	require.Len(t, conflicts, 1)
+	assert.Equal(t, "shared-ambassador", conflicts[0].SessionName)
+	assert.Equal(t, "10.100.0.0/16", iputil.IPNetFromRPC(conflicts[0].Subnet).String())
+	assert.Equal(t, "dev-ambassador", conflicts[0].OtherSessionName)
+	assert.Equal(t, "10.96.0.0/12", iputil.IPNetFromRPC(conflicts[0].OtherSubnet).String())
+
+	added, _ := tr.updateCurSubnets(nil)
+	assert.Equal(t, []string{"10.128.0.0/14", "10.244.0.0/16", "10.96.0.0/12", "192.168.10.0/24"}, cidrStrings(added))
+
+	// Packets are routed to the session that owns the destination, and to the first session otherwise
+	assert.Equal(t, dev, tr.sessionFor(net.IP{10, 100, 0, 1}))
+	assert.Equal(t, shared, tr.sessionFor(net.IP{10, 129, 0, 1}))
+	assert.Equal(t, dev, tr.sessionFor(net.IP{10, 244, 1, 1}))
+	assert.Equal(t, dev, tr.sessionFor(net.IP{192, 168, 10, 1}))
+	assert.Equal(t, dev, tr.sessionFor(net.IP{172, 16, 0, 1}))
+
+	// When the first session is removed, the second session's subnets no longer conflict
+	tr.sessions = []*routerSession{shared}
+	tr.mergeSessionSubnets()
+	assert.Empty(t, tr.sessionConflictsToRPC())
+	assert.Equal(t, []string{"10.100.0.0/16", "10.128.0.0/14"}, cidrStrings(tr.clusterSubnets))
+	added, removed := tr.updateCurSubnets(nil)
+	assert.Equal(t, []string{"10.100.0.0/16"}, cidrStrings(added))
+	assert.Equal(t, []string{"10.244.0.0/16", "10.96.0.0/12", "192.168.10.0/24"}, cidrStrings(removed))
+	assert.Equal(t, shared, tr.sessionFor(net.IP{10, 100, 0, 1}))
+}
+
+// echoStream is a tunnel.Stream that receives the messages that are sent to it.
+type echoStream struct {
+	tunnel.Stream
+	msgs chan tunnel.Message
+}
+
+func (s *echoStream) Send(_ context.Context, m tunnel.Message) error {
+	s.msgs <- m
+	return nil
+}
+
+func (s *echoStream) Receive(context.Context) (tunnel.Message, error) {
+	return <-s.msgs, nil
+}
+
+func TestTunRouter_metrics(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	bytesIn := metrics.TunnelBytes.WithLabelValues(metrics.TunnelIn)
+	bytesOut := metrics.TunnelBytes.WithLabelValues(metrics.TunnelOut)
+	in, out := testutil.ToFloat64(bytesIn), testutil.ToFloat64(bytesOut)
+
+	// Only the payload of normal messages is counted
+	s := countingStream{Stream: &echoStream{msgs: make(chan tunnel.Message, 2)}}
+	require.NoError(t, s.Send(ctx, tunnel.NewMessage(tunnel.Normal, []byte("hello"))))
+	require.NoError(t, s.Send(ctx, tunnel.NewMessage(tunnel.Disconnect, nil)))
+	for i := 0; i < 2; i++ {
+		_, err := s.Receive(ctx)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, float64(5), testutil.ToFloat64(bytesIn)-in)
+	assert.Equal(t, float64(5), testutil.ToFloat64(bytesOut)-out)
+
+	active := testutil.ToFloat64(metrics.ActiveConnections)
+	removed := 0
+	remove := countConnection(func() { removed++ })
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.ActiveConnections)-active)
+	remove()
+	remove()
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.ActiveConnections)-active)
+	assert.Equal(t, 2, removed)
+}
+
+// routeRecorder is a routeProgrammer that records the route operations issued to it.
+type routeRecorder struct {
+	ops []string
+}
+
+func (r *routeRecorder) AddSubnet(_ context.Context, sn *net.IPNet) error {
+	r.ops = append(r.ops, "add "+sn.String())
+	return nil
+}
+
+func (r *routeRecorder) RemoveSubnet(_ context.Context, sn *net.IPNet) error {
+	r.ops = append(r.ops, "remove "+sn.String())
+	return nil
+}
+
+func TestTunRouter_clusterSubnetChanges(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	oldGetRoutingTable := getRoutingTable
+	getRoutingTable = func(context.Context) ([]*routing.Route, error) { return nil, nil }
+	defer func() { getRoutingTable = oldGetRoutingTable }()
+
+	rec := &routeRecorder{}
+	s := &routerSession{name: "default", neverProxySubnets: cidrs(t, "10.244.1.128/25")}
+	tr := &tunRouter{routes: rec, sessions: []*routerSession{s}}
+
+	added := metrics.RouteChanges.WithLabelValues(metrics.RouteAdded)
+	removed := metrics.RouteChanges.WithLabelValues(metrics.RouteRemoved)
+	addedBefore, removedBefore := testutil.ToFloat64(added), testutil.ToFloat64(removed)
+
+	snapshot := func(podSubnets ...string) *manager.ClusterInfo {
+		ci := &manager.ClusterInfo{ServiceSubnets: []*manager.IPNet{iputil.IPNetToRPC(cidrs(t, "10.96.0.0/12")[0])}}
+		for _, sn := range cidrs(t, podSubnets...) {
+			ci.PodSubnets = append(ci.PodSubnets, iputil.IPNetToRPC(sn))
+		}
+		return ci
+	}
+	steps := []struct {
+		name     string
+		snapshot *manager.ClusterInfo
+		ops      []string
+	}{
+		{
+			"initial, with a never-proxy subnet subtracted",
+			snapshot("10.244.0.0/24", "10.244.1.0/24"),
+			[]string{"add 10.244.0.0/24", "add 10.244.1.0/25", "add 10.96.0.0/12"},
+		},
+		{
+			"node added",
+			snapshot("10.244.0.0/24", "10.244.1.0/24", "10.244.2.0/24"),
+			[]string{"add 10.244.2.0/24"},
+		},
+		{
+			"unchanged",
+			snapshot("10.244.0.0/24", "10.244.1.0/24", "10.244.2.0/24"),
+			nil,
+		},
+		{
+			"node removed",
+			snapshot("10.244.1.0/24", "10.244.2.0/24"),
+			[]string{"remove 10.244.0.0/24"},
+		},
+		{
+			"node with never-proxy subnet replaced",
+			snapshot("10.244.2.0/24", "10.244.3.0/24"),
+			[]string{"add 10.244.3.0/24", "remove 10.244.1.0/25"},
+		},
+	}
+	for _, step := range steps {
+		rec.ops = nil
+		tr.setClusterSubnets(ctx, s, step.snapshot)
+		sort.Strings(rec.ops)
+		assert.Equal(t, step.ops, rec.ops, step.name)
+	}
+	assert.Equal(t, []string{"10.244.2.0/24", "10.244.3.0/24", "10.96.0.0/12"}, cidrStrings(tr.curSubnets))
+	assert.Equal(t, float64(5), testutil.ToFloat64(added)-addedBefore)
+	assert.Equal(t, float64(2), testutil.ToFloat64(removed)-removedBefore)
+}
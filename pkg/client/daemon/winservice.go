@@ -0,0 +1,50 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/winservice"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// ServiceCommand returns the telepresence sub-command "daemon-service-foreground" that the Windows
+// service control manager runs.
+func ServiceCommand() *cobra.Command {
+	c := &cobra.Command{
+		Use:    winservice.Command,
+		Short:  "Run the Telepresence " + titleName + " as a Windows service",
+		Args:   cobra.NoArgs,
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if !winservice.IsService() {
+				return fmt.Errorf("%s can only be run by the Windows service control manager", winservice.Command)
+			}
+			return winservice.Run(cmd.Context(), runService)
+		},
+	}
+	// The version is only recorded so that the CLI can tell which version installed the service.
+	c.Flags().String("installed-version", "", "The version of telepresence that installed the service")
+	return c
+}
+
+// runService runs the daemon as a Windows service. The args are passed by the CLI when it starts the
+// service. They are the same as the args of the daemon-foreground command, except for the logging
+// directory, because a service logs to the system log directory.
+func runService(c context.Context, args []string, stop <-chan struct{}) error {
+	flags := pflag.NewFlagSet(winservice.Name, pflag.ContinueOnError)
+	userDaemonAddress := flags.String("user-daemon-address", "", "")
+	runtimeDir := flags.String("runtime-dir", "", "")
+	disableTelemetry := flags.Bool("disable-telemetry", false, "")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return fmt.Errorf("expected the arguments <config dir> <dns>, got %q", flags.Args())
+	}
+	c = withUserEnv(c, *userDaemonAddress, *runtimeDir, *disableTelemetry)
+	return run(c, filelocation.AppSystemLogDir(c), flags.Arg(0), flags.Arg(1), runOptions{windowsService: true, stop: stop})
+}
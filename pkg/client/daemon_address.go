@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const tcpScheme = "tcp://"
+
+// ParseDaemonAddress parses the address of a daemon. The address is either a unix socket path (a named
+// pipe on Windows), optionally prefixed with "unix://", or a "tcp://<host>:<port>" address on the loopback
+// interface. The returned address is normalized so that it can be passed to DialSocket and ListenSocket.
+func ParseDaemonAddress(address string) (string, error) {
+	switch {
+	case strings.HasPrefix(address, tcpScheme):
+		host, port, err := net.SplitHostPort(strings.TrimPrefix(address, tcpScheme))
+		if err != nil {
+			return "", fmt.Errorf("invalid address %q: %w", address, err)
+		}
+		if pn, err := strconv.Atoi(port); err != nil || pn <= 0 || pn > 0xffff {
+			return "", fmt.Errorf("invalid address %q: port must be a number between 1 and 65535", address)
+		}
+		if !isLoopbackHost(host) {
+			// Other users on the host could reach a daemon that listens on any other interface
+			return "", fmt.Errorf("invalid address %q: host must be a loopback address", address)
+		}
+		return tcpScheme + net.JoinHostPort(host, port), nil
+	case strings.Contains(address, "://") && !strings.HasPrefix(address, "unix://"):
+		return "", fmt.Errorf("invalid address %q: only unix:// and tcp:// addresses are supported", address)
+	default:
+		path := strings.TrimPrefix(address, "unix://")
+		if !filepath.IsAbs(path) {
+			return "", fmt.Errorf("invalid address %q: socket path must be absolute", address)
+		}
+		return path, nil
+	}
+}
+
+// ParseSOCKS5Address parses the "<host>:<port>" address of the SOCKS5 proxy of the "socks5" proxy mode. The
+// host must be a loopback address, because the proxy doesn't authenticate its clients.
+func ParseSOCKS5Address(address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %q: %w", address, err)
+	}
+	if pn, err := strconv.Atoi(port); err != nil || pn <= 0 || pn > 0xffff {
+		return "", fmt.Errorf("invalid address %q: port must be a number between 1 and 65535", address)
+	}
+	if !isLoopbackHost(host) {
+		// Anyone who can reach the proxy can reach the cluster
+		return "", fmt.Errorf("invalid address %q: host must be a loopback address", address)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// isLoopbackHost returns true if the given host is "localhost" or a loopback IP.
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// SOCKS5Address returns the socks5Address of the client config, or the DefaultSOCKS5Address when none is
+// configured.
+func SOCKS5Address(ctx context.Context) string {
+	if cfg := GetConfig(ctx); cfg != nil && cfg.SOCKS5Address != "" {
+		return cfg.SOCKS5Address
+	}
+	return DefaultSOCKS5Address
+}
+
+// UserDaemonAddress returns the address of the user daemon. It's the TELEPRESENCE_USER_DAEMON_ADDRESS
+// environment variable when set, otherwise the userDaemonAddress of the client config, or the default
+// ConnectorSocketName. Both settings are validated when loaded.
+func UserDaemonAddress(ctx context.Context) string {
+	if env := GetEnv(ctx); env != nil && env.UserDaemonAddress != "" {
+		return env.UserDaemonAddress
+	}
+	if cfg := GetConfig(ctx); cfg != nil && cfg.UserDaemonAddress != "" {
+		return cfg.UserDaemonAddress
+	}
+	return ConnectorSocketName
+}
+
+func isTCPAddress(address string) bool {
+	return strings.HasPrefix(address, tcpScheme)
+}
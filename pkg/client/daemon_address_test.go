@@ -0,0 +1,112 @@
+package client
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func TestParseDaemonAddress(t *testing.T) {
+	abs, err := filepath.Abs("connector.socket")
+	require.NoError(t, err)
+
+	valid := map[string]string{
+		"tcp://127.0.0.1:4711": "tcp://127.0.0.1:4711",
+		"tcp://localhost:4711": "tcp://localhost:4711",
+		"tcp://[::1]:4711":     "tcp://[::1]:4711",
+		abs:                    abs,
+		"unix://" + abs:        abs,
+	}
+	for address, expected := range valid {
+		parsed, err := ParseDaemonAddress(address)
+		if assert.NoError(t, err, address) {
+			assert.Equal(t, expected, parsed, address)
+		}
+	}
+
+	invalid := map[string]string{
+		"tcp://0.0.0.0:4711":      "loopback",
+		"tcp://192.168.1.7:4711":  "loopback",
+		"tcp://example.com:4711":  "loopback",
+		"tcp://127.0.0.1":         "missing port",
+		"tcp://127.0.0.1:0":       "port must be",
+		"tcp://127.0.0.1:http":    "port must be",
+		"tcp://127.0.0.1:70000":   "port must be",
+		"http://127.0.0.1:4711":   "only unix:// and tcp://",
+		"connector.socket":        "must be absolute",
+		"unix://connector.socket": "must be absolute",
+	}
+	for address, msg := range invalid {
+		_, err := ParseDaemonAddress(address)
+		if assert.Error(t, err, address) {
+			assert.Contains(t, err.Error(), msg, address)
+		}
+	}
+}
+
+func TestParseSOCKS5Address(t *testing.T) {
+	valid := map[string]string{
+		"127.0.0.1:1080": "127.0.0.1:1080",
+		"localhost:1080": "localhost:1080",
+		"[::1]:1080":     "[::1]:1080",
+	}
+	for address, expected := range valid {
+		parsed, err := ParseSOCKS5Address(address)
+		if assert.NoError(t, err, address) {
+			assert.Equal(t, expected, parsed, address)
+		}
+	}
+
+	invalid := map[string]string{
+		"0.0.0.0:1080":     "loopback",
+		"example.com:1080": "loopback",
+		"127.0.0.1":        "missing port",
+		"127.0.0.1:0":      "port must be",
+		"127.0.0.1:socks":  "port must be",
+	}
+	for address, msg := range invalid {
+		_, err := ParseSOCKS5Address(address)
+		if assert.Error(t, err, address) {
+			assert.Contains(t, err.Error(), msg, address)
+		}
+	}
+
+	ctx := WithConfig(dlog.NewTestContext(t, false), &Config{})
+	assert.Equal(t, DefaultSOCKS5Address, SOCKS5Address(ctx))
+	ctx = WithConfig(ctx, &Config{SOCKS5Address: "127.0.0.1:1081"})
+	assert.Equal(t, "127.0.0.1:1081", SOCKS5Address(ctx))
+}
+
+func TestUserDaemonAddress(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+
+	// Fall back to the default when nothing is configured
+	assert.Equal(t, ConnectorSocketName, UserDaemonAddress(ctx))
+	ctx = WithEnv(ctx, &Env{})
+	ctx = WithConfig(ctx, &Config{})
+	assert.Equal(t, ConnectorSocketName, UserDaemonAddress(ctx))
+
+	ctx = WithConfig(ctx, &Config{UserDaemonAddress: "tcp://127.0.0.1:4711"})
+	assert.Equal(t, "tcp://127.0.0.1:4711", UserDaemonAddress(ctx))
+
+	// The environment overrides the config
+	ctx = WithEnv(ctx, &Env{UserDaemonAddress: "tcp://127.0.0.1:4712"})
+	assert.Equal(t, "tcp://127.0.0.1:4712", UserDaemonAddress(ctx))
+}
+
+func TestLoadEnv_userDaemonAddress(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	t.Setenv("TELEPRESENCE_USER_DAEMON_ADDRESS", "tcp://localhost:4711")
+	env, err := LoadEnv(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "tcp://localhost:4711", env.UserDaemonAddress)
+
+	t.Setenv("TELEPRESENCE_USER_DAEMON_ADDRESS", "tcp://0.0.0.0:4711")
+	_, err = LoadEnv(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "TELEPRESENCE_USER_DAEMON_ADDRESS")
+}
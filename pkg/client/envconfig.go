@@ -2,11 +2,18 @@ package client
 
 import (
 	"context"
+	"fmt"
 	"os"
 
 	"github.com/sethvargo/go-envconfig"
 )
 
+// The defaults of the Env values that are defaults of the config.
+const (
+	defaultManagerNamespace = "ambassador"
+	defaultRegistry         = "docker.io/datawire"
+)
+
 type Env struct {
 	// I'd like to set TELEPRESENCE_LOGIN_DOMAIN,default=auth.datawire.io, but
 	// sethvargo/go-envconfig doesn't support filling in the default for our later references to
@@ -21,11 +28,17 @@ type Env struct {
 
 	ManagerNamespace string `env:"TELEPRESENCE_MANAGER_NAMESPACE,default=ambassador"`
 
-	// This environment variable becomes the default for the images.registry and images.webhookRegistry
+	// This environment variable becomes the default for the images.registry
 	Registry string `env:"TELEPRESENCE_REGISTRY,default=docker.io/datawire"`
 
-	// This environment variable becomes the default for the images.agentImage and images.webhookAgentImage
+	// This environment variable becomes the default for the images.agentImage
 	AgentImage string `env:"TELEPRESENCE_AGENT_IMAGE,default="`
+
+	// This environment variable overrides the userDaemonAddress of the client config
+	UserDaemonAddress string `env:"TELEPRESENCE_USER_DAEMON_ADDRESS,default="`
+
+	// Any non-empty value disables telemetry, regardless of the telemetry.enabled of the client config
+	ScoutDisable string `env:"SCOUT_DISABLE,default="`
 }
 
 func (env Env) Get(key string) string {
@@ -84,5 +97,19 @@ func LoadEnv(ctx context.Context) (*Env, error) {
 	if err := envconfig.Process(ctx, &env); err != nil {
 		return nil, err
 	}
+	if err := ValidateRegistry(env.Registry); err != nil {
+		return nil, fmt.Errorf("TELEPRESENCE_REGISTRY: %w", err)
+	}
+	if env.AgentImage != "" {
+		if err := ValidateImage(env.AgentImage); err != nil {
+			return nil, fmt.Errorf("TELEPRESENCE_AGENT_IMAGE: %w", err)
+		}
+	}
+	if env.UserDaemonAddress != "" {
+		var err error
+		if env.UserDaemonAddress, err = ParseDaemonAddress(env.UserDaemonAddress); err != nil {
+			return nil, fmt.Errorf("TELEPRESENCE_USER_DAEMON_ADDRESS: %w", err)
+		}
+	}
 	return &env, nil
 }
@@ -22,6 +22,22 @@ const (
 	Unknown // Something else. Consult the logs
 )
 
+// String returns the name of the category, e.g. "user" or "config".
+func (c Category) String() string {
+	switch c {
+	case OK:
+		return "ok"
+	case User:
+		return "user"
+	case Config:
+		return "config"
+	case NoLogs:
+		return "no_logs"
+	default:
+		return "unknown"
+	}
+}
+
 // New creates a new categorized error based in its argument. The argument
 // can be an error or a string. If it isn't, it will be converted to a string
 // using its '%v' formatter.
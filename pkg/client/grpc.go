@@ -0,0 +1,264 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// sessionNameKey is the gRPC metadata key that carries the name of the user daemon session that a call
+// from the root daemon to the traffic-manager proxy of the user daemon belongs to.
+const sessionNameKey = "x-telepresence-session"
+
+// SessionNameDialOptions returns the dial options that make every call on a connection carry the given
+// session name, so that the user daemon can pass the call on to the traffic-manager of that session.
+func SessionNameDialOptions(name string) []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(metadata.AppendToOutgoingContext(ctx, sessionNameKey, name), method, req, reply, cc, opts...)
+		}),
+		grpc.WithChainStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(metadata.AppendToOutgoingContext(ctx, sessionNameKey, name), desc, cc, method, opts...)
+		}),
+	}
+}
+
+// SessionNameFromContext returns the session name that the caller of an incoming call added using the
+// SessionNameDialOptions, and true, or an empty string and false when the call carries no session name.
+func SessionNameFromContext(ctx context.Context) (string, bool) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if names := md.Get(sessionNameKey); len(names) > 0 {
+			return names[0], true
+		}
+	}
+	return "", false
+}
+
+// sessionContextKey is the gRPC metadata key that carries the kubernetes context of the user daemon
+// session that a call from the CLI to the traffic-manager proxy of the user daemon is for.
+const sessionContextKey = "x-telepresence-context"
+
+// WithSessionContext returns a context that makes the outgoing calls made with it carry the given
+// kubernetes context, so that the user daemon can pass them on to the traffic-manager of the session
+// of that context. The given context is returned unchanged when the kubernetes context is empty.
+func WithSessionContext(ctx context.Context, kubeContext string) context.Context {
+	if kubeContext == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, sessionContextKey, kubeContext)
+}
+
+// SessionContextFromContext returns the kubernetes context that the caller of an incoming call added
+// using WithSessionContext, and true, or an empty string and false when the call carries no context.
+func SessionContextFromContext(ctx context.Context) (string, bool) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if kubeContexts := md.Get(sessionContextKey); len(kubeContexts) > 0 {
+			return kubeContexts[0], true
+		}
+	}
+	return "", false
+}
+
+// The gRPC metadata keys that carry the connector API version, the oldest connector API version that is
+// served, and the version of the binary, of the CLI in its calls to the user daemon, and of the user
+// daemon in the header of its responses.
+const (
+	apiVersionKey    = "x-telepresence-api-version"
+	minAPIVersionKey = "x-telepresence-min-api-version"
+	versionKey       = "x-telepresence-version"
+)
+
+// PeerVersion is what the peer of a call between the CLI and the user daemon announces about its version.
+type PeerVersion struct {
+	// API is the connector API version of the peer, and MinAPI is the oldest one that it serves.
+	API    int
+	MinAPI int
+
+	// Version is the version of the peer's binary. It's empty when the peer predates the versioning.
+	Version string
+}
+
+// OwnVersion returns the PeerVersion that this binary announces.
+func OwnVersion() PeerVersion {
+	return PeerVersion{API: ConnectorAPIVersion, MinAPI: MinConnectorAPIVersion, Version: Version()}
+}
+
+// String returns the peer's version as it's presented to the user, e.g. "telepresence v2.4.5".
+func (pv PeerVersion) String() string {
+	if pv.Version == "" {
+		return "telepresence 2.4.4 or older"
+	}
+	return "telepresence " + pv.Version
+}
+
+// Serves returns true if the peer serves the connector API version of the other peer.
+func (pv PeerVersion) Serves(other PeerVersion) bool {
+	return pv.MinAPI <= other.API && other.API <= pv.API
+}
+
+// Compatible returns true if the two peers have a connector API version in common, i.e. when one of them
+// serves the version of the other.
+func (pv PeerVersion) Compatible(other PeerVersion) bool {
+	return pv.Serves(other) || other.Serves(pv)
+}
+
+// VersionMismatch returns the message that tells the user that the CLI and the user daemon have no
+// connector API version in common, and what to do about it.
+func VersionMismatch(cli, daemon PeerVersion) string {
+	if cli.API > daemon.API {
+		return fmt.Sprintf("the user daemon is %s, which is too old to serve this CLI, which is %s. "+
+			`Quit the daemons with "telepresence quit -s" so that they are relaunched with this version`, daemon, cli)
+	}
+	return fmt.Sprintf("the user daemon is %s, which no longer serves this CLI, which is %s. "+
+		"Please upgrade the CLI", daemon, cli)
+}
+
+// pairs returns the metadata key-value pairs that announce the peer's version.
+func (pv PeerVersion) pairs() []string {
+	return []string{
+		apiVersionKey, strconv.Itoa(pv.API),
+		minAPIVersionKey, strconv.Itoa(pv.MinAPI),
+		versionKey, pv.Version,
+	}
+}
+
+// PeerVersionFromMetadata returns the PeerVersion that the given metadata announces. A peer that announces
+// nothing predates the versioning, and speaks version 1 of the connector API.
+func PeerVersionFromMetadata(md metadata.MD) PeerVersion {
+	pv := PeerVersion{API: 1, MinAPI: 1}
+	if vs := md.Get(apiVersionKey); len(vs) > 0 {
+		if v, err := strconv.Atoi(vs[0]); err == nil && v > 0 {
+			pv.API = v
+			pv.MinAPI = v
+		}
+	}
+	if vs := md.Get(minAPIVersionKey); len(vs) > 0 {
+		if v, err := strconv.Atoi(vs[0]); err == nil && v > 0 && v <= pv.API {
+			pv.MinAPI = v
+		}
+	}
+	if vs := md.Get(versionKey); len(vs) > 0 {
+		pv.Version = vs[0]
+	}
+	return pv
+}
+
+// PeerVersionFromContext returns the PeerVersion that the caller of an incoming call announced.
+func PeerVersionFromContext(ctx context.Context) PeerVersion {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return PeerVersionFromMetadata(md)
+}
+
+// VersionHeader returns the metadata that the user daemon sends in the header of its responses to announce
+// its version.
+func VersionHeader() metadata.MD {
+	return metadata.Pairs(OwnVersion().pairs()...)
+}
+
+// VersionDialOptions returns the dial options that make every call on a connection announce the version of
+// this binary.
+func VersionDialOptions() []grpc.DialOption {
+	kvs := OwnVersion().pairs()
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(metadata.AppendToOutgoingContext(ctx, kvs...), method, req, reply, cc, opts...)
+		}),
+		grpc.WithChainStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(metadata.AppendToOutgoingContext(ctx, kvs...), desc, cc, method, opts...)
+		}),
+	}
+}
+
+// GrpcDialOptions returns the dial options that apply the "grpc" settings of the client config to a
+// connection that the CLI or a daemon opens.
+func GrpcDialOptions(ctx context.Context) []grpc.DialOption {
+	opts := []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(resourceExhaustedUnaryInterceptor),
+		grpc.WithChainStreamInterceptor(resourceExhaustedStreamInterceptor),
+	}
+	cfg := GetConfig(ctx)
+	if cfg == nil {
+		return opts
+	}
+	g := &cfg.Grpc
+	if mxRecvSize := g.MaxReceiveSize; mxRecvSize != nil {
+		if mz, ok := mxRecvSize.AsInt64(); ok {
+			opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(int(mz))))
+		}
+	}
+	if g.KeepAliveTime > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                g.KeepAliveTime,
+			Timeout:             g.KeepAliveTimeout,
+			PermitWithoutStream: true,
+		}))
+	}
+	return opts
+}
+
+// GrpcServerOptions returns the server options that apply the "grpc" settings of the client config to
+// the gRPC server of a daemon. The servers are served using dhttp, so keepalive pings are answered by
+// the HTTP/2 server and need no enforcement policy.
+func GrpcServerOptions(ctx context.Context) []grpc.ServerOption {
+	var opts []grpc.ServerOption
+	if cfg := GetConfig(ctx); cfg != nil {
+		if mxRecvSize := cfg.Grpc.MaxReceiveSize; mxRecvSize != nil {
+			if mz, ok := mxRecvSize.AsInt64(); ok {
+				opts = append(opts, grpc.MaxRecvMsgSize(int(mz)))
+			}
+		}
+	}
+	return opts
+}
+
+// checkResourceExhausted adds a hint about how to raise the limit to an error caused by a message
+// that exceeds the maximum receive size. The returned error retains the gRPC status code.
+func checkResourceExhausted(ctx context.Context, err error) error {
+	if st, ok := status.FromError(err); ok && st.Code() == codes.ResourceExhausted && strings.Contains(st.Message(), "larger than max") {
+		err = status.Errorf(codes.ResourceExhausted, "%s. The limit can be configured as %q in %q",
+			st.Message(), "grpc.maxReceiveSize", GetConfigFile(ctx))
+	}
+	return err
+}
+
+func resourceExhaustedUnaryInterceptor(
+	ctx context.Context,
+	method string,
+	req, reply interface{},
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	return checkResourceExhausted(ctx, invoker(ctx, method, req, reply, cc, opts...))
+}
+
+func resourceExhaustedStreamInterceptor(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	cc *grpc.ClientConn,
+	method string,
+	streamer grpc.Streamer,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	s, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		return nil, checkResourceExhausted(ctx, err)
+	}
+	return &resourceExhaustedStream{ClientStream: s, ctx: ctx}, nil
+}
+
+type resourceExhaustedStream struct {
+	grpc.ClientStream
+	ctx context.Context
+}
+
+func (s *resourceExhaustedStream) RecvMsg(m interface{}) error {
+	return checkResourceExhausted(s.ctx, s.ClientStream.RecvMsg(m))
+}
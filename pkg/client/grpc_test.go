@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/common"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// oversizedSize exceeds the 4MiB that gRPC permits a client to receive by default.
+const oversizedSize = 5 * 1024 * 1024
+
+type oversizedConnector struct {
+	connector.UnimplementedConnectorServer
+}
+
+func (oversizedConnector) Version(context.Context, *emptypb.Empty) (*common.VersionInfo, error) {
+	return &common.VersionInfo{Version: strings.Repeat("x", oversizedSize)}, nil
+}
+
+func (oversizedConnector) UserNotifications(_ *emptypb.Empty, stream connector.Connector_UserNotificationsServer) error {
+	return stream.Send(&connector.Notification{Message: strings.Repeat("x", oversizedSize)})
+}
+
+func dialOversizedConnector(ctx context.Context, t *testing.T) connector.ConnectorClient {
+	lis := bufconn.Listen(1024 * 1024)
+	svc := grpc.NewServer(GrpcServerOptions(ctx)...)
+	connector.RegisterConnectorServer(svc, oversizedConnector{})
+	go func() { _ = svc.Serve(lis) }()
+	t.Cleanup(svc.Stop)
+
+	conn, err := grpc.DialContext(ctx, "bufconn", append([]grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+	}, GrpcDialOptions(ctx)...)...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return connector.NewConnectorClient(conn)
+}
+
+func TestGrpcDialOptions_maxReceiveSize(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	ctx = filelocation.WithAppUserConfigDir(ctx, t.TempDir())
+	env, err := LoadEnv(ctx)
+	require.NoError(t, err)
+	ctx = WithEnv(ctx, env)
+
+	t.Run("default", func(t *testing.T) {
+		cfg := GetDefaultConfig(ctx)
+		cc := dialOversizedConnector(WithConfig(ctx, &cfg), t)
+
+		_, err := cc.Version(ctx, &emptypb.Empty{})
+		require.Error(t, err)
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.Contains(t, err.Error(), `"grpc.maxReceiveSize"`)
+
+		stream, err := cc.UserNotifications(ctx, &emptypb.Empty{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		require.Error(t, err)
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.Contains(t, err.Error(), `"grpc.maxReceiveSize"`)
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		cfg := GetDefaultConfig(ctx)
+		mz := resource.MustParse("8Mi")
+		cfg.Grpc.MaxReceiveSize = &mz
+		cc := dialOversizedConnector(WithConfig(ctx, &cfg), t)
+
+		vi, err := cc.Version(ctx, &emptypb.Empty{})
+		require.NoError(t, err)
+		assert.Len(t, vi.Version, oversizedSize)
+
+		stream, err := cc.UserNotifications(ctx, &emptypb.Empty{})
+		require.NoError(t, err)
+		n, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Len(t, n.Message, oversizedSize)
+	})
+}
+
+type sessionNameConnector struct {
+	connector.UnimplementedConnectorServer
+}
+
+func (sessionNameConnector) Version(ctx context.Context, _ *emptypb.Empty) (*common.VersionInfo, error) {
+	name, ok := SessionNameFromContext(ctx)
+	if !ok {
+		name = "<none>"
+	}
+	return &common.VersionInfo{Version: name}, nil
+}
+
+func TestSessionNameDialOptions(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	lis := bufconn.Listen(1024 * 1024)
+	svc := grpc.NewServer()
+	connector.RegisterConnectorServer(svc, sessionNameConnector{})
+	go func() { _ = svc.Serve(lis) }()
+	t.Cleanup(svc.Stop)
+
+	dial := func(opts ...grpc.DialOption) connector.ConnectorClient {
+		conn, err := grpc.DialContext(ctx, "bufconn", append([]grpc.DialOption{
+			grpc.WithInsecure(),
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+				return lis.Dial()
+			}),
+		}, opts...)...)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		return connector.NewConnectorClient(conn)
+	}
+
+	vi, err := dial(SessionNameDialOptions("dev-ambassador")...).Version(ctx, &emptypb.Empty{})
+	require.NoError(t, err)
+	assert.Equal(t, "dev-ambassador", vi.Version)
+
+	vi, err = dial().Version(ctx, &emptypb.Empty{})
+	require.NoError(t, err)
+	assert.Equal(t, "<none>", vi.Version)
+}
@@ -0,0 +1,27 @@
+package helper
+
+import (
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFDsStart is the first file descriptor that systemd passes to a socket-activated service.
+const listenFDsStart = 3
+
+// activationListener returns the listener that systemd passes to the helper when it's started by
+// socket activation, or nil when it isn't.
+func activationListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	if n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS")); n < 1 {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	f := os.NewFile(listenFDsStart, "LISTEN_FD_3")
+	defer f.Close()
+	return net.FileListener(f)
+}
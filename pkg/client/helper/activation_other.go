@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package helper
+
+import (
+	"net"
+)
+
+// activationListener returns nil because launchd daemons on macOS keep the helper running, so it
+// creates its own socket.
+func activationListener() (net.Listener, error) {
+	return nil, nil
+}
@@ -0,0 +1,57 @@
+package helper
+
+import (
+	"context"
+	"runtime"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/helper"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// socketName is a variable so that tests can use a helper that listens to another socket.
+var socketName = SocketName
+
+// StartDaemon asks the privileged helper to start the root daemon. The returned bool is false when no
+// helper is installed or when it can't be reached, in which case the caller should start the root
+// daemon using sudo instead.
+func StartDaemon(ctx context.Context, rq *rpc.StartDaemonRequest) (bool, error) {
+	if runtime.GOOS == "windows" {
+		return false, nil
+	}
+	if exists, err := client.SocketExists(ctx, socketName); err != nil || !exists {
+		return false, nil
+	}
+	conn, err := client.DialSocket(ctx, socketName)
+	if err != nil {
+		dlog.Warnf(ctx, "unable to contact the privileged helper: %v", err)
+		return false, nil
+	}
+	defer conn.Close()
+	helperClient := rpc.NewHelperClient(conn)
+
+	// The helper launches its own binary, which must be the binary of this CLI.
+	vi, err := helperClient.Version(ctx, &empty.Empty{})
+	if err != nil {
+		dlog.Warnf(ctx, "unable to use the privileged helper: %v", err)
+		return false, nil
+	}
+	if vi.Version != client.Version() {
+		dlog.Warnf(ctx, "unable to use the privileged helper: it was installed by telepresence %s but this is telepresence %s",
+			vi.Version, client.Version())
+		return false, nil
+	}
+	if _, err = helperClient.StartDaemon(ctx, rq); err != nil {
+		switch status.Code(err) {
+		case codes.Unavailable, codes.Unimplemented:
+			dlog.Warnf(ctx, "unable to use the privileged helper: %v", err)
+			return false, nil
+		}
+		return true, err
+	}
+	return true, nil
+}
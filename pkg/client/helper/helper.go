@@ -0,0 +1,237 @@
+// Package helper contains the privileged helper, a small process that runs as root and starts the root
+// daemon on behalf of unprivileged users so that they don't need sudo. It's installed once as a systemd
+// socket-activated unit on Linux or as a launchd daemon on macOS.
+package helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/common"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/helper"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+)
+
+const ProcessName = "helper"
+
+// SocketName is the path of the socket that the helper listens to. A systemd unit that activates the
+// helper must use the same path.
+const SocketName = "/var/run/telepresence-helper.socket"
+
+// Command returns the telepresence sub-command "helper-foreground"
+func Command() *cobra.Command {
+	return &cobra.Command{
+		Use:    ProcessName + "-foreground",
+		Short:  "Launch the Telepresence privileged helper in the foreground",
+		Args:   cobra.NoArgs,
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return run(cmd.Context())
+		},
+	}
+}
+
+func run(c context.Context) error {
+	if !proc.IsAdmin() {
+		return fmt.Errorf("telepresence %s must run with elevated privileges", ProcessName)
+	}
+	dlog.Infof(c, "Telepresence %s %s starting...", ProcessName, client.DisplayVersion())
+
+	listener, err := activationListener()
+	if err != nil {
+		return fmt.Errorf("unable to use the socket passed by systemd: %w", err)
+	}
+	if listener == nil {
+		if listener, err = client.ListenSocket(c, ProcessName, SocketName); err != nil {
+			return err
+		}
+		defer func() {
+			_ = client.RemoveSocket(listener)
+		}()
+		if err = restrictHelperSocket(c, SocketName); err != nil {
+			return err
+		}
+	}
+
+	g := dgroup.NewGroup(c, dgroup.GroupConfig{
+		EnableSignalHandling: true,
+	})
+	g.Go("server-grpc", func(c context.Context) error {
+		return serve(c, listener, &service{startDaemon: startDaemon})
+	})
+	return g.Wait()
+}
+
+// serve serves the given service on the given listener until the context is cancelled. The gRPC server
+// must not be served using dhttp because the handshake that retrieves the peer credentials only runs
+// when the server serves the listener itself.
+func serve(c context.Context, listener net.Listener, s rpc.HelperServer) error {
+	svc := grpc.NewServer(grpc.Creds(peerCredentials{}))
+	rpc.RegisterHelperServer(svc, s)
+	go func() {
+		<-c.Done()
+		svc.GracefulStop()
+	}()
+	return svc.Serve(listener)
+}
+
+// startDaemon starts the root daemon with the given arguments.
+func startDaemon(_ context.Context, args ...string) error {
+	return proc.StartInBackground(append([]string{client.GetExe()}, args...)...)
+}
+
+type service struct {
+	rpc.UnsafeHelperServer
+	startDaemon func(context.Context, ...string) error
+}
+
+func (s *service) Version(_ context.Context, _ *empty.Empty) (*common.VersionInfo, error) {
+	return &common.VersionInfo{
+		ApiVersion: client.APIVersion,
+		Version:    client.Version(),
+	}, nil
+}
+
+func (s *service) StartDaemon(c context.Context, rq *rpc.StartDaemonRequest) (*empty.Empty, error) {
+	uid, err := callerUID(c)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	args, err := daemonArgs(c, uid, rq)
+	if err != nil {
+		dlog.Errorf(c, "refusing to start the root daemon for uid %d: %v", uid, err)
+		return nil, err
+	}
+	dlog.Infof(c, "starting the root daemon for uid %d", uid)
+	if err = s.startDaemon(c, args...); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to launch the root daemon: %v", err)
+	}
+	return &empty.Empty{}, nil
+}
+
+// callerUID returns the user id of the process that made the call.
+func callerUID(c context.Context) (int, error) {
+	if p, ok := peer.FromContext(c); ok {
+		if ai, ok := p.AuthInfo.(peerCredInfo); ok {
+			return ai.uid, nil
+		}
+	}
+	return 0, errors.New("unable to determine the user id of the caller")
+}
+
+// lookupHomeDir returns the home directory of the user with the given uid.
+var lookupHomeDir = func(uid int) (string, error) {
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return "", err
+	}
+	return u.HomeDir, nil
+}
+
+// runUserDir is the directory that contains the runtime directories that systemd-logind creates for
+// logged-in users.
+var runUserDir = "/run/user"
+
+// userDirs returns the log, config, and runtime directories of the user with the given uid. They are derived
+// from the uid rather than taken from the request, so that no user can make the root daemon read or
+// write files of another user.
+func userDirs(c context.Context, uid int) (logDir, configDir, runtimeDir string, err error) {
+	home, err := lookupHomeDir(uid)
+	if err != nil {
+		return "", "", "", err
+	}
+	uc := filelocation.WithUserHomeDir(c, home)
+	if logDir, err = filelocation.AppUserLogDir(uc); err != nil {
+		return "", "", "", err
+	}
+	if configDir, err = filelocation.AppUserConfigDir(uc); err != nil {
+		return "", "", "", err
+	}
+	// The helper doesn't know the XDG_RUNTIME_DIR of the user, but it's the directory that
+	// systemd-logind creates for the user when it exists.
+	runDir := filepath.Join(runUserDir, strconv.Itoa(uid))
+	if owner, err := fileOwner(runDir); err == nil && owner == uid {
+		runtimeDir = filepath.Join(runDir, "telepresence")
+	} else if runtimeDir, err = filelocation.AppUserCacheDir(uc); err != nil {
+		return "", "", "", err
+	}
+	for _, dir := range []string{logDir, configDir, runtimeDir} {
+		if err = checkOwner(dir, uid); err != nil {
+			return "", "", "", err
+		}
+	}
+	return logDir, configDir, runtimeDir, nil
+}
+
+// daemonArgs returns the arguments that start the root daemon as requested by the user with the given
+// uid. The daemon uses the directories of that user and only accepts connections from that user.
+func daemonArgs(c context.Context, uid int, rq *rpc.StartDaemonRequest) ([]string, error) {
+	logDir, configDir, runtimeDir, err := userDirs(c, uid)
+	if err != nil {
+		return nil, status.Errorf(codes.PermissionDenied, "uid %d: %v", uid, err)
+	}
+	if rq.Dns != "" {
+		ip := net.ParseIP(rq.Dns)
+		if ip == nil {
+			return nil, status.Errorf(codes.InvalidArgument, "dns %q is not an IP address", rq.Dns)
+		}
+		if err = CheckNameserver(ip); err != nil {
+			return nil, status.Errorf(codes.PermissionDenied, "dns: %v", err)
+		}
+	}
+	address, err := client.ParseDaemonAddress(rq.UserDaemonAddress)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "user_daemon_address: %v", err)
+	}
+	args := []string{
+		"daemon-foreground", logDir, configDir, rq.Dns,
+		"--user-daemon-address", address,
+		"--runtime-dir", runtimeDir,
+		"--uid", strconv.Itoa(uid),
+		"--privileged-helper",
+	}
+	if rq.DisableTelemetry {
+		args = append(args, "--disable-telemetry")
+	}
+	return args, nil
+}
+
+// checkOwner checks that the given path, or its closest existing parent when it doesn't exist yet,
+// is owned by the given uid.
+func checkOwner(path string, uid int) error {
+	path = filepath.Clean(path)
+	for {
+		owner, err := fileOwner(path)
+		switch {
+		case err == nil:
+			if uid != 0 && owner != uid {
+				return fmt.Errorf("%q is not owned by uid %d", path, uid)
+			}
+			return nil
+		case !os.IsNotExist(err):
+			return err
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return err
+		}
+		path = parent
+	}
+}
@@ -0,0 +1,220 @@
+//go:build !windows
+// +build !windows
+
+package helper
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/helper"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+func validRequest(t *testing.T) *rpc.StartDaemonRequest {
+	fakeUserDirs(t)
+	fakeResolvConf(t, "10.0.0.10")
+	return &rpc.StartDaemonRequest{
+		Dns:               "10.0.0.10",
+		UserDaemonAddress: "tcp://127.0.0.1:4711",
+	}
+}
+
+// fakeUserDirs makes the helper find the home directory of every user in a temporary directory and the
+// runtime directories that systemd-logind creates in another.
+func fakeUserDirs(t *testing.T) (home, runDir string) {
+	home = t.TempDir()
+	runDir = t.TempDir()
+	origLookup, origRun := lookupHomeDir, runUserDir
+	lookupHomeDir = func(int) (string, error) { return home, nil }
+	runUserDir = runDir
+	t.Cleanup(func() { lookupHomeDir, runUserDir = origLookup, origRun })
+	return home, runDir
+}
+
+func TestDaemonArgs(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	uid := os.Getuid()
+	rq := validRequest(t)
+	home, runDir := fakeUserDirs(t)
+	uc := filelocation.WithUserHomeDir(ctx, home)
+	logDir, err := filelocation.AppUserLogDir(uc)
+	require.NoError(t, err)
+	configDir, err := filelocation.AppUserConfigDir(uc)
+	require.NoError(t, err)
+	cacheDir, err := filelocation.AppUserCacheDir(uc)
+	require.NoError(t, err)
+
+	args, err := daemonArgs(ctx, uid, rq)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"daemon-foreground", logDir, configDir, "10.0.0.10",
+		"--user-daemon-address", "tcp://127.0.0.1:4711",
+		"--runtime-dir", cacheDir,
+		"--uid", strconv.Itoa(uid),
+		"--privileged-helper",
+	}, args)
+
+	// The runtime directory that systemd-logind created for the user is preferred
+	require.NoError(t, os.Mkdir(filepath.Join(runDir, strconv.Itoa(uid)), 0o700))
+	args, err = daemonArgs(ctx, uid, rq)
+	require.NoError(t, err)
+	assert.Contains(t, args, filepath.Join(runDir, strconv.Itoa(uid), "telepresence"))
+
+	rq.DisableTelemetry = true
+	args, err = daemonArgs(ctx, uid, rq)
+	require.NoError(t, err)
+	assert.Equal(t, "--disable-telemetry", args[len(args)-1])
+
+	tests := []struct {
+		name   string
+		uid    int
+		modify func(*rpc.StartDaemonRequest)
+		code   codes.Code
+	}{
+		{"dirs of another user", uid + 1, func(rq *rpc.StartDaemonRequest) {}, codes.PermissionDenied},
+		{"unknown user", uid, func(rq *rpc.StartDaemonRequest) {
+			lookupHomeDir = func(uid int) (string, error) { return "", user.UnknownUserIdError(uid) }
+		}, codes.PermissionDenied},
+		{"invalid dns", uid, func(rq *rpc.StartDaemonRequest) { rq.Dns = "dns.example.com" }, codes.InvalidArgument},
+		{"dns not a name server", uid, func(rq *rpc.StartDaemonRequest) { rq.Dns = "192.0.2.53" }, codes.PermissionDenied},
+		{"remote user daemon", uid, func(rq *rpc.StartDaemonRequest) { rq.UserDaemonAddress = "tcp://0.0.0.0:4711" }, codes.InvalidArgument},
+		{"no user daemon", uid, func(rq *rpc.StartDaemonRequest) { rq.UserDaemonAddress = "" }, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			rq := validRequest(t)
+			tt.modify(rq)
+			_, err := daemonArgs(ctx, tt.uid, rq)
+			require.Error(t, err)
+			assert.Equal(t, tt.code, status.Code(err), err.Error())
+		})
+	}
+}
+
+func TestRestrictToUser(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	uid := os.Getuid()
+	sock := filepath.Join(t.TempDir(), "daemon.socket")
+	listener, err := client.ListenSocket(ctx, ProcessName, sock)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	listener, err = RestrictToUser(ctx, listener, uid)
+	require.NoError(t, err)
+	fi, err := os.Stat(sock)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+	owner, err := fileOwner(sock)
+	require.NoError(t, err)
+	assert.Equal(t, uid, owner)
+
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.Close()
+		}
+		accepted <- err
+	}()
+	conn, err := net.Dial("unix", sock)
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.NoError(t, <-accepted)
+}
+
+func TestPermittedPeer(t *testing.T) {
+	assert.True(t, permittedPeer(1000, 1000))
+	assert.True(t, permittedPeer(0, 1000))
+	assert.False(t, permittedPeer(1001, 1000))
+}
+
+// startFakeHelper serves a helper on a socket in a temporary directory. The helper records the uid of
+// the callers and the arguments that it would start the root daemon with instead of starting it.
+func startFakeHelper(t *testing.T) (ctx context.Context, uids *[]int, started *[][]string) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	t.Cleanup(cancel)
+	uids = new([]int)
+	started = new([][]string)
+
+	sock := filepath.Join(t.TempDir(), "helper.socket")
+	listener, err := client.ListenSocket(ctx, ProcessName, sock)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.RemoveSocket(listener) })
+
+	s := &service{startDaemon: func(ctx context.Context, args ...string) error {
+		uid, err := callerUID(ctx)
+		if err != nil {
+			return err
+		}
+		*uids = append(*uids, uid)
+		*started = append(*started, args)
+		return nil
+	}}
+	go func() { _ = serve(ctx, listener, s) }()
+
+	orig := socketName
+	socketName = sock
+	t.Cleanup(func() { socketName = orig })
+	return ctx, uids, started
+}
+
+func TestHelper(t *testing.T) {
+	ctx, uids, started := startFakeHelper(t)
+
+	conn, err := client.DialSocket(ctx, socketName)
+	require.NoError(t, err)
+	defer conn.Close()
+	vi, err := rpc.NewHelperClient(conn).Version(ctx, &empty.Empty{})
+	require.NoError(t, err)
+	assert.Equal(t, client.Version(), vi.Version)
+
+	ok, err := StartDaemon(ctx, validRequest(t))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []int{os.Getuid()}, *uids)
+	require.Len(t, *started, 1)
+	assert.Contains(t, (*started)[0], "--privileged-helper")
+
+	// A refused request is an error rather than a reason to fall back to sudo
+	rq := validRequest(t)
+	rq.Dns = "not-an-ip"
+	ok, err = StartDaemon(ctx, rq)
+	assert.True(t, ok)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Len(t, *started, 1)
+
+	// A root daemon that would forward the DNS queries of the host to a name server other than those of
+	// the host is never started.
+	rq = validRequest(t)
+	rq.Dns = "192.0.2.53"
+	ok, err = StartDaemon(ctx, rq)
+	assert.True(t, ok)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Len(t, *started, 1)
+}
+
+func TestStartDaemon_noHelper(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	orig := socketName
+	socketName = filepath.Join(t.TempDir(), "helper.socket")
+	defer func() { socketName = orig }()
+
+	ok, err := StartDaemon(ctx, validRequest(t))
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,93 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"google.golang.org/grpc/credentials"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// peerCredInfo is the AuthInfo of a connection to the helper. It identifies the user that runs the
+// process at the other end of the connection.
+type peerCredInfo struct {
+	credentials.CommonAuthInfo
+	uid int
+}
+
+func (peerCredInfo) AuthType() string {
+	return "peercred"
+}
+
+// peerCredentials are server-side transport credentials that authenticate the caller using the
+// credentials that the kernel records for the peer of a unix socket. They don't encrypt anything.
+type peerCredentials struct{}
+
+func (peerCredentials) ClientHandshake(_ context.Context, _ string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return conn, peerCredInfo{uid: -1}, nil
+}
+
+func (peerCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, nil, errors.New("only unix socket connections are accepted")
+	}
+	uid, err := peerUID(uc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, peerCredInfo{CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.NoSecurity}, uid: uid}, nil
+}
+
+func (peerCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "peercred"}
+}
+
+func (peerCredentials) Clone() credentials.TransportCredentials {
+	return peerCredentials{}
+}
+
+func (peerCredentials) OverrideServerName(string) error {
+	return nil
+}
+
+// userListener is a listener that only accepts connections from processes that are run by a given user
+// or by root.
+type userListener struct {
+	net.Listener
+	c   context.Context
+	uid int
+}
+
+// Accept returns the next connection from a permitted peer. Connections from other peers are closed.
+func (l *userListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		uc, ok := conn.(*net.UnixConn)
+		if !ok {
+			_ = conn.Close()
+			continue
+		}
+		uid, err := peerUID(uc)
+		if err == nil && permittedPeer(uid, l.uid) {
+			return conn, nil
+		}
+		if err != nil {
+			dlog.Errorf(l.c, "refusing connection: unable to determine the user id of the peer: %v", err)
+		} else {
+			dlog.Errorf(l.c, "refusing connection from uid %d", uid)
+		}
+		_ = conn.Close()
+	}
+}
+
+// permittedPeer returns true if a peer that runs as peerUID may connect to a socket that is restricted
+// to the given uid.
+func permittedPeer(peerUID, uid int) bool {
+	return peerUID == uid || peerUID == 0
+}
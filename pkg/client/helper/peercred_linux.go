@@ -0,0 +1,13 @@
+package helper
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+func fdPeerUID(fd int) (int, error) {
+	cred, err := unix.GetsockoptUcred(fd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+	if err != nil {
+		return 0, err
+	}
+	return int(cred.Uid), nil
+}
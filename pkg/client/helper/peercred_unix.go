@@ -0,0 +1,33 @@
+//go:build !windows
+// +build !windows
+
+package helper
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// peerUID returns the id of the user that runs the process at the other end of the given unix socket.
+func peerUID(uc *net.UnixConn) (int, error) {
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var uid int
+	var uidErr error
+	if err = raw.Control(func(fd uintptr) { uid, uidErr = fdPeerUID(int(fd)) }); err != nil {
+		return 0, err
+	}
+	return uid, uidErr
+}
+
+// fileOwner returns the id of the user that owns the given file.
+func fileOwner(path string) (int, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return int(fi.Sys().(*syscall.Stat_t).Uid), nil
+}
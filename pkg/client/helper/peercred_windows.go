@@ -0,0 +1,16 @@
+package helper
+
+import (
+	"errors"
+	"net"
+)
+
+var errNotSupported = errors.New("the privileged helper isn't supported on Windows")
+
+func peerUID(*net.UnixConn) (int, error) {
+	return 0, errNotSupported
+}
+
+func fileOwner(string) (int, error) {
+	return 0, errNotSupported
+}
@@ -0,0 +1,118 @@
+package helper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/subnet"
+)
+
+// The root daemon that the helper starts runs as root on behalf of a user that didn't use sudo. That user
+// must not be able to use it to route or resolve the traffic of the host elsewhere, so the daemon limits
+// the network changes that it makes to those that telepresence needs to reach a cluster. The functions
+// below check that a change is within that scope.
+
+// Subnets with a prefix that is shorter than these are too broad to be cluster subnets.
+const (
+	minPrefixIPv4 = 8
+	minPrefixIPv6 = 32
+)
+
+// reservedSubnets are subnets that are never routed to a cluster.
+var reservedSubnets = func() []*net.IPNet {
+	var sns []*net.IPNet
+	for _, s := range []string{
+		"0.0.0.0/8",      // this network
+		"127.0.0.0/8",    // loopback
+		"169.254.0.0/16", // link-local
+		"224.0.0.0/4",    // multicast
+		"::/128",         // unspecified
+		"::1/128",        // loopback
+		"fe80::/10",      // link-local
+		"ff00::/8",       // multicast
+	} {
+		_, sn, _ := net.ParseCIDR(s)
+		sns = append(sns, sn)
+	}
+	return sns
+}()
+
+// resolvConfPath is a variable so that tests can use other name servers than those of the host.
+var resolvConfPath = "/etc/resolv.conf"
+
+// ErrOutOfScope is the error of a network change that the root daemon started by the helper refuses.
+var ErrOutOfScope = errors.New("outside the scope of a root daemon started by the privileged helper")
+
+func outOfScope(format string, args ...interface{}) error {
+	return fmt.Errorf("%s is %w", fmt.Sprintf(format, args...), ErrOutOfScope)
+}
+
+// CheckSubnet returns an error when the given subnet is a default route, is broader than a cluster subnet
+// can be, or overlaps with the loopback, link-local, or multicast subnets.
+func CheckSubnet(sn *net.IPNet) error {
+	ones, bits := sn.Mask.Size()
+	if bits == 8*net.IPv4len && ones < minPrefixIPv4 || bits == 8*net.IPv6len && ones < minPrefixIPv6 {
+		return outOfScope("subnet %s", sn)
+	}
+	for _, rsn := range reservedSubnets {
+		if subnet.Overlaps(sn, rsn) {
+			return outOfScope("subnet %s", sn)
+		}
+	}
+	return nil
+}
+
+// CheckIP returns an error when the given IP is unspecified, or is a loopback, link-local, or multicast IP.
+func CheckIP(ip net.IP) error {
+	if ip.IsUnspecified() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.IsLinkLocalMulticast() {
+		return outOfScope("IP %s", ip)
+	}
+	return nil
+}
+
+// CheckDomain returns an error when the given domain, such as a cluster domain or a DNS suffix that is
+// resolved in the cluster, has less than two labels. Such a domain would make the root daemon resolve all
+// names of a top-level domain.
+func CheckDomain(domain string) error {
+	d := strings.Trim(domain, ".")
+	if !strings.ContainsRune(d, '.') || len(validation.IsDNS1123Subdomain(strings.ToLower(d))) > 0 {
+		return outOfScope("domain %q", domain)
+	}
+	return nil
+}
+
+// CheckLabel returns an error when the given DNS search path or namespace isn't a single DNS label.
+func CheckLabel(label string) error {
+	if len(validation.IsDNS1123Label(label)) > 0 {
+		return outOfScope("DNS search path %q", label)
+	}
+	return nil
+}
+
+// CheckNameserver returns an error when the given IP isn't one of the name servers of the host. The root
+// daemon forwards the DNS queries that the cluster can't resolve to that name server, and on Linux it
+// redirects the queries that are sent to it.
+func CheckNameserver(ip net.IP) error {
+	f, err := os.Open(resolvConfPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" && ip.Equal(net.ParseIP(fields[1])) {
+			return nil
+		}
+	}
+	if err = sc.Err(); err != nil {
+		return err
+	}
+	return outOfScope("name server %s", ip)
+}
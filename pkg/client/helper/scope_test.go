@@ -0,0 +1,93 @@
+package helper
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeResolvConf makes the helper find the given name servers instead of those of the host.
+func fakeResolvConf(t *testing.T, nameservers ...string) {
+	rc := filepath.Join(t.TempDir(), "resolv.conf")
+	var content string
+	for _, ns := range nameservers {
+		content += "nameserver " + ns + "\n"
+	}
+	require.NoError(t, os.WriteFile(rc, []byte(content), 0o644))
+	orig := resolvConfPath
+	resolvConfPath = rc
+	t.Cleanup(func() { resolvConfPath = orig })
+}
+
+func TestCheckSubnet(t *testing.T) {
+	tests := []struct {
+		subnet  string
+		inScope bool
+	}{
+		{"10.96.0.0/12", true},
+		{"10.244.0.0/16", true},
+		{"192.168.1.0/24", true},
+		{"10.0.0.0/8", true},
+		{"fd00:10:96::/112", true},
+		{"0.0.0.0/0", false},
+		{"0.0.0.0/1", false},
+		{"128.0.0.0/1", false},
+		{"10.0.0.0/7", false},
+		{"127.0.0.0/24", false},
+		{"169.254.169.254/32", false},
+		{"224.0.0.0/24", false},
+		{"::/0", false},
+		{"2000::/3", false},
+		{"::1/128", false},
+		{"fe80::/64", false},
+	}
+	for _, tt := range tests {
+		_, sn, err := net.ParseCIDR(tt.subnet)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		err = CheckSubnet(sn)
+		if tt.inScope {
+			assert.NoError(t, err, tt.subnet)
+		} else {
+			assert.True(t, errors.Is(err, ErrOutOfScope), tt.subnet)
+		}
+	}
+}
+
+func TestCheckIP(t *testing.T) {
+	assert.NoError(t, CheckIP(net.ParseIP("10.96.0.10")))
+	assert.NoError(t, CheckIP(net.ParseIP("fd00:10:96::a")))
+	for _, ip := range []string{"0.0.0.0", "127.0.0.53", "169.254.169.254", "224.0.0.251", "::", "::1", "fe80::1"} {
+		assert.True(t, errors.Is(CheckIP(net.ParseIP(ip)), ErrOutOfScope), ip)
+	}
+}
+
+func TestCheckDomain(t *testing.T) {
+	for _, d := range []string{"cluster.local", "cluster.local.", "svc.example.com"} {
+		assert.NoError(t, CheckDomain(d), d)
+	}
+	for _, d := range []string{"", ".", "com", "com.", "local", "bad_label.example.com"} {
+		assert.True(t, errors.Is(CheckDomain(d), ErrOutOfScope), d)
+	}
+}
+
+func TestCheckLabel(t *testing.T) {
+	assert.NoError(t, CheckLabel("default"))
+	assert.NoError(t, CheckLabel("kube-system"))
+	for _, l := range []string{"", "example.com", "com.", "Bad_Label"} {
+		assert.True(t, errors.Is(CheckLabel(l), ErrOutOfScope), l)
+	}
+}
+
+func TestCheckNameserver(t *testing.T) {
+	fakeResolvConf(t, "10.0.0.10", "fd00::53")
+	assert.NoError(t, CheckNameserver(net.ParseIP("10.0.0.10")))
+	assert.NoError(t, CheckNameserver(net.ParseIP("fd00::53")))
+	assert.True(t, errors.Is(CheckNameserver(net.ParseIP("192.0.2.53")), ErrOutOfScope))
+}
@@ -0,0 +1,55 @@
+//go:build !windows
+// +build !windows
+
+package helper
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// GroupName is the name of the group whose members may use the helper. The socket of the helper is
+// only accessible by root when no such group exists.
+const GroupName = "telepresence"
+
+// restrictHelperSocket makes the socket at the given path accessible to root and the members of the
+// GroupName group only.
+func restrictHelperSocket(c context.Context, path string) error {
+	g, err := user.LookupGroup(GroupName)
+	if err != nil {
+		dlog.Warnf(c, "the %s socket is only accessible by root because the group %q cannot be found: %v", ProcessName, GroupName, err)
+		return os.Chmod(path, 0o600)
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return err
+	}
+	if err = os.Chown(path, 0, gid); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0o660)
+}
+
+// RestrictToUser makes the unix socket that the given listener listens to accessible to the user with
+// the given uid only, and returns a listener that refuses connections from peers that are run by
+// another user than that user or root.
+func RestrictToUser(c context.Context, listener net.Listener, uid int) (net.Listener, error) {
+	ul, ok := listener.(*net.UnixListener)
+	if !ok {
+		return nil, errors.New("only unix socket listeners can be restricted to a user")
+	}
+	path := ul.Addr().String()
+	if err := os.Chown(path, uid, -1); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		return nil, err
+	}
+	return &userListener{Listener: listener, c: c, uid: uid}, nil
+}
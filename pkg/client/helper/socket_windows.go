@@ -0,0 +1,14 @@
+package helper
+
+import (
+	"context"
+	"net"
+)
+
+func restrictHelperSocket(context.Context, string) error {
+	return errNotSupported
+}
+
+func RestrictToUser(context.Context, net.Listener, int) (net.Listener, error) {
+	return nil, errNotSupported
+}
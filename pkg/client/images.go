@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+)
+
+// DefaultRegistry is the registry that the images are pulled from unless images.registry or
+// TELEPRESENCE_REGISTRY says otherwise.
+const DefaultRegistry = "docker.io/datawire"
+
+// AgentImageSource tells where the effective agent image comes from.
+type AgentImageSource string
+
+const (
+	AgentImageFromConfig         = AgentImageSource("config")
+	AgentImageFromEnv            = AgentImageSource("environment")
+	AgentImageFromTrafficManager = AgentImageSource("traffic-manager")
+	AgentImageDefault            = AgentImageSource("default")
+)
+
+// DefaultAgentImage returns the agent image that is used when neither the client nor the traffic-manager
+// configures one.
+func DefaultAgentImage() string {
+	return DefaultRegistry + "/" + defaultAgentImageName()
+}
+
+func defaultAgentImageName() string {
+	return "tel2:" + strings.TrimPrefix(Version(), "v")
+}
+
+// ResolveAgentImage returns the agent image that is used when agents are installed, and where it comes
+// from. The precedence is:
+//
+//   1. images.registry and images.agentImage in the config file
+//   2. the TELEPRESENCE_REGISTRY and TELEPRESENCE_AGENT_IMAGE environment variables
+//   3. the agent image configured in the traffic-manager, given as managerImage
+//   4. the built-in default
+//
+// The registry and the image name are overridden separately, so an override of just the registry
+// pulls the default image name from that registry.
+func ResolveAgentImage(ctx context.Context, managerImage string) (string, AgentImageSource) {
+	env := GetEnv(ctx)
+	img := GetConfig(ctx).Images
+	regSource := imageSettingSource(img.Registry, env.Registry, env.Registry != DefaultRegistry)
+	nameSource := imageSettingSource(img.AgentImage, env.AgentImage, env.AgentImage != "")
+
+	var source AgentImageSource
+	switch {
+	case regSource == AgentImageFromConfig || nameSource == AgentImageFromConfig:
+		source = AgentImageFromConfig
+	case regSource == AgentImageFromEnv || nameSource == AgentImageFromEnv:
+		source = AgentImageFromEnv
+	case managerImage != "":
+		return managerImage, AgentImageFromTrafficManager
+	default:
+		return DefaultAgentImage(), AgentImageDefault
+	}
+
+	// The config defaults to the environment, so it holds the effective values at this point
+	name := img.AgentImage
+	if name == "" {
+		name = defaultAgentImageName()
+	}
+	return img.Registry + "/" + name, source
+}
+
+// imageSettingSource returns the source of an image setting, given its value in the config, and its value in
+// the environment together with whether the environment actually sets it. A config value that is equal to
+// the value of the environment is attributed to the environment, because that's where the config got it from.
+func imageSettingSource(configValue, envValue string, envSet bool) AgentImageSource {
+	switch {
+	case configValue != "" && configValue != envValue:
+		return AgentImageFromConfig
+	case envSet:
+		return AgentImageFromEnv
+	default:
+		return AgentImageDefault
+	}
+}
+
+// ValidateRegistry returns an error unless the given string can be used as the registry part of an image
+// reference, e.g. "docker.io/datawire" or "localhost:5000".
+func ValidateRegistry(registry string) error {
+	if registry == "" || strings.HasSuffix(registry, "/") {
+		return fmt.Errorf("invalid registry %q", registry)
+	}
+	if _, err := reference.ParseNormalizedNamed(registry + "/tel2"); err != nil {
+		return fmt.Errorf("invalid registry %q: %w", registry, err)
+	}
+	return nil
+}
+
+// ValidateImage returns an error unless the given string can be used as the name, with an optional tag or
+// digest, of an image in a registry, e.g. "tel2:2.4.4".
+func ValidateImage(image string) error {
+	if _, err := reference.ParseNormalizedNamed(DefaultRegistry + "/" + image); err != nil {
+		return fmt.Errorf("invalid image %q: %w", image, err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func TestResolveAgentImage(t *testing.T) {
+	const managerImage = "registry.example.com/datawire/tel2:2.4.5"
+	tests := []struct {
+		name           string
+		envRegistry    string
+		envAgentImage  string
+		cfgRegistry    string
+		cfgAgentImage  string
+		managerImage   string
+		expectedImage  string
+		expectedSource AgentImageSource
+	}{
+		{
+			name:           "default",
+			expectedImage:  DefaultAgentImage(),
+			expectedSource: AgentImageDefault,
+		},
+		{
+			name:           "traffic-manager",
+			managerImage:   managerImage,
+			expectedImage:  managerImage,
+			expectedSource: AgentImageFromTrafficManager,
+		},
+		{
+			name:           "environment registry over traffic-manager",
+			envRegistry:    "localhost:5000",
+			managerImage:   managerImage,
+			expectedImage:  "localhost:5000/" + defaultAgentImageName(),
+			expectedSource: AgentImageFromEnv,
+		},
+		{
+			name:           "environment image over traffic-manager",
+			envAgentImage:  "tel2:custom",
+			managerImage:   managerImage,
+			expectedImage:  DefaultRegistry + "/tel2:custom",
+			expectedSource: AgentImageFromEnv,
+		},
+		{
+			name:           "config registry",
+			cfgRegistry:    "localhost:5000",
+			expectedImage:  "localhost:5000/" + defaultAgentImageName(),
+			expectedSource: AgentImageFromConfig,
+		},
+		{
+			name:           "config over environment",
+			envRegistry:    "env.example.com",
+			envAgentImage:  "tel2:env",
+			cfgRegistry:    "cfg.example.com",
+			cfgAgentImage:  "tel2:cfg",
+			expectedImage:  "cfg.example.com/tel2:cfg",
+			expectedSource: AgentImageFromConfig,
+		},
+		{
+			name:           "config image with environment registry",
+			envRegistry:    "env.example.com",
+			cfgAgentImage:  "tel2:cfg",
+			managerImage:   managerImage,
+			expectedImage:  "env.example.com/tel2:cfg",
+			expectedSource: AgentImageFromConfig,
+		},
+		{
+			name:           "config equal to environment",
+			envRegistry:    "env.example.com",
+			cfgRegistry:    "env.example.com",
+			expectedImage:  "env.example.com/" + defaultAgentImageName(),
+			expectedSource: AgentImageFromEnv,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			env := &Env{Registry: DefaultRegistry, AgentImage: tt.envAgentImage}
+			if tt.envRegistry != "" {
+				env.Registry = tt.envRegistry
+			}
+			ctx := WithEnv(dlog.NewTestContext(t, false), env)
+			cfg := GetDefaultConfig(ctx)
+			cfg.Images.merge(&Images{Registry: tt.cfgRegistry, AgentImage: tt.cfgAgentImage})
+			ctx = WithConfig(ctx, &cfg)
+
+			image, source := ResolveAgentImage(ctx, tt.managerImage)
+			assert.Equal(t, tt.expectedImage, image)
+			assert.Equal(t, tt.expectedSource, source)
+		})
+	}
+}
+
+func TestValidateRegistry(t *testing.T) {
+	for _, registry := range []string{"docker.io/datawire", "localhost:5000", "registry.example.com/team/images"} {
+		assert.NoError(t, ValidateRegistry(registry), registry)
+	}
+	for _, registry := range []string{"", "docker.io/datawire/", "Docker.io/Datawire", "host:port", "docker.io//datawire"} {
+		assert.Error(t, ValidateRegistry(registry), registry)
+	}
+}
+
+func TestValidateImage(t *testing.T) {
+	for _, image := range []string{"tel2", "tel2:2.4.5", "team/tel2:latest", "tel2@sha256:" + sha256Zero} {
+		assert.NoError(t, ValidateImage(image), image)
+	}
+	for _, image := range []string{"", "Tel2", "tel2:", "tel2:bad tag", ":2.4.5", "/tel2"} {
+		assert.Error(t, ValidateImage(image), image)
+	}
+}
+
+const sha256Zero = "0000000000000000000000000000000000000000000000000000000000000000"
@@ -0,0 +1,21 @@
+package client
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// DaemonCleanupCommand is the hidden sub-command that reverts the changes to the network
+// configuration of the host that a root daemon that didn't terminate gracefully left behind.
+const DaemonCleanupCommand = "daemon-cleanup-foreground"
+
+// DaemonNetworkStateFile returns the path of the file in which the root daemon records the changes
+// that it makes to the network configuration of the host, i.e. the name of its TUN device, the
+// subnets routed to it, and the DNS overrides. The file is removed when the daemon terminates
+// gracefully. It's kept in a directory that only root can write to, because a daemon that starts
+// reverts the changes that the file records.
+func DaemonNetworkStateFile(ctx context.Context) string {
+	return filepath.Join(filelocation.AppSystemRuntimeDir(ctx), "daemon-network.json")
+}
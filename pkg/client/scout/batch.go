@@ -0,0 +1,150 @@
+package scout
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dcontext"
+	"github.com/datawire/dlib/dlog"
+)
+
+var (
+	// flushInterval is the longest time that a report stays in the buffer while the endpoint is reachable.
+	flushInterval = 30 * time.Second
+
+	// batchSize is the number of buffered reports that triggers a flush before the flushInterval has passed.
+	batchSize = 20
+
+	// maxBuffered is the hard cap on the number of buffered reports. The oldest reports are dropped
+	// when it's exceeded, which only happens when the endpoint has been unreachable for a while.
+	maxBuffered = 500
+
+	// initialBackoff and maxBackoff bound the delay before a flush is retried after a transport error.
+	initialBackoff = 5 * time.Second
+	maxBackoff     = 5 * time.Minute
+
+	// shutdownTimeout limits the time spent sending the remaining reports when the Batcher is closed.
+	shutdownTimeout = 2 * time.Second
+)
+
+// A Batcher buffers reports and sends them in batches, so that reporting never blocks the functional
+// goroutines. A batch is sent when the flush interval has passed, when the buffer holds a full batch,
+// and when the Batcher is closed. Reports that can't be sent due to a transport error remain in the
+// buffer and are retried with an exponential backoff.
+type Batcher struct {
+	send func(ctx context.Context, metadata map[string]interface{}) error
+
+	mu      sync.Mutex
+	buffer  []map[string]interface{}
+	dropped int
+
+	full      chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewBatcher returns a Batcher that uses the given function to send each report.
+func NewBatcher(send func(ctx context.Context, metadata map[string]interface{}) error) *Batcher {
+	return &Batcher{
+		send:   send,
+		full:   make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+}
+
+// Add adds a report to the buffer. It never blocks.
+func (b *Batcher) Add(metadata map[string]interface{}) {
+	b.mu.Lock()
+	b.buffer = append(b.buffer, metadata)
+	if over := len(b.buffer) - maxBuffered; over > 0 {
+		b.buffer = b.buffer[over:]
+		b.dropped += over
+	}
+	full := len(b.buffer) >= batchSize
+	b.mu.Unlock()
+	if full {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Close makes Run send the remaining reports and return.
+func (b *Batcher) Close() {
+	b.closeOnce.Do(func() { close(b.closed) })
+}
+
+// Run sends the buffered reports until the Batcher is closed. A cancelled context doesn't end
+// Run, because reports may still be added during shutdown. They are sent when the Batcher is closed.
+func (b *Batcher) Run(ctx context.Context) error {
+	backoff := time.Duration(0)
+	timer := time.NewTimer(flushInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-b.closed:
+			ctx, cancel := context.WithTimeout(dcontext.WithoutCancel(ctx), shutdownTimeout)
+			defer cancel()
+			b.flush(ctx)
+			return nil
+		case <-timer.C:
+		case <-b.full:
+			if backoff > 0 {
+				// Wait for the timer, a full buffer doesn't cut a backoff short
+				continue
+			}
+			if !timer.Stop() {
+				<-timer.C
+			}
+		}
+		switch {
+		case ctx.Err() != nil:
+			// Keep the reports until the Batcher is closed
+			backoff = 0
+		case b.flush(ctx):
+			backoff = 0
+		case backoff == 0:
+			backoff = initialBackoff
+		default:
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+		if backoff > 0 {
+			timer.Reset(backoff)
+		} else {
+			timer.Reset(flushInterval)
+		}
+	}
+}
+
+// flush sends the buffered reports and returns false if sending failed, in which case the reports that
+// weren't sent are put back into the buffer.
+func (b *Batcher) flush(ctx context.Context) bool {
+	b.mu.Lock()
+	batch := b.buffer
+	b.buffer = nil
+	dropped := b.dropped
+	b.dropped = 0
+	b.mu.Unlock()
+
+	if dropped > 0 {
+		dlog.Debugf(ctx, "dropped %d telemetry reports because the buffer was full", dropped)
+	}
+	for i, metadata := range batch {
+		if err := b.send(ctx, metadata); err != nil {
+			dlog.Debugf(ctx, "sending telemetry reports failed: %v", err)
+			b.mu.Lock()
+			b.buffer = append(batch[i:], b.buffer...)
+			if over := len(b.buffer) - maxBuffered; over > 0 {
+				b.buffer = b.buffer[over:]
+				b.dropped += over
+			}
+			b.mu.Unlock()
+			return false
+		}
+	}
+	return true
+}
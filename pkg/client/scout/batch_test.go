@@ -0,0 +1,242 @@
+package scout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// recorder is a send function for a Batcher that records the actions of the reports it sends.
+type recorder struct {
+	sync.Mutex
+	sent  []string
+	fails int
+}
+
+func (r *recorder) send(_ context.Context, metadata map[string]interface{}) error {
+	r.Lock()
+	defer r.Unlock()
+	if r.fails > 0 {
+		r.fails--
+		return errors.New("connection refused")
+	}
+	r.sent = append(r.sent, metadata["action"].(string))
+	return nil
+}
+
+func (r *recorder) actions() []string {
+	r.Lock()
+	defer r.Unlock()
+	return append([]string(nil), r.sent...)
+}
+
+func setBatchVars(t *testing.T, interval time.Duration, size, max int, backoff time.Duration) {
+	origInterval, origSize, origMax, origBackoff := flushInterval, batchSize, maxBuffered, initialBackoff
+	flushInterval, batchSize, maxBuffered, initialBackoff = interval, size, max, backoff
+	t.Cleanup(func() {
+		flushInterval, batchSize, maxBuffered, initialBackoff = origInterval, origSize, origMax, origBackoff
+	})
+}
+
+func startBatcher(t *testing.T, r *recorder) (*Batcher, <-chan error) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	t.Cleanup(cancel)
+	b := NewBatcher(r.send)
+	done := make(chan error, 1)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		done <- b.Run(ctx)
+	}()
+	// Registered after setBatchVars, so this runs before the variables are restored
+	t.Cleanup(func() {
+		b.Close()
+		<-stopped
+	})
+	return b, done
+}
+
+func addReports(b *Batcher, from, to int) {
+	for i := from; i < to; i++ {
+		b.Add(map[string]interface{}{"action": fmt.Sprintf("a%d", i)})
+	}
+}
+
+func TestBatcher_batchSize(t *testing.T) {
+	setBatchVars(t, time.Hour, 3, 100, time.Hour)
+	r := &recorder{}
+	b, _ := startBatcher(t, r)
+
+	addReports(b, 0, 2)
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, r.actions(), "nothing is sent until the batch is full")
+
+	addReports(b, 2, 3)
+	assert.Eventually(t, func() bool { return len(r.actions()) == 3 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"a0", "a1", "a2"}, r.actions())
+}
+
+func TestBatcher_flushInterval(t *testing.T) {
+	setBatchVars(t, 50*time.Millisecond, 100, 100, time.Hour)
+	r := &recorder{}
+	b, _ := startBatcher(t, r)
+
+	addReports(b, 0, 1)
+	assert.Eventually(t, func() bool { return len(r.actions()) == 1 }, time.Second, 10*time.Millisecond)
+}
+
+func TestBatcher_flushOnClose(t *testing.T) {
+	setBatchVars(t, time.Hour, 100, 100, time.Hour)
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	r := &recorder{}
+	b := NewBatcher(r.send)
+	done := make(chan error, 1)
+	go func() { done <- b.Run(ctx) }()
+
+	addReports(b, 0, 2)
+	// Reports that are added during shutdown are also sent
+	cancel()
+	addReports(b, 2, 3)
+	b.Close()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return when the Batcher was closed")
+	}
+	assert.Equal(t, []string{"a0", "a1", "a2"}, r.actions())
+}
+
+func TestBatcher_retry(t *testing.T) {
+	setBatchVars(t, 20*time.Millisecond, 100, 100, 20*time.Millisecond)
+	r := &recorder{fails: 2}
+	b, _ := startBatcher(t, r)
+
+	addReports(b, 0, 3)
+	assert.Eventually(t, func() bool { return len(r.actions()) == 3 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"a0", "a1", "a2"}, r.actions(), "reports are retried in order")
+}
+
+func TestBatcher_maxBuffered(t *testing.T) {
+	setBatchVars(t, time.Hour, 100, 5, time.Hour)
+	r := &recorder{}
+	b, done := startBatcher(t, r)
+
+	addReports(b, 0, 8)
+	b.mu.Lock()
+	assert.Len(t, b.buffer, 5)
+	b.mu.Unlock()
+
+	b.Close()
+	<-done
+	assert.Equal(t, []string{"a3", "a4", "a5", "a6", "a7"}, r.actions(), "the oldest reports are dropped")
+}
+
+func TestReport_deadEndpoint(t *testing.T) {
+	origTimeout := asyncReportTimeout
+	asyncReportTimeout = 200 * time.Millisecond
+	defer func() { asyncReportTimeout = origTimeout }()
+
+	// An endpoint that never responds
+	blocked := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-blocked:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(blocked)
+
+	testcases := map[string]struct {
+		forwardErr error
+		async      bool
+	}{
+		"forwarded":    {forwardErr: nil},
+		"no connector": {forwardErr: errors.New("telepresence user daemon is not running"), async: true},
+	}
+	for name, tc := range testcases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			ctx := filelocation.WithUserHomeDir(dlog.NewTestContext(t, false), t.TempDir())
+			var forwarded []string
+			ctx = WithForwarder(ctx, func(_ context.Context, action string, metadata map[string]interface{}) error {
+				forwarded = append(forwarded, action)
+				assert.Equal(t, "go-test", metadata["mode"], "the base metadata is forwarded")
+				return tc.forwardErr
+			})
+			s := NewScout(ctx, "go-test")
+			s.Reporter.Endpoint = server.URL
+
+			start := time.Now()
+			s.Report(ctx, "some_action")
+			assert.Less(t, int64(time.Since(start)), int64(100*time.Millisecond), "reporting must not wait for the endpoint")
+			assert.Equal(t, []string{"some_action"}, forwarded)
+
+			WaitForAsyncReports()
+			elapsed := time.Since(start)
+			if tc.async {
+				assert.GreaterOrEqual(t, int64(elapsed), int64(asyncReportTimeout), "the report was sent directly")
+			}
+			assert.Less(t, int64(elapsed), int64(asyncReportTimeout+time.Second), "the direct report has a short deadline")
+		})
+	}
+}
+
+func TestWaitForAsyncReports(t *testing.T) {
+	origTimeout, origWait := asyncReportTimeout, asyncReportWait
+	asyncReportTimeout = 5 * time.Second
+	asyncReportWait = 100 * time.Millisecond
+	defer func() { asyncReportTimeout, asyncReportWait = origTimeout, origWait }()
+
+	start := time.Now()
+	WaitForAsyncReports()
+	assert.Less(t, int64(time.Since(start)), int64(asyncReportWait), "nothing is in flight")
+
+	blocked := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-blocked:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(blocked)
+
+	ctx := filelocation.WithUserHomeDir(dlog.NewTestContext(t, false), t.TempDir())
+	ctx = WithForwarder(ctx, func(context.Context, string, map[string]interface{}) error {
+		return errors.New("telepresence user daemon is not running")
+	})
+	s := NewScout(ctx, "go-test")
+	s.Reporter.Endpoint = server.URL
+	s.Report(ctx, "some_action")
+
+	start = time.Now()
+	WaitForAsyncReports()
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, int64(elapsed), int64(asyncReportWait), "the report is in flight")
+	assert.Less(t, int64(elapsed), int64(time.Second), "the wait is bounded")
+}
+
+func TestStartBatching_disabled(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	s := &Scout{disabled: true}
+	b := s.StartBatching()
+	done := make(chan error, 1)
+	go func() { done <- b.Run(ctx) }()
+	s.Report(ctx, "some_action")
+	b.Close()
+	require.NoError(t, <-done)
+	assert.Empty(t, b.buffer)
+}
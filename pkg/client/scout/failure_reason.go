@@ -0,0 +1,74 @@
+package scout
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"syscall"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Values of the failure_reason trait. The raw error text is never reported, only one of these categories.
+const (
+	FailureTimeout      = "timeout"
+	FailureRBAC         = "rbac"
+	FailureVersionSkew  = "version_skew"
+	FailurePortConflict = "port_conflict"
+	FailureOther        = "other"
+)
+
+// FailureReason returns the category of the given error that is suitable for use as a failure_reason trait,
+// or an empty string when the error is nil.
+func FailureReason(err error) string {
+	if err == nil {
+		return ""
+	}
+	var te interface{ Timeout() bool }
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &te) && te.Timeout() {
+		return FailureTimeout
+	}
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return FailurePortConflict
+	}
+	if k8serrors.IsForbidden(err) || k8serrors.IsUnauthorized(err) {
+		return FailureRBAC
+	}
+	var se interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &se) {
+		switch se.GRPCStatus().Code() {
+		case codes.DeadlineExceeded:
+			return FailureTimeout
+		case codes.PermissionDenied, codes.Unauthenticated:
+			return FailureRBAC
+		case codes.Unimplemented:
+			return FailureVersionSkew
+		}
+	}
+
+	// Errors that have passed through a gRPC call or an exec'ed command have lost their type, so
+	// fall back to looking at the message.
+	msg := strings.ToLower(err.Error())
+	switch {
+	case containsAny(msg, "deadline exceeded", "timed out", "timeout"):
+		return FailureTimeout
+	case containsAny(msg, "address already in use", "only one usage of each socket address"):
+		return FailurePortConflict
+	case containsAny(msg, "forbidden", "unauthorized"):
+		return FailureRBAC
+	case containsAny(msg, "unknown method", "unknown service", "or later is required", "incompatible version"):
+		return FailureVersionSkew
+	}
+	return FailureOther
+}
+
+func containsAny(s string, subs ...string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package scout_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/scout"
+)
+
+func TestFailureReason(t *testing.T) {
+	deployments := schema.GroupResource{Group: "apps", Resource: "deployments"}
+	testcases := map[string]struct {
+		err      error
+		expected string
+	}{
+		"nil": {
+			err:      nil,
+			expected: "",
+		},
+		"context deadline": {
+			err:      fmt.Errorf("connect: %w", context.DeadlineExceeded),
+			expected: scout.FailureTimeout,
+		},
+		"net timeout": {
+			err:      &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded},
+			expected: scout.FailureTimeout,
+		},
+		"grpc deadline": {
+			err:      status.Error(codes.DeadlineExceeded, "context deadline exceeded"),
+			expected: scout.FailureTimeout,
+		},
+		"timeout text": {
+			err:      errors.New("the traffic-manager in namespace ambassador timed out waiting for the agent"),
+			expected: scout.FailureTimeout,
+		},
+		"k8s forbidden": {
+			err:      k8serrors.NewForbidden(deployments, "echo", errors.New(`User "joe" cannot patch resource "deployments"`)),
+			expected: scout.FailureRBAC,
+		},
+		"k8s unauthorized": {
+			err:      k8serrors.NewUnauthorized("token expired"),
+			expected: scout.FailureRBAC,
+		},
+		"grpc permission denied": {
+			err:      status.Error(codes.PermissionDenied, "nope"),
+			expected: scout.FailureRBAC,
+		},
+		"forbidden text": {
+			err:      errors.New(`deployments.apps "echo" is forbidden: User "joe" cannot patch resource "deployments"`),
+			expected: scout.FailureRBAC,
+		},
+		"grpc unimplemented": {
+			err:      status.Error(codes.Unimplemented, "unknown method WatchIntercepts"),
+			expected: scout.FailureVersionSkew,
+		},
+		"agent version text": {
+			err:      errors.New(`the traffic-agent of echo.default has version "2.4.0", but version 2.4.4 or later is required`),
+			expected: scout.FailureVersionSkew,
+		},
+		"address in use": {
+			err:      &net.OpError{Op: "listen", Net: "tcp", Err: os.NewSyscallError("bind", syscall.EADDRINUSE)},
+			expected: scout.FailurePortConflict,
+		},
+		"address in use text": {
+			err:      errors.New("listen tcp 127.0.0.1:8080: bind: address already in use"),
+			expected: scout.FailurePortConflict,
+		},
+		"windows address in use text": {
+			err:      errors.New("listen tcp 127.0.0.1:8080: bind: Only one usage of each socket address (protocol/network address/port) is normally permitted."),
+			expected: scout.FailurePortConflict,
+		},
+		"grpc unavailable": {
+			err:      status.Error(codes.Unavailable, "connection refused"),
+			expected: scout.FailureOther,
+		},
+		"unknown": {
+			err:      errors.New("something went wrong"),
+			expected: scout.FailureOther,
+		},
+	}
+	for name, tc := range testcases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, scout.FailureReason(tc.err))
+		})
+	}
+}
@@ -7,10 +7,13 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 
 	"github.com/datawire/ambassador/v2/pkg/metriton"
+	"github.com/datawire/dlib/dcontext"
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_auth/authdata"
@@ -20,10 +23,54 @@ import (
 // Environment variable prefix for additional metadata to be reported
 const environmentMetadataPrefix = "TELEPRESENCE_REPORT_"
 
+// asyncReportTimeout is the deadline for reports that are sent directly by a process that can't forward them.
+var asyncReportTimeout = 2 * time.Second
+
+// asyncReportWait is the longest time that WaitForAsyncReports waits for the reports that are in flight.
+var asyncReportWait = 500 * time.Millisecond
+
+// asyncReports tracks the reports that are sent directly in the background.
+var asyncReports sync.WaitGroup
+
 // Scout is a Metriton reported
 type Scout struct {
 	index    int
 	Reporter *metriton.Reporter
+
+	// disabled is true when telemetry is disabled. The Reporter is nil then.
+	disabled bool
+
+	// batcher, when set, receives the reports instead of the Reporter.
+	batcher *Batcher
+
+	// forward, when set, is used to hand the reports over to another process.
+	forward Forwarder
+}
+
+// A Forwarder hands a report over to a long-running process that sends it on behalf of the caller.
+type Forwarder func(ctx context.Context, action string, metadata map[string]interface{}) error
+
+type forwarderKey struct{}
+
+// WithForwarder returns a context that makes NewScout create instances that forward their reports
+// using the given Forwarder. The reports are sent directly in the background when forwarding fails.
+func WithForwarder(ctx context.Context, f Forwarder) context.Context {
+	return context.WithValue(ctx, forwarderKey{}, f)
+}
+
+// WaitForAsyncReports waits for the reports that are sent directly in the background, but never longer
+// than asyncReportWait, so that a slow endpoint doesn't delay the exit of the CLI. It returns
+// immediately when no report is in flight.
+func WaitForAsyncReports() {
+	done := make(chan struct{})
+	go func() {
+		asyncReports.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(asyncReportWait):
+	}
 }
 
 // ScoutMeta is a key/value association used when reporting
@@ -33,7 +80,8 @@ type ScoutMeta struct {
 }
 
 // getInstallIDFromFilesystem returns the telepresence install ID, and also sets the reporter base
-// metadata to include any conflicting install IDs written by old versions of the product.
+// metadata to include any conflicting install IDs written by old versions of the product. A new
+// install ID is a random UUID, so it reveals nothing about the user or the host.
 func getInstallIDFromFilesystem(ctx context.Context, reporter *metriton.Reporter) (string, error) {
 	type filecacheEntry struct {
 		Body string
@@ -43,8 +91,15 @@ func getInstallIDFromFilesystem(ctx context.Context, reporter *metriton.Reporter
 	readFile := func(filename string) (string, error) {
 		if _, isCached := filecache[filename]; !isCached {
 			bs, err := os.ReadFile(filename)
+			body := strings.TrimSpace(string(bs))
+			if err == nil {
+				// A corrupted ID is treated as if it doesn't exist, so that it's replaced
+				if _, perr := uuid.Parse(body); perr != nil {
+					err = os.ErrNotExist
+				}
+			}
 			filecache[filename] = filecacheEntry{
-				Body: string(bs),
+				Body: body,
 				Err:  err,
 			}
 		}
@@ -84,14 +139,23 @@ func getInstallIDFromFilesystem(ctx context.Context, reporter *metriton.Reporter
 		}
 	}
 
-	// Current.  Telepresence-2 now uses the most appropriate directory for the platform, and
-	// uses "telepresence" instead of "telepresence2".  On GOOS=linux this is probably
-	// (depending on how $XDG_CONFIG_HOME is set) the same as the Telepresence 1 location.
-	telConfigDir, err := filelocation.AppUserConfigDir(ctx)
+	// Telepresence-2 prior to 2.4.5 used the most appropriate config directory for the platform, and
+	// "telepresence" instead of "telepresence2".  On GOOS=linux this is probably (depending on how
+	// $XDG_CONFIG_HOME is set) the same as the Telepresence 1 location.
+	if telConfigDir, err := filelocation.AppUserConfigDir(ctx); err == nil {
+		if id, err := readFile(filepath.Join(telConfigDir, "id")); err == nil {
+			allIDs["telepresence-2<2.4.5"] = id
+			retID = id
+		}
+	}
+
+	// Current.  The ID is a cache that is regenerated when it's lost or corrupted, so it lives in
+	// the cache directory.
+	telCacheDir, err := filelocation.AppUserCacheDir(ctx)
 	if err != nil {
 		return "", err
 	}
-	idFilename := filepath.Join(telConfigDir, "id")
+	idFilename := filepath.Join(telCacheDir, "id")
 	if id, err := readFile(idFilename); err != nil {
 		if !os.IsNotExist(err) {
 			return "", err
@@ -111,7 +175,11 @@ func getInstallIDFromFilesystem(ctx context.Context, reporter *metriton.Reporter
 		if err := os.MkdirAll(filepath.Dir(idFilename), 0755); err != nil {
 			return "", err
 		}
-		if err := os.WriteFile(idFilename, []byte(retID), 0644); err != nil {
+		if err := os.WriteFile(idFilename, []byte(retID), 0600); err != nil {
+			return "", err
+		}
+		// WriteFile doesn't change the permissions of a file that already exists
+		if err := os.Chmod(idFilename, 0600); err != nil {
 			return "", err
 		}
 	}
@@ -126,13 +194,20 @@ func getInstallIDFromFilesystem(ctx context.Context, reporter *metriton.Reporter
 }
 
 // NewScout creates a new initialized Scout instance that can be used to
-// send telepresence reports to Metriton
+// send telepresence reports to Metriton. The instance does nothing when
+// telemetry is disabled, so that no metadata is collected and no install ID
+// is read or written.
 func NewScout(ctx context.Context, mode string) (s *Scout) {
+	if enabled, _ := client.GetTelemetry(ctx); !enabled {
+		return &Scout{disabled: true}
+	}
 	baseMeta := getOsMetadata(ctx)
 	baseMeta["mode"] = mode
 	baseMeta["trace_id"] = uuid.New()
 	baseMeta["goos"] = runtime.GOOS
+	forward, _ := ctx.Value(forwarderKey{}).(Forwarder)
 	return &Scout{
+		forward: forward,
 		Reporter: &metriton.Reporter{
 			Application: "telepresence2",
 			Version:     client.Version(),
@@ -151,9 +226,57 @@ func NewScout(ctx context.Context, mode string) (s *Scout) {
 	}
 }
 
+// InstallID returns the telepresence install ID. The ID is also available when
+// telemetry is disabled, because the traffic-manager uses it to identify the
+// client.
+func (s *Scout) InstallID(ctx context.Context) string {
+	if s.disabled {
+		// Only the ID is of interest, so the metadata of the throwaway reporter is discarded
+		id, err := getInstallIDFromFilesystem(ctx, &metriton.Reporter{BaseMetadata: make(map[string]interface{})})
+		if err != nil {
+			return "00000000-0000-0000-0000-000000000000"
+		}
+		return id
+	}
+	return s.Reporter.InstallID()
+}
+
+// StartBatching makes Report add the reports to a Batcher instead of sending them right away. The
+// returned Batcher must be Run, and it sends the remaining reports when it's closed.
+func (s *Scout) StartBatching() *Batcher {
+	send := func(context.Context, map[string]interface{}) error { return nil }
+	if !s.disabled {
+		// The batched reports are snapshots that include the base metadata, so they are sent by a
+		// reporter of their own. That way, the base metadata is never read by the Batcher while
+		// SetMetadatum modifies it.
+		installID := s.Reporter.InstallID()
+		r := &metriton.Reporter{
+			Application:  s.Reporter.Application,
+			Version:      s.Reporter.Version,
+			GetInstallID: func(*metriton.Reporter) (string, error) { return installID, nil },
+			Client:       s.Reporter.Client,
+			Endpoint:     s.Reporter.Endpoint,
+		}
+		send = func(ctx context.Context, metadata map[string]interface{}) error {
+			_, err := r.Report(ctx, metadata)
+			return err
+		}
+	}
+	s.batcher = NewBatcher(send)
+	return s.batcher
+}
+
+// Disabled returns true if telemetry is disabled, in which case reports are discarded.
+func (s *Scout) Disabled() bool {
+	return s.disabled
+}
+
 // SetMetadatum associates the given key with the given value in the metadata
 // of this instance. It's an error if the key already exists.
 func (s *Scout) SetMetadatum(key string, value interface{}) {
+	if s.disabled {
+		return
+	}
 	oldValue, ok := s.Reporter.BaseMetadata[key]
 	if ok {
 		panic(fmt.Sprintf("trying to replace metadata[%q] = %q with %q", key, oldValue, value))
@@ -167,6 +290,13 @@ func (s *Scout) SetMetadatum(key string, value interface{}) {
 // determine the correct order of reported events for this installation
 // attempt (correlated by the trace_id set at the start).
 func (s *Scout) Report(ctx context.Context, action string, meta ...ScoutMeta) {
+	if s.disabled {
+		return
+	}
+	if enabled, _ := client.GetTelemetry(ctx); !enabled {
+		// Telemetry was disabled in the client config after this instance was created
+		return
+	}
 	s.index++
 	metadata := getDefaultEnvironmentMetadata()
 	metadata["action"] = action
@@ -180,12 +310,51 @@ func (s *Scout) Report(ctx context.Context, action string, meta ...ScoutMeta) {
 		metadata[metaItem.Key] = metaItem.Value
 	}
 
-	_, err = s.Reporter.Report(ctx, metadata)
-	if err != nil && ctx.Err() == nil {
-		dlog.Infof(ctx, "scout report %q failed: %v", action, err)
+	switch {
+	case s.batcher != nil:
+		s.batcher.Add(s.snapshot(metadata))
+	case s.forward != nil:
+		metadata = s.snapshot(metadata)
+		if err = s.forward(ctx, action, metadata); err != nil {
+			s.reportAsync(ctx, action, metadata)
+		}
+	default:
+		_, err = s.Reporter.Report(ctx, metadata)
+		if err != nil && ctx.Err() == nil {
+			dlog.Infof(ctx, "scout report %q failed: %v", action, err)
+		}
 	}
 }
 
+// snapshot returns the base metadata merged with the given metadata, so that a report that is sent
+// later isn't affected by metadata that is set after it was made.
+func (s *Scout) snapshot(metadata map[string]interface{}) map[string]interface{} {
+	// Getting the install ID adds the metadata about install IDs of earlier versions.
+	_ = s.Reporter.InstallID()
+	merged := make(map[string]interface{}, len(s.Reporter.BaseMetadata)+len(metadata))
+	for k, v := range s.Reporter.BaseMetadata {
+		merged[k] = v
+	}
+	for k, v := range metadata {
+		merged[k] = v
+	}
+	return merged
+}
+
+// reportAsync sends the report in the background with a short deadline, so that an unreachable
+// endpoint never delays the caller.
+func (s *Scout) reportAsync(ctx context.Context, action string, metadata map[string]interface{}) {
+	asyncReports.Add(1)
+	go func() {
+		defer asyncReports.Done()
+		ctx, cancel := context.WithTimeout(dcontext.WithoutCancel(ctx), asyncReportTimeout)
+		defer cancel()
+		if _, err := s.Reporter.Report(ctx, metadata); err != nil {
+			dlog.Debugf(ctx, "scout report %q failed: %v", action, err)
+		}
+	}()
+}
+
 // Returns a metadata map containing all the additional environment variables to be reported
 func getDefaultEnvironmentMetadata() map[string]interface{} {
 	metadata := map[string]interface{}{}
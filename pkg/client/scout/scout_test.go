@@ -2,6 +2,7 @@ package scout_test
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,15 +13,25 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/datawire/ambassador/v2/pkg/metriton"
 	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/scout"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
 )
 
 func TestInstallID(t *testing.T) {
+	const (
+		tp1ID     = "2b1c6d4e-8f0a-4c39-9d2e-5f7a1b3c4d01"
+		edgectlID = "2b1c6d4e-8f0a-4c39-9d2e-5f7a1b3c4d02"
+		tp21ID    = "2b1c6d4e-8f0a-4c39-9d2e-5f7a1b3c4d03"
+		tp2ID     = "2b1c6d4e-8f0a-4c39-9d2e-5f7a1b3c4d04"
+		tpID      = "2b1c6d4e-8f0a-4c39-9d2e-5f7a1b3c4d05"
+	)
 	type testcase struct {
 		InputGOOS    string
 		InputEnv     map[string]string
@@ -37,31 +48,33 @@ func TestInstallID(t *testing.T) {
 			"fresh-install": {
 				InputGOOS: "windows",
 				ExpectedExtra: map[string]interface{}{
-					"install_id_telepresence-1":     nil,
-					"install_id_edgectl":            nil,
-					"install_id_telepresence-2<2.1": nil,
-					"install_id_telepresence-2":     nil,
-					"new_install":                   true,
+					"install_id_telepresence-1":       nil,
+					"install_id_edgectl":              nil,
+					"install_id_telepresence-2<2.1":   nil,
+					"install_id_telepresence-2<2.4.5": nil,
+					"install_id_telepresence-2":       nil,
+					"new_install":                     true,
 				},
 				ExpectedHomeDir: map[string]string{
-					`AppData\Roaming\telepresence\id`: "${id}",
+					`AppData\Local\telepresence\id`: "${id}",
 				},
 			},
 			"upgrade-tp2.1": {
 				InputGOOS: "windows",
 				InputHomeDir: map[string]string{
-					`AppData\Roaming\telepresence\id`: "tp2.1-id",
+					`AppData\Roaming\telepresence\id`: tp21ID,
 				},
-				ExpectedID: "tp2.1-id",
+				ExpectedID: tp21ID,
 				ExpectedExtra: map[string]interface{}{
-					"install_id_telepresence-1":     nil,
-					"install_id_edgectl":            nil,
-					"install_id_telepresence-2<2.1": nil,
-					"install_id_telepresence-2":     nil,
-					"new_install":                   false,
+					"install_id_telepresence-1":       nil,
+					"install_id_edgectl":              nil,
+					"install_id_telepresence-2<2.1":   nil,
+					"install_id_telepresence-2<2.4.5": nil,
+					"install_id_telepresence-2":       nil,
+					"new_install":                     false,
 				},
 				ExpectedHomeDir: map[string]string{
-					`AppData\Roaming\telepresence\id`: "tp2.1-id",
+					`AppData\Local\telepresence\id`: tp21ID,
 				},
 			},
 		}
@@ -74,34 +87,36 @@ func TestInstallID(t *testing.T) {
 					"XDG_CONFIG_HOME": "$HOME/other-config",
 				},
 				InputHomeDir: map[string]string{
-					".config/telepresence/id":       "tp1-id",
-					"other-config/edgectl/id":       "edgectl-id",
-					"other-config/telepresence2/id": "tp2.1-id",
-					"other-config/telepresence/id":  "tp2-id",
+					".config/telepresence/id":       tp1ID,
+					"other-config/edgectl/id":       edgectlID,
+					"other-config/telepresence2/id": tp21ID,
+					"other-config/telepresence/id":  tp2ID,
 				},
-				ExpectedID: "tp2-id",
+				ExpectedID: tp2ID,
 				ExpectedExtra: map[string]interface{}{
-					"install_id_telepresence-1":     "tp1-id",
-					"install_id_edgectl":            "edgectl-id",
-					"install_id_telepresence-2<2.1": "tp2.1-id",
-					"install_id_telepresence-2":     nil,
-					"new_install":                   false,
+					"install_id_telepresence-1":       tp1ID,
+					"install_id_edgectl":              edgectlID,
+					"install_id_telepresence-2<2.1":   tp21ID,
+					"install_id_telepresence-2<2.4.5": nil,
+					"install_id_telepresence-2":       nil,
+					"new_install":                     false,
 				},
 			},
 			"linux": {
 				InputGOOS: "linux",
 				InputHomeDir: map[string]string{
-					".config/edgectl/id":       "edgectl-id",
-					".config/telepresence2/id": "tp2.1-id",
-					".config/telepresence/id":  "tp-id",
+					".config/edgectl/id":       edgectlID,
+					".config/telepresence2/id": tp21ID,
+					".config/telepresence/id":  tpID,
 				},
-				ExpectedID: "tp-id",
+				ExpectedID: tpID,
 				ExpectedExtra: map[string]interface{}{
-					"install_id_telepresence-1":     nil,
-					"install_id_edgectl":            "edgectl-id",
-					"install_id_telepresence-2<2.1": "tp2.1-id",
-					"install_id_telepresence-2":     nil,
-					"new_install":                   false,
+					"install_id_telepresence-1":       nil,
+					"install_id_edgectl":              edgectlID,
+					"install_id_telepresence-2<2.1":   tp21ID,
+					"install_id_telepresence-2<2.4.5": nil,
+					"install_id_telepresence-2":       nil,
+					"new_install":                     false,
 				},
 			},
 			"darwin-xdg": {
@@ -110,35 +125,37 @@ func TestInstallID(t *testing.T) {
 					"XDG_CONFIG_HOME": "$HOME/other-config",
 				},
 				InputHomeDir: map[string]string{
-					".config/telepresence/id":                     "tp1-id",
-					"other-config/edgectl/id":                     "edgectl-id",
-					"other-config/telepresence2/id":               "tp2.1-id",
-					"Library/Application Support/telepresence/id": "tp2-id",
+					".config/telepresence/id":                     tp1ID,
+					"other-config/edgectl/id":                     edgectlID,
+					"other-config/telepresence2/id":               tp21ID,
+					"Library/Application Support/telepresence/id": tp2ID,
 				},
-				ExpectedID: "tp2-id",
+				ExpectedID: tp2ID,
 				ExpectedExtra: map[string]interface{}{
-					"install_id_telepresence-1":     "tp1-id",
-					"install_id_edgectl":            "edgectl-id",
-					"install_id_telepresence-2<2.1": "tp2.1-id",
-					"install_id_telepresence-2":     nil,
-					"new_install":                   false,
+					"install_id_telepresence-1":       tp1ID,
+					"install_id_edgectl":              edgectlID,
+					"install_id_telepresence-2<2.1":   tp21ID,
+					"install_id_telepresence-2<2.4.5": nil,
+					"install_id_telepresence-2":       nil,
+					"new_install":                     false,
 				},
 			},
 			"darwin": {
 				InputGOOS: "darwin",
 				InputHomeDir: map[string]string{
-					".config/telepresence/id":                     "tp1-id",
-					".config/edgectl/id":                          "edgectl-id",
-					".config/telepresence2/id":                    "tp2.1-id",
-					"Library/Application Support/telepresence/id": "tp2-id",
+					".config/telepresence/id":                     tp1ID,
+					".config/edgectl/id":                          edgectlID,
+					".config/telepresence2/id":                    tp21ID,
+					"Library/Application Support/telepresence/id": tp2ID,
 				},
-				ExpectedID: "tp2-id",
+				ExpectedID: tp2ID,
 				ExpectedExtra: map[string]interface{}{
-					"install_id_telepresence-1":     "tp1-id",
-					"install_id_edgectl":            "edgectl-id",
-					"install_id_telepresence-2<2.1": "tp2.1-id",
-					"install_id_telepresence-2":     nil,
-					"new_install":                   false,
+					"install_id_telepresence-1":       tp1ID,
+					"install_id_edgectl":              edgectlID,
+					"install_id_telepresence-2<2.1":   tp21ID,
+					"install_id_telepresence-2<2.4.5": nil,
+					"install_id_telepresence-2":       nil,
+					"new_install":                     false,
 				},
 			},
 			"badfiles": {
@@ -147,19 +164,69 @@ func TestInstallID(t *testing.T) {
 					"XDG_CONFIG_HOME": "$HOME/other-config",
 				},
 				InputHomeDir: map[string]string{
-					".config/telepresence/id/x":       "tp1-id",
-					"other-config/edgectl/id/x":       "edgectl-id",
-					"other-config/telepresence2/id/x": "tp2.1-id",
-					"other-config/telepresence/id/x":  "tp2-id",
+					".config/telepresence/id/x":       tp1ID,
+					"other-config/edgectl/id/x":       edgectlID,
+					"other-config/telepresence2/id/x": tp21ID,
+					"other-config/telepresence/id/x":  tp2ID,
+					".cache/telepresence/id/x":        tpID,
 				},
 				ExpectedID:  "00000000-0000-0000-0000-000000000000",
-				ExpectedErr: fmt.Sprintf("read %s: %s", filepath.Join("$HOME", "other-config", "telepresence", "id"), errMsg),
+				ExpectedErr: fmt.Sprintf("read %s: %s", filepath.Join("$HOME", ".cache", "telepresence", "id"), errMsg),
 				ExpectedExtra: map[string]interface{}{
-					"install_id_telepresence-1":     nil,
-					"install_id_edgectl":            nil,
-					"install_id_telepresence-2<2.1": nil,
-					"install_id_telepresence-2":     nil,
-					"new_install":                   true,
+					"install_id_telepresence-1":       nil,
+					"install_id_edgectl":              nil,
+					"install_id_telepresence-2<2.1":   nil,
+					"install_id_telepresence-2<2.4.5": nil,
+					"install_id_telepresence-2":       nil,
+					"new_install":                     true,
+				},
+			},
+			"upgrade-tp2.4": {
+				InputGOOS: "linux",
+				InputHomeDir: map[string]string{
+					".config/telepresence/id": tp2ID,
+					".cache/telepresence/id":  tpID,
+				},
+				ExpectedID: tpID,
+				ExpectedExtra: map[string]interface{}{
+					"install_id_telepresence-1":       tp2ID,
+					"install_id_edgectl":              nil,
+					"install_id_telepresence-2<2.1":   nil,
+					"install_id_telepresence-2<2.4.5": tp2ID,
+					"install_id_telepresence-2":       nil,
+					"new_install":                     false,
+				},
+				ExpectedHomeDir: map[string]string{
+					".cache/telepresence/id": tpID,
+				},
+			},
+			"corrupted": {
+				InputGOOS: "linux",
+				InputHomeDir: map[string]string{
+					".cache/telepresence/id": "2b1c6d4e-8f0a-4c39\x00",
+				},
+				ExpectedExtra: map[string]interface{}{
+					"install_id_telepresence-2": nil,
+					"new_install":               true,
+				},
+				ExpectedHomeDir: map[string]string{
+					".cache/telepresence/id": "${id}",
+				},
+			},
+			"corrupted-with-legacy": {
+				InputGOOS: "linux",
+				InputHomeDir: map[string]string{
+					".config/edgectl/id":     edgectlID,
+					".cache/telepresence/id": "",
+				},
+				ExpectedID: edgectlID,
+				ExpectedExtra: map[string]interface{}{
+					"install_id_edgectl":        nil,
+					"install_id_telepresence-2": nil,
+					"new_install":               false,
+				},
+				ExpectedHomeDir: map[string]string{
+					".cache/telepresence/id": edgectlID,
 				},
 			},
 			"upgrade-tp1": {
@@ -168,35 +235,37 @@ func TestInstallID(t *testing.T) {
 					"XDG_CONFIG_HOME": "$HOME/other-config",
 				},
 				InputHomeDir: map[string]string{
-					".config/telepresence/id": "tp1-id",
+					".config/telepresence/id": tp1ID,
 				},
-				ExpectedID: "tp1-id",
+				ExpectedID: tp1ID,
 				ExpectedExtra: map[string]interface{}{
-					"install_id_telepresence-1":     nil,
-					"install_id_edgectl":            nil,
-					"install_id_telepresence-2<2.1": nil,
-					"install_id_telepresence-2":     nil,
-					"new_install":                   false,
+					"install_id_telepresence-1":       nil,
+					"install_id_edgectl":              nil,
+					"install_id_telepresence-2<2.1":   nil,
+					"install_id_telepresence-2<2.4.5": nil,
+					"install_id_telepresence-2":       nil,
+					"new_install":                     false,
 				},
 				ExpectedHomeDir: map[string]string{
-					"other-config/telepresence/id": "tp1-id",
+					".cache/telepresence/id": tp1ID,
 				},
 			},
 			"upgrade-edgectl": {
 				InputGOOS: "linux",
 				InputHomeDir: map[string]string{
-					".config/edgectl/id": "edge-id",
+					".config/edgectl/id": edgectlID,
 				},
-				ExpectedID: "edge-id",
+				ExpectedID: edgectlID,
 				ExpectedExtra: map[string]interface{}{
-					"install_id_telepresence-1":     nil,
-					"install_id_edgectl":            nil,
-					"install_id_telepresence-2<2.1": nil,
-					"install_id_telepresence-2":     nil,
-					"new_install":                   false,
+					"install_id_telepresence-1":       nil,
+					"install_id_edgectl":              nil,
+					"install_id_telepresence-2<2.1":   nil,
+					"install_id_telepresence-2<2.4.5": nil,
+					"install_id_telepresence-2":       nil,
+					"new_install":                     false,
 				},
 				ExpectedHomeDir: map[string]string{
-					".config/telepresence/id": "edge-id",
+					".cache/telepresence/id": edgectlID,
 				},
 			},
 			"upgrade-tp1-and-edgectl": {
@@ -205,49 +274,52 @@ func TestInstallID(t *testing.T) {
 					"XDG_CONFIG_HOME": "$HOME/other-config",
 				},
 				InputHomeDir: map[string]string{
-					".config/telepresence/id": "tp1-id",
-					"other-config/edgectl/id": "edge-id",
+					".config/telepresence/id": tp1ID,
+					"other-config/edgectl/id": edgectlID,
 				},
-				ExpectedID: "tp1-id",
+				ExpectedID: tp1ID,
 				ExpectedExtra: map[string]interface{}{
-					"install_id_telepresence-1":     nil,
-					"install_id_edgectl":            "edge-id",
-					"install_id_telepresence-2<2.1": nil,
-					"install_id_telepresence-2":     nil,
-					"new_install":                   false,
+					"install_id_telepresence-1":       nil,
+					"install_id_edgectl":              edgectlID,
+					"install_id_telepresence-2<2.1":   nil,
+					"install_id_telepresence-2<2.4.5": nil,
+					"install_id_telepresence-2":       nil,
+					"new_install":                     false,
 				},
 				ExpectedHomeDir: map[string]string{
-					"other-config/telepresence/id": "tp1-id",
+					".cache/telepresence/id": tp1ID,
 				},
 			},
 			"upgrade-tp2.1": {
 				InputGOOS: "darwin",
 				InputHomeDir: map[string]string{
-					".config/telepresence2/id": "tp2.1-id",
+					".config/telepresence2/id": tp21ID,
 				},
-				ExpectedID: "tp2.1-id",
+				ExpectedID: tp21ID,
 				ExpectedExtra: map[string]interface{}{
-					"install_id_telepresence-1":     nil,
-					"install_id_edgectl":            nil,
-					"install_id_telepresence-2<2.1": nil,
-					"install_id_telepresence-2":     nil,
-					"new_install":                   false,
+					"install_id_telepresence-1":       nil,
+					"install_id_edgectl":              nil,
+					"install_id_telepresence-2<2.1":   nil,
+					"install_id_telepresence-2<2.4.5": nil,
+					"install_id_telepresence-2":       nil,
+					"new_install":                     false,
 				},
 				ExpectedHomeDir: map[string]string{
-					"Library/Application Support/telepresence/id": "tp2.1-id",
+					"Library/Caches/telepresence/id": tp21ID,
 				},
 			},
 			"fresh-install": {
 				InputGOOS: "darwin",
 				ExpectedExtra: map[string]interface{}{
-					"install_id_telepresence-1":     nil,
-					"install_id_edgectl":            nil,
-					"install_id_telepresence-2<2.1": nil,
-					"install_id_telepresence-2":     nil,
-					"new_install":                   true,
+					"install_id_telepresence-1":       nil,
+					"install_id_edgectl":              nil,
+					"install_id_telepresence-2<2.1":   nil,
+					"install_id_telepresence-2<2.4.5": nil,
+					"install_id_telepresence-2":       nil,
+					"new_install":                     true,
 				},
 				ExpectedHomeDir: map[string]string{
-					"Library/Application Support/telepresence/id": "${id}",
+					"Library/Caches/telepresence/id": "${id}",
 				},
 			},
 		}
@@ -317,6 +389,42 @@ func TestInstallID(t *testing.T) {
 	}
 }
 
+func TestInstallID_persistence(t *testing.T) {
+	ctx := filelocation.WithUserHomeDir(dlog.NewTestContext(t, true), t.TempDir())
+	cacheDir, err := filelocation.AppUserCacheDir(ctx)
+	require.NoError(t, err)
+	idFile := filepath.Join(cacheDir, "id")
+
+	assertIDFile := func(expectedID string) {
+		t.Helper()
+		bs, err := os.ReadFile(idFile)
+		require.NoError(t, err)
+		assert.Equal(t, expectedID, string(bs))
+		if runtime.GOOS != "windows" {
+			st, err := os.Stat(idFile)
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0600), st.Mode().Perm())
+		}
+	}
+
+	id := scout.NewScout(ctx, "go-test").Reporter.InstallID()
+	_, err = uuid.Parse(id)
+	require.NoError(t, err)
+	assertIDFile(id)
+
+	// The ID survives a restart
+	assert.Equal(t, id, scout.NewScout(ctx, "go-test").Reporter.InstallID())
+
+	// A corrupted ID is regenerated, and the permissions of the file are tightened
+	require.NoError(t, os.WriteFile(idFile, []byte("not-an-id"), 0644))
+	require.NoError(t, os.Chmod(idFile, 0644))
+	newID := scout.NewScout(ctx, "go-test").Reporter.InstallID()
+	assert.NotEqual(t, id, newID)
+	_, err = uuid.Parse(newID)
+	require.NoError(t, err)
+	assertIDFile(newID)
+}
+
 func TestReport(t *testing.T) {
 	const (
 		mockVersion     = "v2.4.5-test"
@@ -466,3 +574,56 @@ func TestReport(t *testing.T) {
 		})
 	}
 }
+
+// countingTransport counts the requests that would have been sent.
+type countingTransport struct {
+	count int
+}
+
+func (c *countingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	c.count++
+	return nil, errors.New("no network calls expected")
+}
+
+func TestReport_disabled(t *testing.T) {
+	disabled := false
+	testcases := map[string]struct {
+		env *client.Env
+		cfg client.Config
+	}{
+		"environment": {env: &client.Env{ScoutDisable: "1"}},
+		"config":      {env: &client.Env{}, cfg: client.Config{Telemetry: client.Telemetry{Enabled: &disabled}}},
+	}
+	for tcName, tcData := range testcases {
+		tcData := tcData
+		t.Run(tcName, func(t *testing.T) {
+			configDir := t.TempDir()
+			ctx := dlog.NewTestContext(t, false)
+			ctx = filelocation.WithAppUserConfigDir(ctx, configDir)
+			ctx = client.WithEnv(ctx, tcData.env)
+			ctx = client.WithConfig(ctx, &tcData.cfg)
+
+			transport := &countingTransport{}
+			origTransport := http.DefaultTransport
+			http.DefaultTransport = transport
+			defer func() { http.DefaultTransport = origTransport }()
+
+			sc := scout.NewScout(ctx, "test-mode")
+			assert.True(t, sc.Disabled())
+			sc.SetMetadatum("extra_field", "extra value")
+			sc.Report(ctx, "test-action", scout.ScoutMeta{Key: "error", Value: "boom"})
+
+			assert.Zero(t, transport.count)
+			// Not even the install ID is written
+			entries, err := os.ReadDir(configDir)
+			require.NoError(t, err)
+			assert.Empty(t, entries)
+
+			// The traffic-manager still gets an install ID
+			id := sc.InstallID(ctx)
+			assert.NotEmpty(t, id)
+			assert.Equal(t, id, sc.InstallID(ctx))
+			assert.Zero(t, transport.count)
+		})
+	}
+}
@@ -2,40 +2,83 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"os"
 	"time"
 
 	"google.golang.org/grpc"
 )
 
-// DialSocket dials the given socket and returns the resulting connection
+// DialSocket dials the given socket and returns the resulting connection. The socket name can also be a
+// "tcp://" address as returned by ParseDaemonAddress.
 func DialSocket(ctx context.Context, socketName string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if isTCPAddress(socketName) {
+		return dialTCPSocket(ctx, socketName, opts...)
+	}
 	return dialSocket(ctx, socketName, opts...)
 }
 
-// ListenSocket returns a listener for the given socket and returns the resulting connection
+// ListenSocket returns a listener for the given socket and returns the resulting connection. The socket
+// name can also be a "tcp://" address as returned by ParseDaemonAddress, in which case the listener only
+// accepts connections from clients that know the token that it stores in the AppUserRuntimeDir.
 func ListenSocket(ctx context.Context, processName, socketName string) (net.Listener, error) {
+	if isTCPAddress(socketName) {
+		return listenTCPSocket(ctx, processName, socketName)
+	}
 	return listenSocket(ctx, processName, socketName)
 }
 
 // RemoveSocket removes any representation of the the socket from the filesystem.
 func RemoveSocket(listener net.Listener) error {
+	if tl, ok := listener.(*tokenListener); ok {
+		return os.Remove(tl.tokenFile)
+	}
 	return removeSocket(listener)
 }
 
+// RemoveStaleSocket removes the representation in the filesystem of a socket that was left behind by a
+// process that terminated ungracefully.
+func RemoveStaleSocket(ctx context.Context, name string) error {
+	if isTCPAddress(name) {
+		return removeTCPSocket(ctx, name)
+	}
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 // SocketExists returns true if a socket is found with the given name
-func SocketExists(name string) (bool, error) {
+func SocketExists(ctx context.Context, name string) (bool, error) {
+	if isTCPAddress(name) {
+		return tcpSocketExists(ctx, name)
+	}
 	return socketExists(name)
 }
 
+// ErrStaleSocket means that a socket exists but that no process is listening to it. That happens
+// when the process that created it terminated ungracefully.
+var ErrStaleSocket = errors.New("socket exists but no process is listening to it")
+
+// SocketOwnerPID returns the id of the process that listens to the socket with the given name, or
+// zero when the platform doesn't disclose it. The returned error wraps ErrStaleSocket when the socket
+// exists but no process listens to it.
+func SocketOwnerPID(name string) (int, error) {
+	if isTCPAddress(name) {
+		return tcpSocketOwnerPID(name)
+	}
+	return socketOwnerPID(name)
+}
+
 // WaitUntilSocketVanishes waits until the socket at the given path is removed
 // and returns when that happens. The wait will be max ttw (time to wait) long.
 // An error is returned if that time is exceeded before the socket is removed.
-func WaitUntilSocketVanishes(name, path string, ttw time.Duration) error {
+func WaitUntilSocketVanishes(ctx context.Context, name, path string, ttw time.Duration) error {
 	giveUp := time.Now().Add(ttw)
 	for giveUp.After(time.Now()) {
-		if exists, err := SocketExists(path); err != nil || !exists {
+		if exists, err := SocketExists(ctx, path); err != nil || !exists {
 			return err
 		}
 		time.Sleep(250 * time.Millisecond)
@@ -46,10 +89,10 @@ func WaitUntilSocketVanishes(name, path string, ttw time.Duration) error {
 // WaitUntilSocketAppears waits until the socket at the given path comes into
 // existence and returns when that happens. The wait will be max ttw (time to wait) long.
 // An error is returned if that time is exceeded before the socket is removed.
-func WaitUntilSocketAppears(name, path string, ttw time.Duration) error {
+func WaitUntilSocketAppears(ctx context.Context, name, path string, ttw time.Duration) error {
 	giveUp := time.Now().Add(ttw)
 	for giveUp.After(time.Now()) {
-		if exists, err := SocketExists(path); err != nil || exists {
+		if exists, err := SocketExists(ctx, path); err != nil || exists {
 			return err
 		}
 		time.Sleep(250 * time.Millisecond)
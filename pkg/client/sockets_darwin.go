@@ -0,0 +1,10 @@
+package client
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// peerPID returns the id of the process at the other end of the given unix socket.
+func peerPID(fd int) (int, error) {
+	return unix.GetsockoptInt(fd, unix.SOL_LOCAL, unix.LOCAL_PEERPID)
+}
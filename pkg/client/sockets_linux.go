@@ -0,0 +1,14 @@
+package client
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// peerPID returns the id of the process at the other end of the given unix socket.
+func peerPID(fd int) (int, error) {
+	cred, err := unix.GetsockoptUcred(fd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+	if err != nil {
+		return 0, err
+	}
+	return int(cred.Pid), nil
+}
@@ -0,0 +1,275 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
+)
+
+// A daemon that listens to a TCP address can be reached by all users on the host, so it only accepts
+// connections that start with the token of the session. The token is stored in a file in the runtime
+// directory of the user that started the daemon. That file also tells that the daemon is running.
+
+const tokenLen = 64
+
+// tokenReadTimeout is how long a listener waits for the token of a new connection.
+const tokenReadTimeout = time.Second
+
+// tokenFile returns the path of the file that holds the token of a daemon listening to the given address.
+func tokenFile(ctx context.Context, address string) (string, error) {
+	dir, err := filelocation.AppUserRuntimeDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	_, port, err := net.SplitHostPort(strings.TrimPrefix(address, tcpScheme))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tcp-"+port+".token"), nil
+}
+
+func readToken(ctx context.Context, address string) ([]byte, error) {
+	tf, err := tokenFile(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	token, err := os.ReadFile(tf)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(token), nil
+}
+
+func dialTCPSocket(ctx context.Context, address string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	token, err := readToken(ctx, address)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = fmt.Errorf("%w; this usually means that the process is not running", err)
+		}
+		return nil, err
+	}
+	conn, err := dialTokenSocket(ctx, strings.TrimPrefix(address, tcpScheme), token, opts...)
+	if err == nil {
+		return conn, nil
+	}
+
+	switch {
+	case isConnRefused(err):
+		// The token file exists but nobody listens to the address. This means that the process
+		// terminated ungracefully, so the token file is removed to make it known that it isn't running.
+		if rmErr := removeTCPSocket(ctx, address); rmErr != nil {
+			err = fmt.Errorf("%w (token file rm failed with %v)", err, rmErr)
+		} else {
+			err = fmt.Errorf("%w; this usually means that the process is not running: %v", os.ErrNotExist, err)
+		}
+	case errors.Is(err, context.DeadlineExceeded):
+		err = fmt.Errorf("dial %s: %w; this usually means that the process has locked up", address, err)
+	}
+	return nil, err
+}
+
+// dialTokenSocket dials the given host:port address, and sends the given token first on each connection.
+func dialTokenSocket(ctx context.Context, addr string, token []byte, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	return grpc.DialContext(ctx, addr, append([]grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithNoProxy(),
+		grpc.WithBlock(),
+		grpc.FailOnNonTempDialError(true),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialWithToken(ctx, addr, token)
+		}),
+	}, append(append(tracing.DialOptions(), GrpcDialOptions(ctx)...), opts...)...)...)
+}
+
+func dialWithToken(ctx context.Context, addr string, token []byte) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write(token); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// tokenListener is a TCP listener that only accepts connections that start with its token. The token of
+// each connection is verified in a goroutine of its own, so that clients that are slow to send it, or that
+// never do, don't delay the connections of other clients.
+type tokenListener struct {
+	net.Listener
+	token     []byte
+	tokenFile string
+
+	// readTimeout is how long the listener waits for the token of a new connection
+	readTimeout time.Duration
+
+	startOnce sync.Once
+	verified  chan net.Conn
+	done      chan struct{}
+	err       error // the error of the Accept of the Listener, readable once done is closed
+}
+
+func newTokenListener(listener net.Listener, token []byte, tokenFile string) *tokenListener {
+	return &tokenListener{
+		Listener:    listener,
+		token:       token,
+		tokenFile:   tokenFile,
+		readTimeout: tokenReadTimeout,
+		verified:    make(chan net.Conn),
+		done:        make(chan struct{}),
+	}
+}
+
+func listenTCPSocket(ctx context.Context, processName, address string) (net.Listener, error) {
+	tf, err := tokenFile(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	hostPort := strings.TrimPrefix(address, tcpScheme)
+	if host, _, err := net.SplitHostPort(hostPort); err != nil || !isLoopbackHost(host) {
+		// Other users on the host could reach a daemon that listens on any other interface
+		return nil, fmt.Errorf("the %s can only listen to a loopback address, not %q", processName, address)
+	}
+	listener, err := net.Listen("tcp", hostPort)
+	if err != nil {
+		if isAddrInUse(err) {
+			err = fmt.Errorf("address %q is in use so the %s is either already running or the port is used by another process: %w",
+				address, processName, err)
+		}
+		return nil, err
+	}
+	token, err := newToken()
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(tf), 0700); err == nil {
+		err = os.WriteFile(tf, token, 0600)
+	}
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("unable to store the token of the %s: %w", processName, err)
+	}
+	return newTokenListener(listener, token, tf), nil
+}
+
+// newToken returns a new random token.
+func newToken() ([]byte, error) {
+	tb := make([]byte, tokenLen/2)
+	if _, err := rand.Read(tb); err != nil {
+		return nil, err
+	}
+	return []byte(hex.EncodeToString(tb)), nil
+}
+
+// Accept returns the next connection that starts with the token of the listener. Other connections are closed.
+func (l *tokenListener) Accept() (net.Conn, error) {
+	l.startOnce.Do(func() { go l.acceptLoop() })
+	select {
+	case conn := <-l.verified:
+		return conn, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+// acceptLoop accepts the connections of the Listener and verifies their tokens until the Listener fails.
+func (l *tokenListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			l.err = err
+			close(l.done)
+			return
+		}
+		go func() {
+			if !verifyToken(conn, l.token, l.readTimeout) {
+				conn.Close()
+				return
+			}
+			select {
+			case l.verified <- conn:
+			case <-l.done:
+				conn.Close()
+			}
+		}()
+	}
+}
+
+func verifyToken(conn net.Conn, token []byte, timeout time.Duration) bool {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return false
+	}
+	buf := make([]byte, len(token))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return false
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(buf, token) == 1
+}
+
+func removeTCPSocket(ctx context.Context, address string) error {
+	tf, err := tokenFile(ctx, address)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(tf); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// tcpSocketExists returns true if the token file of a daemon listening to the given address exists.
+func tcpSocketExists(ctx context.Context, address string) (bool, error) {
+	tf, err := tokenFile(ctx, address)
+	if err != nil {
+		return false, err
+	}
+	if _, err = os.Stat(tf); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// tcpSocketOwnerPID returns zero because the process that listens to a TCP address isn't disclosed.
+func tcpSocketOwnerPID(address string) (int, error) {
+	conn, err := net.DialTimeout("tcp", strings.TrimPrefix(address, tcpScheme), time.Second)
+	if err != nil {
+		if isConnRefused(err) {
+			err = fmt.Errorf("%s: %w", address, ErrStaleSocket)
+		}
+		return 0, err
+	}
+	conn.Close()
+	return 0, nil
+}
@@ -0,0 +1,191 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/common"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+type versionConnector struct {
+	connector.UnimplementedConnectorServer
+}
+
+func (versionConnector) Version(context.Context, *emptypb.Empty) (*common.VersionInfo, error) {
+	return &common.VersionInfo{Version: "v2.4.5"}, nil
+}
+
+// freeTCPAddress returns a "tcp://" address on the loopback interface that nobody listens to.
+func freeTCPAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return tcpScheme + l.Addr().String()
+}
+
+func tcpTestContext(t *testing.T) context.Context {
+	ctx := dlog.NewTestContext(t, false)
+	return filelocation.WithAppUserRuntimeDir(ctx, t.TempDir())
+}
+
+func serveVersionConnector(ctx context.Context, t *testing.T, address string) net.Listener {
+	l, err := ListenSocket(ctx, "connector", address)
+	require.NoError(t, err)
+	serveVersionConnectorOn(t, l)
+	return l
+}
+
+func serveVersionConnectorOn(t *testing.T, l net.Listener) {
+	svc := grpc.NewServer()
+	connector.RegisterConnectorServer(svc, versionConnector{})
+	go func() { _ = svc.Serve(l) }()
+	t.Cleanup(svc.Stop)
+}
+
+func TestTCPSocket(t *testing.T) {
+	ctx := tcpTestContext(t)
+	address := freeTCPAddress(t)
+
+	exists, err := SocketExists(ctx, address)
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	l := serveVersionConnector(ctx, t, address)
+	exists, err = SocketExists(ctx, address)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// The token file is private to the user
+	tf, err := tokenFile(ctx, address)
+	require.NoError(t, err)
+	fi, err := os.Stat(tf)
+	require.NoError(t, err)
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	}
+
+	conn, err := DialSocket(ctx, address)
+	require.NoError(t, err)
+	vi, err := connector.NewConnectorClient(conn).Version(ctx, &emptypb.Empty{})
+	conn.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "v2.4.5", vi.Version)
+
+	// A second daemon can't use the same address
+	_, err = ListenSocket(ctx, "connector", address)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is in use so the connector is either already running")
+
+	require.NoError(t, RemoveSocket(l))
+	exists, err = SocketExists(ctx, address)
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestTCPSocket_verifyToken(t *testing.T) {
+	ctx := tcpTestContext(t)
+	address := freeTCPAddress(t)
+	serveVersionConnector(ctx, t, address)
+	token, err := readToken(ctx, address)
+	require.NoError(t, err)
+	require.Len(t, token, tokenLen)
+
+	rejected := func(prefix []byte) bool {
+		conn, err := net.Dial("tcp", address[len(tcpScheme):])
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write(prefix)
+		require.NoError(t, err)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		_, err = conn.Read(make([]byte, 1))
+		return errors.Is(err, io.EOF)
+	}
+
+	// A client that doesn't know the token is disconnected
+	assert.True(t, rejected(bytes.Repeat([]byte("0"), tokenLen)))
+
+	// A client that doesn't send the token is disconnected after the tokenReadTimeout
+	assert.True(t, rejected(token[:tokenLen/2]))
+
+	// A client that sends the token gets the HTTP/2 settings of the gRPC server
+	assert.False(t, rejected(token))
+
+	// A gRPC client with the wrong token can't call the daemon
+	tc, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	_, err = grpc.DialContext(tc, address[len(tcpScheme):],
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialWithToken(ctx, addr, bytes.Repeat([]byte("0"), tokenLen))
+		}))
+	assert.Error(t, err)
+}
+
+func TestTCPSocket_slowClient(t *testing.T) {
+	ctx := tcpTestContext(t)
+	address := freeTCPAddress(t)
+	l, err := ListenSocket(ctx, "connector", address)
+	require.NoError(t, err)
+	l.(*tokenListener).readTimeout = time.Minute
+	serveVersionConnectorOn(t, l)
+
+	// A client that connects but never sends its token doesn't block the others
+	slow, err := net.Dial("tcp", address[len(tcpScheme):])
+	require.NoError(t, err)
+	defer slow.Close()
+
+	tc, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	conn, err := DialSocket(tc, address)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = connector.NewConnectorClient(conn).Version(tc, &emptypb.Empty{})
+	require.NoError(t, err)
+}
+
+func TestTCPSocket_loopbackOnly(t *testing.T) {
+	ctx := tcpTestContext(t)
+	_, err := ListenSocket(ctx, "connector", tcpScheme+"0.0.0.0:0")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can only listen to a loopback address")
+}
+
+func TestTCPSocket_stale(t *testing.T) {
+	ctx := tcpTestContext(t)
+	address := freeTCPAddress(t)
+
+	// A daemon that died without removing its token file
+	l, err := ListenSocket(ctx, "connector", address)
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+	exists, err := SocketExists(ctx, address)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	_, err = SocketOwnerPID(address)
+	assert.ErrorIs(t, err, ErrStaleSocket)
+
+	// Dialing removes the token file and reports that the daemon isn't running
+	_, err = DialSocket(ctx, address)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	exists, err = SocketExists(ctx, address)
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
@@ -15,6 +15,7 @@ import (
 	"google.golang.org/grpc"
 
 	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 )
 
 const (
@@ -34,7 +35,7 @@ func dialSocket(ctx context.Context, socketName string, opts ...grpc.DialOption)
 			grpc.WithNoProxy(),
 			grpc.WithBlock(),
 			grpc.FailOnNonTempDialError(true),
-		}, opts...)...)
+		}, append(append(tracing.DialOptions(), GrpcDialOptions(ctx)...), opts...)...)...)
 		if err == nil {
 			return conn, nil
 		}
@@ -94,6 +95,14 @@ func listenSocket(_ context.Context, processName, socketName string) (net.Listen
 	return listener, nil
 }
 
+func isConnRefused(err error) bool {
+	return errors.Is(err, unix.ECONNREFUSED)
+}
+
+func isAddrInUse(err error) bool {
+	return errors.Is(err, unix.EADDRINUSE)
+}
+
 func removeSocket(listener net.Listener) error {
 	return os.Remove(listener.Addr().String())
 }
@@ -112,3 +121,24 @@ func socketExists(path string) (bool, error) {
 	}
 	return true, nil
 }
+
+func socketOwnerPID(name string) (int, error) {
+	conn, err := net.DialTimeout("unix", name, time.Second)
+	if err != nil {
+		if errors.Is(err, unix.ECONNREFUSED) {
+			err = fmt.Errorf("%s: %w", name, ErrStaleSocket)
+		}
+		return 0, err
+	}
+	defer conn.Close()
+	raw, err := conn.(*net.UnixConn).SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var pid int
+	var pidErr error
+	if err = raw.Control(func(fd uintptr) { pid, pidErr = peerPID(int(fd)) }); err != nil {
+		return 0, err
+	}
+	return pid, pidErr
+}
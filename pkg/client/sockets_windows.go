@@ -2,6 +2,7 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 
@@ -10,6 +11,7 @@ import (
 	"google.golang.org/grpc"
 
 	"github.com/telepresenceio/telepresence/v2/pkg/proc"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 )
 
 // The Windows IPC between the CLI and the user and root daemons is based on named pipes rather than
@@ -35,7 +37,7 @@ func dialSocket(c context.Context, socketName string, opts ...grpc.DialOption) (
 			conn, err := winio.DialPipeContext(c, socketName)
 			return conn, err
 		}),
-	}, opts...)...)
+	}, append(append(tracing.DialOptions(), GrpcDialOptions(c)...), opts...)...)...)
 	return conn, err
 }
 
@@ -53,6 +55,14 @@ func listenSocket(_ context.Context, processName, socketName string) (net.Listen
 	return winio.ListenPipe(socketName, config)
 }
 
+func isConnRefused(err error) bool {
+	return errors.Is(err, windows.WSAECONNREFUSED)
+}
+
+func isAddrInUse(err error) bool {
+	return errors.Is(err, windows.WSAEADDRINUSE)
+}
+
 // removeSocket does nothing because a named pipe has no representation in the file system that
 // needs to be removed
 func removeSocket(listener net.Listener) error {
@@ -90,3 +100,9 @@ func socketExists(name string) (bool, error) {
 	}
 	return false, err
 }
+
+// socketOwnerPID returns zero because the process that serves a named pipe isn't disclosed. A named
+// pipe can't be stale since it vanishes with the process that created it.
+func socketOwnerPID(_ string) (int, error) {
+	return 0, nil
+}
@@ -0,0 +1,159 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// A user daemon on Windows can serve the CLI in a WSL2 distribution. The loopback interface of Windows
+// can't be reached from WSL2, so the daemon listens to the interface of Windows on the virtual switch of
+// WSL, which other hosts on that switch can reach too. The listener is therefore guarded by a token, like
+// a TCP listener on the loopback interface is. The address and the token are stored in the endpoint
+// file in the AppUserCacheDir of the Windows user, which the CLI in WSL reads through the mount of the
+// Windows drive.
+
+// WSLEndpointFileName is the name of the file that describes the WSL endpoint of the user daemon.
+const WSLEndpointFileName = "wsl-connector.json"
+
+// wslInterfacePrefix is the prefix of the name of the interface of Windows on the virtual switch of WSL,
+// e.g. "vEthernet (WSL)" or "vEthernet (WSL (Hyper-V firewall))".
+const wslInterfacePrefix = "vEthernet (WSL"
+
+// WSLEndpoint is the endpoint that a user daemon on Windows serves the CLI in WSL on.
+type WSLEndpoint struct {
+	// Address is the host:port address that the user daemon listens to
+	Address string `json:"address"`
+
+	// Interface is the name of the interface of the address
+	Interface string `json:"interface"`
+
+	// Token is the token that each connection must start with
+	Token string `json:"token"`
+
+	// PID is the process id of the user daemon on Windows
+	PID int `json:"pid"`
+}
+
+// WSLEndpointFile returns the path of the endpoint file of the user daemon.
+func WSLEndpointFile(ctx context.Context) (string, error) {
+	dir, err := filelocation.AppUserCacheDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, WSLEndpointFileName), nil
+}
+
+// ReadWSLEndpoint reads the given endpoint file. The returned error wraps os.ErrNotExist when the file
+// doesn't exist, which means that no user daemon serves the CLI in WSL.
+func ReadWSLEndpoint(file string) (*WSLEndpoint, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var ep WSLEndpoint
+	if err = json.Unmarshal(data, &ep); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", file, err)
+	}
+	if ep.Address == "" || len(ep.Token) != tokenLen {
+		return nil, fmt.Errorf("%s is not a valid endpoint file", file)
+	}
+	return &ep, nil
+}
+
+// ListenWSLSocket returns a listener on the given interface, or on the interface of Windows on the
+// virtual switch of WSL when the name is empty. The listener only accepts connections that start with the
+// token that it stores, together with its address, in the given endpoint file. RemoveSocket removes the
+// endpoint file.
+func ListenWSLSocket(ctx context.Context, processName, ifaceName, endpointFile string) (net.Listener, error) {
+	ip, ifaceName, err := wslInterfaceIP(ifaceName)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(ip.String(), "0"))
+	if err != nil {
+		return nil, err
+	}
+	token, err := newToken()
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	data, err := json.Marshal(&WSLEndpoint{
+		Address:   listener.Addr().String(),
+		Interface: ifaceName,
+		Token:     string(token),
+		PID:       os.Getpid(),
+	})
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(endpointFile), 0700); err == nil {
+			err = os.WriteFile(endpointFile, data, 0600)
+		}
+	}
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("unable to store the WSL endpoint of the %s: %w", processName, err)
+	}
+	return newTokenListener(listener, token, endpointFile), nil
+}
+
+// DialWSLSocket dials the given endpoint of a user daemon on Windows. The returned error wraps
+// os.ErrNotExist when nothing listens to the endpoint.
+func DialWSLSocket(ctx context.Context, ep *WSLEndpoint, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	conn, err := dialTokenSocket(ctx, ep.Address, []byte(ep.Token), opts...)
+	if err == nil {
+		return conn, nil
+	}
+	switch {
+	case isConnRefused(err):
+		err = fmt.Errorf("%w; this usually means that the process is not running: %v", os.ErrNotExist, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		err = fmt.Errorf("dial %s: %w; this usually means that a firewall on Windows blocks the connections from WSL", ep.Address, err)
+	}
+	return nil, err
+}
+
+// wslInterfaceIP returns the IPv4 address, and the name, of the given interface, or of the interface of
+// Windows on the virtual switch of WSL when the name is empty. A given interface must be the interface of
+// WSL or a loopback interface.
+func wslInterfaceIP(name string) (net.IP, string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, "", err
+	}
+	for i := range ifaces {
+		iface := &ifaces[i]
+		if name != "" && iface.Name != name || name == "" && !strings.HasPrefix(iface.Name, wslInterfacePrefix) {
+			continue
+		}
+		if iface.Flags&net.FlagLoopback == 0 && !strings.HasPrefix(iface.Name, wslInterfacePrefix) {
+			// Hosts on any other network could reach the user daemon
+			return nil, "", fmt.Errorf("the network interface %s is neither a loopback interface nor the interface of WSL", iface.Name)
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, "", err
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				return ipNet.IP, iface.Name, nil
+			}
+		}
+	}
+	if name == "" {
+		name = wslInterfacePrefix + ")"
+	}
+	return nil, "", fmt.Errorf("found no IPv4 address of the network interface %s; is WSL2 running?", name)
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// loopbackInterface returns the name of the loopback interface, which the tests use in place of the
+// interface of Windows on the virtual switch of WSL.
+func loopbackInterface(t *testing.T) string {
+	ifaces, err := net.Interfaces()
+	require.NoError(t, err)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			return iface.Name
+		}
+	}
+	t.Skip("no loopback interface")
+	return ""
+}
+
+func TestWSLSocket(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	lo := loopbackInterface(t)
+	endpointFile := filepath.Join(t.TempDir(), "telepresence", WSLEndpointFileName)
+
+	_, err := ReadWSLEndpoint(endpointFile)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	l, err := ListenWSLSocket(ctx, "connector", lo, endpointFile)
+	require.NoError(t, err)
+	svc := grpc.NewServer()
+	connector.RegisterConnectorServer(svc, versionConnector{})
+	go func() { _ = svc.Serve(l) }()
+	defer svc.Stop()
+
+	// The endpoint file tells the CLI in WSL where, and with what token, the daemon is reached
+	ep, err := ReadWSLEndpoint(endpointFile)
+	require.NoError(t, err)
+	assert.Equal(t, l.Addr().String(), ep.Address)
+	assert.True(t, strings.HasPrefix(ep.Address, "127.0.0.1:"))
+	assert.Equal(t, lo, ep.Interface)
+	assert.Equal(t, os.Getpid(), ep.PID)
+	assert.Len(t, ep.Token, tokenLen)
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(endpointFile)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	}
+
+	conn, err := DialWSLSocket(ctx, ep)
+	require.NoError(t, err)
+	vi, err := connector.NewConnectorClient(conn).Version(ctx, &emptypb.Empty{})
+	conn.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "v2.4.5", vi.Version)
+
+	// A client with another token is rejected
+	tc, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	bad := *ep
+	bad.Token = strings.Repeat("0", tokenLen)
+	_, err = DialWSLSocket(tc, &bad)
+	assert.Error(t, err)
+
+	// Nothing listens when the daemon is gone
+	require.NoError(t, RemoveSocket(l))
+	_, err = os.Stat(endpointFile)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	svc.Stop()
+	_, err = DialWSLSocket(ctx, ep)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestReadWSLEndpoint_invalid(t *testing.T) {
+	endpointFile := filepath.Join(t.TempDir(), WSLEndpointFileName)
+	require.NoError(t, os.WriteFile(endpointFile, []byte(`{"address":"172.29.64.1:49875","token":"short"}`), 0600))
+	_, err := ReadWSLEndpoint(endpointFile)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is not a valid endpoint file")
+}
+
+func Test_wslInterfaceIP(t *testing.T) {
+	_, _, err := wslInterfaceIP("")
+	if err == nil {
+		t.Skip("this host has a WSL interface")
+	}
+	assert.Contains(t, err.Error(), "vEthernet (WSL)")
+}
+
+func Test_wslInterfaceIP_otherInterface(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	require.NoError(t, err)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 || strings.HasPrefix(iface.Name, wslInterfacePrefix) {
+			continue
+		}
+		// The user daemon must not be reachable from other networks
+		_, _, err = wslInterfaceIP(iface.Name)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "is neither a loopback interface nor the interface of WSL")
+		return
+	}
+	t.Skip("this host has no network interface other than loopback")
+}
@@ -0,0 +1,25 @@
+package client
+
+import "context"
+
+// TelemetrySource tells what decided whether telemetry is enabled.
+type TelemetrySource string
+
+const (
+	TelemetryDefault     TelemetrySource = "default"
+	TelemetryEnvironment TelemetrySource = "environment"
+	TelemetryConfig      TelemetrySource = "config"
+)
+
+// GetTelemetry returns whether telemetry is enabled and what decided that. The SCOUT_DISABLE environment
+// variable takes precedence over the telemetry.enabled of the client config. Telemetry is enabled when
+// neither is set.
+func GetTelemetry(ctx context.Context) (bool, TelemetrySource) {
+	if env := GetEnv(ctx); env != nil && env.ScoutDisable != "" {
+		return false, TelemetryEnvironment
+	}
+	if cfg := GetConfig(ctx); cfg != nil && cfg.Telemetry.Enabled != nil {
+		return *cfg.Telemetry.Enabled, TelemetryConfig
+	}
+	return true, TelemetryDefault
+}
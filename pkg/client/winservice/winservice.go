@@ -0,0 +1,83 @@
+// Package winservice installs, queries, and runs the root daemon as a Windows service. A root daemon
+// that runs as a service isn't a child of the terminal that started it, so it survives when that
+// terminal is closed. The functions of this package report that no service is installed on other
+// platforms.
+package winservice
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Name is the name of the Windows service that runs the root daemon.
+const Name = "TelepresenceDaemon"
+
+// DisplayName is the name of the service that is shown to users.
+const DisplayName = "Telepresence Root Daemon"
+
+// Command is the telepresence sub-command that the service control manager runs.
+const Command = "daemon-service-foreground"
+
+// versionFlag is the flag that records the version that installed the service in its command line.
+const versionFlag = "--installed-version"
+
+var ErrNotSupported = errors.New("the root daemon can only run as a service on Windows")
+
+// Info describes the installed service.
+type Info struct {
+	// Installed is false when no service is installed. The other fields are then undefined.
+	Installed bool
+
+	// Running is true when the service is started.
+	Running bool
+
+	// Version is the version of the telepresence binary that installed the service.
+	Version string
+}
+
+// RunFunc is the function that a service runs. The args are the arguments that were passed to Start.
+// The stop channel is closed when the service control manager tells the service to stop, which happens
+// when a user stops it or when the system shuts down.
+type RunFunc func(ctx context.Context, args []string, stop <-chan struct{}) error
+
+// Query returns information about the installed service.
+func Query() (*Info, error) {
+	return query()
+}
+
+// Start starts the service with the given arguments.
+func Start(args ...string) error {
+	return start(args...)
+}
+
+// Install installs the service so that it runs the given telepresence executable, replacing a service
+// that is already installed. The service can be started by interactive users.
+func Install(exe, version string) error {
+	return install(exe, version)
+}
+
+// Uninstall stops and removes the service.
+func Uninstall() error {
+	return uninstall()
+}
+
+// IsService returns true if the current process runs as a Windows service.
+func IsService() bool {
+	return isService()
+}
+
+// Run runs the current process as the service and returns when the given function returns.
+func Run(ctx context.Context, fn RunFunc) error {
+	return run(ctx, fn)
+}
+
+// installedVersion returns the version that installed a service with the given command line.
+func installedVersion(cmdLine string) string {
+	for _, arg := range strings.Fields(cmdLine) {
+		if arg = strings.Trim(arg, `"`); strings.HasPrefix(arg, versionFlag+"=") {
+			return strings.TrimPrefix(arg, versionFlag+"=")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package winservice
+
+import "context"
+
+func query() (*Info, error) {
+	return &Info{}, nil
+}
+
+func start(...string) error {
+	return ErrNotSupported
+}
+
+func install(string, string) error {
+	return ErrNotSupported
+}
+
+func uninstall() error {
+	return ErrNotSupported
+}
+
+func isService() bool {
+	return false
+}
+
+func run(context.Context, RunFunc) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,22 @@
+package winservice
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstalledVersion(t *testing.T) {
+	tests := []struct {
+		cmdLine string
+		version string
+	}{
+		{`C:\telepresence\telepresence.exe daemon-service-foreground --installed-version=v2.4.5`, "v2.4.5"},
+		{`"C:\Program Files\telepresence\telepresence.exe" daemon-service-foreground --installed-version=v2.4.6-rc.1`, "v2.4.6-rc.1"},
+		{`"C:\telepresence.exe" daemon-service-foreground "--installed-version=v2.4.5"`, "v2.4.5"},
+		{`C:\telepresence\telepresence.exe daemon-service-foreground`, ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.version, installedVersion(tt.cmdLine), tt.cmdLine)
+	}
+}
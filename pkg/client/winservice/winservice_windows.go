@@ -0,0 +1,214 @@
+package winservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceSDDL grants full control of the service to the system and to administrators, and allows
+// interactive users to query, start, and stop it, so that the CLI can start the root daemon without
+// elevated privileges.
+const serviceSDDL = "D:(A;;CCLCSWRPWPDTLOCRRC;;;SY)(A;;CCDCLCSWRPWPDTLOCRSDRCWDWO;;;BA)(A;;CCLCSWRPWPLOCRRC;;;IU)"
+
+// stopTimeout is how long Install and Uninstall wait for a running service to stop.
+const stopTimeout = 10 * time.Second
+
+// openService opens the service with the given access rights only. The functions of the mgr package
+// can't be used for this because they require full access, which users that aren't administrators
+// don't have.
+func openService(access uint32) (*mgr.Service, func(), error) {
+	mh, err := windows.OpenSCManager(nil, nil, windows.SC_MANAGER_CONNECT)
+	if err != nil {
+		return nil, nil, err
+	}
+	// UTF16PtrFromString can only fail if the argument contains a NUL byte. That will never happen here.
+	namePtr, _ := windows.UTF16PtrFromString(Name)
+	sh, err := windows.OpenService(mh, namePtr, access)
+	if err != nil {
+		_ = windows.CloseServiceHandle(mh)
+		return nil, nil, err
+	}
+	return &mgr.Service{Name: Name, Handle: sh}, func() {
+		_ = windows.CloseServiceHandle(sh)
+		_ = windows.CloseServiceHandle(mh)
+	}, nil
+}
+
+func query() (*Info, error) {
+	s, closer, err := openService(windows.SERVICE_QUERY_CONFIG | windows.SERVICE_QUERY_STATUS)
+	if err != nil {
+		if errors.Is(err, windows.ERROR_SERVICE_DOES_NOT_EXIST) {
+			return &Info{}, nil
+		}
+		return nil, fmt.Errorf("unable to open service %s: %w", Name, err)
+	}
+	defer closer()
+	cfg, err := s.Config()
+	if err != nil {
+		return nil, fmt.Errorf("unable to query the configuration of service %s: %w", Name, err)
+	}
+	st, err := s.Query()
+	if err != nil {
+		return nil, fmt.Errorf("unable to query the status of service %s: %w", Name, err)
+	}
+	return &Info{
+		Installed: true,
+		Running:   st.State != svc.Stopped,
+		Version:   installedVersion(cfg.BinaryPathName),
+	}, nil
+}
+
+func start(args ...string) error {
+	s, closer, err := openService(windows.SERVICE_START)
+	if err != nil {
+		return fmt.Errorf("unable to open service %s: %w", Name, err)
+	}
+	defer closer()
+	if err = s.Start(args...); err != nil && !errors.Is(err, windows.ERROR_SERVICE_ALREADY_RUNNING) {
+		return fmt.Errorf("unable to start service %s: %w", Name, err)
+	}
+	return nil
+}
+
+func install(exe, version string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = m.Disconnect()
+	}()
+	if err = remove(m); err != nil {
+		return err
+	}
+	s, err := m.CreateService(Name, exe, mgr.Config{
+		StartType:   mgr.StartManual,
+		DisplayName: DisplayName,
+		Description: "Manages the network and DNS configuration of Telepresence sessions",
+	}, Command, versionFlag+"="+version)
+	if err != nil {
+		return fmt.Errorf("unable to create service %s: %w", Name, err)
+	}
+	defer s.Close()
+
+	sd, err := windows.SecurityDescriptorFromString(serviceSDDL)
+	if err != nil {
+		return err
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return err
+	}
+	if err = windows.SetSecurityInfo(s.Handle, windows.SE_SERVICE, windows.DACL_SECURITY_INFORMATION, nil, nil, dacl, nil); err != nil {
+		return fmt.Errorf("unable to allow users to start service %s: %w", Name, err)
+	}
+	return nil
+}
+
+func uninstall() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = m.Disconnect()
+	}()
+	return remove(m)
+}
+
+// remove stops and deletes the service if it's installed.
+func remove(m *mgr.Mgr) error {
+	s, err := m.OpenService(Name)
+	if err != nil {
+		if errors.Is(err, windows.ERROR_SERVICE_DOES_NOT_EXIST) {
+			return nil
+		}
+		return err
+	}
+	defer s.Close()
+	if err = stop(s); err != nil {
+		return err
+	}
+	if err = s.Delete(); err != nil {
+		return fmt.Errorf("unable to delete service %s: %w", Name, err)
+	}
+	return nil
+}
+
+// stop stops the service and waits for it to report that it's stopped.
+func stop(s *mgr.Service) error {
+	st, err := s.Control(svc.Stop)
+	if err != nil {
+		if errors.Is(err, windows.ERROR_SERVICE_NOT_ACTIVE) {
+			return nil
+		}
+		return fmt.Errorf("unable to stop service %s: %w", Name, err)
+	}
+	for deadline := time.Now().Add(stopTimeout); st.State != svc.Stopped; {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout waiting for service %s to stop", Name)
+		}
+		time.Sleep(300 * time.Millisecond)
+		if st, err = s.Query(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isService() bool {
+	is, err := svc.IsWindowsService()
+	return err == nil && is
+}
+
+func run(ctx context.Context, fn RunFunc) error {
+	return svc.Run(Name, &handler{ctx: ctx, fn: fn})
+}
+
+// handler runs a RunFunc as a service.
+type handler struct {
+	ctx context.Context
+	fn  RunFunc
+}
+
+// Execute runs the function of the handler and tells it to stop when the service control manager
+// sends a stop or shutdown request. The first argument is the name of the service, so it's not
+// passed to the function.
+func (h *handler) Execute(args []string, rq <-chan svc.ChangeRequest, st chan<- svc.Status) (bool, uint32) {
+	st <- svc.Status{State: svc.StartPending}
+	if len(args) > 0 {
+		args = args[1:]
+	}
+	stopCh := make(chan struct{})
+	var stopOnce sync.Once
+	done := make(chan error, 1)
+	go func() {
+		done <- h.fn(h.ctx, args, stopCh)
+	}()
+	st <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case err := <-done:
+			st <- svc.Status{State: svc.StopPending}
+			if err != nil {
+				return true, 1
+			}
+			return false, 0
+		case cr := <-rq:
+			switch cr.Cmd {
+			case svc.Interrogate:
+				st <- cr.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				st <- svc.Status{State: svc.StopPending}
+				stopOnce.Do(func() { close(stopCh) })
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package winservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/windows/svc"
+)
+
+// execute runs a handler for the given function and returns the statuses that it reports, its result,
+// and the channel used to send it change requests.
+func execute(fn RunFunc) (chan svc.ChangeRequest, chan svc.Status, <-chan [2]interface{}) {
+	rq := make(chan svc.ChangeRequest)
+	st := make(chan svc.Status, 10)
+	result := make(chan [2]interface{}, 1)
+	h := &handler{ctx: context.Background(), fn: fn}
+	go func() {
+		ssec, code := h.Execute([]string{Name, "config", "10.0.0.10"}, rq, st)
+		result <- [2]interface{}{ssec, code}
+	}()
+	return rq, st, result
+}
+
+func TestHandler_stop(t *testing.T) {
+	var args []string
+	rq, st, result := execute(func(_ context.Context, a []string, stop <-chan struct{}) error {
+		args = a
+		<-stop
+		return nil
+	})
+	assert.Equal(t, svc.StartPending, (<-st).State)
+	assert.Equal(t, svc.Running, (<-st).State)
+
+	rq <- svc.ChangeRequest{Cmd: svc.Shutdown}
+	select {
+	case r := <-result:
+		assert.Equal(t, [2]interface{}{false, uint32(0)}, r)
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler didn't return after a shutdown request")
+	}
+	assert.Equal(t, []string{"config", "10.0.0.10"}, args)
+}
+
+func TestHandler_failure(t *testing.T) {
+	_, _, result := execute(func(context.Context, []string, <-chan struct{}) error {
+		return errors.New("boom")
+	})
+	select {
+	case r := <-result:
+		assert.Equal(t, [2]interface{}{true, uint32(1)}, r)
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler didn't return when its function failed")
+	}
+}
@@ -29,6 +29,7 @@ type k8sPortForwardDialer struct {
 	// static
 	logCtx          context.Context
 	kubeFlags       *kates.ConfigFlags
+	kubeConfig      *rest.Config
 	kubeRESTClient  *rest.RESTClient
 	kubeKatesClient *kates.Client
 	spdyTransport   http.RoundTripper
@@ -49,6 +50,7 @@ func NewK8sPortForwardDialer(logCtx context.Context, kubeFlags *kates.ConfigFlag
 	if err != nil {
 		return nil, err
 	}
+	WithProxy(kubeConfig)
 
 	if err := setKubernetesDefaults(kubeConfig); err != nil {
 		return nil, err
@@ -65,6 +67,7 @@ func NewK8sPortForwardDialer(logCtx context.Context, kubeFlags *kates.ConfigFlag
 	}
 	dialer := &k8sPortForwardDialer{
 		logCtx:          logCtx,
+		kubeConfig:      kubeConfig,
 		kubeFlags:       kubeFlags,
 		kubeRESTClient:  kubeRESTClient,
 		kubeKatesClient: kubeKatesClient,
@@ -210,7 +213,7 @@ func (pf *k8sPortForwardDialer) spdyStream(pod *kates.Pod) (httpstream.Connectio
 
 	spdyStream, _, err := spdyDialer.Dial(portforward.PortForwardProtocolV1Name)
 	if err != nil {
-		return nil, err
+		return nil, WithProxyError(pf.kubeConfig, err)
 	}
 
 	pf.spdyStreams[cacheKey] = spdyStream
@@ -0,0 +1,62 @@
+package dnet
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/http/httpproxy"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/rest"
+)
+
+// proxyFromEnvironment is like http.ProxyFromEnvironment, but it reads the environment on each call
+// instead of only once.
+func proxyFromEnvironment(req *http.Request) (*url.URL, error) {
+	return httpproxy.FromEnvironment().ProxyFunc()(req.URL)
+}
+
+// ProxyFunc returns the function that selects the proxy for the requests to the API server of the
+// given config. The proxy-url of the kubeconfig cluster takes precedence. Otherwise, HTTPS_PROXY and
+// HTTP_PROXY are used unless NO_PROXY, which may contain CIDRs, matches the API server.
+func ProxyFunc(config *rest.Config) func(*http.Request) (*url.URL, error) {
+	if config.Proxy != nil {
+		return config.Proxy
+	}
+	return utilnet.NewProxierWithNoProxyCIDR(proxyFromEnvironment)
+}
+
+// WithProxy makes all clients created from the given config, including the SPDY port-forward
+// dialer, use the proxy that ProxyFunc selects. The config is returned for convenience.
+func WithProxy(config *rest.Config) *rest.Config {
+	config.Proxy = ProxyFunc(config)
+	return config
+}
+
+// ProxyURL returns the proxy that is used to reach the API server of the given config, or nil when
+// the API server is reached directly.
+func ProxyURL(config *rest.Config) *url.URL {
+	host, _, err := rest.DefaultServerURL(config.Host, "", schema.GroupVersion{}, rest.IsConfigTransportTLS(*config))
+	if err != nil {
+		return nil
+	}
+	proxyURL, err := ProxyFunc(config)(&http.Request{URL: host})
+	if err != nil {
+		return nil
+	}
+	return proxyURL
+}
+
+// WithProxyError adds the proxy that is used to reach the API server of the given config to the
+// error, so that a proxy that is unreachable or refuses the connection is easy to identify. The
+// password of an authenticated proxy is redacted.
+func WithProxyError(config *rest.Config, err error) error {
+	if err == nil {
+		return nil
+	}
+	if proxyURL := ProxyURL(config); proxyURL != nil {
+		return fmt.Errorf("%w (using proxy %s)", err, proxyURL.Redacted())
+	}
+	return err
+}
@@ -0,0 +1,210 @@
+package dnet
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// apiHost is the address of the API server as seen by the clients. It doesn't resolve, so the API
+// server can only be reached through the testProxy.
+const apiHost = "api.cluster.test:6443"
+
+// testProxy is an HTTP proxy that records the CONNECT requests that it receives, and connects all
+// tunnels to the same backend.
+type testProxy struct {
+	*httptest.Server
+	backend string
+
+	sync.Mutex
+	connects []string
+	auths    []string
+}
+
+func newTestProxy(t *testing.T, backend string) *testProxy {
+	p := &testProxy{backend: backend}
+	p.Server = httptest.NewServer(http.HandlerFunc(p.serve))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *testProxy) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	p.Lock()
+	p.connects = append(p.connects, r.Host)
+	p.auths = append(p.auths, r.Header.Get("Proxy-Authorization"))
+	p.Unlock()
+
+	upstream, err := net.Dial("tcp", p.backend)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	conn, buf, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	go func() {
+		defer upstream.Close()
+		defer conn.Close()
+		go func() { _, _ = io.Copy(upstream, buf) }()
+		_, _ = io.Copy(conn, upstream)
+	}()
+}
+
+func (p *testProxy) recorded() ([]string, []string) {
+	p.Lock()
+	defer p.Unlock()
+	return append([]string(nil), p.connects...), append([]string(nil), p.auths...)
+}
+
+// newAPIServer returns a TLS server that responds to the version request of a discovery client.
+func newAPIServer(t *testing.T) *httptest.Server {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"major":"1","minor":"21","gitVersion":"v1.21.0"}`))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func testConfig() *rest.Config {
+	return &rest.Config{
+		Host:            "https://" + apiHost,
+		TLSClientConfig: rest.TLSClientConfig{Insecure: true},
+	}
+}
+
+func clearProxyEnv(t *testing.T) {
+	for _, env := range []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy", "NO_PROXY", "no_proxy"} {
+		t.Setenv(env, "")
+	}
+}
+
+func basicAuth(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+func TestWithProxy(t *testing.T) {
+	apiServer := newAPIServer(t)
+	tests := []struct {
+		name      string
+		kubeProxy bool
+		envProxy  bool
+		noProxy   string
+		wantAuth  string
+	}{
+		{name: "kubeconfig proxy-url", kubeProxy: true, wantAuth: basicAuth("alice", "secret")},
+		{name: "HTTPS_PROXY", envProxy: true},
+		{name: "kubeconfig proxy-url takes precedence", kubeProxy: true, envProxy: true, wantAuth: basicAuth("alice", "secret")},
+		{name: "NO_PROXY doesn't apply to proxy-url", kubeProxy: true, noProxy: "cluster.test", wantAuth: basicAuth("alice", "secret")},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			clearProxyEnv(t)
+			proxy := newTestProxy(t, apiServer.Listener.Addr().String())
+			config := testConfig()
+			if tt.kubeProxy {
+				proxyURL, err := url.Parse(proxy.URL)
+				require.NoError(t, err)
+				proxyURL.User = url.UserPassword("alice", "secret")
+				// This is what client-go makes of the proxy-url of a kubeconfig cluster
+				config.Proxy = http.ProxyURL(proxyURL)
+			}
+			if tt.envProxy {
+				unused := newTestProxy(t, apiServer.Listener.Addr().String())
+				if tt.kubeProxy {
+					t.Setenv("HTTPS_PROXY", unused.URL)
+				} else {
+					t.Setenv("HTTPS_PROXY", proxy.URL)
+				}
+			}
+			t.Setenv("NO_PROXY", tt.noProxy)
+			WithProxy(config)
+
+			// The kube client
+			dc, err := discovery.NewDiscoveryClientForConfig(config)
+			require.NoError(t, err)
+			info, err := dc.ServerVersion()
+			require.NoError(t, err)
+			assert.Equal(t, "v1.21.0", info.GitVersion)
+
+			// The SPDY port-forward dialer
+			_, upgrader, err := spdy.RoundTripperFor(config)
+			require.NoError(t, err)
+			req, err := http.NewRequest(http.MethodPost, config.Host+"/api/v1/namespaces/default/pods/x/portforward", nil)
+			require.NoError(t, err)
+			conn, err := upgrader.(interface {
+				Dial(*http.Request) (net.Conn, error)
+			}).Dial(req)
+			require.NoError(t, err)
+			conn.Close()
+
+			connects, auths := proxy.recorded()
+			assert.Equal(t, []string{apiHost, apiHost}, connects)
+			assert.Equal(t, []string{tt.wantAuth, tt.wantAuth}, auths)
+		})
+	}
+}
+
+func TestProxyURL_noProxy(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    string
+		noProxy string
+		direct  bool
+	}{
+		{name: "proxied", host: "https://" + apiHost},
+		{name: "domain", host: "https://" + apiHost, noProxy: "example.com,cluster.test", direct: true},
+		{name: "other domain", host: "https://" + apiHost, noProxy: "example.com"},
+		{name: "IP", host: "https://10.1.2.3:6443", noProxy: "10.1.2.3", direct: true},
+		{name: "CIDR", host: "https://10.1.2.3:6443", noProxy: "10.0.0.0/8", direct: true},
+		{name: "other CIDR", host: "https://10.1.2.3:6443", noProxy: "192.168.0.0/16"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			clearProxyEnv(t)
+			t.Setenv("HTTPS_PROXY", "http://proxy.example.com:3128")
+			t.Setenv("NO_PROXY", tt.noProxy)
+			proxyURL := ProxyURL(&rest.Config{Host: tt.host})
+			if tt.direct {
+				assert.Nil(t, proxyURL)
+			} else {
+				require.NotNil(t, proxyURL)
+				assert.Equal(t, "proxy.example.com:3128", proxyURL.Host)
+			}
+		})
+	}
+}
+
+func TestWithProxyError(t *testing.T) {
+	clearProxyEnv(t)
+	refused := errors.New("connection refused")
+	config := &rest.Config{Host: "https://" + apiHost}
+	assert.Equal(t, refused, WithProxyError(config, refused), "no proxy, no change")
+
+	config.Proxy = http.ProxyURL(&url.URL{Scheme: "http", Host: "proxy.example.com:3128", User: url.UserPassword("alice", "secret")})
+	err := WithProxyError(config, refused)
+	assert.True(t, errors.Is(err, refused))
+	assert.Contains(t, err.Error(), "proxy.example.com:3128")
+	assert.NotContains(t, err.Error(), "secret")
+}
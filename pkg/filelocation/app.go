@@ -2,6 +2,7 @@ package filelocation
 
 import (
 	"context"
+	"os"
 	"path/filepath"
 )
 
@@ -38,6 +39,46 @@ func AppUserLogDir(ctx context.Context) (string, error) {
 	}
 }
 
+// AppSystemLogDir returns the directory to use for application-specific log
+// files of processes that don't run on behalf of a user, such as the root
+// daemon when it runs as a Windows service.
+//
+//  - On Windows, it returns "%ProgramData%\telepresence\logs".
+//
+//  - On everything else, it returns "/var/log/telepresence".
+func AppSystemLogDir(ctx context.Context) string {
+	if goos(ctx) == "windows" {
+		dir := os.Getenv("ProgramData")
+		if dir == "" {
+			dir = `C:\ProgramData`
+		}
+		return filepath.Join(dir, appName, "logs")
+	}
+	return filepath.Join("/var/log", appName)
+}
+
+// AppSystemRuntimeDir returns the directory to use for application-specific
+// runtime files of processes that run as root or as a system service, such as
+// the record of the network changes made by the root daemon. The directory is
+// only writable by root.
+//
+//  - On Windows, it returns "%ProgramData%\telepresence".
+//
+//  - On everything else, it returns "/var/run/telepresence".
+func AppSystemRuntimeDir(ctx context.Context) string {
+	if untyped := ctx.Value(sysRuntimeCtxKey{}); untyped != nil {
+		return untyped.(string)
+	}
+	if goos(ctx) == "windows" {
+		dir := os.Getenv("ProgramData")
+		if dir == "" {
+			dir = `C:\ProgramData`
+		}
+		return filepath.Join(dir, appName)
+	}
+	return filepath.Join("/var/run", appName)
+}
+
 // AppUserCacheDir returns the directory to use for application-specific
 // user-specific cache data.
 //
@@ -54,6 +95,29 @@ func AppUserCacheDir(ctx context.Context) (string, error) {
 	return filepath.Join(userDir, appName), nil
 }
 
+// AppUserRuntimeDir returns the directory to use for application-specific
+// user-specific runtime files, such as the files that authenticate clients to
+// a daemon.
+//
+//  - On Linux, it returns "$XDG_RUNTIME_DIR/telepresence" when XDG_RUNTIME_DIR
+//    is set.
+//
+//  - On everything else, it returns "{{AppUserCacheDir}}".
+//
+// If the location cannot be determined (for example, $HOME is not defined),
+// then it will return an error.
+func AppUserRuntimeDir(ctx context.Context) (string, error) {
+	if untyped := ctx.Value(runtimeCtxKey{}); untyped != nil {
+		return untyped.(string), nil
+	}
+	if goos(ctx) == "linux" {
+		if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+			return filepath.Join(dir, appName), nil
+		}
+	}
+	return AppUserCacheDir(ctx)
+}
+
 // AppUserConfigDir returns the directory to use for application-specific
 // user-specific configuration data.
 //
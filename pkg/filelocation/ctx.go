@@ -45,6 +45,21 @@ func WithAppUserConfigDir(ctx context.Context, configDir string) context.Context
 	return context.WithValue(ctx, configCtxKey{}, configDir)
 }
 
+type runtimeCtxKey struct{}
+
+// WithAppUserRuntimeDir spoofs the AppUserRuntimeDir.  This is useful for testing, or for when
+// finding a normal user's runtime files as root.
+func WithAppUserRuntimeDir(ctx context.Context, runtimeDir string) context.Context {
+	return context.WithValue(ctx, runtimeCtxKey{}, runtimeDir)
+}
+
+type sysRuntimeCtxKey struct{}
+
+// WithAppSystemRuntimeDir spoofs the AppSystemRuntimeDir.  This is useful for testing
+func WithAppSystemRuntimeDir(ctx context.Context, runtimeDir string) context.Context {
+	return context.WithValue(ctx, sysRuntimeCtxKey{}, runtimeDir)
+}
+
 type sysConfigsCtxKey struct{}
 
 // WithAppSystemConfigDirs spoofs the AppSystemConfigDirs.  This is useful for testing
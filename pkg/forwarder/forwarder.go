@@ -2,9 +2,12 @@ package forwarder
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -32,11 +35,18 @@ type Forwarder struct {
 	manager     manager.ManagerClient
 	sessionInfo *manager.SessionInfo
 
-	intercept  *manager.InterceptInfo
-	muxTunnel  connpool.MuxTunnel
+	intercepts []*interceptTarget
 	mgrVersion semver.Version
 }
 
+// interceptTarget is an active intercept, and the tunnel to its intercepting client.
+type interceptTarget struct {
+	info      *manager.InterceptInfo
+	matcher   *HTTPMatcher // nil when the intercept has no HTTP conditions
+	tlsConfig *tls.Config  // nil when the agent doesn't terminate the TLS of the intercepted connections
+	muxTunnel connpool.MuxTunnel
+}
+
 func NewForwarder(listen *net.TCPAddr, targetHost string, targetPort int32) *Forwarder {
 	return &Forwarder{
 		listenAddr: listen,
@@ -50,7 +60,9 @@ func (f *Forwarder) SetManager(sessionInfo *manager.SessionInfo, manager manager
 	defer f.mu.Unlock()
 	f.sessionInfo = sessionInfo
 	f.manager = manager
-	f.muxTunnel = nil // any existing tunnel is lost when a reconnect happens
+	for _, it := range f.intercepts {
+		it.muxTunnel = nil // any existing tunnel is lost when a reconnect happens
+	}
 	f.mgrVersion = version
 }
 
@@ -98,6 +110,18 @@ func (f *Forwarder) ServeListener(ctx context.Context, listener *net.TCPListener
 
 func (f *Forwarder) Listen(ctx context.Context) (*net.TCPListener, error) {
 	f.mu.Lock()
+	f.initContextsLocked(ctx)
+	listenAddr := f.listenAddr
+	f.mu.Unlock()
+	return net.ListenTCP("tcp", listenAddr)
+}
+
+// initContextsLocked sets up the listener and target lifetimes unless they have been set up already. This
+// makes it possible to serve both TCP and UDP using the same forwarder.
+func (f *Forwarder) initContextsLocked(ctx context.Context) {
+	if f.lCtx != nil {
+		return
+	}
 
 	// Set up listener lifetime (same as the overall forwarder lifetime)
 	f.lCtx, f.lCancel = context.WithCancel(ctx)
@@ -105,23 +129,26 @@ func (f *Forwarder) Listen(ctx context.Context) (*net.TCPListener, error) {
 
 	// Set up target lifetime
 	f.tCtx, f.tCancel = context.WithCancel(f.lCtx)
-	listenAddr := f.listenAddr
-
-	f.mu.Unlock()
-	return net.ListenTCP("tcp", listenAddr)
 }
 
 func (f *Forwarder) Close() error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	if f.muxTunnel != nil {
-		_ = f.muxTunnel.CloseSend()
-	}
+	f.closeTunnelsLocked()
 	f.lCancel()
 	return nil
 }
 
+func (f *Forwarder) closeTunnelsLocked() {
+	for _, it := range f.intercepts {
+		if it.muxTunnel != nil {
+			_ = it.muxTunnel.CloseSend()
+			it.muxTunnel = nil
+		}
+	}
+}
+
 func (f *Forwarder) Target() (string, int32) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -129,85 +156,158 @@ func (f *Forwarder) Target() (string, int32) {
 	return f.targetHost, f.targetPort
 }
 
+// SetTarget changes the host and port that connections are forwarded to. Connections to the old target
+// are dropped.
+func (f *Forwarder) SetTarget(targetHost string, targetPort int32) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.targetHost == targetHost && f.targetPort == targetPort {
+		return
+	}
+	if f.tCancel != nil {
+		dlog.Debugf(f.lCtx, "Forward target changed from %s:%d to %s:%d", f.targetHost, f.targetPort, targetHost, targetPort)
+		f.tCancel()
+		f.tCtx, f.tCancel = context.WithCancel(f.lCtx)
+	}
+	f.targetHost = targetHost
+	f.targetPort = targetPort
+}
+
 func (f *Forwarder) Intercepting() bool {
 	f.mu.Lock()
-	intercepting := f.intercept != nil
+	intercepting := len(f.intercepts) > 0
 	f.mu.Unlock()
 	return intercepting
 }
 
-func (f *Forwarder) SetIntercepting(intercept *manager.InterceptInfo) {
+// SetIntercepts sets the active intercepts. An intercept without HTTP conditions must be the only one. The
+// HTTP requests that don't match the conditions of any of the intercepts go to the target. Connections are
+// dropped when the intercepts change.
+func (f *Forwarder) SetIntercepts(intercepts []*manager.InterceptInfo) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	iceptInfo := func(ii *manager.InterceptInfo) string {
-		is := ii.Spec
-		return fmt.Sprintf("'%s' (%s:%d)", is.Name, is.Client, is.TargetPort)
-	}
-	if intercept == nil {
-		if f.intercept == nil {
-			return
+	iceptInfo := func(iis []*manager.InterceptInfo) string {
+		if len(iis) == 0 {
+			return fmt.Sprintf("%s:%d", f.targetHost, f.targetPort)
 		}
-		dlog.Debugf(f.lCtx, "Forward target changed from intercept %s to %s:%d", iceptInfo(f.intercept), f.targetHost, f.targetPort)
-	} else {
-		if f.intercept == nil {
-			dlog.Debugf(f.lCtx, "Forward target changed from %s:%d to intercept %s", f.targetHost, f.targetPort, iceptInfo(intercept))
-		} else {
-			if f.intercept.Id == intercept.Id {
-				return
-			}
-			dlog.Debugf(f.lCtx, "Forward target changed from intercept %s to intercept %q", iceptInfo(f.intercept), iceptInfo(intercept))
+		descs := make([]string, len(iis))
+		for i, ii := range iis {
+			is := ii.Spec
+			descs[i] = fmt.Sprintf("'%s' (%s:%d)", is.Name, is.Client, is.TargetPort)
 		}
+		return "intercept " + strings.Join(descs, ", ")
 	}
+	current := make([]*manager.InterceptInfo, len(f.intercepts))
+	for i, it := range f.intercepts {
+		current[i] = it.info
+	}
+	if sameIntercepts(current, intercepts) {
+		return
+	}
+	dlog.Debugf(f.lCtx, "Forward target changed from %s to %s", iceptInfo(current), iceptInfo(intercepts))
 
 	// Drop existing connections
-	if f.muxTunnel != nil {
-		_ = f.muxTunnel.CloseSend()
-		f.muxTunnel = nil
-	}
+	f.closeTunnelsLocked()
 	f.tCancel()
 
-	// Set up new target and lifetime
+	// Set up new targets and lifetime
 	f.tCtx, f.tCancel = context.WithCancel(f.lCtx)
-	if intercept != nil {
+	f.intercepts = make([]*interceptTarget, 0, len(intercepts))
+	for _, intercept := range intercepts {
+		it := &interceptTarget{info: intercept}
+		if HasHTTPConditions(intercept.Spec) {
+			var err error
+			if it.matcher, err = NewHTTPMatcher(intercept.Spec); err != nil {
+				dlog.Errorf(f.tCtx, "HTTP requests will not be intercepted by %q: %v", intercept.Spec.Name, err)
+				continue
+			}
+		}
+		var err error
+		if it.tlsConfig, err = f.agentTLSConfig(f.tCtx, intercept); err != nil {
+			dlog.Errorf(f.tCtx, "Connections will not be intercepted by %q: %v", intercept.Spec.Name, err)
+			continue
+		}
 		if f.manager != nil {
 			muxTunnel, err := f.startManagerTunnel(f.tCtx, intercept.ClientSession)
 			if err != nil {
 				dlog.Error(f.tCtx, err)
-				return
+				continue
 			}
-			f.muxTunnel = muxTunnel
+			it.muxTunnel = muxTunnel
+		}
+		f.intercepts = append(f.intercepts, it)
+	}
+}
+
+// sameIntercepts returns true if the given lists contain the same intercepts in the same order.
+func sameIntercepts(a, b []*manager.InterceptInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Id != b[i].Id {
+			return false
 		}
 	}
-	f.intercept = intercept
+	return true
 }
 
-func (f *Forwarder) forwardConn(clientConn *net.TCPConn) error {
+// forwardConn forwards the given connection to the target, or to the intercepting client if an
+// intercept is active. The connection is either a TCP connection or a UDP connection created by
+// ServeUDP, and the target is dialed using the same network. The TLS of TCP connections is terminated
+// when an intercept uses an agent_tls_secret, and connections that go to the target are then encrypted
+// again.
+func (f *Forwarder) forwardConn(clientConn net.Conn) error {
 	f.mu.Lock()
 	ctx := f.tCtx
 	targetHost := f.targetHost
 	targetPort := f.targetPort
-	intercept := f.intercept
-	muxTunnel := f.muxTunnel
+	intercepts := f.intercepts
 	f.mu.Unlock()
-	if intercept != nil {
-		return f.interceptConn(ctx, clientConn, intercept, muxTunnel)
-	}
 
-	targetAddr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", targetHost, targetPort))
-	if err != nil {
-		return fmt.Errorf("error on resolve(%s:%d): %w", targetHost, targetPort, err)
+	network := clientConn.RemoteAddr().Network()
+	targetAddr := net.JoinHostPort(targetHost, strconv.Itoa(int(targetPort)))
+
+	if len(intercepts) > 0 {
+		dialApp := func() (net.Conn, error) {
+			conn, err := net.Dial("tcp", targetAddr)
+			if err != nil {
+				return nil, fmt.Errorf("error on dial: %w", err)
+			}
+			return conn, nil
+		}
+		if tlsConfig := terminationConfig(intercepts); tlsConfig != nil && network == "tcp" {
+			tc, err := terminateTLS(ctx, clientConn, tlsConfig)
+			if err != nil {
+				_ = clientConn.Close()
+				return err
+			}
+			clientConn = tc
+			serverName := tc.ConnectionState().ServerName
+			dialApp = func() (net.Conn, error) {
+				return dialTLS(ctx, targetAddr, serverName)
+			}
+		}
+		if it := intercepts[0]; it.matcher == nil {
+			return f.interceptConn(ctx, clientConn, it.info, it.muxTunnel)
+		}
+		if network == "tcp" {
+			return f.routeConn(ctx, clientConn, dialApp, intercepts)
+		}
+		// The conditions can't be applied, so the connection goes to the app.
 	}
 
 	ctx = dlog.WithField(ctx, "client", clientConn.RemoteAddr().String())
-	ctx = dlog.WithField(ctx, "target", targetAddr.String())
+	ctx = dlog.WithField(ctx, "target", targetAddr)
 
 	dlog.Debug(ctx, "Forwarding...")
 	defer dlog.Debug(ctx, "Done forwarding")
 
 	defer clientConn.Close()
 
-	targetConn, err := net.DialTCP("tcp", nil, targetAddr)
+	targetConn, err := net.Dial(network, targetAddr)
 	if err != nil {
 		return fmt.Errorf("error on dial: %w", err)
 	}
@@ -219,14 +319,14 @@ func (f *Forwarder) forwardConn(clientConn *net.TCPConn) error {
 		if _, err := io.Copy(targetConn, clientConn); err != nil {
 			dlog.Debugf(ctx, "Error clientConn->targetConn: %+v", err)
 		}
-		_ = targetConn.CloseWrite()
+		closeWrite(targetConn)
 		done <- struct{}{}
 	}()
 	go func() {
 		if _, err := io.Copy(clientConn, targetConn); err != nil {
 			dlog.Debugf(ctx, "Error targetConn->clientConn: %+v", err)
 		}
-		_ = clientConn.CloseWrite()
+		closeWrite(clientConn)
 		done <- struct{}{}
 	}()
 
@@ -242,6 +342,47 @@ func (f *Forwarder) forwardConn(clientConn *net.TCPConn) error {
 	return nil
 }
 
+// routeConn routes the HTTP requests of the given connection to the intercepting client of the first of the
+// given intercepts whose conditions they match, or to the target, which is dialed using dialApp, when they
+// match none.
+func (f *Forwarder) routeConn(ctx context.Context, clientConn net.Conn, dialApp func() (net.Conn, error), intercepts []*interceptTarget) error {
+	ctx = dlog.WithField(ctx, "client", clientConn.RemoteAddr().String())
+
+	dlog.Debug(ctx, "Routing HTTP requests...")
+	defer dlog.Debug(ctx, "Done routing HTTP requests")
+
+	dialIntercept := func(it *interceptTarget) func() (net.Conn, error) {
+		return func() (net.Conn, error) {
+			// The tunnel to the intercepting client takes the connection that it relays, so give it one end of
+			// a pipe that appears to be the client's connection, and use the other end as the backend.
+			local, remote := net.Pipe()
+			conn := &addrConn{Conn: remote, local: clientConn.LocalAddr(), remote: clientConn.RemoteAddr()}
+			go func() {
+				defer conn.Close()
+				if err := f.interceptConn(ctx, conn, it.info, it.muxTunnel); err != nil {
+					dlog.Error(ctx, err)
+				}
+			}()
+			return local, nil
+		}
+	}
+	routes := make([]httpRoute, len(intercepts))
+	for i, it := range intercepts {
+		routes[i] = httpRoute{matcher: it.matcher, dial: dialIntercept(it)}
+	}
+	return routeHTTP(ctx, clientConn, dialApp, routes)
+}
+
+// closeWrite shuts down the writing side of the given connection if possible, and closes it otherwise.
+// The latter is the case for UDP connections, which have no notion of a half-closed state.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = cw.CloseWrite()
+	} else {
+		_ = conn.Close()
+	}
+}
+
 func (f *Forwarder) startManagerTunnel(ctx context.Context, clientSession *manager.SessionInfo) (connpool.MuxTunnel, error) {
 	agentTunnel, err := f.manager.AgentTunnel(ctx)
 	if err != nil {
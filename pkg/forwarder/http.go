@@ -0,0 +1,264 @@
+package forwarder
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+// HTTPMatcher decides whether an HTTP request should be intercepted, based on the HTTP conditions of an
+// intercept spec.
+type HTTPMatcher struct {
+	headers    []headerMatcher
+	pathPrefix string
+}
+
+type headerMatcher struct {
+	name  string
+	value *regexp.Regexp
+}
+
+// HasHTTPConditions returns true if the given spec only intercepts HTTP requests that meet certain conditions.
+func HasHTTPConditions(spec *manager.InterceptSpec) bool {
+	return len(spec.HttpHeaders) > 0 || spec.HttpPathPrefix != ""
+}
+
+// InterceptsOverlap returns true if the given intercepts of the same workload may intercept the same traffic,
+// which means that the traffic-agent can't serve both. An intercept without HTTP conditions overlaps with
+// every other intercept. Two intercepts with HTTP conditions only overlap when a request can meet the
+// conditions of both, which is ruled out when they require different literal values of the same header,
+// or path prefixes that neither starts with the other.
+func InterceptsOverlap(a, b *manager.InterceptSpec) bool {
+	if !HasHTTPConditions(a) || !HasHTTPConditions(b) {
+		return true
+	}
+	if !strings.HasPrefix(a.HttpPathPrefix, b.HttpPathPrefix) && !strings.HasPrefix(b.HttpPathPrefix, a.HttpPathPrefix) {
+		return false
+	}
+	for _, ah := range a.HttpHeaders {
+		for _, bh := range b.HttpHeaders {
+			if !strings.EqualFold(ah.Name, bh.Name) {
+				continue
+			}
+			av, aLiteral := literalHeaderValue(ah.ValueRegex)
+			bv, bLiteral := literalHeaderValue(bh.ValueRegex)
+			if aLiteral && bLiteral && av != bv {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// literalHeaderValue returns the only value that the given header value regex matches, and true, when the
+// regex matches a single value.
+func literalHeaderValue(valueRegex string) (string, bool) {
+	rx, err := regexp.Compile(valueRegex)
+	if err != nil {
+		return "", false
+	}
+	return rx.LiteralPrefix()
+}
+
+// CompileHeaderRegex compiles the given regular expression so that it must match a header value in full.
+func CompileHeaderRegex(valueRegex string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + valueRegex + ")$")
+}
+
+// NewHTTPMatcher returns a matcher for the HTTP conditions of the given spec, or nil when the spec has
+// no such conditions.
+func NewHTTPMatcher(spec *manager.InterceptSpec) (*HTTPMatcher, error) {
+	if !HasHTTPConditions(spec) {
+		return nil, nil
+	}
+	m := &HTTPMatcher{pathPrefix: spec.HttpPathPrefix}
+	for _, hm := range spec.HttpHeaders {
+		rx, err := CompileHeaderRegex(hm.ValueRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression for header %q: %w", hm.Name, err)
+		}
+		m.headers = append(m.headers, headerMatcher{name: http.CanonicalHeaderKey(hm.Name), value: rx})
+	}
+	return m, nil
+}
+
+// Matches returns true if the given request meets all conditions of this matcher.
+func (m *HTTPMatcher) Matches(r *http.Request) bool {
+	if !strings.HasPrefix(r.URL.Path, m.pathPrefix) {
+		return false
+	}
+	for _, hm := range m.headers {
+		found := false
+		for _, v := range r.Header.Values(hm.name) {
+			if hm.value.MatchString(v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// httpBackend is a lazily dialed connection to one of the two destinations of a routed HTTP connection.
+type httpBackend struct {
+	dial   func() (net.Conn, error)
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (b *httpBackend) connect() error {
+	if b.conn == nil {
+		conn, err := b.dial()
+		if err != nil {
+			return err
+		}
+		b.conn = conn
+		b.reader = bufio.NewReader(conn)
+	}
+	return nil
+}
+
+func (b *httpBackend) close() {
+	if b.conn != nil {
+		_ = b.conn.Close()
+		b.conn = nil
+		b.reader = nil
+	}
+}
+
+// httpRoute is a destination of the HTTP requests that match the conditions of its matcher.
+type httpRoute struct {
+	matcher *HTTPMatcher
+	dial    func() (net.Conn, error)
+}
+
+// routeHTTP reads the HTTP/1.x requests of the given client connection one by one, and sends each request
+// to the first of the given routes whose conditions it matches, or to the app if it matches none. The
+// responses are relayed back to the client in the same order.
+//
+// Once a request results in a protocol switch, e.g. a websocket upgrade, or when the client speaks HTTP/2
+// without prior upgrade, the rest of the connection is relayed verbatim to the destination of that request.
+func routeHTTP(ctx context.Context, clientConn net.Conn, app func() (net.Conn, error), routes []httpRoute) error {
+	defer clientConn.Close()
+	appBackend := &httpBackend{dial: app}
+	defer appBackend.close()
+	interceptBackends := make([]*httpBackend, len(routes))
+	for i, route := range routes {
+		interceptBackends[i] = &httpBackend{dial: route.dial}
+		defer interceptBackends[i].close()
+	}
+
+	cr := bufio.NewReader(clientConn)
+	for ctx.Err() == nil {
+		req, err := http.ReadRequest(cr)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("unable to read HTTP request: %w", err)
+		}
+
+		if req.Method == "PRI" && req.ProtoMajor == 2 {
+			// HTTP/2 with prior knowledge. The conditions can't be evaluated, so all of it goes to the app.
+			if err = appBackend.connect(); err != nil {
+				return err
+			}
+			if _, err = io.WriteString(appBackend.conn, "PRI * HTTP/2.0\r\n\r\n"); err != nil {
+				return err
+			}
+			relay(ctx, cr, clientConn, appBackend.reader, appBackend.conn)
+			return nil
+		}
+
+		backend := appBackend
+		for i, route := range routes {
+			if route.matcher.Matches(req) {
+				dlog.Tracef(ctx, "%s %s is intercepted", req.Method, req.URL.Path)
+				backend = interceptBackends[i]
+				break
+			}
+		}
+		if err = backend.connect(); err != nil {
+			return err
+		}
+
+		// Don't let Request.Write add a User-Agent header that the client didn't send.
+		if _, ok := req.Header["User-Agent"]; !ok {
+			req.Header["User-Agent"] = []string{""}
+		}
+		if err = req.Write(backend.conn); err != nil {
+			return fmt.Errorf("unable to write HTTP request: %w", err)
+		}
+		resp, err := http.ReadResponse(backend.reader, req)
+		if err != nil {
+			return fmt.Errorf("unable to read HTTP response: %w", err)
+		}
+		err = resp.Write(clientConn)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("unable to write HTTP response: %w", err)
+		}
+
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			relay(ctx, cr, clientConn, backend.reader, backend.conn)
+			return nil
+		}
+		if req.Close || resp.Close {
+			return nil
+		}
+	}
+	return nil
+}
+
+// relay copies data in both directions between the client and the backend until both directions are done.
+func relay(ctx context.Context, cr io.Reader, clientConn net.Conn, br io.Reader, backendConn net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		if _, err := io.Copy(backendConn, cr); err != nil {
+			dlog.Debugf(ctx, "Error clientConn->backendConn: %+v", err)
+		}
+		closeWrite(backendConn)
+		done <- struct{}{}
+	}()
+	go func() {
+		if _, err := io.Copy(clientConn, br); err != nil {
+			dlog.Debugf(ctx, "Error backendConn->clientConn: %+v", err)
+		}
+		closeWrite(clientConn)
+		done <- struct{}{}
+	}()
+	for numClosed := 0; numClosed < 2; {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			numClosed++
+		}
+	}
+}
+
+// addrConn is a net.Conn with substituted addresses.
+type addrConn struct {
+	net.Conn
+	local  net.Addr
+	remote net.Addr
+}
+
+func (c *addrConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *addrConn) RemoteAddr() net.Addr {
+	return c.remote
+}
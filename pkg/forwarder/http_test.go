@@ -0,0 +1,150 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+// httpServer starts an HTTP server that responds to every request with the given name followed by the
+// request path, and returns its address.
+func httpServer(t *testing.T, name string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "%s %s", name, r.URL.Path)
+	})}
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return l.Addr().String()
+}
+
+func TestNewHTTPMatcher(t *testing.T) {
+	m, err := NewHTTPMatcher(&manager.InterceptSpec{})
+	require.NoError(t, err)
+	assert.Nil(t, m)
+
+	_, err = NewHTTPMatcher(&manager.InterceptSpec{HttpHeaders: []*manager.HTTPHeaderMatch{{Name: "x-dev", ValueRegex: "("}}})
+	assert.Error(t, err)
+
+	m, err = NewHTTPMatcher(&manager.InterceptSpec{
+		HttpHeaders:    []*manager.HTTPHeaderMatch{{Name: "x-dev", ValueRegex: "alice|bob"}},
+		HttpPathPrefix: "/api/",
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		path    string
+		headers map[string]string
+		matches bool
+	}{
+		{"/api/users", map[string]string{"X-Dev": "alice"}, true},
+		{"/api/users", map[string]string{"x-dev": "bob"}, true},
+		{"/api/users", map[string]string{"X-Dev": "alice2"}, false},
+		{"/api/users", map[string]string{"X-Other": "alice"}, false},
+		{"/web/index.html", map[string]string{"X-Dev": "alice"}, false},
+		{"/api/users", nil, false},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest("GET", "http://echo"+tt.path, nil)
+		require.NoError(t, err)
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		assert.Equal(t, tt.matches, m.Matches(r), "%s %v", tt.path, tt.headers)
+	}
+}
+
+func TestRouteHTTP(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	appAddr := httpServer(t, "app")
+	var routes []httpRoute
+	for _, dev := range []string{"alice", "bob"} {
+		matcher, err := NewHTTPMatcher(&manager.InterceptSpec{
+			HttpHeaders: []*manager.HTTPHeaderMatch{{Name: "x-dev", ValueRegex: dev}},
+		})
+		require.NoError(t, err)
+		addr := httpServer(t, dev)
+		routes = append(routes, httpRoute{matcher: matcher, dial: func() (net.Conn, error) { return net.Dial("tcp", addr) }})
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = routeHTTP(ctx, conn, func() (net.Conn, error) { return net.Dial("tcp", appAddr) }, routes)
+			}()
+		}
+	}()
+
+	// A single client with one keep-alive connection, so that requests for both destinations are
+	// routed using the same connection.
+	client := &http.Client{Transport: &http.Transport{MaxConnsPerHost: 1}}
+	get := func(path, dev string) string {
+		req, err := http.NewRequest("GET", "http://"+l.Addr().String()+path, nil)
+		require.NoError(t, err)
+		if dev != "" {
+			req.Header.Set("X-Dev", dev)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	assert.Equal(t, "app /one", get("/one", ""))
+	assert.Equal(t, "alice /two", get("/two", "alice"))
+	assert.Equal(t, "app /three", get("/three", "carol"))
+	assert.Equal(t, "bob /four", get("/four", "bob"))
+	assert.Equal(t, "alice /five", get("/five", "alice"))
+}
+
+func TestInterceptsOverlap(t *testing.T) {
+	allTraffic := &manager.InterceptSpec{}
+	header := func(name, valueRegex string) *manager.InterceptSpec {
+		return &manager.InterceptSpec{HttpHeaders: []*manager.HTTPHeaderMatch{{Name: name, ValueRegex: valueRegex}}}
+	}
+	pathPrefix := func(prefix string) *manager.InterceptSpec {
+		return &manager.InterceptSpec{HttpPathPrefix: prefix}
+	}
+	tests := []struct {
+		name    string
+		a, b    *manager.InterceptSpec
+		overlap bool
+	}{
+		{"all traffic and all traffic", allTraffic, allTraffic, true},
+		{"all traffic and header", allTraffic, header("x-dev", "alice"), true},
+		{"all traffic and path prefix", allTraffic, pathPrefix("/api"), true},
+		{"same header value", header("x-dev", "alice"), header("X-Dev", "alice"), true},
+		{"different header values", header("x-dev", "alice"), header("X-Dev", "bob"), false},
+		{"header value and regex", header("x-dev", "alice"), header("x-dev", "al.*"), true},
+		{"different headers", header("x-dev", "alice"), header("x-team", "blue"), true},
+		{"nested path prefixes", pathPrefix("/api"), pathPrefix("/api/v2"), true},
+		{"disjoint path prefixes", pathPrefix("/api"), pathPrefix("/web"), false},
+		{"header and path prefix", header("x-dev", "alice"), pathPrefix("/api"), true},
+	}
+	for _, tt := range tests {
+		// The result doesn't depend on which intercept comes first
+		assert.Equal(t, tt.overlap, InterceptsOverlap(tt.a, tt.b), tt.name)
+		assert.Equal(t, tt.overlap, InterceptsOverlap(tt.b, tt.a), tt.name+", reversed")
+	}
+}
@@ -0,0 +1,76 @@
+package forwarder
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+// agentTLSConfig returns the configuration that terminates the TLS of the connections that the given
+// intercept receives, or nil when the intercept doesn't use an agent_tls_secret. The certificate is
+// obtained from the traffic-manager, which reads it from the secret.
+func (f *Forwarder) agentTLSConfig(ctx context.Context, ii *manager.InterceptInfo) (*tls.Config, error) {
+	if ii.Spec.AgentTlsSecret == "" {
+		return nil, nil
+	}
+	if f.manager == nil {
+		return nil, errors.New("no traffic-manager to get the TLS certificate from")
+	}
+	cert, err := f.manager.GetAgentTLSCertificate(ctx, &manager.AgentTLSCertificateRequest{
+		Session:     f.sessionInfo,
+		InterceptId: ii.Id,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the certificate of TLS secret %q: %w", ii.Spec.AgentTlsSecret, err)
+	}
+	kp, err := tls.X509KeyPair(cert.CertPem, cert.KeyPem)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate in TLS secret %q: %w", ii.Spec.AgentTlsSecret, err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{kp},
+		// The HTTP conditions can only be applied to HTTP/1.x, so HTTP/2 must not be negotiated
+		NextProtos: []string{"http/1.1"},
+	}, nil
+}
+
+// terminationConfig returns the TLS configuration of the first of the given intercepts that has one. All
+// intercepts of a port share the same connections, so they can't terminate TLS differently.
+func terminationConfig(intercepts []*interceptTarget) *tls.Config {
+	for _, it := range intercepts {
+		if it.tlsConfig != nil {
+			return it.tlsConfig
+		}
+	}
+	return nil
+}
+
+// terminateTLS performs the server side of the TLS handshake on the given connection, and returns the
+// connection that carries the decrypted traffic.
+func terminateTLS(ctx context.Context, conn net.Conn, config *tls.Config) (*tls.Conn, error) {
+	tc := tls.Server(conn, config)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return nil, fmt.Errorf("TLS handshake with %s failed: %w", conn.RemoteAddr(), err)
+	}
+	return tc, nil
+}
+
+// dialTLS dials the given address and performs the client side of a TLS handshake using the given server
+// name, so that the app that the traffic-agent forwards to sees the same SNI as the original client did.
+// The app is in the same pod, so its certificate isn't verified.
+func dialTLS(ctx context.Context, addr, serverName string) (net.Conn, error) {
+	d := tls.Dialer{Config: &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"http/1.1"},
+	}}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error on dial: %w", err)
+	}
+	return conn, nil
+}
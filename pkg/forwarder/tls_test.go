@@ -0,0 +1,155 @@
+package forwarder
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+// selfSignedCert returns the PEM encoded certificate and key of a self-signed certificate for the given hosts.
+func selfSignedCert(t *testing.T, hosts ...string) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: hosts[0]},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	kb, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: kb})
+}
+
+// tlsManager is a traffic-manager that only knows how to return the certificate of a TLS secret.
+type tlsManager struct {
+	manager.ManagerClient
+	cert *manager.AgentTLSCertificate
+}
+
+func (m *tlsManager) GetAgentTLSCertificate(context.Context, *manager.AgentTLSCertificateRequest, ...grpc.CallOption) (*manager.AgentTLSCertificate, error) {
+	if m.cert == nil {
+		return nil, errors.New("not found")
+	}
+	return m.cert, nil
+}
+
+// httpsServer starts an HTTPS server that responds to every request with the given name followed by the
+// server name that the client requested and the request path, and returns its address.
+func httpsServer(t *testing.T, name string) string {
+	certPEM, keyPEM := selfSignedCert(t, "app.example.com")
+	kp, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{kp}})
+	require.NoError(t, err)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "%s %s %s", name, r.TLS.ServerName, r.URL.Path)
+	})}
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(func() { _ = srv.Close() })
+	return l.Addr().String()
+}
+
+func TestForwarder_agentTLSConfig(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	ii := &manager.InterceptInfo{Id: "1", Spec: &manager.InterceptSpec{Name: "echo"}}
+
+	f := NewForwarder(nil, "127.0.0.1", 8080)
+	config, err := f.agentTLSConfig(ctx, ii)
+	require.NoError(t, err)
+	assert.Nil(t, config, "no TLS is terminated without an agent_tls_secret")
+
+	ii.Spec.AgentTlsSecret = "echo-tls"
+	f.manager = &tlsManager{}
+	_, err = f.agentTLSConfig(ctx, ii)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"echo-tls"`)
+
+	f.manager = &tlsManager{cert: &manager.AgentTLSCertificate{CertPem: []byte("bogus"), KeyPem: []byte("bogus")}}
+	_, err = f.agentTLSConfig(ctx, ii)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"echo-tls"`)
+}
+
+func TestForwarder_TLSTermination(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	appAddr := httpsServer(t, "app")
+	appHost, appPort, err := net.SplitHostPort(appAddr)
+	require.NoError(t, err)
+	port, err := net.LookupPort("tcp", appPort)
+	require.NoError(t, err)
+
+	certPEM, keyPEM := selfSignedCert(t, "echo.example.com")
+	f := NewForwarder(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}, appHost, int32(port))
+	f.manager = &tlsManager{cert: &manager.AgentTLSCertificate{CertPem: certPEM, KeyPem: keyPEM}}
+	l, err := f.Listen(ctx)
+	require.NoError(t, err)
+	go func() { _ = f.ServeListener(ctx, l) }()
+
+	// An intercept of requests that never arrive, so that all requests are routed to the app
+	spec := &manager.InterceptSpec{
+		Name:           "echo",
+		AgentTlsSecret: "echo-tls",
+		HttpHeaders:    []*manager.HTTPHeaderMatch{{Name: "x-dev", ValueRegex: "alice"}},
+	}
+	ii := &manager.InterceptInfo{Id: "1", Spec: spec}
+	matcher, err := NewHTTPMatcher(spec)
+	require.NoError(t, err)
+	config, err := f.agentTLSConfig(ctx, ii)
+	require.NoError(t, err)
+	f.mu.Lock()
+	f.intercepts = []*interceptTarget{{info: ii, matcher: matcher, tlsConfig: config}}
+	f.mu.Unlock()
+
+	// The client only trusts the certificate of the secret, and the app sees the server name that it asked for
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(certPEM))
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, l.Addr().String())
+		},
+	}}
+	resp, err := client.Get("https://echo.example.com/hello")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "app echo.example.com /hello", string(body))
+}
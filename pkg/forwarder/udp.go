@@ -0,0 +1,194 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// udpConnTTL is how long a UDP connection, i.e. the datagrams exchanged with one source address, is kept
+// alive when no datagrams are sent or received.
+var udpConnTTL = time.Minute
+
+// udpConnBufferSize is the number of datagrams that can be queued for one UDP connection before
+// new datagrams are dropped.
+const udpConnBufferSize = 64
+
+// ServeUDP listens for UDP datagrams on the port of the forwarder's listen address. The datagrams are
+// grouped into connections by source address, and each such connection is forwarded in the same way as a
+// TCP connection, i.e. to the target or, when an intercept is active, to the intercepting client. A
+// connection is closed when it has been idle for more than a minute.
+func (f *Forwarder) ServeUDP(ctx context.Context) error {
+	f.mu.Lock()
+	f.initContextsLocked(ctx)
+	addr := &net.UDPAddr{IP: f.listenAddr.IP, Port: f.listenAddr.Port, Zone: f.listenAddr.Zone}
+	f.mu.Unlock()
+
+	pc, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	return f.ServePacketConn(ctx, pc)
+}
+
+// ServePacketConn is like ServeUDP but uses an existing UDP connection.
+func (f *Forwarder) ServePacketConn(ctx context.Context, pc *net.UDPConn) error {
+	defer pc.Close()
+
+	dlog.Debugf(ctx, "Forwarding UDP from %s", pc.LocalAddr())
+	defer dlog.Debugf(ctx, "Done forwarding UDP from %s", pc.LocalAddr())
+
+	go func() {
+		<-ctx.Done()
+		pc.Close()
+	}()
+
+	var connsLock sync.Mutex
+	conns := make(map[string]*udpConn)
+
+	// Don't return until the relays have ended, since they use the shared socket.
+	var wg sync.WaitGroup
+	defer func() {
+		connsLock.Lock()
+		open := make([]*udpConn, 0, len(conns))
+		for _, uc := range conns {
+			open = append(open, uc)
+		}
+		connsLock.Unlock()
+		for _, uc := range open {
+			_ = uc.Close()
+		}
+		wg.Wait()
+	}()
+
+	buf := make([]byte, 0x10000)
+	for {
+		n, src, err := pc.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			dlog.Infof(ctx, "Error on UDP read: %+v", err)
+			continue
+		}
+		dg := make([]byte, n)
+		copy(dg, buf[:n])
+
+		key := src.String()
+		connsLock.Lock()
+		uc, found := conns[key]
+		if !found {
+			uc = newUDPConn(pc, src, func() {
+				connsLock.Lock()
+				if conns[key] == uc {
+					delete(conns, key)
+				}
+				connsLock.Unlock()
+			})
+			conns[key] = uc
+		}
+		connsLock.Unlock()
+
+		if !found {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := f.forwardConn(uc); err != nil {
+					dlog.Error(ctx, err)
+				}
+			}()
+		}
+		uc.push(dg)
+	}
+}
+
+// udpConn is a net.Conn that represents the datagrams exchanged with one source address on a shared
+// UDP socket. Each Read returns one datagram, and each Write sends one datagram to the source address.
+type udpConn struct {
+	pc        *net.UDPConn
+	src       *net.UDPAddr
+	incoming  chan []byte
+	done      chan struct{}
+	idle      *time.Timer
+	closeOnce sync.Once
+	onClose   func()
+}
+
+func newUDPConn(pc *net.UDPConn, src *net.UDPAddr, onClose func()) *udpConn {
+	uc := &udpConn{
+		pc:       pc,
+		src:      src,
+		incoming: make(chan []byte, udpConnBufferSize),
+		done:     make(chan struct{}),
+		onClose:  onClose,
+	}
+	uc.idle = time.AfterFunc(udpConnTTL, func() { _ = uc.Close() })
+	return uc
+}
+
+// push queues a datagram that arrived from the source address. The datagram is dropped if the queue is full.
+func (uc *udpConn) push(dg []byte) {
+	uc.idle.Reset(udpConnTTL)
+	select {
+	case <-uc.done:
+	case uc.incoming <- dg:
+	default:
+	}
+}
+
+func (uc *udpConn) Read(b []byte) (int, error) {
+	select {
+	case <-uc.done:
+		return 0, io.EOF
+	case dg := <-uc.incoming:
+		return copy(b, dg), nil
+	}
+}
+
+func (uc *udpConn) Write(b []byte) (int, error) {
+	select {
+	case <-uc.done:
+		return 0, net.ErrClosed
+	default:
+	}
+	uc.idle.Reset(udpConnTTL)
+	return uc.pc.WriteToUDP(b, uc.src)
+}
+
+func (uc *udpConn) Close() error {
+	uc.closeOnce.Do(func() {
+		uc.idle.Stop()
+		close(uc.done)
+		if uc.onClose != nil {
+			uc.onClose()
+		}
+	})
+	return nil
+}
+
+func (uc *udpConn) LocalAddr() net.Addr {
+	return uc.pc.LocalAddr()
+}
+
+func (uc *udpConn) RemoteAddr() net.Addr {
+	return uc.src
+}
+
+// Deadlines are not supported. The connection is closed when it has been idle for too long.
+
+func (uc *udpConn) SetDeadline(_ time.Time) error {
+	return nil
+}
+
+func (uc *udpConn) SetReadDeadline(_ time.Time) error {
+	return nil
+}
+
+func (uc *udpConn) SetWriteDeadline(_ time.Time) error {
+	return nil
+}
@@ -0,0 +1,92 @@
+package forwarder
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// udpAddrEchoServer starts a UDP server that responds to each datagram with the address of its sender,
+// and returns the server's port.
+func udpAddrEchoServer(t *testing.T) int32 {
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = pc.Close() })
+	go func() {
+		buf := make([]byte, 0x100)
+		for {
+			_, src, err := pc.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			_, _ = pc.WriteToUDP([]byte(src.String()), src)
+		}
+	}()
+	return int32(pc.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func roundtrip(t *testing.T, conn *net.UDPConn) string {
+	t.Helper()
+	_, err := conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 0x100)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+func TestForwarder_ServeUDP(t *testing.T) {
+	oldTTL := udpConnTTL
+	udpConnTTL = 200 * time.Millisecond
+	defer func() { udpConnTTL = oldTTL }()
+
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	targetPort := udpAddrEchoServer(t)
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	lisAddr := pc.LocalAddr().(*net.UDPAddr)
+
+	f := NewForwarder(&net.TCPAddr{IP: lisAddr.IP, Port: lisAddr.Port}, "127.0.0.1", targetPort)
+	f.mu.Lock()
+	f.initContextsLocked(ctx)
+	f.mu.Unlock()
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		assert.NoError(t, f.ServePacketConn(ctx, pc))
+	}()
+
+	c1, err := net.DialUDP("udp", nil, lisAddr)
+	require.NoError(t, err)
+	defer c1.Close()
+	c2, err := net.DialUDP("udp", nil, lisAddr)
+	require.NoError(t, err)
+	defer c2.Close()
+
+	// Each source gets its own connection to the target, and the connection is kept while in use.
+	a1 := roundtrip(t, c1)
+	a2 := roundtrip(t, c2)
+	assert.NotEqual(t, a1, a2)
+	assert.Equal(t, a1, roundtrip(t, c1))
+	assert.Equal(t, a2, roundtrip(t, c2))
+
+	// An idle connection is closed, so a new one is created for the next datagram.
+	time.Sleep(3 * udpConnTTL)
+	assert.NotEqual(t, a1, roundtrip(t, c1))
+
+	cancel()
+	select {
+	case <-served:
+	case <-time.After(2 * time.Second):
+		t.Fatal("ServePacketConn did not return when its context was cancelled")
+	}
+}
@@ -0,0 +1,249 @@
+package install
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Annotations of a pod template that customize the traffic-agent container that is added to its pods. They take
+// precedence over the defaults that the traffic-manager is configured with.
+const (
+	// AgentResourcesAnnotation holds the JSON of the resource requirements of the traffic-agent container, e.g.
+	// {"requests":{"cpu":"50m","memory":"64Mi"},"limits":{"memory":"128Mi"}}
+	AgentResourcesAnnotation = DomainPrefix + "agent-resources"
+
+	// AgentSecurityContextAnnotation holds the JSON of the security context settings of the traffic-agent
+	// container, e.g. {"runAsNonRoot":true,"readOnlyRootFilesystem":true}
+	AgentSecurityContextAnnotation = DomainPrefix + "agent-security-context"
+
+	// AgentAppProtocolAnnotation holds the appProtocol that is declared by a service port when telepresence
+	// changes its target port to the port of the traffic-agent, e.g. "http" or "kubernetes.io/h2c".
+	AgentAppProtocolAnnotation = DomainPrefix + "agent-app-protocol"
+
+	// IgnorePortsAnnotation holds a comma separated list of the numbers of container ports that the
+	// traffic-agent must never take over, e.g. "9090,15020". The ports are added to the ones that the
+	// traffic-manager ignores by default.
+	IgnorePortsAnnotation = DomainPrefix + "inject-ignore-ports"
+)
+
+// AgentSecurityContext are the settings of the security context of the traffic-agent container that can be
+// configured. The agent always runs as user and group AgentUID.
+type AgentSecurityContext struct {
+	RunAsNonRoot           *bool `json:"runAsNonRoot,omitempty"`
+	ReadOnlyRootFilesystem *bool `json:"readOnlyRootFilesystem,omitempty"`
+}
+
+// AgentConfig is the configuration of the traffic-agent container. The zero value is the default configuration.
+type AgentConfig struct {
+	Resources       *corev1.ResourceRequirements
+	SecurityContext AgentSecurityContext
+	AppProtocol     string
+
+	// IgnorePorts are the sorted numbers of the container ports that the traffic-agent must not take over.
+	IgnorePorts []int32
+}
+
+// ParseAgentResources parses the JSON of the resource requirements of a traffic-agent container. The error of
+// an invalid value names the offending field.
+func ParseAgentResources(s string) (*corev1.ResourceRequirements, error) {
+	var raw map[string]map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &raw); err != nil {
+		return nil, fmt.Errorf("must be a JSON object with requests and/or limits: %w", err)
+	}
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	rr := &corev1.ResourceRequirements{}
+	for _, key := range keys {
+		var rl corev1.ResourceList
+		switch key {
+		case "requests":
+			rr.Requests = make(corev1.ResourceList, len(raw[key]))
+			rl = rr.Requests
+		case "limits":
+			rr.Limits = make(corev1.ResourceList, len(raw[key]))
+			rl = rr.Limits
+		default:
+			return nil, fmt.Errorf("%s: unknown field, must be requests or limits", key)
+		}
+		names := make([]string, 0, len(raw[key]))
+		for name := range raw[key] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if errs := validation.IsQualifiedName(name); len(errs) > 0 {
+				return nil, fmt.Errorf("%s.%s: invalid resource name: %s", key, name, strings.Join(errs, ", "))
+			}
+			v := fmt.Sprint(raw[key][name])
+			q, err := resource.ParseQuantity(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %q is not a valid quantity", key, name, v)
+			}
+			if q.Sign() < 0 {
+				return nil, fmt.Errorf("%s.%s: %q must not be negative", key, name, v)
+			}
+			rl[corev1.ResourceName(name)] = q
+		}
+	}
+	names := make([]string, 0, len(rr.Requests))
+	for name := range rr.Requests {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rq := rr.Requests[corev1.ResourceName(name)]
+		if lq, ok := rr.Limits[corev1.ResourceName(name)]; ok && rq.Cmp(lq) > 0 {
+			return nil, fmt.Errorf("requests.%s: %s must be less than or equal to limits.%s %s", name, rq.String(), name, lq.String())
+		}
+	}
+	return rr, nil
+}
+
+// ParseAgentSecurityContext parses the JSON of the security context settings of a traffic-agent container.
+// The error of an invalid value names the offending field.
+func ParseAgentSecurityContext(s string) (*AgentSecurityContext, error) {
+	dec := json.NewDecoder(bytes.NewReader([]byte(s)))
+	dec.DisallowUnknownFields()
+	var sc AgentSecurityContext
+	if err := dec.Decode(&sc); err != nil {
+		return nil, fmt.Errorf("must be a JSON object with runAsNonRoot and/or readOnlyRootFilesystem: %w", err)
+	}
+	return &sc, nil
+}
+
+// ValidateAppProtocol returns an error if the given string isn't a valid appProtocol of a service port.
+func ValidateAppProtocol(s string) error {
+	if errs := validation.IsQualifiedName(s); len(errs) > 0 {
+		return fmt.Errorf("%q is not a valid appProtocol: %s", s, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// ParseIgnorePorts parses a comma separated list of container port numbers. The returned numbers are sorted
+// and unique, so that the configuration that they render doesn't depend on the order of the list.
+func ParseIgnorePorts(s string) ([]int32, error) {
+	var ports []int32
+	for _, ps := range strings.Split(s, ",") {
+		if ps = strings.TrimSpace(ps); ps == "" {
+			continue
+		}
+		port, err := strconv.Atoi(ps)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("%q is not a valid port number", ps)
+		}
+		ports = append(ports, int32(port))
+	}
+	return mergePorts(nil, ports), nil
+}
+
+// mergePorts returns the sorted union of the given port numbers.
+func mergePorts(a, b []int32) []int32 {
+	if len(a)+len(b) == 0 {
+		return nil
+	}
+	merged := make([]int32, 0, len(a)+len(b))
+	merged = append(append(merged, a...), b...)
+	sort.Slice(merged, func(i, j int) bool { return merged[i] < merged[j] })
+	last := 0
+	for i := 1; i < len(merged); i++ {
+		if merged[i] != merged[last] {
+			last++
+			merged[last] = merged[i]
+		}
+	}
+	return merged[:last+1]
+}
+
+// WithAnnotations returns a copy of this config where the settings of the given pod template annotations
+// take precedence. An invalid annotation is an error that names the annotation and the offending field.
+func (ac *AgentConfig) WithAnnotations(annotations map[string]string) (*AgentConfig, error) {
+	cfg := AgentConfig{}
+	if ac != nil {
+		cfg = *ac
+	}
+	if s, ok := annotations[AgentResourcesAnnotation]; ok {
+		rr, err := ParseAgentResources(s)
+		if err != nil {
+			return nil, fmt.Errorf("annotations[%q]: %w", AgentResourcesAnnotation, err)
+		}
+		cfg.Resources = rr
+	}
+	if s, ok := annotations[AgentSecurityContextAnnotation]; ok {
+		sc, err := ParseAgentSecurityContext(s)
+		if err != nil {
+			return nil, fmt.Errorf("annotations[%q]: %w", AgentSecurityContextAnnotation, err)
+		}
+		if sc.RunAsNonRoot != nil {
+			cfg.SecurityContext.RunAsNonRoot = sc.RunAsNonRoot
+		}
+		if sc.ReadOnlyRootFilesystem != nil {
+			cfg.SecurityContext.ReadOnlyRootFilesystem = sc.ReadOnlyRootFilesystem
+		}
+	}
+	if s, ok := annotations[AgentAppProtocolAnnotation]; ok {
+		if err := ValidateAppProtocol(s); err != nil {
+			return nil, fmt.Errorf("annotations[%q]: %w", AgentAppProtocolAnnotation, err)
+		}
+		cfg.AppProtocol = s
+	}
+	if s, ok := annotations[IgnorePortsAnnotation]; ok {
+		ports, err := ParseIgnorePorts(s)
+		if err != nil {
+			return nil, fmt.Errorf("annotations[%q]: %w", IgnorePortsAnnotation, err)
+		}
+		cfg.IgnorePorts = mergePorts(cfg.IgnorePorts, ports)
+	}
+	return &cfg, nil
+}
+
+// ReadOnlyRootFilesystem returns true if the root filesystem of the traffic-agent container is read-only. The
+// pod must then have the AgentTmpVolume.
+func (ac *AgentConfig) ReadOnlyRootFilesystem() bool {
+	return ac != nil && ac.SecurityContext.ReadOnlyRootFilesystem != nil && *ac.SecurityContext.ReadOnlyRootFilesystem
+}
+
+// IgnoresPort returns true if the traffic-agent must not take over the container port with the given number.
+func (ac *AgentConfig) IgnoresPort(port int32) bool {
+	if ac == nil {
+		return false
+	}
+	i := sort.Search(len(ac.IgnorePorts), func(i int) bool { return ac.IgnorePorts[i] >= port })
+	return i < len(ac.IgnorePorts) && ac.IgnorePorts[i] == port
+}
+
+// apply applies this config to the given traffic-agent container.
+func (ac *AgentConfig) apply(cn *corev1.Container) {
+	if ac == nil {
+		return
+	}
+	if ac.Resources != nil {
+		cn.Resources = *ac.Resources.DeepCopy()
+	}
+	sc := cn.SecurityContext
+	if ac.SecurityContext.RunAsNonRoot != nil {
+		b := *ac.SecurityContext.RunAsNonRoot
+		sc.RunAsNonRoot = &b
+	}
+	if ac.SecurityContext.ReadOnlyRootFilesystem != nil {
+		b := *ac.SecurityContext.ReadOnlyRootFilesystem
+		sc.ReadOnlyRootFilesystem = &b
+	}
+	if ac.ReadOnlyRootFilesystem() {
+		// The agent writes to /tmp
+		cn.VolumeMounts = append(cn.VolumeMounts, corev1.VolumeMount{
+			Name:      AgentTmpVolumeName,
+			MountPath: "/tmp",
+		})
+	}
+}
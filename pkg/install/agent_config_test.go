@@ -0,0 +1,183 @@
+package install
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestAgentContainer_agentConfig(t *testing.T) {
+	appContainer := &corev1.Container{
+		Name:  "echo",
+		Image: "echo:1.0",
+		Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+		},
+	}
+	agent := func(t *testing.T, cfg *AgentConfig) corev1.Container {
+		t.Helper()
+		orig := appContainer.DeepCopy()
+		cn := AgentContainer("echo", "docker.io/datawire/tel2:2.4.5", appContainer, corev1.ContainerPort{ContainerPort: 9900}, 8080, "ambassador", cfg)
+
+		// The app container is never modified
+		assert.Equal(t, orig, appContainer)
+		return cn
+	}
+	bp := func(b bool) *bool { return &b }
+	tmpMount := corev1.VolumeMount{Name: AgentTmpVolumeName, MountPath: "/tmp"}
+
+	t.Run("default", func(t *testing.T) {
+		cn := agent(t, nil)
+		assert.Empty(t, cn.Resources)
+		sc := cn.SecurityContext
+		assert.Equal(t, bp(true), sc.RunAsNonRoot)
+		assert.Equal(t, AgentUID, *sc.RunAsUser)
+		assert.Nil(t, sc.ReadOnlyRootFilesystem)
+		assert.NotContains(t, cn.VolumeMounts, tmpMount)
+	})
+
+	t.Run("limit range", func(t *testing.T) {
+		rr, err := ParseAgentResources(`{"requests":{"cpu":"50m","memory":"64Mi"},"limits":{"cpu":0.5,"memory":"128Mi"}}`)
+		require.NoError(t, err)
+		cn := agent(t, &AgentConfig{Resources: rr})
+		assertQuantity(t, "50m", cn.Resources.Requests[corev1.ResourceCPU])
+		assertQuantity(t, "64Mi", cn.Resources.Requests[corev1.ResourceMemory])
+		assertQuantity(t, "500m", cn.Resources.Limits[corev1.ResourceCPU])
+		assertQuantity(t, "128Mi", cn.Resources.Limits[corev1.ResourceMemory])
+		assert.Equal(t, bp(true), cn.SecurityContext.RunAsNonRoot)
+	})
+
+	t.Run("restricted", func(t *testing.T) {
+		cfg, err := (*AgentConfig)(nil).WithAnnotations(map[string]string{
+			AgentResourcesAnnotation:       `{"limits":{"cpu":"100m","memory":"128Mi"}}`,
+			AgentSecurityContextAnnotation: `{"runAsNonRoot":true,"readOnlyRootFilesystem":true}`,
+		})
+		require.NoError(t, err)
+		assert.True(t, cfg.ReadOnlyRootFilesystem())
+		cn := agent(t, cfg)
+		assertQuantity(t, "128Mi", cn.Resources.Limits[corev1.ResourceMemory])
+		sc := cn.SecurityContext
+		assert.Equal(t, bp(true), sc.RunAsNonRoot)
+		assert.Equal(t, bp(true), sc.ReadOnlyRootFilesystem)
+		assert.Contains(t, cn.VolumeMounts, tmpMount)
+	})
+
+	t.Run("annotations override defaults", func(t *testing.T) {
+		rr, err := ParseAgentResources(`{"requests":{"cpu":"50m"}}`)
+		require.NoError(t, err)
+		dflt := &AgentConfig{Resources: rr, SecurityContext: AgentSecurityContext{ReadOnlyRootFilesystem: bp(true)}}
+		cfg, err := dflt.WithAnnotations(map[string]string{
+			AgentSecurityContextAnnotation: `{"readOnlyRootFilesystem":false}`,
+			AgentAppProtocolAnnotation:     "kubernetes.io/h2c",
+		})
+		require.NoError(t, err)
+		assert.False(t, cfg.ReadOnlyRootFilesystem())
+		assert.Equal(t, "kubernetes.io/h2c", cfg.AppProtocol)
+		assert.True(t, dflt.ReadOnlyRootFilesystem(), "the defaults must not be modified")
+
+		cn := agent(t, cfg)
+		assertQuantity(t, "50m", cn.Resources.Requests[corev1.ResourceCPU])
+		assert.Equal(t, bp(false), cn.SecurityContext.ReadOnlyRootFilesystem)
+		assert.NotContains(t, cn.VolumeMounts, tmpMount)
+	})
+}
+
+func assertQuantity(t *testing.T, expected string, actual resource.Quantity) {
+	t.Helper()
+	q := resource.MustParse(expected)
+	assert.Zero(t, q.Cmp(actual), "expected %s, got %s", expected, actual.String())
+}
+
+func TestAgentConfig_WithAnnotations_invalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    string
+	}{
+		{
+			name:        "not JSON",
+			annotations: map[string]string{AgentResourcesAnnotation: "cpu=50m"},
+			expected:    `annotations["telepresence.getambassador.io/agent-resources"]: must be a JSON object`,
+		},
+		{
+			name:        "unknown resources field",
+			annotations: map[string]string{AgentResourcesAnnotation: `{"request":{"cpu":"50m"}}`},
+			expected:    "request: unknown field, must be requests or limits",
+		},
+		{
+			name:        "invalid quantity",
+			annotations: map[string]string{AgentResourcesAnnotation: `{"limits":{"memory":"128MB"}}`},
+			expected:    `limits.memory: "128MB" is not a valid quantity`,
+		},
+		{
+			name:        "negative quantity",
+			annotations: map[string]string{AgentResourcesAnnotation: `{"requests":{"cpu":"-1"}}`},
+			expected:    `requests.cpu: "-1" must not be negative`,
+		},
+		{
+			name:        "request exceeds limit",
+			annotations: map[string]string{AgentResourcesAnnotation: `{"requests":{"memory":"256Mi"},"limits":{"memory":"128Mi"}}`},
+			expected:    "requests.memory: 256Mi must be less than or equal to limits.memory 128Mi",
+		},
+		{
+			name:        "unknown security context field",
+			annotations: map[string]string{AgentSecurityContextAnnotation: `{"runAsUser":0}`},
+			expected:    `annotations["telepresence.getambassador.io/agent-security-context"]: must be a JSON object with runAsNonRoot and/or readOnlyRootFilesystem: json: unknown field "runAsUser"`,
+		},
+		{
+			name:        "invalid security context value",
+			annotations: map[string]string{AgentSecurityContextAnnotation: `{"readOnlyRootFilesystem":"yes"}`},
+			expected:    "readOnlyRootFilesystem",
+		},
+		{
+			name:        "invalid appProtocol",
+			annotations: map[string]string{AgentAppProtocolAnnotation: "h2c over tls"},
+			expected:    `annotations["telepresence.getambassador.io/agent-app-protocol"]: "h2c over tls" is not a valid appProtocol`,
+		},
+		{
+			name:        "ignored port name",
+			annotations: map[string]string{IgnorePortsAnnotation: "9090,metrics"},
+			expected:    `annotations["telepresence.getambassador.io/inject-ignore-ports"]: "metrics" is not a valid port number`,
+		},
+		{
+			name:        "ignored port out of range",
+			annotations: map[string]string{IgnorePortsAnnotation: "0"},
+			expected:    `"0" is not a valid port number`,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := (&AgentConfig{}).WithAnnotations(tt.annotations)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+}
+
+func TestAgentConfig_WithAnnotations_ignorePorts(t *testing.T) {
+	defaults := &AgentConfig{IgnorePorts: []int32{15020}}
+	cfg, err := defaults.WithAnnotations(map[string]string{IgnorePortsAnnotation: " 9090, 15020,8081 ,9090,"})
+	require.NoError(t, err)
+	assert.Equal(t, []int32{8081, 9090, 15020}, cfg.IgnorePorts, "ports are sorted and unique")
+	assert.Equal(t, []int32{15020}, defaults.IgnorePorts, "the defaults are not modified")
+	for _, port := range []int32{8081, 9090, 15020} {
+		assert.True(t, cfg.IgnoresPort(port), port)
+	}
+	for _, port := range []int32{80, 8080, 9091, 20000} {
+		assert.False(t, cfg.IgnoresPort(port), port)
+	}
+
+	// The order of the list doesn't affect the configuration
+	reordered, err := defaults.WithAnnotations(map[string]string{IgnorePortsAnnotation: "8081,9090"})
+	require.NoError(t, err)
+	assert.Equal(t, cfg, reordered)
+
+	cfg, err = defaults.WithAnnotations(map[string]string{})
+	require.NoError(t, err)
+	assert.Equal(t, []int32{15020}, cfg.IgnorePorts)
+	assert.False(t, (*AgentConfig)(nil).IgnoresPort(15020))
+}
@@ -14,7 +14,8 @@ const EnvPrefix = "_TEL_AGENT_"
 const InitContainerName = "tel-agent-init"
 const AgentUID = int64(7777)
 
-// AgentContainer will return a configured traffic agent
+// AgentContainer will return a configured traffic agent. The given config may be nil, in which case the
+// defaults are used.
 func AgentContainer(
 	name string,
 	imageName string,
@@ -22,8 +23,9 @@ func AgentContainer(
 	port corev1.ContainerPort,
 	appPort int,
 	managerNamespace string,
+	cfg *AgentConfig,
 ) corev1.Container {
-	return corev1.Container{
+	cn := corev1.Container{
 		Name:         AgentContainerName,
 		Image:        imageName,
 		Args:         []string{"agent"},
@@ -44,6 +46,47 @@ func AgentContainer(
 			},
 		},
 	}
+	cfg.apply(&cn)
+	return cn
+}
+
+// AgentManagerNamespace returns the namespace of the traffic-manager that the given agent container
+// connects to, or an empty string if the container doesn't declare it.
+func AgentManagerNamespace(agent *corev1.Container) string {
+	for _, env := range agent.Env {
+		if env.Name == EnvPrefix+"MANAGER_HOST" && strings.HasPrefix(env.Value, ManagerAppName+".") {
+			return strings.TrimPrefix(env.Value, ManagerAppName+".")
+		}
+	}
+	return ""
+}
+
+// AgentName returns the name of the workload that the given agent container belongs to, or an empty string if
+// the container doesn't declare it.
+func AgentName(agent *corev1.Container) string {
+	for _, env := range agent.Env {
+		if env.Name == EnvPrefix+"NAME" {
+			return env.Value
+		}
+	}
+	return ""
+}
+
+// MissingPullSecrets returns the given secret names that the given image pull secrets don't already reference,
+// in the given order and without duplicates.
+func MissingPullSecrets(pullSecrets []corev1.LocalObjectReference, names []string) []string {
+	declared := make(map[string]bool, len(pullSecrets)+len(names))
+	for _, ps := range pullSecrets {
+		declared[ps.Name] = true
+	}
+	var missing []string
+	for _, name := range names {
+		if !declared[name] {
+			declared[name] = true
+			missing = append(missing, name)
+		}
+	}
+	return missing
 }
 
 // InitContainer will return a configured init container for an agent.
@@ -126,6 +169,17 @@ func agentEnvironment(agentName string, appContainer *kates.Container, appPort i
 			Name:  "TELEPRESENCE_MOUNTS",
 			Value: strings.Join(mounts, ":"),
 		})
+
+		// Have the agent report the volumes so that the client can select which ones to mount.
+		volumeMounts := make([]string, len(appContainer.VolumeMounts))
+		for i := range appContainer.VolumeMounts {
+			vm := &appContainer.VolumeMounts[i]
+			volumeMounts[i] = vm.Name + "=" + vm.MountPath
+		}
+		env = append(env, corev1.EnvVar{
+			Name:  EnvPrefix + "APP_VOLUME_MOUNTS",
+			Value: strings.Join(volumeMounts, ":"),
+		})
 	}
 	env = append(env, corev1.EnvVar{
 		Name:  EnvPrefix + "MANAGER_HOST",
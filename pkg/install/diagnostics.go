@@ -0,0 +1,242 @@
+package install
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/datawire/dlib/dcontext"
+	"github.com/datawire/dlib/dlog"
+	"github.com/datawire/dlib/dtime"
+)
+
+const (
+	// maxDiagnostics is the maximum number of messages that are added to an error.
+	maxDiagnostics = 6
+
+	// maxDiagnosticLength is the maximum length of a message that is added to an error.
+	maxDiagnosticLength = 300
+
+	// diagnosticsInterval is how long to wait before the diagnostics of a workload are first collected
+	// while waiting for it. The interval doubles after each collection, up to maxDiagnosticsInterval,
+	// because each collection lists the replica sets, pods, and events of the namespace.
+	diagnosticsInterval = 2 * time.Second
+
+	// maxDiagnosticsInterval is the longest interval between two collections of the diagnostics of a workload.
+	maxDiagnosticsInterval = 30 * time.Second
+)
+
+// WorkloadDiagnostics returns the messages that explain why the pods of the given workload don't become
+// ready. The messages come from the conditions and container statuses of the pods, and from the warning
+// events of the workload, of the replica sets that it owns, and of the pods that they own.
+func WorkloadDiagnostics(c context.Context, client *kates.Client, obj kates.Object) ([]string, error) {
+	ns := obj.GetNamespace()
+	var rss []*kates.ReplicaSet
+	if err := client.List(c, kates.Query{Kind: "ReplicaSet", Namespace: ns}, &rss); err != nil {
+		return nil, err
+	}
+	var pods []*kates.Pod
+	if err := client.List(c, kates.Query{Kind: "Pod", Namespace: ns}, &pods); err != nil {
+		return nil, err
+	}
+	var events []*kates.Event
+	if err := client.List(c, kates.Query{Kind: "Event", Namespace: ns}, &events); err != nil {
+		return nil, err
+	}
+	return workloadDiagnostics(obj.GetUID(), rss, pods, events), nil
+}
+
+// workloadDiagnostics returns the diagnostics of the workload with the given UID, most recent events last.
+// Messages that are repeated, e.g. by an event that reports the condition of a pod, are only included once.
+func workloadDiagnostics(uid types.UID, rss []*kates.ReplicaSet, pods []*kates.Pod, events []*kates.Event) []string {
+	involved := map[types.UID]struct{}{uid: {}}
+	for _, rs := range rss {
+		if ownedBy(rs.OwnerReferences, involved) {
+			involved[rs.UID] = struct{}{}
+		}
+	}
+	var owned []*kates.Pod
+	for _, pod := range pods {
+		if ownedBy(pod.OwnerReferences, involved) {
+			owned = append(owned, pod)
+		}
+	}
+	for _, pod := range owned {
+		involved[pod.UID] = struct{}{}
+	}
+
+	var msgs []string
+	seen := make(map[string]struct{})
+	add := func(subject, reason, message string) {
+		message = strings.TrimSpace(message)
+		key := message
+		if key == "" {
+			key = subject + ": " + reason
+		}
+		if _, ok := seen[key]; ok {
+			return
+		}
+		seen[key] = struct{}{}
+		msgs = append(msgs, truncateDiagnostic(fmt.Sprintf("%s: %s: %s", subject, reason, message)))
+	}
+	for _, pod := range owned {
+		subject := "pod " + pod.Name
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse {
+				add(subject, cond.Reason, cond.Message)
+			}
+		}
+		statuses := make([]corev1.ContainerStatus, 0, len(pod.Status.InitContainerStatuses)+len(pod.Status.ContainerStatuses))
+		statuses = append(statuses, pod.Status.InitContainerStatuses...)
+		statuses = append(statuses, pod.Status.ContainerStatuses...)
+		for _, cs := range statuses {
+			if w := cs.State.Waiting; w != nil && w.Reason != "ContainerCreating" && w.Reason != "PodInitializing" {
+				add(subject+" container "+cs.Name, w.Reason, w.Message)
+			}
+		}
+	}
+
+	warnings := make([]*kates.Event, 0, len(events))
+	for _, ev := range events {
+		if _, ok := involved[ev.InvolvedObject.UID]; ok && ev.Type == corev1.EventTypeWarning {
+			warnings = append(warnings, ev)
+		}
+	}
+	sort.SliceStable(warnings, func(i, j int) bool {
+		return warnings[i].LastTimestamp.Before(&warnings[j].LastTimestamp)
+	})
+	for _, ev := range warnings {
+		add(strings.ToLower(ev.InvolvedObject.Kind)+" "+ev.InvolvedObject.Name, ev.Reason, ev.Message)
+	}
+	return msgs
+}
+
+// ownedBy returns true if one of the given owner references refers to one of the given owners.
+func ownedBy(refs []metav1.OwnerReference, owners map[types.UID]struct{}) bool {
+	for _, ref := range refs {
+		if _, ok := owners[ref.UID]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func truncateDiagnostic(msg string) string {
+	if len(msg) > maxDiagnosticLength {
+		msg = msg[:maxDiagnosticLength-3] + "..."
+	}
+	return msg
+}
+
+// WithDiagnostics returns the given error with the given diagnostics of the workload in the given namespace
+// appended to its message. Only the most recent diagnostics are included, so that a pod that keeps failing
+// doesn't produce an error that is too long to read.
+func WithDiagnostics(err error, namespace string, diagnostics []string) error {
+	if err == nil || len(diagnostics) == 0 {
+		return err
+	}
+	var sb strings.Builder
+	sb.WriteString("; the cluster reports:")
+	if skipped := len(diagnostics) - maxDiagnostics; skipped > 0 {
+		diagnostics = diagnostics[skipped:]
+		fmt.Fprintf(&sb, "\n  (%d earlier messages omitted, use \"kubectl get events -n %s\" to see them)", skipped, namespace)
+	}
+	for _, d := range diagnostics {
+		sb.WriteString("\n  ")
+		sb.WriteString(d)
+	}
+	return fmt.Errorf("%w%s", err, sb.String())
+}
+
+// DiagnosticsWatcher collects the diagnostics of a workload while something waits for it. The diagnostics
+// are collected with an increasing interval, so that they're available when the wait fails, even if the workload has been
+// removed by then, e.g. by the rollback of a failed Helm install.
+type DiagnosticsWatcher struct {
+	client    *kates.Client
+	kind      string
+	namespace string
+	name      string
+	cancel    context.CancelFunc
+	done      chan struct{}
+
+	mu          sync.Mutex
+	diagnostics []string
+}
+
+// WatchDiagnostics starts collecting the diagnostics of the workload with the given kind, namespace, and name.
+// The workload doesn't need to exist yet. The watcher must be stopped using Stop.
+func WatchDiagnostics(c context.Context, client *kates.Client, kind, namespace, name string) *DiagnosticsWatcher {
+	c, cancel := context.WithCancel(c)
+	w := &DiagnosticsWatcher{
+		client:    client,
+		kind:      kind,
+		namespace: namespace,
+		name:      name,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	go func() {
+		defer close(w.done)
+		interval := diagnosticsInterval
+		for {
+			dtime.SleepWithContext(c, interval)
+			if c.Err() != nil {
+				return
+			}
+			w.collect(c)
+			interval *= 2
+			if interval > maxDiagnosticsInterval {
+				interval = maxDiagnosticsInterval
+			}
+		}
+	}()
+	return w
+}
+
+// collect collects the diagnostics of the workload, unless it can't be found.
+func (w *DiagnosticsWatcher) collect(c context.Context) {
+	var objs []*kates.Unstructured
+	if err := w.client.List(c, kates.Query{Kind: w.kind, Namespace: w.namespace}, &objs); err != nil {
+		dlog.Debugf(c, "unable to list %s in namespace %s: %v", w.kind, w.namespace, err)
+		return
+	}
+	for _, obj := range objs {
+		if obj.GetName() != w.name {
+			continue
+		}
+		diagnostics, err := WorkloadDiagnostics(c, w.client, obj)
+		if err != nil {
+			dlog.Debugf(c, "unable to collect the diagnostics of %s %s.%s: %v", w.kind, w.name, w.namespace, err)
+			return
+		}
+		w.mu.Lock()
+		w.diagnostics = diagnostics
+		w.mu.Unlock()
+		return
+	}
+}
+
+// Stop stops the watcher and returns the given error with the diagnostics of the workload appended. The
+// diagnostics are collected one last time if the workload still exists. A nil error is returned as is.
+func (w *DiagnosticsWatcher) Stop(c context.Context, err error) error {
+	w.cancel()
+	<-w.done
+	if err == nil {
+		return nil
+	}
+	// The given context has often timed out at this point
+	c, cancel := context.WithTimeout(dcontext.WithoutCancel(c), 5*time.Second)
+	defer cancel()
+	w.collect(c)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return WithDiagnostics(err, w.namespace, w.diagnostics)
+}
@@ -0,0 +1,144 @@
+package install
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+)
+
+// diagnosticsFixture is a deployment "echo" with a replica set "echo-7d9" and the events of a failure mode.
+type diagnosticsFixture struct {
+	rss    []*kates.ReplicaSet
+	pods   []*kates.Pod
+	events []*kates.Event
+	seq    int
+}
+
+const echoUID = types.UID("echo-uid")
+
+func newDiagnosticsFixture() *diagnosticsFixture {
+	return &diagnosticsFixture{rss: []*kates.ReplicaSet{{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "echo-7d9",
+			UID:             "echo-7d9-uid",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "echo", UID: echoUID}},
+		},
+	}}}
+}
+
+func (f *diagnosticsFixture) addPod(name string, status corev1.PodStatus) {
+	f.pods = append(f.pods, &kates.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			UID:             types.UID(name + "-uid"),
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "echo-7d9", UID: "echo-7d9-uid"}},
+		},
+		Status: status,
+	})
+}
+
+// addEvent adds an event of the given type to the stream. Each event is more recent than the previous one.
+func (f *diagnosticsFixture) addEvent(kind, name, eventType, reason, message string) {
+	f.seq++
+	f.events = append(f.events, &kates.Event{
+		InvolvedObject: corev1.ObjectReference{Kind: kind, Name: name, UID: types.UID(name + "-uid")},
+		Type:           eventType,
+		Reason:         reason,
+		Message:        message,
+		LastTimestamp:  metav1.NewTime(time.Date(2021, 11, 1, 12, 0, f.seq, 0, time.UTC)),
+	})
+}
+
+func (f *diagnosticsFixture) diagnostics() []string {
+	return workloadDiagnostics(echoUID, f.rss, f.pods, f.events)
+}
+
+func TestWorkloadDiagnostics_failedScheduling(t *testing.T) {
+	const msg = "0/3 nodes are available: 1 node(s) had taint {node-role.kubernetes.io/master: }, 2 Insufficient cpu."
+	f := newDiagnosticsFixture()
+	f.addPod("echo-7d9-x2x7q", corev1.PodStatus{
+		Phase: corev1.PodPending,
+		Conditions: []corev1.PodCondition{{
+			Type:    corev1.PodScheduled,
+			Status:  corev1.ConditionFalse,
+			Reason:  corev1.PodReasonUnschedulable,
+			Message: msg,
+		}},
+	})
+	f.addEvent("Pod", "echo-7d9-x2x7q", corev1.EventTypeNormal, "Scheduled", "this is not a warning")
+	f.addEvent("Pod", "echo-7d9-x2x7q", corev1.EventTypeWarning, "FailedScheduling", msg)
+
+	// The event repeats the condition of the pod
+	assert.Equal(t, []string{"pod echo-7d9-x2x7q: Unschedulable: " + msg}, f.diagnostics())
+}
+
+func TestWorkloadDiagnostics_imagePullBackOff(t *testing.T) {
+	const image = "registry.example.com/datawire/tel2:2.4.5"
+	f := newDiagnosticsFixture()
+	f.addPod("echo-7d9-x2x7q", corev1.PodStatus{
+		Phase: corev1.PodPending,
+		ContainerStatuses: []corev1.ContainerStatus{
+			{Name: "echo", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}}},
+			{Name: AgentContainerName, State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{
+				Reason:  "ImagePullBackOff",
+				Message: fmt.Sprintf("Back-off pulling image %q", image),
+			}}},
+		},
+	})
+	f.addEvent("Pod", "echo-7d9-x2x7q", corev1.EventTypeNormal, "Pulling", fmt.Sprintf("Pulling image %q", image))
+	f.addEvent("Pod", "echo-7d9-x2x7q", corev1.EventTypeWarning, "Failed",
+		fmt.Sprintf("Failed to pull image %q: rpc error: code = Unknown desc = unauthorized: authentication required", image))
+	f.addEvent("Pod", "echo-7d9-x2x7q", corev1.EventTypeWarning, "BackOff", fmt.Sprintf("Back-off pulling image %q", image))
+
+	err := WithDiagnostics(errors.New("the agent install timed out"), "default", f.diagnostics())
+	assert.Equal(t, `the agent install timed out; the cluster reports:
+  pod echo-7d9-x2x7q container traffic-agent: ImagePullBackOff: Back-off pulling image "`+image+`"
+  pod echo-7d9-x2x7q: Failed: Failed to pull image "`+image+`": rpc error: code = Unknown desc = unauthorized: authentication required`,
+		err.Error())
+}
+
+func TestWorkloadDiagnostics_quotaExceeded(t *testing.T) {
+	f := newDiagnosticsFixture()
+	f.addEvent("ReplicaSet", "echo-7d9", corev1.EventTypeWarning, "FailedCreate",
+		`Error creating: pods "echo-7d9-x2x7q" is forbidden: exceeded quota: compute-resources, requested: limits.cpu=1100m, used: limits.cpu=3, limited: limits.cpu=4`)
+
+	// Events of other workloads are not included
+	f.addEvent("ReplicaSet", "web-5c4", corev1.EventTypeWarning, "FailedCreate", `Error creating: pods "web-5c4-abcde" is forbidden`)
+
+	err := WithDiagnostics(errors.New("waiting for agent failed"), "default", f.diagnostics())
+	assert.Equal(t, `waiting for agent failed; the cluster reports:
+  replicaset echo-7d9: FailedCreate: Error creating: pods "echo-7d9-x2x7q" is forbidden: exceeded quota: compute-resources, requested: limits.cpu=1100m, used: limits.cpu=3, limited: limits.cpu=4`,
+		err.Error())
+}
+
+func TestWithDiagnostics_truncated(t *testing.T) {
+	f := newDiagnosticsFixture()
+	f.addPod("echo-7d9-x2x7q", corev1.PodStatus{})
+	for i := 0; i < maxDiagnostics+2; i++ {
+		f.addEvent("Pod", "echo-7d9-x2x7q", corev1.EventTypeWarning, "BackOff", fmt.Sprintf("Back-off restarting failed container %d", i))
+	}
+	f.addEvent("Pod", "echo-7d9-x2x7q", corev1.EventTypeWarning, "FailedMount", strings.Repeat("x", 2*maxDiagnosticLength))
+
+	cause := errors.New("the agent install timed out")
+	err := WithDiagnostics(cause, "default", f.diagnostics())
+	assert.ErrorIs(t, err, cause)
+	lines := strings.Split(err.Error(), "\n")
+	require.Len(t, lines, maxDiagnostics+2)
+	assert.Equal(t, `  (3 earlier messages omitted, use "kubectl get events -n default" to see them)`, lines[1])
+	assert.Equal(t, "  pod echo-7d9-x2x7q: BackOff: Back-off restarting failed container 3", lines[2])
+	assert.Len(t, lines[len(lines)-1], 2+maxDiagnosticLength)
+	assert.True(t, strings.HasSuffix(lines[len(lines)-1], "xxx..."))
+
+	assert.Nil(t, WithDiagnostics(nil, "default", f.diagnostics()))
+	assert.Equal(t, cause, WithDiagnostics(cause, "default", nil))
+}
@@ -103,80 +103,117 @@ func FindMatchingService(c context.Context, client *kates.Client, portNameOrNumb
 	return nil, fmt.Errorf("found %s services with a selector matching labels %v%s in namespace %s%s", count, labels, portRef, namespace, suffix)
 }
 
+// describeServicePorts returns a comma separated list of the ports of the given service. Each port is
+// described as <name>/<number>, or just <number> when the port has no name.
+func describeServicePorts(svc *kates.Service) string {
+	descs := make([]string, len(svc.Spec.Ports))
+	for i := range svc.Spec.Ports {
+		port := &svc.Spec.Ports[i]
+		if port.Name != "" {
+			descs[i] = fmt.Sprintf("%s/%d", port.Name, port.Port)
+		} else {
+			descs[i] = strconv.Itoa(int(port.Port))
+		}
+	}
+	return strings.Join(descs, ", ")
+}
+
+// FindServicePort returns the port of the given service that matches portNameOrNumber. An empty
+// portNameOrNumber is only accepted when the service has exactly one port. The error that is returned
+// when no single port can be determined lists the ports that are available.
+func FindServicePort(svc *kates.Service, portNameOrNumber string) (*kates.ServicePort, error) {
+	ports := svcPortByNameOrNumber(svc, portNameOrNumber)
+	switch len(ports) {
+	case 1:
+		return ports[0], nil
+	case 0:
+		if portNameOrNumber == "" {
+			return nil, fmt.Errorf("service %s has no ports", svc.Name)
+		}
+		return nil, fmt.Errorf("service %s has no port with name or number %q. Available ports are: %s",
+			svc.Name, portNameOrNumber, describeServicePorts(svc))
+	default:
+		return nil, fmt.Errorf(`service %s has multiple ports: %s.
+Please specify the Service port you want to intercept by passing the --port=<local port>:<svcPortName or svcPortNumber> flag`,
+			svc.Name, describeServicePorts(svc))
+	}
+}
+
 // FindMatchingPort finds the matching container associated with portNameOrNumber
-// in the given service.
-func FindMatchingPort(cns []corev1.Container, portNameOrNumber string, svc *kates.Service) (
+// in the given service. A non-empty containerName restricts the search to the
+// container with that name, which resolves a target port that is declared by more
+// than one container.
+func FindMatchingPort(cns []corev1.Container, portNameOrNumber, containerName string, svc *kates.Service) (
 	sPort *kates.ServicePort,
 	cn *kates.Container,
 	cPortIndex int,
 	err error,
 ) {
-	// For now, we only support intercepting one port on a given service.
-	ports := svcPortByNameOrNumber(svc, portNameOrNumber)
-	switch numPorts := len(ports); {
-	case numPorts == 0:
-		// this may happen when portNameOrNumber is specified but none of the
-		// ports match
-		return nil, nil, 0, errors.New("found no Service with a port that matches any container in this workload")
-
-	case numPorts > 1:
-		return nil, nil, 0, errors.New(`found matching Service with multiple matching ports.
-Please specify the Service port you want to intercept by passing the --port=local:svcPortName flag.`)
-	default:
+	port, err := FindServicePort(svc, portNameOrNumber)
+	if err != nil {
+		return nil, nil, 0, err
 	}
-	port := ports[0]
-	var matchingServicePort *corev1.ServicePort
-	var matchingContainer *corev1.Container
-	var containerPortIndex int
 
-	if port.TargetPort.Type == intstr.String {
-		portName := port.TargetPort.StrVal
-		for ci := 0; ci < len(cns) && matchingContainer == nil; ci++ {
-			cn := &cns[ci]
-			for pi := range cn.Ports {
-				if cn.Ports[pi].Name == portName {
-					matchingServicePort = port
-					matchingContainer = cn
-					containerPortIndex = pi
-					break
-				}
+	if containerName != "" {
+		ci := -1
+		names := make([]string, len(cns))
+		for i := range cns {
+			names[i] = cns[i].Name
+			if cns[i].Name == containerName {
+				ci = i
 			}
 		}
-	} else {
-		// First see if we have a container with a matching port
-		portNum := port.TargetPort.IntVal
-	containerLoop:
-		for ci := range cns {
-			cn := &cns[ci]
-			for pi := range cn.Ports {
-				if cn.Ports[pi].ContainerPort == portNum {
-					matchingServicePort = port
-					matchingContainer = cn
-					containerPortIndex = pi
-					break containerLoop
-				}
+		if ci < 0 {
+			return nil, nil, 0, fmt.Errorf("found no container named %q in this workload. Available containers are: %s",
+				containerName, strings.Join(names, ", "))
+		}
+		cns = cns[ci : ci+1]
+	}
+
+	// Find all containers that declare the target port. A port that is declared by more than one
+	// container cannot be resolved, because we wouldn't know which container to intercept.
+	var matchingContainers []*corev1.Container
+	var matchingPortIndexes []int
+	for ci := range cns {
+		cn := &cns[ci]
+		for pi := range cn.Ports {
+			cp := &cn.Ports[pi]
+			if port.TargetPort.Type == intstr.String && cp.Name == port.TargetPort.StrVal ||
+				port.TargetPort.Type == intstr.Int && cp.ContainerPort == port.TargetPort.IntVal {
+				matchingContainers = append(matchingContainers, cn)
+				matchingPortIndexes = append(matchingPortIndexes, pi)
+				break
 			}
 		}
+	}
+
+	switch len(matchingContainers) {
+	case 1:
+		return port, matchingContainers[0], matchingPortIndexes[0], nil
+	case 0:
+		if port.TargetPort.Type == intstr.String {
+			return nil, nil, 0, fmt.Errorf("found no container in this workload with a port named %q, which is the target port of service %s",
+				port.TargetPort.StrVal, svc.Name)
+		}
 		// If no container matched, then use the first container with no ports at all. This
 		// enables intercepts of containers that indeed do listen a port but lack a matching
 		// port description in the manifest, which is what you get if you do:
 		//     kubectl create deploy my-deploy --image my-image
 		//     kubectl expose deploy my-deploy --port 80 --target-port 8080
-		if matchingContainer == nil {
-			for ci := range cns {
-				cn := &cns[ci]
-				if len(cn.Ports) == 0 {
-					matchingServicePort = port
-					matchingContainer = cn
-					containerPortIndex = -1
-					break
-				}
+		for ci := range cns {
+			cn := &cns[ci]
+			if len(cn.Ports) == 0 {
+				return port, cn, -1, nil
 			}
 		}
-	}
-
-	if matchingServicePort == nil {
 		return nil, nil, 0, errors.New("found no Service with a port that matches any container in this workload")
+	default:
+		names := make([]string, len(matchingContainers))
+		for i, cn := range matchingContainers {
+			names[i] = cn.Name
+		}
+		return nil, nil, 0, fmt.Errorf(`target port %s of service %s is ambiguous, it is declared by the containers %s.
+Please specify the container you want to intercept by passing the --container=<container name> flag`,
+			port.TargetPort.String(), svc.Name, strings.Join(names, ", "))
 	}
-	return matchingServicePort, matchingContainer, containerPortIndex, nil
 }
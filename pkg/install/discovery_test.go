@@ -0,0 +1,163 @@
+package install
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+)
+
+func testDeployment(containers ...corev1.Container) *kates.Deployment {
+	return &kates.Deployment{
+		TypeMeta:   metav1.TypeMeta{Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: containers},
+			},
+		},
+	}
+}
+
+func testService(ports ...corev1.ServicePort) *kates.Service {
+	return &kates.Service{
+		TypeMeta:   metav1.TypeMeta{Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Ports: ports},
+	}
+}
+
+func TestFindMatchingPort_Named(t *testing.T) {
+	svc := testService(
+		corev1.ServicePort{Name: "http", Port: 80, TargetPort: intstr.FromString("http")},
+		corev1.ServicePort{Name: "grpc", Port: 9090, TargetPort: intstr.FromString("grpc")},
+	)
+	dep := testDeployment(
+		corev1.Container{Name: "web", Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}}},
+		corev1.Container{Name: "api", Ports: []corev1.ContainerPort{
+			{Name: "metrics", ContainerPort: 9100},
+			{Name: "grpc", ContainerPort: 9000},
+		}},
+	)
+	cns := dep.Spec.Template.Spec.Containers
+
+	sp, cn, pi, err := FindMatchingPort(cns, "grpc", "", svc)
+	require.NoError(t, err)
+	assert.Equal(t, int32(9090), sp.Port)
+	assert.Equal(t, "api", cn.Name)
+	assert.Equal(t, 1, pi)
+
+	sp, cn, pi, err = FindMatchingPort(cns, "http", "", svc)
+	require.NoError(t, err)
+	assert.Equal(t, int32(80), sp.Port)
+	assert.Equal(t, "web", cn.Name)
+	assert.Equal(t, 0, pi)
+
+	_, _, _, err = FindMatchingPort(cns, "admin", "", svc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "http/80, grpc/9090")
+}
+
+func TestFindMatchingPort_Numeric(t *testing.T) {
+	svc := testService(
+		corev1.ServicePort{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080)},
+		corev1.ServicePort{Port: 443, TargetPort: intstr.FromInt(8443)},
+	)
+	dep := testDeployment(
+		corev1.Container{Name: "web", Ports: []corev1.ContainerPort{{ContainerPort: 8080}, {ContainerPort: 8443}}},
+	)
+	cns := dep.Spec.Template.Spec.Containers
+
+	sp, cn, pi, err := FindMatchingPort(cns, "443", "", svc)
+	require.NoError(t, err)
+	assert.Equal(t, int32(443), sp.Port)
+	assert.Equal(t, "web", cn.Name)
+	assert.Equal(t, 1, pi)
+
+	// A container without declared ports is used when no container declares the target port
+	dep = testDeployment(corev1.Container{Name: "plain"})
+	sp, cn, pi, err = FindMatchingPort(dep.Spec.Template.Spec.Containers, "80", "", svc)
+	require.NoError(t, err)
+	assert.Equal(t, int32(80), sp.Port)
+	assert.Equal(t, "plain", cn.Name)
+	assert.Equal(t, -1, pi)
+}
+
+func TestFindMatchingPort_NoSelector(t *testing.T) {
+	dep := testDeployment(corev1.Container{Name: "web", Ports: []corev1.ContainerPort{{ContainerPort: 8080}}})
+	cns := dep.Spec.Template.Spec.Containers
+
+	// A single port service doesn't need a selector
+	svc := testService(corev1.ServicePort{Port: 80, TargetPort: intstr.FromInt(8080)})
+	sp, _, _, err := FindMatchingPort(cns, "", "", svc)
+	require.NoError(t, err)
+	assert.Equal(t, int32(80), sp.Port)
+
+	// A multi-port service does, and the error lists the available ports
+	svc = testService(
+		corev1.ServicePort{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080)},
+		corev1.ServicePort{Port: 8081, TargetPort: intstr.FromInt(8081)},
+	)
+	_, _, _, err = FindMatchingPort(cns, "", "", svc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "multiple ports: http/80, 8081")
+}
+
+func TestFindMatchingPort_Ambiguous(t *testing.T) {
+	dep := testDeployment(
+		corev1.Container{Name: "web", Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}}},
+		corev1.Container{Name: "sidecar", Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8081}}},
+	)
+	cns := dep.Spec.Template.Spec.Containers
+
+	svc := testService(corev1.ServicePort{Name: "http", Port: 80, TargetPort: intstr.FromString("http")})
+	_, _, _, err := FindMatchingPort(cns, "http", "", svc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ambiguous")
+	assert.Contains(t, err.Error(), "web, sidecar")
+
+	dep.Spec.Template.Spec.Containers[1].Ports[0].ContainerPort = 8080
+	svc = testService(corev1.ServicePort{Port: 80, TargetPort: intstr.FromInt(8080)})
+	_, _, _, err = FindMatchingPort(cns, "80", "", svc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ambiguous")
+}
+
+func TestFindMatchingPort_ContainerName(t *testing.T) {
+	dep := testDeployment(
+		corev1.Container{Name: "web", Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}}},
+		corev1.Container{Name: "sidecar", Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8081}}},
+		corev1.Container{Name: "worker"},
+	)
+	cns := dep.Spec.Template.Spec.Containers
+
+	// The container name breaks the tie between containers that declare the same port name
+	svc := testService(corev1.ServicePort{Name: "http", Port: 80, TargetPort: intstr.FromString("http")})
+	sp, cn, pi, err := FindMatchingPort(cns, "http", "sidecar", svc)
+	require.NoError(t, err)
+	assert.Equal(t, int32(80), sp.Port)
+	assert.Equal(t, "sidecar", cn.Name)
+	assert.Equal(t, int32(8081), cn.Ports[pi].ContainerPort)
+
+	// The named container must declare the port
+	_, _, _, err = FindMatchingPort(cns, "http", "worker", svc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `port named "http"`)
+
+	// A numeric target port that no container declares is served by the named container
+	svc = testService(corev1.ServicePort{Port: 80, TargetPort: intstr.FromInt(9000)})
+	_, cn, pi, err = FindMatchingPort(cns, "", "worker", svc)
+	require.NoError(t, err)
+	assert.Equal(t, "worker", cn.Name)
+	assert.Equal(t, -1, pi)
+
+	_, _, _, err = FindMatchingPort(cns, "", "db", svc)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `found no container named "db" in this workload. Available containers are: web, sidecar, worker`)
+}
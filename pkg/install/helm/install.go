@@ -8,10 +8,12 @@ import (
 
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
 
 	"github.com/datawire/ambassador/v2/pkg/kates"
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
 )
 
 const helmDriver = "secrets"
@@ -45,29 +47,89 @@ func getValues(ctx context.Context) map[string]interface{} {
 		"systemaPort": cloudConfig.SystemaPort,
 		"createdBy":   releaseOwner,
 	}
+	// The traffic-manager honors the telemetry setting of the client that installs it
+	telemetryEnabled, _ := client.GetTelemetry(ctx)
+	values["telemetry"] = map[string]interface{}{
+		"enabled": telemetryEnabled,
+	}
 	if mxRecvSize := clientConfig.Grpc.MaxReceiveSize; mxRecvSize != nil {
 		values["grpc"] = map[string]interface{}{
 			"maxReceiveSize": mxRecvSize.String(),
 		}
 	}
-	if imgConfig.WebhookAgentImage != "" {
-		parts := strings.Split(imgConfig.WebhookAgentImage, ":")
-		image := imgConfig.WebhookAgentImage
-		tag := ""
-		if len(parts) > 1 {
-			image = parts[0]
-			tag = parts[1]
+
+	// The agent injector uses the webhook images, which default to the images that the client uses
+	webhookRegistry := imgConfig.WebhookRegistry
+	if webhookRegistry == "" {
+		webhookRegistry = imageRegistry
+	}
+	agentImage := map[string]interface{}{
+		"registry": webhookRegistry,
+	}
+	webhookAgentImage := imgConfig.WebhookAgentImage
+	if webhookAgentImage == "" {
+		webhookAgentImage = imgConfig.AgentImage
+	}
+	if webhookAgentImage != "" {
+		agentImage["name"], agentImage["tag"] = splitImageTag(webhookAgentImage)
+	}
+	if secrets := imgConfig.AgentImagePullSecrets; len(secrets) > 0 {
+		pullSecrets := make([]interface{}, len(secrets))
+		for i, secret := range secrets {
+			pullSecrets[i] = map[string]interface{}{"name": secret}
+		}
+		agentImage["pullSecrets"] = pullSecrets
+	}
+	values["agentInjector"] = map[string]interface{}{
+		"agentImage": agentImage,
+	}
+	return values
+}
+
+// splitImageTag splits the given image into its name and its tag. A colon that precedes the last slash
+// separates the host and port of a registry rather than a tag.
+func splitImageTag(image string) (name, tag string) {
+	if i := strings.LastIndexByte(image, ':'); i > strings.LastIndexByte(image, '/') {
+		return image[:i], image[i+1:]
+	}
+	return image, ""
+}
+
+// agentImageRef returns the reference of the agent image that the agent injector uses given the values of
+// a release, followed by the names of its pull secrets. Values that are absent or empty are replaced with
+// the chart defaults, and an empty tag with the given appVersion, so that values that denote the same image
+// give the same reference regardless of how they were stored.
+func agentImageRef(vals map[string]interface{}, appVersion string) string {
+	var agentImage map[string]interface{}
+	if ai, ok := vals["agentInjector"].(map[string]interface{}); ok {
+		agentImage, _ = ai["agentImage"].(map[string]interface{})
+	}
+	value := func(key, dflt string) string {
+		if v, ok := agentImage[key].(string); ok && v != "" {
+			return v
 		}
-		values["agentInjector"] = map[string]interface{}{
-			"agentImage": map[string]interface{}{
-				"registry": imgConfig.WebhookRegistry,
-				"name":     image,
-				"tag":      tag,
-			},
+		return dflt
+	}
+	ref := value("registry", client.DefaultRegistry) + "/" + value("name", "tel2") + ":" + value("tag", appVersion)
+	if secrets, ok := agentImage["pullSecrets"].([]interface{}); ok {
+		for _, secret := range secrets {
+			if sm, ok := secret.(map[string]interface{}); ok {
+				ref += fmt.Sprintf(" %v", sm["name"])
+			}
 		}
 	}
+	return ref
+}
 
-	return values
+// agentImageChanged returns true if the agent image of the agent injector of the given release differs from
+// the one in the given values. An empty tag means the appVersion of the release in both cases, because a
+// change of the version is decided by shouldUpgradeRelease.
+func agentImageChanged(rel *release.Release, vals map[string]interface{}) bool {
+	appVersion := ""
+	if rel.Chart != nil && rel.Chart.Metadata != nil {
+		appVersion = rel.Chart.Metadata.AppVersion
+	}
+	return agentImageRef(rel.Config, appVersion) != agentImageRef(vals, appVersion)
 }
 
 func timedRun(ctx context.Context, run func(time.Duration) error) error {
@@ -91,8 +153,8 @@ func timedRun(ctx context.Context, run func(time.Duration) error) error {
 	}
 }
 
-func installNew(ctx context.Context, chrt *chart.Chart, helmConfig *action.Configuration, namespace string) error {
-	dlog.Infof(ctx, "No existing Traffic Manager found, installing %s...", client.Version())
+func installNew(ctx context.Context, chrt *chart.Chart, helmConfig *action.Configuration, namespace string, vals map[string]interface{}) error {
+	dlog.Infof(ctx, "No existing Traffic Manager found in namespace %s, installing %s...", namespace, client.Version())
 	install := action.NewInstall(helmConfig)
 	install.ReleaseName = releaseName
 	install.Namespace = namespace
@@ -100,19 +162,19 @@ func installNew(ctx context.Context, chrt *chart.Chart, helmConfig *action.Confi
 	install.CreateNamespace = true
 	return timedRun(ctx, func(timeout time.Duration) error {
 		install.Timeout = timeout
-		_, err := install.Run(chrt, getValues(ctx))
+		_, err := install.Run(chrt, vals)
 		return err
 	})
 }
 
-func upgradeExisting(ctx context.Context, existingVer string, chrt *chart.Chart, helmConfig *action.Configuration, namespace string) error {
-	dlog.Infof(ctx, "Existing Traffic Manager %s found, upgrading to %s...", existingVer, client.Version())
+func upgradeExisting(ctx context.Context, existingVer string, chrt *chart.Chart, helmConfig *action.Configuration, namespace string, vals map[string]interface{}) error {
+	dlog.Infof(ctx, "Existing Traffic Manager %s found in namespace %s, upgrading to %s...", existingVer, namespace, client.Version())
 	upgrade := action.NewUpgrade(helmConfig)
 	upgrade.Atomic = true
 	upgrade.Namespace = namespace
 	return timedRun(ctx, func(timeout time.Duration) error {
 		upgrade.Timeout = timeout
-		_, err := upgrade.Run(releaseName, chrt, getValues(ctx))
+		_, err := upgrade.Run(releaseName, chrt, vals)
 		return err
 	})
 }
@@ -127,12 +189,50 @@ func uninstallExisting(ctx context.Context, helmConfig *action.Configuration, na
 	})
 }
 
-// EnsureTrafficManager ensures the traffic manager is installed
-func EnsureTrafficManager(ctx context.Context, configFlags *kates.ConfigFlags, client *kates.Client, namespace string) error {
+// importLegacyObjects is a variable so that tests can install without a cluster.
+var importLegacyObjects = importLegacy
+
+// EnsureTrafficManager ensures the traffic manager is installed. The given values override the chart defaults
+// and the values that were given when the traffic manager was installed or last upgraded.
+func EnsureTrafficManager(ctx context.Context, configFlags *kates.ConfigFlags, client *kates.Client, namespace string, vals map[string]interface{}) error {
 	helmConfig, err := getHelmConfig(ctx, configFlags, namespace)
 	if err != nil {
 		return fmt.Errorf("failed to initialize helm config: %w", err)
 	}
+	store := configMapValues{client: client, namespace: namespace}
+	modified, err := ensureTrafficManager(ctx, helmConfig, client, store, namespace, vals)
+	if modified {
+		// We've just modified the resources totally outside of the kates client, so invalidate the cache to make sure
+		// it'll return fresh resources
+		client.InvalidateCache()
+	}
+	return err
+}
+
+// ensureTrafficManager installs or upgrades the traffic manager release in the given namespace. The returned
+// bool is true if the release was modified. A traffic manager that is found in the namespace but isn't owned
+// by the cli is used as is.
+//
+// The release is upgraded when the cli is newer, when values are given, or when the client config asks for
+// another agent image than the one that the agent injector of the release uses. The values are merged with those
+// recorded in the given store, and the result is recorded once the release is installed or upgraded.
+func ensureTrafficManager(
+	ctx context.Context,
+	helmConfig *action.Configuration,
+	client *kates.Client,
+	store valuesStore,
+	namespace string,
+	vals map[string]interface{},
+) (bool, error) {
+	chrt, err := loadChart()
+	if err != nil {
+		return false, fmt.Errorf("unable to load built-in helm chart: %w", err)
+	}
+	// Fail before anything is applied
+	if err = validateValues(chrt, vals); err != nil {
+		return false, err
+	}
+
 	existing, err := getHelmRelease(ctx, helmConfig)
 	if err != nil {
 		// If we weren't able to get the helm release at all, there's no hope for installing it
@@ -140,56 +240,77 @@ func EnsureTrafficManager(ctx context.Context, configFlags *kates.ConfigFlags, c
 		// kind of issue communicating with kubernetes. Let's hope it's the former and let's hope the traffic manager
 		// is already set up. If it's the latter case (or the traffic manager isn't there), we'll be alerted by
 		// a subsequent error anyway.
-		dlog.Errorf(ctx, "Unable to look for existing helm release: %v. Assuming it's there and continuing...", err)
-		return nil
+		dlog.Errorf(ctx, "Unable to look for existing helm release in namespace %s: %v. Assuming it's there and continuing...", namespace, err)
+		return false, nil
 	}
 
-	chrt, err := loadChart()
-	if err != nil {
-		return fmt.Errorf("unable to load built-in helm chart: %w", err)
-	}
 	// Under various conditions, helm can leave the release history hanging around after the release is gone.
 	// In those cases, an uninstall should clean everything up and leave us ready to install again
 	if existing != nil && shouldManageRelease(ctx, existing) && releaseNeedsCleanup(ctx, existing) {
 		err := uninstallExisting(ctx, helmConfig, namespace)
 		if err != nil {
-			return fmt.Errorf("failed to clean up leftover release history: %w", err)
+			return false, fmt.Errorf("failed to clean up leftover release history: %w", err)
 		}
 		existing = nil
 	}
 	if existing == nil {
-		err := importLegacy(ctx, namespace, client)
+		err := importLegacyObjects(ctx, namespace, client)
 		if err != nil {
 			// Similarly to the error check for getHelmRelease, this could happen because of missing permissions,
 			// or a different k8s error. We don't want to block on permissions failures, so let's log and hope.
 			dlog.Errorf(ctx, "Unable to import existing k8s resources: %v. Assuming traffic-manager is setup and continuing...", err)
-			return nil
+			return false, nil
 		}
 
-		err = installNew(ctx, chrt, helmConfig, namespace)
-		if err != nil {
-			return err
+		// Values recorded by a release that no longer exists are not reused
+		if err = installNew(ctx, chrt, helmConfig, namespace, releaseValues(ctx, nil, vals)); err != nil {
+			return false, err
 		}
-		// We've just modified the resources totally outside of the kates client, so invalidate the cache to make sure
-		// it'll return fresh resources
-		client.InvalidateCache()
-		return nil
+		recordValues(ctx, store, vals)
+		return true, nil
 	}
 	ver := releaseVer(existing)
-	if shouldManageRelease(ctx, existing) && shouldUpgradeRelease(ctx, existing) {
-		err = upgradeExisting(ctx, ver, chrt, helmConfig, namespace)
+	if !shouldManageRelease(ctx, existing) {
+		if len(vals) > 0 {
+			return false, errcat.User.Newf(
+				"the traffic-manager in namespace %s was not installed by telepresence, so the given Helm values cannot be applied", namespace)
+		}
+	} else {
+		recorded, err := store.Load(ctx)
 		if err != nil {
-			return err
+			return false, fmt.Errorf("unable to load the values of the traffic-manager in namespace %s: %w", namespace, err)
+		}
+		merged := mergeValues(recorded, vals)
+		relVals := releaseValues(ctx, nil, merged)
+		if len(vals) > 0 || shouldUpgradeRelease(ctx, existing) || agentImageChanged(existing, relVals) {
+			if err = upgradeExisting(ctx, ver, chrt, helmConfig, namespace, relVals); err != nil {
+				return false, err
+			}
+			recordValues(ctx, store, merged)
+			return true, nil
 		}
-		client.InvalidateCache()
-		return nil
 	}
-	dlog.Infof(ctx, "Existing Traffic Manager %s not owned by cli or does not need upgrade, will not modify", ver)
-	return nil
+	dlog.Infof(ctx, "Existing Traffic Manager %s in namespace %s not owned by cli or does not need upgrade, will not modify", ver, namespace)
+	return false, nil
 }
 
-// DeleteTrafficManager deletes the traffic manager
-func DeleteTrafficManager(ctx context.Context, configFlags *kates.ConfigFlags, namespace string) error {
+// recordValues records the given values in the store, or removes the recorded values when none are given.
+// The release is already installed at this point, so a failure is logged rather than returned.
+func recordValues(ctx context.Context, store valuesStore, vals map[string]interface{}) {
+	var err error
+	if len(vals) > 0 {
+		err = store.Save(ctx, vals)
+	} else {
+		err = store.Delete(ctx)
+	}
+	if err != nil {
+		dlog.Errorf(ctx, "Unable to record the values of the traffic-manager; they will not be reused by upgrades: %v", err)
+	}
+}
+
+// DeleteTrafficManager deletes the traffic manager, together with the values that were recorded when it
+// was installed or upgraded.
+func DeleteTrafficManager(ctx context.Context, configFlags *kates.ConfigFlags, client *kates.Client, namespace string) error {
 	helmConfig, err := getHelmConfig(ctx, configFlags, namespace)
 	if err != nil {
 		return fmt.Errorf("failed to initialize helm config: %w", err)
@@ -203,5 +324,11 @@ func DeleteTrafficManager(ctx context.Context, configFlags *kates.ConfigFlags, n
 		dlog.Info(ctx, "Traffic Manager already deleted or not owned by cli, will not uninstall")
 		return nil
 	}
-	return uninstallExisting(ctx, helmConfig, namespace)
+	if err = uninstallExisting(ctx, helmConfig, namespace); err != nil {
+		return err
+	}
+	if err = (configMapValues{client: client, namespace: namespace}).Delete(ctx); err != nil {
+		dlog.Errorf(ctx, "Unable to delete the recorded values of the traffic-manager: %v", err)
+	}
+	return nil
 }
@@ -0,0 +1,261 @@
+package helm
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// fakeHelmConfig returns a helm configuration that stores its releases in memory and doesn't talk to a cluster.
+func fakeHelmConfig(t *testing.T, namespace string) *action.Configuration {
+	mem := driver.NewMemory()
+	mem.SetNamespace(namespace)
+	return &action.Configuration{
+		Releases:     storage.Init(mem),
+		KubeClient:   &kubefake.PrintingKubeClient{Out: io.Discard},
+		Capabilities: chartutil.DefaultCapabilities,
+		Log:          t.Logf,
+	}
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx := client.WithEnv(dlog.NewTestContext(t, false), &client.Env{Registry: client.DefaultRegistry})
+	cfg := client.GetDefaultConfig(ctx)
+	return client.WithConfig(ctx, &cfg)
+}
+
+func noLegacyObjects(t *testing.T) {
+	saved := importLegacyObjects
+	importLegacyObjects = func(context.Context, string, *kates.Client) error { return nil }
+	t.Cleanup(func() { importLegacyObjects = saved })
+}
+
+func Test_ensureTrafficManager_install(t *testing.T) {
+	noLegacyObjects(t)
+	ctx := testContext(t)
+	helmConfig := fakeHelmConfig(t, "team-tp")
+
+	modified, err := ensureTrafficManager(ctx, helmConfig, nil, &memoryValues{}, "team-tp", nil)
+	require.NoError(t, err)
+	assert.True(t, modified)
+
+	rel, err := getHelmRelease(ctx, helmConfig)
+	require.NoError(t, err)
+	require.NotNil(t, rel)
+	assert.Equal(t, "team-tp", rel.Namespace)
+
+	// The namespaced objects are created in the manager namespace, and the names of the cluster-wide ones
+	// are unique to it, so that managers in other namespaces don't interfere.
+	assert.Contains(t, rel.Manifest, "namespace: team-tp")
+	assert.NotContains(t, rel.Manifest, "namespace: ambassador")
+	assert.Contains(t, rel.Manifest, "name: traffic-manager-team-tp")
+}
+
+func Test_ensureTrafficManager_namespaced(t *testing.T) {
+	noLegacyObjects(t)
+	ctx := testContext(t)
+	helmConfig := fakeHelmConfig(t, "team-tp")
+
+	_, err := ensureTrafficManager(ctx, helmConfig, nil, &memoryValues{}, "team-tp", map[string]interface{}{
+		"managerRbac": map[string]interface{}{
+			"namespaced": true,
+			"namespaces": []interface{}{"team-tp", "blue"},
+		},
+	})
+	require.NoError(t, err)
+	rel, err := getHelmRelease(ctx, helmConfig)
+	require.NoError(t, err)
+	require.NotNil(t, rel)
+
+	// The traffic-manager is told which namespaces it manages
+	assert.Contains(t, rel.Manifest, "- name: MANAGED_NAMESPACES\n            value: \"team-tp blue\"\n")
+	assert.Contains(t, rel.Manifest, "kind: Role\n")
+}
+
+func Test_ensureTrafficManager_telemetry(t *testing.T) {
+	noLegacyObjects(t)
+	const scoutDisable = "- name: SCOUT_DISABLE\n            value: \"1\"\n"
+	enabled, disabled := true, false
+	tests := []struct {
+		name        string
+		scout       string
+		enabled     *bool
+		expectedEnv bool
+	}{
+		{"default", "", nil, false},
+		{"disabled by config", "", &disabled, true},
+		{"disabled by environment", "yes", &enabled, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := testContext(t)
+			env := *client.GetEnv(ctx)
+			env.ScoutDisable = tt.scout
+			ctx = client.WithEnv(ctx, &env)
+			cfg := *client.GetConfig(ctx)
+			cfg.Telemetry.Enabled = tt.enabled
+			ctx = client.WithConfig(ctx, &cfg)
+			helmConfig := fakeHelmConfig(t, "team-tp")
+
+			_, err := ensureTrafficManager(ctx, helmConfig, nil, &memoryValues{}, "team-tp", nil)
+			require.NoError(t, err)
+			rel, err := getHelmRelease(ctx, helmConfig)
+			require.NoError(t, err)
+			require.NotNil(t, rel)
+			if tt.expectedEnv {
+				assert.Contains(t, rel.Manifest, scoutDisable)
+			} else {
+				assert.NotContains(t, rel.Manifest, "SCOUT_DISABLE")
+			}
+		})
+	}
+}
+
+func Test_ensureTrafficManager_lookupOnly(t *testing.T) {
+	noLegacyObjects(t)
+	ctx := testContext(t)
+	helmConfig := fakeHelmConfig(t, "team-tp")
+
+	// A traffic-manager that was installed by someone else is used as is, even when it's older.
+	chrt, err := loadChart()
+	require.NoError(t, err)
+	chrt.Metadata.Version = "2.0.0"
+	existing := &release.Release{
+		Name:      releaseName,
+		Namespace: "team-tp",
+		Version:   1,
+		Chart:     chrt,
+		Config:    map[string]interface{}{"createdBy": "ops-team"},
+		Info:      &release.Info{Status: release.StatusDeployed},
+	}
+	require.NoError(t, helmConfig.Releases.Create(existing))
+
+	modified, err := ensureTrafficManager(ctx, helmConfig, nil, &memoryValues{}, "team-tp", nil)
+	require.NoError(t, err)
+	assert.False(t, modified)
+
+	rel, err := getHelmRelease(ctx, helmConfig)
+	require.NoError(t, err)
+	require.NotNil(t, rel)
+	assert.Equal(t, 1, rel.Version)
+	assert.Equal(t, "2.0.0", releaseVer(rel))
+
+	// Values can't be applied to it
+	_, err = ensureTrafficManager(ctx, helmConfig, nil, &memoryValues{}, "team-tp", map[string]interface{}{"logLevel": "debug"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "was not installed by telepresence")
+}
+
+func Test_getValues_agentImage(t *testing.T) {
+	ctx := testContext(t)
+	agentImage := func(ctx context.Context) interface{} {
+		return getValues(ctx)["agentInjector"].(map[string]interface{})["agentImage"]
+	}
+
+	// The chart default is used unless an image is configured
+	assert.Equal(t, map[string]interface{}{"registry": client.DefaultRegistry}, agentImage(ctx))
+
+	// The webhook images default to the images of the client
+	cfg := *client.GetConfig(ctx)
+	cfg.Images.Registry = "localhost:5000"
+	cfg.Images.AgentImage = "tel2:custom"
+	ctx = client.WithConfig(ctx, &cfg)
+	assert.Equal(t, map[string]interface{}{"registry": "localhost:5000", "name": "tel2", "tag": "custom"}, agentImage(ctx))
+
+	cfg.Images.WebhookRegistry = "webhook.example.com"
+	cfg.Images.WebhookAgentImage = "tel2-webhook:1.0"
+	ctx = client.WithConfig(ctx, &cfg)
+	assert.Equal(t, map[string]interface{}{"registry": "webhook.example.com", "name": "tel2-webhook", "tag": "1.0"}, agentImage(ctx))
+
+	cfg.Images.AgentImagePullSecrets = []string{"registry-creds"}
+	ctx = client.WithConfig(ctx, &cfg)
+	assert.Equal(t, []interface{}{map[string]interface{}{"name": "registry-creds"}}, agentImage(ctx).(map[string]interface{})["pullSecrets"])
+
+	// The port of a registry is not a tag
+	cfg.Images.WebhookAgentImage = "localhost:5000/tel2"
+	ctx = client.WithConfig(ctx, &cfg)
+	assert.Equal(t, "localhost:5000/tel2", agentImage(ctx).(map[string]interface{})["name"])
+	assert.Equal(t, "", agentImage(ctx).(map[string]interface{})["tag"])
+}
+
+func Test_agentImageChanged(t *testing.T) {
+	rel := func(agentImage map[string]interface{}) *release.Release {
+		r := &release.Release{Chart: &chart.Chart{Metadata: &chart.Metadata{AppVersion: "2.5.0"}}, Config: map[string]interface{}{}}
+		if agentImage != nil {
+			r.Config["agentInjector"] = map[string]interface{}{"agentImage": agentImage}
+		}
+		return r
+	}
+	vals := func(agentImage map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"agentInjector": map[string]interface{}{"agentImage": agentImage}}
+	}
+
+	// Different representations of the chart default
+	dflt := vals(map[string]interface{}{"registry": client.DefaultRegistry})
+	assert.False(t, agentImageChanged(rel(nil), dflt))
+	assert.False(t, agentImageChanged(rel(map[string]interface{}{"registry": client.DefaultRegistry, "name": "tel2", "tag": ""}), dflt))
+	assert.False(t, agentImageChanged(rel(map[string]interface{}{"registry": client.DefaultRegistry, "pullSecrets": []interface{}{}}), dflt))
+	assert.False(t, agentImageChanged(rel(map[string]interface{}{"registry": client.DefaultRegistry, "tag": "2.5.0"}), dflt))
+
+	assert.True(t, agentImageChanged(rel(nil), vals(map[string]interface{}{"registry": "localhost:5000"})))
+	assert.True(t, agentImageChanged(rel(nil), vals(map[string]interface{}{"name": "tel2", "tag": "custom"})))
+	assert.True(t, agentImageChanged(rel(nil), vals(map[string]interface{}{
+		"pullSecrets": []interface{}{map[string]interface{}{"name": "registry-creds"}},
+	})))
+	assert.False(t, agentImageChanged(
+		rel(map[string]interface{}{"pullSecrets": []interface{}{map[string]interface{}{"name": "registry-creds"}}}),
+		vals(map[string]interface{}{"pullSecrets": []interface{}{map[string]interface{}{"name": "registry-creds"}}})))
+}
+
+func Test_ensureTrafficManager_agentImage(t *testing.T) {
+	noLegacyObjects(t)
+	ctx := testContext(t)
+	helmConfig := fakeHelmConfig(t, "team-tp")
+
+	// An owned release of the same version as the cli
+	chrt, err := loadChart()
+	require.NoError(t, err)
+	chrt.Metadata.Version = client.Semver().String()
+	existing := &release.Release{
+		Name:      releaseName,
+		Namespace: "team-tp",
+		Version:   1,
+		Chart:     chrt,
+		Config:    releaseValues(ctx, nil, nil),
+		Info:      &release.Info{Status: release.StatusDeployed},
+	}
+	require.NoError(t, helmConfig.Releases.Create(existing))
+
+	modified, err := ensureTrafficManager(ctx, helmConfig, nil, &memoryValues{}, "team-tp", nil)
+	require.NoError(t, err)
+	assert.False(t, modified)
+
+	// The release is upgraded when the client config asks for another agent image
+	cfg := *client.GetConfig(ctx)
+	cfg.Images.Registry = "localhost:5000"
+	ctx = client.WithConfig(ctx, &cfg)
+	modified, err = ensureTrafficManager(ctx, helmConfig, nil, &memoryValues{}, "team-tp", nil)
+	require.NoError(t, err)
+	assert.True(t, modified)
+
+	rel, err := getHelmRelease(ctx, helmConfig)
+	require.NoError(t, err)
+	require.NotNil(t, rel)
+	assert.Equal(t, 2, rel.Version)
+	assert.Contains(t, rel.Manifest, "- name: TELEPRESENCE_REGISTRY\n            value: localhost:5000\n")
+}
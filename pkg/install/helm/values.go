@@ -0,0 +1,178 @@
+package helm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/getter"
+	"sigs.k8s.io/yaml"
+
+	"github.com/datawire/ambassador/v2/pkg/kates"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// valuesConfigMapName is the name of the ConfigMap in the manager namespace that records the Helm values
+// that were given when the traffic-manager was installed or upgraded.
+const valuesConfigMapName = "traffic-manager-values"
+
+const valuesConfigMapKey = "values.yaml"
+
+// extraValueKeys are top-level keys that the templates of the chart use, but that have no default in its
+// values.yaml.
+var extraValueKeys = map[string]bool{"replicaCount": true}
+
+// ParseValues merges the given values files and "key=value" assignments, in that order, into one map of
+// Helm values, just like the --values and --set flags of the helm command do.
+func ParseValues(valueFiles, setValues []string) (map[string]interface{}, error) {
+	opts := values.Options{ValueFiles: valueFiles, Values: setValues}
+	vals, err := opts.MergeValues(getter.Providers{})
+	if err != nil {
+		return nil, errcat.User.New(err)
+	}
+	return vals, nil
+}
+
+// ValidateValues returns an error if the given values contain keys that the chart of the traffic-manager
+// doesn't declare.
+func ValidateValues(vals map[string]interface{}) error {
+	chrt, err := loadChart()
+	if err != nil {
+		return fmt.Errorf("unable to load built-in helm chart: %w", err)
+	}
+	return validateValues(chrt, vals)
+}
+
+func validateValues(chrt *chart.Chart, vals map[string]interface{}) error {
+	if invalid := invalidKeys("", vals, chrt.Values); len(invalid) > 0 {
+		return errcat.User.Newf("the traffic-manager chart has no value(s) %s", strings.Join(invalid, ", "))
+	}
+	return nil
+}
+
+func invalidKeys(prefix string, vals, defaults map[string]interface{}) []string {
+	keys := make([]string, 0, len(vals))
+	for k := range vals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var invalid []string
+	for _, k := range keys {
+		d, ok := defaults[k]
+		if !ok {
+			if !(prefix == "" && extraValueKeys[k]) {
+				invalid = append(invalid, prefix+k)
+			}
+			continue
+		}
+		// The keys of maps that are empty by default, like nodeSelector or resources, can be anything
+		if vm, ok := vals[k].(map[string]interface{}); ok {
+			if dm, ok := d.(map[string]interface{}); ok && len(dm) > 0 {
+				invalid = append(invalid, invalidKeys(prefix+k+".", vm, dm)...)
+			}
+		}
+	}
+	return invalid
+}
+
+// mergeValues returns a deep merge of the given values, where the values of later maps take precedence.
+// The given maps are not modified.
+func mergeValues(maps ...map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	for _, m := range maps {
+		for k, v := range m {
+			if vm, ok := v.(map[string]interface{}); ok {
+				if om, ok := out[k].(map[string]interface{}); ok {
+					out[k] = mergeValues(om, vm)
+					continue
+				}
+				v = mergeValues(vm)
+			}
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// releaseValues returns the values of the release. The values that the client config controls are overridden
+// by the values recorded by a previous install or upgrade, which in turn are overridden by the given values.
+// The chart defaults apply to all values that aren't set.
+func releaseValues(ctx context.Context, recorded, vals map[string]interface{}) map[string]interface{} {
+	return mergeValues(getValues(ctx), recorded, vals)
+}
+
+// valuesStore records the values that were given when the traffic-manager was installed or upgraded, so
+// that subsequent upgrades reuse them.
+type valuesStore interface {
+	// Load returns the recorded values, or nil when no values are recorded
+	Load(ctx context.Context) (map[string]interface{}, error)
+
+	// Save records the given values
+	Save(ctx context.Context, vals map[string]interface{}) error
+
+	// Delete removes the recorded values
+	Delete(ctx context.Context) error
+}
+
+// configMapValues is a valuesStore that records the values in a ConfigMap in the manager namespace.
+type configMapValues struct {
+	client    *kates.Client
+	namespace string
+}
+
+func (s configMapValues) configMap() *kates.ConfigMap {
+	return &kates.ConfigMap{
+		TypeMeta: kates.TypeMeta{
+			Kind:       "ConfigMap",
+			APIVersion: "v1",
+		},
+		ObjectMeta: kates.ObjectMeta{
+			Namespace: s.namespace,
+			Name:      valuesConfigMapName,
+			Labels:    map[string]string{"app.kubernetes.io/created-by": releaseOwner},
+		},
+	}
+}
+
+func (s configMapValues) Load(ctx context.Context) (map[string]interface{}, error) {
+	cm := s.configMap()
+	if err := s.client.Get(ctx, cm, cm); err != nil {
+		if kates.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var vals map[string]interface{}
+	if err := yaml.Unmarshal([]byte(cm.Data[valuesConfigMapKey]), &vals); err != nil {
+		return nil, fmt.Errorf("unable to parse %s in ConfigMap %s.%s: %w", valuesConfigMapKey, valuesConfigMapName, s.namespace, err)
+	}
+	return vals, nil
+}
+
+func (s configMapValues) Save(ctx context.Context, vals map[string]interface{}) error {
+	data, err := yaml.Marshal(vals)
+	if err != nil {
+		return err
+	}
+	cm := s.configMap()
+	if err = s.client.Get(ctx, cm, cm); err != nil {
+		if !kates.IsNotFound(err) {
+			return err
+		}
+		cm.Data = map[string]string{valuesConfigMapKey: string(data)}
+		return s.client.Create(ctx, cm, nil)
+	}
+	cm.Data = map[string]string{valuesConfigMapKey: string(data)}
+	return s.client.Update(ctx, cm, nil)
+}
+
+func (s configMapValues) Delete(ctx context.Context) error {
+	if err := s.client.Delete(ctx, s.configMap(), nil); err != nil && !kates.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
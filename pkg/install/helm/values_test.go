@@ -0,0 +1,149 @@
+package helm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/action"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/errcat"
+)
+
+// memoryValues is a valuesStore that keeps the values in memory.
+type memoryValues struct {
+	vals map[string]interface{}
+}
+
+func (s *memoryValues) Load(context.Context) (map[string]interface{}, error) {
+	return s.vals, nil
+}
+
+func (s *memoryValues) Save(_ context.Context, vals map[string]interface{}) error {
+	s.vals = vals
+	return nil
+}
+
+func (s *memoryValues) Delete(context.Context) error {
+	s.vals = nil
+	return nil
+}
+
+func writeValuesFile(t *testing.T, data string) string {
+	file := filepath.Join(t.TempDir(), "values.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(data), 0600))
+	return file
+}
+
+func TestParseValues_mergeOrder(t *testing.T) {
+	ctx := testContext(t)
+	file := writeValuesFile(t, `
+logLevel: debug
+nodeSelector:
+  disk: hdd
+  zone: a
+`)
+	vals, err := ParseValues([]string{file}, []string{"nodeSelector.disk=ssd"})
+	require.NoError(t, err)
+	require.NoError(t, ValidateValues(vals))
+
+	chrt, err := loadChart()
+	require.NoError(t, err)
+	install := action.NewInstall(fakeHelmConfig(t, "team-tp"))
+	install.DryRun = true
+	install.ReleaseName = releaseName
+	install.Namespace = "team-tp"
+	rel, err := install.Run(chrt, releaseValues(ctx, nil, vals))
+	require.NoError(t, err)
+
+	// chart default < values file < --set
+	assert.Contains(t, rel.Manifest, "- name: POD_CIDR_STRATEGY\n            value: auto\n")
+	assert.Contains(t, rel.Manifest, "- name: LOG_LEVEL\n            value: debug\n")
+	assert.Contains(t, rel.Manifest, "nodeSelector:\n        disk: ssd\n        zone: a\n")
+}
+
+func TestParseValues_invalid(t *testing.T) {
+	_, err := ParseValues([]string{filepath.Join(t.TempDir(), "missing.yaml")}, nil)
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+
+	_, err = ParseValues(nil, []string{"nodeSelector"})
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+}
+
+func TestValidateValues(t *testing.T) {
+	vals, err := ParseValues(nil, []string{
+		"nodeSelector.disk=ssd",
+		"resources.limits.cpu=100m",
+		"tolerations[0].key=dedicated",
+		"replicaCount=1",
+		"agentInjector.webhook.timeoutSeconds=10",
+	})
+	require.NoError(t, err)
+	assert.NoError(t, ValidateValues(vals))
+
+	vals, err = ParseValues(nil, []string{
+		"nodeSelectr.disk=ssd",
+		"agentInjector.webhook.timeout=10",
+		"createdBy=me",
+	})
+	require.NoError(t, err)
+	err = ValidateValues(vals)
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+	assert.Contains(t, err.Error(), "agentInjector.webhook.timeout, createdBy, nodeSelectr")
+}
+
+func Test_ensureTrafficManager_recordsValues(t *testing.T) {
+	noLegacyObjects(t)
+	ctx := testContext(t)
+	helmConfig := fakeHelmConfig(t, "team-tp")
+	store := &memoryValues{}
+
+	modified, err := ensureTrafficManager(ctx, helmConfig, nil, store, "team-tp", map[string]interface{}{
+		"nodeSelector": map[string]interface{}{"disk": "ssd"},
+		"logLevel":     "debug",
+	})
+	require.NoError(t, err)
+	assert.True(t, modified)
+
+	// The upgrade reuses the recorded values unless they are overridden
+	modified, err = ensureTrafficManager(ctx, helmConfig, nil, store, "team-tp", map[string]interface{}{
+		"logLevel": "trace",
+	})
+	require.NoError(t, err)
+	assert.True(t, modified)
+
+	expected := map[string]interface{}{
+		"nodeSelector": map[string]interface{}{"disk": "ssd"},
+		"logLevel":     "trace",
+	}
+	assert.Equal(t, expected, store.vals)
+
+	rel, err := getHelmRelease(ctx, helmConfig)
+	require.NoError(t, err)
+	require.NotNil(t, rel)
+	assert.Equal(t, 2, rel.Version)
+	assert.Equal(t, expected["nodeSelector"], rel.Config["nodeSelector"])
+	assert.Equal(t, "trace", rel.Config["logLevel"])
+	assert.Equal(t, releaseOwner, rel.Config["createdBy"])
+}
+
+func Test_ensureTrafficManager_invalidValues(t *testing.T) {
+	noLegacyObjects(t)
+	ctx := testContext(t)
+	helmConfig := fakeHelmConfig(t, "team-tp")
+
+	_, err := ensureTrafficManager(ctx, helmConfig, nil, &memoryValues{}, "team-tp", map[string]interface{}{"nodeSelectr": "x"})
+	require.Error(t, err)
+	assert.Equal(t, errcat.User, errcat.GetCategory(err))
+
+	// Nothing was applied
+	rel, err := getHelmRelease(ctx, helmConfig)
+	require.NoError(t, err)
+	assert.Nil(t, rel)
+}
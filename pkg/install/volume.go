@@ -21,3 +21,13 @@ func AgentVolume() corev1.Volume {
 		},
 	}
 }
+
+// AgentTmpVolume is the volume that the traffic-agent writes to when its root filesystem is read-only.
+func AgentTmpVolume() corev1.Volume {
+	return corev1.Volume{
+		Name: AgentTmpVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"net"
 
+	"gopkg.in/yaml.v3"
+
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 )
 
@@ -40,3 +42,24 @@ func (s *Subnet) UnmarshalJSON(data []byte) error {
 	*s = *(*Subnet)(ipNet)
 	return nil
 }
+
+func (s *Subnet) String() string {
+	return (*net.IPNet)(s).String()
+}
+
+func (s *Subnet) MarshalYAML() (interface{}, error) {
+	return (*net.IPNet)(s).String(), nil
+}
+
+func (s *Subnet) UnmarshalYAML(node *yaml.Node) error {
+	var str string
+	if err := node.Decode(&str); err != nil {
+		return err
+	}
+	_, ipNet, err := net.ParseCIDR(str)
+	if err != nil {
+		return err
+	}
+	*s = *(*Subnet)(ipNet)
+	return nil
+}
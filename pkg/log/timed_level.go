@@ -27,6 +27,10 @@ type TimedLevel interface {
 
 	// Reset restores the log-level to its default value
 	Reset(ctx context.Context)
+
+	// SetDefault sets the default log-level. It becomes active unless a log-level that was set with
+	// Set is active.
+	SetDefault(ctx context.Context, level string)
 }
 
 type timedLevel struct {
@@ -99,3 +103,12 @@ func (tl *timedLevel) Reset(ctx context.Context) {
 	tl.tempLevel = ""
 	tl.setter(ctx, tl.defaultLevel)
 }
+
+func (tl *timedLevel) SetDefault(ctx context.Context, level string) {
+	tl.Lock()
+	defer tl.Unlock()
+	tl.defaultLevel = level
+	if tl.tempLevel == "" {
+		tl.setter(ctx, level)
+	}
+}
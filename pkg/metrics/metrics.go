@@ -0,0 +1,158 @@
+// Package metrics declares the Prometheus metrics of the Telepresence daemons. All metrics are registered
+// here, in one registry, so that their names stay stable. A daemon exposes them in the Prometheus text
+// format when it calls Serve, which it does when metrics.address is set in the config.
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/datawire/dlib/dhttp"
+	"github.com/datawire/dlib/dlog"
+)
+
+const namespace = "telepresence"
+
+// The values of the result label of DNSQueries.
+const (
+	DNSRouted   = "routed"
+	DNSBypassed = "bypassed"
+)
+
+// The values of the direction label of TunnelBytes.
+const (
+	TunnelIn  = "in"
+	TunnelOut = "out"
+)
+
+// The values of the reason label of ConnectionEvictions.
+const (
+	EvictedIdle  = "idle"
+	EvictedLimit = "limit"
+)
+
+// The values of the operation label of RouteChanges.
+const (
+	RouteAdded   = "add"
+	RouteRemoved = "remove"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	// DNSQueries counts the DNS queries that the root daemon answers with cluster records (routed), and
+	// those that it passes on to the original resolver or answers with NXDOMAIN (bypassed).
+	DNSQueries = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dns_queries_total",
+		Help:      "DNS queries handled by the root daemon, by whether they were routed to the cluster or bypassed.",
+	}, []string{"result"})).(*prometheus.CounterVec)
+
+	// TunnelBytes counts the payload bytes that the root daemon sends to (out) and receives from (in)
+	// the cluster over the tunnel.
+	TunnelBytes = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tunnel_bytes_total",
+		Help:      "Payload bytes sent to (out) and received from (in) the cluster over the tunnel.",
+	}, []string{"direction"})).(*prometheus.CounterVec)
+
+	// ActiveConnections is the number of connections that the root daemon currently routes to the cluster.
+	ActiveConnections = register(prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "tunnel_active_connections",
+		Help:      "Connections that are currently routed to the cluster.",
+	})).(prometheus.Gauge)
+
+	// TrackedConnections is the number of TCP connections and UDP flows in the connection table of the
+	// root daemon.
+	TrackedConnections = register(prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "tunnel_tracked_connections",
+		Help:      "TCP connections and UDP flows in the connection table of the root daemon.",
+	})).(prometheus.Gauge)
+
+	// ConnectionEvictions counts the connections that the root daemon closed because they were idle, or
+	// to make room for new connections when the maximum number of tracked connections was reached (limit).
+	ConnectionEvictions = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tunnel_connection_evictions_total",
+		Help:      "Connections closed by the root daemon because they were idle, or to stay within the maximum number of tracked connections.",
+	}, []string{"reason"})).(*prometheus.CounterVec)
+
+	// RefusedConnections counts the new connections that the root daemon refused because the maximum
+	// number of tracked connections was reached.
+	RefusedConnections = register(prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tunnel_refused_connections_total",
+		Help:      "New connections refused because the maximum number of tracked connections was reached.",
+	})).(prometheus.Counter)
+
+	// RouteChanges counts the routes of cluster and also-proxy subnets that the root daemon added to, and
+	// removed from, the TUN device, e.g. when nodes with new pod subnets join the cluster.
+	RouteChanges = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "route_changes_total",
+		Help:      "Routes of subnets added to (add), and removed from (remove), the TUN device by the root daemon.",
+	}, []string{"operation"})).(*prometheus.CounterVec)
+
+	// Intercepts is the number of intercepts of the user daemon's sessions.
+	Intercepts = register(prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "intercepts",
+		Help:      "Intercepts of the user daemon's sessions.",
+	})).(prometheus.Gauge)
+
+	// ReconnectAttempts counts the attempts of the user daemon to replace a broken traffic-manager session.
+	ReconnectAttempts = register(prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconnect_attempts_total",
+		Help:      "Attempts to replace a broken traffic-manager session.",
+	})).(prometheus.Counter)
+)
+
+func init() {
+	// Make the labeled series visible before they're incremented for the first time
+	DNSQueries.WithLabelValues(DNSRouted)
+	DNSQueries.WithLabelValues(DNSBypassed)
+	TunnelBytes.WithLabelValues(TunnelIn)
+	TunnelBytes.WithLabelValues(TunnelOut)
+	ConnectionEvictions.WithLabelValues(EvictedIdle)
+	ConnectionEvictions.WithLabelValues(EvictedLimit)
+	RouteChanges.WithLabelValues(RouteAdded)
+	RouteChanges.WithLabelValues(RouteRemoved)
+}
+
+func register(c prometheus.Collector) prometheus.Collector {
+	registry.MustRegister(c)
+	return c
+}
+
+// Handler returns the handler that serves the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ListenAndServe serves the metrics on "/metrics" of the given address until the context is cancelled. A
+// failure to listen is logged rather than returned, because the metrics are not essential to a daemon.
+func ListenAndServe(ctx context.Context, address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		dlog.Errorf(ctx, "unable to serve metrics: %v", err)
+		<-ctx.Done()
+		return nil
+	}
+	return Serve(ctx, listener)
+}
+
+// Serve serves the metrics on "/metrics" of the given listener until the context is cancelled.
+func Serve(ctx context.Context, listener net.Listener) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	sc := &dhttp.ServerConfig{Handler: mux}
+	dlog.Infof(ctx, "Serving metrics on http://%s/metrics", listener.Addr())
+	return sc.Serve(ctx, listener)
+}
@@ -0,0 +1,120 @@
+package metrics_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	tpdns "github.com/telepresenceio/telepresence/v2/pkg/client/daemon/dns"
+	"github.com/telepresenceio/telepresence/v2/pkg/metrics"
+)
+
+type responseWriter struct {
+	dns.ResponseWriter
+}
+
+func (w *responseWriter) WriteMsg(*dns.Msg) error {
+	return nil
+}
+
+// scrape returns the values of the series served by the metrics endpoint of the given address.
+func scrape(t *testing.T, address string) map[string]string {
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", address))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	series := make(map[string]string)
+	for _, line := range strings.Split(string(body), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.LastIndexByte(line, ' '); i > 0 {
+			series[line[:i]] = line[i+1:]
+		}
+	}
+	return series
+}
+
+func TestServe(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	done := make(chan error, 1)
+	go func() { done <- metrics.Serve(ctx, listener) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	before := scrape(t, listener.Addr().String())
+	for _, name := range []string{
+		`telepresence_dns_queries_total{result="routed"}`,
+		`telepresence_dns_queries_total{result="bypassed"}`,
+		`telepresence_tunnel_bytes_total{direction="in"}`,
+		`telepresence_tunnel_bytes_total{direction="out"}`,
+		`telepresence_tunnel_active_connections`,
+		`telepresence_tunnel_tracked_connections`,
+		`telepresence_tunnel_connection_evictions_total{reason="idle"}`,
+		`telepresence_tunnel_connection_evictions_total{reason="limit"}`,
+		`telepresence_tunnel_refused_connections_total`,
+		`telepresence_route_changes_total{operation="add"}`,
+		`telepresence_route_changes_total{operation="remove"}`,
+		`telepresence_intercepts`,
+		`telepresence_reconnect_attempts_total`,
+	} {
+		assert.Contains(t, before, name)
+	}
+
+	// Drive fake DNS traffic through the DNS server of the root daemon
+	resolve := func(_ context.Context, _ uint16, domain string) []net.IP {
+		if domain == "echo.default." {
+			return []net.IP{{10, 96, 0, 10}}
+		}
+		return nil
+	}
+	s := tpdns.NewServer(ctx, nil, nil, resolve, nil)
+	for _, name := range []string{"echo.default.", "echo.default.", "example.com."} {
+		r := new(dns.Msg)
+		r.SetQuestion(name, dns.TypeA)
+		s.ServeDNS(&responseWriter{}, r)
+	}
+	metrics.Intercepts.Add(2)
+	defer metrics.Intercepts.Sub(2)
+
+	after := scrape(t, listener.Addr().String())
+	delta := func(name string) string {
+		var b, a float64
+		_, _ = fmt.Sscan(before[name], &b)
+		_, _ = fmt.Sscan(after[name], &a)
+		return fmt.Sprint(a - b)
+	}
+	assert.Equal(t, "2", delta(`telepresence_dns_queries_total{result="routed"}`))
+	assert.Equal(t, "1", delta(`telepresence_dns_queries_total{result="bypassed"}`))
+	assert.Equal(t, "2", delta(`telepresence_intercepts`))
+}
+
+func TestListenAndServe_addressInUse(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	// A failure to listen doesn't end the daemon
+	done := make(chan error, 1)
+	go func() { done <- metrics.ListenAndServe(ctx, listener.Addr().String()) }()
+	cancel()
+	assert.NoError(t, <-done)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
 
 	//nolint:depguard // TODO: Switch Run() over to dexec.
@@ -13,25 +14,52 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/shellquote"
 )
 
+// ExitError is the error returned by Run when the command exits with a non-zero exit code or is
+// terminated by a signal.
+type ExitError struct {
+	// Cmd is the command line of the command.
+	Cmd string
+
+	// Code is the exit code of the command, or 128 plus the signal number when the command was
+	// terminated by a signal, which is what a shell reports in that case.
+	Code int
+
+	// Signal is the signal that terminated the command, or nil if the command exited.
+	Signal os.Signal
+}
+
+func (e *ExitError) Error() string {
+	if e.Signal != nil {
+		return fmt.Sprintf("%s: terminated by signal %v", e.Cmd, e.Signal)
+	}
+	return fmt.Sprintf("%s: exited with %d", e.Cmd, e.Code)
+}
+
+// ExitCode returns the exit code that a process should exit with to propagate the exit status of the command.
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
 // Run will run the given executable with given args and env, wait for it to terminate, and return
 // the result. The run will dispatch signals as appropriate for the given platform (SIGTERM and SIGINT on Unix platforms
-// and os.Interrupt on Windows).
+// and os.Interrupt on Windows). On Unix platforms, the command runs in a process group of its own and the signals
+// are dispatched to that group, so that they also reach the processes that the command starts.
+//
+// An *ExitError is returned when the command exits with a non-zero exit code or is terminated by a signal.
 func Run(ctx context.Context, env map[string]string, exe string, args ...string) error {
 	cmd := exec.CommandContext(ctx, exe, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
-	cmd.Env = os.Environ()
-	for k, v := range env {
-		cmd.Env = append(cmd.Env, k+"="+v)
-	}
+	cmd.Env = Environ(env)
 
-	var err error
-	if err = cmd.Start(); err != nil {
+	restoreTerminal, err := startInProcessGroup(cmd)
+	if err != nil {
 		return fmt.Errorf("%s: %w", shellquote.ShellString(exe, args), err)
 	}
+	defer restoreTerminal()
 
-	// Ensure that signals are propagated to the child process
+	// Ensure that signals are propagated to the processes of the command
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, signalsToForward...)
 	defer func() {
@@ -39,24 +67,66 @@ func Run(ctx context.Context, env map[string]string, exe string, args ...string)
 		close(sigCh)
 	}()
 	go func() {
-		sig := <-sigCh
-		if sig == nil {
-			return
+		for sig := range sigCh {
+			_ = signalProcessGroup(cmd.Process, sig)
 		}
-		_ = cmd.Process.Signal(sig)
 	}()
 	s, err := cmd.Process.Wait()
 	if err != nil {
 		return fmt.Errorf("%s: %w", shellquote.ShellString(exe, args), err)
 	}
 
-	exitCode := s.ExitCode()
-	if exitCode != 0 {
-		return fmt.Errorf("%s %s: exited with %d", exe, strings.Join(args, " "), exitCode)
+	if exitCode, sig := exitStatus(s); exitCode != 0 {
+		return &ExitError{Cmd: strings.TrimSpace(exe + " " + strings.Join(args, " ")), Code: exitCode, Signal: sig}
 	}
 	return nil
 }
 
+// Environ returns the environment of this process with the given env merged over it, so that a
+// variable in env replaces a variable with the same name in the environment of this process.
+func Environ(env map[string]string) []string {
+	local := os.Environ()
+	merged := make([]string, 0, len(local)+len(env))
+	overrides := make(map[string]struct{}, len(env))
+	for k := range env {
+		overrides[envKey(k)] = struct{}{}
+	}
+	for _, kv := range local {
+		k := kv
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			k = kv[:i]
+		}
+		if _, ok := overrides[envKey(k)]; !ok {
+			merged = append(merged, kv)
+		}
+	}
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		merged = append(merged, k+"="+env[k])
+	}
+	return merged
+}
+
+type noPromptsKey struct{}
+
+// WithoutPrompts returns a context that makes the functions of this package fail rather than prompt
+// the user, e.g. for a sudo password or a UAC confirmation. The given reason tells why prompting
+// isn't possible, and is included in the errors.
+func WithoutPrompts(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, noPromptsKey{}, reason)
+}
+
+// NoPromptReason returns the reason given to WithoutPrompts, or an empty string when the user can
+// be prompted.
+func NoPromptReason(ctx context.Context) string {
+	reason, _ := ctx.Value(noPromptsKey{}).(string)
+	return reason
+}
+
 func StartInBackground(args ...string) error {
 	return startInBackground(args...)
 }
@@ -65,6 +135,19 @@ func StartInBackgroundAsRoot(ctx context.Context, args ...string) error {
 	return startInBackgroundAsRoot(ctx, args...)
 }
 
+// RunAsRoot runs the given command with elevated privileges. The user is prompted for a password
+// when needed, unless the context is WithoutPrompts. The call waits for the command to terminate,
+// except on Windows when the elevation requires a UAC prompt, in which case the command is started but
+// not waited for.
+func RunAsRoot(ctx context.Context, args ...string) error {
+	return runAsRoot(ctx, args...)
+}
+
 func IsAdmin() bool {
 	return isAdmin()
 }
+
+// IsAlive returns true if a process with the given id exists.
+func IsAlive(pid int) bool {
+	return isAlive(pid)
+}
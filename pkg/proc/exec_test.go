@@ -0,0 +1,36 @@
+package proc
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnviron(t *testing.T) {
+	t.Setenv("TEL_TEST_SHARED", "local")
+	t.Setenv("TEL_TEST_LOCAL", "local")
+	env := Environ(map[string]string{"TEL_TEST_SHARED": "intercepted", "TEL_TEST_REMOTE": "intercepted"})
+
+	count := func(kv string) int {
+		n := 0
+		for _, e := range env {
+			if e == kv {
+				n++
+			}
+		}
+		return n
+	}
+	assert.Equal(t, 1, count("TEL_TEST_SHARED=intercepted"))
+	assert.Equal(t, 0, count("TEL_TEST_SHARED=local"))
+	assert.Equal(t, 1, count("TEL_TEST_LOCAL=local"))
+	assert.Equal(t, 1, count("TEL_TEST_REMOTE=intercepted"))
+	assert.Equal(t, len(os.Environ())+1, len(env))
+}
+
+func TestNoPromptReason(t *testing.T) {
+	ctx := context.Background()
+	assert.Empty(t, NoPromptReason(ctx))
+	assert.Equal(t, "--output json is used", NoPromptReason(WithoutPrompts(ctx, "--output json is used")))
+}
@@ -5,13 +5,19 @@ package proc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 
 	//nolint:depguard // Because startInBackground{,AsRoot}() won't ever .Wait() for the process
 	// and we'd turn off logging, using dexec would just be extra overhead.
 	"os/exec"
 
+	//nolint:depguard // We specifically need "syscall.WaitStatus" rather than "unix.WaitStatus" for
+	// os.ProcessState.Sys().
+	"syscall"
+
 	"golang.org/x/sys/unix"
 
 	"github.com/datawire/dlib/dexec"
@@ -20,10 +26,69 @@ import (
 
 var signalsToForward = []os.Signal{unix.SIGINT, unix.SIGTERM}
 
+// envKey returns the key that identifies the given environment variable name. Names are case-sensitive.
+func envKey(name string) string {
+	return name
+}
+
 func isAdmin() bool {
 	return os.Geteuid() == 0
 }
 
+func isAlive(pid int) bool {
+	// Signal 0 performs the error checking without sending anything. EPERM means that the process
+	// exists but is owned by someone else, e.g. the root daemon.
+	err := unix.Kill(pid, 0)
+	return err == nil || errors.Is(err, unix.EPERM)
+}
+
+// startInProcessGroup starts the given command in a process group of its own, so that signals can be
+// dispatched to all processes of the command. When the command's stdin is the terminal of this process
+// group, the command's group becomes the foreground group of the terminal, so that it can read from the
+// terminal and receive the signals generated by it, such as SIGINT on <ctrl-c>. The returned function
+// gives the terminal back to the process group of this process.
+func startInProcessGroup(cmd *exec.Cmd) (func(), error) {
+	cmd.SysProcAttr = &unix.SysProcAttr{Setpgid: true}
+	tty := -1
+	if f, ok := cmd.Stdin.(*os.File); ok {
+		fd := int(f.Fd())
+		if pgrp, err := unix.IoctlGetInt(fd, unix.TIOCGPGRP); err == nil && pgrp == unix.Getpgrp() {
+			tty = fd
+			cmd.SysProcAttr.Foreground = true
+			cmd.SysProcAttr.Ctty = fd
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return func() {
+		if tty >= 0 {
+			// This process is in the background now, and a background process that changes the
+			// foreground group of the terminal is stopped by SIGTTOU unless that signal is ignored.
+			signal.Ignore(unix.SIGTTOU)
+			defer signal.Reset(unix.SIGTTOU)
+			_ = unix.IoctlSetPointerInt(tty, unix.TIOCSPGRP, unix.Getpgrp())
+		}
+	}, nil
+}
+
+// signalProcessGroup sends the given signal to all processes in the process group of the given process.
+func signalProcessGroup(p *os.Process, sig os.Signal) error {
+	if s, ok := sig.(unix.Signal); ok {
+		return unix.Kill(-p.Pid, s)
+	}
+	return p.Signal(sig)
+}
+
+// exitStatus returns the exit code of the given process state, or 128 plus the signal number along
+// with the signal when the process was terminated by a signal.
+func exitStatus(s *os.ProcessState) (int, os.Signal) {
+	if ws, ok := s.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal()), ws.Signal()
+	}
+	return s.ExitCode(), nil
+}
+
 func startInBackground(args ...string) error {
 	cmd := exec.Command(args[0], args[1:]...)
 
@@ -43,6 +108,17 @@ func startInBackground(args ...string) error {
 	return nil
 }
 
+func runAsRoot(ctx context.Context, args ...string) error {
+	if !isAdmin() {
+		if NoPromptReason(ctx) != "" {
+			args = append([]string{"sudo", "--non-interactive"}, args...)
+		} else {
+			args = append([]string{"sudo"}, args...)
+		}
+	}
+	return Run(ctx, nil, args[0], args[1:]...)
+}
+
 func startInBackgroundAsRoot(ctx context.Context, args ...string) error {
 	if !isAdmin() {
 		// If we're going to be prompting for the `sudo` password, we want to first provide
@@ -60,6 +136,10 @@ func startInBackgroundAsRoot(ctx context.Context, args ...string) error {
 		needPwCmd := dexec.CommandContext(ctx, "sudo", "--non-interactive", "true")
 		needPwCmd.DisableLogging = true
 		if err := needPwCmd.Run(); err != nil {
+			if reason := NoPromptReason(ctx); reason != "" {
+				return fmt.Errorf("root privileges are required to run %s, and sudo can't ask for a password because %s",
+					shellquote.ShellString(args[0], args[1:]), reason)
+			}
 			fmt.Printf("Need root privileges to run: %s\n", shellquote.ShellString(args[0], args[1:]))
 			// `sudo` won't be able to read the password from the terminal when we run
 			// it with Setpgid=true, so do a pre-flight `sudo true` to read the
@@ -2,7 +2,12 @@ package proc
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"strings"
+
+	//nolint:depguard // TODO: Switch Run() over to dexec.
+	"os/exec"
 
 	"golang.org/x/sys/windows"
 
@@ -11,18 +16,64 @@ import (
 
 var signalsToForward = []os.Signal{os.Interrupt}
 
+// envKey returns the key that identifies the given environment variable name. Names are case-insensitive.
+func envKey(name string) string {
+	return strings.ToUpper(name)
+}
+
+// startInProcessGroup starts the given command. The command shares the console, and hence the
+// console's signals, with this process, so there's no terminal to restore.
+func startInProcessGroup(cmd *exec.Cmd) (func(), error) {
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return func() {}, nil
+}
+
+// signalProcessGroup sends the given signal to the given process.
+func signalProcessGroup(p *os.Process, sig os.Signal) error {
+	return p.Signal(sig)
+}
+
+// exitStatus returns the exit code of the given process state. A process is never terminated by a
+// signal on Windows.
+func exitStatus(s *os.ProcessState) (int, os.Signal) {
+	return s.ExitCode(), nil
+}
+
 func startInBackground(args ...string) error {
 	return shellExec("open", args[0], args[1:]...)
 }
 
-func startInBackgroundAsRoot(_ context.Context, args ...string) error {
+func startInBackgroundAsRoot(ctx context.Context, args ...string) error {
 	verb := "runas"
 	if isAdmin() {
 		verb = "open"
+	} else if err := checkPromptAllowed(ctx, args); err != nil {
+		return err
 	}
 	return shellExec(verb, args[0], args[1:]...)
 }
 
+func runAsRoot(ctx context.Context, args ...string) error {
+	if isAdmin() {
+		return Run(ctx, nil, args[0], args[1:]...)
+	}
+	if err := checkPromptAllowed(ctx, args); err != nil {
+		return err
+	}
+	return shellExec("runas", args[0], args[1:]...)
+}
+
+// checkPromptAllowed returns an error if the UAC prompt that elevates the given command isn't allowed.
+func checkPromptAllowed(ctx context.Context, args []string) error {
+	if reason := NoPromptReason(ctx); reason != "" {
+		return fmt.Errorf("administrator privileges are required to run %s, and they can't be asked for because %s",
+			shellquote.ShellString(args[0], args[1:]), reason)
+	}
+	return nil
+}
+
 func shellExec(verb, exe string, args ...string) error {
 	cwd, _ := os.Getwd()
 	// UTF16PtrFromString can only fail if the argument contains a NUL byte. That will never happen here.
@@ -55,3 +106,15 @@ func isAdmin() bool {
 	adm, err := windows.GetCurrentProcessToken().IsMember(sid)
 	return err == nil && adm
 }
+
+func isAlive(pid int) bool {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		// Access denied means that the process exists.
+		return err == windows.ERROR_ACCESS_DENIED
+	}
+	defer windows.CloseHandle(h)
+	const stillActive = 259
+	var exitCode uint32
+	return windows.GetExitCodeProcess(h, &exitCode) == nil && exitCode == stillActive
+}
@@ -0,0 +1,258 @@
+package routing
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// The parsers in this file are not constrained to the operating system that produces their input,
+// so that captured routing tables of all systems can be tested everywhere.
+
+// parseProcNetRoute parses the IPv4 routing table of Linux, as found in /proc/net/route.
+func parseProcNetRoute(r io.Reader) ([]*Route, error) {
+	var routes []*Route
+	sc := bufio.NewScanner(r)
+	first := true
+	for sc.Scan() {
+		if first {
+			first = false // header
+			continue
+		}
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 8 {
+			continue
+		}
+		dst, err := parseHexIPv4(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		gw, err := parseHexIPv4(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		mask, err := parseHexIPv4(fields[7])
+		if err != nil {
+			return nil, err
+		}
+		route := &Route{RoutedNet: &net.IPNet{IP: dst, Mask: net.IPMask(mask)}, Interface: fields[0]}
+		if !gw.IsUnspecified() {
+			route.Gateway = gw
+		}
+		routes = append(routes, route)
+	}
+	return routes, sc.Err()
+}
+
+// parseHexIPv4 parses an IPv4 address that is formatted as a little endian hex number.
+func parseHexIPv4(s string) (net.IP, error) {
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IPv4 address %q: %w", s, err)
+	}
+	ip := make(net.IP, net.IPv4len)
+	binary.LittleEndian.PutUint32(ip, uint32(v))
+	return ip, nil
+}
+
+// parseProcNetIPv6Route parses the IPv6 routing table of Linux, as found in /proc/net/ipv6_route.
+func parseProcNetIPv6Route(r io.Reader) ([]*Route, error) {
+	var routes []*Route
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		dst, err := hex.DecodeString(fields[0])
+		if err != nil || len(dst) != net.IPv6len {
+			return nil, fmt.Errorf("invalid IPv6 address %q", fields[0])
+		}
+		ones, err := strconv.ParseUint(fields[1], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IPv6 prefix length %q", fields[1])
+		}
+		gw, err := hex.DecodeString(fields[4])
+		if err != nil || len(gw) != net.IPv6len {
+			return nil, fmt.Errorf("invalid IPv6 address %q", fields[4])
+		}
+		route := &Route{
+			RoutedNet: &net.IPNet{IP: dst, Mask: net.CIDRMask(int(ones), 128)},
+			Interface: fields[9],
+		}
+		if !net.IP(gw).IsUnspecified() {
+			route.Gateway = gw
+		}
+		routes = append(routes, route)
+	}
+	return routes, sc.Err()
+}
+
+// parseNetstat parses the output of "netstat -rn" on macOS.
+func parseNetstat(r io.Reader) ([]*Route, error) {
+	var routes []*Route
+	sc := bufio.NewScanner(r)
+	ifIndex := -1
+	ipv6 := false
+	for sc.Scan() {
+		line := sc.Text()
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			continue
+		case line == "Internet:":
+			ipv6 = false
+			ifIndex = -1
+			continue
+		case line == "Internet6:":
+			ipv6 = true
+			ifIndex = -1
+			continue
+		case fields[0] == "Destination":
+			for i, f := range fields {
+				if f == "Netif" {
+					ifIndex = i
+				}
+			}
+			continue
+		case ifIndex < 0 || len(fields) <= ifIndex:
+			continue
+		}
+		dst, err := parseNetstatDestination(fields[0], ipv6)
+		if err != nil {
+			return nil, err
+		}
+		route := &Route{RoutedNet: dst, Interface: fields[ifIndex]}
+		if gw := net.ParseIP(stripZone(fields[1])); gw != nil {
+			route.Gateway = gw
+		}
+		routes = append(routes, route)
+	}
+	return routes, sc.Err()
+}
+
+func stripZone(s string) string {
+	if i := strings.IndexByte(s, '%'); i >= 0 {
+		if j := strings.IndexByte(s[i:], '/'); j >= 0 {
+			return s[:i] + s[i+j:]
+		}
+		return s[:i]
+	}
+	return s
+}
+
+// parseNetstatDestination parses a destination of the netstat output. IPv4 destinations may be
+// abbreviated, e.g. "10.8/16", and have an implicit mask that covers the given octets when no
+// mask is given, e.g. "192.168.1" is 192.168.1.0/24.
+func parseNetstatDestination(s string, ipv6 bool) (*net.IPNet, error) {
+	if s == "default" {
+		if ipv6 {
+			return &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}, nil
+		}
+		return &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}, nil
+	}
+	s = stripZone(s)
+	addr, ones := s, -1
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		var err error
+		if ones, err = strconv.Atoi(s[i+1:]); err != nil {
+			return nil, fmt.Errorf("invalid destination %q", s)
+		}
+		addr = s[:i]
+	}
+	if ipv6 {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid destination %q", s)
+		}
+		if ones < 0 {
+			ones = 128
+		}
+		return &net.IPNet{IP: ip.Mask(net.CIDRMask(ones, 128)), Mask: net.CIDRMask(ones, 128)}, nil
+	}
+	octets := strings.Split(addr, ".")
+	if len(octets) > 4 {
+		return nil, fmt.Errorf("invalid destination %q", s)
+	}
+	ip := make(net.IP, net.IPv4len)
+	for i, o := range octets {
+		v, err := strconv.ParseUint(o, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid destination %q", s)
+		}
+		ip[i] = byte(v)
+	}
+	if ones < 0 {
+		ones = len(octets) * 8
+	}
+	return &net.IPNet{IP: ip.Mask(net.CIDRMask(ones, 32)), Mask: net.CIDRMask(ones, 32)}, nil
+}
+
+// parseRoutePrint parses the output of "route print" on Windows.
+func parseRoutePrint(r io.Reader) ([]*Route, error) {
+	var routes []*Route
+	sc := bufio.NewScanner(r)
+	const (
+		none = iota
+		ipv4
+		ipv6
+	)
+	table := none
+	active := false
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		fields := strings.Fields(line)
+		switch {
+		case line == "IPv4 Route Table":
+			table = ipv4
+			continue
+		case line == "IPv6 Route Table":
+			table = ipv6
+			continue
+		case line == "Active Routes:":
+			active = true
+			continue
+		case strings.HasPrefix(line, "====") || line == "Persistent Routes:":
+			active = false
+			continue
+		case !active || len(fields) < 3:
+			continue
+		}
+		switch table {
+		case ipv4:
+			// Network Destination, Netmask, Gateway, Interface, Metric
+			if len(fields) < 4 {
+				continue
+			}
+			dst, mask := net.ParseIP(fields[0]).To4(), net.ParseIP(fields[1]).To4()
+			if dst == nil || mask == nil {
+				continue // header
+			}
+			route := &Route{RoutedNet: &net.IPNet{IP: dst, Mask: net.IPMask(mask)}, Interface: fields[3]}
+			if gw := net.ParseIP(fields[2]); gw != nil {
+				route.Gateway = gw
+			}
+			routes = append(routes, route)
+		case ipv6:
+			// If, Metric, Network Destination, Gateway. The gateway is on the next line when the
+			// destination is too long to fit its column.
+			_, dst, err := net.ParseCIDR(fields[2])
+			if err != nil {
+				continue // header
+			}
+			route := &Route{RoutedNet: dst, Interface: fields[0]}
+			if len(fields) > 3 {
+				if gw := net.ParseIP(fields[3]); gw != nil {
+					route.Gateway = gw
+				}
+			}
+			routes = append(routes, route)
+		}
+	}
+	return routes, sc.Err()
+}
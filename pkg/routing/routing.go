@@ -0,0 +1,232 @@
+// Package routing reads the routing table of the local host and detects conflicts between its routes
+// and the subnets that Telepresence routes to the cluster.
+package routing
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/subnet"
+)
+
+// Route is an entry in the routing table of the local host.
+type Route struct {
+	// RoutedNet is the destination subnet of the route
+	RoutedNet *net.IPNet
+
+	// Interface identifies the interface of the route. This is a name on Linux and macOS, and an
+	// address (IPv4) or an index (IPv6) on Windows.
+	Interface string
+
+	// Gateway is the gateway of the route, or nil when the route is on-link
+	Gateway net.IP
+}
+
+// Default returns true if this is a default route.
+func (r *Route) Default() bool {
+	ones, _ := r.RoutedNet.Mask.Size()
+	return ones == 0
+}
+
+// host returns true if this route is for a single address, such as a neighbor or the gateway of a VPN.
+func (r *Route) host() bool {
+	ones, bits := r.RoutedNet.Mask.Size()
+	return ones == bits
+}
+
+// local returns true if this route is for loopback, link-local, or multicast addresses, which
+// never conflict with the subnets of a cluster.
+func (r *Route) local() bool {
+	ip := r.RoutedNet.IP
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.Equal(net.IPv4bcast) {
+		return true
+	}
+	switch r.Interface {
+	case "lo", "lo0":
+		return true
+	}
+	ifIP := net.ParseIP(r.Interface)
+	return ifIP != nil && ifIP.IsLoopback()
+}
+
+func (r *Route) String() string {
+	if r.Gateway != nil {
+		return fmt.Sprintf("%s via %s dev %s", r.RoutedNet, r.Gateway, r.Interface)
+	}
+	return fmt.Sprintf("%s dev %s", r.RoutedNet, r.Interface)
+}
+
+// matches returns true if the given interface is the one that the Interface of this route identifies.
+func (r *Route) matches(iface *net.Interface) bool {
+	if r.Interface == iface.Name || r.Interface == strconv.Itoa(iface.Index) {
+		return true
+	}
+	ip := net.ParseIP(r.Interface)
+	if ip == nil {
+		return false
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// InterfaceIdentifiers returns the identifiers that the routing table may use for the named
+// interface, i.e. its name, its index, and its addresses.
+func InterfaceIdentifiers(name string) []string {
+	if name == "" {
+		return nil
+	}
+	ids := []string{name}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return ids
+	}
+	ids = append(ids, strconv.Itoa(iface.Index))
+	if addrs, err := iface.Addrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				ids = append(ids, ipNet.IP.String())
+			}
+		}
+	}
+	return ids
+}
+
+// DefaultRouteMTU returns the MTU of the interface of the default routes in the given table, along
+// with the name of that interface. The smallest MTU is returned when the default routes of IPv4 and
+// IPv6 use different interfaces. Routes that belong to one of the ownInterfaces are ignored. Zero and
+// an empty name are returned when the MTU can't be determined.
+func DefaultRouteMTU(table []*Route, ifaces []net.Interface, ownInterfaces ...string) (mtu int, name string) {
+	own := make(map[string]bool, len(ownInterfaces))
+	for _, i := range ownInterfaces {
+		own[i] = true
+	}
+	for _, r := range table {
+		if !r.Default() || own[r.Interface] {
+			continue
+		}
+		for i := range ifaces {
+			iface := &ifaces[i]
+			if iface.MTU > 0 && (mtu == 0 || iface.MTU < mtu) && r.matches(iface) {
+				mtu, name = iface.MTU, iface.Name
+			}
+		}
+	}
+	return mtu, name
+}
+
+// Severity is the severity of a Conflict.
+type Severity int
+
+const (
+	// Warning is a conflict that makes some addresses unreachable, either in the cluster or on the
+	// local network, but doesn't break the connectivity of the host.
+	Warning Severity = iota
+
+	// Error is a collision with the route of another VPN. Routing the subnet would break the VPN, so it
+	// isn't routed unless it is allowed.
+	Error
+)
+
+func (s Severity) String() string {
+	if s == Error {
+		return "error"
+	}
+	return "warning"
+}
+
+// Conflict is an overlap between a subnet that Telepresence routes to the cluster and a local route.
+type Conflict struct {
+	Subnet   *net.IPNet
+	Route    *Route
+	Severity Severity
+}
+
+func (c *Conflict) String() string {
+	return fmt.Sprintf("%s: subnet %s conflicts with the local route %s", c.Severity, c.Subnet, c.Route)
+}
+
+// FindConflicts returns the conflicts between the given subnets and the routes of the given table.
+// Routes that belong to one of the ownInterfaces, i.e. the routes that Telepresence itself added, are
+// ignored, and so are default routes, host routes, and routes for loopback, link-local, and multicast
+// addresses.
+//
+// A conflict with a route on the interface of a default route, i.e. the local network, is a Warning.
+// So is a partial overlap with the route of another interface. An exact collision with the route of
+// another interface, typically a VPN, is an Error unless the subnet is covered by one of the allowed
+// subnets.
+func FindConflicts(table []*Route, subnets, allowed []*net.IPNet, ownInterfaces ...string) []*Conflict {
+	own := make(map[string]bool, len(ownInterfaces))
+	for _, i := range ownInterfaces {
+		own[i] = true
+	}
+	defaultIfs := make(map[string]bool)
+	for _, r := range table {
+		if r.Default() {
+			defaultIfs[r.Interface] = true
+		}
+	}
+	isAllowed := func(sn *net.IPNet) bool {
+		for _, a := range allowed {
+			if subnet.Covers(a, sn) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var conflicts []*Conflict
+	for _, sn := range subnets {
+		for _, r := range table {
+			if own[r.Interface] || r.Default() || r.host() || r.local() || !subnet.Overlaps(sn, r.RoutedNet) {
+				continue
+			}
+			severity := Warning
+			if !defaultIfs[r.Interface] && subnet.Equal(sn, r.RoutedNet) && !isAllowed(sn) {
+				severity = Error
+			}
+			conflicts = append(conflicts, &Conflict{Subnet: sn, Route: r, Severity: severity})
+		}
+	}
+	return conflicts
+}
+
+// Errors returns the subnets of the given conflicts that have an Error severity.
+func Errors(conflicts []*Conflict) []*net.IPNet {
+	var sns []*net.IPNet
+	for _, c := range conflicts {
+		if c.Severity == Error {
+			sns = append(sns, c.Subnet)
+		}
+	}
+	return sns
+}
+
+// ToRPC returns the gRPC representation of this conflict.
+func (c *Conflict) ToRPC() *daemon.SubnetConflict {
+	return &daemon.SubnetConflict{
+		Subnet:    iputil.IPNetToRPC(c.Subnet),
+		Route:     iputil.IPNetToRPC(c.Route.RoutedNet),
+		Interface: c.Route.Interface,
+		Severity:  daemon.SubnetConflict_Severity(c.Severity),
+	}
+}
+
+// ConflictFromRPC returns the conflict of the given gRPC representation.
+func ConflictFromRPC(rc *daemon.SubnetConflict) *Conflict {
+	return &Conflict{
+		Subnet:   iputil.IPNetFromRPC(rc.Subnet),
+		Route:    &Route{RoutedNet: iputil.IPNetFromRPC(rc.Route), Interface: rc.Interface},
+		Severity: Severity(rc.Severity),
+	}
+}
@@ -0,0 +1,49 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+
+	"github.com/datawire/dlib/dexec"
+)
+
+// GetRoutingTable returns the IPv4 and IPv6 routes of the local host.
+func GetRoutingTable(_ context.Context) ([]*Route, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	routes, err := parseProcNetRoute(f)
+	if err != nil {
+		return nil, err
+	}
+
+	f6, err := os.Open("/proc/net/ipv6_route")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			// IPv6 is disabled
+			return routes, nil
+		}
+		return nil, err
+	}
+	defer f6.Close()
+	routes6, err := parseProcNetIPv6Route(f6)
+	if err != nil {
+		return nil, err
+	}
+	return append(routes, routes6...), nil
+}
+
+// AddGatewayRoute adds a route of the given subnet via the given gateway.
+func AddGatewayRoute(ctx context.Context, subnet *net.IPNet, gateway net.IP) error {
+	return dexec.CommandContext(ctx, "ip", "route", "add", subnet.String(), "via", gateway.String()).Run()
+}
+
+// RemoveGatewayRoute removes a route that was added by AddGatewayRoute.
+func RemoveGatewayRoute(ctx context.Context, subnet *net.IPNet, gateway net.IP) error {
+	return dexec.CommandContext(ctx, "ip", "route", "del", subnet.String(), "via", gateway.String()).Run()
+}
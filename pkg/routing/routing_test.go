@@ -0,0 +1,282 @@
+package routing
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseCIDR(t *testing.T, cidr string) *net.IPNet {
+	_, sn, err := net.ParseCIDR(cidr)
+	require.NoError(t, err)
+	return sn
+}
+
+func loadTable(t *testing.T, parse func(io.Reader) ([]*Route, error), files ...string) []*Route {
+	var table []*Route
+	for _, file := range files {
+		f, err := os.Open(filepath.Join("testdata", file))
+		require.NoError(t, err)
+		routes, err := parse(f)
+		f.Close()
+		require.NoError(t, err)
+		table = append(table, routes...)
+	}
+	return table
+}
+
+func findRoute(table []*Route, cidr string) *Route {
+	for _, r := range table {
+		if r.RoutedNet.String() == cidr {
+			return r
+		}
+	}
+	return nil
+}
+
+func TestParse(t *testing.T) {
+	type route struct {
+		cidr  string
+		iface string
+		gw    string
+	}
+	tests := []struct {
+		name   string
+		table  func(t *testing.T) []*Route
+		count  int
+		routes []route
+	}{
+		{
+			name: "linux",
+			table: func(t *testing.T) []*Route {
+				return append(
+					loadTable(t, parseProcNetRoute, "linux_route.txt"),
+					loadTable(t, parseProcNetIPv6Route, "linux_ipv6_route.txt")...)
+			},
+			count: 11,
+			routes: []route{
+				{"0.0.0.0/0", "wlp2s0", "192.168.1.1"},
+				{"10.8.0.0/16", "tun0", "10.8.0.1"},
+				{"10.8.0.1/32", "tun0", ""},
+				{"192.168.1.0/24", "wlp2s0", ""},
+				{"fd00:1234::/64", "tun0", ""},
+				{"::/0", "wlp2s0", "fe80::1"},
+				{"::1/128", "lo", ""},
+			},
+		},
+		{
+			name:  "darwin",
+			table: func(t *testing.T) []*Route { return loadTable(t, parseNetstat, "darwin_netstat.txt") },
+			count: 20,
+			routes: []route{
+				{"0.0.0.0/0", "en0", "192.168.0.1"},
+				{"10.8.0.0/16", "utun3", "10.8.0.1"},
+				{"127.0.0.0/8", "lo0", "127.0.0.1"},
+				{"172.16.0.0/22", "en0", ""},
+				{"192.168.0.0/24", "en0", ""},
+				{"192.168.0.14/32", "en0", ""},
+				{"224.0.0.0/4", "en0", ""},
+				{"::/0", "utun0", "fe80::"},
+				{"fd00:1234::/64", "utun3", "fe80::1"},
+				{"fe80::/64", "lo0", "fe80::1"}, // the first of two
+			},
+		},
+		{
+			name:  "windows",
+			table: func(t *testing.T) []*Route { return loadTable(t, parseRoutePrint, "windows_route_print.txt") },
+			count: 17,
+			routes: []route{
+				{"0.0.0.0/0", "192.168.0.23", "192.168.0.1"},
+				{"10.8.0.0/16", "10.8.0.14", "10.8.0.1"},
+				{"127.0.0.0/8", "127.0.0.1", ""},
+				{"172.16.0.0/22", "192.168.0.23", ""},
+				{"fd00:1234::/64", "19", "fe80::1"},
+				{"fe80::b4c1:2f59:6ff4:d0e/128", "12", ""},
+				{"ff00::/8", "1", ""},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			table := tt.table(t)
+			assert.Len(t, table, tt.count)
+			for _, er := range tt.routes {
+				r := findRoute(table, er.cidr)
+				if !assert.NotNil(t, r, "no route for %s", er.cidr) {
+					continue
+				}
+				assert.Equal(t, er.iface, r.Interface, er.cidr)
+				if er.gw == "" {
+					assert.Nil(t, r.Gateway, er.cidr)
+				} else {
+					assert.Equal(t, er.gw, r.Gateway.String(), er.cidr)
+				}
+			}
+		})
+	}
+}
+
+func TestFindConflicts(t *testing.T) {
+	type conflict struct {
+		subnet   string
+		route    string
+		severity Severity
+	}
+	tests := []struct {
+		name  string
+		table func(t *testing.T) []*Route
+		own   string
+		want  []conflict
+	}{
+		{
+			name: "linux",
+			table: func(t *testing.T) []*Route {
+				return append(
+					loadTable(t, parseProcNetRoute, "linux_route.txt"),
+					loadTable(t, parseProcNetIPv6Route, "linux_ipv6_route.txt")...)
+			},
+			own: "tel0",
+			want: []conflict{
+				{"10.8.0.0/16", "10.8.0.0/16", Error},       // exact collision with the VPN
+				{"172.16.0.0/12", "172.17.0.0/16", Warning}, // partial overlap with the docker bridge
+				{"fd00:1234::/64", "fd00:1234::/64", Error},
+			},
+		},
+		{
+			name:  "darwin",
+			table: func(t *testing.T) []*Route { return loadTable(t, parseNetstat, "darwin_netstat.txt") },
+			own:   "utun4",
+			want: []conflict{
+				{"10.8.0.0/16", "10.8.0.0/16", Error},
+				{"172.16.0.0/12", "172.16.0.0/22", Warning}, // the local network
+				{"fd00:1234::/64", "fd00:1234::/64", Error},
+			},
+		},
+		{
+			name:  "windows",
+			table: func(t *testing.T) []*Route { return loadTable(t, parseRoutePrint, "windows_route_print.txt") },
+			own:   "21",
+			want: []conflict{
+				{"10.8.0.0/16", "10.8.0.0/16", Error},
+				{"172.16.0.0/12", "172.16.0.0/22", Warning},
+				{"fd00:1234::/64", "fd00:1234::/64", Error},
+			},
+		},
+	}
+	subnets := []*net.IPNet{
+		parseCIDR(t, "10.8.0.0/16"),
+		parseCIDR(t, "172.16.0.0/12"),
+		parseCIDR(t, "10.96.0.0/12"),
+		parseCIDR(t, "fd00:1234::/64"),
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			table := tt.table(t)
+			conflicts := FindConflicts(table, subnets, nil, tt.own)
+			got := make([]conflict, len(conflicts))
+			for i, c := range conflicts {
+				got[i] = conflict{c.Subnet.String(), c.Route.RoutedNet.String(), c.Severity}
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, []string{"10.8.0.0/16", "fd00:1234::/64"}, cidrStrings(Errors(conflicts)))
+
+			// An allowed subnet is a warning
+			conflicts = FindConflicts(table, subnets, []*net.IPNet{parseCIDR(t, "10.0.0.0/8")}, tt.own)
+			assert.Equal(t, []string{"fd00:1234::/64"}, cidrStrings(Errors(conflicts)))
+			assert.Len(t, conflicts, 3)
+
+			// Routes of the own interface never conflict
+			ownTable := append(table, &Route{RoutedNet: parseCIDR(t, "10.96.0.0/12"), Interface: tt.own})
+			assert.Len(t, FindConflicts(ownTable, subnets, nil, tt.own), 3)
+		})
+	}
+}
+
+func cidrStrings(sns []*net.IPNet) []string {
+	strs := make([]string, len(sns))
+	for i, sn := range sns {
+		strs[i] = sn.String()
+	}
+	return strs
+}
+
+func TestDefaultRouteMTU(t *testing.T) {
+	ifaces := []net.Interface{
+		{Index: 1, Name: "lo", MTU: 65536},
+		{Index: 2, Name: "eth0", MTU: 1500},
+		{Index: 3, Name: "wg0", MTU: 1420},
+		{Index: 4, Name: "tel0", MTU: 1500},
+	}
+	v4Default := &Route{RoutedNet: parseCIDR(t, "0.0.0.0/0"), Interface: "eth0", Gateway: net.IP{192, 168, 1, 1}}
+	tests := []struct {
+		name     string
+		table    []*Route
+		wantMTU  int
+		wantName string
+	}{
+		{
+			name:     "default route",
+			table:    []*Route{v4Default, {RoutedNet: parseCIDR(t, "10.8.0.0/16"), Interface: "wg0"}},
+			wantMTU:  1500,
+			wantName: "eth0",
+		},
+		{
+			name:     "IPv6 default route on a VPN",
+			table:    []*Route{v4Default, {RoutedNet: parseCIDR(t, "::/0"), Interface: "wg0"}},
+			wantMTU:  1420,
+			wantName: "wg0",
+		},
+		{
+			name:     "interface identified by index",
+			table:    []*Route{{RoutedNet: parseCIDR(t, "::/0"), Interface: "3"}},
+			wantMTU:  1420,
+			wantName: "wg0",
+		},
+		{
+			name:  "own interface",
+			table: []*Route{{RoutedNet: parseCIDR(t, "0.0.0.0/0"), Interface: "tel0"}},
+		},
+		{
+			name:  "unknown interface",
+			table: []*Route{{RoutedNet: parseCIDR(t, "0.0.0.0/0"), Interface: "ppp0"}},
+		},
+		{
+			name: "no default route",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			mtu, name := DefaultRouteMTU(tt.table, ifaces, "tel0")
+			assert.Equal(t, tt.wantMTU, mtu)
+			assert.Equal(t, tt.wantName, name)
+		})
+	}
+}
+
+func TestInterfaceIdentifiers(t *testing.T) {
+	assert.Nil(t, InterfaceIdentifiers(""))
+	assert.Equal(t, []string{"no-such-iface"}, InterfaceIdentifiers("no-such-iface"))
+
+	ifaces, err := net.Interfaces()
+	require.NoError(t, err)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback == 0 {
+			continue
+		}
+		ids := InterfaceIdentifiers(iface.Name)
+		require.GreaterOrEqual(t, len(ids), 2)
+		assert.Equal(t, iface.Name, ids[0])
+		assert.Equal(t, strconv.Itoa(iface.Index), ids[1])
+		return
+	}
+	t.Skip("no loopback interface")
+}
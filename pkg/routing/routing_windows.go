@@ -0,0 +1,30 @@
+package routing
+
+import (
+	"bytes"
+	"context"
+	"net"
+
+	"github.com/datawire/dlib/dexec"
+)
+
+// GetRoutingTable returns the IPv4 and IPv6 routes of the local host.
+func GetRoutingTable(ctx context.Context) ([]*Route, error) {
+	cmd := dexec.CommandContext(ctx, "route", "print")
+	cmd.DisableLogging = true
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseRoutePrint(bytes.NewReader(out))
+}
+
+// AddGatewayRoute adds a route of the given subnet via the given gateway.
+func AddGatewayRoute(ctx context.Context, subnet *net.IPNet, gateway net.IP) error {
+	return dexec.CommandContext(ctx, "route", "ADD", subnet.IP.String(), "MASK", net.IP(subnet.Mask).String(), gateway.String()).Run()
+}
+
+// RemoveGatewayRoute removes a route that was added by AddGatewayRoute.
+func RemoveGatewayRoute(ctx context.Context, subnet *net.IPNet, gateway net.IP) error {
+	return dexec.CommandContext(ctx, "route", "DELETE", subnet.IP.String(), "MASK", net.IP(subnet.Mask).String(), gateway.String()).Run()
+}
@@ -0,0 +1,394 @@
+// Package sftpmount mirrors a directory of an SFTP server in a local directory. It's used to make the
+// remote volumes of an intercept available when sshfs or FUSE can't be used on the local machine.
+//
+// A mirror isn't a real mount and has limitations that a FUSE mount doesn't have:
+//   - the remote files are copied when the mirror starts and remote changes are picked up periodically;
+//   - local changes are written back periodically, so the remote side is only eventually consistent;
+//   - a file that is changed both locally and remotely is overwritten with the local content;
+//   - changes are never announced using file system notifications such as inotify on either side.
+package sftpmount
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+const (
+	// DefaultWriteBackInterval is the default interval between write-backs of local changes
+	DefaultWriteBackInterval = 2 * time.Second
+
+	// DefaultRefreshInterval is the default interval between refreshes of remote changes
+	DefaultRefreshInterval = 10 * time.Second
+
+	// maxDepth limits how deep symlinked directories are followed so that a symlink cycle can't
+	// make the mirror grow indefinitely.
+	maxDepth = 32
+)
+
+// entry is the state of a file or directory when it was last synced
+type entry struct {
+	dir        bool
+	localMod   time.Time
+	localSize  int64
+	remoteMod  time.Time
+	remoteSize int64
+}
+
+// Mount mirrors a remote directory in a local directory. A Mount retains its state between calls to
+// Run, so when the connection to the SFTP server breaks, local changes that haven't been written back
+// are written back using the next connection.
+type Mount struct {
+	remoteDir string
+	localDir  string
+	readOnly  bool
+
+	// WriteBackInterval is the interval between write-backs of local changes
+	WriteBackInterval time.Duration
+
+	// RefreshInterval is the interval between refreshes of remote changes
+	RefreshInterval time.Duration
+
+	// entries are the synced files and directories keyed by their slash separated path relative to
+	// the remote and local directories.
+	entries map[string]*entry
+}
+
+// NewMount returns a Mount that mirrors the given remote directory in the given local directory. Local
+// changes are never written back when readOnly is true, and the local files are then made read-only.
+func NewMount(remoteDir, localDir string, readOnly bool) *Mount {
+	return &Mount{
+		remoteDir:         remoteDir,
+		localDir:          localDir,
+		readOnly:          readOnly,
+		WriteBackInterval: DefaultWriteBackInterval,
+		RefreshInterval:   DefaultRefreshInterval,
+		entries:           make(map[string]*entry),
+	}
+}
+
+// Run copies the remote files to the local directory and then keeps writing back local changes and
+// refreshing remote changes until the context is cancelled or the client fails. Local changes are
+// written back one last time when the context is cancelled.
+func (m *Mount) Run(ctx context.Context, c *sftp.Client) error {
+	if err := m.Pull(ctx, c); err != nil {
+		return err
+	}
+	writeBack := time.NewTicker(m.WriteBackInterval)
+	defer writeBack.Stop()
+	refresh := time.NewTicker(m.RefreshInterval)
+	defer refresh.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return m.Push(ctx, c)
+		case <-writeBack.C:
+			if err := m.Push(ctx, c); err != nil {
+				return err
+			}
+		case <-refresh.C:
+			if err := m.Pull(ctx, c); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Pull copies the remote files that are new or have changed since the last sync to the local directory
+// and removes the local files that have been removed remotely. Local files that have changed since the
+// last sync are left as they are, and will overwrite the remote files on the next Push.
+func (m *Mount) Pull(ctx context.Context, c *sftp.Client) error {
+	seen := make(map[string]struct{})
+	if err := m.pullDir(ctx, c, "", 0, seen); err != nil {
+		return err
+	}
+	for _, rel := range m.sortedPaths() {
+		if _, ok := seen[rel]; ok {
+			continue
+		}
+		e := m.entries[rel]
+		delete(m.entries, rel)
+		lp := m.localPath(rel)
+		if e.dir {
+			// A directory with new local files is recreated remotely on the next Push
+			_ = os.Remove(lp)
+		} else if m.localUnchanged(rel, e) {
+			if err := os.Remove(lp); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Mount) pullDir(ctx context.Context, c *sftp.Client, rel string, depth int, seen map[string]struct{}) error {
+	fis, err := c.ReadDir(m.remotePath(rel))
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		cr := path.Join(rel, fi.Name())
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if fi, err = c.Stat(m.remotePath(cr)); err != nil {
+				// dangling symlink
+				continue
+			}
+		}
+		e := m.entries[cr]
+		if e != nil && e.dir != fi.IsDir() {
+			// A file was replaced by a directory, or vice versa
+			if err = os.RemoveAll(m.localPath(cr)); err != nil {
+				return err
+			}
+			delete(m.entries, cr)
+			e = nil
+		}
+		switch {
+		case fi.IsDir():
+			if depth >= maxDepth {
+				continue
+			}
+			seen[cr] = struct{}{}
+			if e == nil {
+				if err = os.MkdirAll(m.localPath(cr), 0700); err != nil {
+					return err
+				}
+				m.entries[cr] = &entry{dir: true}
+			} else if _, err = os.Stat(m.localPath(cr)); os.IsNotExist(err) {
+				// Removed locally. The next Push removes it remotely.
+				prefix := cr + "/"
+				for rel := range m.entries {
+					if strings.HasPrefix(rel, prefix) {
+						seen[rel] = struct{}{}
+					}
+				}
+				continue
+			}
+			if err = m.pullDir(ctx, c, cr, depth+1, seen); err != nil {
+				return err
+			}
+		case fi.Mode().IsRegular():
+			seen[cr] = struct{}{}
+			if e != nil && (e.remoteMod.Equal(fi.ModTime()) && e.remoteSize == fi.Size() || !m.localUnchanged(cr, e)) {
+				continue
+			}
+			if err = m.download(c, cr, fi); err != nil {
+				if errors.Is(err, os.ErrPermission) {
+					dlog.Warnf(ctx, "unable to read %s: %v", m.remotePath(cr), err)
+					delete(seen, cr)
+					continue
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// download copies a remote file to a temporary file which then replaces the local file, so that local
+// readers never see a partially copied file.
+func (m *Mount) download(c *sftp.Client, rel string, fi os.FileInfo) (err error) {
+	rf, err := c.Open(m.remotePath(rel))
+	if err != nil {
+		return err
+	}
+	defer rf.Close()
+
+	lp := m.localPath(rel)
+	tmp, err := os.CreateTemp(filepath.Dir(lp), "."+filepath.Base(lp)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = rf.WriteTo(tmp); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	perm := fi.Mode().Perm() | 0400
+	if m.readOnly {
+		perm &^= 0222
+		// A read-only file can't be replaced on windows
+		_ = os.Chmod(lp, 0600)
+	}
+	if err = os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if err = os.Chtimes(tmp.Name(), fi.ModTime(), fi.ModTime()); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), lp); err != nil {
+		return err
+	}
+	lfi, err := os.Stat(lp)
+	if err != nil {
+		return err
+	}
+	m.entries[rel] = &entry{localMod: lfi.ModTime(), localSize: lfi.Size(), remoteMod: fi.ModTime(), remoteSize: fi.Size()}
+	return nil
+}
+
+// Push writes local files that are new or have changed since the last sync back to the remote directory
+// and removes the remote files that have been removed locally. Push does nothing when the mount is
+// read-only.
+func (m *Mount) Push(ctx context.Context, c *sftp.Client) error {
+	if m.readOnly {
+		return nil
+	}
+	seen := make(map[string]struct{})
+	err := filepath.Walk(m.localDir, func(lp string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(m.localDir, lp)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		seen[rel] = struct{}{}
+		e := m.entries[rel]
+		switch {
+		case fi.IsDir():
+			if e == nil {
+				if err = c.MkdirAll(m.remotePath(rel)); err != nil {
+					return err
+				}
+				m.entries[rel] = &entry{dir: true}
+			}
+		case fi.Mode().IsRegular():
+			if e != nil && e.localMod.Equal(fi.ModTime()) && e.localSize == fi.Size() {
+				return nil
+			}
+			if err = m.upload(c, rel, lp, fi); err != nil {
+				if errors.Is(err, os.ErrPermission) {
+					dlog.Warnf(ctx, "unable to write %s: %v", m.remotePath(rel), err)
+					return nil
+				}
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, rel := range m.sortedPaths() {
+		if _, ok := seen[rel]; ok {
+			continue
+		}
+		e := m.entries[rel]
+		delete(m.entries, rel)
+		rp := m.remotePath(rel)
+		if e.dir {
+			err = c.RemoveDirectory(rp)
+		} else {
+			err = c.Remove(rp)
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			dlog.Warnf(ctx, "unable to remove %s: %v", rp, err)
+		}
+	}
+	return nil
+}
+
+func (m *Mount) upload(c *sftp.Client, rel, lp string, fi os.FileInfo) error {
+	lf, err := os.Open(lp)
+	if err != nil {
+		return err
+	}
+	defer lf.Close()
+
+	rp := m.remotePath(rel)
+	rf, err := c.OpenFile(rp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err = rf.ReadFrom(lf); err != nil {
+		_ = rf.Close()
+		return err
+	}
+	if err = rf.Close(); err != nil {
+		return err
+	}
+	rfi, err := c.Stat(rp)
+	if err != nil {
+		return err
+	}
+	m.entries[rel] = &entry{localMod: fi.ModTime(), localSize: fi.Size(), remoteMod: rfi.ModTime(), remoteSize: rfi.Size()}
+	return nil
+}
+
+// Clean removes the contents of the local directory. Local changes that haven't been written back are lost.
+func (m *Mount) Clean() error {
+	des, err := os.ReadDir(m.localDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return err
+	}
+	for _, de := range des {
+		lp := filepath.Join(m.localDir, de.Name())
+		// Read-only files can't be removed on windows
+		_ = filepath.Walk(lp, func(p string, fi os.FileInfo, err error) error {
+			if err == nil && fi.Mode().IsRegular() {
+				_ = os.Chmod(p, 0600)
+			}
+			return nil
+		})
+		if err = os.RemoveAll(lp); err != nil {
+			return err
+		}
+	}
+	m.entries = make(map[string]*entry)
+	return nil
+}
+
+// localUnchanged returns true if the local file hasn't changed since it was last synced
+func (m *Mount) localUnchanged(rel string, e *entry) bool {
+	fi, err := os.Stat(m.localPath(rel))
+	return err == nil && fi.ModTime().Equal(e.localMod) && fi.Size() == e.localSize
+}
+
+func (m *Mount) localPath(rel string) string {
+	return filepath.Join(m.localDir, filepath.FromSlash(rel))
+}
+
+func (m *Mount) remotePath(rel string) string {
+	return path.Join(m.remoteDir, rel)
+}
+
+// sortedPaths returns the paths of all entries, deepest first, so that files are removed before the
+// directories that contain them.
+func (m *Mount) sortedPaths() []string {
+	paths := make([]string, 0, len(m.entries))
+	for rel := range m.entries {
+		paths = append(paths, rel)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		di, dj := strings.Count(paths[i], "/"), strings.Count(paths[j], "/")
+		if di != dj {
+			return di > dj
+		}
+		return paths[i] < paths[j]
+	})
+	return paths
+}
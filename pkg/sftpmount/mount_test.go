@@ -0,0 +1,194 @@
+package sftpmount
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+type pipeConn struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// fakeServer starts an SFTP server that serves the local file system and returns a client connected to it
+func fakeServer(t *testing.T) *sftp.Client {
+	t.Helper()
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	server, err := sftp.NewServer(pipeConn{Reader: sr, WriteCloser: sw})
+	require.NoError(t, err)
+	go func() {
+		// Closing the server closes the pipe that the client reads from, which ends the client
+		_ = server.Serve()
+		_ = server.Close()
+	}()
+	c, err := sftp.NewClientPipe(cr, cw)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	return c
+}
+
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(name), 0700))
+	require.NoError(t, os.WriteFile(name, []byte(content), 0644))
+}
+
+func assertContent(t *testing.T, name, content string) {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data))
+}
+
+// touch makes the file look changed, even when it's changed within the same second as the last sync
+func touch(t *testing.T, name string) {
+	t.Helper()
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(name, later, later))
+}
+
+func TestMount_read(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	c := fakeServer(t)
+	remote, local := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(remote, "config.yaml"), "level: info\n")
+	writeFile(t, filepath.Join(remote, "certs", "ca.crt"), "-----BEGIN CERTIFICATE-----\n")
+	if runtime.GOOS != "windows" {
+		require.NoError(t, os.Symlink(filepath.Join(remote, "certs"), filepath.Join(remote, "linked")))
+	}
+
+	m := NewMount(filepath.ToSlash(remote), local, false)
+	require.NoError(t, m.Pull(ctx, c))
+	assertContent(t, filepath.Join(local, "config.yaml"), "level: info\n")
+	assertContent(t, filepath.Join(local, "certs", "ca.crt"), "-----BEGIN CERTIFICATE-----\n")
+	if runtime.GOOS != "windows" {
+		assertContent(t, filepath.Join(local, "linked", "ca.crt"), "-----BEGIN CERTIFICATE-----\n")
+	}
+
+	// Remote changes are picked up by a refresh
+	writeFile(t, filepath.Join(remote, "config.yaml"), "level: debug\n")
+	touch(t, filepath.Join(remote, "config.yaml"))
+	require.NoError(t, os.Remove(filepath.Join(remote, "certs", "ca.crt")))
+	require.NoError(t, m.Pull(ctx, c))
+	assertContent(t, filepath.Join(local, "config.yaml"), "level: debug\n")
+	assert.NoFileExists(t, filepath.Join(local, "certs", "ca.crt"))
+
+	// Nothing is left behind
+	require.NoError(t, m.Clean())
+	des, err := os.ReadDir(local)
+	require.NoError(t, err)
+	assert.Empty(t, des)
+}
+
+func TestMount_writeBack(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	c := fakeServer(t)
+	remote, local := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(remote, "data", "a.txt"), "a")
+	writeFile(t, filepath.Join(remote, "data", "b.txt"), "b")
+
+	m := NewMount(filepath.ToSlash(remote), local, false)
+	require.NoError(t, m.Pull(ctx, c))
+
+	// Changed, new, and removed files are written back
+	writeFile(t, filepath.Join(local, "data", "a.txt"), "changed a")
+	writeFile(t, filepath.Join(local, "new", "c.txt"), "c")
+	require.NoError(t, os.Remove(filepath.Join(local, "data", "b.txt")))
+	require.NoError(t, m.Push(ctx, c))
+	assertContent(t, filepath.Join(remote, "data", "a.txt"), "changed a")
+	assertContent(t, filepath.Join(remote, "new", "c.txt"), "c")
+	assert.NoFileExists(t, filepath.Join(remote, "data", "b.txt"))
+
+	// A local change wins over a remote change
+	writeFile(t, filepath.Join(local, "data", "a.txt"), "local a")
+	touch(t, filepath.Join(local, "data", "a.txt"))
+	writeFile(t, filepath.Join(remote, "data", "a.txt"), "remote a")
+	touch(t, filepath.Join(remote, "data", "a.txt"))
+	require.NoError(t, m.Pull(ctx, c))
+	assertContent(t, filepath.Join(local, "data", "a.txt"), "local a")
+	require.NoError(t, m.Push(ctx, c))
+	assertContent(t, filepath.Join(remote, "data", "a.txt"), "local a")
+
+	// Local changes are written back when the mount ends
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() {
+		done <- m.Run(runCtx, c)
+	}()
+	writeFile(t, filepath.Join(local, "d.txt"), "d")
+	cancel()
+	require.NoError(t, <-done)
+	assertContent(t, filepath.Join(remote, "d.txt"), "d")
+}
+
+func TestMount_readOnly(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	c := fakeServer(t)
+	remote, local := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(remote, "a.txt"), "a")
+
+	m := NewMount(filepath.ToSlash(remote), local, true)
+	require.NoError(t, m.Pull(ctx, c))
+	assertContent(t, filepath.Join(local, "a.txt"), "a")
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(filepath.Join(local, "a.txt"))
+		require.NoError(t, err)
+		assert.Zero(t, fi.Mode().Perm()&0222, "the local file must be read-only")
+	}
+
+	// Local changes are never written back
+	writeFile(t, filepath.Join(local, "b.txt"), "b")
+	require.NoError(t, os.Remove(filepath.Join(local, "a.txt")))
+	require.NoError(t, m.Push(ctx, c))
+	assert.NoFileExists(t, filepath.Join(remote, "b.txt"))
+	assertContent(t, filepath.Join(remote, "a.txt"), "a")
+	require.NoError(t, m.Clean())
+}
+
+func TestMount_largeFile(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	c := fakeServer(t)
+	remote, local := t.TempDir(), t.TempDir()
+
+	// Much larger than the maximum packet size of the SFTP protocol
+	large := make([]byte, 5*1024*1024+17)
+	rand.New(rand.NewSource(1)).Read(large)
+	require.NoError(t, os.WriteFile(filepath.Join(remote, "large.bin"), large, 0644))
+
+	m := NewMount(filepath.ToSlash(remote), local, false)
+	require.NoError(t, m.Pull(ctx, c))
+	data, err := os.ReadFile(filepath.Join(local, "large.bin"))
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(large, data), "downloaded content differs")
+
+	// No temporary files are left behind
+	des, err := os.ReadDir(local)
+	require.NoError(t, err)
+	assert.Len(t, des, 1)
+
+	// Write back a changed large file
+	large[len(large)/2] ^= 0xff
+	large = append(large, large[:1024*1024]...)
+	require.NoError(t, os.WriteFile(filepath.Join(local, "large.bin"), large, 0644))
+	touch(t, filepath.Join(local, "large.bin"))
+	require.NoError(t, m.Push(ctx, c))
+	data, err = os.ReadFile(filepath.Join(remote, "large.bin"))
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(large, data), "written back content differs")
+}
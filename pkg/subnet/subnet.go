@@ -173,3 +173,57 @@ func Covers(a, b *net.IPNet) bool {
 	}
 	return a.Contains(m)
 }
+
+// Overlaps answers the question if network range a and network range b have any addresses in common
+func Overlaps(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// Subtract returns the subnets that cover all addresses of network range a that aren't in network
+// range b. The result is a itself when a and b don't overlap, empty when b covers a, and otherwise
+// the sub-ranges that remain when a is split until b is excluded.
+func Subtract(a, b *net.IPNet) []*net.IPNet {
+	if !Overlaps(a, b) {
+		return []*net.IPNet{a}
+	}
+	if Covers(b, a) {
+		return nil
+	}
+
+	// b is a proper sub-range of a. Split a into two halves, keep the half that doesn't
+	// contain b, and continue with the one that does.
+	ones, bits := a.Mask.Size()
+	ip := a.IP.Mask(a.Mask)
+	var result []*net.IPNet
+	for {
+		ones++
+		mask := net.CIDRMask(ones, bits)
+		lo := &net.IPNet{IP: ip, Mask: mask}
+		hi := &net.IPNet{IP: make(net.IP, len(ip)), Mask: mask}
+		copy(hi.IP, ip)
+		hi.IP[(ones-1)/8] |= 0x80 >> ((ones - 1) % 8)
+
+		keep, split := lo, hi
+		if lo.Contains(b.IP) {
+			keep, split = hi, lo
+		}
+		result = append(result, keep)
+		if Covers(b, split) {
+			return result
+		}
+		ip = split.IP
+	}
+}
+
+// SubtractAll returns the subnets that cover all addresses of the given subnets that aren't in
+// any of the excluded subnets.
+func SubtractAll(subnets, excluded []*net.IPNet) []*net.IPNet {
+	for _, x := range excluded {
+		var remaining []*net.IPNet
+		for _, sn := range subnets {
+			remaining = append(remaining, Subtract(sn, x)...)
+		}
+		subnets = remaining
+	}
+	return subnets
+}
@@ -187,3 +187,114 @@ func TestUnique(t *testing.T) {
 		})
 	}
 }
+
+func cidrStrings(sns []*net.IPNet) []string {
+	strs := make([]string, len(sns))
+	for i, sn := range sns {
+		strs[i] = sn.String()
+	}
+	return strs
+}
+
+func parseCIDRs(t *testing.T, cidrs ...string) []*net.IPNet {
+	sns := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, sn, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		sns[i] = sn
+	}
+	return sns
+}
+
+func TestSubtract(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want []string
+	}{
+		{
+			name: "disjoint",
+			a:    "10.244.0.0/16",
+			b:    "10.245.0.0/16",
+			want: []string{"10.244.0.0/16"},
+		},
+		{
+			name: "exact overlap",
+			a:    "10.244.0.0/16",
+			b:    "10.244.0.0/16",
+			want: nil,
+		},
+		{
+			name: "subtracted range covers the subnet",
+			a:    "10.244.3.0/24",
+			b:    "10.244.0.0/16",
+			want: nil,
+		},
+		{
+			name: "contained range at the start",
+			a:    "10.244.0.0/16",
+			b:    "10.244.0.0/18",
+			want: []string{"10.244.128.0/17", "10.244.64.0/18"},
+		},
+		{
+			name: "contained range at the end",
+			a:    "10.244.0.0/16",
+			b:    "10.244.128.0/17",
+			want: []string{"10.244.0.0/17"},
+		},
+		{
+			name: "contained range in the middle",
+			a:    "192.168.0.0/22",
+			b:    "192.168.1.0/24",
+			want: []string{"192.168.2.0/23", "192.168.0.0/24"},
+		},
+		{
+			name: "contained host address",
+			a:    "10.0.0.0/30",
+			b:    "10.0.0.2/32",
+			want: []string{"10.0.0.0/31", "10.0.0.3/32"},
+		},
+		{
+			name: "ipv6",
+			a:    "fd00::/64",
+			b:    "fd00::/65",
+			want: []string{"fd00::8000:0:0:0/65"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			sns := parseCIDRs(t, tt.a, tt.b)
+			got := Subtract(sns[0], sns[1])
+			if tt.want == nil {
+				assert.Empty(t, got)
+			} else {
+				assert.Equal(t, tt.want, cidrStrings(got))
+			}
+		})
+	}
+}
+
+func TestSubtractAll(t *testing.T) {
+	// The first excluded range partially overlaps the subnets, i.e. it covers one and is contained in
+	// another, and the second one is equal to one of the remaining sub-ranges.
+	subnets := parseCIDRs(t, "10.96.0.0/12", "10.244.0.0/16", "10.244.5.0/24")
+	excluded := parseCIDRs(t, "10.244.4.0/22", "10.244.128.0/17")
+	assert.Equal(t, []string{
+		"10.96.0.0/12",
+		"10.244.64.0/18",
+		"10.244.32.0/19",
+		"10.244.16.0/20",
+		"10.244.8.0/21",
+		"10.244.0.0/22",
+	}, cidrStrings(SubtractAll(subnets, excluded)))
+
+	// The remaining ranges and the excluded ones together cover the original subnets, without overlap
+	remaining := SubtractAll(subnets, excluded)
+	for _, r := range remaining {
+		for _, x := range excluded {
+			assert.False(t, Overlaps(r, x), "%s overlaps %s", r, x)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/common"
+)
+
+// The jaeger types are the JSON format that the Jaeger UI can load from a file.
+type jaegerTraces struct {
+	Data []*jaegerTrace `json:"data"`
+}
+
+type jaegerTrace struct {
+	TraceID   string                    `json:"traceID"`
+	Spans     []*jaegerSpan             `json:"spans"`
+	Processes map[string]*jaegerProcess `json:"processes"`
+}
+
+type jaegerSpan struct {
+	TraceID       string            `json:"traceID"`
+	SpanID        string            `json:"spanID"`
+	OperationName string            `json:"operationName"`
+	References    []jaegerReference `json:"references"`
+	StartTime     int64             `json:"startTime"` // microseconds since epoch
+	Duration      int64             `json:"duration"`  // microseconds
+	Tags          []jaegerTag       `json:"tags"`
+	Logs          []interface{}     `json:"logs"`
+	ProcessID     string            `json:"processID"`
+}
+
+type jaegerReference struct {
+	RefType string `json:"refType"`
+	TraceID string `json:"traceID"`
+	SpanID  string `json:"spanID"`
+}
+
+type jaegerTag struct {
+	Key   string      `json:"key"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+type jaegerProcess struct {
+	ServiceName string      `json:"serviceName"`
+	Tags        []jaegerTag `json:"tags"`
+}
+
+// WriteJaegerJSON merges the spans of the given traces and writes them to w in a format that the Jaeger
+// UI can load. A reference to a parent span that isn't among the given spans, such as one that was
+// recorded by the short-lived CLI, is dropped so that the span shows up as a root.
+func WriteJaegerJSON(w io.Writer, traces ...*common.Traces) error {
+	known := make(map[string]bool)
+	for _, t := range traces {
+		for _, s := range t.GetSpans() {
+			known[s.SpanId] = true
+		}
+	}
+
+	byTrace := make(map[string]*jaegerTrace)
+	for _, t := range traces {
+		for _, s := range t.GetSpans() {
+			jt, ok := byTrace[s.TraceId]
+			if !ok {
+				jt = &jaegerTrace{TraceID: s.TraceId, Processes: make(map[string]*jaegerProcess)}
+				byTrace[s.TraceId] = jt
+			}
+			// The service name is unique per process, so it doubles as the process ID.
+			if _, ok := jt.Processes[s.Service]; !ok {
+				jt.Processes[s.Service] = &jaegerProcess{ServiceName: s.Service, Tags: []jaegerTag{}}
+			}
+			jt.Spans = append(jt.Spans, toJaegerSpan(s, known[s.ParentSpanId]))
+		}
+	}
+
+	jts := &jaegerTraces{Data: make([]*jaegerTrace, 0, len(byTrace))}
+	for _, jt := range byTrace {
+		sort.Slice(jt.Spans, func(i, j int) bool { return jt.Spans[i].StartTime < jt.Spans[j].StartTime })
+		jts.Data = append(jts.Data, jt)
+	}
+	sort.Slice(jts.Data, func(i, j int) bool { return jts.Data[i].Spans[0].StartTime < jts.Data[j].Spans[0].StartTime })
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jts)
+}
+
+func toJaegerSpan(s *common.Span, parentKnown bool) *jaegerSpan {
+	js := &jaegerSpan{
+		TraceID:       s.TraceId,
+		SpanID:        s.SpanId,
+		OperationName: s.Name,
+		References:    []jaegerReference{},
+		StartTime:     s.StartTime.AsTime().UnixNano() / 1000,
+		Duration:      s.Duration.AsDuration().Microseconds(),
+		Tags:          make([]jaegerTag, 0, len(s.Attributes)+1),
+		Logs:          []interface{}{},
+		ProcessID:     s.Service,
+	}
+	if s.ParentSpanId != "" && parentKnown {
+		js.References = append(js.References, jaegerReference{RefType: "CHILD_OF", TraceID: s.TraceId, SpanID: s.ParentSpanId})
+	}
+	keys := make([]string, 0, len(s.Attributes))
+	for k := range s.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		js.Tags = append(js.Tags, jaegerTag{Key: k, Type: "string", Value: s.Attributes[k]})
+	}
+	if s.Error {
+		js.Tags = append(js.Tags, jaegerTag{Key: "error", Type: "bool", Value: true})
+		if s.StatusMessage != "" {
+			js.Tags = append(js.Tags, jaegerTag{Key: "otel.status_description", Type: "string", Value: s.StatusMessage})
+		}
+	}
+	return js
+}
@@ -0,0 +1,160 @@
+// Package tracing instruments Telepresence with OpenTelemetry. Tracing is off unless a process calls Start,
+// in which case the spans that end in the process are kept in a bounded in-memory buffer that can be
+// retrieved using Traces, and the trace context is propagated in the metadata of the gRPC calls that use
+// the ServerOptions and DialOptions of this package.
+package tracing
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/common"
+)
+
+// instrumentationName is the name of the tracer that Telepresence creates its own spans with.
+const instrumentationName = "github.com/telepresenceio/telepresence/v2"
+
+// DefaultBufferSize is the number of spans that a process keeps before it starts to discard the oldest ones.
+const DefaultBufferSize = 10000
+
+var (
+	bufferLock sync.Mutex
+	buffer     *spanBuffer
+)
+
+// Start makes the process record spans and keep the most recent bufferSize of them in memory. A bufferSize
+// of zero makes the process propagate the trace context of its calls without keeping any spans. The returned
+// function stops the recording and discards the buffer.
+func Start(ctx context.Context, service string, bufferSize int) func(context.Context) {
+	sb := &spanBuffer{service: service, spans: make([]*common.Span, 0, bufferSize), size: bufferSize}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(sb))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	bufferLock.Lock()
+	buffer = sb
+	bufferLock.Unlock()
+	return func(ctx context.Context) {
+		bufferLock.Lock()
+		if buffer == sb {
+			buffer = nil
+		}
+		bufferLock.Unlock()
+		_ = tp.Shutdown(ctx)
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	}
+}
+
+// Traces returns the buffered spans of this process. The result is empty unless tracing has been started.
+func Traces() *common.Traces {
+	bufferLock.Lock()
+	sb := buffer
+	bufferLock.Unlock()
+	if sb == nil {
+		return &common.Traces{}
+	}
+	return &common.Traces{Spans: sb.snapshot()}
+}
+
+// StartSpan starts a span with the given name as a child of the span in the given context, if any.
+func StartSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// EndSpan records err, if it is not nil, in the span and then ends it.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// ServerOptions returns the options that make a gRPC server continue the traces of its callers.
+func ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(otelgrpc.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(otelgrpc.StreamServerInterceptor()),
+	}
+}
+
+// DialOptions returns the options that make a gRPC client propagate the trace of its calls.
+func DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(otelgrpc.StreamClientInterceptor()),
+	}
+}
+
+// spanBuffer is a span exporter that keeps the most recently ended spans in a ring buffer.
+type spanBuffer struct {
+	sync.Mutex
+	service string
+	spans   []*common.Span
+	size    int
+	next    int // index of the oldest span once the buffer is full
+}
+
+func (sb *spanBuffer) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	sb.Lock()
+	defer sb.Unlock()
+	if sb.size == 0 {
+		return nil
+	}
+	for _, s := range spans {
+		cs := sb.convert(s)
+		if len(sb.spans) < sb.size {
+			sb.spans = append(sb.spans, cs)
+		} else {
+			sb.spans[sb.next] = cs
+			sb.next = (sb.next + 1) % sb.size
+		}
+	}
+	return nil
+}
+
+func (sb *spanBuffer) Shutdown(context.Context) error {
+	return nil
+}
+
+// snapshot returns the buffered spans, oldest first.
+func (sb *spanBuffer) snapshot() []*common.Span {
+	sb.Lock()
+	defer sb.Unlock()
+	spans := make([]*common.Span, 0, len(sb.spans))
+	spans = append(spans, sb.spans[sb.next:]...)
+	return append(spans, sb.spans[:sb.next]...)
+}
+
+func (sb *spanBuffer) convert(s sdktrace.ReadOnlySpan) *common.Span {
+	sc := s.SpanContext()
+	cs := &common.Span{
+		TraceId:       sc.TraceID().String(),
+		SpanId:        sc.SpanID().String(),
+		Name:          s.Name(),
+		Service:       sb.service,
+		StartTime:     timestamppb.New(s.StartTime()),
+		Duration:      durationpb.New(s.EndTime().Sub(s.StartTime())),
+		Error:         s.Status().Code == codes.Error,
+		StatusMessage: s.Status().Description,
+	}
+	if p := s.Parent(); p.IsValid() {
+		cs.ParentSpanId = p.SpanID().String()
+	}
+	if attrs := s.Attributes(); len(attrs) > 0 {
+		cs.Attributes = make(map[string]string, len(attrs))
+		for _, kv := range attrs {
+			cs.Attributes[string(kv.Key)] = kv.Value.Emit()
+		}
+	}
+	return cs
+}
@@ -0,0 +1,150 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/common"
+)
+
+func spanNamed(spans []*common.Span, name, parentID string) *common.Span {
+	for _, s := range spans {
+		if s.Name == name && s.ParentSpanId == parentID {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestTracing_propagation(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	stop := Start(ctx, "test", 100)
+	defer stop(ctx)
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(ServerOptions()...)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	conn, err := grpc.DialContext(ctx, "bufconn", append(DialOptions(),
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+	)...)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	sctx, span := StartSpan(ctx, "root")
+	_, err = healthpb.NewHealthClient(conn).Check(sctx, &healthpb.HealthCheckRequest{})
+	EndSpan(span, err)
+	require.NoError(t, err)
+
+	spans := Traces().Spans
+	root := spanNamed(spans, "root", "")
+	require.NotNil(t, root)
+	client := spanNamed(spans, "grpc.health.v1.Health/Check", root.SpanId)
+	require.NotNil(t, client, "no client span is a child of the root span")
+	server := spanNamed(spans, "grpc.health.v1.Health/Check", client.SpanId)
+	require.NotNil(t, server, "no server span is a child of the client span")
+
+	assert.Equal(t, root.TraceId, client.TraceId)
+	assert.Equal(t, root.TraceId, server.TraceId)
+	assert.Equal(t, "test", server.Service)
+	assert.False(t, server.Error)
+}
+
+func TestTracing_errors(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	stop := Start(ctx, "test", 100)
+	defer stop(ctx)
+
+	_, span := StartSpan(ctx, "failing")
+	EndSpan(span, errors.New("boom"))
+
+	spans := Traces().Spans
+	require.Len(t, spans, 1)
+	assert.True(t, spans[0].Error)
+	assert.Equal(t, "boom", spans[0].StatusMessage)
+}
+
+func TestTracing_buffer(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	assert.Empty(t, Traces().Spans, "spans are recorded although tracing isn't started")
+
+	stop := Start(ctx, "test", 2)
+	for _, name := range []string{"one", "two", "three"} {
+		_, span := StartSpan(ctx, name)
+		span.End()
+	}
+	spans := Traces().Spans
+	require.Len(t, spans, 2)
+	assert.Equal(t, "two", spans[0].Name)
+	assert.Equal(t, "three", spans[1].Name)
+	stop(ctx)
+	assert.Empty(t, Traces().Spans)
+
+	stop = Start(ctx, "test", 0)
+	defer stop(ctx)
+	_, span := StartSpan(ctx, "propagated")
+	span.End()
+	assert.True(t, span.SpanContext().IsValid(), "a zero size buffer must still create spans that can be propagated")
+	assert.Empty(t, Traces().Spans)
+}
+
+func TestWriteJaegerJSON(t *testing.T) {
+	start := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	span := func(service, id, parent string, offset time.Duration) *common.Span {
+		return &common.Span{
+			TraceId:      "t1",
+			SpanId:       id,
+			ParentSpanId: parent,
+			Name:         id,
+			Service:      service,
+			StartTime:    timestamppb.New(start.Add(offset)),
+			Duration:     durationpb.New(time.Millisecond),
+		}
+	}
+	connector := &common.Traces{Spans: []*common.Span{
+		span("connector", "c1", "cli", time.Millisecond),
+		span("connector", "c2", "c1", 2*time.Millisecond),
+	}}
+	daemon := &common.Traces{Spans: []*common.Span{span("daemon", "d1", "c2", 3*time.Millisecond)}}
+	daemon.Spans[0].Error = true
+	daemon.Spans[0].StatusMessage = "boom"
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteJaegerJSON(buf, daemon, connector))
+
+	var result jaegerTraces
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &result))
+	require.Len(t, result.Data, 1)
+	jt := result.Data[0]
+	assert.Equal(t, "t1", jt.TraceID)
+	assert.Len(t, jt.Processes, 2)
+	assert.Equal(t, "daemon", jt.Processes["daemon"].ServiceName)
+
+	require.Len(t, jt.Spans, 3)
+	assert.Equal(t, "c1", jt.Spans[0].SpanID)
+	assert.Empty(t, jt.Spans[0].References, "reference to a span that isn't in the dump")
+	assert.Equal(t, []jaegerReference{{RefType: "CHILD_OF", TraceID: "t1", SpanID: "c1"}}, jt.Spans[1].References)
+	assert.Equal(t, []jaegerReference{{RefType: "CHILD_OF", TraceID: "t1", SpanID: "c2"}}, jt.Spans[2].References)
+	assert.Equal(t, "daemon", jt.Spans[2].ProcessID)
+	assert.Equal(t, start.Add(3*time.Millisecond).UnixNano()/1000, jt.Spans[2].StartTime)
+	assert.Equal(t, int64(1000), jt.Spans[2].Duration)
+	assert.Contains(t, jt.Spans[2].Tags, jaegerTag{Key: "error", Type: "bool", Value: true})
+}
@@ -137,7 +137,7 @@ func (id ConnID) Network() string {
 // TCP, UDP, and ICMP
 func IPProto(network string) int {
 	switch network {
-	case "tcp", "tcp4":
+	case "tcp", "tcp4", "tcp6":
 		return ipproto.TCP
 	case "udp", "udp4", "udp6":
 		return ipproto.UDP
@@ -0,0 +1,96 @@
+package tunnel
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+)
+
+func TestConnID(t *testing.T) {
+	tests := []struct {
+		name      string
+		src, dst  net.IP
+		ipv4      bool
+		protocol  string
+		network   string
+		wantSrc   string
+		wantDst   string
+		wantBytes int
+	}{
+		{
+			name:      "IPv4",
+			src:       net.IP{192, 168, 1, 10},
+			dst:       net.ParseIP("10.96.0.1"), // 16 byte form
+			ipv4:      true,
+			protocol:  "tcp4",
+			network:   "ip4",
+			wantSrc:   "192.168.1.10",
+			wantDst:   "10.96.0.1",
+			wantBytes: 13,
+		},
+		{
+			name:      "IPv6",
+			src:       net.ParseIP("fd00:1::10"),
+			dst:       net.ParseIP("fd00:10:96::1"),
+			protocol:  "tcp6",
+			network:   "ip6",
+			wantSrc:   "fd00:1::10",
+			wantDst:   "fd00:10:96::1",
+			wantBytes: 37,
+		},
+		{
+			name:      "mixed",
+			src:       net.IP{192, 168, 1, 10},
+			dst:       net.ParseIP("fd00:10:96::1"),
+			protocol:  "tcp6",
+			network:   "ip6",
+			wantSrc:   "192.168.1.10",
+			wantDst:   "fd00:10:96::1",
+			wantBytes: 37,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			id := NewConnID(ipproto.TCP, tt.src, tt.dst, 34567, 8080)
+			assert.Len(t, id, tt.wantBytes)
+			assert.Equal(t, tt.ipv4, id.IsIPv4())
+			assert.Equal(t, tt.wantSrc, id.Source().String())
+			assert.Equal(t, tt.wantDst, id.Destination().String())
+			assert.Equal(t, uint16(34567), id.SourcePort())
+			assert.Equal(t, uint16(8080), id.DestinationPort())
+			assert.Equal(t, ipproto.TCP, id.Protocol())
+			assert.Equal(t, tt.protocol, id.ProtocolString())
+			assert.Equal(t, tt.network, id.Network())
+			assert.Equal(t, ipproto.TCP, IPProto(id.ProtocolString()))
+			assert.Equal(t, net.JoinHostPort(tt.wantDst, "8080"), id.DestinationAddr().String())
+
+			// The ID is passed intact to the other side of the tunnel
+			s := &stream{}
+			require.NoError(t, setConnectInfo(StreamInfoMessage(id, "session-1", time.Millisecond, time.Second), s))
+			assert.Equal(t, id, s.id)
+			assert.Equal(t, "session-1", s.sessionID)
+		})
+	}
+}
+
+func TestIPProto(t *testing.T) {
+	for network, proto := range map[string]int{
+		"tcp":    ipproto.TCP,
+		"tcp4":   ipproto.TCP,
+		"tcp6":   ipproto.TCP,
+		"udp":    ipproto.UDP,
+		"udp4":   ipproto.UDP,
+		"udp6":   ipproto.UDP,
+		"icmp":   ipproto.ICMP,
+		"icmpv6": ipproto.ICMPV6,
+		"sctp":   -1,
+	} {
+		assert.Equal(t, proto, IPProto(network), network)
+	}
+}
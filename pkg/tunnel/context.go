@@ -1,6 +1,10 @@
 package tunnel
 
-import "context"
+import (
+	"context"
+	"crypto/tls"
+	"time"
+)
 
 type poolKey struct{}
 
@@ -16,3 +20,36 @@ func GetPool(ctx context.Context) *Pool {
 	}
 	return pool
 }
+
+type tcpKeepAliveKey struct{}
+
+// WithTCPKeepAlive returns a context with the keepalive period of the TCP connections that the endpoints
+// created with it dial or are given. As with net.Dialer.KeepAlive, zero means the default period of 15
+// seconds, and a negative period disables keepalives.
+func WithTCPKeepAlive(ctx context.Context, period time.Duration) context.Context {
+	return context.WithValue(ctx, tcpKeepAliveKey{}, period)
+}
+
+// GetTCPKeepAlive returns the keepalive period of TCP connections, or zero when the context doesn't have one.
+func GetTCPKeepAlive(ctx context.Context) time.Duration {
+	period, _ := ctx.Value(tcpKeepAliveKey{}).(time.Duration)
+	return period
+}
+
+type tlsConfigKey struct{}
+
+// WithTLSConfig returns a context with a function that returns the TLS configuration to use for the TCP
+// connections that the dialers created with it dial to the given destination address. The dialers use TLS
+// on top of those connections when the function returns a configuration, and plain TCP when it returns nil.
+func WithTLSConfig(ctx context.Context, tlsConfig func(destination string) *tls.Config) context.Context {
+	return context.WithValue(ctx, tlsConfigKey{}, tlsConfig)
+}
+
+// GetTLSConfig returns the TLS configuration to use for TCP connections to the given destination address,
+// or nil when they don't use TLS.
+func GetTLSConfig(ctx context.Context, destination string) *tls.Config {
+	if tlsConfig, ok := ctx.Value(tlsConfigKey{}).(func(string) *tls.Config); ok {
+		return tlsConfig(destination)
+	}
+	return nil
+}
@@ -2,12 +2,15 @@ package tunnel
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"os"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/datawire/dlib/dlog"
@@ -17,10 +20,15 @@ import (
 
 // The idleDuration controls how long a dialer for a specific proto+from-to address combination remains alive without
 // reading or writing any messages. The dialer is normally closed by one of the peers.
-const tcpConnTTL = 2 * time.Hour // Default tcp_keepalive_time on Linux
-const udpConnTTL = 1 * time.Minute
+var tcpConnTTL = 2 * time.Hour // Default tcp_keepalive_time on Linux
+var udpConnTTL = 1 * time.Minute
+
 const partlyClosedDuration = 5 * time.Second
 
+// defaultTCPKeepAlive is the keepalive period used when the context doesn't declare one. It's the same as the
+// default of a net.Dialer.
+const defaultTCPKeepAlive = 15 * time.Second
+
 const (
 	notConnected = int32(iota)
 	connecting
@@ -37,6 +45,13 @@ type dialer struct {
 	ttl       int64
 	connected int32
 	done      chan struct{}
+
+	// keepAlive is the time that the conn-to-stream loop may be idle before it sends a KeepAlive message
+	// to the peer. Zero means that no KeepAlive messages are sent.
+	keepAlive time.Duration
+
+	// readDone is closed when the conn-to-stream loop ends.
+	readDone chan struct{}
 }
 
 // NewDialer creates a new handler that dispatches messages in both directions between the given gRPC stream
@@ -63,6 +78,7 @@ func NewConnEndpoint(stream Stream, conn net.Conn) Endpoint {
 		connected: state,
 		ttl:       int64(ttl),
 		done:      make(chan struct{}),
+		readDone:  make(chan struct{}),
 	}
 }
 
@@ -77,8 +93,11 @@ func (h *dialer) Start(ctx context.Context) {
 			h.connected = connecting
 
 			dlog.Debugf(ctx, "   CONN %s, dialing", id)
-			d := net.Dialer{Timeout: h.stream.DialTimeout()}
+			d := net.Dialer{Timeout: h.stream.DialTimeout(), KeepAlive: GetTCPKeepAlive(ctx)}
 			conn, err := d.DialContext(ctx, id.ProtocolString(), id.DestinationAddr().String())
+			if err == nil && id.Protocol() == ipproto.TCP {
+				conn, err = dialTLS(ctx, conn, d.Timeout)
+			}
 			if err != nil {
 				dlog.Errorf(ctx, "!! CONN %s, failed to establish connection: %v", id, err)
 				if err = h.stream.Send(ctx, NewMessage(DialReject, nil)); err != nil {
@@ -96,6 +115,7 @@ func (h *dialer) Start(ctx context.Context) {
 			h.conn = conn
 
 		case connecting:
+			setTCPKeepAlive(h.conn, GetTCPKeepAlive(ctx))
 		default:
 			dlog.Errorf(ctx, "!! CONN %s, start called in invalid state", id)
 			return
@@ -104,6 +124,9 @@ func (h *dialer) Start(ctx context.Context) {
 		// Set up the idle timer to close and release this endpoint when it's been idle for a while.
 		h.idleTimer = time.NewTimer(h.getTTL())
 		h.connected = connected
+		if id.Protocol() == ipproto.TCP {
+			h.keepAlive = keepAliveInterval(GetTCPKeepAlive(ctx))
+		}
 
 		wg := sync.WaitGroup{}
 		wg.Add(2)
@@ -124,7 +147,8 @@ func (h *dialer) getTTL() time.Duration {
 
 func (h *dialer) handleControl(ctx context.Context, cm Message) {
 	switch cm.Code() {
-	case Disconnect: // Peer responded to our disconnect or wants to hard-close. No more messages will arrive
+	case DialOK: // The peer is a dialer that connected
+	case DialReject, Disconnect: // Peer failed to dial, responded to our disconnect, or wants to hard-close. No more messages will arrive
 		h.Close(ctx)
 	case KeepAlive:
 		h.resetIdle()
@@ -167,6 +191,7 @@ func (h *dialer) connToStreamLoop(ctx context.Context, wg *sync.WaitGroup) {
 			}
 		}
 		close(outgoing)
+		close(h.readDone)
 		dlog.Logf(ctx, endLevel, "   CONN %s conn-to-stream loop ended because %s", id, endReason)
 		wg.Done()
 	}()
@@ -176,15 +201,39 @@ func (h *dialer) connToStreamLoop(ctx context.Context, wg *sync.WaitGroup) {
 	buf := make([]byte, 0x100000)
 	dlog.Debugf(ctx, "   CONN %s conn-to-stream loop started", id)
 	for atomic.LoadInt32(&h.connected) == connected {
+		if h.keepAlive > 0 {
+			_ = h.conn.SetReadDeadline(time.Now().Add(h.keepAlive))
+		}
 		n, err := h.conn.Read(buf)
 		if err != nil {
 			switch {
+			case h.keepAlive > 0 && errors.Is(err, os.ErrDeadlineExceeded):
+				// Nothing was read for a while. Let the peer know that this end is still alive, so that
+				// it doesn't close the connection for being idle.
+				dlog.Tracef(ctx, "-> CONN %s, keepalive", id)
+				select {
+				case <-ctx.Done():
+					endReason = ctx.Err().Error()
+					return
+				case outgoing <- NewMessage(KeepAlive, nil):
+				}
+				continue
 			case errors.Is(err, io.EOF):
+				// The local peer has closed its sending side. The peer of the stream is told so when
+				// the outgoing channel is closed, and the other direction remains open until the peer
+				// closes it too.
 				endReason = "EOF was encountered"
 				endLevel = dlog.LogLevelDebug
+				return
 			case errors.Is(err, net.ErrClosed):
 				endReason = "the connection was closed"
 				endLevel = dlog.LogLevelDebug
+			case id.Protocol() == ipproto.UDP && isConnRefused(err):
+				// An ICMP port unreachable was received in response to a datagram that was sent
+				// earlier, i.e. nothing is listening on the destination port. The peer will dial
+				// again when it has more datagrams to send.
+				endReason = "nothing is listening on the destination port"
+				endLevel = dlog.LogLevelInfo
 			default:
 				endReason = fmt.Sprintf("a read error occurred: %v", err)
 			}
@@ -220,6 +269,10 @@ func (h *dialer) streamToConnLoop(ctx context.Context, wg *sync.WaitGroup) {
 
 	incoming, errCh := ReadLoop(ctx, h.stream)
 
+	// readDone is set when the peer has closed its sending side and the write side of the connection has
+	// been closed. The loop then waits for the conn-to-stream loop to end.
+	var readDone <-chan struct{}
+
 	dlog.Debugf(ctx, "   CONN %s stream-to-conn loop started", id)
 	for atomic.LoadInt32(&h.connected) != notConnected {
 		select {
@@ -231,9 +284,18 @@ func (h *dialer) streamToConnLoop(ctx context.Context, wg *sync.WaitGroup) {
 			return
 		case err := <-errCh:
 			dlog.Error(ctx, err)
+		case <-readDone:
+			endReason = "there was no more input in either direction"
+			endLevel = dlog.LogLevelDebug
+			return
 		case dg := <-incoming:
 			if dg == nil {
 				// h.incoming was closed by the reader and is now drained.
+				if id.Protocol() == ipproto.TCP && h.closeWrite(ctx) {
+					incoming = nil
+					readDone = h.readDone
+					continue
+				}
 				endReason = "there was no more input"
 				endLevel = dlog.LogLevelDebug
 				return
@@ -252,7 +314,12 @@ func (h *dialer) streamToConnLoop(ctx context.Context, wg *sync.WaitGroup) {
 				wn, err := h.conn.Write(payload[n:])
 				if err != nil {
 					h.startDisconnect(ctx)
-					endReason = fmt.Sprintf("a write error occurred: %v", err)
+					if id.Protocol() == ipproto.UDP && isConnRefused(err) {
+						endReason = "nothing is listening on the destination port"
+						endLevel = dlog.LogLevelInfo
+					} else {
+						endReason = fmt.Sprintf("a write error occurred: %v", err)
+					}
 					return
 				}
 				dlog.Tracef(ctx, "-> CONN %s, len %d", id, wn)
@@ -262,6 +329,84 @@ func (h *dialer) streamToConnLoop(ctx context.Context, wg *sync.WaitGroup) {
 	}
 }
 
+// closeWrite shuts down the writing side of the connection, so that the local peer reads EOF while it can
+// still write. It returns false if the connection can't be half-closed.
+func (h *dialer) closeWrite(ctx context.Context) bool {
+	cw, ok := h.conn.(interface{ CloseWrite() error })
+	if !ok {
+		return false
+	}
+	if err := cw.CloseWrite(); err != nil {
+		dlog.Debugf(ctx, "!! CONN %s, CloseWrite failed: %v", h.stream.ID(), err)
+		return false
+	}
+	dlog.Debugf(ctx, "   CONN %s, write side closed", h.stream.ID())
+	return true
+}
+
+// dialTLS performs a TLS handshake on the given TCP connection when the context declares a TLS configuration
+// for its remote address, and returns the resulting TLS connection. The connection is returned as is when
+// no TLS is used. It is closed when the handshake fails. As with tls.Dial, the server name defaults to the
+// host of the remote address.
+func dialTLS(ctx context.Context, conn net.Conn, timeout time.Duration) (net.Conn, error) {
+	remote := conn.RemoteAddr().String()
+	tlsConfig := GetTLSConfig(ctx, remote)
+	if tlsConfig == nil {
+		return conn, nil
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName, _, _ = net.SplitHostPort(remote)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	tc := tls.Client(conn, tlsConfig)
+	if err := tc.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	return tc, nil
+}
+
+// setTCPKeepAlive applies the given keepalive period to the connection if it's a TCP connection. The
+// period has the same meaning as net.Dialer.KeepAlive, except that zero leaves the connection as is.
+func setTCPKeepAlive(conn net.Conn, period time.Duration) {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	switch {
+	case period < 0:
+		_ = tc.SetKeepAlive(false)
+	case period > 0:
+		_ = tc.SetKeepAlive(true)
+		_ = tc.SetKeepAlivePeriod(period)
+	}
+}
+
+// keepAliveInterval returns the time that a TCP dialer may be idle before it sends a KeepAlive message to its
+// peer, given the keepalive period of the context.
+func keepAliveInterval(period time.Duration) time.Duration {
+	switch {
+	case period < 0:
+		return 0
+	case period == 0:
+		return defaultTCPKeepAlive
+	default:
+		return period
+	}
+}
+
+// isConnRefused returns true if the error stems from an ICMP port unreachable message. Such messages
+// are reported on a connected UDP socket as ECONNREFUSED by the next read or write, or as ECONNRESET on
+// Windows.
+func isConnRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET)
+}
+
 func (h *dialer) resetIdle() bool {
 	h.idleLock.Lock()
 	stopped := h.idleTimer.Stop()
@@ -274,18 +419,21 @@ func (h *dialer) resetIdle() bool {
 
 // DialWaitLoop reads from the given dialStream. A new goroutine that creates a Tunnel to the manager and then
 // attaches a dialer Endpoint to that tunnel is spawned for each request that arrives. The method blocks until
-// the dialStream is closed.
-func DialWaitLoop(ctx context.Context, manager rpc.ManagerClient, dialStream rpc.Manager_WatchDialClient, sessionID string) {
+// the dialStream is closed. The error that closed the stream is returned, unless it's the end of the stream
+// or the cancellation of the context.
+func DialWaitLoop(ctx context.Context, manager rpc.ManagerClient, dialStream rpc.Manager_WatchDialClient, sessionID string) error {
 	for ctx.Err() == nil {
 		dr, err := dialStream.Recv()
 		if err != nil {
 			if ctx.Err() == nil && !(errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed)) {
 				dlog.Errorf(ctx, "dial request stream recv: %+v", err) // May be io.EOF
+				return err
 			}
-			return
+			return nil
 		}
 		go dialRespond(ctx, manager, dr, sessionID)
 	}
+	return nil
 }
 
 func dialRespond(ctx context.Context, manager rpc.ManagerClient, dr *rpc.DialRequest, sessionID string) {
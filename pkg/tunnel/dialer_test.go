@@ -0,0 +1,470 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+// udpEchoServer starts a UDP server on localhost that echoes each datagram back to its sender, and
+// returns its port.
+func udpEchoServer(t *testing.T) uint16 {
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = pc.Close() })
+	go func() {
+		buf := make([]byte, 0x100)
+		for {
+			n, src, err := pc.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			_, _ = pc.WriteToUDP(buf[:n], src)
+		}
+	}()
+	return uint16(pc.LocalAddr().(*net.UDPAddr).Port)
+}
+
+// unusedUDPPort returns a UDP port on localhost that nothing listens on.
+func unusedUDPPort(t *testing.T) uint16 {
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer pc.Close()
+	return uint16(pc.LocalAddr().(*net.UDPAddr).Port)
+}
+
+// startUDPDialer starts a dialer for a UDP connection to the given port on localhost, in the same way
+// as the intercepting client does when it receives a dial request, and returns the stream of the fake
+// agent on the other end of the tunnel together with the dialer endpoint.
+func startUDPDialer(ctx context.Context, t *testing.T, port uint16) (Stream, Endpoint) {
+	tunnel := newBidi(10, ctx.Done())
+	id := NewConnID(ipproto.UDP, iputil.Parse("10.0.0.1"), iputil.Parse("127.0.0.1"), 5353, port)
+
+	endpointCh := make(chan Endpoint, 1)
+	go func() {
+		client, err := NewClientStream(ctx, tunnel.clientSide(), id, uuid.New().String(), 0, 0)
+		if !assert.NoError(t, err) {
+			close(endpointCh)
+			return
+		}
+		d := NewDialer(client)
+		d.Start(ctx)
+		endpointCh <- d
+	}()
+
+	agent, err := NewServerStream(ctx, tunnel.serverSide())
+	require.NoError(t, err)
+	d := <-endpointCh
+	require.NotNil(t, d)
+	return agent, d
+}
+
+func TestDialer_UDPEcho(t *testing.T) {
+	ctx, cancel := testContext(t, 10*time.Second)
+	defer cancel()
+
+	agent, d := startUDPDialer(ctx, t, udpEchoServer(t))
+
+	// The fake agent relays datagrams and expects them to be echoed by the local process
+	wrCh := make(chan Message)
+	WriteLoop(ctx, agent, wrCh)
+	rdCh, errCh := ReadLoop(ctx, agent)
+
+	// The dialer confirms the dial before it starts relaying
+	select {
+	case m := <-rdCh:
+		require.NotNil(t, m)
+		require.Equal(t, DialOK, m.Code())
+	case err := <-errCh:
+		require.NoError(t, err)
+	}
+
+	for _, payload := range []string{"one", "two", "three"} {
+		wrCh <- NewMessage(Normal, []byte(payload))
+		select {
+		case m := <-rdCh:
+			require.NotNil(t, m)
+			assert.Equal(t, Normal, m.Code())
+			assert.Equal(t, payload, string(m.Payload()))
+		case err := <-errCh:
+			require.NoError(t, err)
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for echo")
+		}
+	}
+	close(wrCh)
+
+	select {
+	case <-d.Done():
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for the dialer to close")
+	}
+}
+
+func TestDialer_UDPNotListening(t *testing.T) {
+	ctx, cancel := testContext(t, 10*time.Second)
+	defer cancel()
+
+	agent, d := startUDPDialer(ctx, t, unusedUDPPort(t))
+
+	wrCh := make(chan Message)
+	WriteLoop(ctx, agent, wrCh)
+	rdCh, errCh := ReadLoop(ctx, agent)
+
+	// Dialing a UDP port always succeeds
+	select {
+	case m := <-rdCh:
+		require.NotNil(t, m)
+		require.Equal(t, DialOK, m.Code())
+	case err := <-errCh:
+		require.NoError(t, err)
+	}
+
+	// The ICMP port unreachable that the datagram results in makes the dialer end the connection
+	// without ever delivering any data back to the agent.
+	wrCh <- NewMessage(Normal, []byte("anybody there?"))
+	for done := false; !done; {
+		select {
+		case m := <-rdCh:
+			if m == nil {
+				done = true
+				break
+			}
+			assert.NotEqual(t, Normal, m.Code(), "unexpected data from a port that nobody listens on")
+		case err := <-errCh:
+			require.NoError(t, err)
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for the dialer to end the connection")
+		}
+	}
+	close(wrCh)
+
+	select {
+	case <-d.Done():
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for the dialer to close")
+	}
+}
+
+// tcpListener starts a TCP listener on localhost and returns it together with a channel that receives
+// the connections that it accepts.
+func tcpListener(t *testing.T) (*net.TCPListener, <-chan *net.TCPConn) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	connCh := make(chan *net.TCPConn, 1)
+	go func() {
+		for {
+			conn, err := l.AcceptTCP()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { _ = conn.Close() })
+			connCh <- conn
+		}
+	}()
+	return l, connCh
+}
+
+// startTCPDialer starts a dialer for a TCP connection to a listener on localhost, and returns the stream
+// of the fake agent on the other end of the tunnel, the connection that the listener accepted, and the
+// dialer endpoint.
+func startTCPDialer(ctx context.Context, t *testing.T) (Stream, *net.TCPConn, Endpoint) {
+	l, connCh := tcpListener(t)
+	tunnel := newBidi(10, ctx.Done())
+	id := NewConnID(ipproto.TCP, iputil.Parse("10.0.0.1"), iputil.Parse("127.0.0.1"), 5432, uint16(l.Addr().(*net.TCPAddr).Port))
+
+	endpointCh := make(chan Endpoint, 1)
+	go func() {
+		client, err := NewClientStream(ctx, tunnel.clientSide(), id, uuid.New().String(), 0, 0)
+		if !assert.NoError(t, err) {
+			close(endpointCh)
+			return
+		}
+		d := NewDialer(client)
+		d.Start(ctx)
+		endpointCh <- d
+	}()
+
+	agent, err := NewServerStream(ctx, tunnel.serverSide())
+	require.NoError(t, err)
+	d := <-endpointCh
+	require.NotNil(t, d)
+	m, err := agent.Receive(ctx)
+	require.NoError(t, err)
+	require.Equal(t, DialOK, m.Code())
+	return agent, <-connCh, d
+}
+
+// readMessages returns the payload of the messages that are read from the channel until it's closed.
+func readMessages(ctx context.Context, t *testing.T, rdCh <-chan Message) string {
+	var buf bytes.Buffer
+	for {
+		select {
+		case m := <-rdCh:
+			if m == nil {
+				return buf.String()
+			}
+			if m.Code() == Normal {
+				buf.Write(m.Payload())
+			}
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for the stream to close")
+		}
+	}
+}
+
+func awaitDone(ctx context.Context, t *testing.T, d Endpoint) {
+	select {
+	case <-d.Done():
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for the dialer to close")
+	}
+}
+
+func TestDialer_TCPHalfCloseByLocal(t *testing.T) {
+	ctx, cancel := testContext(t, 10*time.Second)
+	defer cancel()
+	agent, conn, d := startTCPDialer(ctx, t)
+
+	wrCh := make(chan Message)
+	WriteLoop(ctx, agent, wrCh)
+	rdCh, _ := ReadLoop(ctx, agent)
+
+	// The local process sends a request and closes its sending side
+	_, err := conn.Write([]byte("request"))
+	require.NoError(t, err)
+	require.NoError(t, conn.CloseWrite())
+	assert.Equal(t, "request", readMessages(ctx, t, rdCh))
+
+	// The response is still delivered after a while
+	time.Sleep(100 * time.Millisecond)
+	wrCh <- NewMessage(Normal, []byte("response"))
+	close(wrCh)
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "response", string(data))
+	awaitDone(ctx, t, d)
+}
+
+func TestDialer_TCPHalfCloseByPeer(t *testing.T) {
+	ctx, cancel := testContext(t, 10*time.Second)
+	defer cancel()
+	agent, conn, d := startTCPDialer(ctx, t)
+
+	wrCh := make(chan Message)
+	WriteLoop(ctx, agent, wrCh)
+	rdCh, _ := ReadLoop(ctx, agent)
+
+	// The peer sends a request and closes its sending side, so the local process reads EOF
+	wrCh <- NewMessage(Normal, []byte("request"))
+	close(wrCh)
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "request", string(data))
+
+	// The response is still delivered after a while
+	time.Sleep(100 * time.Millisecond)
+	_, err = conn.Write([]byte("response"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	assert.Equal(t, "response", readMessages(ctx, t, rdCh))
+	awaitDone(ctx, t, d)
+}
+
+// startTCPTunnel connects a client connection to a server connection through an endpoint that is given the
+// accepted client connection, as in a traffic-agent, and a dialer that dials the server, as in a traffic-manager.
+func startTCPTunnel(ctx context.Context, t *testing.T) (client, server *net.TCPConn) {
+	cl, clientCh := tcpListener(t)
+	sl, serverCh := tcpListener(t)
+	client, err := net.DialTCP("tcp", nil, cl.Addr().(*net.TCPAddr))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+
+	tunnel := newBidi(10, ctx.Done())
+	id := NewConnID(ipproto.TCP, iputil.Parse("127.0.0.1"), iputil.Parse("127.0.0.1"), 5432, uint16(sl.Addr().(*net.TCPAddr).Port))
+	go func() {
+		cs, err := NewClientStream(ctx, tunnel.clientSide(), id, uuid.New().String(), 0, 0)
+		if assert.NoError(t, err) {
+			NewConnEndpoint(cs, <-clientCh).Start(ctx)
+		}
+	}()
+	ss, err := NewServerStream(ctx, tunnel.serverSide())
+	require.NoError(t, err)
+	NewDialer(ss).Start(ctx)
+	return client, <-serverCh
+}
+
+func TestDialer_TCPKeepAlive(t *testing.T) {
+	defer func(ttl time.Duration) { tcpConnTTL = ttl }(tcpConnTTL)
+	tcpConnTTL = 300 * time.Millisecond
+	const idle = time.Second
+
+	roundtrip := func(t *testing.T, from, to *net.TCPConn, msg string) {
+		_, err := from.Write([]byte(msg))
+		require.NoError(t, err)
+		buf := make([]byte, len(msg))
+		_, err = io.ReadFull(to, buf)
+		require.NoError(t, err)
+		assert.Equal(t, msg, string(buf))
+	}
+
+	t.Run("idle longer than the TTL", func(t *testing.T) {
+		ctx, cancel := testContext(t, 10*time.Second)
+		defer cancel()
+		client, server := startTCPTunnel(WithTCPKeepAlive(ctx, 50*time.Millisecond), t)
+		roundtrip(t, client, server, "ping")
+		time.Sleep(idle)
+		roundtrip(t, server, client, "pong")
+		roundtrip(t, client, server, "ping")
+	})
+
+	t.Run("without keepalives", func(t *testing.T) {
+		ctx, cancel := testContext(t, 10*time.Second)
+		defer cancel()
+		client, server := startTCPTunnel(WithTCPKeepAlive(ctx, -1), t)
+		roundtrip(t, client, server, "ping")
+		time.Sleep(idle)
+
+		// The connection was closed when the TTL expired
+		_, err := io.ReadAll(client)
+		require.NoError(t, err)
+		_, err = io.ReadAll(server)
+		require.NoError(t, err)
+	})
+}
+
+func TestKeepAliveInterval(t *testing.T) {
+	assert.Equal(t, defaultTCPKeepAlive, keepAliveInterval(0))
+	assert.Equal(t, time.Minute, keepAliveInterval(time.Minute))
+	assert.Equal(t, time.Duration(0), keepAliveInterval(-1))
+}
+
+// selfSignedCert returns the PEM encoded certificate and key of a self-signed certificate for the given hosts.
+func selfSignedCert(t *testing.T, hosts ...string) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: hosts[0]},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	kb, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: kb})
+}
+
+// tlsEchoServer starts a TLS server on localhost that echoes everything that it reads, and returns its
+// port and the PEM encoded certificate that it uses.
+func tlsEchoServer(t *testing.T) (uint16, []byte) {
+	certPEM, keyPEM := selfSignedCert(t, "127.0.0.1")
+	kp, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{kp}})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return uint16(l.Addr().(*net.TCPAddr).Port), certPEM
+}
+
+func TestDialer_TLS(t *testing.T) {
+	port, certPEM := tlsEchoServer(t)
+	id := NewConnID(ipproto.TCP, iputil.Parse("10.0.0.1"), iputil.Parse("127.0.0.1"), 5432, port)
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(certPEM))
+
+	// startTLSDialer starts a dialer that dials the echo server using the given TLS configuration, and
+	// returns the stream of the fake agent and the response to the dial.
+	startTLSDialer := func(ctx context.Context, t *testing.T, config *tls.Config) (Stream, Message) {
+		ctx = WithTLSConfig(ctx, func(destination string) *tls.Config {
+			if destination == id.DestinationAddr().String() {
+				return config
+			}
+			return nil
+		})
+		tunnel := newBidi(10, ctx.Done())
+		go func() {
+			client, err := NewClientStream(ctx, tunnel.clientSide(), id, uuid.New().String(), 0, time.Second)
+			if assert.NoError(t, err) {
+				NewDialer(client).Start(ctx)
+			}
+		}()
+		agent, err := NewServerStream(ctx, tunnel.serverSide())
+		require.NoError(t, err)
+		m, err := agent.Receive(ctx)
+		require.NoError(t, err)
+		return agent, m
+	}
+
+	t.Run("verified by the CA", func(t *testing.T) {
+		ctx, cancel := testContext(t, 10*time.Second)
+		defer cancel()
+		agent, m := startTLSDialer(ctx, t, &tls.Config{RootCAs: roots})
+		require.Equal(t, DialOK, m.Code())
+
+		// The plaintext of the agent is encrypted toward the echo server, and its response is decrypted
+		wrCh := make(chan Message)
+		WriteLoop(ctx, agent, wrCh)
+		rdCh, _ := ReadLoop(ctx, agent)
+		wrCh <- NewMessage(Normal, []byte("hello"))
+		close(wrCh)
+		assert.Equal(t, "hello", readMessages(ctx, t, rdCh))
+	})
+
+	t.Run("not verified", func(t *testing.T) {
+		ctx, cancel := testContext(t, 10*time.Second)
+		defer cancel()
+		_, m := startTLSDialer(ctx, t, &tls.Config{InsecureSkipVerify: true})
+		assert.Equal(t, DialOK, m.Code())
+	})
+
+	t.Run("unknown CA", func(t *testing.T) {
+		ctx, cancel := testContext(t, 10*time.Second)
+		defer cancel()
+		_, m := startTLSDialer(ctx, t, &tls.Config{RootCAs: x509.NewCertPool()})
+		assert.Equal(t, DialReject, m.Code())
+	})
+}
@@ -2,17 +2,89 @@ package tunnel
 
 import (
 	"context"
+	"errors"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/datawire/dlib/dlog"
 )
 
+// Eviction is what a Pool does when a new handler would exceed its MaxConnections.
+type Eviction int
+
+const (
+	// EvictLRU closes the least recently used handler to make room for the new one.
+	EvictLRU = Eviction(iota)
+
+	// RefuseNew refuses the new handler. GetOrCreate then returns ErrPoolFull.
+	RefuseNew
+)
+
+// ErrPoolFull is returned by GetOrCreate when the pool is full and its Eviction is RefuseNew.
+var ErrPoolFull = errors.New("the maximum number of tracked connections has been reached")
+
+// PoolLimits limit the number of handlers in a Pool, and the time that they may remain unused.
+type PoolLimits struct {
+	// MaxConnections is the maximum number of handlers. Zero means that there's no maximum.
+	MaxConnections int
+
+	// Eviction is what happens when a new handler would exceed MaxConnections.
+	Eviction Eviction
+
+	// IdleTimeouts are the times, by protocol, after which an unused handler is closed by EvictIdle. The
+	// handlers of a protocol without a timeout are never closed for being idle.
+	IdleTimeouts map[int]time.Duration
+}
+
+// PoolStats are the current number of handlers in a Pool and the number of handlers that it has evicted
+// or refused since it was created.
+type PoolStats struct {
+	Tracked        int
+	IdleEvictions  uint64
+	LimitEvictions uint64
+	Refused        uint64
+}
+
+// Clock is the mechanism used by a Pool to get the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+type wall struct{}
+
+func (wall) Now() time.Time {
+	return time.Now()
+}
+
 type Pool struct {
-	handlers map[ConnID]Handler
+	// The counters are first in the struct to guarantee 64-bit alignment of atomic access.
+	idleEvictions  uint64
+	limitEvictions uint64
+	refused        uint64
+
+	handlers map[ConnID]*poolEntry
+	limits   PoolLimits
+	clock    Clock
 
 	lock sync.RWMutex
 }
 
+// poolEntry is a handler in the pool together with the time, in Unix nanoseconds, that it was last used.
+type poolEntry struct {
+	lastUsed int64
+	handler  Handler
+}
+
+func (e *poolEntry) touch(now time.Time) {
+	atomic.StoreInt64(&e.lastUsed, now.UnixNano())
+}
+
+func (e *poolEntry) idleSince() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&e.lastUsed))
+}
+
 type Handler interface {
 	// Close closes the handle
 	Close(context.Context)
@@ -20,16 +92,55 @@ type Handler interface {
 	Start(ctx context.Context)
 }
 
+// NewPool returns a pool without limits.
 func NewPool() *Pool {
-	return &Pool{handlers: make(map[ConnID]Handler)}
+	return NewLimitedPool(PoolLimits{})
+}
+
+// NewLimitedPool returns a pool with the given limits.
+func NewLimitedPool(limits PoolLimits) *Pool {
+	return &Pool{handlers: make(map[ConnID]*poolEntry), limits: limits, clock: wall{}}
 }
 
-func (p *Pool) release(ctx context.Context, id ConnID) {
+// SetLimits replaces the limits of the pool. The new limits apply to handlers that are created after the call,
+// and to the next call to EvictIdle.
+func (p *Pool) SetLimits(limits PoolLimits) {
 	p.lock.Lock()
-	delete(p.handlers, id)
+	p.limits = limits
+	p.lock.Unlock()
+}
+
+// Limits returns the current limits of the pool.
+func (p *Pool) Limits() PoolLimits {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.limits
+}
+
+// Stats returns the current statistics of the pool.
+func (p *Pool) Stats() PoolStats {
+	p.lock.RLock()
+	tracked := len(p.handlers)
+	p.lock.RUnlock()
+	return PoolStats{
+		Tracked:        tracked,
+		IdleEvictions:  atomic.LoadUint64(&p.idleEvictions),
+		LimitEvictions: atomic.LoadUint64(&p.limitEvictions),
+		Refused:        atomic.LoadUint64(&p.refused),
+	}
+}
+
+func (p *Pool) release(ctx context.Context, id ConnID, entry *poolEntry) {
+	p.lock.Lock()
+	found := p.handlers[id] == entry
+	if found {
+		delete(p.handlers, id)
+	}
 	count := len(p.handlers)
 	p.lock.Unlock()
-	dlog.Debugf(ctx, "-- POOL %s, count now is %d", id, count)
+	if found {
+		dlog.Debugf(ctx, "-- POOL %s, count now is %d", id, count)
+	}
 }
 
 // HandlerCreator describes the function signature for the function that creates a handler
@@ -38,57 +149,151 @@ type HandlerCreator func(ctx context.Context, release func()) (Handler, error)
 // Get finds a handler for the given id from the pool and returns it. Nil is returned if no such handler exists
 func (p *Pool) Get(id ConnID) Handler {
 	p.lock.RLock()
-	handler := p.handlers[id]
+	entry := p.handlers[id]
 	p.lock.RUnlock()
-	return handler
+	if entry == nil {
+		return nil
+	}
+	entry.touch(p.clock.Now())
+	return entry.handler
 }
 
 // GetOrCreate finds a handler for the given id from the pool, or creates a new handler using the given createHandler func
 // when no handler was found. The handler is returned together with a boolean flag which is set to true if
-// the handler was found or false if it was created.
+// the handler was found or false if it was created. When the pool is full, the least recently used handler is
+// evicted to make room for the new one, or, when the pool refuses new handlers, ErrPoolFull is returned.
 func (p *Pool) GetOrCreate(ctx context.Context, id ConnID, createHandler HandlerCreator) (Handler, bool, error) {
+	now := p.clock.Now()
 	p.lock.RLock()
-	handler, ok := p.handlers[id]
+	entry, ok := p.handlers[id]
+	refuse := p.refuses()
 	p.lock.RUnlock()
 
 	if ok {
-		return handler, true, nil
+		entry.touch(now)
+		return entry.handler, true, nil
+	}
+	if refuse {
+		atomic.AddUint64(&p.refused, 1)
+		return nil, false, ErrPoolFull
 	}
 
 	handlerCtx, cancel := context.WithCancel(ctx)
+	entry = &poolEntry{}
+	entry.touch(now)
 	release := func() {
-		p.release(ctx, id)
+		p.release(ctx, id, entry)
 		cancel()
 	}
 
 	var err error
-	handler, err = createHandler(handlerCtx, release)
-	if err != nil || handler == nil {
+	entry.handler, err = createHandler(handlerCtx, release)
+	if err != nil || entry.handler == nil {
+		cancel()
 		return nil, false, err
 	}
 
 	p.lock.Lock()
-	var old Handler
-	if old, ok = p.handlers[id]; !ok {
-		p.handlers[id] = handler
+	var old *poolEntry
+	if old, ok = p.handlers[id]; ok {
+		p.lock.Unlock()
+		// Toss newly created handler. It's not started anyway.
+		cancel()
+		old.touch(now)
+		return old.handler, true, nil
 	}
+	if p.refuses() {
+		p.lock.Unlock()
+		cancel()
+		atomic.AddUint64(&p.refused, 1)
+		return nil, false, ErrPoolFull
+	}
+	p.handlers[id] = entry
+	evicted := p.evictLRU(ctx, id)
 	count := len(p.handlers)
+	max := p.limits.MaxConnections
 	p.lock.Unlock()
-	if ok {
-		// Toss newly created handler. It's not started anyway.
-		return old, true, nil
+
+	for _, eid := range evicted {
+		dlog.Infof(ctx, "-- POOL %s evicted, the maximum of %d tracked connections has been reached", eid, max)
 	}
-	handler.Start(handlerCtx)
+	entry.handler.Start(handlerCtx)
 	dlog.Debugf(ctx, "++ POOL %s, count now is %d", id, count)
-	return handler, false, nil
+	return entry.handler, false, nil
+}
+
+// refuses returns true if the pool is full and refuses new handlers. The pool must be locked.
+func (p *Pool) refuses() bool {
+	return p.limits.Eviction == RefuseNew && p.limits.MaxConnections > 0 && len(p.handlers) >= p.limits.MaxConnections
+}
+
+// evictLRU removes the least recently used handlers, other than the one with the given id, until the pool
+// no longer exceeds its MaxConnections, closes them in the background, and returns their ids. The pool must
+// be locked.
+func (p *Pool) evictLRU(ctx context.Context, keep ConnID) []ConnID {
+	max := p.limits.MaxConnections
+	if max <= 0 {
+		return nil
+	}
+	var evicted []ConnID
+	for len(p.handlers) > max {
+		var lruID ConnID
+		var lru *poolEntry
+		for id, e := range p.handlers {
+			if id != keep && (lru == nil || atomic.LoadInt64(&e.lastUsed) < atomic.LoadInt64(&lru.lastUsed)) {
+				lruID, lru = id, e
+			}
+		}
+		if lru == nil {
+			break
+		}
+		delete(p.handlers, lruID)
+		evicted = append(evicted, lruID)
+		atomic.AddUint64(&p.limitEvictions, 1)
+		go lru.handler.Close(ctx)
+	}
+	return evicted
+}
+
+// EvictIdle closes the handlers that haven't been used for longer than the idle timeout of their protocol,
+// and returns their ids, least recently used first.
+func (p *Pool) EvictIdle(ctx context.Context) []ConnID {
+	now := p.clock.Now()
+	type idleEntry struct {
+		id    ConnID
+		entry *poolEntry
+	}
+	var idle []idleEntry
+	p.lock.Lock()
+	for id, e := range p.handlers {
+		if timeout, ok := p.limits.IdleTimeouts[id.Protocol()]; ok && timeout > 0 && now.Sub(e.idleSince()) > timeout {
+			delete(p.handlers, id)
+			idle = append(idle, idleEntry{id: id, entry: e})
+		}
+	}
+	p.lock.Unlock()
+	if len(idle) == 0 {
+		return nil
+	}
+	atomic.AddUint64(&p.idleEvictions, uint64(len(idle)))
+	sort.Slice(idle, func(i, j int) bool {
+		return atomic.LoadInt64(&idle[i].entry.lastUsed) < atomic.LoadInt64(&idle[j].entry.lastUsed)
+	})
+	ids := make([]ConnID, len(idle))
+	for i, ie := range idle {
+		ids[i] = ie.id
+		dlog.Debugf(ctx, "-- POOL %s idle since %s", ie.id, ie.entry.idleSince().Format(time.RFC3339))
+		go ie.entry.handler.Close(ctx)
+	}
+	return ids
 }
 
 func (p *Pool) CloseAll(ctx context.Context) {
 	p.lock.RLock()
 	handlers := make([]Handler, len(p.handlers))
 	i := 0
-	for _, handler := range p.handlers {
-		handlers[i] = handler
+	for _, entry := range p.handlers {
+		handlers[i] = entry.handler
 		i++
 	}
 	p.lock.RUnlock()
@@ -0,0 +1,219 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+)
+
+type fakeClock struct {
+	sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.Lock()
+	c.now = c.now.Add(d)
+	c.Unlock()
+}
+
+// fakeHandler reports its id on the closed channel when it's closed.
+type fakeHandler struct {
+	id     ConnID
+	closed chan<- ConnID
+}
+
+func (h *fakeHandler) Close(context.Context) {
+	h.closed <- h.id
+}
+
+func (h *fakeHandler) Start(context.Context) {}
+
+type poolTester struct {
+	*testing.T
+	ctx    context.Context
+	clock  *fakeClock
+	pool   *Pool
+	closed chan ConnID
+}
+
+func newPoolTester(t *testing.T, limits PoolLimits) *poolTester {
+	clock := &fakeClock{now: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}
+	pool := NewLimitedPool(limits)
+	pool.clock = clock
+	return &poolTester{
+		T:      t,
+		ctx:    dlog.NewTestContext(t, false),
+		clock:  clock,
+		pool:   pool,
+		closed: make(chan ConnID, 1000),
+	}
+}
+
+func flowID(proto int, n int) ConnID {
+	return NewConnID(proto, net.IP{10, 0, 0, 1}, net.IP{10, 96, 0, 1}, uint16(10000+n), 80)
+}
+
+// create creates a handler for the given id and advances the clock by one second.
+func (pt *poolTester) create(id ConnID) error {
+	_, found, err := pt.pool.GetOrCreate(pt.ctx, id, func(context.Context, func()) (Handler, error) {
+		return &fakeHandler{id: id, closed: pt.closed}, nil
+	})
+	if err == nil {
+		assert.False(pt, found)
+	}
+	pt.clock.advance(time.Second)
+	return err
+}
+
+// awaitClosed returns the ids of the next n handlers that are closed, in no particular order.
+func (pt *poolTester) awaitClosed(n int) map[ConnID]bool {
+	ids := make(map[ConnID]bool, n)
+	for i := 0; i < n; i++ {
+		select {
+		case id := <-pt.closed:
+			ids[id] = true
+		case <-time.After(5 * time.Second):
+			pt.Fatalf("only %d of %d handlers were closed", i, n)
+		}
+	}
+	return ids
+}
+
+func TestPool_evictLRU(t *testing.T) {
+	pt := newPoolTester(t, PoolLimits{MaxConnections: 3})
+	a, b, c, d, e := flowID(ipproto.TCP, 1), flowID(ipproto.TCP, 2), flowID(ipproto.TCP, 3), flowID(ipproto.UDP, 4), flowID(ipproto.UDP, 5)
+	require.NoError(t, pt.create(a))
+	require.NoError(t, pt.create(b))
+	require.NoError(t, pt.create(c))
+
+	// Using a makes b the least recently used
+	require.NotNil(t, pt.pool.Get(a))
+	pt.clock.advance(time.Second)
+
+	require.NoError(t, pt.create(d))
+	assert.Equal(t, map[ConnID]bool{b: true}, pt.awaitClosed(1))
+	assert.Nil(t, pt.pool.Get(b))
+
+	require.NoError(t, pt.create(e))
+	assert.Equal(t, map[ConnID]bool{c: true}, pt.awaitClosed(1))
+
+	for _, id := range []ConnID{a, d, e} {
+		assert.NotNil(t, pt.pool.Get(id))
+	}
+	assert.Equal(t, PoolStats{Tracked: 3, LimitEvictions: 2}, pt.pool.Stats())
+}
+
+func TestPool_evictLRU_manyFlows(t *testing.T) {
+	const max = 100
+	const flows = 1000
+	pt := newPoolTester(t, PoolLimits{MaxConnections: max})
+	for i := 0; i < flows; i++ {
+		require.NoError(t, pt.create(flowID(ipproto.UDP, i)))
+
+		// The flow that was created max flows ago is the least recently used, and is evicted
+		if i >= max {
+			assert.Equal(t, map[ConnID]bool{flowID(ipproto.UDP, i-max): true}, pt.awaitClosed(1))
+		}
+	}
+	for i := flows - max; i < flows; i++ {
+		assert.NotNil(t, pt.pool.Get(flowID(ipproto.UDP, i)))
+	}
+	assert.Equal(t, PoolStats{Tracked: max, LimitEvictions: flows - max}, pt.pool.Stats())
+}
+
+func TestPool_refuseNew(t *testing.T) {
+	pt := newPoolTester(t, PoolLimits{MaxConnections: 2, Eviction: RefuseNew})
+	a, b, c := flowID(ipproto.TCP, 1), flowID(ipproto.TCP, 2), flowID(ipproto.TCP, 3)
+	require.NoError(t, pt.create(a))
+	require.NoError(t, pt.create(b))
+
+	created := false
+	_, _, err := pt.pool.GetOrCreate(pt.ctx, c, func(context.Context, func()) (Handler, error) {
+		created = true
+		return &fakeHandler{id: c, closed: pt.closed}, nil
+	})
+	assert.ErrorIs(t, err, ErrPoolFull)
+	assert.False(t, created, "the handler of a refused connection must not be created")
+
+	// Existing handlers are still found when the pool is full
+	h, found, err := pt.pool.GetOrCreate(pt.ctx, a, nil)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.NotNil(t, h)
+	assert.Equal(t, PoolStats{Tracked: 2, Refused: 1}, pt.pool.Stats())
+
+	// A released handler makes room for a new one
+	pt.pool.release(pt.ctx, b, pt.pool.handlers[b])
+	require.NoError(t, pt.create(c))
+	assert.Equal(t, PoolStats{Tracked: 2, Refused: 1}, pt.pool.Stats())
+	assert.Empty(t, pt.closed)
+}
+
+func TestPool_evictIdle(t *testing.T) {
+	pt := newPoolTester(t, PoolLimits{IdleTimeouts: map[int]time.Duration{
+		ipproto.TCP: 0,
+		ipproto.UDP: 5 * time.Second,
+	}})
+	x, y, z, w := flowID(ipproto.TCP, 1), flowID(ipproto.UDP, 2), flowID(ipproto.UDP, 3), flowID(ipproto.UDP, 4)
+	require.NoError(t, pt.create(x)) // used at 0s
+	require.NoError(t, pt.create(y)) // used at 1s
+	require.NoError(t, pt.create(z)) // used at 2s
+	require.NoError(t, pt.create(w)) // used at 3s
+
+	pt.clock.advance(time.Second)
+	require.NotNil(t, pt.pool.Get(z)) // used at 5s
+	assert.Empty(t, pt.pool.EvictIdle(pt.ctx))
+
+	// At 9s, y and w have been idle for more than 5s, and are evicted least recently used first
+	pt.clock.advance(4 * time.Second)
+	assert.Equal(t, []ConnID{y, w}, pt.pool.EvictIdle(pt.ctx))
+	assert.Equal(t, map[ConnID]bool{y: true, w: true}, pt.awaitClosed(2))
+
+	// TCP has no idle timeout
+	pt.clock.advance(time.Hour)
+	assert.Equal(t, []ConnID{z}, pt.pool.EvictIdle(pt.ctx))
+	assert.Equal(t, map[ConnID]bool{z: true}, pt.awaitClosed(1))
+	assert.NotNil(t, pt.pool.Get(x))
+	assert.Equal(t, PoolStats{Tracked: 1, IdleEvictions: 3}, pt.pool.Stats())
+
+	// New limits apply to the next eviction
+	pt.pool.SetLimits(PoolLimits{IdleTimeouts: map[int]time.Duration{ipproto.TCP: time.Minute}})
+	pt.clock.advance(2 * time.Minute)
+	assert.Equal(t, []ConnID{x}, pt.pool.EvictIdle(pt.ctx))
+	assert.Equal(t, PoolStats{IdleEvictions: 4}, pt.pool.Stats())
+}
+
+func TestPool_releaseAfterEviction(t *testing.T) {
+	pt := newPoolTester(t, PoolLimits{MaxConnections: 1})
+	a, b := flowID(ipproto.UDP, 1), flowID(ipproto.UDP, 2)
+	var releaseA func()
+	_, _, err := pt.pool.GetOrCreate(pt.ctx, a, func(_ context.Context, release func()) (Handler, error) {
+		releaseA = release
+		return &fakeHandler{id: a, closed: pt.closed}, nil
+	})
+	require.NoError(t, err)
+	pt.clock.advance(time.Second)
+	require.NoError(t, pt.create(b))
+	pt.awaitClosed(1)
+
+	// A new handler with the id of an evicted one isn't removed when the evicted one is released
+	require.NoError(t, pt.create(a))
+	pt.awaitClosed(1)
+	releaseA()
+	assert.NotNil(t, pt.pool.Get(a))
+}
@@ -42,13 +42,17 @@ type GRPCStream interface {
 //   5. closeSend request received from Tunnel peer.
 //   6. Disconnect received from Tunnel peer.
 //
-// When #1 or #2 happens, the Stream will either call CloseSend() (if it's a client Stream)
-// or send a closeSend request (if it's a StreamServer) to its Stream peer, shorten the
-// Idle timer, and then continue to serve incoming data from the Stream peer until it's
-// closed or a Disconnect is received. Once that happens, it's guaranteed that the Tunnel
-// peer will send no more messages and the Stream is closed.
+// When #1 happens, the Stream will either call CloseSend() (if it's a client Stream)
+// or send a closeSend request (if it's a StreamServer) to its Stream peer, and then continue
+// to serve incoming data from the Stream peer until it's closed or a Disconnect is received.
+// Once that happens, it's guaranteed that the Tunnel peer will send no more messages and the
+// Stream is closed. When #2 happens, the same is done but the Idle timer is also shortened.
 //
-// When #3, #4, or #5 happens, the Tunnel will send a Disconnect to its Stream peer and close.
+// When #5 happens to a TCP Stream, the write side of the local connection is closed, and data
+// from the local connection is still sent to the Stream peer until #1 happens. A half-closed
+// TCP connection is hence kept open until both ends have closed it, or until it times out.
+//
+// When #3, #4, or #5 (for UDP) happens, the Tunnel will send a Disconnect to its Stream peer and close.
 //
 // When #6 happens, the Stream will simply close.
 type Stream interface {
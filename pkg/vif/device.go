@@ -7,6 +7,10 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/vif/buffer"
 )
 
+// MinMTU is the smallest MTU of the TUN device. It's the minimum MTU of IPv6. The largest
+// one is buffer.DataPool.MTU, because the buffers that packets are read into can't hold more.
+const MinMTU = 1280
+
 // OpenTun creates a new TUN device and ensures that it is up and running.
 func OpenTun(ctx context.Context) (*Device, error) {
 	return openTun(ctx)
@@ -46,6 +50,21 @@ func (t *Device) WritePacket(from *buffer.Data, offset int) (int, error) {
 	return t.writePacket(from, offset)
 }
 
+// SetMTU sets the MTU of this TUN device.
 func (t *Device) SetMTU(mtu int) error {
 	return t.setMTU(mtu)
 }
+
+// ReadPackets reads packets into the given buffers, one packet per buffer, and returns the number of
+// packets read. It waits for the first packet, and then reads the packets that are already available
+// until all buffers are filled. The length of each buffer that received a packet is set to the length
+// of that packet, so each buffer must have room for a packet of buffer.DataPool.MTU.
+func (t *Device) ReadPackets(bufs []*buffer.Data) (int, error) {
+	return t.readPackets(bufs)
+}
+
+// WritePackets writes each of the given buffers as one packet and returns the number of packets
+// written.
+func (t *Device) WritePackets(bufs []*buffer.Data) (int, error) {
+	return t.writePackets(bufs)
+}
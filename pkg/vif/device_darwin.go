@@ -22,6 +22,7 @@ const uTunControlName = "com.apple.net.utun_control"
 
 type Device struct {
 	*os.File
+	rc   rawConn
 	name string
 }
 
@@ -55,8 +56,14 @@ func openTun(_ context.Context) (*Device, error) {
 	if err != nil {
 		return nil, err
 	}
+	f := os.NewFile(uintptr(fd), "")
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
 	return &Device{
-		File: os.NewFile(uintptr(fd), ""),
+		File: f,
+		rc:   rc,
 		name: name,
 	}, nil
 }
@@ -106,22 +113,43 @@ func (t *Device) readPacket(into *buffer.Data) (int, error) {
 	return n, err
 }
 
-func (t *Device) writePacket(from *buffer.Data, offset int) (n int, err error) {
-	raw := from.Raw()
+// addressFamily returns the address family that the utun header of the given raw packet must declare.
+func addressFamily(raw []byte) (byte, error) {
 	if len(raw) <= buffer.PrefixLen {
 		return 0, unix.EIO
 	}
-
-	ipVer := raw[buffer.PrefixLen] >> 4
-	var af byte
-	switch ipVer {
+	switch raw[buffer.PrefixLen] >> 4 {
 	case ipv4.Version:
-		af = unix.AF_INET
+		return unix.AF_INET, nil
 	case ipv6.Version:
-		af = unix.AF_INET6
+		return unix.AF_INET6, nil
 	default:
 		return 0, errors.New("unable to determine IP version from packet")
 	}
+}
+
+func (t *Device) readPackets(bufs []*buffer.Data) (int, error) {
+	return readBatch(t.rc, bufs, buffer.PrefixLen)
+}
+
+func (t *Device) writePackets(bufs []*buffer.Data) (int, error) {
+	for i, b := range bufs {
+		raw := b.Raw()
+		af, err := addressFamily(raw)
+		if err != nil {
+			return i, err
+		}
+		raw[0], raw[1], raw[2], raw[3] = 0, 0, 0, af
+	}
+	return writeBatch(t.rc, bufs)
+}
+
+func (t *Device) writePacket(from *buffer.Data, offset int) (n int, err error) {
+	raw := from.Raw()
+	af, err := addressFamily(raw)
+	if err != nil {
+		return 0, err
+	}
 
 	if offset > 0 {
 		raw = raw[offset:]
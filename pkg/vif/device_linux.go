@@ -18,6 +18,7 @@ const devicePath = "/dev/net/tun"
 
 type Device struct {
 	*os.File
+	rc    rawConn
 	name  string
 	index int32
 }
@@ -88,7 +89,12 @@ func openTun(_ context.Context) (*Device, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Device{File: os.NewFile(uintptr(fd), devicePath), name: name, index: index}, nil
+	f := os.NewFile(uintptr(fd), devicePath)
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	return &Device{File: f, rc: rc, name: name, index: index}, nil
 }
 
 func (t *Device) addSubnet(ctx context.Context, subnet *net.IPNet) error {
@@ -129,6 +135,14 @@ func (t *Device) writePacket(from *buffer.Data, offset int) (int, error) {
 	return t.File.Write(from.Raw()[offset:])
 }
 
+func (t *Device) readPackets(bufs []*buffer.Data) (int, error) {
+	return readBatch(t.rc, bufs, 0)
+}
+
+func (t *Device) writePackets(bufs []*buffer.Data) (int, error) {
+	return writeBatch(t.rc, bufs)
+}
+
 func getInterfaceIndex(fd int, name string) (int32, error) {
 	var indexRequest struct {
 		name  [unix.IFNAMSIZ]byte
@@ -0,0 +1,184 @@
+package vif
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/buffer"
+)
+
+// packetPair returns a Device and the peer file descriptor of a packet socket pair, which, like a TUN
+// device, preserves the boundaries of the packets.
+func packetPair(tb testing.TB) (*Device, int) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET, 0)
+	require.NoError(tb, err)
+	require.NoError(tb, unix.SetNonblock(fds[0], true))
+	f := os.NewFile(uintptr(fds[0]), "tun")
+	rc, err := f.SyscallConn()
+	require.NoError(tb, err)
+	tb.Cleanup(func() {
+		_ = f.Close()
+		_ = unix.Close(fds[1])
+	})
+	return &Device{File: f, rc: rc, name: "tun"}, fds[1]
+}
+
+func getBufs(n int) []*buffer.Data {
+	bufs := make([]*buffer.Data, n)
+	for i := range bufs {
+		bufs[i] = buffer.DataPool.Get(buffer.DataPool.MTU)
+	}
+	return bufs
+}
+
+func putBufs(bufs []*buffer.Data) {
+	for _, b := range bufs {
+		buffer.DataPool.Put(b)
+	}
+}
+
+func TestDevice_ReadPackets(t *testing.T) {
+	dev, peer := packetPair(t)
+	for i := 1; i <= 5; i++ {
+		_, err := unix.Write(peer, make([]byte, i*100))
+		require.NoError(t, err)
+	}
+
+	bufs := getBufs(3)
+	defer putBufs(bufs)
+	n, err := dev.ReadPackets(bufs)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	for i := 0; i < n; i++ {
+		assert.Len(t, bufs[i].Buf(), (i+1)*100)
+	}
+
+	// The remaining packets, without waiting for more
+	for _, b := range bufs {
+		b.SetLength(buffer.DataPool.MTU)
+	}
+	n, err = dev.ReadPackets(bufs)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	assert.Len(t, bufs[0].Buf(), 400)
+	assert.Len(t, bufs[1].Buf(), 500)
+}
+
+func TestDevice_WritePackets(t *testing.T) {
+	dev, peer := packetPair(t)
+	bufs := getBufs(4)
+	defer putBufs(bufs)
+	for i, b := range bufs {
+		b.SetLength((i + 1) * 10)
+		b.Buf()[0] = byte(i)
+	}
+	n, err := dev.WritePackets(bufs)
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+
+	rb := make([]byte, buffer.DataPool.MTU)
+	for i := range bufs {
+		rn, err := unix.Read(peer, rb)
+		require.NoError(t, err)
+		assert.Equal(t, (i+1)*10, rn)
+		assert.Equal(t, byte(i), rb[0])
+	}
+}
+
+const benchBatch = 32
+
+// BenchmarkDevice_ReadPacket reads packets one at a time, the way the TUN reader did before it read
+// batches. Compare with BenchmarkDevice_ReadPackets.
+func BenchmarkDevice_ReadPacket(b *testing.B) {
+	dev, peer := packetPair(b)
+	pkt := make([]byte, 1400)
+	data := buffer.DataPool.Get(buffer.DataPool.MTU)
+	defer buffer.DataPool.Put(data)
+	b.SetBytes(int64(len(pkt)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i += benchBatch {
+		for j := 0; j < benchBatch; j++ {
+			if _, err := unix.Write(peer, pkt); err != nil {
+				b.Fatal(err)
+			}
+		}
+		for j := 0; j < benchBatch; j++ {
+			data.SetLength(buffer.DataPool.MTU)
+			if _, err := dev.ReadPacket(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkDevice_ReadPackets(b *testing.B) {
+	dev, peer := packetPair(b)
+	pkt := make([]byte, 1400)
+	bufs := getBufs(benchBatch)
+	defer putBufs(bufs)
+	b.SetBytes(int64(len(pkt)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i += benchBatch {
+		for j := 0; j < benchBatch; j++ {
+			if _, err := unix.Write(peer, pkt); err != nil {
+				b.Fatal(err)
+			}
+		}
+		for r := 0; r < benchBatch; {
+			for _, data := range bufs {
+				data.SetLength(buffer.DataPool.MTU)
+			}
+			n, err := dev.ReadPackets(bufs[:benchBatch-r])
+			if err != nil {
+				b.Fatal(err)
+			}
+			r += n
+		}
+	}
+}
+
+// BenchmarkDevice_WritePacket writes packets one at a time, the way the handlers did before the writes
+// were batched. Compare with BenchmarkDevice_WritePackets.
+func BenchmarkDevice_WritePacket(b *testing.B) {
+	dev, peer := packetPair(b)
+	bufs := getBufs(benchBatch)
+	defer putBufs(bufs)
+	rb := make([]byte, buffer.DataPool.MTU)
+	b.SetBytes(int64(buffer.DataPool.MTU))
+	b.ResetTimer()
+	for i := 0; i < b.N; i += benchBatch {
+		for _, data := range bufs {
+			if _, err := dev.WritePacket(data, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+		for range bufs {
+			if _, err := unix.Read(peer, rb); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkDevice_WritePackets(b *testing.B) {
+	dev, peer := packetPair(b)
+	bufs := getBufs(benchBatch)
+	defer putBufs(bufs)
+	rb := make([]byte, buffer.DataPool.MTU)
+	b.SetBytes(int64(buffer.DataPool.MTU))
+	b.ResetTimer()
+	for i := 0; i < b.N; i += benchBatch {
+		if _, err := dev.WritePackets(bufs); err != nil {
+			b.Fatal(err)
+		}
+		for range bufs {
+			if _, err := unix.Read(peer, rb); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
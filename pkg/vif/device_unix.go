@@ -5,12 +5,22 @@ package vif
 
 import (
 	"context"
+	"io"
 	"net"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/buffer"
 )
 
+// rawConn is the part of the syscall.RawConn of the device's file that is used to read and write batches
+// of packets.
+type rawConn interface {
+	Read(f func(fd uintptr) (done bool)) error
+	Write(f func(fd uintptr) (done bool)) error
+}
+
 func (t *Device) setDNS(_ context.Context, server net.IP, domains []string) (err error) {
 	// DNS is configured by other means than through the actual device
 	return nil
@@ -28,3 +38,67 @@ func withSocket(domain int, f func(fd int) error) error {
 func ioctl(socket int, request uint, requestData unsafe.Pointer) error {
 	return unix.IoctlSetInt(socket, request, int(uintptr(requestData)))
 }
+
+// readBatch reads packets into the given buffers, one packet per buffer. It waits for the first packet,
+// and then reads the packets that are immediately available. The TUN device isn't a socket, so there's
+// no recvmmsg, but reading all available packets in one wake-up of the poller avoids parking and waking
+// the reader for each packet. The prefixLen is the length of the header that precedes each packet.
+func readBatch(rc rawConn, bufs []*buffer.Data, prefixLen int) (int, error) {
+	n := 0
+	var readErr error
+	err := rc.Read(func(fd uintptr) bool {
+		for n < len(bufs) {
+			rn, err := unix.Read(int(fd), bufs[n].Raw())
+			switch {
+			case err == unix.EINTR:
+			case err == unix.EAGAIN:
+				// Wait for the first packet, but not for the ones that follow it.
+				return n > 0
+			case err != nil:
+				readErr = err
+				return true
+			case rn == 0:
+				readErr = io.EOF
+				return true
+			case rn > prefixLen:
+				bufs[n].SetLength(rn - prefixLen)
+				n++
+			}
+		}
+		return true
+	})
+	if n > 0 {
+		// An error that follows a packet is returned by the next read.
+		return n, nil
+	}
+	if err == nil {
+		err = readErr
+	}
+	return 0, err
+}
+
+// writeBatch writes each of the given buffers as one packet, and waits when the device can't accept more.
+func writeBatch(rc rawConn, bufs []*buffer.Data) (int, error) {
+	n := 0
+	var writeErr error
+	err := rc.Write(func(fd uintptr) bool {
+		for n < len(bufs) {
+			_, err := unix.Write(int(fd), bufs[n].Raw())
+			switch err {
+			case nil:
+				n++
+			case unix.EINTR:
+			case unix.EAGAIN:
+				return false
+			default:
+				writeErr = err
+				return true
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = writeErr
+	}
+	return n, err
+}
@@ -5,10 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"golang.org/x/sys/windows"
 	"golang.zx2c4.com/wireguard/tun"
+	"golang.zx2c4.com/wireguard/tun/wintun"
 	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
 
 	"github.com/datawire/dlib/derror"
@@ -18,10 +22,20 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/vif/buffer"
 )
 
+// ringCapacity is the capacity of each of the send and receive rings of the wintun session. It's four
+// times the capacity that the wireguard tun.Device uses, so that a burst of packets isn't dropped while
+// the reader is busy dispatching a batch.
+const ringCapacity = 0x2000000 // 32 MiB
+
 // This device will require that wintun.dll is available to the loader.
 // See: https://www.wintun.net/ for more info.
 type Device struct {
-	tun.Device
+	adapter        *wintun.Adapter
+	session        wintun.Session
+	readWait       windows.Handle
+	running        sync.WaitGroup
+	closed         int32
+	closeOnce      sync.Once
 	name           string
 	dns            net.IP
 	interfaceIndex uint32
@@ -37,53 +51,55 @@ func openTun(ctx context.Context) (td *Device, err error) {
 		}
 	}()
 	interfaceName := "tel0"
+
+	// An adapter with this name is a leftover of a daemon that didn't terminate gracefully. It's
+	// deleted, so that its residual configuration doesn't apply.
+	if adapter, err := tun.WintunPool.OpenAdapter(interfaceName); err == nil {
+		if _, err = adapter.Delete(true); err != nil {
+			return nil, fmt.Errorf("failed to delete existing TUN device: %w", err)
+		}
+	}
 	td = &Device{}
-	if td.Device, err = tun.CreateTUN(interfaceName, 0); err != nil {
+	if td.adapter, _, err = tun.WintunPool.CreateAdapter(interfaceName, tun.WintunStaticRequestedGUID); err != nil {
 		return nil, fmt.Errorf("failed to create TUN device: %w", err)
 	}
-	if td.name, err = td.Device.Name(); err != nil {
+	defer func() {
+		if err != nil {
+			_, _ = td.adapter.Delete(false)
+		}
+	}()
+	if td.session, err = td.adapter.StartSession(ringCapacity); err != nil {
+		return nil, fmt.Errorf("failed to start TUN session: %w", err)
+	}
+	td.readWait = td.session.ReadWaitEvent()
+	if td.name, err = td.adapter.Name(); err != nil {
+		td.session.End()
 		return nil, fmt.Errorf("failed to get real name of TUN device: %w", err)
 	}
 	iface, err := td.getLUID().Interface()
 	if err != nil {
+		td.session.End()
 		return nil, fmt.Errorf("failed to get interface for TUN device: %w", err)
 	}
 	td.interfaceIndex = iface.InterfaceIndex
-
 	return td, nil
 }
 
-func (t *Device) Close() error {
-	// The tun.NativeTun device has a closing mutex which is read locked during
-	// a call to Read(). The read lock prevents a call to Close() to proceed
-	// until Read() actually receives something. To resolve that "deadlock",
-	// we call Close() in one goroutine to wait for the lock and write a bogus
-	// message in another that will be returned by Read().
-	closeCh := make(chan error)
-	go func() {
-		// first message is just to indicate that this goroutine has started
-		closeCh <- nil
-		closeCh <- t.Device.Close()
-		close(closeCh)
-	}()
-
-	// Not 100%, but we can be fairly sure that Close() is
-	// hanging on the lock, or at least will be by the time
-	// the Read() returns
-	<-closeCh
-
-	// Send something to the TUN device so that the Read
-	// unlocks the NativeTun.closing mutex and let the actual
-	// Close call continue
-	conn, err := net.Dial("udp", t.dns.String()+":53")
-	if err == nil {
-		_, _ = conn.Write([]byte("bogus"))
-	}
-	return <-closeCh
+// Close ends the session and deletes the adapter. A pending read is woken up by a signal on the read
+// wait event, and the session isn't ended until it has returned.
+func (t *Device) Close() (err error) {
+	t.closeOnce.Do(func() {
+		atomic.StoreInt32(&t.closed, 1)
+		_ = windows.SetEvent(t.readWait)
+		t.running.Wait()
+		t.session.End()
+		_, err = t.adapter.Delete(false)
+	})
+	return err
 }
 
 func (t *Device) getLUID() winipcfg.LUID {
-	return winipcfg.LUID(t.Device.(*tun.NativeTun).LUID())
+	return winipcfg.LUID(t.adapter.LUID())
 }
 
 func (t *Device) addSubnet(_ context.Context, subnet *net.IPNet) error {
@@ -161,9 +177,81 @@ func (t *Device) setMTU(mtu int) error {
 }
 
 func (t *Device) readPacket(into *buffer.Data) (int, error) {
-	return t.Device.Read(into.Raw(), 0)
+	n, err := t.readPackets([]*buffer.Data{into})
+	if n == 0 {
+		return 0, err
+	}
+	return len(into.Buf()), nil
 }
 
 func (t *Device) writePacket(from *buffer.Data, offset int) (int, error) {
-	return t.Device.Write(from.Raw(), offset)
+	return t.write(from.Raw()[offset:])
+}
+
+// readPackets waits until the receive ring has a packet, and then moves the packets of the ring into the
+// given buffers until the ring is empty or all buffers are filled.
+func (t *Device) readPackets(bufs []*buffer.Data) (int, error) {
+	t.running.Add(1)
+	defer t.running.Done()
+	n := 0
+	for n < len(bufs) {
+		if atomic.LoadInt32(&t.closed) != 0 {
+			return n, os.ErrClosed
+		}
+		packet, err := t.session.ReceivePacket()
+		switch err {
+		case nil:
+			b := bufs[n]
+			b.SetLength(len(packet))
+			copy(b.Raw(), packet)
+			t.session.ReleaseReceivePacket(packet)
+			n++
+		case windows.ERROR_NO_MORE_ITEMS:
+			if n > 0 {
+				return n, nil
+			}
+			if _, err = windows.WaitForSingleObject(t.readWait, windows.INFINITE); err != nil {
+				return 0, fmt.Errorf("read failed: %w", err)
+			}
+		case windows.ERROR_HANDLE_EOF:
+			return n, os.ErrClosed
+		case windows.ERROR_INVALID_DATA:
+			return n, errors.New("send ring corrupt")
+		default:
+			return n, fmt.Errorf("read failed: %w", err)
+		}
+	}
+	return n, nil
+}
+
+// writePackets moves the given packets into the send ring. A packet that doesn't fit because the ring
+// is full is dropped, just like a network interface drops packets that it can't keep up with.
+func (t *Device) writePackets(bufs []*buffer.Data) (int, error) {
+	for i, b := range bufs {
+		if _, err := t.write(b.Raw()); err != nil {
+			return i, err
+		}
+	}
+	return len(bufs), nil
+}
+
+func (t *Device) write(packet []byte) (int, error) {
+	t.running.Add(1)
+	defer t.running.Done()
+	if atomic.LoadInt32(&t.closed) != 0 {
+		return 0, os.ErrClosed
+	}
+	sp, err := t.session.AllocateSendPacket(len(packet))
+	switch err {
+	case nil:
+		copy(sp, packet)
+		t.session.SendPacket(sp)
+		return len(packet), nil
+	case windows.ERROR_HANDLE_EOF:
+		return 0, os.ErrClosed
+	case windows.ERROR_BUFFER_OVERFLOW:
+		return len(packet), nil
+	default:
+		return 0, fmt.Errorf("write failed: %w", err)
+	}
 }
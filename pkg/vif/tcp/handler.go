@@ -27,6 +27,8 @@ const (
 	stateFinWait2
 	stateTimedWait
 	stateIdle
+	stateCloseWait
+	stateLastAck
 )
 
 func (s state) String() (txt string) {
@@ -45,6 +47,10 @@ func (s state) String() (txt string) {
 		txt = "FIN_WAIT_2"
 	case stateTimedWait:
 		txt = "TIMED WAIT"
+	case stateCloseWait:
+		txt = "CLOSE_WAIT"
+	case stateLastAck:
+		txt = "LAST_ACK"
 	default:
 		panic("unknown state")
 	}
@@ -147,9 +153,16 @@ type handler struct {
 	// recovered again.
 	packetsLost int64
 
-	// finalSeq is the ack sent with FIN when a connection is closing.
+	// finalSeq is the ack that the peer sends when it has received our FIN.
 	finalSeq uint32
 
+	// halfClosed is 1 when the manager has closed its side of the connection, and the handler waits for
+	// the TUN peer to close its side too.
+	halfClosed int32
+
+	// cancel ends the processing of this handler
+	cancel context.CancelFunc
+
 	// myWindow and is the actual size of my window
 	myWindow int64
 
@@ -238,7 +251,8 @@ func (h *handler) HandlePacket(ctx context.Context, pkt Packet) {
 }
 
 func (h *handler) Close(ctx context.Context) {
-	if h.state() == stateEstablished || h.state() == stateSynReceived {
+	switch h.state() {
+	case stateEstablished, stateSynReceived:
 		if h.muxTunnel != nil {
 			// Wait for the fromMgr queue to drain before sending a FIN
 			atomic.StoreInt32(&h.isClosing, 1)
@@ -246,11 +260,35 @@ func (h *handler) Close(ctx context.Context) {
 		}
 		h.setState(ctx, stateFinWait1)
 		h.sendFin(ctx, true)
+	case stateCloseWait:
+		h.setState(ctx, stateLastAck)
+		h.sendFin(ctx, true)
+	case stateFinWait1, stateFinWait2:
+		if atomic.CompareAndSwapInt32(&h.halfClosed, 1, 0) {
+			// The TUN peer didn't close its side of the half-closed connection in time.
+			dlog.Debugf(ctx, "   CON %s, closing half-closed connection", h.id)
+			h.cancel()
+		}
+	}
+}
+
+// closeByMgr is called when the manager will send no more data. A FIN is sent to the TUN peer, which
+// may continue to send data until it sends a FIN too.
+func (h *handler) closeByMgr(ctx context.Context) {
+	switch h.state() {
+	case stateEstablished:
+		atomic.StoreInt32(&h.halfClosed, 1)
+		h.setState(ctx, stateFinWait1)
+		h.sendFin(ctx, true)
+	case stateCloseWait:
+		h.setState(ctx, stateLastAck)
+		h.sendFin(ctx, true)
 	}
 }
 
 func (h *handler) Start(ctx context.Context) {
 	ctx, cancel := context.WithCancel(ctx)
+	h.cancel = cancel
 	go h.processResends(ctx)
 	go func() {
 		defer cancel()
@@ -349,7 +387,7 @@ func (h *handler) sendFin(ctx context.Context, expectAck bool) {
 	l := uint32(0)
 	if expectAck {
 		l = 1
-		h.finalSeq = h.sequence()
+		h.setFinalSequence(h.sequence() + 1)
 	}
 	h.sendToTun(ctx, pkt, l, true)
 }
@@ -398,7 +436,7 @@ func (h *handler) processPayload(ctx context.Context, data []byte) {
 			// wait for the window to increase.
 			dlog.Debugf(ctx, "   CON %s TCP window is zero", h.id)
 			h.sendCondition.Wait()
-			if h.state() != stateEstablished {
+			if s := h.state(); s != stateEstablished && s != stateCloseWait {
 				h.sendLock.Unlock()
 				return
 			}
@@ -436,7 +474,11 @@ func (h *handler) processPayload(ctx context.Context, data []byte) {
 
 		// Decrease the window size with the bytes that we just sent unless it's already updated
 		// from a received packet
-		atomic.CompareAndSwapInt64(&h.peerWindow, int64(window), int64(window-mxSend))
+		h.sendLock.Lock()
+		if h.peerWindow == int64(window) {
+			h.peerWindow = int64(window - mxSend)
+		}
+		h.sendLock.Unlock()
 		start = end
 	}
 }
@@ -562,12 +604,23 @@ func (h *handler) handleReceived(ctx context.Context, pkt Packet) quitReason {
 	sq := tcpHdr.Sequence()
 	lastAck := h.peerSequenceAcked()
 	payloadLen := len(tcpHdr.Payload())
+	fin := tcpHdr.FIN() // The packet may be released once it's sent to the manager
 	state := h.state()
 	switch {
 	case sq == lastAck:
-		if state == stateFinWait1 && ackNbr == h.finalSeq && !tcpHdr.FIN() {
-			h.setState(ctx, stateTimedWait)
-			return quitByUs
+		if ackNbr == h.finalSequence() && !fin {
+			switch {
+			case state == stateFinWait1 && atomic.LoadInt32(&h.halfClosed) == 1:
+				// Our FIN is acked, but the peer may continue to send data until it sends its FIN.
+				h.setState(ctx, stateFinWait2)
+				state = stateFinWait2
+			case state == stateFinWait1:
+				h.setState(ctx, stateTimedWait)
+				return quitByUs
+			case state == stateLastAck:
+				h.setState(ctx, stateTimedWait)
+				return quitByBoth
+			}
 		}
 	case sq > lastAck:
 		if sq <= h.lastKnown {
@@ -610,14 +663,18 @@ func (h *handler) handleReceived(ctx context.Context, pkt Packet) quitReason {
 
 	switch {
 	case payloadLen > 0:
-		h.lastKnown = tcpHdr.Sequence() + uint32(payloadLen)
+		h.lastKnown = sq + uint32(payloadLen)
 		release = false
 		if !h.sendToMgr(ctx, pkt) {
 			h.packetsLost++
 			return pleaseContinue
 		}
+		if fin {
+			// The FIN counts as one byte
+			h.lastKnown++
+		}
 		h.setPeerSequenceToAck(h.lastKnown)
-	case tcpHdr.FIN():
+	case fin:
 		h.setPeerSequenceToAck(lastAck + 1)
 	default:
 		// don't ack an ack
@@ -627,19 +684,28 @@ func (h *handler) handleReceived(ctx context.Context, pkt Packet) quitReason {
 
 	switch state {
 	case stateEstablished:
-		if tcpHdr.FIN() {
-			h.sendFin(ctx, false)
-			h.setState(ctx, stateTimedWait)
-			return quitByPeer
+		if fin {
+			if h.muxTunnel != nil {
+				h.sendFin(ctx, false)
+				h.setState(ctx, stateTimedWait)
+				return quitByPeer
+			}
+			// The peer will send no more data, but the manager may still send data to the peer until
+			// it closes its side too.
+			h.setState(ctx, stateCloseWait)
+			h.closeToMgr(ctx)
 		}
 	case stateFinWait1:
-		if tcpHdr.FIN() {
+		if fin {
 			h.setState(ctx, stateTimedWait)
 			return quitByBoth
 		}
-		h.setState(ctx, stateFinWait2)
+		if atomic.LoadInt32(&h.halfClosed) == 0 {
+			h.setState(ctx, stateFinWait2)
+		}
 	case stateFinWait2:
-		if tcpHdr.FIN() {
+		if fin {
+			h.setState(ctx, stateTimedWait)
 			return quitByUs
 		}
 	}
@@ -887,8 +953,8 @@ func (h *handler) setState(ctx context.Context, s state) {
 	if oldState != s {
 		dlog.Debugf(ctx, "   CON %s, state %s -> %s", h.id, h.state(), s)
 		atomic.StoreInt32((*int32)(&h.wfState), int32(s))
-		if oldState == stateEstablished {
-			// Unblock any sender when moving from stateEstablished
+		if oldState == stateEstablished || oldState == stateCloseWait {
+			// Unblock any sender when moving from a state where it may send
 			h.sendCondition.Signal()
 		}
 	}
@@ -908,6 +974,15 @@ func (h *handler) setSequence(v uint32) {
 	atomic.StoreUint32(&h.seq, v)
 }
 
+// finalSequence is the ack that the peer sends when it has received our FIN
+func (h *handler) finalSequence() uint32 {
+	return atomic.LoadUint32(&h.finalSeq)
+}
+
+func (h *handler) setFinalSequence(v uint32) {
+	atomic.StoreUint32(&h.finalSeq, v)
+}
+
 // peerSequenceToAck is the received sequence that this will ack on next send
 func (h *handler) peerSequenceToAck() uint32 {
 	return atomic.LoadUint32(&h.peerSeqToAck)
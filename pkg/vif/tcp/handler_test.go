@@ -0,0 +1,299 @@
+package tcp
+
+import (
+	"context"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
+	"github.com/telepresenceio/telepresence/v2/pkg/vif/ip"
+)
+
+// segment is what the handler wrote to the TUN device.
+type segment struct {
+	seq, ack uint32
+	syn, fin bool
+	payload  string
+}
+
+type tunRecorder chan segment
+
+func (r tunRecorder) Write(_ context.Context, pkt ip.Packet) error {
+	hdr := pkt.(Packet).Header()
+	r <- segment{seq: hdr.Sequence(), ack: hdr.AckNumber(), syn: hdr.SYN(), fin: hdr.FIN(), payload: string(hdr.Payload())}
+	return nil
+}
+
+// fakeStream is the stream to the traffic-manager. What the handler sends is written to the sent channel,
+// which is closed when the handler closes its sending side. Closing the recv channel closes the sending
+// side of the manager.
+type fakeStream struct {
+	sync.Mutex
+	id     tunnel.ConnID
+	sent   chan tunnel.Message
+	recv   chan tunnel.Message
+	closed bool
+
+	// closeSends counts the calls to CloseSend. Both the handler and its write loop call it when they end,
+	// and done is closed on the second call.
+	closeSends int
+	done       chan struct{}
+}
+
+func (s *fakeStream) Tag() string                     { return "TST" }
+func (s *fakeStream) ID() tunnel.ConnID               { return s.id }
+func (s *fakeStream) PeerVersion() uint16             { return tunnel.Version }
+func (s *fakeStream) SessionID() string               { return "session" }
+func (s *fakeStream) DialTimeout() time.Duration      { return time.Second }
+func (s *fakeStream) RoundtripLatency() time.Duration { return time.Second }
+
+func (s *fakeStream) Receive(ctx context.Context) (tunnel.Message, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case m, ok := <-s.recv:
+		if !ok {
+			return nil, net.ErrClosed
+		}
+		return m, nil
+	}
+}
+
+func (s *fakeStream) Send(_ context.Context, m tunnel.Message) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return net.ErrClosed
+	}
+	s.sent <- m
+	return nil
+}
+
+func (s *fakeStream) CloseSend(context.Context) error {
+	s.Lock()
+	defer s.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.sent)
+	}
+	s.closeSends++
+	if s.closeSends == 2 {
+		close(s.done)
+	}
+	return nil
+}
+
+// sentData returns the data that the handler sends to the manager until it closes its sending side.
+func (s *fakeStream) sentData(t *testing.T) string {
+	var data []byte
+	for {
+		select {
+		case m, ok := <-s.sent:
+			if !ok {
+				return string(data)
+			}
+			if m.Code() == tunnel.Normal {
+				data = append(data, m.Payload()...)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for the handler to close its sending side")
+		}
+	}
+}
+
+// tunPeer plays the part of the local process that makes a connection through the TUN device.
+type tunPeer struct {
+	*testing.T
+	ctx     context.Context
+	id      tunnel.ConnID
+	h       PacketHandler
+	tun     tunRecorder
+	stream  *fakeStream
+	removed chan struct{}
+	seq     uint32 // the next sequence that the peer sends
+	ack     uint32 // the next sequence that the peer expects
+}
+
+func newTunPeer(t *testing.T) *tunPeer {
+	ctx := dlog.NewTestContext(t, false)
+	id := tunnel.NewConnID(ipproto.TCP, net.IP{10, 0, 0, 1}, net.IP{10, 96, 0, 1}, 34567, 5432)
+	p := &tunPeer{
+		T:       t,
+		ctx:     ctx,
+		id:      id,
+		tun:     make(tunRecorder, 100),
+		stream:  &fakeStream{id: id, sent: make(chan tunnel.Message, 100), recv: make(chan tunnel.Message), done: make(chan struct{})},
+		removed: make(chan struct{}),
+		seq:     1000,
+	}
+	closing := int32(0)
+	p.h = NewHandler(func(context.Context) (tunnel.Stream, error) {
+		return p.stream, nil
+	}, nil, &closing, p.tun, id, func() { close(p.removed) }, rand.NewSource(1))
+	p.h.Start(ctx)
+	return p
+}
+
+// send sends a segment with the given flags and payload to the handler.
+func (p *tunPeer) send(syn, fin bool, payload string) {
+	hl := HeaderLen
+	if syn {
+		hl += 4 // for the Maximum Segment Size option
+	}
+	pkt := NewPacket(hl+len(payload), p.id.Source(), p.id.Destination(), false)
+	ipHdr := pkt.IPHeader()
+	ipHdr.SetL4Protocol(ipproto.TCP)
+	ipHdr.SetChecksum()
+
+	tcpHdr := Header(ipHdr.Payload())
+	tcpHdr.SetDataOffset(hl / 4)
+	tcpHdr.SetSourcePort(p.id.SourcePort())
+	tcpHdr.SetDestinationPort(p.id.DestinationPort())
+	tcpHdr.SetSequence(p.seq)
+	tcpHdr.SetWindowSize(0xffff)
+	tcpHdr.SetSYN(syn)
+	tcpHdr.SetFIN(fin)
+	if syn {
+		opts := tcpHdr.OptionBytes()
+		opts[0] = byte(maximumSegmentSize)
+		opts[1] = 4
+		binary.BigEndian.PutUint16(opts[2:], 1460)
+	} else {
+		tcpHdr.SetACK(true)
+		tcpHdr.SetAckNumber(p.ack)
+	}
+	if payload != "" {
+		copy(tcpHdr.Payload(), payload)
+		tcpHdr.SetPSH(true)
+	}
+	tcpHdr.SetChecksum(ipHdr)
+
+	p.seq += uint32(len(payload))
+	if syn || fin {
+		p.seq++
+	}
+	p.h.HandlePacket(p.ctx, pkt)
+}
+
+// expect returns the next segment that the handler writes to the TUN device, and advances the
+// sequence that the peer expects.
+func (p *tunPeer) expect() segment {
+	select {
+	case s := <-p.tun:
+		p.ack = s.seq + uint32(len(s.payload))
+		if s.syn || s.fin {
+			p.ack++
+		}
+		return s
+	case <-time.After(5 * time.Second):
+		p.Fatal("timeout waiting for a segment from the handler")
+		return segment{}
+	}
+}
+
+// connect performs the three-way handshake and sends a request to the manager.
+func (p *tunPeer) connect() {
+	p.send(true, false, "")
+	s := p.expect()
+	require.True(p, s.syn)
+	require.Equal(p, p.seq, s.ack)
+	p.send(false, false, "") // ACK of SYN
+
+	p.send(false, false, "request")
+	require.Equal(p, segment{seq: p.ack, ack: p.seq}, p.expect())
+}
+
+// awaitRemoved waits for the handler and its write loop to end, so that nothing is logged after the
+// test has completed.
+func (p *tunPeer) awaitRemoved() {
+	for _, ch := range []chan struct{}{p.removed, p.stream.done} {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			p.Fatal("timeout waiting for the handler to end")
+		}
+	}
+}
+
+func TestHandler_halfCloseByTunPeer(t *testing.T) {
+	p := newTunPeer(t)
+	p.connect()
+
+	// The peer closes its sending side. The FIN is acked, and the manager is told that no more data will arrive
+	p.send(false, true, "")
+	require.Equal(t, segment{seq: p.ack, ack: p.seq}, p.expect())
+	assert.Equal(t, "request", p.stream.sentData(t))
+	assert.Equal(t, stateCloseWait, p.h.(*handler).state())
+
+	// The response is still delivered after a while
+	time.Sleep(100 * time.Millisecond)
+	p.stream.recv <- tunnel.NewMessage(tunnel.Normal, []byte("response"))
+	s := p.expect()
+	require.Equal(t, "response", s.payload)
+	p.send(false, false, "")
+
+	// The manager closes its side, so the connection is closed once the FIN is acked
+	close(p.stream.recv)
+	require.True(t, p.expect().fin)
+	assert.Equal(t, stateLastAck, p.h.(*handler).state())
+	p.send(false, false, "")
+	p.awaitRemoved()
+}
+
+func TestHandler_halfCloseByManager(t *testing.T) {
+	p := newTunPeer(t)
+	p.connect()
+
+	// The manager sends its response and closes its sending side
+	p.stream.recv <- tunnel.NewMessage(tunnel.Normal, []byte("response"))
+	close(p.stream.recv)
+	require.Equal(t, "response", p.expect().payload)
+	require.True(t, p.expect().fin)
+	p.send(false, false, "") // ACK of FIN
+
+	// The peer still sends data after a while, and then closes its side
+	time.Sleep(100 * time.Millisecond)
+	p.send(false, false, " more")
+	require.Equal(t, segment{seq: p.ack, ack: p.seq}, p.expect())
+	assert.Equal(t, stateFinWait2, p.h.(*handler).state())
+	p.send(false, true, "")
+	require.Equal(t, segment{seq: p.ack, ack: p.seq}, p.expect())
+	assert.Equal(t, "request more", p.stream.sentData(t))
+	p.awaitRemoved()
+}
+
+func TestHandler_finWithData(t *testing.T) {
+	p := newTunPeer(t)
+	p.connect()
+
+	// The FIN that arrives with the last data is acked together with it
+	p.send(false, true, " last")
+	require.Equal(t, segment{seq: p.ack, ack: p.seq}, p.expect())
+	assert.Equal(t, "request last", p.stream.sentData(t))
+	close(p.stream.recv)
+	require.True(t, p.expect().fin)
+	p.send(false, false, "")
+	p.awaitRemoved()
+}
+
+func TestHandler_closeHalfClosed(t *testing.T) {
+	p := newTunPeer(t)
+	p.connect()
+	close(p.stream.recv)
+	require.True(t, p.expect().fin)
+	p.send(false, false, "")
+
+	// A half-closed connection that is evicted by the pool ends, even though the peer didn't close its side
+	require.Eventually(t, func() bool { return p.h.(*handler).state() == stateFinWait2 }, 5*time.Second, time.Millisecond)
+	p.h.Close(p.ctx)
+	p.awaitRemoved()
+}
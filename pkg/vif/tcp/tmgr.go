@@ -120,6 +120,9 @@ func (h *handler) readFromMgrLoop(ctx context.Context) {
 			dlog.Error(ctx, err)
 		case m := <-fromMgrCh:
 			if m == nil {
+				if ctx.Err() == nil {
+					h.closeByMgr(ctx)
+				}
 				return
 			}
 
@@ -188,6 +191,7 @@ func (h *handler) writeToMgrLoop(ctx context.Context) {
 	const maxBufSize = 0x10000
 
 	var mgrWrite func(payload []byte) bool
+	var mgrWriteControl func(m tunnel.Message) bool
 	if h.muxTunnel != nil {
 		mgrWrite = func(payload []byte) bool {
 			dlog.Tracef(ctx, "-> MGR %s, len %d", h.id, len(payload))
@@ -200,13 +204,25 @@ func (h *handler) writeToMgrLoop(ctx context.Context) {
 			return false
 		}
 	} else {
-		defer close(h.toMgrMsgCh)
-		tunnel.WriteLoop(ctx, h.stream, h.toMgrMsgCh)
+		// Closing msgCh closes the sending side of the stream. The h.toMgrMsgCh is never closed, so that
+		// the control messages sent by other goroutines are ignored rather than causing a panic once
+		// this loop has ended.
+		msgCh := make(chan tunnel.Message)
+		defer close(msgCh)
+		tunnel.WriteLoop(ctx, h.stream, msgCh)
 		mgrWrite = func(payload []byte) bool {
 			select {
 			case <-ctx.Done():
 				return true
-			case h.toMgrMsgCh <- tunnel.NewMessage(tunnel.Normal, payload):
+			case msgCh <- tunnel.NewMessage(tunnel.Normal, payload):
+				return false
+			}
+		}
+		mgrWriteControl = func(m tunnel.Message) bool {
+			select {
+			case <-ctx.Done():
+				return true
+			case msgCh <- m:
 				return false
 			}
 		}
@@ -234,8 +250,18 @@ func (h *handler) writeToMgrLoop(ctx context.Context) {
 			}
 		case <-h.tunDone:
 			return
+		case m := <-h.toMgrMsgCh:
+			if mgrWriteControl != nil && mgrWriteControl(m) {
+				return
+			}
 		case pkt := <-h.toMgrCh:
 			if pkt == nil {
+				// The TUN peer will send no more data. Flush what's buffered and close the sending
+				// side of the stream.
+				if buf.Len() > 0 {
+					flushTimer.Stop()
+					sendBuf()
+				}
 				return
 			}
 			h.adjustReceiveWindow()
@@ -275,6 +301,17 @@ func (h *handler) sendConnControl(ctx context.Context, code connpool.ControlCode
 func (h *handler) sendStreamControl(ctx context.Context, code tunnel.MessageCode) {
 	select {
 	case <-ctx.Done():
+	case <-h.tunDone:
 	case h.toMgrMsgCh <- tunnel.NewMessage(code, nil):
 	}
 }
+
+// closeToMgr makes the writeToMgrLoop send what it has buffered and then close the sending side of
+// the stream.
+func (h *handler) closeToMgr(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-h.tunDone:
+	case h.toMgrCh <- nil:
+	}
+}
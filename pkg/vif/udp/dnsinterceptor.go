@@ -4,7 +4,6 @@ import (
 	"context"
 	"net"
 	"sync"
-	"time"
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/v2/pkg/tunnel"
@@ -12,7 +11,7 @@ import (
 )
 
 type dnsInterceptor struct {
-	timedHandler
+	flowHandler
 	toTun   ip.Writer
 	fromTun chan Datagram
 	dnsConn *net.UDPConn
@@ -22,7 +21,7 @@ type dnsInterceptor struct {
 // instead of passing them on to the traffic-manager
 func NewDnsInterceptor(toTun ip.Writer, id tunnel.ConnID, remove func(), dnsAddr *net.UDPAddr) (DatagramHandler, error) {
 	h := &dnsInterceptor{
-		timedHandler: timedHandler{
+		flowHandler: flowHandler{
 			id:     id,
 			remove: remove,
 		},
@@ -40,7 +39,7 @@ func (h *dnsInterceptor) Close(ctx context.Context) {
 	if h.dnsConn != nil {
 		_ = h.dnsConn.Close()
 	}
-	h.timedHandler.Close(ctx)
+	h.flowHandler.Close(ctx)
 }
 
 func (h *dnsInterceptor) HandleDatagram(ctx context.Context, dg Datagram) {
@@ -51,7 +50,6 @@ func (h *dnsInterceptor) HandleDatagram(ctx context.Context, dg Datagram) {
 }
 
 func (h *dnsInterceptor) Start(ctx context.Context) {
-	h.idleTimer = time.NewTimer(idleDuration)
 	go func() {
 		defer h.Close(ctx)
 		wg := sync.WaitGroup{}
@@ -89,8 +87,6 @@ func (h *dnsInterceptor) tunToConn(ctx context.Context, wg *sync.WaitGroup) {
 		select {
 		case <-ctx.Done():
 			return
-		case <-h.idleTimer.C:
-			return
 		case dg := <-h.fromTun:
 			payload := dg.Header().Payload()
 			pn := len(payload)
@@ -103,9 +99,6 @@ func (h *dnsInterceptor) tunToConn(ctx context.Context, wg *sync.WaitGroup) {
 				n += wn
 			}
 			dg.Release()
-			if !h.resetIdle() {
-				return
-			}
 		}
 	}
 }
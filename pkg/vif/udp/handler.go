@@ -2,8 +2,6 @@ package udp
 
 import (
 	"context"
-	"sync"
-	"time"
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/v2/pkg/connpool"
@@ -16,29 +14,19 @@ type DatagramHandler interface {
 	HandleDatagram(ctx context.Context, dg Datagram)
 }
 
-type timedHandler struct {
-	id        tunnel.ConnID
-	idleTimer *time.Timer
-	idleLock  sync.Mutex
-	remove    func()
+// flowHandler is the part that the UDP handlers have in common. A flow has no end, so its handler is
+// closed by the owner of the tunnel.Pool that it's in, when the flow has been idle for too long.
+type flowHandler struct {
+	id     tunnel.ConnID
+	remove func()
 }
 
-func (h *timedHandler) resetIdle() bool {
-	h.idleLock.Lock()
-	stopped := h.idleTimer.Stop()
-	if stopped {
-		h.idleTimer.Reset(idleDuration)
-	}
-	h.idleLock.Unlock()
-	return stopped
-}
-
-func (h *timedHandler) Close(_ context.Context) {
+func (h *flowHandler) Close(_ context.Context) {
 	h.remove()
 }
 
 type handler struct {
-	timedHandler
+	flowHandler
 	stream    tunnel.Stream
 	muxTunnel connpool.MuxTunnel // Deprecated
 	toTun     ip.Writer
@@ -46,11 +34,10 @@ type handler struct {
 }
 
 const ioChannelSize = 0x40
-const idleDuration = 5 * time.Second
 
 func NewHandler(stream tunnel.Stream, muxTunnel connpool.MuxTunnel, toTun ip.Writer, id tunnel.ConnID, remove func()) DatagramHandler {
 	return &handler{
-		timedHandler: timedHandler{
+		flowHandler: flowHandler{
 			id:     id,
 			remove: remove,
 		},
@@ -95,7 +82,6 @@ func sendUDPToTun(ctx context.Context, id tunnel.ConnID, payload []byte, toTun i
 }
 
 func (h *handler) Start(ctx context.Context) {
-	h.idleTimer = time.NewTimer(idleDuration)
 	go h.writeLoop(ctx)
 }
 
@@ -105,13 +91,7 @@ func (h *handler) writeLoop(ctx context.Context) {
 		select {
 		case <-ctx.Done():
 			return
-		case <-h.idleTimer.C:
-			return
 		case dg := <-h.fromTun:
-			if !h.resetIdle() {
-				dg.Release()
-				return
-			}
 			dlog.Debugf(ctx, "<- TUN %s", dg)
 			dlog.Debugf(ctx, "-> MGR %s", dg)
 			udpHdr := dg.Header()
@@ -30,18 +30,43 @@ type ConnectorClient interface {
 	// MUST_RESTART is returned, based on whether the current connection
 	// is in agreement with the ConnectionRequest.
 	Connect(ctx context.Context, in *ConnectRequest, opts ...grpc.CallOption) (*ConnectInfo, error)
+	// ConnectStream does the same as Connect, but streams the progress of
+	// the steps that it takes. The last message carries the ConnectInfo.
+	// Clients fall back to Connect when talking to a connector that
+	// doesn't implement this call.
+	ConnectStream(ctx context.Context, in *ConnectRequest, opts ...grpc.CallOption) (Connector_ConnectStreamClient, error)
 	// Status is much like Connect, except that it doesn't actually do
 	// anything.  It's a dry-run.
 	Status(ctx context.Context, in *ConnectRequest, opts ...grpc.CallOption) (*ConnectInfo, error)
 	// Adds an intercept to a workload.  Requires having already called
 	// Connect.
 	CreateIntercept(ctx context.Context, in *CreateInterceptRequest, opts ...grpc.CallOption) (*InterceptResult, error)
+	// PlanIntercept resolves the workload and service of an intercept and
+	// reports the changes that CreateIntercept would make to the cluster,
+	// without changing anything. Requires having already called Connect.
+	PlanIntercept(ctx context.Context, in *CreateInterceptRequest, opts ...grpc.CallOption) (*InterceptPlan, error)
 	// Deactivates and removes an existent workload intercept.
 	// Requires having already called Connect.
-	RemoveIntercept(ctx context.Context, in *manager.RemoveInterceptRequest2, opts ...grpc.CallOption) (*InterceptResult, error)
+	RemoveIntercept(ctx context.Context, in *RemoveInterceptRequest, opts ...grpc.CallOption) (*InterceptResult, error)
+	// HoldIntercept is called by the CLI that runs the handler of an intercept
+	// created with an owner_pid, and the call lasts for as long as the handler
+	// runs. When the call ends without the intercept being removed, and the
+	// owner no longer exists, the connector removes the intercept. No messages
+	// are sent on the stream.
+	HoldIntercept(ctx context.Context, in *HoldInterceptRequest, opts ...grpc.CallOption) (Connector_HoldInterceptClient, error)
 	// Uninstalls traffic-agents and traffic-manager from the cluster.
 	// Requires having already called Connect.
 	Uninstall(ctx context.Context, in *UninstallRequest, opts ...grpc.CallOption) (*UninstallResult, error)
+	// PortForward forwards local ports to the pods that back the given
+	// services or pods, using the connection to the cluster of the session.
+	// A snapshot of the forwards is sent when they are established and each
+	// time a forward moves to another pod. The forwards are removed when the
+	// call ends. Requires having already called Connect.
+	PortForward(ctx context.Context, in *PortForwardRequest, opts ...grpc.CallOption) (Connector_PortForwardClient, error)
+	// Resolve looks up a name in the cluster, using the same cluster side
+	// lookup as the DNS server of the root daemon. Requires having already
+	// called Connect.
+	Resolve(ctx context.Context, in *ResolveRequest, opts ...grpc.CallOption) (*ResolveResult, error)
 	// Returns a list of workloads and their current intercept status.
 	// Requires having already called Connect.
 	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*WorkloadInfoSnapshot, error)
@@ -56,6 +81,14 @@ type ConnectorClient interface {
 	GetCloudLicense(ctx context.Context, in *LicenseRequest, opts ...grpc.CallOption) (*LicenseData, error)
 	// SetLogLevel will temporarily set the log-level for the daemon for a duration that is determined by the request.
 	SetLogLevel(ctx context.Context, in *manager.LogLevelRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// GatherTraces returns the trace spans that the connector has buffered.
+	GatherTraces(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*common.Traces, error)
+	// ReportTelemetry adds a telemetry report from the CLI to the reports that the
+	// connector sends in batches.
+	ReportTelemetry(ctx context.Context, in *TelemetryReport, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// Disconnect ends one session and leaves the other sessions, and the
+	// connector process, running.
+	Disconnect(ctx context.Context, in *DisconnectRequest, opts ...grpc.CallOption) (*DisconnectResult, error)
 	// Quits (terminates) the connector process.
 	Quit(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error)
 }
@@ -86,6 +119,38 @@ func (c *connectorClient) Connect(ctx context.Context, in *ConnectRequest, opts
 	return out, nil
 }
 
+func (c *connectorClient) ConnectStream(ctx context.Context, in *ConnectRequest, opts ...grpc.CallOption) (Connector_ConnectStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Connector_ServiceDesc.Streams[0], "/telepresence.connector.Connector/ConnectStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &connectorConnectStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Connector_ConnectStreamClient interface {
+	Recv() (*ConnectProgress, error)
+	grpc.ClientStream
+}
+
+type connectorConnectStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *connectorConnectStreamClient) Recv() (*ConnectProgress, error) {
+	m := new(ConnectProgress)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *connectorClient) Status(ctx context.Context, in *ConnectRequest, opts ...grpc.CallOption) (*ConnectInfo, error) {
 	out := new(ConnectInfo)
 	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/Status", in, out, opts...)
@@ -104,7 +169,16 @@ func (c *connectorClient) CreateIntercept(ctx context.Context, in *CreateInterce
 	return out, nil
 }
 
-func (c *connectorClient) RemoveIntercept(ctx context.Context, in *manager.RemoveInterceptRequest2, opts ...grpc.CallOption) (*InterceptResult, error) {
+func (c *connectorClient) PlanIntercept(ctx context.Context, in *CreateInterceptRequest, opts ...grpc.CallOption) (*InterceptPlan, error) {
+	out := new(InterceptPlan)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/PlanIntercept", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *connectorClient) RemoveIntercept(ctx context.Context, in *RemoveInterceptRequest, opts ...grpc.CallOption) (*InterceptResult, error) {
 	out := new(InterceptResult)
 	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/RemoveIntercept", in, out, opts...)
 	if err != nil {
@@ -113,6 +187,38 @@ func (c *connectorClient) RemoveIntercept(ctx context.Context, in *manager.Remov
 	return out, nil
 }
 
+func (c *connectorClient) HoldIntercept(ctx context.Context, in *HoldInterceptRequest, opts ...grpc.CallOption) (Connector_HoldInterceptClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Connector_ServiceDesc.Streams[1], "/telepresence.connector.Connector/HoldIntercept", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &connectorHoldInterceptClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Connector_HoldInterceptClient interface {
+	Recv() (*emptypb.Empty, error)
+	grpc.ClientStream
+}
+
+type connectorHoldInterceptClient struct {
+	grpc.ClientStream
+}
+
+func (x *connectorHoldInterceptClient) Recv() (*emptypb.Empty, error) {
+	m := new(emptypb.Empty)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *connectorClient) Uninstall(ctx context.Context, in *UninstallRequest, opts ...grpc.CallOption) (*UninstallResult, error) {
 	out := new(UninstallResult)
 	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/Uninstall", in, out, opts...)
@@ -122,6 +228,47 @@ func (c *connectorClient) Uninstall(ctx context.Context, in *UninstallRequest, o
 	return out, nil
 }
 
+func (c *connectorClient) PortForward(ctx context.Context, in *PortForwardRequest, opts ...grpc.CallOption) (Connector_PortForwardClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Connector_ServiceDesc.Streams[2], "/telepresence.connector.Connector/PortForward", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &connectorPortForwardClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Connector_PortForwardClient interface {
+	Recv() (*PortForwardSnapshot, error)
+	grpc.ClientStream
+}
+
+type connectorPortForwardClient struct {
+	grpc.ClientStream
+}
+
+func (x *connectorPortForwardClient) Recv() (*PortForwardSnapshot, error) {
+	m := new(PortForwardSnapshot)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *connectorClient) Resolve(ctx context.Context, in *ResolveRequest, opts ...grpc.CallOption) (*ResolveResult, error) {
+	out := new(ResolveResult)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/Resolve", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *connectorClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*WorkloadInfoSnapshot, error) {
 	out := new(WorkloadInfoSnapshot)
 	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/List", in, out, opts...)
@@ -132,7 +279,7 @@ func (c *connectorClient) List(ctx context.Context, in *ListRequest, opts ...grp
 }
 
 func (c *connectorClient) UserNotifications(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (Connector_UserNotificationsClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Connector_ServiceDesc.Streams[0], "/telepresence.connector.Connector/UserNotifications", opts...)
+	stream, err := c.cc.NewStream(ctx, &Connector_ServiceDesc.Streams[3], "/telepresence.connector.Connector/UserNotifications", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -217,6 +364,33 @@ func (c *connectorClient) SetLogLevel(ctx context.Context, in *manager.LogLevelR
 	return out, nil
 }
 
+func (c *connectorClient) GatherTraces(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*common.Traces, error) {
+	out := new(common.Traces)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/GatherTraces", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *connectorClient) ReportTelemetry(ctx context.Context, in *TelemetryReport, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/ReportTelemetry", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *connectorClient) Disconnect(ctx context.Context, in *DisconnectRequest, opts ...grpc.CallOption) (*DisconnectResult, error) {
+	out := new(DisconnectResult)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/Disconnect", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *connectorClient) Quit(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error) {
 	out := new(emptypb.Empty)
 	err := c.cc.Invoke(ctx, "/telepresence.connector.Connector/Quit", in, out, opts...)
@@ -239,18 +413,43 @@ type ConnectorServer interface {
 	// MUST_RESTART is returned, based on whether the current connection
 	// is in agreement with the ConnectionRequest.
 	Connect(context.Context, *ConnectRequest) (*ConnectInfo, error)
+	// ConnectStream does the same as Connect, but streams the progress of
+	// the steps that it takes. The last message carries the ConnectInfo.
+	// Clients fall back to Connect when talking to a connector that
+	// doesn't implement this call.
+	ConnectStream(*ConnectRequest, Connector_ConnectStreamServer) error
 	// Status is much like Connect, except that it doesn't actually do
 	// anything.  It's a dry-run.
 	Status(context.Context, *ConnectRequest) (*ConnectInfo, error)
 	// Adds an intercept to a workload.  Requires having already called
 	// Connect.
 	CreateIntercept(context.Context, *CreateInterceptRequest) (*InterceptResult, error)
+	// PlanIntercept resolves the workload and service of an intercept and
+	// reports the changes that CreateIntercept would make to the cluster,
+	// without changing anything. Requires having already called Connect.
+	PlanIntercept(context.Context, *CreateInterceptRequest) (*InterceptPlan, error)
 	// Deactivates and removes an existent workload intercept.
 	// Requires having already called Connect.
-	RemoveIntercept(context.Context, *manager.RemoveInterceptRequest2) (*InterceptResult, error)
+	RemoveIntercept(context.Context, *RemoveInterceptRequest) (*InterceptResult, error)
+	// HoldIntercept is called by the CLI that runs the handler of an intercept
+	// created with an owner_pid, and the call lasts for as long as the handler
+	// runs. When the call ends without the intercept being removed, and the
+	// owner no longer exists, the connector removes the intercept. No messages
+	// are sent on the stream.
+	HoldIntercept(*HoldInterceptRequest, Connector_HoldInterceptServer) error
 	// Uninstalls traffic-agents and traffic-manager from the cluster.
 	// Requires having already called Connect.
 	Uninstall(context.Context, *UninstallRequest) (*UninstallResult, error)
+	// PortForward forwards local ports to the pods that back the given
+	// services or pods, using the connection to the cluster of the session.
+	// A snapshot of the forwards is sent when they are established and each
+	// time a forward moves to another pod. The forwards are removed when the
+	// call ends. Requires having already called Connect.
+	PortForward(*PortForwardRequest, Connector_PortForwardServer) error
+	// Resolve looks up a name in the cluster, using the same cluster side
+	// lookup as the DNS server of the root daemon. Requires having already
+	// called Connect.
+	Resolve(context.Context, *ResolveRequest) (*ResolveResult, error)
 	// Returns a list of workloads and their current intercept status.
 	// Requires having already called Connect.
 	List(context.Context, *ListRequest) (*WorkloadInfoSnapshot, error)
@@ -265,6 +464,14 @@ type ConnectorServer interface {
 	GetCloudLicense(context.Context, *LicenseRequest) (*LicenseData, error)
 	// SetLogLevel will temporarily set the log-level for the daemon for a duration that is determined by the request.
 	SetLogLevel(context.Context, *manager.LogLevelRequest) (*emptypb.Empty, error)
+	// GatherTraces returns the trace spans that the connector has buffered.
+	GatherTraces(context.Context, *emptypb.Empty) (*common.Traces, error)
+	// ReportTelemetry adds a telemetry report from the CLI to the reports that the
+	// connector sends in batches.
+	ReportTelemetry(context.Context, *TelemetryReport) (*emptypb.Empty, error)
+	// Disconnect ends one session and leaves the other sessions, and the
+	// connector process, running.
+	Disconnect(context.Context, *DisconnectRequest) (*DisconnectResult, error)
 	// Quits (terminates) the connector process.
 	Quit(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
 	mustEmbedUnimplementedConnectorServer()
@@ -280,18 +487,33 @@ func (UnimplementedConnectorServer) Version(context.Context, *emptypb.Empty) (*c
 func (UnimplementedConnectorServer) Connect(context.Context, *ConnectRequest) (*ConnectInfo, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Connect not implemented")
 }
+func (UnimplementedConnectorServer) ConnectStream(*ConnectRequest, Connector_ConnectStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method ConnectStream not implemented")
+}
 func (UnimplementedConnectorServer) Status(context.Context, *ConnectRequest) (*ConnectInfo, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Status not implemented")
 }
 func (UnimplementedConnectorServer) CreateIntercept(context.Context, *CreateInterceptRequest) (*InterceptResult, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CreateIntercept not implemented")
 }
-func (UnimplementedConnectorServer) RemoveIntercept(context.Context, *manager.RemoveInterceptRequest2) (*InterceptResult, error) {
+func (UnimplementedConnectorServer) PlanIntercept(context.Context, *CreateInterceptRequest) (*InterceptPlan, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PlanIntercept not implemented")
+}
+func (UnimplementedConnectorServer) RemoveIntercept(context.Context, *RemoveInterceptRequest) (*InterceptResult, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method RemoveIntercept not implemented")
 }
+func (UnimplementedConnectorServer) HoldIntercept(*HoldInterceptRequest, Connector_HoldInterceptServer) error {
+	return status.Errorf(codes.Unimplemented, "method HoldIntercept not implemented")
+}
 func (UnimplementedConnectorServer) Uninstall(context.Context, *UninstallRequest) (*UninstallResult, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Uninstall not implemented")
 }
+func (UnimplementedConnectorServer) PortForward(*PortForwardRequest, Connector_PortForwardServer) error {
+	return status.Errorf(codes.Unimplemented, "method PortForward not implemented")
+}
+func (UnimplementedConnectorServer) Resolve(context.Context, *ResolveRequest) (*ResolveResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Resolve not implemented")
+}
 func (UnimplementedConnectorServer) List(context.Context, *ListRequest) (*WorkloadInfoSnapshot, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
 }
@@ -316,6 +538,15 @@ func (UnimplementedConnectorServer) GetCloudLicense(context.Context, *LicenseReq
 func (UnimplementedConnectorServer) SetLogLevel(context.Context, *manager.LogLevelRequest) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SetLogLevel not implemented")
 }
+func (UnimplementedConnectorServer) GatherTraces(context.Context, *emptypb.Empty) (*common.Traces, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GatherTraces not implemented")
+}
+func (UnimplementedConnectorServer) ReportTelemetry(context.Context, *TelemetryReport) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportTelemetry not implemented")
+}
+func (UnimplementedConnectorServer) Disconnect(context.Context, *DisconnectRequest) (*DisconnectResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Disconnect not implemented")
+}
 func (UnimplementedConnectorServer) Quit(context.Context, *emptypb.Empty) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Quit not implemented")
 }
@@ -368,6 +599,27 @@ func _Connector_Connect_Handler(srv interface{}, ctx context.Context, dec func(i
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Connector_ConnectStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ConnectRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ConnectorServer).ConnectStream(m, &connectorConnectStreamServer{stream})
+}
+
+type Connector_ConnectStreamServer interface {
+	Send(*ConnectProgress) error
+	grpc.ServerStream
+}
+
+type connectorConnectStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *connectorConnectStreamServer) Send(m *ConnectProgress) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 func _Connector_Status_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(ConnectRequest)
 	if err := dec(in); err != nil {
@@ -404,8 +656,26 @@ func _Connector_CreateIntercept_Handler(srv interface{}, ctx context.Context, de
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Connector_PlanIntercept_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateInterceptRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).PlanIntercept(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/PlanIntercept",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).PlanIntercept(ctx, req.(*CreateInterceptRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Connector_RemoveIntercept_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(manager.RemoveInterceptRequest2)
+	in := new(RemoveInterceptRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
@@ -417,11 +687,32 @@ func _Connector_RemoveIntercept_Handler(srv interface{}, ctx context.Context, de
 		FullMethod: "/telepresence.connector.Connector/RemoveIntercept",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(ConnectorServer).RemoveIntercept(ctx, req.(*manager.RemoveInterceptRequest2))
+		return srv.(ConnectorServer).RemoveIntercept(ctx, req.(*RemoveInterceptRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Connector_HoldIntercept_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(HoldInterceptRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ConnectorServer).HoldIntercept(m, &connectorHoldInterceptServer{stream})
+}
+
+type Connector_HoldInterceptServer interface {
+	Send(*emptypb.Empty) error
+	grpc.ServerStream
+}
+
+type connectorHoldInterceptServer struct {
+	grpc.ServerStream
+}
+
+func (x *connectorHoldInterceptServer) Send(m *emptypb.Empty) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 func _Connector_Uninstall_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(UninstallRequest)
 	if err := dec(in); err != nil {
@@ -440,6 +731,45 @@ func _Connector_Uninstall_Handler(srv interface{}, ctx context.Context, dec func
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Connector_PortForward_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PortForwardRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ConnectorServer).PortForward(m, &connectorPortForwardServer{stream})
+}
+
+type Connector_PortForwardServer interface {
+	Send(*PortForwardSnapshot) error
+	grpc.ServerStream
+}
+
+type connectorPortForwardServer struct {
+	grpc.ServerStream
+}
+
+func (x *connectorPortForwardServer) Send(m *PortForwardSnapshot) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Connector_Resolve_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResolveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).Resolve(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/Resolve",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).Resolve(ctx, req.(*ResolveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Connector_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(ListRequest)
 	if err := dec(in); err != nil {
@@ -587,6 +917,60 @@ func _Connector_SetLogLevel_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Connector_GatherTraces_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).GatherTraces(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/GatherTraces",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).GatherTraces(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Connector_ReportTelemetry_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TelemetryReport)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).ReportTelemetry(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/ReportTelemetry",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).ReportTelemetry(ctx, req.(*TelemetryReport))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Connector_Disconnect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DisconnectRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConnectorServer).Disconnect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Connector/Disconnect",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConnectorServer).Disconnect(ctx, req.(*DisconnectRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Connector_Quit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(emptypb.Empty)
 	if err := dec(in); err != nil {
@@ -628,6 +1012,10 @@ var Connector_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "CreateIntercept",
 			Handler:    _Connector_CreateIntercept_Handler,
 		},
+		{
+			MethodName: "PlanIntercept",
+			Handler:    _Connector_PlanIntercept_Handler,
+		},
 		{
 			MethodName: "RemoveIntercept",
 			Handler:    _Connector_RemoveIntercept_Handler,
@@ -636,6 +1024,10 @@ var Connector_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Uninstall",
 			Handler:    _Connector_Uninstall_Handler,
 		},
+		{
+			MethodName: "Resolve",
+			Handler:    _Connector_Resolve_Handler,
+		},
 		{
 			MethodName: "List",
 			Handler:    _Connector_List_Handler,
@@ -664,12 +1056,39 @@ var Connector_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "SetLogLevel",
 			Handler:    _Connector_SetLogLevel_Handler,
 		},
+		{
+			MethodName: "GatherTraces",
+			Handler:    _Connector_GatherTraces_Handler,
+		},
+		{
+			MethodName: "ReportTelemetry",
+			Handler:    _Connector_ReportTelemetry_Handler,
+		},
+		{
+			MethodName: "Disconnect",
+			Handler:    _Connector_Disconnect_Handler,
+		},
 		{
 			MethodName: "Quit",
 			Handler:    _Connector_Quit_Handler,
 		},
 	},
 	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ConnectStream",
+			Handler:       _Connector_ConnectStream_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "HoldIntercept",
+			Handler:       _Connector_HoldIntercept_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "PortForward",
+			Handler:       _Connector_PortForward_Handler,
+			ServerStreams: true,
+		},
 		{
 			StreamName:    "UserNotifications",
 			Handler:       _Connector_UserNotifications_Handler,
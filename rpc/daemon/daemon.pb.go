@@ -13,6 +13,7 @@ import (
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
 	durationpb "google.golang.org/protobuf/types/known/durationpb"
 	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 	reflect "reflect"
 	sync "sync"
 )
@@ -24,31 +25,1188 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type SubnetConflict_Severity int32
+
+const (
+	// The overlap makes some addresses unreachable but the subnet is routed
+	SubnetConflict_WARNING SubnetConflict_Severity = 0
+	// The subnet collides with the route of another VPN and is not routed
+	SubnetConflict_ERROR SubnetConflict_Severity = 1
+)
+
+// Enum value maps for SubnetConflict_Severity.
+var (
+	SubnetConflict_Severity_name = map[int32]string{
+		0: "WARNING",
+		1: "ERROR",
+	}
+	SubnetConflict_Severity_value = map[string]int32{
+		"WARNING": 0,
+		"ERROR":   1,
+	}
+)
+
+func (x SubnetConflict_Severity) Enum() *SubnetConflict_Severity {
+	p := new(SubnetConflict_Severity)
+	*p = x
+	return p
+}
+
+func (x SubnetConflict_Severity) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (SubnetConflict_Severity) Descriptor() protoreflect.EnumDescriptor {
+	return file_rpc_daemon_daemon_proto_enumTypes[0].Descriptor()
+}
+
+func (SubnetConflict_Severity) Type() protoreflect.EnumType {
+	return &file_rpc_daemon_daemon_proto_enumTypes[0]
+}
+
+func (x SubnetConflict_Severity) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use SubnetConflict_Severity.Descriptor instead.
+func (SubnetConflict_Severity) EnumDescriptor() ([]byte, []int) {
+	return file_rpc_daemon_daemon_proto_rawDescGZIP(), []int{3, 0}
+}
+
+type RoutedSubnet_Source int32
+
+const (
+	RoutedSubnet_UNSPECIFIED RoutedSubnet_Source = 0
+	// The subnet of the cluster's services
+	RoutedSubnet_SERVICES RoutedSubnet_Source = 1
+	// A subnet of the cluster's pods
+	RoutedSubnet_PODS RoutedSubnet_Source = 2
+	// A subnet that the user added using the alsoProxy setting
+	RoutedSubnet_ALSO_PROXY RoutedSubnet_Source = 3
+)
+
+// Enum value maps for RoutedSubnet_Source.
+var (
+	RoutedSubnet_Source_name = map[int32]string{
+		0: "UNSPECIFIED",
+		1: "SERVICES",
+		2: "PODS",
+		3: "ALSO_PROXY",
+	}
+	RoutedSubnet_Source_value = map[string]int32{
+		"UNSPECIFIED": 0,
+		"SERVICES":    1,
+		"PODS":        2,
+		"ALSO_PROXY":  3,
+	}
+)
+
+func (x RoutedSubnet_Source) Enum() *RoutedSubnet_Source {
+	p := new(RoutedSubnet_Source)
+	*p = x
+	return p
+}
+
+func (x RoutedSubnet_Source) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (RoutedSubnet_Source) Descriptor() protoreflect.EnumDescriptor {
+	return file_rpc_daemon_daemon_proto_enumTypes[1].Descriptor()
+}
+
+func (RoutedSubnet_Source) Type() protoreflect.EnumType {
+	return &file_rpc_daemon_daemon_proto_enumTypes[1]
+}
+
+func (x RoutedSubnet_Source) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use RoutedSubnet_Source.Descriptor instead.
+func (RoutedSubnet_Source) EnumDescriptor() ([]byte, []int) {
+	return file_rpc_daemon_daemon_proto_rawDescGZIP(), []int{4, 0}
+}
+
 type DaemonStatus struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	OutboundConfig *OutboundInfo `protobuf:"bytes,4,opt,name=outbound_config,json=outboundConfig,proto3" json:"outbound_config,omitempty"`
+	OutboundConfig *OutboundInfo `protobuf:"bytes,4,opt,name=outbound_config,json=outboundConfig,proto3" json:"outbound_config,omitempty"`
+	// Name of the TUN interface that the cluster subnets are routed to
+	TunName string `protobuf:"bytes,5,opt,name=tun_name,json=tunName,proto3" json:"tun_name,omitempty"`
+	// The subnets that are currently routed to the TUN interface
+	RoutedSubnets []*RoutedSubnet `protobuf:"bytes,6,rep,name=routed_subnets,json=routedSubnets,proto3" json:"routed_subnets,omitempty"`
+	// Address of the local DNS server, if one is used
+	DnsListener string `protobuf:"bytes,7,opt,name=dns_listener,json=dnsListener,proto3" json:"dns_listener,omitempty"`
+	// The DNS search paths currently in use
+	SearchPaths []string `protobuf:"bytes,8,rep,name=search_paths,json=searchPaths,proto3" json:"search_paths,omitempty"`
+	// Conflicts between the subnets that are routed to the cluster and the
+	// routes of the local host
+	SubnetConflicts []*SubnetConflict `protobuf:"bytes,9,rep,name=subnet_conflicts,json=subnetConflicts,proto3" json:"subnet_conflicts,omitempty"`
+	// Time of the last packet that was routed to the cluster. Not set when no
+	// packet has been routed yet.
+	LastActivity *timestamppb.Timestamp `protobuf:"bytes,10,opt,name=last_activity,json=lastActivity,proto3" json:"last_activity,omitempty"`
+	// True when the daemon was started by the privileged helper rather than
+	// with sudo
+	PrivilegedHelper bool `protobuf:"varint,11,opt,name=privileged_helper,json=privilegedHelper,proto3" json:"privileged_helper,omitempty"`
+	// True when the daemon runs as a Windows service
+	WindowsService bool `protobuf:"varint,12,opt,name=windows_service,json=windowsService,proto3" json:"windows_service,omitempty"`
+	// MTU of the TUN interface
+	TunMtu int32 `protobuf:"varint,13,opt,name=tun_mtu,json=tunMtu,proto3" json:"tun_mtu,omitempty"`
+	// Address of the DNS server that the local DNS server forwards the queries
+	// that aren't resolved in the cluster to, if it does so itself
+	DnsForwarder string `protobuf:"bytes,14,opt,name=dns_forwarder,json=dnsForwarder,proto3" json:"dns_forwarder,omitempty"`
+	// Name of the interface of the default route when its MTU, less the overhead
+	// of the tunnel, limited the default MTU of the TUN interface
+	TunMtuLimitedBy string `protobuf:"bytes,15,opt,name=tun_mtu_limited_by,json=tunMtuLimitedBy,proto3" json:"tun_mtu_limited_by,omitempty"`
+	// Cluster subnets of a session that overlap the cluster subnets of an
+	// earlier session, and therefore aren't routed
+	SessionConflicts []*SessionConflict `protobuf:"bytes,16,rep,name=session_conflicts,json=sessionConflicts,proto3" json:"session_conflicts,omitempty"`
+	// The mode in which the local DNS server is hooked into the resolver of
+	// the host, e.g. "resolver files" or "systemd-resolved"
+	DnsMode string `protobuf:"bytes,17,opt,name=dns_mode,json=dnsMode,proto3" json:"dns_mode,omitempty"`
+	// The number of host routes of the IPs of the services, and their pods, in
+	// the mapped namespaces of the sessions whose outbound traffic policy is
+	// "mappedNamespaces"
+	ManagedRoutes int32 `protobuf:"varint,18,opt,name=managed_routes,json=managedRoutes,proto3" json:"managed_routes,omitempty"`
+	// The routes of cluster subnets via the docker network of a local cluster,
+	// see OutboundInfo.docker_network
+	GatewayRoutes []*GatewayRoute `protobuf:"bytes,19,rep,name=gateway_routes,json=gatewayRoutes,proto3" json:"gateway_routes,omitempty"`
+	// The connections that are tracked by the daemon, see ConnectionStats
+	Connections *ConnectionStats `protobuf:"bytes,20,opt,name=connections,proto3" json:"connections,omitempty"`
+}
+
+func (x *DaemonStatus) Reset() {
+	*x = DaemonStatus{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_daemon_daemon_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DaemonStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DaemonStatus) ProtoMessage() {}
+
+func (x *DaemonStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_daemon_daemon_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DaemonStatus.ProtoReflect.Descriptor instead.
+func (*DaemonStatus) Descriptor() ([]byte, []int) {
+	return file_rpc_daemon_daemon_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *DaemonStatus) GetOutboundConfig() *OutboundInfo {
+	if x != nil {
+		return x.OutboundConfig
+	}
+	return nil
+}
+
+func (x *DaemonStatus) GetTunName() string {
+	if x != nil {
+		return x.TunName
+	}
+	return ""
+}
+
+func (x *DaemonStatus) GetRoutedSubnets() []*RoutedSubnet {
+	if x != nil {
+		return x.RoutedSubnets
+	}
+	return nil
+}
+
+func (x *DaemonStatus) GetDnsListener() string {
+	if x != nil {
+		return x.DnsListener
+	}
+	return ""
+}
+
+func (x *DaemonStatus) GetSearchPaths() []string {
+	if x != nil {
+		return x.SearchPaths
+	}
+	return nil
+}
+
+func (x *DaemonStatus) GetSubnetConflicts() []*SubnetConflict {
+	if x != nil {
+		return x.SubnetConflicts
+	}
+	return nil
+}
+
+func (x *DaemonStatus) GetLastActivity() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastActivity
+	}
+	return nil
+}
+
+func (x *DaemonStatus) GetPrivilegedHelper() bool {
+	if x != nil {
+		return x.PrivilegedHelper
+	}
+	return false
+}
+
+func (x *DaemonStatus) GetWindowsService() bool {
+	if x != nil {
+		return x.WindowsService
+	}
+	return false
+}
+
+func (x *DaemonStatus) GetTunMtu() int32 {
+	if x != nil {
+		return x.TunMtu
+	}
+	return 0
+}
+
+func (x *DaemonStatus) GetDnsForwarder() string {
+	if x != nil {
+		return x.DnsForwarder
+	}
+	return ""
+}
+
+func (x *DaemonStatus) GetTunMtuLimitedBy() string {
+	if x != nil {
+		return x.TunMtuLimitedBy
+	}
+	return ""
+}
+
+func (x *DaemonStatus) GetSessionConflicts() []*SessionConflict {
+	if x != nil {
+		return x.SessionConflicts
+	}
+	return nil
+}
+
+func (x *DaemonStatus) GetDnsMode() string {
+	if x != nil {
+		return x.DnsMode
+	}
+	return ""
+}
+
+func (x *DaemonStatus) GetManagedRoutes() int32 {
+	if x != nil {
+		return x.ManagedRoutes
+	}
+	return 0
+}
+
+func (x *DaemonStatus) GetGatewayRoutes() []*GatewayRoute {
+	if x != nil {
+		return x.GatewayRoutes
+	}
+	return nil
+}
+
+func (x *DaemonStatus) GetConnections() *ConnectionStats {
+	if x != nil {
+		return x.Connections
+	}
+	return nil
+}
+
+// ConnectionStats describes the table of the TCP connections and UDP flows
+// that the daemon routes to the cluster, and how it was kept within the
+// limits of the connections config.
+type ConnectionStats struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Number of connections that are currently tracked
+	Tracked int32 `protobuf:"varint,1,opt,name=tracked,proto3" json:"tracked,omitempty"`
+	// Maximum number of tracked connections, zero when there's no maximum
+	MaxTracked int32 `protobuf:"varint,2,opt,name=max_tracked,json=maxTracked,proto3" json:"max_tracked,omitempty"`
+	// Connections that were closed because they were unused for longer than
+	// the idle timeout of their protocol
+	IdleEvictions uint64 `protobuf:"varint,3,opt,name=idle_evictions,json=idleEvictions,proto3" json:"idle_evictions,omitempty"`
+	// Connections that were closed to make room for new ones when the
+	// maximum was reached
+	LimitEvictions uint64 `protobuf:"varint,4,opt,name=limit_evictions,json=limitEvictions,proto3" json:"limit_evictions,omitempty"`
+	// New connections that were refused because the maximum was reached
+	Refused uint64 `protobuf:"varint,5,opt,name=refused,proto3" json:"refused,omitempty"`
+}
+
+func (x *ConnectionStats) Reset() {
+	*x = ConnectionStats{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_daemon_daemon_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ConnectionStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ConnectionStats) ProtoMessage() {}
+
+func (x *ConnectionStats) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_daemon_daemon_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ConnectionStats.ProtoReflect.Descriptor instead.
+func (*ConnectionStats) Descriptor() ([]byte, []int) {
+	return file_rpc_daemon_daemon_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ConnectionStats) GetTracked() int32 {
+	if x != nil {
+		return x.Tracked
+	}
+	return 0
+}
+
+func (x *ConnectionStats) GetMaxTracked() int32 {
+	if x != nil {
+		return x.MaxTracked
+	}
+	return 0
+}
+
+func (x *ConnectionStats) GetIdleEvictions() uint64 {
+	if x != nil {
+		return x.IdleEvictions
+	}
+	return 0
+}
+
+func (x *ConnectionStats) GetLimitEvictions() uint64 {
+	if x != nil {
+		return x.LimitEvictions
+	}
+	return 0
+}
+
+func (x *ConnectionStats) GetRefused() uint64 {
+	if x != nil {
+		return x.Refused
+	}
+	return 0
+}
+
+// SessionConflict is an overlap between a cluster subnet of one session and a
+// cluster subnet of another session that was established earlier.
+type SessionConflict struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	SessionName      string         `protobuf:"bytes,1,opt,name=session_name,json=sessionName,proto3" json:"session_name,omitempty"`
+	Subnet           *manager.IPNet `protobuf:"bytes,2,opt,name=subnet,proto3" json:"subnet,omitempty"`
+	OtherSessionName string         `protobuf:"bytes,3,opt,name=other_session_name,json=otherSessionName,proto3" json:"other_session_name,omitempty"`
+	OtherSubnet      *manager.IPNet `protobuf:"bytes,4,opt,name=other_subnet,json=otherSubnet,proto3" json:"other_subnet,omitempty"`
+}
+
+func (x *SessionConflict) Reset() {
+	*x = SessionConflict{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_daemon_daemon_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SessionConflict) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SessionConflict) ProtoMessage() {}
+
+func (x *SessionConflict) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_daemon_daemon_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SessionConflict.ProtoReflect.Descriptor instead.
+func (*SessionConflict) Descriptor() ([]byte, []int) {
+	return file_rpc_daemon_daemon_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SessionConflict) GetSessionName() string {
+	if x != nil {
+		return x.SessionName
+	}
+	return ""
+}
+
+func (x *SessionConflict) GetSubnet() *manager.IPNet {
+	if x != nil {
+		return x.Subnet
+	}
+	return nil
+}
+
+func (x *SessionConflict) GetOtherSessionName() string {
+	if x != nil {
+		return x.OtherSessionName
+	}
+	return ""
+}
+
+func (x *SessionConflict) GetOtherSubnet() *manager.IPNet {
+	if x != nil {
+		return x.OtherSubnet
+	}
+	return nil
+}
+
+// SubnetConflict is an overlap between a subnet that is routed to the cluster
+// and a route of the local host.
+type SubnetConflict struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Subnet *manager.IPNet `protobuf:"bytes,1,opt,name=subnet,proto3" json:"subnet,omitempty"`
+	Route  *manager.IPNet `protobuf:"bytes,2,opt,name=route,proto3" json:"route,omitempty"`
+	// The interface of the local route
+	Interface string                  `protobuf:"bytes,3,opt,name=interface,proto3" json:"interface,omitempty"`
+	Severity  SubnetConflict_Severity `protobuf:"varint,4,opt,name=severity,proto3,enum=telepresence.daemon.SubnetConflict_Severity" json:"severity,omitempty"`
+}
+
+func (x *SubnetConflict) Reset() {
+	*x = SubnetConflict{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_daemon_daemon_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SubnetConflict) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubnetConflict) ProtoMessage() {}
+
+func (x *SubnetConflict) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_daemon_daemon_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubnetConflict.ProtoReflect.Descriptor instead.
+func (*SubnetConflict) Descriptor() ([]byte, []int) {
+	return file_rpc_daemon_daemon_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SubnetConflict) GetSubnet() *manager.IPNet {
+	if x != nil {
+		return x.Subnet
+	}
+	return nil
+}
+
+func (x *SubnetConflict) GetRoute() *manager.IPNet {
+	if x != nil {
+		return x.Route
+	}
+	return nil
+}
+
+func (x *SubnetConflict) GetInterface() string {
+	if x != nil {
+		return x.Interface
+	}
+	return ""
+}
+
+func (x *SubnetConflict) GetSeverity() SubnetConflict_Severity {
+	if x != nil {
+		return x.Severity
+	}
+	return SubnetConflict_WARNING
+}
+
+// RoutedSubnet is a subnet that is routed to the TUN interface, along with
+// the reason why it is routed.
+type RoutedSubnet struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Subnet *manager.IPNet      `protobuf:"bytes,1,opt,name=subnet,proto3" json:"subnet,omitempty"`
+	Source RoutedSubnet_Source `protobuf:"varint,2,opt,name=source,proto3,enum=telepresence.daemon.RoutedSubnet_Source" json:"source,omitempty"`
+	// The session that the subnet is routed to
+	SessionName string `protobuf:"bytes,3,opt,name=session_name,json=sessionName,proto3" json:"session_name,omitempty"`
+}
+
+func (x *RoutedSubnet) Reset() {
+	*x = RoutedSubnet{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_daemon_daemon_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RoutedSubnet) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RoutedSubnet) ProtoMessage() {}
+
+func (x *RoutedSubnet) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_daemon_daemon_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RoutedSubnet.ProtoReflect.Descriptor instead.
+func (*RoutedSubnet) Descriptor() ([]byte, []int) {
+	return file_rpc_daemon_daemon_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *RoutedSubnet) GetSubnet() *manager.IPNet {
+	if x != nil {
+		return x.Subnet
+	}
+	return nil
+}
+
+func (x *RoutedSubnet) GetSource() RoutedSubnet_Source {
+	if x != nil {
+		return x.Source
+	}
+	return RoutedSubnet_UNSPECIFIED
+}
+
+func (x *RoutedSubnet) GetSessionName() string {
+	if x != nil {
+		return x.SessionName
+	}
+	return ""
+}
+
+type Paths struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Paths      []string `protobuf:"bytes,1,rep,name=paths,proto3" json:"paths,omitempty"`
+	Namespaces []string `protobuf:"bytes,2,rep,name=namespaces,proto3" json:"namespaces,omitempty"`
+	// The session that the paths belong to
+	SessionName string `protobuf:"bytes,3,opt,name=session_name,json=sessionName,proto3" json:"session_name,omitempty"`
+}
+
+func (x *Paths) Reset() {
+	*x = Paths{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_daemon_daemon_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Paths) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Paths) ProtoMessage() {}
+
+func (x *Paths) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_daemon_daemon_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Paths.ProtoReflect.Descriptor instead.
+func (*Paths) Descriptor() ([]byte, []int) {
+	return file_rpc_daemon_daemon_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Paths) GetPaths() []string {
+	if x != nil {
+		return x.Paths
+	}
+	return nil
+}
+
+func (x *Paths) GetNamespaces() []string {
+	if x != nil {
+		return x.Namespaces
+	}
+	return nil
+}
+
+func (x *Paths) GetSessionName() string {
+	if x != nil {
+		return x.SessionName
+	}
+	return ""
+}
+
+// HeadlessService is a service without a cluster IP, as described by its EndpointSlices.
+type HeadlessService struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name      string                  `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Namespace string                  `protobuf:"bytes,2,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	Ports     []*HeadlessService_Port `protobuf:"bytes,3,rep,name=ports,proto3" json:"ports,omitempty"`
+	// The endpoints that are ready
+	Endpoints []*HeadlessService_Endpoint `protobuf:"bytes,4,rep,name=endpoints,proto3" json:"endpoints,omitempty"`
+}
+
+func (x *HeadlessService) Reset() {
+	*x = HeadlessService{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_daemon_daemon_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *HeadlessService) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HeadlessService) ProtoMessage() {}
+
+func (x *HeadlessService) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_daemon_daemon_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HeadlessService.ProtoReflect.Descriptor instead.
+func (*HeadlessService) Descriptor() ([]byte, []int) {
+	return file_rpc_daemon_daemon_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *HeadlessService) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *HeadlessService) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *HeadlessService) GetPorts() []*HeadlessService_Port {
+	if x != nil {
+		return x.Ports
+	}
+	return nil
+}
+
+func (x *HeadlessService) GetEndpoints() []*HeadlessService_Endpoint {
+	if x != nil {
+		return x.Endpoints
+	}
+	return nil
+}
+
+type HeadlessServices struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Services []*HeadlessService `protobuf:"bytes,1,rep,name=services,proto3" json:"services,omitempty"`
+	// The session that the services belong to
+	SessionName string `protobuf:"bytes,2,opt,name=session_name,json=sessionName,proto3" json:"session_name,omitempty"`
+}
+
+func (x *HeadlessServices) Reset() {
+	*x = HeadlessServices{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_daemon_daemon_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *HeadlessServices) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HeadlessServices) ProtoMessage() {}
+
+func (x *HeadlessServices) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_daemon_daemon_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HeadlessServices.ProtoReflect.Descriptor instead.
+func (*HeadlessServices) Descriptor() ([]byte, []int) {
+	return file_rpc_daemon_daemon_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *HeadlessServices) GetServices() []*HeadlessService {
+	if x != nil {
+		return x.Services
+	}
+	return nil
+}
+
+func (x *HeadlessServices) GetSessionName() string {
+	if x != nil {
+		return x.SessionName
+	}
+	return ""
+}
+
+// RoutedIPs are the IPs of the services, and of their pods, in the mapped
+// namespaces of a session.
+type RoutedIPs struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Ips [][]byte `protobuf:"bytes,1,rep,name=ips,proto3" json:"ips,omitempty"`
+	// The session that the IPs belong to
+	SessionName string `protobuf:"bytes,2,opt,name=session_name,json=sessionName,proto3" json:"session_name,omitempty"`
+}
+
+func (x *RoutedIPs) Reset() {
+	*x = RoutedIPs{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_daemon_daemon_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RoutedIPs) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RoutedIPs) ProtoMessage() {}
+
+func (x *RoutedIPs) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_daemon_daemon_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RoutedIPs.ProtoReflect.Descriptor instead.
+func (*RoutedIPs) Descriptor() ([]byte, []int) {
+	return file_rpc_daemon_daemon_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *RoutedIPs) GetIps() [][]byte {
+	if x != nil {
+		return x.Ips
+	}
+	return nil
+}
+
+func (x *RoutedIPs) GetSessionName() string {
+	if x != nil {
+		return x.SessionName
+	}
+	return ""
+}
+
+// DNS configuration for the local DNS resolver
+type DNSConfig struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// local_ip is the address of the local DNS server. Only used by Linux systems that have no
+	// systemd-resolved configured. Defaults to the first line of /etc/resolv.conf
+	LocalIp []byte `protobuf:"bytes,1,opt,name=local_ip,json=localIp,proto3" json:"local_ip,omitempty"`
+	// remote_ip is the address of the kube-dns.kube-system, dns-default.openshift-dns, or similar service,
+	RemoteIp []byte `protobuf:"bytes,2,opt,name=remote_ip,json=remoteIp,proto3" json:"remote_ip,omitempty"`
+	// Suffixes to exclude
+	ExcludeSuffixes []string `protobuf:"bytes,3,rep,name=exclude_suffixes,json=excludeSuffixes,proto3" json:"exclude_suffixes,omitempty"`
+	// Suffixes to include. Has higher prio than the excludes
+	IncludeSuffixes []string `protobuf:"bytes,4,rep,name=include_suffixes,json=includeSuffixes,proto3" json:"include_suffixes,omitempty"`
+	// The maximum time wait for a cluster side host lookup.
+	LookupTimeout *durationpb.Duration `protobuf:"bytes,6,opt,name=lookup_timeout,json=lookupTimeout,proto3" json:"lookup_timeout,omitempty"`
+	// The time that a failed cluster side host lookup is cached.
+	NegativeCacheTtl *durationpb.Duration `protobuf:"bytes,7,opt,name=negative_cache_ttl,json=negativeCacheTtl,proto3" json:"negative_cache_ttl,omitempty"`
+	// The maximum time that a successful cluster side host lookup is cached.
+	MaxTtl *durationpb.Duration `protobuf:"bytes,8,opt,name=max_ttl,json=maxTtl,proto3" json:"max_ttl,omitempty"`
+}
+
+func (x *DNSConfig) Reset() {
+	*x = DNSConfig{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_daemon_daemon_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DNSConfig) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DNSConfig) ProtoMessage() {}
+
+func (x *DNSConfig) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_daemon_daemon_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DNSConfig.ProtoReflect.Descriptor instead.
+func (*DNSConfig) Descriptor() ([]byte, []int) {
+	return file_rpc_daemon_daemon_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *DNSConfig) GetLocalIp() []byte {
+	if x != nil {
+		return x.LocalIp
+	}
+	return nil
+}
+
+func (x *DNSConfig) GetRemoteIp() []byte {
+	if x != nil {
+		return x.RemoteIp
+	}
+	return nil
+}
+
+func (x *DNSConfig) GetExcludeSuffixes() []string {
+	if x != nil {
+		return x.ExcludeSuffixes
+	}
+	return nil
+}
+
+func (x *DNSConfig) GetIncludeSuffixes() []string {
+	if x != nil {
+		return x.IncludeSuffixes
+	}
+	return nil
+}
+
+func (x *DNSConfig) GetLookupTimeout() *durationpb.Duration {
+	if x != nil {
+		return x.LookupTimeout
+	}
+	return nil
+}
+
+func (x *DNSConfig) GetNegativeCacheTtl() *durationpb.Duration {
+	if x != nil {
+		return x.NegativeCacheTtl
+	}
+	return nil
+}
+
+func (x *DNSConfig) GetMaxTtl() *durationpb.Duration {
+	if x != nil {
+		return x.MaxTtl
+	}
+	return nil
+}
+
+// OutboundInfo contains all information that the root daemon needs in order to
+// establish outbound traffic to the cluster.
+type OutboundInfo struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// session makes it possible for the root daemon to identify itself as the
+	// same client as the user daemon.
+	Session *manager.SessionInfo `protobuf:"bytes,2,opt,name=session,proto3" json:"session,omitempty"`
+	// DNS configuration
+	Dns *DNSConfig `protobuf:"bytes,3,opt,name=dns,proto3" json:"dns,omitempty"`
+	// also_proxy are user-added subnets.
+	AlsoProxySubnets []*manager.IPNet `protobuf:"bytes,5,rep,name=also_proxy_subnets,json=alsoProxySubnets,proto3" json:"also_proxy_subnets,omitempty"`
+	// never_proxy are subnets that are never routed to the cluster, even when they
+	// overlap with a cluster subnet or an also_proxy subnet.
+	NeverProxySubnets []*manager.IPNet `protobuf:"bytes,6,rep,name=never_proxy_subnets,json=neverProxySubnets,proto3" json:"never_proxy_subnets,omitempty"`
+	// allow_conflicting_subnets are subnets that are routed to the cluster even
+	// when they collide with the routes of another VPN.
+	AllowConflictingSubnets []*manager.IPNet `protobuf:"bytes,7,rep,name=allow_conflicting_subnets,json=allowConflictingSubnets,proto3" json:"allow_conflicting_subnets,omitempty"`
+	// mtu is the MTU of the TUN device. Zero means that the root daemon uses its
+	// default, limited by the MTU of the interface of the default route.
+	Mtu int32 `protobuf:"varint,8,opt,name=mtu,proto3" json:"mtu,omitempty"`
+	// session_name identifies the session of the user daemon that the info
+	// belongs to. The subnets of sessions with different names are routed side
+	// by side, and info with a known name replaces a broken session.
+	SessionName string `protobuf:"bytes,9,opt,name=session_name,json=sessionName,proto3" json:"session_name,omitempty"`
+	// outbound_traffic_policy is "all", which routes the service and pod
+	// subnets of the cluster, or "mappedNamespaces", which routes only the IPs
+	// that are set with SetRoutedIPs. Empty means "all".
+	OutboundTrafficPolicy string `protobuf:"bytes,10,opt,name=outbound_traffic_policy,json=outboundTrafficPolicy,proto3" json:"outbound_traffic_policy,omitempty"`
+	// docker_network is set when the cluster runs in containers on a docker
+	// network of the host, e.g. a kind, k3d, or minikube cluster. The cluster
+	// subnets of the session are then routed via its nodes instead of to the
+	// TUN device.
+	DockerNetwork *DockerNetworkRoutes `protobuf:"bytes,11,opt,name=docker_network,json=dockerNetwork,proto3" json:"docker_network,omitempty"`
+}
+
+func (x *OutboundInfo) Reset() {
+	*x = OutboundInfo{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_daemon_daemon_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *OutboundInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OutboundInfo) ProtoMessage() {}
+
+func (x *OutboundInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_daemon_daemon_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OutboundInfo.ProtoReflect.Descriptor instead.
+func (*OutboundInfo) Descriptor() ([]byte, []int) {
+	return file_rpc_daemon_daemon_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *OutboundInfo) GetSession() *manager.SessionInfo {
+	if x != nil {
+		return x.Session
+	}
+	return nil
+}
+
+func (x *OutboundInfo) GetDns() *DNSConfig {
+	if x != nil {
+		return x.Dns
+	}
+	return nil
+}
+
+func (x *OutboundInfo) GetAlsoProxySubnets() []*manager.IPNet {
+	if x != nil {
+		return x.AlsoProxySubnets
+	}
+	return nil
+}
+
+func (x *OutboundInfo) GetNeverProxySubnets() []*manager.IPNet {
+	if x != nil {
+		return x.NeverProxySubnets
+	}
+	return nil
+}
+
+func (x *OutboundInfo) GetAllowConflictingSubnets() []*manager.IPNet {
+	if x != nil {
+		return x.AllowConflictingSubnets
+	}
+	return nil
+}
+
+func (x *OutboundInfo) GetMtu() int32 {
+	if x != nil {
+		return x.Mtu
+	}
+	return 0
+}
+
+func (x *OutboundInfo) GetSessionName() string {
+	if x != nil {
+		return x.SessionName
+	}
+	return ""
+}
+
+func (x *OutboundInfo) GetOutboundTrafficPolicy() string {
+	if x != nil {
+		return x.OutboundTrafficPolicy
+	}
+	return ""
+}
+
+func (x *OutboundInfo) GetDockerNetwork() *DockerNetworkRoutes {
+	if x != nil {
+		return x.DockerNetwork
+	}
+	return nil
+}
+
+// DockerNetworkRoutes are the routes that lead the cluster subnets of a
+// session through the docker network that the nodes of a local cluster are
+// attached to.
+type DockerNetworkRoutes struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// gateway is the IP of the node that the cluster subnets are routed via
+	Gateway []byte `protobuf:"bytes,1,opt,name=gateway,proto3" json:"gateway,omitempty"`
+	// node_routes route the pod subnet of each node via the node itself, so
+	// that traffic to a pod takes no detour through the gateway
+	NodeRoutes []*GatewayRoute `protobuf:"bytes,2,rep,name=node_routes,json=nodeRoutes,proto3" json:"node_routes,omitempty"`
+}
+
+func (x *DockerNetworkRoutes) Reset() {
+	*x = DockerNetworkRoutes{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_daemon_daemon_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DockerNetworkRoutes) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DockerNetworkRoutes) ProtoMessage() {}
+
+func (x *DockerNetworkRoutes) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_daemon_daemon_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DockerNetworkRoutes.ProtoReflect.Descriptor instead.
+func (*DockerNetworkRoutes) Descriptor() ([]byte, []int) {
+	return file_rpc_daemon_daemon_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *DockerNetworkRoutes) GetGateway() []byte {
+	if x != nil {
+		return x.Gateway
+	}
+	return nil
+}
+
+func (x *DockerNetworkRoutes) GetNodeRoutes() []*GatewayRoute {
+	if x != nil {
+		return x.NodeRoutes
+	}
+	return nil
+}
+
+// GatewayRoute is a route of a subnet via a gateway.
+type GatewayRoute struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Subnet  *manager.IPNet `protobuf:"bytes,1,opt,name=subnet,proto3" json:"subnet,omitempty"`
+	Gateway []byte         `protobuf:"bytes,2,opt,name=gateway,proto3" json:"gateway,omitempty"`
 }
 
-func (x *DaemonStatus) Reset() {
-	*x = DaemonStatus{}
+func (x *GatewayRoute) Reset() {
+	*x = GatewayRoute{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_rpc_daemon_daemon_proto_msgTypes[0]
+		mi := &file_rpc_daemon_daemon_proto_msgTypes[12]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *DaemonStatus) String() string {
+func (x *GatewayRoute) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*DaemonStatus) ProtoMessage() {}
+func (*GatewayRoute) ProtoMessage() {}
 
-func (x *DaemonStatus) ProtoReflect() protoreflect.Message {
-	mi := &file_rpc_daemon_daemon_proto_msgTypes[0]
+func (x *GatewayRoute) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_daemon_daemon_proto_msgTypes[12]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -59,44 +1217,50 @@ func (x *DaemonStatus) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use DaemonStatus.ProtoReflect.Descriptor instead.
-func (*DaemonStatus) Descriptor() ([]byte, []int) {
-	return file_rpc_daemon_daemon_proto_rawDescGZIP(), []int{0}
+// Deprecated: Use GatewayRoute.ProtoReflect.Descriptor instead.
+func (*GatewayRoute) Descriptor() ([]byte, []int) {
+	return file_rpc_daemon_daemon_proto_rawDescGZIP(), []int{12}
 }
 
-func (x *DaemonStatus) GetOutboundConfig() *OutboundInfo {
+func (x *GatewayRoute) GetSubnet() *manager.IPNet {
 	if x != nil {
-		return x.OutboundConfig
+		return x.Subnet
 	}
 	return nil
 }
 
-type Paths struct {
+func (x *GatewayRoute) GetGateway() []byte {
+	if x != nil {
+		return x.Gateway
+	}
+	return nil
+}
+
+type SessionName struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Paths      []string `protobuf:"bytes,1,rep,name=paths,proto3" json:"paths,omitempty"`
-	Namespaces []string `protobuf:"bytes,2,rep,name=namespaces,proto3" json:"namespaces,omitempty"`
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
 }
 
-func (x *Paths) Reset() {
-	*x = Paths{}
+func (x *SessionName) Reset() {
+	*x = SessionName{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_rpc_daemon_daemon_proto_msgTypes[1]
+		mi := &file_rpc_daemon_daemon_proto_msgTypes[13]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *Paths) String() string {
+func (x *SessionName) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*Paths) ProtoMessage() {}
+func (*SessionName) ProtoMessage() {}
 
-func (x *Paths) ProtoReflect() protoreflect.Message {
-	mi := &file_rpc_daemon_daemon_proto_msgTypes[1]
+func (x *SessionName) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_daemon_daemon_proto_msgTypes[13]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -107,61 +1271,46 @@ func (x *Paths) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use Paths.ProtoReflect.Descriptor instead.
-func (*Paths) Descriptor() ([]byte, []int) {
-	return file_rpc_daemon_daemon_proto_rawDescGZIP(), []int{1}
-}
-
-func (x *Paths) GetPaths() []string {
-	if x != nil {
-		return x.Paths
-	}
-	return nil
+// Deprecated: Use SessionName.ProtoReflect.Descriptor instead.
+func (*SessionName) Descriptor() ([]byte, []int) {
+	return file_rpc_daemon_daemon_proto_rawDescGZIP(), []int{13}
 }
 
-func (x *Paths) GetNamespaces() []string {
+func (x *SessionName) GetName() string {
 	if x != nil {
-		return x.Namespaces
+		return x.Name
 	}
-	return nil
+	return ""
 }
 
-// DNS configuration for the local DNS resolver
-type DNSConfig struct {
+type HeadlessService_Port struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	// local_ip is the address of the local DNS server. Only used by Linux systems that have no
-	// systemd-resolved configured. Defaults to the first line of /etc/resolv.conf
-	LocalIp []byte `protobuf:"bytes,1,opt,name=local_ip,json=localIp,proto3" json:"local_ip,omitempty"`
-	// remote_ip is the address of the kube-dns.kube-system, dns-default.openshift-dns, or similar service,
-	RemoteIp []byte `protobuf:"bytes,2,opt,name=remote_ip,json=remoteIp,proto3" json:"remote_ip,omitempty"`
-	// Suffixes to exclude
-	ExcludeSuffixes []string `protobuf:"bytes,3,rep,name=exclude_suffixes,json=excludeSuffixes,proto3" json:"exclude_suffixes,omitempty"`
-	// Suffixes to include. Has higher prio than the excludes
-	IncludeSuffixes []string `protobuf:"bytes,4,rep,name=include_suffixes,json=includeSuffixes,proto3" json:"include_suffixes,omitempty"`
-	// The maximum time wait for a cluster side host lookup.
-	LookupTimeout *durationpb.Duration `protobuf:"bytes,6,opt,name=lookup_timeout,json=lookupTimeout,proto3" json:"lookup_timeout,omitempty"`
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// TCP, UDP, or SCTP
+	Protocol string `protobuf:"bytes,2,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	Port     int32  `protobuf:"varint,3,opt,name=port,proto3" json:"port,omitempty"`
 }
 
-func (x *DNSConfig) Reset() {
-	*x = DNSConfig{}
+func (x *HeadlessService_Port) Reset() {
+	*x = HeadlessService_Port{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_rpc_daemon_daemon_proto_msgTypes[2]
+		mi := &file_rpc_daemon_daemon_proto_msgTypes[14]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *DNSConfig) String() string {
+func (x *HeadlessService_Port) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*DNSConfig) ProtoMessage() {}
+func (*HeadlessService_Port) ProtoMessage() {}
 
-func (x *DNSConfig) ProtoReflect() protoreflect.Message {
-	mi := &file_rpc_daemon_daemon_proto_msgTypes[2]
+func (x *HeadlessService_Port) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_daemon_daemon_proto_msgTypes[14]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -172,79 +1321,60 @@ func (x *DNSConfig) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use DNSConfig.ProtoReflect.Descriptor instead.
-func (*DNSConfig) Descriptor() ([]byte, []int) {
-	return file_rpc_daemon_daemon_proto_rawDescGZIP(), []int{2}
-}
-
-func (x *DNSConfig) GetLocalIp() []byte {
-	if x != nil {
-		return x.LocalIp
-	}
-	return nil
-}
-
-func (x *DNSConfig) GetRemoteIp() []byte {
-	if x != nil {
-		return x.RemoteIp
-	}
-	return nil
+// Deprecated: Use HeadlessService_Port.ProtoReflect.Descriptor instead.
+func (*HeadlessService_Port) Descriptor() ([]byte, []int) {
+	return file_rpc_daemon_daemon_proto_rawDescGZIP(), []int{6, 0}
 }
 
-func (x *DNSConfig) GetExcludeSuffixes() []string {
+func (x *HeadlessService_Port) GetName() string {
 	if x != nil {
-		return x.ExcludeSuffixes
+		return x.Name
 	}
-	return nil
+	return ""
 }
 
-func (x *DNSConfig) GetIncludeSuffixes() []string {
+func (x *HeadlessService_Port) GetProtocol() string {
 	if x != nil {
-		return x.IncludeSuffixes
+		return x.Protocol
 	}
-	return nil
+	return ""
 }
 
-func (x *DNSConfig) GetLookupTimeout() *durationpb.Duration {
+func (x *HeadlessService_Port) GetPort() int32 {
 	if x != nil {
-		return x.LookupTimeout
+		return x.Port
 	}
-	return nil
+	return 0
 }
 
-// OutboundInfo contains all information that the root daemon needs in order to
-// establish outbound traffic to the cluster.
-type OutboundInfo struct {
+type HeadlessService_Endpoint struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	// session makes it possible for the root daemon to identify itself as the
-	// same client as the user daemon.
-	Session *manager.SessionInfo `protobuf:"bytes,2,opt,name=session,proto3" json:"session,omitempty"`
-	// DNS configuration
-	Dns *DNSConfig `protobuf:"bytes,3,opt,name=dns,proto3" json:"dns,omitempty"`
-	// also_proxy are user-added subnets.
-	AlsoProxySubnets []*manager.IPNet `protobuf:"bytes,5,rep,name=also_proxy_subnets,json=alsoProxySubnets,proto3" json:"also_proxy_subnets,omitempty"`
+	// The hostname of the pod, or empty when the pod has none, in which case a name is derived
+	// from its IP
+	Hostname string   `protobuf:"bytes,1,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	Ips      [][]byte `protobuf:"bytes,2,rep,name=ips,proto3" json:"ips,omitempty"`
 }
 
-func (x *OutboundInfo) Reset() {
-	*x = OutboundInfo{}
+func (x *HeadlessService_Endpoint) Reset() {
+	*x = HeadlessService_Endpoint{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_rpc_daemon_daemon_proto_msgTypes[3]
+		mi := &file_rpc_daemon_daemon_proto_msgTypes[15]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *OutboundInfo) String() string {
+func (x *HeadlessService_Endpoint) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*OutboundInfo) ProtoMessage() {}
+func (*HeadlessService_Endpoint) ProtoMessage() {}
 
-func (x *OutboundInfo) ProtoReflect() protoreflect.Message {
-	mi := &file_rpc_daemon_daemon_proto_msgTypes[3]
+func (x *HeadlessService_Endpoint) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_daemon_daemon_proto_msgTypes[15]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -255,28 +1385,21 @@ func (x *OutboundInfo) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use OutboundInfo.ProtoReflect.Descriptor instead.
-func (*OutboundInfo) Descriptor() ([]byte, []int) {
-	return file_rpc_daemon_daemon_proto_rawDescGZIP(), []int{3}
-}
-
-func (x *OutboundInfo) GetSession() *manager.SessionInfo {
-	if x != nil {
-		return x.Session
-	}
-	return nil
+// Deprecated: Use HeadlessService_Endpoint.ProtoReflect.Descriptor instead.
+func (*HeadlessService_Endpoint) Descriptor() ([]byte, []int) {
+	return file_rpc_daemon_daemon_proto_rawDescGZIP(), []int{6, 1}
 }
 
-func (x *OutboundInfo) GetDns() *DNSConfig {
+func (x *HeadlessService_Endpoint) GetHostname() string {
 	if x != nil {
-		return x.Dns
+		return x.Hostname
 	}
-	return nil
+	return ""
 }
 
-func (x *OutboundInfo) GetAlsoProxySubnets() []*manager.IPNet {
+func (x *HeadlessService_Endpoint) GetIps() [][]byte {
 	if x != nil {
-		return x.AlsoProxySubnets
+		return x.Ips
 	}
 	return nil
 }
@@ -290,21 +1413,167 @@ var file_rpc_daemon_daemon_proto_rawDesc = []byte{
 	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f,
 	0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x1b,
 	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f,
-	0x65, 0x6d, 0x70, 0x74, 0x79, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x18, 0x72, 0x70, 0x63,
-	0x2f, 0x63, 0x6f, 0x6d, 0x6d, 0x6f, 0x6e, 0x2f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x2e,
-	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x19, 0x72, 0x70, 0x63, 0x2f, 0x6d, 0x61, 0x6e, 0x61, 0x67,
-	0x65, 0x72, 0x2f, 0x6d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x72, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
-	0x22, 0x6c, 0x0a, 0x0c, 0x44, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73,
-	0x12, 0x4a, 0x0a, 0x0f, 0x6f, 0x75, 0x74, 0x62, 0x6f, 0x75, 0x6e, 0x64, 0x5f, 0x63, 0x6f, 0x6e,
-	0x66, 0x69, 0x67, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x21, 0x2e, 0x74, 0x65, 0x6c, 0x65,
+	0x65, 0x6d, 0x70, 0x74, 0x79, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x1f, 0x67, 0x6f, 0x6f,
+	0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d,
+	0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x18, 0x72, 0x70,
+	0x63, 0x2f, 0x63, 0x6f, 0x6d, 0x6d, 0x6f, 0x6e, 0x2f, 0x74, 0x72, 0x61, 0x63, 0x69, 0x6e, 0x67,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x18, 0x72, 0x70, 0x63, 0x2f, 0x63, 0x6f, 0x6d, 0x6d,
+	0x6f, 0x6e, 0x2f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x1a, 0x19, 0x72, 0x70, 0x63, 0x2f, 0x6d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x72, 0x2f, 0x6d, 0x61,
+	0x6e, 0x61, 0x67, 0x65, 0x72, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x90, 0x07, 0x0a, 0x0c,
+	0x44, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x4a, 0x0a, 0x0f,
+	0x6f, 0x75, 0x74, 0x62, 0x6f, 0x75, 0x6e, 0x64, 0x5f, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x18,
+	0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x21, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73,
+	0x65, 0x6e, 0x63, 0x65, 0x2e, 0x64, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x2e, 0x4f, 0x75, 0x74, 0x62,
+	0x6f, 0x75, 0x6e, 0x64, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x0e, 0x6f, 0x75, 0x74, 0x62, 0x6f, 0x75,
+	0x6e, 0x64, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x12, 0x19, 0x0a, 0x08, 0x74, 0x75, 0x6e, 0x5f,
+	0x6e, 0x61, 0x6d, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x74, 0x75, 0x6e, 0x4e,
+	0x61, 0x6d, 0x65, 0x12, 0x48, 0x0a, 0x0e, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x64, 0x5f, 0x73, 0x75,
+	0x62, 0x6e, 0x65, 0x74, 0x73, 0x18, 0x06, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x21, 0x2e, 0x74, 0x65,
+	0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x64, 0x61, 0x65, 0x6d, 0x6f,
+	0x6e, 0x2e, 0x52, 0x6f, 0x75, 0x74, 0x65, 0x64, 0x53, 0x75, 0x62, 0x6e, 0x65, 0x74, 0x52, 0x0d,
+	0x72, 0x6f, 0x75, 0x74, 0x65, 0x64, 0x53, 0x75, 0x62, 0x6e, 0x65, 0x74, 0x73, 0x12, 0x21, 0x0a,
+	0x0c, 0x64, 0x6e, 0x73, 0x5f, 0x6c, 0x69, 0x73, 0x74, 0x65, 0x6e, 0x65, 0x72, 0x18, 0x07, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0b, 0x64, 0x6e, 0x73, 0x4c, 0x69, 0x73, 0x74, 0x65, 0x6e, 0x65, 0x72,
+	0x12, 0x21, 0x0a, 0x0c, 0x73, 0x65, 0x61, 0x72, 0x63, 0x68, 0x5f, 0x70, 0x61, 0x74, 0x68, 0x73,
+	0x18, 0x08, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0b, 0x73, 0x65, 0x61, 0x72, 0x63, 0x68, 0x50, 0x61,
+	0x74, 0x68, 0x73, 0x12, 0x4e, 0x0a, 0x10, 0x73, 0x75, 0x62, 0x6e, 0x65, 0x74, 0x5f, 0x63, 0x6f,
+	0x6e, 0x66, 0x6c, 0x69, 0x63, 0x74, 0x73, 0x18, 0x09, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x23, 0x2e,
+	0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x64, 0x61, 0x65,
+	0x6d, 0x6f, 0x6e, 0x2e, 0x53, 0x75, 0x62, 0x6e, 0x65, 0x74, 0x43, 0x6f, 0x6e, 0x66, 0x6c, 0x69,
+	0x63, 0x74, 0x52, 0x0f, 0x73, 0x75, 0x62, 0x6e, 0x65, 0x74, 0x43, 0x6f, 0x6e, 0x66, 0x6c, 0x69,
+	0x63, 0x74, 0x73, 0x12, 0x3f, 0x0a, 0x0d, 0x6c, 0x61, 0x73, 0x74, 0x5f, 0x61, 0x63, 0x74, 0x69,
+	0x76, 0x69, 0x74, 0x79, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f,
+	0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d,
+	0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x0c, 0x6c, 0x61, 0x73, 0x74, 0x41, 0x63, 0x74, 0x69,
+	0x76, 0x69, 0x74, 0x79, 0x12, 0x2b, 0x0a, 0x11, 0x70, 0x72, 0x69, 0x76, 0x69, 0x6c, 0x65, 0x67,
+	0x65, 0x64, 0x5f, 0x68, 0x65, 0x6c, 0x70, 0x65, 0x72, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x10, 0x70, 0x72, 0x69, 0x76, 0x69, 0x6c, 0x65, 0x67, 0x65, 0x64, 0x48, 0x65, 0x6c, 0x70, 0x65,
+	0x72, 0x12, 0x27, 0x0a, 0x0f, 0x77, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x73, 0x5f, 0x73, 0x65, 0x72,
+	0x76, 0x69, 0x63, 0x65, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0e, 0x77, 0x69, 0x6e, 0x64,
+	0x6f, 0x77, 0x73, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x17, 0x0a, 0x07, 0x74, 0x75,
+	0x6e, 0x5f, 0x6d, 0x74, 0x75, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x05, 0x52, 0x06, 0x74, 0x75, 0x6e,
+	0x4d, 0x74, 0x75, 0x12, 0x23, 0x0a, 0x0d, 0x64, 0x6e, 0x73, 0x5f, 0x66, 0x6f, 0x72, 0x77, 0x61,
+	0x72, 0x64, 0x65, 0x72, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x64, 0x6e, 0x73, 0x46,
+	0x6f, 0x72, 0x77, 0x61, 0x72, 0x64, 0x65, 0x72, 0x12, 0x2b, 0x0a, 0x12, 0x74, 0x75, 0x6e, 0x5f,
+	0x6d, 0x74, 0x75, 0x5f, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x65, 0x64, 0x5f, 0x62, 0x79, 0x18, 0x0f,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x0f, 0x74, 0x75, 0x6e, 0x4d, 0x74, 0x75, 0x4c, 0x69, 0x6d, 0x69,
+	0x74, 0x65, 0x64, 0x42, 0x79, 0x12, 0x51, 0x0a, 0x11, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e,
+	0x5f, 0x63, 0x6f, 0x6e, 0x66, 0x6c, 0x69, 0x63, 0x74, 0x73, 0x18, 0x10, 0x20, 0x03, 0x28, 0x0b,
+	0x32, 0x24, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e,
+	0x64, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x2e, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x43, 0x6f,
+	0x6e, 0x66, 0x6c, 0x69, 0x63, 0x74, 0x52, 0x10, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x43,
+	0x6f, 0x6e, 0x66, 0x6c, 0x69, 0x63, 0x74, 0x73, 0x12, 0x19, 0x0a, 0x08, 0x64, 0x6e, 0x73, 0x5f,
+	0x6d, 0x6f, 0x64, 0x65, 0x18, 0x11, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x64, 0x6e, 0x73, 0x4d,
+	0x6f, 0x64, 0x65, 0x12, 0x25, 0x0a, 0x0e, 0x6d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x64, 0x5f, 0x72,
+	0x6f, 0x75, 0x74, 0x65, 0x73, 0x18, 0x12, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0d, 0x6d, 0x61, 0x6e,
+	0x61, 0x67, 0x65, 0x64, 0x52, 0x6f, 0x75, 0x74, 0x65, 0x73, 0x12, 0x48, 0x0a, 0x0e, 0x67, 0x61,
+	0x74, 0x65, 0x77, 0x61, 0x79, 0x5f, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x73, 0x18, 0x13, 0x20, 0x03,
+	0x28, 0x0b, 0x32, 0x21, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63,
+	0x65, 0x2e, 0x64, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x2e, 0x47, 0x61, 0x74, 0x65, 0x77, 0x61, 0x79,
+	0x52, 0x6f, 0x75, 0x74, 0x65, 0x52, 0x0d, 0x67, 0x61, 0x74, 0x65, 0x77, 0x61, 0x79, 0x52, 0x6f,
+	0x75, 0x74, 0x65, 0x73, 0x12, 0x46, 0x0a, 0x0b, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69,
+	0x6f, 0x6e, 0x73, 0x18, 0x14, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x24, 0x2e, 0x74, 0x65, 0x6c, 0x65,
 	0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x64, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x2e,
-	0x4f, 0x75, 0x74, 0x62, 0x6f, 0x75, 0x6e, 0x64, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x0e, 0x6f, 0x75,
-	0x74, 0x62, 0x6f, 0x75, 0x6e, 0x64, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x4a, 0x04, 0x08, 0x01,
-	0x10, 0x02, 0x4a, 0x04, 0x08, 0x02, 0x10, 0x03, 0x4a, 0x04, 0x08, 0x03, 0x10, 0x04, 0x22, 0x3d,
-	0x0a, 0x05, 0x50, 0x61, 0x74, 0x68, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x70, 0x61, 0x74, 0x68, 0x73,
-	0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x05, 0x70, 0x61, 0x74, 0x68, 0x73, 0x12, 0x1e, 0x0a,
-	0x0a, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28,
-	0x09, 0x52, 0x0a, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x73, 0x22, 0xe1, 0x01,
+	0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x74, 0x61, 0x74, 0x73, 0x52,
+	0x0b, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x4a, 0x04, 0x08, 0x01,
+	0x10, 0x02, 0x4a, 0x04, 0x08, 0x02, 0x10, 0x03, 0x4a, 0x04, 0x08, 0x03, 0x10, 0x04, 0x22, 0xb6,
+	0x01, 0x0a, 0x0f, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x74, 0x61,
+	0x74, 0x73, 0x12, 0x18, 0x0a, 0x07, 0x74, 0x72, 0x61, 0x63, 0x6b, 0x65, 0x64, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x05, 0x52, 0x07, 0x74, 0x72, 0x61, 0x63, 0x6b, 0x65, 0x64, 0x12, 0x1f, 0x0a, 0x0b,
+	0x6d, 0x61, 0x78, 0x5f, 0x74, 0x72, 0x61, 0x63, 0x6b, 0x65, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x0a, 0x6d, 0x61, 0x78, 0x54, 0x72, 0x61, 0x63, 0x6b, 0x65, 0x64, 0x12, 0x25, 0x0a,
+	0x0e, 0x69, 0x64, 0x6c, 0x65, 0x5f, 0x65, 0x76, 0x69, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0d, 0x69, 0x64, 0x6c, 0x65, 0x45, 0x76, 0x69, 0x63, 0x74,
+	0x69, 0x6f, 0x6e, 0x73, 0x12, 0x27, 0x0a, 0x0f, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x5f, 0x65, 0x76,
+	0x69, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0e, 0x6c,
+	0x69, 0x6d, 0x69, 0x74, 0x45, 0x76, 0x69, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x18, 0x0a,
+	0x07, 0x72, 0x65, 0x66, 0x75, 0x73, 0x65, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x04, 0x52, 0x07,
+	0x72, 0x65, 0x66, 0x75, 0x73, 0x65, 0x64, 0x22, 0xd7, 0x01, 0x0a, 0x0f, 0x53, 0x65, 0x73, 0x73,
+	0x69, 0x6f, 0x6e, 0x43, 0x6f, 0x6e, 0x66, 0x6c, 0x69, 0x63, 0x74, 0x12, 0x21, 0x0a, 0x0c, 0x73,
+	0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x0b, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x33,
+	0x0a, 0x06, 0x73, 0x75, 0x62, 0x6e, 0x65, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1b,
+	0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x6d, 0x61,
+	0x6e, 0x61, 0x67, 0x65, 0x72, 0x2e, 0x49, 0x50, 0x4e, 0x65, 0x74, 0x52, 0x06, 0x73, 0x75, 0x62,
+	0x6e, 0x65, 0x74, 0x12, 0x2c, 0x0a, 0x12, 0x6f, 0x74, 0x68, 0x65, 0x72, 0x5f, 0x73, 0x65, 0x73,
+	0x73, 0x69, 0x6f, 0x6e, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x10, 0x6f, 0x74, 0x68, 0x65, 0x72, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x4e, 0x61, 0x6d,
+	0x65, 0x12, 0x3e, 0x0a, 0x0c, 0x6f, 0x74, 0x68, 0x65, 0x72, 0x5f, 0x73, 0x75, 0x62, 0x6e, 0x65,
+	0x74, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1b, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72,
+	0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x6d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x72, 0x2e, 0x49,
+	0x50, 0x4e, 0x65, 0x74, 0x52, 0x0b, 0x6f, 0x74, 0x68, 0x65, 0x72, 0x53, 0x75, 0x62, 0x6e, 0x65,
+	0x74, 0x22, 0x84, 0x02, 0x0a, 0x0e, 0x53, 0x75, 0x62, 0x6e, 0x65, 0x74, 0x43, 0x6f, 0x6e, 0x66,
+	0x6c, 0x69, 0x63, 0x74, 0x12, 0x33, 0x0a, 0x06, 0x73, 0x75, 0x62, 0x6e, 0x65, 0x74, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x1b, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65,
+	0x6e, 0x63, 0x65, 0x2e, 0x6d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x72, 0x2e, 0x49, 0x50, 0x4e, 0x65,
+	0x74, 0x52, 0x06, 0x73, 0x75, 0x62, 0x6e, 0x65, 0x74, 0x12, 0x31, 0x0a, 0x05, 0x72, 0x6f, 0x75,
+	0x74, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1b, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70,
+	0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x6d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x72, 0x2e,
+	0x49, 0x50, 0x4e, 0x65, 0x74, 0x52, 0x05, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x12, 0x1c, 0x0a, 0x09,
+	0x69, 0x6e, 0x74, 0x65, 0x72, 0x66, 0x61, 0x63, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x09, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x66, 0x61, 0x63, 0x65, 0x12, 0x48, 0x0a, 0x08, 0x73, 0x65,
+	0x76, 0x65, 0x72, 0x69, 0x74, 0x79, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x2c, 0x2e, 0x74,
+	0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x64, 0x61, 0x65, 0x6d,
+	0x6f, 0x6e, 0x2e, 0x53, 0x75, 0x62, 0x6e, 0x65, 0x74, 0x43, 0x6f, 0x6e, 0x66, 0x6c, 0x69, 0x63,
+	0x74, 0x2e, 0x53, 0x65, 0x76, 0x65, 0x72, 0x69, 0x74, 0x79, 0x52, 0x08, 0x73, 0x65, 0x76, 0x65,
+	0x72, 0x69, 0x74, 0x79, 0x22, 0x22, 0x0a, 0x08, 0x53, 0x65, 0x76, 0x65, 0x72, 0x69, 0x74, 0x79,
+	0x12, 0x0b, 0x0a, 0x07, 0x57, 0x41, 0x52, 0x4e, 0x49, 0x4e, 0x47, 0x10, 0x00, 0x12, 0x09, 0x0a,
+	0x05, 0x45, 0x52, 0x52, 0x4f, 0x52, 0x10, 0x01, 0x22, 0xeb, 0x01, 0x0a, 0x0c, 0x52, 0x6f, 0x75,
+	0x74, 0x65, 0x64, 0x53, 0x75, 0x62, 0x6e, 0x65, 0x74, 0x12, 0x33, 0x0a, 0x06, 0x73, 0x75, 0x62,
+	0x6e, 0x65, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1b, 0x2e, 0x74, 0x65, 0x6c, 0x65,
+	0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x6d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x72,
+	0x2e, 0x49, 0x50, 0x4e, 0x65, 0x74, 0x52, 0x06, 0x73, 0x75, 0x62, 0x6e, 0x65, 0x74, 0x12, 0x40,
+	0x0a, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x28,
+	0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x64, 0x61,
+	0x65, 0x6d, 0x6f, 0x6e, 0x2e, 0x52, 0x6f, 0x75, 0x74, 0x65, 0x64, 0x53, 0x75, 0x62, 0x6e, 0x65,
+	0x74, 0x2e, 0x53, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x52, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65,
+	0x12, 0x21, 0x0a, 0x0c, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x5f, 0x6e, 0x61, 0x6d, 0x65,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x4e,
+	0x61, 0x6d, 0x65, 0x22, 0x41, 0x0a, 0x06, 0x53, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x12, 0x0f, 0x0a,
+	0x0b, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49, 0x45, 0x44, 0x10, 0x00, 0x12, 0x0c,
+	0x0a, 0x08, 0x53, 0x45, 0x52, 0x56, 0x49, 0x43, 0x45, 0x53, 0x10, 0x01, 0x12, 0x08, 0x0a, 0x04,
+	0x50, 0x4f, 0x44, 0x53, 0x10, 0x02, 0x12, 0x0e, 0x0a, 0x0a, 0x41, 0x4c, 0x53, 0x4f, 0x5f, 0x50,
+	0x52, 0x4f, 0x58, 0x59, 0x10, 0x03, 0x22, 0x60, 0x0a, 0x05, 0x50, 0x61, 0x74, 0x68, 0x73, 0x12,
+	0x14, 0x0a, 0x05, 0x70, 0x61, 0x74, 0x68, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x05,
+	0x70, 0x61, 0x74, 0x68, 0x73, 0x12, 0x1e, 0x0a, 0x0a, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61,
+	0x63, 0x65, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0a, 0x6e, 0x61, 0x6d, 0x65, 0x73,
+	0x70, 0x61, 0x63, 0x65, 0x73, 0x12, 0x21, 0x0a, 0x0c, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e,
+	0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x73, 0x65, 0x73,
+	0x73, 0x69, 0x6f, 0x6e, 0x4e, 0x61, 0x6d, 0x65, 0x22, 0xd7, 0x02, 0x0a, 0x0f, 0x48, 0x65, 0x61,
+	0x64, 0x6c, 0x65, 0x73, 0x73, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x12, 0x0a, 0x04,
+	0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65,
+	0x12, 0x1c, 0x0a, 0x09, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x09, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x12, 0x3f,
+	0x0a, 0x05, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x29, 0x2e,
+	0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x64, 0x61, 0x65,
+	0x6d, 0x6f, 0x6e, 0x2e, 0x48, 0x65, 0x61, 0x64, 0x6c, 0x65, 0x73, 0x73, 0x53, 0x65, 0x72, 0x76,
+	0x69, 0x63, 0x65, 0x2e, 0x50, 0x6f, 0x72, 0x74, 0x52, 0x05, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x12,
+	0x4b, 0x0a, 0x09, 0x65, 0x6e, 0x64, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x73, 0x18, 0x04, 0x20, 0x03,
+	0x28, 0x0b, 0x32, 0x2d, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63,
+	0x65, 0x2e, 0x64, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x2e, 0x48, 0x65, 0x61, 0x64, 0x6c, 0x65, 0x73,
+	0x73, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x2e, 0x45, 0x6e, 0x64, 0x70, 0x6f, 0x69, 0x6e,
+	0x74, 0x52, 0x09, 0x65, 0x6e, 0x64, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x73, 0x1a, 0x4a, 0x0a, 0x04,
+	0x50, 0x6f, 0x72, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x63, 0x6f, 0x6c, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x63, 0x6f, 0x6c, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x6f, 0x72, 0x74, 0x18, 0x03, 0x20, 0x01,
+	0x28, 0x05, 0x52, 0x04, 0x70, 0x6f, 0x72, 0x74, 0x1a, 0x38, 0x0a, 0x08, 0x45, 0x6e, 0x64, 0x70,
+	0x6f, 0x69, 0x6e, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x68, 0x6f, 0x73, 0x74, 0x6e, 0x61, 0x6d, 0x65,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x68, 0x6f, 0x73, 0x74, 0x6e, 0x61, 0x6d, 0x65,
+	0x12, 0x10, 0x0a, 0x03, 0x69, 0x70, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x03, 0x69,
+	0x70, 0x73, 0x22, 0x77, 0x0a, 0x10, 0x48, 0x65, 0x61, 0x64, 0x6c, 0x65, 0x73, 0x73, 0x53, 0x65,
+	0x72, 0x76, 0x69, 0x63, 0x65, 0x73, 0x12, 0x40, 0x0a, 0x08, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63,
+	0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x24, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70,
+	0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x64, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x2e, 0x48,
+	0x65, 0x61, 0x64, 0x6c, 0x65, 0x73, 0x73, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x52, 0x08,
+	0x73, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x73, 0x12, 0x21, 0x0a, 0x0c, 0x73, 0x65, 0x73, 0x73,
+	0x69, 0x6f, 0x6e, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b,
+	0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x4e, 0x61, 0x6d, 0x65, 0x22, 0x40, 0x0a, 0x09, 0x52,
+	0x6f, 0x75, 0x74, 0x65, 0x64, 0x49, 0x50, 0x73, 0x12, 0x10, 0x0a, 0x03, 0x69, 0x70, 0x73, 0x18,
+	0x01, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x03, 0x69, 0x70, 0x73, 0x12, 0x21, 0x0a, 0x0c, 0x73, 0x65,
+	0x73, 0x73, 0x69, 0x6f, 0x6e, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x0b, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x4e, 0x61, 0x6d, 0x65, 0x22, 0xde, 0x02,
 	0x0a, 0x09, 0x44, 0x4e, 0x53, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x12, 0x19, 0x0a, 0x08, 0x6c,
 	0x6f, 0x63, 0x61, 0x6c, 0x5f, 0x69, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x07, 0x6c,
 	0x6f, 0x63, 0x61, 0x6c, 0x49, 0x70, 0x12, 0x1b, 0x0a, 0x09, 0x72, 0x65, 0x6d, 0x6f, 0x74, 0x65,
@@ -318,52 +1587,116 @@ var file_rpc_daemon_daemon_proto_rawDesc = []byte{
 	0x6b, 0x75, 0x70, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x18, 0x06, 0x20, 0x01, 0x28,
 	0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
 	0x62, 0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x0d, 0x6c, 0x6f,
-	0x6f, 0x6b, 0x75, 0x70, 0x54, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x4a, 0x04, 0x08, 0x05, 0x10,
-	0x06, 0x22, 0xd4, 0x01, 0x0a, 0x0c, 0x4f, 0x75, 0x74, 0x62, 0x6f, 0x75, 0x6e, 0x64, 0x49, 0x6e,
-	0x66, 0x6f, 0x12, 0x3b, 0x0a, 0x07, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20,
-	0x01, 0x28, 0x0b, 0x32, 0x21, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e,
-	0x63, 0x65, 0x2e, 0x6d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x72, 0x2e, 0x53, 0x65, 0x73, 0x73, 0x69,
-	0x6f, 0x6e, 0x49, 0x6e, 0x66, 0x6f, 0x52, 0x07, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x12,
-	0x30, 0x0a, 0x03, 0x64, 0x6e, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1e, 0x2e, 0x74,
-	0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x64, 0x61, 0x65, 0x6d,
-	0x6f, 0x6e, 0x2e, 0x44, 0x4e, 0x53, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x52, 0x03, 0x64, 0x6e,
-	0x73, 0x12, 0x49, 0x0a, 0x12, 0x61, 0x6c, 0x73, 0x6f, 0x5f, 0x70, 0x72, 0x6f, 0x78, 0x79, 0x5f,
-	0x73, 0x75, 0x62, 0x6e, 0x65, 0x74, 0x73, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1b, 0x2e,
-	0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x6d, 0x61, 0x6e,
-	0x61, 0x67, 0x65, 0x72, 0x2e, 0x49, 0x50, 0x4e, 0x65, 0x74, 0x52, 0x10, 0x61, 0x6c, 0x73, 0x6f,
-	0x50, 0x72, 0x6f, 0x78, 0x79, 0x53, 0x75, 0x62, 0x6e, 0x65, 0x74, 0x73, 0x4a, 0x04, 0x08, 0x01,
-	0x10, 0x02, 0x4a, 0x04, 0x08, 0x04, 0x10, 0x05, 0x32, 0xae, 0x03, 0x0a, 0x06, 0x44, 0x61, 0x65,
-	0x6d, 0x6f, 0x6e, 0x12, 0x43, 0x0a, 0x07, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x16,
-	0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66,
-	0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x20, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65,
-	0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x63, 0x6f, 0x6d, 0x6d, 0x6f, 0x6e, 0x2e, 0x56, 0x65, 0x72,
-	0x73, 0x69, 0x6f, 0x6e, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x43, 0x0a, 0x06, 0x53, 0x74, 0x61, 0x74,
-	0x75, 0x73, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74,
-	0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x21, 0x2e, 0x74, 0x65, 0x6c,
-	0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x64, 0x61, 0x65, 0x6d, 0x6f, 0x6e,
-	0x2e, 0x44, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x36, 0x0a,
-	0x04, 0x51, 0x75, 0x69, 0x74, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70,
-	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x16, 0x2e,
+	0x6f, 0x6b, 0x75, 0x70, 0x54, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x12, 0x47, 0x0a, 0x12, 0x6e,
+	0x65, 0x67, 0x61, 0x74, 0x69, 0x76, 0x65, 0x5f, 0x63, 0x61, 0x63, 0x68, 0x65, 0x5f, 0x74, 0x74,
+	0x6c, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x52, 0x10, 0x6e, 0x65, 0x67, 0x61, 0x74, 0x69, 0x76, 0x65, 0x43, 0x61, 0x63, 0x68,
+	0x65, 0x54, 0x74, 0x6c, 0x12, 0x32, 0x0a, 0x07, 0x6d, 0x61, 0x78, 0x5f, 0x74, 0x74, 0x6c, 0x18,
+	0x08, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x52, 0x06, 0x6d, 0x61, 0x78, 0x54, 0x74, 0x6c, 0x4a, 0x04, 0x08, 0x05, 0x10, 0x06, 0x22, 0xb8,
+	0x04, 0x0a, 0x0c, 0x4f, 0x75, 0x74, 0x62, 0x6f, 0x75, 0x6e, 0x64, 0x49, 0x6e, 0x66, 0x6f, 0x12,
+	0x3b, 0x0a, 0x07, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x21, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e,
+	0x6d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x72, 0x2e, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49,
+	0x6e, 0x66, 0x6f, 0x52, 0x07, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x30, 0x0a, 0x03,
+	0x64, 0x6e, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1e, 0x2e, 0x74, 0x65, 0x6c, 0x65,
+	0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x64, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x2e,
+	0x44, 0x4e, 0x53, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x52, 0x03, 0x64, 0x6e, 0x73, 0x12, 0x49,
+	0x0a, 0x12, 0x61, 0x6c, 0x73, 0x6f, 0x5f, 0x70, 0x72, 0x6f, 0x78, 0x79, 0x5f, 0x73, 0x75, 0x62,
+	0x6e, 0x65, 0x74, 0x73, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1b, 0x2e, 0x74, 0x65, 0x6c,
+	0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x6d, 0x61, 0x6e, 0x61, 0x67, 0x65,
+	0x72, 0x2e, 0x49, 0x50, 0x4e, 0x65, 0x74, 0x52, 0x10, 0x61, 0x6c, 0x73, 0x6f, 0x50, 0x72, 0x6f,
+	0x78, 0x79, 0x53, 0x75, 0x62, 0x6e, 0x65, 0x74, 0x73, 0x12, 0x4b, 0x0a, 0x13, 0x6e, 0x65, 0x76,
+	0x65, 0x72, 0x5f, 0x70, 0x72, 0x6f, 0x78, 0x79, 0x5f, 0x73, 0x75, 0x62, 0x6e, 0x65, 0x74, 0x73,
+	0x18, 0x06, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1b, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65,
+	0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x6d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x72, 0x2e, 0x49, 0x50,
+	0x4e, 0x65, 0x74, 0x52, 0x11, 0x6e, 0x65, 0x76, 0x65, 0x72, 0x50, 0x72, 0x6f, 0x78, 0x79, 0x53,
+	0x75, 0x62, 0x6e, 0x65, 0x74, 0x73, 0x12, 0x57, 0x0a, 0x19, 0x61, 0x6c, 0x6c, 0x6f, 0x77, 0x5f,
+	0x63, 0x6f, 0x6e, 0x66, 0x6c, 0x69, 0x63, 0x74, 0x69, 0x6e, 0x67, 0x5f, 0x73, 0x75, 0x62, 0x6e,
+	0x65, 0x74, 0x73, 0x18, 0x07, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1b, 0x2e, 0x74, 0x65, 0x6c, 0x65,
+	0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x6d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x72,
+	0x2e, 0x49, 0x50, 0x4e, 0x65, 0x74, 0x52, 0x17, 0x61, 0x6c, 0x6c, 0x6f, 0x77, 0x43, 0x6f, 0x6e,
+	0x66, 0x6c, 0x69, 0x63, 0x74, 0x69, 0x6e, 0x67, 0x53, 0x75, 0x62, 0x6e, 0x65, 0x74, 0x73, 0x12,
+	0x10, 0x0a, 0x03, 0x6d, 0x74, 0x75, 0x18, 0x08, 0x20, 0x01, 0x28, 0x05, 0x52, 0x03, 0x6d, 0x74,
+	0x75, 0x12, 0x21, 0x0a, 0x0c, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x5f, 0x6e, 0x61, 0x6d,
+	0x65, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e,
+	0x4e, 0x61, 0x6d, 0x65, 0x12, 0x36, 0x0a, 0x17, 0x6f, 0x75, 0x74, 0x62, 0x6f, 0x75, 0x6e, 0x64,
+	0x5f, 0x74, 0x72, 0x61, 0x66, 0x66, 0x69, 0x63, 0x5f, 0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x18,
+	0x0a, 0x20, 0x01, 0x28, 0x09, 0x52, 0x15, 0x6f, 0x75, 0x74, 0x62, 0x6f, 0x75, 0x6e, 0x64, 0x54,
+	0x72, 0x61, 0x66, 0x66, 0x69, 0x63, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x12, 0x4f, 0x0a, 0x0e,
+	0x64, 0x6f, 0x63, 0x6b, 0x65, 0x72, 0x5f, 0x6e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x18, 0x0b,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x28, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65,
+	0x6e, 0x63, 0x65, 0x2e, 0x64, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x2e, 0x44, 0x6f, 0x63, 0x6b, 0x65,
+	0x72, 0x4e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x52, 0x6f, 0x75, 0x74, 0x65, 0x73, 0x52, 0x0d,
+	0x64, 0x6f, 0x63, 0x6b, 0x65, 0x72, 0x4e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x4a, 0x04, 0x08,
+	0x01, 0x10, 0x02, 0x4a, 0x04, 0x08, 0x04, 0x10, 0x05, 0x22, 0x73, 0x0a, 0x13, 0x44, 0x6f, 0x63,
+	0x6b, 0x65, 0x72, 0x4e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x52, 0x6f, 0x75, 0x74, 0x65, 0x73,
+	0x12, 0x18, 0x0a, 0x07, 0x67, 0x61, 0x74, 0x65, 0x77, 0x61, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x07, 0x67, 0x61, 0x74, 0x65, 0x77, 0x61, 0x79, 0x12, 0x42, 0x0a, 0x0b, 0x6e, 0x6f,
+	0x64, 0x65, 0x5f, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32,
+	0x21, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x64,
+	0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x2e, 0x47, 0x61, 0x74, 0x65, 0x77, 0x61, 0x79, 0x52, 0x6f, 0x75,
+	0x74, 0x65, 0x52, 0x0a, 0x6e, 0x6f, 0x64, 0x65, 0x52, 0x6f, 0x75, 0x74, 0x65, 0x73, 0x22, 0x5d,
+	0x0a, 0x0c, 0x47, 0x61, 0x74, 0x65, 0x77, 0x61, 0x79, 0x52, 0x6f, 0x75, 0x74, 0x65, 0x12, 0x33,
+	0x0a, 0x06, 0x73, 0x75, 0x62, 0x6e, 0x65, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1b,
+	0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x6d, 0x61,
+	0x6e, 0x61, 0x67, 0x65, 0x72, 0x2e, 0x49, 0x50, 0x4e, 0x65, 0x74, 0x52, 0x06, 0x73, 0x75, 0x62,
+	0x6e, 0x65, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x67, 0x61, 0x74, 0x65, 0x77, 0x61, 0x79, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0c, 0x52, 0x07, 0x67, 0x61, 0x74, 0x65, 0x77, 0x61, 0x79, 0x22, 0x21, 0x0a,
+	0x0b, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x12, 0x0a, 0x04,
+	0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65,
+	0x32, 0xdc, 0x05, 0x0a, 0x06, 0x44, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x12, 0x43, 0x0a, 0x07, 0x56,
+	0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x20,
+	0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x63, 0x6f,
+	0x6d, 0x6d, 0x6f, 0x6e, 0x2e, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x6e, 0x66, 0x6f,
+	0x12, 0x43, 0x0a, 0x06, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f,
+	0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70,
+	0x74, 0x79, 0x1a, 0x21, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63,
+	0x65, 0x2e, 0x64, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x2e, 0x44, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x53,
+	0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x36, 0x0a, 0x04, 0x51, 0x75, 0x69, 0x74, 0x12, 0x16, 0x2e,
 	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e,
-	0x45, 0x6d, 0x70, 0x74, 0x79, 0x12, 0x4c, 0x0a, 0x0f, 0x53, 0x65, 0x74, 0x4f, 0x75, 0x74, 0x62,
-	0x6f, 0x75, 0x6e, 0x64, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x21, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70,
-	0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x64, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x2e, 0x4f,
-	0x75, 0x74, 0x62, 0x6f, 0x75, 0x6e, 0x64, 0x49, 0x6e, 0x66, 0x6f, 0x1a, 0x16, 0x2e, 0x67, 0x6f,
+	0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x12, 0x43, 0x0a,
+	0x0c, 0x47, 0x61, 0x74, 0x68, 0x65, 0x72, 0x54, 0x72, 0x61, 0x63, 0x65, 0x73, 0x12, 0x16, 0x2e,
+	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e,
+	0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x1b, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73,
+	0x65, 0x6e, 0x63, 0x65, 0x2e, 0x63, 0x6f, 0x6d, 0x6d, 0x6f, 0x6e, 0x2e, 0x54, 0x72, 0x61, 0x63,
+	0x65, 0x73, 0x12, 0x4c, 0x0a, 0x0f, 0x53, 0x65, 0x74, 0x4f, 0x75, 0x74, 0x62, 0x6f, 0x75, 0x6e,
+	0x64, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x21, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73,
+	0x65, 0x6e, 0x63, 0x65, 0x2e, 0x64, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x2e, 0x4f, 0x75, 0x74, 0x62,
+	0x6f, 0x75, 0x6e, 0x64, 0x49, 0x6e, 0x66, 0x6f, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c,
+	0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79,
+	0x12, 0x49, 0x0a, 0x0d, 0x52, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f,
+	0x6e, 0x12, 0x20, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65,
+	0x2e, 0x64, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x2e, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x4e,
+	0x61, 0x6d, 0x65, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x12, 0x46, 0x0a, 0x10, 0x53,
+	0x65, 0x74, 0x44, 0x6e, 0x73, 0x53, 0x65, 0x61, 0x72, 0x63, 0x68, 0x50, 0x61, 0x74, 0x68, 0x12,
+	0x1a, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x64,
+	0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x2e, 0x50, 0x61, 0x74, 0x68, 0x73, 0x1a, 0x16, 0x2e, 0x67, 0x6f,
 	0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d,
-	0x70, 0x74, 0x79, 0x12, 0x46, 0x0a, 0x10, 0x53, 0x65, 0x74, 0x44, 0x6e, 0x73, 0x53, 0x65, 0x61,
-	0x72, 0x63, 0x68, 0x50, 0x61, 0x74, 0x68, 0x12, 0x1a, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72,
-	0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x64, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x2e, 0x50, 0x61,
-	0x74, 0x68, 0x73, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f,
-	0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x12, 0x4c, 0x0a, 0x0b, 0x53,
-	0x65, 0x74, 0x4c, 0x6f, 0x67, 0x4c, 0x65, 0x76, 0x65, 0x6c, 0x12, 0x25, 0x2e, 0x74, 0x65, 0x6c,
-	0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x6d, 0x61, 0x6e, 0x61, 0x67, 0x65,
-	0x72, 0x2e, 0x4c, 0x6f, 0x67, 0x4c, 0x65, 0x76, 0x65, 0x6c, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
-	0x74, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
-	0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x42, 0x36, 0x5a, 0x34, 0x67, 0x69, 0x74,
-	0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73,
-	0x65, 0x6e, 0x63, 0x65, 0x69, 0x6f, 0x2f, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65,
-	0x6e, 0x63, 0x65, 0x2f, 0x72, 0x70, 0x63, 0x2f, 0x76, 0x32, 0x2f, 0x64, 0x61, 0x65, 0x6d, 0x6f,
-	0x6e, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x70, 0x74, 0x79, 0x12, 0x54, 0x0a, 0x13, 0x53, 0x65, 0x74, 0x48, 0x65, 0x61, 0x64, 0x6c, 0x65,
+	0x73, 0x73, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x73, 0x12, 0x25, 0x2e, 0x74, 0x65, 0x6c,
+	0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x64, 0x61, 0x65, 0x6d, 0x6f, 0x6e,
+	0x2e, 0x48, 0x65, 0x61, 0x64, 0x6c, 0x65, 0x73, 0x73, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65,
+	0x73, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x12, 0x46, 0x0a, 0x0c, 0x53, 0x65, 0x74,
+	0x52, 0x6f, 0x75, 0x74, 0x65, 0x64, 0x49, 0x50, 0x73, 0x12, 0x1e, 0x2e, 0x74, 0x65, 0x6c, 0x65,
+	0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x64, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x2e,
+	0x52, 0x6f, 0x75, 0x74, 0x65, 0x64, 0x49, 0x50, 0x73, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67,
+	0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74,
+	0x79, 0x12, 0x4c, 0x0a, 0x0b, 0x53, 0x65, 0x74, 0x4c, 0x6f, 0x67, 0x4c, 0x65, 0x76, 0x65, 0x6c,
+	0x12, 0x25, 0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e,
+	0x6d, 0x61, 0x6e, 0x61, 0x67, 0x65, 0x72, 0x2e, 0x4c, 0x6f, 0x67, 0x4c, 0x65, 0x76, 0x65, 0x6c,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x42,
+	0x36, 0x5a, 0x34, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x74, 0x65,
+	0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x69, 0x6f, 0x2f, 0x74, 0x65, 0x6c,
+	0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2f, 0x72, 0x70, 0x63, 0x2f, 0x76, 0x32,
+	0x2f, 0x64, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -378,42 +1711,90 @@ func file_rpc_daemon_daemon_proto_rawDescGZIP() []byte {
 	return file_rpc_daemon_daemon_proto_rawDescData
 }
 
-var file_rpc_daemon_daemon_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_rpc_daemon_daemon_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_rpc_daemon_daemon_proto_msgTypes = make([]protoimpl.MessageInfo, 16)
 var file_rpc_daemon_daemon_proto_goTypes = []interface{}{
-	(*DaemonStatus)(nil),            // 0: telepresence.daemon.DaemonStatus
-	(*Paths)(nil),                   // 1: telepresence.daemon.Paths
-	(*DNSConfig)(nil),               // 2: telepresence.daemon.DNSConfig
-	(*OutboundInfo)(nil),            // 3: telepresence.daemon.OutboundInfo
-	(*durationpb.Duration)(nil),     // 4: google.protobuf.Duration
-	(*manager.SessionInfo)(nil),     // 5: telepresence.manager.SessionInfo
-	(*manager.IPNet)(nil),           // 6: telepresence.manager.IPNet
-	(*emptypb.Empty)(nil),           // 7: google.protobuf.Empty
-	(*manager.LogLevelRequest)(nil), // 8: telepresence.manager.LogLevelRequest
-	(*common.VersionInfo)(nil),      // 9: telepresence.common.VersionInfo
+	(SubnetConflict_Severity)(0),     // 0: telepresence.daemon.SubnetConflict.Severity
+	(RoutedSubnet_Source)(0),         // 1: telepresence.daemon.RoutedSubnet.Source
+	(*DaemonStatus)(nil),             // 2: telepresence.daemon.DaemonStatus
+	(*ConnectionStats)(nil),          // 3: telepresence.daemon.ConnectionStats
+	(*SessionConflict)(nil),          // 4: telepresence.daemon.SessionConflict
+	(*SubnetConflict)(nil),           // 5: telepresence.daemon.SubnetConflict
+	(*RoutedSubnet)(nil),             // 6: telepresence.daemon.RoutedSubnet
+	(*Paths)(nil),                    // 7: telepresence.daemon.Paths
+	(*HeadlessService)(nil),          // 8: telepresence.daemon.HeadlessService
+	(*HeadlessServices)(nil),         // 9: telepresence.daemon.HeadlessServices
+	(*RoutedIPs)(nil),                // 10: telepresence.daemon.RoutedIPs
+	(*DNSConfig)(nil),                // 11: telepresence.daemon.DNSConfig
+	(*OutboundInfo)(nil),             // 12: telepresence.daemon.OutboundInfo
+	(*DockerNetworkRoutes)(nil),      // 13: telepresence.daemon.DockerNetworkRoutes
+	(*GatewayRoute)(nil),             // 14: telepresence.daemon.GatewayRoute
+	(*SessionName)(nil),              // 15: telepresence.daemon.SessionName
+	(*HeadlessService_Port)(nil),     // 16: telepresence.daemon.HeadlessService.Port
+	(*HeadlessService_Endpoint)(nil), // 17: telepresence.daemon.HeadlessService.Endpoint
+	(*timestamppb.Timestamp)(nil),    // 18: google.protobuf.Timestamp
+	(*manager.IPNet)(nil),            // 19: telepresence.manager.IPNet
+	(*durationpb.Duration)(nil),      // 20: google.protobuf.Duration
+	(*manager.SessionInfo)(nil),      // 21: telepresence.manager.SessionInfo
+	(*emptypb.Empty)(nil),            // 22: google.protobuf.Empty
+	(*manager.LogLevelRequest)(nil),  // 23: telepresence.manager.LogLevelRequest
+	(*common.VersionInfo)(nil),       // 24: telepresence.common.VersionInfo
+	(*common.Traces)(nil),            // 25: telepresence.common.Traces
 }
 var file_rpc_daemon_daemon_proto_depIdxs = []int32{
-	3,  // 0: telepresence.daemon.DaemonStatus.outbound_config:type_name -> telepresence.daemon.OutboundInfo
-	4,  // 1: telepresence.daemon.DNSConfig.lookup_timeout:type_name -> google.protobuf.Duration
-	5,  // 2: telepresence.daemon.OutboundInfo.session:type_name -> telepresence.manager.SessionInfo
-	2,  // 3: telepresence.daemon.OutboundInfo.dns:type_name -> telepresence.daemon.DNSConfig
-	6,  // 4: telepresence.daemon.OutboundInfo.also_proxy_subnets:type_name -> telepresence.manager.IPNet
-	7,  // 5: telepresence.daemon.Daemon.Version:input_type -> google.protobuf.Empty
-	7,  // 6: telepresence.daemon.Daemon.Status:input_type -> google.protobuf.Empty
-	7,  // 7: telepresence.daemon.Daemon.Quit:input_type -> google.protobuf.Empty
-	3,  // 8: telepresence.daemon.Daemon.SetOutboundInfo:input_type -> telepresence.daemon.OutboundInfo
-	1,  // 9: telepresence.daemon.Daemon.SetDnsSearchPath:input_type -> telepresence.daemon.Paths
-	8,  // 10: telepresence.daemon.Daemon.SetLogLevel:input_type -> telepresence.manager.LogLevelRequest
-	9,  // 11: telepresence.daemon.Daemon.Version:output_type -> telepresence.common.VersionInfo
-	0,  // 12: telepresence.daemon.Daemon.Status:output_type -> telepresence.daemon.DaemonStatus
-	7,  // 13: telepresence.daemon.Daemon.Quit:output_type -> google.protobuf.Empty
-	7,  // 14: telepresence.daemon.Daemon.SetOutboundInfo:output_type -> google.protobuf.Empty
-	7,  // 15: telepresence.daemon.Daemon.SetDnsSearchPath:output_type -> google.protobuf.Empty
-	7,  // 16: telepresence.daemon.Daemon.SetLogLevel:output_type -> google.protobuf.Empty
-	11, // [11:17] is the sub-list for method output_type
-	5,  // [5:11] is the sub-list for method input_type
-	5,  // [5:5] is the sub-list for extension type_name
-	5,  // [5:5] is the sub-list for extension extendee
-	0,  // [0:5] is the sub-list for field type_name
+	12, // 0: telepresence.daemon.DaemonStatus.outbound_config:type_name -> telepresence.daemon.OutboundInfo
+	6,  // 1: telepresence.daemon.DaemonStatus.routed_subnets:type_name -> telepresence.daemon.RoutedSubnet
+	5,  // 2: telepresence.daemon.DaemonStatus.subnet_conflicts:type_name -> telepresence.daemon.SubnetConflict
+	18, // 3: telepresence.daemon.DaemonStatus.last_activity:type_name -> google.protobuf.Timestamp
+	4,  // 4: telepresence.daemon.DaemonStatus.session_conflicts:type_name -> telepresence.daemon.SessionConflict
+	14, // 5: telepresence.daemon.DaemonStatus.gateway_routes:type_name -> telepresence.daemon.GatewayRoute
+	3,  // 6: telepresence.daemon.DaemonStatus.connections:type_name -> telepresence.daemon.ConnectionStats
+	19, // 7: telepresence.daemon.SessionConflict.subnet:type_name -> telepresence.manager.IPNet
+	19, // 8: telepresence.daemon.SessionConflict.other_subnet:type_name -> telepresence.manager.IPNet
+	19, // 9: telepresence.daemon.SubnetConflict.subnet:type_name -> telepresence.manager.IPNet
+	19, // 10: telepresence.daemon.SubnetConflict.route:type_name -> telepresence.manager.IPNet
+	0,  // 11: telepresence.daemon.SubnetConflict.severity:type_name -> telepresence.daemon.SubnetConflict.Severity
+	19, // 12: telepresence.daemon.RoutedSubnet.subnet:type_name -> telepresence.manager.IPNet
+	1,  // 13: telepresence.daemon.RoutedSubnet.source:type_name -> telepresence.daemon.RoutedSubnet.Source
+	16, // 14: telepresence.daemon.HeadlessService.ports:type_name -> telepresence.daemon.HeadlessService.Port
+	17, // 15: telepresence.daemon.HeadlessService.endpoints:type_name -> telepresence.daemon.HeadlessService.Endpoint
+	8,  // 16: telepresence.daemon.HeadlessServices.services:type_name -> telepresence.daemon.HeadlessService
+	20, // 17: telepresence.daemon.DNSConfig.lookup_timeout:type_name -> google.protobuf.Duration
+	20, // 18: telepresence.daemon.DNSConfig.negative_cache_ttl:type_name -> google.protobuf.Duration
+	20, // 19: telepresence.daemon.DNSConfig.max_ttl:type_name -> google.protobuf.Duration
+	21, // 20: telepresence.daemon.OutboundInfo.session:type_name -> telepresence.manager.SessionInfo
+	11, // 21: telepresence.daemon.OutboundInfo.dns:type_name -> telepresence.daemon.DNSConfig
+	19, // 22: telepresence.daemon.OutboundInfo.also_proxy_subnets:type_name -> telepresence.manager.IPNet
+	19, // 23: telepresence.daemon.OutboundInfo.never_proxy_subnets:type_name -> telepresence.manager.IPNet
+	19, // 24: telepresence.daemon.OutboundInfo.allow_conflicting_subnets:type_name -> telepresence.manager.IPNet
+	13, // 25: telepresence.daemon.OutboundInfo.docker_network:type_name -> telepresence.daemon.DockerNetworkRoutes
+	14, // 26: telepresence.daemon.DockerNetworkRoutes.node_routes:type_name -> telepresence.daemon.GatewayRoute
+	19, // 27: telepresence.daemon.GatewayRoute.subnet:type_name -> telepresence.manager.IPNet
+	22, // 28: telepresence.daemon.Daemon.Version:input_type -> google.protobuf.Empty
+	22, // 29: telepresence.daemon.Daemon.Status:input_type -> google.protobuf.Empty
+	22, // 30: telepresence.daemon.Daemon.Quit:input_type -> google.protobuf.Empty
+	22, // 31: telepresence.daemon.Daemon.GatherTraces:input_type -> google.protobuf.Empty
+	12, // 32: telepresence.daemon.Daemon.SetOutboundInfo:input_type -> telepresence.daemon.OutboundInfo
+	15, // 33: telepresence.daemon.Daemon.RemoveSession:input_type -> telepresence.daemon.SessionName
+	7,  // 34: telepresence.daemon.Daemon.SetDnsSearchPath:input_type -> telepresence.daemon.Paths
+	9,  // 35: telepresence.daemon.Daemon.SetHeadlessServices:input_type -> telepresence.daemon.HeadlessServices
+	10, // 36: telepresence.daemon.Daemon.SetRoutedIPs:input_type -> telepresence.daemon.RoutedIPs
+	23, // 37: telepresence.daemon.Daemon.SetLogLevel:input_type -> telepresence.manager.LogLevelRequest
+	24, // 38: telepresence.daemon.Daemon.Version:output_type -> telepresence.common.VersionInfo
+	2,  // 39: telepresence.daemon.Daemon.Status:output_type -> telepresence.daemon.DaemonStatus
+	22, // 40: telepresence.daemon.Daemon.Quit:output_type -> google.protobuf.Empty
+	25, // 41: telepresence.daemon.Daemon.GatherTraces:output_type -> telepresence.common.Traces
+	22, // 42: telepresence.daemon.Daemon.SetOutboundInfo:output_type -> google.protobuf.Empty
+	22, // 43: telepresence.daemon.Daemon.RemoveSession:output_type -> google.protobuf.Empty
+	22, // 44: telepresence.daemon.Daemon.SetDnsSearchPath:output_type -> google.protobuf.Empty
+	22, // 45: telepresence.daemon.Daemon.SetHeadlessServices:output_type -> google.protobuf.Empty
+	22, // 46: telepresence.daemon.Daemon.SetRoutedIPs:output_type -> google.protobuf.Empty
+	22, // 47: telepresence.daemon.Daemon.SetLogLevel:output_type -> google.protobuf.Empty
+	38, // [38:48] is the sub-list for method output_type
+	28, // [28:38] is the sub-list for method input_type
+	28, // [28:28] is the sub-list for extension type_name
+	28, // [28:28] is the sub-list for extension extendee
+	0,  // [0:28] is the sub-list for field type_name
 }
 
 func init() { file_rpc_daemon_daemon_proto_init() }
@@ -435,7 +1816,7 @@ func file_rpc_daemon_daemon_proto_init() {
 			}
 		}
 		file_rpc_daemon_daemon_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Paths); i {
+			switch v := v.(*ConnectionStats); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -447,7 +1828,7 @@ func file_rpc_daemon_daemon_proto_init() {
 			}
 		}
 		file_rpc_daemon_daemon_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*DNSConfig); i {
+			switch v := v.(*SessionConflict); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -459,6 +1840,90 @@ func file_rpc_daemon_daemon_proto_init() {
 			}
 		}
 		file_rpc_daemon_daemon_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SubnetConflict); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_daemon_daemon_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RoutedSubnet); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_daemon_daemon_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Paths); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_daemon_daemon_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*HeadlessService); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_daemon_daemon_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*HeadlessServices); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_daemon_daemon_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RoutedIPs); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_daemon_daemon_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DNSConfig); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_daemon_daemon_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*OutboundInfo); i {
 			case 0:
 				return &v.state
@@ -470,19 +1935,80 @@ func file_rpc_daemon_daemon_proto_init() {
 				return nil
 			}
 		}
+		file_rpc_daemon_daemon_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DockerNetworkRoutes); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_daemon_daemon_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GatewayRoute); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_daemon_daemon_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SessionName); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_daemon_daemon_proto_msgTypes[14].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*HeadlessService_Port); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_rpc_daemon_daemon_proto_msgTypes[15].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*HeadlessService_Endpoint); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_rpc_daemon_daemon_proto_rawDesc,
-			NumEnums:      0,
-			NumMessages:   4,
+			NumEnums:      2,
+			NumMessages:   16,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_rpc_daemon_daemon_proto_goTypes,
 		DependencyIndexes: file_rpc_daemon_daemon_proto_depIdxs,
+		EnumInfos:         file_rpc_daemon_daemon_proto_enumTypes,
 		MessageInfos:      file_rpc_daemon_daemon_proto_msgTypes,
 	}.Build()
 	File_rpc_daemon_daemon_proto = out.File
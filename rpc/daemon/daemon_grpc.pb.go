@@ -27,10 +27,23 @@ type DaemonClient interface {
 	Status(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*DaemonStatus, error)
 	// Quit quits (terminates) the service.
 	Quit(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// GatherTraces returns the trace spans that the daemon has buffered.
+	GatherTraces(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*common.Traces, error)
 	// SetOutboundInfo provides the information needed to set up outbound connectivity
 	SetOutboundInfo(ctx context.Context, in *OutboundInfo, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// RemoveSession removes the routes and DNS configuration of the named session
+	// and leaves those of other sessions in place.
+	RemoveSession(ctx context.Context, in *SessionName, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	// SetDnsSearchPath sets a new search path.
 	SetDnsSearchPath(ctx context.Context, in *Paths, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// SetHeadlessServices replaces the headless services that the DNS resolver answers queries
+	// for, so that the service names resolve to the IPs of their pods, and names of the form
+	// <pod>.<service>.<namespace> resolve to the IPs of individual pods.
+	SetHeadlessServices(ctx context.Context, in *HeadlessServices, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// SetRoutedIPs replaces the IPs that are routed to the cluster of a session
+	// whose outbound traffic policy is "mappedNamespaces". The routes are
+	// updated in batches, so the call returns before they are.
+	SetRoutedIPs(ctx context.Context, in *RoutedIPs, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	// SetLogLevel will temporarily set the log-level for the daemon for a duration that is determined b the request.
 	SetLogLevel(ctx context.Context, in *manager.LogLevelRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
 }
@@ -70,6 +83,15 @@ func (c *daemonClient) Quit(ctx context.Context, in *emptypb.Empty, opts ...grpc
 	return out, nil
 }
 
+func (c *daemonClient) GatherTraces(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*common.Traces, error) {
+	out := new(common.Traces)
+	err := c.cc.Invoke(ctx, "/telepresence.daemon.Daemon/GatherTraces", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *daemonClient) SetOutboundInfo(ctx context.Context, in *OutboundInfo, opts ...grpc.CallOption) (*emptypb.Empty, error) {
 	out := new(emptypb.Empty)
 	err := c.cc.Invoke(ctx, "/telepresence.daemon.Daemon/SetOutboundInfo", in, out, opts...)
@@ -79,6 +101,15 @@ func (c *daemonClient) SetOutboundInfo(ctx context.Context, in *OutboundInfo, op
 	return out, nil
 }
 
+func (c *daemonClient) RemoveSession(ctx context.Context, in *SessionName, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/telepresence.daemon.Daemon/RemoveSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *daemonClient) SetDnsSearchPath(ctx context.Context, in *Paths, opts ...grpc.CallOption) (*emptypb.Empty, error) {
 	out := new(emptypb.Empty)
 	err := c.cc.Invoke(ctx, "/telepresence.daemon.Daemon/SetDnsSearchPath", in, out, opts...)
@@ -88,6 +119,24 @@ func (c *daemonClient) SetDnsSearchPath(ctx context.Context, in *Paths, opts ...
 	return out, nil
 }
 
+func (c *daemonClient) SetHeadlessServices(ctx context.Context, in *HeadlessServices, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/telepresence.daemon.Daemon/SetHeadlessServices", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *daemonClient) SetRoutedIPs(ctx context.Context, in *RoutedIPs, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/telepresence.daemon.Daemon/SetRoutedIPs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *daemonClient) SetLogLevel(ctx context.Context, in *manager.LogLevelRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
 	out := new(emptypb.Empty)
 	err := c.cc.Invoke(ctx, "/telepresence.daemon.Daemon/SetLogLevel", in, out, opts...)
@@ -107,10 +156,23 @@ type DaemonServer interface {
 	Status(context.Context, *emptypb.Empty) (*DaemonStatus, error)
 	// Quit quits (terminates) the service.
 	Quit(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
+	// GatherTraces returns the trace spans that the daemon has buffered.
+	GatherTraces(context.Context, *emptypb.Empty) (*common.Traces, error)
 	// SetOutboundInfo provides the information needed to set up outbound connectivity
 	SetOutboundInfo(context.Context, *OutboundInfo) (*emptypb.Empty, error)
+	// RemoveSession removes the routes and DNS configuration of the named session
+	// and leaves those of other sessions in place.
+	RemoveSession(context.Context, *SessionName) (*emptypb.Empty, error)
 	// SetDnsSearchPath sets a new search path.
 	SetDnsSearchPath(context.Context, *Paths) (*emptypb.Empty, error)
+	// SetHeadlessServices replaces the headless services that the DNS resolver answers queries
+	// for, so that the service names resolve to the IPs of their pods, and names of the form
+	// <pod>.<service>.<namespace> resolve to the IPs of individual pods.
+	SetHeadlessServices(context.Context, *HeadlessServices) (*emptypb.Empty, error)
+	// SetRoutedIPs replaces the IPs that are routed to the cluster of a session
+	// whose outbound traffic policy is "mappedNamespaces". The routes are
+	// updated in batches, so the call returns before they are.
+	SetRoutedIPs(context.Context, *RoutedIPs) (*emptypb.Empty, error)
 	// SetLogLevel will temporarily set the log-level for the daemon for a duration that is determined b the request.
 	SetLogLevel(context.Context, *manager.LogLevelRequest) (*emptypb.Empty, error)
 	mustEmbedUnimplementedDaemonServer()
@@ -129,12 +191,24 @@ func (UnimplementedDaemonServer) Status(context.Context, *emptypb.Empty) (*Daemo
 func (UnimplementedDaemonServer) Quit(context.Context, *emptypb.Empty) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Quit not implemented")
 }
+func (UnimplementedDaemonServer) GatherTraces(context.Context, *emptypb.Empty) (*common.Traces, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GatherTraces not implemented")
+}
 func (UnimplementedDaemonServer) SetOutboundInfo(context.Context, *OutboundInfo) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SetOutboundInfo not implemented")
 }
+func (UnimplementedDaemonServer) RemoveSession(context.Context, *SessionName) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveSession not implemented")
+}
 func (UnimplementedDaemonServer) SetDnsSearchPath(context.Context, *Paths) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SetDnsSearchPath not implemented")
 }
+func (UnimplementedDaemonServer) SetHeadlessServices(context.Context, *HeadlessServices) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetHeadlessServices not implemented")
+}
+func (UnimplementedDaemonServer) SetRoutedIPs(context.Context, *RoutedIPs) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetRoutedIPs not implemented")
+}
 func (UnimplementedDaemonServer) SetLogLevel(context.Context, *manager.LogLevelRequest) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SetLogLevel not implemented")
 }
@@ -205,6 +279,24 @@ func _Daemon_Quit_Handler(srv interface{}, ctx context.Context, dec func(interfa
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Daemon_GatherTraces_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DaemonServer).GatherTraces(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.daemon.Daemon/GatherTraces",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DaemonServer).GatherTraces(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Daemon_SetOutboundInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(OutboundInfo)
 	if err := dec(in); err != nil {
@@ -223,6 +315,24 @@ func _Daemon_SetOutboundInfo_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Daemon_RemoveSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionName)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DaemonServer).RemoveSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.daemon.Daemon/RemoveSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DaemonServer).RemoveSession(ctx, req.(*SessionName))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Daemon_SetDnsSearchPath_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(Paths)
 	if err := dec(in); err != nil {
@@ -241,6 +351,42 @@ func _Daemon_SetDnsSearchPath_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Daemon_SetHeadlessServices_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeadlessServices)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DaemonServer).SetHeadlessServices(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.daemon.Daemon/SetHeadlessServices",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DaemonServer).SetHeadlessServices(ctx, req.(*HeadlessServices))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Daemon_SetRoutedIPs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RoutedIPs)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DaemonServer).SetRoutedIPs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.daemon.Daemon/SetRoutedIPs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DaemonServer).SetRoutedIPs(ctx, req.(*RoutedIPs))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Daemon_SetLogLevel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(manager.LogLevelRequest)
 	if err := dec(in); err != nil {
@@ -278,14 +424,30 @@ var Daemon_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Quit",
 			Handler:    _Daemon_Quit_Handler,
 		},
+		{
+			MethodName: "GatherTraces",
+			Handler:    _Daemon_GatherTraces_Handler,
+		},
 		{
 			MethodName: "SetOutboundInfo",
 			Handler:    _Daemon_SetOutboundInfo_Handler,
 		},
+		{
+			MethodName: "RemoveSession",
+			Handler:    _Daemon_RemoveSession_Handler,
+		},
 		{
 			MethodName: "SetDnsSearchPath",
 			Handler:    _Daemon_SetDnsSearchPath_Handler,
 		},
+		{
+			MethodName: "SetHeadlessServices",
+			Handler:    _Daemon_SetHeadlessServices_Handler,
+		},
+		{
+			MethodName: "SetRoutedIPs",
+			Handler:    _Daemon_SetRoutedIPs_Handler,
+		},
 		{
 			MethodName: "SetLogLevel",
 			Handler:    _Daemon_SetLogLevel_Handler,
@@ -0,0 +1,196 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.27.1
+// 	protoc        v3.17.3
+// source: rpc/helper/helper.proto
+
+package helper
+
+import (
+	common "github.com/telepresenceio/telepresence/rpc/v2/common"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type StartDaemonRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// The IP of the DNS server that the root daemon uses, if any
+	Dns string `protobuf:"bytes,4,opt,name=dns,proto3" json:"dns,omitempty"`
+	// The address of the user daemon of the calling user
+	UserDaemonAddress string `protobuf:"bytes,5,opt,name=user_daemon_address,json=userDaemonAddress,proto3" json:"user_daemon_address,omitempty"`
+	// True when the calling user disabled telemetry using the SCOUT_DISABLE
+	// environment variable
+	DisableTelemetry bool `protobuf:"varint,6,opt,name=disable_telemetry,json=disableTelemetry,proto3" json:"disable_telemetry,omitempty"`
+}
+
+func (x *StartDaemonRequest) Reset() {
+	*x = StartDaemonRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_rpc_helper_helper_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StartDaemonRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StartDaemonRequest) ProtoMessage() {}
+
+func (x *StartDaemonRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_rpc_helper_helper_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StartDaemonRequest.ProtoReflect.Descriptor instead.
+func (*StartDaemonRequest) Descriptor() ([]byte, []int) {
+	return file_rpc_helper_helper_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *StartDaemonRequest) GetDns() string {
+	if x != nil {
+		return x.Dns
+	}
+	return ""
+}
+
+func (x *StartDaemonRequest) GetUserDaemonAddress() string {
+	if x != nil {
+		return x.UserDaemonAddress
+	}
+	return ""
+}
+
+func (x *StartDaemonRequest) GetDisableTelemetry() bool {
+	if x != nil {
+		return x.DisableTelemetry
+	}
+	return false
+}
+
+var File_rpc_helper_helper_proto protoreflect.FileDescriptor
+
+var file_rpc_helper_helper_proto_rawDesc = []byte{
+	0x0a, 0x17, 0x72, 0x70, 0x63, 0x2f, 0x68, 0x65, 0x6c, 0x70, 0x65, 0x72, 0x2f, 0x68, 0x65, 0x6c,
+	0x70, 0x65, 0x72, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x13, 0x74, 0x65, 0x6c, 0x65, 0x70,
+	0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x68, 0x65, 0x6c, 0x70, 0x65, 0x72, 0x1a, 0x1b,
+	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f,
+	0x65, 0x6d, 0x70, 0x74, 0x79, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x18, 0x72, 0x70, 0x63,
+	0x2f, 0x63, 0x6f, 0x6d, 0x6d, 0x6f, 0x6e, 0x2f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xb7, 0x01, 0x0a, 0x12, 0x53, 0x74, 0x61, 0x72, 0x74, 0x44,
+	0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03,
+	0x64, 0x6e, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x64, 0x6e, 0x73, 0x12, 0x2e,
+	0x0a, 0x13, 0x75, 0x73, 0x65, 0x72, 0x5f, 0x64, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x5f, 0x61, 0x64,
+	0x64, 0x72, 0x65, 0x73, 0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x11, 0x75, 0x73, 0x65,
+	0x72, 0x44, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x12, 0x2b,
+	0x0a, 0x11, 0x64, 0x69, 0x73, 0x61, 0x62, 0x6c, 0x65, 0x5f, 0x74, 0x65, 0x6c, 0x65, 0x6d, 0x65,
+	0x74, 0x72, 0x79, 0x18, 0x06, 0x20, 0x01, 0x28, 0x08, 0x52, 0x10, 0x64, 0x69, 0x73, 0x61, 0x62,
+	0x6c, 0x65, 0x54, 0x65, 0x6c, 0x65, 0x6d, 0x65, 0x74, 0x72, 0x79, 0x4a, 0x04, 0x08, 0x01, 0x10,
+	0x02, 0x4a, 0x04, 0x08, 0x02, 0x10, 0x03, 0x4a, 0x04, 0x08, 0x03, 0x10, 0x04, 0x52, 0x07, 0x6c,
+	0x6f, 0x67, 0x5f, 0x64, 0x69, 0x72, 0x52, 0x0a, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x5f, 0x64,
+	0x69, 0x72, 0x52, 0x0b, 0x72, 0x75, 0x6e, 0x74, 0x69, 0x6d, 0x65, 0x5f, 0x64, 0x69, 0x72, 0x32,
+	0x9d, 0x01, 0x0a, 0x06, 0x48, 0x65, 0x6c, 0x70, 0x65, 0x72, 0x12, 0x43, 0x0a, 0x07, 0x56, 0x65,
+	0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x20, 0x2e,
+	0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x63, 0x6f, 0x6d,
+	0x6d, 0x6f, 0x6e, 0x2e, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x6e, 0x66, 0x6f, 0x12,
+	0x4e, 0x0a, 0x0b, 0x53, 0x74, 0x61, 0x72, 0x74, 0x44, 0x61, 0x65, 0x6d, 0x6f, 0x6e, 0x12, 0x27,
+	0x2e, 0x74, 0x65, 0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2e, 0x68, 0x65,
+	0x6c, 0x70, 0x65, 0x72, 0x2e, 0x53, 0x74, 0x61, 0x72, 0x74, 0x44, 0x61, 0x65, 0x6d, 0x6f, 0x6e,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x42,
+	0x36, 0x5a, 0x34, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x74, 0x65,
+	0x6c, 0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x69, 0x6f, 0x2f, 0x74, 0x65, 0x6c,
+	0x65, 0x70, 0x72, 0x65, 0x73, 0x65, 0x6e, 0x63, 0x65, 0x2f, 0x72, 0x70, 0x63, 0x2f, 0x76, 0x32,
+	0x2f, 0x68, 0x65, 0x6c, 0x70, 0x65, 0x72, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_rpc_helper_helper_proto_rawDescOnce sync.Once
+	file_rpc_helper_helper_proto_rawDescData = file_rpc_helper_helper_proto_rawDesc
+)
+
+func file_rpc_helper_helper_proto_rawDescGZIP() []byte {
+	file_rpc_helper_helper_proto_rawDescOnce.Do(func() {
+		file_rpc_helper_helper_proto_rawDescData = protoimpl.X.CompressGZIP(file_rpc_helper_helper_proto_rawDescData)
+	})
+	return file_rpc_helper_helper_proto_rawDescData
+}
+
+var file_rpc_helper_helper_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_rpc_helper_helper_proto_goTypes = []interface{}{
+	(*StartDaemonRequest)(nil), // 0: telepresence.helper.StartDaemonRequest
+	(*emptypb.Empty)(nil),      // 1: google.protobuf.Empty
+	(*common.VersionInfo)(nil), // 2: telepresence.common.VersionInfo
+}
+var file_rpc_helper_helper_proto_depIdxs = []int32{
+	1, // 0: telepresence.helper.Helper.Version:input_type -> google.protobuf.Empty
+	0, // 1: telepresence.helper.Helper.StartDaemon:input_type -> telepresence.helper.StartDaemonRequest
+	2, // 2: telepresence.helper.Helper.Version:output_type -> telepresence.common.VersionInfo
+	1, // 3: telepresence.helper.Helper.StartDaemon:output_type -> google.protobuf.Empty
+	2, // [2:4] is the sub-list for method output_type
+	0, // [0:2] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_rpc_helper_helper_proto_init() }
+func file_rpc_helper_helper_proto_init() {
+	if File_rpc_helper_helper_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_rpc_helper_helper_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StartDaemonRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_rpc_helper_helper_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_rpc_helper_helper_proto_goTypes,
+		DependencyIndexes: file_rpc_helper_helper_proto_depIdxs,
+		MessageInfos:      file_rpc_helper_helper_proto_msgTypes,
+	}.Build()
+	File_rpc_helper_helper_proto = out.File
+	file_rpc_helper_helper_proto_rawDesc = nil
+	file_rpc_helper_helper_proto_goTypes = nil
+	file_rpc_helper_helper_proto_depIdxs = nil
+}
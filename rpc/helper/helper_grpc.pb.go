@@ -0,0 +1,143 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package helper
+
+import (
+	context "context"
+	common "github.com/telepresenceio/telepresence/rpc/v2/common"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// HelperClient is the client API for Helper service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type HelperClient interface {
+	// Version returns version information from the Helper
+	Version(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*common.VersionInfo, error)
+	// StartDaemon starts the root daemon for the calling user
+	StartDaemon(ctx context.Context, in *StartDaemonRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+}
+
+type helperClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewHelperClient(cc grpc.ClientConnInterface) HelperClient {
+	return &helperClient{cc}
+}
+
+func (c *helperClient) Version(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*common.VersionInfo, error) {
+	out := new(common.VersionInfo)
+	err := c.cc.Invoke(ctx, "/telepresence.helper.Helper/Version", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *helperClient) StartDaemon(ctx context.Context, in *StartDaemonRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/telepresence.helper.Helper/StartDaemon", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// HelperServer is the server API for Helper service.
+// All implementations must embed UnimplementedHelperServer
+// for forward compatibility
+type HelperServer interface {
+	// Version returns version information from the Helper
+	Version(context.Context, *emptypb.Empty) (*common.VersionInfo, error)
+	// StartDaemon starts the root daemon for the calling user
+	StartDaemon(context.Context, *StartDaemonRequest) (*emptypb.Empty, error)
+	mustEmbedUnimplementedHelperServer()
+}
+
+// UnimplementedHelperServer must be embedded to have forward compatible implementations.
+type UnimplementedHelperServer struct {
+}
+
+func (UnimplementedHelperServer) Version(context.Context, *emptypb.Empty) (*common.VersionInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Version not implemented")
+}
+func (UnimplementedHelperServer) StartDaemon(context.Context, *StartDaemonRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartDaemon not implemented")
+}
+func (UnimplementedHelperServer) mustEmbedUnimplementedHelperServer() {}
+
+// UnsafeHelperServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to HelperServer will
+// result in compilation errors.
+type UnsafeHelperServer interface {
+	mustEmbedUnimplementedHelperServer()
+}
+
+func RegisterHelperServer(s grpc.ServiceRegistrar, srv HelperServer) {
+	s.RegisterService(&Helper_ServiceDesc, srv)
+}
+
+func _Helper_Version_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HelperServer).Version(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.helper.Helper/Version",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HelperServer).Version(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Helper_StartDaemon_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartDaemonRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HelperServer).StartDaemon(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.helper.Helper/StartDaemon",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HelperServer).StartDaemon(ctx, req.(*StartDaemonRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Helper_ServiceDesc is the grpc.ServiceDesc for Helper service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Helper_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "telepresence.helper.Helper",
+	HandlerType: (*HelperServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Version",
+			Handler:    _Helper_Version_Handler,
+		},
+		{
+			MethodName: "StartDaemon",
+			Handler:    _Helper_StartDaemon_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "rpc/helper/helper.proto",
+}
@@ -4,6 +4,7 @@ package manager
 
 import (
 	context "context"
+	common "github.com/telepresenceio/telepresence/rpc/v2/common"
 	grpc "google.golang.org/grpc"
 	codes "google.golang.org/grpc/codes"
 	status "google.golang.org/grpc/status"
@@ -30,6 +31,13 @@ type ManagerClient interface {
 	// GetCloudConfig returns the config (host + port) for Ambassador Cloud for use
 	// by the agents.
 	GetCloudConfig(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*AmbassadorCloudConfig, error)
+	// GetAgentImage returns the image that the traffic-manager uses for the
+	// traffic-agents that it injects.
+	GetAgentImage(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*AgentImage, error)
+	// GetAgentConfig returns the default configuration of the traffic-agents
+	// that the traffic-manager injects, so that the client installs the
+	// traffic-agents with the same configuration.
+	GetAgentConfig(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*AgentConfig, error)
 	// ArriveAsClient establishes a session between a client and the Manager.
 	ArriveAsClient(ctx context.Context, in *ClientInfo, opts ...grpc.CallOption) (*SessionInfo, error)
 	// ArriveAsAgent establishes a session between an agent and the Manager.
@@ -39,12 +47,27 @@ type ManagerClient interface {
 	Remain(ctx context.Context, in *RemainRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	// Depart terminates a session.
 	Depart(ctx context.Context, in *SessionInfo, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// GetClients returns the clients that currently have a session with the Manager.
+	GetClients(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ClientInfoSnapshot, error)
 	// SetLogLevel will temporarily set the log-level for the traffic-manager and all
 	// traffic-agents for a duration that is determined b the request.
 	SetLogLevel(ctx context.Context, in *LogLevelRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// SetRemoteLogLevel temporarily sets the log-level of the traffic-manager and
+	// of the traffic-agents of the given workloads. The traffic-manager resets the
+	// log-levels when the duration expires, also when the caller is gone.
+	SetRemoteLogLevel(ctx context.Context, in *RemoteLogLevelRequest, opts ...grpc.CallOption) (*RemoteLogLevels, error)
+	// GetRemoteLogLevels returns the temporary log-levels of the traffic-manager
+	// and the traffic-agents.
+	GetRemoteLogLevels(ctx context.Context, in *SessionInfo, opts ...grpc.CallOption) (*RemoteLogLevels, error)
 	// GetLogs will acquire logs for the various Telepresence components in kubernetes
 	// (pending the request) and return them to the caller
 	GetLogs(ctx context.Context, in *GetLogsRequest, opts ...grpc.CallOption) (*LogsResponse, error)
+	// StreamLogs streams the logs of the traffic-manager, or of the
+	// traffic-agents of a workload. The logs of the traffic-agents of a
+	// workload can only be streamed by a client that could intercept it.
+	StreamLogs(ctx context.Context, in *StreamLogsRequest, opts ...grpc.CallOption) (Manager_StreamLogsClient, error)
+	// GatherTraces returns the trace spans that the traffic-manager has buffered.
+	GatherTraces(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*common.Traces, error)
 	// WatchAgents notifies a client of the set of known Agents.
 	//
 	// A session ID is required; if no session ID is given then the call
@@ -75,6 +98,9 @@ type ManagerClient interface {
 	// changing the disposition from "WATING" to "ACTIVE" or to an
 	// error, and setting a human-readable status message.
 	ReviewIntercept(ctx context.Context, in *ReviewInterceptRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// GetAgentTLSCertificate lets an agent get the certificate of the
+	// agent_tls_secret of an intercept of its workload.
+	GetAgentTLSCertificate(ctx context.Context, in *AgentTLSCertificateRequest, opts ...grpc.CallOption) (*AgentTLSCertificate, error)
 	// ClientTunnel receives messages from the client and dispatches them to tracked
 	// net.Conn instances in the traffic-manager. Responses from tracked instances
 	// are sent back on the returned message stream
@@ -92,6 +118,10 @@ type ManagerClient interface {
 	WatchLookupHost(ctx context.Context, in *SessionInfo, opts ...grpc.CallOption) (Manager_WatchLookupHostClient, error)
 	// WatchLogLevel lets an agent receive log-level updates
 	WatchLogLevel(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (Manager_WatchLogLevelClient, error)
+	// WatchAgentLogLevel lets an agent receive the log-levels that are set for it
+	// using SetRemoteLogLevel. An empty log-level resets it. Agents that don't
+	// watch them can't get their own log-level.
+	WatchAgentLogLevel(ctx context.Context, in *SessionInfo, opts ...grpc.CallOption) (Manager_WatchAgentLogLevelClient, error)
 	// A Tunnel represents one single connection where the client or
 	// traffic-agent represents one end (the client-side) and the
 	// traffic-manager represents the other (the server side). The first
@@ -154,6 +184,24 @@ func (c *managerClient) GetCloudConfig(ctx context.Context, in *emptypb.Empty, o
 	return out, nil
 }
 
+func (c *managerClient) GetAgentImage(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*AgentImage, error) {
+	out := new(AgentImage)
+	err := c.cc.Invoke(ctx, "/telepresence.manager.Manager/GetAgentImage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managerClient) GetAgentConfig(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*AgentConfig, error) {
+	out := new(AgentConfig)
+	err := c.cc.Invoke(ctx, "/telepresence.manager.Manager/GetAgentConfig", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *managerClient) ArriveAsClient(ctx context.Context, in *ClientInfo, opts ...grpc.CallOption) (*SessionInfo, error) {
 	out := new(SessionInfo)
 	err := c.cc.Invoke(ctx, "/telepresence.manager.Manager/ArriveAsClient", in, out, opts...)
@@ -190,6 +238,15 @@ func (c *managerClient) Depart(ctx context.Context, in *SessionInfo, opts ...grp
 	return out, nil
 }
 
+func (c *managerClient) GetClients(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ClientInfoSnapshot, error) {
+	out := new(ClientInfoSnapshot)
+	err := c.cc.Invoke(ctx, "/telepresence.manager.Manager/GetClients", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *managerClient) SetLogLevel(ctx context.Context, in *LogLevelRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
 	out := new(emptypb.Empty)
 	err := c.cc.Invoke(ctx, "/telepresence.manager.Manager/SetLogLevel", in, out, opts...)
@@ -199,6 +256,24 @@ func (c *managerClient) SetLogLevel(ctx context.Context, in *LogLevelRequest, op
 	return out, nil
 }
 
+func (c *managerClient) SetRemoteLogLevel(ctx context.Context, in *RemoteLogLevelRequest, opts ...grpc.CallOption) (*RemoteLogLevels, error) {
+	out := new(RemoteLogLevels)
+	err := c.cc.Invoke(ctx, "/telepresence.manager.Manager/SetRemoteLogLevel", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managerClient) GetRemoteLogLevels(ctx context.Context, in *SessionInfo, opts ...grpc.CallOption) (*RemoteLogLevels, error) {
+	out := new(RemoteLogLevels)
+	err := c.cc.Invoke(ctx, "/telepresence.manager.Manager/GetRemoteLogLevels", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *managerClient) GetLogs(ctx context.Context, in *GetLogsRequest, opts ...grpc.CallOption) (*LogsResponse, error) {
 	out := new(LogsResponse)
 	err := c.cc.Invoke(ctx, "/telepresence.manager.Manager/GetLogs", in, out, opts...)
@@ -208,8 +283,49 @@ func (c *managerClient) GetLogs(ctx context.Context, in *GetLogsRequest, opts ..
 	return out, nil
 }
 
+func (c *managerClient) StreamLogs(ctx context.Context, in *StreamLogsRequest, opts ...grpc.CallOption) (Manager_StreamLogsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Manager_ServiceDesc.Streams[0], "/telepresence.manager.Manager/StreamLogs", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &managerStreamLogsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Manager_StreamLogsClient interface {
+	Recv() (*LogChunk, error)
+	grpc.ClientStream
+}
+
+type managerStreamLogsClient struct {
+	grpc.ClientStream
+}
+
+func (x *managerStreamLogsClient) Recv() (*LogChunk, error) {
+	m := new(LogChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *managerClient) GatherTraces(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*common.Traces, error) {
+	out := new(common.Traces)
+	err := c.cc.Invoke(ctx, "/telepresence.manager.Manager/GatherTraces", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *managerClient) WatchAgents(ctx context.Context, in *SessionInfo, opts ...grpc.CallOption) (Manager_WatchAgentsClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Manager_ServiceDesc.Streams[0], "/telepresence.manager.Manager/WatchAgents", opts...)
+	stream, err := c.cc.NewStream(ctx, &Manager_ServiceDesc.Streams[1], "/telepresence.manager.Manager/WatchAgents", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -241,7 +357,7 @@ func (x *managerWatchAgentsClient) Recv() (*AgentInfoSnapshot, error) {
 }
 
 func (c *managerClient) WatchIntercepts(ctx context.Context, in *SessionInfo, opts ...grpc.CallOption) (Manager_WatchInterceptsClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Manager_ServiceDesc.Streams[1], "/telepresence.manager.Manager/WatchIntercepts", opts...)
+	stream, err := c.cc.NewStream(ctx, &Manager_ServiceDesc.Streams[2], "/telepresence.manager.Manager/WatchIntercepts", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -273,7 +389,7 @@ func (x *managerWatchInterceptsClient) Recv() (*InterceptInfoSnapshot, error) {
 }
 
 func (c *managerClient) WatchClusterInfo(ctx context.Context, in *SessionInfo, opts ...grpc.CallOption) (Manager_WatchClusterInfoClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Manager_ServiceDesc.Streams[2], "/telepresence.manager.Manager/WatchClusterInfo", opts...)
+	stream, err := c.cc.NewStream(ctx, &Manager_ServiceDesc.Streams[3], "/telepresence.manager.Manager/WatchClusterInfo", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -349,8 +465,17 @@ func (c *managerClient) ReviewIntercept(ctx context.Context, in *ReviewIntercept
 	return out, nil
 }
 
+func (c *managerClient) GetAgentTLSCertificate(ctx context.Context, in *AgentTLSCertificateRequest, opts ...grpc.CallOption) (*AgentTLSCertificate, error) {
+	out := new(AgentTLSCertificate)
+	err := c.cc.Invoke(ctx, "/telepresence.manager.Manager/GetAgentTLSCertificate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *managerClient) ClientTunnel(ctx context.Context, opts ...grpc.CallOption) (Manager_ClientTunnelClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Manager_ServiceDesc.Streams[3], "/telepresence.manager.Manager/ClientTunnel", opts...)
+	stream, err := c.cc.NewStream(ctx, &Manager_ServiceDesc.Streams[4], "/telepresence.manager.Manager/ClientTunnel", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -381,7 +506,7 @@ func (x *managerClientTunnelClient) Recv() (*ConnMessage, error) {
 }
 
 func (c *managerClient) AgentTunnel(ctx context.Context, opts ...grpc.CallOption) (Manager_AgentTunnelClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Manager_ServiceDesc.Streams[4], "/telepresence.manager.Manager/AgentTunnel", opts...)
+	stream, err := c.cc.NewStream(ctx, &Manager_ServiceDesc.Streams[5], "/telepresence.manager.Manager/AgentTunnel", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -430,7 +555,7 @@ func (c *managerClient) AgentLookupHostResponse(ctx context.Context, in *LookupH
 }
 
 func (c *managerClient) WatchLookupHost(ctx context.Context, in *SessionInfo, opts ...grpc.CallOption) (Manager_WatchLookupHostClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Manager_ServiceDesc.Streams[5], "/telepresence.manager.Manager/WatchLookupHost", opts...)
+	stream, err := c.cc.NewStream(ctx, &Manager_ServiceDesc.Streams[6], "/telepresence.manager.Manager/WatchLookupHost", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -462,7 +587,7 @@ func (x *managerWatchLookupHostClient) Recv() (*LookupHostRequest, error) {
 }
 
 func (c *managerClient) WatchLogLevel(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (Manager_WatchLogLevelClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Manager_ServiceDesc.Streams[6], "/telepresence.manager.Manager/WatchLogLevel", opts...)
+	stream, err := c.cc.NewStream(ctx, &Manager_ServiceDesc.Streams[7], "/telepresence.manager.Manager/WatchLogLevel", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -493,8 +618,40 @@ func (x *managerWatchLogLevelClient) Recv() (*LogLevelRequest, error) {
 	return m, nil
 }
 
+func (c *managerClient) WatchAgentLogLevel(ctx context.Context, in *SessionInfo, opts ...grpc.CallOption) (Manager_WatchAgentLogLevelClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Manager_ServiceDesc.Streams[8], "/telepresence.manager.Manager/WatchAgentLogLevel", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &managerWatchAgentLogLevelClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Manager_WatchAgentLogLevelClient interface {
+	Recv() (*LogLevelRequest, error)
+	grpc.ClientStream
+}
+
+type managerWatchAgentLogLevelClient struct {
+	grpc.ClientStream
+}
+
+func (x *managerWatchAgentLogLevelClient) Recv() (*LogLevelRequest, error) {
+	m := new(LogLevelRequest)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *managerClient) Tunnel(ctx context.Context, opts ...grpc.CallOption) (Manager_TunnelClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Manager_ServiceDesc.Streams[7], "/telepresence.manager.Manager/Tunnel", opts...)
+	stream, err := c.cc.NewStream(ctx, &Manager_ServiceDesc.Streams[9], "/telepresence.manager.Manager/Tunnel", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -525,7 +682,7 @@ func (x *managerTunnelClient) Recv() (*TunnelMessage, error) {
 }
 
 func (c *managerClient) WatchDial(ctx context.Context, in *SessionInfo, opts ...grpc.CallOption) (Manager_WatchDialClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Manager_ServiceDesc.Streams[8], "/telepresence.manager.Manager/WatchDial", opts...)
+	stream, err := c.cc.NewStream(ctx, &Manager_ServiceDesc.Streams[10], "/telepresence.manager.Manager/WatchDial", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -571,6 +728,13 @@ type ManagerServer interface {
 	// GetCloudConfig returns the config (host + port) for Ambassador Cloud for use
 	// by the agents.
 	GetCloudConfig(context.Context, *emptypb.Empty) (*AmbassadorCloudConfig, error)
+	// GetAgentImage returns the image that the traffic-manager uses for the
+	// traffic-agents that it injects.
+	GetAgentImage(context.Context, *emptypb.Empty) (*AgentImage, error)
+	// GetAgentConfig returns the default configuration of the traffic-agents
+	// that the traffic-manager injects, so that the client installs the
+	// traffic-agents with the same configuration.
+	GetAgentConfig(context.Context, *emptypb.Empty) (*AgentConfig, error)
 	// ArriveAsClient establishes a session between a client and the Manager.
 	ArriveAsClient(context.Context, *ClientInfo) (*SessionInfo, error)
 	// ArriveAsAgent establishes a session between an agent and the Manager.
@@ -580,12 +744,27 @@ type ManagerServer interface {
 	Remain(context.Context, *RemainRequest) (*emptypb.Empty, error)
 	// Depart terminates a session.
 	Depart(context.Context, *SessionInfo) (*emptypb.Empty, error)
+	// GetClients returns the clients that currently have a session with the Manager.
+	GetClients(context.Context, *emptypb.Empty) (*ClientInfoSnapshot, error)
 	// SetLogLevel will temporarily set the log-level for the traffic-manager and all
 	// traffic-agents for a duration that is determined b the request.
 	SetLogLevel(context.Context, *LogLevelRequest) (*emptypb.Empty, error)
+	// SetRemoteLogLevel temporarily sets the log-level of the traffic-manager and
+	// of the traffic-agents of the given workloads. The traffic-manager resets the
+	// log-levels when the duration expires, also when the caller is gone.
+	SetRemoteLogLevel(context.Context, *RemoteLogLevelRequest) (*RemoteLogLevels, error)
+	// GetRemoteLogLevels returns the temporary log-levels of the traffic-manager
+	// and the traffic-agents.
+	GetRemoteLogLevels(context.Context, *SessionInfo) (*RemoteLogLevels, error)
 	// GetLogs will acquire logs for the various Telepresence components in kubernetes
 	// (pending the request) and return them to the caller
 	GetLogs(context.Context, *GetLogsRequest) (*LogsResponse, error)
+	// StreamLogs streams the logs of the traffic-manager, or of the
+	// traffic-agents of a workload. The logs of the traffic-agents of a
+	// workload can only be streamed by a client that could intercept it.
+	StreamLogs(*StreamLogsRequest, Manager_StreamLogsServer) error
+	// GatherTraces returns the trace spans that the traffic-manager has buffered.
+	GatherTraces(context.Context, *emptypb.Empty) (*common.Traces, error)
 	// WatchAgents notifies a client of the set of known Agents.
 	//
 	// A session ID is required; if no session ID is given then the call
@@ -616,6 +795,9 @@ type ManagerServer interface {
 	// changing the disposition from "WATING" to "ACTIVE" or to an
 	// error, and setting a human-readable status message.
 	ReviewIntercept(context.Context, *ReviewInterceptRequest) (*emptypb.Empty, error)
+	// GetAgentTLSCertificate lets an agent get the certificate of the
+	// agent_tls_secret of an intercept of its workload.
+	GetAgentTLSCertificate(context.Context, *AgentTLSCertificateRequest) (*AgentTLSCertificate, error)
 	// ClientTunnel receives messages from the client and dispatches them to tracked
 	// net.Conn instances in the traffic-manager. Responses from tracked instances
 	// are sent back on the returned message stream
@@ -633,6 +815,10 @@ type ManagerServer interface {
 	WatchLookupHost(*SessionInfo, Manager_WatchLookupHostServer) error
 	// WatchLogLevel lets an agent receive log-level updates
 	WatchLogLevel(*emptypb.Empty, Manager_WatchLogLevelServer) error
+	// WatchAgentLogLevel lets an agent receive the log-levels that are set for it
+	// using SetRemoteLogLevel. An empty log-level resets it. Agents that don't
+	// watch them can't get their own log-level.
+	WatchAgentLogLevel(*SessionInfo, Manager_WatchAgentLogLevelServer) error
 	// A Tunnel represents one single connection where the client or
 	// traffic-agent represents one end (the client-side) and the
 	// traffic-manager represents the other (the server side). The first
@@ -668,6 +854,12 @@ func (UnimplementedManagerServer) CanConnectAmbassadorCloud(context.Context, *em
 func (UnimplementedManagerServer) GetCloudConfig(context.Context, *emptypb.Empty) (*AmbassadorCloudConfig, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetCloudConfig not implemented")
 }
+func (UnimplementedManagerServer) GetAgentImage(context.Context, *emptypb.Empty) (*AgentImage, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAgentImage not implemented")
+}
+func (UnimplementedManagerServer) GetAgentConfig(context.Context, *emptypb.Empty) (*AgentConfig, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAgentConfig not implemented")
+}
 func (UnimplementedManagerServer) ArriveAsClient(context.Context, *ClientInfo) (*SessionInfo, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ArriveAsClient not implemented")
 }
@@ -680,12 +872,27 @@ func (UnimplementedManagerServer) Remain(context.Context, *RemainRequest) (*empt
 func (UnimplementedManagerServer) Depart(context.Context, *SessionInfo) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Depart not implemented")
 }
+func (UnimplementedManagerServer) GetClients(context.Context, *emptypb.Empty) (*ClientInfoSnapshot, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetClients not implemented")
+}
 func (UnimplementedManagerServer) SetLogLevel(context.Context, *LogLevelRequest) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SetLogLevel not implemented")
 }
+func (UnimplementedManagerServer) SetRemoteLogLevel(context.Context, *RemoteLogLevelRequest) (*RemoteLogLevels, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetRemoteLogLevel not implemented")
+}
+func (UnimplementedManagerServer) GetRemoteLogLevels(context.Context, *SessionInfo) (*RemoteLogLevels, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRemoteLogLevels not implemented")
+}
 func (UnimplementedManagerServer) GetLogs(context.Context, *GetLogsRequest) (*LogsResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetLogs not implemented")
 }
+func (UnimplementedManagerServer) StreamLogs(*StreamLogsRequest, Manager_StreamLogsServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamLogs not implemented")
+}
+func (UnimplementedManagerServer) GatherTraces(context.Context, *emptypb.Empty) (*common.Traces, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GatherTraces not implemented")
+}
 func (UnimplementedManagerServer) WatchAgents(*SessionInfo, Manager_WatchAgentsServer) error {
 	return status.Errorf(codes.Unimplemented, "method WatchAgents not implemented")
 }
@@ -710,6 +917,9 @@ func (UnimplementedManagerServer) GetIntercept(context.Context, *GetInterceptReq
 func (UnimplementedManagerServer) ReviewIntercept(context.Context, *ReviewInterceptRequest) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ReviewIntercept not implemented")
 }
+func (UnimplementedManagerServer) GetAgentTLSCertificate(context.Context, *AgentTLSCertificateRequest) (*AgentTLSCertificate, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAgentTLSCertificate not implemented")
+}
 func (UnimplementedManagerServer) ClientTunnel(Manager_ClientTunnelServer) error {
 	return status.Errorf(codes.Unimplemented, "method ClientTunnel not implemented")
 }
@@ -728,6 +938,9 @@ func (UnimplementedManagerServer) WatchLookupHost(*SessionInfo, Manager_WatchLoo
 func (UnimplementedManagerServer) WatchLogLevel(*emptypb.Empty, Manager_WatchLogLevelServer) error {
 	return status.Errorf(codes.Unimplemented, "method WatchLogLevel not implemented")
 }
+func (UnimplementedManagerServer) WatchAgentLogLevel(*SessionInfo, Manager_WatchAgentLogLevelServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchAgentLogLevel not implemented")
+}
 func (UnimplementedManagerServer) Tunnel(Manager_TunnelServer) error {
 	return status.Errorf(codes.Unimplemented, "method Tunnel not implemented")
 }
@@ -819,6 +1032,42 @@ func _Manager_GetCloudConfig_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Manager_GetAgentImage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagerServer).GetAgentImage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.manager.Manager/GetAgentImage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagerServer).GetAgentImage(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Manager_GetAgentConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagerServer).GetAgentConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.manager.Manager/GetAgentConfig",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagerServer).GetAgentConfig(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Manager_ArriveAsClient_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(ClientInfo)
 	if err := dec(in); err != nil {
@@ -891,6 +1140,24 @@ func _Manager_Depart_Handler(srv interface{}, ctx context.Context, dec func(inte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Manager_GetClients_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagerServer).GetClients(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.manager.Manager/GetClients",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagerServer).GetClients(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Manager_SetLogLevel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(LogLevelRequest)
 	if err := dec(in); err != nil {
@@ -909,6 +1176,42 @@ func _Manager_SetLogLevel_Handler(srv interface{}, ctx context.Context, dec func
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Manager_SetRemoteLogLevel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoteLogLevelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagerServer).SetRemoteLogLevel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.manager.Manager/SetRemoteLogLevel",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagerServer).SetRemoteLogLevel(ctx, req.(*RemoteLogLevelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Manager_GetRemoteLogLevels_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionInfo)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagerServer).GetRemoteLogLevels(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.manager.Manager/GetRemoteLogLevels",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagerServer).GetRemoteLogLevels(ctx, req.(*SessionInfo))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Manager_GetLogs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(GetLogsRequest)
 	if err := dec(in); err != nil {
@@ -927,6 +1230,45 @@ func _Manager_GetLogs_Handler(srv interface{}, ctx context.Context, dec func(int
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Manager_StreamLogs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamLogsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ManagerServer).StreamLogs(m, &managerStreamLogsServer{stream})
+}
+
+type Manager_StreamLogsServer interface {
+	Send(*LogChunk) error
+	grpc.ServerStream
+}
+
+type managerStreamLogsServer struct {
+	grpc.ServerStream
+}
+
+func (x *managerStreamLogsServer) Send(m *LogChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Manager_GatherTraces_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagerServer).GatherTraces(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.manager.Manager/GatherTraces",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagerServer).GatherTraces(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Manager_WatchAgents_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(SessionInfo)
 	if err := stream.RecvMsg(m); err != nil {
@@ -1080,6 +1422,24 @@ func _Manager_ReviewIntercept_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Manager_GetAgentTLSCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AgentTLSCertificateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagerServer).GetAgentTLSCertificate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.manager.Manager/GetAgentTLSCertificate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagerServer).GetAgentTLSCertificate(ctx, req.(*AgentTLSCertificateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Manager_ClientTunnel_Handler(srv interface{}, stream grpc.ServerStream) error {
 	return srv.(ManagerServer).ClientTunnel(&managerClientTunnelServer{stream})
 }
@@ -1210,6 +1570,27 @@ func (x *managerWatchLogLevelServer) Send(m *LogLevelRequest) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _Manager_WatchAgentLogLevel_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SessionInfo)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ManagerServer).WatchAgentLogLevel(m, &managerWatchAgentLogLevelServer{stream})
+}
+
+type Manager_WatchAgentLogLevelServer interface {
+	Send(*LogLevelRequest) error
+	grpc.ServerStream
+}
+
+type managerWatchAgentLogLevelServer struct {
+	grpc.ServerStream
+}
+
+func (x *managerWatchAgentLogLevelServer) Send(m *LogLevelRequest) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 func _Manager_Tunnel_Handler(srv interface{}, stream grpc.ServerStream) error {
 	return srv.(ManagerServer).Tunnel(&managerTunnelServer{stream})
 }
@@ -1280,6 +1661,14 @@ var Manager_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetCloudConfig",
 			Handler:    _Manager_GetCloudConfig_Handler,
 		},
+		{
+			MethodName: "GetAgentImage",
+			Handler:    _Manager_GetAgentImage_Handler,
+		},
+		{
+			MethodName: "GetAgentConfig",
+			Handler:    _Manager_GetAgentConfig_Handler,
+		},
 		{
 			MethodName: "ArriveAsClient",
 			Handler:    _Manager_ArriveAsClient_Handler,
@@ -1296,14 +1685,30 @@ var Manager_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Depart",
 			Handler:    _Manager_Depart_Handler,
 		},
+		{
+			MethodName: "GetClients",
+			Handler:    _Manager_GetClients_Handler,
+		},
 		{
 			MethodName: "SetLogLevel",
 			Handler:    _Manager_SetLogLevel_Handler,
 		},
+		{
+			MethodName: "SetRemoteLogLevel",
+			Handler:    _Manager_SetRemoteLogLevel_Handler,
+		},
+		{
+			MethodName: "GetRemoteLogLevels",
+			Handler:    _Manager_GetRemoteLogLevels_Handler,
+		},
 		{
 			MethodName: "GetLogs",
 			Handler:    _Manager_GetLogs_Handler,
 		},
+		{
+			MethodName: "GatherTraces",
+			Handler:    _Manager_GatherTraces_Handler,
+		},
 		{
 			MethodName: "CreateIntercept",
 			Handler:    _Manager_CreateIntercept_Handler,
@@ -1324,6 +1729,10 @@ var Manager_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ReviewIntercept",
 			Handler:    _Manager_ReviewIntercept_Handler,
 		},
+		{
+			MethodName: "GetAgentTLSCertificate",
+			Handler:    _Manager_GetAgentTLSCertificate_Handler,
+		},
 		{
 			MethodName: "LookupHost",
 			Handler:    _Manager_LookupHost_Handler,
@@ -1334,6 +1743,11 @@ var Manager_ServiceDesc = grpc.ServiceDesc{
 		},
 	},
 	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamLogs",
+			Handler:       _Manager_StreamLogs_Handler,
+			ServerStreams: true,
+		},
 		{
 			StreamName:    "WatchAgents",
 			Handler:       _Manager_WatchAgents_Handler,
@@ -1371,6 +1785,11 @@ var Manager_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _Manager_WatchLogLevel_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "WatchAgentLogLevel",
+			Handler:       _Manager_WatchAgentLogLevel_Handler,
+			ServerStreams: true,
+		},
 		{
 			StreamName:    "Tunnel",
 			Handler:       _Manager_Tunnel_Handler,